@@ -1,34 +1,46 @@
 #!/bin/bash
 
 # CasGists Docker Entrypoint Script - BASE SPEC Compliant
+#
+# Runs under tini, which forwards signals and reaps exited processes, and
+# execs the server so SIGTERM from "docker stop" reaches it directly. The
+# server runs in container mode: data on /data, logs on stdout and all
+# configuration from CASGISTS_* variables.
 
 set -e
 
-# Default values per BASE SPEC
+# Earlier images documented CASGISTS_DB_TYPE and CASGISTS_DB_DSN; the
+# server reads database.type and database.dsn
+if [ -z "$CASGISTS_DATABASE_TYPE" ] && [ -n "$CASGISTS_DB_TYPE" ]; then
+    export CASGISTS_DATABASE_TYPE="$CASGISTS_DB_TYPE"
+fi
+if [ -z "$CASGISTS_DATABASE_DSN" ] && [ -n "$CASGISTS_DB_DSN" ]; then
+    export CASGISTS_DATABASE_DSN="$CASGISTS_DB_DSN"
+fi
+# The same goes for the secret key, which the server otherwise generates
+# once and keeps in /data/config.yaml
+if [ -z "$CASGISTS_SECURITY_SECRET_KEY" ] && [ -n "$CASGISTS_SECRET_KEY" ]; then
+    export CASGISTS_SECURITY_SECRET_KEY="$CASGISTS_SECRET_KEY"
+fi
+
 CASGISTS_SERVER_PORT=${CASGISTS_SERVER_PORT:-80}
-CASGISTS_DB_TYPE=${CASGISTS_DB_TYPE:-sqlite}
-CASGISTS_DB_DSN=${CASGISTS_DB_DSN:-/data/db/casgists.db}
+CASGISTS_DATABASE_TYPE=${CASGISTS_DATABASE_TYPE:-sqlite}
 CASGISTS_DATA_DIR=${CASGISTS_DATA_DIR:-/data}
-CASGISTS_LOG_DIR=${CASGISTS_LOG_DIR:-/var/log/casgists}
-
-# Create necessary directories
-mkdir -p "$CASGISTS_DATA_DIR"
-mkdir -p "$CASGISTS_DATA_DIR/db"
-mkdir -p "$CASGISTS_LOG_DIR"
-mkdir -p /config
 
 # Wait for database if using PostgreSQL or MySQL
-if [ "$CASGISTS_DB_TYPE" = "postgres" ] || [ "$CASGISTS_DB_TYPE" = "postgresql" ] || [ "$CASGISTS_DB_TYPE" = "mysql" ]; then
+if [ "$CASGISTS_DATABASE_TYPE" = "postgres" ] || [ "$CASGISTS_DATABASE_TYPE" = "postgresql" ] || [ "$CASGISTS_DATABASE_TYPE" = "mysql" ]; then
     echo "⏳ Waiting for database to be ready..."
 
-    # Extract host and port from DSN
-    DB_HOST=$(echo "$CASGISTS_DB_DSN" | sed -n 's/.*@\([^:\/]*\)[:\/]\([0-9]*\).*/\1/p')
-    DB_PORT=$(echo "$CASGISTS_DB_DSN" | sed -n 's/.*@[^:\/]*[:\/]\([0-9]*\).*/\1/p')
+    # Extract host and port from DSN, or use database.host and database.port
+    DB_HOST=$(echo "$CASGISTS_DATABASE_DSN" | sed -n 's/.*@\([^:\/]*\)[:\/]\([0-9]*\).*/\1/p')
+    DB_PORT=$(echo "$CASGISTS_DATABASE_DSN" | sed -n 's/.*@[^:\/]*[:\/]\([0-9]*\).*/\1/p')
+    [ -z "$DB_HOST" ] && DB_HOST=$CASGISTS_DATABASE_HOST
+    [ -z "$DB_PORT" ] && DB_PORT=$CASGISTS_DATABASE_PORT
 
     # Default ports if not found
-    [ -z "$DB_PORT" ] && [ "$CASGISTS_DB_TYPE" = "postgres" ] && DB_PORT=5432
-    [ -z "$DB_PORT" ] && [ "$CASGISTS_DB_TYPE" = "postgresql" ] && DB_PORT=5432
-    [ -z "$DB_PORT" ] && [ "$CASGISTS_DB_TYPE" = "mysql" ] && DB_PORT=3306
+    [ -z "$DB_PORT" ] && [ "$CASGISTS_DATABASE_TYPE" = "postgres" ] && DB_PORT=5432
+    [ -z "$DB_PORT" ] && [ "$CASGISTS_DATABASE_TYPE" = "postgresql" ] && DB_PORT=5432
+    [ -z "$DB_PORT" ] && [ "$CASGISTS_DATABASE_TYPE" = "mysql" ] && DB_PORT=3306
 
     if [ -n "$DB_HOST" ] && [ -n "$DB_PORT" ]; then
         echo "📡 Checking connection to $DB_HOST:$DB_PORT..."
@@ -50,23 +62,13 @@ if [ "$CASGISTS_DB_TYPE" = "postgres" ] || [ "$CASGISTS_DB_TYPE" = "postgresql"
     fi
 fi
 
-# Generate secret key if not provided
-if [ -z "$CASGISTS_SECRET_KEY" ]; then
-    echo "🔐 Generating random secret key..."
-    CASGISTS_SECRET_KEY=$(head -c 32 /dev/urandom | base64 | tr -d '\n=' | head -c 32)
-    export CASGISTS_SECRET_KEY
-    echo "✅ Generated secret key (save for future use)"
-    echo "   CASGISTS_SECRET_KEY=$CASGISTS_SECRET_KEY"
-fi
-
 # Print startup info
 echo ""
 echo "🚀 Starting CasGists..."
 echo "   Version: $(/usr/local/bin/casgists --version 2>/dev/null | tail -1 || echo 'unknown')"
 echo "   Port: $CASGISTS_SERVER_PORT"
-echo "   Database: $CASGISTS_DB_TYPE"
+echo "   Database: $CASGISTS_DATABASE_TYPE"
 echo "   Data directory: $CASGISTS_DATA_DIR"
-echo "   Log directory: $CASGISTS_LOG_DIR"
 echo ""
 
 # Execute the main command
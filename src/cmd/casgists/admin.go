@@ -0,0 +1,682 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/alerting"
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/utils"
+	"github.com/google/uuid"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// generatedPasswordLength is the length of passwords generated when the
+// operator doesn't pass --password
+const generatedPasswordLength = 20
+
+func newAdminCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Emergency administration without the web UI",
+		Long: `Emergency administration, run on the server without the web UI.
+
+The commands work on the database directly, so they can be used on the
+server box when nobody can log in. Every command is recorded in the audit
+log with the operating system user that ran it.`,
+		Args: cobra.NoArgs,
+	}
+	cmd.AddCommand(newAdminUserCommand(), newAdminSessionsCommand(), newAdminConfigCommand(), newAdminSecretKeyCommand())
+
+	// The commands before they were grouped
+	for _, legacy := range []struct {
+		use, replacement string
+		target           *cobra.Command
+	}{
+		{"create-admin <username> <email>", "admin user create --admin", newAdminUserCreateCommand(true)},
+		{"reset-password <user>", "admin user reset-password", newAdminResetPasswordCommand()},
+		{"unlock-user <user>", "admin user unlock", newAdminUnlockCommand()},
+		{"disable-2fa <user>", "admin user disable-2fa", newAdminDisable2FACommand()},
+		{"revoke-all-sessions [<user>]", "admin sessions revoke", newAdminRevokeSessionsCommand()},
+		{"set-config <key> <value>", "admin config set", newAdminSetConfigCommand()},
+	} {
+		legacy.target.Use = legacy.use
+		legacy.target.Hidden = true
+		legacy.target.Deprecated = fmt.Sprintf("use \"casgists %s\"", legacy.replacement)
+		cmd.AddCommand(legacy.target)
+	}
+	return cmd
+}
+
+func newAdminUserCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:     "user",
+		Aliases: []string{"users"},
+		Short:   "Create users and recover accounts",
+		Long: `Create users and recover accounts. <user> is a username or email
+address.`,
+		Args: cobra.NoArgs,
+	}
+	cmd.AddCommand(
+		newAdminUserCreateCommand(false),
+		newAdminResetPasswordCommand(),
+		newAdminPromoteCommand(true),
+		newAdminPromoteCommand(false),
+		newAdminDeactivateCommand(),
+		newAdminUnlockCommand(),
+		newAdminDisable2FACommand(),
+	)
+	return cmd
+}
+
+// newAdminUserCreateCommand creates users, or only administrators when
+// admin is set
+func newAdminUserCreateCommand(admin bool) *cobra.Command {
+	var password string
+	cmd := &cobra.Command{
+		Use:   "create <username> <email>",
+		Short: "Create a user",
+		Long: `Create a user with a verified email address. Without --password a
+password is generated and printed once.`,
+		Example: "  casgists admin user create ops ops@example.com --admin",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runCreateUser(db, cfg, args[0], args[1], password, admin)
+			})
+		},
+	}
+	addPasswordFlag(cmd, &password)
+	if !admin {
+		cmd.Flags().BoolVar(&admin, "admin", false, "make the user an administrator")
+	}
+	return cmd
+}
+
+func newAdminResetPasswordCommand() *cobra.Command {
+	var password string
+	cmd := &cobra.Command{
+		Use:   "reset-password <user>",
+		Short: "Set a new password and sign the user out",
+		Long: `Set a new password and sign the user out everywhere. Without
+--password a password is generated and printed once.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runResetPassword(db, cfg, args[0], password)
+			})
+		},
+	}
+	addPasswordFlag(cmd, &password)
+	return cmd
+}
+
+// newAdminPromoteCommand grants administrator rights, or takes them away
+// when promote is false
+func newAdminPromoteCommand(promote bool) *cobra.Command {
+	use, short := "promote <user>", "Make a user an administrator"
+	if !promote {
+		use, short = "demote <user>", "Take administrator rights away from a user"
+	}
+	return &cobra.Command{
+		Use:   use,
+		Short: short,
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runSetAdmin(db, args[0], promote)
+			})
+		},
+	}
+}
+
+func newAdminDeactivateCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "deactivate <user>",
+		Short: "Disable an account and sign the user out",
+		Long: `Disable an account and sign the user out everywhere. The user can't log
+in again until the account is unlocked with "casgists admin user unlock".`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runDeactivateUser(db, args[0])
+			})
+		},
+	}
+}
+
+func newAdminUnlockCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlock <user>",
+		Short: "Re-enable a disabled, suspended or deactivated account",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runUnlockUser(db, args[0])
+			})
+		},
+	}
+}
+
+func newAdminDisable2FACommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "disable-2fa <user>",
+		Short: "Turn off two-factor authentication",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runDisable2FA(db, args[0])
+			})
+		},
+	}
+}
+
+func newAdminSessionsCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "sessions",
+		Short: "Sign users out",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newAdminRevokeSessionsCommand())
+	return cmd
+}
+
+func newAdminRevokeSessionsCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "revoke [<user>]",
+		Short: "Sign out one user, or everybody",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ref := ""
+			if len(args) == 1 {
+				ref = args[0]
+			}
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runRevokeAllSessions(db, ref)
+			})
+		},
+	}
+}
+
+func newAdminConfigCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "config",
+		Short: "Change system settings",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newAdminSetConfigCommand())
+	return cmd
+}
+
+func newAdminSetConfigCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:     "set <key> <value>",
+		Short:   "Change a system setting, as on the settings page",
+		Example: "  casgists admin config set alerting.enabled false",
+		Args:    cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(func(db *gorm.DB, cfg *config.Config) error {
+				return runSetConfig(db, args[0], args[1])
+			})
+		},
+	}
+}
+
+func newAdminSecretKeyCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret-key",
+		Short: "Manage the server secret key",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rotate",
+		Short: "Replace security.secret_key and sign everybody out",
+		Long: `Replace security.secret_key in the config file with a new random key and
+sign everybody out. Use it when the key may have leaked.
+
+The key signs access tokens, OAuth state cookies, image proxy URLs and
+newsletter unsubscribe links, so links of the last two kinds in email
+already sent stop working. Personal access tokens are not affected.
+
+The server uses the new key after a restart.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withDatabase(runRotateSecretKey)
+		},
+	})
+	return cmd
+}
+
+func addPasswordFlag(cmd *cobra.Command, password *string) {
+	cmd.Flags().StringVar(password, "password", "", "the new password, instead of a generated one")
+}
+
+// withDatabase runs an admin command against the server's database
+func withDatabase(run func(*gorm.DB, *config.Config) error) error {
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return run(db, cfg)
+}
+
+// runCreateUser creates a new account with a verified email address
+func runCreateUser(db *gorm.DB, cfg *config.Config, username, email, password string, admin bool) error {
+	users := services.NewUserService(db, cfg, nil, nil)
+	if err := users.ValidateUsername(username); err != nil {
+		return err
+	}
+	if err := users.ValidateEmail(email); err != nil {
+		return err
+	}
+
+	var existing int64
+	db.Model(&models.User{}).Where("username = ? OR email = ?", username, email).Count(&existing)
+	if existing > 0 {
+		return fmt.Errorf("a user with username %q or email %q already exists", username, email)
+	}
+
+	password, generated, err := choosePassword(cfg, password)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	created := &models.User{
+		Username:        username,
+		Email:           email,
+		PasswordHash:    hash,
+		IsAdmin:         admin,
+		IsActive:        true,
+		EmailVerified:   true,
+		IsEmailVerified: true,
+	}
+	if err := db.Create(created).Error; err != nil {
+		return fmt.Errorf("failed to create user: %w", err)
+	}
+
+	action, role := "admin_cli.create_user", "user"
+	if admin {
+		action, role = "admin_cli.create_admin", "administrator"
+	}
+	if err := writeCLIAudit(db, action, created.ID, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Created %s %s (%s)\n", role, created.Username, created.Email)
+	printPassword(password, generated)
+	return nil
+}
+
+// runResetPassword sets a new password and signs the user out everywhere
+func runResetPassword(db *gorm.DB, cfg *config.Config, ref, password string) error {
+	target, err := findUser(db, ref)
+	if err != nil {
+		return err
+	}
+
+	password, generated, err := choosePassword(cfg, password)
+	if err != nil {
+		return err
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var revoked int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(target).Update("password_hash", hash).Error; err != nil {
+			return err
+		}
+		result := tx.Where("user_id = ?", target.ID).Delete(&models.Session{})
+		revoked = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to reset password: %w", err)
+	}
+	if err := writeCLIAudit(db, "admin_cli.reset_password", target.ID, map[string]interface{}{
+		"sessions_revoked": revoked,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Reset password for %s and revoked %d session(s)\n", target.Username, revoked)
+	printPassword(password, generated)
+	return nil
+}
+
+// runUnlockUser re-enables a disabled, suspended or deactivated account
+func runUnlockUser(db *gorm.DB, ref string) error {
+	target, err := findUser(db, ref)
+	if err != nil {
+		return err
+	}
+
+	if err := db.Model(target).Updates(map[string]interface{}{
+		"is_active":      true,
+		"is_suspended":   false,
+		"deactivated_at": nil,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to unlock user: %w", err)
+	}
+	if err := writeCLIAudit(db, "admin_cli.unlock_user", target.ID, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Unlocked %s\n", target.Username)
+	return nil
+}
+
+// runSetAdmin grants or takes away administrator rights. The last active
+// administrator keeps them.
+func runSetAdmin(db *gorm.DB, ref string, admin bool) error {
+	target, err := findUser(db, ref)
+	if err != nil {
+		return err
+	}
+	if target.IsAdmin == admin {
+		if admin {
+			fmt.Printf("ℹ️  %s is already an administrator\n", target.Username)
+		} else {
+			fmt.Printf("ℹ️  %s is not an administrator\n", target.Username)
+		}
+		return nil
+	}
+
+	if !admin {
+		var admins int64
+		if err := db.Model(&models.User{}).Where("is_admin = ? AND is_active = ? AND id <> ?", true, true, target.ID).Count(&admins).Error; err != nil {
+			return fmt.Errorf("failed to count administrators: %w", err)
+		}
+		if admins == 0 {
+			return fmt.Errorf("%s is the last active administrator", target.Username)
+		}
+	}
+
+	if err := db.Model(target).Update("is_admin", admin).Error; err != nil {
+		return fmt.Errorf("failed to update user: %w", err)
+	}
+	action := "admin_cli.promote"
+	if !admin {
+		action = "admin_cli.demote"
+	}
+	if err := writeCLIAudit(db, action, target.ID, nil); err != nil {
+		return err
+	}
+
+	if admin {
+		fmt.Printf("✅ %s is now an administrator\n", target.Username)
+	} else {
+		fmt.Printf("✅ %s is no longer an administrator\n", target.Username)
+	}
+	return nil
+}
+
+// runDeactivateUser disables an account and signs the user out. Unlike
+// deactivating their own account, the user can't undo it by logging in.
+func runDeactivateUser(db *gorm.DB, ref string) error {
+	target, err := findUser(db, ref)
+	if err != nil {
+		return err
+	}
+	if target.IsAdmin && target.IsActive {
+		var admins int64
+		if err := db.Model(&models.User{}).Where("is_admin = ? AND is_active = ? AND id <> ?", true, true, target.ID).Count(&admins).Error; err != nil {
+			return fmt.Errorf("failed to count administrators: %w", err)
+		}
+		if admins == 0 {
+			return fmt.Errorf("%s is the last active administrator", target.Username)
+		}
+	}
+
+	var revoked int64
+	err = db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(target).Update("is_active", false).Error; err != nil {
+			return err
+		}
+		result := tx.Where("user_id = ?", target.ID).Delete(&models.Session{})
+		revoked = result.RowsAffected
+		return result.Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to deactivate user: %w", err)
+	}
+	if err := writeCLIAudit(db, "admin_cli.deactivate_user", target.ID, map[string]interface{}{
+		"sessions_revoked": revoked,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Deactivated %s and revoked %d session(s)\n", target.Username, revoked)
+	return nil
+}
+
+// runDisable2FA turns off two-factor authentication for a user who lost
+// their authenticator
+func runDisable2FA(db *gorm.DB, ref string) error {
+	target, err := findUser(db, ref)
+	if err != nil {
+		return err
+	}
+	if !target.TwoFactorEnabled {
+		fmt.Printf("ℹ️  Two-factor authentication is not enabled for %s\n", target.Username)
+		return nil
+	}
+
+	if err := db.Model(target).Updates(map[string]interface{}{
+		"two_factor_enabled": false,
+		"two_factor_secret":  "",
+	}).Error; err != nil {
+		return fmt.Errorf("failed to disable two-factor authentication: %w", err)
+	}
+	if err := db.Where("user_id = ?", target.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+		return fmt.Errorf("failed to delete recovery codes: %w", err)
+	}
+	if err := writeCLIAudit(db, "admin_cli.disable_2fa", target.ID, nil); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Disabled two-factor authentication for %s\n", target.Username)
+	return nil
+}
+
+// runRevokeAllSessions signs out one user, or everybody when no user is given
+func runRevokeAllSessions(db *gorm.DB, ref string) error {
+	query := db.Where("1 = 1")
+	var target *models.User
+	if ref != "" {
+		found, err := findUser(db, ref)
+		if err != nil {
+			return err
+		}
+		target = found
+		query = db.Where("user_id = ?", target.ID)
+	}
+
+	result := query.Delete(&models.Session{})
+	if result.Error != nil {
+		return fmt.Errorf("failed to revoke sessions: %w", result.Error)
+	}
+
+	details := map[string]interface{}{"sessions_revoked": result.RowsAffected}
+	targetID := uuid.Nil
+	if target != nil {
+		targetID = target.ID
+	}
+	if err := writeCLIAudit(db, "admin_cli.revoke_all_sessions", targetID, details); err != nil {
+		return err
+	}
+
+	if target != nil {
+		fmt.Printf("✅ Revoked %d session(s) for %s\n", result.RowsAffected, target.Username)
+	} else {
+		fmt.Printf("✅ Revoked %d session(s) for all users\n", result.RowsAffected)
+	}
+	return nil
+}
+
+// runSetConfig stores a system setting, the same way the admin settings page
+// does
+func runSetConfig(db *gorm.DB, key, value string) error {
+	if err := alerting.ValidateSetting(key, value); err != nil {
+		return err
+	}
+
+	previous, _ := models.GetConfigValue(db, key)
+	if err := models.SetConfigValue(db, key, value); err != nil {
+		return fmt.Errorf("failed to set %s: %w", key, err)
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.set_config",
+		ResourceType: "system_config",
+		ResourceID:   key,
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"previous": previous,
+		"value":    value,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Set %s = %s\n", key, value)
+	fmt.Println("ℹ️  Restart the server if the setting is only read at startup")
+	return nil
+}
+
+// runRotateSecretKey writes a new secret key to the config file and
+// deletes every session, so nobody stays signed in with a token the old key
+// signed
+func runRotateSecretKey(db *gorm.DB, cfg *config.Config) error {
+	// The environment overrides the config file
+	if os.Getenv("CASGISTS_SECURITY_SECRET_KEY") != "" {
+		return errors.New("the secret key is set by CASGISTS_SECURITY_SECRET_KEY, change it there")
+	}
+	configFile, err := configFilePath()
+	if err != nil {
+		return err
+	}
+
+	key, err := config.GenerateSecretKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	settings := config.NewSettings(cfg, models.NewConfigStore(db), configFile)
+	if err := settings.Save("security.secret_key", key); err != nil {
+		return err
+	}
+
+	result := db.Where("1 = 1").Delete(&models.Session{})
+	if result.Error != nil {
+		return fmt.Errorf("the new key is saved, but revoking sessions failed: %w", result.Error)
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.rotate_secret_key",
+		ResourceType: "system_config",
+		ResourceID:   "security.secret_key",
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"sessions_revoked": result.RowsAffected,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Printf("✅ Wrote a new secret key to %s and revoked %d session(s)\n", configFile, result.RowsAffected)
+	fmt.Println("ℹ️  Restart the server to use the new key")
+	return nil
+}
+
+// findUser looks a user up by username or email
+func findUser(db *gorm.DB, ref string) (*models.User, error) {
+	var found models.User
+	err := db.Where("username = ? OR email = ?", ref, ref).First(&found).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("user %q not found", ref)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up user: %w", err)
+	}
+	return &found, nil
+}
+
+// choosePassword checks the password given on the command line, or generates
+// one when none was given
+func choosePassword(cfg *config.Config, password string) (string, bool, error) {
+	if password == "" {
+		return utils.GenerateSecurePassword(generatedPasswordLength), true, nil
+	}
+	if minLength := cfg.GetInt("security.password.min_length"); len(password) < minLength {
+		return "", false, fmt.Errorf("password must be at least %d characters", minLength)
+	}
+	return password, false, nil
+}
+
+func printPassword(password string, generated bool) {
+	if generated {
+		fmt.Printf("🔑 Generated password: %s\n", password)
+		fmt.Println("   It is not shown again; change it after logging in.")
+	}
+}
+
+// writeCLIAudit records a user-related admin command in the audit log
+func writeCLIAudit(db *gorm.DB, action string, userID uuid.UUID, details map[string]interface{}) error {
+	entry := models.AuditLog{
+		Action:       action,
+		ResourceType: "user",
+	}
+	if userID != uuid.Nil {
+		entry.ResourceID = userID.String()
+	}
+	return writeAuditEntry(db, entry, details)
+}
+
+// writeAuditEntry records who ran an admin command on the server box. There
+// is no logged-in user, so the operating system account is recorded instead.
+func writeAuditEntry(db *gorm.DB, entry models.AuditLog, details map[string]interface{}) error {
+	if details == nil {
+		details = map[string]interface{}{}
+	}
+	details["source"] = "cli"
+	details["operator"] = operatorName()
+
+	encoded, err := json.Marshal(details)
+	if err != nil {
+		return fmt.Errorf("failed to encode audit details: %w", err)
+	}
+	entry.Details = string(encoded)
+	entry.UserAgent = "casgists-cli/" + Version
+	entry.Success = true
+	entry.CreatedAt = time.Now()
+
+	if err := db.Create(&entry).Error; err != nil {
+		return fmt.Errorf("failed to write audit entry: %w", err)
+	}
+	return nil
+}
+
+// operatorName returns the operating system user running the command
+func operatorName() string {
+	if current, err := user.Current(); err == nil {
+		return current.Username
+	}
+	if name := os.Getenv("USER"); name != "" {
+		return name
+	}
+	return "unknown"
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/archive"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/git"
+	"github.com/casapps/casgists/src/internal/migration"
+	"github.com/casapps/casgists/src/internal/objectstore"
+	"github.com/casapps/casgists/src/internal/scanning"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// handleExportCommand writes the whole instance to a portable archive
+func handleExportCommand(args []string) error {
+	if containsArg(args, "--help") || containsArg(args, "-h") {
+		printArchiveHelp()
+		return nil
+	}
+	output, args := extractFlag(args, "--output")
+	if output == "" {
+		output, args = extractFlag(args, "-o")
+	}
+	username, args := extractFlag(args, "--user")
+	if username != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--user can't be combined with %s", args[0])
+		}
+		return exportUserGists(username, output)
+	}
+	all := containsArg(args, "--all")
+	noRepos := containsArg(args, "--no-repos")
+	noUploads := containsArg(args, "--no-uploads")
+	upload := containsArg(args, "--upload")
+	for _, arg := range args {
+		if arg != "--all" && arg != "--no-repos" && arg != "--no-uploads" && arg != "--upload" {
+			return fmt.Errorf("unknown export option: %s", arg)
+		}
+	}
+	if !all {
+		printArchiveHelp()
+		return fmt.Errorf("usage: casgists export --all [-o FILE | --upload] [--no-repos] [--no-uploads] | --user USERNAME [-o FILE]")
+	}
+	if upload && output != "" {
+		return fmt.Errorf("--upload can't be combined with -o")
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	opts := archive.ExportOptions{Version: Version, Source: cfg.GetString("server.url")}
+	if !noRepos {
+		if opts.Repositories, err = git.NewStorageDriver(cfg); err != nil {
+			return err
+		}
+	}
+	if !noUploads {
+		opts.UploadsDir = cfg.GetString("storage.path")
+	}
+
+	var store objectstore.Bucket
+	if upload {
+		if store, err = objectstore.NewRemote(cfg); err != nil {
+			return err
+		}
+		if store == nil {
+			return fmt.Errorf("--upload needs object storage; set storage.type to s3 or minio")
+		}
+		// Staged locally, as the archive's size must be known to upload it
+		spool, err := os.CreateTemp("", "casgists-export-*.tar.gz")
+		if err != nil {
+			return err
+		}
+		spool.Close()
+		defer os.Remove(spool.Name())
+		output = spool.Name()
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	manifest, err := archive.Export(db, w, opts)
+	if err != nil {
+		return err
+	}
+
+	uploadedTo := ""
+	if store != nil {
+		if file, ok := w.(*os.File); ok {
+			if err := file.Close(); err != nil {
+				return err
+			}
+		}
+		key := objectstore.PrefixArchives + "casgists-export-" + manifest.CreatedAt.UTC().Format("20060102-150405") + ".tar.gz"
+		if err := objectstore.PutFile(context.Background(), store, key, output); err != nil {
+			return fmt.Errorf("failed to upload archive: %w", err)
+		}
+		uploadedTo = store.Describe() + key
+	}
+
+	rows := 0
+	for _, table := range manifest.Tables {
+		rows += table.Rows
+	}
+	entry := models.AuditLog{
+		Action:       "admin_cli.export",
+		ResourceType: "instance",
+	}
+	details := map[string]interface{}{
+		"tables":       len(manifest.Tables),
+		"rows":         rows,
+		"repositories": manifest.Repositories,
+		"uploads":      manifest.Uploads,
+	}
+	if uploadedTo != "" {
+		details["uploaded_to"] = uploadedTo
+	}
+	if err := writeAuditEntry(db, entry, details); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Exported %d tables (%d rows), %d repositories and %d uploads (archive format v%d, schema %d)\n",
+		len(manifest.Tables), rows, manifest.Repositories, manifest.Uploads, manifest.Version, manifest.SchemaVersion)
+	if uploadedTo != "" {
+		fmt.Fprintf(os.Stderr, "✅ Uploaded to %s\n", uploadedTo)
+	}
+	return nil
+}
+
+// exportUserGists writes the gists of one user to a zip, the same archive
+// GET /api/v1/user/export serves
+func exportUserGists(username, output string) error {
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	var user models.User
+	if err := db.Where("username = ?", username).First(&user).Error; err != nil {
+		return fmt.Errorf("user %s not found", username)
+	}
+	userArchive, err := migration.LoadUserArchive(db, user.ID, urls.NewBuilder(cfg))
+	if err != nil {
+		return err
+	}
+
+	if output == "" {
+		output = userArchive.Filename()
+	}
+	var w io.Writer = os.Stdout
+	if output != "-" {
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := userArchive.Write(w); err != nil {
+		return err
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.export_user",
+		ResourceType: "user",
+		ResourceID:   user.ID.String(),
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{"gists": userArchive.Count()}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Exported %d gists of %s\n", userArchive.Count(), username)
+	return nil
+}
+
+// handleImportCommand loads an archive into this instance
+func handleImportCommand(args []string) error {
+	if containsArg(args, "--help") || containsArg(args, "-h") {
+		printArchiveHelp()
+		return nil
+	}
+	dryRun := containsArg(args, "--dry-run")
+	overwrite := containsArg(args, "--overwrite")
+	var positional []string
+	for _, arg := range args {
+		switch {
+		case arg == "--dry-run" || arg == "--overwrite":
+		case strings.HasPrefix(arg, "--"):
+			return fmt.Errorf("unknown import option: %s", arg)
+		default:
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: casgists import FILE|- [--dry-run] [--overwrite]")
+	}
+
+	var r io.Reader = os.Stdin
+	if positional[0] != "-" {
+		file, err := os.Open(positional[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	opts := archive.ImportOptions{
+		UploadsDir: cfg.GetString("storage.path"),
+		Overwrite:  overwrite,
+		DryRun:     dryRun,
+	}
+	if opts.Repositories, err = git.NewStorageDriver(cfg); err != nil {
+		return err
+	}
+	if cfg.GetBool("scanning.enabled") && !dryRun {
+		scanner, err := scanning.NewService(db, cfg, email.NewService(db, cfg))
+		if err != nil {
+			return fmt.Errorf("failed to set up upload scanning: %w", err)
+		}
+		opts.ScanUpload = func(name, path string) (bool, error) {
+			result, err := scanner.ScanFile(context.Background(), scanning.Upload{
+				Filename: name,
+				Source:   models.QuarantineSourceArchiveImport,
+			}, path)
+			return result.Infected(), err
+		}
+	}
+	result, err := archive.Import(db, r, opts)
+	if result != nil {
+		printImportResult(result)
+	}
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Println("🔍 Dry run, nothing was changed")
+		return nil
+	}
+	entry := models.AuditLog{
+		Action:       "admin_cli.import",
+		ResourceType: "instance",
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"source":         result.Manifest.Source,
+		"archive_schema": result.Manifest.SchemaVersion,
+		"repositories":   result.Repositories,
+		"uploads":        result.Uploads,
+		"quarantined":    len(result.Quarantined),
+		"overwrite":      overwrite,
+	}); err != nil {
+		return err
+	}
+	fmt.Println("✅ Import complete, rebuild the search index with 'casgists search reindex' and restart the server")
+	return nil
+}
+
+func printImportResult(result *archive.ImportResult) {
+	manifest := result.Manifest
+	fmt.Printf("📦 Archive v%d from CasGists %s (%s, schema %d), created %s\n",
+		manifest.Version, manifest.CasGistsVersion, manifest.Database, manifest.SchemaVersion, manifest.CreatedAt.Format("2006-01-02 15:04"))
+
+	tables := make([]string, 0, len(result.Tables))
+	for table := range result.Tables {
+		tables = append(tables, table)
+	}
+	sort.Strings(tables)
+	for _, table := range tables {
+		fmt.Printf("   📋 %s: %d rows\n", table, result.Tables[table])
+		if dropped := result.DroppedColumns[table]; len(dropped) > 0 {
+			fmt.Printf("      ⚠️  not in this version, left out: %s\n", strings.Join(dropped, ", "))
+		}
+	}
+	for _, table := range result.SkippedTables {
+		fmt.Printf("   ⏭️  %s: not in this version, skipped\n", table)
+	}
+	fmt.Printf("📊 %d tables, %d repositories, %d uploads\n", len(result.Tables), result.Repositories, result.Uploads)
+	for _, name := range result.Quarantined {
+		fmt.Printf("   🦠 %s: flagged by the malware scanner, quarantined\n", name)
+	}
+}
+
+func printArchiveHelp() {
+	fmt.Printf(`Export the whole instance to a portable archive, or import one
+
+Usage:
+  casgists export --all [options]
+  casgists export --user USERNAME [-o FILE]
+  casgists import FILE|- [options]
+
+Export options:
+  --all                 Export every table, repository and upload
+  --user USERNAME       Export only the gists of USERNAME as a zip, with a
+                        directory per gist and a metadata.json in GitHub's
+                        gist format; it is written to
+                        casgists-USERNAME-DATE.zip unless -o is given
+  -o, --output FILE     Write to FILE instead of stdout
+  --upload              Upload to archives/ in object storage (storage.type
+                        s3 or minio) instead of writing it out
+  --no-repos            Leave git repositories out
+  --no-uploads          Leave files under storage.path out
+
+Import options:
+  --dry-run             Show what the archive holds and what this version
+                        would leave out, without changing anything
+  --overwrite           Replace the data of an instance that already has users
+
+When scanning.enabled is set, every upload is scanned as it is imported
+and flagged files are quarantined instead.
+
+An archive (format v%d) stores each table as JSON lines keyed by column
+name, plus repositories and uploads, in a gzipped tar file. Unlike a
+backup it can be imported into a newer CasGists version or another
+database type. Stop the server before importing. Sessions are not
+exported, so everyone signs in again afterwards.
+
+Examples:
+  casgists export --all -o casgists-archive.tar.gz
+  casgists export --all --upload
+  casgists export --user octocat -o octocat-gists.zip
+  casgists import casgists-archive.tar.gz --dry-run
+  casgists import casgists-archive.tar.gz
+`, archive.FormatVersion)
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/casapps/casgists/src/internal/backup"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/spf13/cobra"
+)
+
+// backupRunOptions are the flags of the backup run command
+type backupRunOptions struct {
+	backupType    string
+	noRepos       bool
+	noAttachments bool
+	output        string
+}
+
+func newBackupCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "backup",
+		Short: "Take or restore backups",
+		Long: `Take a backup now or restore one, on the server box. The commands use the
+server's configuration and database directly, so the server does not need
+to be running.`,
+		Args: cobra.NoArgs,
+	}
+	cmd.AddCommand(newBackupRunCommand(), newBackupRestoreCommand())
+	return cmd
+}
+
+func newBackupRunCommand() *cobra.Command {
+	opts := &backupRunOptions{}
+	cmd := &cobra.Command{
+		Use:   "run",
+		Short: "Take a backup now",
+		Long: `Take a backup now, the way scheduled backups are taken: it is encrypted
+when backup.encrypt is on, uploaded when backups go to object storage and
+old backups are pruned by the retention policy afterwards.
+
+An incremental backup follows the last scheduled or manual backup, or is
+taken as a full one when there is none.`,
+		Example: `  casgists backup run
+  casgists backup run --type incremental
+  casgists backup run --no-repos --output /tmp/casgists.tar.gz`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBackup(opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.backupType, "type", backup.TypeFull, "full or incremental")
+	cmd.Flags().BoolVar(&opts.noRepos, "no-repos", false, "leave out the git repositories")
+	cmd.Flags().BoolVar(&opts.noAttachments, "no-attachments", false, "leave out attachments")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "write the backup here instead of the backup directory")
+	return cmd
+}
+
+// runBackup takes a backup through the scheduler, so it is treated like a
+// scheduled one
+func runBackup(opts *backupRunOptions) error {
+	if opts.backupType != backup.TypeFull && opts.backupType != backup.TypeIncremental {
+		return fmt.Errorf("unknown backup type %q, use %s or %s", opts.backupType, backup.TypeFull, backup.TypeIncremental)
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	scheduler := backup.NewScheduler(db, cfg, backup.NewManager(db, cfg), nil, nil)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("📦 Taking a %s backup...\n", opts.backupType)
+	result, err := scheduler.Run(ctx, backup.BackupOptions{
+		Type:               opts.backupType,
+		IncludeGitRepos:    cfg.GetBool("backup.include_git_repos") && !opts.noRepos,
+		IncludeAttachments: cfg.GetBool("backup.include_attachments") && !opts.noAttachments,
+		OutputPath:         opts.output,
+	}, nil)
+	if err != nil {
+		fmt.Printf("❌ Backup failed: %v\n", err)
+		return err
+	}
+	for _, message := range result.Errors {
+		fmt.Printf("   ⚠️  %s\n", message)
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.backup",
+		ResourceType: "backup",
+		ResourceID:   result.ID,
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"type":    result.Type,
+		"size":    result.Size,
+		"success": result.Success,
+	}); err != nil {
+		return err
+	}
+
+	if !result.Success {
+		return fmt.Errorf("backup finished with %d errors", len(result.Errors))
+	}
+	location := result.OutputPath
+	if result.StorageKey != "" {
+		location = result.StorageKey
+	}
+	fmt.Printf("✅ %s backup %s saved to %s (%d bytes)\n", result.Type, result.ID, location, result.Size)
+	return nil
+}
@@ -0,0 +1,242 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"syscall"
+
+	"golang.org/x/term"
+
+	"github.com/casapps/casgists/src/internal/configbundle"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// passphraseEnv holds the bundle passphrase unless --passphrase-env names
+// another variable
+const passphraseEnv = "CASGISTS_BUNDLE_PASSPHRASE"
+
+// handleConfigCommand handles the configuration bundle commands
+func handleConfigCommand(args []string) error {
+	if len(args) == 0 {
+		printConfigHelp()
+		return nil
+	}
+
+	switch args[0] {
+	case "export":
+		return runConfigExport(args[1:])
+	case "import":
+		return runConfigImport(args[1:])
+	case "--help", "-h", "help":
+		printConfigHelp()
+		return nil
+	default:
+		printConfigHelp()
+		return fmt.Errorf("unknown config command: %s", args[0])
+	}
+}
+
+// runConfigExport writes the configuration bundle to stdout or a file.
+// Progress goes to stderr so stdout can be redirected.
+func runConfigExport(args []string) error {
+	secrets, args := extractFlag(args, "--secrets")
+	envName, args := extractFlag(args, "--passphrase-env")
+	output, args := extractFlag(args, "--output")
+	if output == "" {
+		output, args = extractFlag(args, "-o")
+	}
+	if len(args) != 0 {
+		return fmt.Errorf("usage: casgists config export [--secrets redact|encrypt|plain] [--passphrase-env VAR] [-o FILE]")
+	}
+
+	opts := configbundle.ExportOptions{Secrets: secrets}
+	if secrets == configbundle.SecretsEncrypt {
+		passphrase, err := bundlePassphrase(envName, true)
+		if err != nil {
+			return err
+		}
+		opts.Passphrase = passphrase
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	bundle, err := configbundle.Export(db, cfg, opts)
+	if err != nil {
+		return err
+	}
+
+	var w io.Writer = os.Stdout
+	if output != "" && output != "-" {
+		file, err := os.OpenFile(output, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0600)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		w = file
+	}
+	if err := configbundle.Encode(w, bundle); err != nil {
+		return fmt.Errorf("failed to write bundle: %w", err)
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.config_export",
+		ResourceType: "system_config",
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"secrets":  bundle.Secrets,
+		"settings": len(bundle.Settings),
+		"system":   len(bundle.System),
+	}); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "✅ Exported %d settings and %d system config values (secrets: %s)\n", len(bundle.Settings), len(bundle.System), bundle.Secrets)
+	return nil
+}
+
+// runConfigImport applies a bundle to the database and configuration file
+func runConfigImport(args []string) error {
+	envName, args := extractFlag(args, "--passphrase-env")
+	dryRun := containsArg(args, "--dry-run")
+	var positional []string
+	for _, arg := range args {
+		if arg != "--dry-run" {
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) != 1 {
+		return fmt.Errorf("usage: casgists config import FILE|- [--passphrase-env VAR] [--dry-run]")
+	}
+
+	var r io.Reader = os.Stdin
+	if positional[0] != "-" {
+		file, err := os.Open(positional[0])
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		r = file
+	}
+	bundle, err := configbundle.Decode(r)
+	if err != nil {
+		return err
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	opts := configbundle.ImportOptions{DryRun: dryRun}
+	if bundle.Secrets == configbundle.SecretsEncrypt {
+		// Reading the bundle from stdin leaves no terminal to prompt on
+		if opts.Passphrase, err = bundlePassphrase(envName, positional[0] != "-"); err != nil {
+			return err
+		}
+	}
+	result, err := configbundle.Import(db, cfg, bundle, opts)
+	if err != nil {
+		return err
+	}
+
+	configPath, err := configFilePath()
+	if err != nil {
+		return err
+	}
+
+	if dryRun {
+		fmt.Printf("🔍 Dry run, nothing was changed\n")
+	} else {
+		if err := configbundle.WriteSettings(configPath, result.Settings); err != nil {
+			return err
+		}
+		entry := models.AuditLog{
+			Action:       "admin_cli.config_import",
+			ResourceType: "system_config",
+		}
+		if err := writeAuditEntry(db, entry, map[string]interface{}{
+			"source":   bundle.Source,
+			"changed":  result.Changed,
+			"redacted": result.Redacted,
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, key := range result.Changed {
+		fmt.Printf("   ✏️  %s\n", key)
+	}
+	for _, key := range result.Redacted {
+		fmt.Printf("   🔒 %s (redacted in the bundle, kept as is)\n", key)
+	}
+	fmt.Printf("📊 %d changed, %d unchanged, %d redacted\n", len(result.Changed), result.Unchanged, len(result.Redacted))
+	if len(result.Settings) > 0 && !dryRun {
+		fmt.Printf("📝 Settings written to %s, restart the server to apply them\n", configPath)
+	}
+	return nil
+}
+
+// bundlePassphrase reads the passphrase from the environment, or prompts
+// for it when allowed and stdin is a terminal
+func bundlePassphrase(envName string, prompt bool) (string, error) {
+	if envName == "" {
+		envName = passphraseEnv
+	}
+	if passphrase := os.Getenv(envName); passphrase != "" {
+		return passphrase, nil
+	}
+	if !prompt || !term.IsTerminal(int(syscall.Stdin)) {
+		return "", fmt.Errorf("%w, set %s", configbundle.ErrPassphraseRequired, envName)
+	}
+
+	fmt.Fprint(os.Stderr, "Bundle passphrase: ")
+	passphrase, err := term.ReadPassword(int(syscall.Stdin))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	if strings.TrimSpace(string(passphrase)) == "" {
+		return "", errors.New("empty passphrase")
+	}
+	return string(passphrase), nil
+}
+
+func printConfigHelp() {
+	fmt.Printf(`Export or import instance configuration, e.g. to clone staging into production
+
+Usage:
+  casgists config <command> [options]
+
+Commands:
+  export                Write a configuration bundle to stdout
+  import FILE|-         Apply a configuration bundle
+
+Export options:
+  --secrets MODE        redact (default), encrypt or plain
+  --passphrase-env VAR  Passphrase variable for encrypt (default %[1]s)
+  -o, --output FILE     Write to FILE instead of stdout
+
+Import options:
+  --passphrase-env VAR  Passphrase variable for encrypted bundles (default %[1]s)
+  --dry-run             Show what would change without changing it
+
+A bundle holds the system configuration stored in the database and the
+%[2]s settings. Instance specific values such as the server URL and
+read-only mode are left out. Redacted secrets keep the target's value on
+import. Settings are merged into the configuration file and take effect
+after a restart.
+
+Examples:
+  casgists config export > config-bundle.yaml
+  %[1]s=... casgists config export --secrets encrypt -o config-bundle.yaml
+  casgists config import config-bundle.yaml --dry-run
+`, passphraseEnv, strings.Join(configbundle.Sections, ", "))
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/privileges"
+	"gorm.io/gorm"
+)
+
+// containerMode is on with --container, CASGISTS_CONTAINER=true or when a
+// container is detected. The server then keeps everything on the /data
+// volume, logs to stdout only and leaves users and services to the container
+// runtime.
+var containerMode bool
+
+// newPathConfig returns the paths the server uses: the data volume in
+// container mode, otherwise the platform's directories for the privilege
+// level
+func newPathConfig() *config.PathConfig {
+	if containerMode {
+		return config.NewContainerPathConfig()
+	}
+	return config.NewPathConfig(privileges.IsElevated())
+}
+
+// errContainerMode is returned by commands that manage the host, which the
+// container runtime does in container mode
+func errContainerMode(what string) error {
+	return fmt.Errorf("%s is not supported in container mode; the container runtime manages the process (set %s=false to override)", what, config.EnvContainer)
+}
+
+// warnWithoutInit warns when the server is the container's init process.
+// It handles SIGTERM itself, but only an init such as tini, or "docker run
+// --init", reaps the orphaned processes git leaves behind.
+func warnWithoutInit() {
+	if os.Getpid() == 1 {
+		log.Println("Running as PID 1; run the container with --init, or tini as the entrypoint, so exited git processes are reaped")
+	}
+}
+
+// keepSecretKey saves a secret key the configuration loader generated to
+// the config file on the data volume, so a recreated container keeps
+// everybody signed in. A key from CASGISTS_SECURITY_SECRET_KEY or the config
+// file is left alone.
+func keepSecretKey(db *gorm.DB, cfg *config.Config, pathConfig *config.PathConfig) error {
+	if os.Getenv("CASGISTS_SECURITY_SECRET_KEY") != "" || cfg.InConfig("security.secret_key") {
+		return nil
+	}
+	configFile := config.ConfigFilePath(pathConfig)
+	settings := config.NewSettings(cfg, models.NewConfigStore(db), configFile)
+	if err := settings.Save("security.secret_key", cfg.GetString("security.secret_key")); err != nil {
+		return fmt.Errorf("failed to save the generated secret key: %w", err)
+	}
+	log.Printf("Generated a secret key and saved it to %s", configFile)
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"gorm.io/gorm"
+)
+
+// openDatabase loads the configuration and database the same way the server
+// does, for commands that work on the database directly. The returned func
+// closes the database.
+func openDatabase() (*gorm.DB, *config.Config, func(), error) {
+	db, cfg, closeDB, err := connectDatabase()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := backupBeforeMigrations(db, cfg); err != nil {
+		closeDB()
+		return nil, nil, nil, err
+	}
+	if err := database.MigrateDB(db); err != nil {
+		closeDB()
+		return nil, nil, nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	return db, cfg, closeDB, nil
+}
+
+// connectDatabase is openDatabase without migrating the database
+func connectDatabase() (*gorm.DB, *config.Config, func(), error) {
+	cfg, err := loadConfig()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	return db, cfg, func() { closeGorm(db) }, nil
+}
+
+// closeGorm closes the connections of a database
+func closeGorm(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		sqlDB.Close()
+	}
+}
+
+// loadConfig resolves paths and loads the configuration the same way the
+// server does, without touching the database
+func loadConfig() (*config.Config, error) {
+	pathConfig := newPathConfig()
+	if err := pathConfig.ResolveAll(); err != nil {
+		return nil, fmt.Errorf("failed to resolve paths: %w", err)
+	}
+	if err := pathConfig.CreateDirectories(); err != nil {
+		return nil, fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	cfg, err := config.LoadWithPaths(pathConfig)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load configuration: %w", err)
+	}
+	cfg.Set("version", Version)
+	return cfg, nil
+}
+
+// configFilePath returns the configuration file the server reads
+func configFilePath() (string, error) {
+	pathConfig := newPathConfig()
+	if err := pathConfig.ResolveAll(); err != nil {
+		return "", fmt.Errorf("failed to resolve paths: %w", err)
+	}
+	return config.ConfigFilePath(pathConfig), nil
+}
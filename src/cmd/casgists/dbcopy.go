@@ -0,0 +1,237 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"text/tabwriter"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/dbcopy"
+	"github.com/spf13/cobra"
+)
+
+// dbMigrateOptions are the flags of the db migrate command
+type dbMigrateOptions struct {
+	from, fromDSN  string
+	to, toDSN      string
+	dryRun         bool
+	yes            bool
+	noConfigUpdate bool
+	batchSize      int
+	json           bool
+}
+
+func newDBCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "db",
+		Short: "Move the database to another database server or repair its counts",
+		Args:  cobra.NoArgs,
+	}
+	cmd.AddCommand(newDBMigrateCommand(), newDBReconcileCommand())
+	return cmd
+}
+
+func newDBMigrateCommand() *cobra.Command {
+	opts := &dbMigrateOptions{}
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Copy the database to another kind of database, such as SQLite to PostgreSQL",
+		Long: `Copy every table of the database to an empty database of another kind,
+such as from SQLite to PostgreSQL, and switch the configuration to it.
+
+The target gets its schema from the migrations. Rows are copied parent
+tables first, with booleans, timestamps and UUIDs converted to the target's
+column types, in one transaction; each table's row count and checksum are
+compared before it is committed. The config file is then pointed at the
+target. The source is left as it was, to go back to.
+
+Stop the server first: rows written during the copy would be lost.`,
+		Example: `  casgists db migrate --to postgres --to-dsn "postgres://casgists:secret@db/casgists" --dry-run
+  casgists db migrate --from sqlite --to postgres --to-dsn "postgres://casgists:secret@db/casgists"
+  casgists db migrate --from postgres --to sqlite --to-dsn /var/lib/casgists/db/casgists.db`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDBMigrate(opts)
+		},
+	}
+	cmd.Flags().StringVar(&opts.from, "from", "", "type of the source database, sqlite, postgres or mysql (the configured database)")
+	cmd.Flags().StringVar(&opts.fromDSN, "from-dsn", "", "source DSN or SQLite file (the configured database)")
+	cmd.Flags().StringVar(&opts.to, "to", "", "type of the target database, sqlite, postgres or mysql")
+	cmd.Flags().StringVar(&opts.toDSN, "to-dsn", "", "target DSN or SQLite file")
+	cmd.Flags().BoolVar(&opts.dryRun, "dry-run", false, "check both databases and show what would be copied")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "don't ask for confirmation")
+	cmd.Flags().BoolVar(&opts.noConfigUpdate, "no-config-update", false, "leave the config file pointing at the source")
+	cmd.Flags().IntVar(&opts.batchSize, "batch-size", 500, "rows inserted per statement")
+	cmd.Flags().BoolVar(&opts.json, "json", false, "print the report as JSON")
+	cmd.MarkFlagRequired("to")
+	cmd.MarkFlagRequired("to-dsn")
+	return cmd
+}
+
+// runDBMigrate copies the configured database to the target
+func runDBMigrate(opts *dbMigrateOptions) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+
+	configured := databaseType(cfg.GetString("database.type"))
+	if opts.from == "" {
+		opts.from = configured
+	}
+	from, to := databaseType(opts.from), databaseType(opts.to)
+	if opts.fromDSN == "" {
+		if from != configured {
+			return fmt.Errorf("the configured database is %s, not %s; pass --from-dsn", configured, from)
+		}
+		opts.fromDSN = cfg.GetString("database.dsn")
+		if opts.fromDSN == "" && from == "sqlite" {
+			opts.fromDSN = cfg.GetString("database.path")
+		}
+	}
+	if from == to && opts.fromDSN == opts.toDSN {
+		return errors.New("the source and target are the same database")
+	}
+
+	source, err := database.Initialize(databaseConfig(cfg, from, opts.fromDSN))
+	if err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	defer closeGorm(source)
+	target, err := database.Initialize(databaseConfig(cfg, to, opts.toDSN))
+	if err != nil {
+		return fmt.Errorf("target: %w", err)
+	}
+	defer closeGorm(target)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	if !opts.dryRun && !opts.yes {
+		if !confirm(fmt.Sprintf("Copy the %s database to %s and switch to it? Stop the server first. [y/N]: ", from, to)) {
+			fmt.Println("Cancelled.")
+			return nil
+		}
+	}
+
+	copyOpts := dbcopy.Options{DryRun: opts.dryRun, BatchSize: opts.batchSize}
+	if !opts.json {
+		copyOpts.Progress = func(table string, copied, total int64) {
+			fmt.Printf("\r   %s: %d/%d rows", table, copied, total)
+			if copied == total {
+				fmt.Println()
+			}
+		}
+		fmt.Printf("📦 Copying %s to %s...\n", from, to)
+	}
+	report, err := dbcopy.Copy(ctx, source, target, copyOpts)
+	if err != nil {
+		return err
+	}
+
+	if !opts.dryRun {
+		if err := writeAuditEntry(target, models.AuditLog{
+			Action:       "admin_cli.db_migrate",
+			ResourceType: "database",
+			ResourceID:   to,
+		}, map[string]interface{}{"from": from, "to": to, "rows": report.Rows, "tables": len(report.Tables)}); err != nil {
+			return err
+		}
+		if !opts.noConfigUpdate {
+			if err := switchDatabase(cfg, to, opts.toDSN); err != nil {
+				return err
+			}
+		}
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(report)
+	}
+	printCopyReport(report)
+	if opts.dryRun {
+		return nil
+	}
+	if opts.noConfigUpdate {
+		fmt.Println("💡 Point database.type and database.dsn at the target to use it.")
+	}
+	fmt.Println("💡 Start the server, then rebuild the search index with: casgists search reindex")
+	return nil
+}
+
+// databaseType returns the name the database package uses for a type
+func databaseType(name string) string {
+	switch name {
+	case "", "sqlite3":
+		return "sqlite"
+	case "postgresql", "pg":
+		return "postgres"
+	}
+	return name
+}
+
+// databaseConfig is cfg with another database
+func databaseConfig(cfg *config.Config, dbType, dsn string) *config.Config {
+	v := config.New()
+	v.Set("database.type", dbType)
+	v.Set("database.dsn", dsn)
+	v.Set("database.max_connections", cfg.GetInt("database.max_connections"))
+	v.Set("debug", cfg.GetBool("debug"))
+	return v
+}
+
+// switchDatabase points the config file at the new database
+func switchDatabase(cfg *config.Config, dbType, dsn string) error {
+	configFile, err := configFilePath()
+	if err != nil {
+		return err
+	}
+	settings := config.NewSettings(cfg, nil, configFile)
+	for key, value := range map[string]string{"database.type": dbType, "database.dsn": dsn} {
+		if err := settings.Save(key, value); err != nil {
+			return fmt.Errorf("the database was copied, but the config file wasn't updated: %w", err)
+		}
+	}
+	fmt.Printf("📝 %s now uses the %s database\n", configFile, dbType)
+	if os.Getenv("CASGISTS_DATABASE_TYPE") != "" || os.Getenv("CASGISTS_DATABASE_DSN") != "" {
+		fmt.Println("⚠️  CASGISTS_DATABASE_TYPE or CASGISTS_DATABASE_DSN is set and overrides the config file; update the environment too")
+	}
+	return nil
+}
+
+func printCopyReport(report *dbcopy.Report) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	if report.DryRun {
+		fmt.Fprintln(w, "TABLE\tROWS")
+		for _, table := range report.Tables {
+			fmt.Fprintf(w, "%s\t%d\n", table.Name, table.Rows)
+		}
+	} else {
+		fmt.Fprintln(w, "TABLE\tROWS\tCHECKSUM")
+		for _, table := range report.Tables {
+			checksum := table.Checksum
+			if checksum == "" {
+				checksum = "-"
+			}
+			fmt.Fprintf(w, "%s\t%d\t%s\n", table.Name, table.Copied, checksum)
+		}
+	}
+	w.Flush()
+
+	for _, name := range report.Skipped {
+		fmt.Printf("⏭️  %s is not part of the %s schema and was not copied\n", name, report.Target)
+	}
+	if report.DryRun {
+		fmt.Printf("\n🔍 Dry run: %d rows in %d tables would be copied from %s to %s; the target is empty\n", report.Rows, len(report.Tables), report.Source, report.Target)
+		return
+	}
+	fmt.Printf("\n✅ Copied and verified %d rows in %d tables from %s to %s\n", report.Rows, len(report.Tables), report.Source, report.Target)
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+
+	"github.com/casapps/casgists/src/internal/counters"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/spf13/cobra"
+)
+
+func newDBReconcileCommand() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "reconcile-counts",
+		Short: "Recompute the star and fork counts of every gist",
+		Long: `Recompute the star count of every gist from its stars and the fork
+count from its forks, and correct the gists where they drifted. The server
+does the same every gists.counters.reconcile_interval; this runs it now.
+View counts have nothing to be recomputed from and are left alone.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDBReconcile(asJSON)
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the result as JSON")
+	return cmd
+}
+
+// runDBReconcile recomputes the star and fork counts of the gists
+func runDBReconcile(asJSON bool) error {
+	db, _, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	result, err := counters.Reconcile(context.Background(), db)
+	if err != nil {
+		return fmt.Errorf("failed to reconcile counts: %w", err)
+	}
+	if result.Stars > 0 || result.Forks > 0 {
+		if err := writeAuditEntry(db, models.AuditLog{
+			Action:       "admin_cli.db_reconcile_counts",
+			ResourceType: "database",
+		}, map[string]interface{}{"stars": result.Stars, "forks": result.Forks}); err != nil {
+			return err
+		}
+	}
+
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(result)
+	}
+	fmt.Printf("✅ Checked %d gists: corrected the star count of %d and the fork count of %d\n", result.Checked, result.Stars, result.Forks)
+	return nil
+}
@@ -0,0 +1,298 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/spf13/cobra"
+)
+
+// doctorOptions are the flags of the doctor command
+type doctorOptions struct {
+	json       bool
+	strict     bool
+	offline    bool
+	configOnly bool
+}
+
+func newDoctorCommand() *cobra.Command {
+	opts := &doctorOptions{}
+	cmd := &cobra.Command{
+		Use:   "doctor",
+		Short: "Check the configuration, database and port without starting the server",
+		Long: `Check that the server can start: the configuration is loaded the way the
+server loads it and linted, the database is connected to and the
+configured port is tried.
+
+Exits with an error when errors are found, or with --strict when warnings
+are found too.`,
+		Example: `  casgists doctor
+  casgists doctor --json --strict
+  casgists doctor --offline --config-only`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDoctor(opts)
+		},
+	}
+	cmd.Flags().BoolVar(&opts.json, "json", false, "print the report as JSON, for CI")
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "fail on warnings too")
+	cmd.Flags().BoolVar(&opts.offline, "offline", false, "skip the SMTP connection check")
+	cmd.Flags().BoolVar(&opts.configOnly, "config-only", false, "only check the configuration, not the port")
+	return cmd
+}
+
+// runDoctor checks the configuration and what the server needs to start
+func runDoctor(opts *doctorOptions) error {
+	if !opts.json {
+		fmt.Println("🔍 Checking CasGists configuration...")
+	}
+
+	report, cfg := runConfigCheck(!opts.offline)
+	if cfg != nil && !opts.configOnly {
+		checkPort(report, cfg)
+	}
+
+	if opts.json {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printLintReport(report)
+	}
+
+	if report.Failed(opts.strict) {
+		return fmt.Errorf("%d error(s), %d warning(s)", report.Errors, report.Warnings)
+	}
+	return nil
+}
+
+// runConfigCheck loads the configuration the way the server does and lints
+// it. Problems loading it are reported as diagnostics too; the
+// configuration is nil then.
+func runConfigCheck(checkNetwork bool) (*config.LintReport, *config.Config) {
+	report := &config.LintReport{Valid: true, Diagnostics: []config.Diagnostic{}}
+
+	pathConfig := newPathConfig()
+	if err := pathConfig.ResolveAll(); err != nil {
+		report.Add(config.Diagnostic{
+			Severity: config.SeverityError,
+			Code:     "paths_unresolved",
+			Message:  fmt.Sprintf("path resolution failed: %v", err),
+			Hint:     "check the CASGISTS_*_DIR environment variables",
+		})
+		return report, nil
+	}
+
+	cfg, err := config.LoadWithPaths(pathConfig)
+	if err != nil {
+		report.Add(config.Diagnostic{
+			Severity: config.SeverityError,
+			Code:     "config_unreadable",
+			Message:  fmt.Sprintf("configuration loading failed: %v", err),
+			Hint:     "fix the syntax of config.yaml",
+		})
+		return report, nil
+	}
+
+	report = config.Lint(cfg, config.LintOptions{CheckSMTP: checkNetwork})
+
+	// A SQLite database that doesn't exist yet is created on first start;
+	// lint has already checked that its directory is writable
+	sqliteMissing := false
+	if dbType := cfg.GetString("database.type"); dbType == "sqlite" || dbType == "" {
+		_, err := os.Stat(cfg.GetString("database.path"))
+		sqliteMissing = os.IsNotExist(err)
+	}
+	if sqliteMissing {
+		return report, cfg
+	}
+
+	if err := testDatabaseConnection(cfg); err != nil {
+		report.Add(config.Diagnostic{
+			Severity: config.SeverityError,
+			Code:     "database_unreachable",
+			Key:      "database",
+			Message:  fmt.Sprintf("database connection failed: %v", err),
+			Hint:     "check the database settings and that the database server is running",
+		})
+	}
+
+	return report, cfg
+}
+
+// checkPort reports a configured port something else listens on. Without
+// one the server picks a free port when it starts.
+func checkPort(report *config.LintReport, cfg *config.Config) {
+	port := cfg.GetInt("server.port")
+	if port == 0 {
+		return
+	}
+	if err := testPortAvailability(port); err != nil {
+		report.Add(config.Diagnostic{
+			Severity: config.SeverityWarning,
+			Code:     "port_unavailable",
+			Key:      "server.port",
+			Message:  fmt.Sprintf("port %d is not available: %v", port, err),
+			Hint:     "stop whatever listens on it, which may be CasGists itself, or change server.port",
+		})
+	}
+}
+
+func printLintReport(report *config.LintReport) {
+	for _, d := range report.Diagnostics {
+		icon := "⚠️ "
+		if d.Severity == config.SeverityError {
+			icon = "❌"
+		}
+		if d.Key != "" {
+			fmt.Printf("%s [%s] %s: %s\n", icon, d.Code, d.Key, d.Message)
+		} else {
+			fmt.Printf("%s [%s] %s\n", icon, d.Code, d.Message)
+		}
+		if d.Hint != "" {
+			fmt.Printf("   💡 %s\n", d.Hint)
+		}
+	}
+
+	switch {
+	case report.Errors > 0:
+		fmt.Printf("\n❌ Configuration has %d error(s) and %d warning(s)\n", report.Errors, report.Warnings)
+	case report.Warnings > 0:
+		fmt.Printf("\n⚠️  Configuration is usable but has %d warning(s)\n", report.Warnings)
+	default:
+		fmt.Println("\n🎉 Configuration is valid and ready!")
+	}
+}
+
+func newStatusCommand() *cobra.Command {
+	var port int
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show whether a server is running on this machine",
+		Long: `Ask a server on this machine for its health and print its version,
+uptime and request metrics. Without --port the ports 64000 to 64005 are
+tried.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStatus(port)
+		},
+	}
+	cmd.Flags().IntVar(&port, "port", 0, "port the server listens on")
+	return cmd
+}
+
+// runStatus shows server status
+func runStatus(port int) error {
+	fmt.Println("📊 Checking CasGists server status...")
+
+	// Try to connect to potential running instances
+	portRanges := []int{64000, 64001, 64002, 64003, 64004, 64005}
+	if port != 0 {
+		portRanges = []int{port}
+	}
+	var runningPort int
+	var serverResponse map[string]interface{}
+
+	for _, port := range portRanges {
+		if resp, err := checkServerHealth(port); err == nil {
+			runningPort = port
+			serverResponse = resp
+			break
+		}
+	}
+
+	if runningPort == 0 {
+		fmt.Println("❌ No CasGists server detected")
+		fmt.Println("💡 Start server with: casgists serve")
+		return nil
+	}
+
+	fmt.Printf("✅ CasGists server running on port %d\n", runningPort)
+
+	if serverResponse != nil {
+		if version, ok := serverResponse["version"].(string); ok {
+			fmt.Printf("📦 Version: %s\n", version)
+		}
+		if uptime, ok := serverResponse["uptime"].(string); ok {
+			fmt.Printf("⏱️  Uptime: %s\n", uptime)
+		}
+		if status, ok := serverResponse["status"].(string); ok {
+			fmt.Printf("🟢 Status: %s\n", status)
+		}
+		if metrics, ok := serverResponse["metrics"].(map[string]interface{}); ok {
+			if users, ok := metrics["total_users"].(float64); ok {
+				fmt.Printf("👥 Users: %.0f\n", users)
+			}
+			if gists, ok := metrics["total_gists"].(float64); ok {
+				fmt.Printf("📝 Gists: %.0f\n", gists)
+			}
+			if requests, ok := metrics["requests_per_minute"].(float64); ok {
+				fmt.Printf("📈 Requests (last minute): %.0f\n", requests)
+			}
+			if p50, ok := metrics["p50_response_time"].(string); ok {
+				fmt.Printf("⚡ Response time: p50 %s, p95 %v, average %v\n", p50, metrics["p95_response_time"], metrics["average_response_time"])
+			}
+			if connections, ok := metrics["active_connections"].(float64); ok {
+				fmt.Printf("🔌 Connections: %.0f open, %v requests in flight\n", connections, metrics["requests_in_flight"])
+			}
+		}
+	}
+
+	return nil
+}
+
+// Helper functions
+func testDatabaseConnection(cfg *config.Config) error {
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}()
+
+	// Simple ping test
+	if sqlDB, err := db.DB(); err == nil {
+		return sqlDB.Ping()
+	}
+	return nil
+}
+
+func testPortAvailability(port int) error {
+	conn, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return err
+	}
+	conn.Close()
+	return nil
+}
+
+func checkServerHealth(port int) (map[string]interface{}, error) {
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get(fmt.Sprintf("http://localhost:%d/healthz", port))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("server returned status %d", resp.StatusCode)
+	}
+
+	var result map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/urls"
+	"github.com/spf13/cobra"
+)
+
+// gistClient talks to a CasGists server over the REST API with a personal
+// access token
+type gistClient struct {
+	server string
+	token  string
+	json   bool
+	http   *http.Client
+}
+
+// remoteGist is the part of a gist in API responses the commands show
+type remoteGist struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Visibility  string   `json:"visibility"`
+	Tags        []string `json:"tags"`
+	URL         string   `json:"url"`
+	CreatedAt   string   `json:"created_at"`
+	User        *struct {
+		Username string `json:"username"`
+	} `json:"user"`
+	Files []remoteFile `json:"files"`
+}
+
+type remoteFile struct {
+	Filename string `json:"filename"`
+	Language string `json:"language,omitempty"`
+	Content  string `json:"content"`
+	Size     int64  `json:"size,omitempty"`
+}
+
+func newGistCommand() *cobra.Command {
+	client := &gistClient{http: &http.Client{Timeout: 30 * time.Second}}
+	cmd := &cobra.Command{
+		Use:   "gist",
+		Short: "List, create and show gists on a CasGists server",
+		Long: `List, create and show gists on a CasGists server, over the REST API.
+
+The server is taken from --server or CASGISTS_URL. The personal access
+token is taken from --token, CASGISTS_TOKEN, or the access_token line of
+the credentials file the shell client saves on login
+($XDG_CONFIG_HOME/casgists/credentials, or CASGISTS_TOKEN_FILE).`,
+		Args: cobra.NoArgs,
+		// A client doesn't log; the output is for scripts to read
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return client.resolve()
+		},
+	}
+	cmd.PersistentFlags().StringVar(&client.server, "server", os.Getenv("CASGISTS_URL"), "server URL, such as https://gists.example.com")
+	cmd.PersistentFlags().StringVar(&client.token, "token", "", "personal access token")
+	cmd.PersistentFlags().BoolVar(&client.json, "json", false, "print the API response as JSON")
+	cmd.AddCommand(newGistListCommand(client), newGistCreateCommand(client), newGistGetCommand(client))
+	return cmd
+}
+
+func newGistListCommand(client *gistClient) *cobra.Command {
+	var username, visibility, cursor string
+	var limit int
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List gists",
+		Long: `List gists, newest first. When there are more, the cursor of the next
+page is printed to standard error; repeat the command with --cursor to
+list it.`,
+		Example: `  casgists gist list
+  casgists gist list --user alice --limit 50`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			query := url.Values{}
+			query.Set("per_page", strconv.Itoa(limit))
+			if cursor != "" {
+				query.Set("cursor", cursor)
+			}
+			if username != "" {
+				query.Set("username", username)
+			}
+			if visibility != "" {
+				query.Set("visibility", visibility)
+			}
+
+			var response struct {
+				Gists      []remoteGist `json:"gists"`
+				Pagination struct {
+					NextCursor string `json:"next_cursor"`
+				} `json:"pagination"`
+			}
+			raw, err := client.do(http.MethodGet, "/api/v1/gists?"+query.Encode(), nil, &response)
+			if err != nil || client.json {
+				return client.printRaw(raw, err)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "ID\tOWNER\tVISIBILITY\tFILES\tTITLE")
+			for _, gist := range response.Gists {
+				owner := ""
+				if gist.User != nil {
+					owner = gist.User.Username
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%d\t%s\n", gist.ID, owner, gist.Visibility, len(gist.Files), gist.Title)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			if next := response.Pagination.NextCursor; next != "" {
+				fmt.Fprintf(os.Stderr, "More gists: repeat with --cursor %s\n", next)
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&username, "user", "", "only gists of this user")
+	cmd.Flags().StringVar(&visibility, "visibility", "", "only public, unlisted or private gists")
+	cmd.Flags().StringVar(&cursor, "cursor", "", "list the page after this cursor, printed by the previous page")
+	cmd.Flags().IntVar(&limit, "limit", 20, "gists per page, at most 100")
+	return cmd
+}
+
+func newGistCreateCommand(client *gistClient) *cobra.Command {
+	var title, description, visibility, stdinName string
+	var tags []string
+	cmd := &cobra.Command{
+		Use:   "create FILE...",
+		Short: "Create a gist from files",
+		Long: `Create a gist from files and print its address. "-" reads a file from
+standard input, named by --filename.`,
+		Example: `  casgists gist create main.go go.mod --title "Hello world"
+  kubectl get pods | casgists gist create - --filename pods.txt --visibility private`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			files := make([]remoteFile, 0, len(args))
+			for _, path := range args {
+				file, err := readGistFile(path, stdinName)
+				if err != nil {
+					return err
+				}
+				files = append(files, file)
+			}
+			if title == "" {
+				title = files[0].Filename
+			}
+
+			request := map[string]interface{}{
+				"title":       title,
+				"description": description,
+				"visibility":  visibility,
+				"tags":        tags,
+				"files":       files,
+			}
+			var created remoteGist
+			raw, err := client.do(http.MethodPost, "/api/v1/gists", request, &created)
+			if err != nil || client.json {
+				return client.printRaw(raw, err)
+			}
+			fmt.Println(client.webURL(created))
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&title, "title", "", "title, the first file name when empty")
+	cmd.Flags().StringVar(&description, "description", "", "description, in markdown")
+	cmd.Flags().StringVar(&visibility, "visibility", "", "public, unlisted or private (the server's default when empty)")
+	cmd.Flags().StringSliceVar(&tags, "tag", nil, "tag the gist; repeat or separate with commas")
+	cmd.Flags().StringVar(&stdinName, "filename", "snippet.txt", "name of the file read from standard input")
+	return cmd
+}
+
+func newGistGetCommand(client *gistClient) *cobra.Command {
+	var filename string
+	cmd := &cobra.Command{
+		Use:   "get ID",
+		Short: "Show a gist and its files",
+		Example: `  casgists gist get 3f0c6a2e-8d1b-4f5a-9c7e-2b4d6f8a0c1e
+  casgists gist get 3f0c6a2e-8d1b-4f5a-9c7e-2b4d6f8a0c1e --file main.go > main.go`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var gist remoteGist
+			raw, err := client.do(http.MethodGet, urls.Path(urls.APIGist, args[0]), nil, &gist)
+			if err != nil || client.json {
+				return client.printRaw(raw, err)
+			}
+
+			if filename != "" {
+				for _, file := range gist.Files {
+					if file.Filename == filename {
+						_, err := io.WriteString(os.Stdout, file.Content)
+						return err
+					}
+				}
+				return fmt.Errorf("gist %s has no file %q", gist.ID, filename)
+			}
+
+			fmt.Printf("%s\n%s\n", gist.Title, client.webURL(gist))
+			if gist.Description != "" {
+				fmt.Printf("\n%s\n", gist.Description)
+			}
+			for _, file := range gist.Files {
+				fmt.Printf("\n==> %s <==\n%s", file.Filename, file.Content)
+				if !strings.HasSuffix(file.Content, "\n") {
+					fmt.Println()
+				}
+			}
+			return nil
+		},
+	}
+	cmd.Flags().StringVar(&filename, "file", "", "print only the content of this file")
+	return cmd
+}
+
+// resolve fills in the token from the environment or the credentials file
+// and checks that the client has what it needs
+func (c *gistClient) resolve() error {
+	c.server = strings.TrimRight(c.server, "/")
+	if c.server == "" {
+		return errors.New("no server given, pass --server or set CASGISTS_URL")
+	}
+	if c.token == "" {
+		c.token = os.Getenv("CASGISTS_TOKEN")
+	}
+	if c.token == "" {
+		c.token = savedToken()
+	}
+	if c.token == "" {
+		return errors.New("no token given, pass --token, set CASGISTS_TOKEN or log in with the shell client")
+	}
+	return nil
+}
+
+// do sends an API request and decodes the response into out. The raw
+// response is returned for --json.
+func (c *gistClient) do(method, path string, body, out interface{}) ([]byte, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("User-Agent", "casgists-cli/"+Version)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode >= 300 {
+		var apiError struct {
+			Message string `json:"message"`
+		}
+		if json.Unmarshal(raw, &apiError) == nil && apiError.Message != "" {
+			return nil, fmt.Errorf("%s (%d)", apiError.Message, resp.StatusCode)
+		}
+		return nil, fmt.Errorf("server returned %s", resp.Status)
+	}
+	if err := json.Unmarshal(raw, out); err != nil {
+		return nil, fmt.Errorf("unexpected response from %s: %w", c.server, err)
+	}
+	return raw, nil
+}
+
+// printRaw prints a response as it came with --json, indented
+func (c *gistClient) printRaw(raw []byte, err error) error {
+	if err != nil {
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, raw, "", "  "); err != nil {
+		return err
+	}
+	indented.WriteByte('\n')
+	_, err = indented.WriteTo(os.Stdout)
+	return err
+}
+
+// webURL returns the address of a gist's page
+func (c *gistClient) webURL(gist remoteGist) string {
+	if gist.URL != "" {
+		return c.server + gist.URL
+	}
+	return c.server + urls.Path(urls.GistPage, gist.ID)
+}
+
+// readGistFile reads a file to upload; "-" is standard input
+func readGistFile(path, stdinName string) (remoteFile, error) {
+	if path == "-" {
+		content, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return remoteFile{}, fmt.Errorf("failed to read standard input: %w", err)
+		}
+		return remoteFile{Filename: stdinName, Content: string(content)}, nil
+	}
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return remoteFile{}, err
+	}
+	return remoteFile{Filename: filepath.Base(path), Content: string(content)}, nil
+}
+
+// savedToken reads the access token the shell client saved on login
+func savedToken() string {
+	path := os.Getenv("CASGISTS_TOKEN_FILE")
+	if path == "" {
+		dir, err := os.UserConfigDir()
+		if err != nil {
+			return ""
+		}
+		path = filepath.Join(dir, "casgists", "credentials")
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if token, ok := strings.CutPrefix(scanner.Text(), "access_token="); ok {
+			return strings.TrimSpace(token)
+		}
+	}
+	return ""
+}
@@ -4,92 +4,82 @@ import (
 	"context"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
-	"strconv"
-	"strings"
 
 	"github.com/casapps/casgists/src/internal/installer"
+	"github.com/spf13/cobra"
 )
 
-// handleInstallCommand handles the install command
-func handleInstallCommand(args []string) error {
-	// Default values
-	installPort := 64080
-	installUser := "casgists"
-	installPath := "/opt/casgists"
-	dataDir := "/var/lib/casgists"
-	configPath := "/etc/casgists/config.yaml"
-	noSystemService := false
-
-	// Parse flags
-	for i, arg := range args {
-		switch arg {
-		case "--port":
-			if i+1 < len(args) {
-				if port, err := strconv.Atoi(args[i+1]); err == nil {
-					installPort = port
-				}
-			}
-		case "--user":
-			if i+1 < len(args) {
-				installUser = args[i+1]
-			}
-		case "--install-path":
-			if i+1 < len(args) {
-				installPath = args[i+1]
-			}
-		case "--data-dir":
-			if i+1 < len(args) {
-				dataDir = args[i+1]
-			}
-		case "--config":
-			if i+1 < len(args) {
-				configPath = args[i+1]
-			}
-		case "--no-service":
-			noSystemService = true
-		case "--help", "-h":
-			printInstallHelp()
-			return nil
-		}
-	}
-
-	return runInstall(installPort, installUser, installPath, dataDir, configPath, noSystemService)
+// installOptions are the flags of the install and uninstall commands
+type installOptions struct {
+	port        int
+	user        string
+	installPath string
+	dataDir     string
+	configPath  string
+	noService   bool
 }
 
-// handleUninstallCommand handles the uninstall command
-func handleUninstallCommand(args []string) error {
-	// Default values
-	installUser := "casgists"
-	installPath := "/opt/casgists"
-	dataDir := "/var/lib/casgists"
-	configPath := "/etc/casgists/config.yaml"
+// addInstallFlags adds the flags both commands share, defaulting to the
+// platform's layout
+func addInstallFlags(cmd *cobra.Command, opts *installOptions) {
+	defaults := installer.DefaultConfig()
+	cmd.Flags().StringVar(&opts.user, "user", defaults.User, "system user to run as (not used on Windows)")
+	cmd.Flags().StringVar(&opts.installPath, "install-path", defaults.InstallPath, "installation directory")
+	cmd.Flags().StringVar(&opts.dataDir, "data-dir", defaults.DataDir, "data directory")
+	cmd.Flags().StringVar(&opts.configPath, "config", defaults.ConfigPath, "configuration file path")
+}
 
-	// Parse flags
-	for _, arg := range args {
-		switch arg {
-		case "--user":
-			// Would need to parse next arg
-		case "--help", "-h":
-			printUninstallHelp()
-			return nil
-		}
-	}
+func newInstallCommand() *cobra.Command {
+	opts := &installOptions{}
+	cmd := &cobra.Command{
+		Use:   "install",
+		Short: "Install CasGists as a system service",
+		Long: `Install CasGists as a system service: a systemd or SysV init service on
+Linux, a launchd daemon on macOS and a service registered with the service
+control manager on Windows, where it must be run from an elevated prompt.`,
+		Example: `  sudo casgists install
+  sudo casgists install --port 64080 --user casgists
+  sudo casgists install --data-dir /var/lib/casgists --no-service
+  casgists.exe install --data-dir D:\CasGists`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runInstall(opts.port, opts.user, opts.installPath, opts.dataDir, opts.configPath, opts.noService)
+		},
+	}
+	cmd.Flags().IntVar(&opts.port, "port", 64080, "port to bind to")
+	addInstallFlags(cmd, opts)
+	cmd.Flags().BoolVar(&opts.noService, "no-service", false, "skip system service installation")
+	return cmd
+}
 
-	return runUninstall(installUser, installPath, dataDir, configPath)
+func newUninstallCommand() *cobra.Command {
+	opts := &installOptions{}
+	cmd := &cobra.Command{
+		Use:     "uninstall",
+		Short:   "Uninstall CasGists from the system",
+		Example: "  sudo casgists uninstall",
+		Args:    cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUninstall(opts.user, opts.installPath, opts.dataDir, opts.configPath)
+		},
+	}
+	addInstallFlags(cmd, opts)
+	return cmd
 }
 
 func runInstall(port int, user, installPath, dataDir, configPath string, noService bool) error {
+	if containerMode {
+		return errContainerMode("installing a system service")
+	}
+
 	// Verify system requirements
 	if err := installer.VerifySystemRequirements(); err != nil {
 		return fmt.Errorf("system requirements not met: %w", err)
 	}
 
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("this command must be run as root (use sudo)")
+	if err := installer.RequireAdmin("this command"); err != nil {
+		return err
 	}
 
 	// Validate port
@@ -120,7 +110,6 @@ func runInstall(port int, user, installPath, dataDir, configPath string, noServi
 		InstallPath:     installPath,
 		WorkingDir:      dataDir,
 		EnvFile:         filepath.Join(filepath.Dir(configPath), "environment"),
-		LogFile:         filepath.Join("/var/log/casgists", "casgists.log"),
 		NoSystemService: noService,
 	}
 
@@ -137,9 +126,12 @@ func runInstall(port int, user, installPath, dataDir, configPath string, noServi
 }
 
 func runUninstall(user, installPath, dataDir, configPath string) error {
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("this command must be run as root (use sudo)")
+	if containerMode {
+		return errContainerMode("uninstalling a system service")
+	}
+
+	if err := installer.RequireAdmin("this command"); err != nil {
+		return err
 	}
 
 	// Create installer config
@@ -162,114 +154,3 @@ func runUninstall(user, installPath, dataDir, configPath string) error {
 
 	return nil
 }
-
-// handleVerifyInstallCommand verifies installation
-func handleVerifyInstallCommand(args []string) error {
-	fmt.Println("Verifying CasGists installation...")
-
-	// Check binary
-	if _, err := os.Stat("/opt/casgists/bin/casgists"); os.IsNotExist(err) {
-		fmt.Println("❌ Binary not found at /opt/casgists/bin/casgists")
-	} else {
-		fmt.Println("✅ Binary installed")
-	}
-
-	// Check config
-	if _, err := os.Stat("/etc/casgists/config.yaml"); os.IsNotExist(err) {
-		fmt.Println("❌ Configuration not found at /etc/casgists/config.yaml")
-	} else {
-		fmt.Println("✅ Configuration file exists")
-	}
-
-	// Check data directory
-	if _, err := os.Stat("/var/lib/casgists"); os.IsNotExist(err) {
-		fmt.Println("❌ Data directory not found at /var/lib/casgists")
-	} else {
-		fmt.Println("✅ Data directory exists")
-	}
-
-	// Check service (Linux with systemd)
-	if runtime.GOOS == "linux" {
-		if _, err := os.Stat("/etc/systemd/system/casgists.service"); err == nil {
-			fmt.Println("✅ Systemd service installed")
-			
-			// Try to get service status
-			if output, err := exec.Command("systemctl", "is-active", "casgists").Output(); err == nil {
-				status := strings.TrimSpace(string(output))
-				if status == "active" {
-					fmt.Println("✅ Service is running")
-				} else {
-					fmt.Println("⚠️  Service is not running")
-				}
-			}
-		}
-	}
-
-	// Check port
-	portFile := "/etc/casgists/config.yaml"
-	if data, err := os.ReadFile(portFile); err == nil {
-		// Simple port extraction (not robust, but good enough for verification)
-		portStr := extractPort(string(data))
-		if port, err := strconv.Atoi(portStr); err == nil {
-			if err := installer.CheckPort(port); err != nil {
-				fmt.Printf("✅ CasGists appears to be listening on port %d\n", port)
-			} else {
-				fmt.Printf("⚠️  Port %d is not in use\n", port)
-			}
-		}
-	}
-
-	return nil
-}
-
-func extractPort(config string) string {
-	// Very simple port extraction - in production would use proper YAML parser
-	lines := strings.Split(config, "\n")
-	for _, line := range lines {
-		if strings.Contains(line, "port:") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 2 {
-				return strings.TrimSpace(parts[1])
-			}
-		}
-	}
-	return "64080"
-}
-
-func printInstallHelp() {
-	fmt.Println(`Install CasGists as a system service
-
-Usage:
-  casgists install [options]
-
-Options:
-  --port PORT           Port to bind to (default: 64080)
-  --user USER           System user to run as (default: casgists)
-  --install-path PATH   Installation directory (default: /opt/casgists)
-  --data-dir PATH       Data directory (default: /var/lib/casgists)
-  --config PATH         Configuration file path (default: /etc/casgists/config.yaml)
-  --no-service          Skip system service installation
-  -h, --help            Show this help message
-
-Examples:
-  sudo casgists install
-  sudo casgists install --port 64080 --user casgists
-  sudo casgists install --data-dir /var/lib/casgists --no-service`)
-}
-
-func printUninstallHelp() {
-	fmt.Println(`Uninstall CasGists from the system
-
-Usage:
-  casgists uninstall [options]
-
-Options:
-  --user USER           System user (default: casgists)
-  --install-path PATH   Installation directory (default: /opt/casgists)
-  --data-dir PATH       Data directory (default: /var/lib/casgists)
-  --config PATH         Configuration file path (default: /etc/casgists/config.yaml)
-  -h, --help            Show this help message
-
-Examples:
-  sudo casgists uninstall`)
-}
\ No newline at end of file
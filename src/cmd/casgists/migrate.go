@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/spf13/cobra"
+)
+
+func newMigrateCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "migrate",
+		Short: "Show, apply or revert database migrations",
+		Long: `Show, apply or revert the versioned database migrations. The server
+applies pending migrations when it starts, so "up" is only needed to
+migrate ahead of a start. Stop the server before reverting migrations;
+the release that is started afterwards must not need them.
+
+Before changing the schema the database is saved to the backup directory,
+unless update.backup_before_migrate is off.`,
+		Args: cobra.NoArgs,
+	}
+	cmd.AddCommand(newMigrateStatusCommand(), newMigrateUpCommand(), newMigrateDownCommand())
+	return cmd
+}
+
+func newMigrateStatusCommand() *cobra.Command {
+	var asJSON bool
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "List migrations and whether they are applied",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			db, _, closeDB, err := connectDatabase()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			migrations, err := database.Migrations(db)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				type status struct {
+					database.Migration
+					Reversible bool `json:"reversible"`
+				}
+				list := make([]status, len(migrations))
+				for i, m := range migrations {
+					list[i] = status{Migration: m, Reversible: m.Reversible()}
+				}
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(map[string]interface{}{
+					"database":   db.Dialector.Name(),
+					"migrations": list,
+				})
+			}
+
+			pending := 0
+			w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+			fmt.Fprintln(w, "VERSION\tNAME\tSTATUS\tREVERSIBLE")
+			for _, m := range migrations {
+				state := "applied"
+				switch {
+				case m.Up == "":
+					state = "applied (unknown to this release)"
+				case !m.Applied:
+					state = "pending"
+					pending++
+				}
+				reversible := "no"
+				if m.Reversible() {
+					reversible = "yes"
+				}
+				fmt.Fprintf(w, "%06d\t%s\t%s\t%s\n", m.Version, m.Name, state, reversible)
+			}
+			if err := w.Flush(); err != nil {
+				return err
+			}
+			fmt.Printf("\n%d migrations, %d pending, on %s\n", len(migrations), pending, db.Dialector.Name())
+			return nil
+		},
+	}
+	cmd.Flags().BoolVar(&asJSON, "json", false, "print the migrations as JSON")
+	return cmd
+}
+
+func newMigrateUpCommand() *cobra.Command {
+	var steps int
+	cmd := &cobra.Command{
+		Use:   "up",
+		Short: "Apply pending migrations",
+		Example: `  casgists migrate up
+  casgists migrate up --steps 1`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if steps < 0 {
+				return errors.New("--steps can't be negative")
+			}
+			db, cfg, closeDB, err := connectDatabase()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			if err := backupBeforeMigrations(db, cfg); err != nil {
+				return err
+			}
+			// All of them is what the server does on start
+			if steps == 0 {
+				migrations, err := database.Migrations(db)
+				if err != nil {
+					return err
+				}
+				if err := database.MigrateDB(db); err != nil {
+					return fmt.Errorf("failed to run migrations: %w", err)
+				}
+				pending := 0
+				for _, m := range migrations {
+					if !m.Applied {
+						pending++
+					}
+				}
+				fmt.Printf("✅ Applied %d migrations\n", pending)
+				return nil
+			}
+
+			applied, err := database.MigrateUp(db, steps)
+			for _, m := range applied {
+				fmt.Printf("⬆️  %06d %s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("✅ Applied %d migrations\n", len(applied))
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&steps, "steps", 0, "apply this many migrations, all pending ones when 0")
+	return cmd
+}
+
+func newMigrateDownCommand() *cobra.Command {
+	var steps int
+	var yes bool
+	cmd := &cobra.Command{
+		Use:   "down",
+		Short: "Revert the last applied migrations",
+		Long: `Revert the last applied migrations, newest first, by running their down
+files. Data in the tables and columns they added is lost; the database is
+saved to the backup directory first, unless update.backup_before_migrate
+is off. Migrations without a down file can't be reverted.`,
+		Example: `  casgists migrate down
+  casgists migrate down --steps 3 --yes`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if steps <= 0 {
+				return errors.New("--steps must be greater than 0")
+			}
+			db, cfg, closeDB, err := connectDatabase()
+			if err != nil {
+				return err
+			}
+			defer closeDB()
+
+			if !yes && !confirm(fmt.Sprintf("Revert the last %d migrations? Data they added is lost. [y/N]: ", steps)) {
+				fmt.Println("Cancelled.")
+				return nil
+			}
+			if cfg.GetBool("update.backup_before_migrate") {
+				if err := saveDatabase(db, cfg); err != nil {
+					return err
+				}
+			}
+
+			reverted, err := database.MigrateDown(db, steps)
+			for _, m := range reverted {
+				fmt.Printf("⬇️  %06d %s\n", m.Version, m.Name)
+			}
+			if err != nil {
+				return err
+			}
+			fmt.Printf("✅ Reverted %d migrations\n", len(reverted))
+			return nil
+		},
+	}
+	cmd.Flags().IntVar(&steps, "steps", 1, "number of migrations to revert")
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, "don't ask for confirmation")
+	return cmd
+}
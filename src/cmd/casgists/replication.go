@@ -0,0 +1,162 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/replication"
+)
+
+// handleReplicationCommand handles the SQLite replication commands
+func handleReplicationCommand(args []string) error {
+	if len(args) == 0 {
+		printReplicationHelp()
+		return nil
+	}
+
+	switch args[0] {
+	case "status":
+		return runReplicationStatus()
+	case "restore":
+		return runReplicationRestore(args[1:])
+	case "--help", "-h", "help":
+		printReplicationHelp()
+		return nil
+	default:
+		printReplicationHelp()
+		return fmt.Errorf("unknown replication command: %s", args[0])
+	}
+}
+
+// runReplicationStatus lists the generations in the replica and how far
+// each one can restore
+func runReplicationStatus() error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	replica, err := replication.NewReplicaFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	if cfg.GetBool("replication.enabled") {
+		fmt.Println("🟢 Replication: enabled")
+	} else {
+		fmt.Println("⚪ Replication: disabled")
+	}
+	fmt.Printf("📦 Target: %s\n", replica.Describe())
+
+	generations, err := replication.ListGenerations(context.Background(), replica)
+	if err != nil {
+		return err
+	}
+	if len(generations) == 0 {
+		fmt.Println("⚠️  The replica holds no generations yet")
+		return nil
+	}
+
+	fmt.Println("\nGenerations:")
+	for _, g := range generations {
+		snapshot := "snapshot"
+		if !g.Snapshot {
+			snapshot = "no snapshot, cannot be restored"
+		}
+		fmt.Printf("  %s  %s → %s  (%d WAL segments, %s)\n",
+			g.Name,
+			g.CreatedAt.Format(time.RFC3339),
+			g.RestorableTo.Format(time.RFC3339),
+			g.Segments,
+			snapshot)
+	}
+	return nil
+}
+
+// runReplicationRestore rebuilds the database from the replica
+func runReplicationRestore(args []string) error {
+	output, args := extractFlag(args, "--to")
+	generation, args := extractFlag(args, "--generation")
+	timestamp, args := extractFlag(args, "--timestamp")
+	force := containsArg(args, "--force")
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	if t := cfg.GetString("database.type"); t != "sqlite" && t != "" {
+		return fmt.Errorf("replication only supports SQLite, not %s", t)
+	}
+	replica, err := replication.NewReplicaFromConfig(cfg)
+	if err != nil {
+		return err
+	}
+
+	opts := replication.RestoreOptions{OutputPath: output, Generation: generation}
+	if opts.OutputPath == "" {
+		opts.OutputPath = cfg.GetString("database.path")
+	}
+	if timestamp != "" {
+		opts.Timestamp, err = time.Parse(time.RFC3339, timestamp)
+		if err != nil {
+			return fmt.Errorf("invalid --timestamp, expected RFC3339 such as 2024-01-15T14:30:00Z: %w", err)
+		}
+	}
+
+	// Keep the database being replaced instead of deleting it
+	var keptAs string
+	if _, err := os.Stat(opts.OutputPath); err == nil {
+		if !force {
+			return fmt.Errorf("%s already exists; stop the server and pass --force to replace it, or restore elsewhere with --to", opts.OutputPath)
+		}
+		keptAs = fmt.Sprintf("%s.before-restore-%s", opts.OutputPath, time.Now().UTC().Format("20060102T150405Z"))
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			if err := os.Rename(opts.OutputPath+suffix, keptAs+suffix); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to move the existing database aside: %w", err)
+			}
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("📥 Restoring from %s...\n", replica.Describe())
+	result, err := replication.Restore(ctx, replica, opts)
+	if err != nil {
+		fmt.Printf("❌ Restore failed: %v\n", err)
+		if keptAs != "" {
+			fmt.Printf("   The previous database is still at %s\n", keptAs)
+		}
+		return err
+	}
+
+	fmt.Printf("✅ Restored generation %s to %s\n", result.Generation, result.OutputPath)
+	fmt.Printf("📸 Snapshot: %s\n", result.SnapshotAt.Format(time.RFC3339))
+	fmt.Printf("⏱️  Restored to: %s (%d WAL segments)\n", result.RestoredTo.Format(time.RFC3339), result.Segments)
+	if keptAs != "" {
+		fmt.Printf("💾 Previous database kept as %s\n", keptAs)
+	}
+	return nil
+}
+
+func printReplicationHelp() {
+	fmt.Println(`Show SQLite replication status or restore from the replica
+
+Usage:
+  casgists replication <command> [options]
+
+Commands:
+  status      List the generations in the replica
+  restore     Rebuild the database from the replica
+
+Restore options:
+  --to PATH              Database file to write (default: database.path)
+  --timestamp RFC3339    Restore the database as it was at this time
+  --generation NAME      Restore a specific generation
+  --force                Replace an existing database, keeping it aside
+
+Stop the server before restoring over its database.`)
+}
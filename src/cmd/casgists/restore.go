@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"github.com/casapps/casgists/src/internal/backup"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/spf13/cobra"
+)
+
+// restoreOptions are the flags of the restore command
+type restoreOptions struct {
+	encryptionKey     string
+	encryptionKeyFile string
+	validateOnly      bool
+	noSnapshot        bool
+	overwrite         bool
+	force             bool
+}
+
+func newBackupRestoreCommand() *cobra.Command {
+	opts := &restoreOptions{}
+	cmd := &cobra.Command{
+		Use:   "restore FILE|BACKUP_ID",
+		Short: "Validate and restore a backup, after a safety snapshot",
+		Long: `Restore a backup taken by scheduled backups, the backup API or
+"casgists backup run".
+
+The backup is read through first, so a damaged file or a wrong key is
+found before anything is changed. Then a snapshot of the current data is
+saved next to the other backups as casgists-backup-snapshot-*.tar.gz, and
+the backup is restored with the server in read-only mode. To go back,
+restore the snapshot.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRestore(args[0], opts)
+		},
+	}
+	cmd.Flags().BoolVar(&opts.validateOnly, "validate-only", false, "check the backup without restoring it")
+	cmd.Flags().BoolVar(&opts.noSnapshot, "no-snapshot", false, "don't take a snapshot before restoring")
+	cmd.Flags().BoolVar(&opts.overwrite, "overwrite", false, "replace users and organizations that exist")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "restore a backup taken on another database type")
+	cmd.Flags().StringVar(&opts.encryptionKey, "encryption-key", "", "key of an encrypted backup, instead of the configured backup.encryption_key")
+	cmd.Flags().StringVar(&opts.encryptionKeyFile, "encryption-key-file", "", "read the key from the first line of this file")
+	return cmd
+}
+
+// newRestoreCommand keeps "casgists restore" working
+func newRestoreCommand() *cobra.Command {
+	cmd := newBackupRestoreCommand()
+	cmd.Hidden = true
+	cmd.Deprecated = `use "casgists backup restore"`
+	return cmd
+}
+
+// runRestore restores a backup taken by the backup scheduler or the backup
+// API, given as a file or a backup ID
+func runRestore(ref string, opts *restoreOptions) error {
+	key := opts.encryptionKey
+	if opts.encryptionKeyFile != "" {
+		content, err := os.ReadFile(opts.encryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read encryption key file: %w", err)
+		}
+		key, _, _ = strings.Cut(string(content), "\n")
+		key = strings.TrimSpace(key)
+	}
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+	manager := backup.NewManager(db, cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	backupPath := ref
+	if _, err := os.Stat(backupPath); err != nil {
+		located, cleanup, err := manager.Locate(ctx, backupPath)
+		if err != nil {
+			return fmt.Errorf("%s is neither a file nor a backup ID: %w", backupPath, err)
+		}
+		defer cleanup()
+		backupPath = located
+	}
+
+	fmt.Printf("🔍 Validating %s...\n", backupPath)
+	verification, err := manager.VerifyBackup(backupPath, key)
+	if err != nil {
+		fmt.Printf("❌ Not a usable backup: %v\n", err)
+		return err
+	}
+	printVerification(verification)
+	if !verification.Compatible && !opts.force {
+		return fmt.Errorf("backup can't be restored here: %s (pass --force to restore it anyway)", verification.Problem)
+	}
+	if opts.validateOnly {
+		fmt.Println("✅ Backup is valid, nothing was changed")
+		return nil
+	}
+	if readOnly, _ := models.GetReadOnlyMode(db); !readOnly {
+		fmt.Println("⚠️  The server is put in read-only mode while the restore runs")
+	}
+
+	lastPhase := ""
+	result, err := manager.Restore(ctx, backup.RestoreOptions{
+		BackupPath:        backupPath,
+		OverwriteExisting: opts.overwrite,
+		SkipValidation:    opts.force,
+		Snapshot:          !opts.noSnapshot,
+		EncryptionKey:     key,
+	}, func(status backup.RestoreStatus) {
+		if status.Phase != lastPhase {
+			switch status.Phase {
+			case backup.PhaseSnapshot:
+				fmt.Println("📸 Taking a pre-restore snapshot...")
+			case backup.PhaseRestoring:
+				if status.Snapshot != "" {
+					fmt.Printf("💾 Snapshot saved as %s\n", status.Snapshot)
+				}
+				fmt.Println("📥 Restoring...")
+			case backup.PhaseDone, backup.PhaseFailed:
+				fmt.Println()
+			}
+			lastPhase = status.Phase
+		}
+		if status.Phase == backup.PhaseRestoring && status.Total > 0 {
+			fmt.Printf("\r   %d/%d entries", status.Processed, status.Total)
+		}
+	})
+	if err != nil {
+		fmt.Printf("❌ Restore failed: %v\n", err)
+		return err
+	}
+
+	fmt.Printf("👤 Users: %d, 📝 gists: %d, 🏢 organizations: %d, 🔗 webhooks: %d, 📁 files: %d\n",
+		result.RestoredUsers, result.RestoredGists, result.RestoredOrgs, result.RestoredWebhooks, result.RestoredFiles)
+	for _, message := range result.Errors {
+		fmt.Printf("   ⚠️  %s\n", message)
+	}
+
+	status := manager.RestoreStatus()
+	entry := models.AuditLog{
+		Action:       "admin_cli.restore",
+		ResourceType: "backup",
+		ResourceID:   verification.Filename,
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"backup_id": verification.Metadata.ID,
+		"snapshot":  status.Snapshot,
+		"overwrite": opts.overwrite,
+		"success":   result.Success,
+	}); err != nil {
+		return err
+	}
+
+	if !result.Success {
+		return fmt.Errorf("restore finished with %d errors", len(result.Errors))
+	}
+	fmt.Println("✅ Restore complete, rebuild the search index with 'casgists search reindex' and restart the server")
+	return nil
+}
+
+func printVerification(verification *backup.Verification) {
+	metadata := verification.Metadata
+	backupType := metadata.Type
+	if backupType == "" {
+		backupType = backup.TypeFull
+	}
+	fmt.Printf("📦 %s backup from CasGists %s (%s), created %s\n",
+		backupType, metadata.CasGistVersion, metadata.DatabaseType, metadata.CreatedAt.Format("2006-01-02 15:04"))
+	fmt.Printf("   %d users, %d gists, %d entries, %d bytes\n",
+		metadata.TotalUsers, metadata.TotalGists, verification.Entries, verification.Size)
+	if verification.Encrypted {
+		fmt.Println("   🔒 Encrypted")
+	}
+	if !verification.Compatible {
+		fmt.Printf("   ⚠️  %s\n", verification.Problem)
+	}
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/spf13/cobra"
+)
+
+// Command groups, in the order "casgists --help" lists them
+const (
+	groupServer = "server"
+	groupAdmin  = "admin"
+	groupClient = "client"
+)
+
+// annotationStdout marks commands that keep stdout for their output, such
+// as JSON or an archive, so logging isn't set up for them
+const annotationStdout = "stdout"
+
+func newRootCommand() *cobra.Command {
+	serve := &serveOptions{}
+	root := &cobra.Command{
+		Use:   "casgists",
+		Short: "Self-hosted Git snippet manager",
+		Long: `CasGists - Self-hosted Git snippet manager
+
+Without a command the server is started, as with "casgists serve".`,
+		Example: `  casgists                            Start the server
+  sudo casgists install               Install as a system service
+  casgists setup                      Run the setup wizard
+  casgists doctor                     Check the configuration
+  casgists admin user reset-password alice
+  casgists gist create notes.md --title Notes`,
+		Version:       Version,
+		Args:          cobra.NoArgs,
+		SilenceErrors: true,
+		PersistentPreRun: func(cmd *cobra.Command, args []string) {
+			// Errors from here on come from running the command, not from
+			// how it was called
+			cmd.SilenceUsage = true
+			container, _ := cmd.Flags().GetBool("container")
+			containerMode = container || config.InContainer()
+			if !reservesStdout(cmd, args) {
+				setupLogging()
+			}
+		},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(serve)
+		},
+	}
+	root.SetVersionTemplate("CasGists v{{.Version}}\n")
+	root.PersistentFlags().Bool("container", false, "run in container mode, which is detected in Docker, Podman and Kubernetes (CASGISTS_CONTAINER)")
+	addServeFlags(root, serve)
+	root.SetHelpCommandGroupID(groupServer)
+	root.SetCompletionCommandGroupID(groupServer)
+
+	root.AddGroup(
+		&cobra.Group{ID: groupServer, Title: "Server Commands:"},
+		&cobra.Group{ID: groupAdmin, Title: "Administration Commands:"},
+		&cobra.Group{ID: groupClient, Title: "Client Commands:"},
+	)
+	addCommands(root, groupServer,
+		newServeCommand(),
+		newInstallCommand(),
+		newUninstallCommand(),
+		newSetupCommand(),
+		newDoctorCommand(),
+		newStatusCommand(),
+		newVerifyInstallCommand(),
+		newUpgradeCommand(),
+	)
+	addCommands(root, groupAdmin,
+		newAdminCommand(),
+		newBackupCommand(),
+		newRestoreCommand(),
+		newMigrateCommand(),
+		newDBCommand(),
+		newSearchCommand(),
+		newStorageCommand(),
+		newReplicationCommand(),
+		newConfigCommand(),
+		newExportCommand(),
+		newImportCommand(),
+	)
+	addCommands(root, groupClient,
+		newGistCommand(),
+	)
+	return root
+}
+
+func addCommands(root *cobra.Command, group string, commands ...*cobra.Command) {
+	for _, cmd := range commands {
+		cmd.GroupID = group
+		root.AddCommand(cmd)
+	}
+}
+
+// reservesStdout reports whether a command writes its result to stdout, so
+// log lines must not end up there
+func reservesStdout(cmd *cobra.Command, args []string) bool {
+	for c := cmd; c != nil; c = c.Parent() {
+		if c.Annotations[annotationStdout] != "" {
+			return true
+		}
+	}
+	if flag := cmd.Flags().Lookup("json"); flag != nil && flag.Value.String() == "true" {
+		return true
+	}
+	// "config export" writes the bundle to stdout
+	if cmd.Name() == "config" && len(args) > 0 && args[0] == "export" {
+		return true
+	}
+	// Commands that parse their own arguments
+	return cmd.DisableFlagParsing && containsArg(args, "--json")
+}
+
+// newPassthroughCommand wraps a command that parses its own arguments and
+// prints its own help
+func newPassthroughCommand(use, short string, run func([]string) error) *cobra.Command {
+	return &cobra.Command{
+		Use:                use,
+		Short:              short,
+		DisableFlagParsing: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return run(args)
+		},
+	}
+}
+
+func newSearchCommand() *cobra.Command {
+	return newPassthroughCommand("search", "Rebuild the search index or show its health", handleSearchCommand)
+}
+
+func newStorageCommand() *cobra.Command {
+	return newPassthroughCommand("storage", "Check or migrate git repository storage, toggle read-only mode", handleStorageCommand)
+}
+
+func newReplicationCommand() *cobra.Command {
+	return newPassthroughCommand("replication", "Show SQLite replication status or restore from the replica", handleReplicationCommand)
+}
+
+func newConfigCommand() *cobra.Command {
+	return newPassthroughCommand("config", "Export or import instance configuration bundles", handleConfigCommand)
+}
+
+func newExportCommand() *cobra.Command {
+	cmd := newPassthroughCommand("export", "Export the whole instance, or one user's gists, to an archive", handleExportCommand)
+	cmd.Annotations = map[string]string{annotationStdout: "archive"}
+	return cmd
+}
+
+func newImportCommand() *cobra.Command {
+	return newPassthroughCommand("import", "Import an archive written by export", handleImportCommand)
+}
+
+func newVerifyInstallCommand() *cobra.Command {
+	return newPassthroughCommand("verify-install", "Self-test the installation and print a PASS/FAIL report", handleVerifyInstallCommand)
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/search"
+)
+
+// handleSearchCommand handles the search maintenance commands
+func handleSearchCommand(args []string) error {
+	if len(args) == 0 {
+		printSearchHelp()
+		return nil
+	}
+
+	switch args[0] {
+	case "reindex":
+		return runSearchReindex()
+	case "status":
+		return runSearchStatus()
+	case "--help", "-h", "help":
+		printSearchHelp()
+		return nil
+	default:
+		printSearchHelp()
+		return fmt.Errorf("unknown search command: %s", args[0])
+	}
+}
+
+// runSearchReindex rebuilds the search index, printing progress as it goes
+func runSearchReindex() error {
+	manager, cfg, closeDB, err := openSearchManager()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	// Stop cleanly on Ctrl+C; the index can be rebuilt again later
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("🔍 Rebuilding search index...")
+	started := time.Now()
+	err = manager.Reindex(ctx, func(status search.ReindexStatus) {
+		fmt.Printf("\r   %d/%d gists indexed (%.0f%%), %d failed", status.Indexed, status.Total, status.Percent(), status.Failed)
+	})
+	fmt.Println()
+	if err != nil {
+		fmt.Printf("❌ Reindex failed: %v\n", err)
+		return err
+	}
+
+	status := manager.ReindexStatus()
+	fmt.Printf("✅ Indexed %d gists in %s\n", status.Indexed, time.Since(started).Round(time.Millisecond))
+	return printIndexHealth(manager, cfg)
+}
+
+// runSearchStatus prints how far the search index has drifted from the
+// database
+func runSearchStatus() error {
+	manager, cfg, closeDB, err := openSearchManager()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return printIndexHealth(manager, cfg)
+}
+
+func printIndexHealth(manager *search.Manager, cfg *config.Config) error {
+	health, err := manager.Health(context.Background(), cfg.GetDuration("search.index.max_lag"))
+	if err != nil {
+		return err
+	}
+
+	icon := "🟢"
+	if health.Status != search.IndexHealthy {
+		icon = "🟡"
+	}
+	fmt.Printf("%s Search index: %s (%s)\n", icon, health.Status, health.Provider)
+	fmt.Printf("📄 Documents: %d indexed, %d gists in database\n", health.IndexedDocuments, health.DatabaseGists)
+	if health.LastIndexedAt != nil {
+		fmt.Printf("⏱️  Last indexed: %s (lag %ds)\n", health.LastIndexedAt.Format(time.RFC3339), health.LagSeconds)
+	}
+	for _, problem := range health.Problems {
+		fmt.Printf("⚠️  %s\n", problem)
+	}
+	return nil
+}
+
+// openSearchManager opens the database and returns a search manager for it
+func openSearchManager() (*search.Manager, *config.Config, func(), error) {
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	backend, backendConfig := search.BackendFromViper(cfg)
+	manager, err := search.NewManager(db, backend, backendConfig)
+	if err != nil {
+		closeDB()
+		return nil, nil, nil, err
+	}
+
+	return manager, cfg, func() {
+		manager.Close()
+		closeDB()
+	}, nil
+}
+
+func printSearchHelp() {
+	fmt.Println(`Maintain the search index
+
+Usage:
+  casgists search <command>
+
+Commands:
+  reindex     Rebuild the search index from the database
+  status      Compare the search index with the database
+
+A running server can also be asked to reindex in the background with
+POST /api/v1/admin/search/reindex. With search.backend: bleve the server
+holds the index, so use the endpoint while it runs.`)
+}
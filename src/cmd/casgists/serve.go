@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/logging"
+	"github.com/casapps/casgists/src/internal/privileges"
+	"github.com/casapps/casgists/src/internal/server"
+	"github.com/labstack/echo/v4"
+	"github.com/spf13/cobra"
+)
+
+// serveOptions are the flags of the serve command, which the root command
+// shares
+type serveOptions struct {
+	port int
+}
+
+func newServeCommand() *cobra.Command {
+	opts := &serveOptions{}
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Start the server (the default command)",
+		Long: `Start the server in the foreground.
+
+SIGHUP reloads the config file. SIGINT or SIGTERM shuts the server down
+gracefully, waiting up to server.shutdown_timeout for requests and
+background jobs; a second signal exits at once. Run as a Windows service,
+stopping the service shuts it down the same way.
+
+In container mode, which --container, CASGISTS_CONTAINER=true or a detected
+Docker, Podman or Kubernetes container turns on, everything is stored on
+the /data volume (CASGISTS_DATA_DIR), logs go to stdout only, the
+configuration comes from CASGISTS_* variables and privileges are left as
+the container runtime set them.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(opts)
+		},
+	}
+	addServeFlags(cmd, opts)
+	return cmd
+}
+
+func addServeFlags(cmd *cobra.Command, opts *serveOptions) {
+	cmd.Flags().IntVar(&opts.port, "port", 0, "port to listen on, instead of server.port")
+
+	// Service definitions written by earlier installers pass these
+	cmd.Flags().String("config", "", "")
+	cmd.Flags().MarkDeprecated("config", "the config file next to the database is read, set CASGISTS_DATA_DIR to move it")
+	cmd.Flags().Bool("service", false, "")
+	cmd.Flags().MarkHidden("service")
+}
+
+// runServe starts the server and blocks until it is shut down
+func runServe(opts *serveOptions) error {
+	if containerMode {
+		// The container runs as whichever user the image chose
+		slog.Info("Running in container mode")
+		warnWithoutInit()
+	} else {
+		// Check if this requires privilege escalation; "casgists serve" is
+		// the same as a bare "casgists"
+		args := os.Args[1:]
+		if len(args) > 0 && args[0] == "serve" {
+			args = args[1:]
+		}
+		if privileges.RequiresElevation(args) {
+			result := privileges.EscalatePrivileges()
+			if !result.Success && !result.AlreadyElevated {
+				slog.Warn("Failed to escalate privileges", "error", result.Error)
+				slog.Warn("Running in user mode with limited functionality")
+			}
+		}
+	}
+
+	// Initialize path configuration
+	pathConfig := newPathConfig()
+	if err := pathConfig.ResolveAll(); err != nil {
+		return fmt.Errorf("failed to resolve paths: %w", err)
+	}
+
+	// Create necessary directories
+	if err := pathConfig.CreateDirectories(); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	// Validate paths
+	if err := pathConfig.ValidatePaths(); err != nil {
+		return fmt.Errorf("path validation failed: %w", err)
+	}
+
+	// Initialize main configuration with resolved paths
+	cfg, err := config.LoadWithPaths(pathConfig)
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %w", err)
+	}
+	cfg.Set("version", Version)
+	if opts.port != 0 {
+		cfg.Set("server.port", opts.port)
+	}
+
+	// Log as configured from here on
+	if logCloser != nil {
+		logCloser.Close()
+	}
+	logCloser, err = logging.Setup(logging.ConfigFromViper(cfg, pathConfig.GetLogDir()))
+	if err != nil {
+		slog.Warn("Logging to the console only", "error", err)
+	}
+	defer logCloser.Close()
+
+	// Initialize database
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		return fmt.Errorf("failed to initialize database: %w", err)
+	}
+
+	// Get the underlying SQL DB for closing
+	sqlDB, err := db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	defer sqlDB.Close()
+
+	// Run migrations, after saving the database when an upgrade brought new
+	// ones
+	if err := backupBeforeMigrations(db, cfg); err != nil {
+		return err
+	}
+	if err := database.MigrateDB(db); err != nil {
+		return fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	// A container has no setup step that writes a config file
+	if containerMode {
+		if err := keepSecretKey(db, cfg, pathConfig); err != nil {
+			return err
+		}
+	}
+
+	// Create Echo instance
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	e.Logger = logging.NewEchoLogger(slog.Default())
+
+	// Note: Static files are served from disk in development
+	// In production, they would be embedded in the binary
+
+	// Initialize server with path config
+	srv := server.NewWithPaths(e, cfg, db, pathConfig)
+
+	// Get configured port - check environment first
+	port := cfg.GetInt("server.port")
+	if port == 0 {
+		// No port specified, use port manager to select one
+		portManager := server.NewPortManager(db)
+		port, err = portManager.GetConfiguredPort()
+		if err != nil {
+			return fmt.Errorf("failed to get configured port: %w", err)
+		}
+		// Update config with selected port
+		cfg.Set("server.port", port)
+	} else {
+		// Port specified via flag or environment variable, use it directly
+		log.Printf("Using configured port: %d", port)
+	}
+
+	log.Printf("CasGists v%s starting on port %d", Version, port)
+
+	// Set up graceful shutdown
+	go func() {
+		// Shutdown makes Start return ErrServerClosed, which isn't a failure
+		if err := srv.Start(context.Background(), fmt.Sprintf(":%d", port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Server failed", "error", err)
+			os.Exit(1)
+		}
+	}()
+
+	// Reload the config file on SIGHUP
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			result, err := srv.ReloadConfig()
+			if err != nil {
+				slog.Error("Config not reloaded", "error", err)
+				continue
+			}
+			config.LogReload(result)
+		}
+	}()
+
+	// Wait for Ctrl+C, or SIGTERM from systemd, Docker or Kubernetes, to
+	// shut down gracefully. A second signal exits at once.
+	quit := make(chan os.Signal, 2)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
+	// Under the Windows service manager a stop request does the same
+	defer watchServiceManager(quit)()
+	sig := <-quit
+
+	timeout := cfg.GetDuration("server.shutdown_timeout")
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	log.Printf("Received %s, shutting down (waiting up to %s)...", sig, timeout)
+	go func() {
+		<-quit
+		slog.Warn("Received a second signal, exiting without waiting")
+		os.Exit(1)
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	// Keep going on error, so the deferred calls close the database and
+	// flush the logs
+	if err := srv.Shutdown(ctx); err != nil {
+		slog.Error("Shutdown did not complete cleanly", "error", err)
+	} else {
+		log.Println("Shutdown complete")
+	}
+	return nil
+}
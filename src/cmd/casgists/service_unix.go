@@ -0,0 +1,11 @@
+//go:build !windows
+
+package main
+
+import "os"
+
+// watchServiceManager has nothing to connect to outside Windows; systemd
+// and launchd stop the server with SIGTERM
+func watchServiceManager(quit chan<- os.Signal) func() {
+	return func() {}
+}
@@ -0,0 +1,64 @@
+//go:build windows
+
+package main
+
+import (
+	"log/slog"
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/windows/svc"
+)
+
+// serviceHandler reports the server to the Windows service control manager
+// and turns its stop requests into the signal a console stop sends
+type serviceHandler struct {
+	quit    chan<- os.Signal
+	stopped <-chan struct{}
+}
+
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	status <- svc.Status{State: svc.Running, Accepts: svc.AcceptStop | svc.AcceptShutdown}
+	for {
+		select {
+		case request := <-requests:
+			switch request.Cmd {
+			case svc.Interrogate:
+				status <- request.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				status <- svc.Status{State: svc.StopPending}
+				h.quit <- syscall.SIGTERM
+				<-h.stopped
+				return false, 0
+			}
+		case <-h.stopped:
+			// The server stopped on its own
+			return false, 0
+		}
+	}
+}
+
+// watchServiceManager connects to the service control manager when the
+// process was started as a Windows service, which must happen within 30
+// seconds of starting. Stop requests are sent to quit; the returned func
+// reports the service stopped once the server has shut down.
+func watchServiceManager(quit chan<- os.Signal) func() {
+	isService, err := svc.IsWindowsService()
+	if err != nil || !isService {
+		return func() {}
+	}
+
+	stopped := make(chan struct{})
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		// The name is ignored for a service in its own process
+		if err := svc.Run("casgists", &serviceHandler{quit: quit, stopped: stopped}); err != nil {
+			slog.Error("Service control manager failed", "error", err)
+		}
+	}()
+	return func() {
+		close(stopped)
+		<-finished
+	}
+}
@@ -11,20 +11,37 @@ import (
 	"strings"
 	"syscall"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/installer"
+	"github.com/spf13/cobra"
 	"golang.org/x/term"
 )
 
-// handleSetupCommand handles the setup command
-func handleSetupCommand(args []string) error {
-	// Check for help flag
-	for _, arg := range args {
-		if arg == "--help" || arg == "-h" {
-			printSetupHelp()
-			return nil
-		}
+func newSetupCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "setup",
+		Short: "Run the interactive setup wizard",
+		Long: `Interactive setup wizard for CasGists
+
+This wizard will help you:
+- Configure basic settings (port, domain)
+- Set up database connection
+- Configure authentication
+- Set up email (optional)
+- Create admin user
+
+Run this after installation or to reconfigure an existing instance.`,
+		Example: `  casgists setup                 Run setup wizard
+  sudo casgists setup            Run setup wizard with system installation`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSetup()
+		},
 	}
+}
 
+// runSetup walks through the setup questions
+func runSetup() error {
 	fmt.Println("🚀 CasGists Setup Wizard")
 	fmt.Println("========================")
 	fmt.Println()
@@ -67,6 +84,10 @@ func handleSetupCommand(args []string) error {
 }
 
 func runSystemInstallation(reader *bufio.Reader) error {
+	if containerMode {
+		return errContainerMode("system installation")
+	}
+
 	fmt.Println("\n📦 System Installation")
 	fmt.Println("======================")
 
@@ -97,27 +118,28 @@ func runSystemInstallation(reader *bufio.Reader) error {
 	}
 
 	// Installation path
-	fmt.Print("Installation path [/opt/casgists]: ")
+	defaults := installer.DefaultConfig()
+	fmt.Printf("Installation path [%s]: ", defaults.InstallPath)
 	installPath, _ := reader.ReadString('\n')
 	installPath = strings.TrimSpace(installPath)
 	if installPath == "" {
-		installPath = "/opt/casgists"
+		installPath = defaults.InstallPath
 	}
 
 	// Data directory
-	fmt.Print("Data directory [/var/lib/casgists]: ")
+	fmt.Printf("Data directory [%s]: ", defaults.DataDir)
 	dataDir, _ := reader.ReadString('\n')
 	dataDir = strings.TrimSpace(dataDir)
 	if dataDir == "" {
-		dataDir = "/var/lib/casgists"
+		dataDir = defaults.DataDir
 	}
 
 	// Config path
-	fmt.Print("Config file [/etc/casgists/config.yaml]: ")
+	fmt.Printf("Config file [%s]: ", defaults.ConfigPath)
 	configPath, _ := reader.ReadString('\n')
 	configPath = strings.TrimSpace(configPath)
 	if configPath == "" {
-		configPath = "/etc/casgists/config.yaml"
+		configPath = defaults.ConfigPath
 	}
 
 	// Confirmation
@@ -228,6 +250,11 @@ func runLocalSetup(reader *bufio.Reader) error {
 		}
 	}
 
+	secretKey, err := config.GenerateSecretKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate secret key: %w", err)
+	}
+
 	// Generate basic config
 	configContent := fmt.Sprintf(`# CasGists Configuration
 server:
@@ -256,7 +283,7 @@ logging:
 features:
   registration: true
   anonymous_gists: true
-`, port, port, generateSecretKey())
+`, port, port, secretKey)
 
 	if err := os.WriteFile(configPath, []byte(configContent), 0644); err != nil {
 		return fmt.Errorf("failed to create config file: %w", err)
@@ -341,12 +368,15 @@ func configureEmail(reader *bufio.Reader) string {
 
 	return fmt.Sprintf(`
 email:
-  smtp_host: %s
-  smtp_port: %s
-  smtp_username: %s
-  smtp_password: %s
-  from_email: %s
-  from_name: CasGists
+  enabled: true
+  smtp:
+    host: %s
+    port: %s
+    username: %s
+    password: %s
+  from:
+    address: %s
+    name: CasGists
 `, host, portStr, username, password, fromEmail)
 }
 
@@ -383,35 +413,3 @@ Password: %s
 
 	return nil
 }
-
-func generateSecretKey() string {
-	const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
-	b := make([]byte, 64)
-	for i := range b {
-		b[i] = charset[int(b[i])%len(charset)]
-	}
-	return string(b)
-}
-
-func printSetupHelp() {
-	fmt.Println(`Interactive setup wizard for CasGists
-
-Usage:
-  casgists setup [options]
-
-Options:
-  -h, --help            Show this help message
-
-This wizard will help you:
-- Configure basic settings (port, domain)
-- Set up database connection
-- Configure authentication
-- Set up email (optional)
-- Create admin user
-
-Run this after installation or to reconfigure an existing instance.
-
-Examples:
-  casgists setup                 Run setup wizard
-  sudo casgists setup            Run setup wizard with system installation`)
-}
\ No newline at end of file
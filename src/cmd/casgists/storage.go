@@ -0,0 +1,249 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+// handleStorageCommand handles the git repository storage commands
+func handleStorageCommand(args []string) error {
+	if len(args) == 0 {
+		printStorageHelp()
+		return nil
+	}
+
+	switch args[0] {
+	case "status":
+		return runStorageStatus()
+	case "read-only":
+		return runStorageReadOnly(args[1:])
+	case "migrate":
+		return runStorageMigrate(args[1:])
+	case "--help", "-h", "help":
+		printStorageHelp()
+		return nil
+	default:
+		printStorageHelp()
+		return fmt.Errorf("unknown storage command: %s", args[0])
+	}
+}
+
+// runStorageStatus checks the configured driver the same way /healthz does
+func runStorageStatus() error {
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	storage, err := git.NewStorageDriver(cfg)
+	if err != nil {
+		return err
+	}
+
+	health := storage.Health(context.Background())
+	fmt.Printf("📦 Driver: %s (%s)\n", health.Driver, health.Target)
+	if health.Healthy {
+		fmt.Printf("✅ Healthy, %.1fms round trip\n", health.LatencyMs)
+	} else {
+		fmt.Printf("❌ Unhealthy: %s\n", health.Error)
+	}
+	if health.AvailableBytes > 0 {
+		fmt.Printf("💾 Available: %d MB\n", health.AvailableBytes/1024/1024)
+	}
+
+	ids, err := storage.List()
+	if err != nil {
+		return err
+	}
+	fmt.Printf("📁 Repositories: %d\n", len(ids))
+
+	if readOnly, message := models.GetReadOnlyMode(db); readOnly {
+		fmt.Printf("🔒 Read-only mode: on (%s)\n", message)
+	} else {
+		fmt.Println("🔓 Read-only mode: off")
+	}
+
+	if !health.Healthy {
+		return fmt.Errorf("storage driver %s is unhealthy", health.Driver)
+	}
+	return nil
+}
+
+// runStorageReadOnly turns read-only mode on or off
+func runStorageReadOnly(args []string) error {
+	message, args := extractFlag(args, "--message")
+	if len(args) != 1 || (args[0] != "on" && args[0] != "off") {
+		return fmt.Errorf("usage: casgists storage read-only on|off [--message TEXT]")
+	}
+
+	db, _, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	return setReadOnly(db, args[0] == "on", message)
+}
+
+func setReadOnly(db *gorm.DB, enabled bool, message string) error {
+	if err := models.SetReadOnlyMode(db, enabled, message); err != nil {
+		return err
+	}
+
+	entry := models.AuditLog{
+		Action:       "admin_cli.read_only",
+		ResourceType: "system_config",
+		ResourceID:   models.ConfigKeyReadOnlyMode,
+	}
+	if err := writeAuditEntry(db, entry, map[string]interface{}{
+		"enabled": enabled,
+		"message": message,
+	}); err != nil {
+		return err
+	}
+
+	if enabled {
+		fmt.Println("🔒 Read-only mode is on, the server rejects writes within a few seconds")
+	} else {
+		fmt.Println("🔓 Read-only mode is off")
+	}
+	return nil
+}
+
+// runStorageMigrate copies every repository to another driver while the
+// instance is read-only
+func runStorageMigrate(args []string) error {
+	deleteSource := containsArg(args, "--delete-source")
+	var positional []string
+	for _, arg := range args {
+		if !strings.HasPrefix(arg, "--") {
+			positional = append(positional, arg)
+		}
+	}
+	if len(positional) == 0 {
+		return fmt.Errorf("usage: casgists storage migrate DRIVER [key=value...] [--delete-source]")
+	}
+	driver := positional[0]
+
+	db, cfg, closeDB, err := openDatabase()
+	if err != nil {
+		return err
+	}
+	defer closeDB()
+
+	from, err := git.NewStorageDriver(cfg)
+	if err != nil {
+		return err
+	}
+
+	// Settings on the command line describe the target driver
+	settings := map[string]string{}
+	for _, setting := range positional[1:] {
+		key, value, ok := strings.Cut(setting, "=")
+		if !ok || key == "" {
+			return fmt.Errorf("invalid driver setting %q, expected key=value", setting)
+		}
+		settings[key] = value
+		cfg.Set("git.storage."+driver+"."+key, value)
+	}
+	to, err := git.OpenStorageDriver(driver, cfg)
+	if err != nil {
+		return err
+	}
+	if from.Name() == to.Name() && from.Describe() == to.Describe() {
+		return fmt.Errorf("source and target are both %s %s", to.Name(), to.Describe())
+	}
+
+	if health := to.Health(context.Background()); !health.Healthy {
+		return fmt.Errorf("target storage is unhealthy: %s", health.Error)
+	}
+
+	if readOnly, _ := models.GetReadOnlyMode(db); !readOnly {
+		if err := setReadOnly(db, true, "Repository storage is being migrated"); err != nil {
+			return err
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Printf("🚚 Migrating repositories from %s (%s) to %s (%s)\n", from.Name(), from.Describe(), to.Name(), to.Describe())
+	result, err := git.MigrateRepositories(ctx, from, to, git.MigrateOptions{DeleteSource: deleteSource}, func(p git.MigrateProgress) {
+		if p.Current != "" {
+			fmt.Printf("\r   %d/%d %s", p.Done+1, p.Total, p.Current)
+		}
+	})
+	fmt.Println()
+	if result != nil {
+		fmt.Printf("📊 %d copied, %d already present, %d failed (%d MB)\n", result.Copied, result.Skipped, result.Failed, result.Bytes/1024/1024)
+	}
+
+	details := map[string]interface{}{
+		"from":          from.Name() + ":" + from.Describe(),
+		"to":            to.Name() + ":" + to.Describe(),
+		"delete_source": deleteSource,
+	}
+	if result != nil {
+		details["copied"] = result.Copied
+		details["skipped"] = result.Skipped
+		details["failed"] = result.Failed
+	}
+	entry := models.AuditLog{
+		Action:       "admin_cli.storage_migrate",
+		ResourceType: "system_config",
+		ResourceID:   "git.storage.driver",
+	}
+	if auditErr := writeAuditEntry(db, entry, details); auditErr != nil {
+		fmt.Printf("⚠️  Failed to record the migration in the audit log: %v\n", auditErr)
+	}
+
+	if err != nil {
+		fmt.Println("❌ Migration incomplete, the instance stays read-only. Run the same command again to resume.")
+		return err
+	}
+
+	fmt.Println("✅ All repositories copied and verified")
+	fmt.Println("\nTo finish, set the new driver in the configuration:")
+	fmt.Println("\n  git:\n    storage:")
+	fmt.Printf("      driver: %s\n", driver)
+	if len(settings) > 0 {
+		fmt.Printf("      %s:\n", driver)
+		for key, value := range settings {
+			fmt.Printf("        %s: %s\n", key, value)
+		}
+	}
+	fmt.Println("\nthen restart the server and run: casgists storage read-only off")
+	return nil
+}
+
+func printStorageHelp() {
+	fmt.Printf(`Manage where gist git repositories are stored
+
+Usage:
+  casgists storage <command> [options]
+
+Commands:
+  status                          Check the configured driver and read-only mode
+  read-only on|off [--message M]  Reject or accept writes on the running server
+  migrate DRIVER [key=value...]   Copy all repositories to another driver
+
+Migrate options:
+  key=value          Target driver settings, e.g. path=/mnt/nfs/repos
+  --delete-source    Remove each repository from the old driver once verified
+
+Drivers: %s
+
+Migrating turns read-only mode on and leaves it on, so the server can be
+switched to the new driver before writes resume.
+`, strings.Join(git.StorageDriverNames(), ", "))
+}
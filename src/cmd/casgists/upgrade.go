@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/update"
+	"github.com/spf13/cobra"
+	"gorm.io/gorm"
+)
+
+// upgradeOptions are the flags of the upgrade command
+type upgradeOptions struct {
+	check   bool
+	version string
+	force   bool
+	yes     bool
+}
+
+func newUpgradeCommand() *cobra.Command {
+	opts := &upgradeOptions{}
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Replace this binary with the latest release",
+		Long: `Check the GitHub releases of CasGists for a newer version, download the
+build for this platform, verify it against the release's SHA256SUMS.txt
+(and its signature, when update.public_key is set) and swap it in for this
+binary. The binary it replaces is kept next to it with an .old suffix.
+
+The running server keeps the old version until it is restarted. On start
+the new version saves the database to the backup directory, unless
+update.backup_before_migrate is off, and then applies its migrations.`,
+		Example: `  casgists upgrade --check
+  sudo casgists upgrade
+  sudo casgists upgrade --version 1.4.2 --yes`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUpgrade(opts)
+		},
+	}
+	cmd.Flags().BoolVar(&opts.check, "check", false, "only report whether an update is available")
+	cmd.Flags().StringVar(&opts.version, "version", "", "install this version instead of the latest")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "install even when not newer, or over a development build")
+	cmd.Flags().BoolVarP(&opts.yes, "yes", "y", false, "don't ask for confirmation")
+	return cmd
+}
+
+// runUpgrade replaces the running binary with a release
+func runUpgrade(opts *upgradeOptions) error {
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	client := update.NewClient(cfg)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Println("🔍 Checking for updates...")
+	var release *update.Release
+	if opts.version != "" {
+		release, err = client.Tagged(ctx, opts.version)
+	} else {
+		release, err = client.Latest(ctx)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to check for updates: %w", err)
+	}
+
+	newer := update.Newer(release.Version, Version)
+	fmt.Printf("📦 Installed: %s, release: %s\n", Version, release.Version)
+	if opts.check {
+		if newer {
+			fmt.Printf("⬆️  CasGists %s is available: %s\n", release.Version, release.URL)
+		} else {
+			fmt.Println("✅ CasGists is up to date")
+		}
+		return nil
+	}
+
+	if containerMode {
+		return fmt.Errorf("the binary in a container is replaced by pulling the new image, such as casapps/casgists:%s", release.Version)
+	}
+	switch {
+	case opts.force:
+	case Version == "dev":
+		return errors.New("this is a development build, which isn't upgraded unless --force is given")
+	case !newer:
+		fmt.Println("✅ CasGists is up to date")
+		return nil
+	}
+
+	target, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find this binary: %w", err)
+	}
+	if target, err = filepath.EvalSymlinks(target); err != nil {
+		return fmt.Errorf("failed to find this binary: %w", err)
+	}
+	if !opts.yes && !confirm(fmt.Sprintf("Replace %s with CasGists %s? [y/N]: ", target, release.Version)) {
+		fmt.Println("Upgrade cancelled.")
+		return nil
+	}
+
+	fmt.Printf("⬇️  Downloading %s...\n", update.AssetName(runtime.GOOS, runtime.GOARCH))
+	binary, err := client.Download(ctx, release)
+	if err != nil {
+		return err
+	}
+	staged, err := update.Stage(target, binary)
+	if err != nil {
+		return err
+	}
+	// A binary that doesn't run on this machine is not swapped in
+	out, err := exec.CommandContext(ctx, staged, "--version").Output()
+	if err != nil {
+		os.Remove(staged)
+		return fmt.Errorf("the downloaded binary doesn't run: %w", err)
+	}
+	fmt.Printf("✅ Verified %s", out)
+
+	old, err := update.Swap(target, staged)
+	if err != nil {
+		os.Remove(staged)
+		return err
+	}
+	log.Printf("Upgraded %s from %s to %s", target, Version, release.Version)
+
+	fmt.Printf("🎉 Upgraded to CasGists %s; the previous binary is kept as %s\n", release.Version, old)
+	fmt.Println("💡 Restart the server to run it, e.g. sudo systemctl restart casgists.")
+	fmt.Println("   Pending database migrations are applied on start, after a backup of the database.")
+	return nil
+}
+
+// confirm asks a yes or no question on the terminal
+func confirm(question string) bool {
+	fmt.Print(question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// backupBeforeMigrations saves the database when this version brings
+// migrations it doesn't have yet
+func backupBeforeMigrations(db *gorm.DB, cfg *config.Config) error {
+	if !cfg.GetBool("update.backup_before_migrate") {
+		return nil
+	}
+	pending, err := database.PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return err
+	}
+
+	log.Printf("%d database migrations to apply, saving the database first", len(pending))
+	return saveDatabase(db, cfg)
+}
+
+// saveDatabase backs the database up before its schema is changed
+func saveDatabase(db *gorm.DB, cfg *config.Config) error {
+	path, err := update.BackupBeforeMigrations(context.Background(), db, cfg)
+	if err != nil {
+		return fmt.Errorf("failed to back up the database before migrating it: %w (set update.backup_before_migrate to false to migrate without a backup)", err)
+	}
+	log.Printf("Saved the database to %s", path)
+	return nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/selftest"
+)
+
+// handleVerifyInstallCommand runs the installation self-test and prints a
+// PASS/FAIL report. It fails when any check fails, so it can gate an
+// upgrade in scripts.
+func handleVerifyInstallCommand(args []string) error {
+	if containsArg(args, "--help") || containsArg(args, "-h") {
+		printVerifyInstallHelp()
+		return nil
+	}
+	jsonOutput := containsArg(args, "--json")
+	opts := selftest.Options{Version: Version, Offline: containsArg(args, "--offline")}
+	if timeout, _ := extractFlag(args, "--timeout"); timeout != "" {
+		d, err := time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("invalid --timeout: %w", err)
+		}
+		opts.Timeout = d
+	}
+
+	if !jsonOutput {
+		fmt.Printf("🔍 Verifying CasGists v%s installation...\n", Version)
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	cfg.Set("version", Version)
+
+	// The throwaway instance logs its requests to stdout, which is reserved
+	// for the report with --json
+	stdout := os.Stdout
+	if jsonOutput {
+		os.Stdout = os.Stderr
+	}
+	report := selftest.Run(context.Background(), cfg, opts)
+	report.Add(checkService())
+	report.Add(checkRunningServer(cfg))
+	os.Stdout = stdout
+
+	if jsonOutput {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(report); err != nil {
+			return err
+		}
+	} else {
+		printVerifyReport(report)
+	}
+
+	if !report.OK() {
+		return fmt.Errorf("%d check(s) failed", report.Failed)
+	}
+	return nil
+}
+
+// checkService checks the systemd service installed by casgists install
+func checkService() selftest.Result {
+	result := selftest.Result{Name: "service", Status: selftest.StatusSkip, Detail: "not installed as a systemd service"}
+	if runtime.GOOS != "linux" {
+		return result
+	}
+	if _, err := os.Stat("/etc/systemd/system/casgists.service"); err != nil {
+		return result
+	}
+
+	output, _ := exec.Command("systemctl", "is-active", "casgists").Output()
+	status := strings.TrimSpace(string(output))
+	if status != "active" {
+		result.Status = selftest.StatusFail
+		result.Detail = fmt.Sprintf("casgists.service is %s", status)
+		return result
+	}
+	result.Status = selftest.StatusPass
+	result.Detail = "casgists.service is active"
+	return result
+}
+
+// checkRunningServer checks that a server on the configured port runs this
+// binary's version, which after an upgrade means it was restarted
+func checkRunningServer(cfg *config.Config) selftest.Result {
+	result := selftest.Result{Name: "running server", Status: selftest.StatusSkip}
+	port := cfg.GetInt("server.port")
+	if port == 0 {
+		result.Detail = "no fixed port configured"
+		return result
+	}
+	health, err := checkServerHealth(port)
+	if err != nil {
+		result.Detail = fmt.Sprintf("no server answering on port %d", port)
+		return result
+	}
+
+	version, _ := health["version"].(string)
+	if version != Version {
+		result.Status = selftest.StatusFail
+		result.Detail = fmt.Sprintf("port %d runs v%s but this binary is v%s, restart the server", port, version, Version)
+		return result
+	}
+	result.Status = selftest.StatusPass
+	result.Detail = fmt.Sprintf("v%s on port %d", version, port)
+	return result
+}
+
+func printVerifyReport(report *selftest.Report) {
+	for _, result := range report.Results {
+		icon := "✅"
+		switch result.Status {
+		case selftest.StatusFail:
+			icon = "❌"
+		case selftest.StatusSkip:
+			icon = "⏭️ "
+		}
+		line := fmt.Sprintf("%s %s %-18s", icon, result.Status, result.Name)
+		if result.Detail != "" {
+			line += " " + result.Detail
+		}
+		fmt.Println(strings.TrimRight(line, " "))
+	}
+
+	if report.OK() {
+		fmt.Printf("\n🎉 PASS: %d passed, %d skipped\n", report.Passed, report.Skipped)
+	} else {
+		fmt.Printf("\n❌ FAIL: %d failed, %d passed, %d skipped\n", report.Failed, report.Passed, report.Skipped)
+	}
+}
+
+func printVerifyInstallHelp() {
+	fmt.Println(`Verify a CasGists installation end to end
+
+Checks the configuration, database and repository storage, boots a
+throwaway instance on the same configuration with a temporary database,
+registers a user, creates a gist, fetches it raw and deletes it, and
+connects to the SMTP server and webhook hosts without sending anything.
+Exits non-zero when any check fails.
+
+Usage:
+  casgists verify-install [options]
+
+Options:
+  --json             Print the report as JSON
+  --offline          Skip the SMTP and webhook connection checks
+  --timeout DURATION Timeout for each connection and request (default: 10s)
+  -h, --help         Show this help message
+
+Examples:
+  casgists verify-install
+  casgists verify-install --json > verify-report.json`)
+}
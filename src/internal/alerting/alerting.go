@@ -0,0 +1,563 @@
+package alerting
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/diskspace"
+)
+
+// Built-in rule names
+const (
+	RuleDBLatency      = "db_latency"
+	RuleFailedWebhooks = "failed_webhooks"
+	RuleDiskUsage      = "disk_usage"
+	RuleErrorRate      = "error_rate"
+)
+
+// ConfigKeyEnabled is the setting that turns the alerting engine on or off.
+// Rule settings live under alerting.rules.<rule>.enabled and
+// alerting.rules.<rule>.threshold. Values saved through the admin settings API
+// take precedence over the config file.
+const ConfigKeyEnabled = "alerting.enabled"
+
+// ErrInvalidSetting is returned when an alerting setting has an unusable value
+var ErrInvalidSetting = errors.New("invalid alerting setting")
+
+// Notifier delivers alert emails to an administrator
+type Notifier interface {
+	SendSystemAlertNotification(userID uuid.UUID, email, username, title, message string) error
+}
+
+// Rule is a threshold on one health metric. The rule fires while the
+// measured value is above the threshold.
+type Rule struct {
+	Name        string  `json:"name"`
+	Title       string  `json:"title"`
+	Description string  `json:"description"`
+	Unit        string  `json:"unit"`
+	Severity    string  `json:"severity"`
+	Enabled     bool    `json:"enabled"`
+	Threshold   float64 `json:"threshold"`
+}
+
+// RuleStatus is a rule together with its most recent evaluation
+type RuleStatus struct {
+	Rule
+	Value       *float64   `json:"value,omitempty"`
+	Error       string     `json:"error,omitempty"`
+	Firing      bool       `json:"firing"`
+	EvaluatedAt *time.Time `json:"evaluated_at,omitempty"`
+}
+
+// definition describes a built-in rule and how to measure it
+type definition struct {
+	name        string
+	title       string
+	description string
+	unit        string
+	severity    string
+	measure     func(e *Engine, ctx context.Context) (float64, error)
+}
+
+var definitions = []definition{
+	{
+		name:        RuleDBLatency,
+		title:       "Database latency",
+		description: "Round-trip time of a trivial database query",
+		unit:        "ms",
+		severity:    models.AlertSeverityWarning,
+		measure:     (*Engine).measureDBLatency,
+	},
+	{
+		name:        RuleFailedWebhooks,
+		title:       "Failed webhook deliveries",
+		description: "Webhook deliveries that failed within the evaluation window",
+		unit:        "count",
+		severity:    models.AlertSeverityWarning,
+		measure:     (*Engine).measureFailedWebhooks,
+	},
+	{
+		name:        RuleDiskUsage,
+		title:       "Disk usage",
+		description: "Used space on the volume holding gist storage",
+		unit:        "percent",
+		severity:    models.AlertSeverityCritical,
+		measure:     (*Engine).measureDiskUsage,
+	},
+	{
+		name:        RuleErrorRate,
+		title:       "Error rate",
+		description: "Share of HTTP responses with a 5xx status within the evaluation window",
+		unit:        "percent",
+		severity:    models.AlertSeverityCritical,
+		measure:     (*Engine).measureErrorRate,
+	},
+}
+
+// reading is the result of the last measurement of a rule
+type reading struct {
+	value float64
+	err   string
+	at    time.Time
+}
+
+// Engine periodically measures health metrics, records alerts when a rule's
+// threshold is crossed and emails administrators when alerts fire or resolve
+type Engine struct {
+	db       *gorm.DB
+	config   *config.Config
+	notifier Notifier
+	requests requestCounter
+
+	mu       sync.Mutex
+	readings map[string]reading
+	stop     chan bool
+}
+
+// NewEngine creates a new alerting engine. The notifier may be nil, in which
+// case alerts are only recorded.
+func NewEngine(db *gorm.DB, config *config.Config, notifier Notifier) *Engine {
+	return &Engine{
+		db:       db,
+		config:   config,
+		notifier: notifier,
+		readings: make(map[string]reading),
+		stop:     make(chan bool, 1),
+	}
+}
+
+// IsEnabled reports whether the engine evaluates rules
+func (e *Engine) IsEnabled() bool {
+	enabled := e.config.GetBool(ConfigKeyEnabled)
+	if value, ok := e.overrides()[ConfigKeyEnabled]; ok {
+		enabled, _ = strconv.ParseBool(value)
+	}
+	return enabled
+}
+
+// Rules returns the built-in rules with their effective settings
+func (e *Engine) Rules() []Rule {
+	overrides := e.overrides()
+
+	rules := make([]Rule, 0, len(definitions))
+	for _, def := range definitions {
+		rule := Rule{
+			Name:        def.name,
+			Title:       def.title,
+			Description: def.description,
+			Unit:        def.unit,
+			Severity:    def.severity,
+			Enabled:     e.config.GetBool(enabledKey(def.name)),
+			Threshold:   e.config.GetFloat64(thresholdKey(def.name)),
+		}
+		if value, ok := overrides[enabledKey(def.name)]; ok {
+			if enabled, err := strconv.ParseBool(value); err == nil {
+				rule.Enabled = enabled
+			}
+		}
+		if value, ok := overrides[thresholdKey(def.name)]; ok {
+			if threshold, err := strconv.ParseFloat(value, 64); err == nil {
+				rule.Threshold = threshold
+			}
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+// ObserveRequest counts an HTTP response towards the error rate
+func (e *Engine) ObserveRequest(status int) {
+	e.requests.observe(time.Now(), status >= 500)
+}
+
+// Evaluate measures every enabled rule once, opening an alert when a
+// threshold is crossed and resolving it once the value is back to normal
+func (e *Engine) Evaluate(ctx context.Context) error {
+	if !e.IsEnabled() {
+		return nil
+	}
+
+	measures := make(map[string]definition, len(definitions))
+	for _, def := range definitions {
+		measures[def.name] = def
+	}
+
+	for _, rule := range e.Rules() {
+		open, err := e.openAlert(rule.Name)
+		if err != nil {
+			return err
+		}
+
+		if !rule.Enabled {
+			// Disabling a rule silently closes its alert
+			if open != nil {
+				e.resolve(open, false)
+			}
+			continue
+		}
+
+		value, err := measures[rule.Name].measure(e, ctx)
+		e.record(rule.Name, value, err)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case value > rule.Threshold && open == nil:
+			e.fire(rule, value)
+		case value > rule.Threshold:
+			e.refire(rule, open, value)
+		case open != nil:
+			open.Value = value
+			e.resolve(open, true)
+		}
+	}
+
+	return nil
+}
+
+// Status returns every rule with its last measurement
+func (e *Engine) Status() ([]RuleStatus, error) {
+	var firing []string
+	if err := e.db.Model(&models.SystemAlert{}).
+		Where("status = ?", models.AlertStatusFiring).
+		Pluck("rule", &firing).Error; err != nil {
+		return nil, err
+	}
+
+	rules := e.Rules()
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	statuses := make([]RuleStatus, 0, len(rules))
+	for _, rule := range rules {
+		status := RuleStatus{Rule: rule}
+		if r, ok := e.readings[rule.Name]; ok {
+			at := r.at
+			status.EvaluatedAt = &at
+			if r.err != "" {
+				status.Error = r.err
+			} else {
+				value := r.value
+				status.Value = &value
+			}
+		}
+		for _, name := range firing {
+			if name == rule.Name {
+				status.Firing = true
+			}
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Recent returns the most recent alerts, firing or resolved
+func (e *Engine) Recent(limit int) ([]models.SystemAlert, error) {
+	var alerts []models.SystemAlert
+	err := e.db.Order("fired_at DESC").Limit(limit).Find(&alerts).Error
+	return alerts, err
+}
+
+// Start runs the evaluation loop until the context is cancelled or Stop is
+// called. Rules are re-read on every tick, so settings changes take effect
+// without a restart.
+func (e *Engine) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if err := e.Evaluate(ctx); err != nil {
+				slog.Error("Alert evaluation failed", "error", err)
+			}
+		}
+	}
+}
+
+// Stop stops the evaluation loop
+func (e *Engine) Stop() {
+	select {
+	case e.stop <- true:
+	default:
+	}
+}
+
+// SettingsDefaults returns the alerting settings with their config file
+// values, keyed as they are in the admin settings API
+func SettingsDefaults(config *config.Config) map[string]interface{} {
+	settings := map[string]interface{}{
+		ConfigKeyEnabled: config.GetBool(ConfigKeyEnabled),
+	}
+	for _, def := range definitions {
+		settings[enabledKey(def.name)] = config.GetBool(enabledKey(def.name))
+		settings[thresholdKey(def.name)] = config.GetFloat64(thresholdKey(def.name))
+	}
+	return settings
+}
+
+// ValidateSetting checks a value saved through the admin settings API.
+// Keys outside the alerting namespace are accepted unchanged.
+func ValidateSetting(key string, value interface{}) error {
+	if !strings.HasPrefix(key, "alerting.") {
+		return nil
+	}
+	raw := fmt.Sprintf("%v", value)
+
+	if key == ConfigKeyEnabled {
+		if _, err := strconv.ParseBool(raw); err != nil {
+			return fmt.Errorf("%w: %s must be true or false", ErrInvalidSetting, key)
+		}
+		return nil
+	}
+
+	for _, def := range definitions {
+		switch key {
+		case enabledKey(def.name):
+			if _, err := strconv.ParseBool(raw); err != nil {
+				return fmt.Errorf("%w: %s must be true or false", ErrInvalidSetting, key)
+			}
+			return nil
+		case thresholdKey(def.name):
+			threshold, err := strconv.ParseFloat(raw, 64)
+			if err != nil || threshold < 0 {
+				return fmt.Errorf("%w: %s must be a non-negative number", ErrInvalidSetting, key)
+			}
+			if def.unit == "percent" && threshold > 100 {
+				return fmt.Errorf("%w: %s must be a percentage between 0 and 100", ErrInvalidSetting, key)
+			}
+			return nil
+		}
+	}
+
+	return fmt.Errorf("%w: unknown key %s", ErrInvalidSetting, key)
+}
+
+// fire opens a new alert and notifies administrators
+func (e *Engine) fire(rule Rule, value float64) {
+	now := time.Now()
+	alert := &models.SystemAlert{
+		Rule:      rule.Name,
+		Severity:  rule.Severity,
+		Status:    models.AlertStatusFiring,
+		Message:   describe(rule, value),
+		Value:     value,
+		Threshold: rule.Threshold,
+		FiredAt:   now,
+	}
+	if err := e.db.Create(alert).Error; err != nil {
+		slog.Error("Failed to record alert", "rule", rule.Name, "error", err)
+		return
+	}
+
+	e.notify(alert, "[FIRING] "+rule.Title)
+}
+
+// refire updates a firing alert and repeats the notification once the
+// repeat interval has passed
+func (e *Engine) refire(rule Rule, alert *models.SystemAlert, value float64) {
+	alert.Value = value
+	alert.Threshold = rule.Threshold
+	alert.Message = describe(rule, value)
+	updates := map[string]interface{}{
+		"value":     alert.Value,
+		"threshold": alert.Threshold,
+		"message":   alert.Message,
+	}
+	if err := e.db.Model(alert).Updates(updates).Error; err != nil {
+		slog.Error("Failed to update alert", "rule", rule.Name, "error", err)
+		return
+	}
+
+	repeat := e.config.GetDuration("alerting.repeat_interval")
+	if repeat > 0 && (alert.NotifiedAt == nil || time.Since(*alert.NotifiedAt) >= repeat) {
+		e.notify(alert, "[FIRING] "+rule.Title)
+	}
+}
+
+// resolve closes an alert, optionally notifying administrators
+func (e *Engine) resolve(alert *models.SystemAlert, notify bool) {
+	now := time.Now()
+	updates := map[string]interface{}{
+		"status":      models.AlertStatusResolved,
+		"resolved_at": now,
+		"value":       alert.Value,
+	}
+	if err := e.db.Model(alert).Updates(updates).Error; err != nil {
+		slog.Error("Failed to resolve alert", "rule", alert.Rule, "error", err)
+		return
+	}
+
+	if notify {
+		title := alert.Rule
+		for _, def := range definitions {
+			if def.name == alert.Rule {
+				title = def.title
+			}
+		}
+		alert.Message = fmt.Sprintf("%s is back below its threshold (was firing since %s).",
+			title, alert.FiredAt.UTC().Format(time.RFC3339))
+		e.notify(alert, "[RESOLVED] "+title)
+	}
+}
+
+// notify emails every active administrator and records when it happened
+func (e *Engine) notify(alert *models.SystemAlert, title string) {
+	if e.notifier == nil {
+		return
+	}
+
+	var admins []models.User
+	if err := e.db.Where("is_admin = ? AND deactivated_at IS NULL", true).Find(&admins).Error; err != nil {
+		slog.Error("Failed to load administrators for alert", "rule", alert.Rule, "error", err)
+		return
+	}
+
+	for _, admin := range admins {
+		if err := e.notifier.SendSystemAlertNotification(admin.ID, admin.Email, admin.Username, title, alert.Message); err != nil {
+			slog.Error("Failed to send alert", "rule", alert.Rule, "user", admin.Username, "error", err)
+		}
+	}
+
+	now := time.Now()
+	alert.NotifiedAt = &now
+	e.db.Model(alert).Update("notified_at", now)
+}
+
+// openAlert returns the rule's firing alert, if any
+func (e *Engine) openAlert(rule string) (*models.SystemAlert, error) {
+	var alerts []models.SystemAlert
+	if err := e.db.Where("rule = ? AND status = ?", rule, models.AlertStatusFiring).
+		Order("fired_at DESC").Limit(1).Find(&alerts).Error; err != nil {
+		return nil, fmt.Errorf("failed to load %s alerts: %w", rule, err)
+	}
+	if len(alerts) == 0 {
+		return nil, nil
+	}
+	return &alerts[0], nil
+}
+
+// record stores the latest measurement of a rule
+func (e *Engine) record(rule string, value float64, err error) {
+	r := reading{value: value, at: time.Now()}
+	if err != nil {
+		r.err = err.Error()
+		slog.Error("Failed to measure alert rule", "rule", rule, "error", err)
+	}
+
+	e.mu.Lock()
+	e.readings[rule] = r
+	e.mu.Unlock()
+}
+
+// overrides returns the alerting settings saved through the admin settings API
+func (e *Engine) overrides() map[string]string {
+	var configs []models.SystemConfig
+	e.db.Where("key LIKE ?", "alerting.%").Find(&configs)
+
+	overrides := make(map[string]string, len(configs))
+	for _, config := range configs {
+		overrides[config.Key] = config.Value
+	}
+	return overrides
+}
+
+// window returns the period failed webhooks and errors are counted over
+func (e *Engine) window() time.Duration {
+	window := e.config.GetDuration("alerting.window")
+	if window <= 0 {
+		window = 5 * time.Minute
+	}
+	return window
+}
+
+// interval returns how often rules are evaluated (default: 1 minute)
+func (e *Engine) interval() time.Duration {
+	interval := e.config.GetDuration("alerting.interval")
+	if interval < 10*time.Second {
+		interval = time.Minute
+	}
+	return interval
+}
+
+func (e *Engine) measureDBLatency(ctx context.Context) (float64, error) {
+	var result int
+	start := time.Now()
+	if err := e.db.WithContext(ctx).Raw("SELECT 1").Scan(&result).Error; err != nil {
+		return 0, err
+	}
+	return float64(time.Since(start).Microseconds()) / 1000, nil
+}
+
+func (e *Engine) measureFailedWebhooks(ctx context.Context) (float64, error) {
+	var count int64
+	err := e.db.WithContext(ctx).Table("webhook_deliveries").
+		Where("success = ? AND created_at >= ?", false, time.Now().Add(-e.window())).
+		Count(&count).Error
+	return float64(count), err
+}
+
+func (e *Engine) measureDiskUsage(ctx context.Context) (float64, error) {
+	path := e.config.GetString("storage.path")
+	if path == "" {
+		return 0, errors.New("storage path is not configured")
+	}
+
+	usage, err := diskspace.Of(path)
+	if err != nil {
+		return 0, err
+	}
+	return usage.UsedPercent(), nil
+}
+
+func (e *Engine) measureErrorRate(ctx context.Context) (float64, error) {
+	total, failed := e.requests.count(time.Now(), e.window())
+	if total == 0 || total < int64(e.config.GetInt("alerting.error_rate_min_requests")) {
+		// Too little traffic for a meaningful rate
+		return 0, nil
+	}
+	return float64(failed) / float64(total) * 100, nil
+}
+
+// describe formats the firing message for a rule
+func describe(rule Rule, value float64) string {
+	return fmt.Sprintf("%s is %s, above the threshold of %s.",
+		rule.Title, formatValue(rule.Unit, value), formatValue(rule.Unit, rule.Threshold))
+}
+
+func formatValue(unit string, value float64) string {
+	switch unit {
+	case "ms":
+		return fmt.Sprintf("%.0fms", value)
+	case "percent":
+		return fmt.Sprintf("%.1f%%", value)
+	default:
+		return strconv.FormatFloat(value, 'f', -1, 64)
+	}
+}
+
+func enabledKey(rule string) string {
+	return "alerting.rules." + rule + ".enabled"
+}
+
+func thresholdKey(rule string) string {
+	return "alerting.rules." + rule + ".threshold"
+}
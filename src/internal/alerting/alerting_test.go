@@ -0,0 +1,144 @@
+package alerting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+type sentAlert struct {
+	username string
+	title    string
+}
+
+type fakeNotifier struct {
+	sent []sentAlert
+}
+
+func (n *fakeNotifier) SendSystemAlertNotification(userID uuid.UUID, email, username, title, message string) error {
+	n.sent = append(n.sent, sentAlert{username: username, title: title})
+	return nil
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.SystemConfig{}, &models.SystemAlert{}))
+	require.NoError(t, db.Exec("CREATE TABLE webhook_deliveries (id TEXT PRIMARY KEY, success BOOLEAN, created_at DATETIME)").Error)
+	return db
+}
+
+func TestEngineFiresAndResolves(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := config.New()
+	cfg.Set("alerting.enabled", true)
+	cfg.Set("alerting.window", "5m")
+	cfg.Set("alerting.repeat_interval", "6h")
+	cfg.Set("alerting.rules.failed_webhooks.enabled", true)
+	cfg.Set("alerting.rules.failed_webhooks.threshold", 2)
+
+	require.NoError(t, db.Create(&models.User{Username: "root", Email: "root@example.com", IsAdmin: true}).Error)
+	require.NoError(t, db.Create(&models.User{Username: "alice", Email: "alice@example.com"}).Error)
+	for i := 0; i < 3; i++ {
+		require.NoError(t, db.Exec("INSERT INTO webhook_deliveries (id, success, created_at) VALUES (?, ?, ?)",
+			uuid.NewString(), false, time.Now()).Error)
+	}
+
+	notifier := &fakeNotifier{}
+	engine := NewEngine(db, cfg, notifier)
+	ctx := context.Background()
+
+	require.NoError(t, engine.Evaluate(ctx))
+	require.Len(t, notifier.sent, 1)
+	assert.Equal(t, sentAlert{username: "root", title: "[FIRING] Failed webhook deliveries"}, notifier.sent[0])
+
+	alerts, err := engine.Recent(10)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, RuleFailedWebhooks, alerts[0].Rule)
+	assert.True(t, alerts[0].IsFiring())
+	assert.Equal(t, float64(3), alerts[0].Value)
+
+	// Still firing: no duplicate alert and no repeated email within the repeat interval
+	require.NoError(t, engine.Evaluate(ctx))
+	assert.Len(t, notifier.sent, 1)
+	alerts, err = engine.Recent(10)
+	require.NoError(t, err)
+	assert.Len(t, alerts, 1)
+
+	statuses, err := engine.Status()
+	require.NoError(t, err)
+	for _, status := range statuses {
+		if status.Name == RuleFailedWebhooks {
+			assert.True(t, status.Firing)
+			require.NotNil(t, status.Value)
+		}
+	}
+
+	// Raising the threshold through the settings API resolves the alert
+	require.NoError(t, models.SetConfigValue(db, "alerting.rules.failed_webhooks.threshold", "10"))
+	require.NoError(t, engine.Evaluate(ctx))
+	require.Len(t, notifier.sent, 2)
+	assert.Equal(t, "[RESOLVED] Failed webhook deliveries", notifier.sent[1].title)
+
+	alerts, err = engine.Recent(10)
+	require.NoError(t, err)
+	require.Len(t, alerts, 1)
+	assert.Equal(t, models.AlertStatusResolved, alerts[0].Status)
+	assert.NotNil(t, alerts[0].ResolvedAt)
+
+	assert.Contains(t, engine.PrometheusRules(), `expr: casgists_alert_value{rule="failed_webhooks"} > 10`)
+}
+
+func TestEngineDisabled(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := config.New()
+	cfg.Set("alerting.enabled", true)
+	cfg.Set("alerting.rules.db_latency.enabled", true)
+	cfg.Set("alerting.rules.db_latency.threshold", 0)
+
+	require.NoError(t, models.SetConfigValue(db, ConfigKeyEnabled, "false"))
+
+	engine := NewEngine(db, cfg, &fakeNotifier{})
+	assert.False(t, engine.IsEnabled())
+	require.NoError(t, engine.Evaluate(context.Background()))
+
+	alerts, err := engine.Recent(10)
+	require.NoError(t, err)
+	assert.Empty(t, alerts)
+}
+
+func TestValidateSetting(t *testing.T) {
+	assert.NoError(t, ValidateSetting("gist.max_files", "anything"))
+	assert.NoError(t, ValidateSetting("alerting.enabled", false))
+	assert.NoError(t, ValidateSetting("alerting.rules.db_latency.threshold", float64(500)))
+	assert.ErrorIs(t, ValidateSetting("alerting.rules.db_latency.threshold", float64(-1)), ErrInvalidSetting)
+	assert.ErrorIs(t, ValidateSetting("alerting.rules.disk_usage.threshold", float64(120)), ErrInvalidSetting)
+	assert.ErrorIs(t, ValidateSetting("alerting.rules.error_rate.enabled", "sometimes"), ErrInvalidSetting)
+	assert.ErrorIs(t, ValidateSetting("alerting.rules.cpu.threshold", float64(80)), ErrInvalidSetting)
+}
+
+func TestRequestCounter(t *testing.T) {
+	var counter requestCounter
+	now := time.Unix(1700000000, 0)
+
+	counter.observe(now.Add(-10*time.Minute), true) // Outside the window
+	counter.observe(now.Add(-2*time.Minute), true)
+	counter.observe(now.Add(-time.Minute), false)
+	counter.observe(now, false)
+
+	total, failed := counter.count(now, 5*time.Minute)
+	assert.Equal(t, int64(3), total)
+	assert.Equal(t, int64(1), failed)
+}
@@ -0,0 +1,83 @@
+package alerting
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PrometheusMetrics renders the rules' last measurements in the Prometheus
+// exposition format so an external Prometheus can scrape them
+func (e *Engine) PrometheusMetrics() (string, error) {
+	statuses, err := e.Status()
+	if err != nil {
+		return "", err
+	}
+
+	var b strings.Builder
+	b.WriteString("# HELP casgists_alert_value Last measured value of the metric watched by an alerting rule\n")
+	b.WriteString("# TYPE casgists_alert_value gauge\n")
+	for _, status := range statuses {
+		if status.Value != nil {
+			fmt.Fprintf(&b, "casgists_alert_value{rule=%q} %s\n", status.Name, formatFloat(*status.Value))
+		}
+	}
+
+	b.WriteString("\n# HELP casgists_alert_threshold Threshold above which an alerting rule fires\n")
+	b.WriteString("# TYPE casgists_alert_threshold gauge\n")
+	for _, status := range statuses {
+		fmt.Fprintf(&b, "casgists_alert_threshold{rule=%q} %s\n", status.Name, formatFloat(status.Threshold))
+	}
+
+	b.WriteString("\n# HELP casgists_alert_firing Whether an alerting rule is currently firing\n")
+	b.WriteString("# TYPE casgists_alert_firing gauge\n")
+	for _, status := range statuses {
+		firing := 0
+		if status.Firing {
+			firing = 1
+		}
+		fmt.Fprintf(&b, "casgists_alert_firing{rule=%q,severity=%q} %d\n", status.Name, status.Severity, firing)
+	}
+
+	return b.String(), nil
+}
+
+// PrometheusRules renders the enabled rules as a Prometheus alerting rules
+// file that fires on the same thresholds as the built-in engine
+func (e *Engine) PrometheusRules() string {
+	var b strings.Builder
+	b.WriteString("groups:\n")
+	b.WriteString("  - name: casgists\n")
+	b.WriteString("    rules:\n")
+
+	for _, rule := range e.Rules() {
+		if !rule.Enabled {
+			continue
+		}
+		fmt.Fprintf(&b, "      - alert: Casgists%s\n", alertName(rule.Name))
+		fmt.Fprintf(&b, "        expr: casgists_alert_value{rule=%q} > %s\n", rule.Name, formatFloat(rule.Threshold))
+		fmt.Fprintf(&b, "        for: %s\n", e.interval())
+		b.WriteString("        labels:\n")
+		fmt.Fprintf(&b, "          severity: %s\n", rule.Severity)
+		b.WriteString("        annotations:\n")
+		fmt.Fprintf(&b, "          summary: %q\n", rule.Title+" above "+formatValue(rule.Unit, rule.Threshold))
+		fmt.Fprintf(&b, "          description: %q\n", rule.Description)
+	}
+
+	return b.String()
+}
+
+// alertName converts a rule name such as db_latency into DbLatency
+func alertName(rule string) string {
+	parts := strings.Split(rule, "_")
+	for i, part := range parts {
+		if part != "" {
+			parts[i] = strings.ToUpper(part[:1]) + part[1:]
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+func formatFloat(value float64) string {
+	return strconv.FormatFloat(value, 'f', -1, 64)
+}
@@ -0,0 +1,64 @@
+package alerting
+
+import (
+	"sync"
+	"time"
+)
+
+// requestBuckets is the number of one-minute buckets kept, which bounds the
+// longest window the error rate can be measured over
+const requestBuckets = 60
+
+// requestBucket counts the responses of one minute
+type requestBucket struct {
+	minute int64
+	total  int64
+	failed int64
+}
+
+// requestCounter counts HTTP responses in one-minute buckets so the error
+// rate can be computed over a sliding window without storing every request
+type requestCounter struct {
+	mu      sync.Mutex
+	buckets [requestBuckets]requestBucket
+}
+
+// observe counts one response
+func (r *requestCounter) observe(now time.Time, failed bool) {
+	minute := now.Unix() / 60
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	bucket := &r.buckets[minute%requestBuckets]
+	if bucket.minute != minute {
+		*bucket = requestBucket{minute: minute}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+}
+
+// count returns the responses and failures within the window before now
+func (r *requestCounter) count(now time.Time, window time.Duration) (total, failed int64) {
+	current := now.Unix() / 60
+	oldest := current - int64(window/time.Minute) + 1
+	if oldest > current {
+		oldest = current
+	}
+	if oldest <= current-requestBuckets {
+		oldest = current - requestBuckets + 1
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, bucket := range r.buckets {
+		if bucket.minute >= oldest && bucket.minute <= current {
+			total += bucket.total
+			failed += bucket.failed
+		}
+	}
+	return total, failed
+}
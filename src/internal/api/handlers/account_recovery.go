@@ -0,0 +1,130 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// AccountRecoveryHandler serves the forgotten password and email
+// verification links
+type AccountRecoveryHandler struct {
+	service *services.AccountRecoveryService
+}
+
+// NewAccountRecoveryHandler creates a new account recovery handler.
+// emailService may be nil.
+func NewAccountRecoveryHandler(db *gorm.DB, config *config.Config, emailService *email.Service) *AccountRecoveryHandler {
+	return &AccountRecoveryHandler{
+		service: services.NewAccountRecoveryService(db, config, emailService),
+	}
+}
+
+// RegisterRoutes registers the account recovery routes. limit is applied
+// to each of them, since they are all reached without signing in.
+func (h *AccountRecoveryHandler) RegisterRoutes(g *echo.Group, limit ...echo.MiddlewareFunc) {
+	g.POST("/auth/forgot-password", h.ForgotPassword, limit...)
+	g.GET("/auth/reset/:token", h.CheckReset, limit...)
+	g.POST("/auth/reset/:token", h.ResetPassword, limit...)
+	g.GET("/auth/verify/:token", h.VerifyEmail, limit...)
+	g.POST("/auth/verify/resend", h.ResendVerification, limit...)
+}
+
+// ForgotPassword emails a reset link. The answer is the same whether or not
+// an account uses the address.
+func (h *AccountRecoveryHandler) ForgotPassword(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil || req.Email == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "email is required")
+	}
+	if _, err := h.service.RequestPasswordReset(req.Email, c.RealIP()); err != nil {
+		return accountRecoveryError(err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"message": "If an account uses this address, a reset link has been sent to it.",
+	})
+}
+
+// CheckReset reports whether a reset link still works
+func (h *AccountRecoveryHandler) CheckReset(c echo.Context) error {
+	reset, err := h.service.CheckResetToken(c.Param("token"), c.RealIP())
+	if err != nil {
+		return accountRecoveryError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"valid":      true,
+		"expires_at": reset.ExpiresAt,
+	})
+}
+
+// ResetPassword sets a new password from a reset link and signs out every
+// session
+func (h *AccountRecoveryHandler) ResetPassword(c echo.Context) error {
+	var req struct {
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil || req.Password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "password is required")
+	}
+	if _, err := h.service.ResetPassword(c.Param("token"), req.Password, c.RealIP()); err != nil {
+		return accountRecoveryError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "Your password has been changed. Sign in with the new one.",
+	})
+}
+
+// VerifyEmail marks an address verified from the emailed link
+func (h *AccountRecoveryHandler) VerifyEmail(c echo.Context) error {
+	user, err := h.service.VerifyEmail(c.Param("token"), c.RealIP())
+	if err != nil {
+		return accountRecoveryError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"verified": true,
+		"email":    user.Email,
+	})
+}
+
+// ResendVerification sends a new verification link. The answer is the same
+// whether or not an account uses the address.
+func (h *AccountRecoveryHandler) ResendVerification(c echo.Context) error {
+	var req struct {
+		Email string `json:"email"`
+	}
+	if err := c.Bind(&req); err != nil || req.Email == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "email is required")
+	}
+	if err := h.service.ResendVerification(req.Email); err != nil {
+		return accountRecoveryError(err)
+	}
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"message": "If an unverified account uses this address, a verification link has been sent to it.",
+	})
+}
+
+// accountRecoveryError maps account recovery service errors to HTTP errors
+func accountRecoveryError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrRecoveryInvalidToken),
+		errors.Is(err, services.ErrRecoveryPassword):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrRecoveryDisabled),
+		errors.Is(err, services.ErrRecoveryUnavailable):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrEmailAlreadyVerified):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrRecoveryLocked):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "account recovery failed")
+	}
+}
@@ -1,29 +1,43 @@
 package handlers
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/alerting"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/contentpolicy"
+	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/markup"
+	"github.com/casapps/casgists/src/internal/scanning"
+	"github.com/casapps/casgists/src/internal/search"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/trash"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // AdminHandler handles admin-related endpoints
 type AdminHandler struct {
-	db     *gorm.DB
-	config *viper.Viper
+	db            *gorm.DB
+	config        *config.Config
+	searchManager *search.Manager
+	quota         *services.QuotaService
 }
 
 // NewAdminHandler creates a new admin handler
-func NewAdminHandler(db *gorm.DB, config *viper.Viper) *AdminHandler {
+func NewAdminHandler(db *gorm.DB, config *config.Config, searchManager *search.Manager) *AdminHandler {
 	return &AdminHandler{
-		db:     db,
-		config: config,
+		db:            db,
+		config:        config,
+		searchManager: searchManager,
+		quota:         services.NewQuotaService(db, config),
 	}
 }
 
@@ -80,9 +94,24 @@ func (h *AdminHandler) Dashboard(c echo.Context) error {
 		"recent_users": recentUsers,
 		"recent_gists": recentGists,
 		"system_info":  systemInfo,
+		"search_index": h.searchIndexHealth(c),
 	})
 }
 
+// searchIndexHealth returns the search index health, or nil when search is
+// unavailable
+func (h *AdminHandler) searchIndexHealth(c echo.Context) *search.IndexHealth {
+	if h.searchManager == nil {
+		return nil
+	}
+	health, err := h.searchManager.Health(c.Request().Context(), h.config.GetDuration("search.index.max_lag"))
+	if err != nil {
+		c.Logger().Errorf("Failed to check search index: %v", err)
+		return nil
+	}
+	return health
+}
+
 // GetUsers returns paginated list of users
 func (h *AdminHandler) GetUsers(c echo.Context) error {
 	// Check if user is admin
@@ -188,7 +217,8 @@ func (h *AdminHandler) GetUser(c echo.Context) error {
 		Find(&recentGists)
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"user": user,
+		"user":  user,
+		"quota": h.quota.TierFor(&user),
 		"stats": map[string]interface{}{
 			"gist_count":         gistCount,
 			"organization_count": orgCount,
@@ -222,13 +252,14 @@ func (h *AdminHandler) UpdateUser(c echo.Context) error {
 
 	// Parse request
 	var req struct {
-		Username    string `json:"username"`
-		Email       string `json:"email"`
-		DisplayName string `json:"display_name"`
-		IsAdmin     *bool  `json:"is_admin"`
-		IsSuspended *bool  `json:"is_suspended"`
-		MaxGists    *int   `json:"max_gists"`
-		MaxFileSize *int64 `json:"max_file_size"`
+		Username    string  `json:"username"`
+		Email       string  `json:"email"`
+		DisplayName string  `json:"display_name"`
+		IsAdmin     *bool   `json:"is_admin"`
+		IsSuspended *bool   `json:"is_suspended"`
+		MaxGists    *int    `json:"max_gists"`
+		MaxFileSize *int64  `json:"max_file_size"`
+		QuotaTier   *string `json:"quota_tier"` // Empty returns the user to the automatic tier
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -286,6 +317,16 @@ func (h *AdminHandler) UpdateUser(c echo.Context) error {
 		}
 	}
 
+	// Assigned through the quota service so cached tiers are dropped
+	if req.QuotaTier != nil {
+		if err := h.quota.Assign(userID, *req.QuotaTier); err != nil {
+			if errors.Is(err, services.ErrQuotaUnknownTier) {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to assign quota tier")
+		}
+	}
+
 	// Reload user
 	h.db.First(&user, userID)
 
@@ -321,13 +362,32 @@ func (h *AdminHandler) DeleteUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
 	}
 
+	// What happens to the user's public gists; defaults to the instance policy
+	var orgID *uuid.UUID
+	if raw := c.QueryParam("organization_id"); raw != "" {
+		parsed, err := uuid.Parse(raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid organization ID")
+		}
+		orgID = &parsed
+	}
+	gistDisposition := services.NewGistDispositionService(h.db, h.config)
+	disposition, err := gistDisposition.ResolveForAdmin(userID, c.QueryParam("gist_policy"), orgID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	// Start transaction
 	tx := h.db.Begin()
 	defer tx.Rollback()
 
-	// Delete user's gists
-	if err := tx.Where("user_id = ?", userID).Delete(&models.Gist{}).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete user gists")
+	// Transfer or delete the user's gists
+	gists, err := gistDisposition.Apply(tx, userID, disposition)
+	if err != nil {
+		if errors.Is(err, services.ErrGistPolicyArchiveAccount) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to transfer or delete user gists")
 	}
 
 	// Remove from organizations
@@ -350,7 +410,10 @@ func (h *AdminHandler) DeleteUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
 	}
 
-	return c.NoContent(http.StatusNoContent)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deleted": true,
+		"gists":   gists,
+	})
 }
 
 // GetSystemInfo returns system information
@@ -367,6 +430,20 @@ func (h *AdminHandler) GetSystemInfo(c echo.Context) error {
 		"host": h.config.GetString("database.host"),
 		"name": h.config.GetString("database.name"),
 	}
+	if sqlDB, err := h.db.DB(); err == nil {
+		stats := sqlDB.Stats()
+		dbInfo["pool"] = map[string]interface{}{
+			"max_open":   stats.MaxOpenConnections,
+			"open":       stats.OpenConnections,
+			"in_use":     stats.InUse,
+			"idle":       stats.Idle,
+			"wait_count": stats.WaitCount,
+			"wait_time":  stats.WaitDuration.String(),
+		}
+	}
+	dbInfo["replicas"] = database.Replicas(h.db)
+	dbInfo["slow_query_threshold"] = h.config.GetDuration("database.slow_query_threshold").String()
+	dbInfo["slow_queries"] = database.SlowQueries(h.db)
 
 	// Storage info
 	storageInfo := map[string]interface{}{
@@ -444,6 +521,22 @@ func (h *AdminHandler) GetSettings(c echo.Context) error {
 		"maintenance.message":     "System is under maintenance",
 	}
 
+	for key, defaultValue := range alerting.SettingsDefaults(h.config) {
+		defaults[key] = defaultValue
+	}
+	for key, defaultValue := range markup.SettingsDefaults(h.config) {
+		defaults[key] = defaultValue
+	}
+	for key, defaultValue := range services.QuotaSettingsDefaults(h.config) {
+		defaults[key] = defaultValue
+	}
+	for key, defaultValue := range contentpolicy.SettingsDefaults(h.config) {
+		defaults[key] = defaultValue
+	}
+	for key, defaultValue := range passkey.SettingsDefaults(h.config) {
+		defaults[key] = defaultValue
+	}
+
 	for key, defaultValue := range defaults {
 		if _, exists := settings[key]; !exists {
 			settings[key] = defaultValue
@@ -467,6 +560,27 @@ func (h *AdminHandler) UpdateSettings(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
 	}
 
+	// Reject unusable alerting thresholds, sanitizer settings, quota tier
+	// limits, content policy rules and security key requirements before
+	// saving anything
+	for key, value := range settings {
+		if err := alerting.ValidateSetting(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := markup.ValidateSetting(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := services.ValidateQuotaSetting(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := contentpolicy.ValidateSetting(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if err := passkey.ValidateSetting(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+	}
+
 	// Update each setting
 	for key, value := range settings {
 		var config models.SystemConfig
@@ -578,13 +692,134 @@ func (h *AdminHandler) GetAuditLogs(c echo.Context) error {
 	})
 }
 
+// GetQuotaTiers returns the defined quota tiers and how users are placed in
+// them
+func (h *AdminHandler) GetQuotaTiers(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"enabled":            h.quota.Enabled(),
+		"tiers":              h.quota.Tiers(),
+		"member_after_days":  h.config.GetInt("quota.member_after_days"),
+		"trusted_after_days": h.config.GetInt("quota.trusted_after_days"),
+	})
+}
+
+// GetQuarantine lists the uploads the malware scanner flagged, newest first
+func (h *AdminHandler) GetQuarantine(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	// Parse pagination
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 50
+	}
+
+	query := h.db.Model(&models.QuarantinedFile{})
+	if source := c.QueryParam("source"); source != "" {
+		query = query.Where("source = ?", source)
+	}
+
+	var total int64
+	query.Count(&total)
+
+	var files []models.QuarantinedFile
+	if err := query.
+		Preload("User").
+		Order("created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&files).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quarantined files")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"files":   files,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+		"pages":   (total + int64(limit) - 1) / int64(limit),
+		"enabled": h.config.GetBool("scanning.enabled"),
+	})
+}
+
+// DeleteQuarantined removes a quarantined file once an admin has dealt
+// with it
+func (h *AdminHandler) DeleteQuarantined(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid quarantined file ID")
+	}
+
+	var file models.QuarantinedFile
+	if err := h.db.First(&file, "id = ?", id).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "Quarantined file not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch quarantined file")
+	}
+	if err := scanning.RemoveQuarantined(h.db, h.config.GetString("scanning.quarantine_dir"), &file); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete quarantined file")
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// PurgeDeleted permanently deletes gists, users and organizations that were
+// soft-deleted more than older_than_days ago (default: 30)
+func (h *AdminHandler) PurgeDeleted(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	days := 30
+	if raw := c.QueryParam("older_than_days"); raw != "" {
+		parsed, err := strconv.Atoi(raw)
+		if err != nil || parsed < 0 {
+			return echo.NewHTTPError(http.StatusBadRequest, "older_than_days must be a number of days")
+		}
+		days = parsed
+	}
+
+	cutoff := time.Now().AddDate(0, 0, -days)
+	result, err := models.PurgeDeleted(h.db.WithContext(c.Request().Context()), cutoff)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to purge deleted records")
+	}
+	trash.RemoveRepositories(h.config, result.GistIDs)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"purged": result,
+		"cutoff": cutoff,
+	})
+}
+
 // RegisterRoutes registers admin routes
 func (h *AdminHandler) RegisterRoutes(g *echo.Group) {
 	// HTML pages
 	g.GET("/admin", h.DashboardPage)
 	g.GET("/admin/dashboard", h.DashboardPage)
 	g.GET("/admin/users", h.UsersPage)
-	g.GET("/admin/settings", h.SettingsPage)
 	
 	// API endpoints
 	g.GET("/admin/api/dashboard", h.Dashboard)
@@ -595,6 +830,10 @@ func (h *AdminHandler) RegisterRoutes(g *echo.Group) {
 	g.GET("/admin/api/system", h.GetSystemInfo)
 	g.GET("/admin/api/settings", h.GetSettings)
 	g.PUT("/admin/api/settings", h.UpdateSettings)
+	g.GET("/admin/api/quota/tiers", h.GetQuotaTiers)
+	g.GET("/admin/api/quarantine", h.GetQuarantine)
+	g.DELETE("/admin/api/quarantine/:id", h.DeleteQuarantined)
+	g.POST("/admin/api/purge-deleted", h.PurgeDeleted)
 	g.POST("/admin/api/backup", h.CreateBackup)
 	g.GET("/admin/api/audit", h.GetAuditLogs)
 }
\ No newline at end of file
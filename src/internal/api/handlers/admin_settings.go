@@ -0,0 +1,239 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/alerting"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/contentpolicy"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/markup"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// secretMask stands in for a saved secret. Sending it back keeps the
+// secret unchanged.
+const secretMask = "********"
+
+// componentSetting is a group of settings outside config.Catalog that a
+// component reads from the system_configs table itself
+type componentSetting struct {
+	prefix   string
+	validate func(key string, value interface{}) error
+	// defaults lists the group's keys; nil when validate rejects unknown
+	// keys itself
+	defaults func(config *config.Config) map[string]interface{}
+}
+
+// componentSettings are the alerting rules, sanitizer settings, quota tier
+// limits, content policy rules and security key requirement
+var componentSettings = []componentSetting{
+	{prefix: "alerting.", validate: alerting.ValidateSetting},
+	{prefix: "markup.sanitizer.", validate: markup.ValidateSetting, defaults: markup.SettingsDefaults},
+	{prefix: "quota.tiers.", validate: services.ValidateQuotaSetting},
+	{prefix: "content_policy.", validate: contentpolicy.ValidateSetting, defaults: contentpolicy.SettingsDefaults},
+	{prefix: "security.webauthn.", validate: passkey.ValidateSetting, defaults: passkey.SettingsDefaults},
+}
+
+// AdminSettingsHandler lets administrators change the runtime settings in
+// config.Catalog and componentSettings without editing the config file
+// (admin only)
+type AdminSettingsHandler struct {
+	db       *gorm.DB
+	config   *config.Config
+	settings *config.Settings
+}
+
+// NewAdminSettingsHandler creates a new admin settings handler
+func NewAdminSettingsHandler(db *gorm.DB, cfg *config.Config, settings *config.Settings) *AdminSettingsHandler {
+	return &AdminSettingsHandler{db: db, config: cfg, settings: settings}
+}
+
+// settingValue is a setting with its current value and where it comes from
+type settingValue struct {
+	config.Setting
+	Value  interface{}   `json:"value"`
+	Source config.Source `json:"source"`
+}
+
+// List returns the settings grouped by category
+func (h *AdminSettingsHandler) List(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	grouped := map[string][]settingValue{}
+	for category, settings := range config.SettingsByCategory() {
+		for _, setting := range settings {
+			grouped[category] = append(grouped[category], settingValue{
+				Setting: setting,
+				Value:   h.shown(setting),
+				Source:  h.settings.Source(setting.Key),
+			})
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"categories": config.Categories,
+		"settings":   grouped,
+	})
+}
+
+// Update saves the settings sent as {"key": value}. Nothing is saved if
+// any value is invalid. Each change is recorded in the audit log.
+func (h *AdminSettingsHandler) Update(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	var req map[string]interface{}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	changes := map[string]string{}
+	previous := map[string]string{}
+	restart := []string{}
+	for key, value := range req {
+		setting, ok := config.LookupSetting(key)
+		if !ok {
+			raw, known, err := h.componentValue(key, value)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+			}
+			if !known {
+				return echo.NewHTTPError(http.StatusBadRequest, "Unknown setting: "+key)
+			}
+			if current := h.componentRaw(key); raw != current {
+				changes[key] = raw
+				previous[key] = current
+			}
+			continue
+		}
+		if setting.Secret && value == secretMask {
+			continue
+		}
+		raw, err := setting.Parse(value)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		if current := h.settings.Raw(key); raw != current {
+			changes[key] = raw
+			previous[key] = current
+			if setting.Restart {
+				restart = append(restart, key)
+			}
+		}
+	}
+
+	if err := h.settings.SaveAll(changes); errors.Is(err, config.ErrInvalidSettings) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	} else if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save settings")
+	}
+
+	changed := make([]string, 0, len(changes))
+	for key := range changes {
+		changed = append(changed, key)
+	}
+	sort.Strings(changed)
+	sort.Strings(restart)
+	for _, key := range changed {
+		h.audit(c, key, previous[key], changes[key])
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":          "Settings updated successfully",
+		"changed":          changed,
+		"restart_required": restart,
+	})
+}
+
+// componentValue checks a setting from componentSettings with the
+// validator of the component that reads it and returns it as stored. known
+// is false for a key no component has.
+func (h *AdminSettingsHandler) componentValue(key string, value interface{}) (raw string, known bool, err error) {
+	for _, group := range componentSettings {
+		if !strings.HasPrefix(key, group.prefix) {
+			continue
+		}
+		if err := group.validate(key, value); err != nil {
+			return "", true, err
+		}
+		if group.defaults != nil {
+			if _, ok := group.defaults(h.config)[key]; !ok {
+				return "", false, nil
+			}
+		}
+		return fmt.Sprint(value), true, nil
+	}
+	return "", false, nil
+}
+
+// componentRaw returns the saved value of a setting from
+// componentSettings, or its config file value when none is saved
+func (h *AdminSettingsHandler) componentRaw(key string) string {
+	var saved models.SystemConfig
+	if result := h.db.Where("key = ?", key).Limit(1).Find(&saved); result.Error == nil && result.RowsAffected > 0 {
+		return saved.Value
+	}
+	if value := h.config.Get(key); value != nil {
+		return fmt.Sprint(value)
+	}
+	return ""
+}
+
+// shown returns a setting's value as the API shows it, with secrets masked
+func (h *AdminSettingsHandler) shown(setting config.Setting) interface{} {
+	if setting.Secret {
+		if h.settings.String(setting.Key) == "" {
+			return ""
+		}
+		return secretMask
+	}
+	if setting.Type == config.TypeDuration {
+		return h.settings.Duration(setting.Key).String()
+	}
+	return h.settings.Get(setting.Key)
+}
+
+// audit records a setting change; secret values are not recorded
+func (h *AdminSettingsHandler) audit(c echo.Context, key, from, to string) {
+	if setting, _ := config.LookupSetting(key); setting.Secret {
+		from, to = secretMask, secretMask
+	}
+	details, _ := json.Marshal(map[string]string{"from": from, "to": to})
+
+	entry := &models.AuditLog{
+		ID:           uuid.New(),
+		Action:       "settings.update",
+		ResourceType: "setting",
+		ResourceID:   key,
+		Details:      string(details),
+		IPAddress:    c.RealIP(),
+		UserAgent:    c.Request().UserAgent(),
+		Success:      true,
+		CreatedAt:    time.Now(),
+	}
+	if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+		entry.UserID = &userID
+	}
+	if err := h.db.Create(entry).Error; err != nil {
+		c.Logger().Warnf("Failed to audit change to %s: %v", key, err)
+	}
+}
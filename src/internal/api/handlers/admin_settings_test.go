@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestAdminSettingsUpdateComponentSettings(t *testing.T) {
+	_, db, cfg := setupGistHandlerTest(t)
+	require.NoError(t, db.AutoMigrate(&models.AuditLog{}))
+	// Saving validates the whole configuration
+	cfg.Set("database.type", "sqlite")
+	cfg.Set("database.path", "casgists.db")
+	cfg.Set("server.port", 8080)
+	cfg.Set("security.secret_key", "test-secret")
+	h := NewAdminSettingsHandler(db, cfg, config.NewSettings(cfg, models.NewConfigStore(db), ""))
+
+	update := func(body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodPut, "/api/v1/admin/settings", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		c.Set("is_admin", true)
+		if err := h.Update(c); err != nil {
+			he, ok := err.(*echo.HTTPError)
+			require.True(t, ok, err)
+			rec.Code = he.Code
+		}
+		return rec
+	}
+
+	rec := update(`{
+		"alerting.enabled": true,
+		"markup.sanitizer.allow_math": true,
+		"quota.tiers.pro.max_files": 50,
+		"content_policy.secret_scanning": "block",
+		"security.webauthn.require": "admins"
+	}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	for key, want := range map[string]string{
+		passkey.ConfigKeyRequire:         "admins",
+		"content_policy.secret_scanning": "block",
+		"quota.tiers.pro.max_files":      "50",
+	} {
+		var saved models.SystemConfig
+		require.NoError(t, db.Where("key = ?", key).First(&saved).Error, key)
+		assert.Equal(t, want, saved.Value, key)
+	}
+
+	for _, body := range []string{
+		`{"alerting.enabled": "sometimes"}`,
+		`{"markup.sanitizer.iframe_hosts": "https://example.com/embed"}`,
+		`{"markup.sanitizer.allow_scripts": true}`,
+		`{"quota.tiers.pro.max_stars": 5}`,
+		`{"content_policy.secret_scanning": "maybe"}`,
+		`{"security.webauthn.require": "everyone"}`,
+		`{"security.webauthn.enabled": false}`,
+		`{"unknown.setting": 1}`,
+	} {
+		assert.Equal(t, http.StatusBadRequest, update(body).Code, body)
+	}
+}
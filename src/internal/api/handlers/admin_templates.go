@@ -39,6 +39,38 @@ func (h *AdminHandler) DashboardPage(c echo.Context) error {
 	var recentGists []models.Gist
 	h.db.Preload("User").Order("created_at DESC").Limit(5).Find(&recentGists)
 	
+	// Alerts raised by the alerting engine
+	var recentAlerts []models.SystemAlert
+	h.db.Order("fired_at DESC").Limit(5).Find(&recentAlerts)
+
+	systemAlerts := []map[string]interface{}{}
+	for _, alert := range recentAlerts {
+		if !alert.IsFiring() {
+			continue
+		}
+		alertType := "warning"
+		if alert.Severity == models.AlertSeverityCritical {
+			alertType = "error"
+		}
+		systemAlerts = append(systemAlerts, map[string]interface{}{
+			"Type":    alertType,
+			"Icon":    "exclamation-triangle",
+			"Message": alert.Message,
+		})
+	}
+
+	// Search index drift
+	searchIndex := h.searchIndexHealth(c)
+	if searchIndex != nil {
+		for _, problem := range searchIndex.Problems {
+			systemAlerts = append(systemAlerts, map[string]interface{}{
+				"Type":    "warning",
+				"Icon":    "search",
+				"Message": problem,
+			})
+		}
+	}
+
 	// Generate chart data for last 7 days
 	userLabels := []string{}
 	userData := []int{}
@@ -96,7 +128,9 @@ func (h *AdminHandler) DashboardPage(c echo.Context) error {
 				"Data":   gistData,
 			},
 		},
-		"RecentAlerts": []map[string]interface{}{},
+		"SystemAlerts": systemAlerts,
+		"RecentAlerts": recentAlerts,
+		"SearchIndex":  searchIndex,
 	}
 
 	return c.Render(http.StatusOK, "admin_dashboard", data)
@@ -0,0 +1,68 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/casapps/casgists/src/internal/alerting"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AlertingHandler handles the admin alerting endpoints
+type AlertingHandler struct {
+	db       *gorm.DB
+	config   *config.Config
+	alerting *alerting.Engine
+}
+
+// NewAlertingHandler creates a new alerting handler
+func NewAlertingHandler(db *gorm.DB, config *config.Config, engine *alerting.Engine) *AlertingHandler {
+	return &AlertingHandler{
+		db:       db,
+		config:   config,
+		alerting: engine,
+	}
+}
+
+// GetAlerts returns the rules with their last measurements and recent alerts
+func (h *AlertingHandler) GetAlerts(c echo.Context) error {
+	rules, err := h.alerting.Status()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch alert status")
+	}
+
+	recent, err := h.alerting.Recent(50)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch alerts")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"enabled": h.alerting.IsEnabled(),
+		"rules":   rules,
+		"alerts":  recent,
+	})
+}
+
+// Evaluate runs the rules immediately instead of waiting for the next tick
+func (h *AlertingHandler) Evaluate(c echo.Context) error {
+	if err := h.alerting.Evaluate(c.Request().Context()); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to evaluate alert rules")
+	}
+	return h.GetAlerts(c)
+}
+
+// PrometheusMetrics exposes the rules' measurements for Prometheus to scrape
+func (h *AlertingHandler) PrometheusMetrics(c echo.Context) error {
+	metrics, err := h.alerting.PrometheusMetrics()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to render alert metrics")
+	}
+	return c.Blob(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", []byte(metrics))
+}
+
+// PrometheusRules exports the enabled rules as a Prometheus alerting rules file
+func (h *AlertingHandler) PrometheusRules(c echo.Context) error {
+	c.Response().Header().Set(echo.HeaderContentDisposition, `attachment; filename="casgists-alerts.yml"`)
+	return c.Blob(http.StatusOK, "application/yaml", []byte(h.alerting.PrometheusRules()))
+}
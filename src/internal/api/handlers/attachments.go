@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/blobs"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// AttachmentHandler uploads and serves the binary and large files attached
+// to gists
+type AttachmentHandler struct {
+	db          *gorm.DB
+	attachments *services.AttachmentService
+	gists       *GistHandler
+	urls        *urls.Builder
+}
+
+// NewAttachmentHandler creates a new attachment handler. A nil store
+// leaves attachments disabled.
+func NewAttachmentHandler(db *gorm.DB, config *config.Config, gitOps GitOperations, store blobs.Store) *AttachmentHandler {
+	return &AttachmentHandler{
+		db:          db,
+		attachments: services.NewAttachmentService(db, config, store),
+		gists:       NewGistHandler(db, config, gitOps),
+		urls:        urls.NewBuilder(config),
+	}
+}
+
+// AttachmentResponse is an attachment with the URL it downloads from
+type AttachmentResponse struct {
+	models.GistAttachment
+	URL string `json:"url"`
+}
+
+// RegisterRoutes registers the attachment routes under /gists on an API
+// group. read guards the routes that download.
+func (h *AttachmentHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc, read ...echo.MiddlewareFunc) {
+	g.GET("/gists/:"+urls.ParamGist+"/attachments", h.List, read...)
+	g.POST("/gists/:"+urls.ParamGist+"/attachments", h.Upload, auth)
+	g.GET("/gists/:"+urls.ParamGist+"/attachments/:"+urls.ParamFile, h.Download, read...)
+	g.DELETE("/gists/:"+urls.ParamGist+"/attachments/:"+urls.ParamFile, h.Delete, auth)
+}
+
+// List returns a gist's attachments
+func (h *AttachmentHandler) List(c echo.Context) error {
+	gist, err := h.readableGist(c)
+	if err != nil {
+		return err
+	}
+	attachments, err := h.attachments.List(gist.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch attachments")
+	}
+	response := make([]AttachmentResponse, 0, len(attachments))
+	for _, attachment := range attachments {
+		response = append(response, h.response(&attachment))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"attachments":     response,
+		"inline_max_size": h.attachments.InlineMaxSize(),
+		"max_size":        h.attachments.MaxSize(),
+	})
+}
+
+// Upload stores the multipart/form-data part named file as an attachment.
+// The part is streamed to storage rather than buffered. Its filename is
+// the part's, or ?filename= when set; an attachment of that name is
+// replaced.
+func (h *AttachmentHandler) Upload(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	reader, err := c.Request().MultipartReader()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload must be multipart/form-data")
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return echo.NewHTTPError(http.StatusBadRequest, "Upload has no file part")
+		}
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid multipart body")
+		}
+		if part.FormName() != "file" {
+			part.Close()
+			continue
+		}
+
+		filename := c.QueryParam("filename")
+		if filename == "" {
+			filename = part.FileName()
+		}
+		attachment, created, err := h.attachments.Upload(gistID, &user, filename, part)
+		part.Close()
+		if err != nil {
+			return attachmentError(err)
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		return c.JSON(status, h.response(attachment))
+	}
+}
+
+// Download serves an attachment's content. Range and If-None-Match
+// requests are honored; the ETag is the content's SHA-256.
+func (h *AttachmentHandler) Download(c echo.Context) error {
+	gist, err := h.readableGist(c)
+	if err != nil {
+		return err
+	}
+	attachment, err := h.attachments.Get(gist.ID, c.Param(urls.ParamFile))
+	if err != nil {
+		return attachmentError(err)
+	}
+	content, err := h.attachments.Open(attachment)
+	if err != nil {
+		return attachmentError(err)
+	}
+	defer content.Close()
+	if err := BurnRead(c, h.db, gist); err != nil {
+		return err
+	}
+
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, attachment.ContentType)
+	header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": attachment.Filename}))
+	header.Set("X-Content-Type-Options", "nosniff")
+	if !httpcache.NoStore(c) {
+		header.Set("ETag", `"`+attachment.SHA256+`"`)
+		httpcache.Revalidate(c, gist.Visibility != models.VisibilityPrivate)
+	}
+	if gist.IndexingDisabled {
+		header.Set("X-Robots-Tag", "noindex, nofollow")
+	}
+	http.ServeContent(c.Response(), c.Request(), attachment.Filename, attachment.UpdatedAt, content)
+	return nil
+}
+
+// Delete removes an attachment
+func (h *AttachmentHandler) Delete(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	if err := h.attachments.Delete(gistID, &user, c.Param(urls.ParamFile)); err != nil {
+		return attachmentError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// readableGist returns the gist of the route if the request may read it
+func (h *AttachmentHandler) readableGist(c echo.Context) (*models.Gist, error) {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	var gist models.Gist
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired).First(&gist, "id = ?", gistID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	if err := h.gists.authorizeRead(c, &gist); err != nil {
+		return nil, err
+	}
+	return &gist, nil
+}
+
+func (h *AttachmentHandler) response(attachment *models.GistAttachment) AttachmentResponse {
+	return AttachmentResponse{
+		GistAttachment: *attachment,
+		URL:            h.urls.URL(urls.APIAttachment, attachment.GistID.String(), attachment.Filename),
+	}
+}
+
+// attachmentError maps attachment service errors to HTTP errors
+func attachmentError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrAttachmentGistNotFound),
+		errors.Is(err, services.ErrAttachmentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrAttachmentsDisabled):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrGistAccessDenied):
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	case errors.Is(err, services.ErrAttachmentFilename):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrAttachmentNameTaken),
+		errors.Is(err, services.ErrAttachmentLimit):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrAttachmentInline):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	case errors.Is(err, services.ErrAttachmentTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, services.ErrAttachmentStorage):
+		return echo.NewHTTPError(http.StatusInternalServerError, services.ErrAttachmentStorage.Error())
+	default:
+		return orgPolicyError(err)
+	}
+}
@@ -4,9 +4,14 @@ import (
 	"net/http"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/api/middleware"
 	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
-	"github.com/spf13/viper"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/go-webauthn/webauthn/protocol"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
@@ -14,27 +19,31 @@ import (
 
 // AuthHandler handles authentication endpoints
 type AuthHandler struct {
-	db          *gorm.DB
-	authService *auth.AuthService
-	totpService *auth.TOTPService
-	config      *viper.Viper
+	db           *gorm.DB
+	authService  *auth.AuthService
+	totpService  *auth.TOTPService
+	emailService *email.Service
+	config       *config.Config
 }
 
-// NewAuthHandler creates a new auth handler
-func NewAuthHandler(db *gorm.DB, authService *auth.AuthService, config *viper.Viper) *AuthHandler {
+// NewAuthHandler creates a new auth handler. emailService may be nil, in
+// which case no verification email is sent on registration.
+func NewAuthHandler(db *gorm.DB, authService *auth.AuthService, config *config.Config, emailService *email.Service) *AuthHandler {
 	return &AuthHandler{
-		db:          db,
-		authService: authService,
-		totpService: auth.NewTOTPService("CasGists"),
-		config:      config,
+		db:           db,
+		authService:  authService,
+		totpService:  auth.NewTOTPService("CasGists"),
+		emailService: emailService,
+		config:       config,
 	}
 }
 
 // LoginRequest represents a login request
 type LoginRequest struct {
-	Username string `json:"username" validate:"required"`
-	Password string `json:"password" validate:"required"`
-	TOTPCode string `json:"totp_code,omitempty"`
+	Username     string `json:"username" validate:"required"`
+	Password     string `json:"password" validate:"required"`
+	TOTPCode     string `json:"totp_code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"` // Stands in for the TOTP code once
 }
 
 // LoginResponse represents a login response
@@ -44,6 +53,21 @@ type LoginResponse struct {
 	ExpiresAt     time.Time `json:"expires_at"`
 	User          *UserResponse `json:"user"`
 	Require2FA    bool      `json:"require_2fa,omitempty"`
+	Reactivated   bool      `json:"reactivated,omitempty"`
+
+	// Set when the login finishes with a security key: TwoFactorMethods
+	// lists the second factors the user can give, and WebAuthn has the
+	// options for /auth/webauthn/login. A user who must have a key but
+	// has none registers one through /auth/webauthn/register instead.
+	TwoFactorMethods            []string                      `json:"two_factor_methods,omitempty"`
+	CeremonyID                  string                        `json:"ceremony_id,omitempty"`
+	WebAuthn                    *protocol.CredentialAssertion `json:"webauthn,omitempty"`
+	RequireWebAuthnRegistration bool                          `json:"require_webauthn_registration,omitempty"`
+	WebAuthnRegistration        *protocol.CredentialCreation  `json:"webauthn_registration,omitempty"`
+
+	// Set while auth.require_2fa is on and the user has no second factor:
+	// the API refuses the session until one is enrolled
+	RequireTwoFactorEnrollment bool `json:"require_2fa_enrollment,omitempty"`
 }
 
 // UserResponse represents a user in API responses
@@ -54,6 +78,9 @@ type UserResponse struct {
 	DisplayName   string    `json:"display_name"`
 	AvatarURL     string    `json:"avatar_url"`
 	IsAdmin       bool      `json:"is_admin"`
+
+	Quota    *services.QuotaTier `json:"quota,omitempty"`    // Only shown to the user themselves and admins
+	Timezone string              `json:"timezone,omitempty"` // Only shown to the user themselves
 }
 
 // Login handles user login
@@ -62,6 +89,24 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
 	}
+	return h.login(c, req)
+}
+
+// Recover signs in with a recovery code in place of the authenticator app
+// code. The code is used up.
+func (h *AuthHandler) Recover(c echo.Context) error {
+	var req LoginRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.RecoveryCode == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "recovery_code is required")
+	}
+	req.TOTPCode = ""
+	return h.login(c, req)
+}
+
+func (h *AuthHandler) login(c echo.Context, req LoginRequest) error {
 
 	// Validate request
 	// TODO: Fix validator
@@ -78,8 +123,20 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
 	}
 
-	// Check if user is active
-	if !user.IsActive {
+	// Check if user is active. Self-deactivated accounts may log in again
+	// to reactivate within the grace period.
+	accounts := services.NewAccountService(h.db, h.config)
+	if user.PendingApproval {
+		if !auth.CheckPasswordHash(req.Password, user.PasswordHash) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
+		}
+		return echo.NewHTTPError(http.StatusForbidden, "account is awaiting approval")
+	}
+	if user.IsDeactivated() {
+		if !accounts.CanReactivate(&user) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "account is deactivated")
+		}
+	} else if !user.IsActive {
 		return echo.NewHTTPError(http.StatusUnauthorized, "account is disabled")
 	}
 
@@ -88,20 +145,83 @@ func (h *AuthHandler) Login(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
 	}
 
+	// Suspended accounts are told so only once the password is right
+	if user.IsSuspended {
+		return echo.NewHTTPError(http.StatusForbidden, "account is suspended")
+	}
+
+	// Admins can't be locked out by turning verification on
+	recovery := services.NewAccountRecoveryService(h.db, h.config, h.emailService)
+	if recovery.RequireVerification() && !user.IsAdmin && !user.EmailVerified && !user.IsEmailVerified {
+		return echo.NewHTTPError(http.StatusForbidden, "email address is not verified")
+	}
+
+	// A registered security key is the second factor. The authenticator
+	// app code stands in for it unless the account must use a key.
+	passkeys := passkey.NewService(h.db, h.config)
+	hasKeys := passkeys.Enabled() && passkeys.HasCredentials(user.ID)
+	keyRequired := passkeys.Required(&user)
+	secondFactor := req.TOTPCode != "" || req.RecoveryCode != ""
+	if hasKeys && (!secondFactor || !user.TwoFactorEnabled || keyRequired) {
+		options, ceremonyID, err := passkeys.BeginSecondFactor(&user, requestOrigin(c))
+		if err != nil {
+			return webAuthnError(err)
+		}
+		methods := []string{"webauthn"}
+		if user.TwoFactorEnabled && !keyRequired {
+			methods = append(methods, "totp", "recovery_code")
+		}
+		return c.JSON(http.StatusOK, LoginResponse{
+			Require2FA:       true,
+			TwoFactorMethods: methods,
+			CeremonyID:       ceremonyID,
+			WebAuthn:         options,
+		})
+	}
+
 	// Check 2FA if enabled
+	twoFactor := services.NewTwoFactorService(h.db, h.config)
 	if user.TwoFactorEnabled {
-		if req.TOTPCode == "" {
+		if !secondFactor {
 			return c.JSON(http.StatusOK, LoginResponse{
-				Require2FA: true,
+				Require2FA:       true,
+				TwoFactorMethods: []string{"totp", "recovery_code"},
 			})
 		}
 
-		// Verify TOTP code
-		if !h.totpService.ValidateTOTP(user.TwoFactorSecret, req.TOTPCode) {
+		// Verify TOTP code, or use up a recovery code
+		if req.RecoveryCode != "" {
+			if err := twoFactor.UseRecoveryCode(user.ID, req.RecoveryCode); err != nil {
+				return echo.NewHTTPError(http.StatusUnauthorized, "invalid recovery code")
+			}
+		} else if !h.totpService.ValidateTOTP(user.TwoFactorSecret, req.TOTPCode) {
 			return echo.NewHTTPError(http.StatusUnauthorized, "invalid 2FA code")
 		}
 	}
 
+	// An account that must use a security key and has none registers one
+	// now; finishing the registration signs the user in
+	if keyRequired && !hasKeys {
+		options, ceremonyID, err := passkeys.BeginEnrollment(&user, requestOrigin(c))
+		if err != nil {
+			return webAuthnError(err)
+		}
+		return c.JSON(http.StatusOK, LoginResponse{
+			RequireWebAuthnRegistration: true,
+			CeremonyID:                  ceremonyID,
+			WebAuthnRegistration:        options,
+		})
+	}
+
+	// Reactivate a self-deactivated account now that the owner has proven access
+	reactivated := false
+	if user.IsDeactivated() {
+		if err := accounts.Reactivate(&user); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to reactivate account")
+		}
+		reactivated = true
+	}
+
 	// Create session
 	session := &models.Session{
 		UserID:       user.ID,
@@ -135,9 +255,11 @@ func (h *AuthHandler) Login(c echo.Context) error {
 	h.db.Save(&user)
 
 	return c.JSON(http.StatusOK, LoginResponse{
-		AccessToken:  tokenPair.AccessToken,
-		RefreshToken: tokenPair.RefreshToken,
-		ExpiresAt:    tokenPair.ExpiresAt,
+		AccessToken:                tokenPair.AccessToken,
+		RefreshToken:               tokenPair.RefreshToken,
+		ExpiresAt:                  tokenPair.ExpiresAt,
+		Reactivated:                reactivated,
+		RequireTwoFactorEnrollment: twoFactor.Required() && !user.TwoFactorEnabled && !hasKeys,
 		User: &UserResponse{
 			ID:          user.ID,
 			Username:    user.Username,
@@ -151,9 +273,17 @@ func (h *AuthHandler) Login(c echo.Context) error {
 
 // RegisterRequest represents a registration request
 type RegisterRequest struct {
-	Username string `json:"username" validate:"required,min=3,max=32"`
-	Email    string `json:"email" validate:"required,email"`
-	Password string `json:"password" validate:"required,min=8"`
+	Username   string `json:"username" validate:"required,min=3,max=32"`
+	Email      string `json:"email" validate:"required,email"`
+	Password   string `json:"password" validate:"required,min=8"`
+	InviteCode string `json:"invite_code,omitempty"` // Required while registration is invite only
+}
+
+// PendingApprovalResponse answers a registration an admin has to approve
+// before the account can sign in
+type PendingApprovalResponse struct {
+	Status string        `json:"status"`
+	User   *UserResponse `json:"user"`
 }
 
 // Register handles user registration
@@ -171,6 +301,15 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	// 	return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	// }
 
+	// Registration may be closed, invite only or limited to some domains
+	registration := services.NewRegistrationService(h.db, h.config)
+	if !registration.Open() {
+		return echo.NewHTTPError(http.StatusForbidden, services.ErrRegistrationClosed.Error())
+	}
+	if err := registration.CheckEmail(req.Email); err != nil {
+		return registrationError(err)
+	}
+
 	// Check if user already exists
 	var existingUser models.User
 	if err := h.db.Where("username = ? OR email = ?", req.Username, req.Email).First(&existingUser).Error; err == nil {
@@ -187,16 +326,34 @@ func (h *AuthHandler) Register(c echo.Context) error {
 	}
 
 	// Create user
-	user := models.User{
+	created, err := registration.Signup(services.SignupInput{
 		Username:     req.Username,
 		Email:        req.Email,
 		PasswordHash: hashedPassword,
-		IsActive:     true,
-		DisplayName:  req.Username,
+		InviteCode:   req.InviteCode,
+	})
+	if err != nil {
+		return registrationError(err)
 	}
+	user := *created
 
-	if err := h.db.Create(&user).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create user")
+	// The link is sent whether or not verification is required to sign in
+	recovery := services.NewAccountRecoveryService(h.db, h.config, h.emailService)
+	if _, err := recovery.SendVerification(&user); err != nil {
+		c.Logger().Errorf("Failed to send verification email: %v", err)
+	}
+
+	// Accounts waiting for an admin get no session
+	if user.PendingApproval {
+		return c.JSON(http.StatusAccepted, PendingApprovalResponse{
+			Status: "pending_approval",
+			User: &UserResponse{
+				ID:          user.ID,
+				Username:    user.Username,
+				Email:       user.Email,
+				DisplayName: user.DisplayName,
+			},
+		})
 	}
 
 	// Create session
@@ -298,12 +455,97 @@ func (h *AuthHandler) RefreshToken(c echo.Context) error {
 	})
 }
 
-// Logout handles user logout
+// IntrospectResponse describes the credentials a request was made with
+type IntrospectResponse struct {
+	Active           bool                       `json:"active"`
+	TokenType        string                     `json:"token_type"`
+	User             *UserResponse              `json:"user"`
+	Scopes           []string                   `json:"scopes"`
+	SessionID        uuid.UUID                  `json:"session_id"`
+	IssuedAt         *time.Time                 `json:"issued_at,omitempty"`
+	ExpiresAt        *time.Time                 `json:"expires_at,omitempty"`
+	ExpiresIn        int                        `json:"expires_in"`         // Seconds until the access token expires
+	SessionExpiresAt time.Time                  `json:"session_expires_at"` // When the refresh token stops working
+	RateLimit        *middleware.RateLimitState `json:"rate_limit,omitempty"`
+}
+
+// Introspect returns who the caller is authenticated as, what their token
+// allows and how much of their rate limit is left, so CLI tools and
+// integrations can check credentials without probing other endpoints
+func (h *AuthHandler) Introspect(c echo.Context) error {
+	claims, ok := c.Get("token_claims").(*auth.Claims)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Access tokens outlive a logout until they expire; report those as revoked
+	var session models.Session
+	if err := h.db.First(&session, "id = ?", claims.SessionID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusUnauthorized, "session has been revoked")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch session")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, claims.UserID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusUnauthorized, "user not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
+	}
+	if user.IsDeactivated() || !user.IsActive || user.IsSuspended {
+		return echo.NewHTTPError(http.StatusUnauthorized, "account is disabled")
+	}
+
+	tier := services.NewQuotaService(h.db, h.config).TierFor(&user)
+	resp := IntrospectResponse{
+		Active:    true,
+		TokenType: "session",
+		User: &UserResponse{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+			IsAdmin:     user.IsAdmin,
+			Quota:       &tier,
+		},
+		Scopes:           claims.Scopes(),
+		SessionID:        claims.SessionID,
+		SessionExpiresAt: session.ExpiresAt,
+	}
+	if claims.IssuedAt != nil {
+		resp.IssuedAt = &claims.IssuedAt.Time
+	}
+	if claims.ExpiresAt != nil {
+		resp.ExpiresAt = &claims.ExpiresAt.Time
+		resp.ExpiresIn = int(time.Until(claims.ExpiresAt.Time).Seconds())
+	}
+	if state, ok := c.Get("rate_limit").(middleware.RateLimitState); ok {
+		resp.RateLimit = &state
+	}
+
+	return c.JSON(http.StatusOK, resp)
+}
+
+// Logout handles user logout.
+// CLI clients whose access token has already expired can revoke their
+// session by sending the refresh token instead.
 func (h *AuthHandler) Logout(c echo.Context) error {
 	// Get session ID from context
 	sessionID, ok := c.Get("session_id").(uuid.UUID)
 	if !ok {
-		return echo.NewHTTPError(http.StatusBadRequest, "invalid session")
+		var req RefreshTokenRequest
+		if err := c.Bind(&req); err != nil || req.RefreshToken == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid session")
+		}
+
+		// Revoke by refresh token
+		if err := h.db.Where("refresh_token = ?", req.RefreshToken).Delete(&models.Session{}).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete session")
+		}
+		return c.NoContent(http.StatusNoContent)
 	}
 
 	// Delete session
@@ -0,0 +1,287 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/casapps/casgists/src/internal/automation"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// AutomationHandler handles tag automation rule endpoints
+type AutomationHandler struct {
+	db     *gorm.DB
+	config *config.Config
+	policy *services.OrgPolicyService
+}
+
+// NewAutomationHandler creates a new automation handler
+func NewAutomationHandler(db *gorm.DB, config *config.Config) *AutomationHandler {
+	return &AutomationHandler{
+		db:     db,
+		config: config,
+		policy: services.NewOrgPolicyService(db),
+	}
+}
+
+// AutomationRuleRequest creates or updates a rule
+type AutomationRuleRequest struct {
+	Name    *string           `json:"name"`
+	Tag     *string           `json:"tag"`
+	Action  *string           `json:"action"`
+	Params  map[string]string `json:"params"`
+	Enabled *bool             `json:"enabled"`
+	// Organization creates the rule for an organization instead of the user
+	Organization string `json:"organization"`
+}
+
+// AutomationRuleResponse describes a rule
+type AutomationRuleResponse struct {
+	models.AutomationRule
+	Params map[string]string `json:"params"`
+}
+
+// ListActions returns the actions rules can use
+func (h *AutomationHandler) ListActions(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"actions": automation.Actions(),
+	})
+}
+
+// List returns the user's rules, or an organization's with ?organization=
+func (h *AutomationHandler) List(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	query := h.db.Where("user_id = ?", user.ID)
+	if orgName := c.QueryParam("organization"); orgName != "" {
+		orgID, err := h.authorizeOrg(orgName, user)
+		if err != nil {
+			return err
+		}
+		query = h.db.Where("organization_id = ?", orgID)
+	}
+
+	var rules []models.AutomationRule
+	if err := query.Order("created_at").Find(&rules).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch automation rules")
+	}
+
+	responses := make([]AutomationRuleResponse, 0, len(rules))
+	for i := range rules {
+		responses = append(responses, newAutomationRuleResponse(&rules[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"rules": responses,
+		"total": len(responses),
+	})
+}
+
+// Create saves a new rule
+func (h *AutomationHandler) Create(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+
+	var req AutomationRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	rule := models.AutomationRule{UserID: &user.ID, Enabled: true}
+	owner := h.db.Model(&models.AutomationRule{}).Where("user_id = ?", user.ID)
+	if req.Organization != "" {
+		orgID, err := h.authorizeOrg(req.Organization, user)
+		if err != nil {
+			return err
+		}
+		rule.UserID = nil
+		rule.OrganizationID = &orgID
+		owner = h.db.Model(&models.AutomationRule{}).Where("organization_id = ?", orgID)
+	}
+
+	var count int64
+	owner.Count(&count)
+	if limit := h.config.GetInt64("automation.max_rules"); limit > 0 && count >= limit {
+		return echo.NewHTTPError(http.StatusBadRequest, "automation rule limit reached")
+	}
+
+	if err := h.apply(&rule, &req); err != nil {
+		return err
+	}
+	if err := h.db.Create(&rule).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create automation rule")
+	}
+	return c.JSON(http.StatusCreated, newAutomationRuleResponse(&rule))
+}
+
+// Get returns a rule
+func (h *AutomationHandler) Get(c echo.Context) error {
+	rule, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, newAutomationRuleResponse(rule))
+}
+
+// Update changes a rule; omitted fields are left as they are
+func (h *AutomationHandler) Update(c echo.Context) error {
+	rule, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	var req AutomationRuleRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := h.apply(rule, &req); err != nil {
+		return err
+	}
+	if err := h.db.Save(rule).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update automation rule")
+	}
+	return c.JSON(http.StatusOK, newAutomationRuleResponse(rule))
+}
+
+// Delete removes a rule and its run history
+func (h *AutomationHandler) Delete(c echo.Context) error {
+	rule, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("rule_id = ?", rule.ID).Delete(&models.AutomationRun{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(rule).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete automation rule")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListRuns returns the most recent runs of a rule
+func (h *AutomationHandler) ListRuns(c echo.Context) error {
+	rule, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	var runs []models.AutomationRun
+	if err := h.db.Where("rule_id = ?", rule.ID).
+		Order("created_at DESC").
+		Limit(limit).
+		Find(&runs).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch automation runs")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"runs": runs,
+	})
+}
+
+// apply copies the request onto the rule and validates the result
+func (h *AutomationHandler) apply(rule *models.AutomationRule, req *AutomationRuleRequest) error {
+	if req.Name != nil {
+		rule.Name = *req.Name
+	}
+	if req.Tag != nil {
+		rule.Tag = *req.Tag
+	}
+	if req.Action != nil {
+		rule.Action = *req.Action
+	}
+	if req.Enabled != nil {
+		rule.Enabled = *req.Enabled
+	}
+	if req.Params != nil {
+		params, err := json.Marshal(req.Params)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid params")
+		}
+		rule.Params = string(params)
+	}
+
+	if err := automation.ValidateRule(h.db, rule); err != nil {
+		if errors.Is(err, automation.ErrInvalidRule) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return orgPolicyError(err)
+	}
+	return nil
+}
+
+// load resolves the :id rule, which must belong to the user or to an
+// organization the user administers
+func (h *AutomationHandler) load(c echo.Context) (*models.AutomationRule, error) {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return nil, err
+	}
+	ruleID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid rule ID")
+	}
+
+	var rule models.AutomationRule
+	if err := h.db.First(&rule, "id = ?", ruleID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "automation rule not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch automation rule")
+	}
+
+	if rule.UserID != nil && *rule.UserID == user.ID {
+		return &rule, nil
+	}
+	if rule.OrganizationID != nil && h.policy.AuthorizeAdmin(*rule.OrganizationID, user) == nil {
+		return &rule, nil
+	}
+	return nil, echo.NewHTTPError(http.StatusNotFound, "automation rule not found")
+}
+
+// authorizeOrg checks that the user may manage the named organization's rules
+func (h *AutomationHandler) authorizeOrg(orgName string, user *models.User) (uuid.UUID, error) {
+	org, err := h.policy.FindOrganization(orgName)
+	if err != nil {
+		return uuid.Nil, orgPolicyError(err)
+	}
+	if err := h.policy.AuthorizeAdmin(org.ID, user); err != nil {
+		return uuid.Nil, orgPolicyError(err)
+	}
+	return org.ID, nil
+}
+
+func (h *AutomationHandler) currentUser(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return &user, nil
+}
+
+func newAutomationRuleResponse(rule *models.AutomationRule) AutomationRuleResponse {
+	params, _ := automation.RuleParams(rule)
+	return AutomationRuleResponse{AutomationRule: *rule, Params: params}
+}
@@ -1,32 +1,41 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
 	"time"
 
 	"github.com/casapps/casgists/src/internal/backup"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/objectstore"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // BackupHandler handles backup and restore endpoints
 type BackupHandler struct {
-	db      *gorm.DB
-	config  *viper.Viper
-	manager *backup.Manager
+	db        *gorm.DB
+	config    *config.Config
+	manager   *backup.Manager
+	scheduler *backup.Scheduler
 }
 
-// NewBackupHandler creates a new backup handler
-func NewBackupHandler(db *gorm.DB, config *viper.Viper) *BackupHandler {
+// NewBackupHandler creates a new backup handler. Backups are taken through
+// the scheduler, so they never overlap a scheduled one.
+func NewBackupHandler(db *gorm.DB, config *config.Config, scheduler *backup.Scheduler) *BackupHandler {
 	return &BackupHandler{
-		db:      db,
-		config:  config,
-		manager: backup.NewManager(db, config),
+		db:        db,
+		config:    config,
+		manager:   scheduler.Manager(),
+		scheduler: scheduler,
 	}
 }
 
@@ -40,6 +49,7 @@ func (h *BackupHandler) CreateBackup(c echo.Context) error {
 
 	// Parse request
 	var req struct {
+		Type               string `json:"type"`
 		IncludeGitRepos    bool   `json:"include_git_repos"`
 		IncludeAttachments bool   `json:"include_attachments"`
 		IncludeLogs        bool   `json:"include_logs"`
@@ -51,69 +61,62 @@ func (h *BackupHandler) CreateBackup(c echo.Context) error {
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
 	}
+	switch req.Type {
+	case "":
+		req.Type = backup.TypeFull
+	case backup.TypeFull, backup.TypeIncremental:
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "type must be full or incremental")
+	}
 
 	// Create backup ID
 	backupID := uuid.New().String()
 	
-	// Set backup path
-	backupDir := h.config.GetString("backup.directory")
-	if backupDir == "" {
-		backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
-	}
-	
 	// Ensure backup directory exists
-	if err := os.MkdirAll(backupDir, 0755); err != nil {
+	if err := os.MkdirAll(h.manager.Dir(), 0755); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create backup directory")
 	}
 
-	filename := fmt.Sprintf("casgists-backup-%s-%s.tar.gz", 
-		time.Now().Format("20060102-150405"), backupID[:8])
-	outputPath := filepath.Join(backupDir, filename)
-
 	// Create backup options
 	options := backup.BackupOptions{
+		ID:                 backupID,
+		Type:               req.Type,
 		IncludeGitRepos:    req.IncludeGitRepos,
 		IncludeAttachments: req.IncludeAttachments,
 		IncludeLogs:        req.IncludeLogs,
 		IncludeConfigs:     req.IncludeConfigs,
 		EncryptionKey:      req.EncryptionKey,
-		OutputPath:         outputPath,
 	}
 
-	// Create backup in background
+	var adminID *uuid.UUID
+	if id, ok := c.Get("user_id").(uuid.UUID); ok {
+		adminID = &id
+	}
+
+	// Create backup in background; the request is over before it is done.
+	// The scheduler tells system webhooks and the administrators how it
+	// went.
 	go func() {
-		ctx := c.Request().Context()
-		result, err := h.manager.CreateBackup(ctx, options)
-		
-		// Store backup record in database
-		backupRecord := map[string]interface{}{
-			"id":          backupID,
-			"filename":    filename,
-			"path":        outputPath,
-			"size":        result.Size,
-			"description": req.Description,
-			"created_at":  time.Now(),
-			"success":     result.Success,
-			"errors":      result.Errors,
-		}
-		
-		// You could store this in a backups table
+		result, err := h.scheduler.Run(context.Background(), options, adminID)
 		if err != nil {
-			backupRecord["error"] = err.Error()
+			slog.Error("Backup failed", "backup", backupID[:8], "error", err)
+		} else if !result.Success {
+			slog.Warn("Backup finished with errors", "backup", backupID[:8], "errors", result.Errors)
 		}
-		
-		// Log or store the backup record
-		// h.db.Table("backups").Create(backupRecord)
 	}()
 
 	return c.JSON(http.StatusAccepted, map[string]interface{}{
 		"backup_id": backupID,
+		"type":      req.Type,
 		"status":    "in_progress",
 		"message":   "Backup creation started",
 	})
 }
 
-// RestoreBackup restores from a backup file
+// RestoreBackup starts restoring a backup, picked by ID or uploaded
+// earlier. The backup is read through before anything is written, and a
+// snapshot of the current data is taken first unless snapshot is false.
+// Progress is reported by RestoreStatus.
 func (h *BackupHandler) RestoreBackup(c echo.Context) error {
 	// Check if user is admin
 	isAdmin, _ := c.Get("is_admin").(bool)
@@ -133,35 +136,22 @@ func (h *BackupHandler) RestoreBackup(c echo.Context) error {
 		RestoreConfig       bool   `json:"restore_config"`
 		RestoreGitRepos     bool   `json:"restore_git_repos"`
 		SkipValidation      bool   `json:"skip_validation"`
+		Snapshot            *bool  `json:"snapshot"`
+		EncryptionKey       string `json:"encryption_key"`
 	}
 
 	if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
 	}
 
-	// Determine backup path
-	backupPath := req.BackupPath
-	if backupPath == "" && req.BackupID != "" {
-		// Look up backup by ID
-		backupDir := h.config.GetString("backup.directory")
-		if backupDir == "" {
-			backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
-		}
-		
-		// Find backup file
-		pattern := filepath.Join(backupDir, fmt.Sprintf("*-%s.tar.gz", req.BackupID[:8]))
-		matches, _ := filepath.Glob(pattern)
-		if len(matches) > 0 {
-			backupPath = matches[0]
-		}
-	}
-
+	// Determine backup path; backups in object storage are downloaded
+	backupPath, cleanup := req.BackupPath, func() {}
 	if backupPath == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Backup file not found")
-	}
-
-	// Check if file exists
-	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		var err error
+		if backupPath, cleanup, err = h.locate(c, req.BackupID); err != nil {
+			return err
+		}
+	} else if _, err := os.Stat(backupPath); os.IsNotExist(err) {
 		return echo.NewHTTPError(http.StatusNotFound, "Backup file not found")
 	}
 
@@ -176,83 +166,177 @@ func (h *BackupHandler) RestoreBackup(c echo.Context) error {
 		RestoreConfig:     req.RestoreConfig,
 		RestoreGitRepos:   req.RestoreGitRepos,
 		SkipValidation:    req.SkipValidation,
+		Snapshot:          req.Snapshot == nil || *req.Snapshot,
+		EncryptionKey:     req.EncryptionKey,
 	}
 
-	// Perform restore
-	ctx := c.Request().Context()
-	result, err := h.manager.RestoreBackup(ctx, options)
-	if err != nil {
+	if err := h.manager.StartRestore(options, cleanup); err != nil {
+		cleanup()
+		if errors.Is(err, backup.ErrRestoreInProgress) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Restore failed: %v", err))
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"success":           result.Success,
-		"restored_users":    result.RestoredUsers,
-		"restored_gists":    result.RestoredGists,
-		"restored_files":    result.RestoredFiles,
-		"restored_orgs":     result.RestoredOrgs,
-		"restored_webhooks": result.RestoredWebhooks,
-		"skipped_items":     result.SkippedItems,
-		"errors":            result.Errors,
-		"backup_metadata":   result.BackupMetadata,
-	})
+	return c.JSON(http.StatusAccepted, h.manager.RestoreStatus())
 }
 
-// ListBackups lists available backups
-func (h *BackupHandler) ListBackups(c echo.Context) error {
+// RestoreStatus reports the progress of the running or last restore
+func (h *BackupHandler) RestoreStatus(c echo.Context) error {
 	// Check if user is admin
 	isAdmin, _ := c.Get("is_admin").(bool)
 	if !isAdmin {
 		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
 	}
 
-	backupDir := h.config.GetString("backup.directory")
-	if backupDir == "" {
-		backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
+	return c.JSON(http.StatusOK, h.manager.RestoreStatus())
+}
+
+// UploadBackup stores a backup file uploaded as the "file" part of a
+// multipart body, so it can be restored by its ID. It is checked with the
+// configured encryption key; damaged files are refused, and encrypted ones
+// the key can't open are kept for ValidateBackup with the right key.
+func (h *BackupHandler) UploadBackup(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
 	}
 
-	// Find all backup files
-	pattern := filepath.Join(backupDir, "casgists-backup-*.tar.gz")
-	files, err := filepath.Glob(pattern)
+	reader, err := c.Request().MultipartReader()
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list backups")
+		return echo.NewHTTPError(http.StatusBadRequest, "Upload must be multipart/form-data")
 	}
-
-	backups := []map[string]interface{}{}
-	for _, file := range files {
-		info, err := os.Stat(file)
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return echo.NewHTTPError(http.StatusBadRequest, "Upload has no file part")
+		}
 		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid multipart body")
+		}
+		if part.FormName() != "file" {
+			part.Close()
 			continue
 		}
 
-		// Extract backup ID from filename
-		filename := filepath.Base(file)
-		var backupID string
-		if len(filename) > 32 {
-			backupID = filename[len(filename)-44 : len(filename)-7] // Extract UUID portion
+		entry, err := h.manager.Import(part)
+		part.Close()
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to store backup")
 		}
 
-		backup := map[string]interface{}{
-			"id":         backupID,
-			"filename":   filename,
-			"path":       file,
-			"size":       info.Size(),
-			"created_at": info.ModTime(),
+		verification, err := h.manager.VerifyBackup(entry.Path, "")
+		if err != nil && !errors.Is(err, backup.ErrEncrypted) && !errors.Is(err, backup.ErrDecrypt) {
+			os.Remove(entry.Path)
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Not a usable backup: %v", err))
 		}
+		return c.JSON(http.StatusCreated, map[string]interface{}{
+			"backup":     entry,
+			"validation": validationResponse(verification, err),
+		})
+	}
+}
 
-		// Try to read metadata
-		metadata, err := h.manager.ReadBackupMetadata(file)
-		if err == nil {
-			backup["metadata"] = metadata
-		}
+// ValidateBackup reads a backup through and reports whether it can be
+// restored here, without restoring anything
+func (h *BackupHandler) ValidateBackup(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
 
+	var req struct {
+		EncryptionKey string `json:"encryption_key"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	backupPath, cleanup, err := h.locate(c, c.Param("id"))
+	if err != nil {
+		return err
+	}
+	defer cleanup()
+
+	verification, err := h.manager.VerifyBackup(backupPath, req.EncryptionKey)
+	return c.JSON(http.StatusOK, validationResponse(verification, err))
+}
+
+// validationResponse describes what VerifyBackup found
+func validationResponse(verification *backup.Verification, err error) map[string]interface{} {
+	response := map[string]interface{}{
+		"valid":        err == nil && verification.Compatible,
+		"verification": verification,
+	}
+	if err != nil {
+		response["error"] = err.Error()
+	}
+	return response
+}
+
+// locate finds a backup by ID, locally or in object storage
+func (h *BackupHandler) locate(c echo.Context, backupID string) (string, func(), error) {
+	backupPath, cleanup, err := h.manager.Locate(c.Request().Context(), backupID)
+	if errors.Is(err, backup.ErrBackupNotFound) {
+		return "", nil, echo.NewHTTPError(http.StatusNotFound, "Backup not found")
+	}
+	if err != nil {
+		return "", nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to find backup")
+	}
+	return backupPath, cleanup, nil
+}
+
+// ListBackups lists available backups, newest first. The metadata of
+// backups in object storage is only read when one is asked for, as that
+// means downloading it.
+func (h *BackupHandler) ListBackups(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	entries, err := h.manager.Backups(c.Request().Context())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list backups")
+	}
+
+	backups := []map[string]interface{}{}
+	for _, entry := range entries {
+		backup := map[string]interface{}{
+			"id":         entry.ID,
+			"type":       entry.Type,
+			"filename":   entry.Filename,
+			"size":       entry.Size,
+			"created_at": entry.CreatedAt,
+		}
+		if entry.StorageKey != "" {
+			backup["storage"] = h.manager.Store().Name()
+			backup["storage_key"] = entry.StorageKey
+		} else {
+			backup["path"] = entry.Path
+
+			// Try to read metadata
+			if metadata, err := h.manager.ReadBackupMetadata(entry.Path); err == nil {
+				backup["metadata"] = metadata
+			}
+		}
 		backups = append(backups, backup)
 	}
 
-	return c.JSON(http.StatusOK, map[string]interface{}{
+	response := map[string]interface{}{
 		"backups": backups,
 		"total":   len(backups),
-	})
+	}
+	if next, backupType := h.scheduler.Next(time.Now()); !next.IsZero() {
+		response["next_backup"] = map[string]interface{}{
+			"type": backupType,
+			"at":   next,
+		}
+	}
+	return c.JSON(http.StatusOK, response)
 }
 
 // GetBackupInfo returns information about a specific backup
@@ -266,15 +350,10 @@ func (h *BackupHandler) GetBackupInfo(c echo.Context) error {
 	backupID := c.Param("id")
 	
 	// Find backup file
-	backupDir := h.config.GetString("backup.directory")
-	if backupDir == "" {
-		backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
-	}
-	
-	pattern := filepath.Join(backupDir, fmt.Sprintf("*-%s*.tar.gz", backupID))
+	pattern := filepath.Join(h.manager.Dir(), fmt.Sprintf("*-%s*.tar.gz", backupID))
 	matches, _ := filepath.Glob(pattern)
 	if len(matches) == 0 {
-		return echo.NewHTTPError(http.StatusNotFound, "Backup not found")
+		return h.remoteBackupInfo(c, backupID)
 	}
 
 	backupPath := matches[0]
@@ -312,15 +391,17 @@ func (h *BackupHandler) DeleteBackup(c echo.Context) error {
 	backupID := c.Param("id")
 	
 	// Find backup file
-	backupDir := h.config.GetString("backup.directory")
-	if backupDir == "" {
-		backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
-	}
-	
-	pattern := filepath.Join(backupDir, fmt.Sprintf("*-%s*.tar.gz", backupID))
+	pattern := filepath.Join(h.manager.Dir(), fmt.Sprintf("*-%s*.tar.gz", backupID))
 	matches, _ := filepath.Glob(pattern)
 	if len(matches) == 0 {
-		return echo.NewHTTPError(http.StatusNotFound, "Backup not found")
+		object, err := h.findRemoteBackup(c, backupID)
+		if err != nil {
+			return err
+		}
+		if err := h.manager.DeleteRemoteBackup(c.Request().Context(), object.Key); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete backup")
+		}
+		return c.NoContent(http.StatusNoContent)
 	}
 
 	// Delete file
@@ -342,15 +423,10 @@ func (h *BackupHandler) DownloadBackup(c echo.Context) error {
 	backupID := c.Param("id")
 	
 	// Find backup file
-	backupDir := h.config.GetString("backup.directory")
-	if backupDir == "" {
-		backupDir = filepath.Join(h.config.GetString("paths.data"), "backups")
-	}
-	
-	pattern := filepath.Join(backupDir, fmt.Sprintf("*-%s*.tar.gz", backupID))
+	pattern := filepath.Join(h.manager.Dir(), fmt.Sprintf("*-%s*.tar.gz", backupID))
 	matches, _ := filepath.Glob(pattern)
 	if len(matches) == 0 {
-		return echo.NewHTTPError(http.StatusNotFound, "Backup not found")
+		return h.downloadRemoteBackup(c, backupID)
 	}
 
 	backupPath := matches[0]
@@ -363,12 +439,75 @@ func (h *BackupHandler) DownloadBackup(c echo.Context) error {
 	return c.File(backupPath)
 }
 
-// RegisterRoutes registers backup routes
-func (h *BackupHandler) RegisterRoutes(g *echo.Group) {
-	g.GET("/backup", h.ListBackups)
-	g.POST("/backup", h.CreateBackup)
-	g.POST("/backup/restore", h.RestoreBackup)
-	g.GET("/backup/:id", h.GetBackupInfo)
-	g.DELETE("/backup/:id", h.DeleteBackup)
-	g.GET("/backup/:id/download", h.DownloadBackup)
+// findRemoteBackup looks a backup up in object storage
+func (h *BackupHandler) findRemoteBackup(c echo.Context, backupID string) (objectstore.Object, error) {
+	object, err := h.manager.FindRemoteBackup(c.Request().Context(), backupID)
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return object, echo.NewHTTPError(http.StatusNotFound, "Backup not found")
+	}
+	if err != nil {
+		return object, echo.NewHTTPError(http.StatusInternalServerError, "Failed to list backups in object storage")
+	}
+	return object, nil
+}
+
+// remoteBackupInfo returns information about a backup in object storage,
+// downloading it to read its metadata
+func (h *BackupHandler) remoteBackupInfo(c echo.Context, backupID string) error {
+	object, err := h.findRemoteBackup(c, backupID)
+	if err != nil {
+		return err
+	}
+	backupPath, cleanup, err := h.manager.FetchRemoteBackup(c.Request().Context(), object.Key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to download backup from object storage")
+	}
+	defer cleanup()
+	metadata, err := h.manager.ReadBackupMetadata(backupPath)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read backup metadata")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"id":          backupID,
+		"filename":    path.Base(object.Key),
+		"storage":     h.manager.Store().Name(),
+		"storage_key": object.Key,
+		"size":        object.Size,
+		"created_at":  object.ModTime,
+		"metadata":    metadata,
+	})
+}
+
+// downloadRemoteBackup streams a backup from object storage
+func (h *BackupHandler) downloadRemoteBackup(c echo.Context, backupID string) error {
+	object, err := h.findRemoteBackup(c, backupID)
+	if err != nil {
+		return err
+	}
+	content, err := h.manager.OpenRemoteBackup(c.Request().Context(), object.Key)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open backup in object storage")
+	}
+	defer content.Close()
+
+	filename := path.Base(object.Key)
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
+	c.Response().Header().Set("Content-Type", "application/gzip")
+	http.ServeContent(c.Response(), c.Request(), filename, object.ModTime, content)
+	return nil
+}
+
+// RegisterRoutes registers backup routes behind admin, the middleware
+// authenticating an admin
+func (h *BackupHandler) RegisterRoutes(g *echo.Group, admin ...echo.MiddlewareFunc) {
+	g.GET("/backup", h.ListBackups, admin...)
+	g.POST("/backup", h.CreateBackup, admin...)
+	g.POST("/backup/upload", h.UploadBackup, admin...)
+	g.POST("/backup/restore", h.RestoreBackup, admin...)
+	g.GET("/backup/restore", h.RestoreStatus, admin...)
+	g.POST("/backup/:id/validate", h.ValidateBackup, admin...)
+	g.GET("/backup/:id", h.GetBackupInfo, admin...)
+	g.DELETE("/backup/:id", h.DeleteBackup, admin...)
+	g.GET("/backup/:id/download", h.DownloadBackup, admin...)
 }
\ No newline at end of file
@@ -0,0 +1,82 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/cache"
+)
+
+// CacheHandler reports on and flushes the cache (admin only)
+type CacheHandler struct {
+	cache *cache.CacheManager
+}
+
+// NewCacheHandler creates a new cache handler
+func NewCacheHandler(cacheManager *cache.CacheManager) *CacheHandler {
+	return &CacheHandler{cache: cacheManager}
+}
+
+// FlushCacheRequest selects what to flush. An empty namespace flushes
+// everything.
+type FlushCacheRequest struct {
+	Namespace string `json:"namespace"`
+}
+
+// Status returns the cache backend, whether it answers, and the hits and
+// misses of this instance
+func (h *CacheHandler) Status(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"cache":      h.cache.Stats(c.Request().Context()),
+		"namespaces": cache.Namespaces(),
+	})
+}
+
+// Flush removes every cached value, or those in one namespace
+func (h *CacheHandler) Flush(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	var req FlushCacheRequest
+	if c.Request().ContentLength != 0 {
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+		}
+	}
+	if req.Namespace != "" && !knownNamespace(req.Namespace) {
+		return echo.NewHTTPError(http.StatusBadRequest, "unknown cache namespace")
+	}
+	if !h.cache.Enabled() {
+		return echo.NewHTTPError(http.StatusConflict, "the cache is disabled")
+	}
+
+	if err := h.cache.Flush(c.Request().Context(), req.Namespace); err != nil {
+		c.Logger().Errorf("Failed to flush cache: %v", err)
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "Failed to flush cache")
+	}
+	c.Logger().Infof("Cache flushed by %v (namespace %q)", c.Get("username"), req.Namespace)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":    "Cache flushed",
+		"namespace": req.Namespace,
+	})
+}
+
+func knownNamespace(namespace string) bool {
+	for _, known := range cache.Namespaces() {
+		if namespace == known {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/codeimage"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/syntax"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// imageStore is the subset of the cache API the code image handler needs
+type imageStore interface {
+	Get(ctx context.Context, key string) (string, error)
+	Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+}
+
+// CodeImageHandler renders gist files as syntax-highlighted PNG images
+type CodeImageHandler struct {
+	db        *gorm.DB
+	config    *config.Config
+	cache     imageStore
+	languages *syntax.LanguageDetector
+}
+
+// NewCodeImageHandler creates a new code image handler. Rendered images are
+// stored in the shared cache when it is enabled, and in process memory
+// otherwise.
+func NewCodeImageHandler(db *gorm.DB, config *config.Config, cacheManager *cache.CacheManager) *CodeImageHandler {
+	var store imageStore = cache.NewMemoryCache()
+	if cacheManager != nil && cacheManager.Enabled() {
+		store = cacheManager
+	}
+
+	return &CodeImageHandler{
+		db:        db,
+		config:    config,
+		cache:     store,
+		languages: syntax.NewLanguageDetector(),
+	}
+}
+
+// Render returns a PNG of a gist file. Supported query parameters are theme,
+// width (pixels, 0 to fit the code) and line_numbers.
+func (h *CodeImageHandler) Render(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+
+	var gist models.Gist
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated).First(&gist, gistID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+
+	// Check visibility
+	userID, _ := c.Get("user_id").(uuid.UUID)
+	if gist.Visibility == models.VisibilityPrivate && (gist.UserID == nil || *gist.UserID != userID) {
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	}
+	if err := BurnRead(c, h.db, &gist); err != nil {
+		return err
+	}
+
+	var file models.GistFile
+	if err := h.db.Where("gist_id = ? AND filename = ?", gist.ID, c.Param("file")).First(&file).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "file not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch file")
+	}
+
+	opts, err := h.options(c, &file)
+	if err != nil {
+		return err
+	}
+	if opts.MaxBytes > 0 && len(file.Content) > opts.MaxBytes {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file is too large to render as an image")
+	}
+
+	// Identical content and options always produce the same image, so the
+	// hash doubles as the ETag
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%s\x00%s\x00%s\x00%d\x00%t\x00%d\x00%d",
+		file.Content, opts.Title, opts.Language, opts.Theme, opts.Width, opts.LineNumbers, opts.MaxWidth, opts.MaxLines)))
+	hash := hex.EncodeToString(sum[:])
+	etag := `"` + hash + `"`
+
+	header := c.Response().Header()
+	if gist.Visibility == models.VisibilityPrivate {
+		header.Set("Cache-Control", "private, max-age=3600")
+	} else {
+		header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(h.cacheTTL().Seconds())))
+	}
+	if httpcache.NotModified(c, etag, time.Time{}) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	ctx := c.Request().Context()
+	key := cache.CodeImageKey(hash)
+	if cached, err := h.cache.Get(ctx, key); err == nil && cached != "" {
+		return c.Blob(http.StatusOK, "image/png", []byte(cached))
+	}
+
+	var buf bytes.Buffer
+	if err := codeimage.Render(&buf, file.Content, opts); err != nil {
+		switch {
+		case errors.Is(err, codeimage.ErrTooLarge):
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "file is too large to render as an image")
+		case errors.Is(err, codeimage.ErrUnknownTheme), errors.Is(err, codeimage.ErrInvalidWidth):
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to render image")
+	}
+
+	h.cache.Set(ctx, key, buf.Bytes(), h.cacheTTL())
+
+	return c.Blob(http.StatusOK, "image/png", buf.Bytes())
+}
+
+// options builds the render options from the query string and config
+func (h *CodeImageHandler) options(c echo.Context, file *models.GistFile) (codeimage.Options, error) {
+	opts := codeimage.Options{
+		Theme:       c.QueryParam("theme"),
+		Title:       file.Filename,
+		LineNumbers: true,
+		MaxWidth:    h.config.GetInt("codeimage.max_width"),
+		MaxLines:    h.config.GetInt("codeimage.max_lines"),
+		MaxBytes:    h.config.GetInt("codeimage.max_bytes"),
+	}
+	if opts.Theme == "" {
+		opts.Theme = h.config.GetString("codeimage.default_theme")
+	}
+	if _, err := codeimage.LookupTheme(opts.Theme); err != nil {
+		return opts, echo.NewHTTPError(http.StatusBadRequest,
+			fmt.Sprintf("unknown theme %q, available themes: %v", opts.Theme, codeimage.Themes()))
+	}
+
+	if width := c.QueryParam("width"); width != "" {
+		w, err := strconv.Atoi(width)
+		if err != nil || (w != 0 && (w < codeimage.MinWidth || (opts.MaxWidth > 0 && w > opts.MaxWidth))) {
+			return opts, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("width must be 0 or between %d and %d", codeimage.MinWidth, opts.MaxWidth))
+		}
+		opts.Width = w
+	}
+
+	if lineNumbers := c.QueryParam("line_numbers"); lineNumbers != "" {
+		show, err := strconv.ParseBool(lineNumbers)
+		if err != nil {
+			return opts, echo.NewHTTPError(http.StatusBadRequest, "line_numbers must be true or false")
+		}
+		opts.LineNumbers = show
+	}
+
+	// Prefer detection, which yields the syntax package's language IDs; the
+	// stored language is free text entered by the author
+	if lang := h.languages.DetectLanguage(file.Filename, file.Content); lang != nil && lang.ID != "text" {
+		opts.Language = lang.ID
+	} else {
+		opts.Language = file.Language
+	}
+
+	return opts, nil
+}
+
+func (h *CodeImageHandler) cacheTTL() time.Duration {
+	if ttl := h.config.GetDuration("codeimage.cache_ttl"); ttl > 0 {
+		return ttl
+	}
+	return cache.TTLVeryLong
+}
@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// ParamCollection names the collection ID in collection routes
+const ParamCollection = "collectionID"
+
+// CollectionHandler manages users' gist collections and pinned gists
+type CollectionHandler struct {
+	db      *gorm.DB
+	service *services.GistCollectionService
+	gists   *GistHandler
+}
+
+// NewCollectionHandler creates a new collection handler
+func NewCollectionHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *CollectionHandler {
+	return &CollectionHandler{
+		db:      db,
+		service: services.NewGistCollectionService(db),
+		gists:   NewGistHandler(db, config, gitOps),
+	}
+}
+
+// CollectionRequest is the body of a new or changed collection; fields
+// left out stay as they are
+type CollectionRequest struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	IsPublic    *bool   `json:"is_public"`
+}
+
+// CollectionOrderRequest lists every collection of the user in order
+type CollectionOrderRequest struct {
+	CollectionIDs []uuid.UUID `json:"collection_ids"`
+}
+
+// CollectionGistsRequest lists gists in order
+type CollectionGistsRequest struct {
+	GistIDs []uuid.UUID `json:"gist_ids"`
+}
+
+// CollectionGistRequest names one gist
+type CollectionGistRequest struct {
+	GistID uuid.UUID `json:"gist_id"`
+}
+
+// CollectionResponse is a collection with the gists its viewer may see
+type CollectionResponse struct {
+	models.GistCollection
+	Gists []GistResponse `json:"gists"`
+}
+
+// RegisterRoutes registers the collection and pin routes. The signed in
+// user's are managed under /user, anyone's are read under /users/:username.
+func (h *CollectionHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc, public ...echo.MiddlewareFunc) {
+	collection := "/user/collections/:" + ParamCollection
+	g.GET("/user/collections", h.ListOwn, auth)
+	g.POST("/user/collections", h.Create, auth)
+	g.PUT("/user/collections/order", h.Reorder, auth)
+	g.GET(collection, h.GetOwn, auth)
+	g.PATCH(collection, h.Update, auth)
+	g.DELETE(collection, h.Delete, auth)
+	g.PUT(collection+"/gists", h.SetGists, auth)
+	g.POST(collection+"/gists", h.AddGist, auth)
+	g.DELETE(collection+"/gists/:"+urls.ParamGist, h.RemoveGist, auth)
+	g.GET("/user/pins", h.ListOwnPins, auth)
+	g.PUT("/user/pins", h.SetPins, auth)
+
+	g.GET("/users/:username/collections", h.List, public...)
+	g.GET("/users/:username/collections/:"+ParamCollection, h.Get, public...)
+	g.GET("/users/:username/pins", h.ListPins, public...)
+}
+
+// ListOwn returns the signed in user's collections
+func (h *CollectionHandler) ListOwn(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	return h.list(c, user, user)
+}
+
+// List returns the public collections of a user
+func (h *CollectionHandler) List(c echo.Context) error {
+	owner, err := h.profileUser(c)
+	if err != nil {
+		return err
+	}
+	return h.list(c, owner, requestViewer(h.db, c))
+}
+
+// GetOwn returns one of the signed in user's collections
+func (h *CollectionHandler) GetOwn(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	return h.get(c, user, user)
+}
+
+// Get returns a public collection of a user
+func (h *CollectionHandler) Get(c echo.Context) error {
+	owner, err := h.profileUser(c)
+	if err != nil {
+		return err
+	}
+	return h.get(c, owner, requestViewer(h.db, c))
+}
+
+// Create adds a collection
+func (h *CollectionHandler) Create(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	collection, err := h.service.Create(user, services.CollectionInput(req))
+	if err != nil {
+		return collectionError(err)
+	}
+	return c.JSON(http.StatusCreated, CollectionResponse{GistCollection: *collection, Gists: []GistResponse{}})
+}
+
+// Update changes a collection's name, description or visibility
+func (h *CollectionHandler) Update(c echo.Context) error {
+	user, collectionID, err := h.requestCollection(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	if _, err := h.service.Update(collectionID, user, services.CollectionInput(req)); err != nil {
+		return collectionError(err)
+	}
+	return h.get(c, user, user)
+}
+
+// Delete removes a collection, leaving its gists alone
+func (h *CollectionHandler) Delete(c echo.Context) error {
+	user, collectionID, err := h.requestCollection(c)
+	if err != nil {
+		return err
+	}
+	if err := h.service.Delete(collectionID, user); err != nil {
+		return collectionError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// Reorder puts the user's collections in order
+func (h *CollectionHandler) Reorder(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionOrderRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := h.service.Reorder(user, req.CollectionIDs); err != nil {
+		return collectionError(err)
+	}
+	return h.list(c, user, user)
+}
+
+// SetGists replaces the gists of a collection, in order
+func (h *CollectionHandler) SetGists(c echo.Context) error {
+	user, collectionID, err := h.requestCollection(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionGistsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := h.service.SetGists(collectionID, user, req.GistIDs); err != nil {
+		return collectionError(err)
+	}
+	return h.get(c, user, user)
+}
+
+// AddGist adds a gist to the end of a collection
+func (h *CollectionHandler) AddGist(c echo.Context) error {
+	user, collectionID, err := h.requestCollection(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionGistRequest
+	if err := c.Bind(&req); err != nil || req.GistID == uuid.Nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "gist_id is required")
+	}
+	if err := h.service.AddGist(collectionID, user, req.GistID); err != nil {
+		return collectionError(err)
+	}
+	return h.get(c, user, user)
+}
+
+// RemoveGist takes a gist out of a collection
+func (h *CollectionHandler) RemoveGist(c echo.Context) error {
+	user, collectionID, err := h.requestCollection(c)
+	if err != nil {
+		return err
+	}
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	if err := h.service.RemoveGist(collectionID, user, gistID); err != nil {
+		return collectionError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListOwnPins returns the gists pinned to the signed in user's profile
+func (h *CollectionHandler) ListOwnPins(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	return h.listPins(c, user, user)
+}
+
+// ListPins returns the gists pinned to a user's profile
+func (h *CollectionHandler) ListPins(c echo.Context) error {
+	owner, err := h.profileUser(c)
+	if err != nil {
+		return err
+	}
+	return h.listPins(c, owner, requestViewer(h.db, c))
+}
+
+// SetPins replaces the gists pinned to the signed in user's profile
+func (h *CollectionHandler) SetPins(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req CollectionGistsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if err := h.service.SetPins(user, req.GistIDs); err != nil {
+		return collectionError(err)
+	}
+	return h.listPins(c, user, user)
+}
+
+func (h *CollectionHandler) list(c echo.Context, owner, viewer *models.User) error {
+	views, err := h.service.List(owner, viewer)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch collections")
+	}
+	collections := make([]CollectionResponse, 0, len(views))
+	for _, view := range views {
+		collections = append(collections, h.response(view))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"collections": collections,
+	})
+}
+
+func (h *CollectionHandler) get(c echo.Context, owner, viewer *models.User) error {
+	collectionID, err := uuid.Parse(c.Param(ParamCollection))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid collection ID")
+	}
+	view, err := h.service.Get(collectionID, owner, viewer)
+	if err != nil {
+		return collectionError(err)
+	}
+	return c.JSON(http.StatusOK, h.response(*view))
+}
+
+func (h *CollectionHandler) listPins(c echo.Context, owner, viewer *models.User) error {
+	gists, err := h.service.Pins(owner, viewer)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch pinned gists")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"gists": h.gists.buildGistListResponse(gists),
+		"limit": services.MaxPinnedGists,
+	})
+}
+
+// response builds the response of a collection
+func (h *CollectionHandler) response(view services.CollectionView) CollectionResponse {
+	return CollectionResponse{
+		GistCollection: view.GistCollection,
+		Gists:          h.gists.buildGistListResponse(view.Gists),
+	}
+}
+
+// requestUser returns the signed in user
+func (h *CollectionHandler) requestUser(c echo.Context) (*models.User, error) {
+	user := requestViewer(h.db, c)
+	if user == nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	return user, nil
+}
+
+// requestCollection returns the signed in user and the collection of the
+// route
+func (h *CollectionHandler) requestCollection(c echo.Context) (*models.User, uuid.UUID, error) {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return nil, uuid.Nil, err
+	}
+	collectionID, err := uuid.Parse(c.Param(ParamCollection))
+	if err != nil {
+		return nil, uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid collection ID")
+	}
+	return user, collectionID, nil
+}
+
+// profileUser returns the user of the route; deactivated profiles are
+// hidden
+func (h *CollectionHandler) profileUser(c echo.Context) (*models.User, error) {
+	var user models.User
+	if err := h.db.Where("username = ? AND deactivated_at IS NULL", c.Param("username")).First(&user).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	return &user, nil
+}
+
+// collectionError maps collection service errors to HTTP errors
+func collectionError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrCollectionNotFound),
+		errors.Is(err, services.ErrCollectionGist):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrCollectionName),
+		errors.Is(err, services.ErrCollectionDescription),
+		errors.Is(err, services.ErrCollectionOrder):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrCollectionNameTaken),
+		errors.Is(err, services.ErrCollectionLimit),
+		errors.Is(err, services.ErrCollectionFull),
+		errors.Is(err, services.ErrPinLimit):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update collections")
+	}
+}
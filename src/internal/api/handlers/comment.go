@@ -0,0 +1,265 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/markup"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// ParamComment names the comment ID in comment routes
+const ParamComment = "commentID"
+
+// CommentHandler handles gist comments, replies and reactions
+type CommentHandler struct {
+	comments *services.CommentService
+	markup   *markup.Renderer
+	notifier *notifications.Service
+}
+
+// NewCommentHandler creates a new comment handler. emailService may be nil,
+// in which case no notification emails are sent.
+func NewCommentHandler(db *gorm.DB, config *config.Config, emailService *email.Service) *CommentHandler {
+	return &CommentHandler{
+		comments: services.NewCommentService(db, config, emailService),
+		markup:   markup.NewRenderer(db, config),
+		notifier: notifications.NewService(db),
+	}
+}
+
+// CreateCommentRequest is the body of a new comment or reply
+type CreateCommentRequest struct {
+	Content         string     `json:"content"`
+	ParentCommentID *uuid.UUID `json:"parent_comment_id,omitempty"`
+}
+
+// UpdateCommentRequest is the body of a comment edit
+type UpdateCommentRequest struct {
+	Content string `json:"content"`
+}
+
+// ReactionRequest names the reaction to add
+type ReactionRequest struct {
+	Reaction string `json:"reaction"`
+}
+
+// RegisterRoutes registers the comment routes under /gists on an API group.
+// Reading comments takes an optional session; everything else needs one.
+func (h *CommentHandler) RegisterRoutes(g *echo.Group, auth, optionalAuth echo.MiddlewareFunc) {
+	g.GET("/gists/:"+urls.ParamGist+"/comments", h.List, optionalAuth)
+	g.POST("/gists/:"+urls.ParamGist+"/comments", h.Create, auth)
+	g.PATCH("/gists/:"+urls.ParamGist+"/comments/:"+ParamComment, h.Update, auth)
+	g.PUT("/gists/:"+urls.ParamGist+"/comments/:"+ParamComment, h.Update, auth)
+	g.DELETE("/gists/:"+urls.ParamGist+"/comments/:"+ParamComment, h.Delete, auth)
+	g.POST("/gists/:"+urls.ParamGist+"/comments/:"+ParamComment+"/reactions", h.AddReaction, auth)
+	g.DELETE("/gists/:"+urls.ParamGist+"/comments/:"+ParamComment+"/reactions/:reaction", h.RemoveReaction, auth)
+}
+
+// List returns a gist's comments as one-level threads
+func (h *CommentHandler) List(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	var userID *uuid.UUID
+	if id, ok := c.Get("user_id").(uuid.UUID); ok {
+		userID = &id
+	}
+
+	comments, total, err := h.comments.ListComments(gistID, userID)
+	if err != nil {
+		return commentError(err)
+	}
+	h.render(comments)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"comments":  comments,
+		"total":     total,
+		"reactions": models.CommentReactions,
+	})
+}
+
+// Create adds a comment, or a reply when parent_comment_id is set
+func (h *CommentHandler) Create(c echo.Context) error {
+	gistID, userID, err := commentRequestIDs(c)
+	if err != nil {
+		return err
+	}
+	var req CreateCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	comment, err := h.comments.CreateComment(gistID, userID, services.CreateCommentInput{
+		Content:         req.Content,
+		ParentCommentID: req.ParentCommentID,
+	})
+	if err != nil {
+		return commentError(err)
+	}
+	comment.ContentHTML = h.markup.Render(comment.Content)
+	h.publish(notifications.EventCommentCreated, comment)
+
+	return c.JSON(http.StatusCreated, comment)
+}
+
+// Update changes the content of the user's own comment
+func (h *CommentHandler) Update(c echo.Context) error {
+	gistID, userID, err := commentRequestIDs(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := uuid.Parse(c.Param(ParamComment))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+	var req UpdateCommentRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	comment, err := h.comments.UpdateComment(gistID, commentID, userID, req.Content)
+	if err != nil {
+		return commentError(err)
+	}
+	comment.ContentHTML = h.markup.Render(comment.Content)
+	h.publish(notifications.EventCommentUpdated, comment)
+
+	return c.JSON(http.StatusOK, comment)
+}
+
+// Delete removes a comment and its replies
+func (h *CommentHandler) Delete(c echo.Context) error {
+	gistID, userID, err := commentRequestIDs(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := uuid.Parse(c.Param(ParamComment))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+
+	if err := h.comments.DeleteComment(gistID, commentID, userID); err != nil {
+		return commentError(err)
+	}
+	h.notifier.PublishComment(gistID, notifications.EventCommentDeleted, map[string]uuid.UUID{
+		"id": commentID,
+	})
+	return c.NoContent(http.StatusNoContent)
+}
+
+// AddReaction reacts to a comment and returns its new counts
+func (h *CommentHandler) AddReaction(c echo.Context) error {
+	gistID, userID, err := commentRequestIDs(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := uuid.Parse(c.Param(ParamComment))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+	var req ReactionRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	comment, err := h.comments.AddReaction(gistID, commentID, userID, req.Reaction)
+	if err != nil {
+		return commentError(err)
+	}
+	h.publishReactions(comment)
+	return c.JSON(http.StatusOK, reactionResponse(comment))
+}
+
+// RemoveReaction takes back a reaction and returns the new counts
+func (h *CommentHandler) RemoveReaction(c echo.Context) error {
+	gistID, userID, err := commentRequestIDs(c)
+	if err != nil {
+		return err
+	}
+	commentID, err := uuid.Parse(c.Param(ParamComment))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid comment ID")
+	}
+
+	comment, err := h.comments.RemoveReaction(gistID, commentID, userID, c.Param("reaction"))
+	if err != nil {
+		return commentError(err)
+	}
+	h.publishReactions(comment)
+	return c.JSON(http.StatusOK, reactionResponse(comment))
+}
+
+// render fills in the markdown of comments and their replies
+func (h *CommentHandler) render(comments []models.GistComment) {
+	for i := range comments {
+		comments[i].ContentHTML = h.markup.Render(comments[i].Content)
+		h.render(comments[i].Replies)
+	}
+}
+
+// publish pushes a new or edited comment to the streams watching its gist.
+// What the acting user reacted with is theirs alone.
+func (h *CommentHandler) publish(eventType string, comment *models.GistComment) {
+	shared := *comment
+	shared.ViewerReactions = nil
+	h.notifier.PublishComment(comment.GistID, eventType, &shared)
+}
+
+// publishReactions pushes a comment's new reaction counts to the streams
+// watching its gist
+func (h *CommentHandler) publishReactions(comment *models.GistComment) {
+	h.notifier.PublishComment(comment.GistID, notifications.EventCommentReactions, map[string]interface{}{
+		"comment_id": comment.ID,
+		"reactions":  comment.Reactions,
+	})
+}
+
+func reactionResponse(comment *models.GistComment) map[string]interface{} {
+	return map[string]interface{}{
+		"comment_id":       comment.ID,
+		"reactions":        comment.Reactions,
+		"viewer_reactions": comment.ViewerReactions,
+	}
+}
+
+// commentRequestIDs returns the gist of the route and the signed in user
+func commentRequestIDs(c echo.Context) (uuid.UUID, uuid.UUID, error) {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return uuid.Nil, uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return uuid.Nil, uuid.Nil, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	return gistID, userID, nil
+}
+
+// commentError maps comment service errors to HTTP errors
+func commentError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrCommentGistNotFound),
+		errors.Is(err, services.ErrCommentNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrCommentNotAuthor),
+		errors.Is(err, services.ErrCommentDeleteDenied):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrCommentInvalid),
+		errors.Is(err, services.ErrCommentParentInvalid),
+		errors.Is(err, services.ErrReactionInvalid):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to process comment")
+	}
+}
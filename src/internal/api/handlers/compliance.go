@@ -2,41 +2,53 @@ package handlers
 
 import (
 	"fmt"
+	"log/slog"
 	"net/http"
 	"strings"
 	"time"
 
 	"github.com/casapps/casgists/src/internal/compliance"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/objectstore"
+	"github.com/casapps/casgists/src/internal/services"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // ComplianceHandler handles compliance-related endpoints
 type ComplianceHandler struct {
 	db          *gorm.DB
-	config      *viper.Viper
+	config      *config.Config
 	gdprService *compliance.GDPRService
 	auditService *compliance.AuditService
+	gistDisposition *services.GistDispositionService
 }
 
 // NewComplianceHandler creates a new compliance handler
-func NewComplianceHandler(db *gorm.DB, config *viper.Viper) *ComplianceHandler {
+func NewComplianceHandler(db *gorm.DB, config *config.Config) *ComplianceHandler {
 	exportDir := config.GetString("paths.gdpr_exports")
 	if exportDir == "" {
 		exportDir = "./data/gdpr_exports"
 	}
 	
+	// Exports move to object storage when it is remote
+	store, err := objectstore.NewRemote(config)
+	if err != nil {
+		slog.Warn("Failed to open object storage, GDPR exports stay on local disk", "dir", exportDir, "error", err)
+	}
+
 	auditService := compliance.NewAuditService(db)
-	gdprService := compliance.NewGDPRService(db, exportDir, auditService)
+	gistDisposition := services.NewGistDispositionService(db, config)
+	gdprService := compliance.NewGDPRService(db, exportDir, store, auditService, gistDisposition)
 	
 	return &ComplianceHandler{
-		db:           db,
-		config:       config,
-		gdprService:  gdprService,
-		auditService: auditService,
+		db:              db,
+		config:          config,
+		gdprService:     gdprService,
+		auditService:    auditService,
+		gistDisposition: gistDisposition,
 	}
 }
 
@@ -122,11 +134,19 @@ func (h *ComplianceHandler) DownloadExport(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusGone, "Export has expired")
 	}
 
+	content, modTime, err := h.gdprService.OpenExport(c.Request().Context(), exportRequest)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Export file not found")
+	}
+	defer content.Close()
+
 	// Set headers for download
+	filename := fmt.Sprintf("casgists-data-export-%s.zip", userID.String()[:8])
 	c.Response().Header().Set("Content-Type", "application/zip")
-	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"casgists-data-export-%s.zip\"", userID.String()[:8]))
+	c.Response().Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", filename))
 
-	return c.File(exportRequest.ExportFilePath)
+	http.ServeContent(c.Response(), c.Request(), filename, modTime, content)
+	return nil
 }
 
 // RequestDataDeletion handles GDPR data deletion requests
@@ -139,9 +159,11 @@ func (h *ComplianceHandler) RequestDataDeletion(c echo.Context) error {
 
 	// Parse request
 	var req struct {
-		Reason   string `json:"reason" validate:"required,min=10"`
-		Confirm  bool   `json:"confirm" validate:"required"`
-		Password string `json:"password" validate:"required"`
+		Reason         string     `json:"reason" validate:"required,min=10"`
+		Confirm        bool       `json:"confirm" validate:"required"`
+		Password       string     `json:"password" validate:"required"`
+		GistPolicy     string     `json:"gist_policy"`
+		OrganizationID *uuid.UUID `json:"organization_id"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -159,21 +181,45 @@ func (h *ComplianceHandler) RequestDataDeletion(c echo.Context) error {
 	// TODO: Verify password
 	// This would require injecting auth service
 
+	// What happens to the user's public gists; empty means the instance default
+	disposition, err := h.gistDisposition.Resolve(userID, req.GistPolicy, req.OrganizationID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	// Get client IP
 	clientIP := c.RealIP()
 
 	// Create deletion request
-	deletionRequest, err := h.gdprService.RequestDataDeletion(userID, req.Reason, clientIP)
+	deletionRequest, err := h.gdprService.RequestDataDeletion(userID, req.Reason, clientIP, disposition)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"deletion_request": deletionRequest,
+		"gist_policy":      disposition,
 		"message": "Your data deletion request has been submitted. Your account and all associated data will be permanently deleted within 30 days.",
 	})
 }
 
+// GetDeletionOptions returns the choices for the user's public gists, to
+// show before they request deletion of their account
+func (h *ComplianceHandler) GetDeletionOptions(c echo.Context) error {
+	// Get current user ID from context
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	options, err := h.gistDisposition.Options(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load deletion options")
+	}
+
+	return c.JSON(http.StatusOK, options)
+}
+
 // GetDeletionRequests returns user's deletion requests
 func (h *ComplianceHandler) GetDeletionRequests(c echo.Context) error {
 	// Get current user ID from context
@@ -334,6 +380,7 @@ func (h *ComplianceHandler) RegisterRoutes(g *echo.Group) {
 	g.GET("/compliance/gdpr/exports", h.GetExportRequests)
 	g.GET("/compliance/gdpr/export/:id/download", h.DownloadExport)
 	
+	g.GET("/compliance/gdpr/delete/options", h.GetDeletionOptions)
 	g.POST("/compliance/gdpr/delete", h.RequestDataDeletion)
 	g.GET("/compliance/gdpr/deletions", h.GetDeletionRequests)
 	
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// ConfigHandler reloads the configuration file (admin only)
+type ConfigHandler struct {
+	reloader *config.Reloader
+}
+
+// NewConfigHandler creates a new config handler
+func NewConfigHandler(reloader *config.Reloader) *ConfigHandler {
+	return &ConfigHandler{reloader: reloader}
+}
+
+// Reload reads the config file again and applies it, as SIGHUP does. The
+// response lists the settings that changed and those that need a restart.
+func (h *ConfigHandler) Reload(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	result, err := h.reloader.Reload()
+	if errors.Is(err, config.ErrNoConfigFile) {
+		return echo.NewHTTPError(http.StatusConflict, "there is no config file to reload")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	config.LogReload(result)
+	return c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,250 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/domains"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// CustomDomainHandler lets users and organization admins serve their
+// profile and public gists on their own domains
+type CustomDomainHandler struct {
+	db      *gorm.DB
+	domains *domains.Service
+	policy  *services.OrgPolicyService
+}
+
+// NewCustomDomainHandler creates a new custom domain handler
+func NewCustomDomainHandler(db *gorm.DB, service *domains.Service) *CustomDomainHandler {
+	return &CustomDomainHandler{
+		db:      db,
+		domains: service,
+		policy:  services.NewOrgPolicyService(db),
+	}
+}
+
+// CustomDomainResponse describes a custom domain
+type CustomDomainResponse struct {
+	ID           uuid.UUID                         `json:"id"`
+	Domain       string                            `json:"domain"`
+	Verified     bool                              `json:"verified"`
+	VerifiedAt   *time.Time                        `json:"verified_at,omitempty"`
+	SSLEnabled   bool                              `json:"ssl_enabled"`
+	SSLExpiresAt *time.Time                        `json:"ssl_expires_at,omitempty"`
+	CreatedAt    time.Time                         `json:"created_at"`
+	Verification *domains.VerificationInstructions `json:"verification,omitempty"` // Until the domain is verified
+}
+
+// RegisterRoutes registers the custom domain routes of users and
+// organizations. auth must authenticate the request.
+func (h *CustomDomainHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc) {
+	for _, prefix := range []string{"/user/domains", "/orgs/:name/domains"} {
+		g.GET(prefix, h.List, auth)
+		g.POST(prefix, h.Add, auth)
+		g.GET(prefix+"/:id", h.Get, auth)
+		g.POST(prefix+"/:id/verify", h.Verify, auth)
+		g.PUT(prefix+"/:id", h.Update, auth)
+		g.DELETE(prefix+"/:id", h.Remove, auth)
+	}
+}
+
+// List returns the domains of the user or organization
+func (h *CustomDomainHandler) List(c echo.Context) error {
+	userID, org, err := h.owner(c)
+	if err != nil {
+		return err
+	}
+
+	var list []models.CustomDomain
+	if org != nil {
+		list, err = h.domains.GetOrganizationDomains(org.ID)
+	} else {
+		list, err = h.domains.GetUserDomains(*userID)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch domains")
+	}
+
+	response := make([]CustomDomainResponse, 0, len(list))
+	for i := range list {
+		list[i].Organization = org
+		response = append(response, h.newResponse(&list[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"domains": response,
+	})
+}
+
+// Add adds a domain, to be verified with the DNS record in the response
+func (h *CustomDomainHandler) Add(c echo.Context) error {
+	userID, org, err := h.owner(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		Domain string `json:"domain"`
+	}
+	if err := c.Bind(&req); err != nil || req.Domain == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "domain is required")
+	}
+
+	var domain *models.CustomDomain
+	if org != nil {
+		domain, err = h.domains.AddCustomDomain(req.Domain, nil, &org.ID)
+	} else {
+		domain, err = h.domains.AddCustomDomain(req.Domain, userID, nil)
+	}
+	if err != nil {
+		return customDomainError(err)
+	}
+	domain.Organization = org
+	return c.JSON(http.StatusCreated, h.newResponse(domain))
+}
+
+// Get returns a domain
+func (h *CustomDomainHandler) Get(c echo.Context) error {
+	domain, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	return c.JSON(http.StatusOK, h.newResponse(domain))
+}
+
+// Verify looks up the domain's TXT record and marks it verified when the
+// record holds the token
+func (h *CustomDomainHandler) Verify(c echo.Context) error {
+	domain, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	if err := h.domains.VerifyDomain(domain); err != nil {
+		return customDomainError(err)
+	}
+	return c.JSON(http.StatusOK, h.newResponse(domain))
+}
+
+// Update turns HTTPS on or off for a verified domain
+func (h *CustomDomainHandler) Update(c echo.Context) error {
+	domain, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		SSLEnabled *bool `json:"ssl_enabled"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request")
+	}
+	if req.SSLEnabled != nil {
+		if err := h.domains.SetSSL(domain, *req.SSLEnabled); err != nil {
+			return customDomainError(err)
+		}
+	}
+	return c.JSON(http.StatusOK, h.newResponse(domain))
+}
+
+// Remove removes a domain
+func (h *CustomDomainHandler) Remove(c echo.Context) error {
+	domain, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	if err := h.domains.RemoveDomain(domain); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to remove domain")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// owner returns whose domains the request is for: the signed in user, or
+// the organization in the path, which only its owners and admins manage
+func (h *CustomDomainHandler) owner(c echo.Context) (*uuid.UUID, *models.Organization, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	name := c.Param("name")
+	if name == "" {
+		return &userID, nil, nil
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	org, err := h.policy.FindOrganization(name)
+	if err != nil {
+		return nil, nil, orgPolicyError(err)
+	}
+	if err := h.policy.AuthorizeAdmin(org.ID, &user); err != nil {
+		return nil, nil, orgPolicyError(err)
+	}
+	return &userID, org, nil
+}
+
+// load returns the domain in the path, if it belongs to the owner
+func (h *CustomDomainHandler) load(c echo.Context) (*models.CustomDomain, error) {
+	userID, org, err := h.owner(c)
+	if err != nil {
+		return nil, err
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "domain not found")
+	}
+
+	var domain *models.CustomDomain
+	if org != nil {
+		domain, err = h.domains.GetDomain(id, nil, &org.ID)
+	} else {
+		domain, err = h.domains.GetDomain(id, userID, nil)
+	}
+	if err != nil {
+		return nil, customDomainError(err)
+	}
+	domain.Organization = org
+	return domain, nil
+}
+
+func (h *CustomDomainHandler) newResponse(domain *models.CustomDomain) CustomDomainResponse {
+	response := CustomDomainResponse{
+		ID:           domain.ID,
+		Domain:       domain.Domain,
+		Verified:     domain.Verified,
+		VerifiedAt:   domain.VerifiedAt,
+		SSLEnabled:   domain.SSLEnabled,
+		SSLExpiresAt: domain.SSLExpiresAt,
+		CreatedAt:    domain.CreatedAt,
+	}
+	if !domain.Verified {
+		response.Verification = h.domains.GetVerificationInstructions(domain)
+	}
+	return response
+}
+
+// customDomainError maps custom domain errors to HTTP errors
+func customDomainError(err error) error {
+	switch {
+	case errors.Is(err, domains.ErrDomainNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, domains.ErrDomainsDisabled):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, domains.ErrDomainInvalid),
+		errors.Is(err, domains.ErrDomainNotVerified),
+		errors.Is(err, domains.ErrDomainVerification):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, domains.ErrDomainTaken):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update domain")
+	}
+}
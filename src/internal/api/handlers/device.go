@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// DeviceAuthHandler implements the OAuth 2.0 device authorization grant
+// (RFC 8628) so the CLI can log in through the browser instead of asking
+// for a password or a long-lived token on the command line
+type DeviceAuthHandler struct {
+	db          *gorm.DB
+	authService *auth.AuthService
+	config      *config.Config
+}
+
+// NewDeviceAuthHandler creates a new device authorization handler
+func NewDeviceAuthHandler(db *gorm.DB, authService *auth.AuthService, config *config.Config) *DeviceAuthHandler {
+	return &DeviceAuthHandler{
+		db:          db,
+		authService: authService,
+		config:      config,
+	}
+}
+
+// DeviceCodeResponse is returned when a CLI starts the device flow
+type DeviceCodeResponse struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// DeviceAuthorizationResponse describes a pending device login to the approving user
+type DeviceAuthorizationResponse struct {
+	UserCode   string    `json:"user_code"`
+	ClientName string    `json:"client_name"`
+	IPAddress  string    `json:"ip_address"`
+	Status     string    `json:"status"`
+	CreatedAt  time.Time `json:"created_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+}
+
+// RequestCode starts a device login and returns the codes the CLI shows the user
+func (h *DeviceAuthHandler) RequestCode(c echo.Context) error {
+	if !h.config.GetBool("security.device_flow.enabled") {
+		return echo.NewHTTPError(http.StatusNotFound, "device login is disabled")
+	}
+
+	var req struct {
+		ClientName string `json:"client_name"`
+	}
+	c.Bind(&req)
+
+	clientName := strings.TrimSpace(req.ClientName)
+	if clientName == "" {
+		clientName = "casgists-cli"
+	}
+	if len(clientName) > 100 {
+		clientName = clientName[:100]
+	}
+
+	deviceCode, err := auth.GenerateDeviceCode()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate device code")
+	}
+	userCode, err := auth.GenerateUserCode()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate user code")
+	}
+
+	codeTTL := h.codeTTL()
+	authorization := models.DeviceAuthorization{
+		DeviceCodeHash: auth.HashToken(deviceCode),
+		UserCode:       userCode,
+		ClientName:     clientName,
+		Status:         models.DeviceAuthPending,
+		IPAddress:      c.RealIP(),
+		ExpiresAt:      time.Now().Add(codeTTL),
+	}
+	if err := h.db.Create(&authorization).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create device authorization")
+	}
+
+	verificationURI := h.baseURL(c) + "/device"
+	return c.JSON(http.StatusOK, DeviceCodeResponse{
+		DeviceCode:              deviceCode,
+		UserCode:                userCode,
+		VerificationURI:         verificationURI,
+		VerificationURIComplete: verificationURI + "?user_code=" + userCode,
+		ExpiresIn:               int(codeTTL.Seconds()),
+		Interval:                int(h.pollInterval().Seconds()),
+	})
+}
+
+// PollToken is polled by the CLI until the user approves or denies the login.
+// Errors use the RFC 8628 error codes so standard clients understand them.
+func (h *DeviceAuthHandler) PollToken(c echo.Context) error {
+	var req struct {
+		DeviceCode string `json:"device_code"`
+	}
+	if err := c.Bind(&req); err != nil || req.DeviceCode == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "device_code is required")
+	}
+
+	var authorization models.DeviceAuthorization
+	if err := h.db.Where("device_code_hash = ?", auth.HashToken(req.DeviceCode)).First(&authorization).Error; err != nil {
+		return deviceError(c, "invalid_grant")
+	}
+
+	if authorization.IsExpired() {
+		return deviceError(c, "expired_token")
+	}
+
+	// Enforce the polling interval
+	now := time.Now()
+	if authorization.LastPolledAt != nil && now.Sub(*authorization.LastPolledAt) < h.pollInterval() {
+		h.db.Model(&authorization).Update("last_polled_at", now)
+		return deviceError(c, "slow_down")
+	}
+	h.db.Model(&authorization).Update("last_polled_at", now)
+
+	switch authorization.Status {
+	case models.DeviceAuthPending:
+		return deviceError(c, "authorization_pending")
+	case models.DeviceAuthDenied:
+		return deviceError(c, "access_denied")
+	case models.DeviceAuthConsumed:
+		return deviceError(c, "invalid_grant")
+	}
+
+	// Approved: consume the grant exactly once
+	result := h.db.Model(&models.DeviceAuthorization{}).
+		Where("id = ? AND status = ?", authorization.ID, models.DeviceAuthApproved).
+		Update("status", models.DeviceAuthConsumed)
+	if result.Error != nil || result.RowsAffected == 0 || authorization.UserID == nil {
+		return deviceError(c, "invalid_grant")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", *authorization.UserID).Error; err != nil {
+		return deviceError(c, "invalid_grant")
+	}
+	if !user.IsActive {
+		return deviceError(c, "access_denied")
+	}
+
+	// CLI sessions are short-lived; the access token itself expires after
+	// 15 minutes and is renewed with the refresh token
+	session := &models.Session{
+		UserID:     user.ID,
+		IPAddress:  c.RealIP(),
+		UserAgent:  "device:" + authorization.ClientName,
+		ExpiresAt:  time.Now().Add(h.sessionTTL()),
+		LastUsedAt: time.Now(),
+	}
+	if err := h.db.Create(session).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create session")
+	}
+
+	tokenPair, err := h.authService.GenerateTokenPair(&user, session.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate tokens")
+	}
+
+	session.Token = tokenPair.AccessToken
+	session.RefreshToken = tokenPair.RefreshToken
+	if err := h.db.Save(session).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session")
+	}
+
+	return c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
+		ExpiresAt:    tokenPair.ExpiresAt,
+		User: &UserResponse{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+			IsAdmin:     user.IsAdmin,
+		},
+	})
+}
+
+// Lookup returns a pending device login so the user can confirm it is theirs
+func (h *DeviceAuthHandler) Lookup(c echo.Context) error {
+	authorization, err := h.findPending(c.QueryParam("user_code"))
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(http.StatusOK, DeviceAuthorizationResponse{
+		UserCode:   authorization.UserCode,
+		ClientName: authorization.ClientName,
+		IPAddress:  authorization.IPAddress,
+		Status:     authorization.Status,
+		CreatedAt:  authorization.CreatedAt,
+		ExpiresAt:  authorization.ExpiresAt,
+	})
+}
+
+// Approve approves or denies a pending device login for the current user
+func (h *DeviceAuthHandler) Approve(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		UserCode string `json:"user_code"`
+		Approve  bool   `json:"approve"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	authorization, err := h.findPending(req.UserCode)
+	if err != nil {
+		return err
+	}
+
+	status := models.DeviceAuthDenied
+	if req.Approve {
+		status = models.DeviceAuthApproved
+	}
+
+	if err := h.db.Model(authorization).Updates(map[string]interface{}{
+		"status":  status,
+		"user_id": userID,
+	}).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update device authorization")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"status": status,
+	})
+}
+
+// findPending loads an unexpired, still-pending authorization by user code
+func (h *DeviceAuthHandler) findPending(userCode string) (*models.DeviceAuthorization, error) {
+	userCode = auth.NormalizeUserCode(userCode)
+	if userCode == "" {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "user_code is required")
+	}
+
+	var authorization models.DeviceAuthorization
+	if err := h.db.Where("user_code = ?", userCode).First(&authorization).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusNotFound, "unknown code")
+	}
+	if authorization.IsExpired() {
+		return nil, echo.NewHTTPError(http.StatusGone, "code has expired")
+	}
+	if authorization.Status != models.DeviceAuthPending {
+		return nil, echo.NewHTTPError(http.StatusConflict, "code has already been used")
+	}
+
+	return &authorization, nil
+}
+
+func (h *DeviceAuthHandler) baseURL(c echo.Context) string {
+	if url := strings.TrimSuffix(h.config.GetString("server.url"), "/"); url != "" {
+		return url
+	}
+	return fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+}
+
+func (h *DeviceAuthHandler) codeTTL() time.Duration {
+	if ttl := h.config.GetDuration("security.device_flow.code_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 15 * time.Minute
+}
+
+func (h *DeviceAuthHandler) pollInterval() time.Duration {
+	if interval := h.config.GetDuration("security.device_flow.poll_interval"); interval >= time.Second {
+		return interval
+	}
+	return 5 * time.Second
+}
+
+func (h *DeviceAuthHandler) sessionTTL() time.Duration {
+	if ttl := h.config.GetDuration("security.device_flow.session_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 7 * 24 * time.Hour
+}
+
+// deviceError writes an RFC 8628 token endpoint error
+func deviceError(c echo.Context, code string) error {
+	return c.JSON(http.StatusBadRequest, map[string]string{
+		"error": code,
+	})
+}
@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupDeviceTest(t *testing.T) (*DeviceAuthHandler, *gorm.DB, *models.User) {
+	_, db, cfg := setupGistHandlerTest(t)
+	require.NoError(t, db.AutoMigrate(&models.DeviceAuthorization{}, &models.Session{}))
+	cfg.Set("security.device_flow.enabled", true)
+	cfg.Set("server.url", "https://gists.example.com")
+
+	user := &models.User{Username: "gopher", Email: "gopher@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	return NewDeviceAuthHandler(db, auth.NewAuthService("test-secret", "casgists"), cfg), db, user
+}
+
+// callDevice calls a device flow handler, as user when it isn't nil, and
+// returns the status and the decoded response
+func callDevice(t *testing.T, handler echo.HandlerFunc, user *models.User, method, target, body string) (int, map[string]interface{}) {
+	req := httptest.NewRequest(method, target, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	if user != nil {
+		c.Set("user_id", user.ID)
+	}
+	if err := handler(c); err != nil {
+		he, ok := err.(*echo.HTTPError)
+		require.True(t, ok, err)
+		return he.Code, nil
+	}
+	var response map[string]interface{}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	return rec.Code, response
+}
+
+// startDeviceLogin requests codes and returns the device and user codes
+func startDeviceLogin(t *testing.T, h *DeviceAuthHandler) (string, string) {
+	status, response := callDevice(t, h.RequestCode, nil, http.MethodPost, "/api/v1/auth/device/code", `{"client_name":"test-cli"}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "https://gists.example.com/device", response["verification_uri"])
+	return response["device_code"].(string), response["user_code"].(string)
+}
+
+// pollDeviceToken polls for the token as the CLI does, first clearing the last poll
+// so the interval doesn't apply
+func pollDeviceToken(t *testing.T, h *DeviceAuthHandler, db *gorm.DB, deviceCode string) (int, map[string]interface{}) {
+	require.NoError(t, db.Model(&models.DeviceAuthorization{}).
+		Where("device_code_hash = ?", auth.HashToken(deviceCode)).Update("last_polled_at", nil).Error)
+	return callDevice(t, h.PollToken, nil, http.MethodPost, "/api/v1/auth/device/token", `{"device_code":"`+deviceCode+`"}`)
+}
+
+func TestDeviceFlowApprove(t *testing.T) {
+	h, db, user := setupDeviceTest(t)
+	deviceCode, userCode := startDeviceLogin(t, h)
+
+	_, response := pollDeviceToken(t, h, db, deviceCode)
+	assert.Equal(t, "authorization_pending", response["error"])
+
+	// The user types the code in any case and without the dash
+	typed := strings.ToLower(strings.ReplaceAll(userCode, "-", " "))
+	status, response := callDevice(t, h.Lookup, user, http.MethodGet, "/api/v1/auth/device?user_code="+url.QueryEscape(typed), "")
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, "test-cli", response["client_name"])
+
+	status, response = callDevice(t, h.Approve, user, http.MethodPost, "/api/v1/auth/device/approve",
+		`{"user_code":"`+typed+`","approve":true}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.DeviceAuthApproved, response["status"])
+
+	status, response = pollDeviceToken(t, h, db, deviceCode)
+	require.Equal(t, http.StatusOK, status)
+	assert.NotEmpty(t, response["access_token"])
+	assert.NotEmpty(t, response["refresh_token"])
+
+	var sessions int64
+	db.Model(&models.Session{}).Where("user_id = ? AND user_agent = ?", user.ID, "device:test-cli").Count(&sessions)
+	assert.Equal(t, int64(1), sessions)
+
+	// The grant is consumed by the first poll that gets it
+	_, response = pollDeviceToken(t, h, db, deviceCode)
+	assert.Equal(t, "invalid_grant", response["error"])
+	db.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&sessions)
+	assert.Equal(t, int64(1), sessions)
+
+	// And its code can't be approved again
+	status, _ = callDevice(t, h.Approve, user, http.MethodPost, "/api/v1/auth/device/approve",
+		`{"user_code":"`+userCode+`","approve":true}`)
+	assert.Equal(t, http.StatusConflict, status)
+}
+
+func TestDeviceFlowDeny(t *testing.T) {
+	h, db, user := setupDeviceTest(t)
+	deviceCode, userCode := startDeviceLogin(t, h)
+
+	status, response := callDevice(t, h.Approve, user, http.MethodPost, "/api/v1/auth/device/approve",
+		`{"user_code":"`+userCode+`","approve":false}`)
+	require.Equal(t, http.StatusOK, status)
+	assert.Equal(t, models.DeviceAuthDenied, response["status"])
+
+	_, response = pollDeviceToken(t, h, db, deviceCode)
+	assert.Equal(t, "access_denied", response["error"])
+}
+
+func TestDeviceFlowSlowDown(t *testing.T) {
+	h, db, _ := setupDeviceTest(t)
+	deviceCode, _ := startDeviceLogin(t, h)
+
+	_, response := pollDeviceToken(t, h, db, deviceCode)
+	assert.Equal(t, "authorization_pending", response["error"])
+
+	// Polling again within the interval is refused, and counts as a poll
+	body := `{"device_code":"` + deviceCode + `"}`
+	_, response = callDevice(t, h.PollToken, nil, http.MethodPost, "/api/v1/auth/device/token", body)
+	assert.Equal(t, "slow_down", response["error"])
+	_, response = callDevice(t, h.PollToken, nil, http.MethodPost, "/api/v1/auth/device/token", body)
+	assert.Equal(t, "slow_down", response["error"])
+}
+
+func TestDeviceFlowExpiry(t *testing.T) {
+	h, db, user := setupDeviceTest(t)
+	deviceCode, userCode := startDeviceLogin(t, h)
+	require.NoError(t, db.Model(&models.DeviceAuthorization{}).Where("user_code = ?", userCode).
+		Update("expires_at", time.Now().Add(-time.Minute)).Error)
+
+	_, response := pollDeviceToken(t, h, db, deviceCode)
+	assert.Equal(t, "expired_token", response["error"])
+
+	status, _ := callDevice(t, h.Lookup, user, http.MethodGet, "/api/v1/auth/device?user_code="+userCode, "")
+	assert.Equal(t, http.StatusGone, status)
+	status, _ = callDevice(t, h.Approve, user, http.MethodPost, "/api/v1/auth/device/approve",
+		`{"user_code":"`+userCode+`","approve":true}`)
+	assert.Equal(t, http.StatusGone, status)
+}
+
+func TestDeviceFlowUnknownCodes(t *testing.T) {
+	h, _, user := setupDeviceTest(t)
+
+	_, response := callDevice(t, h.PollToken, nil, http.MethodPost, "/api/v1/auth/device/token", `{"device_code":"unknown"}`)
+	assert.Equal(t, "invalid_grant", response["error"])
+
+	status, _ := callDevice(t, h.Lookup, user, http.MethodGet, "/api/v1/auth/device?user_code=BCDF-GHJK", "")
+	assert.Equal(t, http.StatusNotFound, status)
+
+	status, _ = callDevice(t, h.Approve, user, http.MethodPost, "/api/v1/auth/device/approve", `{}`)
+	assert.Equal(t, http.StatusBadRequest, status)
+}
+
+func TestDeviceFlowDisabled(t *testing.T) {
+	h, _, _ := setupDeviceTest(t)
+	h.config.Set("security.device_flow.enabled", false)
+
+	status, _ := callDevice(t, h.RequestCode, nil, http.MethodPost, "/api/v1/auth/device/code", `{}`)
+	assert.Equal(t, http.StatusNotFound, status)
+}
@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"net/http"
+	"net/mail"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// EmailHandler handles email preference and delivery endpoints
+type EmailHandler struct {
+	db      *gorm.DB
+	config  *config.Config
+	service *email.Service
+}
+
+// NewEmailHandler creates a new email handler
+func NewEmailHandler(db *gorm.DB, config *config.Config, service *email.Service) *EmailHandler {
+	return &EmailHandler{
+		db:      db,
+		config:  config,
+		service: service,
+	}
+}
+
+// emailPreferenceKeys are the preferences users can change
+var emailPreferenceKeys = map[string]bool{
+	"notify_gist_starred":   true,
+	"notify_gist_forked":    true,
+	"notify_gist_commented": true,
+	"notify_followed":       true,
+	"notify_weekly_digest":  true,
+	"notify_system_alerts":  true,
+	"digest_notifications":  true,
+}
+
+// GetPreferences returns the user's email notification preferences
+func (h *EmailHandler) GetPreferences(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	preference, err := h.service.GetEmailPreference(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch email preferences")
+	}
+	return c.JSON(http.StatusOK, preference)
+}
+
+// UpdatePreferences changes the preferences present in the body and
+// leaves the rest alone
+func (h *EmailHandler) UpdatePreferences(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var updates map[string]bool
+	if err := c.Bind(&updates); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	for key := range updates {
+		if !emailPreferenceKeys[key] {
+			return echo.NewHTTPError(http.StatusBadRequest, "unknown preference: "+key)
+		}
+	}
+
+	if err := h.service.UpdateEmailPreference(userID, updates); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update email preferences")
+	}
+	return h.GetPreferences(c)
+}
+
+// SendTest sends a test email straight over SMTP, skipping the queue, so
+// an administrator sees at once whether the settings work. It goes to
+// the given address or else the administrator's own.
+func (h *EmailHandler) SendTest(c echo.Context) error {
+	if !h.config.GetBool("email.enabled") {
+		return echo.NewHTTPError(http.StatusConflict, "email is disabled")
+	}
+
+	var req struct {
+		To string `json:"to"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	to, name := req.To, ""
+	if to != "" {
+		if _, err := mail.ParseAddress(to); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid email address")
+		}
+	} else {
+		userID, _ := c.Get("user_id").(uuid.UUID)
+		var user models.User
+		if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+		}
+		to, name = user.Email, user.Username
+	}
+
+	if err := h.service.SendTestEmail(to, name); err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to send test email: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "test email sent",
+		"to":      to,
+	})
+}
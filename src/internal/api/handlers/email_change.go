@@ -0,0 +1,176 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// EmailChangeHandler handles account email change endpoints
+type EmailChangeHandler struct {
+	db      *gorm.DB
+	config  *config.Config
+	service *services.EmailChangeService
+}
+
+// NewEmailChangeHandler creates a new email change handler
+func NewEmailChangeHandler(db *gorm.DB, config *config.Config, emailService *email.Service) *EmailChangeHandler {
+	return &EmailChangeHandler{
+		db:      db,
+		config:  config,
+		service: services.NewEmailChangeService(db, config, emailService),
+	}
+}
+
+// EmailChangeResponse describes an email change request
+type EmailChangeResponse struct {
+	Status          string     `json:"status"`
+	OldEmail        string     `json:"old_email,omitempty"`
+	NewEmail        string     `json:"new_email"`
+	ExpiresAt       time.Time  `json:"expires_at"`
+	RevertExpiresAt *time.Time `json:"revert_expires_at,omitempty"`
+}
+
+// Request starts an email change for the current user
+func (h *EmailChangeHandler) Request(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	if err := c.Bind(&req); err != nil || req.Email == "" || req.Password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "email and password are required")
+	}
+
+	request, err := h.service.RequestChange(userID, req.Email, req.Password)
+	if err != nil {
+		return emailChangeError(err)
+	}
+
+	return c.JSON(http.StatusAccepted, newEmailChangeResponse(request))
+}
+
+// GetPending returns the current user's pending email change
+func (h *EmailChangeHandler) GetPending(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	request, err := h.service.GetPending(userID)
+	if err != nil {
+		return emailChangeError(err)
+	}
+
+	return c.JSON(http.StatusOK, newEmailChangeResponse(request))
+}
+
+// Cancel cancels the current user's pending email change
+func (h *EmailChangeHandler) Cancel(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	if err := h.service.CancelPending(userID); err != nil {
+		return emailChangeError(err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ConfirmPage is the link sent to the new address. It only shows a button
+// that posts to Confirm, so a mail scanner opening the link changes nothing.
+func (h *EmailChangeHandler) ConfirmPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "email_change", map[string]interface{}{
+		"Title":   "Confirm email change",
+		"Message": "Confirm that this is the new email address of your account.",
+		"Button":  "Confirm",
+		"Action":  "confirm",
+		"Token":   c.QueryParam("token"),
+	})
+}
+
+// RevertPage is the link sent to the old address, which posts to Revert
+// like ConfirmPage
+func (h *EmailChangeHandler) RevertPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "email_change", map[string]interface{}{
+		"Title":   "Revert email change",
+		"Message": "Restore this email address on your account and sign out everywhere. Only do this if you did not make the change.",
+		"Button":  "Revert",
+		"Action":  "revert",
+		"Token":   c.QueryParam("token"),
+	})
+}
+
+// Confirm applies an email change from the link sent to the new address
+func (h *EmailChangeHandler) Confirm(c echo.Context) error {
+	request, err := h.service.ConfirmChange(tokenParam(c))
+	if err != nil {
+		return emailChangeError(err)
+	}
+
+	return c.JSON(http.StatusOK, newEmailChangeResponse(request))
+}
+
+// Revert undoes an email change from the link sent to the old address
+func (h *EmailChangeHandler) Revert(c echo.Context) error {
+	request, err := h.service.RevertChange(tokenParam(c))
+	if err != nil {
+		return emailChangeError(err)
+	}
+
+	return c.JSON(http.StatusOK, newEmailChangeResponse(request))
+}
+
+// tokenParam reads the token from the query string (email links) or a JSON body
+func tokenParam(c echo.Context) string {
+	if token := c.QueryParam("token"); token != "" {
+		return token
+	}
+	var req struct {
+		Token string `json:"token"`
+	}
+	c.Bind(&req)
+	return req.Token
+}
+
+func newEmailChangeResponse(request *models.EmailChangeRequest) EmailChangeResponse {
+	return EmailChangeResponse{
+		Status:          request.Status,
+		OldEmail:        request.OldEmail,
+		NewEmail:        request.NewEmail,
+		ExpiresAt:       request.ExpiresAt,
+		RevertExpiresAt: request.RevertExpiresAt,
+	}
+}
+
+// emailChangeError maps service errors to HTTP errors
+func emailChangeError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrEmailChangeInvalidPassword):
+		return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+	case errors.Is(err, services.ErrEmailChangeInvalidEmail),
+		errors.Is(err, services.ErrEmailChangeSameEmail),
+		errors.Is(err, services.ErrEmailChangeInvalidToken):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrEmailChangeEmailTaken):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrEmailChangeNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to process email change")
+	}
+}
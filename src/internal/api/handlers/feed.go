@@ -0,0 +1,97 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// FeedHandler serves the signed in user's home timeline
+type FeedHandler struct {
+	db      *gorm.DB
+	service *services.FeedService
+	gists   *GistHandler
+}
+
+// NewFeedHandler creates a new feed handler
+func NewFeedHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *FeedHandler {
+	return &FeedHandler{
+		db:      db,
+		service: services.NewFeedService(db),
+		gists:   NewGistHandler(db, config, gitOps),
+	}
+}
+
+// FeedItemResponse is one entry of the feed
+type FeedItemResponse struct {
+	Type      string        `json:"type"`
+	Actor     *UserResponse `json:"actor"`
+	Gist      GistResponse  `json:"gist"`
+	CommentID *uuid.UUID    `json:"comment_id,omitempty"`
+	CreatedAt string        `json:"created_at"`
+}
+
+// Feed returns the newest activity of the users the signed in user
+// follows. ?before= (RFC 3339) continues from the previous page's
+// next_before.
+func (h *FeedHandler) Feed(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	var before time.Time
+	if raw := c.QueryParam("before"); raw != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, raw)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "before must be an RFC 3339 timestamp")
+		}
+		before = parsed
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > services.MaxFeedItems {
+		limit = 30
+	}
+
+	items, err := h.service.Feed(&user, before, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch feed")
+	}
+
+	response := make([]FeedItemResponse, 0, len(items))
+	for _, item := range items {
+		actor := item.Actor
+		response = append(response, FeedItemResponse{
+			Type: item.Type,
+			Actor: &UserResponse{
+				ID:          actor.ID,
+				Username:    actor.Username,
+				DisplayName: actor.DisplayName,
+				AvatarURL:   h.gists.images.URL(actor.AvatarURL),
+			},
+			Gist:      h.gists.buildGistResponse(&item.Gist, item.Gist.User),
+			CommentID: item.CommentID,
+			CreatedAt: h.gists.timestamp(item.CreatedAt),
+		})
+	}
+
+	result := map[string]interface{}{
+		"items": response,
+	}
+	if len(items) == limit {
+		result["next_before"] = items[len(items)-1].CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return c.JSON(http.StatusOK, result)
+}
@@ -1,12 +1,35 @@
 package handlers
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"path/filepath"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/api/middleware"
+	"github.com/casapps/casgists/src/internal/automation"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/contentpolicy"
+	"github.com/casapps/casgists/src/internal/counters"
+	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
-	"github.com/spf13/viper"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/formatting"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/imageproxy"
+	"github.com/casapps/casgists/src/internal/markup"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/scanning"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+	"github.com/casapps/casgists/src/internal/utils"
+	"github.com/casapps/casgists/src/internal/webhooks"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
@@ -15,32 +38,231 @@ import (
 // GistHandler handles gist-related endpoints
 type GistHandler struct {
 	db        *gorm.DB
-	config    *viper.Viper
+	config    *config.Config
 	gitOps    GitOperations
+	markup    *markup.Renderer
+
+	formatter *formatting.Runner // nil unless formatting hooks are enabled
+	quota     *services.QuotaService
+	policy    *contentpolicy.Checker
+	images    *imageproxy.Signer // nil when the image proxy is disabled
+	scanner   *scanning.Service  // nil unless upload scanning is enabled
+	webhooks  *webhooks.Service
+	notifier  *notifications.Service
+	shares    *services.GistShareService
+	trash     *services.GistTrashService
 }
 
 // GitOperations interface for git operations
 type GitOperations interface {
 	InitializeGistRepo(gist *models.Gist, files []models.GistFile, author *models.User) error
 	UpdateGistFiles(gist *models.Gist, files []models.GistFile, author *models.User, message string) error
+	ForkGistRepo(source, fork *models.Gist, files []models.GistFile, author *models.User) error
 	DeleteGistRepo(gist *models.Gist) error
 }
 
 // NewGistHandler creates a new gist handler
-func NewGistHandler(db *gorm.DB, config *viper.Viper, gitOps GitOperations) *GistHandler {
+func NewGistHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *GistHandler {
+	formatter, err := formatting.NewRunner(config)
+	if err != nil {
+		slog.Warn("Failed to set up formatting hooks, saving files as written", "error", err)
+	}
+	var scanner *scanning.Service
+	if config.GetBool("scanning.enabled") {
+		scanner, err = scanning.NewService(db, config, email.NewService(db, config))
+		if err != nil {
+			slog.Warn("Failed to set up upload scanning, saving files unscanned", "error", err)
+		}
+	}
 	return &GistHandler{
-		db:     db,
-		config: config,
-		gitOps: gitOps,
+		db:        db,
+		config:    config,
+		gitOps:    gitOps,
+		markup:    markup.NewRenderer(db, config),
+		formatter: formatter,
+		quota:     services.NewQuotaService(db, config),
+		policy:    contentpolicy.NewChecker(db, config),
+		images:    imageproxy.NewSigner(config),
+		scanner:   scanner,
+		webhooks:  webhooks.NewService(db, config),
+		notifier:  notifications.NewService(db),
+		shares:    services.NewGistShareService(db),
+		trash:     services.NewGistTrashService(db, config),
+	}
+}
+
+// formatFile runs the formatting hooks over a file about to be saved,
+// replacing its content when a formatter rewrote it and its lint
+// annotations
+func (h *GistHandler) formatFile(c echo.Context, file *models.GistFile) {
+	result := h.formatter.Process(c.Request().Context(), file.Filename, file.Language, file.Content)
+	file.Content = result.Content
+	file.LintAnnotations = result.Annotations
+}
+
+// scanFile runs the malware scanner over a file about to be saved and
+// records the verdict on it. An infected file has already been quarantined
+// by the scanner; the save is rejected with 422 naming the file.
+func (h *GistHandler) scanFile(c echo.Context, userID uuid.UUID, gistID *uuid.UUID, file *models.GistFile) error {
+	result, err := h.scanner.Scan(c.Request().Context(), scanning.Upload{
+		Filename: file.Filename,
+		Source:   models.QuarantineSourceGistUpload,
+		UserID:   &userID,
+		GistID:   gistID,
+	}, []byte(file.Content))
+	switch {
+	case errors.Is(err, scanning.ErrScanFailed):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "uploads cannot be scanned right now")
+	case err != nil:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to scan upload")
+	case result == nil:
+		return nil
+	case result.Infected():
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":   "upload rejected by malware scan",
+			"file":      file.Filename,
+			"signature": result.Signature,
+		})
+	}
+	now := time.Now().UTC()
+	file.ScanStatus = result.Status
+	file.ScannedAt = &now
+	return nil
+}
+
+// checkQuota enforces the user's quota tier on the files a gist will have
+// once saved. Files count against the user saving them, also in
+// organization gists.
+func (h *GistHandler) checkQuota(userID uuid.UUID, files []services.QuotaFile) error {
+	err := h.quota.CheckUserFiles(userID, files)
+	var quotaErr *services.QuotaError
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &quotaErr) && quotaErr.Limit == "max_files":
+		return echo.NewHTTPError(http.StatusBadRequest, quotaErr.Error())
+	case errors.As(err, &quotaErr):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, quotaErr.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to check quota")
+	}
+}
+
+// checkPolicy runs the content policy over the files a gist will have once
+// saved, merged with the organization's policy for organization gists.
+// Blocking violations reject the save with 422 and the full list so
+// clients can show each one next to its file and line; otherwise the
+// warnings are returned to be sent back with the saved gist.
+func (h *GistHandler) checkPolicy(orgID *uuid.UUID, visibility models.Visibility, files []contentpolicy.File) (contentpolicy.Violations, error) {
+	var orgPolicy *models.ContentPolicy
+	if orgID != nil {
+		settings, err := services.NewOrgPolicyService(h.db).GetSettings(*orgID)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to check content policy")
+		}
+		orgPolicy = &settings.ContentPolicy
+	}
+
+	violations := h.policy.Check(contentpolicy.Gist{Visibility: visibility, Files: files}, orgPolicy)
+	if violations.Blocked() {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, map[string]interface{}{
+			"message":    "gist violates the content policy",
+			"violations": violations,
+		})
+	}
+	return violations.Warnings(), nil
+}
+
+// authorizeGist checks that the requesting user may act on the gist: its
+// owner, or for organization gists a member whose role allows the action
+func (h *GistHandler) authorizeGist(c echo.Context, gist *models.Gist, action services.GistAction) error {
+	var user *models.User
+	if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+		var found models.User
+		if err := h.db.First(&found, "id = ?", userID).Error; err == nil {
+			user = &found
+		}
+	}
+	err := services.NewOrgPolicyService(h.db).AuthorizeGist(gist, user, action)
+	if errors.Is(err, services.ErrGistAccessDenied) {
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	}
+	if errors.Is(err, services.ErrGistHidden) {
+		return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+	}
+	if err != nil {
+		return orgPolicyError(err)
+	}
+	return nil
+}
+
+// authorizeRead checks that the requesting user may read the gist, or that
+// the request carries one of its share links
+func (h *GistHandler) authorizeRead(c echo.Context, gist *models.Gist) error {
+	if middleware.IsShareOnly(c) {
+		if SharedRead(c, h.shares, gist) {
+			return nil
+		}
+		return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+	}
+	err := h.authorizeGist(c, gist, services.GistRead)
+	if err != nil && SharedRead(c, h.shares, gist) {
+		return nil
+	}
+	return err
+}
+
+// recordHistory commits the gist's files to its repository as the acting
+// user. The database holds the files served, so a failed commit is logged
+// rather than failing the change. Sandbox gists have no repository.
+func (h *GistHandler) recordHistory(c echo.Context, gist *models.Gist, userID uuid.UUID, message string) {
+	if h.gitOps == nil || gist.Ephemeral {
+		return
+	}
+	var author models.User
+	if err := h.db.First(&author, "id = ?", userID).Error; err != nil {
+		c.Logger().Errorf("Failed to load author of gist %s change: %v", gist.ID, err)
+		return
+	}
+	if err := h.gitOps.UpdateGistFiles(gist, gist.Files, &author, message); err != nil {
+		c.Logger().Errorf("Failed to update git repo for gist %s: %v", gist.ID, err)
 	}
 }
 
 // CreateGistRequest represents a gist creation request
 type CreateGistRequest struct {
-	Title       string              `json:"title" validate:"required"`
-	Description string              `json:"description"`
-	Visibility  string              `json:"visibility"` // public, private, unlisted
-	Files       []CreateFileRequest `json:"files" validate:"required,min=1"`
+	Title        string              `json:"title" validate:"required"`
+	Name         string              `json:"name"`
+	Description  string              `json:"description"`
+	Visibility   string              `json:"visibility"`   // public, private, unlisted
+	Organization string              `json:"organization"` // Create the gist under this organization
+	Slug         string              `json:"slug"`         // Address under /o/:org, derived from the name or title when empty
+	Tags         []string            `json:"tags"`
+	Files        []CreateFileRequest `json:"files" validate:"required,min=1"`
+
+	// Owner permissions, left unchanged when omitted (all allowed on create)
+	AllowEmbed    *bool `json:"allow_embed"`
+	AllowIndexing *bool `json:"allow_indexing"`
+	AllowForks    *bool `json:"allow_forks"`
+
+	// Expiry, at most one of expires_at and expires_in (seconds); without
+	// either the gist doesn't expire
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn     int        `json:"expires_in,omitempty"`
+	BurnAfterRead bool       `json:"burn_after_read"` // Expire on the first anonymous view
+}
+
+// applyPermissions copies the permissions set in the request to the gist
+func (r *CreateGistRequest) applyPermissions(gist *models.Gist) {
+	if r.AllowEmbed != nil {
+		gist.EmbedDisabled = !*r.AllowEmbed
+	}
+	if r.AllowIndexing != nil {
+		gist.IndexingDisabled = !*r.AllowIndexing
+	}
+	if r.AllowForks != nil {
+		gist.ForksDisabled = !*r.AllowForks
+	}
 }
 
 // CreateFileRequest represents a file in a gist creation request
@@ -56,6 +278,9 @@ type GistResponse struct {
 	Title       string          `json:"title"`
 	Description string          `json:"description"`
 	Visibility  string          `json:"visibility"`
+	Tags        []string        `json:"tags"`
+	Slug        string          `json:"slug,omitempty"`
+	URL         string          `json:"url,omitempty"` // /o/:org/:slug for organization gists
 	ViewCount   int             `json:"view_count"`
 	StarCount   int             `json:"star_count"`
 	ForkCount   int             `json:"fork_count"`
@@ -63,6 +288,30 @@ type GistResponse struct {
 	UpdatedAt   string          `json:"updated_at"`
 	User        *UserResponse   `json:"user"`
 	Files       []FileResponse  `json:"files"`
+
+	// Markdown rendered to sanitized HTML
+	DescriptionHTML string `json:"description_html,omitempty"`
+	ReadmeHTML      string `json:"readme_html,omitempty"` // README.md, single gist responses only
+
+	// Totals of the files' stats
+	TotalSize       int64 `json:"total_size"` // Bytes
+	WordCount       int   `json:"word_count"`
+	ReadTimeSeconds int   `json:"read_time_seconds"`
+
+	// Content policy warnings, on responses to saves only
+	PolicyWarnings contentpolicy.Violations `json:"policy_warnings,omitempty"`
+
+	// Created by an API sandbox request and purged after api.sandbox.ttl
+	Ephemeral bool `json:"ephemeral,omitempty"`
+
+	// Expiry; expired gists are no longer served
+	ExpiresAt     string `json:"expires_at,omitempty"`
+	BurnAfterRead bool   `json:"burn_after_read,omitempty"`
+
+	// Owner permissions
+	AllowEmbed    bool `json:"allow_embed"`
+	AllowIndexing bool `json:"allow_indexing"`
+	AllowForks    bool `json:"allow_forks"`
 }
 
 // FileResponse represents a file in API responses
@@ -70,15 +319,34 @@ type FileResponse struct {
 	ID        uuid.UUID `json:"id"`
 	Filename  string    `json:"filename"`
 	Language  string    `json:"language"`
-	Content   string    `json:"content"`
+	Content   string    `json:"content,omitempty"` // Left out of list responses
 	Size      int64     `json:"size"`
 	LineCount int64     `json:"line_count"`
+	HTML      string    `json:"html,omitempty"` // Markdown files in single gist responses only
+
+	// Stats computed when the file is saved
+	WordCount       int `json:"word_count,omitempty"` // Prose files only
+	ReadTimeSeconds int `json:"read_time_seconds"`
+	Complexity      int `json:"complexity,omitempty"` // Code files only, 1 for straight-line code
+
+	// Messages from the formatting hooks when the file was saved
+	Annotations models.LintAnnotations `json:"annotations,omitempty"`
+
+	// Malware scan verdict, when upload scanning was enabled at save time
+	ScanStatus string     `json:"scan_status,omitempty"` // clean, skipped or error
+	ScannedAt  *time.Time `json:"scanned_at,omitempty"`
 }
 
-// Create creates a new gist
+// Create creates a new gist from a JSON, urlencoded or multipart request
 func (h *GistHandler) Create(c echo.Context) error {
 	var req CreateGistRequest
-	if err := c.Bind(&req); err != nil {
+	if isFormRequest(c) {
+		formReq, err := h.bindFormCreateRequest(c)
+		if err != nil {
+			return err
+		}
+		req = *formReq
+	} else if err := c.Bind(&req); err != nil {
 		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
 	}
 
@@ -93,6 +361,31 @@ func (h *GistHandler) Create(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 
+	// Load user
+	var user models.User
+	if err := h.db.First(&user, userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Organization gists follow the organization's policies and default
+	// visibility
+	var orgID *uuid.UUID
+	var org *models.Organization
+	if req.Organization != "" {
+		policy := services.NewOrgPolicyService(h.db)
+		var err error
+		org, err = policy.FindOrganization(req.Organization)
+		if err != nil {
+			return orgPolicyError(err)
+		}
+		orgVisibility, err := policy.AuthorizeGistCreate(org.ID, &user, req.Name, req.Visibility)
+		if err != nil {
+			return orgPolicyError(err)
+		}
+		req.Visibility = string(orgVisibility)
+		orgID = &org.ID
+	}
+
 	// Validate visibility
 	visibility := models.VisibilityPrivate
 	if req.Visibility == "public" {
@@ -105,13 +398,51 @@ func (h *GistHandler) Create(c echo.Context) error {
 	gist := models.Gist{
 		ID:          uuid.New(),
 		UserID:      &userID,
+		Name:        req.Name,
 		Title:       req.Title,
 		Description: req.Description,
 		Visibility:  visibility,
-		GitRepoPath: uuid.New().String(), // Placeholder for git repo path
+		Ephemeral:   middleware.IsSandbox(c),
+	}
+	gist.GitRepoPath = gist.ID.String() // The repository is stored under the gist's ID
+	gist.SetTags(req.Tags)
+	if len(gist.TagsString) > 500 {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many tags")
+	}
+	req.applyPermissions(&gist)
+	if err := req.applyExpiry(&gist); err != nil {
+		return err
+	}
+	if orgID != nil {
+		// A gist is owned by either a user or an organization
+		gist.UserID = nil
+		gist.OrganizationID = orgID
+
+		if req.Slug != "" {
+			if !models.ValidGistSlug(req.Slug) {
+				return orgGistError(services.ErrGistSlugInvalid)
+			}
+			taken, err := models.IsGistSlugTaken(h.db, *orgID, req.Slug, gist.ID)
+			if err != nil {
+				return echo.NewHTTPError(http.StatusInternalServerError, "failed to create gist")
+			}
+			if taken {
+				return orgGistError(services.ErrGistSlugTaken)
+			}
+		}
+		if err := models.SetOrgSlug(h.db, &gist, nil, "", req.Slug); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to create gist")
+		}
 	}
 
 	// Create files
+	quotaFiles := make([]services.QuotaFile, 0, len(req.Files))
+	for _, fileReq := range req.Files {
+		quotaFiles = append(quotaFiles, services.QuotaFile{Filename: fileReq.Filename, Size: int64(len(fileReq.Content))})
+	}
+	if err := h.checkQuota(userID, quotaFiles); err != nil {
+		return err
+	}
 	for _, fileReq := range req.Files {
 		file := models.GistFile{
 			ID:       uuid.New(),
@@ -121,60 +452,218 @@ func (h *GistHandler) Create(c echo.Context) error {
 			Size:     int64(len(fileReq.Content)),
 			Lines:    countLines(fileReq.Content),
 		}
+		h.formatFile(c, &file)
 		gist.Files = append(gist.Files, file)
 	}
+	policyFiles := make([]contentpolicy.File, 0, len(gist.Files))
+	for _, file := range gist.Files {
+		policyFiles = append(policyFiles, contentpolicy.File{Filename: file.Filename, Content: file.Content})
+	}
+	warnings, err := h.checkPolicy(orgID, gist.Visibility, policyFiles)
+	if err != nil {
+		return err
+	}
+	for i := range gist.Files {
+		if err := h.scanFile(c, userID, nil, &gist.Files[i]); err != nil {
+			return err
+		}
+	}
 
 	// Save to database
 	if err := h.db.Create(&gist).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create gist")
 	}
+	gist.Organization = org
 
-	// Load user
-	var user models.User
-	h.db.First(&user, userID)
-
-	// Initialize Git repository if gitOps is available
-	if h.gitOps != nil {
+	// Initialize the gist's Git repository; sandbox gists don't get one
+	if h.gitOps != nil && !gist.Ephemeral {
 		if err := h.gitOps.InitializeGistRepo(&gist, gist.Files, &user); err != nil {
-			// Log error but don't fail the request
-			// Git operations are optional for basic functionality
+			// Log error but don't fail the request, the files are saved
 			c.Logger().Errorf("Failed to initialize git repo for gist %s: %v", gist.ID, err)
 		}
 	}
 
+	// Queue the owner's tag automation rules; sandbox gists don't run them,
+	// as rules can deliver webhooks
+	if h.config.GetBool("automation.enabled") && !gist.Ephemeral {
+		if _, err := automation.Enqueue(h.db, &gist, &userID); err != nil {
+			c.Logger().Errorf("Failed to queue automation rules for gist %s: %v", gist.ID, err)
+		}
+	}
+	if !gist.Ephemeral {
+		go h.webhooks.TriggerGistCreated(context.Background(), &gist, userID)
+	}
+
+	// Plain-text clients (curl, pastebin-style scripts) just get the raw URLs
+	if acceptsPlainText(c) {
+		return c.String(http.StatusCreated, h.rawURLs(c, &gist))
+	}
+
 	// Return response
-	return c.JSON(http.StatusCreated, h.buildGistResponse(&gist, &user))
+	response := h.buildGistResponse(&gist, &user)
+	response.PolicyWarnings = warnings
+	return c.JSON(http.StatusCreated, response)
 }
 
-// List returns a list of gists
-func (h *GistHandler) List(c echo.Context) error {
-	// Parse pagination parameters
-	page, _ := strconv.Atoi(c.QueryParam("page"))
-	if page < 1 {
-		page = 1
+// isFormRequest reports whether the request body is urlencoded or multipart
+func isFormRequest(c echo.Context) bool {
+	contentType := c.Request().Header.Get(echo.HeaderContentType)
+	return strings.HasPrefix(contentType, echo.MIMEApplicationForm) ||
+		strings.HasPrefix(contentType, echo.MIMEMultipartForm)
+}
+
+// acceptsPlainText reports whether the client prefers a text/plain response
+func acceptsPlainText(c echo.Context) bool {
+	accept := c.Request().Header.Get(echo.HeaderAccept)
+	return strings.HasPrefix(accept, echo.MIMETextPlain)
+}
+
+// bindFormCreateRequest builds a gist creation request from form fields.
+//
+// Supported shapes:
+//
+//	curl -F 'file=@script.sh' .../api/v1/gists          (one or more file parts)
+//	curl -d 'content=echo hi' -d 'filename=hi.sh' ...    (urlencoded content)
+//
+// title, description, visibility, expires_in and burn_after_read are
+// optional form fields.
+func (h *GistHandler) bindFormCreateRequest(c echo.Context) (*CreateGistRequest, error) {
+	maxFileSize := h.config.GetInt64("storage.max_file_size")
+	if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+		if tier, err := h.quota.TierForUserID(userID); err == nil && tier.MaxFileSize > 0 {
+			maxFileSize = tier.MaxFileSize
+		}
+	}
+	if maxFileSize <= 0 {
+		maxFileSize = 5 * 1024 * 1024
+	}
+
+	req := &CreateGistRequest{
+		Title:        c.FormValue("title"),
+		Name:         c.FormValue("name"),
+		Description:  c.FormValue("description"),
+		Visibility:   c.FormValue("visibility"),
+		Organization: c.FormValue("organization"),
+	}
+	if tags := c.FormValue("tags"); tags != "" {
+		req.Tags = strings.Split(tags, ",")
+	}
+	if expiresIn := c.FormValue("expires_in"); expiresIn != "" {
+		seconds, err := strconv.Atoi(expiresIn)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "expires_in must be a number of seconds")
+		}
+		req.ExpiresIn = seconds
+	}
+	req.BurnAfterRead, _ = strconv.ParseBool(c.FormValue("burn_after_read"))
+
+	// Multipart file uploads
+	if form, err := c.MultipartForm(); err == nil && form != nil {
+		for _, headers := range form.File {
+			for _, fh := range headers {
+				if fh.Size > maxFileSize {
+					return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+						fmt.Sprintf("file %s exceeds maximum size of %d bytes", fh.Filename, maxFileSize))
+				}
+
+				src, err := fh.Open()
+				if err != nil {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, "failed to read uploaded file")
+				}
+				content, err := io.ReadAll(io.LimitReader(src, maxFileSize))
+				src.Close()
+				if err != nil {
+					return nil, echo.NewHTTPError(http.StatusBadRequest, "failed to read uploaded file")
+				}
+
+				req.Files = append(req.Files, CreateFileRequest{
+					Filename: filepath.Base(fh.Filename),
+					Content:  string(content),
+				})
+			}
+		}
 	}
+
+	// Inline content (urlencoded or a plain multipart field)
+	content := c.FormValue("content")
+	if content == "" {
+		content = c.FormValue("file")
+	}
+	if content != "" {
+		if int64(len(content)) > maxFileSize {
+			return nil, echo.NewHTTPError(http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("content exceeds maximum size of %d bytes", maxFileSize))
+		}
+		filename := c.FormValue("filename")
+		if filename == "" {
+			filename = "paste.txt"
+		}
+		req.Files = append(req.Files, CreateFileRequest{
+			Filename: filepath.Base(filename),
+			Content:  content,
+			Language: c.FormValue("language"),
+		})
+	}
+
+	if len(req.Files) == 0 {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "at least one file is required")
+	}
+
+	if req.Title == "" {
+		req.Title = req.Files[0].Filename
+	}
+
+	return req, nil
+}
+
+// rawURLs returns one raw file URL per line for plain-text responses
+func (h *GistHandler) rawURLs(c echo.Context, gist *models.Gist) string {
+	baseURL := strings.TrimSuffix(h.config.GetString("server.url"), "/")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+	}
+
+	var b strings.Builder
+	for _, file := range gist.Files {
+		b.WriteString(baseURL + urls.Path(urls.RawFile, gist.ID.String(), file.Filename) + "\n")
+	}
+	return b.String()
+}
+
+// List returns a list of gists. Pages follow each other by cursor; page
+// asks for a numbered page with a total count, the way lists were paged
+// before cursors.
+func (h *GistHandler) List(c echo.Context) error {
+	// Parse pagination parameters; per_page is the documented name
 	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if perPage, err := strconv.Atoi(c.QueryParam("per_page")); err == nil {
+		limit = perPage
+	}
 	if limit < 1 || limit > 100 {
 		limit = 20
 	}
+	sort := services.GistSort(c.QueryParam("sort"))
 
-	// Build query
-	query := h.db.Model(&models.Gist{}).Preload("User").Preload("Files")
+	// Build query; lists show the file summary kept on the gist, not the files
+	query := database.ReadReplica(h.db).Model(&models.Gist{}).
+		Scopes(models.HideDeactivatedOwners, models.HideExpired, models.SandboxScope(middleware.IsSandbox(c))).
+		Preload("User", services.ListedUser)
 
 	// Filter by user if specified
 	if username := c.QueryParam("username"); username != "" {
 		var user models.User
-		if err := h.db.Where("username = ?", username).First(&user).Error; err != nil {
+		if err := h.db.Where("username = ? AND deactivated_at IS NULL", username).First(&user).Error; err != nil {
 			return echo.NewHTTPError(http.StatusNotFound, "user not found")
 		}
 		query = query.Where("user_id = ?", user.ID)
 	}
 
-	// Filter by visibility
+	// Filter by visibility; owners still see their gists an administrator hid
 	if c.Get("user_id") == nil {
 		// Not authenticated, only show public gists
-		query = query.Where("visibility = ?", models.VisibilityPublic)
+		query = query.Where("visibility = ?", models.VisibilityPublic).Scopes(models.HideModerated)
 	} else {
+		query = query.Where("gists.hidden_at IS NULL OR gists.user_id = ?", c.Get("user_id"))
 		// Authenticated
 		if visibility := c.QueryParam("visibility"); visibility != "" {
 			switch visibility {
@@ -189,35 +678,52 @@ func (h *GistHandler) List(c echo.Context) error {
 		}
 	}
 
-	// Count total
-	var total int64
-	query.Count(&total)
-
-	// Apply sorting
-	sort := c.QueryParam("sort")
-	switch sort {
-	case "created":
-		query = query.Order("created_at DESC")
-	case "updated":
-		query = query.Order("updated_at DESC")
-	case "stars":
-		query = query.Order("star_count DESC")
-	default:
-		query = query.Order("created_at DESC")
+	if c.QueryParam("page") != "" {
+		return h.listPage(c, query, sort, limit)
 	}
 
-	// Apply pagination
-	offset := (page - 1) * limit
-	query = query.Offset(offset).Limit(limit)
+	var cursor *services.GistCursor
+	if value := c.QueryParam("cursor"); value != "" {
+		var err error
+		if cursor, err = services.ParseGistCursor(value, sort); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor, start again without one")
+		}
+	}
+	page, err := services.PageGists(query, sort, cursor, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gists")
+	}
+	setNextLink(c, page.NextCursor)
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"gists": h.buildGistListResponse(page.Gists),
+		"pagination": map[string]interface{}{
+			"limit":       limit,
+			"next_cursor": page.NextCursor,
+			"has_more":    page.NextCursor != "",
+		},
+	})
+}
+
+// listPage responds with a numbered page of a gist list and the total
+// count, which costs a second query on the whole list
+func (h *GistHandler) listPage(c echo.Context, query *gorm.DB, sort string, limit int) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+
+	var total int64
+	if err := query.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to count gists")
+	}
 
-	// Fetch gists
 	var gists []models.Gist
-	if err := query.Find(&gists).Error; err != nil {
+	if err := services.OrderGists(query, sort).Offset((page - 1) * limit).Limit(limit).Find(&gists).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gists")
 	}
 
-	// Build response
-	response := map[string]interface{}{
+	return c.JSON(http.StatusOK, map[string]interface{}{
 		"gists": h.buildGistListResponse(gists),
 		"pagination": map[string]interface{}{
 			"page":  page,
@@ -225,9 +731,20 @@ func (h *GistHandler) List(c echo.Context) error {
 			"total": total,
 			"pages": (total + int64(limit) - 1) / int64(limit),
 		},
-	}
+	})
+}
 
-	return c.JSON(http.StatusOK, response)
+// setNextLink adds a Link header pointing at the next page of a list, the
+// request's URL with the cursor replaced
+func setNextLink(c echo.Context, nextCursor string) {
+	if nextCursor == "" {
+		return
+	}
+	next := *c.Request().URL
+	query := next.Query()
+	query.Set("cursor", nextCursor)
+	next.RawQuery = query.Encode()
+	c.Response().Header().Add("Link", fmt.Sprintf(`<%s>; rel="next"`, next.RequestURI()))
 }
 
 // Get returns a single gist
@@ -240,7 +757,7 @@ func (h *GistHandler) Get(c echo.Context) error {
 
 	// Fetch gist
 	var gist models.Gist
-	if err := h.db.Preload("User").Preload("Files").First(&gist, gistID).Error; err != nil {
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired).Preload("User").Preload("Organization").Preload("Files").First(&gist, gistID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
 		}
@@ -248,16 +765,19 @@ func (h *GistHandler) Get(c echo.Context) error {
 	}
 
 	// Check visibility
-	userID, _ := c.Get("user_id").(uuid.UUID)
-	if gist.Visibility == models.VisibilityPrivate && (gist.UserID == nil || *gist.UserID != userID) {
-		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	if err := h.authorizeRead(c, &gist); err != nil {
+		return err
+	}
+	if err := BurnRead(c, h.db, &gist); err != nil {
+		return err
 	}
 
-	// Increment view count
-	h.db.Model(&gist).Update("view_count", gist.ViewCount+1)
+	// Count the view; it is written with the next batch
+	counters.CountView(h.db, gist.ID)
 
 	// Return response
-	return c.JSON(http.StatusOK, h.buildGistResponse(&gist, gist.User))
+	httpcache.Revalidate(c, gist.Visibility != models.VisibilityPrivate)
+	return httpcache.JSON(c, h.buildGistDetailResponse(&gist, gist.User), gist.UpdatedAt)
 }
 
 // Update updates a gist
@@ -283,9 +803,9 @@ func (h *GistHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
 	}
 
-	// Check ownership
-	if gist.UserID == nil || *gist.UserID != userID {
-		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	// Check ownership or organization role
+	if err := h.authorizeGist(c, &gist, services.GistWrite); err != nil {
+		return err
 	}
 
 	// Parse request
@@ -295,6 +815,7 @@ func (h *GistHandler) Update(c echo.Context) error {
 	}
 
 	// Validate visibility
+	previousVisibility := gist.Visibility
 	visibility := gist.Visibility
 	if req.Visibility == "public" {
 		visibility = models.VisibilityPublic
@@ -308,9 +829,18 @@ func (h *GistHandler) Update(c echo.Context) error {
 	gist.Title = req.Title
 	gist.Description = req.Description
 	gist.Visibility = visibility
+	req.applyPermissions(&gist)
 
 	// Update files (simplified - in production, you'd handle file updates more carefully)
-	h.db.Where("gist_id = ?", gistID).Delete(&models.GistFile{})
+	quotaFiles := make([]services.QuotaFile, 0, len(req.Files))
+	for _, fileReq := range req.Files {
+		quotaFiles = append(quotaFiles, services.QuotaFile{Filename: fileReq.Filename, Size: int64(len(fileReq.Content))})
+	}
+	if err := h.checkQuota(userID, quotaFiles); err != nil {
+		return err
+	}
+	files := make([]models.GistFile, 0, len(req.Files))
+	policyFiles := make([]contentpolicy.File, 0, len(req.Files))
 	for _, fileReq := range req.Files {
 		file := models.GistFile{
 			ID:       uuid.New(),
@@ -321,19 +851,49 @@ func (h *GistHandler) Update(c echo.Context) error {
 			Size:     int64(len(fileReq.Content)),
 			Lines:    countLines(fileReq.Content),
 		}
-		h.db.Create(&file)
+		h.formatFile(c, &file)
+		files = append(files, file)
+		policyFiles = append(policyFiles, contentpolicy.File{Filename: file.Filename, Content: file.Content})
+	}
+	warnings, err := h.checkPolicy(gist.OrganizationID, visibility, policyFiles)
+	if err != nil {
+		return err
+	}
+	for i := range files {
+		if err := h.scanFile(c, userID, &gistID, &files[i]); err != nil {
+			return err
+		}
+	}
+	h.db.Where("gist_id = ?", gistID).Delete(&models.GistFile{})
+	for i := range files {
+		h.db.Create(&files[i])
 	}
 
 	// Save changes
 	if err := h.db.Save(&gist).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update gist")
 	}
+	if err := models.RefreshGistStats(h.db, gistID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update gist")
+	}
 
 	// Reload with associations
 	h.db.Preload("User").Preload("Files").First(&gist, gistID)
 
+	// Record the change in the gist's history
+	if len(req.Files) > 0 {
+		h.recordHistory(c, &gist, userID, "Update gist")
+	}
+
+	go h.webhooks.TriggerGistUpdated(context.Background(), &gist, userID)
+	if visibility != previousVisibility {
+		go h.webhooks.TriggerGistVisibilityChanged(context.Background(), &gist, previousVisibility, userID)
+	}
+
 	// Return response
-	return c.JSON(http.StatusOK, h.buildGistResponse(&gist, gist.User))
+	response := h.buildGistResponse(&gist, gist.User)
+	response.PolicyWarnings = warnings
+	return c.JSON(http.StatusOK, response)
 }
 
 // Delete deletes a gist
@@ -359,41 +919,81 @@ func (h *GistHandler) Delete(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
 	}
 
-	// Check ownership
-	if gist.UserID == nil || *gist.UserID != userID {
-		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	// Check ownership or organization role
+	if err := h.authorizeGist(c, &gist, services.GistDelete); err != nil {
+		return err
 	}
 
 	// Delete gist (soft delete)
 	if err := h.db.Delete(&gist).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete gist")
 	}
+	go h.webhooks.TriggerGistDeleted(context.Background(), gist.ID, userID)
 
 	return c.NoContent(http.StatusNoContent)
 }
 
 // Helper methods
 
+// timestamp formats a gist time for responses, RFC 3339 in UTC unless the
+// api.legacy_timestamps shim is on
+func (h *GistHandler) timestamp(t time.Time) string {
+	if h.config.GetBool("api.legacy_timestamps") {
+		return t.Format(utils.LegacyTimestampLayout)
+	}
+	return utils.FormatTimestamp(t)
+}
+
 func (h *GistHandler) buildGistResponse(gist *models.Gist, user *models.User) GistResponse {
 	response := GistResponse{
 		ID:          gist.ID,
 		Title:       gist.Title,
 		Description: gist.Description,
 		Visibility:  string(gist.Visibility),
+		Tags:        gist.TagList(),
+		Slug:        gist.SlugValue(),
 		ViewCount:   gist.ViewCount,
 		StarCount:   gist.StarCount,
 		ForkCount:   gist.ForkCount,
-		CreatedAt:   gist.CreatedAt.Format("2006-01-02T15:04:05Z"),
-		UpdatedAt:   gist.UpdatedAt.Format("2006-01-02T15:04:05Z"),
+		CreatedAt:   h.timestamp(gist.CreatedAt),
+		UpdatedAt:   h.timestamp(gist.UpdatedAt),
+
+		DescriptionHTML: h.markup.Render(gist.Description),
+
+		TotalSize:       gist.TotalSize,
+		WordCount:       gist.WordCount,
+		ReadTimeSeconds: gist.ReadTimeSeconds,
+
+		Ephemeral: gist.Ephemeral,
+
+		BurnAfterRead: gist.BurnAfterRead,
+
+		AllowEmbed:    !gist.EmbedDisabled,
+		AllowIndexing: !gist.IndexingDisabled,
+		AllowForks:    !gist.ForksDisabled,
+	}
+
+	if gist.ExpiresAt != nil {
+		response.ExpiresAt = h.timestamp(*gist.ExpiresAt)
 	}
 
-	if user != nil {
+	if gist.Organization != nil && gist.Slug != nil {
+		response.URL = models.OrgGistPath(gist.Organization.Name, *gist.Slug)
+	}
+
+	if user != nil && user.IsDeactivated() {
+		// Gists kept public by a deactivated account are shown anonymously
+		response.User = &UserResponse{
+			Username:    "ghost",
+			DisplayName: "Deactivated user",
+		}
+	} else if user != nil {
 		response.User = &UserResponse{
 			ID:          user.ID,
 			Username:    user.Username,
 			Email:       user.Email,
 			DisplayName: user.DisplayName,
-			AvatarURL:   user.AvatarURL,
+			AvatarURL:   h.images.URL(user.AvatarURL),
 			IsAdmin:     user.IsAdmin,
 		}
 	}
@@ -406,16 +1006,55 @@ func (h *GistHandler) buildGistResponse(gist *models.Gist, user *models.User) Gi
 			Content:   file.Content,
 			Size:      file.Size,
 			LineCount: int64(file.Lines),
+
+			WordCount:       file.WordCount,
+			ReadTimeSeconds: file.ReadTimeSeconds,
+			Complexity:      file.Complexity,
+
+			Annotations: file.LintAnnotations,
+
+			ScanStatus: file.ScanStatus,
+			ScannedAt:  file.ScannedAt,
 		})
 	}
 
 	return response
 }
 
+// buildGistDetailResponse builds the response for a single gist, which
+// also carries its rendered README and markdown files
+func (h *GistHandler) buildGistDetailResponse(gist *models.Gist, user *models.User) GistResponse {
+	response := h.buildGistResponse(gist, user)
+	for i := range response.Files {
+		file := &response.Files[i]
+		if !markup.IsMarkdown(file.Filename) {
+			continue
+		}
+		file.HTML = h.markup.Render(file.Content)
+		if response.ReadmeHTML == "" && markup.IsReadme(file.Filename) {
+			response.ReadmeHTML = file.HTML
+		}
+	}
+	return response
+}
+
+// buildGistListResponse builds the responses of a gist list. Their files
+// come from the gist's file summary, without content.
 func (h *GistHandler) buildGistListResponse(gists []models.Gist) []GistResponse {
 	responses := make([]GistResponse, 0, len(gists))
 	for _, gist := range gists {
-		responses = append(responses, h.buildGistResponse(&gist, gist.User))
+		response := h.buildGistResponse(&gist, gist.User)
+		response.Files = make([]FileResponse, 0, len(gist.FileSummary))
+		for _, file := range gist.FileSummary {
+			response.Files = append(response.Files, FileResponse{
+				ID:        file.ID,
+				Filename:  file.Filename,
+				Language:  file.Language,
+				Size:      file.Size,
+				LineCount: int64(file.Lines),
+			})
+		}
+		responses = append(responses, response)
 	}
 	return responses
 }
@@ -458,8 +1097,8 @@ func (h *GistHandler) Star(c echo.Context) error {
 	}
 
 	// Check visibility
-	if gist.Visibility == models.VisibilityPrivate && (gist.UserID == nil || *gist.UserID != userID) {
-		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	if err := h.authorizeGist(c, &gist, services.GistRead); err != nil {
+		return err
 	}
 
 	// Check if already starred
@@ -478,21 +1117,26 @@ func (h *GistHandler) Star(c echo.Context) error {
 		GistID: gistID,
 	}
 
+	// Creating the star increments star_count
 	if err := h.db.Create(&star).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to star gist")
 	}
+	h.db.Model(&gist).Select("star_count").Take(&gist)
 
-	// Update star count
-	h.db.Model(&gist).Update("star_count", gist.StarCount+1)
-
-	// Send notification to gist owner if different from starring user
-	if gist.UserID != nil && *gist.UserID != userID {
-		// TODO: Send notification through email service
+	var actor models.User
+	if err := h.db.First(&actor, "id = ?", userID).Error; err == nil {
+		// Send notification to gist owner if different from starring user
+		if gist.UserID != nil && *gist.UserID != userID {
+			if err := h.notifier.GistStarred(&gist, &actor); err != nil {
+				c.Logger().Errorf("Failed to notify about star of gist %s: %v", gistID, err)
+			}
+		}
+		go h.webhooks.TriggerGistStarred(context.Background(), &gist, &actor, true)
 	}
 
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"message": "gist starred",
-		"star_count": gist.StarCount + 1,
+		"star_count": gist.StarCount,
 	})
 }
 
@@ -510,19 +1154,19 @@ func (h *GistHandler) Unstar(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
 	}
 
-	// Delete star
-	result := h.db.Where("user_id = ? AND gist_id = ?", userID, gistID).Delete(&models.GistStar{})
-	
-	if result.RowsAffected == 0 {
+	// Delete star; deleting the loaded star decrements star_count
+	var star models.GistStar
+	if err := h.db.Where("user_id = ? AND gist_id = ?", userID, gistID).First(&star).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "star not found")
 	}
+	if err := h.db.Delete(&star).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to unstar gist")
+	}
 
-	// Update star count
 	var gist models.Gist
-	if err := h.db.First(&gist, gistID).Error; err == nil {
-		var count int64
-		h.db.Model(&models.GistStar{}).Where("gist_id = ?", gistID).Count(&count)
-		h.db.Model(&gist).Update("star_count", count)
+	var actor models.User
+	if h.db.First(&gist, gistID).Error == nil && h.db.First(&actor, "id = ?", userID).Error == nil {
+		go h.webhooks.TriggerGistStarred(context.Background(), &gist, &actor, false)
 	}
 
 	return c.NoContent(http.StatusNoContent)
@@ -544,7 +1188,7 @@ func (h *GistHandler) Fork(c echo.Context) error {
 
 	// Fetch original gist with files
 	var originalGist models.Gist
-	if err := h.db.Preload("Files").Preload("User").First(&originalGist, gistID).Error; err != nil {
+	if err := h.db.Scopes(models.HideExpired).Preload("Files").Preload("User").First(&originalGist, gistID).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
 		}
@@ -552,8 +1196,8 @@ func (h *GistHandler) Fork(c echo.Context) error {
 	}
 
 	// Check visibility
-	if originalGist.Visibility == models.VisibilityPrivate && (originalGist.UserID == nil || *originalGist.UserID != userID) {
-		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	if err := h.authorizeGist(c, &originalGist, services.GistRead); err != nil {
+		return err
 	}
 
 	// Check if user is trying to fork their own gist
@@ -561,6 +1205,11 @@ func (h *GistHandler) Fork(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "cannot fork your own gist")
 	}
 
+	// The owner may have turned forking off
+	if originalGist.ForksDisabled {
+		return echo.NewHTTPError(http.StatusForbidden, "forking is disabled for this gist")
+	}
+
 	// Check if already forked by this user
 	var existingFork models.Gist
 	if err := h.db.Where("forked_from_id = ? AND user_id = ?", gistID, userID).First(&existingFork).Error; err == nil {
@@ -576,9 +1225,9 @@ func (h *GistHandler) Fork(c echo.Context) error {
 		Title:        originalGist.Title,
 		Description:  originalGist.Description,
 		Visibility:   originalGist.Visibility,
-		GitRepoPath:  uuid.New().String(), // New git repo for the fork
 		ForkedFromID: &gistID,
 	}
+	fork.GitRepoPath = fork.ID.String()
 
 	// Start transaction
 	tx := h.db.Begin()
@@ -610,9 +1259,13 @@ func (h *GistHandler) Fork(c echo.Context) error {
 			return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy files")
 		}
 	}
+	if err := services.CopyAttachments(tx, gistID, fork.ID); err != nil {
+		tx.Rollback()
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to copy attachments")
+	}
 
 	// Update fork count on original
-	if err := tx.Model(&originalGist).Update("fork_count", originalGist.ForkCount+1).Error; err != nil {
+	if err := tx.Model(&originalGist).UpdateColumn("fork_count", gorm.Expr("fork_count + ?", 1)).Error; err != nil {
 		tx.Rollback()
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update fork count")
 	}
@@ -625,14 +1278,23 @@ func (h *GistHandler) Fork(c echo.Context) error {
 	// Reload fork with associations
 	h.db.Preload("User").Preload("Files").First(&fork, fork.ID)
 
-	// Initialize git repository for the fork
+	// The fork's repository starts as a copy of the original's history
 	if h.gitOps != nil {
-		// TODO: Initialize git repo
+		var user models.User
+		h.db.First(&user, "id = ?", userID)
+		if err := h.gitOps.ForkGistRepo(&originalGist, &fork, fork.Files, &user); err != nil {
+			c.Logger().Errorf("Failed to fork git repo of gist %s: %v", gistID, err)
+		}
 	}
 
 	// Send notification to original gist owner
-	if originalGist.UserID != nil {
-		// TODO: Send notification through email service
+	if originalGist.UserID != nil && fork.User != nil {
+		if err := h.notifier.GistForked(&originalGist, &fork, fork.User); err != nil {
+			c.Logger().Errorf("Failed to notify about fork of gist %s: %v", gistID, err)
+		}
+	}
+	if fork.User != nil {
+		go h.webhooks.TriggerGistForked(context.Background(), &originalGist, &fork, fork.User)
 	}
 
 	// Return response
@@ -693,7 +1355,7 @@ func (h *GistHandler) GetStars(c echo.Context) error {
 				ID:        star.User.ID,
 				Username:  star.User.Username,
 				Email:     "", // Don't expose email
-				AvatarURL: star.User.AvatarURL,
+				AvatarURL: h.images.URL(star.User.AvatarURL),
 				StarredAt: star.CreatedAt,
 			})
 		}
@@ -745,7 +1407,7 @@ func (h *GistHandler) GetForks(c echo.Context) error {
 	var forks []models.Gist
 	offset := (page - 1) * perPage
 	
-	if err := h.db.Preload("User").Preload("Files").
+	if err := h.db.Preload("User", services.ListedUser).
 		Where("forked_from_id = ?", gistID).
 		Offset(offset).Limit(perPage).
 		Order("created_at DESC").
@@ -0,0 +1,157 @@
+package handlers
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/streaming"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Archive formats accepted by GET /gists/:id/download?format=
+const (
+	ArchiveFormatZip   = "zip"
+	ArchiveFormatTar   = "tar"
+	ArchiveFormatTarGz = "tar.gz"
+)
+
+var archiveContentTypes = map[string]string{
+	ArchiveFormatZip:   "application/zip",
+	ArchiveFormatTar:   "application/x-tar",
+	ArchiveFormatTarGz: "application/gzip",
+}
+
+// Download sends the gist's files as a zip, tar or gzipped tar archive, in
+// a directory named after the gist. The archive is written as each file is
+// read from the database, so a large gist is never held in memory whole.
+func (h *GistHandler) Download(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = ArchiveFormatZip
+	}
+	contentType, ok := archiveContentTypes[format]
+	if !ok {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be zip, tar or tar.gz")
+	}
+
+	var gist models.Gist
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired).First(&gist, gistID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	if err := h.authorizeRead(c, &gist); err != nil {
+		return err
+	}
+
+	// The contents are loaded one file at a time while writing
+	var files []models.GistFile
+	if err := h.db.Select("id", "filename", "size", "updated_at").Where("gist_id = ?", gist.ID).
+		Order("filename").Find(&files).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist files")
+	}
+	var size int64
+	for _, file := range files {
+		size += file.Size
+	}
+	limits := streaming.RouteLimits(h.config, streaming.RouteArchive)
+	if !limits.Allows(size) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "gist too large to download as an archive; clone it instead")
+	}
+
+	if err := BurnRead(c, h.db, &gist); err != nil {
+		return err
+	}
+	name := gist.ID.String()
+	c.Response().Header().Set(echo.HeaderContentDisposition,
+		mime.FormatMediaType("attachment", map[string]string{"filename": name + "." + format}))
+	httpcache.Revalidate(c, gist.Visibility != models.VisibilityPrivate)
+	if httpcache.NotModified(c, "", gist.UpdatedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	return streaming.StreamFunc(c, limits, contentType, -1, func(w io.Writer) error {
+		switch format {
+		case ArchiveFormatZip:
+			return h.writeZipArchive(w, name, files)
+		case ArchiveFormatTarGz:
+			gz := gzip.NewWriter(w)
+			if err := h.writeTarArchive(gz, name, files); err != nil {
+				return err
+			}
+			return gz.Close()
+		default:
+			return h.writeTarArchive(w, name, files)
+		}
+	})
+}
+
+// fileContent loads the content of one gist file
+func (h *GistHandler) fileContent(file models.GistFile) (string, error) {
+	var loaded models.GistFile
+	err := h.db.Select("content").Take(&loaded, "id = ?", file.ID).Error
+	return loaded.Content, err
+}
+
+// writeZipArchive writes the files into a zip archive under dir
+func (h *GistHandler) writeZipArchive(w io.Writer, dir string, files []models.GistFile) error {
+	zw := zip.NewWriter(w)
+	for _, file := range files {
+		content, err := h.fileContent(file)
+		if err != nil {
+			return err
+		}
+		entry, err := zw.CreateHeader(&zip.FileHeader{
+			Name:     dir + "/" + file.Filename,
+			Method:   zip.Deflate,
+			Modified: file.UpdatedAt,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(entry, content); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// writeTarArchive writes the files into a tar archive under dir
+func (h *GistHandler) writeTarArchive(w io.Writer, dir string, files []models.GistFile) error {
+	tw := tar.NewWriter(w)
+	for _, file := range files {
+		content, err := h.fileContent(file)
+		if err != nil {
+			return err
+		}
+		err = tw.WriteHeader(&tar.Header{
+			Name:     dir + "/" + file.Filename,
+			Size:     int64(len(content)),
+			Mode:     0644,
+			ModTime:  file.UpdatedAt,
+			Typeflag: tar.TypeReg,
+			Format:   tar.FormatPAX,
+		})
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(tw, content); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
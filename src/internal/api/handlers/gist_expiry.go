@@ -0,0 +1,55 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// applyExpiry copies the expiry set in the request to the gist
+func (r *CreateGistRequest) applyExpiry(gist *models.Gist) error {
+	if r.ExpiresAt != nil && r.ExpiresIn != 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "set expires_at or expires_in, not both")
+	}
+	if r.ExpiresIn < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "expiry must be in the future")
+	}
+	expiresAt := r.ExpiresAt
+	if r.ExpiresIn > 0 {
+		at := time.Now().Add(time.Duration(r.ExpiresIn) * time.Second)
+		expiresAt = &at
+	}
+	if expiresAt != nil && !expiresAt.After(time.Now()) {
+		return echo.NewHTTPError(http.StatusBadRequest, "expiry must be in the future")
+	}
+	gist.ExpiresAt = expiresAt
+	gist.BurnAfterRead = r.BurnAfterRead
+	return nil
+}
+
+// BurnRead expires a burn-after-read gist on its first anonymous view. When
+// anonymous readers race, the ones that lose get a not found error. The
+// response that burns the gist is kept out of every cache.
+func BurnRead(c echo.Context, db *gorm.DB, gist *models.Gist) error {
+	if !gist.BurnAfterRead {
+		return nil
+	}
+	c.Response().Header().Set("Cache-Control", "private, no-store")
+	if c.Get("user_id") != nil {
+		return nil
+	}
+	burned, err := models.BurnGist(db, gist.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	if !burned {
+		return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+	}
+	now := time.Now()
+	gist.ExpiresAt = &now
+	return nil
+}
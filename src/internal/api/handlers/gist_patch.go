@@ -0,0 +1,364 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/contentpolicy"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// File operations accepted by PATCH /gists/:id
+const (
+	FileOpAdd    = "add"
+	FileOpModify = "modify"
+	FileOpRename = "rename"
+	FileOpDelete = "delete"
+)
+
+// PatchGistRequest represents a partial gist update. Omitted fields are left
+// unchanged and only the listed files are touched, so file IDs stay stable.
+type PatchGistRequest struct {
+	Title       *string         `json:"title"`
+	Description *string         `json:"description"`
+	Visibility  *string         `json:"visibility"`
+	Files       []FileOperation `json:"files"`
+	Message     string          `json:"message"` // Commit message for the gist's history
+
+	// Owner permissions
+	AllowEmbed    *bool `json:"allow_embed"`
+	AllowIndexing *bool `json:"allow_indexing"`
+	AllowForks    *bool `json:"allow_forks"`
+}
+
+// FileOperation is a single change to one file of a gist. Existing files are
+// addressed by id or, when id is omitted, by filename.
+type FileOperation struct {
+	Op          string     `json:"op"`
+	ID          *uuid.UUID `json:"id"`
+	Filename    string     `json:"filename"`
+	NewFilename string     `json:"new_filename"` // rename, or modify and rename at once
+	Content     *string    `json:"content"`
+	Language    *string    `json:"language"`
+}
+
+// patchedFile tracks a file while operations are applied in memory
+type patchedFile struct {
+	file    models.GistFile
+	isNew   bool
+	changed bool
+}
+
+// Patch applies per-file operations to a gist without recreating its files
+func (h *GistHandler) Patch(c echo.Context) error {
+	// Parse gist ID
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+
+	// Get user ID from context
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	// Fetch gist
+	var gist models.Gist
+	if err := h.db.Preload("Files").First(&gist, gistID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+
+	// Check ownership or organization role
+	if err := h.authorizeGist(c, &gist, services.GistWrite); err != nil {
+		return err
+	}
+
+	// Parse request
+	var req PatchGistRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	permissionsChanged := req.AllowEmbed != nil || req.AllowIndexing != nil || req.AllowForks != nil
+	if req.Title == nil && req.Description == nil && req.Visibility == nil && len(req.Files) == 0 && !permissionsChanged {
+		return echo.NewHTTPError(http.StatusBadRequest, "no changes requested")
+	}
+
+	// Gist fields
+	updates := map[string]interface{}{"updated_at": time.Now()}
+	if req.Title != nil {
+		if strings.TrimSpace(*req.Title) == "" {
+			return echo.NewHTTPError(http.StatusBadRequest, "title cannot be empty")
+		}
+		updates["title"] = *req.Title
+	}
+	if req.Description != nil {
+		updates["description"] = *req.Description
+	}
+	if req.Visibility != nil {
+		switch models.Visibility(*req.Visibility) {
+		case models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityUnlisted:
+			updates["visibility"] = *req.Visibility
+		default:
+			return echo.NewHTTPError(http.StatusBadRequest, "visibility must be public, private or unlisted")
+		}
+	}
+	if req.AllowEmbed != nil {
+		updates["embed_disabled"] = !*req.AllowEmbed
+	}
+	if req.AllowIndexing != nil {
+		updates["indexing_disabled"] = !*req.AllowIndexing
+	}
+	if req.AllowForks != nil {
+		updates["forks_disabled"] = !*req.AllowForks
+	}
+
+	// Files
+	files, deleted, err := applyFileOperations(gist.ID, gist.Files, req.Files)
+	if err != nil {
+		return err
+	}
+	for _, pf := range files {
+		if pf.isNew || pf.changed {
+			h.formatFile(c, &pf.file)
+		}
+	}
+	if err := h.checkFileLimits(userID, files); err != nil {
+		return err
+	}
+	previousVisibility := gist.Visibility
+	visibility := previousVisibility
+	if req.Visibility != nil {
+		visibility = models.Visibility(*req.Visibility)
+	}
+	policyFiles := make([]contentpolicy.File, 0, len(files))
+	for _, pf := range files {
+		policyFiles = append(policyFiles, contentpolicy.File{Filename: pf.file.Filename, Content: pf.file.Content})
+	}
+	warnings, err := h.checkPolicy(gist.OrganizationID, visibility, policyFiles)
+	if err != nil {
+		return err
+	}
+	for _, pf := range files {
+		if pf.isNew || pf.changed {
+			if err := h.scanFile(c, userID, &gist.ID, &pf.file); err != nil {
+				return err
+			}
+		}
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&gist).Updates(updates).Error; err != nil {
+			return err
+		}
+		if len(deleted) > 0 {
+			if err := tx.Where("gist_id = ? AND id IN ?", gist.ID, deleted).Delete(&models.GistFile{}).Error; err != nil {
+				return err
+			}
+		}
+		for _, pf := range files {
+			switch {
+			case pf.isNew:
+				if err := tx.Create(&pf.file).Error; err != nil {
+					return err
+				}
+			case pf.changed:
+				if err := tx.Model(&pf.file).Select("filename", "content", "language", "size", "lines", "word_count", "read_time_seconds", "complexity", "lint_annotations", "scan_status", "scanned_at", "updated_at").
+					Updates(&pf.file).Error; err != nil {
+					return err
+				}
+			}
+		}
+		return models.RefreshGistStats(tx, gist.ID)
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update gist")
+	}
+
+	// Reload with associations
+	h.db.Preload("User").Preload("Files").First(&gist, gistID)
+
+	// Record the change in the gist's history
+	if len(req.Files) > 0 {
+		message := req.Message
+		if message == "" {
+			message = describeFileOperations(req.Files)
+		}
+		h.recordHistory(c, &gist, userID, message)
+	}
+	go h.webhooks.TriggerGistUpdated(context.Background(), &gist, userID)
+	if visibility != previousVisibility {
+		go h.webhooks.TriggerGistVisibilityChanged(context.Background(), &gist, previousVisibility, userID)
+	}
+
+	response := h.buildGistResponse(&gist, gist.User)
+	response.PolicyWarnings = warnings
+	return c.JSON(http.StatusOK, response)
+}
+
+// applyFileOperations applies ops in order to a copy of existing, the files
+// of gist gistID, and returns the resulting files plus the IDs of stored
+// files that were deleted
+func applyFileOperations(gistID uuid.UUID, existing []models.GistFile, ops []FileOperation) ([]*patchedFile, []uuid.UUID, error) {
+	files := make([]*patchedFile, 0, len(existing)+len(ops))
+	for _, file := range existing {
+		files = append(files, &patchedFile{file: file})
+	}
+	var deleted []uuid.UUID
+
+	find := func(i int, op FileOperation) (int, error) {
+		for idx, pf := range files {
+			if (op.ID != nil && pf.file.ID == *op.ID) || (op.ID == nil && op.Filename != "" && pf.file.Filename == op.Filename) {
+				return idx, nil
+			}
+		}
+		if op.ID == nil && op.Filename == "" {
+			return -1, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: id or filename is required", i))
+		}
+		return -1, echo.NewHTTPError(http.StatusNotFound, fmt.Sprintf("files[%d]: file not found", i))
+	}
+	taken := func(name string, except int) bool {
+		for idx, pf := range files {
+			if idx != except && pf.file.Filename == name {
+				return true
+			}
+		}
+		return false
+	}
+	rename := func(i, idx int, name string) error {
+		if err := validateFilename(i, name); err != nil {
+			return err
+		}
+		if taken(name, idx) {
+			return echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("files[%d]: a file named %q already exists", i, name))
+		}
+		files[idx].file.Filename = name
+		return nil
+	}
+	setContent := func(pf *patchedFile, content string) {
+		pf.file.Content = content
+		pf.file.Size = int64(len(content))
+		pf.file.Lines = countLines(content)
+	}
+
+	for i, op := range ops {
+		switch op.Op {
+		case FileOpAdd:
+			if err := validateFilename(i, op.Filename); err != nil {
+				return nil, nil, err
+			}
+			if taken(op.Filename, -1) {
+				return nil, nil, echo.NewHTTPError(http.StatusConflict, fmt.Sprintf("files[%d]: a file named %q already exists", i, op.Filename))
+			}
+			pf := &patchedFile{isNew: true, file: models.GistFile{ID: uuid.New(), GistID: gistID, Filename: op.Filename}}
+			if op.Content != nil {
+				setContent(pf, *op.Content)
+			}
+			if op.Language != nil {
+				pf.file.Language = *op.Language
+			}
+			files = append(files, pf)
+
+		case FileOpModify, FileOpRename:
+			idx, err := find(i, op)
+			if err != nil {
+				return nil, nil, err
+			}
+			if op.Op == FileOpRename && op.NewFilename == "" {
+				return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: new_filename is required", i))
+			}
+			if op.Op == FileOpModify && op.Content == nil && op.Language == nil && op.NewFilename == "" {
+				return nil, nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: nothing to modify", i))
+			}
+			pf := files[idx]
+			if op.NewFilename != "" && op.NewFilename != pf.file.Filename {
+				if err := rename(i, idx, op.NewFilename); err != nil {
+					return nil, nil, err
+				}
+			}
+			if op.Content != nil {
+				setContent(pf, *op.Content)
+			}
+			if op.Language != nil {
+				pf.file.Language = *op.Language
+			}
+			pf.changed = true
+
+		case FileOpDelete:
+			idx, err := find(i, op)
+			if err != nil {
+				return nil, nil, err
+			}
+			if !files[idx].isNew {
+				deleted = append(deleted, files[idx].file.ID)
+			}
+			files = append(files[:idx], files[idx+1:]...)
+
+		default:
+			return nil, nil, echo.NewHTTPError(http.StatusBadRequest,
+				fmt.Sprintf("files[%d]: op must be one of add, modify, rename or delete", i))
+		}
+	}
+
+	if len(files) == 0 {
+		return nil, nil, echo.NewHTTPError(http.StatusBadRequest, "a gist must have at least one file")
+	}
+	return files, deleted, nil
+}
+
+// checkFileLimits enforces the user's quota tier on the patched files
+func (h *GistHandler) checkFileLimits(userID uuid.UUID, files []*patchedFile) error {
+	quotaFiles := make([]services.QuotaFile, 0, len(files))
+	for _, pf := range files {
+		quotaFiles = append(quotaFiles, services.QuotaFile{Filename: pf.file.Filename, Size: pf.file.Size})
+	}
+	return h.checkQuota(userID, quotaFiles)
+}
+
+// validateFilename rejects names that cannot be stored as a file in the
+// gist's repository
+func validateFilename(i int, name string) error {
+	switch {
+	case strings.TrimSpace(name) == "":
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: filename is required", i))
+	case len(name) > 255:
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: filename is too long", i))
+	case name == "." || name == ".." || strings.ContainsAny(name, "/\\\x00"):
+		return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("files[%d]: invalid filename %q", i, name))
+	}
+	return nil
+}
+
+// describeFileOperations builds a default commit message such as
+// "Rename a.txt to b.txt, delete c.txt"
+func describeFileOperations(ops []FileOperation) string {
+	parts := make([]string, 0, len(ops))
+	for _, op := range ops {
+		name := op.Filename
+		if name == "" && op.ID != nil {
+			name = op.ID.String()
+		}
+		switch op.Op {
+		case FileOpAdd:
+			parts = append(parts, "add "+name)
+		case FileOpRename:
+			parts = append(parts, fmt.Sprintf("rename %s to %s", name, op.NewFilename))
+		case FileOpDelete:
+			parts = append(parts, "delete "+name)
+		default:
+			parts = append(parts, "update "+name)
+		}
+	}
+	message := strings.Join(parts, ", ")
+	return strings.ToUpper(message[:1]) + message[1:]
+}
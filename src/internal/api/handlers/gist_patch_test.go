@@ -0,0 +1,27 @@
+package handlers
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestPatchAddsFileToEmptyGist(t *testing.T) {
+	h, db, _ := setupGistHandlerTest(t)
+	user, gist := createTestGist(t, db)
+	require.NoError(t, db.Where("gist_id = ?", gist.ID).Delete(&models.GistFile{}).Error)
+
+	rec := serveAs(t, h.Patch, user.ID, http.MethodPatch, gist.ID.String(),
+		`{"files":[{"op":"add","filename":"new.txt","content":"hello"}]}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+
+	var files []models.GistFile
+	require.NoError(t, db.Where("gist_id = ?", gist.ID).Find(&files).Error)
+	require.Len(t, files, 1)
+	assert.Equal(t, "new.txt", files[0].Filename)
+	assert.Equal(t, "hello", files[0].Content)
+}
@@ -0,0 +1,195 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/streaming"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// Raw bundle formats accepted by GET /gists/:id/raw?format=
+const (
+	RawFormatText      = "text"
+	RawFormatMultipart = "multipart"
+)
+
+// Raw returns every file of a gist in one response: a plain-text bundle
+// with an index and a separator line before each file, or multipart/mixed
+// with one part per file when ?format=multipart is given or the client
+// accepts multipart/mixed.
+func (h *GistHandler) Raw(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+
+	var gist models.Gist
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired).Preload("Files").First(&gist, gistID).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+
+	if err := h.authorizeRead(c, &gist); err != nil {
+		return err
+	}
+	if err := BurnRead(c, h.db, &gist); err != nil {
+		return err
+	}
+
+	files := gist.Files
+	sort.Slice(files, func(i, j int) bool { return files[i].Filename < files[j].Filename })
+
+	format := c.QueryParam("format")
+	if format == "" {
+		format = RawFormatText
+		if strings.Contains(c.Request().Header.Get(echo.HeaderAccept), "multipart/mixed") {
+			format = RawFormatMultipart
+		}
+	}
+	if format != RawFormatText && format != RawFormatMultipart {
+		return echo.NewHTTPError(http.StatusBadRequest, "format must be text or multipart")
+	}
+
+	// Both formats hold what the text bundle does, and the multipart
+	// boundary is random, so the ETag is taken from the format and the text
+	// bundle. The bundle isn't assembled in memory: it is written once to be
+	// hashed and measured, then again to the client.
+	hasher := httpcache.NewHasher()
+	io.WriteString(hasher, format+"\n")
+	var size byteCount
+	if err := writeRawTextBundle(io.MultiWriter(hasher, &size), &gist, files); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to build response")
+	}
+	c.Response().Header().Set("X-Gist-File-Count", strconv.Itoa(len(files)))
+	httpcache.Revalidate(c, gist.Visibility != models.VisibilityPrivate)
+	c.Response().Header().Add(echo.HeaderVary, echo.HeaderAccept)
+	if httpcache.NotModified(c, hasher.ETag(), gist.UpdatedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+
+	limits := streaming.RouteLimits(h.config, streaming.RouteRaw)
+	if format == RawFormatMultipart {
+		if !limits.Allows(int64(size)) {
+			return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "response too large; clone the gist instead")
+		}
+		// The boundary goes in the header, before the body is written
+		boundary := multipart.NewWriter(io.Discard).Boundary()
+		return streaming.StreamFunc(c, limits, "multipart/mixed; boundary="+boundary, -1, func(out io.Writer) error {
+			return writeRawMultipartBundle(out, boundary, &gist, files)
+		})
+	}
+	return streaming.StreamFunc(c, limits, echo.MIMETextPlainCharsetUTF8, int64(size), func(out io.Writer) error {
+		return writeRawTextBundle(out, &gist, files)
+	})
+}
+
+// byteCount counts the bytes written to it
+type byteCount int64
+
+func (n *byteCount) Write(p []byte) (int, error) {
+	*n += byteCount(len(p))
+	return len(p), nil
+}
+
+// rawIndex lists the gist's files with their sizes in bytes and line
+// counts, one per line
+func rawIndex(gist *models.Gist, files []models.GistFile) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "gist: %s\n", gist.ID)
+	if gist.Title != "" {
+		fmt.Fprintf(&b, "title: %s\n", strings.Join(strings.Fields(gist.Title), " "))
+	}
+	fmt.Fprintf(&b, "files: %d\n", len(files))
+	for _, file := range files {
+		fmt.Fprintf(&b, "file: %s\t%d\t%d\n", file.Filename, len(file.Content), countLines(file.Content))
+	}
+	return b.String()
+}
+
+// writeRawTextBundle writes the plain-text bundle: the index, a blank
+// line, then each file after a "==> filename <==" line. A newline is added
+// to files that don't end with one, so separators always start a line; the
+// sizes in the index are those of the files as stored.
+func writeRawTextBundle(w io.Writer, gist *models.Gist, files []models.GistFile) error {
+	if _, err := io.WriteString(w, rawIndex(gist, files)); err != nil {
+		return err
+	}
+	for _, file := range files {
+		if _, err := fmt.Fprintf(w, "\n==> %s <==\n", file.Filename); err != nil {
+			return err
+		}
+		if _, err := io.WriteString(w, file.Content); err != nil {
+			return err
+		}
+		if file.Content != "" && !strings.HasSuffix(file.Content, "\n") {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// writeRawMultipartBundle writes a multipart/mixed body whose first part is
+// the index, followed by one attachment part per file
+func writeRawMultipartBundle(out io.Writer, boundary string, gist *models.Gist, files []models.GistFile) error {
+	w := multipart.NewWriter(out)
+	if err := w.SetBoundary(boundary); err != nil {
+		return err
+	}
+
+	index := textproto.MIMEHeader{}
+	index.Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	index.Set(echo.HeaderContentDisposition, `inline; name="index"`)
+	part, err := w.CreatePart(index)
+	if err != nil {
+		return err
+	}
+	if _, err := io.WriteString(part, rawIndex(gist, files)); err != nil {
+		return err
+	}
+
+	for _, file := range files {
+		header := textproto.MIMEHeader{}
+		header.Set(echo.HeaderContentType, rawContentType(file.Filename))
+		header.Set(echo.HeaderContentDisposition, mime.FormatMediaType("attachment", map[string]string{"filename": file.Filename}))
+		header.Set(echo.HeaderContentLength, strconv.Itoa(len(file.Content)))
+		part, err := w.CreatePart(header)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(part, file.Content); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// rawContentType guesses a file's content type from its extension,
+// defaulting to plain text
+func rawContentType(filename string) string {
+	contentType := mime.TypeByExtension(filepath.Ext(filename))
+	if contentType == "" || !strings.HasPrefix(contentType, "text/") && !strings.Contains(contentType, "json") &&
+		!strings.Contains(contentType, "xml") && !strings.Contains(contentType, "javascript") {
+		return echo.MIMETextPlainCharsetUTF8
+	}
+	if !strings.Contains(contentType, "charset") {
+		contentType += "; charset=utf-8"
+	}
+	return contentType
+}
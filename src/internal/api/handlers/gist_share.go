@@ -0,0 +1,198 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// ParamShare names the share link ID in share routes
+const ParamShare = "shareID"
+
+// GistShareHandler manages a gist's share links
+type GistShareHandler struct {
+	db     *gorm.DB
+	shares *services.GistShareService
+	urls   *urls.Builder
+}
+
+// NewGistShareHandler creates a new share link handler
+func NewGistShareHandler(db *gorm.DB, config *config.Config) *GistShareHandler {
+	return &GistShareHandler{
+		db:     db,
+		shares: services.NewGistShareService(db),
+		urls:   urls.NewBuilder(config),
+	}
+}
+
+// CreateShareRequest is the body of a new share link. At most one of
+// expires_at and expires_in (seconds) may be set; without either the link
+// lasts until revoked.
+type CreateShareRequest struct {
+	Label     string     `json:"label"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ExpiresIn int        `json:"expires_in,omitempty"`
+}
+
+// ShareResponse is a share link with the URLs it opens. Token and URLs are
+// only returned when the link is created.
+type ShareResponse struct {
+	models.GistShare
+	Active bool              `json:"active"`
+	Token  string            `json:"token,omitempty"`
+	URLs   map[string]string `json:"urls,omitempty"`
+}
+
+// RegisterRoutes registers the share link routes under /gists on an API
+// group
+func (h *GistShareHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.GET("/gists/:"+urls.ParamGist+"/shares", h.List, auth)
+	g.POST("/gists/:"+urls.ParamGist+"/shares", h.Create, auth)
+	g.DELETE("/gists/:"+urls.ParamGist+"/shares/:"+ParamShare, h.Revoke, auth)
+}
+
+// Create makes a share link and returns its token, which can't be fetched
+// again
+func (h *GistShareHandler) Create(c echo.Context) error {
+	gistID, user, err := h.requestGistUser(c)
+	if err != nil {
+		return err
+	}
+	var req CreateShareRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.ExpiresAt != nil && req.ExpiresIn != 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "Set expires_at or expires_in, not both")
+	}
+	if req.ExpiresIn < 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, services.ErrShareExpiry.Error())
+	}
+	expiresAt := req.ExpiresAt
+	if req.ExpiresIn > 0 {
+		at := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		expiresAt = &at
+	}
+
+	share, token, err := h.shares.CreateShare(gistID, user, services.CreateShareInput{
+		Label:     req.Label,
+		ExpiresAt: expiresAt,
+	})
+	if err != nil {
+		return shareError(err)
+	}
+
+	id := gistID.String()
+	return c.JSON(http.StatusCreated, ShareResponse{
+		GistShare: *share,
+		Active:    true,
+		Token:     token,
+		URLs: map[string]string{
+			"api":      urls.Shared(h.urls.URL(urls.APIGist, id), token),
+			"raw":      urls.Shared(h.urls.URL(urls.APIRaw, id), token),
+			"download": urls.Shared(h.urls.URL(urls.APIDownload, id), token),
+			"embed":    urls.Shared(h.urls.URL(urls.GistEmbed, id), token),
+		},
+	})
+}
+
+// List returns a gist's share links, including expired and revoked ones
+func (h *GistShareHandler) List(c echo.Context) error {
+	gistID, user, err := h.requestGistUser(c)
+	if err != nil {
+		return err
+	}
+
+	shares, err := h.shares.ListShares(gistID, user)
+	if err != nil {
+		return shareError(err)
+	}
+	now := time.Now()
+	response := make([]ShareResponse, 0, len(shares))
+	for _, share := range shares {
+		response = append(response, ShareResponse{GistShare: share, Active: share.Active(now)})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"shares": response,
+	})
+}
+
+// Revoke stops a share link from granting access
+func (h *GistShareHandler) Revoke(c echo.Context) error {
+	gistID, user, err := h.requestGistUser(c)
+	if err != nil {
+		return err
+	}
+	shareID, err := uuid.Parse(c.Param(ParamShare))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid share ID")
+	}
+
+	if _, err := h.shares.RevokeShare(gistID, shareID, user); err != nil {
+		return shareError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// requestGistUser returns the gist of the route and the signed in user
+func (h *GistShareHandler) requestGistUser(c echo.Context) (uuid.UUID, *models.User, error) {
+	gistID, err := uuid.Parse(c.Param(urls.ParamGist))
+	if err != nil {
+		return uuid.Nil, nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return uuid.Nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return uuid.Nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	return gistID, &user, nil
+}
+
+// SharedRead reports whether the request carries an active share link of
+// the gist. Responses it lets through are kept out of shared caches and
+// search engines, and don't pass the token on in Referer headers. Share
+// links don't open gists an administrator hid.
+func SharedRead(c echo.Context, shares *services.GistShareService, gist *models.Gist) bool {
+	token := c.QueryParam(urls.QueryShare)
+	if token == "" || gist.HiddenAt != nil {
+		return false
+	}
+	if _, err := shares.Redeem(gist.ID, token); err != nil {
+		return false
+	}
+	header := c.Response().Header()
+	header.Set("Cache-Control", "private, no-store")
+	header.Set("Referrer-Policy", "no-referrer")
+	header.Set("X-Robots-Tag", "noindex, nofollow")
+	return true
+}
+
+// shareError maps share link service errors to HTTP errors
+func shareError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrShareGistNotFound),
+		errors.Is(err, services.ErrShareNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrGistAccessDenied):
+		return echo.NewHTTPError(http.StatusForbidden, "only the gist's owner and organization admins can manage share links")
+	case errors.Is(err, services.ErrShareExpiry),
+		errors.Is(err, services.ErrShareLabel):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrShareLimit):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return orgPolicyError(err)
+	}
+}
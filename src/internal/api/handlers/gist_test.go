@@ -0,0 +1,149 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/webhooks"
+)
+
+func setupGistHandlerTest(t *testing.T) (*GistHandler, *gorm.DB, *config.Config) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	// Webhooks are triggered from their own goroutine; keep it on the
+	// in-memory database
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(
+		&models.User{},
+		&models.Gist{},
+		&models.GistFile{},
+		&models.GistStar{},
+		&models.Tag{},
+		&models.GistTag{},
+		&models.Organization{},
+		&models.OrganizationMember{},
+		&models.OrganizationSettings{},
+		&models.SystemConfig{},
+		&models.Webhook{},
+		&models.WebhookDelivery{},
+	))
+
+	cfg := config.New()
+	return NewGistHandler(db, cfg, nil), db, cfg
+}
+
+// createTestGist saves a user and a gist of theirs with one file
+func createTestGist(t *testing.T, db *gorm.DB) (*models.User, *models.Gist) {
+	user := &models.User{Username: "gopher", Email: "gopher@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	gist := &models.Gist{
+		Title:      "Example",
+		UserID:     &user.ID,
+		Visibility: models.VisibilityPublic,
+		Files:      []models.GistFile{{Filename: "hello.txt", Content: "hello"}},
+	}
+	require.NoError(t, db.Create(gist).Error)
+	return user, gist
+}
+
+// serveAs runs handler for a request made by userID
+func serveAs(t *testing.T, handler echo.HandlerFunc, userID uuid.UUID, method, id, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "/api/v1/gists/"+id, strings.NewReader(body))
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(req, rec)
+	c.SetParamNames("id")
+	c.SetParamValues(id)
+	c.Set("user_id", userID)
+	require.NoError(t, handler(c))
+	return rec
+}
+
+// receiveGistWebhooks subscribes a webhook of the gist to events and
+// returns a function waiting for its next delivery
+func receiveGistWebhooks(t *testing.T, db *gorm.DB, user *models.User, gist *models.Gist, events string) func() webhooks.WebhookPayload {
+	received := make(chan webhooks.WebhookPayload, 10)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhooks.WebhookPayload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err == nil {
+			received <- payload
+		}
+	}))
+	t.Cleanup(server.Close)
+	require.NoError(t, db.Create(&models.Webhook{
+		ID:          uuid.New(),
+		UserID:      &user.ID,
+		GistID:      &gist.ID,
+		URL:         server.URL,
+		Events:      events,
+		IsActive:    true,
+		ContentType: "application/json",
+	}).Error)
+
+	return func() webhooks.WebhookPayload {
+		select {
+		case payload := <-received:
+			return payload
+		case <-time.After(5 * time.Second):
+			t.Fatal("the gist's webhook wasn't delivered")
+			return webhooks.WebhookPayload{}
+		}
+	}
+}
+
+func TestGistWebhooks(t *testing.T) {
+	h, db, cfg := setupGistHandlerTest(t)
+	cfg.Set("webhooks.enabled", true)
+	user, gist := createTestGist(t, db)
+	next := receiveGistWebhooks(t, db, user, gist, `["gist.updated","gist.deleted"]`)
+
+	rec := serveAs(t, h.Patch, user.ID, http.MethodPatch, gist.ID.String(), `{"title":"Renamed"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	payload := next()
+	assert.Equal(t, webhooks.EventGistUpdated, payload.Event)
+	assert.Equal(t, gist.ID.String(), payload.Data.(map[string]interface{})["id"])
+
+	rec = serveAs(t, h.Delete, user.ID, http.MethodDelete, gist.ID.String(), "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, webhooks.EventGistDeleted, next().Event)
+}
+
+func TestGistVisibilityAndStarWebhooks(t *testing.T) {
+	h, db, cfg := setupGistHandlerTest(t)
+	cfg.Set("webhooks.enabled", true)
+	user, gist := createTestGist(t, db)
+	next := receiveGistWebhooks(t, db, user, gist, `["gist.visibility_changed","gist.starred"]`)
+
+	rec := serveAs(t, h.Patch, user.ID, http.MethodPatch, gist.ID.String(), `{"visibility":"unlisted"}`)
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	payload := next()
+	assert.Equal(t, webhooks.EventGistVisibilityChanged, payload.Event)
+	assert.Equal(t, "public", payload.Data.(map[string]interface{})["previous_visibility"])
+
+	rec = serveAs(t, h.Star, user.ID, http.MethodPost, gist.ID.String(), "")
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+	payload = next()
+	assert.Equal(t, webhooks.EventGistStarred, payload.Event)
+	assert.Equal(t, "starred", payload.Data.(map[string]interface{})["action"])
+
+	rec = serveAs(t, h.Unstar, user.ID, http.MethodDelete, gist.ID.String(), "")
+	require.Equal(t, http.StatusNoContent, rec.Code)
+	assert.Equal(t, "unstarred", next().Data.(map[string]interface{})["action"])
+}
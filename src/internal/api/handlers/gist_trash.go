@@ -0,0 +1,85 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// TrashedGistResponse is a deleted gist in the trash
+type TrashedGistResponse struct {
+	GistResponse
+	DeletedAt string `json:"deleted_at"`
+	PurgeAt   string `json:"purge_at,omitempty"` // Omitted while deleted gists are kept until an admin purges them
+}
+
+// Trash lists the requesting user's deleted gists, and those of the
+// organizations they administer, that can still be restored
+func (h *GistHandler) Trash(c echo.Context) error {
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+
+	trashed, err := h.trash.List(user)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch trash")
+	}
+	response := make([]TrashedGistResponse, 0, len(trashed))
+	for _, item := range trashed {
+		entry := TrashedGistResponse{
+			GistResponse: h.buildGistResponse(&item.Gist, nil),
+			DeletedAt:    h.timestamp(item.Gist.DeletedAt.Time),
+		}
+		if item.PurgeAt != nil {
+			entry.PurgeAt = h.timestamp(*item.PurgeAt)
+		}
+		response = append(response, entry)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"gists": response,
+	})
+}
+
+// Restore takes a deleted gist out of the trash
+func (h *GistHandler) Restore(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+	user, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+
+	gist, err := h.trash.Restore(gistID, user)
+	if errors.Is(err, services.ErrTrashGistNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to restore gist")
+	}
+
+	if err := h.db.Preload("User").Preload("Organization").Preload("Files").First(gist, "id = ?", gist.ID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	return c.JSON(http.StatusOK, h.buildGistResponse(gist, gist.User))
+}
+
+// requestUser loads the signed in user
+func (h *GistHandler) requestUser(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return &user, nil
+}
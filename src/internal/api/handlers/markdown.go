@@ -0,0 +1,45 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/markup"
+)
+
+// maxMarkdownPreviewSize bounds the markdown a preview request may contain
+const maxMarkdownPreviewSize = 1 << 20
+
+// MarkdownHandler renders markdown for the editor's preview, the same way
+// gist files and comments are rendered
+type MarkdownHandler struct {
+	markup *markup.Renderer
+}
+
+// NewMarkdownHandler creates a new markdown handler
+func NewMarkdownHandler(db *gorm.DB, config *config.Config) *MarkdownHandler {
+	return &MarkdownHandler{markup: markup.NewRenderer(db, config)}
+}
+
+// RenderMarkdownRequest carries the markdown to render
+type RenderMarkdownRequest struct {
+	Text string `json:"text"`
+}
+
+// Render returns the request's markdown as sanitized HTML
+func (h *MarkdownHandler) Render(c echo.Context) error {
+	var req RenderMarkdownRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if len(req.Text) > maxMarkdownPreviewSize {
+		return echo.NewHTTPError(http.StatusBadRequest, "text is too long")
+	}
+
+	return c.JSON(http.StatusOK, map[string]string{
+		"html": h.markup.Render(req.Text),
+	})
+}
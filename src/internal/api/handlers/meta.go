@@ -0,0 +1,53 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/casapps/casgists/src/internal/api/middleware"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// MetaHandler serves API metadata such as versions and deprecations
+type MetaHandler struct {
+	db           *gorm.DB
+	config       *config.Config
+	deprecations *middleware.DeprecationRegistry
+}
+
+// NewMetaHandler creates a new API metadata handler
+func NewMetaHandler(db *gorm.DB, config *config.Config, deprecations *middleware.DeprecationRegistry) *MetaHandler {
+	return &MetaHandler{
+		db:           db,
+		config:       config,
+		deprecations: deprecations,
+	}
+}
+
+// Versions lists the available API versions
+func (h *MetaHandler) Versions(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"current": "v1",
+		"versions": []map[string]string{
+			{"version": "v1", "status": "stable", "base_url": "/api/v1"},
+			{"version": "v2", "status": "preview", "base_url": "/api/v2"},
+		},
+	})
+}
+
+// ListDeprecations lists deprecated endpoints with their sunset dates
+func (h *MetaHandler) ListDeprecations(c echo.Context) error {
+	deprecations := h.deprecations.List()
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deprecations": deprecations,
+		"total":        len(deprecations),
+	})
+}
+
+// DeprecationUsage reports which callers still use deprecated endpoints (admin only)
+func (h *MetaHandler) DeprecationUsage(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"usage": h.deprecations.Usage(),
+	})
+}
@@ -0,0 +1,71 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/enrichment"
+	"github.com/labstack/echo/v4"
+)
+
+// maxSuggestFiles bounds the files a suggestion request may contain
+const maxSuggestFiles = 20
+
+// MetadataSuggestionHandler suggests gist titles, descriptions and tags for
+// the editor
+type MetadataSuggestionHandler struct {
+	config *config.Config
+	hook   enrichment.Hook
+}
+
+// NewMetadataSuggestionHandler creates a new metadata suggestion handler. A
+// misconfigured hook falls back to the local heuristic.
+func NewMetadataSuggestionHandler(config *config.Config) *MetadataSuggestionHandler {
+	hook, err := enrichment.NewHook(config)
+	if err != nil {
+		slog.Warn("Failed to set up metadata suggestions, using the heuristic", "error", err)
+		hook = enrichment.NewHeuristicHook()
+	}
+	return &MetadataSuggestionHandler{
+		config: config,
+		hook:   hook,
+	}
+}
+
+// SuggestMetadataRequest lists the files to suggest metadata for
+type SuggestMetadataRequest struct {
+	Files []enrichment.File `json:"files"`
+}
+
+// Suggest returns suggested metadata for the files in the request
+func (h *MetadataSuggestionHandler) Suggest(c echo.Context) error {
+	if h.hook == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "metadata suggestions are disabled")
+	}
+
+	var req SuggestMetadataRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if len(req.Files) > maxSuggestFiles {
+		return echo.NewHTTPError(http.StatusBadRequest, "too many files")
+	}
+	hasContent := false
+	for _, file := range req.Files {
+		if strings.TrimSpace(file.Content) != "" {
+			hasContent = true
+			break
+		}
+	}
+	if !hasContent {
+		return echo.NewHTTPError(http.StatusBadRequest, "at least one file with content is required")
+	}
+
+	suggestion, err := h.hook.Suggest(c.Request().Context(), req.Files)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to suggest metadata")
+	}
+	return c.JSON(http.StatusOK, suggestion)
+}
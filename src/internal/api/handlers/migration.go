@@ -2,30 +2,142 @@ package handlers
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
 	"net/http"
+	"strings"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/migration"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // MigrationHandler handles import/export endpoints
 type MigrationHandler struct {
 	db     *gorm.DB
-	config *viper.Viper
+	config *config.Config
+	repos  migration.RepoInitializer
 }
 
 // NewMigrationHandler creates a new migration handler
-func NewMigrationHandler(db *gorm.DB, config *viper.Viper) *MigrationHandler {
+func NewMigrationHandler(db *gorm.DB, config *config.Config, repos migration.RepoInitializer) *MigrationHandler {
 	return &MigrationHandler{
 		db:     db,
 		config: config,
+		repos:  repos,
 	}
 }
 
+// migrationRequest starts an import job from an import source
+type migrationRequest struct {
+	Source      string `json:"source" validate:"required,oneof=gitlab gitea forgejo"`
+	AccessToken string `json:"access_token" validate:"required"`
+	BaseURL     string `json:"base_url"` // Required for Gitea and Forgejo
+	Topic       string `json:"topic"`    // Gitea and Forgejo repositories to import, "gist" by default
+	Limit       int    `json:"limit" validate:"min=0"`
+}
+
+// CreateMigration starts importing the caller's snippets from GitLab or
+// repositories from Gitea or Forgejo. The access token is checked before
+// the job is accepted; the import itself runs in the background and
+// reports on each item through GetMigration.
+func (h *MigrationHandler) CreateMigration(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	var req migrationRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return h.startMigration(c, userID, req)
+}
+
+func (h *MigrationHandler) startMigration(c echo.Context, userID uuid.UUID, req migrationRequest) error {
+	source := migration.ImportSource(strings.ToLower(req.Source))
+	importer, err := migration.NewSourceImporter(source, migration.SourceOptions{
+		BaseURL:     req.BaseURL,
+		AccessToken: req.AccessToken,
+		Topic:       req.Topic,
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	job := migration.NewJob(h.db, importer, migration.JobOptions{
+		UserID:    userID,
+		SourceURL: req.BaseURL,
+		Limit:     req.Limit,
+		Repos:     h.repos,
+		Quota:     services.NewQuotaService(h.db, h.config),
+	})
+	if err := job.Start(c.Request().Context()); err != nil {
+		if errors.Is(err, migration.ErrRateLimited) {
+			return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// The request context ends with the response
+	go func() {
+		if err := job.Run(context.Background()); err != nil {
+			slog.Error("Import job ended early", "job", job.ID(), "error", err)
+		}
+	}()
+
+	report, err := migration.LoadReport(h.db, job.ID(), userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load import job")
+	}
+	return c.JSON(http.StatusAccepted, report)
+}
+
+// ListMigrations returns the caller's import jobs, newest first
+func (h *MigrationHandler) ListMigrations(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	reports, err := migration.ListReports(h.db, userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to list import jobs")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"migrations": reports,
+	})
+}
+
+// GetMigration returns an import job with the outcome of every item
+func (h *MigrationHandler) GetMigration(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	jobID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "Import job not found")
+	}
+	report, err := migration.LoadReport(h.db, jobID, userID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, "Import job not found")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load import job")
+	}
+	return c.JSON(http.StatusOK, report)
+}
+
 // Import handles gist import from various sources
 func (h *MigrationHandler) Import(c echo.Context) error {
 	// Get current user ID from context
@@ -36,7 +148,7 @@ func (h *MigrationHandler) Import(c echo.Context) error {
 
 	// Parse request
 	var req struct {
-		Source       string `json:"source" validate:"required,oneof=github gitlab opengist"`
+		Source       string `json:"source" validate:"required,oneof=github gitlab gitea forgejo opengist"`
 		AccessToken  string `json:"access_token"`
 		BaseURL      string `json:"base_url"` // For self-hosted instances
 		IncludeStars bool   `json:"include_stars"`
@@ -53,6 +165,17 @@ func (h *MigrationHandler) Import(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
+	// Snippet and repository sources run as import jobs
+	switch migration.ImportSource(req.Source) {
+	case migration.SourceGitLab, migration.SourceGitea, migration.SourceForgejo:
+		return h.startMigration(c, userID, migrationRequest{
+			Source:      req.Source,
+			AccessToken: req.AccessToken,
+			BaseURL:     req.BaseURL,
+			Limit:       req.Limit,
+		})
+	}
+
 	// Create import options
 	options := migration.ImportOptions{
 		Source:       migration.ImportSource(req.Source),
@@ -65,12 +188,12 @@ func (h *MigrationHandler) Import(c echo.Context) error {
 		DryRun:       req.DryRun,
 	}
 
-	// Create importer
-	importer := migration.NewImporter(h.db, options)
+	// Create migrator
+	migrator := migration.NewMigrator(h.db, options)
 
 	// Perform import
 	ctx := context.Background()
-	result, err := importer.Import(ctx)
+	result, err := migrator.Import(ctx)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Import failed: %v", err))
 	}
@@ -154,18 +277,32 @@ func (h *MigrationHandler) Export(c echo.Context) error {
 	return c.JSON(http.StatusOK, response)
 }
 
+// ExportUser downloads every gist the caller owns as a zip, with a
+// directory of files per gist and a metadata.json in GitHub's gist format
+func (h *MigrationHandler) ExportUser(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	archive, err := migration.LoadUserArchive(h.db, userID, urls.NewBuilder(h.config))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to export gists")
+	}
+
+	c.Response().Header().Set(echo.HeaderContentType, "application/zip")
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", archive.Filename()))
+	c.Response().WriteHeader(http.StatusOK)
+	if err := archive.Write(c.Response()); err != nil {
+		// The status is sent; a truncated zip fails to open
+		c.Logger().Errorf("Failed to write gist export for user %s: %v", userID, err)
+	}
+	return nil
+}
+
 // GetImportStatus returns the status of an import job
 func (h *MigrationHandler) GetImportStatus(c echo.Context) error {
-	// Get job ID from URL
-	jobID := c.Param("id")
-
-	// TODO: Implement job tracking for async imports
-	// For now, return a placeholder response
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"job_id": jobID,
-		"status": "completed",
-		"message": "Import job tracking not yet implemented",
-	})
+	return h.GetMigration(c)
 }
 
 // GetExportStatus returns the status of an export job
@@ -211,6 +348,22 @@ func (h *MigrationHandler) GetImportFormats(c echo.Context) error {
 			"auth_type":    "token",
 			"supports_self_hosted": true,
 		},
+		{
+			"id":          "gitea",
+			"name":        "Gitea Repositories",
+			"description": "Import repositories tagged with a topic from a Gitea instance",
+			"requires_auth": true,
+			"auth_type":    "token",
+			"supports_self_hosted": true,
+		},
+		{
+			"id":          "forgejo",
+			"name":        "Forgejo Repositories",
+			"description": "Import repositories tagged with a topic from a Forgejo instance",
+			"requires_auth": true,
+			"auth_type":    "token",
+			"supports_self_hosted": true,
+		},
 		{
 			"id":          "opengist",
 			"name":        "OpenGist",
@@ -265,12 +418,18 @@ func (h *MigrationHandler) GetExportFormats(c echo.Context) error {
 }
 
 // RegisterRoutes registers migration routes
-func (h *MigrationHandler) RegisterRoutes(g *echo.Group) {
+func (h *MigrationHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc) {
 	g.GET("/migration/import/formats", h.GetImportFormats)
 	g.GET("/migration/export/formats", h.GetExportFormats)
-	g.POST("/migration/import", h.Import)
-	g.POST("/migration/export", h.Export)
-	g.GET("/migration/import/:id/status", h.GetImportStatus)
-	g.GET("/migration/export/:id/status", h.GetExportStatus)
-	g.GET("/migration/download/:id", h.DownloadExport)
+	g.POST("/migration/import", h.Import, auth)
+	g.POST("/migration/export", h.Export, auth)
+	g.GET("/migration/import/:id/status", h.GetImportStatus, auth)
+	g.GET("/migration/export/:id/status", h.GetExportStatus, auth)
+	g.GET("/migration/download/:id", h.DownloadExport, auth)
+
+	g.POST("/migrations", h.CreateMigration, auth)
+	g.GET("/migrations", h.ListMigrations, auth)
+	g.GET("/migrations/:id", h.GetMigration, auth)
+
+	g.GET("/user/export", h.ExportUser, auth)
 }
\ No newline at end of file
@@ -0,0 +1,369 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// ModerationHandler takes users' reports on gists and lets admins review
+// them, hide gists, suspend users and leave notes for each other
+type ModerationHandler struct {
+	db      *gorm.DB
+	service *services.ModerationService
+	gists   *GistHandler
+}
+
+// NewModerationHandler creates a new moderation handler
+func NewModerationHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *ModerationHandler {
+	return &ModerationHandler{
+		db:      db,
+		service: services.NewModerationService(db),
+		gists:   NewGistHandler(db, config, gitOps),
+	}
+}
+
+// ReportRequest is the body of a report on a gist
+type ReportRequest struct {
+	Reason      string `json:"reason"`
+	Description string `json:"description"`
+}
+
+// CloseReportRequest is the body of a report resolution
+type CloseReportRequest struct {
+	Status     string `json:"status"` // resolved or dismissed
+	Resolution string `json:"resolution"`
+}
+
+// ModerationRequest is the body of hiding, suspending and their reversal
+type ModerationRequest struct {
+	Reason string `json:"reason"`
+}
+
+// ModerationNoteRequest is the body of a moderation note
+type ModerationNoteRequest struct {
+	Body string `json:"body"`
+}
+
+// ReportResponse is a report with who filed it and on which gist
+type ReportResponse struct {
+	models.Report
+	GistTitle        string     `json:"gist_title"`
+	GistOwner        string     `json:"gist_owner,omitempty"`
+	GistHiddenAt     *time.Time `json:"gist_hidden_at,omitempty"`
+	ReporterUsername string     `json:"reporter_username"`
+}
+
+// ModerationNoteResponse is a moderation note with its author's username
+type ModerationNoteResponse struct {
+	models.ModerationNote
+	AuthorUsername string `json:"author_username"`
+}
+
+// RegisterRoutes registers the report route
+func (h *ModerationHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.POST("/gists/:id/report", h.Report, auth)
+}
+
+// RegisterAdminRoutes registers the moderation routes
+func (h *ModerationHandler) RegisterAdminRoutes(g *echo.Group, admin ...echo.MiddlewareFunc) {
+	g.GET("/admin/reports", h.Reports, admin...)
+	g.GET("/admin/reports/:id", h.GetReport, admin...)
+	g.POST("/admin/reports/:id/resolve", h.CloseReport, admin...)
+	g.POST("/admin/gists/:id/hide", h.HideGist, admin...)
+	g.DELETE("/admin/gists/:id/hide", h.UnhideGist, admin...)
+	g.POST("/admin/users/:id/suspend", h.SuspendUser, admin...)
+	g.DELETE("/admin/users/:id/suspend", h.UnsuspendUser, admin...)
+	g.GET("/admin/gists/:id/notes", h.notesOf(models.ModerationSubjectGist), admin...)
+	g.POST("/admin/gists/:id/notes", h.addNoteTo(models.ModerationSubjectGist), admin...)
+	g.GET("/admin/users/:id/notes", h.notesOf(models.ModerationSubjectUser), admin...)
+	g.POST("/admin/users/:id/notes", h.addNoteTo(models.ModerationSubjectUser), admin...)
+}
+
+// Report flags a gist the user can read for the admins to review
+func (h *ModerationHandler) Report(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+	user, err := h.gists.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req ReportRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	var gist models.Gist
+	if err := h.db.Scopes(models.HideDeactivatedOwners, models.HideExpired).First(&gist, "id = ?", gistID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+	}
+	if err := h.gists.authorizeRead(c, &gist); err != nil {
+		return err
+	}
+
+	report, err := h.service.Report(&gist, user, req.Reason, req.Description)
+	if err != nil {
+		return moderationError(err)
+	}
+	return c.JSON(http.StatusCreated, report)
+}
+
+// Reports lists reports, newest first. ?status= keeps those with the
+// status and ?gist= those on one gist.
+func (h *ModerationHandler) Reports(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+	filter := services.ReportFilter{Status: c.QueryParam("status"), Page: page, Limit: limit}
+	if gist := c.QueryParam("gist"); gist != "" {
+		gistID, err := uuid.Parse(gist)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+		}
+		filter.GistID = &gistID
+	}
+
+	reports, total, err := h.service.Reports(filter)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch reports")
+	}
+	response := make([]ReportResponse, 0, len(reports))
+	for i := range reports {
+		response = append(response, h.buildReportResponse(&reports[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"reports": response,
+		"total":   total,
+		"page":    page,
+		"limit":   limit,
+		"pages":   (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// GetReport returns a report
+func (h *ModerationHandler) GetReport(c echo.Context) error {
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid report ID")
+	}
+	report, err := h.service.GetReport(reportID)
+	if err != nil {
+		return moderationError(err)
+	}
+	return c.JSON(http.StatusOK, h.buildReportResponse(report))
+}
+
+// CloseReport resolves or dismisses an open report
+func (h *ModerationHandler) CloseReport(c echo.Context) error {
+	reportID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid report ID")
+	}
+	moderator, err := h.gists.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req CloseReportRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if req.Status == "" {
+		req.Status = models.ReportStatusResolved
+	}
+
+	report, err := h.service.CloseReport(reportID, moderator, req.Status, req.Resolution)
+	if err != nil {
+		return moderationError(err)
+	}
+	return c.JSON(http.StatusOK, h.buildReportResponse(report))
+}
+
+// HideGist hides a gist from everyone but its owner and admins
+func (h *ModerationHandler) HideGist(c echo.Context) error {
+	return h.moderate(c, func(id uuid.UUID, moderator *models.User, reason string) (interface{}, error) {
+		gist, err := h.service.HideGist(id, moderator, reason)
+		if err != nil {
+			return nil, err
+		}
+		return h.gistStatus(gist), nil
+	})
+}
+
+// UnhideGist shows a hidden gist again
+func (h *ModerationHandler) UnhideGist(c echo.Context) error {
+	return h.moderate(c, func(id uuid.UUID, moderator *models.User, reason string) (interface{}, error) {
+		gist, err := h.service.UnhideGist(id, moderator, reason)
+		if err != nil {
+			return nil, err
+		}
+		return h.gistStatus(gist), nil
+	})
+}
+
+// SuspendUser suspends a user and ends their sessions
+func (h *ModerationHandler) SuspendUser(c echo.Context) error {
+	return h.moderate(c, func(id uuid.UUID, moderator *models.User, reason string) (interface{}, error) {
+		user, err := h.service.SuspendUser(id, moderator, reason)
+		if err != nil {
+			return nil, err
+		}
+		return h.userStatus(user), nil
+	})
+}
+
+// UnsuspendUser lifts a user's suspension
+func (h *ModerationHandler) UnsuspendUser(c echo.Context) error {
+	return h.moderate(c, func(id uuid.UUID, moderator *models.User, reason string) (interface{}, error) {
+		user, err := h.service.UnsuspendUser(id, moderator, reason)
+		if err != nil {
+			return nil, err
+		}
+		return h.userStatus(user), nil
+	})
+}
+
+// notesOf returns the handler listing the notes on a gist or user
+func (h *ModerationHandler) notesOf(subjectType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subjectID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid "+subjectType+" ID")
+		}
+		notes, err := h.service.Notes(subjectType, subjectID)
+		if err != nil {
+			return moderationError(err)
+		}
+		response := make([]ModerationNoteResponse, 0, len(notes))
+		for _, note := range notes {
+			response = append(response, ModerationNoteResponse{ModerationNote: note, AuthorUsername: note.Author.Username})
+		}
+		return c.JSON(http.StatusOK, map[string]interface{}{
+			"notes": response,
+		})
+	}
+}
+
+// addNoteTo returns the handler leaving a note on a gist or user
+func (h *ModerationHandler) addNoteTo(subjectType string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		subjectID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid "+subjectType+" ID")
+		}
+		author, err := h.gists.requestUser(c)
+		if err != nil {
+			return err
+		}
+		var req ModerationNoteRequest
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+
+		note, err := h.service.AddNote(subjectType, subjectID, author, req.Body)
+		if err != nil {
+			return moderationError(err)
+		}
+		return c.JSON(http.StatusCreated, ModerationNoteResponse{ModerationNote: *note, AuthorUsername: author.Username})
+	}
+}
+
+// moderate parses the subject ID and the optional reason of a moderation
+// action, and runs it as the signed in admin
+func (h *ModerationHandler) moderate(c echo.Context, action func(uuid.UUID, *models.User, string) (interface{}, error)) error {
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid ID")
+	}
+	moderator, err := h.gists.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req ModerationRequest
+	if c.Request().ContentLength > 0 {
+		if err := c.Bind(&req); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+		}
+	}
+
+	response, err := action(id, moderator, req.Reason)
+	if err != nil {
+		return moderationError(err)
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *ModerationHandler) gistStatus(gist *models.Gist) map[string]interface{} {
+	return map[string]interface{}{
+		"id":            gist.ID,
+		"hidden":        gist.HiddenAt != nil,
+		"hidden_at":     gist.HiddenAt,
+		"hidden_reason": gist.HiddenReason,
+	}
+}
+
+func (h *ModerationHandler) userStatus(user *models.User) map[string]interface{} {
+	return map[string]interface{}{
+		"id":                user.ID,
+		"username":          user.Username,
+		"is_suspended":      user.IsSuspended,
+		"suspended_at":      user.SuspendedAt,
+		"suspension_reason": user.SuspensionReason,
+	}
+}
+
+func (h *ModerationHandler) buildReportResponse(report *models.Report) ReportResponse {
+	response := ReportResponse{
+		Report:           *report,
+		GistTitle:        report.Gist.Title,
+		GistHiddenAt:     report.Gist.HiddenAt,
+		ReporterUsername: report.Reporter.Username,
+	}
+	if report.Gist.UserID != nil {
+		var owner models.User
+		if err := h.db.Select("username").First(&owner, "id = ?", *report.Gist.UserID).Error; err == nil {
+			response.GistOwner = owner.Username
+		}
+	}
+	return response
+}
+
+// moderationError maps moderation service errors to HTTP errors
+func moderationError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrReportNotFound),
+		errors.Is(err, services.ErrModerationGist),
+		errors.Is(err, services.ErrModerationUser):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrReportReason),
+		errors.Is(err, services.ErrReportDescription),
+		errors.Is(err, services.ErrReportOwnGist),
+		errors.Is(err, services.ErrReportStatus),
+		errors.Is(err, services.ErrModerationReason),
+		errors.Is(err, services.ErrModerationNote),
+		errors.Is(err, services.ErrModerationSubject):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrReportDuplicate),
+		errors.Is(err, services.ErrReportClosed):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	case errors.Is(err, services.ErrSuspendAdmin):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "moderation failed")
+	}
+}
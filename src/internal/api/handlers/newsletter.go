@@ -0,0 +1,331 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/newsletter"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// NewsletterHandler handles instance newsletter endpoints
+type NewsletterHandler struct {
+	db         *gorm.DB
+	config     *config.Config
+	dispatcher *newsletter.Dispatcher
+}
+
+// NewNewsletterHandler creates a new newsletter handler
+func NewNewsletterHandler(db *gorm.DB, config *config.Config, dispatcher *newsletter.Dispatcher) *NewsletterHandler {
+	return &NewsletterHandler{
+		db:         db,
+		config:     config,
+		dispatcher: dispatcher,
+	}
+}
+
+// NewsletterRequest creates or updates a newsletter
+type NewsletterRequest struct {
+	Subject    *string `json:"subject"`
+	Body       *string `json:"body"`
+	Recurrence *string `json:"recurrence"`
+}
+
+// NewsletterResponse describes a newsletter and its delivery stats
+type NewsletterResponse struct {
+	models.Newsletter
+	Stats map[string]int64 `json:"stats,omitempty"`
+}
+
+// List returns newsletters, newest first, optionally filtered by ?status=
+func (h *NewsletterHandler) List(c echo.Context) error {
+	query := h.db.Order("created_at DESC")
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var newsletters []models.Newsletter
+	if err := query.Find(&newsletters).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch newsletters")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"newsletters": newsletters,
+		"total":       len(newsletters),
+	})
+}
+
+// Create saves a new draft
+func (h *NewsletterHandler) Create(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req NewsletterRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	n := models.Newsletter{Status: models.NewsletterDraft, CreatedBy: &userID}
+	if err := h.apply(&n, &req); err != nil {
+		return err
+	}
+	if err := h.db.Create(&n).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create newsletter")
+	}
+	return c.JSON(http.StatusCreated, NewsletterResponse{Newsletter: n})
+}
+
+// Get returns a newsletter with its delivery stats
+func (h *NewsletterHandler) Get(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	stats, err := h.dispatcher.Stats(n.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch newsletter stats")
+	}
+	return c.JSON(http.StatusOK, NewsletterResponse{Newsletter: *n, Stats: stats})
+}
+
+// Update changes a draft or scheduled newsletter; omitted fields are left
+// as they are
+func (h *NewsletterHandler) Update(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	if !n.Editable() {
+		return echo.NewHTTPError(http.StatusConflict, "newsletter has already been sent")
+	}
+
+	var req NewsletterRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if err := h.apply(n, &req); err != nil {
+		return err
+	}
+	if err := h.db.Save(n).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update newsletter")
+	}
+	return c.JSON(http.StatusOK, NewsletterResponse{Newsletter: *n})
+}
+
+// Delete removes a newsletter that isn't being sent, with its deliveries
+func (h *NewsletterHandler) Delete(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	if n.Status == models.NewsletterSending {
+		return echo.NewHTTPError(http.StatusConflict, "cancel the newsletter before deleting it")
+	}
+
+	err = h.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("newsletter_id = ?", n.ID).Delete(&models.NewsletterDelivery{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(n).Error
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to delete newsletter")
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// SendTest mails the newsletter to the requesting administrator only
+func (h *NewsletterHandler) SendTest(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	userID, _ := c.Get("user_id").(uuid.UUID)
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	if err := h.dispatcher.SendTest(n, &user); err != nil {
+		if errors.Is(err, newsletter.ErrInvalidNewsletter) {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to send test email: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message": "test email sent",
+		"to":      user.Email,
+	})
+}
+
+// Schedule queues the newsletter for sending, now or at scheduled_at
+func (h *NewsletterHandler) Schedule(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	var req struct {
+		ScheduledAt *time.Time `json:"scheduled_at"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	var at time.Time
+	if req.ScheduledAt != nil {
+		at = *req.ScheduledAt
+	}
+
+	if err := h.dispatcher.Schedule(n, at); err != nil {
+		if errors.Is(err, newsletter.ErrInvalidNewsletter) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to schedule newsletter")
+	}
+	return c.JSON(http.StatusOK, NewsletterResponse{Newsletter: *n})
+}
+
+// Cancel stops a scheduled or in-progress newsletter
+func (h *NewsletterHandler) Cancel(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+	if err := h.dispatcher.Cancel(n); err != nil {
+		if errors.Is(err, newsletter.ErrInvalidNewsletter) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to cancel newsletter")
+	}
+	return c.JSON(http.StatusOK, NewsletterResponse{Newsletter: *n})
+}
+
+// ListDeliveries returns the recipients of a newsletter, optionally filtered
+// by ?status=
+func (h *NewsletterHandler) ListDeliveries(c echo.Context) error {
+	n, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+	query := h.db.Where("newsletter_id = ?", n.ID)
+	if status := c.QueryParam("status"); status != "" {
+		query = query.Where("status = ?", status)
+	}
+
+	var deliveries []models.NewsletterDelivery
+	if err := query.Order("created_at").Limit(limit).Find(&deliveries).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch newsletter deliveries")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deliveries": deliveries,
+	})
+}
+
+// GetSubscription returns whether the user receives the newsletter
+func (h *NewsletterHandler) GetSubscription(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var pref models.UserPreference
+	subscribed := h.db.Where("user_id = ?", userID).First(&pref).Error == nil && pref.Newsletter
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"subscribed": subscribed,
+	})
+}
+
+// UpdateSubscription opts the user in or out of the newsletter
+func (h *NewsletterHandler) UpdateSubscription(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req struct {
+		Subscribed *bool `json:"subscribed"`
+	}
+	if err := c.Bind(&req); err != nil || req.Subscribed == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "subscribed is required")
+	}
+	if err := h.setSubscription(userID, *req.Subscribed); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update newsletter subscription")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"subscribed": *req.Subscribed,
+	})
+}
+
+// Unsubscribe handles the signed link in every newsletter, which works
+// without logging in
+func (h *NewsletterHandler) Unsubscribe(c echo.Context) error {
+	userID, err := uuid.Parse(c.QueryParam("user"))
+	if err != nil || !newsletter.VerifyUnsubscribeToken(h.config, userID, c.QueryParam("token")) {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid unsubscribe link")
+	}
+	if err := h.setSubscription(userID, false); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update newsletter subscription")
+	}
+	return c.String(http.StatusOK, "You have been unsubscribed from the newsletter.")
+}
+
+func (h *NewsletterHandler) setSubscription(userID uuid.UUID, subscribed bool) error {
+	var count int64
+	if err := h.db.Model(&models.UserPreference{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		if !subscribed {
+			return nil
+		}
+		return h.db.Create(&models.UserPreference{ID: uuid.New(), UserID: userID, Newsletter: true}).Error
+	}
+	return h.db.Model(&models.UserPreference{}).
+		Where("user_id = ?", userID).
+		Update("newsletter", subscribed).Error
+}
+
+// apply copies the request onto the newsletter and validates the result
+func (h *NewsletterHandler) apply(n *models.Newsletter, req *NewsletterRequest) error {
+	if req.Subject != nil {
+		n.Subject = *req.Subject
+	}
+	if req.Body != nil {
+		n.Body = *req.Body
+	}
+	if req.Recurrence != nil {
+		n.Recurrence = *req.Recurrence
+	}
+	if err := newsletter.Validate(h.config, n); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+func (h *NewsletterHandler) load(c echo.Context) (*models.Newsletter, error) {
+	newsletterID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "invalid newsletter ID")
+	}
+
+	var n models.Newsletter
+	if err := h.db.First(&n, "id = ?", newsletterID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "newsletter not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch newsletter")
+	}
+	return &n, nil
+}
@@ -0,0 +1,147 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// NodeInfo schema versions served by the instance, newest first
+var nodeInfoVersions = []string{"2.1", "2.0"}
+
+// nodeInfoSchema is the namespace NodeInfo schema relations are published under
+const nodeInfoSchema = "http://nodeinfo.diaspora.software/ns/schema/"
+
+// NodeInfo is a NodeInfo 2.0/2.1 document describing the instance
+type NodeInfo struct {
+	Version           string                 `json:"version"`
+	Software          NodeInfoSoftware       `json:"software"`
+	Protocols         []string               `json:"protocols"`
+	Services          NodeInfoServices       `json:"services"`
+	OpenRegistrations bool                   `json:"openRegistrations"`
+	Usage             NodeInfoUsage          `json:"usage"`
+	Metadata          map[string]interface{} `json:"metadata"`
+}
+
+// NodeInfoSoftware identifies the server software
+type NodeInfoSoftware struct {
+	Name       string `json:"name"`
+	Version    string `json:"version"`
+	Repository string `json:"repository,omitempty"` // 2.1 only
+	Homepage   string `json:"homepage,omitempty"`   // 2.1 only
+}
+
+// NodeInfoServices lists third party sites the instance can talk to
+type NodeInfoServices struct {
+	Inbound  []string `json:"inbound"`
+	Outbound []string `json:"outbound"`
+}
+
+// NodeInfoUsage holds the instance's usage statistics
+type NodeInfoUsage struct {
+	Users      NodeInfoUsers `json:"users"`
+	LocalPosts int64         `json:"localPosts"`
+}
+
+// NodeInfoUsers counts users, and how many of them logged in recently
+type NodeInfoUsers struct {
+	Total          int64 `json:"total"`
+	ActiveHalfyear int64 `json:"activeHalfyear"`
+	ActiveMonth    int64 `json:"activeMonth"`
+}
+
+// NodeInfoDiscovery serves /.well-known/nodeinfo, linking to the NodeInfo
+// documents for each supported schema version
+func (h *MetaHandler) NodeInfoDiscovery(c echo.Context) error {
+	if !h.config.GetBool("nodeinfo.enabled") {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+
+	baseURL := strings.TrimSuffix(h.config.GetString("server.url"), "/")
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+	}
+
+	links := make([]map[string]string, 0, len(nodeInfoVersions))
+	for _, version := range nodeInfoVersions {
+		links = append(links, map[string]string{
+			"rel":  nodeInfoSchema + version,
+			"href": baseURL + "/nodeinfo/" + version,
+		})
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=86400")
+	return c.JSON(http.StatusOK, map[string]interface{}{"links": links})
+}
+
+// NodeInfo serves the NodeInfo document for the requested schema version
+func (h *MetaHandler) NodeInfo(c echo.Context) error {
+	if !h.config.GetBool("nodeinfo.enabled") {
+		return echo.NewHTTPError(http.StatusNotFound, "not found")
+	}
+
+	version := c.Param("version")
+	supported := false
+	for _, v := range nodeInfoVersions {
+		supported = supported || v == version
+	}
+	if !supported {
+		return echo.NewHTTPError(http.StatusNotFound, "unsupported NodeInfo version")
+	}
+
+	appVersion := h.config.GetString("version")
+	if appVersion == "" {
+		appVersion = "dev"
+	}
+
+	info := NodeInfo{
+		Version: version,
+		Software: NodeInfoSoftware{
+			Name:    "casgists",
+			Version: appVersion,
+		},
+		Protocols:         []string{},
+		Services:          NodeInfoServices{Inbound: []string{}, Outbound: []string{}},
+		OpenRegistrations: h.config.GetBool("features.registration"),
+		Metadata: map[string]interface{}{
+			"nodeName":        h.config.GetString("ui.title"),
+			"nodeDescription": h.config.GetString("ui.description"),
+		},
+	}
+	if version != "2.0" {
+		info.Software.Repository = "https://github.com/casapps/casgists"
+		info.Software.Homepage = "https://github.com/casapps/casgists"
+	}
+	if h.config.GetBool("nodeinfo.usage") {
+		info.Usage = h.nodeInfoUsage()
+	}
+
+	c.Response().Header().Set("Cache-Control", "public, max-age=1800")
+	c.Response().Header().Set(echo.HeaderContentType,
+		fmt.Sprintf(`application/json; profile="%s%s#"`, nodeInfoSchema, version))
+	return c.JSON(http.StatusOK, info)
+}
+
+// nodeInfoUsage counts active accounts and public gists. Deactivated
+// accounts are left out, like everywhere else they are hidden.
+func (h *MetaHandler) nodeInfoUsage() NodeInfoUsage {
+	var usage NodeInfoUsage
+	now := time.Now()
+
+	users := func() *gorm.DB {
+		return h.db.Model(&models.User{}).Where("deactivated_at IS NULL")
+	}
+	users().Count(&usage.Users.Total)
+	users().Where("last_login_at >= ?", now.AddDate(0, -6, 0)).Count(&usage.Users.ActiveHalfyear)
+	users().Where("last_login_at >= ?", now.AddDate(0, 0, -30)).Count(&usage.Users.ActiveMonth)
+
+	h.db.Model(&models.Gist{}).Scopes(models.HideDeactivatedOwners).
+		Where("visibility = ?", models.VisibilityPublic).Count(&usage.LocalPosts)
+
+	return usage
+}
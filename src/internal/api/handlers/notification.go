@@ -0,0 +1,309 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// Stream timing
+const (
+	streamHeartbeat    = 25 * time.Second // Keeps proxies from closing idle streams
+	streamWriteTimeout = 10 * time.Second // Drops clients that stop reading
+	maxWatchedGists    = 20
+)
+
+// NotificationHandler serves the signed in user's notification center
+type NotificationHandler struct {
+	db            *gorm.DB
+	notifications *notifications.Service
+}
+
+// NewNotificationHandler creates a new notification handler
+func NewNotificationHandler(db *gorm.DB) *NotificationHandler {
+	return &NotificationHandler{
+		db:            db,
+		notifications: notifications.NewService(db),
+	}
+}
+
+// RegisterRoutes registers the notification routes on an API group. The
+// stream takes an optional session, as it can be opened with a ticket.
+func (h *NotificationHandler) RegisterRoutes(g *echo.Group, auth, optionalAuth echo.MiddlewareFunc) {
+	g.GET("/notifications", h.List, auth)
+	g.GET("/notifications/stream", h.Stream, optionalAuth)
+	g.POST("/notifications/stream/ticket", h.StreamTicket, auth)
+	g.GET("/notifications/unread-count", h.UnreadCount, auth)
+	g.POST("/notifications/read", h.MarkAllRead, auth)
+	g.POST("/notifications/:id/read", h.MarkRead, auth)
+	g.GET("/notifications/preferences", h.GetPreferences, auth)
+	g.PATCH("/notifications/preferences", h.UpdatePreferences, auth)
+}
+
+// List returns the user's notifications, newest first. ?unread=true leaves
+// out those already read.
+func (h *NotificationHandler) List(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	page := 1
+	perPage := notifications.DefaultPerPage
+	if p, err := strconv.Atoi(c.QueryParam("page")); err == nil && p > 0 {
+		page = p
+	}
+	if pp, err := strconv.Atoi(c.QueryParam("per_page")); err == nil && pp > 0 && pp <= notifications.MaxPerPage {
+		perPage = pp
+	}
+	unreadOnly, _ := strconv.ParseBool(c.QueryParam("unread"))
+
+	items, total, err := h.notifications.List(userID, unreadOnly, page, perPage)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch notifications")
+	}
+	unread, err := h.notifications.UnreadCount(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch notifications")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"notifications": items,
+		"unread_count":  unread,
+		"pagination": map[string]interface{}{
+			"page":        page,
+			"per_page":    perPage,
+			"total":       total,
+			"total_pages": (total + int64(perPage) - 1) / int64(perPage),
+		},
+	})
+}
+
+// UnreadCount returns how many notifications the user hasn't read, for the
+// badge in the navigation bar
+func (h *NotificationHandler) UnreadCount(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	unread, err := h.notifications.UnreadCount(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count notifications")
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"unread_count": unread})
+}
+
+// MarkRead marks one notification as read
+func (h *NotificationHandler) MarkRead(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	notificationID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid notification ID")
+	}
+
+	notification, err := h.notifications.MarkRead(userID, notificationID)
+	if errors.Is(err, notifications.ErrNotFound) {
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update notification")
+	}
+	return c.JSON(http.StatusOK, notification)
+}
+
+// MarkAllRead marks all of the user's notifications as read
+func (h *NotificationHandler) MarkAllRead(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	marked, err := h.notifications.MarkAllRead(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update notifications")
+	}
+	return c.JSON(http.StatusOK, map[string]int64{"marked": marked})
+}
+
+// GetPreferences returns which notifications the user gets
+func (h *NotificationHandler) GetPreferences(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	preference, err := h.notifications.Preferences(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch notification preferences")
+	}
+	return c.JSON(http.StatusOK, preference)
+}
+
+// UpdatePreferences turns notification types on or off. Types left out of
+// the request keep their setting.
+func (h *NotificationHandler) UpdatePreferences(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+	var req notifications.PreferenceUpdate
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	preference, err := h.notifications.UpdatePreferences(userID, req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update notification preferences")
+	}
+	return c.JSON(http.StatusOK, preference)
+}
+
+// StreamTicket issues a single-use ticket for opening a stream without an
+// Authorization header
+func (h *NotificationHandler) StreamTicket(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	ticket, expires, err := h.notifications.Streams().IssueTicket(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to issue stream ticket")
+	}
+	return c.JSON(http.StatusCreated, map[string]interface{}{
+		"ticket":     ticket,
+		"expires_at": expires,
+	})
+}
+
+// Stream pushes the user's new notifications as server-sent events, and
+// the comment changes of each gist named by ?gist=. Requests without a
+// session authenticate with ?ticket=.
+func (h *NotificationHandler) Stream(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		var err error
+		if userID, err = h.notifications.Streams().RedeemTicket(c.QueryParam("ticket")); err != nil {
+			return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+		}
+	}
+
+	gistIDs, err := h.watchedGists(c, userID)
+	if err != nil {
+		return err
+	}
+	unread, err := h.notifications.UnreadCount(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count notifications")
+	}
+
+	streams := h.notifications.Streams()
+	sub, err := streams.Subscribe(userID, gistIDs...)
+	if errors.Is(err, notifications.ErrTooManyStreams) {
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to open stream")
+	}
+	defer streams.Unsubscribe(sub)
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, "text/event-stream")
+	res.Header().Set("Cache-Control", "no-cache")
+	res.Header().Set("Connection", "keep-alive")
+	res.Header().Set("X-Accel-Buffering", "no")
+	res.WriteHeader(http.StatusOK)
+
+	controller := http.NewResponseController(res)
+	defer controller.SetWriteDeadline(time.Time{})
+	send := func(event string, data interface{}) error {
+		payload, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		// A client that stops reading fails the write instead of holding
+		// the stream open
+		_ = controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+		if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, payload); err != nil {
+			return err
+		}
+		return controller.Flush()
+	}
+
+	if err := send("ready", map[string]int64{"unread_count": unread}); err != nil {
+		return nil
+	}
+	heartbeat := time.NewTicker(streamHeartbeat)
+	defer heartbeat.Stop()
+	for {
+		select {
+		case <-c.Request().Context().Done():
+			return nil
+		case <-sub.Dropped():
+			// The client fell too far behind; it refetches and reconnects
+			_ = send("overflow", map[string]string{"message": "Too many events were missed; reload and reconnect"})
+			return nil
+		case event := <-sub.Events():
+			if err := send(event.Type, event.Data); err != nil {
+				return nil
+			}
+		case <-heartbeat.C:
+			_ = controller.SetWriteDeadline(time.Now().Add(streamWriteTimeout))
+			if _, err := fmt.Fprint(res, ": heartbeat\n\n"); err != nil {
+				return nil
+			}
+			if err := controller.Flush(); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// watchedGists returns the gists named by ?gist=, which the user must be
+// able to read
+func (h *NotificationHandler) watchedGists(c echo.Context, userID uuid.UUID) ([]uuid.UUID, error) {
+	values := c.QueryParams()["gist"]
+	if len(values) == 0 {
+		return nil, nil
+	}
+	if len(values) > maxWatchedGists {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("A stream can watch at most %d gists", maxWatchedGists))
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	policy := services.NewOrgPolicyService(h.db)
+	gistIDs := make([]uuid.UUID, 0, len(values))
+	for _, value := range values {
+		gistID, err := uuid.Parse(value)
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+		}
+		var gist models.Gist
+		if err := h.db.First(&gist, "id = ?", gistID).Error; err != nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Gist not found")
+		}
+		if err := policy.AuthorizeGist(&gist, &user, services.GistRead); err != nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Gist not found")
+		}
+		gistIDs = append(gistIDs, gistID)
+	}
+	return gistIDs, nil
+}
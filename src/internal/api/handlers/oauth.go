@@ -0,0 +1,222 @@
+package handlers
+
+import (
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/oauth"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// OAuthHandler signs users in through GitHub, GitLab or an OpenID Connect
+// provider
+type OAuthHandler struct {
+	db          *gorm.DB
+	authService *auth.AuthService
+	config      *config.Config
+	providers   *oauth.Manager
+}
+
+// NewOAuthHandler creates a new OAuth handler
+func NewOAuthHandler(db *gorm.DB, authService *auth.AuthService, config *config.Config, providers *oauth.Manager) *OAuthHandler {
+	return &OAuthHandler{
+		db:          db,
+		authService: authService,
+		config:      config,
+		providers:   providers,
+	}
+}
+
+// ListProviders returns the providers users can sign in with
+func (h *OAuthHandler) ListProviders(c echo.Context) error {
+	providers := []map[string]string{}
+	for _, provider := range h.providers.Enabled() {
+		providers = append(providers, map[string]string{
+			"name":         provider.Name,
+			"display_name": provider.DisplayName,
+			"login_url":    urls.Path(oauth.LoginRoute, provider.Name),
+		})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"providers":    providers,
+		"allow_signup": h.providers.AllowSignup() && services.NewRegistrationService(h.db, h.config).ProviderSignup(),
+	})
+}
+
+// Start sends the user to the provider to sign in. The redirect query
+// parameter is where to go once signed in.
+func (h *OAuthHandler) Start(c echo.Context) error {
+	provider, err := h.providers.Provider(c.Param("provider"))
+	if err != nil {
+		return h.fail(c, oauth.CodeUnavailable)
+	}
+
+	state, err := oauth.NewState(provider.Name, c.QueryParam("redirect"))
+	if err != nil {
+		return h.fail(c, oauth.CodeServer)
+	}
+	cookie, err := state.Encode(h.secret())
+	if err != nil {
+		return h.fail(c, oauth.CodeServer)
+	}
+	authURL, err := provider.AuthCodeURL(c.Request().Context(), state, h.callbackURL(provider.Name))
+	if err != nil {
+		slog.Error("OAuth sign in failed to start", "provider", provider.Name, "error", err)
+		return h.fail(c, oauth.CodeProvider)
+	}
+
+	h.setCookie(c, &http.Cookie{
+		Name:     oauth.StateCookie,
+		Value:    cookie,
+		Path:     "/auth/oauth",
+		MaxAge:   int(oauth.StateTTL.Seconds()),
+		HttpOnly: true,
+		// Lax still sends the cookie on the provider's redirect back
+		SameSite: http.SameSiteLaxMode,
+	})
+	return c.Redirect(http.StatusFound, authURL)
+}
+
+// Callback completes a sign in: it checks the state, exchanges the code,
+// finds or creates the user's account and starts a session. The tokens
+// are set as a cookie and handed to the login page in the URL fragment,
+// which the browser never sends to a server.
+func (h *OAuthHandler) Callback(c echo.Context) error {
+	name := c.Param("provider")
+	cookie, err := c.Cookie(oauth.StateCookie)
+	// The state can be used once, whatever the outcome
+	h.setCookie(c, &http.Cookie{Name: oauth.StateCookie, Path: "/auth/oauth", MaxAge: -1, HttpOnly: true})
+	if err != nil {
+		return h.fail(c, oauth.CodeInvalidState)
+	}
+	state, err := oauth.DecodeState(cookie.Value, h.secret(), name, c.QueryParam("state"))
+	if err != nil {
+		return h.fail(c, oauth.ErrorCode(err))
+	}
+	if c.QueryParam("error") != "" {
+		return h.fail(c, oauth.CodeDenied)
+	}
+
+	provider, err := h.providers.Provider(name)
+	if err != nil {
+		return h.fail(c, oauth.CodeUnavailable)
+	}
+
+	ctx := c.Request().Context()
+	accessToken, err := provider.Exchange(ctx, c.QueryParam("code"), state.Verifier, h.callbackURL(name))
+	if err != nil {
+		slog.Warn("OAuth sign in failed", "provider", name, "error", err)
+		return h.fail(c, oauth.CodeProvider)
+	}
+	identity, err := provider.Identity(ctx, accessToken)
+	if err != nil {
+		slog.Warn("OAuth sign in failed", "provider", name, "error", err)
+		return h.fail(c, oauth.CodeProvider)
+	}
+
+	// New accounts follow the registration rules, which provider sign in
+	// can't always meet
+	registration := services.NewRegistrationService(h.db, h.config)
+	allowSignup := h.providers.AllowSignup() && registration.ProviderSignup() && registration.CheckEmail(identity.Email) == nil
+	user, _, err := oauth.SignIn(h.db, identity, allowSignup)
+	if err != nil {
+		if oauth.ErrorCode(err) == oauth.CodeServer {
+			slog.Warn("OAuth sign in failed", "provider", name, "error", err)
+		}
+		return h.fail(c, oauth.ErrorCode(err))
+	}
+
+	// The provider stands in for the password, so the same account checks
+	// as a password login apply
+	accounts := services.NewAccountService(h.db, h.config)
+	if user.IsDeactivated() {
+		if !accounts.CanReactivate(user) {
+			return h.fail(c, oauth.CodeAccountDisabled)
+		}
+	} else if !user.IsActive || user.IsSuspended {
+		return h.fail(c, oauth.CodeAccountDisabled)
+	}
+	// A provider login can't stand in for the second factor or a
+	// security key
+	passkeys := passkey.NewService(h.db, h.config)
+	if user.TwoFactorEnabled || passkeys.Required(user) || (passkeys.Enabled() && passkeys.HasCredentials(user.ID)) {
+		return h.fail(c, oauth.CodeTwoFactorRequired)
+	}
+	if user.IsDeactivated() {
+		if err := accounts.Reactivate(user); err != nil {
+			return h.fail(c, oauth.CodeServer)
+		}
+	}
+
+	// Create session
+	session := &models.Session{
+		UserID:     user.ID,
+		IPAddress:  c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		ExpiresAt:  time.Now().Add(time.Duration(h.config.GetInt("security.session_timeout")) * time.Second),
+		LastUsedAt: time.Now(),
+	}
+	if err := h.db.Create(session).Error; err != nil {
+		return h.fail(c, oauth.CodeServer)
+	}
+
+	tokenPair, err := h.authService.GenerateTokenPair(user, session.ID)
+	if err != nil {
+		return h.fail(c, oauth.CodeServer)
+	}
+
+	session.Token = tokenPair.AccessToken
+	session.RefreshToken = tokenPair.RefreshToken
+	if err := h.db.Save(session).Error; err != nil {
+		return h.fail(c, oauth.CodeServer)
+	}
+
+	user.LastLoginAt = &session.CreatedAt
+	h.db.Save(user)
+
+	h.setCookie(c, &http.Cookie{
+		Name:     "access_token",
+		Value:    tokenPair.AccessToken,
+		Path:     "/",
+		Expires:  tokenPair.ExpiresAt,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+	fragment := url.Values{
+		"access_token":  {tokenPair.AccessToken},
+		"refresh_token": {tokenPair.RefreshToken},
+		"redirect":      {state.Redirect},
+	}
+	return c.Redirect(http.StatusFound, "/login#"+fragment.Encode())
+}
+
+// fail sends the user back to the login page with an error code
+func (h *OAuthHandler) fail(c echo.Context, code string) error {
+	return c.Redirect(http.StatusFound, "/login?oauth_error="+url.QueryEscape(code))
+}
+
+// callbackURL is the redirect URI registered with the provider
+func (h *OAuthHandler) callbackURL(provider string) string {
+	return urls.NewBuilder(h.config).URL(oauth.CallbackRoute, provider)
+}
+
+// secret signs the state cookie
+func (h *OAuthHandler) secret() []byte {
+	return []byte(h.config.GetString("security.secret_key"))
+}
+
+// setCookie marks cookies secure when the site is served over HTTPS
+func (h *OAuthHandler) setCookie(c echo.Context, cookie *http.Cookie) {
+	cookie.Secure = c.Scheme() == "https" || strings.HasPrefix(h.config.GetString("server.url"), "https://")
+	c.SetCookie(cookie)
+}
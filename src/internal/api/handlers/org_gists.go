@@ -0,0 +1,161 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// OrgGistHandler serves organization gists by their /o/:org/:slug
+// addresses and transfers gists into organizations
+type OrgGistHandler struct {
+	db      *gorm.DB
+	config  *config.Config
+	service *services.OrgGistService
+	gists   *GistHandler
+}
+
+// NewOrgGistHandler creates a new organization gist handler
+func NewOrgGistHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *OrgGistHandler {
+	return &OrgGistHandler{
+		db:      db,
+		config:  config,
+		service: services.NewOrgGistService(db),
+		gists:   NewGistHandler(db, config, gitOps),
+	}
+}
+
+// List returns a page of the organization's gists
+func (h *OrgGistHandler) List(c echo.Context) error {
+	view, err := h.service.View(c.Param("name"), requestViewer(h.db, c))
+	if err != nil {
+		return orgGistError(err)
+	}
+
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	gists, total, err := h.service.ListGists(view, page, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gists")
+	}
+	for i := range gists {
+		gists[i].Organization = view.Organization
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"gists": h.gists.buildGistListResponse(gists),
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// Get returns the gist at /o/:org/:slug. Old addresses answer 301 with the
+// gist's current API location.
+func (h *OrgGistHandler) Get(c echo.Context) error {
+	view, err := h.service.View(c.Param("name"), requestViewer(h.db, c))
+	if err != nil {
+		return orgGistError(err)
+	}
+	gist, moved, err := h.service.Resolve(view, c.Param("slug"))
+	if err != nil {
+		return orgGistError(err)
+	}
+	if moved != "" {
+		c.Response().Header().Set("X-Gist-Location", moved)
+		return c.Redirect(http.StatusMovedPermanently, "/api/v1/gists/"+gist.ID.String())
+	}
+
+	gist.Organization = view.Organization
+	return c.JSON(http.StatusOK, h.gists.buildGistDetailResponse(gist, nil))
+}
+
+// RenameOrgGistRequest changes an organization gist's slug
+type RenameOrgGistRequest struct {
+	Slug string `json:"slug"`
+}
+
+// Rename changes the slug of an organization gist; the old address keeps
+// redirecting to it
+func (h *OrgGistHandler) Rename(c echo.Context) error {
+	var req RenameOrgGistRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	view, err := h.service.View(c.Param("name"), requestViewer(h.db, c))
+	if err != nil {
+		return orgGistError(err)
+	}
+	gist, err := h.service.Rename(view, c.Param("slug"), req.Slug)
+	if err != nil {
+		return orgGistError(err)
+	}
+
+	gist.Organization = view.Organization
+	return c.JSON(http.StatusOK, h.gists.buildGistResponse(gist, nil))
+}
+
+// TransferGistRequest moves a gist into an organization
+type TransferGistRequest struct {
+	Organization string `json:"organization"`
+	Slug         string `json:"slug"` // Derived from the gist name or title when empty
+}
+
+// Transfer moves one of the user's gists into an organization
+func (h *OrgGistHandler) Transfer(c echo.Context) error {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid gist ID")
+	}
+	var req TransferGistRequest
+	if err := c.Bind(&req); err != nil || req.Organization == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "organization is required")
+	}
+	user := requestViewer(h.db, c)
+	if user == nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	gist, org, err := h.service.TransferToOrganization(gistID, user, req.Organization, req.Slug)
+	if err != nil {
+		return orgGistError(err)
+	}
+
+	if err := h.db.Preload("Files").First(gist, "id = ?", gist.ID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	gist.Organization = org
+	return c.JSON(http.StatusOK, h.gists.buildGistResponse(gist, nil))
+}
+
+// orgGistError maps organization gist errors to HTTP errors
+func orgGistError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrOrgGistNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrGistNotOwner):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrGistSlugInvalid):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrGistSlugTaken):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return orgPolicyError(err)
+	}
+}
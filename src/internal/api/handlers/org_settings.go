@@ -0,0 +1,170 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// OrgSettingsHandler handles organization settings endpoints
+type OrgSettingsHandler struct {
+	db     *gorm.DB
+	config *config.Config
+	policy *services.OrgPolicyService
+}
+
+// NewOrgSettingsHandler creates a new organization settings handler
+func NewOrgSettingsHandler(db *gorm.DB, config *config.Config) *OrgSettingsHandler {
+	return &OrgSettingsHandler{
+		db:     db,
+		config: config,
+		policy: services.NewOrgPolicyService(db),
+	}
+}
+
+// OrgSettingsResponse describes an organization's settings
+type OrgSettingsResponse struct {
+	DefaultGistVisibility   string    `json:"default_gist_visibility"`
+	RequireTwoFactor        bool      `json:"require_two_factor"`
+	MemberGistCreation      string    `json:"member_gist_creation"`
+	AllowedWebhookDomains   []string  `json:"allowed_webhook_domains"`
+	GistNamePattern         string    `json:"gist_name_pattern"`
+	MembersWithoutTwoFactor []string  `json:"members_without_two_factor,omitempty"`
+	UpdatedAt               time.Time `json:"updated_at"`
+
+	// Stricter content rules for the organization's gists, on top of the
+	// instance's content policy
+	ContentPolicy models.ContentPolicy `json:"content_policy"`
+}
+
+// GetSettings returns an organization's settings to its members
+func (h *OrgSettingsHandler) GetSettings(c echo.Context) error {
+	user, org, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	role, err := h.policy.MemberRole(org.ID, user.ID)
+	if err != nil {
+		return orgPolicyError(err)
+	}
+
+	settings, err := h.policy.GetSettings(org.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch organization settings")
+	}
+
+	return c.JSON(http.StatusOK, h.newResponse(org.ID, settings, models.IsOrgAdminRole(role)))
+}
+
+// UpdateSettings changes an organization's settings (owners and admins only)
+func (h *OrgSettingsHandler) UpdateSettings(c echo.Context) error {
+	user, org, err := h.load(c)
+	if err != nil {
+		return err
+	}
+
+	if err := h.policy.AuthorizeAdmin(org.ID, user); err != nil {
+		return orgPolicyError(err)
+	}
+
+	var req struct {
+		DefaultGistVisibility *string               `json:"default_gist_visibility"`
+		RequireTwoFactor      *bool                 `json:"require_two_factor"`
+		MemberGistCreation    *string               `json:"member_gist_creation"`
+		AllowedWebhookDomains []string              `json:"allowed_webhook_domains"`
+		GistNamePattern       *string               `json:"gist_name_pattern"`
+		ContentPolicy         *models.ContentPolicy `json:"content_policy"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+
+	// Don't let an admin lock themselves out
+	if req.RequireTwoFactor != nil && *req.RequireTwoFactor && !user.TwoFactorEnabled {
+		return echo.NewHTTPError(http.StatusForbidden, "enable two-factor authentication on your own account first")
+	}
+
+	settings, err := h.policy.UpdateSettings(org.ID, services.OrgSettingsUpdate{
+		DefaultGistVisibility: req.DefaultGistVisibility,
+		RequireTwoFactor:      req.RequireTwoFactor,
+		MemberGistCreation:    req.MemberGistCreation,
+		AllowedWebhookDomains: req.AllowedWebhookDomains,
+		GistNamePattern:       req.GistNamePattern,
+		ContentPolicy:         req.ContentPolicy,
+	})
+	if err != nil {
+		return orgPolicyError(err)
+	}
+
+	return c.JSON(http.StatusOK, h.newResponse(org.ID, settings, true))
+}
+
+// load resolves the current user and the organization named in the URL
+func (h *OrgSettingsHandler) load(c echo.Context) (*models.User, *models.Organization, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	org, err := h.policy.FindOrganization(c.Param("name"))
+	if err != nil {
+		return nil, nil, orgPolicyError(err)
+	}
+
+	return &user, org, nil
+}
+
+func (h *OrgSettingsHandler) newResponse(orgID uuid.UUID, settings *models.OrganizationSettings, isAdmin bool) OrgSettingsResponse {
+	response := OrgSettingsResponse{
+		DefaultGistVisibility: string(settings.DefaultGistVisibility),
+		RequireTwoFactor:      settings.RequireTwoFactor,
+		MemberGistCreation:    settings.MemberGistCreation,
+		AllowedWebhookDomains: settings.WebhookDomains(),
+		GistNamePattern:       settings.GistNamePattern,
+		UpdatedAt:             settings.UpdatedAt,
+		ContentPolicy:         settings.ContentPolicy,
+	}
+	if response.AllowedWebhookDomains == nil {
+		response.AllowedWebhookDomains = []string{}
+	}
+
+	// Admins see who still has to enable 2FA
+	if isAdmin && settings.RequireTwoFactor {
+		response.MembersWithoutTwoFactor, _ = h.policy.MembersWithoutTwoFactor(orgID)
+	}
+
+	return response
+}
+
+// orgPolicyError maps organization policy errors to HTTP errors
+func orgPolicyError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrOrgNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrOrgNotMember),
+		errors.Is(err, services.ErrOrgAdminRequired),
+		errors.Is(err, services.ErrOrgTwoFactorRequired),
+		errors.Is(err, services.ErrOrgGistCreationDenied),
+		errors.Is(err, services.ErrOrgViewerReadOnly):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrOrgGistNameInvalid),
+		errors.Is(err, services.ErrOrgWebhookDomainNotAllowed),
+		errors.Is(err, services.ErrOrgInvalidSettings):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to apply organization policy")
+	}
+}
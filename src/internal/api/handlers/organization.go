@@ -1,27 +1,34 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
-	"strconv"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/webhooks"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // OrganizationHandler handles organization-related endpoints
 type OrganizationHandler struct {
-	db     *gorm.DB
-	config *viper.Viper
+	db       *gorm.DB
+	config   *config.Config
+	webhooks *webhooks.Service
+	notifier *notifications.Service
 }
 
 // NewOrganizationHandler creates a new organization handler
-func NewOrganizationHandler(db *gorm.DB, config *viper.Viper) *OrganizationHandler {
+func NewOrganizationHandler(db *gorm.DB, config *config.Config) *OrganizationHandler {
 	return &OrganizationHandler{
-		db:     db,
-		config: config,
+		db:       db,
+		config:   config,
+		webhooks: webhooks.NewService(db, config),
+		notifier: notifications.NewService(db),
 	}
 }
 
@@ -121,6 +128,8 @@ func (h *OrganizationHandler) Create(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to commit transaction")
 	}
 
+	go h.webhooks.TriggerOrgCreated(context.Background(), orgEventData(org), userID)
+
 	// Return organization with basic info
 	// (Owner info not directly available in this model structure)
 
@@ -134,9 +143,7 @@ func (h *OrganizationHandler) Get(c echo.Context) error {
 
 	// Find organization
 	var org models.Organization
-	if err := h.db.Where("name = ?", orgName).
-		Preload("Owner").
-		First(&org).Error; err != nil {
+	if err := h.db.Where("name = ?", orgName).First(&org).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "Organization not found")
 		}
@@ -403,41 +410,72 @@ func (h *OrganizationHandler) AddMember(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch user")
 	}
 
-	// Check if user is already a member
-	var existingCount int64
-	h.db.Model(&models.OrganizationUser{}).
-		Where("organization_id = ? AND user_id = ?", org.ID, userToAdd.ID).
-		Count(&existingCount)
-	
-	if existingCount > 0 {
-		return echo.NewHTTPError(http.StatusConflict, "User is already a member")
+	// Members must have 2FA enabled if the organization requires it
+	settings, err := services.NewOrgPolicyService(h.db).GetSettings(org.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch organization settings")
+	}
+	if settings.RequireTwoFactor && !userToAdd.TwoFactorEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "This organization requires members to enable two-factor authentication")
 	}
 
-	// Parse request for role
+	// Parse request for role: viewers read the organization's private gists,
+	// members also create and edit them, admins also delete them
 	var req struct {
-		Role string `json:"role" validate:"required,oneof=member admin"`
+		Role string `json:"role"`
 	}
-
-	if err := c.Bind(&req); err != nil {
+	c.Bind(&req)
+	switch req.Role {
+	case "":
 		req.Role = "member" // Default to member
-	}
-
-	// Create membership
-	newMember := &models.OrganizationUser{
-		ID:             uuid.New(),
-		OrganizationID: org.ID,
-		UserID:         userToAdd.ID,
-		Role:           req.Role,
-	}
-
-	if err := h.db.Create(newMember).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add member")
+	case "member", "admin", "viewer":
+	default:
+		return echo.NewHTTPError(http.StatusBadRequest, "Role must be member, admin or viewer")
+	}
+
+	// Adding an existing member changes their role
+	status := http.StatusOK
+	var newMember models.OrganizationUser
+	err = h.db.Where("organization_id = ? AND user_id = ?", org.ID, userToAdd.ID).First(&newMember).Error
+	switch {
+	case err == nil:
+		if newMember.Role == "owner" {
+			return echo.NewHTTPError(http.StatusBadRequest, "Cannot change the organization owner's role")
+		}
+		previousRole := newMember.Role
+		if err := h.db.Model(&newMember).Update("role", req.Role).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update member")
+		}
+		if previousRole != req.Role {
+			go h.webhooks.TriggerOrgMemberRoleChanged(context.Background(), orgEventData(&org), memberEventData(&userToAdd), req.Role, previousRole, userID)
+		}
+	case err == gorm.ErrRecordNotFound:
+		newMember = models.OrganizationUser{
+			ID:             uuid.New(),
+			OrganizationID: org.ID,
+			UserID:         userToAdd.ID,
+			Role:           req.Role,
+		}
+		if err := h.db.Create(&newMember).Error; err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to add member")
+		}
+		go h.webhooks.TriggerOrgMemberAdded(context.Background(), orgEventData(&org), memberEventData(&userToAdd), req.Role, userID)
+		status = http.StatusCreated
+
+		var actor models.User
+		if err := h.db.First(&actor, "id = ?", userID).Error; err == nil {
+			if err := h.notifier.AddedToOrganization(userToAdd.ID, org.Name, req.Role, userID, actor.Username); err != nil {
+				c.Logger().Errorf("Failed to notify %s of organization membership: %v", userToAdd.Username, err)
+			}
+		}
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch member")
 	}
 
 	// Load user details
-	h.db.Model(newMember).Association("User").Find(&newMember.User)
+	newMember.User = userToAdd
 
-	return c.JSON(http.StatusCreated, map[string]interface{}{
+	return c.JSON(status, map[string]interface{}{
 		"user": map[string]interface{}{
 			"id":           newMember.User.ID,
 			"username":     newMember.User.Username,
@@ -512,92 +550,42 @@ func (h *OrganizationHandler) RemoveMember(c echo.Context) error {
 		Delete(&models.OrganizationUser{}).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to remove member")
 	}
+	go h.webhooks.TriggerOrgMemberRemoved(context.Background(), orgEventData(&org), memberEventData(&userToRemove), targetMember.Role, userID)
 
 	return c.NoContent(http.StatusNoContent)
 }
 
-// GetGists returns all gists for an organization
-func (h *OrganizationHandler) GetGists(c echo.Context) error {
-	// Get org name from URL
-	orgName := c.Param("name")
-
-	// Parse pagination
-	page, _ := strconv.Atoi(c.QueryParam("page"))
-	if page < 1 {
-		page = 1
+// orgEventData describes an organization in webhook payloads
+func orgEventData(org *models.Organization) webhooks.OrgEventData {
+	return webhooks.OrgEventData{
+		ID:          org.ID,
+		Name:        org.Name,
+		DisplayName: org.DisplayName,
+		CreatedAt:   org.CreatedAt,
 	}
-	limit, _ := strconv.Atoi(c.QueryParam("limit"))
-	if limit <= 0 || limit > 100 {
-		limit = 30
-	}
-
-	// Find organization
-	var org models.Organization
-	if err := h.db.Where("name = ?", orgName).First(&org).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Organization not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch organization")
-	}
-
-	// Build query
-	query := h.db.Model(&models.Gist{}).
-		Where("organization_id = ?", org.ID)
-
-	// Check if user has access to private gists
-	userID, authenticated := c.Get("user_id").(uuid.UUID)
-	if org.IsPublic || !authenticated {
-		// Only show public gists
-		query = query.Where("is_public = ?", true)
-	} else {
-		// Check if user is a member
-		var memberCount int64
-		h.db.Model(&models.OrganizationUser{}).
-			Where("organization_id = ? AND user_id = ?", org.ID, userID).
-			Count(&memberCount)
-		
-		if memberCount == 0 {
-			// Not a member, only show public gists
-			query = query.Where("is_public = ?", true)
-		}
-		// If member, show all gists
-	}
-
-	// Count total
-	var total int64
-	query.Count(&total)
+}
 
-	// Get gists
-	var gists []models.Gist
-	offset := (page - 1) * limit
-	if err := query.
-		Preload("User").
-		Preload("Files").
-		Order("updated_at DESC").
-		Offset(offset).
-		Limit(limit).
-		Find(&gists).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch gists")
+// memberEventData describes an organization member in webhook payloads
+func memberEventData(user *models.User) webhooks.UserEventData {
+	return webhooks.UserEventData{
+		ID:          user.ID,
+		Username:    user.Username,
+		DisplayName: user.DisplayName,
+		Bio:         user.Bio,
+		CreatedAt:   user.CreatedAt,
+		UpdatedAt:   user.UpdatedAt,
 	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"gists": gists,
-		"total": total,
-		"page":  page,
-		"limit": limit,
-		"pages": (total + int64(limit) - 1) / int64(limit),
-	})
 }
 
-// RegisterRoutes registers organization routes
-func (h *OrganizationHandler) RegisterRoutes(g *echo.Group) {
-	g.GET("/orgs", h.List)
-	g.POST("/orgs", h.Create)
-	g.GET("/orgs/:name", h.Get)
-	g.PATCH("/orgs/:name", h.Update)
-	g.DELETE("/orgs/:name", h.Delete)
-	g.GET("/orgs/:name/members", h.GetMembers)
-	g.PUT("/orgs/:name/members/:username", h.AddMember)
-	g.DELETE("/orgs/:name/members/:username", h.RemoveMember)
-	g.GET("/orgs/:name/gists", h.GetGists)
+// RegisterRoutes registers organization routes. auth guards the routes that
+// need a user, optionalAuth the ones public organizations open to everyone.
+func (h *OrganizationHandler) RegisterRoutes(g *echo.Group, auth, optionalAuth echo.MiddlewareFunc) {
+	g.GET("/orgs", h.List, auth)
+	g.POST("/orgs", h.Create, auth)
+	g.GET("/orgs/:name", h.Get, optionalAuth)
+	g.PATCH("/orgs/:name", h.Update, auth)
+	g.DELETE("/orgs/:name", h.Delete, auth)
+	g.GET("/orgs/:name/members", h.GetMembers, optionalAuth)
+	g.PUT("/orgs/:name/members/:username", h.AddMember, auth)
+	g.DELETE("/orgs/:name/members/:username", h.RemoveMember, auth)
 }
\ No newline at end of file
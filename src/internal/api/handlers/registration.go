@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// RegistrationHandler tells clients how to sign up and lets admins manage
+// invite codes and approve new accounts
+type RegistrationHandler struct {
+	db      *gorm.DB
+	service *services.RegistrationService
+}
+
+// NewRegistrationHandler creates a new registration handler
+func NewRegistrationHandler(db *gorm.DB, config *config.Config) *RegistrationHandler {
+	return &RegistrationHandler{
+		db:      db,
+		service: services.NewRegistrationService(db, config),
+	}
+}
+
+// CreateInviteRequest is the body of a new invite code
+type CreateInviteRequest struct {
+	Label     string     `json:"label"`
+	MaxUses   *int       `json:"max_uses"`   // Defaults to 1; 0 for no limit
+	ExpiresIn int64      `json:"expires_in"` // Seconds; alternative to expires_at
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// InviteResponse is an invite code. Code is only set when it is created.
+type InviteResponse struct {
+	models.InviteCode
+	Active bool   `json:"active"`
+	Code   string `json:"code,omitempty"`
+}
+
+// PendingUserResponse is an account awaiting approval
+type PendingUserResponse struct {
+	ID        uuid.UUID  `json:"id"`
+	Username  string     `json:"username"`
+	Email     string     `json:"email"`
+	InviteID  *uuid.UUID `json:"invite_code_id,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// RegisterRoutes registers the route telling clients how to sign up
+func (h *RegistrationHandler) RegisterRoutes(g *echo.Group) {
+	g.GET("/auth/registration", h.Settings)
+}
+
+// RegisterAdminRoutes registers the invite code and approval routes
+func (h *RegistrationHandler) RegisterAdminRoutes(g *echo.Group, admin ...echo.MiddlewareFunc) {
+	g.GET("/admin/invites", h.ListInvites, admin...)
+	g.POST("/admin/invites", h.CreateInvite, admin...)
+	g.DELETE("/admin/invites/:id", h.RevokeInvite, admin...)
+	g.GET("/admin/registrations", h.ListPending, admin...)
+	g.POST("/admin/registrations/:id/approve", h.Approve, admin...)
+	g.DELETE("/admin/registrations/:id", h.Reject, admin...)
+}
+
+// Settings returns whether sign up is open and what it asks for. The
+// domain lists are left out, so they aren't handed to spammers.
+func (h *RegistrationHandler) Settings(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"open":             h.service.Open(),
+		"invite_only":      h.service.InviteOnly(),
+		"require_approval": h.service.RequireApproval(),
+	})
+}
+
+// ListInvites returns every invite code, newest first
+func (h *RegistrationHandler) ListInvites(c echo.Context) error {
+	invites, err := h.service.Invites()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch invite codes")
+	}
+	now := time.Now()
+	response := make([]InviteResponse, 0, len(invites))
+	for _, invite := range invites {
+		response = append(response, InviteResponse{InviteCode: invite, Active: invite.Active(now)})
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"invites": response,
+	})
+}
+
+// CreateInvite creates an invite code. The code is only returned now.
+func (h *RegistrationHandler) CreateInvite(c echo.Context) error {
+	admin, err := h.requestUser(c)
+	if err != nil {
+		return err
+	}
+	var req CreateInviteRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	input := services.CreateInviteInput{Label: req.Label, MaxUses: 1, ExpiresAt: req.ExpiresAt}
+	if req.MaxUses != nil {
+		input.MaxUses = *req.MaxUses
+	}
+	if req.ExpiresIn != 0 {
+		if req.ExpiresAt != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "give expires_in or expires_at, not both")
+		}
+		expiresAt := time.Now().Add(time.Duration(req.ExpiresIn) * time.Second)
+		input.ExpiresAt = &expiresAt
+	}
+
+	invite, code, err := h.service.CreateInvite(admin, input)
+	if err != nil {
+		return registrationError(err)
+	}
+	return c.JSON(http.StatusCreated, InviteResponse{InviteCode: *invite, Active: true, Code: code})
+}
+
+// RevokeInvite stops an invite code from working
+func (h *RegistrationHandler) RevokeInvite(c echo.Context) error {
+	inviteID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid invite code ID")
+	}
+	if _, err := h.service.RevokeInvite(inviteID); err != nil {
+		return registrationError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// ListPending returns the accounts awaiting approval, oldest first
+func (h *RegistrationHandler) ListPending(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit < 1 || limit > 100 {
+		limit = 20
+	}
+
+	users, total, err := h.service.Pending(page, limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch pending accounts")
+	}
+	response := make([]PendingUserResponse, 0, len(users))
+	for _, user := range users {
+		response = append(response, pendingUserResponse(&user))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"users": response,
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// Approve activates an account awaiting approval
+func (h *RegistrationHandler) Approve(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	user, err := h.service.Approve(userID)
+	if err != nil {
+		return registrationError(err)
+	}
+	return c.JSON(http.StatusOK, pendingUserResponse(user))
+}
+
+// Reject deletes an account awaiting approval
+func (h *RegistrationHandler) Reject(c echo.Context) error {
+	userID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid user ID")
+	}
+	if err := h.service.Reject(userID); err != nil {
+		return registrationError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// requestUser loads the signed in user
+func (h *RegistrationHandler) requestUser(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+	return &user, nil
+}
+
+func pendingUserResponse(user *models.User) PendingUserResponse {
+	return PendingUserResponse{
+		ID:        user.ID,
+		Username:  user.Username,
+		Email:     user.Email,
+		InviteID:  user.InviteCodeID,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// registrationError maps registration service errors to HTTP errors
+func registrationError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrRegistrationClosed),
+		errors.Is(err, services.ErrRegistrationDomain),
+		errors.Is(err, services.ErrInviteRequired),
+		errors.Is(err, services.ErrInviteInvalid):
+		return echo.NewHTTPError(http.StatusForbidden, err.Error())
+	case errors.Is(err, services.ErrInviteNotFound),
+		errors.Is(err, services.ErrSignupUserNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, services.ErrInviteExpiry),
+		errors.Is(err, services.ErrInviteLabel),
+		errors.Is(err, services.ErrInviteUses):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrNotPendingApproval):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "registration failed")
+	}
+}
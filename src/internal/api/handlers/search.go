@@ -1,26 +1,29 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"net/http"
 	"strconv"
 	"strings"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/casapps/casgists/src/internal/search"
+	"github.com/google/uuid"
 	"gorm.io/gorm"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 )
 
 // SearchHandler handles search-related endpoints
 type SearchHandler struct {
 	searchManager *search.Manager
-	config        *viper.Viper
+	config        *config.Config
 	db            *gorm.DB
 }
 
 // NewSearchHandler creates a new search handler
-func NewSearchHandler(searchManager *search.Manager, config *viper.Viper, db *gorm.DB) *SearchHandler {
+func NewSearchHandler(searchManager *search.Manager, config *config.Config, db *gorm.DB) *SearchHandler {
 	return &SearchHandler{
 		searchManager: searchManager,
 		config:        config,
@@ -48,17 +51,23 @@ func (h *SearchHandler) Search(c echo.Context) error {
 		limit = 20
 	}
 
-	// Get authenticated user (optional) - for future use
-	// userID, _ := c.Get("user_id").(uuid.UUID)
+	// Limit concurrent searches per caller
+	release, err := h.searchManager.Acquire(searchCaller(c))
+	if err != nil {
+		return searchError(err)
+	}
+	defer release()
 
 	// Perform search
 	filters := search.SearchFilters{
-		Limit:  limit,
-		Offset: (page - 1) * limit,
+		Language: c.QueryParam("language"),
+		Sort:     c.QueryParam("sort"),
+		Limit:    limit,
+		Offset:   (page - 1) * limit,
 	}
 	results, err := h.searchManager.Search(c.Request().Context(), query, filters)
 	if err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Search failed")
+		return searchError(err)
 	}
 
 	// Return results
@@ -79,10 +88,19 @@ func (h *SearchHandler) SearchUsers(c echo.Context) error {
 		limit = 20
 	}
 
+	if err := h.searchManager.Check(query); err != nil {
+		return searchError(err)
+	}
+	release, err := h.searchManager.Acquire(searchCaller(c))
+	if err != nil {
+		return searchError(err)
+	}
+	defer release()
+
 	// Search users in database
 	var users []models.User
 	searchQuery := h.db.Model(&models.User{}).
-		Where("deleted_at IS NULL")
+		Where("deleted_at IS NULL AND deactivated_at IS NULL")
 	
 	if query != "" {
 		searchQuery = searchQuery.Where(
@@ -93,7 +111,7 @@ func (h *SearchHandler) SearchUsers(c echo.Context) error {
 	
 	searchQuery = searchQuery.Limit(limit)
 	
-	err := searchQuery.Find(&users).Error
+	err = searchQuery.Find(&users).Error
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Search failed")
 	}
@@ -137,7 +155,8 @@ func (h *SearchHandler) Autocomplete(c echo.Context) error {
 	})
 }
 
-// Reindex triggers a search index rebuild (admin only)
+// Reindex starts a background rebuild of the search index (admin only).
+// Progress is reported by IndexHealth.
 func (h *SearchHandler) Reindex(c echo.Context) error {
 	// Check if user is admin
 	isAdmin, _ := c.Get("is_admin").(bool)
@@ -145,47 +164,64 @@ func (h *SearchHandler) Reindex(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
 	}
 
-	// Start reindexing in background
-	go func() {
-		if err := h.searchManager.UpdateIndex(c.Request().Context()); err != nil {
-			// Log error
-			c.Logger().Errorf("Failed to reindex: %v", err)
+	if err := h.searchManager.StartReindex(); err != nil {
+		if errors.Is(err, search.ErrReindexInProgress) {
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
 		}
-	}()
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to start reindex")
+	}
 
-	return c.JSON(http.StatusOK, map[string]string{
-		"status": "Reindexing started",
+	return c.JSON(http.StatusAccepted, map[string]interface{}{
+		"status":  "Reindexing started",
+		"reindex": h.searchManager.ReindexStatus(),
 	})
 }
 
-// GetStats returns search statistics (admin only)
-func (h *SearchHandler) GetStats(c echo.Context) error {
+// IndexHealth returns how far the search index has drifted from the
+// database, with the progress of any running reindex (admin only)
+func (h *SearchHandler) IndexHealth(c echo.Context) error {
 	// Check if user is admin
 	isAdmin, _ := c.Get("is_admin").(bool)
 	if !isAdmin {
 		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
 	}
 
-	// TODO: Implement search statistics
-	// For now, return basic stats
-	stats := map[string]interface{}{
-		"provider": "sqlite_fts",
-		"indexed_gists": 0,
-		"last_reindex": nil,
+	health, err := h.searchManager.Health(c.Request().Context(), h.config.GetDuration("search.index.max_lag"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check search index")
 	}
 
-	return c.JSON(http.StatusOK, stats)
+	return c.JSON(http.StatusOK, health)
 }
 
+// searchCaller identifies the caller for the concurrent search limit
+func searchCaller(c echo.Context) string {
+	if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+		return "user:" + userID.String()
+	}
+	return "ip:" + c.RealIP()
+}
 
-// RegisterRoutes registers search routes
-func (h *SearchHandler) RegisterRoutes(g *echo.Group) {
-	g.GET("/search", h.Search)
-	g.GET("/search/gists", h.SearchGists)
-	g.GET("/search/users", h.SearchUsers)
-	g.GET("/search/autocomplete", h.Autocomplete)
-	
-	// Admin routes
-	g.POST("/search/reindex", h.Reindex)
-	g.GET("/search/stats", h.GetStats)
+// searchError maps search errors to HTTP errors
+func searchError(err error) error {
+	switch {
+	case errors.Is(err, search.ErrQueryTooComplex):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, search.ErrTooManySearches):
+		return echo.NewHTTPError(http.StatusTooManyRequests, err.Error())
+	case errors.Is(err, context.DeadlineExceeded):
+		return echo.NewHTTPError(http.StatusServiceUnavailable, "search timed out; try a more specific query")
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "Search failed")
+	}
+}
+
+// RegisterRoutes registers search routes; public middleware wraps the
+// read-only search endpoints. Reindex and IndexHealth are admin routes,
+// registered under /admin/search.
+func (h *SearchHandler) RegisterRoutes(g *echo.Group, public ...echo.MiddlewareFunc) {
+	g.GET("/search", h.Search, public...)
+	g.GET("/search/gists", h.SearchGists, public...)
+	g.GET("/search/users", h.SearchUsers, public...)
+	g.GET("/search/autocomplete", h.Autocomplete, public...)
 }
\ No newline at end of file
@@ -1,15 +1,21 @@
 package handlers
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
 	"time"
 
 	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/oauth"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/urls"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"golang.org/x/crypto/bcrypt"
 	"gorm.io/gorm"
 )
@@ -17,16 +23,19 @@ import (
 // SetupHandler handles setup wizard endpoints
 type SetupHandler struct {
 	db         *gorm.DB
-	config     *viper.Viper
+	config     *config.Config
 	auth       *auth.AuthService
+	settings   *config.Settings
 }
 
-// NewSetupHandler creates a new setup handler
-func NewSetupHandler(db *gorm.DB, config *viper.Viper, authService *auth.AuthService) *SetupHandler {
+// NewSetupHandler creates a new setup handler. The wizard saves its
+// settings through settings, so they are still in effect after a restart.
+func NewSetupHandler(db *gorm.DB, config *config.Config, authService *auth.AuthService, settings *config.Settings) *SetupHandler {
 	return &SetupHandler{
-		db:     db,
-		config: config,
-		auth:   authService,
+		db:       db,
+		config:   config,
+		auth:     authService,
+		settings: settings,
 	}
 }
 
@@ -147,9 +156,6 @@ func (h *SetupHandler) CreateFirstAdmin(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create session")
 	}
 
-	// Initialize default settings
-	h.initializeDefaultSettings()
-
 	return c.JSON(http.StatusCreated, map[string]interface{}{
 		"user":          user,
 		"access_token":  tokenPair.AccessToken,
@@ -205,6 +211,12 @@ func (h *SetupHandler) GetSteps(c echo.Context) error {
 			"description": "Configure security and authentication options",
 			"required":    true,
 		},
+		{
+			"id":          "oauth",
+			"title":       "Sign In Providers",
+			"description": "Let users sign in with GitHub, GitLab or OpenID Connect (optional)",
+			"required":    false,
+		},
 		{
 			"id":          "features",
 			"title":       "Features",
@@ -280,6 +292,8 @@ func (h *SetupHandler) ProcessStep(c echo.Context) error {
 		return h.processEmailStep(c)
 	case "security":
 		return h.processSecurityStep(c)
+	case "oauth":
+		return h.processOAuthStep(c)
 	case "features":
 		return h.processFeaturesStep(c)
 	case "review":
@@ -321,17 +335,17 @@ func (h *SetupHandler) processDatabaseStep(c echo.Context) error {
 		"database.host":     req.Host,
 		"database.port":     req.Port,
 		"database.name":     req.Name,
-		"database.username": req.Username,
+		"database.user":     req.Username,
 		"database.password": req.Password,
 		"database.ssl_mode": req.SSLMode,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message": "Database configuration saved",
+		"message": "Database configuration saved, restart to use it",
 		"next":    "storage",
 	})
 }
@@ -361,17 +375,17 @@ func (h *SetupHandler) processStorageStep(c echo.Context) error {
 
 	// Save storage configuration
 	configs := map[string]interface{}{
-		"data_dir":       req.DataDir,
-		"git.repos_path": req.ReposPath,
-		"temp_dir":       req.TempDir,
+		"paths.data":             req.DataDir,
+		"git.storage.local.path": req.ReposPath,
+		"paths.temp":             req.TempDir,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"message": "Storage configuration saved",
+		"message": "Storage configuration saved, restart to use it",
 		"next":    "server",
 	})
 }
@@ -383,6 +397,8 @@ func (h *SetupHandler) processServerStep(c echo.Context) error {
 		HTTPSEnabled bool   `json:"https_enabled"`
 		CertFile     string `json:"cert_file"`
 		KeyFile      string `json:"key_file"`
+		AutoCert     bool   `json:"auto_cert"`
+		ACMEEmail    string `json:"acme_email"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -393,22 +409,32 @@ func (h *SetupHandler) processServerStep(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
-	// Validate HTTPS settings
-	if req.HTTPSEnabled && (req.CertFile == "" || req.KeyFile == "") {
+	// Validate HTTPS settings; automatic certificates need no files
+	if req.HTTPSEnabled && !req.AutoCert && (req.CertFile == "" || req.KeyFile == "") {
 		return echo.NewHTTPError(http.StatusBadRequest, "Certificate and key files required for HTTPS")
 	}
+	if req.HTTPSEnabled && !req.AutoCert {
+		if _, err := tls.LoadX509KeyPair(req.CertFile, req.KeyFile); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, fmt.Sprintf("Cannot load certificate: %v", err))
+		}
+	}
+	if req.HTTPSEnabled && req.AutoCert && !strings.HasPrefix(req.URL, "https://") {
+		return echo.NewHTTPError(http.StatusBadRequest, "Automatic certificates need an https:// URL")
+	}
 
-	// Save server configuration
+	// Save server configuration; the server reads server.tls on start
 	configs := map[string]interface{}{
-		"server.url":          req.URL,
-		"server.port":         req.Port,
-		"server.https_enabled": req.HTTPSEnabled,
-		"server.cert_file":    req.CertFile,
-		"server.key_file":     req.KeyFile,
+		"server.url":            req.URL,
+		"server.port":           req.Port,
+		"server.tls.enabled":    req.HTTPSEnabled,
+		"server.tls.cert_path":  req.CertFile,
+		"server.tls.key_path":   req.KeyFile,
+		"server.tls.auto_cert":  req.AutoCert,
+		"server.tls.acme.email": req.ACMEEmail,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -435,18 +461,18 @@ func (h *SetupHandler) processEmailStep(c echo.Context) error {
 
 	// Save email configuration
 	configs := map[string]interface{}{
-		"email.enabled":  req.Enabled,
-		"email.provider": req.Provider,
-		"email.host":     req.Host,
-		"email.port":     req.Port,
-		"email.username": req.Username,
-		"email.password": req.Password,
-		"email.from":     req.From,
-		"email.use_tls":  req.UseTLS,
+		"email.enabled":       req.Enabled,
+		"email.provider":      req.Provider,
+		"email.smtp.host":     req.Host,
+		"email.smtp.port":     req.Port,
+		"email.smtp.username": req.Username,
+		"email.smtp.password": req.Password,
+		"email.smtp.tls":      req.UseTLS,
+		"email.from.address":  req.From,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -483,21 +509,99 @@ func (h *SetupHandler) processSecurityStep(c echo.Context) error {
 
 	// Save security configuration
 	configs := map[string]interface{}{
-		"security.secret_key":         req.SecretKey,
-		"auth.signup_enabled":         req.SignupEnabled,
+		"security.secret_key":             req.SecretKey,
+		"features.registration":           req.SignupEnabled,
 		"auth.require_email_verification": req.RequireEmail,
-		"auth.2fa_enabled":            req.Enable2FA,
-		"auth.session_timeout":        req.SessionTimeout,
-		"auth.password_min_length":    req.PasswordMinLength,
+		"auth.require_2fa":                req.Enable2FA,
+		"security.session.idle_timeout":   (time.Duration(req.SessionTimeout) * time.Second).String(),
+		"security.password.min_length":    req.PasswordMinLength,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"message": "Security configuration saved",
-		"next":    "features",
+		"next":    "oauth",
+	})
+}
+
+func (h *SetupHandler) processOAuthStep(c echo.Context) error {
+	var req struct {
+		AllowSignup *bool `json:"allow_signup"`
+		Providers   map[string]struct {
+			Enabled      bool     `json:"enabled"`
+			DisplayName  string   `json:"display_name"`
+			ClientID     string   `json:"client_id"`
+			ClientSecret string   `json:"client_secret"`
+			URL          string   `json:"url"`
+			Scopes       []string `json:"scopes"`
+		} `json:"providers"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	manager := oauth.NewManager(h.db, h.config)
+	current := map[string]oauth.Settings{}
+	for _, settings := range manager.Settings() {
+		current[settings.Name] = settings
+	}
+
+	configs := map[string]interface{}{}
+	for name, provider := range req.Providers {
+		existing, known := current[name]
+		if !known {
+			return echo.NewHTTPError(http.StatusBadRequest, "Unknown sign in provider: "+name)
+		}
+		// A blank secret keeps the one already saved
+		secret := provider.ClientSecret
+		if secret == "" {
+			secret = existing.ClientSecret
+		}
+		providerURL := strings.TrimRight(provider.URL, "/")
+		if providerURL != "" {
+			if parsed, err := url.Parse(providerURL); err != nil || (parsed.Scheme != "https" && parsed.Scheme != "http") || parsed.Host == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "Invalid URL for "+name)
+			}
+		}
+		if provider.Enabled {
+			if provider.ClientID == "" || secret == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "Client ID and secret required for "+name)
+			}
+			if name == oauth.KindOIDC && providerURL == "" {
+				return echo.NewHTTPError(http.StatusBadRequest, "Issuer URL required for OpenID Connect")
+			}
+		}
+
+		prefix := "oauth." + name + "."
+		configs[prefix+"enabled"] = provider.Enabled
+		configs[prefix+"display_name"] = provider.DisplayName
+		configs[prefix+"client_id"] = provider.ClientID
+		configs[prefix+"client_secret"] = secret
+		configs[prefix+"url"] = providerURL
+		configs[prefix+"scopes"] = strings.Join(provider.Scopes, ",")
+	}
+	if req.AllowSignup != nil {
+		configs["oauth.allow_signup"] = *req.AllowSignup
+	}
+
+	if err := h.saveConfigs(configs); err != nil {
+		return err
+	}
+
+	// Each provider needs its callback URL registered with the client
+	callbacks := map[string]string{}
+	for _, name := range oauth.ProviderNames {
+		callbacks[name] = urls.NewBuilder(h.config).URL(oauth.CallbackRoute, name)
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"message":       "Sign in provider configuration saved",
+		"callback_urls": callbacks,
+		"next":          "features",
 	})
 }
 
@@ -518,17 +622,17 @@ func (h *SetupHandler) processFeaturesStep(c echo.Context) error {
 
 	// Save features configuration
 	configs := map[string]interface{}{
-		"features.search_enabled":        req.SearchEnabled,
-		"features.webhook_enabled":       req.WebhookEnabled,
-		"features.api_enabled":           req.APIEnabled,
+		"features.search":                req.SearchEnabled,
+		"features.webhooks":              req.WebhookEnabled,
+		"features.api":                   req.APIEnabled,
 		"features.public_gists_enabled":  req.PublicGists,
-		"features.organizations_enabled": req.Organizations,
-		"features.backup_enabled":        req.BackupEnabled,
+		"features.organizations":         req.Organizations,
+		"backup.enabled":                 req.BackupEnabled,
 		"features.import_enabled":        req.ImportEnabled,
 	}
 
-	for key, value := range configs {
-		h.saveConfig(key, value)
+	if err := h.saveConfigs(configs); err != nil {
+		return err
 	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
@@ -539,8 +643,12 @@ func (h *SetupHandler) processFeaturesStep(c echo.Context) error {
 
 func (h *SetupHandler) processReviewStep(c echo.Context) error {
 	// Mark setup as complete
-	h.saveConfig("setup.completed", true)
-	h.saveConfig("setup.completed_at", time.Now().Format(time.RFC3339))
+	if err := h.saveConfigs(map[string]interface{}{
+		"setup.completed":    true,
+		"setup.completed_at": time.Now().Format(time.RFC3339),
+	}); err != nil {
+		return err
+	}
 
 	// Get all configuration for review
 	var configs []models.SystemConfig
@@ -565,24 +673,21 @@ func (h *SetupHandler) processReviewStep(c echo.Context) error {
 
 // Helper methods
 
+// saveConfig saves a wizard setting. Settings read before the database
+// opens go to the config file; the rest are saved in the database, over
+// the config file and environment.
 func (h *SetupHandler) saveConfig(key string, value interface{}) error {
-	var config models.SystemConfig
-	err := h.db.Where("key = ?", key).First(&config).Error
-	
-	if err == gorm.ErrRecordNotFound {
-		valueStr := fmt.Sprintf("%v", value)
-		config = models.SystemConfig{
-			Key:   key,
-			Value: valueStr,
+	return h.settings.Save(key, value)
+}
+
+// saveConfigs saves the settings of a wizard step
+func (h *SetupHandler) saveConfigs(configs map[string]interface{}) error {
+	for key, value := range configs {
+		if err := h.saveConfig(key, value); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, fmt.Sprintf("Failed to save %s: %v", key, err))
 		}
-		return h.db.Create(&config).Error
-	} else if err != nil {
-		return err
-	} else {
-		valueStr := fmt.Sprintf("%v", value)
-		config.Value = valueStr
-		return h.db.Save(&config).Error
 	}
+	return nil
 }
 
 func (h *SetupHandler) performSystemChecks() map[string]bool {
@@ -598,17 +703,19 @@ func (h *SetupHandler) performSystemChecks() map[string]bool {
 }
 
 func (h *SetupHandler) isDatabaseConfigured() bool {
-	// Check if database is properly configured
-	var count int64
-	h.db.Model(&models.SystemConfig{}).Where("key LIKE 'database.%'").Count(&count)
-	return count >= 2 // At least type and connection info
+	// The wizard runs on the database, so it is configured if it answers
+	sqlDB, err := h.db.DB()
+	return err == nil && sqlDB.Ping() == nil
 }
 
 func (h *SetupHandler) isStorageConfigured() bool {
-	// Check if storage paths are configured
-	var count int64
-	h.db.Model(&models.SystemConfig{}).Where("key IN ('data_dir', 'git.repos_path')").Count(&count)
-	return count >= 2
+	// Check if the storage paths exist
+	for _, key := range []string{"paths.data", "git.storage.local.path"} {
+		if _, err := os.Stat(h.settings.String(key)); err != nil {
+			return false
+		}
+	}
+	return true
 }
 
 func (h *SetupHandler) isServerConfigured() bool {
@@ -660,28 +767,6 @@ func (h *SetupHandler) calculateProgress() int {
 	return (completed * 100) / len(checks)
 }
 
-func (h *SetupHandler) initializeDefaultSettings() {
-	defaults := map[string]interface{}{
-		"auth.signup_enabled":     true,
-		"auth.2fa_enabled":        true,
-		"auth.session_timeout":    86400,
-		"gist.max_files":          10,
-		"gist.max_file_size":      10485760, // 10MB
-		"user.max_gists":          1000,
-		"search.enabled":          true,
-		"webhook.enabled":         true,
-		"email.enabled":           false,
-		"backup.enabled":          true,
-		"features.organizations_enabled": true,
-		"features.public_gists_enabled":  true,
-		"features.api_enabled":           true,
-	}
-
-	for key, value := range defaults {
-		h.saveConfig(key, value)
-	}
-}
-
 // RegisterRoutes registers setup routes
 func (h *SetupHandler) RegisterRoutes(g *echo.Group) {
 	g.GET("/setup/status", h.GetStatus)
@@ -0,0 +1,155 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/api/middleware"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// TagHandler lists tags, browses gists by tag and lets admins rename and
+// merge tags
+type TagHandler struct {
+	db      *gorm.DB
+	service *services.TagService
+	gists   *GistHandler
+}
+
+// NewTagHandler creates a new tag handler
+func NewTagHandler(db *gorm.DB, config *config.Config, gitOps GitOperations) *TagHandler {
+	return &TagHandler{
+		db:      db,
+		service: services.NewTagService(db),
+		gists:   NewGistHandler(db, config, gitOps),
+	}
+}
+
+// RenameTagRequest is the body of a tag rename
+type RenameTagRequest struct {
+	Name string `json:"name"`
+}
+
+// MergeTagsRequest is the body of a tag merge
+type MergeTagsRequest struct {
+	Sources []string `json:"sources"`
+	Target  string   `json:"target"`
+}
+
+// RegisterRoutes registers the public tag routes
+func (h *TagHandler) RegisterRoutes(g *echo.Group, public ...echo.MiddlewareFunc) {
+	g.GET("/tags", h.List, public...)
+	g.GET("/tags/:name/gists", h.Gists, public...)
+}
+
+// RegisterAdminRoutes registers the tag maintenance routes
+func (h *TagHandler) RegisterAdminRoutes(g *echo.Group, admin ...echo.MiddlewareFunc) {
+	g.POST("/admin/tags/:name/rename", h.Rename, admin...)
+	g.POST("/admin/tags/merge", h.Merge, admin...)
+}
+
+// List returns the tags of public gists with how many gists carry them,
+// most used first. ?q= keeps the tags starting with it.
+func (h *TagHandler) List(c echo.Context) error {
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 500 {
+		limit = 100
+	}
+
+	tags, err := h.service.Popular(middleware.IsSandbox(c), c.QueryParam("q"), limit)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch tags")
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tags": tags,
+	})
+}
+
+// Gists returns a page of the gists carrying a tag, newest first
+func (h *TagHandler) Gists(c echo.Context) error {
+	page, _ := strconv.Atoi(c.QueryParam("page"))
+	if page < 1 {
+		page = 1
+	}
+	limit, _ := strconv.Atoi(c.QueryParam("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 30
+	}
+
+	scope := services.TagScope{Viewer: requestViewer(h.db, c), Sandbox: middleware.IsSandbox(c)}
+	gists, total, err := h.service.Gists(c.Param("name"), scope, page, limit)
+	if errors.Is(err, services.ErrTagInvalid) {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gists")
+	}
+
+	tag, _ := services.NormalizeTag(c.Param("name"))
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tag":   tag,
+		"gists": h.gists.buildGistListResponse(gists),
+		"total": total,
+		"page":  page,
+		"limit": limit,
+		"pages": (total + int64(limit) - 1) / int64(limit),
+	})
+}
+
+// Rename renames a tag on every gist, merging it into the new name when
+// that is already in use
+func (h *TagHandler) Rename(c echo.Context) error {
+	var req RenameTagRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	changed, err := h.service.Rename(c.Param("name"), req.Name)
+	if err != nil {
+		return tagError(err)
+	}
+	name, _ := services.NormalizeTag(req.Name)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tag":           name,
+		"gists_changed": changed,
+	})
+}
+
+// Merge replaces several tags with one on every gist
+func (h *TagHandler) Merge(c echo.Context) error {
+	var req MergeTagsRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+	if len(req.Sources) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "sources are required")
+	}
+
+	changed, err := h.service.Merge(req.Sources, req.Target)
+	if err != nil {
+		return tagError(err)
+	}
+	target, _ := services.NormalizeTag(req.Target)
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"tag":           target,
+		"gists_changed": changed,
+	})
+}
+
+// tagError maps the errors of tag renames and merges to HTTP errors
+func tagError(err error) error {
+	switch {
+	case errors.Is(err, services.ErrTagInvalid),
+		errors.Is(err, services.ErrTagMergeSelf):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, services.ErrTagNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update tags")
+	}
+}
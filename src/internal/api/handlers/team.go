@@ -1,26 +1,30 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/webhooks"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // TeamHandler handles team-related endpoints
 type TeamHandler struct {
-	db     *gorm.DB
-	config *viper.Viper
+	db       *gorm.DB
+	config   *config.Config
+	webhooks *webhooks.Service
 }
 
 // NewTeamHandler creates a new team handler
-func NewTeamHandler(db *gorm.DB, config *viper.Viper) *TeamHandler {
+func NewTeamHandler(db *gorm.DB, config *config.Config) *TeamHandler {
 	return &TeamHandler{
-		db:     db,
-		config: config,
+		db:       db,
+		config:   config,
+		webhooks: webhooks.NewService(db, config),
 	}
 }
 
@@ -135,6 +139,13 @@ func (h *TeamHandler) Create(c echo.Context) error {
 	if err := h.db.Create(team).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create team")
 	}
+	go h.webhooks.TriggerTeamCreated(context.Background(), webhooks.TeamEventData{
+		ID:           team.ID,
+		Name:         team.Name,
+		Description:  team.Description,
+		Organization: orgEventData(&org),
+		CreatedAt:    team.CreatedAt,
+	}, userID)
 
 	return c.JSON(http.StatusCreated, team)
 }
@@ -0,0 +1,57 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/telemetry"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// TelemetryHandler handles the admin telemetry opt-in endpoints
+type TelemetryHandler struct {
+	db        *gorm.DB
+	config    *config.Config
+	telemetry *telemetry.Service
+}
+
+// NewTelemetryHandler creates a new telemetry handler
+func NewTelemetryHandler(db *gorm.DB, config *config.Config, telemetryService *telemetry.Service) *TelemetryHandler {
+	return &TelemetryHandler{
+		db:        db,
+		config:    config,
+		telemetry: telemetryService,
+	}
+}
+
+// GetStatus returns whether telemetry is enabled and when it last reported
+func (h *TelemetryHandler) GetStatus(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.telemetry.GetStatus())
+}
+
+// UpdateStatus toggles the telemetry opt-in
+func (h *TelemetryHandler) UpdateStatus(c echo.Context) error {
+	var req struct {
+		Enabled *bool `json:"enabled"`
+	}
+	if err := c.Bind(&req); err != nil || req.Enabled == nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "enabled is required")
+	}
+
+	if err := h.telemetry.SetEnabled(*req.Enabled); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update telemetry setting")
+	}
+
+	return c.JSON(http.StatusOK, h.telemetry.GetStatus())
+}
+
+// Preview returns the exact payload that would be sent, without sending it
+func (h *TelemetryHandler) Preview(c echo.Context) error {
+	payload, err := h.telemetry.BuildPayload()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to build telemetry payload")
+	}
+
+	return c.JSON(http.StatusOK, payload)
+}
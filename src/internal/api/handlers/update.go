@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/update"
+)
+
+// UpdateHandler tells administrators whether a newer release is out
+type UpdateHandler struct {
+	client  *update.Client
+	version string
+}
+
+// NewUpdateHandler creates a new update handler for the running version
+func NewUpdateHandler(client *update.Client, version string) *UpdateHandler {
+	return &UpdateHandler{client: client, version: version}
+}
+
+// Check compares the running version with the latest release. The answer
+// is cached for update.check_interval; ?refresh=true asks GitHub again.
+func (h *UpdateHandler) Check(c echo.Context) error {
+	// Check if user is admin
+	isAdmin, _ := c.Get("is_admin").(bool)
+	if !isAdmin {
+		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
+	}
+
+	status, err := h.client.Check(c.Request().Context(), h.version, c.QueryParam("refresh") == "true")
+	if errors.Is(err, update.ErrNoRelease) {
+		return echo.NewHTTPError(http.StatusNotFound, "no release has been published")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to check for updates: "+err.Error())
+	}
+	return c.JSON(http.StatusOK, status)
+}
@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"errors"
 	"net/http"
+	"strconv"
 
+	"github.com/casapps/casgists/src/internal/api/middleware"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
-	"github.com/spf13/viper"
+	"github.com/casapps/casgists/src/internal/imageproxy"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/utils"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"gorm.io/gorm"
@@ -13,14 +20,18 @@ import (
 // UserHandler handles user-related endpoints
 type UserHandler struct {
 	db     *gorm.DB
-	config *viper.Viper
+	config *config.Config
+	quota  *services.QuotaService
+	images *imageproxy.Signer // nil when the image proxy is disabled
 }
 
 // NewUserHandler creates a new user handler
-func NewUserHandler(db *gorm.DB, config *viper.Viper) *UserHandler {
+func NewUserHandler(db *gorm.DB, config *config.Config) *UserHandler {
 	return &UserHandler{
 		db:     db,
 		config: config,
+		quota:  services.NewQuotaService(db, config),
+		images: imageproxy.NewSigner(config),
 	}
 }
 
@@ -32,7 +43,7 @@ func (h *UserHandler) Get(c echo.Context) error {
 	}
 
 	var user models.User
-	if err := h.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := h.db.Where("username = ? AND deactivated_at IS NULL", username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "user not found")
 		}
@@ -44,7 +55,7 @@ func (h *UserHandler) Get(c echo.Context) error {
 		Username:    user.Username,
 		Email:       user.Email,
 		DisplayName: user.DisplayName,
-		AvatarURL:   user.AvatarURL,
+		AvatarURL:   h.images.URL(user.AvatarURL),
 		IsAdmin:     user.IsAdmin,
 	})
 }
@@ -61,21 +72,39 @@ func (h *UserHandler) GetCurrent(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
 	}
 
+	return h.currentUserResponse(c, &user)
+}
+
+// currentUserResponse describes the signed in user to themselves, with
+// their quota tier and preferences
+func (h *UserHandler) currentUserResponse(c echo.Context, user *models.User) error {
+	timezone, err := models.UserTimezone(h.db, user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
+	}
+	if timezone == "" {
+		timezone = h.config.GetString("ui.timezone")
+	}
+
+	tier := h.quota.TierFor(user)
 	return c.JSON(http.StatusOK, UserResponse{
 		ID:          user.ID,
 		Username:    user.Username,
 		Email:       user.Email,
 		DisplayName: user.DisplayName,
-		AvatarURL:   user.AvatarURL,
+		AvatarURL:   h.images.URL(user.AvatarURL),
 		IsAdmin:     user.IsAdmin,
+		Quota:       &tier,
+		Timezone:    timezone,
 	})
 }
 
 // UpdateUserRequest represents a user update request
 type UpdateUserRequest struct {
-	DisplayName string `json:"display_name"`
-	Bio         string `json:"bio"`
-	Email       string `json:"email" validate:"email"`
+	DisplayName string  `json:"display_name"`
+	Bio         string  `json:"bio"`
+	Email       string  `json:"email" validate:"email"`
+	Timezone    *string `json:"timezone"` // IANA name such as Europe/Berlin
 }
 
 // Update updates the current user
@@ -101,12 +130,17 @@ func (h *UserHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch user")
 	}
 
-	// Check if email is already taken by another user
+	// Email changes must be verified through the email change flow
 	if req.Email != "" && req.Email != user.Email {
-		var existingUser models.User
-		if err := h.db.Where("email = ? AND id != ?", req.Email, userID).First(&existingUser).Error; err == nil {
-			return echo.NewHTTPError(http.StatusConflict, "email already taken")
+		return echo.NewHTTPError(http.StatusBadRequest, "email changes require verification; use POST /api/v1/user/email")
+	}
+	var timezone string
+	if req.Timezone != nil {
+		loc, err := utils.LoadTimezone(*req.Timezone)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 		}
+		timezone = loc.String()
 	}
 
 	// Update user
@@ -116,23 +150,17 @@ func (h *UserHandler) Update(c echo.Context) error {
 	if req.Bio != "" {
 		user.Bio = req.Bio
 	}
-	if req.Email != "" {
-		user.Email = req.Email
-		user.EmailVerified = false // Reset email verification
-	}
 
 	if err := h.db.Save(&user).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user")
 	}
+	if timezone != "" {
+		if err := models.SetUserTimezone(h.db, user.ID, timezone); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to update user")
+		}
+	}
 
-	return c.JSON(http.StatusOK, UserResponse{
-		ID:          user.ID,
-		Username:    user.Username,
-		Email:       user.Email,
-		DisplayName: user.DisplayName,
-		AvatarURL:   user.AvatarURL,
-		IsAdmin:     user.IsAdmin,
-	})
+	return h.currentUserResponse(c, &user)
 }
 
 // GetGists returns gists for a user
@@ -142,9 +170,9 @@ func (h *UserHandler) GetGists(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusBadRequest, "username required")
 	}
 
-	// Find user
+	// Find user (deactivated profiles are hidden)
 	var user models.User
-	if err := h.db.Where("username = ?", username).First(&user).Error; err != nil {
+	if err := h.db.Where("username = ? AND deactivated_at IS NULL", username).First(&user).Error; err != nil {
 		if err == gorm.ErrRecordNotFound {
 			return echo.NewHTTPError(http.StatusNotFound, "user not found")
 		}
@@ -152,36 +180,38 @@ func (h *UserHandler) GetGists(c echo.Context) error {
 	}
 
 	// Build query for user's gists
-	query := h.db.Model(&models.Gist{}).
+	query := database.ReadReplica(h.db).Model(&models.Gist{}).
 		Where("user_id = ?", user.ID).
-		Preload("User").
-		Preload("Files")
+		Scopes(models.SandboxScope(middleware.IsSandbox(c)))
 
 	// Check if current user can see private gists
 	currentUserID, _ := c.Get("user_id").(uuid.UUID)
 	if currentUserID != user.ID {
-		// Different user, only show public gists
-		query = query.Where("is_public = ?", true)
-	}
-
-	// Apply sorting
-	sort := c.QueryParam("sort")
-	switch sort {
-	case "created":
-		query = query.Order("created_at DESC")
-	case "updated":
-		query = query.Order("updated_at DESC")
-	case "stars":
-		query = query.Order("star_count DESC")
-	default:
-		query = query.Order("created_at DESC")
-	}
-
-	// Fetch gists
-	var gists []models.Gist
-	if err := query.Find(&gists).Error; err != nil {
+		// Different user, only show public gists they may see
+		query = query.Where("visibility = ?", models.VisibilityPublic).Scopes(models.HideModerated)
+	}
+
+	limit, _ := strconv.Atoi(c.QueryParam("per_page"))
+	if limit < 1 || limit > 100 {
+		limit = 30
+	}
+	sort := services.GistSort(c.QueryParam("sort"))
+	var cursor *services.GistCursor
+	if value := c.QueryParam("cursor"); value != "" {
+		var err error
+		if cursor, err = services.ParseGistCursor(value, sort); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid cursor, start again without one")
+		}
+	}
+	page, err := services.PageGists(query, sort, cursor, limit)
+	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gists")
 	}
+	gists := page.Gists
+	for i := range gists {
+		gists[i].User = &user
+	}
+	setNextLink(c, page.NextCursor)
 
 	// Create gist handler to use its response building methods
 	gistHandler := &GistHandler{db: h.db, config: h.config}
@@ -192,9 +222,53 @@ func (h *UserHandler) GetGists(c echo.Context) error {
 			Username:    user.Username,
 			Email:       user.Email,
 			DisplayName: user.DisplayName,
-			AvatarURL:   user.AvatarURL,
+			AvatarURL:   h.images.URL(user.AvatarURL),
 			IsAdmin:     user.IsAdmin,
 		},
 		"gists": gistHandler.buildGistListResponse(gists),
+		"pagination": map[string]interface{}{
+			"limit":       limit,
+			"next_cursor": page.NextCursor,
+			"has_more":    page.NextCursor != "",
+		},
+	})
+}
+
+// DeactivateRequest represents an account deactivation request
+type DeactivateRequest struct {
+	Password        string `json:"password"`
+	KeepGistsPublic bool   `json:"keep_gists_public"`
+}
+
+// Deactivate deactivates the current user's account. Logging in again within
+// the grace period reactivates it.
+func (h *UserHandler) Deactivate(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "unauthorized")
+	}
+
+	var req DeactivateRequest
+	if err := c.Bind(&req); err != nil || req.Password == "" {
+		return echo.NewHTTPError(http.StatusBadRequest, "password is required")
+	}
+
+	accounts := services.NewAccountService(h.db, h.config)
+	reactivateBefore, err := accounts.Deactivate(userID, req.Password, req.KeepGistsPublic)
+	if err != nil {
+		switch {
+		case errors.Is(err, services.ErrAccountInvalidPassword):
+			return echo.NewHTTPError(http.StatusUnauthorized, err.Error())
+		case errors.Is(err, services.ErrAccountAlreadyDeactivated), errors.Is(err, services.ErrAccountLastAdmin):
+			return echo.NewHTTPError(http.StatusConflict, err.Error())
+		default:
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to deactivate account")
+		}
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"deactivated":       true,
+		"keep_gists_public": req.KeepGistsPublic,
+		"reactivate_before": reactivateBefore,
 	})
-}
\ No newline at end of file
+}
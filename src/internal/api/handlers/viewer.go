@@ -0,0 +1,22 @@
+package handlers
+
+import (
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// requestViewer returns the signed in user, or nil for anonymous requests
+func requestViewer(db *gorm.DB, c echo.Context) *models.User {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil
+	}
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil
+	}
+	return &user
+}
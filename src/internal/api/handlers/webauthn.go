@@ -0,0 +1,302 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+)
+
+// WebAuthnHandler registers security keys and passkeys and signs users in
+// with them
+type WebAuthnHandler struct {
+	db          *gorm.DB
+	authService *auth.AuthService
+	config      *config.Config
+	passkeys    *passkey.Service
+}
+
+// NewWebAuthnHandler creates a new WebAuthn handler
+func NewWebAuthnHandler(db *gorm.DB, authService *auth.AuthService, config *config.Config) *WebAuthnHandler {
+	return &WebAuthnHandler{
+		db:          db,
+		authService: authService,
+		config:      config,
+		passkeys:    passkey.NewService(db, config),
+	}
+}
+
+// WebAuthnFinishRequest finishes a registration or sign in with the
+// browser's response to the options it was started with
+type WebAuthnFinishRequest struct {
+	CeremonyID string          `json:"ceremony_id"`
+	Name       string          `json:"name,omitempty"` // Registration only
+	Credential json.RawMessage `json:"credential"`
+}
+
+// WebAuthnCredentialResponse represents a security key in API responses
+type WebAuthnCredentialResponse struct {
+	ID         uuid.UUID  `json:"id"`
+	Name       string     `json:"name"`
+	Transports []string   `json:"transports"`
+	Synced     bool       `json:"synced"` // A passkey backed up by the platform
+	LastUsedAt *time.Time `json:"last_used_at"`
+	CreatedAt  time.Time  `json:"created_at"`
+}
+
+// RegisterRoutes registers the WebAuthn routes on an /auth group. Finishing
+// a registration takes an optional session: without one it completes the
+// enrollment a password login asked for.
+func (h *WebAuthnHandler) RegisterRoutes(g *echo.Group, auth, optionalAuth echo.MiddlewareFunc) {
+	g.POST("/webauthn/register/begin", h.BeginRegistration, auth)
+	g.POST("/webauthn/register", h.FinishRegistration, optionalAuth)
+	g.POST("/webauthn/login/begin", h.BeginLogin)
+	g.POST("/webauthn/login", h.FinishLogin)
+	g.GET("/webauthn/credentials", h.ListCredentials, auth)
+	g.DELETE("/webauthn/credentials/:id", h.DeleteCredential, auth)
+}
+
+// BeginRegistration returns the options the browser creates a credential
+// with
+func (h *WebAuthnHandler) BeginRegistration(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	options, ceremonyID, err := h.passkeys.BeginRegistration(user, requestOrigin(c))
+	if err != nil {
+		return webAuthnError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// FinishRegistration saves the credential the browser created. Signed in
+// users get the credential back; a user finishing the enrollment their
+// password login asked for is signed in.
+func (h *WebAuthnHandler) FinishRegistration(c echo.Context) error {
+	var req WebAuthnFinishRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.CeremonyID == "" || len(req.Credential) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ceremony_id and credential are required")
+	}
+
+	var userID *uuid.UUID
+	if id, ok := c.Get("user_id").(uuid.UUID); ok {
+		userID = &id
+	}
+	credential, err := h.passkeys.FinishRegistration(requestOrigin(c), req.CeremonyID, userID, req.Name, req.Credential)
+	if err != nil {
+		return webAuthnError(err)
+	}
+	if userID != nil {
+		return c.JSON(http.StatusCreated, credentialResponse(credential))
+	}
+
+	var user models.User
+	if err := h.db.First(&user, "id = ?", credential.UserID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "database error")
+	}
+	return h.signIn(c, &user)
+}
+
+// BeginLogin returns the options the browser signs in with a passkey with
+func (h *WebAuthnHandler) BeginLogin(c echo.Context) error {
+	options, ceremonyID, err := h.passkeys.BeginLogin(requestOrigin(c))
+	if err != nil {
+		return webAuthnError(err)
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"ceremony_id": ceremonyID,
+		"options":     options,
+	})
+}
+
+// FinishLogin verifies a passkey sign in, or the security key check a
+// password login asked for, and starts a session
+func (h *WebAuthnHandler) FinishLogin(c echo.Context) error {
+	var req WebAuthnFinishRequest
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "invalid request body")
+	}
+	if req.CeremonyID == "" || len(req.Credential) == 0 {
+		return echo.NewHTTPError(http.StatusBadRequest, "ceremony_id and credential are required")
+	}
+
+	user, err := h.passkeys.FinishLogin(requestOrigin(c), req.CeremonyID, req.Credential)
+	if err != nil {
+		if errors.Is(err, passkey.ErrCredentialNotFound) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "invalid credentials")
+		}
+		return webAuthnError(err)
+	}
+	return h.signIn(c, user)
+}
+
+// ListCredentials returns the current user's security keys
+func (h *WebAuthnHandler) ListCredentials(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	credentials, err := h.passkeys.Credentials(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to load security keys")
+	}
+
+	response := make([]WebAuthnCredentialResponse, 0, len(credentials))
+	for i := range credentials {
+		response = append(response, credentialResponse(&credentials[i]))
+	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"credentials": response,
+		"required":    h.passkeys.Required(user),
+	})
+}
+
+// DeleteCredential removes one of the current user's security keys
+func (h *WebAuthnHandler) DeleteCredential(c echo.Context) error {
+	user, err := h.currentUser(c)
+	if err != nil {
+		return err
+	}
+	id, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "security key not found")
+	}
+	if err := h.passkeys.RemoveCredential(user, id); err != nil {
+		return webAuthnError(err)
+	}
+	return c.NoContent(http.StatusNoContent)
+}
+
+// signIn runs the account checks of a password login and starts a session
+func (h *WebAuthnHandler) signIn(c echo.Context, user *models.User) error {
+	accounts := services.NewAccountService(h.db, h.config)
+	if user.IsDeactivated() {
+		if !accounts.CanReactivate(user) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "account is deactivated")
+		}
+	} else if !user.IsActive {
+		return echo.NewHTTPError(http.StatusUnauthorized, "account is disabled")
+	}
+
+	reactivated := false
+	if user.IsDeactivated() {
+		if err := accounts.Reactivate(user); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, "failed to reactivate account")
+		}
+		reactivated = true
+	}
+
+	// Create session
+	session := &models.Session{
+		UserID:     user.ID,
+		IPAddress:  c.RealIP(),
+		UserAgent:  c.Request().UserAgent(),
+		ExpiresAt:  time.Now().Add(time.Duration(h.config.GetInt("security.session_timeout")) * time.Second),
+		LastUsedAt: time.Now(),
+	}
+	if err := h.db.Create(session).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to create session")
+	}
+
+	tokenPair, err := h.authService.GenerateTokenPair(user, session.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to generate tokens")
+	}
+
+	session.Token = tokenPair.AccessToken
+	session.RefreshToken = tokenPair.RefreshToken
+	if err := h.db.Save(session).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to save session")
+	}
+
+	user.LastLoginAt = &session.CreatedAt
+	h.db.Save(user)
+
+	return c.JSON(http.StatusOK, LoginResponse{
+		AccessToken:  tokenPair.AccessToken,
+		RefreshToken: tokenPair.RefreshToken,
+		ExpiresAt:    tokenPair.ExpiresAt,
+		Reactivated:  reactivated,
+		User: &UserResponse{
+			ID:          user.ID,
+			Username:    user.Username,
+			Email:       user.Email,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+			IsAdmin:     user.IsAdmin,
+		},
+	})
+}
+
+func (h *WebAuthnHandler) currentUser(c echo.Context) (*models.User, error) {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+	}
+	var user models.User
+	if err := h.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+	}
+	return &user, nil
+}
+
+func credentialResponse(credential *models.Credential) WebAuthnCredentialResponse {
+	transports := []string{}
+	if credential.Transports != "" {
+		transports = strings.Split(credential.Transports, ",")
+	}
+	return WebAuthnCredentialResponse{
+		ID:         credential.ID,
+		Name:       credential.Name,
+		Transports: transports,
+		Synced:     credential.BackupState,
+		LastUsedAt: credential.LastUsedAt,
+		CreatedAt:  credential.CreatedAt,
+	}
+}
+
+// requestOrigin is the origin the browser sent the request to, which
+// WebAuthn checks when server.url isn't set
+func requestOrigin(c echo.Context) string {
+	return fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
+}
+
+// webAuthnError maps a passkey error to a response. Verification details
+// are logged rather than returned.
+func webAuthnError(err error) error {
+	switch {
+	case errors.Is(err, passkey.ErrDisabled):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, passkey.ErrInvalidCeremony), errors.Is(err, passkey.ErrNoCredentials):
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	case errors.Is(err, passkey.ErrVerification):
+		slog.Warn("WebAuthn verification failed", "error", err)
+		return echo.NewHTTPError(http.StatusUnauthorized, passkey.ErrVerification.Error())
+	case errors.Is(err, passkey.ErrCredentialNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, passkey.ErrLastRequiredCredential):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	default:
+		slog.Error("WebAuthn request failed", "error", err)
+		return echo.NewHTTPError(http.StatusInternalServerError, "security key request failed")
+	}
+}
@@ -1,26 +1,29 @@
 package handlers
 
 import (
+	"encoding/json"
 	"net/http"
 	"strconv"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/services"
 	"github.com/casapps/casgists/src/internal/webhook"
+	"github.com/casapps/casgists/src/internal/webhooks"
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // WebhookHandler handles webhook-related endpoints
 type WebhookHandler struct {
 	db      *gorm.DB
-	config  *viper.Viper
+	config  *config.Config
 	manager *webhook.Manager
 }
 
 // NewWebhookHandler creates a new webhook handler
-func NewWebhookHandler(db *gorm.DB, config *viper.Viper, manager *webhook.Manager) *WebhookHandler {
+func NewWebhookHandler(db *gorm.DB, config *config.Config, manager *webhook.Manager) *WebhookHandler {
 	return &WebhookHandler{
 		db:      db,
 		config:  config,
@@ -50,6 +53,16 @@ func (h *WebhookHandler) List(c echo.Context) error {
 	query := h.db.Model(&models.Webhook{}).
 		Where("user_id = ?", userID)
 
+	// Organization webhooks are listed by org admins
+	if orgName := c.QueryParam("organization"); orgName != "" {
+		orgID, err := h.authorizeOrg(orgName, userID, "")
+		if err != nil {
+			return err
+		}
+		query = h.db.Model(&models.Webhook{}).
+			Where("organization_id = ?", orgID)
+	}
+
 	// Count total
 	var total int64
 	query.Count(&total)
@@ -97,12 +110,16 @@ func (h *WebhookHandler) Create(c echo.Context) error {
 
 	// Parse request
 	var req struct {
-		URL         string   `json:"url" validate:"required,url,https"`
+		URL         string   `json:"url" validate:"required,url,startswith=https://"`
 		EventTypes  []string `json:"event_types" validate:"required,min=1"`
 		Secret      string   `json:"secret" validate:"required,min=16"`
 		ContentType string   `json:"content_type"`
 		InsecureSSL bool     `json:"insecure_ssl"`
 		IsActive    bool     `json:"is_active"`
+		// Organization creates the webhook for an organization instead of the user
+		Organization string `json:"organization"`
+		// DigestWindow batches events over this many seconds into one delivery
+		DigestWindow int `json:"digest_window"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -114,34 +131,26 @@ func (h *WebhookHandler) Create(c echo.Context) error {
 	}
 
 	// Validate event types
-	validEventTypes := []string{
-		string(webhook.EventGistCreated),
-		string(webhook.EventGistUpdated),
-		string(webhook.EventGistDeleted),
-		string(webhook.EventGistStarred),
-		string(webhook.EventGistForked),
-		string(webhook.EventCommentAdded),
-		string(webhook.EventUserCreated),
-		string(webhook.EventOrgCreated),
-		string(webhook.EventTeamCreated),
-		"*", // All events
-	}
-
-	for _, eventType := range req.EventTypes {
-		valid := false
-		for _, validType := range validEventTypes {
-			if eventType == validType {
-				valid = true
-				break
-			}
-		}
-		if !valid {
-			return echo.NewHTTPError(http.StatusBadRequest, "Invalid event type: "+eventType)
-		}
+	if err := webhooks.ValidateEvents(req.EventTypes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := webhooks.ValidateDigestWindow(h.config, req.DigestWindow); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	// Create webhook subscription
-	sub, err := h.manager.CreateSubscription(userID, req.URL, req.EventTypes, req.Secret)
+	var sub *models.Webhook
+	var err error
+	if req.Organization != "" {
+		orgID, authErr := h.authorizeOrg(req.Organization, userID, req.URL)
+		if authErr != nil {
+			return authErr
+		}
+		sub, err = h.manager.CreateOrganizationSubscription(orgID, req.URL, req.EventTypes, req.Secret)
+	} else {
+		sub, err = h.manager.CreateSubscription(userID, req.URL, req.EventTypes, req.Secret)
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create webhook")
 	}
@@ -156,6 +165,7 @@ func (h *WebhookHandler) Create(c echo.Context) error {
 	}
 	
 	updates["insecure_ssl"] = req.InsecureSSL
+	updates["digest_window"] = req.DigestWindow
 
 	h.db.Model(sub).Updates(updates)
 
@@ -173,19 +183,10 @@ func (h *WebhookHandler) Get(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
-	// Get webhook ID from URL
-	webhookID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
-	}
-
 	// Find webhook
-	var wh models.Webhook
-	if err := h.db.Where("id = ? AND user_id = ?", webhookID, userID).First(&wh).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	wh, err := h.lookupWebhook(c, userID)
+	if err != nil {
+		return err
 	}
 
 	// Hide secret
@@ -202,29 +203,22 @@ func (h *WebhookHandler) Update(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
-	// Get webhook ID from URL
-	webhookID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
-	}
-
 	// Find webhook
-	var wh models.Webhook
-	if err := h.db.Where("id = ? AND user_id = ?", webhookID, userID).First(&wh).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	wh, err := h.lookupWebhook(c, userID)
+	if err != nil {
+		return err
 	}
 
 	// Parse request
 	var req struct {
-		URL         string   `json:"url" validate:"omitempty,url,https"`
+		URL         string   `json:"url" validate:"omitempty,url,startswith=https://"`
 		EventTypes  []string `json:"event_types"`
 		Secret      string   `json:"secret" validate:"omitempty,min=16"`
 		ContentType string   `json:"content_type"`
 		InsecureSSL *bool    `json:"insecure_ssl"`
 		IsActive    *bool    `json:"is_active"`
+		// DigestWindow set to 0 turns digest mode off
+		DigestWindow *int `json:"digest_window"`
 	}
 
 	if err := c.Bind(&req); err != nil {
@@ -239,10 +233,25 @@ func (h *WebhookHandler) Update(c echo.Context) error {
 	updates := map[string]interface{}{}
 
 	if req.URL != "" {
+		if wh.OrganizationID != nil {
+			if err := services.NewOrgPolicyService(h.db).CheckWebhookURL(*wh.OrganizationID, req.URL); err != nil {
+				return orgPolicyError(err)
+			}
+		}
 		updates["url"] = req.URL
 	}
-	if len(req.EventTypes) > 0 {
+	if len(req.EventTypes) > 0 && wh.GistID != nil {
+		// Gist webhooks keep their events as a JSON array
+		if err := validateGistEventTypes(req.EventTypes); err != nil {
+			return err
+		}
+		events, _ := json.Marshal(req.EventTypes)
+		updates["events"] = string(events)
+	} else if len(req.EventTypes) > 0 {
 		// Validate event types
+		if err := webhooks.ValidateEvents(req.EventTypes); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
 		eventTypesStr := ""
 		for i, et := range req.EventTypes {
 			if i > 0 {
@@ -264,14 +273,20 @@ func (h *WebhookHandler) Update(c echo.Context) error {
 	if req.IsActive != nil {
 		updates["is_active"] = *req.IsActive
 	}
+	if req.DigestWindow != nil {
+		if err := webhooks.ValidateDigestWindow(h.config, *req.DigestWindow); err != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		updates["digest_window"] = *req.DigestWindow
+	}
 
 	// Update webhook
-	if err := h.manager.UpdateSubscription(webhookID, updates); err != nil {
+	if err := h.manager.UpdateSubscription(wh.ID, updates); err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update webhook")
 	}
 
 	// Reload webhook
-	h.db.First(&wh, webhookID)
+	h.db.First(wh, wh.ID)
 
 	// Hide secret
 	wh.Secret = ""
@@ -287,20 +302,12 @@ func (h *WebhookHandler) Delete(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
-	// Get webhook ID from URL
-	webhookID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
-	}
-
 	// Verify ownership
-	var wh models.Webhook
-	if err := h.db.Where("id = ? AND user_id = ?", webhookID, userID).First(&wh).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	wh, err := h.lookupWebhook(c, userID)
+	if err != nil {
+		return err
 	}
+	webhookID := wh.ID
 
 	// Delete webhook
 	if err := h.manager.DeleteSubscription(webhookID); err != nil {
@@ -318,20 +325,12 @@ func (h *WebhookHandler) Test(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
-	// Get webhook ID from URL
-	webhookID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
-	}
-
 	// Verify ownership
-	var wh models.Webhook
-	if err := h.db.Where("id = ? AND user_id = ?", webhookID, userID).First(&wh).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	wh, err := h.lookupWebhook(c, userID)
+	if err != nil {
+		return err
 	}
+	webhookID := wh.ID
 
 	// Send test webhook
 	if err := h.manager.TestWebhook(webhookID); err != nil {
@@ -351,20 +350,12 @@ func (h *WebhookHandler) GetDeliveries(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
 	}
 
-	// Get webhook ID from URL
-	webhookID, err := uuid.Parse(c.Param("id"))
-	if err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
-	}
-
 	// Verify ownership
-	var wh models.Webhook
-	if err := h.db.Where("id = ? AND user_id = ?", webhookID, userID).First(&wh).Error; err != nil {
-		if err == gorm.ErrRecordNotFound {
-			return echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
-		}
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	wh, err := h.lookupWebhook(c, userID)
+	if err != nil {
+		return err
 	}
+	webhookID := wh.ID
 
 	// Parse pagination
 	page, _ := strconv.Atoi(c.QueryParam("page"))
@@ -404,64 +395,234 @@ func (h *WebhookHandler) GetDeliveries(c echo.Context) error {
 	})
 }
 
-// GetEventTypes returns available webhook event types
-func (h *WebhookHandler) GetEventTypes(c echo.Context) error {
-	eventTypes := []map[string]string{
-		{
-			"type":        string(webhook.EventGistCreated),
-			"description": "Triggered when a new gist is created",
-		},
-		{
-			"type":        string(webhook.EventGistUpdated),
-			"description": "Triggered when a gist is updated",
-		},
-		{
-			"type":        string(webhook.EventGistDeleted),
-			"description": "Triggered when a gist is deleted",
-		},
-		{
-			"type":        string(webhook.EventGistStarred),
-			"description": "Triggered when a gist is starred",
-		},
-		{
-			"type":        string(webhook.EventGistForked),
-			"description": "Triggered when a gist is forked",
-		},
-		{
-			"type":        string(webhook.EventCommentAdded),
-			"description": "Triggered when a comment is added to a gist",
-		},
-		{
-			"type":        string(webhook.EventUserCreated),
-			"description": "Triggered when a new user is created",
-		},
-		{
-			"type":        string(webhook.EventOrgCreated),
-			"description": "Triggered when a new organization is created",
-		},
-		{
-			"type":        string(webhook.EventTeamCreated),
-			"description": "Triggered when a new team is created",
-		},
-		{
-			"type":        "*",
-			"description": "All events",
-		},
+// lookupWebhook resolves the webhook addressed by the request, either
+// /webhooks/:id or /gists/:id/webhooks/:webhook_id
+func (h *WebhookHandler) lookupWebhook(c echo.Context, userID uuid.UUID) (*models.Webhook, error) {
+	if c.Param("webhook_id") == "" {
+		webhookID, err := uuid.Parse(c.Param("id"))
+		if err != nil {
+			return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
+		}
+		return h.findWebhook(webhookID, userID)
+	}
+
+	gistID, err := h.ownedGist(c, userID)
+	if err != nil {
+		return nil, err
+	}
+	webhookID, err := uuid.Parse(c.Param("webhook_id"))
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid webhook ID")
 	}
 
+	var wh models.Webhook
+	if err := h.db.Where("id = ? AND gist_id = ?", webhookID, gistID).First(&wh).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	}
+	return &wh, nil
+}
+
+// ownedGist parses the :id gist parameter and checks that the user owns the
+// gist; only owners manage its webhooks
+func (h *WebhookHandler) ownedGist(c echo.Context, userID uuid.UUID) (uuid.UUID, error) {
+	gistID, err := uuid.Parse(c.Param("id"))
+	if err != nil {
+		return uuid.Nil, echo.NewHTTPError(http.StatusBadRequest, "Invalid gist ID")
+	}
+
+	var gist struct {
+		UserID *uuid.UUID
+	}
+	result := h.db.Table("gists").Select("user_id").
+		Where("id = ? AND deleted_at IS NULL", gistID).Limit(1).Scan(&gist)
+	if result.Error != nil {
+		return uuid.Nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch gist")
+	}
+	if result.RowsAffected == 0 {
+		return uuid.Nil, echo.NewHTTPError(http.StatusNotFound, "Gist not found")
+	}
+	if gist.UserID == nil || *gist.UserID != userID {
+		return uuid.Nil, echo.NewHTTPError(http.StatusForbidden, "Only the gist owner can manage its webhooks")
+	}
+	return gistID, nil
+}
+
+// findWebhook loads a webhook owned by the user or by an organization the
+// user administers
+func (h *WebhookHandler) findWebhook(webhookID, userID uuid.UUID) (*models.Webhook, error) {
+	var wh models.Webhook
+	if err := h.db.Where("id = ?", webhookID).First(&wh).Error; err != nil {
+		if err == gorm.ErrRecordNotFound {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+		}
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhook")
+	}
+
+	if wh.UserID != nil && *wh.UserID == userID {
+		return &wh, nil
+	}
+	if wh.OrganizationID != nil &&
+		services.NewOrgPolicyService(h.db).AuthorizeWebhook(*wh.OrganizationID, userID, "") == nil {
+		return &wh, nil
+	}
+	return nil, echo.NewHTTPError(http.StatusNotFound, "Webhook not found")
+}
+
+// authorizeOrg checks that the user may manage the named organization's
+// webhooks and that the URL, if any, is on an allowed domain
+func (h *WebhookHandler) authorizeOrg(orgName string, userID uuid.UUID, rawURL string) (uuid.UUID, error) {
+	policy := services.NewOrgPolicyService(h.db)
+	org, err := policy.FindOrganization(orgName)
+	if err != nil {
+		return uuid.Nil, orgPolicyError(err)
+	}
+	if err := policy.AuthorizeWebhook(org.ID, userID, rawURL); err != nil {
+		return uuid.Nil, orgPolicyError(err)
+	}
+	return org.ID, nil
+}
+
+// gistEventTypes are the events a gist webhook can subscribe to: those about
+// an existing gist
+var gistEventTypes = webhooks.GistEvents()
+
+func validateGistEventTypes(eventTypes []string) error {
+	if err := webhooks.ValidateGistEvents(eventTypes); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+// ListForGist returns the webhooks attached to a gist
+func (h *WebhookHandler) ListForGist(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	gistID, err := h.ownedGist(c, userID)
+	if err != nil {
+		return err
+	}
+
+	var webhooks []models.Webhook
+	if err := h.db.Where("gist_id = ?", gistID).
+		Order("created_at DESC").
+		Find(&webhooks).Error; err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to fetch webhooks")
+	}
+
+	// Hide secrets
+	for i := range webhooks {
+		webhooks[i].Secret = ""
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"webhooks":    webhooks,
+		"total":       len(webhooks),
+		"event_types": gistEventTypes,
+	})
+}
+
+// CreateForGist attaches a webhook to a gist. It only receives events about
+// that gist, in addition to the owner's user-level webhooks.
+func (h *WebhookHandler) CreateForGist(c echo.Context) error {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	gistID, err := h.ownedGist(c, userID)
+	if err != nil {
+		return err
+	}
+
+	// Check webhook limit
+	var count int64
+	h.db.Model(&models.Webhook{}).Where("gist_id = ?", gistID).Count(&count)
+	maxWebhooks := h.config.GetInt64("webhook.max_per_gist")
+	if maxWebhooks > 0 && count >= maxWebhooks {
+		return echo.NewHTTPError(http.StatusBadRequest, "Webhook limit reached for this gist")
+	}
+
+	var req struct {
+		URL         string   `json:"url" validate:"required,url,startswith=https://"`
+		EventTypes  []string `json:"event_types" validate:"required,min=1"`
+		Secret      string   `json:"secret" validate:"required,min=16"`
+		ContentType string   `json:"content_type"`
+		InsecureSSL bool     `json:"insecure_ssl"`
+		IsActive    *bool    `json:"is_active"`
+		// DigestWindow batches events over this many seconds into one delivery
+		DigestWindow int `json:"digest_window"`
+	}
+
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request")
+	}
+
+	if err := c.Validate(req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	if err := validateGistEventTypes(req.EventTypes); err != nil {
+		return err
+	}
+	if err := webhooks.ValidateDigestWindow(h.config, req.DigestWindow); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	sub, err := h.manager.CreateGistSubscription(userID, gistID, req.URL, req.EventTypes, req.Secret)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create webhook")
+	}
+
+	updates := map[string]interface{}{
+		"insecure_ssl":  req.InsecureSSL,
+		"digest_window": req.DigestWindow,
+	}
+	if req.ContentType != "" {
+		updates["content_type"] = req.ContentType
+	}
+	if req.IsActive != nil {
+		updates["is_active"] = *req.IsActive
+	}
+	h.db.Model(sub).Updates(updates)
+
+	// Hide secret in response
+	sub.Secret = ""
+
+	return c.JSON(http.StatusCreated, sub)
+}
+
+// GetEventTypes returns available webhook event types. Besides these a
+// webhook can subscribe to a namespace such as "gist.*", or to "*".
+func (h *WebhookHandler) GetEventTypes(c echo.Context) error {
 	return c.JSON(http.StatusOK, map[string]interface{}{
-		"event_types": eventTypes,
+		"event_types": webhooks.Catalogue(),
 	})
 }
 
-// RegisterRoutes registers webhook routes
-func (h *WebhookHandler) RegisterRoutes(g *echo.Group) {
-	g.GET("/webhooks", h.List)
-	g.POST("/webhooks", h.Create)
+// RegisterRoutes registers webhook routes; auth wraps all but the event
+// type listing
+func (h *WebhookHandler) RegisterRoutes(g *echo.Group, auth echo.MiddlewareFunc) {
+	g.GET("/webhooks", h.List, auth)
+	g.POST("/webhooks", h.Create, auth)
 	g.GET("/webhooks/event-types", h.GetEventTypes)
-	g.GET("/webhooks/:id", h.Get)
-	g.PUT("/webhooks/:id", h.Update)
-	g.DELETE("/webhooks/:id", h.Delete)
-	g.POST("/webhooks/:id/test", h.Test)
-	g.GET("/webhooks/:id/deliveries", h.GetDeliveries)
+	g.GET("/webhooks/:id", h.Get, auth)
+	g.PUT("/webhooks/:id", h.Update, auth)
+	g.DELETE("/webhooks/:id", h.Delete, auth)
+	g.POST("/webhooks/:id/test", h.Test, auth)
+	g.GET("/webhooks/:id/deliveries", h.GetDeliveries, auth)
+
+	// Webhooks attached to a single gist
+	g.GET("/gists/:id/webhooks", h.ListForGist, auth)
+	g.POST("/gists/:id/webhooks", h.CreateForGist, auth)
+	g.GET("/gists/:id/webhooks/:webhook_id", h.Get, auth)
+	g.PUT("/gists/:id/webhooks/:webhook_id", h.Update, auth)
+	g.DELETE("/gists/:id/webhooks/:webhook_id", h.Delete, auth)
+	g.POST("/gists/:id/webhooks/:webhook_id/test", h.Test, auth)
+	g.GET("/gists/:id/webhooks/:webhook_id/deliveries", h.GetDeliveries, auth)
 }
\ No newline at end of file
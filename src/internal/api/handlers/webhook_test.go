@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	managermodels "github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/webhook"
+)
+
+// structValidator validates bound requests the way the server does
+type structValidator struct {
+	validate *validator.Validate
+}
+
+func (v structValidator) Validate(i interface{}) error {
+	if err := v.validate.Struct(i); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	return nil
+}
+
+func TestGistWebhookRoutesRequireAuth(t *testing.T) {
+	_, db, cfg := setupGistHandlerTest(t)
+	user, gist := createTestGist(t, db)
+	// The manager writes through its own model, which has the columns the
+	// migrations create
+	require.NoError(t, db.AutoMigrate(&managermodels.Webhook{}))
+
+	authService := auth.NewAuthService("test-secret", "casgists")
+	e := echo.New()
+	e.Validator = structValidator{validate: validator.New()}
+	NewWebhookHandler(db, cfg, webhook.NewManager(db, 1)).
+		RegisterRoutes(e.Group("/api/v1"), auth.NewMiddleware(authService).Auth())
+
+	tokens, err := authService.GenerateTokenPair(user, uuid.New())
+	require.NoError(t, err)
+	path := "/api/v1/gists/" + gist.ID.String() + "/webhooks"
+	serve := func(method, token, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	body := `{"url":"https://hooks.example.com/casgists","event_types":["gist.updated"],"secret":"0123456789abcdef"}`
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodPost, "", body).Code)
+	assert.Equal(t, http.StatusUnauthorized, serve(http.MethodGet, "", "").Code)
+
+	rec := serve(http.MethodPost, tokens.AccessToken, body)
+	require.Equal(t, http.StatusCreated, rec.Code, rec.Body.String())
+
+	rec = serve(http.MethodGet, tokens.AccessToken, "")
+	require.Equal(t, http.StatusOK, rec.Code, rec.Body.String())
+	var list struct {
+		Webhooks []struct {
+			URL string `json:"url"`
+		} `json:"webhooks"`
+	}
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	require.Len(t, list.Webhooks, 1)
+	assert.Equal(t, "https://hooks.example.com/casgists", list.Webhooks[0].URL)
+}
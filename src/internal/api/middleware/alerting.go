@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/alerting"
+)
+
+// AlertingMiddleware feeds response statuses to the alerting engine's error
+// rate rule
+func AlertingMiddleware(engine *alerting.Engine) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			err := next(c)
+
+			status := c.Response().Status
+			if err != nil {
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				} else {
+					status = http.StatusInternalServerError
+				}
+			}
+			engine.ObserveRequest(status)
+
+			return err
+		}
+	}
+}
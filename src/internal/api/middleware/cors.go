@@ -6,11 +6,12 @@ import (
 	"strings"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // CORS returns a CORS middleware configured from settings
-func CORS(cfg *viper.Viper) echo.MiddlewareFunc {
+func CORS(cfg *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			req := c.Request()
@@ -90,6 +91,8 @@ func isPublicReadEndpoint(c echo.Context) bool {
 			"/api/v1/explore",
 			"/api/v1/trending",
 			"/healthz",
+			"/livez",
+			"/readyz",
 		}
 
 		for _, publicPath := range publicPaths {
@@ -2,12 +2,14 @@ package middleware
 
 import (
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
 	"net/http"
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 const (
@@ -15,6 +17,9 @@ const (
 	csrfCookieName = "csrf_token"
 	csrfHeaderName = "X-CSRF-Token"
 	csrfFormField  = "csrf_token"
+
+	// CSRFHeaderName is the header browser clients send the token in
+	CSRFHeaderName = csrfHeaderName
 )
 
 // CSRFConfig holds CSRF middleware configuration
@@ -41,7 +46,7 @@ func DefaultCSRFConfig() CSRFConfig {
 }
 
 // CSRF returns CSRF protection middleware
-func CSRF(config *viper.Viper) echo.MiddlewareFunc {
+func CSRF(config *config.Config) echo.MiddlewareFunc {
 	// Check if CSRF is disabled
 	if config != nil && config.GetBool("security.disable_csrf") {
 		// Return a no-op middleware that just sets a token for templates
@@ -53,39 +58,50 @@ func CSRF(config *viper.Viper) echo.MiddlewareFunc {
 			}
 		}
 	}
-	
+
 	cfg := DefaultCSRFConfig()
-	
+
 	// Override with config values if available
 	if config != nil {
-		if config.GetBool("server.enable_https") {
+		if config.GetBool("server.enable_https") || config.GetBool("server.tls.enabled") {
 			cfg.CookieSecure = true
 		}
 		if domain := config.GetString("server.cookie_domain"); domain != "" {
 			cfg.CookieDomain = domain
 		}
 	}
-	
+
 	return CSRFWithConfig(cfg)
 }
 
-// CSRFWithConfig returns CSRF middleware with custom config
+// CSRFWithConfig returns double-submit cookie CSRF middleware with custom
+// config. Safe requests get a token cookie, which is kept for as long as it
+// is in use. State-changing requests signed in by the session cookie must
+// send the same token back in the X-CSRF-Token header or the csrf_token form
+// field. Requests with an Authorization header, or without a session, can't
+// be forged by another site and aren't checked.
 func CSRFWithConfig(config CSRFConfig) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			req := c.Request()
-			
+
 			// Skip CSRF for safe methods
-			if req.Method == http.MethodGet || req.Method == http.MethodHead || 
-			   req.Method == http.MethodOptions || req.Method == http.MethodTrace {
-				// Generate token for forms
-				token, err := generateCSRFToken(config.TokenLength)
-				if err != nil {
-					return err
+			if req.Method == http.MethodGet || req.Method == http.MethodHead ||
+				req.Method == http.MethodOptions || req.Method == http.MethodTrace {
+				// Keep the token the browser already has, so forms in other
+				// tabs stay valid; only a missing or malformed one is replaced
+				token := ""
+				if cookie, err := c.Cookie(csrfCookieName); err == nil && validCSRFToken(cookie.Value, config.TokenLength) {
+					token = cookie.Value
+				} else {
+					var err error
+					if token, err = generateCSRFToken(config.TokenLength); err != nil {
+						return err
+					}
 				}
-				
-				// Set cookie
-				cookie := &http.Cookie{
+
+				// Set the cookie again to push its expiry forward
+				c.SetCookie(&http.Cookie{
 					Name:     csrfCookieName,
 					Value:    token,
 					Path:     config.CookiePath,
@@ -94,40 +110,53 @@ func CSRFWithConfig(config CSRFConfig) echo.MiddlewareFunc {
 					Secure:   config.CookieSecure,
 					HttpOnly: config.CookieHTTPOnly,
 					SameSite: config.CookieSameSite,
-				}
-				c.SetCookie(cookie)
-				
+				})
+
 				// Set token in context for templates
 				c.Set(csrfTokenKey, token)
-				
+
 				return next(c)
 			}
-			
+
+			if !cookieSession(c) {
+				return next(c)
+			}
+
 			// For unsafe methods, verify CSRF token
 			cookie, err := c.Cookie(csrfCookieName)
-			if err != nil {
+			if err != nil || cookie.Value == "" {
 				return echo.NewHTTPError(http.StatusForbidden, "CSRF cookie not found")
 			}
-			
+
 			// Check token from multiple sources
 			token := extractCSRFToken(c)
 			if token == "" {
 				return echo.NewHTTPError(http.StatusForbidden, "CSRF token not found")
 			}
-			
+
 			// Validate token
-			if cookie.Value != token {
+			if subtle.ConstantTimeCompare([]byte(cookie.Value), []byte(token)) != 1 {
 				return echo.NewHTTPError(http.StatusForbidden, "Invalid CSRF token")
 			}
-			
+
 			// Set token in context for next handlers
 			c.Set(csrfTokenKey, token)
-			
+
 			return next(c)
 		}
 	}
 }
 
+// cookieSession reports whether the request is signed in by the session
+// cookie the browser sends on its own, rather than an Authorization header
+func cookieSession(c echo.Context) bool {
+	if c.Request().Header.Get("Authorization") != "" {
+		return false
+	}
+	cookie, err := c.Cookie("access_token")
+	return err == nil && cookie.Value != ""
+}
+
 // generateCSRFToken generates a random CSRF token
 func generateCSRFToken(length int) (string, error) {
 	bytes := make([]byte, length)
@@ -137,23 +166,29 @@ func generateCSRFToken(length int) (string, error) {
 	return base64.URLEncoding.EncodeToString(bytes), nil
 }
 
+// validCSRFToken reports whether token looks like one generateCSRFToken made
+func validCSRFToken(token string, length int) bool {
+	bytes, err := base64.URLEncoding.DecodeString(token)
+	return err == nil && len(bytes) == length
+}
+
 // extractCSRFToken extracts CSRF token from request
 func extractCSRFToken(c echo.Context) string {
 	// Try header first
 	if token := c.Request().Header.Get(csrfHeaderName); token != "" {
 		return token
 	}
-	
+
 	// Try form value
 	if token := c.FormValue(csrfFormField); token != "" {
 		return token
 	}
-	
+
 	// Try query parameter (for AJAX requests)
 	if token := c.QueryParam(csrfFormField); token != "" {
 		return token
 	}
-	
+
 	return ""
 }
 
@@ -170,4 +205,4 @@ func CSRFTokenFunc(c echo.Context) func() string {
 	return func() string {
 		return GetCSRFToken(c)
 	}
-}
\ No newline at end of file
+}
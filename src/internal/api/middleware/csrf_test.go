@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestCSRF(t *testing.T) {
+	e := echo.New()
+	e.Use(CSRF(config.New()))
+	e.GET("/form", func(c echo.Context) error { return c.String(http.StatusOK, GetCSRFToken(c)) })
+	e.POST("/gists", func(c echo.Context) error { return c.NoContent(http.StatusCreated) })
+
+	// A read hands out a token and keeps it on the next read
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookies := rec.Result().Cookies()
+	require.Len(t, cookies, 1)
+	token := cookies[0].Value
+	assert.Equal(t, token, rec.Body.String())
+
+	req := httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: token})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, token, rec.Body.String())
+
+	// A malformed cookie is replaced
+	req = httptest.NewRequest(http.MethodGet, "/form", nil)
+	req.AddCookie(&http.Cookie{Name: csrfCookieName, Value: "attacker-chosen"})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.NotEqual(t, "attacker-chosen", rec.Body.String())
+
+	post := func(header string, cookies ...*http.Cookie) int {
+		req := httptest.NewRequest(http.MethodPost, "/gists", nil)
+		if header != "" {
+			req.Header.Set(csrfHeaderName, header)
+		}
+		for _, cookie := range cookies {
+			req.AddCookie(cookie)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	session := &http.Cookie{Name: "access_token", Value: "session"}
+	csrfCookie := &http.Cookie{Name: csrfCookieName, Value: token}
+
+	assert.Equal(t, http.StatusCreated, post(token, session, csrfCookie))
+	assert.Equal(t, http.StatusForbidden, post("", session, csrfCookie))
+	assert.Equal(t, http.StatusForbidden, post(token, session))
+	assert.Equal(t, http.StatusForbidden, post(token+"x", session, csrfCookie))
+
+	// Without a session cookie there is nothing to forge
+	assert.Equal(t, http.StatusCreated, post(""))
+
+	// Bearer tokens aren't sent by the browser on their own
+	req = httptest.NewRequest(http.MethodPost, "/gists", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	req.AddCookie(session)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+func TestCSRFDisabled(t *testing.T) {
+	config := config.New()
+	config.Set("security.disable_csrf", true)
+
+	e := echo.New()
+	e.Use(CSRF(config))
+	e.POST("/gists", func(c echo.Context) error { return c.String(http.StatusCreated, GetCSRFToken(c)) })
+
+	req := httptest.NewRequest(http.MethodPost, "/gists", nil)
+	req.AddCookie(&http.Cookie{Name: "access_token", Value: "session"})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+	assert.Equal(t, "disabled", rec.Body.String())
+}
@@ -0,0 +1,175 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Deprecation describes a deprecated API route
+type Deprecation struct {
+	Method      string    `json:"method"`
+	Path        string    `json:"path"`
+	Since       time.Time `json:"deprecated_since"`
+	Sunset      time.Time `json:"sunset"`
+	Replacement string    `json:"replacement,omitempty"`
+	Message     string    `json:"message,omitempty"`
+}
+
+// maxDeprecationCallers is how many callers are counted apart per route.
+// Anonymous callers are counted by IP address, so calls from callers past
+// this are counted together as otherCallers instead of growing the map.
+const maxDeprecationCallers = 100
+
+// otherCallers counts the calls of callers past maxDeprecationCallers
+const otherCallers = "other"
+
+// DeprecationUsage counts calls made by a single caller to a deprecated route
+type DeprecationUsage struct {
+	Caller   string    `json:"caller"`
+	Count    int64     `json:"count"`
+	LastSeen time.Time `json:"last_seen"`
+}
+
+// DeprecationReport summarizes usage of one deprecated route
+type DeprecationReport struct {
+	Deprecation
+	TotalCalls int64              `json:"total_calls"`
+	Callers    []DeprecationUsage `json:"callers"`
+}
+
+// DeprecationRegistry tracks deprecated routes and who still calls them
+type DeprecationRegistry struct {
+	mu           sync.RWMutex
+	deprecations map[string]*Deprecation
+	usage        map[string]map[string]*DeprecationUsage
+}
+
+// NewDeprecationRegistry creates an empty deprecation registry
+func NewDeprecationRegistry() *DeprecationRegistry {
+	return &DeprecationRegistry{
+		deprecations: make(map[string]*Deprecation),
+		usage:        make(map[string]map[string]*DeprecationUsage),
+	}
+}
+
+// Register marks a route (as registered with echo, e.g. "/api/gists/:id") as deprecated
+func (r *DeprecationRegistry) Register(d Deprecation) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.deprecations[deprecationKey(d.Method, d.Path)] = &d
+}
+
+// Lookup returns the deprecation for a route, if any
+func (r *DeprecationRegistry) Lookup(method, path string) (*Deprecation, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	d, ok := r.deprecations[deprecationKey(method, path)]
+	return d, ok
+}
+
+// List returns all registered deprecations ordered by path
+func (r *DeprecationRegistry) List() []Deprecation {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	list := make([]Deprecation, 0, len(r.deprecations))
+	for _, d := range r.deprecations {
+		list = append(list, *d)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Path == list[j].Path {
+			return list[i].Method < list[j].Method
+		}
+		return list[i].Path < list[j].Path
+	})
+	return list
+}
+
+// Usage returns per-route usage counters for all deprecated routes
+func (r *DeprecationRegistry) Usage() []DeprecationReport {
+	deprecations := r.List()
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	reports := make([]DeprecationReport, 0, len(deprecations))
+	for _, d := range deprecations {
+		report := DeprecationReport{Deprecation: d, Callers: []DeprecationUsage{}}
+		for _, u := range r.usage[deprecationKey(d.Method, d.Path)] {
+			report.TotalCalls += u.Count
+			report.Callers = append(report.Callers, *u)
+		}
+		sort.Slice(report.Callers, func(i, j int) bool {
+			return report.Callers[i].Count > report.Callers[j].Count
+		})
+		reports = append(reports, report)
+	}
+	return reports
+}
+
+// record increments the usage counter for a caller
+func (r *DeprecationRegistry) record(key, caller string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	callers, ok := r.usage[key]
+	if !ok {
+		callers = make(map[string]*DeprecationUsage)
+		r.usage[key] = callers
+	}
+	u, ok := callers[caller]
+	if !ok && len(callers) >= maxDeprecationCallers {
+		caller = otherCallers
+		u, ok = callers[caller]
+	}
+	if !ok {
+		u = &DeprecationUsage{Caller: caller}
+		callers[caller] = u
+	}
+	u.Count++
+	u.LastSeen = time.Now().UTC()
+}
+
+// Middleware adds Deprecation, Sunset and Link headers to deprecated routes
+// and records which callers still use them
+func (r *DeprecationRegistry) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			d, ok := r.Lookup(c.Request().Method, c.Path())
+			if !ok {
+				return next(c)
+			}
+
+			// Headers per RFC 9745 (Deprecation) and RFC 8594 (Sunset)
+			header := c.Response().Header()
+			header.Set("Deprecation", fmt.Sprintf("@%d", d.Since.Unix()))
+			if !d.Sunset.IsZero() {
+				header.Set("Sunset", d.Sunset.UTC().Format(http.TimeFormat))
+			}
+			if d.Replacement != "" {
+				header.Add("Link", fmt.Sprintf(`<%s>; rel="successor-version"`, d.Replacement))
+			}
+			header.Add("Link", `</api/v1/meta/deprecations>; rel="deprecation"`)
+
+			err := next(c)
+
+			// Record after the handler so auth middleware has identified the caller
+			caller := "ip:" + c.RealIP()
+			if userID := c.Get("user_id"); userID != nil {
+				caller = fmt.Sprintf("user:%v", userID)
+			}
+			r.record(deprecationKey(d.Method, d.Path), caller)
+
+			return err
+		}
+	}
+}
+
+func deprecationKey(method, path string) string {
+	return method + " " + path
+}
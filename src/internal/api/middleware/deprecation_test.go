@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDeprecationMiddleware(t *testing.T) {
+	registry := NewDeprecationRegistry()
+	registry.Register(Deprecation{
+		Method:      http.MethodGet,
+		Path:        "/api/gists/:id",
+		Since:       time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC),
+		Sunset:      time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC),
+		Replacement: "/api/v1/gists/:id",
+	})
+
+	e := echo.New()
+	e.Use(registry.Middleware())
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/gists/:id", ok)
+	e.GET("/api/v1/gists/:id", ok)
+
+	t.Run("DeprecatedRouteHeaders", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/gists/abc", nil))
+
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, "@1790812800", rec.Header().Get("Deprecation"))
+		assert.Equal(t, "Thu, 01 Apr 2027 00:00:00 GMT", rec.Header().Get("Sunset"))
+		assert.Contains(t, rec.Header().Values("Link"), `</api/v1/gists/:id>; rel="successor-version"`)
+	})
+
+	t.Run("CurrentRouteHasNoHeaders", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/gists/abc", nil))
+
+		assert.Empty(t, rec.Header().Get("Deprecation"))
+		assert.Empty(t, rec.Header().Get("Sunset"))
+	})
+
+	t.Run("UsageCounters", func(t *testing.T) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/gists/def", nil))
+
+		usage := registry.Usage()
+		require.Len(t, usage, 1)
+		assert.Equal(t, int64(2), usage[0].TotalCalls)
+		require.Len(t, usage[0].Callers, 1)
+		assert.Equal(t, "ip:192.0.2.1", usage[0].Callers[0].Caller)
+	})
+}
+
+func TestDeprecationUsageCapsCallers(t *testing.T) {
+	registry := NewDeprecationRegistry()
+	registry.Register(Deprecation{Method: http.MethodGet, Path: "/api/gists"})
+
+	e := echo.New()
+	e.Use(registry.Middleware())
+	e.GET("/api/gists", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+
+	// Every call from a different address, as a scan would
+	calls := maxDeprecationCallers + 50
+	for i := 0; i < calls; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/api/gists", nil)
+		req.RemoteAddr = fmt.Sprintf("10.0.%d.%d:1234", i/256, i%256)
+		e.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	usage := registry.Usage()
+	require.Len(t, usage, 1)
+	assert.Equal(t, int64(calls), usage[0].TotalCalls)
+	assert.Len(t, usage[0].Callers, maxDeprecationCallers+1)
+	// The busiest caller sorts first
+	assert.Equal(t, otherCallers, usage[0].Callers[0].Caller)
+	assert.Equal(t, int64(50), usage[0].Callers[0].Count)
+}
@@ -2,8 +2,9 @@ package middleware
 
 import (
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // DatabaseInjector injects the database connection into context
@@ -17,7 +18,7 @@ func DatabaseInjector(db *gorm.DB) echo.MiddlewareFunc {
 }
 
 // ConfigInjector injects the configuration into context
-func ConfigInjector(cfg *viper.Viper) echo.MiddlewareFunc {
+func ConfigInjector(cfg *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			c.Set("config", cfg)
@@ -32,6 +33,6 @@ func GetDB(c echo.Context) *gorm.DB {
 }
 
 // GetConfig extracts configuration from context
-func GetConfig(c echo.Context) *viper.Viper {
-	return c.Get("config").(*viper.Viper)
+func GetConfig(c echo.Context) *config.Config {
+	return c.Get("config").(*config.Config)
 }
\ No newline at end of file
@@ -0,0 +1,111 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	registry := metrics.NewRegistry()
+	m := metrics.NewHTTPMetrics(registry)
+	registry.NewGaugeFunc("casgists_users", "Users.", func() float64 { return 3 })
+	registry.NewLabeledGaugeFunc("casgists_gists", "Gists by visibility.", "visibility", func() map[string]float64 {
+		return map[string]float64{"public": 2, "private": 1}
+	})
+
+	e := echo.New()
+	e.Use(Metrics(m))
+	e.GET("/gists/:id", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.GET("/broken", func(c echo.Context) error { return echo.NewHTTPError(http.StatusTeapot) })
+	e.GET("/metrics", MetricsHandler(registry))
+
+	for _, path := range []string{"/gists/1", "/gists/2", "/broken", "/nope/1", "/nope/2"} {
+		e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderContentType), "text/plain; version=0.0.4")
+	body := rec.Body.String()
+
+	// Routes are labelled by pattern, unknown paths share one series
+	assert.Contains(t, body, "# TYPE casgists_http_requests_total counter\n")
+	assert.Contains(t, body, `casgists_http_requests_total{method="GET",route="/gists/:id",status="200"} 2`+"\n")
+	assert.Contains(t, body, `casgists_http_requests_total{method="GET",route="/broken",status="418"} 1`+"\n")
+	assert.Contains(t, body, `casgists_http_requests_total{method="GET",route="unmatched",status="404"} 2`+"\n")
+
+	assert.Contains(t, body, "# TYPE casgists_http_request_duration_seconds histogram\n")
+	assert.Contains(t, body, `casgists_http_request_duration_seconds_bucket{method="GET",route="/gists/:id",le="+Inf"} 2`+"\n")
+	assert.Contains(t, body, `casgists_http_request_duration_seconds_count{method="GET",route="/gists/:id"} 2`+"\n")
+
+	// The scrape itself is in flight
+	assert.Contains(t, body, "casgists_http_requests_in_flight 1\n")
+
+	assert.Contains(t, body, "casgists_users 3\n")
+	assert.Contains(t, body, `casgists_gists{visibility="private"} 1`+"\n")
+	assert.Contains(t, body, `casgists_gists{visibility="public"} 2`+"\n")
+
+	count, _ := m.Recent.Stats()
+	assert.EqualValues(t, 6, count)
+}
+
+func TestMetricsRecentTraffic(t *testing.T) {
+	m := metrics.NewHTTPMetrics(metrics.NewRegistry())
+	assert.Equal(t, metrics.WindowSummary{}, m.Recent.Summary())
+
+	// 90 fast requests and 10 slow ones
+	for i := 0; i < 90; i++ {
+		m.Recent.Observe(2 * time.Millisecond)
+	}
+	for i := 0; i < 10; i++ {
+		m.Recent.Observe(400 * time.Millisecond)
+	}
+	summary := m.Recent.Summary()
+	assert.EqualValues(t, 100, summary.Count)
+	assert.Equal(t, 41800*time.Microsecond, summary.Average)
+	assert.GreaterOrEqual(t, summary.P50, 2*time.Millisecond)
+	assert.LessOrEqual(t, summary.P50, 2400*time.Microsecond)
+	assert.GreaterOrEqual(t, summary.P95, 400*time.Millisecond)
+	assert.LessOrEqual(t, summary.P95, 480*time.Millisecond)
+
+	// Open connections are counted through the server's ConnState hook
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	server.Config.ConnState = m.ConnState
+	server.Start()
+	client := server.Client()
+	resp, err := client.Get(server.URL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.EqualValues(t, 1, m.Connections.Value(), "the keep-alive connection stays open")
+
+	client.CloseIdleConnections()
+	server.Close()
+	assert.EqualValues(t, 0, m.Connections.Value())
+}
+
+func TestMetricsLabelEscaping(t *testing.T) {
+	registry := metrics.NewRegistry()
+	counter := registry.NewCounterVec("casgists_test_total", "Line one\nline two.", "value")
+	counter.WithLabelValues(`a "quoted" \ value`).Add(2)
+	counter.WithLabelValues("ignored").Add(-1)
+
+	e := echo.New()
+	rec := httptest.NewRecorder()
+	require.NoError(t, MetricsHandler(registry)(e.NewContext(httptest.NewRequest(http.MethodGet, "/metrics", nil), rec)))
+
+	assert.Equal(t, "# HELP casgists_test_total Line one\\nline two.\n"+
+		"# TYPE casgists_test_total counter\n"+
+		`casgists_test_total{value="a \"quoted\" \\ value"} 2`+"\n"+
+		`casgists_test_total{value="ignored"} 0`+"\n", rec.Body.String())
+}
@@ -0,0 +1,93 @@
+package middleware
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// NormalizeParams gives handlers route parameters in canonical form.
+// Parameters registered under a legacy name (gistId, filename, ...) are
+// renamed to the canonical one, and values the router left escaped are
+// unescaped. A gist ID in another form of the same UUID, such as upper
+// case or without hyphens, is redirected to the canonical URL for reads,
+// and replaced by the canonical ID for writes.
+func NormalizeParams() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			// The router shares the name slice between requests
+			names := append([]string(nil), c.ParamNames()...)
+			values := c.ParamValues()
+			escaped := c.Request().URL.RawPath != ""
+			for i, name := range names {
+				if canonical, ok := urls.ParamAliases[name]; ok {
+					names[i] = canonical
+				}
+				if escaped {
+					if unescaped, err := url.PathUnescape(values[i]); err == nil {
+						values[i] = unescaped
+					}
+				}
+			}
+			c.SetParamNames(names...)
+			c.SetParamValues(values...)
+
+			if !urls.IsGistRoute(c.Path()) {
+				return next(c)
+			}
+			raw := c.Param(urls.ParamGist)
+			id, ok := urls.CanonicalID(raw)
+			if !ok || id == raw {
+				return next(c)
+			}
+			method := c.Request().Method
+			if method == http.MethodGet || method == http.MethodHead {
+				return c.Redirect(http.StatusMovedPermanently, canonicalURL(c, id))
+			}
+			for i, name := range names {
+				if name == urls.ParamGist {
+					values[i] = id
+				}
+			}
+			c.SetParamValues(values...)
+			return next(c)
+		}
+	}
+}
+
+// canonicalURL is the request URL with the gist ID segment of the route
+// replaced by id
+func canonicalURL(c echo.Context, id string) string {
+	route := strings.Split(c.Path(), "/")
+	segments := strings.Split(c.Request().URL.EscapedPath(), "/")
+	for i, segment := range route {
+		if segment == ":"+urls.ParamGist && i < len(segments) {
+			segments[i] = id
+		}
+	}
+	location := strings.Join(segments, "/")
+	if query := c.Request().URL.RawQuery; query != "" {
+		location += "?" + query
+	}
+	return location
+}
+
+// RedirectTo permanently redirects a legacy route to a canonical route
+// pattern, carrying over its parameters and query string
+func RedirectTo(pattern string) echo.HandlerFunc {
+	return func(c echo.Context) error {
+		var values []string
+		for _, name := range urls.Params(pattern) {
+			values = append(values, c.Param(name))
+		}
+		location := urls.Path(pattern, values...)
+		if query := c.Request().URL.RawQuery; query != "" {
+			location += "?" + query
+		}
+		return c.Redirect(http.StatusMovedPermanently, location)
+	}
+}
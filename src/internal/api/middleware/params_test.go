@@ -0,0 +1,113 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+func TestNormalizeParams(t *testing.T) {
+	const id = "0b8e1c9a-5f5e-4f3c-9a53-7d2d6c1e4f10"
+
+	e := echo.New()
+	e.Use(NormalizeParams())
+	echoParams := func(c echo.Context) error {
+		return c.String(http.StatusOK, c.Param(urls.ParamGist)+"|"+c.Param(urls.ParamFile))
+	}
+	for _, route := range []string{
+		urls.GistPage, urls.GistEmbed, urls.GistJSON, urls.RawFile, urls.RawRevision, urls.APIGist, urls.APIRaw,
+		"/api/gists/:id/comments", "/api/v1/gists/:id/files/:file/image.png",
+	} {
+		e.GET(route, echoParams)
+		e.PUT(route, echoParams)
+	}
+	e.GET("/legacy/:gistId/:filename", echoParams)
+	e.GET("/api/v1/automation/rules/:id", echoParams)
+	for legacy, canonical := range urls.LegacyRoutes {
+		e.GET(legacy, RedirectTo(canonical))
+	}
+
+	serve := func(method, target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, target, nil))
+		return rec
+	}
+
+	t.Run("CanonicalRoutes", func(t *testing.T) {
+		for target, want := range map[string]string{
+			"/gists/" + id:                                  id + "|",
+			"/gists/" + id + "/embed":                       id + "|",
+			"/g/" + id:                                      id + "|",
+			"/raw/" + id + "/main.go":                       id + "|main.go",
+			"/raw/" + id + "/0f1e2d3/main.go":               id + "|main.go",
+			"/api/v1/gists/" + id:                           id + "|",
+			"/api/v1/gists/" + id + "/raw":                  id + "|",
+			"/api/gists/" + id + "/comments":                id + "|",
+			"/api/v1/gists/" + id + "/files/a.go/image.png": id + "|a.go",
+		} {
+			rec := serve(http.MethodGet, target)
+			assert.Equal(t, http.StatusOK, rec.Code, target)
+			assert.Equal(t, want, rec.Body.String(), target)
+		}
+	})
+
+	t.Run("LegacyParamNames", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/legacy/"+id+"/notes.md")
+		assert.Equal(t, id+"|notes.md", rec.Body.String())
+	})
+
+	t.Run("EscapedFilenames", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/raw/"+id+"/dir%2Fnotes%20v2.md")
+		assert.Equal(t, id+"|dir/notes v2.md", rec.Body.String())
+		rec = serve(http.MethodGet, "/raw/"+id+"/100%25.txt")
+		assert.Equal(t, id+"|100%.txt", rec.Body.String())
+	})
+
+	t.Run("NonCanonicalIDsRedirect", func(t *testing.T) {
+		short := strings.ReplaceAll(id, "-", "")
+		for target, want := range map[string]string{
+			"/gists/" + strings.ToUpper(id):            "/gists/" + id,
+			"/gists/" + short + "/embed?theme=dark":    "/gists/" + id + "/embed?theme=dark",
+			"/raw/" + short + "/a%20b.go":              "/raw/" + id + "/a%20b.go",
+			"/api/v1/gists/" + short + "/raw":          "/api/v1/gists/" + id + "/raw",
+			"/api/gists/" + short + "/comments?page=2": "/api/gists/" + id + "/comments?page=2",
+		} {
+			rec := serve(http.MethodGet, target)
+			assert.Equal(t, http.StatusMovedPermanently, rec.Code, target)
+			assert.Equal(t, want, rec.Header().Get(echo.HeaderLocation), target)
+		}
+	})
+
+	t.Run("NonCanonicalIDsOnWrites", func(t *testing.T) {
+		rec := serve(http.MethodPut, "/api/v1/gists/"+strings.ToUpper(id))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, id+"|", rec.Body.String())
+	})
+
+	t.Run("OtherRoutesKeepTheirIDs", func(t *testing.T) {
+		rec := serve(http.MethodGet, "/api/v1/automation/rules/"+strings.ToUpper(id))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Equal(t, strings.ToUpper(id)+"|", rec.Body.String())
+		rec = serve(http.MethodGet, "/gists/not-a-uuid")
+		assert.Equal(t, http.StatusOK, rec.Code)
+	})
+
+	t.Run("LegacyRoutes", func(t *testing.T) {
+		for target, want := range map[string]string{
+			"/gist/" + id:                            "/gists/" + id,
+			"/gists/" + id + "/raw":                  "/api/v1/gists/" + id + "/raw",
+			"/gists/" + id + "/raw?format=multipart": "/api/v1/gists/" + id + "/raw?format=multipart",
+			"/gists/" + id + "/raw/my%20file.txt":    "/raw/" + id + "/my%20file.txt",
+		} {
+			rec := serve(http.MethodGet, target)
+			assert.Equal(t, http.StatusMovedPermanently, rec.Code, target)
+			assert.Equal(t, want, rec.Header().Get(echo.HeaderLocation), target)
+		}
+	})
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// forwardedHeaders are the headers a reverse proxy sets to describe the
+// original request. Clients can send them too, so they only count when
+// they come from a trusted proxy.
+var forwardedHeaders = []string{
+	echo.HeaderXForwardedFor,
+	echo.HeaderXRealIP,
+	echo.HeaderXForwardedProto,
+	echo.HeaderXForwardedProtocol,
+	echo.HeaderXForwardedSsl,
+	echo.HeaderXUrlScheme,
+	"X-Forwarded-Host",
+	"Forwarded",
+}
+
+// TrustedProxies are the reverse proxies whose X-Forwarded-* headers are
+// believed: the client address, scheme and host they report replace those
+// of the connection
+type TrustedProxies struct {
+	nets []*net.IPNet
+}
+
+// ParseTrustedProxies parses a list of CIDR ranges and single addresses
+func ParseTrustedProxies(entries []string) (*TrustedProxies, error) {
+	proxies := &TrustedProxies{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			ip := net.ParseIP(entry)
+			if ip == nil {
+				return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+			}
+			bits := 128
+			if ip.To4() != nil {
+				bits = 32
+			}
+			entry = fmt.Sprintf("%s/%d", ip, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q", entry)
+		}
+		proxies.nets = append(proxies.nets, ipNet)
+	}
+	return proxies, nil
+}
+
+// TrustedProxiesFromViper reads server.trusted_proxies. An invalid list
+// trusts no proxy; config lint reports it.
+func TrustedProxiesFromViper(config *config.Config) *TrustedProxies {
+	proxies, err := ParseTrustedProxies(config.GetStringSlice("server.trusted_proxies"))
+	if err != nil {
+		return &TrustedProxies{}
+	}
+	return proxies
+}
+
+// Trusts reports whether a connection from addr, an IP with or without a
+// port, comes from a trusted proxy
+func (p *TrustedProxies) Trusts(addr string) bool {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		addr = host
+	}
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, ipNet := range p.nets {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// IPExtractor returns the client address for c.RealIP(): the rightmost
+// X-Forwarded-For entry that isn't a trusted proxy
+func (p *TrustedProxies) IPExtractor() echo.IPExtractor {
+	options := []echo.TrustOption{
+		echo.TrustLoopback(false),
+		echo.TrustLinkLocal(false),
+		echo.TrustPrivateNet(false),
+	}
+	for _, ipNet := range p.nets {
+		options = append(options, echo.TrustIPRange(ipNet))
+	}
+	return echo.ExtractIPFromXFFHeader(options...)
+}
+
+// Middleware drops the forwarded headers of requests that don't come from a
+// trusted proxy, so c.Scheme() and the request's host can't be spoofed, and
+// takes the host from X-Forwarded-Host for those that do. X-Real-IP is set
+// to the client address, for code that reads the request directly. It must
+// run before routing (echo.Pre).
+func (p *TrustedProxies) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			if p.Trusts(req.RemoteAddr) {
+				if host := req.Header.Get("X-Forwarded-Host"); host != "" {
+					host, _, _ = strings.Cut(host, ",")
+					req.Host = strings.TrimSpace(host)
+				}
+			} else {
+				for _, header := range forwardedHeaders {
+					req.Header.Del(header)
+				}
+			}
+			req.Header.Set(echo.HeaderXRealIP, c.RealIP())
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTrustedProxies(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"10.0.0.0/8", "192.0.2.7", "::1", ""})
+	require.NoError(t, err)
+	assert.True(t, proxies.Trusts("10.1.2.3:443"))
+	assert.True(t, proxies.Trusts("192.0.2.7"))
+	assert.True(t, proxies.Trusts("[::1]:8080"))
+	assert.False(t, proxies.Trusts("192.0.2.8:80"))
+	assert.False(t, proxies.Trusts("not-an-ip"))
+
+	_, err = ParseTrustedProxies([]string{"10.0.0.0/33"})
+	assert.Error(t, err)
+	_, err = ParseTrustedProxies([]string{"proxy.example.com"})
+	assert.Error(t, err)
+}
+
+func TestTrustedProxiesMiddleware(t *testing.T) {
+	proxies, err := ParseTrustedProxies([]string{"127.0.0.1"})
+	require.NoError(t, err)
+
+	e := echo.New()
+	e.IPExtractor = proxies.IPExtractor()
+	e.Pre(proxies.Middleware())
+	e.GET("/", func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]string{
+			"ip":      c.RealIP(),
+			"scheme":  c.Scheme(),
+			"host":    c.Request().Host,
+			"real_ip": c.Request().Header.Get(echo.HeaderXRealIP),
+		})
+	})
+
+	request := func(remoteAddr string) string {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Host = "127.0.0.1:8080"
+		req.Header.Set(echo.HeaderXForwardedFor, "203.0.113.9, 198.51.100.4")
+		req.Header.Set(echo.HeaderXRealIP, "203.0.113.9")
+		req.Header.Set(echo.HeaderXForwardedProto, "https")
+		req.Header.Set("X-Forwarded-Host", "gists.example.com")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Body.String()
+	}
+
+	// Behind the proxy, the client is the rightmost address the proxy
+	// didn't add itself
+	assert.JSONEq(t, `{"ip": "198.51.100.4", "scheme": "https", "host": "gists.example.com", "real_ip": "198.51.100.4"}`,
+		request("127.0.0.1:50000"))
+
+	// Anyone else can't claim to be someone
+	assert.JSONEq(t, `{"ip": "192.0.2.1", "scheme": "http", "host": "127.0.0.1:8080", "real_ip": "192.0.2.1"}`,
+		request("192.0.2.1:50000"))
+}
@@ -0,0 +1,238 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/ratelimit"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// PublicReadConfig configures the anonymous read tier
+type PublicReadConfig struct {
+	Enabled       bool          // Allow public reads without a token
+	Limit         int           // Requests per client IP per window
+	Window        time.Duration // Rate limit window
+	CacheTTL      time.Duration // How long anonymous responses are cached; 0 disables caching
+	MaxCacheBytes int           // Larger responses are not cached
+}
+
+// PublicReadConfigFromViper reads the tier configuration from public_api.*
+// and ratelimit.anonymous_api
+func PublicReadConfigFromViper(cfg *config.Config) PublicReadConfig {
+	return PublicReadConfig{
+		Enabled:       cfg.GetBool("public_api.enabled"),
+		Limit:         cfg.GetInt("ratelimit.anonymous_api"),
+		Window:        cfg.GetDuration("public_api.window"),
+		CacheTTL:      cfg.GetDuration("public_api.cache_ttl"),
+		MaxCacheBytes: cfg.GetInt("public_api.max_cache_bytes"),
+	}
+}
+
+// responseStore is the subset of the cache API the tier needs
+type responseStore interface {
+	GetJSON(ctx context.Context, key string, dest interface{}) error
+	SetJSON(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+}
+
+// PublicReadTier lets integrations read public content without
+// credentials. Anonymous requests are rate limited per client IP and served
+// from a short-lived response cache; authenticated requests pass straight
+// through. Every anonymous response carries RateLimit-* and X-RateLimit-*
+// headers.
+type PublicReadTier struct {
+	cfg    PublicReadConfig
+	cache  responseStore
+	limits ratelimit.Store
+}
+
+type cachedResponse struct {
+	ContentType  string `json:"content_type"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+	CacheControl string `json:"cache_control,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// NewPublicReadTier creates the anonymous read tier. Responses are cached in
+// the shared cache when it is enabled, and in process memory otherwise.
+// Requests are counted in limits, or in memory when limits is nil.
+func NewPublicReadTier(cfg PublicReadConfig, cacheManager *cache.CacheManager, limits ratelimit.Store) *PublicReadTier {
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+	if cfg.MaxCacheBytes <= 0 {
+		cfg.MaxCacheBytes = 1024 * 1024
+	}
+
+	var store responseStore = cache.NewMemoryCache()
+	if cacheManager != nil && cacheManager.Enabled() {
+		store = cacheManager
+	}
+
+	if limits == nil {
+		limits = ratelimit.NewMemoryStore()
+	}
+
+	return &PublicReadTier{
+		cfg:    cfg,
+		cache:  store,
+		limits: limits,
+	}
+}
+
+// Middleware returns the tier middleware. It must run after OptionalAuth so
+// authenticated callers are recognized.
+func (t *PublicReadTier) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if _, ok := c.Get("user_id").(uuid.UUID); ok {
+				return next(c)
+			}
+			req := c.Request()
+			if req.Method != http.MethodGet && req.Method != http.MethodHead {
+				return next(c)
+			}
+			// Share links on gist routes authorize the request themselves,
+			// even with the tier off, and their responses must not be cached
+			// for others
+			shared := c.QueryParam(urls.QueryShare) != "" && urls.IsGistRoute(c.Path())
+			if !t.cfg.Enabled {
+				if !shared {
+					return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+				}
+				c.Set("share_only", true)
+			}
+
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Tier", "anonymous")
+			if t.cfg.Limit > 0 {
+				now := time.Now()
+				result, err := t.limits.Take(req.Context(), "public:"+c.RealIP(), t.cfg.Limit, t.cfg.Window)
+				if err != nil {
+					slog.Warn("Anonymous rate limit not checked", "error", err)
+				} else {
+					setRateLimitHeaders(header, result, t.cfg.Window, now)
+					if !result.Allowed {
+						header.Set("Retry-After", strconv.Itoa(int(result.RetryAfter(now).Seconds())))
+						return echo.NewHTTPError(http.StatusTooManyRequests, "anonymous rate limit exceeded, authenticate for a higher limit")
+					}
+				}
+			}
+
+			if t.cfg.CacheTTL <= 0 || shared {
+				return next(c)
+			}
+			return t.serveCached(c, next)
+		}
+	}
+}
+
+// serveCached answers from the response cache, or runs the handler and
+// caches a successful response. Replies keep the ETag, Last-Modified and
+// Cache-Control the handler set, and are answered 304 when the client has
+// them.
+func (t *PublicReadTier) serveCached(c echo.Context, next echo.HandlerFunc) error {
+	req := c.Request()
+	sum := sha256.Sum256([]byte(req.URL.RequestURI() + "\n" + req.Header.Get("Accept")))
+	key := cache.Key(cache.NamespacePublicRead, hex.EncodeToString(sum[:]))
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", fmt.Sprintf("public, max-age=%d", int(t.cfg.CacheTTL.Seconds())))
+	header.Add("Vary", "Authorization")
+
+	var cached cachedResponse
+	if err := t.cache.GetJSON(req.Context(), key, &cached); err == nil {
+		header.Set("X-Cache", "HIT")
+		if cached.CacheControl != "" {
+			header.Set("Cache-Control", cached.CacheControl)
+		}
+		modified, _ := http.ParseTime(cached.LastModified)
+		if httpcache.NotModified(c, cached.ETag, modified) {
+			return c.NoContent(http.StatusNotModified)
+		}
+		return c.Blob(http.StatusOK, cached.ContentType, cached.Body)
+	}
+	header.Set("X-Cache", "MISS")
+
+	res := c.Response()
+	recorder := &captureWriter{ResponseWriter: res.Writer, limit: t.cfg.MaxCacheBytes}
+	res.Writer = recorder
+	err := next(c)
+	res.Writer = recorder.ResponseWriter
+	if err != nil {
+		// The error handler writes the response after this returns; it
+		// must not be kept by shared caches as the page's content
+		header.Set("Cache-Control", "no-store")
+		return err
+	}
+
+	// Handlers mark responses that must not be replayed, such as the one
+	// burning a burn-after-read gist, no-store
+	storable := !strings.Contains(header.Get("Cache-Control"), "no-store")
+	if res.Status == http.StatusOK && !recorder.overflow && req.Method == http.MethodGet && storable {
+		cached = cachedResponse{
+			ContentType:  header.Get(echo.HeaderContentType),
+			ETag:         header.Get("ETag"),
+			LastModified: header.Get(echo.HeaderLastModified),
+			CacheControl: header.Get("Cache-Control"),
+			Body:         recorder.body.Bytes(),
+		}
+		_ = t.cache.SetJSON(req.Context(), key, cached, t.cfg.CacheTTL)
+	}
+	return nil
+}
+
+// IsShareOnly reports whether an anonymous request reached a handler only
+// because it carries a share link, so it may read nothing else
+func IsShareOnly(c echo.Context) bool {
+	shareOnly, _ := c.Get("share_only").(bool)
+	return shareOnly
+}
+
+// captureWriter copies what the handler writes, up to limit bytes
+type captureWriter struct {
+	http.ResponseWriter
+	body     bytes.Buffer
+	limit    int
+	overflow bool
+}
+
+func (w *captureWriter) Write(b []byte) (int, error) {
+	if !w.overflow {
+		if w.body.Len()+len(b) > w.limit {
+			w.overflow = true
+			w.body.Reset()
+		} else {
+			w.body.Write(b)
+		}
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush lets streaming handlers flush through the capture
+func (w *captureWriter) Flush() {
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the connection, to set write
+// deadlines
+func (w *captureWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,165 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPublicReadTier(t *testing.T) {
+	tier := NewPublicReadTier(PublicReadConfig{
+		Enabled:  true,
+		Limit:    2,
+		Window:   time.Minute,
+		CacheTTL: time.Minute,
+	}, nil, nil)
+
+	calls := 0
+	e := echo.New()
+	e.GET("/gists", func(c echo.Context) error {
+		calls++
+		return c.JSON(http.StatusOK, map[string]int{"calls": calls})
+	}, tier.Middleware())
+	e.GET("/me", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			c.Set("user_id", uuid.New())
+			return next(c)
+		}
+	}, tier.Middleware())
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "192.0.2.1:1234"
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/gists")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "anonymous", rec.Header().Get("X-RateLimit-Tier"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("X-RateLimit-Reset"))
+	assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "public, max-age=60", rec.Header().Get("Cache-Control"))
+
+	// The second request is answered from the cache
+	rec = get("/gists")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, "0", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.JSONEq(t, `{"calls":1}`, rec.Body.String())
+	assert.Equal(t, 1, calls)
+
+	rec = get("/gists")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Authenticated callers are not limited by the anonymous tier
+	rec = get("/me")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Tier"))
+}
+
+func TestPublicReadTierDisabled(t *testing.T) {
+	tier := NewPublicReadTier(PublicReadConfig{Enabled: false}, nil, nil)
+
+	e := echo.New()
+	e.GET("/gists", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, tier.Middleware())
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gists", nil))
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+}
+
+func TestPublicReadTierNoStore(t *testing.T) {
+	tier := NewPublicReadTier(PublicReadConfig{Enabled: true, CacheTTL: time.Minute}, nil, nil)
+
+	calls := 0
+	e := echo.New()
+	e.GET("/gists/burn", func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set("Cache-Control", "private, no-store")
+		return c.JSON(http.StatusOK, map[string]int{"calls": calls})
+	}, tier.Middleware())
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gists/burn", nil))
+		assert.Equal(t, "MISS", rec.Header().Get("X-Cache"))
+	}
+	// Responses marked no-store are never replayed
+	assert.Equal(t, 2, calls)
+}
+
+func TestPublicReadTierErrors(t *testing.T) {
+	tier := NewPublicReadTier(PublicReadConfig{Enabled: true, CacheTTL: time.Minute}, nil, nil)
+
+	calls := 0
+	e := echo.New()
+	e.GET("/gists/private", func(c echo.Context) error {
+		calls++
+		return echo.NewHTTPError(http.StatusForbidden, "access denied")
+	}, tier.Middleware())
+
+	for i := 0; i < 2; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gists/private", nil))
+		assert.Equal(t, http.StatusForbidden, rec.Code)
+		// Errors must not be kept by a CDN as the gist's content
+		assert.Equal(t, "no-store", rec.Header().Get("Cache-Control"))
+	}
+	assert.Equal(t, 2, calls)
+}
+
+func TestPublicReadTierValidators(t *testing.T) {
+	tier := NewPublicReadTier(PublicReadConfig{Enabled: true, CacheTTL: time.Minute}, nil, nil)
+
+	calls := 0
+	e := echo.New()
+	e.GET("/raw", func(c echo.Context) error {
+		calls++
+		c.Response().Header().Set("ETag", `"abc"`)
+		c.Response().Header().Set("Last-Modified", "Sun, 01 Mar 2026 12:00:00 GMT")
+		c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+		return c.String(http.StatusOK, "content")
+	}, tier.Middleware())
+
+	get := func(header, value string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/raw", nil)
+		if header != "" {
+			req.Header.Set(header, value)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	get("", "")
+	// Cached replies carry the handler's validators and caching policy
+	rec := get("", "")
+	assert.Equal(t, "HIT", rec.Header().Get("X-Cache"))
+	assert.Equal(t, `"abc"`, rec.Header().Get("ETag"))
+	assert.Equal(t, "Sun, 01 Mar 2026 12:00:00 GMT", rec.Header().Get("Last-Modified"))
+	assert.Equal(t, "public, max-age=31536000, immutable", rec.Header().Get("Cache-Control"))
+	assert.Equal(t, "content", rec.Body.String())
+
+	rec = get("If-None-Match", `"abc"`)
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	rec = get("If-Modified-Since", "Sun, 01 Mar 2026 12:00:00 GMT")
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	rec = get("If-Modified-Since", "Sat, 28 Feb 2026 12:00:00 GMT")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, calls)
+}
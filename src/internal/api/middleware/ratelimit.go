@@ -1,17 +1,129 @@
 package middleware
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/ratelimit"
 )
 
-// RateLimit returns a rate limiting middleware
-func RateLimit(cfg *viper.Viper) echo.MiddlewareFunc {
-	// For now, return a simple middleware that does nothing
-	// TODO: Implement proper rate limiting
+// RateLimiter limits requests by the policies of ratelimit.*. It runs
+// before authentication, so a token is counted whether or not it turns out
+// to be valid; the client IP's bucket keeps made-up tokens from getting
+// around the limit.
+type RateLimiter struct {
+	store ratelimit.Store
+
+	mu       sync.RWMutex
+	policies map[string]ratelimit.Policy
+	enabled  bool
+}
+
+// NewRateLimiter creates a rate limiter that counts requests in store
+func NewRateLimiter(cfg *config.Config, store ratelimit.Store) *RateLimiter {
+	if store == nil {
+		store = ratelimit.NewMemoryStore()
+	}
+	return &RateLimiter{
+		store:    store,
+		policies: ratelimit.PoliciesFromConfig(cfg),
+		enabled:  cfg.GetBool("ratelimit.enabled"),
+	}
+}
+
+// Reload applies the ratelimit.* settings of cfg to the requests that
+// follow. Counts so far are kept.
+func (l *RateLimiter) Reload(cfg *config.Config) {
+	policies := ratelimit.PoliciesFromConfig(cfg)
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.policies = policies
+	l.enabled = cfg.GetBool("ratelimit.enabled")
+}
+
+// policy returns the named policy, and false when it limits nothing
+func (l *RateLimiter) policy(name string) (ratelimit.Policy, bool) {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	policy, ok := l.policies[name]
+	return policy, l.enabled && ok && policy.Active()
+}
+
+// Middleware returns the middleware for a policy. Responses carry the
+// RateLimit-* headers of the bucket closest to its limit; refused requests
+// also get Retry-After. If the store fails the request is let through.
+// The policy is looked up on each request, so Reload applies at once.
+func (l *RateLimiter) Middleware(name string) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			policy, active := l.policy(name)
+			if !active {
+				return next(c)
+			}
+
+			type bucket struct {
+				key   string
+				limit int
+			}
+			var buckets []bucket
+			if token := requestToken(c); token != "" && policy.PerToken > 0 {
+				buckets = append(buckets, bucket{policy.Name + ":token:" + token, policy.PerToken})
+			}
+			if policy.PerIP > 0 {
+				buckets = append(buckets, bucket{policy.Name + ":ip:" + c.RealIP(), policy.PerIP})
+			}
+
+			var reported *ratelimit.Result
+			for _, b := range buckets {
+				result, err := l.store.Take(c.Request().Context(), b.key, b.limit, policy.Window)
+				if err != nil {
+					slog.Warn("Rate limit not checked", "policy", policy.Name, "error", err)
+					continue
+				}
+				if reported == nil || !result.Allowed || result.Remaining < reported.Remaining {
+					reported = &result
+				}
+				if !result.Allowed {
+					break
+				}
+			}
+			if reported == nil {
+				return next(c)
+			}
+
+			now := time.Now()
+			header := c.Response().Header()
+			ratelimit.SetHeaders(header, *reported, policy.Window, now)
+			if !reported.Allowed {
+				header.Set("Retry-After", strconv.Itoa(int(reported.RetryAfter(now).Seconds())))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "too many requests, try again later")
+			}
 			return next(c)
 		}
 	}
-}
\ No newline at end of file
+}
+
+// requestToken identifies the credentials a request carries, from the
+// Authorization header or the session cookie. The token is hashed so it
+// isn't kept in the store.
+func requestToken(c echo.Context) string {
+	token := c.Request().Header.Get("Authorization")
+	if token == "" {
+		if cookie, err := c.Cookie("access_token"); err == nil {
+			token = cookie.Value
+		}
+	}
+	if token == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:16])
+}
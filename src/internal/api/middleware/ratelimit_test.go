@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/ratelimit"
+)
+
+func TestRateLimiter(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("ratelimit.enabled", true)
+	cfg.Set("ratelimit.login_attempts", 2)
+	cfg.Set("ratelimit.login_window", 15)
+	cfg.Set("ratelimit.api_per_ip", 3)
+	cfg.Set("ratelimit.api_per_token", 1)
+	cfg.Set("ratelimit.api_window", "1m")
+	limiter := NewRateLimiter(cfg, nil)
+
+	e := echo.New()
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.POST("/login", ok, limiter.Middleware(ratelimit.PolicyLogin))
+	e.GET("/api", ok, limiter.Middleware(ratelimit.PolicyAPI))
+	e.GET("/open", ok, limiter.Middleware(ratelimit.PolicyRaw))
+
+	send := func(method, path, ip, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := send(http.MethodPost, "/login", "192.0.2.1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, "900", rec.Header().Get("RateLimit-Reset"))
+	assert.Equal(t, "2;w=900", rec.Header().Get("RateLimit-Policy"))
+
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/login", "192.0.2.1", "").Code)
+	rec = send(http.MethodPost, "/login", "192.0.2.1", "")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Each client IP has its own bucket
+	assert.Equal(t, http.StatusOK, send(http.MethodPost, "/login", "192.0.2.2", "").Code)
+
+	// A token's requests count against the token and the IP; the response
+	// describes whichever is closer to its limit
+	rec = send(http.MethodGet, "/api", "198.51.100.1", "first")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("RateLimit-Limit"))
+	assert.Equal(t, "0", rec.Header().Get("RateLimit-Remaining"))
+	assert.Equal(t, http.StatusTooManyRequests, send(http.MethodGet, "/api", "198.51.100.1", "first").Code)
+
+	// Made-up tokens still run into the IP's limit
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api", "198.51.100.1", "second").Code)
+	assert.Equal(t, http.StatusOK, send(http.MethodGet, "/api", "198.51.100.1", "third").Code)
+	rec = send(http.MethodGet, "/api", "198.51.100.1", "fourth")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "3", rec.Header().Get("RateLimit-Limit"))
+
+	// Policies without limits pass everything through
+	rec = send(http.MethodGet, "/open", "198.51.100.1", "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("RateLimit-Limit"))
+}
+
+func TestRateLimiterDisabled(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("ratelimit.enabled", false)
+	cfg.Set("ratelimit.login_attempts", 1)
+	cfg.Set("ratelimit.login_window", 15)
+	limiter := NewRateLimiter(cfg, nil)
+
+	e := echo.New()
+	e.POST("/login", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, limiter.Middleware(ratelimit.PolicyLogin))
+
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+}
+
+func TestRateLimiterReload(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("ratelimit.enabled", false)
+	cfg.Set("ratelimit.login_attempts", 1)
+	cfg.Set("ratelimit.login_window", 15)
+	limiter := NewRateLimiter(cfg, nil)
+
+	e := echo.New()
+	e.POST("/login", func(c echo.Context) error {
+		return c.NoContent(http.StatusOK)
+	}, limiter.Middleware(ratelimit.PolicyLogin))
+	login := func() int {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/login", nil))
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, login())
+	assert.Equal(t, http.StatusOK, login())
+
+	// Routes registered before the reload follow the new settings
+	cfg.Set("ratelimit.enabled", true)
+	limiter.Reload(cfg)
+	assert.Equal(t, http.StatusOK, login())
+	assert.Equal(t, http.StatusTooManyRequests, login())
+
+	cfg.Set("ratelimit.login_attempts", 5)
+	limiter.Reload(cfg)
+	assert.Equal(t, http.StatusOK, login())
+}
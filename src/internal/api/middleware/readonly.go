@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// readOnlyCheckInterval bounds how often the read-only flag is read from
+// the database. The flag is usually flipped by the CLI in another process.
+const readOnlyCheckInterval = 2 * time.Second
+
+// readOnlyExemptPaths can still be posted to in read-only mode, so people
+// can sign in and out while content is frozen
+var readOnlyExemptPaths = []string{
+	"/auth/login",
+	"/auth/logout",
+	"/auth/refresh",
+	"/api/v1/auth/login",
+	"/api/v1/auth/logout",
+	"/api/v1/auth/refresh",
+}
+
+// ReadOnly rejects every request that could change data while read-only
+// mode is on
+func ReadOnly(db *gorm.DB) echo.MiddlewareFunc {
+	var (
+		mu        sync.Mutex
+		checkedAt time.Time
+		enabled   bool
+		message   string
+	)
+	state := func() (bool, string) {
+		mu.Lock()
+		defer mu.Unlock()
+		if time.Since(checkedAt) >= readOnlyCheckInterval {
+			enabled, message = models.GetReadOnlyMode(db)
+			checkedAt = time.Now()
+		}
+		return enabled, message
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			path := strings.TrimSuffix(c.Request().URL.Path, "/")
+			for _, exempt := range readOnlyExemptPaths {
+				if path == exempt {
+					return next(c)
+				}
+			}
+
+			readOnly, reason := state()
+			if !readOnly {
+				return next(c)
+			}
+			if reason == "" {
+				reason = "The instance is in read-only mode for maintenance"
+			}
+			c.Response().Header().Set("Retry-After", "300")
+			return echo.NewHTTPError(http.StatusServiceUnavailable, reason)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package middleware
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// RequestLogger writes one structured line per request: method, URI,
+// route, status, latency, bytes written, client IP, request ID and, once
+// signed in, the user ID. Server errors are logged at the error level and
+// client errors at warn, so a higher level keeps only the problems. It
+// must run outside the request ID middleware to see the ID.
+func RequestLogger(logger *slog.Logger) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			latency := time.Since(start)
+
+			req, res := c.Request(), c.Response()
+			status := res.Status
+			if err != nil && !res.Committed {
+				status = http.StatusInternalServerError
+				if he, ok := err.(*echo.HTTPError); ok {
+					status = he.Code
+				}
+			}
+
+			level := slog.LevelInfo
+			switch {
+			case status >= 500:
+				level = slog.LevelError
+			case status >= 400:
+				level = slog.LevelWarn
+			}
+			if !logger.Enabled(req.Context(), level) {
+				return err
+			}
+
+			requestID := res.Header().Get(echo.HeaderXRequestID)
+			if requestID == "" {
+				requestID = req.Header.Get(echo.HeaderXRequestID)
+			}
+			attrs := []slog.Attr{
+				slog.String("method", req.Method),
+				slog.String("uri", req.RequestURI),
+				slog.String("route", c.Path()),
+				slog.Int("status", status),
+				slog.Duration("latency", latency),
+				slog.Int64("bytes_out", res.Size),
+				slog.String("remote_ip", c.RealIP()),
+				slog.String("request_id", requestID),
+			}
+			if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+				attrs = append(attrs, slog.String("user_id", userID.String()))
+			}
+			if err != nil {
+				attrs = append(attrs, slog.String("error", err.Error()))
+			}
+			logger.LogAttrs(context.Background(), level, "request", attrs...)
+			return err
+		}
+	}
+}
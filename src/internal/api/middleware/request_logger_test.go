@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	echomw "github.com/labstack/echo/v4/middleware"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRequestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelInfo}))
+
+	e := echo.New()
+	e.Use(RequestLogger(logger), echomw.RequestID())
+	e.GET("/gists/:id", func(c echo.Context) error { return c.String(http.StatusOK, "hello") })
+	e.GET("/fail", func(c echo.Context) error { return echo.NewHTTPError(http.StatusBadGateway, "upstream") })
+
+	lastLine := func() map[string]interface{} {
+		lines := bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n"))
+		var entry map[string]interface{}
+		require.NoError(t, json.Unmarshal(lines[len(lines)-1], &entry))
+		return entry
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/gists/abc?x=1", nil))
+	entry := lastLine()
+	assert.Equal(t, "INFO", entry["level"])
+	assert.Equal(t, "request", entry["msg"])
+	assert.Equal(t, "GET", entry["method"])
+	assert.Equal(t, "/gists/abc?x=1", entry["uri"])
+	assert.Equal(t, "/gists/:id", entry["route"])
+	assert.EqualValues(t, 200, entry["status"])
+	assert.EqualValues(t, 5, entry["bytes_out"])
+	assert.Equal(t, rec.Header().Get(echo.HeaderXRequestID), entry["request_id"])
+	assert.NotEmpty(t, entry["request_id"])
+
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/fail", nil))
+	entry = lastLine()
+	assert.Equal(t, "ERROR", entry["level"])
+	assert.EqualValues(t, 502, entry["status"])
+	assert.Contains(t, entry["error"], "upstream")
+
+	// Below the configured level nothing is written
+	buf.Reset()
+	quiet := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelWarn}))
+	e = echo.New()
+	e.Use(RequestLogger(quiet))
+	e.GET("/", func(c echo.Context) error { return c.NoContent(http.StatusOK) })
+	e.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+	assert.Empty(t, buf.String())
+}
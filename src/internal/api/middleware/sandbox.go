@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// SandboxHeader marks an API request as a sandbox request. Gists it
+// creates are ephemeral and purged after api.sandbox.ttl.
+const SandboxHeader = "X-CasGists-Sandbox"
+
+// sandboxRoutes are the write endpoints open to sandbox requests. Routes
+// addressing a gist by :id only accept sandbox gists.
+var sandboxRoutes = map[string]bool{
+	http.MethodPost + " /api/v1/gists":                  true,
+	http.MethodPost + " /api/v1/gists/suggest-metadata": true,
+	http.MethodPut + " /api/v1/gists/:id":               true,
+	http.MethodPatch + " /api/v1/gists/:id":             true,
+	http.MethodDelete + " /api/v1/gists/:id":            true,
+}
+
+// Sandbox lets integration developers test against a production instance
+// without touching real data. Requests sent with X-CasGists-Sandbox: true
+// may read anything, but may only create gists, which are marked
+// ephemeral, and change gists created the same way. Other writes are
+// rejected rather than silently applied to real data.
+func Sandbox(db *gorm.DB, config *config.Config) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			requested, _ := strconv.ParseBool(c.Request().Header.Get(SandboxHeader))
+			if !requested {
+				return next(c)
+			}
+			if !config.GetBool("api.sandbox.enabled") {
+				return echo.NewHTTPError(http.StatusBadRequest, "Sandbox mode is not enabled on this instance")
+			}
+			c.Set("sandbox", true)
+			c.Response().Header().Set(SandboxHeader, "true")
+
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			route := c.Request().Method + " " + c.Path()
+			if !sandboxRoutes[route] {
+				return echo.NewHTTPError(http.StatusForbidden, route+" is not available in sandbox mode")
+			}
+			if id := c.Param("id"); id != "" {
+				var count int64
+				if err := db.Model(&models.Gist{}).Where("id = ? AND ephemeral = ?", id, true).Count(&count).Error; err != nil {
+					return echo.NewHTTPError(http.StatusInternalServerError, "Failed to check gist")
+				}
+				if count == 0 {
+					return echo.NewHTTPError(http.StatusForbidden, "Sandbox requests can only change sandbox gists")
+				}
+			}
+			return next(c)
+		}
+	}
+}
+
+// IsSandbox reports whether the request is a sandbox request
+func IsSandbox(c echo.Context) bool {
+	sandbox, _ := c.Get("sandbox").(bool)
+	return sandbox
+}
@@ -0,0 +1,73 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestSandbox(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.Gist{}))
+	owner := uuid.New()
+	sandboxGist := &models.Gist{Title: "sandbox", UserID: &owner, GitRepoPath: "sandbox", Ephemeral: true}
+	realGist := &models.Gist{Title: "real", UserID: &owner, GitRepoPath: "real"}
+	require.NoError(t, db.Create(sandboxGist).Error)
+	require.NoError(t, db.Create(realGist).Error)
+
+	config := config.New()
+	config.Set("api.sandbox.enabled", true)
+
+	e := echo.New()
+	e.Use(Sandbox(db, config))
+	handler := func(c echo.Context) error {
+		return c.JSON(http.StatusOK, map[string]bool{"sandbox": IsSandbox(c)})
+	}
+	e.GET("/api/v1/gists", handler)
+	e.POST("/api/v1/gists", handler)
+	e.PATCH("/api/v1/gists/:id", handler)
+	e.POST("/api/v1/webhooks", handler)
+
+	do := func(method, path string, sandbox bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		if sandbox {
+			req.Header.Set(SandboxHeader, "true")
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := do(http.MethodPost, "/api/v1/gists", false)
+	assert.JSONEq(t, `{"sandbox":false}`, rec.Body.String())
+	assert.Empty(t, rec.Header().Get(SandboxHeader))
+
+	rec = do(http.MethodPost, "/api/v1/gists", true)
+	assert.JSONEq(t, `{"sandbox":true}`, rec.Body.String())
+	assert.Equal(t, "true", rec.Header().Get(SandboxHeader))
+	assert.Equal(t, http.StatusOK, do(http.MethodGet, "/api/v1/gists", true).Code)
+
+	// Only sandbox gists can be changed
+	assert.Equal(t, http.StatusOK, do(http.MethodPatch, "/api/v1/gists/"+sandboxGist.ID.String(), true).Code)
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPatch, "/api/v1/gists/"+realGist.ID.String(), true).Code)
+
+	// Writes that can't be made ephemeral are refused
+	assert.Equal(t, http.StatusForbidden, do(http.MethodPost, "/api/v1/webhooks", true).Code)
+	assert.Equal(t, http.StatusOK, do(http.MethodPost, "/api/v1/webhooks", false).Code)
+
+	config.Set("api.sandbox.enabled", false)
+	assert.Equal(t, http.StatusBadRequest, do(http.MethodPost, "/api/v1/gists", true).Code)
+}
@@ -5,11 +5,12 @@ import (
 	"strings"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Security returns security headers middleware
-func Security(cfg *viper.Viper) echo.MiddlewareFunc {
+func Security(cfg *config.Config) echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
 			res := c.Response()
@@ -26,7 +27,7 @@ func Security(cfg *viper.Viper) echo.MiddlewareFunc {
 			res.Header().Set("Permissions-Policy", "camera=(), microphone=(), geolocation=()")
 
 			// HSTS for HTTPS
-			if c.Request().TLS != nil || c.Request().Header.Get("X-Forwarded-Proto") == "https" {
+			if c.Scheme() == "https" {
 				res.Header().Set("Strict-Transport-Security", 
 					"max-age=31536000; includeSubDomains")
 			}
@@ -36,7 +37,7 @@ func Security(cfg *viper.Viper) echo.MiddlewareFunc {
 	}
 }
 
-func buildCSP(cfg *viper.Viper) string {
+func buildCSP(cfg *config.Config) string {
 	policies := map[string]string{
 		"default-src":   "'self'",
 		"script-src":    "'self' 'unsafe-inline'",
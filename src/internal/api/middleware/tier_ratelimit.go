@@ -0,0 +1,97 @@
+package middleware
+
+import (
+	"log/slog"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/ratelimit"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// TierResolver returns the quota tier of a user
+type TierResolver interface {
+	TierForUserID(userID uuid.UUID) (services.QuotaTier, error)
+}
+
+// TierRateLimiter limits authenticated API requests per user, at the rate
+// of the user's quota tier. It must run after authentication; anonymous
+// requests are limited by the public read tier instead.
+type TierRateLimiter struct {
+	tiers TierResolver
+	store ratelimit.Store
+}
+
+// RateLimitState is the caller's rate limit after the current request,
+// stored in the context as "rate_limit" for handlers that report it.
+// Limit is 0 for unlimited tiers.
+type RateLimitState struct {
+	Tier      string     `json:"tier"`
+	Limit     int        `json:"limit"`
+	Remaining int        `json:"remaining"`
+	Reset     *time.Time `json:"reset,omitempty"`
+}
+
+// NewTierRateLimiter creates a rate limiter for the tiers tiers resolves,
+// counting requests in store, or in memory when store is nil
+func NewTierRateLimiter(tiers TierResolver, store ratelimit.Store) *TierRateLimiter {
+	if store == nil {
+		store = ratelimit.NewMemoryStore()
+	}
+	return &TierRateLimiter{tiers: tiers, store: store}
+}
+
+// Middleware returns the rate limiting middleware. Every authenticated
+// response carries the tier name and, for limited tiers, RateLimit-* and
+// X-RateLimit-* headers.
+func (l *TierRateLimiter) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(uuid.UUID)
+			if !ok {
+				return next(c)
+			}
+			tier, err := l.tiers.TierForUserID(userID)
+			if err != nil {
+				// Authentication has already been checked; don't fail the
+				// request because the tier couldn't be looked up
+				return next(c)
+			}
+
+			header := c.Response().Header()
+			header.Set("X-RateLimit-Tier", tier.Name)
+			if tier.RateLimit <= 0 || tier.RateWindow() <= 0 {
+				c.Set("rate_limit", RateLimitState{Tier: tier.Name})
+				return next(c)
+			}
+
+			now := time.Now()
+			result, err := l.store.Take(c.Request().Context(), "tier:"+userID.String(), tier.RateLimit, tier.RateWindow())
+			if err != nil {
+				slog.Warn("Rate limit of the tier not checked", "tier", tier.Name, "error", err)
+				c.Set("rate_limit", RateLimitState{Tier: tier.Name})
+				return next(c)
+			}
+			c.Set("rate_limit", RateLimitState{Tier: tier.Name, Limit: tier.RateLimit, Remaining: result.Remaining, Reset: &result.Reset})
+			setRateLimitHeaders(header, result, tier.RateWindow(), now)
+			if !result.Allowed {
+				header.Set("Retry-After", strconv.Itoa(int(result.RetryAfter(now).Seconds())))
+				return echo.NewHTTPError(http.StatusTooManyRequests, "rate limit of the "+tier.Name+" tier exceeded")
+			}
+			return next(c)
+		}
+	}
+}
+
+// setRateLimitHeaders sets the standard RateLimit-* headers and the
+// X-RateLimit-* headers clients already read, whose reset is a Unix time
+func setRateLimitHeaders(header http.Header, result ratelimit.Result, window time.Duration, now time.Time) {
+	ratelimit.SetHeaders(header, result, window, now)
+	header.Set("X-RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("X-RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("X-RateLimit-Reset", strconv.FormatInt(result.Reset.Unix(), 10))
+}
@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+type staticTiers map[uuid.UUID]services.QuotaTier
+
+func (s staticTiers) TierForUserID(userID uuid.UUID) (services.QuotaTier, error) {
+	return s[userID], nil
+}
+
+func TestTierRateLimiter(t *testing.T) {
+	free, admin := uuid.New(), uuid.New()
+	limiter := NewTierRateLimiter(staticTiers{
+		free:  {Name: services.QuotaTierFree, RateLimit: 2, RateWindowSeconds: 60},
+		admin: {Name: services.QuotaTierAdmin, RateWindowSeconds: 60},
+	}, nil)
+
+	e := echo.New()
+	var state RateLimitState
+	e.GET("/user", func(c echo.Context) error {
+		state, _ = c.Get("rate_limit").(RateLimitState)
+		return c.NoContent(http.StatusOK)
+	}, func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if id, err := uuid.Parse(c.Request().Header.Get("X-User")); err == nil {
+				c.Set("user_id", id)
+			}
+			return next(c)
+		}
+	}, limiter.Middleware())
+
+	get := func(userID string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/user", nil)
+		req.Header.Set("X-User", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(free.String())
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "free", rec.Header().Get("X-RateLimit-Tier"))
+	assert.Equal(t, "2", rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "1", rec.Header().Get("X-RateLimit-Remaining"))
+	assert.Equal(t, RateLimitState{Tier: "free", Limit: 2, Remaining: 1, Reset: state.Reset}, state)
+	assert.NotNil(t, state.Reset)
+
+	assert.Equal(t, http.StatusOK, get(free.String()).Code)
+	rec = get(free.String())
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.NotEmpty(t, rec.Header().Get("Retry-After"))
+
+	// Unlimited tiers and anonymous requests pass without limit headers
+	for i := 0; i < 5; i++ {
+		rec = get(admin.String())
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	assert.Equal(t, "admin", rec.Header().Get("X-RateLimit-Tier"))
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, RateLimitState{Tier: "admin"}, state)
+
+	rec = get("")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Empty(t, rec.Header().Get("X-RateLimit-Tier"))
+}
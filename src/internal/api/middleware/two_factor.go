@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+// TwoFactorEnrollment tells whether two-factor authentication is required
+// and whether a user has enrolled a second factor
+type TwoFactorEnrollment interface {
+	Required() bool
+	Enrolled(userID uuid.UUID) bool
+}
+
+// twoFactorExempt are the routes a user without a second factor can still
+// reach, to enroll one or to sign out
+var twoFactorExempt = map[string]bool{
+	"/api/v1/user":                          true,
+	"/api/v1/auth/logout":                   true,
+	"/api/v1/auth/introspect":               true,
+	"/api/v1/auth/webauthn/register/begin":  true,
+	"/api/v1/auth/webauthn/register":        true,
+	"/api/v1/auth/webauthn/credentials":     true,
+	"/api/v1/auth/webauthn/credentials/:id": true,
+}
+
+// RequireTwoFactor refuses authenticated requests from users who haven't
+// enrolled an authenticator app or a security key while two-factor
+// authentication is required. It must run after authentication; the
+// authenticator app is enrolled through /auth/2fa/setup, outside the API.
+func RequireTwoFactor(enrollment TwoFactorEnrollment) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			userID, ok := c.Get("user_id").(uuid.UUID)
+			if !ok || twoFactorExempt[c.Path()] || !enrollment.Required() {
+				return next(c)
+			}
+			if !enrollment.Enrolled(userID) {
+				return echo.NewHTTPError(http.StatusForbidden,
+					"two-factor authentication is required: set it up at /auth/2fa/setup or register a security key")
+			}
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+type staticEnrollment struct {
+	required bool
+	enrolled map[uuid.UUID]bool
+}
+
+func (s *staticEnrollment) Required() bool                 { return s.required }
+func (s *staticEnrollment) Enrolled(userID uuid.UUID) bool { return s.enrolled[userID] }
+
+func TestRequireTwoFactor(t *testing.T) {
+	enrolled, missing := uuid.New(), uuid.New()
+	enrollment := &staticEnrollment{required: true, enrolled: map[uuid.UUID]bool{enrolled: true}}
+
+	e := echo.New()
+	authenticate := func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if id, err := uuid.Parse(c.Request().Header.Get("X-User")); err == nil {
+				c.Set("user_id", id)
+			}
+			return next(c)
+		}
+	}
+	ok := func(c echo.Context) error { return c.NoContent(http.StatusOK) }
+	e.GET("/api/v1/gists", ok, authenticate, RequireTwoFactor(enrollment))
+	e.POST("/api/v1/auth/webauthn/register/begin", ok, authenticate, RequireTwoFactor(enrollment))
+
+	request := func(method, path, userID string) int {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set("X-User", userID)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/v1/gists", enrolled.String()))
+	assert.Equal(t, http.StatusForbidden, request(http.MethodGet, "/api/v1/gists", missing.String()))
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/v1/gists", ""), "anonymous requests are left alone")
+
+	// Enrolling a security key stays open
+	assert.Equal(t, http.StatusOK, request(http.MethodPost, "/api/v1/auth/webauthn/register/begin", missing.String()))
+
+	enrollment.required = false
+	assert.Equal(t, http.StatusOK, request(http.MethodGet, "/api/v1/gists", missing.String()))
+}
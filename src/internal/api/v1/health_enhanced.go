@@ -12,6 +12,7 @@ import (
 	"github.com/casapps/casgists/src/internal/cache"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/casapps/casgists/src/internal/git"
+	"github.com/casapps/casgists/src/internal/metrics"
 	"github.com/casapps/casgists/src/internal/search"
 )
 
@@ -384,6 +385,9 @@ func (h *HealthService) getSearchBackend() string {
 }
 
 func (h *HealthService) getCacheBackend() string {
+	if backend, ok := h.cache.(interface{ Backend() string }); ok {
+		return backend.Backend()
+	}
 	backend, _ := models.GetConfigValue(h.db, "cache_type")
 	if backend == "" {
 		return "memory"
@@ -391,14 +395,14 @@ func (h *HealthService) getCacheBackend() string {
 	return backend
 }
 
-func (h *HealthService) getRequestsPerMinute() int {
-	// TODO: Implement actual request tracking
-	return 45 // Placeholder
+func (h *HealthService) getRequestsPerMinute() int64 {
+	count, _ := metrics.HTTP.Recent.Stats()
+	return count
 }
 
 func (h *HealthService) getAverageResponseTime() string {
-	// TODO: Implement actual response time tracking
-	return "120ms" // Placeholder
+	_, average := metrics.HTTP.Recent.Stats()
+	return fmt.Sprintf("%dms", average.Milliseconds())
 }
 
 // EnhancedHealthHandler handles the enhanced health check endpoint
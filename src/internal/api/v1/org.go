@@ -4,14 +4,14 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 	
 	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // registerOrgRoutes registers all organization routes
-func registerOrgRoutes(g *echo.Group, db *gorm.DB, cfg *viper.Viper, cacheManager *cache.CacheManager) {
+func registerOrgRoutes(g *echo.Group, db *gorm.DB, cfg *config.Config, cacheManager *cache.CacheManager) {
 	// TODO: Implement organization endpoints
 	g.GET("", func(c echo.Context) error {
 		return c.JSON(http.StatusOK, map[string]interface{}{
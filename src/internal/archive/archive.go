@@ -0,0 +1,157 @@
+// Package archive writes a whole instance to a portable archive and reads
+// it back, for long-term archival and for moving between major CasGists
+// versions. Unlike a backup, which restores the same version onto the same
+// kind of database, an archive stores every table as JSON lines keyed by
+// column name, so it can be imported into a newer schema or another
+// database.
+//
+// An archive is a gzipped tar file:
+//
+//	manifest.json                 format, version and contents, always first
+//	tables/<table>.jsonl          one JSON object per row
+//	repositories/<id>/<path>      git repositories, file by file
+//	uploads/<path>                files under storage.path
+//
+// See docs/archive-format.md for the full description.
+package archive
+
+import (
+	"encoding/base64"
+	"errors"
+	"path"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// Format identifies CasGists archives in the manifest
+const Format = "casgists-archive"
+
+// FormatVersion is the archive format written by Export. Import reads this
+// version and older ones.
+const FormatVersion = 1
+
+// Locations inside an archive
+const (
+	manifestName    = "manifest.json"
+	tablesDir       = "tables/"
+	repositoriesDir = "repositories/"
+	uploadsDir      = "uploads/"
+)
+
+var (
+	ErrNotArchive   = errors.New("not a CasGists archive")
+	ErrNewerFormat  = errors.New("archive format is newer than this version of CasGists supports")
+	ErrNotEmpty     = errors.New("instance already has users, import into a fresh instance or overwrite it")
+	ErrInvalidEntry = errors.New("invalid archive entry")
+)
+
+// Manifest describes an archive
+type Manifest struct {
+	Format          string      `json:"format"`
+	Version         int         `json:"version"`
+	CasGistsVersion string      `json:"casgists_version,omitempty"`
+	SchemaVersion   int         `json:"schema_version"` // Last database migration applied
+	Database        string      `json:"database"`       // Database type exported from
+	CreatedAt       time.Time   `json:"created_at"`
+	Source          string      `json:"source,omitempty"`
+	Tables          []TableInfo `json:"tables"`
+	Repositories    int         `json:"repositories"`
+	Uploads         int         `json:"uploads"`
+}
+
+// TableInfo describes an exported table
+type TableInfo struct {
+	Name    string   `json:"name"`
+	File    string   `json:"file"`
+	Rows    int      `json:"rows"`
+	Columns []string `json:"columns"`
+}
+
+// tableOrder puts tables others refer to first, so rows can be inserted
+// where foreign keys are enforced. Other tables follow by name.
+var tableOrder = []string{"users", "organizations", "teams", "gists", "gist_files"}
+
+// skipTable reports whether a table is left out of archives: migration
+// bookkeeping, sign-in state that is useless elsewhere, and search indexes
+// that are rebuilt from the gists
+func skipTable(name string) bool {
+	switch name {
+	case "schema_migrations", "sessions", "device_authorizations":
+		return true
+	}
+	return strings.HasPrefix(name, "sqlite_") || strings.Contains(name, "_fts")
+}
+
+func tableRank(name string) int {
+	for i, table := range tableOrder {
+		if name == table {
+			return i
+		}
+	}
+	return len(tableOrder)
+}
+
+// columnKind says how a column's values are written as JSON
+type columnKind int
+
+const (
+	kindOther columnKind = iota
+	kindBool
+	kindTime
+)
+
+func kindOf(databaseType string) columnKind {
+	databaseType = strings.ToUpper(databaseType)
+	switch {
+	case strings.Contains(databaseType, "BOOL"):
+		return kindBool
+	case strings.Contains(databaseType, "TIME"), strings.Contains(databaseType, "DATE"):
+		return kindTime
+	}
+	return kindOther
+}
+
+// binaryValue holds bytes that aren't UTF-8 text
+type binaryValue struct {
+	Base64 string `json:"base64"`
+}
+
+// encodeValue turns a scanned database value into its JSON form: booleans
+// as booleans whatever the database stores, times as RFC 3339 in UTC, text
+// as strings and other bytes as {"base64": "..."}
+func encodeValue(value interface{}, kind columnKind) interface{} {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		if kind == kindOther && !utf8.Valid(v) {
+			return binaryValue{Base64: base64.StdEncoding.EncodeToString(v)}
+		}
+		return encodeValue(string(v), kind)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	case int64:
+		if kind == kindBool {
+			return v != 0
+		}
+	case string:
+		if kind == kindBool {
+			return v == "1" || strings.EqualFold(v, "true") || strings.EqualFold(v, "t")
+		}
+	}
+	return value
+}
+
+// safePath cleans an archive entry path and rejects ones that would leave
+// the directory they are extracted to
+func safePath(name string) (string, error) {
+	if name == "" || strings.Contains(name, "\\") {
+		return "", ErrInvalidEntry
+	}
+	cleaned := path.Clean("/" + name)
+	if cleaned == "/" || cleaned != "/"+strings.TrimSuffix(name, "/") {
+		return "", ErrInvalidEntry
+	}
+	return strings.TrimPrefix(cleaned, "/"), nil
+}
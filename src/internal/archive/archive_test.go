@@ -0,0 +1,164 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-billy/v5/util"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistFile{}, &models.SystemConfig{}))
+	require.NoError(t, db.Exec("CREATE TABLE schema_migrations (version INTEGER PRIMARY KEY)").Error)
+	require.NoError(t, db.Exec("INSERT INTO schema_migrations (version) VALUES (24), (25)").Error)
+	return db
+}
+
+func TestExportImport(t *testing.T) {
+	source := setupTestDB(t)
+	user := &models.User{Username: "alice", Email: "alice@example.com", IsAdmin: true}
+	require.NoError(t, source.Create(user).Error)
+	gist := &models.Gist{Title: "Hello", UserID: &user.ID, Visibility: models.VisibilityPublic}
+	require.NoError(t, source.Create(gist).Error)
+	require.NoError(t, source.Create(&models.GistFile{GistID: gist.ID, Filename: "hello.go", Content: "package main\n"}).Error)
+	require.NoError(t, source.Create(&models.SystemConfig{Key: "site_name", Value: "Archived"}).Error)
+
+	repos := git.NewLocalDriver(t.TempDir())
+	require.NoError(t, repos.Create(gist.ID.String()))
+	fs, err := repos.Filesystem(gist.ID.String())
+	require.NoError(t, err)
+	require.NoError(t, util.WriteFile(fs, "refs/heads/main", []byte("abc123\n"), 0644))
+
+	uploads := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(uploads, "avatars"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(uploads, "avatars", "alice.png"), []byte{0x89, 'P', 'N', 'G'}, 0644))
+
+	var buf bytes.Buffer
+	manifest, err := Export(source, &buf, ExportOptions{Version: "1.2.3", Repositories: repos, UploadsDir: uploads})
+	require.NoError(t, err)
+	assert.Equal(t, 25, manifest.SchemaVersion)
+	assert.Equal(t, 1, manifest.Repositories)
+	assert.Equal(t, 1, manifest.Uploads)
+	require.NotEmpty(t, manifest.Tables)
+	assert.Equal(t, "users", manifest.Tables[0].Name)
+	for _, table := range manifest.Tables {
+		assert.NotEqual(t, "schema_migrations", table.Name)
+	}
+
+	read, err := ReadManifest(bytes.NewReader(buf.Bytes()))
+	require.NoError(t, err)
+	assert.Equal(t, manifest.Tables, read.Tables)
+
+	t.Run("DryRun", func(t *testing.T) {
+		target := setupTestDB(t)
+		require.NoError(t, target.Migrator().DropColumn(&models.User{}, "Bio"))
+
+		result, err := Import(target, bytes.NewReader(buf.Bytes()), ImportOptions{DryRun: true})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Tables["users"])
+		assert.Equal(t, []string{"bio"}, result.DroppedColumns["users"])
+
+		var count int64
+		target.Model(&models.User{}).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("FreshInstance", func(t *testing.T) {
+		target := setupTestDB(t)
+		require.NoError(t, target.Create(&models.SystemConfig{Key: "setup_completed", Value: "false"}).Error)
+		targetRepos := git.NewLocalDriver(t.TempDir())
+		targetUploads := t.TempDir()
+
+		result, err := Import(target, bytes.NewReader(buf.Bytes()), ImportOptions{Repositories: targetRepos, UploadsDir: targetUploads})
+		require.NoError(t, err)
+		assert.Equal(t, 1, result.Tables["gists"])
+		assert.Equal(t, 1, result.Repositories)
+		assert.Equal(t, 1, result.Uploads)
+
+		var imported models.User
+		require.NoError(t, target.First(&imported, "id = ?", user.ID).Error)
+		assert.Equal(t, "alice", imported.Username)
+		assert.True(t, imported.IsAdmin)
+		assert.WithinDuration(t, user.CreatedAt, imported.CreatedAt, 0)
+
+		var file models.GistFile
+		require.NoError(t, target.First(&file, "gist_id = ?", gist.ID).Error)
+		assert.Equal(t, "package main\n", file.Content)
+
+		// Archived tables replace the target's rows
+		var configs []models.SystemConfig
+		require.NoError(t, target.Find(&configs).Error)
+		require.Len(t, configs, 1)
+		assert.Equal(t, "site_name", configs[0].Key)
+
+		fs, err := targetRepos.Filesystem(gist.ID.String())
+		require.NoError(t, err)
+		ref, err := util.ReadFile(fs, "refs/heads/main")
+		require.NoError(t, err)
+		assert.Equal(t, "abc123\n", string(ref))
+
+		avatar, err := os.ReadFile(filepath.Join(targetUploads, "avatars", "alice.png"))
+		require.NoError(t, err)
+		assert.Equal(t, []byte{0x89, 'P', 'N', 'G'}, avatar)
+
+		// A second import would replace what's there now
+		_, err = Import(target, bytes.NewReader(buf.Bytes()), ImportOptions{})
+		assert.ErrorIs(t, err, ErrNotEmpty)
+		_, err = Import(target, bytes.NewReader(buf.Bytes()), ImportOptions{Overwrite: true})
+		assert.NoError(t, err)
+	})
+
+	t.Run("ScanUploads", func(t *testing.T) {
+		targetUploads := t.TempDir()
+		var scanned []string
+		result, err := Import(setupTestDB(t), bytes.NewReader(buf.Bytes()), ImportOptions{
+			UploadsDir: targetUploads,
+			ScanUpload: func(name, path string) (bool, error) {
+				scanned = append(scanned, name)
+				return true, os.Remove(path)
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"uploads/avatars/alice.png"}, scanned)
+		assert.Equal(t, scanned, result.Quarantined)
+		assert.Zero(t, result.Uploads)
+		assert.NoFileExists(t, filepath.Join(targetUploads, "avatars", "alice.png"))
+	})
+
+	t.Run("Invalid", func(t *testing.T) {
+		_, err := Import(setupTestDB(t), bytes.NewReader([]byte("not an archive")), ImportOptions{})
+		assert.ErrorIs(t, err, ErrNotArchive)
+
+		newer := bytes.Buffer{}
+		gz := gzip.NewWriter(&newer)
+		tw := tar.NewWriter(gz)
+		body := []byte(`{"format": "casgists-archive", "version": 99}`)
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: manifestName, Size: int64(len(body)), Mode: 0644}))
+		_, err = tw.Write(body)
+		require.NoError(t, err)
+		require.NoError(t, tw.Close())
+		require.NoError(t, gz.Close())
+		_, err = ReadManifest(&newer)
+		assert.ErrorIs(t, err, ErrNewerFormat)
+	})
+
+	for _, name := range []string{"../etc/passwd", "uploads/../../x", "/abs", `a\b`} {
+		_, err := safePath(name)
+		assert.ErrorIs(t, err, ErrInvalidEntry, name)
+	}
+}
@@ -0,0 +1,267 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/util"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+// ExportOptions controls what goes into an archive
+type ExportOptions struct {
+	Version      string            // CasGists version writing the archive
+	Source       string            // Instance URL, for information only
+	Repositories git.StorageDriver // Nil leaves repositories out
+	UploadsDir   string            // Empty leaves uploads out
+}
+
+// Export writes the instance to w as an archive. Tables are staged in a
+// temporary directory first so the manifest, which lists their row
+// counts, can come first in the archive.
+func Export(db *gorm.DB, w io.Writer, opts ExportOptions) (*Manifest, error) {
+	staging, err := os.MkdirTemp("", "casgists-export-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	manifest := &Manifest{
+		Format:          Format,
+		Version:         FormatVersion,
+		CasGistsVersion: opts.Version,
+		SchemaVersion:   schemaVersion(db),
+		Database:        db.Dialector.Name(),
+		CreatedAt:       time.Now().UTC().Truncate(time.Second),
+		Source:          opts.Source,
+	}
+
+	tables, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tables: %w", err)
+	}
+	sort.Slice(tables, func(i, j int) bool {
+		ri, rj := tableRank(tables[i]), tableRank(tables[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return tables[i] < tables[j]
+	})
+	for _, table := range tables {
+		if skipTable(table) {
+			continue
+		}
+		info, err := exportTable(db, table, filepath.Join(staging, table+".jsonl"))
+		if err != nil {
+			return nil, fmt.Errorf("failed to export table %s: %w", table, err)
+		}
+		manifest.Tables = append(manifest.Tables, *info)
+	}
+
+	var repoIDs []string
+	if opts.Repositories != nil {
+		if repoIDs, err = opts.Repositories.List(); err != nil {
+			return nil, fmt.Errorf("failed to list repositories: %w", err)
+		}
+		sort.Strings(repoIDs)
+		manifest.Repositories = len(repoIDs)
+	}
+	var uploads []string
+	if opts.UploadsDir != "" {
+		if uploads, err = listFiles(opts.UploadsDir); err != nil {
+			return nil, fmt.Errorf("failed to list uploads: %w", err)
+		}
+		manifest.Uploads = len(uploads)
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	encoded, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	if err := writeEntry(tw, manifestName, int64(len(encoded)), 0644, manifest.CreatedAt, bytes.NewReader(encoded)); err != nil {
+		return nil, err
+	}
+
+	for _, table := range manifest.Tables {
+		if err := writeFile(tw, tablesDir+table.File, filepath.Join(staging, table.File), manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range repoIDs {
+		fs, err := opts.Repositories.Filesystem(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repository %s: %w", id, err)
+		}
+		if err := writeRepository(tw, repositoriesDir+id+"/", fs); err != nil {
+			return nil, fmt.Errorf("failed to export repository %s: %w", id, err)
+		}
+	}
+	for _, name := range uploads {
+		if err := writeFile(tw, uploadsDir+filepath.ToSlash(name), filepath.Join(opts.UploadsDir, name), manifest.CreatedAt); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+// exportTable writes every row of a table to path as JSON lines
+func exportTable(db *gorm.DB, table, path string) (*TableInfo, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	out := bufio.NewWriter(file)
+
+	rows, err := db.Table(table).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columnTypes, err := rows.ColumnTypes()
+	if err != nil {
+		return nil, err
+	}
+	info := &TableInfo{Name: table, File: table + ".jsonl"}
+	kinds := make([]columnKind, len(columnTypes))
+	for i, column := range columnTypes {
+		info.Columns = append(info.Columns, column.Name())
+		kinds[i] = kindOf(column.DatabaseTypeName())
+	}
+
+	values := make([]interface{}, len(columnTypes))
+	pointers := make([]interface{}, len(columnTypes))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	encoder := json.NewEncoder(out)
+	encoder.SetEscapeHTML(false)
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make(map[string]interface{}, len(values))
+		for i, value := range values {
+			row[info.Columns[i]] = encodeValue(value, kinds[i])
+		}
+		if err := encoder.Encode(row); err != nil {
+			return nil, err
+		}
+		info.Rows++
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if err := out.Flush(); err != nil {
+		return nil, err
+	}
+	return info, file.Close()
+}
+
+// schemaVersion returns the last migration applied to the database
+func schemaVersion(db *gorm.DB) int {
+	var version int
+	db.Raw("SELECT COALESCE(MAX(version), 0) FROM schema_migrations").Scan(&version)
+	return version
+}
+
+// writeRepository adds every regular file of a repository under prefix
+func writeRepository(tw *tar.Writer, prefix string, fs billy.Filesystem) error {
+	return util.Walk(fs, "/", func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		file, err := fs.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return writeEntry(tw, prefix+strings.TrimLeft(filepath.ToSlash(name), "/"), info.Size(), int64(info.Mode().Perm()), info.ModTime(), file)
+	})
+}
+
+// writeFile adds a file from disk
+func writeFile(tw *tar.Writer, name, path string, fallbackTime time.Time) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	modTime := info.ModTime()
+	if modTime.IsZero() {
+		modTime = fallbackTime
+	}
+	return writeEntry(tw, name, info.Size(), int64(info.Mode().Perm()), modTime, file)
+}
+
+func writeEntry(tw *tar.Writer, name string, size, mode int64, modTime time.Time, r io.Reader) error {
+	header := &tar.Header{
+		Name:     name,
+		Size:     size,
+		Mode:     mode,
+		ModTime:  modTime,
+		Typeflag: tar.TypeReg,
+		Format:   tar.FormatPAX,
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.CopyN(tw, r, size); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// listFiles returns the regular files under dir, relative to it. A
+// missing directory has no files.
+func listFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if os.IsNotExist(err) && path == dir {
+			return filepath.SkipDir
+		}
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() {
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			files = append(files, rel)
+		}
+		return nil
+	})
+	return files, err
+}
@@ -0,0 +1,462 @@
+package archive
+
+import (
+	"archive/tar"
+	"bufio"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+// importBatchSize is how many rows are inserted per statement
+const importBatchSize = 100
+
+// ImportOptions controls how an archive is applied
+type ImportOptions struct {
+	Repositories git.StorageDriver // Nil skips the archive's repositories
+	UploadsDir   string            // Empty skips the archive's uploads
+	Overwrite    bool              // Replace the data of an instance that has users
+	DryRun       bool              // Report what would be imported without changing anything
+	// ScanUpload checks each upload once it is written to path. An upload
+	// the check quarantines is left out of the import.
+	ScanUpload func(name, path string) (quarantined bool, err error)
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	Manifest *Manifest
+	// Tables counts the rows imported per table; for a dry run, the rows
+	// the archive holds for tables this instance has
+	Tables map[string]int
+	// SkippedTables are archived tables this instance doesn't have
+	SkippedTables []string
+	// DroppedColumns are archived columns this instance doesn't have,
+	// by table. Their values are not imported.
+	DroppedColumns map[string][]string
+	Repositories   int
+	Uploads        int
+	// Quarantined are the uploads ScanUpload flagged, by archive path
+	Quarantined []string
+}
+
+// ReadManifest reads the manifest at the start of an archive
+func ReadManifest(r io.Reader) (*Manifest, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotArchive, err)
+	}
+	defer gz.Close()
+	return readManifest(tar.NewReader(gz))
+}
+
+func readManifest(tr *tar.Reader) (*Manifest, error) {
+	header, err := tr.Next()
+	if err != nil || header.Name != manifestName {
+		return nil, fmt.Errorf("%w: %s must come first", ErrNotArchive, manifestName)
+	}
+	var manifest Manifest
+	if err := json.NewDecoder(tr).Decode(&manifest); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotArchive, err)
+	}
+	if manifest.Format != Format {
+		return nil, fmt.Errorf("%w: format is %q", ErrNotArchive, manifest.Format)
+	}
+	if manifest.Version > FormatVersion {
+		return nil, fmt.Errorf("%w (version %d, supported up to %d)", ErrNewerFormat, manifest.Version, FormatVersion)
+	}
+	return &manifest, nil
+}
+
+// Import loads an archive into the instance. Every archived table this
+// instance has is emptied and refilled; columns it doesn't have are
+// dropped, and columns the archive doesn't have get their defaults.
+// Repositories in the archive replace those with the same ID.
+func Import(db *gorm.DB, r io.Reader, opts ImportOptions) (*ImportResult, error) {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrNotArchive, err)
+	}
+	defer gz.Close()
+	tr := tar.NewReader(gz)
+
+	manifest, err := readManifest(tr)
+	if err != nil {
+		return nil, err
+	}
+	plan, result, err := planImport(db, manifest)
+	if err != nil {
+		return nil, err
+	}
+	if opts.DryRun {
+		return result, nil
+	}
+
+	if !opts.Overwrite {
+		var users int64
+		if err := db.Model(&models.User{}).Count(&users).Error; err != nil {
+			return nil, err
+		}
+		if users > 0 {
+			return nil, ErrNotEmpty
+		}
+	}
+
+	staging, err := os.MkdirTemp("", "casgists-import-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staging)
+
+	result.Tables = map[string]int{}
+	result.Repositories, result.Uploads = 0, 0
+	tablesDone := false
+	importTables := func() error {
+		tablesDone = true
+		return loadTables(db, plan, staging, result)
+	}
+	preparedRepos := map[string]billy.Filesystem{}
+
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to read archive: %w", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		name, err := safePath(header.Name)
+		if err != nil {
+			return result, fmt.Errorf("%w: %s", err, header.Name)
+		}
+
+		// Tables come first; stage them until the first file of another kind
+		if strings.HasPrefix(name, tablesDir) {
+			if tablesDone {
+				return result, fmt.Errorf("%w: %s after repositories or uploads", ErrInvalidEntry, name)
+			}
+			if err := stageFile(tr, filepath.Join(staging, path.Base(name))); err != nil {
+				return result, err
+			}
+			continue
+		}
+		if !tablesDone {
+			if err := importTables(); err != nil {
+				return result, err
+			}
+		}
+
+		switch {
+		case strings.HasPrefix(name, repositoriesDir):
+			if opts.Repositories == nil {
+				continue
+			}
+			id, file, ok := strings.Cut(strings.TrimPrefix(name, repositoriesDir), "/")
+			if !ok || file == "" {
+				return result, fmt.Errorf("%w: %s", ErrInvalidEntry, name)
+			}
+			fs, ok := preparedRepos[id]
+			if !ok {
+				if fs, err = prepareRepository(opts.Repositories, id); err != nil {
+					return result, fmt.Errorf("failed to import repository %s: %w", id, err)
+				}
+				preparedRepos[id] = fs
+				result.Repositories++
+			}
+			if err := writeRepoFile(fs, file, os.FileMode(header.Mode).Perm(), tr); err != nil {
+				return result, fmt.Errorf("failed to import repository %s: %w", id, err)
+			}
+		case strings.HasPrefix(name, uploadsDir):
+			if opts.UploadsDir == "" {
+				continue
+			}
+			target := filepath.Join(opts.UploadsDir, filepath.FromSlash(strings.TrimPrefix(name, uploadsDir)))
+			if err := stageFile(tr, target); err != nil {
+				return result, err
+			}
+			if opts.ScanUpload != nil {
+				quarantined, err := opts.ScanUpload(name, target)
+				if err != nil {
+					return result, fmt.Errorf("failed to scan upload %s: %w", name, err)
+				}
+				if quarantined {
+					result.Quarantined = append(result.Quarantined, name)
+					continue
+				}
+			}
+			result.Uploads++
+		}
+	}
+
+	if !tablesDone {
+		if err := importTables(); err != nil {
+			return result, err
+		}
+	}
+	return result, nil
+}
+
+// tablePlan is an archived table and the target's columns
+type tablePlan struct {
+	info    TableInfo
+	columns map[string]columnKind
+}
+
+// planImport matches the archive's tables and columns against this
+// instance's schema
+func planImport(db *gorm.DB, manifest *Manifest) ([]tablePlan, *ImportResult, error) {
+	result := &ImportResult{
+		Manifest:       manifest,
+		Tables:         map[string]int{},
+		DroppedColumns: map[string][]string{},
+	}
+
+	var plan []tablePlan
+	for _, table := range manifest.Tables {
+		if skipTable(table.Name) || !db.Migrator().HasTable(table.Name) {
+			result.SkippedTables = append(result.SkippedTables, table.Name)
+			continue
+		}
+		columnTypes, err := db.Migrator().ColumnTypes(table.Name)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read columns of %s: %w", table.Name, err)
+		}
+		columns := make(map[string]columnKind, len(columnTypes))
+		for _, column := range columnTypes {
+			columns[column.Name()] = kindOf(column.DatabaseTypeName())
+		}
+		for _, column := range table.Columns {
+			if _, ok := columns[column]; !ok {
+				result.DroppedColumns[table.Name] = append(result.DroppedColumns[table.Name], column)
+			}
+		}
+		plan = append(plan, tablePlan{info: table, columns: columns})
+		result.Tables[table.Name] = table.Rows
+	}
+	result.Repositories = manifest.Repositories
+	result.Uploads = manifest.Uploads
+	return plan, result, nil
+}
+
+// loadTables empties the planned tables, children first, then fills them,
+// parents first. Where foreign keys are enforced a table may only be
+// emptied or filled after another, so tables that fail are retried as long
+// as others make progress.
+func loadTables(db *gorm.DB, plan []tablePlan, staging string, result *ImportResult) error {
+	reversed := make([]tablePlan, len(plan))
+	for i, table := range plan {
+		reversed[len(plan)-1-i] = table
+	}
+	err := untilSettled(reversed, func(table tablePlan) error {
+		return db.Exec("DELETE FROM " + quoteTable(db, table.info.Name)).Error
+	})
+	if err != nil {
+		return fmt.Errorf("failed to empty table: %w", err)
+	}
+
+	err = untilSettled(plan, func(table tablePlan) error {
+		rows, err := loadTable(db, table, filepath.Join(staging, path.Base(table.info.File)))
+		if err == nil {
+			result.Tables[table.info.Name] = rows
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to import table: %w", err)
+	}
+	return nil
+}
+
+// untilSettled runs fn on each table, retrying failures until all
+// succeed or a round makes no progress
+func untilSettled(tables []tablePlan, fn func(tablePlan) error) error {
+	pending := tables
+	for len(pending) > 0 {
+		var failed []tablePlan
+		var lastErr error
+		for _, table := range pending {
+			if err := fn(table); err != nil {
+				failed = append(failed, table)
+				lastErr = fmt.Errorf("%s: %w", table.info.Name, err)
+			}
+		}
+		if len(failed) == len(pending) {
+			return lastErr
+		}
+		pending = failed
+	}
+	return nil
+}
+
+// loadTable inserts a staged table's rows in one transaction
+func loadTable(db *gorm.DB, table tablePlan, path string) (int, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	count := 0
+	err = db.Transaction(func(tx *gorm.DB) error {
+		batch := make([]map[string]interface{}, 0, importBatchSize)
+		flush := func() error {
+			if len(batch) == 0 {
+				return nil
+			}
+			if err := tx.Table(table.info.Name).Create(&batch).Error; err != nil {
+				return err
+			}
+			count += len(batch)
+			batch = batch[:0]
+			return nil
+		}
+
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 0, 64*1024), 256*1024*1024)
+		for scanner.Scan() {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			decoder := json.NewDecoder(strings.NewReader(scanner.Text()))
+			decoder.UseNumber()
+			var archived map[string]interface{}
+			if err := decoder.Decode(&archived); err != nil {
+				return fmt.Errorf("invalid row: %w", err)
+			}
+			row := make(map[string]interface{}, len(archived))
+			for column, value := range archived {
+				if kind, ok := table.columns[column]; ok {
+					row[column] = decodeValue(value, kind)
+				}
+			}
+			batch = append(batch, row)
+			if len(batch) == importBatchSize {
+				if err := flush(); err != nil {
+					return err
+				}
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		return flush()
+	})
+	if err != nil {
+		return 0, err
+	}
+	return count, nil
+}
+
+// timeLayouts are tried, in order, for archived times
+var timeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999-07:00", "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// decodeValue turns an archived JSON value back into one the database
+// accepts for a column of the given kind
+func decodeValue(value interface{}, kind columnKind) interface{} {
+	switch v := value.(type) {
+	case json.Number:
+		if kind == kindBool {
+			return v.String() != "0"
+		}
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case string:
+		if kind == kindTime {
+			for _, layout := range timeLayouts {
+				if t, err := time.Parse(layout, v); err == nil {
+					return t
+				}
+			}
+		}
+	case map[string]interface{}:
+		if encoded, ok := v["base64"].(string); ok && len(v) == 1 {
+			if decoded, err := base64.StdEncoding.DecodeString(encoded); err == nil {
+				return decoded
+			}
+		}
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	case []interface{}:
+		encoded, _ := json.Marshal(v)
+		return string(encoded)
+	}
+	return value
+}
+
+// quoteTable quotes a table name for the database
+func quoteTable(db *gorm.DB, name string) string {
+	var b strings.Builder
+	db.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
+
+// prepareRepository replaces any repository with the same ID by an empty one
+func prepareRepository(storage git.StorageDriver, id string) (billy.Filesystem, error) {
+	if err := storage.Delete(id); err != nil {
+		return nil, err
+	}
+	if err := storage.Create(id); err != nil {
+		return nil, err
+	}
+	return storage.Filesystem(id)
+}
+
+func writeRepoFile(fs billy.Filesystem, name string, perm os.FileMode, r io.Reader) error {
+	if perm == 0 {
+		perm = 0644
+	}
+	if dir := path.Dir(name); dir != "." {
+		if err := fs.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	file, err := fs.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// stageFile writes the current archive entry to target
+func stageFile(r io.Reader, target string) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, r)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write %s: %w", target, err)
+	}
+	return nil
+}
@@ -45,6 +45,23 @@ type Claims struct {
 	jwt.RegisteredClaims
 }
 
+// Scopes a token can grant
+const (
+	ScopeRead  = "read"  // Read the user's gists and profile
+	ScopeWrite = "write" // Create, change and delete gists and settings
+	ScopeAdmin = "admin" // Administer the instance
+)
+
+// Scopes returns the scopes the token grants. Session tokens carry every
+// scope their user has.
+func (c *Claims) Scopes() []string {
+	scopes := []string{ScopeRead, ScopeWrite}
+	if c.IsAdmin {
+		scopes = append(scopes, ScopeAdmin)
+	}
+	return scopes
+}
+
 // TokenPair represents access and refresh tokens
 type TokenPair struct {
 	AccessToken  string    `json:"access_token"`
@@ -0,0 +1,58 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"math/big"
+	"strings"
+)
+
+// userCodeAlphabet avoids vowels and look-alike characters so user codes are
+// easy to read aloud and cannot spell words
+const userCodeAlphabet = "BCDFGHJKLMNPQRSTVWXZ"
+
+// GenerateDeviceCode generates the secret code a CLI polls with during the device flow
+func GenerateDeviceCode() (string, error) {
+	return GenerateSecureToken(32)
+}
+
+// GenerateUserCode generates the short code a user types into the browser,
+// formatted as XXXX-XXXX
+func GenerateUserCode() (string, error) {
+	var b strings.Builder
+	max := big.NewInt(int64(len(userCodeAlphabet)))
+	for i := 0; i < 8; i++ {
+		if i == 4 {
+			b.WriteByte('-')
+		}
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		b.WriteByte(userCodeAlphabet[n.Int64()])
+	}
+	return b.String(), nil
+}
+
+// NormalizeUserCode upper-cases a user code and restores the dash, so codes
+// typed as "bcdf ghjk" or "BCDFGHJK" still match
+func NormalizeUserCode(code string) string {
+	code = strings.ToUpper(code)
+	code = strings.Map(func(r rune) rune {
+		if strings.ContainsRune(userCodeAlphabet, r) {
+			return r
+		}
+		return -1
+	}, code)
+	if len(code) == 8 {
+		code = code[:4] + "-" + code[4:]
+	}
+	return code
+}
+
+// HashToken returns the hex-encoded SHA-256 hash used to store secrets at rest
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateUserCode(t *testing.T) {
+	format := regexp.MustCompile(`^[` + userCodeAlphabet + `]{4}-[` + userCodeAlphabet + `]{4}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := GenerateUserCode()
+		require.NoError(t, err)
+		assert.Regexp(t, format, code)
+		assert.Equal(t, code, NormalizeUserCode(code))
+		seen[code] = true
+	}
+	// 20^8 codes; a repeat in 100 means the generator is broken
+	assert.Len(t, seen, 100)
+}
+
+func TestGenerateDeviceCode(t *testing.T) {
+	first, err := GenerateDeviceCode()
+	require.NoError(t, err)
+	second, err := GenerateDeviceCode()
+	require.NoError(t, err)
+	assert.NotEqual(t, first, second)
+	assert.GreaterOrEqual(t, len(first), 32)
+}
+
+func TestNormalizeUserCode(t *testing.T) {
+	for typed, want := range map[string]string{
+		"BCDF-GHJK":   "BCDF-GHJK",
+		"bcdf-ghjk":   "BCDF-GHJK",
+		"bcdfghjk":    "BCDF-GHJK",
+		" bcdf ghjk ": "BCDF-GHJK",
+		"BCDF_GHJK":   "BCDF-GHJK",
+		// Characters outside the alphabet are dropped, so a code with
+		// vowels or digits can't match
+		"BCDF-GHJA": "BCDFGHJ",
+		"":          "",
+	} {
+		assert.Equal(t, want, NormalizeUserCode(typed), "typed %q", typed)
+	}
+}
+
+func TestHashToken(t *testing.T) {
+	assert.Equal(t, HashToken("device-code"), HashToken("device-code"))
+	assert.NotEqual(t, HashToken("device-code"), HashToken("device-code2"))
+	assert.Len(t, HashToken("device-code"), 64)
+}
@@ -11,6 +11,8 @@ import (
 type Middleware struct {
 	authService *AuthService
 	skipper     func(c echo.Context) bool
+
+	authenticated []echo.MiddlewareFunc // Run once a request is authenticated
 }
 
 // NewMiddleware creates a new authentication middleware
@@ -21,6 +23,22 @@ func NewMiddleware(authService *AuthService) *Middleware {
 	}
 }
 
+// WithAuthenticated returns a copy of the middleware that runs mw after a
+// request has been authenticated, such as per-user rate limits
+func (m *Middleware) WithAuthenticated(mw ...echo.MiddlewareFunc) *Middleware {
+	copied := *m
+	copied.authenticated = append(append([]echo.MiddlewareFunc{}, m.authenticated...), mw...)
+	return &copied
+}
+
+// chain wraps next in the middleware run for authenticated requests
+func (m *Middleware) chain(next echo.HandlerFunc) echo.HandlerFunc {
+	for i := len(m.authenticated) - 1; i >= 0; i-- {
+		next = m.authenticated[i](next)
+	}
+	return next
+}
+
 // DefaultSkipper returns true for paths that don't require authentication
 func DefaultSkipper(c echo.Context) bool {
 	path := c.Path()
@@ -33,6 +51,8 @@ func DefaultSkipper(c echo.Context) bool {
 		"/forgot-password",
 		"/reset-password",
 		"/health",
+		"/livez",
+		"/readyz",
 		"/metrics",
 		"/api/v1/auth/login",
 		"/api/v1/auth/register",
@@ -66,6 +86,7 @@ func DefaultSkipper(c echo.Context) bool {
 // Auth returns the authentication middleware handler
 func (m *Middleware) Auth() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		authenticated := m.chain(next)
 		return func(c echo.Context) error {
 			// Skip authentication for certain paths
 			if m.skipper != nil && m.skipper(c) {
@@ -101,8 +122,9 @@ func (m *Middleware) Auth() echo.MiddlewareFunc {
 			c.Set("email", claims.Email)
 			c.Set("is_admin", claims.IsAdmin)
 			c.Set("session_id", claims.SessionID)
+			c.Set("token_claims", claims)
 			
-			return next(c)
+			return authenticated(c)
 		}
 	}
 }
@@ -123,6 +145,7 @@ func (m *Middleware) RequireAdmin() echo.MiddlewareFunc {
 // OptionalAuth returns middleware that sets user context if authenticated but doesn't require it
 func (m *Middleware) OptionalAuth() echo.MiddlewareFunc {
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		authenticated := m.chain(next)
 		return func(c echo.Context) error {
 			// Extract token from header
 			auth := c.Request().Header.Get("Authorization")
@@ -151,6 +174,8 @@ func (m *Middleware) OptionalAuth() echo.MiddlewareFunc {
 					c.Set("email", claims.Email)
 					c.Set("is_admin", claims.IsAdmin)
 					c.Set("session_id", claims.SessionID)
+					c.Set("token_claims", claims)
+					return authenticated(c)
 				}
 			}
 			
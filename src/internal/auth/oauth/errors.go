@@ -0,0 +1,55 @@
+package oauth
+
+import "errors"
+
+// Error codes the callback sends back to the login page, which shows the
+// matching message. Codes keep provider error text out of the page.
+const (
+	CodeUnavailable        = "unavailable"
+	CodeInvalidState       = "state"
+	CodeDenied             = "denied"
+	CodeProvider           = "provider"
+	CodeEmailNotVerified   = "email_not_verified"
+	CodeAccountNotVerified = "account_not_verified"
+	CodeSignupDisabled     = "signup_disabled"
+	CodeAccountDisabled    = "account_disabled"
+	CodeTwoFactorRequired  = "two_factor"
+	CodeServer             = "server"
+)
+
+var errorMessages = map[string]string{
+	CodeUnavailable:        "That sign in provider is not available.",
+	CodeInvalidState:       "The sign in expired or was started in another browser. Please try again.",
+	CodeDenied:             "Sign in was cancelled at the provider.",
+	CodeProvider:           "The sign in provider could not be reached or rejected the sign in. Please try again.",
+	CodeEmailNotVerified:   "The provider has not verified your email address. Verify it there and try again.",
+	CodeAccountNotVerified: "An account with your email address exists but has not verified it. Sign in with your password and verify your email address first.",
+	CodeSignupDisabled:     "No account uses your email address and sign up is disabled.",
+	CodeAccountDisabled:    "Your account is disabled.",
+	CodeTwoFactorRequired:  "Your account uses two-factor authentication. Sign in with your password or a passkey instead.",
+	CodeServer:             "Something went wrong signing you in. Please try again.",
+}
+
+// ErrorMessage returns the message for an error code, or "" for an
+// unknown code
+func ErrorMessage(code string) string {
+	return errorMessages[code]
+}
+
+// ErrorCode returns the code of an error returned by SignIn or DecodeState
+func ErrorCode(err error) string {
+	switch {
+	case errors.Is(err, ErrUnknownProvider):
+		return CodeUnavailable
+	case errors.Is(err, ErrInvalidState):
+		return CodeInvalidState
+	case errors.Is(err, ErrEmailNotVerified):
+		return CodeEmailNotVerified
+	case errors.Is(err, ErrAccountNotVerified):
+		return CodeAccountNotVerified
+	case errors.Is(err, ErrSignupDisabled):
+		return CodeSignupDisabled
+	default:
+		return CodeServer
+	}
+}
@@ -0,0 +1,193 @@
+package oauth
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+var (
+	// ErrUnknownProvider is returned for a provider that isn't configured
+	ErrUnknownProvider = errors.New("unknown or disabled sign in provider")
+	// ErrEmailNotVerified is returned when the provider doesn't vouch for
+	// the account's email address, which linking and sign up both need
+	ErrEmailNotVerified = errors.New("the provider has not verified the account's email address")
+	// ErrAccountNotVerified is returned when the local account with the
+	// same email address never verified it. Linking would hand the account
+	// to whoever registered the address without owning it.
+	ErrAccountNotVerified = errors.New("the account with this email address has not verified it")
+	// ErrSignupDisabled is returned when a first sign in would create an
+	// account and sign up is off
+	ErrSignupDisabled = errors.New("sign up is disabled")
+)
+
+// reservedUsernames are never given to accounts created on sign in
+var reservedUsernames = map[string]bool{
+	"admin": true, "administrator": true, "root": true, "system": true,
+	"archive": true, "api": true, "www": true, "mail": true, "ftp": true,
+}
+
+// SignIn returns the local account of an identity. An identity signed in
+// with before has a link to its account. Otherwise it is linked to the
+// account with the same verified email address, or, with allowSignup, a
+// new account is created. created reports the last case.
+func SignIn(db *gorm.DB, identity *Identity, allowSignup bool) (user *models.User, created bool, err error) {
+	now := time.Now()
+
+	var link models.UserIdentity
+	err = db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&link).Error
+	if err == nil {
+		var linked models.User
+		err = db.First(&linked, "id = ?", link.UserID).Error
+		if err == nil {
+			db.Model(&link).Updates(map[string]interface{}{"email": identity.Email, "last_login_at": now})
+			return &linked, false, nil
+		}
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, fmt.Errorf("failed to load linked user: %w", err)
+		}
+		// The account was deleted; the identity can start over
+		db.Delete(&link)
+	} else if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, fmt.Errorf("failed to look up identity: %w", err)
+	}
+
+	if identity.Email == "" || !identity.EmailVerified {
+		return nil, false, ErrEmailNotVerified
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		var existing models.User
+		lookup := tx.Where("LOWER(email) = LOWER(?)", identity.Email).First(&existing)
+		switch {
+		case lookup.Error == nil:
+			if !existing.EmailVerified && !existing.IsEmailVerified {
+				return ErrAccountNotVerified
+			}
+			user = &existing
+		case !errors.Is(lookup.Error, gorm.ErrRecordNotFound):
+			return fmt.Errorf("failed to look up user by email: %w", lookup.Error)
+		case !allowSignup:
+			return ErrSignupDisabled
+		default:
+			if user, err = createUser(tx, identity); err != nil {
+				return err
+			}
+			created = true
+		}
+
+		return tx.Create(&models.UserIdentity{
+			UserID:      user.ID,
+			Provider:    identity.Provider,
+			Subject:     identity.Subject,
+			Email:       identity.Email,
+			LastLoginAt: &now,
+		}).Error
+	})
+	if err != nil {
+		return nil, false, err
+	}
+	return user, created, nil
+}
+
+// createUser creates the account of an identity signing in for the first
+// time. The account has a random password, so it signs in through the
+// provider until the user sets one with a password reset.
+func createUser(tx *gorm.DB, identity *Identity) (*models.User, error) {
+	username, err := availableUsername(tx, identity)
+	if err != nil {
+		return nil, err
+	}
+	password, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate password: %w", err)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	displayName := identity.Name
+	if displayName == "" {
+		displayName = username
+	}
+	user := &models.User{
+		Username:        username,
+		Email:           identity.Email,
+		PasswordHash:    hash,
+		DisplayName:     truncate(displayName, 100),
+		AvatarURL:       truncate(identity.AvatarURL, 255),
+		IsActive:        true,
+		EmailVerified:   true,
+		IsEmailVerified: true,
+	}
+	if err := tx.Create(user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create user: %w", err)
+	}
+	return user, nil
+}
+
+// availableUsername picks a free username for an identity, starting from
+// its username at the provider or its email address
+func availableUsername(tx *gorm.DB, identity *Identity) (string, error) {
+	base := usernameFrom(identity.Username)
+	if base == "" {
+		local, _, _ := strings.Cut(identity.Email, "@")
+		base = usernameFrom(local)
+	}
+	if base == "" {
+		base = "user"
+	}
+
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			suffix := fmt.Sprintf("-%d", i)
+			candidate = strings.TrimRight(truncate(base, 39-len(suffix)), "-") + suffix
+		}
+		if len(candidate) < 3 || reservedUsernames[strings.ToLower(candidate)] {
+			continue
+		}
+		var count int64
+		if err := tx.Model(&models.User{}).Where("LOWER(username) = LOWER(?)", candidate).Count(&count).Error; err != nil {
+			return "", fmt.Errorf("failed to check username: %w", err)
+		}
+		if count == 0 {
+			return candidate, nil
+		}
+	}
+
+	random, err := auth.GenerateSecureToken(6)
+	if err != nil {
+		return "", err
+	}
+	return "user-" + strings.ToLower(usernameFrom(random)), nil
+}
+
+// usernameFrom keeps the letters, digits and single hyphens of a name
+func usernameFrom(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			b.WriteRune(r)
+		case b.Len() > 0 && !strings.HasSuffix(b.String(), "-"):
+			b.WriteByte('-')
+		}
+	}
+	return strings.Trim(truncate(b.String(), 39), "-")
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,207 @@
+// Package oauth signs users in through an external identity provider:
+// GitHub, GitLab or any OpenID Connect provider. Providers are configured
+// under the oauth section of the configuration file, and settings saved
+// through the setup wizard or admin settings override it. A provider
+// vouches for an identity; SignIn links it to a local account by verified
+// email or creates one.
+package oauth
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Provider kinds
+const (
+	KindGitHub = "github"
+	KindGitLab = "gitlab"
+	KindOIDC   = "oidc"
+)
+
+// Sign in routes, with the provider name as the parameter
+const (
+	LoginRoute    = "/auth/oauth/:provider"
+	CallbackRoute = "/auth/oauth/:provider/callback"
+)
+
+// ProviderNames are the providers that can be configured, in the order
+// they are offered on the login page
+var ProviderNames = []string{KindGitHub, KindGitLab, KindOIDC}
+
+// defaultScopes are requested when a provider configures none
+var defaultScopes = map[string][]string{
+	KindGitHub: {"read:user", "user:email"},
+	KindGitLab: {"openid", "email", "profile"},
+	KindOIDC:   {"openid", "email", "profile"},
+}
+
+// defaultDisplayNames label the sign in buttons
+var defaultDisplayNames = map[string]string{
+	KindGitHub: "GitHub",
+	KindGitLab: "GitLab",
+	KindOIDC:   "Single sign-on",
+}
+
+// Settings configure one provider
+type Settings struct {
+	Name         string   `json:"name"`
+	DisplayName  string   `json:"display_name"`
+	Enabled      bool     `json:"enabled"`
+	ClientID     string   `json:"client_id"`
+	ClientSecret string   `json:"-"`
+	Scopes       []string `json:"scopes"`
+	// URL is the GitHub Enterprise or GitLab instance, or the issuer of an
+	// OpenID Connect provider
+	URL string `json:"url,omitempty"`
+}
+
+// Configured reports whether the provider is enabled and has what it
+// needs to sign users in
+func (s Settings) Configured() bool {
+	if !s.Enabled || s.ClientID == "" || s.ClientSecret == "" {
+		return false
+	}
+	return s.Name != KindOIDC || s.URL != ""
+}
+
+// Manager reads the provider settings and keeps the endpoints found by
+// OpenID Connect discovery
+type Manager struct {
+	db     *gorm.DB
+	config *config.Config
+	client *http.Client
+
+	mu        sync.Mutex
+	endpoints map[string]*discovered
+}
+
+// discovered holds the endpoints of an issuer and when they were read
+type discovered struct {
+	endpoints Endpoints
+	at        time.Time
+}
+
+// discoveryTTL is how long discovered endpoints are used before the
+// discovery document is read again
+const discoveryTTL = time.Hour
+
+// NewManager creates a provider manager
+func NewManager(db *gorm.DB, config *config.Config) *Manager {
+	return &Manager{
+		db:        db,
+		config:    config,
+		client:    &http.Client{Timeout: 15 * time.Second},
+		endpoints: make(map[string]*discovered),
+	}
+}
+
+// WithClient replaces the HTTP client used to talk to providers
+func (m *Manager) WithClient(client *http.Client) *Manager {
+	m.client = client
+	return m
+}
+
+// Settings returns the settings of every provider, configured or not
+func (m *Manager) Settings() []Settings {
+	overrides := m.overrides()
+	all := make([]Settings, 0, len(ProviderNames))
+	for _, name := range ProviderNames {
+		all = append(all, m.settings(name, overrides))
+	}
+	return all
+}
+
+// Enabled returns the providers users can sign in with
+func (m *Manager) Enabled() []Settings {
+	var enabled []Settings
+	for _, settings := range m.Settings() {
+		if settings.Configured() {
+			enabled = append(enabled, settings)
+		}
+	}
+	return enabled
+}
+
+// Provider returns a configured provider by name
+func (m *Manager) Provider(name string) (*Provider, error) {
+	settings := m.settings(name, m.overrides())
+	if _, known := defaultScopes[name]; !known || !settings.Configured() {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownProvider, name)
+	}
+	return &Provider{Settings: settings, manager: m}, nil
+}
+
+// AllowSignup reports whether a first sign in may create an account
+func (m *Manager) AllowSignup() bool {
+	if value, ok := m.overrides()["oauth.allow_signup"]; ok {
+		allowed, _ := strconv.ParseBool(value)
+		return allowed && m.config.GetBool("features.registration")
+	}
+	return m.config.GetBool("oauth.allow_signup") && m.config.GetBool("features.registration")
+}
+
+func (m *Manager) settings(name string, overrides map[string]string) Settings {
+	prefix := "oauth." + name + "."
+	get := func(key string) string {
+		if value, ok := overrides[prefix+key]; ok {
+			return value
+		}
+		return m.config.GetString(prefix + key)
+	}
+
+	settings := Settings{
+		Name:         name,
+		DisplayName:  get("display_name"),
+		ClientID:     get("client_id"),
+		ClientSecret: get("client_secret"),
+		URL:          strings.TrimRight(get("url"), "/"),
+	}
+	settings.Enabled, _ = strconv.ParseBool(get("enabled"))
+
+	if value, ok := overrides[prefix+"scopes"]; ok {
+		settings.Scopes = splitScopes(value)
+	} else {
+		settings.Scopes = m.config.GetStringSlice(prefix + "scopes")
+	}
+	if len(settings.Scopes) == 0 {
+		settings.Scopes = defaultScopes[name]
+	}
+	if settings.DisplayName == "" {
+		settings.DisplayName = defaultDisplayNames[name]
+	}
+	if name == KindGitLab && settings.URL == "" {
+		settings.URL = "https://gitlab.com"
+	}
+	return settings
+}
+
+// overrides returns the OAuth settings saved through the setup wizard or
+// the admin settings API
+func (m *Manager) overrides() map[string]string {
+	overrides := map[string]string{}
+	if m.db == nil {
+		return overrides
+	}
+	var configs []models.SystemConfig
+	m.db.Where("key LIKE ?", "oauth.%").Find(&configs)
+	for _, config := range configs {
+		overrides[config.Key] = config.Value
+	}
+	return overrides
+}
+
+// splitScopes reads scopes saved as a comma or space separated list
+func splitScopes(value string) []string {
+	return strings.FieldsFunc(value, func(r rune) bool {
+		return r == ',' || r == ' '
+	})
+}
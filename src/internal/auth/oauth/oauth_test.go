@@ -0,0 +1,335 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func newTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}, &models.User{}, &models.UserIdentity{}))
+	return db
+}
+
+// fakeProvider serves OpenID Connect discovery, a token endpoint that
+// checks the PKCE verifier, userinfo, and the GitHub API under /api/v3
+func fakeProvider(t *testing.T, claims map[string]interface{}) *httptest.Server {
+	var server *httptest.Server
+	var challenge string
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 server.URL,
+			"authorization_endpoint": server.URL + "/authorize",
+			"token_endpoint":         server.URL + "/token",
+			"userinfo_endpoint":      server.URL + "/userinfo",
+		})
+	})
+	token := func(w http.ResponseWriter, r *http.Request) {
+		require.NoError(t, r.ParseForm())
+		state := &State{Verifier: r.PostForm.Get("code_verifier")}
+		if r.PostForm.Get("code") != "good-code" || state.Challenge() != challenge {
+			// GitHub answers a bad code with 200 and an error
+			json.NewEncoder(w).Encode(map[string]string{"error": "bad_verification_code"})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]string{"access_token": "provider-token", "token_type": "bearer"})
+	}
+	mux.HandleFunc("/token", token)
+	mux.HandleFunc("/login/oauth/access_token", token)
+	authorized := func(w http.ResponseWriter, r *http.Request) bool {
+		if r.Header.Get("Authorization") != "Bearer provider-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return false
+		}
+		return true
+	}
+	mux.HandleFunc("/userinfo", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode(claims)
+		}
+	})
+	mux.HandleFunc("/api/v3/user", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"id": 42, "login": "octo", "name": "Octo Cat", "email": "public@example.com",
+			})
+		}
+	})
+	mux.HandleFunc("/api/v3/user/emails", func(w http.ResponseWriter, r *http.Request) {
+		if authorized(w, r) {
+			json.NewEncoder(w).Encode([]map[string]interface{}{
+				{"email": "unverified@example.com", "primary": false, "verified": false},
+				{"email": "old@example.com", "primary": false, "verified": true},
+				{"email": "octo@example.com", "primary": true, "verified": true},
+			})
+		}
+	})
+	// Records the challenge the way a provider keeps it with the code
+	authorizeHandler := func(w http.ResponseWriter, r *http.Request) {
+		challenge = r.URL.Query().Get("code_challenge")
+	}
+	mux.HandleFunc("/authorize", authorizeHandler)
+	mux.HandleFunc("/login/oauth/authorize", authorizeHandler)
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// authorize follows an authorization URL as the browser would
+func authorize(t *testing.T, authURL string) url.Values {
+	resp, err := http.Get(authURL)
+	require.NoError(t, err)
+	resp.Body.Close()
+	parsed, err := url.Parse(authURL)
+	require.NoError(t, err)
+	return parsed.Query()
+}
+
+func TestSettings(t *testing.T) {
+	db := newTestDB(t)
+	config := config.New()
+	config.Set("features.registration", true)
+	config.Set("oauth.allow_signup", true)
+	config.Set("oauth.github.enabled", true)
+	config.Set("oauth.github.client_id", "file-id")
+	config.Set("oauth.github.client_secret", "file-secret")
+	config.Set("oauth.oidc.enabled", true)
+	config.Set("oauth.oidc.client_id", "oidc-id")
+	config.Set("oauth.oidc.client_secret", "oidc-secret")
+	manager := NewManager(db, config)
+
+	// The OpenID Connect provider has no issuer
+	enabled := manager.Enabled()
+	require.Len(t, enabled, 1)
+	assert.Equal(t, KindGitHub, enabled[0].Name)
+	assert.Equal(t, "GitHub", enabled[0].DisplayName)
+	assert.Equal(t, []string{"read:user", "user:email"}, enabled[0].Scopes)
+	_, err := manager.Provider(KindOIDC)
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+	_, err = manager.Provider("myspace")
+	assert.ErrorIs(t, err, ErrUnknownProvider)
+
+	// Settings saved through the setup wizard win over the file
+	for key, value := range map[string]string{
+		"oauth.oidc.url":           "https://id.example.com/",
+		"oauth.oidc.display_name":  "Example SSO",
+		"oauth.oidc.scopes":        "openid, email",
+		"oauth.github.client_id":   "saved-id",
+		"oauth.allow_signup":       "false",
+		"oauth.gitlab.client_id":   "gitlab-id",
+		"oauth.gitlab.enabled":     "false",
+		"oauth.unrelated.settings": "x",
+	} {
+		require.NoError(t, db.Create(&models.SystemConfig{Key: key, Value: value}).Error)
+	}
+	provider, err := manager.Provider(KindOIDC)
+	require.NoError(t, err)
+	assert.Equal(t, "https://id.example.com", provider.URL)
+	assert.Equal(t, "Example SSO", provider.DisplayName)
+	assert.Equal(t, []string{"openid", "email"}, provider.Scopes)
+	github, err := manager.Provider(KindGitHub)
+	require.NoError(t, err)
+	assert.Equal(t, "saved-id", github.ClientID)
+	assert.Equal(t, "file-secret", github.ClientSecret)
+	assert.Len(t, manager.Enabled(), 2)
+	assert.False(t, manager.AllowSignup())
+
+	gitlab := manager.Settings()[1]
+	assert.Equal(t, "https://gitlab.com", gitlab.URL)
+	assert.False(t, gitlab.Configured())
+}
+
+func TestState(t *testing.T) {
+	secret := []byte("instance-secret")
+	state, err := NewState(KindGitHub, "/gists?page=2")
+	require.NoError(t, err)
+	assert.Equal(t, "/gists?page=2", state.Redirect)
+	cookie, err := state.Encode(secret)
+	require.NoError(t, err)
+
+	decoded, err := DecodeState(cookie, secret, KindGitHub, state.Value)
+	require.NoError(t, err)
+	assert.Equal(t, state.Verifier, decoded.Verifier)
+	assert.Equal(t, state.Challenge(), decoded.Challenge())
+
+	_, err = DecodeState(cookie, []byte("other-secret"), KindGitHub, state.Value)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = DecodeState(cookie, secret, KindGitLab, state.Value)
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = DecodeState(cookie, secret, KindGitHub, "forged")
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = DecodeState(cookie, secret, KindGitHub, "")
+	assert.ErrorIs(t, err, ErrInvalidState)
+	_, err = DecodeState("x"+cookie, secret, KindGitHub, state.Value)
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	state.Expires = time.Now().Add(-time.Second)
+	expired, err := state.Encode(secret)
+	require.NoError(t, err)
+	_, err = DecodeState(expired, secret, KindGitHub, state.Value)
+	assert.ErrorIs(t, err, ErrInvalidState)
+
+	for redirect, want := range map[string]string{
+		"":                     "/",
+		"/settings":            "/settings",
+		"//evil.example.com":   "/",
+		"/\\evil.example.com":  "/",
+		"https://evil.example": "/",
+	} {
+		assert.Equal(t, want, SafeRedirect(redirect), redirect)
+	}
+}
+
+func TestOIDCProvider(t *testing.T) {
+	server := fakeProvider(t, map[string]interface{}{
+		"sub":                "user-7",
+		"email":              "Ada@Example.com",
+		"email_verified":     "true",
+		"preferred_username": "ada",
+		"name":               "Ada Lovelace",
+	})
+	config := config.New()
+	config.Set("oauth.oidc.enabled", true)
+	config.Set("oauth.oidc.url", server.URL)
+	config.Set("oauth.oidc.client_id", "client")
+	config.Set("oauth.oidc.client_secret", "secret")
+	provider, err := NewManager(nil, config).Provider(KindOIDC)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	state, err := NewState(KindOIDC, "/")
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, state, "https://gists.example.com/auth/oauth/oidc/callback")
+	require.NoError(t, err)
+	query := authorize(t, authURL)
+	assert.Equal(t, "client", query.Get("client_id"))
+	assert.Equal(t, "openid email profile", query.Get("scope"))
+	assert.Equal(t, state.Value, query.Get("state"))
+	assert.Equal(t, "S256", query.Get("code_challenge_method"))
+
+	_, err = provider.Exchange(ctx, "good-code", "wrong-verifier", "")
+	assert.ErrorContains(t, err, "bad_verification_code")
+	token, err := provider.Exchange(ctx, "good-code", state.Verifier, "")
+	require.NoError(t, err)
+
+	identity, err := provider.Identity(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, &Identity{
+		Provider:      KindOIDC,
+		Subject:       "user-7",
+		Email:         "Ada@Example.com",
+		EmailVerified: true,
+		Username:      "ada",
+		Name:          "Ada Lovelace",
+	}, identity)
+}
+
+func TestGitHubProvider(t *testing.T) {
+	server := fakeProvider(t, nil)
+	config := config.New()
+	config.Set("oauth.github.enabled", true)
+	// A GitHub Enterprise server keeps its API under /api/v3
+	config.Set("oauth.github.url", server.URL)
+	config.Set("oauth.github.client_id", "client")
+	config.Set("oauth.github.client_secret", "secret")
+	provider, err := NewManager(nil, config).Provider(KindGitHub)
+	require.NoError(t, err)
+
+	ctx := context.Background()
+	state, err := NewState(KindGitHub, "/")
+	require.NoError(t, err)
+	authURL, err := provider.AuthCodeURL(ctx, state, "")
+	require.NoError(t, err)
+	authorize(t, authURL)
+	token, err := provider.Exchange(ctx, "good-code", state.Verifier, "")
+	require.NoError(t, err)
+
+	identity, err := provider.Identity(ctx, token)
+	require.NoError(t, err)
+	assert.Equal(t, "42", identity.Subject)
+	assert.Equal(t, "octo", identity.Username)
+	// The primary verified address, not the public profile one
+	assert.Equal(t, "octo@example.com", identity.Email)
+	assert.True(t, identity.EmailVerified)
+}
+
+func TestSignIn(t *testing.T) {
+	db := newTestDB(t)
+	identity := &Identity{
+		Provider:      KindGitHub,
+		Subject:       "42",
+		Email:         "octo@example.com",
+		EmailVerified: true,
+		Username:      "admin",
+		Name:          "Octo Cat",
+	}
+
+	_, _, err := SignIn(db, identity, false)
+	assert.ErrorIs(t, err, ErrSignupDisabled)
+
+	// A reserved username gets a suffix
+	user, created, err := SignIn(db, identity, true)
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "admin-2", user.Username)
+	assert.Equal(t, "Octo Cat", user.DisplayName)
+	assert.True(t, user.EmailVerified)
+
+	// The link is used from now on, whatever the email says
+	again, created, err := SignIn(db, &Identity{Provider: KindGitHub, Subject: "42", Email: "new@example.com"}, false)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, user.ID, again.ID)
+	var link models.UserIdentity
+	require.NoError(t, db.First(&link, "subject = ?", "42").Error)
+	assert.Equal(t, "new@example.com", link.Email)
+
+	// Another provider links to the same account by verified email
+	linked, created, err := SignIn(db, &Identity{
+		Provider: KindOIDC, Subject: "abc", Email: "OCTO@example.com", EmailVerified: true, Username: "octo",
+	}, true)
+	require.NoError(t, err)
+	assert.False(t, created)
+	assert.Equal(t, user.ID, linked.ID)
+
+	_, _, err = SignIn(db, &Identity{Provider: KindGitLab, Subject: "9", Email: "octo@example.com"}, true)
+	assert.ErrorIs(t, err, ErrEmailNotVerified)
+
+	// Whoever registered an address without verifying it doesn't get the
+	// provider's account
+	squatter := &models.User{Username: "squatter", Email: "victim@example.com", PasswordHash: "x", IsActive: true}
+	require.NoError(t, db.Create(squatter).Error)
+	_, _, err = SignIn(db, &Identity{
+		Provider: KindGitLab, Subject: "10", Email: "victim@example.com", EmailVerified: true,
+	}, true)
+	assert.ErrorIs(t, err, ErrAccountNotVerified)
+
+	var identities int64
+	db.Model(&models.UserIdentity{}).Count(&identities)
+	assert.Equal(t, int64(2), identities)
+}
+
+func TestUsernameFrom(t *testing.T) {
+	assert.Equal(t, "jane-doe", usernameFrom("jane.doe"))
+	assert.Equal(t, "j-rg", usernameFrom("_jörg_"))
+	assert.Equal(t, "", usernameFrom("..."))
+}
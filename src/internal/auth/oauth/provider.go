@@ -0,0 +1,300 @@
+package oauth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxResponseSize caps what is read from a provider
+const maxResponseSize = 1 << 20
+
+// Endpoints are where a provider authorizes users, issues tokens and
+// describes the signed in user
+type Endpoints struct {
+	AuthURL     string `json:"authorization_endpoint"`
+	TokenURL    string `json:"token_endpoint"`
+	UserInfoURL string `json:"userinfo_endpoint"`
+}
+
+// Identity is the account a provider vouches for
+type Identity struct {
+	Provider string
+	// Subject is the provider's stable ID for the account
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Username      string
+	Name          string
+	AvatarURL     string
+}
+
+// Provider is a configured identity provider
+type Provider struct {
+	Settings
+	manager *Manager
+}
+
+// AuthCodeURL returns where to send the user to sign in. The state is
+// echoed back to the callback and the challenge binds the code to the
+// verifier kept in the state cookie (PKCE).
+func (p *Provider) AuthCodeURL(ctx context.Context, state *State, redirectURI string) (string, error) {
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {redirectURI},
+		"scope":                 {strings.Join(p.Scopes, " ")},
+		"state":                 {state.Value},
+		"code_challenge":        {state.Challenge()},
+		"code_challenge_method": {"S256"},
+	}
+	separator := "?"
+	if strings.Contains(endpoints.AuthURL, "?") {
+		separator = "&"
+	}
+	return endpoints.AuthURL + separator + query.Encode(), nil
+}
+
+// Exchange trades the code from the callback for an access token
+func (p *Provider) Exchange(ctx context.Context, code, verifier, redirectURI string) (string, error) {
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {redirectURI},
+		"client_id":     {p.ClientID},
+		"client_secret": {p.ClientSecret},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoints.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+
+	var token struct {
+		AccessToken      string `json:"access_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	// GitHub reports a bad code with 200 and an error field
+	if err := p.manager.do(req, &token); err != nil && token.Error == "" {
+		return "", fmt.Errorf("failed to exchange the code with %s: %w", p.DisplayName, err)
+	}
+	if token.Error != "" {
+		return "", fmt.Errorf("%s rejected the code: %s %s", p.DisplayName, token.Error, token.ErrorDescription)
+	}
+	if token.AccessToken == "" {
+		return "", fmt.Errorf("%s returned no access token", p.DisplayName)
+	}
+	return token.AccessToken, nil
+}
+
+// Identity returns the account the access token belongs to
+func (p *Provider) Identity(ctx context.Context, accessToken string) (*Identity, error) {
+	var (
+		identity *Identity
+		err      error
+	)
+	if p.Name == KindGitHub {
+		identity, err = p.gitHubIdentity(ctx, accessToken)
+	} else {
+		identity, err = p.userInfoIdentity(ctx, accessToken)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the %s account: %w", p.DisplayName, err)
+	}
+	identity.Provider = p.Name
+	identity.Email = strings.TrimSpace(identity.Email)
+	return identity, nil
+}
+
+// gitHubIdentity reads the user and their email addresses from the GitHub
+// API; only /user/emails says whether an address is verified
+func (p *Provider) gitHubIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	var user struct {
+		ID        int64  `json:"id"`
+		Login     string `json:"login"`
+		Name      string `json:"name"`
+		Email     string `json:"email"`
+		AvatarURL string `json:"avatar_url"`
+	}
+	if err := p.getJSON(ctx, p.gitHubAPI()+"/user", accessToken, &user); err != nil {
+		return nil, err
+	}
+	if user.ID == 0 {
+		return nil, fmt.Errorf("no user ID in the response")
+	}
+	identity := &Identity{
+		Subject:   strconv.FormatInt(user.ID, 10),
+		Email:     user.Email,
+		Username:  user.Login,
+		Name:      user.Name,
+		AvatarURL: user.AvatarURL,
+	}
+
+	var emails []struct {
+		Email    string `json:"email"`
+		Primary  bool   `json:"primary"`
+		Verified bool   `json:"verified"`
+	}
+	if err := p.getJSON(ctx, p.gitHubAPI()+"/user/emails", accessToken, &emails); err != nil {
+		// Without the user:email scope the address can't be checked
+		return identity, nil
+	}
+	for _, email := range emails {
+		if email.Verified && (email.Primary || !identity.EmailVerified) {
+			identity.Email = email.Email
+			identity.EmailVerified = true
+		}
+	}
+	return identity, nil
+}
+
+// userInfoIdentity reads the OpenID Connect userinfo endpoint
+func (p *Provider) userInfoIdentity(ctx context.Context, accessToken string) (*Identity, error) {
+	endpoints, err := p.endpoints(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if endpoints.UserInfoURL == "" {
+		return nil, fmt.Errorf("the provider has no userinfo endpoint")
+	}
+
+	var claims struct {
+		Subject           string          `json:"sub"`
+		Email             string          `json:"email"`
+		EmailVerified     json.RawMessage `json:"email_verified"`
+		PreferredUsername string          `json:"preferred_username"`
+		Nickname          string          `json:"nickname"`
+		Name              string          `json:"name"`
+		Picture           string          `json:"picture"`
+	}
+	if err := p.getJSON(ctx, endpoints.UserInfoURL, accessToken, &claims); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("no subject in the userinfo response")
+	}
+
+	username := claims.PreferredUsername
+	if username == "" {
+		username = claims.Nickname
+	}
+	return &Identity{
+		Subject:  claims.Subject,
+		Email:    claims.Email,
+		Username: username,
+		Name:     claims.Name,
+		// Some providers send the claim as a string
+		EmailVerified: strings.Trim(string(claims.EmailVerified), `"`) == "true",
+		AvatarURL:     claims.Picture,
+	}, nil
+}
+
+// endpoints returns the provider's endpoints, discovering them for
+// OpenID Connect providers
+func (p *Provider) endpoints(ctx context.Context) (Endpoints, error) {
+	if p.Name == KindGitHub {
+		base := "https://github.com"
+		if p.URL != "" {
+			base = p.URL
+		}
+		return Endpoints{
+			AuthURL:  base + "/login/oauth/authorize",
+			TokenURL: base + "/login/oauth/access_token",
+		}, nil
+	}
+	return p.manager.discover(ctx, p.URL)
+}
+
+// gitHubAPI returns the API root of GitHub or a GitHub Enterprise server
+func (p *Provider) gitHubAPI() string {
+	if p.URL == "" {
+		return "https://api.github.com"
+	}
+	return p.URL + "/api/v3"
+}
+
+func (p *Provider) getJSON(ctx context.Context, endpoint, accessToken string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	req.Header.Set("Accept", "application/json")
+	return p.manager.do(req, v)
+}
+
+// do sends a request and decodes the JSON response into v. v is decoded
+// for error responses too, so callers can read the provider's error.
+func (m *Manager) do(req *http.Request, v interface{}) error {
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return err
+	}
+	decodeErr := json.Unmarshal(body, v)
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("%s returned %d", req.URL.Host, resp.StatusCode)
+	}
+	if decodeErr != nil {
+		return fmt.Errorf("invalid response from %s: %w", req.URL.Host, decodeErr)
+	}
+	return nil
+}
+
+// discover reads the OpenID Connect discovery document of an issuer,
+// keeping it for discoveryTTL
+func (m *Manager) discover(ctx context.Context, issuer string) (Endpoints, error) {
+	m.mu.Lock()
+	cached, ok := m.endpoints[issuer]
+	m.mu.Unlock()
+	if ok && time.Since(cached.at) < discoveryTTL {
+		return cached.endpoints, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, issuer+"/.well-known/openid-configuration", nil)
+	if err != nil {
+		return Endpoints{}, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	var document struct {
+		Endpoints
+		Issuer string `json:"issuer"`
+	}
+	if err := m.do(req, &document); err != nil {
+		return Endpoints{}, fmt.Errorf("failed to discover %s: %w", issuer, err)
+	}
+	if strings.TrimRight(document.Issuer, "/") != issuer {
+		return Endpoints{}, fmt.Errorf("discovery document of %s names issuer %q", issuer, document.Issuer)
+	}
+	if document.AuthURL == "" || document.TokenURL == "" {
+		return Endpoints{}, fmt.Errorf("discovery document of %s has no authorization or token endpoint", issuer)
+	}
+
+	m.mu.Lock()
+	m.endpoints[issuer] = &discovered{endpoints: document.Endpoints, at: time.Now()}
+	m.mu.Unlock()
+	return document.Endpoints, nil
+}
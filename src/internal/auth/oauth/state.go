@@ -0,0 +1,114 @@
+package oauth
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// StateCookie carries the state of a sign in between the redirect to the
+// provider and the callback
+const StateCookie = "oauth_state"
+
+// StateTTL is how long a user has to sign in at the provider
+const StateTTL = 10 * time.Minute
+
+// ErrInvalidState is returned when the callback's state is missing,
+// expired, tampered with or for another provider
+var ErrInvalidState = errors.New("invalid or expired sign in state")
+
+// State is the sign in in progress. It is kept in a cookie signed with
+// the instance secret, so nothing is stored for sign ins never finished.
+type State struct {
+	Provider string    `json:"p"`
+	Value    string    `json:"s"`
+	Verifier string    `json:"v"`
+	Redirect string    `json:"r,omitempty"`
+	Expires  time.Time `json:"e"`
+}
+
+// NewState starts a sign in with a provider, returning to redirect, a
+// local path, once signed in
+func NewState(provider, redirect string) (*State, error) {
+	value, err := randomString(24)
+	if err != nil {
+		return nil, err
+	}
+	verifier, err := randomString(48)
+	if err != nil {
+		return nil, err
+	}
+	return &State{
+		Provider: provider,
+		Value:    value,
+		Verifier: verifier,
+		Redirect: SafeRedirect(redirect),
+		Expires:  time.Now().Add(StateTTL),
+	}, nil
+}
+
+// Challenge returns the S256 PKCE challenge of the verifier
+func (s *State) Challenge() string {
+	sum := sha256.Sum256([]byte(s.Verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// Encode returns the signed cookie value
+func (s *State) Encode(secret []byte) (string, error) {
+	payload, err := json.Marshal(s)
+	if err != nil {
+		return "", err
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(payload)
+	return encoded + "." + sign(secret, encoded), nil
+}
+
+// DecodeState checks a cookie value against the provider and the state
+// the provider sent back to the callback
+func DecodeState(cookie string, secret []byte, provider, value string) (*State, error) {
+	encoded, signature, found := strings.Cut(cookie, ".")
+	if !found || !hmac.Equal([]byte(signature), []byte(sign(secret, encoded))) {
+		return nil, ErrInvalidState
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidState
+	}
+	var state State
+	if err := json.Unmarshal(payload, &state); err != nil {
+		return nil, ErrInvalidState
+	}
+	if state.Provider != provider || value == "" ||
+		!hmac.Equal([]byte(state.Value), []byte(value)) || time.Now().After(state.Expires) {
+		return nil, ErrInvalidState
+	}
+	return &state, nil
+}
+
+// SafeRedirect keeps redirects after sign in on this site
+func SafeRedirect(redirect string) string {
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") ||
+		strings.HasPrefix(redirect, "/\\") {
+		return "/"
+	}
+	return redirect
+}
+
+func sign(secret []byte, value string) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte("oauth-state:" + value))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func randomString(size int) (string, error) {
+	b := make([]byte, size)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
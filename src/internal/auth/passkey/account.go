@@ -0,0 +1,143 @@
+package passkey
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// account is a user and their credentials as the WebAuthn library sees
+// them. The user handle is the user's ID, which is random and never
+// changes, so it identifies the account without revealing its name.
+type account struct {
+	user        *models.User
+	records     []models.Credential
+	credentials []webauthn.Credential
+}
+
+// loadAccount reads a user's credentials
+func (s *Service) loadAccount(user *models.User) (*account, error) {
+	records, err := s.Credentials(user.ID)
+	if err != nil {
+		return nil, err
+	}
+	a := &account{user: user, records: records}
+	for _, record := range records {
+		credential, err := toWebAuthn(record)
+		if err != nil {
+			return nil, err
+		}
+		a.credentials = append(a.credentials, credential)
+	}
+	return a, nil
+}
+
+func (a *account) WebAuthnID() []byte {
+	id := a.user.ID
+	return id[:]
+}
+
+func (a *account) WebAuthnName() string {
+	return a.user.Username
+}
+
+func (a *account) WebAuthnDisplayName() string {
+	if a.user.DisplayName != "" {
+		return a.user.DisplayName
+	}
+	return a.user.Username
+}
+
+func (a *account) WebAuthnCredentials() []webauthn.Credential {
+	return a.credentials
+}
+
+// descriptors lists the account's credentials for the browser
+func (a *account) descriptors() []protocol.CredentialDescriptor {
+	descriptors := make([]protocol.CredentialDescriptor, 0, len(a.credentials))
+	for _, credential := range a.credentials {
+		descriptors = append(descriptors, credential.Descriptor())
+	}
+	return descriptors
+}
+
+// record returns the stored credential with a credential ID
+func (a *account) record(id []byte) *models.Credential {
+	encoded := encode(id)
+	for i := range a.records {
+		if a.records[i].CredentialID == encoded {
+			return &a.records[i]
+		}
+	}
+	return nil
+}
+
+// toWebAuthn converts a stored credential for the WebAuthn library
+func toWebAuthn(record models.Credential) (webauthn.Credential, error) {
+	id, err := decode(record.CredentialID)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("invalid credential ID of security key %s: %w", record.ID, err)
+	}
+	publicKey, err := decode(record.PublicKey)
+	if err != nil {
+		return webauthn.Credential{}, fmt.Errorf("invalid public key of security key %s: %w", record.ID, err)
+	}
+	credential := webauthn.Credential{
+		ID:              id,
+		PublicKey:       publicKey,
+		AttestationType: record.AttestationType,
+		Flags: webauthn.CredentialFlags{
+			BackupEligible: record.BackupEligible,
+			BackupState:    record.BackupState,
+		},
+		Authenticator: webauthn.Authenticator{
+			SignCount: uint32(record.SignCount),
+		},
+	}
+	if aaguid, err := uuid.Parse(record.AAGUID); err == nil {
+		credential.Authenticator.AAGUID = aaguid[:]
+	}
+	for _, transport := range strings.Split(record.Transports, ",") {
+		if transport != "" {
+			credential.Transport = append(credential.Transport, protocol.AuthenticatorTransport(transport))
+		}
+	}
+	return credential, nil
+}
+
+// fromWebAuthn converts a newly registered credential for storage
+func fromWebAuthn(userID uuid.UUID, name string, credential *webauthn.Credential) *models.Credential {
+	transports := make([]string, 0, len(credential.Transport))
+	for _, transport := range credential.Transport {
+		transports = append(transports, string(transport))
+	}
+	record := &models.Credential{
+		UserID:          userID,
+		Name:            name,
+		CredentialID:    encode(credential.ID),
+		PublicKey:       encode(credential.PublicKey),
+		AttestationType: credential.AttestationType,
+		Transports:      strings.Join(transports, ","),
+		SignCount:       int64(credential.Authenticator.SignCount),
+		BackupEligible:  credential.Flags.BackupEligible,
+		BackupState:     credential.Flags.BackupState,
+	}
+	if aaguid, err := uuid.FromBytes(credential.Authenticator.AAGUID); err == nil {
+		record.AAGUID = aaguid.String()
+	}
+	return record
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decode(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(s)
+}
@@ -0,0 +1,289 @@
+package passkey
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// BeginRegistration starts registering a credential for a signed in user.
+// The browser is asked for a passkey where it can make one, so the
+// credential can also sign in without a password.
+func (s *Service) BeginRegistration(user *models.User, origin string) (*protocol.CredentialCreation, string, error) {
+	return s.beginRegistration(user, origin, purposeRegister)
+}
+
+// BeginEnrollment starts registering the first credential of a user who
+// must have one and has just proven who they are with their password.
+// Finishing it needs no session, so the user can sign in.
+func (s *Service) BeginEnrollment(user *models.User, origin string) (*protocol.CredentialCreation, string, error) {
+	return s.beginRegistration(user, origin, purposeEnroll)
+}
+
+func (s *Service) beginRegistration(user *models.User, origin, purpose string) (*protocol.CredentialCreation, string, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	acct, err := s.loadAccount(user)
+	if err != nil {
+		return nil, "", err
+	}
+
+	creation, session, err := rp.BeginRegistration(acct,
+		webauthn.WithExclusions(acct.descriptors()),
+		webauthn.WithAuthenticatorSelection(protocol.AuthenticatorSelection{
+			ResidentKey:        protocol.ResidentKeyRequirementPreferred,
+			RequireResidentKey: protocol.ResidentKeyNotRequired(),
+			UserVerification:   protocol.VerificationPreferred,
+		}),
+	)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start registration: %w", err)
+	}
+	id, err := s.saveCeremony(purpose, &user.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return creation, id, nil
+}
+
+// FinishRegistration verifies the browser's response to a registration and
+// saves the credential. userID is the signed in user; without one only an
+// enrollment can be finished.
+func (s *Service) FinishRegistration(origin, ceremonyID string, userID *uuid.UUID, name string, response []byte) (*models.Credential, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, err
+	}
+	purposes := []string{purposeEnroll}
+	if userID != nil {
+		purposes = append(purposes, purposeRegister)
+	}
+	ceremony, session, err := s.takeCeremony(ceremonyID, purposes...)
+	if err != nil {
+		return nil, err
+	}
+	if ceremony.UserID == nil || (userID != nil && *ceremony.UserID != *userID) {
+		return nil, ErrInvalidCeremony
+	}
+
+	var user models.User
+	if err := s.db.First(&user, "id = ?", *ceremony.UserID).Error; err != nil {
+		return nil, ErrInvalidCeremony
+	}
+	acct, err := s.loadAccount(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := protocol.ParseCredentialCreationResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	credential, err := rp.CreateCredential(acct, *session, parsed)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+	if acct.record(credential.ID) != nil {
+		return nil, fmt.Errorf("%w: the security key is already registered", ErrVerification)
+	}
+
+	name = strings.TrimSpace(name)
+	if name == "" {
+		name = defaultCredentialName
+	}
+	if runes := []rune(name); len(runes) > 100 {
+		name = string(runes[:100])
+	}
+	record := fromWebAuthn(user.ID, name, credential)
+	if err := s.db.Create(record).Error; err != nil {
+		return nil, fmt.Errorf("failed to save security key: %w", err)
+	}
+	return record, nil
+}
+
+// BeginLogin starts a passwordless sign in. The browser offers the
+// passkeys it holds for the site and the user picks one, so no username
+// is needed. The authenticator must verify the user with a PIN or
+// biometric, since nothing else is checked.
+func (s *Service) BeginLogin(origin string) (*protocol.CredentialAssertion, string, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	assertion, session, err := rp.BeginDiscoverableLogin(webauthn.WithUserVerification(protocol.VerificationRequired))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start sign in: %w", err)
+	}
+	id, err := s.saveCeremony(purposeLogin, nil, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, id, nil
+}
+
+// BeginSecondFactor starts checking a user's credential after their
+// password. Any of their credentials will do, and touching it is enough.
+func (s *Service) BeginSecondFactor(user *models.User, origin string) (*protocol.CredentialAssertion, string, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, "", err
+	}
+	acct, err := s.loadAccount(user)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(acct.credentials) == 0 {
+		return nil, "", ErrNoCredentials
+	}
+	assertion, session, err := rp.BeginLogin(acct, webauthn.WithUserVerification(protocol.VerificationDiscouraged))
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to start sign in: %w", err)
+	}
+	id, err := s.saveCeremony(purposeSecondFactor, &user.ID, session)
+	if err != nil {
+		return nil, "", err
+	}
+	return assertion, id, nil
+}
+
+// FinishLogin verifies the browser's response to a passwordless sign in or
+// a second factor check and returns the user who signed in
+func (s *Service) FinishLogin(origin, ceremonyID string, response []byte) (*models.User, error) {
+	rp, err := s.relyingParty(origin)
+	if err != nil {
+		return nil, err
+	}
+	ceremony, session, err := s.takeCeremony(ceremonyID, purposeLogin, purposeSecondFactor)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := protocol.ParseCredentialRequestResponseBytes(response)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	var (
+		acct       *account
+		credential *webauthn.Credential
+	)
+	if ceremony.Purpose == purposeLogin {
+		// The user handle the authenticator returns names the account
+		lookup := func(rawID, userHandle []byte) (webauthn.User, error) {
+			userID, err := uuid.FromBytes(userHandle)
+			if err != nil {
+				return nil, ErrCredentialNotFound
+			}
+			var user models.User
+			if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+				return nil, ErrCredentialNotFound
+			}
+			if acct, err = s.loadAccount(&user); err != nil {
+				return nil, err
+			}
+			return acct, nil
+		}
+		_, credential, err = rp.ValidatePasskeyLogin(lookup, *session, parsed)
+	} else {
+		var user models.User
+		if ceremony.UserID == nil || s.db.First(&user, "id = ?", *ceremony.UserID).Error != nil {
+			return nil, ErrInvalidCeremony
+		}
+		if acct, err = s.loadAccount(&user); err != nil {
+			return nil, err
+		}
+		credential, err = rp.ValidateLogin(acct, *session, parsed)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrVerification, err)
+	}
+
+	// A signature counter that went backwards means the credential's key
+	// may have been copied
+	if credential.Authenticator.CloneWarning {
+		return nil, fmt.Errorf("%w: the signature counter went backwards", ErrVerification)
+	}
+	record := acct.record(credential.ID)
+	if record == nil {
+		return nil, ErrCredentialNotFound
+	}
+	now := time.Now()
+	s.db.Model(record).Updates(map[string]interface{}{
+		"sign_count":   int64(credential.Authenticator.SignCount),
+		"backup_state": credential.Flags.BackupState,
+		"last_used_at": now,
+	})
+	return acct.user, nil
+}
+
+// saveCeremony keeps the session data of a started ceremony and returns
+// the ID that finishes it. Expired ceremonies are cleared out on the way.
+func (s *Service) saveCeremony(purpose string, userID *uuid.UUID, session *webauthn.SessionData) (string, error) {
+	data, err := json.Marshal(session)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode session: %w", err)
+	}
+	now := time.Now()
+	s.db.Where("expires_at < ?", now).Delete(&models.WebAuthnSession{})
+
+	ceremony := &models.WebAuthnSession{
+		UserID:    userID,
+		Purpose:   purpose,
+		Data:      string(data),
+		ExpiresAt: now.Add(ceremonyTTL),
+	}
+	if err := s.db.Create(ceremony).Error; err != nil {
+		return "", fmt.Errorf("failed to save session: %w", err)
+	}
+	return ceremony.ID.String(), nil
+}
+
+// takeCeremony loads a ceremony with one of the given purposes and deletes
+// it, so it can be finished once even by concurrent requests
+func (s *Service) takeCeremony(id string, purposes ...string) (*models.WebAuthnSession, *webauthn.SessionData, error) {
+	ceremonyID, err := uuid.Parse(id)
+	if err != nil {
+		return nil, nil, ErrInvalidCeremony
+	}
+	var ceremony models.WebAuthnSession
+	if err := s.db.First(&ceremony, "id = ?", ceremonyID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, nil, ErrInvalidCeremony
+		}
+		return nil, nil, fmt.Errorf("failed to load session: %w", err)
+	}
+	result := s.db.Where("id = ?", ceremony.ID).Delete(&models.WebAuthnSession{})
+	if result.Error != nil {
+		return nil, nil, fmt.Errorf("failed to remove session: %w", result.Error)
+	}
+	if result.RowsAffected != 1 || ceremony.IsExpired() {
+		return nil, nil, ErrInvalidCeremony
+	}
+
+	allowed := false
+	for _, purpose := range purposes {
+		if ceremony.Purpose == purpose {
+			allowed = true
+			break
+		}
+	}
+	if !allowed {
+		return nil, nil, ErrInvalidCeremony
+	}
+
+	var session webauthn.SessionData
+	if err := json.Unmarshal([]byte(ceremony.Data), &session); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode session: %w", err)
+	}
+	return &ceremony, &session, nil
+}
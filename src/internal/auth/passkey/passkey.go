@@ -0,0 +1,234 @@
+// Package passkey signs users in with WebAuthn credentials: hardware
+// security keys and platform passkeys. A credential is either the second
+// factor after a password or, when the authenticator verifies the user
+// itself, a passwordless sign in. Administrators can require a credential
+// for admin accounts or for everyone.
+//
+// Each ceremony is started by one request and finished by another. The
+// challenge is kept in a WebAuthnSession row in between and is deleted
+// when the ceremony is finished, so it can't be replayed.
+package passkey
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-webauthn/webauthn/webauthn"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// WebAuthn settings. The requirement can also be saved through the admin
+// settings API, which takes precedence over the config file.
+const (
+	ConfigKeyEnabled = "security.webauthn.enabled"
+	ConfigKeyRPID    = "security.webauthn.rp_id"   // Defaults to the host of server.url
+	ConfigKeyRPName  = "security.webauthn.rp_name" // Shown by the browser when registering
+	ConfigKeyOrigins = "security.webauthn.origins" // Defaults to the origin of server.url
+	ConfigKeyRequire = "security.webauthn.require" // off, admins or all
+)
+
+// Requirement levels for ConfigKeyRequire
+const (
+	RequireOff    = "off"
+	RequireAdmins = "admins"
+	RequireAll    = "all"
+)
+
+// Ceremony purposes
+const (
+	purposeRegister     = "register"
+	purposeEnroll       = "enroll"
+	purposeLogin        = "login"
+	purposeSecondFactor = "second_factor"
+)
+
+// ceremonyTTL is how long a started registration or sign in can be
+// finished. Browsers time the prompt out sooner.
+const ceremonyTTL = 5 * time.Minute
+
+// defaultCredentialName names a credential registered without a name
+const defaultCredentialName = "Security key"
+
+var (
+	// ErrDisabled is returned when WebAuthn is turned off
+	ErrDisabled = errors.New("security keys are disabled")
+	// ErrInvalidCeremony is returned for a ceremony that doesn't exist,
+	// expired, was already finished or belongs to someone else
+	ErrInvalidCeremony = errors.New("the security key request expired or was already used")
+	// ErrVerification is returned when the browser's response doesn't
+	// verify
+	ErrVerification = errors.New("the security key could not be verified")
+	// ErrNoCredentials is returned when a user has no credential to sign
+	// in with
+	ErrNoCredentials = errors.New("no security keys are registered")
+	// ErrCredentialNotFound is returned for a credential the user doesn't
+	// have
+	ErrCredentialNotFound = errors.New("security key not found")
+	// ErrLastRequiredCredential is returned when removing a credential
+	// would leave a user who must have one without any
+	ErrLastRequiredCredential = errors.New("a security key is required for this account, so the last one can't be removed")
+)
+
+// Service registers credentials and runs sign in ceremonies
+type Service struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+// NewService creates a new WebAuthn service
+func NewService(db *gorm.DB, config *config.Config) *Service {
+	return &Service{db: db, config: config}
+}
+
+// Enabled reports whether users can register and sign in with credentials
+func (s *Service) Enabled() bool {
+	return s.config.GetBool(ConfigKeyEnabled)
+}
+
+// Requirement returns who must sign in with a credential: RequireOff,
+// RequireAdmins or RequireAll
+func (s *Service) Requirement() string {
+	value := s.config.GetString(ConfigKeyRequire)
+	var setting models.SystemConfig
+	if err := s.db.Where("key = ?", ConfigKeyRequire).First(&setting).Error; err == nil {
+		value = setting.Value
+	}
+	switch value = strings.ToLower(strings.TrimSpace(value)); value {
+	case RequireAdmins, RequireAll:
+		return value
+	default:
+		return RequireOff
+	}
+}
+
+// Required reports whether a user must sign in with a credential. Neither
+// a password alone nor a password and authenticator app code is enough.
+func (s *Service) Required(user *models.User) bool {
+	if !s.Enabled() {
+		return false
+	}
+	switch s.Requirement() {
+	case RequireAll:
+		return true
+	case RequireAdmins:
+		return user.IsAdmin
+	default:
+		return false
+	}
+}
+
+// HasCredentials reports whether a user has registered a credential
+func (s *Service) HasCredentials(userID uuid.UUID) bool {
+	var count int64
+	s.db.Model(&models.Credential{}).Where("user_id = ?", userID).Count(&count)
+	return count > 0
+}
+
+// Credentials returns a user's credentials, oldest first
+func (s *Service) Credentials(userID uuid.UUID) ([]models.Credential, error) {
+	var credentials []models.Credential
+	if err := s.db.Where("user_id = ?", userID).Order("created_at ASC").Find(&credentials).Error; err != nil {
+		return nil, fmt.Errorf("failed to load security keys: %w", err)
+	}
+	return credentials, nil
+}
+
+// RemoveCredential deletes one of a user's credentials. A user who must
+// sign in with a credential keeps their last one.
+func (s *Service) RemoveCredential(user *models.User, id uuid.UUID) error {
+	var credential models.Credential
+	if err := s.db.Where("id = ? AND user_id = ?", id, user.ID).First(&credential).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrCredentialNotFound
+		}
+		return fmt.Errorf("failed to load security key: %w", err)
+	}
+	if s.Required(user) {
+		var count int64
+		s.db.Model(&models.Credential{}).Where("user_id = ?", user.ID).Count(&count)
+		if count <= 1 {
+			return ErrLastRequiredCredential
+		}
+	}
+	if err := s.db.Delete(&credential).Error; err != nil {
+		return fmt.Errorf("failed to remove security key: %w", err)
+	}
+	return nil
+}
+
+// relyingParty configures WebAuthn for this site. Credentials are bound to
+// the relying party ID, so server.url or security.webauthn.rp_id must stay
+// the same once users have registered credentials. Without either, the
+// origin the request came in on is used.
+func (s *Service) relyingParty(origin string) (*webauthn.WebAuthn, error) {
+	if !s.Enabled() {
+		return nil, ErrDisabled
+	}
+	if serverURL := s.config.GetString("server.url"); serverURL != "" {
+		if u, err := url.Parse(serverURL); err == nil && u.Host != "" {
+			origin = u.Scheme + "://" + u.Host
+		}
+	}
+
+	origins := s.config.GetStringSlice(ConfigKeyOrigins)
+	if len(origins) == 0 {
+		origins = []string{origin}
+	}
+	rpID := s.config.GetString(ConfigKeyRPID)
+	if rpID == "" {
+		u, err := url.Parse(origins[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid origin %q: %w", origins[0], err)
+		}
+		rpID = u.Hostname()
+	}
+	name := s.config.GetString(ConfigKeyRPName)
+	if name == "" {
+		name = "CasGists"
+	}
+
+	rp, err := webauthn.New(&webauthn.Config{
+		RPID:          rpID,
+		RPDisplayName: name,
+		RPOrigins:     origins,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid WebAuthn configuration: %w", err)
+	}
+	return rp, nil
+}
+
+// SettingsDefaults returns the WebAuthn settings from the config file, for
+// the admin settings API
+func SettingsDefaults(config *config.Config) map[string]interface{} {
+	require := config.GetString(ConfigKeyRequire)
+	if require == "" {
+		require = RequireOff
+	}
+	return map[string]interface{}{
+		ConfigKeyRequire: require,
+	}
+}
+
+// ValidateSetting checks a value saved through the admin settings API.
+// Other keys are accepted unchanged.
+func ValidateSetting(key string, value interface{}) error {
+	switch key {
+	case ConfigKeyRequire:
+		switch strings.ToLower(strings.TrimSpace(fmt.Sprintf("%v", value))) {
+		case RequireOff, RequireAdmins, RequireAll:
+		default:
+			return fmt.Errorf("%s must be off, admins or all", key)
+		}
+	case ConfigKeyEnabled, ConfigKeyRPID, ConfigKeyRPName, ConfigKeyOrigins:
+		return fmt.Errorf("%s can only be set in the config file", key)
+	}
+	return nil
+}
@@ -0,0 +1,306 @@
+package passkey
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-webauthn/webauthn/protocol"
+	"github.com/go-webauthn/webauthn/protocol/webauthncbor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+const testOrigin = "https://gists.example.com"
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+func newTestService(t *testing.T) (*Service, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}, &models.User{}, &models.Credential{}, &models.WebAuthnSession{}))
+
+	config := config.New()
+	config.Set("server.url", testOrigin)
+	config.Set(ConfigKeyEnabled, true)
+	return NewService(db, config), db
+}
+
+func newTestUser(t *testing.T, db *gorm.DB, username string) *models.User {
+	user := &models.User{Username: username, Email: username + "@example.com", PasswordHash: "x", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	return user
+}
+
+// authenticator is a software security key holding one P-256 credential
+type authenticator struct {
+	key        *ecdsa.PrivateKey
+	id         []byte
+	userHandle []byte
+	origin     string
+	counter    uint32
+}
+
+func newAuthenticator(t *testing.T) *authenticator {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	id := make([]byte, 16)
+	_, err = rand.Read(id)
+	require.NoError(t, err)
+	return &authenticator{key: key, id: id, origin: testOrigin}
+}
+
+func (a *authenticator) clientData(t *testing.T, kind string, challenge protocol.URLEncodedBase64) []byte {
+	data, err := json.Marshal(map[string]string{
+		"type":      kind,
+		"challenge": challenge.String(),
+		"origin":    a.origin,
+	})
+	require.NoError(t, err)
+	return data
+}
+
+func (a *authenticator) authData(rpID string, flags byte) []byte {
+	rpIDHash := sha256.Sum256([]byte(rpID))
+	data := append(rpIDHash[:], flags)
+	return binary.BigEndian.AppendUint32(data, a.counter)
+}
+
+// register answers a registration with "none" attestation
+func (a *authenticator) register(t *testing.T, options *protocol.CredentialCreation) []byte {
+	a.userHandle = options.Response.User.ID.(protocol.URLEncodedBase64)
+
+	x := a.key.PublicKey.X.FillBytes(make([]byte, 32))
+	y := a.key.PublicKey.Y.FillBytes(make([]byte, 32))
+	publicKey, err := webauthncbor.Marshal(map[int]interface{}{1: 2, 3: -7, -1: 1, -2: x, -3: y})
+	require.NoError(t, err)
+
+	authData := a.authData(options.Response.RelyingParty.ID, flagUserPresent|flagUserVerified|flagAttested)
+	authData = append(authData, make([]byte, 16)...) // AAGUID
+	authData = binary.BigEndian.AppendUint16(authData, uint16(len(a.id)))
+	authData = append(authData, a.id...)
+	authData = append(authData, publicKey...)
+
+	attestation, err := webauthncbor.Marshal(map[string]interface{}{
+		"fmt":      "none",
+		"attStmt":  map[string]interface{}{},
+		"authData": authData,
+	})
+	require.NoError(t, err)
+
+	response, err := json.Marshal(map[string]interface{}{
+		"id":    encode(a.id),
+		"rawId": encode(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(a.clientData(t, "webauthn.create", options.Response.Challenge)),
+			"attestationObject": encode(attestation),
+		},
+	})
+	require.NoError(t, err)
+	return response
+}
+
+// assert signs a sign in challenge
+func (a *authenticator) assert(t *testing.T, options *protocol.CredentialAssertion, flags byte) []byte {
+	a.counter++
+	clientData := a.clientData(t, "webauthn.get", options.Response.Challenge)
+	authData := a.authData(options.Response.RelyingPartyID, flags)
+	clientDataHash := sha256.Sum256(clientData)
+	digest := sha256.Sum256(append(append([]byte{}, authData...), clientDataHash[:]...))
+	signature, err := ecdsa.SignASN1(rand.Reader, a.key, digest[:])
+	require.NoError(t, err)
+
+	response, err := json.Marshal(map[string]interface{}{
+		"id":    encode(a.id),
+		"rawId": encode(a.id),
+		"type":  "public-key",
+		"response": map[string]string{
+			"clientDataJSON":    encode(clientData),
+			"authenticatorData": encode(authData),
+			"signature":         encode(signature),
+			"userHandle":        encode(a.userHandle),
+		},
+	})
+	require.NoError(t, err)
+	return response
+}
+
+// registerKey registers a new authenticator for a signed in user
+func registerKey(t *testing.T, service *Service, user *models.User) *authenticator {
+	options, ceremonyID, err := service.BeginRegistration(user, "http://ignored.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, "gists.example.com", options.Response.RelyingParty.ID)
+
+	key := newAuthenticator(t)
+	credential, err := service.FinishRegistration(testOrigin, ceremonyID, &user.ID, "", key.register(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, defaultCredentialName, credential.Name)
+	assert.Equal(t, encode(key.id), credential.CredentialID)
+	return key
+}
+
+func TestRegistrationAndSecondFactor(t *testing.T) {
+	service, db := newTestService(t)
+	user := newTestUser(t, db, "alice")
+	assert.False(t, service.HasCredentials(user.ID))
+
+	key := registerKey(t, service, user)
+	assert.True(t, service.HasCredentials(user.ID))
+
+	// The same key can't be registered twice
+	options, ceremonyID, err := service.BeginRegistration(user, testOrigin)
+	require.NoError(t, err)
+	require.Len(t, options.Response.CredentialExcludeList, 1)
+	_, err = service.FinishRegistration(testOrigin, ceremonyID, &user.ID, "Again", key.register(t, options))
+	assert.ErrorIs(t, err, ErrVerification)
+
+	// Touching the key is enough after a password
+	assertion, ceremonyID, err := service.BeginSecondFactor(user, testOrigin)
+	require.NoError(t, err)
+	require.Len(t, assertion.Response.AllowedCredentials, 1)
+	response := key.assert(t, assertion, flagUserPresent)
+	signedIn, err := service.FinishLogin(testOrigin, ceremonyID, response)
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, signedIn.ID)
+
+	credentials, err := service.Credentials(user.ID)
+	require.NoError(t, err)
+	require.Len(t, credentials, 1)
+	assert.Equal(t, int64(1), credentials[0].SignCount)
+	assert.NotNil(t, credentials[0].LastUsedAt)
+
+	// A ceremony is finished once
+	_, err = service.FinishLogin(testOrigin, ceremonyID, response)
+	assert.ErrorIs(t, err, ErrInvalidCeremony)
+
+	// Another user's key doesn't count
+	other := newTestUser(t, db, "bob")
+	_, _, err = service.BeginSecondFactor(other, testOrigin)
+	assert.ErrorIs(t, err, ErrNoCredentials)
+}
+
+func TestPasskeyLogin(t *testing.T) {
+	service, db := newTestService(t)
+	user := newTestUser(t, db, "alice")
+	key := registerKey(t, service, user)
+
+	// Without a username, the authenticator must verify the user
+	assertion, ceremonyID, err := service.BeginLogin(testOrigin)
+	require.NoError(t, err)
+	assert.Empty(t, assertion.Response.AllowedCredentials)
+	_, err = service.FinishLogin(testOrigin, ceremonyID, key.assert(t, assertion, flagUserPresent))
+	assert.ErrorIs(t, err, ErrVerification)
+
+	assertion, ceremonyID, err = service.BeginLogin(testOrigin)
+	require.NoError(t, err)
+	signedIn, err := service.FinishLogin(testOrigin, ceremonyID, key.assert(t, assertion, flagUserPresent|flagUserVerified))
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, signedIn.ID)
+
+	// A response made for another site doesn't verify
+	key.origin = "https://evil.example.com"
+	assertion, ceremonyID, err = service.BeginLogin(testOrigin)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(testOrigin, ceremonyID, key.assert(t, assertion, flagUserPresent|flagUserVerified))
+	assert.ErrorIs(t, err, ErrVerification)
+}
+
+func TestClonedKey(t *testing.T) {
+	service, db := newTestService(t)
+	user := newTestUser(t, db, "alice")
+	key := registerKey(t, service, user)
+
+	// The stored counter is ahead of the key, as when a copy of it was used
+	require.NoError(t, db.Model(&models.Credential{}).Where("user_id = ?", user.ID).Update("sign_count", 10).Error)
+	assertion, ceremonyID, err := service.BeginSecondFactor(user, testOrigin)
+	require.NoError(t, err)
+	_, err = service.FinishLogin(testOrigin, ceremonyID, key.assert(t, assertion, flagUserPresent))
+	assert.ErrorIs(t, err, ErrVerification)
+}
+
+func TestEnrollment(t *testing.T) {
+	service, db := newTestService(t)
+	user := newTestUser(t, db, "alice")
+
+	// A registration started by a signed in user needs that user to finish
+	options, ceremonyID, err := service.BeginRegistration(user, testOrigin)
+	require.NoError(t, err)
+	_, err = service.FinishRegistration(testOrigin, ceremonyID, nil, "", newAuthenticator(t).register(t, options))
+	assert.ErrorIs(t, err, ErrInvalidCeremony)
+
+	// The enrollment a password login starts doesn't
+	options, ceremonyID, err = service.BeginEnrollment(user, testOrigin)
+	require.NoError(t, err)
+	credential, err := service.FinishRegistration(testOrigin, ceremonyID, nil, " YubiKey ", newAuthenticator(t).register(t, options))
+	require.NoError(t, err)
+	assert.Equal(t, user.ID, credential.UserID)
+	assert.Equal(t, "YubiKey", credential.Name)
+
+	// Nor can another signed in user finish it
+	other := newTestUser(t, db, "bob")
+	options, ceremonyID, err = service.BeginEnrollment(user, testOrigin)
+	require.NoError(t, err)
+	_, err = service.FinishRegistration(testOrigin, ceremonyID, &other.ID, "", newAuthenticator(t).register(t, options))
+	assert.ErrorIs(t, err, ErrInvalidCeremony)
+}
+
+func TestRequirement(t *testing.T) {
+	service, db := newTestService(t)
+	admin := newTestUser(t, db, "admin")
+	require.NoError(t, db.Model(admin).Update("is_admin", true).Error)
+	user := newTestUser(t, db, "alice")
+
+	assert.Equal(t, RequireOff, service.Requirement())
+	assert.False(t, service.Required(admin))
+
+	service.config.Set(ConfigKeyRequire, RequireAdmins)
+	assert.True(t, service.Required(admin))
+	assert.False(t, service.Required(user))
+
+	// Admin settings take precedence over the config file
+	require.NoError(t, db.Create(&models.SystemConfig{Key: ConfigKeyRequire, Value: "all"}).Error)
+	assert.True(t, service.Required(user))
+
+	// The last key of an account that must have one stays
+	first := registerKey(t, service, user)
+	second := registerKey(t, service, user)
+	assert.NotEqual(t, first.id, second.id)
+	credentials, err := service.Credentials(user.ID)
+	require.NoError(t, err)
+	require.Len(t, credentials, 2)
+	require.NoError(t, service.RemoveCredential(user, credentials[0].ID))
+	assert.ErrorIs(t, service.RemoveCredential(user, credentials[1].ID), ErrLastRequiredCredential)
+	assert.ErrorIs(t, service.RemoveCredential(admin, credentials[1].ID), ErrCredentialNotFound)
+
+	service.config.Set(ConfigKeyEnabled, false)
+	assert.False(t, service.Required(user))
+	_, _, err = service.BeginLogin(testOrigin)
+	assert.ErrorIs(t, err, ErrDisabled)
+}
+
+func TestValidateSetting(t *testing.T) {
+	assert.NoError(t, ValidateSetting(ConfigKeyRequire, "admins"))
+	assert.NoError(t, ValidateSetting(ConfigKeyRequire, "OFF"))
+	assert.Error(t, ValidateSetting(ConfigKeyRequire, "everyone"))
+	assert.Error(t, ValidateSetting(ConfigKeyRPID, "example.com"))
+	assert.NoError(t, ValidateSetting("gist.max_files", 10))
+}
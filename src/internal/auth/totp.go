@@ -2,9 +2,13 @@ package auth
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/base32"
 	"encoding/base64"
 	"fmt"
 	"image/png"
+	"strings"
+	"unicode"
 
 	"github.com/pquerna/otp"
 	"github.com/pquerna/otp/totp"
@@ -35,7 +39,7 @@ func (t *TOTPService) GenerateTOTP(username string) (*TOTPSetup, error) {
 		Issuer:      t.issuer,
 		AccountName: username,
 		Period:      30,
-		SecretSize:  32,
+		SecretSize:  20, // 32 characters in base32, the size of users.two_factor_secret
 		Algorithm:   otp.AlgorithmSHA1,
 	})
 	if err != nil {
@@ -68,20 +72,29 @@ func (t *TOTPService) ValidateTOTP(secret, code string) bool {
 	return totp.Validate(code, secret)
 }
 
-// GenerateRecoveryCodes generates recovery codes for 2FA
+// GenerateRecoveryCodes generates recovery codes for 2FA. Each code is 80
+// random bits written as XXXX-XXXX-XXXX-XXXX in base32, which avoids the
+// letters and digits people mix up.
 func GenerateRecoveryCodes(count int) ([]string, error) {
 	codes := make([]string, count)
 	for i := 0; i < count; i++ {
-		code, err := GenerateSecureToken(4)
-		if err != nil {
+		bytes := make([]byte, 10)
+		if _, err := rand.Read(bytes); err != nil {
 			return nil, err
 		}
-		// Format as XXXX-XXXX for readability
-		if len(code) >= 8 {
-			codes[i] = fmt.Sprintf("%s-%s", code[:4], code[4:8])
-		} else {
-			codes[i] = code
-		}
+		code := base32.StdEncoding.EncodeToString(bytes)
+		codes[i] = fmt.Sprintf("%s-%s-%s-%s", code[:4], code[4:8], code[8:12], code[12:16])
 	}
 	return codes, nil
-}
\ No newline at end of file
+}
+
+// NormalizeRecoveryCode puts a recovery code as typed into the form it is
+// hashed in: upper case without dashes or spaces
+func NormalizeRecoveryCode(code string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '-' || unicode.IsSpace(r) {
+			return -1
+		}
+		return unicode.ToUpper(r)
+	}, code)
+}
@@ -0,0 +1,210 @@
+package automation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/webhook"
+)
+
+// Built-in action names
+const (
+	ActionSetVisibility      = "set_visibility"
+	ActionNotifyWebhook      = "notify_webhook"
+	ActionAssignOrganization = "assign_organization"
+)
+
+// EventRuleMatched is the webhook event sent by notify_webhook
+const EventRuleMatched = "automation.rule_matched"
+
+// Action is something a rule does to a newly created gist
+type Action struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Params      []string `json:"params"`
+	// UserOnly actions can't be used in organization rules
+	UserOnly bool `json:"user_only,omitempty"`
+
+	// validate checks the parameters when a rule is saved
+	validate func(db *gorm.DB, rule *models.AutomationRule, params map[string]string) error
+	// run applies the action to the gist
+	run func(ctx context.Context, e *Engine, rule *models.AutomationRule, gist *models.Gist, params map[string]string) error
+}
+
+var actions = map[string]Action{
+	ActionSetVisibility: {
+		Name:        ActionSetVisibility,
+		Description: "Change the gist's visibility",
+		Params:      []string{"visibility"},
+		validate:    validateSetVisibility,
+		run:         runSetVisibility,
+	},
+	ActionNotifyWebhook: {
+		Name:        ActionNotifyWebhook,
+		Description: "Send an " + EventRuleMatched + " event to one of the owner's webhooks",
+		Params:      []string{"webhook_id"},
+		validate:    validateNotifyWebhook,
+		run:         runNotifyWebhook,
+	},
+	ActionAssignOrganization: {
+		Name:        ActionAssignOrganization,
+		Description: "Move the gist into an organization the owner can create gists in",
+		Params:      []string{"organization"},
+		UserOnly:    true,
+		validate:    validateAssignOrganization,
+		run:         runAssignOrganization,
+	},
+}
+
+// Actions returns the available actions sorted by name
+func Actions() []Action {
+	list := make([]Action, 0, len(actions))
+	for _, action := range actions {
+		list = append(list, action)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// LookupAction returns the named action
+func LookupAction(name string) (Action, bool) {
+	action, ok := actions[name]
+	return action, ok
+}
+
+func validateSetVisibility(db *gorm.DB, rule *models.AutomationRule, params map[string]string) error {
+	switch models.Visibility(params["visibility"]) {
+	case models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityUnlisted:
+		return nil
+	}
+	return fmt.Errorf("%w: visibility must be public, private or unlisted", ErrInvalidRule)
+}
+
+func runSetVisibility(ctx context.Context, e *Engine, rule *models.AutomationRule, gist *models.Gist, params map[string]string) error {
+	return e.db.WithContext(ctx).Model(&models.Gist{}).
+		Where("id = ?", gist.ID).
+		Update("visibility", params["visibility"]).Error
+}
+
+func validateNotifyWebhook(db *gorm.DB, rule *models.AutomationRule, params map[string]string) error {
+	_, err := ruleWebhook(db, rule, params["webhook_id"])
+	return err
+}
+
+func runNotifyWebhook(ctx context.Context, e *Engine, rule *models.AutomationRule, gist *models.Gist, params map[string]string) error {
+	wh, err := ruleWebhook(e.db, rule, params["webhook_id"])
+	if err != nil {
+		return err
+	}
+
+	payload := &webhook.WebhookPayload{
+		Event:     EventRuleMatched,
+		Timestamp: time.Now(),
+		Server: map[string]interface{}{
+			"name": e.config.GetString("app.name"),
+			"url":  e.config.GetString("server.url"),
+		},
+		Data: map[string]interface{}{
+			"rule": map[string]interface{}{
+				"id":   rule.ID.String(),
+				"name": rule.Name,
+				"tag":  rule.Tag,
+			},
+			"gist": map[string]interface{}{
+				"id":         gist.ID.String(),
+				"title":      gist.Title,
+				"visibility": string(gist.Visibility),
+				"tags":       gist.TagList(),
+				"created_at": gist.CreatedAt,
+			},
+		},
+	}
+	return e.deliveries.DeliverWebhook(ctx, wh, payload)
+}
+
+// ruleWebhook loads an active webhook belonging to the rule's owner. Gist
+// webhooks only receive events about their own gist, so they can't be used.
+func ruleWebhook(db *gorm.DB, rule *models.AutomationRule, rawID string) (*models.Webhook, error) {
+	webhookID, err := uuid.Parse(rawID)
+	if err != nil {
+		return nil, fmt.Errorf("%w: webhook_id must be a webhook ID", ErrInvalidRule)
+	}
+
+	query := db.Where("id = ? AND gist_id IS NULL", webhookID)
+	if rule.OrganizationID != nil {
+		query = query.Where("organization_id = ?", *rule.OrganizationID)
+	} else {
+		query = query.Where("user_id = ?", rule.UserID)
+	}
+
+	var wh models.Webhook
+	if err := query.First(&wh).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("%w: webhook not found", ErrInvalidRule)
+		}
+		return nil, err
+	}
+	if !wh.IsActive {
+		return nil, fmt.Errorf("%w: webhook is not active", ErrInvalidRule)
+	}
+	return &wh, nil
+}
+
+func validateAssignOrganization(db *gorm.DB, rule *models.AutomationRule, params map[string]string) error {
+	_, _, err := ruleOrganization(db, rule, params["organization"])
+	return err
+}
+
+func runAssignOrganization(ctx context.Context, e *Engine, rule *models.AutomationRule, gist *models.Gist, params map[string]string) error {
+	org, user, err := ruleOrganization(e.db, rule, params["organization"])
+	if err != nil {
+		return err
+	}
+
+	// The organization's policies apply as if the gist was created there
+	visibility, err := services.NewOrgPolicyService(e.db).AuthorizeGistCreate(org.ID, user, gist.Name, string(gist.Visibility))
+	if err != nil {
+		return err
+	}
+
+	return e.db.WithContext(ctx).Model(&models.Gist{}).
+		Where("id = ? AND user_id = ?", gist.ID, user.ID).
+		Updates(map[string]interface{}{
+			"user_id":         nil,
+			"organization_id": org.ID,
+			"visibility":      visibility,
+		}).Error
+}
+
+// ruleOrganization resolves the organization of an assign_organization rule
+// and checks that the rule's owner is a member of it
+func ruleOrganization(db *gorm.DB, rule *models.AutomationRule, name string) (*models.Organization, *models.User, error) {
+	if rule.UserID == nil {
+		return nil, nil, fmt.Errorf("%w: %s can only be used in your own rules", ErrInvalidRule, ActionAssignOrganization)
+	}
+	if name == "" {
+		return nil, nil, fmt.Errorf("%w: organization is required", ErrInvalidRule)
+	}
+
+	var user models.User
+	if err := db.First(&user, "id = ?", *rule.UserID).Error; err != nil {
+		return nil, nil, err
+	}
+	policy := services.NewOrgPolicyService(db)
+	org, err := policy.FindOrganization(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := policy.AuthorizeMember(org.ID, &user); err != nil {
+		return nil, nil, err
+	}
+	return org, &user, nil
+}
@@ -0,0 +1,278 @@
+package automation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/webhook"
+)
+
+// ErrInvalidRule is returned when a rule can't be saved as given
+var ErrInvalidRule = errors.New("invalid automation rule")
+
+// batchSize bounds how many queued runs are picked up per tick
+const batchSize = 50
+
+// Engine executes queued automation runs. Runs are rows in automation_runs,
+// so they survive restarts and several processes can share the queue.
+type Engine struct {
+	db         *gorm.DB
+	config     *config.Config
+	deliveries *webhook.DeliveryService
+	stop       chan bool
+}
+
+// NewEngine creates a new automation engine
+func NewEngine(db *gorm.DB, config *config.Config) *Engine {
+	return &Engine{
+		db:         db,
+		config:     config,
+		deliveries: webhook.NewDeliveryService(db),
+		stop:       make(chan bool, 1),
+	}
+}
+
+// ValidateRule normalizes a rule and checks its action parameters
+func ValidateRule(db *gorm.DB, rule *models.AutomationRule) error {
+	rule.Name = strings.TrimSpace(rule.Name)
+	if rule.Name == "" || len(rule.Name) > 100 {
+		return fmt.Errorf("%w: name is required and at most 100 characters", ErrInvalidRule)
+	}
+	tags := models.NormalizeTags([]string{rule.Tag})
+	if len(tags) == 0 || len(tags[0]) > 50 || strings.Contains(tags[0], ",") {
+		return fmt.Errorf("%w: tag is required, at most 50 characters and without commas", ErrInvalidRule)
+	}
+	rule.Tag = tags[0]
+
+	action, ok := LookupAction(rule.Action)
+	if !ok {
+		return fmt.Errorf("%w: unknown action %q", ErrInvalidRule, rule.Action)
+	}
+	if action.UserOnly && rule.OrganizationID != nil {
+		return fmt.Errorf("%w: %s can only be used in your own rules", ErrInvalidRule, action.Name)
+	}
+	params, err := RuleParams(rule)
+	if err != nil {
+		return err
+	}
+	return action.validate(db, rule, params)
+}
+
+// RuleParams decodes a rule's action parameters
+func RuleParams(rule *models.AutomationRule) (map[string]string, error) {
+	params := map[string]string{}
+	if rule.Params == "" {
+		return params, nil
+	}
+	if err := json.Unmarshal([]byte(rule.Params), &params); err != nil {
+		return nil, fmt.Errorf("%w: params must be an object of strings", ErrInvalidRule)
+	}
+	return params, nil
+}
+
+// Enqueue queues a run for every enabled rule of the gist's owner matching
+// one of its tags. It is called right after a gist is created.
+func Enqueue(db *gorm.DB, gist *models.Gist, actorID *uuid.UUID) (int, error) {
+	tags := gist.TagList()
+	if len(tags) == 0 {
+		return 0, nil
+	}
+
+	query := db.Where("enabled = ? AND tag IN ?", true, tags)
+	switch {
+	case gist.OrganizationID != nil:
+		query = query.Where("organization_id = ?", *gist.OrganizationID)
+	case gist.UserID != nil:
+		query = query.Where("user_id = ?", *gist.UserID)
+	default:
+		return 0, nil
+	}
+
+	var rules []models.AutomationRule
+	if err := query.Order("created_at").Find(&rules).Error; err != nil {
+		return 0, fmt.Errorf("failed to find automation rules: %w", err)
+	}
+	for _, rule := range rules {
+		run := models.AutomationRun{
+			RuleID:  rule.ID,
+			GistID:  gist.ID,
+			ActorID: actorID,
+			Status:  models.AutomationRunPending,
+		}
+		if err := db.Create(&run).Error; err != nil {
+			return 0, fmt.Errorf("failed to queue automation run: %w", err)
+		}
+	}
+	return len(rules), nil
+}
+
+// ProcessPending executes the runs that are due and returns how many were
+// picked up
+func (e *Engine) ProcessPending(ctx context.Context) (int, error) {
+	var runs []models.AutomationRun
+	if err := e.db.WithContext(ctx).
+		Where("status = ? AND run_after <= ?", models.AutomationRunPending, time.Now()).
+		Order("created_at").
+		Limit(batchSize).
+		Find(&runs).Error; err != nil {
+		return 0, fmt.Errorf("failed to load automation runs: %w", err)
+	}
+
+	processed := 0
+	for i := range runs {
+		if ctx.Err() != nil {
+			break
+		}
+		run := &runs[i]
+
+		// Claim the run; another process may have got there first
+		claim := e.db.WithContext(ctx).Model(&models.AutomationRun{}).
+			Where("id = ? AND status = ? AND attempts = ?", run.ID, models.AutomationRunPending, run.Attempts).
+			Update("attempts", run.Attempts+1)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		run.Attempts++
+
+		e.finish(ctx, run, e.execute(ctx, run))
+		processed++
+	}
+	return processed, nil
+}
+
+func (e *Engine) execute(ctx context.Context, run *models.AutomationRun) error {
+	var rule models.AutomationRule
+	if err := e.db.WithContext(ctx).First(&rule, "id = ?", run.RuleID).Error; err != nil {
+		return permanent(fmt.Errorf("rule no longer exists"))
+	}
+	if !rule.Enabled {
+		return permanent(fmt.Errorf("rule is disabled"))
+	}
+	var gist models.Gist
+	if err := e.db.WithContext(ctx).First(&gist, "id = ?", run.GistID).Error; err != nil {
+		return permanent(fmt.Errorf("gist no longer exists"))
+	}
+
+	action, ok := LookupAction(rule.Action)
+	if !ok {
+		return permanent(fmt.Errorf("unknown action %q", rule.Action))
+	}
+	params, err := RuleParams(&rule)
+	if err != nil {
+		return permanent(err)
+	}
+	if err := action.run(ctx, e, &rule, &gist, params); err != nil {
+		if errors.Is(err, ErrInvalidRule) {
+			return permanent(err)
+		}
+		return err
+	}
+
+	// The action is done, so a failure here must not cause a retry
+	if err := e.db.WithContext(ctx).Model(&models.AutomationRule{}).
+		Where("id = ?", rule.ID).
+		Updates(map[string]interface{}{
+			"run_count":   gorm.Expr("run_count + ?", 1),
+			"last_run_at": time.Now(),
+		}).Error; err != nil {
+		slog.Error("Failed to update automation rule", "rule", rule.ID, "error", err)
+	}
+	return nil
+}
+
+// finish records the outcome of a run, rescheduling it after a temporary
+// failure until max_attempts is reached
+func (e *Engine) finish(ctx context.Context, run *models.AutomationRun, err error) {
+	now := time.Now()
+	updates := map[string]interface{}{"finished_at": now, "error": ""}
+	switch {
+	case err == nil:
+		updates["status"] = models.AutomationRunSucceeded
+	case isPermanent(err) || run.Attempts >= e.maxAttempts():
+		updates["status"] = models.AutomationRunFailed
+		updates["error"] = err.Error()
+	default:
+		updates = map[string]interface{}{
+			"error":     err.Error(),
+			"run_after": now.Add(e.retryDelay() * time.Duration(run.Attempts)),
+		}
+	}
+	if err := e.db.WithContext(ctx).Model(&models.AutomationRun{}).Where("id = ?", run.ID).Updates(updates).Error; err != nil {
+		slog.Error("Failed to record automation run", "run", run.ID, "error", err)
+	}
+}
+
+// Start runs the queue until the context is cancelled or Stop is called
+func (e *Engine) Start(ctx context.Context) {
+	ticker := time.NewTicker(e.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-e.stop:
+			return
+		case <-ticker.C:
+			if _, err := e.ProcessPending(ctx); err != nil {
+				slog.Error("Automation run processing failed", "error", err)
+			}
+		}
+	}
+}
+
+// Stop stops the queue
+func (e *Engine) Stop() {
+	select {
+	case e.stop <- true:
+	default:
+	}
+}
+
+// interval returns how often the queue is polled (default: 5 seconds)
+func (e *Engine) interval() time.Duration {
+	interval := e.config.GetDuration("automation.poll_interval")
+	if interval <= 0 {
+		interval = 5 * time.Second
+	}
+	return interval
+}
+
+func (e *Engine) maxAttempts() int {
+	attempts := e.config.GetInt("automation.max_attempts")
+	if attempts <= 0 {
+		attempts = 3
+	}
+	return attempts
+}
+
+func (e *Engine) retryDelay() time.Duration {
+	delay := e.config.GetDuration("automation.retry_delay")
+	if delay <= 0 {
+		delay = time.Minute
+	}
+	return delay
+}
+
+// permanentError marks failures that retrying can't fix
+type permanentError struct{ err error }
+
+func (p permanentError) Error() string { return p.err.Error() }
+func (p permanentError) Unwrap() error { return p.err }
+
+func permanent(err error) error { return permanentError{err} }
+
+func isPermanent(err error) bool {
+	var p permanentError
+	return errors.As(err, &p)
+}
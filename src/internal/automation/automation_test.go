@@ -0,0 +1,183 @@
+package automation
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&models.User{}, &models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{},
+		&models.Gist{}, &models.Webhook{}, &models.WebhookDelivery{},
+		&models.AutomationRule{}, &models.AutomationRun{},
+	))
+	return db
+}
+
+func createUserGist(t *testing.T, db *gorm.DB, userID uuid.UUID, tags ...string) *models.Gist {
+	gist := &models.Gist{UserID: &userID, Title: "snippet", GitRepoPath: uuid.NewString(), Visibility: models.VisibilityPrivate}
+	gist.SetTags(tags)
+	require.NoError(t, db.Create(gist).Error)
+	return gist
+}
+
+func createRule(t *testing.T, db *gorm.DB, rule *models.AutomationRule) {
+	rule.Enabled = true
+	require.NoError(t, ValidateRule(db, rule))
+	require.NoError(t, db.Create(rule).Error)
+}
+
+func TestSetVisibilityRule(t *testing.T) {
+	db := setupTestDB(t)
+	engine := NewEngine(db, config.New())
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(&user).Error)
+
+	createRule(t, db, &models.AutomationRule{
+		UserID: &user.ID, Name: "Publish", Tag: " Public ", Action: ActionSetVisibility,
+		Params: `{"visibility":"public"}`,
+	})
+
+	// Untagged gists and other tags don't match
+	untagged := createUserGist(t, db, user.ID, "draft")
+	queued, err := Enqueue(db, untagged, &user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 0, queued)
+
+	gist := createUserGist(t, db, user.ID, "go", "PUBLIC")
+	queued, err = Enqueue(db, gist, &user.ID)
+	require.NoError(t, err)
+	assert.Equal(t, 1, queued)
+
+	processed, err := engine.ProcessPending(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, processed)
+
+	var updated models.Gist
+	require.NoError(t, db.First(&updated, "id = ?", gist.ID).Error)
+	assert.Equal(t, models.VisibilityPublic, updated.Visibility)
+
+	var run models.AutomationRun
+	require.NoError(t, db.First(&run, "gist_id = ?", gist.ID).Error)
+	assert.Equal(t, models.AutomationRunSucceeded, run.Status)
+
+	var rule models.AutomationRule
+	require.NoError(t, db.First(&rule).Error)
+	assert.Equal(t, int64(1), rule.RunCount)
+	assert.Equal(t, "public", rule.Tag)
+}
+
+func TestNotifyWebhookRetries(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, EventRuleMatched, r.Header.Get("X-CasGists-Event"))
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	db := setupTestDB(t)
+	cfg := config.New()
+	cfg.Set("automation.retry_delay", "1ms")
+	engine := NewEngine(db, cfg)
+
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(&user).Error)
+	wh := models.Webhook{ID: uuid.New(), UserID: &user.ID, URL: server.URL, IsActive: true}
+	require.NoError(t, db.Create(&wh).Error)
+
+	createRule(t, db, &models.AutomationRule{
+		UserID: &user.ID, Name: "Notify", Tag: "ops", Action: ActionNotifyWebhook,
+		Params: `{"webhook_id":"` + wh.ID.String() + `"}`,
+	})
+
+	gist := createUserGist(t, db, user.ID, "ops")
+	_, err := Enqueue(db, gist, &user.ID)
+	require.NoError(t, err)
+
+	_, err = engine.ProcessPending(context.Background())
+	require.NoError(t, err)
+	var run models.AutomationRun
+	require.NoError(t, db.First(&run).Error)
+	assert.Equal(t, models.AutomationRunPending, run.Status)
+	assert.Equal(t, 1, run.Attempts)
+	assert.NotEmpty(t, run.Error)
+
+	time.Sleep(5 * time.Millisecond)
+	_, err = engine.ProcessPending(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.First(&run).Error)
+	assert.Equal(t, models.AutomationRunSucceeded, run.Status)
+	assert.Equal(t, int32(2), atomic.LoadInt32(&calls))
+}
+
+func TestAssignOrganizationRule(t *testing.T) {
+	db := setupTestDB(t)
+	engine := NewEngine(db, config.New())
+	user := models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(&user).Error)
+	org := models.Organization{Name: "acme", DisplayName: "Acme"}
+	require.NoError(t, db.Create(&org).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: user.ID, Role: models.OrgRoleMember}).Error)
+
+	createRule(t, db, &models.AutomationRule{
+		UserID: &user.ID, Name: "Team snippets", Tag: "acme", Action: ActionAssignOrganization,
+		Params: `{"organization":"acme"}`,
+	})
+
+	gist := createUserGist(t, db, user.ID, "acme")
+	_, err := Enqueue(db, gist, &user.ID)
+	require.NoError(t, err)
+	_, err = engine.ProcessPending(context.Background())
+	require.NoError(t, err)
+
+	var updated models.Gist
+	require.NoError(t, db.First(&updated, "id = ?", gist.ID).Error)
+	assert.Nil(t, updated.UserID)
+	require.NotNil(t, updated.OrganizationID)
+	assert.Equal(t, org.ID, *updated.OrganizationID)
+}
+
+func TestValidateRule(t *testing.T) {
+	db := setupTestDB(t)
+	alice := models.User{Username: "alice", Email: "alice@example.com"}
+	bob := models.User{Username: "bob", Email: "bob@example.com"}
+	require.NoError(t, db.Create(&alice).Error)
+	require.NoError(t, db.Create(&bob).Error)
+	bobsHook := models.Webhook{ID: uuid.New(), UserID: &bob.ID, URL: "https://example.com", IsActive: true}
+	require.NoError(t, db.Create(&bobsHook).Error)
+	orgID := uuid.New()
+
+	invalid := []models.AutomationRule{
+		{UserID: &alice.ID, Name: "", Tag: "x", Action: ActionSetVisibility, Params: `{"visibility":"public"}`},
+		{UserID: &alice.ID, Name: "n", Tag: " ", Action: ActionSetVisibility, Params: `{"visibility":"public"}`},
+		{UserID: &alice.ID, Name: "n", Tag: "x", Action: "delete_everything"},
+		{UserID: &alice.ID, Name: "n", Tag: "x", Action: ActionSetVisibility, Params: `{"visibility":"secret"}`},
+		{UserID: &alice.ID, Name: "n", Tag: "x", Action: ActionNotifyWebhook, Params: `{"webhook_id":"` + bobsHook.ID.String() + `"}`},
+		{OrganizationID: &orgID, Name: "n", Tag: "x", Action: ActionAssignOrganization, Params: `{"organization":"acme"}`},
+	}
+	for _, rule := range invalid {
+		err := ValidateRule(db, &rule)
+		assert.ErrorIs(t, err, ErrInvalidRule, "%s %s", rule.Action, rule.Params)
+	}
+}
@@ -2,51 +2,96 @@ package backup
 
 import (
 	"archive/tar"
+	"bufio"
 	"compress/gzip"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/objectstore"
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // Manager handles backup and restore operations
 type Manager struct {
 	db        *gorm.DB
-	config    *viper.Viper
+	config    *config.Config
 	dataDir   string
 	gitDir    string
 	uploadDir string
+	blobDir   string             // "" when attachment blobs are in object storage
+	store     objectstore.Bucket // nil when backups stay on local disk
+
+	restoring     sync.Mutex // guards restoreStatus
+	restoreStatus RestoreStatus
 }
 
-// NewManager creates a new backup manager
-func NewManager(db *gorm.DB, config *viper.Viper) *Manager {
-	return &Manager{
+// NewManager creates a new backup manager. Backups are uploaded to the
+// object storage bucket when storage.type is remote.
+func NewManager(db *gorm.DB, config *config.Config) *Manager {
+	store, err := objectstore.NewRemote(config)
+	if err != nil {
+		slog.Warn("Failed to open object storage, backups stay on local disk", "error", err)
+	}
+	m := &Manager{
 		db:        db,
 		config:    config,
 		dataDir:   config.GetString("paths.data"),
 		gitDir:    filepath.Join(config.GetString("paths.data"), "git"),
 		uploadDir: filepath.Join(config.GetString("paths.data"), "uploads"),
+		store:     store,
+	}
+
+	// Blobs kept on local disk are backed up with the attachments
+	if name := config.GetString("storage.type"); name == "" || name == "local" {
+		root := config.GetString("storage.path")
+		if root == "" {
+			root = filepath.Join(config.GetString("data_dir"), "files")
+		}
+		m.blobDir = filepath.Join(root, "blobs")
+	}
+	return m
+}
+
+// Dir returns the directory backups are written to: backup.directory,
+// backup.path or the backups directory under paths.data
+func (m *Manager) Dir() string {
+	for _, key := range []string{"backup.directory", "backup.path"} {
+		if dir := m.config.GetString(key); dir != "" && !strings.Contains(dir, "{") {
+			return dir
+		}
 	}
+	return filepath.Join(m.dataDir, "backups")
 }
 
 // CreateBackup creates a backup of the CasGists instance
 func (m *Manager) CreateBackup(ctx context.Context, options BackupOptions) (*BackupResult, error) {
 	result := &BackupResult{
 		StartTime: time.Now(),
-		ID:        uuid.New().String(),
+		ID:        options.ID,
+		Type:      TypeFull,
+	}
+	if result.ID == "" {
+		result.ID = uuid.New().String()
+	}
+	if options.Type == TypeIncremental || options.Type == TypeSnapshot {
+		result.Type = options.Type
 	}
 
 	// Validate options
 	if options.OutputPath == "" {
-		options.OutputPath = filepath.Join(m.dataDir, "backups", 
+		options.OutputPath = filepath.Join(m.Dir(), 
 			fmt.Sprintf("casgists-backup-%s.tar.gz", time.Now().Format("20060102-150405")))
 	}
 
@@ -70,9 +115,10 @@ func (m *Manager) CreateBackup(ctx context.Context, options BackupOptions) (*Bac
 	}
 	result.DatabaseExported = true
 
-	// Export git repositories if requested
+	// Export git repositories if requested. Incremental backups only hold
+	// the files changed since the backup they build on.
 	if options.IncludeGitRepos {
-		if err := m.exportGitRepos(ctx, tempDir); err != nil {
+		if err := m.exportGitRepos(ctx, tempDir, options.Since); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("Git repos export error: %v", err))
 		} else {
 			result.GitReposExported = true
@@ -81,7 +127,7 @@ func (m *Manager) CreateBackup(ctx context.Context, options BackupOptions) (*Bac
 
 	// Export attachments if requested
 	if options.IncludeAttachments {
-		if err := m.exportAttachments(ctx, tempDir); err != nil {
+		if err := m.exportAttachments(ctx, tempDir, options.Since); err != nil {
 			result.Errors = append(result.Errors, fmt.Sprintf("Attachments export error: %v", err))
 		} else {
 			result.AttachmentsExported = true
@@ -89,10 +135,11 @@ func (m *Manager) CreateBackup(ctx context.Context, options BackupOptions) (*Bac
 	}
 
 	// Create metadata
-	metadata := m.createMetadata()
+	metadata := m.createMetadata(result, options)
 	if err := m.writeMetadata(tempDir, metadata); err != nil {
 		result.Errors = append(result.Errors, fmt.Sprintf("Metadata write error: %v", err))
 	}
+	result.Metadata = metadata
 
 	// Create archive
 	if err := m.createArchive(tempDir, options.OutputPath, options.EncryptionKey); err != nil {
@@ -105,6 +152,15 @@ func (m *Manager) CreateBackup(ctx context.Context, options BackupOptions) (*Bac
 		result.OutputPath = options.OutputPath
 	}
 
+	// Move the archive to object storage
+	if m.store != nil {
+		key, err := m.upload(ctx, options.OutputPath)
+		if err != nil {
+			result.Errors = append(result.Errors, fmt.Sprintf("Upload error: %v", err))
+		}
+		result.StorageKey = key
+	}
+
 	result.EndTime = time.Now()
 	result.Success = len(result.Errors) == 0
 
@@ -207,29 +263,41 @@ func (m *Manager) writeJSON(filename string, data interface{}) error {
 }
 
 // exportGitRepos exports git repositories
-func (m *Manager) exportGitRepos(ctx context.Context, outputDir string) error {
+func (m *Manager) exportGitRepos(ctx context.Context, outputDir string, since time.Time) error {
 	gitDir := filepath.Join(outputDir, "git")
 	if err := os.MkdirAll(gitDir, 0755); err != nil {
 		return err
 	}
 
 	// Copy git directory
-	return copyDir(m.gitDir, gitDir)
+	return copyDir(m.gitDir, gitDir, since)
 }
 
-// exportAttachments exports uploaded attachments
-func (m *Manager) exportAttachments(ctx context.Context, outputDir string) error {
+// exportAttachments exports uploaded attachments and, when they are kept
+// on local disk, attachment blobs
+func (m *Manager) exportAttachments(ctx context.Context, outputDir string, since time.Time) error {
 	attachDir := filepath.Join(outputDir, "attachments")
 	if err := os.MkdirAll(attachDir, 0755); err != nil {
 		return err
 	}
 
 	// Copy uploads directory
-	return copyDir(m.uploadDir, attachDir)
+	if err := copyDir(m.uploadDir, attachDir, since); err != nil {
+		return err
+	}
+
+	if m.blobDir == "" {
+		return nil
+	}
+	blobDir := filepath.Join(outputDir, "blobs")
+	if err := os.MkdirAll(blobDir, 0755); err != nil {
+		return err
+	}
+	return copyDir(m.blobDir, blobDir, since)
 }
 
 // createMetadata creates backup metadata
-func (m *Manager) createMetadata() *BackupMetadata {
+func (m *Manager) createMetadata(result *BackupResult, options BackupOptions) *BackupMetadata {
 	var userCount, gistCount, orgCount int64
 	m.db.Model(&models.User{}).Count(&userCount)
 	m.db.Model(&models.Gist{}).Count(&gistCount)
@@ -237,8 +305,10 @@ func (m *Manager) createMetadata() *BackupMetadata {
 
 	hostname, _ := os.Hostname()
 
-	return &BackupMetadata{
+	metadata := &BackupMetadata{
 		Version:        "1.0",
+		ID:             result.ID,
+		Type:           result.Type,
 		CreatedAt:      time.Now(),
 		CasGistVersion: m.config.GetString("version"),
 		DatabaseType:   m.config.GetString("database.type"),
@@ -246,8 +316,15 @@ func (m *Manager) createMetadata() *BackupMetadata {
 		TotalGists:     gistCount,
 		TotalOrgs:      orgCount,
 		Hostname:       hostname,
-		Encrypted:      false,
+		Encrypted:      options.EncryptionKey != "",
+	}
+	if result.Type == TypeIncremental {
+		metadata.BaseID = options.BaseID
+		metadata.ParentID = options.ParentID
+		since := options.Since
+		metadata.Since = &since
 	}
+	return metadata
 }
 
 // writeMetadata writes metadata to file
@@ -256,7 +333,8 @@ func (m *Manager) writeMetadata(outputDir string, metadata *BackupMetadata) erro
 	return m.writeJSON(metadataFile, metadata)
 }
 
-// createArchive creates a tar.gz archive
+// createArchive creates a tar.gz archive, encrypted when encryptionKey is
+// set
 func (m *Manager) createArchive(sourceDir, outputPath, encryptionKey string) error {
 	// Create output file
 	outFile, err := os.Create(outputPath)
@@ -265,15 +343,43 @@ func (m *Manager) createArchive(sourceDir, outputPath, encryptionKey string) err
 	}
 	defer outFile.Close()
 
+	var out io.Writer = outFile
+	var encrypter *encryptWriter
+	if encryptionKey != "" {
+		if encrypter, err = newEncryptWriter(outFile, encryptionKey); err != nil {
+			return err
+		}
+		out = encrypter
+	}
+
 	// Create gzip writer
-	gzipWriter := gzip.NewWriter(outFile)
-	defer gzipWriter.Close()
+	gzipWriter := gzip.NewWriter(out)
 
 	// Create tar writer
 	tarWriter := tar.NewWriter(gzipWriter)
-	defer tarWriter.Close()
 
 	// Walk through source directory
+	if err := m.writeTar(tarWriter, sourceDir); err != nil {
+		return err
+	}
+
+	// Close the writers in order; each flushes into the next
+	if err := tarWriter.Close(); err != nil {
+		return err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return err
+	}
+	if encrypter != nil {
+		if err := encrypter.Close(); err != nil {
+			return err
+		}
+	}
+	return outFile.Close()
+}
+
+// writeTar adds the files under sourceDir to an archive
+func (m *Manager) writeTar(tarWriter *tar.Writer, sourceDir string) error {
 	return filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
@@ -314,25 +420,60 @@ func (m *Manager) createArchive(sourceDir, outputPath, encryptionKey string) err
 	})
 }
 
-// ReadBackupMetadata reads metadata from a backup file
-func (m *Manager) ReadBackupMetadata(backupPath string) (*BackupMetadata, error) {
+// archiveReader reads the tar stream of a backup file
+type archiveReader struct {
+	io.Reader
+	file *os.File
+}
+
+func (a *archiveReader) Close() error {
+	return a.file.Close()
+}
+
+// openArchive opens a backup file for reading its tar stream, decrypting
+// it with encryptionKey when it is encrypted
+func openArchive(backupPath, encryptionKey string) (io.ReadCloser, error) {
 	backupFile, err := os.Open(backupPath)
 	if err != nil {
 		return nil, err
 	}
-	defer backupFile.Close()
 
-	var reader io.Reader = backupFile
+	buffered := bufio.NewReader(backupFile)
+	var reader io.Reader = buffered
+	if isEncrypted(buffered) {
+		if reader, err = newDecryptReader(buffered, encryptionKey); err != nil {
+			backupFile.Close()
+			return nil, err
+		}
+	}
 
 	// Handle compression
 	if filepath.Ext(backupPath) == ".gz" {
-		gzReader, err := gzip.NewReader(backupFile)
+		gzReader, err := gzip.NewReader(reader)
 		if err != nil {
-			return nil, err
+			backupFile.Close()
+			if errors.Is(err, ErrDecrypt) {
+				return nil, err
+			}
+			return nil, fmt.Errorf("failed to read gzip: %w", err)
 		}
-		defer gzReader.Close()
 		reader = gzReader
 	}
+	return &archiveReader{Reader: reader, file: backupFile}, nil
+}
+
+// ReadBackupMetadata reads metadata from a backup file. Encrypted backups
+// are read with the configured encryption key.
+func (m *Manager) ReadBackupMetadata(backupPath string) (*BackupMetadata, error) {
+	key, err := m.EncryptionKey()
+	if err != nil {
+		return nil, err
+	}
+	reader, err := openArchive(backupPath, key)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
 
 	tarReader := tar.NewReader(reader)
 
@@ -359,10 +500,15 @@ func (m *Manager) ReadBackupMetadata(backupPath string) (*BackupMetadata, error)
 	return nil, fmt.Errorf("metadata not found in backup")
 }
 
-// Helper function to copy directories
-func copyDir(src, dst string) error {
+// Helper function to copy directories. Only files modified at or after
+// since are copied; a zero since copies all of them. A missing source
+// directory has nothing to copy.
+func copyDir(src, dst string, since time.Time) error {
 	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
+			if path == src && os.IsNotExist(err) {
+				return nil
+			}
 			return err
 		}
 
@@ -379,6 +525,9 @@ func copyDir(src, dst string) error {
 		}
 
 		// Copy files
+		if !since.IsZero() && info.ModTime().Before(since) {
+			return nil
+		}
 		return copyFile(path, dstPath)
 	})
 }
@@ -0,0 +1,174 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Backup files are named casgists-backup-[<kind>-]<yyyymmdd-hhmmss>-<id>.tar.gz,
+// id being the first 8 characters of the backup's ID. Full backups have no
+// kind.
+const (
+	filenamePrefix = "casgists-backup-"
+	filenameTime   = "20060102-150405"
+)
+
+// filenameKinds are the kinds in the filenames of backups other than full
+// ones
+var filenameKinds = map[string]string{
+	TypeIncremental: "incr",
+	TypeSnapshot:    "snapshot",
+	TypeUploaded:    "upload",
+}
+
+// Filename returns the name of a backup file
+func Filename(backupType string, created time.Time, id string) string {
+	if len(id) > 8 {
+		id = id[:8]
+	}
+	kind := ""
+	if name, ok := filenameKinds[backupType]; ok {
+		kind = name + "-"
+	}
+	return fmt.Sprintf("%s%s%s-%s.tar.gz", filenamePrefix, kind, created.Format(filenameTime), id)
+}
+
+// Entry is a backup file on local disk or in object storage
+type Entry struct {
+	ID         string    `json:"id"`
+	Type       string    `json:"type"`
+	Filename   string    `json:"filename"`
+	Path       string    `json:"path,omitempty"`        // "" when in object storage
+	StorageKey string    `json:"storage_key,omitempty"` // "" when on local disk
+	Size       int64     `json:"size"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Scheduled reports whether the backup is a full or incremental one, the
+// kinds the scheduler takes and the retention policy applies to
+func (e Entry) Scheduled() bool {
+	return e.Type == TypeFull || e.Type == TypeIncremental
+}
+
+// parseFilename reads a backup's type, creation time and ID from its
+// filename. Files named otherwise aren't backups.
+func parseFilename(name string, modTime time.Time) (Entry, bool) {
+	rest, ok := strings.CutPrefix(name, filenamePrefix)
+	if !ok || !strings.HasSuffix(rest, ".tar.gz") {
+		return Entry{}, false
+	}
+	rest = strings.TrimSuffix(rest, ".tar.gz")
+
+	entry := Entry{Type: TypeFull, Filename: name, CreatedAt: modTime}
+	for backupType, kind := range filenameKinds {
+		if after, ok := strings.CutPrefix(rest, kind+"-"); ok {
+			entry.Type = backupType
+			rest = after
+			break
+		}
+	}
+	if len(rest) >= len(filenameTime) {
+		if created, err := time.ParseInLocation(filenameTime, rest[:len(filenameTime)], time.Local); err == nil {
+			entry.CreatedAt = created
+			rest = rest[len(filenameTime):]
+		}
+	}
+	entry.ID = strings.TrimPrefix(rest, "-")
+	return entry, true
+}
+
+// Backups lists the backup files in the backup directory and in object
+// storage, newest first
+func (m *Manager) Backups(ctx context.Context) ([]Entry, error) {
+	var entries []Entry
+
+	files, err := filepath.Glob(filepath.Join(m.Dir(), filenamePrefix+"*.tar.gz"))
+	if err != nil {
+		return nil, err
+	}
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			continue
+		}
+		if entry, ok := parseFilename(filepath.Base(file), info.ModTime()); ok {
+			entry.Path = file
+			entry.Size = info.Size()
+			entries = append(entries, entry)
+		}
+	}
+
+	remote, err := m.RemoteBackups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	for _, object := range remote {
+		if entry, ok := parseFilename(path.Base(object.Key), object.ModTime); ok {
+			entry.StorageKey = object.Key
+			entry.Size = object.Size
+			entries = append(entries, entry)
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.After(entries[j].CreatedAt)
+	})
+	return entries, nil
+}
+
+// Prune applies the retention policy: the newest backup.retention full
+// backups are kept together with the incremental backups built on them,
+// and older backups are deleted. Pre-restore snapshots and uploaded
+// backups are left for administrators to delete. It returns the deleted
+// backups. A retention of 0 keeps every backup.
+func (m *Manager) Prune(ctx context.Context) ([]Entry, error) {
+	retention := m.config.GetInt("backup.retention")
+	if retention <= 0 {
+		return nil, nil
+	}
+	entries, err := m.Backups(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything older than the oldest full backup kept goes
+	fulls := 0
+	var expired []Entry
+	for i, entry := range entries {
+		if entry.Type != TypeFull {
+			continue
+		}
+		fulls++
+		if fulls == retention {
+			expired = entries[i+1:]
+			break
+		}
+	}
+
+	var deleted []Entry
+	for _, entry := range expired {
+		if !entry.Scheduled() {
+			continue
+		}
+		if err := m.deleteEntry(ctx, entry); err != nil {
+			slog.Error("Failed to delete expired backup", "backup", entry.Filename, "error", err)
+			continue
+		}
+		deleted = append(deleted, entry)
+	}
+	return deleted, nil
+}
+
+func (m *Manager) deleteEntry(ctx context.Context, entry Entry) error {
+	if entry.StorageKey != "" {
+		return m.DeleteRemoteBackup(ctx, entry.StorageKey)
+	}
+	return os.Remove(entry.Path)
+}
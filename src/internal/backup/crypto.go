@@ -0,0 +1,206 @@
+package backup
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Encrypted backups start with encryptedMagic, a salt and a nonce prefix,
+// followed by the archive in chunks sealed with AES-256-GCM. Each chunk's
+// nonce is the prefix and its index, and the last chunk is sealed with
+// different additional data, so chunks can't be reordered or cut off.
+const (
+	encryptedMagic = "CASGBAK1"
+	saltSize       = 16
+	noncePrefixLen = 4
+	chunkSize      = 64 * 1024
+)
+
+var (
+	// ErrEncrypted is returned when an encrypted backup is read without a key
+	ErrEncrypted = errors.New("backup is encrypted, an encryption key is required")
+	// ErrDecrypt is returned when a backup can't be decrypted with the key
+	ErrDecrypt = errors.New("failed to decrypt backup, wrong encryption key?")
+)
+
+var (
+	lastChunk  = []byte{1}
+	otherChunk = []byte{0}
+)
+
+// EncryptionKey returns the passphrase backups are encrypted with:
+// backup.encryption_key, or the first line of backup.encryption_key_file
+func (m *Manager) EncryptionKey() (string, error) {
+	if key := m.config.GetString("backup.encryption_key"); key != "" {
+		return key, nil
+	}
+	file := m.config.GetString("backup.encryption_key_file")
+	if file == "" {
+		return "", nil
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	key, _, _ := strings.Cut(string(content), "\n")
+	return strings.TrimSpace(key), nil
+}
+
+func newBackupGCM(passphrase string, salt []byte) (cipher.AEAD, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, 1<<15, 8, 1, 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptWriter seals what is written to it in chunks. A full chunk is
+// only sealed once more is written, as until then it could be the last.
+type encryptWriter struct {
+	w      io.Writer
+	gcm    cipher.AEAD
+	nonce  []byte
+	index  uint64
+	buffer []byte
+}
+
+func newEncryptWriter(w io.Writer, passphrase string) (*encryptWriter, error) {
+	header := make([]byte, len(encryptedMagic)+saltSize+noncePrefixLen)
+	copy(header, encryptedMagic)
+	if _, err := rand.Read(header[len(encryptedMagic):]); err != nil {
+		return nil, err
+	}
+	salt := header[len(encryptedMagic) : len(encryptedMagic)+saltSize]
+	gcm, err := newBackupGCM(passphrase, salt)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(header); err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, header[len(encryptedMagic)+saltSize:])
+	return &encryptWriter{w: w, gcm: gcm, nonce: nonce, buffer: make([]byte, 0, chunkSize)}, nil
+}
+
+func (e *encryptWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		if len(e.buffer) == chunkSize {
+			if err := e.seal(otherChunk); err != nil {
+				return written, err
+			}
+		}
+		n := copy(e.buffer[len(e.buffer):chunkSize], p)
+		e.buffer = e.buffer[:len(e.buffer)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+// Close seals the last chunk; it doesn't close the underlying writer
+func (e *encryptWriter) Close() error {
+	return e.seal(lastChunk)
+}
+
+func (e *encryptWriter) seal(additional []byte) error {
+	binary.BigEndian.PutUint64(e.nonce[noncePrefixLen:], e.index)
+	e.index++
+	sealed := e.gcm.Seal(nil, e.nonce, e.buffer, additional)
+	e.buffer = e.buffer[:0]
+	_, err := e.w.Write(sealed)
+	return err
+}
+
+// decryptReader opens the chunks an encryptWriter sealed
+type decryptReader struct {
+	r      *bufio.Reader
+	gcm    cipher.AEAD
+	nonce  []byte
+	index  uint64
+	sealed []byte
+	plain  []byte
+	done   bool
+}
+
+func newDecryptReader(r *bufio.Reader, passphrase string) (*decryptReader, error) {
+	header := make([]byte, len(encryptedMagic)+saltSize+noncePrefixLen)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, ErrDecrypt
+	}
+	if passphrase == "" {
+		return nil, ErrEncrypted
+	}
+	gcm, err := newBackupGCM(passphrase, header[len(encryptedMagic):len(encryptedMagic)+saltSize])
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	copy(nonce, header[len(encryptedMagic)+saltSize:])
+	return &decryptReader{
+		r:      r,
+		gcm:    gcm,
+		nonce:  nonce,
+		sealed: make([]byte, chunkSize+gcm.Overhead()),
+	}, nil
+}
+
+func (d *decryptReader) Read(p []byte) (int, error) {
+	for len(d.plain) == 0 {
+		if d.done {
+			return 0, io.EOF
+		}
+		if err := d.open(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, d.plain)
+	d.plain = d.plain[n:]
+	return n, nil
+}
+
+func (d *decryptReader) open() error {
+	n, err := io.ReadFull(d.r, d.sealed)
+	if err == io.ErrUnexpectedEOF || err == io.EOF {
+		d.done = true
+	} else if err != nil {
+		return err
+	} else if _, err := d.r.Peek(1); err == io.EOF {
+		// A full chunk can be the last one
+		d.done = true
+	}
+
+	additional := otherChunk
+	if d.done {
+		additional = lastChunk
+	}
+	binary.BigEndian.PutUint64(d.nonce[noncePrefixLen:], d.index)
+	d.index++
+	plain, err := d.gcm.Open(d.sealed[:0], d.nonce, d.sealed[:n], additional)
+	if err != nil {
+		return ErrDecrypt
+	}
+	d.plain = plain
+	return nil
+}
+
+// isEncrypted reports whether a backup starts with encryptedMagic
+func isEncrypted(r *bufio.Reader) bool {
+	magic, err := r.Peek(len(encryptedMagic))
+	return err == nil && bytes.Equal(magic, []byte(encryptedMagic))
+}
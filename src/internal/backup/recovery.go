@@ -0,0 +1,335 @@
+package backup
+
+import (
+	"archive/tar"
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/google/uuid"
+)
+
+// Restore phases, in order
+const (
+	PhaseValidating = "validating"
+	PhaseSnapshot   = "snapshot"
+	PhaseRestoring  = "restoring"
+	PhaseDone       = "done"
+	PhaseFailed     = "failed"
+)
+
+var (
+	// ErrRestoreInProgress is returned when a restore is started while
+	// another one is running
+	ErrRestoreInProgress = errors.New("a restore is already in progress")
+	// ErrBackupNotFound is returned when no backup has the ID asked for
+	ErrBackupNotFound = errors.New("backup not found")
+)
+
+// Verification is what reading a backup file through found
+type Verification struct {
+	Filename   string          `json:"filename"`
+	Size       int64           `json:"size"`
+	Encrypted  bool            `json:"encrypted"`
+	Entries    int             `json:"entries"`
+	Metadata   *BackupMetadata `json:"metadata"`
+	Compatible bool            `json:"compatible"`
+	Problem    string          `json:"problem,omitempty"` // Why it can't be restored here
+}
+
+// RestoreStatus reports the progress of the running or last restore
+type RestoreStatus struct {
+	Running    bool           `json:"running"`
+	Phase      string         `json:"phase,omitempty"`
+	Backup     string         `json:"backup,omitempty"`   // Filename of the backup restored
+	Snapshot   string         `json:"snapshot,omitempty"` // Filename of the pre-restore snapshot
+	Total      int            `json:"total"`              // Entries in the backup
+	Processed  int            `json:"processed"`
+	StartedAt  *time.Time     `json:"started_at,omitempty"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Result     *RestoreResult `json:"result,omitempty"`
+}
+
+// VerifyBackup reads a backup file from end to end, so every encrypted
+// chunk and the gzip checksum are checked, and reads its metadata. An
+// error means the file is damaged, isn't a backup or needs another key;
+// whether it can be restored on this server is reported in Compatible.
+// An empty encryptionKey means the configured one.
+func (m *Manager) VerifyBackup(backupPath, encryptionKey string) (*Verification, error) {
+	info, err := os.Stat(backupPath)
+	if err != nil {
+		return nil, err
+	}
+	if encryptionKey == "" {
+		if encryptionKey, err = m.EncryptionKey(); err != nil {
+			return nil, fmt.Errorf("failed to read encryption key: %w", err)
+		}
+	}
+
+	verification := &Verification{Filename: filepath.Base(backupPath), Size: info.Size()}
+	if file, err := os.Open(backupPath); err == nil {
+		verification.Encrypted = isEncrypted(bufio.NewReader(file))
+		file.Close()
+	}
+
+	archive, err := openArchive(backupPath, encryptionKey)
+	if err != nil {
+		return verification, err
+	}
+	defer archive.Close()
+
+	tarReader := tar.NewReader(archive)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return verification, fmt.Errorf("backup is damaged: %w", err)
+		}
+		verification.Entries++
+
+		if header.Name == "backup-metadata.json" {
+			var metadata BackupMetadata
+			if err := json.NewDecoder(tarReader).Decode(&metadata); err != nil {
+				return verification, fmt.Errorf("backup metadata is damaged: %w", err)
+			}
+			verification.Metadata = &metadata
+		}
+		if _, err := io.Copy(io.Discard, tarReader); err != nil {
+			return verification, fmt.Errorf("backup is damaged: %w", err)
+		}
+	}
+	// The checksums come after the end of the tar stream
+	if _, err := io.Copy(io.Discard, archive); err != nil {
+		return verification, fmt.Errorf("backup is damaged: %w", err)
+	}
+
+	if verification.Metadata == nil {
+		return verification, fmt.Errorf("metadata not found in backup")
+	}
+	if err := m.validateBackup(verification.Metadata); err != nil {
+		verification.Problem = err.Error()
+	} else {
+		verification.Compatible = true
+	}
+	return verification, nil
+}
+
+// Restore restores a backup the careful way: it reads the whole file
+// first, takes a snapshot of the current data to go back to when
+// options.Snapshot is set, and keeps the server read-only while it writes.
+// When options pick nothing to restore, everything is. progress, which may
+// be nil, is called as the restore moves along.
+func (m *Manager) Restore(ctx context.Context, options RestoreOptions, progress func(RestoreStatus)) (*RestoreResult, error) {
+	if !m.beginRestore(options.BackupPath) {
+		return nil, ErrRestoreInProgress
+	}
+	result, err := m.restore(ctx, options, progress)
+	m.endRestore(result, err, progress)
+	return result, err
+}
+
+// StartRestore runs Restore in the background; RestoreStatus tells how it
+// goes. done, which may be nil, is called once it has finished.
+func (m *Manager) StartRestore(options RestoreOptions, done func()) error {
+	if !m.beginRestore(options.BackupPath) {
+		return ErrRestoreInProgress
+	}
+	go func() {
+		if done != nil {
+			defer done()
+		}
+		result, err := m.restore(context.Background(), options, nil)
+		m.endRestore(result, err, nil)
+		switch {
+		case err != nil:
+			slog.Error("Restore failed", "backup", filepath.Base(options.BackupPath), "error", err)
+		case !result.Success:
+			slog.Warn("Restore finished with errors", "backup", filepath.Base(options.BackupPath), "errors", result.Errors)
+		default:
+			log.Printf("Restored %s", filepath.Base(options.BackupPath))
+		}
+	}()
+	return nil
+}
+
+// RestoreStatus returns the progress of the running or last restore
+func (m *Manager) RestoreStatus() RestoreStatus {
+	m.restoring.Lock()
+	defer m.restoring.Unlock()
+	return m.restoreStatus
+}
+
+func (m *Manager) restore(ctx context.Context, options RestoreOptions, progress func(RestoreStatus)) (*RestoreResult, error) {
+	m.updateRestore(progress, func(status *RestoreStatus) { status.Phase = PhaseValidating })
+	verification, err := m.VerifyBackup(options.BackupPath, options.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	if !verification.Compatible && !options.SkipValidation {
+		return nil, fmt.Errorf("backup validation failed: %s", verification.Problem)
+	}
+	m.updateRestore(progress, func(status *RestoreStatus) { status.Total = verification.Entries })
+
+	if options.Snapshot {
+		m.updateRestore(progress, func(status *RestoreStatus) { status.Phase = PhaseSnapshot })
+		snapshot, err := m.snapshot(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to take a pre-restore snapshot: %w", err)
+		}
+		m.updateRestore(progress, func(status *RestoreStatus) { status.Snapshot = filepath.Base(snapshot) })
+	}
+
+	if !options.RestoreUsers && !options.RestoreGists && !options.RestoreOrgs &&
+		!options.RestoreWebhooks && !options.RestoreConfig && !options.RestoreGitRepos {
+		options.RestoreUsers = true
+		options.RestoreGists = true
+		options.RestoreOrgs = true
+		options.RestoreWebhooks = true
+		options.RestoreConfig = true
+		options.RestoreGitRepos = true
+	}
+
+	// Nothing else is written while the backup goes in
+	if readOnly, _ := models.GetReadOnlyMode(m.db); !readOnly {
+		if err := models.SetReadOnlyMode(m.db, true, "Restoring from a backup"); err != nil {
+			return nil, fmt.Errorf("failed to turn on read-only mode: %w", err)
+		}
+		defer func() {
+			if err := models.SetReadOnlyMode(m.db, false, ""); err != nil {
+				slog.Error("Failed to turn off read-only mode after restoring", "error", err)
+			}
+		}()
+	}
+
+	m.updateRestore(progress, func(status *RestoreStatus) { status.Phase = PhaseRestoring })
+	return m.restoreArchive(ctx, options, func() {
+		m.updateRestore(progress, func(status *RestoreStatus) { status.Processed++ })
+	})
+}
+
+// snapshot takes a full backup of the current data and returns its path.
+// It is encrypted like scheduled backups, and left out of the retention
+// policy.
+func (m *Manager) snapshot(ctx context.Context) (string, error) {
+	var key string
+	if m.config.GetBool("backup.encrypt") {
+		var err error
+		if key, err = m.EncryptionKey(); err != nil {
+			return "", fmt.Errorf("failed to read encryption key: %w", err)
+		}
+	}
+
+	id := uuid.New().String()
+	result, err := m.CreateBackup(ctx, BackupOptions{
+		ID:                 id,
+		Type:               TypeSnapshot,
+		OutputPath:         filepath.Join(m.Dir(), Filename(TypeSnapshot, time.Now(), id)),
+		IncludeGitRepos:    true,
+		IncludeAttachments: true,
+		EncryptionKey:      key,
+	})
+	if err != nil {
+		return "", err
+	}
+	if !result.Success {
+		return "", errors.New(strings.Join(result.Errors, "; "))
+	}
+	return result.OutputPath, nil
+}
+
+func (m *Manager) beginRestore(backupPath string) bool {
+	m.restoring.Lock()
+	defer m.restoring.Unlock()
+	if m.restoreStatus.Running {
+		return false
+	}
+	now := time.Now()
+	m.restoreStatus = RestoreStatus{Running: true, Backup: filepath.Base(backupPath), StartedAt: &now}
+	return true
+}
+
+func (m *Manager) endRestore(result *RestoreResult, err error, progress func(RestoreStatus)) {
+	m.updateRestore(progress, func(status *RestoreStatus) {
+		now := time.Now()
+		status.Running = false
+		status.FinishedAt = &now
+		status.Result = result
+		status.Phase = PhaseDone
+		if err != nil {
+			status.Phase = PhaseFailed
+			status.Error = err.Error()
+		}
+	})
+}
+
+func (m *Manager) updateRestore(progress func(RestoreStatus), update func(*RestoreStatus)) {
+	m.restoring.Lock()
+	update(&m.restoreStatus)
+	status := m.restoreStatus
+	m.restoring.Unlock()
+	if progress != nil {
+		progress(status)
+	}
+}
+
+// Locate returns a local path to the backup whose ID starts with id,
+// downloading it when it is in object storage. cleanup removes the
+// download and does nothing for local backups.
+func (m *Manager) Locate(ctx context.Context, id string) (backupPath string, cleanup func(), err error) {
+	if id == "" {
+		return "", nil, ErrBackupNotFound
+	}
+	entries, err := m.Backups(ctx)
+	if err != nil {
+		return "", nil, err
+	}
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.ID, id) && !strings.HasPrefix(id, entry.ID) {
+			continue
+		}
+		if entry.Path != "" {
+			return entry.Path, func() {}, nil
+		}
+		return m.FetchRemoteBackup(ctx, entry.StorageKey)
+	}
+	return "", nil, ErrBackupNotFound
+}
+
+// Import saves a backup brought from elsewhere to the backup directory,
+// where it is listed as an uploaded backup
+func (m *Manager) Import(reader io.Reader) (Entry, error) {
+	if err := os.MkdirAll(m.Dir(), 0755); err != nil {
+		return Entry{}, err
+	}
+	now := time.Now()
+	id := uuid.New().String()
+	entry := Entry{ID: id[:8], Type: TypeUploaded, Filename: Filename(TypeUploaded, now, id), CreatedAt: now}
+	entry.Path = filepath.Join(m.Dir(), entry.Filename)
+
+	file, err := os.Create(entry.Path)
+	if err != nil {
+		return Entry{}, err
+	}
+	entry.Size, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(entry.Path)
+		return Entry{}, err
+	}
+	return entry, nil
+}
@@ -2,7 +2,6 @@ package backup
 
 import (
 	"archive/tar"
-	"compress/gzip"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -17,16 +16,16 @@ import (
 
 // RestoreResult contains the result of a restore operation
 type RestoreResult struct {
-	Success             bool
-	RestoredUsers       int
-	RestoredGists       int
-	RestoredFiles       int
-	RestoredOrgs        int
-	RestoredWebhooks    int
-	SkippedItems        int
-	Errors              []string
-	BackupMetadata      *BackupMetadata
-	UserMapping         map[uuid.UUID]uuid.UUID // Old ID -> New ID mapping
+	Success             bool                    `json:"success"`
+	RestoredUsers       int                     `json:"restored_users"`
+	RestoredGists       int                     `json:"restored_gists"`
+	RestoredFiles       int                     `json:"restored_files"`
+	RestoredOrgs        int                     `json:"restored_orgs"`
+	RestoredWebhooks    int                     `json:"restored_webhooks"`
+	SkippedItems        int                     `json:"skipped_items"`
+	Errors              []string                `json:"errors"`
+	BackupMetadata      *BackupMetadata         `json:"backup_metadata"`
+	UserMapping         map[uuid.UUID]uuid.UUID `json:"-"` // Old ID -> New ID mapping
 }
 
 // RestoreOptions contains options for restore operations
@@ -40,31 +39,41 @@ type RestoreOptions struct {
 	RestoreConfig       bool
 	RestoreGitRepos     bool
 	SkipValidation      bool
+	Snapshot            bool                    // Take a pre-restore snapshot first; Restore and StartRestore only
+	EncryptionKey       string                  // Defaults to the configured key
 	UserMapping         map[uuid.UUID]uuid.UUID // Old ID -> New ID mapping
 }
 
 // RestoreBackup restores data from a backup file
 func (m *Manager) RestoreBackup(ctx context.Context, options RestoreOptions) (*RestoreResult, error) {
+	return m.restoreArchive(ctx, options, nil)
+}
+
+// restoreArchive restores data from a backup file, calling onEntry, which
+// may be nil, as it reaches each archive entry
+func (m *Manager) restoreArchive(ctx context.Context, options RestoreOptions, onEntry func()) (*RestoreResult, error) {
 	result := &RestoreResult{
 		UserMapping: make(map[uuid.UUID]uuid.UUID),
 	}
 
-	// Open backup file
-	file, err := os.Open(options.BackupPath)
-	if err != nil {
-		return result, fmt.Errorf("failed to open backup file: %w", err)
+	encryptionKey := options.EncryptionKey
+	if encryptionKey == "" {
+		key, err := m.EncryptionKey()
+		if err != nil {
+			return result, fmt.Errorf("failed to read encryption key: %w", err)
+		}
+		encryptionKey = key
 	}
-	defer file.Close()
 
-	// Create gzip reader
-	gzipReader, err := gzip.NewReader(file)
+	// Open backup file
+	archive, err := openArchive(options.BackupPath, encryptionKey)
 	if err != nil {
-		return result, fmt.Errorf("failed to read gzip: %w", err)
+		return result, fmt.Errorf("failed to open backup file: %w", err)
 	}
-	defer gzipReader.Close()
+	defer archive.Close()
 
 	// Create tar reader
-	tarReader := tar.NewReader(gzipReader)
+	tarReader := tar.NewReader(archive)
 
 	// First pass: read metadata
 	metadata, err := m.extractMetadata(tarReader)
@@ -80,11 +89,14 @@ func (m *Manager) RestoreBackup(ctx context.Context, options RestoreOptions) (*R
 		}
 	}
 
-	// Reset tar reader for second pass
-	file.Seek(0, 0)
-	gzipReader, _ = gzip.NewReader(file)
-	defer gzipReader.Close()
-	tarReader = tar.NewReader(gzipReader)
+	// Reopen the archive for the second pass
+	archive.Close()
+	archive, err = openArchive(options.BackupPath, encryptionKey)
+	if err != nil {
+		return result, fmt.Errorf("failed to open backup file: %w", err)
+	}
+	defer archive.Close()
+	tarReader = tar.NewReader(archive)
 
 	// Second pass: restore data
 	for {
@@ -93,8 +105,12 @@ func (m *Manager) RestoreBackup(ctx context.Context, options RestoreOptions) (*R
 			break
 		}
 		if err != nil {
+			// A damaged archive can't be read past the damage
 			result.Errors = append(result.Errors, fmt.Sprintf("Error reading tar: %v", err))
-			continue
+			break
+		}
+		if onEntry != nil {
+			onEntry()
 		}
 
 		switch {
@@ -147,6 +163,13 @@ func (m *Manager) RestoreBackup(ctx context.Context, options RestoreOptions) (*R
 				result.RestoredFiles++
 			}
 
+		case strings.HasPrefix(header.Name, "blobs/") && m.blobDir != "":
+			if err := restoreFile(m.blobDir, strings.TrimPrefix(header.Name, "blobs/"), header, tarReader); err != nil {
+				result.Errors = append(result.Errors, fmt.Sprintf("Attachment restore error: %v", err))
+			} else {
+				result.RestoredFiles++
+			}
+
 		default:
 			result.SkippedItems++
 		}
@@ -331,36 +354,21 @@ func (m *Manager) restoreWebhooks(ctx context.Context, reader io.Reader, overwri
 // restoreGitRepo restores a git repository
 func (m *Manager) restoreGitRepo(header *tar.Header, reader io.Reader) error {
 	// Remove "git/" prefix
-	relPath := strings.TrimPrefix(header.Name, "git/")
-	fullPath := filepath.Join(m.gitDir, relPath)
-
-	// Create directory if needed
-	if header.Typeflag == tar.TypeDir {
-		return os.MkdirAll(fullPath, os.FileMode(header.Mode))
-	}
-
-	// Create parent directory
-	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
-		return err
-	}
-
-	// Create file
-	file, err := os.Create(fullPath)
-	if err != nil {
-		return err
-	}
-	defer file.Close()
-
-	// Copy content
-	_, err = io.Copy(file, reader)
-	return err
+	return restoreFile(m.gitDir, strings.TrimPrefix(header.Name, "git/"), header, reader)
 }
 
 // restoreAttachment restores an uploaded attachment
 func (m *Manager) restoreAttachment(header *tar.Header, reader io.Reader) error {
 	// Remove "attachments/" prefix
-	relPath := strings.TrimPrefix(header.Name, "attachments/")
-	fullPath := filepath.Join(m.uploadDir, relPath)
+	return restoreFile(m.uploadDir, strings.TrimPrefix(header.Name, "attachments/"), header, reader)
+}
+
+// restoreFile writes an archive entry to relPath under dir
+func restoreFile(dir, relPath string, header *tar.Header, reader io.Reader) error {
+	fullPath := filepath.Join(dir, relPath)
+	if !strings.HasPrefix(fullPath, filepath.Clean(dir)+string(filepath.Separator)) && fullPath != filepath.Clean(dir) {
+		return fmt.Errorf("archive entry %q is outside its directory", header.Name)
+	}
 
 	// Create directory if needed
 	if header.Typeflag == tar.TypeDir {
@@ -382,4 +390,4 @@ func (m *Manager) restoreAttachment(header *tar.Header, reader io.Reader) error
 	// Copy content
 	_, err = io.Copy(file, reader)
 	return err
-}
\ No newline at end of file
+}
@@ -0,0 +1,121 @@
+package backup
+
+import (
+	"archive/tar"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupBackupTest(t *testing.T) (*Manager, *gorm.DB) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	sqlDB.SetMaxOpenConns(1)
+	require.NoError(t, db.AutoMigrate(
+		&models.User{},
+		&models.Gist{},
+		&models.GistFile{},
+		&models.GistStar{},
+		&models.GistComment{},
+		&models.Organization{},
+		&models.OrganizationMember{},
+		&models.Webhook{},
+		&models.SystemConfig{},
+	))
+
+	dir := t.TempDir()
+	cfg := config.New()
+	cfg.Set("paths.data", dir)
+	cfg.Set("storage.path", filepath.Join(dir, "files"))
+	cfg.Set("database.type", "sqlite")
+	return NewManager(db, cfg), db
+}
+
+func TestRestoreFileStaysInItsDirectory(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"../escaped.txt", "nested/../../escaped.txt"} {
+		header := &tar.Header{Name: "attachments/" + name, Typeflag: tar.TypeReg}
+		err := restoreFile(dir, name, header, strings.NewReader("payload"))
+		assert.Error(t, err, name)
+	}
+	_, err := os.Stat(filepath.Join(filepath.Dir(dir), "escaped.txt"))
+	assert.True(t, os.IsNotExist(err), "an entry was written outside the directory")
+
+	header := &tar.Header{Name: "attachments/nested/kept.txt", Typeflag: tar.TypeReg}
+	require.NoError(t, restoreFile(dir, "nested/kept.txt", header, strings.NewReader("payload")))
+	content, err := os.ReadFile(filepath.Join(dir, "nested", "kept.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "payload", string(content))
+}
+
+func TestRestoreTakesSnapshot(t *testing.T) {
+	m, db := setupBackupTest(t)
+	ctx := context.Background()
+
+	user := &models.User{Username: "gopher", Email: "gopher@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	gist := &models.Gist{
+		Title:      "Backed up",
+		UserID:     &user.ID,
+		Visibility: models.VisibilityPublic,
+		Files:      []models.GistFile{{Filename: "hello.txt", Content: "hello"}},
+	}
+	require.NoError(t, db.Create(gist).Error)
+
+	backup, err := m.CreateBackup(ctx, BackupOptions{
+		OutputPath: filepath.Join(m.Dir(), Filename(TypeFull, gist.CreatedAt, "full")),
+	})
+	require.NoError(t, err)
+	require.True(t, backup.Success, backup.Errors)
+
+	// The gist is lost after the backup was taken
+	require.NoError(t, db.Unscoped().Where("gist_id = ?", gist.ID).Delete(&models.GistFile{}).Error)
+	require.NoError(t, db.Unscoped().Delete(gist).Error)
+
+	var statuses []RestoreStatus
+	result, err := m.Restore(ctx, RestoreOptions{BackupPath: backup.OutputPath, Snapshot: true}, func(status RestoreStatus) {
+		statuses = append(statuses, status)
+	})
+	require.NoError(t, err)
+	require.True(t, result.Success, result.Errors)
+	assert.Equal(t, 1, result.RestoredGists)
+
+	var restored models.Gist
+	require.NoError(t, db.Preload("Files").Where("title = ?", "Backed up").First(&restored).Error)
+	assert.Equal(t, user.ID, *restored.UserID)
+	require.Len(t, restored.Files, 1)
+	assert.Equal(t, "hello", restored.Files[0].Content)
+
+	// The snapshot holds the data from before the restore
+	status := m.RestoreStatus()
+	assert.False(t, status.Running)
+	require.NotEmpty(t, status.Snapshot)
+	verification, err := m.VerifyBackup(filepath.Join(m.Dir(), status.Snapshot), "")
+	require.NoError(t, err)
+	assert.True(t, verification.Compatible)
+	assert.Equal(t, TypeSnapshot, verification.Metadata.Type)
+
+	phases := map[string]bool{}
+	for _, status := range statuses {
+		phases[status.Phase] = true
+	}
+	assert.True(t, phases[PhaseSnapshot])
+	assert.True(t, phases[PhaseRestoring])
+
+	readOnly, _ := models.GetReadOnlyMode(db)
+	assert.False(t, readOnly, "read-only mode was left on after the restore")
+}
@@ -0,0 +1,288 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/cron"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/urls"
+	"github.com/casapps/casgists/src/internal/webhooks"
+)
+
+// Notifier emails an administrator about a finished backup;
+// *email.Service implements it
+type Notifier interface {
+	SendBackupCompleteNotification(userID uuid.UUID, email, username string, backupStats email.BackupStats) error
+}
+
+// Scheduler takes full backups on backup.schedule and incremental ones on
+// backup.incremental_schedule. Every backup, scheduled or started by an
+// administrator, runs through it: one at a time, followed by the
+// retention policy, system webhooks and an email to the administrators.
+type Scheduler struct {
+	db       *gorm.DB
+	config   *config.Config
+	manager  *Manager
+	webhooks *webhooks.Service
+	notifier Notifier
+	running  sync.Mutex
+	stop     chan bool
+}
+
+// NewScheduler creates a backup scheduler. notifier may be nil.
+func NewScheduler(db *gorm.DB, config *config.Config, manager *Manager, webhookService *webhooks.Service, notifier Notifier) *Scheduler {
+	return &Scheduler{
+		db:       db,
+		config:   config,
+		manager:  manager,
+		webhooks: webhookService,
+		notifier: notifier,
+		stop:     make(chan bool, 1),
+	}
+}
+
+// Manager returns the backup manager backups are taken with
+func (s *Scheduler) Manager() *Manager {
+	return s.manager
+}
+
+// Schedules parses backup.schedule and backup.incremental_schedule. The
+// incremental schedule is nil when it isn't set.
+func Schedules(config *config.Config) (full, incremental *cron.Schedule, err error) {
+	at := config.GetString("backup.time")
+	full, err = cron.ParseAt(config.GetString("backup.schedule"), at)
+	if err != nil {
+		return nil, nil, fmt.Errorf("backup.schedule: %w", err)
+	}
+	if spec := config.GetString("backup.incremental_schedule"); spec != "" {
+		incremental, err = cron.ParseAt(spec, at)
+		if err != nil {
+			return nil, nil, fmt.Errorf("backup.incremental_schedule: %w", err)
+		}
+	}
+	return full, incremental, nil
+}
+
+// Next returns when the next scheduled backup runs and its type. It
+// returns the zero time when scheduled backups are off. A full backup
+// takes the place of an incremental one due at the same time.
+func (s *Scheduler) Next(after time.Time) (time.Time, string) {
+	if !s.config.GetBool("backup.enabled") {
+		return time.Time{}, ""
+	}
+	full, incremental, err := Schedules(s.config)
+	if err != nil {
+		return time.Time{}, ""
+	}
+	next, backupType := full.Next(after), TypeFull
+	if incremental != nil {
+		if at := incremental.Next(after); !at.IsZero() && (next.IsZero() || at.Before(next)) {
+			next, backupType = at, TypeIncremental
+		}
+	}
+	return next, backupType
+}
+
+// Start takes scheduled backups until the context is cancelled or Stop is
+// called
+func (s *Scheduler) Start(ctx context.Context) {
+	if _, _, err := Schedules(s.config); err != nil {
+		slog.Warn("Scheduled backups are off", "error", err)
+		return
+	}
+
+	for {
+		next, backupType := s.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-s.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		result, err := s.Run(ctx, BackupOptions{
+			Type:               backupType,
+			IncludeGitRepos:    s.config.GetBool("backup.include_git_repos"),
+			IncludeAttachments: s.config.GetBool("backup.include_attachments"),
+		}, nil)
+		switch {
+		case err != nil:
+			slog.Error("Scheduled backup failed", "type", backupType, "error", err)
+		case !result.Success:
+			slog.Warn("Scheduled backup finished with errors", "type", result.Type, "backup", result.ID, "errors", result.Errors)
+		default:
+			log.Printf("Scheduled %s backup %s finished (%d bytes)", result.Type, result.ID, result.Size)
+		}
+	}
+}
+
+// Stop stops taking scheduled backups
+func (s *Scheduler) Stop() {
+	select {
+	case s.stop <- true:
+	default:
+	}
+}
+
+// Run takes a backup, waiting for one already running to finish first.
+// An incremental backup builds on the newest backup, and becomes a full
+// one when there is no full backup yet. Unless options say otherwise,
+// backups are encrypted with the configured key when backup.encrypt is
+// on. adminID is the administrator who asked for the backup, nil for
+// scheduled ones.
+func (s *Scheduler) Run(ctx context.Context, options BackupOptions, adminID *uuid.UUID) (*BackupResult, error) {
+	s.running.Lock()
+	defer s.running.Unlock()
+
+	if options.ID == "" {
+		options.ID = uuid.New().String()
+	}
+	if options.Type != TypeIncremental {
+		options.Type = TypeFull
+	} else if err := s.chain(ctx, &options); err != nil {
+		return s.finish(nil, options, err, adminID)
+	}
+	if options.OutputPath == "" {
+		options.OutputPath = filepath.Join(s.manager.Dir(), Filename(options.Type, time.Now(), options.ID))
+	}
+	if options.EncryptionKey == "" && s.config.GetBool("backup.encrypt") {
+		key, err := s.manager.EncryptionKey()
+		if err != nil {
+			return s.finish(nil, options, fmt.Errorf("failed to read encryption key: %w", err), adminID)
+		}
+		if key == "" {
+			slog.Warn("backup.encrypt is on but no encryption key is set, the backup is not encrypted", "backup", filepath.Base(options.OutputPath))
+		}
+		options.EncryptionKey = key
+	}
+
+	result, err := s.manager.CreateBackup(ctx, options)
+	if err == nil && result.Success {
+		if deleted, err := s.manager.Prune(ctx); err != nil {
+			slog.Error("Failed to apply the backup retention policy", "error", err)
+		} else if len(deleted) > 0 {
+			log.Printf("Deleted %d expired backups", len(deleted))
+		}
+	}
+	return s.finish(result, options, err, adminID)
+}
+
+// chain points an incremental backup at the backup it follows, or turns
+// it into a full one
+func (s *Scheduler) chain(ctx context.Context, options *BackupOptions) error {
+	entries, err := s.manager.Backups(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to list backups: %w", err)
+	}
+	var parent *Entry
+	for i, entry := range entries {
+		if !entry.Scheduled() {
+			continue
+		}
+		if parent == nil {
+			parent = &entries[i]
+		}
+		if entry.Type == TypeFull {
+			options.BaseID = entry.ID
+			options.ParentID = parent.ID
+			options.Since = parent.CreatedAt
+			return nil
+		}
+	}
+	options.Type = TypeFull
+	return nil
+}
+
+// finish reports how a backup went to system webhooks and, when it
+// succeeded, by email to the administrators
+func (s *Scheduler) finish(result *BackupResult, options BackupOptions, err error, adminID *uuid.UUID) (*BackupResult, error) {
+	if result == nil {
+		// The backup failed before it started
+		result = &BackupResult{ID: options.ID, Type: options.Type, StartTime: time.Now()}
+	}
+	if result.EndTime.IsZero() {
+		result.EndTime = time.Now()
+	}
+	filename := filepath.Base(options.OutputPath)
+	if options.OutputPath == "" {
+		filename = ""
+	}
+
+	if s.webhooks != nil {
+		event := webhooks.BackupEventData{
+			ID:                  result.ID,
+			Filename:            filename,
+			Size:                result.Size,
+			Success:             err == nil && result.Success,
+			DatabaseExported:    result.DatabaseExported,
+			GitReposExported:    result.GitReposExported,
+			AttachmentsExported: result.AttachmentsExported,
+			Errors:              result.Errors,
+			StartedAt:           result.StartTime,
+			CompletedAt:         result.EndTime,
+		}
+		if err != nil {
+			event.Errors = append(event.Errors, err.Error())
+		}
+		s.webhooks.TriggerBackupCompleted(context.Background(), event, adminID)
+	}
+
+	if err == nil && result.Success {
+		s.notify(result)
+	}
+	return result, err
+}
+
+// notify emails every active administrator about a finished backup
+func (s *Scheduler) notify(result *BackupResult) {
+	if s.notifier == nil || !s.config.GetBool("backup.notify") {
+		return
+	}
+
+	stats := email.BackupStats{
+		Date:            result.EndTime,
+		Size:            result.Size,
+		StorageLocation: result.OutputPath,
+		Type:            result.Type,
+		DownloadURL:     urls.NewBuilder(s.config).URL("/api/v1/backup/:id/download", result.ID[:8]),
+	}
+	stats.ID, _ = uuid.Parse(result.ID)
+	if result.Metadata != nil {
+		stats.GistCount = int(result.Metadata.TotalGists)
+		stats.UserCount = int(result.Metadata.TotalUsers)
+	}
+	if result.StorageKey != "" {
+		stats.StorageLocation = s.manager.Store().Describe() + result.StorageKey
+	}
+	stats.NextBackupDate, _ = s.Next(time.Now())
+
+	var admins []models.User
+	if err := s.db.Where("is_admin = ? AND deactivated_at IS NULL", true).Find(&admins).Error; err != nil {
+		slog.Error("Failed to load administrators for backup notification", "error", err)
+		return
+	}
+	for _, admin := range admins {
+		if err := s.notifier.SendBackupCompleteNotification(admin.ID, admin.Email, admin.Username, stats); err != nil {
+			slog.Error("Failed to send backup notification", "user", admin.Username, "error", err)
+		}
+	}
+}
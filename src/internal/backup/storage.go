@@ -0,0 +1,107 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/objectstore"
+)
+
+// Store returns the bucket backups are uploaded to, or nil when they stay
+// in the backup directory
+func (m *Manager) Store() objectstore.Bucket {
+	return m.store
+}
+
+// upload moves a finished backup archive into object storage and returns
+// its key
+func (m *Manager) upload(ctx context.Context, archivePath string) (string, error) {
+	key := objectstore.PrefixBackups + filepath.Base(archivePath)
+	if err := objectstore.PutFile(ctx, m.store, key, archivePath); err != nil {
+		return "", err
+	}
+	if err := os.Remove(archivePath); err != nil {
+		return key, fmt.Errorf("uploaded, but failed to remove the local copy: %w", err)
+	}
+	return key, nil
+}
+
+// RemoteBackups lists the backup archives in object storage
+func (m *Manager) RemoteBackups(ctx context.Context) ([]objectstore.Object, error) {
+	if m.store == nil {
+		return nil, nil
+	}
+	objects, err := m.store.List(ctx, objectstore.PrefixBackups)
+	if err != nil {
+		return nil, err
+	}
+	backups := objects[:0]
+	for _, object := range objects {
+		name := path.Base(object.Key)
+		if strings.HasPrefix(name, "casgists-backup-") && strings.HasSuffix(name, ".tar.gz") {
+			backups = append(backups, object)
+		}
+	}
+	return backups, nil
+}
+
+// FindRemoteBackup returns the backup in object storage whose filename
+// carries id, as the local backup files are looked up
+func (m *Manager) FindRemoteBackup(ctx context.Context, id string) (objectstore.Object, error) {
+	backups, err := m.RemoteBackups(ctx)
+	if err != nil {
+		return objectstore.Object{}, err
+	}
+	for _, object := range backups {
+		if id != "" && strings.Contains(path.Base(object.Key), "-"+id) {
+			return object, nil
+		}
+	}
+	return objectstore.Object{}, objectstore.ErrNotFound
+}
+
+// OpenRemoteBackup returns a backup archive's content from object storage
+func (m *Manager) OpenRemoteBackup(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	if m.store == nil {
+		return nil, objectstore.ErrNotFound
+	}
+	return m.store.Open(ctx, key)
+}
+
+// DeleteRemoteBackup removes a backup archive from object storage
+func (m *Manager) DeleteRemoteBackup(ctx context.Context, key string) error {
+	if m.store == nil {
+		return objectstore.ErrNotFound
+	}
+	return m.store.Delete(ctx, key)
+}
+
+// FetchRemoteBackup downloads a backup archive to a temporary file, for
+// restoring or reading its metadata. cleanup removes the file.
+func (m *Manager) FetchRemoteBackup(ctx context.Context, key string) (string, func(), error) {
+	reader, err := m.OpenRemoteBackup(ctx, key)
+	if err != nil {
+		return "", nil, err
+	}
+	defer reader.Close()
+
+	file, err := os.CreateTemp("", "casgists-restore-*.tar.gz")
+	if err != nil {
+		return "", nil, err
+	}
+	cleanup := func() { os.Remove(file.Name()) }
+	_, err = io.Copy(file, reader)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		cleanup()
+		return "", nil, err
+	}
+	return file.Name(), cleanup, nil
+}
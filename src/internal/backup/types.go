@@ -4,21 +4,42 @@ import (
 	"time"
 )
 
+// Backup types. A full backup holds everything; an incremental one holds
+// the database and the files changed since the backup before it. A
+// snapshot is a full backup taken before a restore, and an uploaded backup
+// one an administrator brought from elsewhere.
+const (
+	TypeFull        = "full"
+	TypeIncremental = "incremental"
+	TypeSnapshot    = "snapshot"
+	TypeUploaded    = "uploaded"
+)
+
 // BackupMetadata contains metadata about the backup
 type BackupMetadata struct {
-	Version        string    `json:"version"`
-	CreatedAt      time.Time `json:"created_at"`
-	CasGistVersion string    `json:"casgist_version"`
-	DatabaseType   string    `json:"database_type"`
-	TotalUsers     int64     `json:"total_users"`
-	TotalGists     int64     `json:"total_gists"`
-	TotalOrgs      int64     `json:"total_orgs"`
-	Hostname       string    `json:"hostname"`
-	Encrypted      bool      `json:"encrypted"`
+	Version        string     `json:"version"`
+	ID             string     `json:"id,omitempty"`
+	Type           string     `json:"type,omitempty"`
+	BaseID         string     `json:"base_id,omitempty"`   // Full backup an incremental one builds on
+	ParentID       string     `json:"parent_id,omitempty"` // Backup an incremental one follows
+	Since          *time.Time `json:"since,omitempty"`     // Files changed from then on are included
+	CreatedAt      time.Time  `json:"created_at"`
+	CasGistVersion string     `json:"casgist_version"`
+	DatabaseType   string     `json:"database_type"`
+	TotalUsers     int64      `json:"total_users"`
+	TotalGists     int64      `json:"total_gists"`
+	TotalOrgs      int64      `json:"total_orgs"`
+	Hostname       string     `json:"hostname"`
+	Encrypted      bool       `json:"encrypted"`
 }
 
 // BackupOptions contains options for creating a backup
 type BackupOptions struct {
+	ID                 string // Generated when empty
+	Type               string // TypeFull (default), TypeIncremental or TypeSnapshot
+	Since              time.Time
+	BaseID             string
+	ParentID           string
 	IncludeGitRepos    bool
 	IncludeAttachments bool
 	IncludeLogs        bool
@@ -31,13 +52,16 @@ type BackupOptions struct {
 // BackupResult contains the result of a backup operation
 type BackupResult struct {
 	ID                  string
+	Type                string
 	Success             bool
 	StartTime           time.Time
 	EndTime             time.Time
 	Size                int64
 	OutputPath          string
+	StorageKey          string // Key in object storage, when the archive was uploaded
 	DatabaseExported    bool
 	GitReposExported    bool
 	AttachmentsExported bool
+	Metadata            *BackupMetadata
 	Errors              []string
 }
\ No newline at end of file
@@ -0,0 +1,109 @@
+package blobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"os"
+	"path"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/objectstore"
+)
+
+// BucketStore keeps blobs in an object storage bucket, each under
+// blobs/ab/cd/<sum> as on local disk
+type BucketStore struct {
+	bucket objectstore.Bucket
+}
+
+// NewBucketStore creates a store on a bucket
+func NewBucketStore(bucket objectstore.Bucket) *BucketStore {
+	return &BucketStore{bucket: bucket}
+}
+
+// Name returns the bucket's driver name
+func (s *BucketStore) Name() string {
+	return s.bucket.Name()
+}
+
+func bucketKey(sum string) string {
+	return objectstore.PrefixBlobs + sum[:2] + "/" + sum[2:4] + "/" + sum
+}
+
+// Put spools the content to a temporary file while hashing it, as the key
+// is only known once all of it has been read, then uploads it. Content
+// already stored is uploaded again only when the pruner might soon take
+// it, which refreshes its modification time.
+func (s *BucketStore) Put(r io.Reader, max int64) (Blob, error) {
+	tmp, err := os.CreateTemp("", "casgists-blob-*")
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err != nil {
+		return Blob{}, err
+	}
+	if max > 0 && size > max {
+		return Blob{}, ErrTooLarge
+	}
+
+	ctx := context.Background()
+	sum := hex.EncodeToString(hash.Sum(nil))
+	if existing, err := s.bucket.Stat(ctx, bucketKey(sum)); err == nil && time.Since(existing.ModTime) < pruneGrace/2 {
+		return Blob{SHA256: sum, Size: size, ModTime: existing.ModTime}, nil
+	}
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return Blob{}, err
+	}
+	if err := s.bucket.Put(ctx, bucketKey(sum), tmp, size); err != nil {
+		return Blob{}, err
+	}
+	return Blob{SHA256: sum, Size: size, ModTime: time.Now()}, nil
+}
+
+// Open returns a reader over the blob's object
+func (s *BucketStore) Open(sum string) (io.ReadSeekCloser, error) {
+	if !ValidSHA256(sum) {
+		return nil, ErrNotFound
+	}
+	reader, err := s.bucket.Open(context.Background(), bucketKey(sum))
+	if errors.Is(err, objectstore.ErrNotFound) {
+		return nil, ErrNotFound
+	}
+	return reader, err
+}
+
+// Delete removes the blob's object
+func (s *BucketStore) Delete(sum string) error {
+	if !ValidSHA256(sum) {
+		return ErrNotFound
+	}
+	return s.bucket.Delete(context.Background(), bucketKey(sum))
+}
+
+// List lists the objects under blobs/
+func (s *BucketStore) List() ([]Blob, error) {
+	objects, err := s.bucket.List(context.Background(), objectstore.PrefixBlobs)
+	if err != nil {
+		return nil, err
+	}
+	var blobs []Blob
+	for _, object := range objects {
+		sum := path.Base(object.Key)
+		if !ValidSHA256(sum) || object.Key != bucketKey(sum) {
+			continue
+		}
+		blobs = append(blobs, Blob{SHA256: sum, Size: object.Size, ModTime: object.ModTime})
+	}
+	return blobs, nil
+}
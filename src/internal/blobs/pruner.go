@@ -0,0 +1,108 @@
+package blobs
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// pruneGrace is how old a blob must be before it can be pruned, so one
+// stored for an upload still being saved is left alone
+const pruneGrace = time.Hour
+
+// Pruner deletes blobs no attachment refers to any more, such as those of
+// purged gists
+type Pruner struct {
+	db     *gorm.DB
+	config *config.Config
+	store  Store
+	stop   chan bool
+}
+
+// NewPruner creates a new blob pruner
+func NewPruner(db *gorm.DB, config *config.Config, store Store) *Pruner {
+	return &Pruner{
+		db:     db,
+		config: config,
+		store:  store,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Prune deletes unreferenced blobs written before the grace period and
+// returns how many went
+func (p *Pruner) Prune(ctx context.Context) (int, error) {
+	stored, err := p.store.List()
+	if err != nil {
+		return 0, err
+	}
+	cutoff := time.Now().Add(-pruneGrace)
+	pruned := 0
+	for _, blob := range stored {
+		if ctx.Err() != nil {
+			return pruned, ctx.Err()
+		}
+		if blob.ModTime.After(cutoff) {
+			continue
+		}
+		var refs int64
+		if err := p.db.WithContext(ctx).Model(&models.GistAttachment{}).
+			Where("sha256 = ?", blob.SHA256).Count(&refs).Error; err != nil {
+			return pruned, err
+		}
+		if refs > 0 {
+			continue
+		}
+		if err := p.store.Delete(blob.SHA256); err != nil {
+			return pruned, err
+		}
+		pruned++
+	}
+	return pruned, nil
+}
+
+// Start prunes every attachments.prune_interval until the context is
+// cancelled or Stop is called
+func (p *Pruner) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			pruned, err := p.Prune(ctx)
+			if err != nil {
+				slog.Error("Blob prune failed", "error", err)
+			} else if pruned > 0 {
+				log.Printf("Pruned %d unreferenced attachment blobs", pruned)
+			}
+		}
+	}
+}
+
+// Stop stops pruning
+func (p *Pruner) Stop() {
+	select {
+	case p.stop <- true:
+	default:
+	}
+}
+
+// interval returns the pause between prunes (default: 24 hours)
+func (p *Pruner) interval() time.Duration {
+	interval := p.config.GetDuration("attachments.prune_interval")
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return interval
+}
@@ -0,0 +1,254 @@
+// Package blobs stores file content too large or too binary for the
+// database. Blobs are addressed by the SHA-256 of their content, so
+// uploading the same bytes twice stores them once.
+package blobs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/objectstore"
+)
+
+var (
+	ErrNotFound = errors.New("blob not found")
+	ErrTooLarge = errors.New("blob exceeds the maximum size")
+)
+
+// Blob is stored content and its address
+type Blob struct {
+	SHA256  string
+	Size    int64
+	ModTime time.Time // When it was last written
+}
+
+// Store keeps blobs under their SHA-256
+type Store interface {
+	// Name returns the driver name used in storage.type
+	Name() string
+	// Put stores the content read from r, up to max bytes (0 for no
+	// limit). Content already stored is kept and not written again.
+	Put(r io.Reader, max int64) (Blob, error)
+	// Open returns a blob's content
+	Open(sum string) (io.ReadSeekCloser, error)
+	// Delete removes a blob; deleting a missing one is not an error
+	Delete(sum string) error
+	// List returns every stored blob
+	List() ([]Blob, error)
+}
+
+// StoreFactory builds a store from the storage.* settings
+type StoreFactory func(cfg *config.Config) (Store, error)
+
+var (
+	storesMu sync.RWMutex
+	stores   = map[string]StoreFactory{
+		"local": newLocalStoreFromConfig,
+	}
+)
+
+// RegisterStore makes a store driver available under name
+func RegisterStore(name string, factory StoreFactory) {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+	stores[name] = factory
+}
+
+// StoreNames returns the names of all registered drivers and of the
+// object storage drivers blobs can be kept in
+func StoreNames() []string {
+	storesMu.RLock()
+	defer storesMu.RUnlock()
+	names := make([]string, 0, len(stores))
+	for name := range stores {
+		names = append(names, name)
+	}
+	for _, name := range objectstore.DriverNames() {
+		if _, ok := stores[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStore builds the store named by storage.type (default: local). Types
+// without a store of their own keep blobs in the object storage bucket of
+// that type.
+func NewStore(cfg *config.Config) (Store, error) {
+	name := cfg.GetString("storage.type")
+	if name == "" {
+		name = "local"
+	}
+	storesMu.RLock()
+	factory, ok := stores[name]
+	storesMu.RUnlock()
+	if ok {
+		return factory(cfg)
+	}
+	if objectstore.HasDriver(name) {
+		bucket, err := objectstore.Open(name, cfg)
+		if err != nil {
+			return nil, err
+		}
+		return NewBucketStore(bucket), nil
+	}
+	return nil, fmt.Errorf("unknown storage type %q (available: %s)", name, strings.Join(StoreNames(), ", "))
+}
+
+// ValidSHA256 reports whether sum is a lowercase hex SHA-256, the only
+// form blobs are addressed by
+func ValidSHA256(sum string) bool {
+	if len(sum) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range sum {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// LocalStore keeps blobs in a directory, each at ab/cd/<sum>
+type LocalStore struct {
+	root string
+}
+
+// NewLocalStore creates a store rooted at a directory
+func NewLocalStore(root string) *LocalStore {
+	return &LocalStore{root: root}
+}
+
+func newLocalStoreFromConfig(cfg *config.Config) (Store, error) {
+	root := cfg.GetString("storage.path")
+	if root == "" {
+		root = filepath.Join(cfg.GetString("data_dir"), "files")
+	}
+	if strings.Contains(root, "{") {
+		return nil, fmt.Errorf("storage.path has an unresolved placeholder: %s", root)
+	}
+	return NewLocalStore(filepath.Join(root, "blobs")), nil
+}
+
+// Name returns "local"
+func (s *LocalStore) Name() string {
+	return "local"
+}
+
+func (s *LocalStore) path(sum string) string {
+	return filepath.Join(s.root, sum[:2], sum[2:4], sum)
+}
+
+// Put writes the content to a temporary file while hashing it, then moves
+// it into place
+func (s *LocalStore) Put(r io.Reader, max int64) (Blob, error) {
+	tmpDir := filepath.Join(s.root, "tmp")
+	if err := os.MkdirAll(tmpDir, 0750); err != nil {
+		return Blob{}, err
+	}
+	tmp, err := os.CreateTemp(tmpDir, "upload-*")
+	if err != nil {
+		return Blob{}, err
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	if max > 0 {
+		r = io.LimitReader(r, max+1)
+	}
+	size, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return Blob{}, err
+	}
+	if max > 0 && size > max {
+		return Blob{}, ErrTooLarge
+	}
+
+	sum := hex.EncodeToString(hash.Sum(nil))
+	target := s.path(sum)
+	if _, err := os.Stat(target); err == nil {
+		// Touched so the pruner sees it as just written
+		now := time.Now()
+		if err := os.Chtimes(target, now, now); err != nil {
+			return Blob{}, err
+		}
+		return Blob{SHA256: sum, Size: size, ModTime: now}, nil
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return Blob{}, err
+	}
+	if err := os.Rename(tmp.Name(), target); err != nil {
+		return Blob{}, err
+	}
+	return Blob{SHA256: sum, Size: size, ModTime: time.Now()}, nil
+}
+
+// Open opens a blob's file
+func (s *LocalStore) Open(sum string) (io.ReadSeekCloser, error) {
+	if !ValidSHA256(sum) {
+		return nil, ErrNotFound
+	}
+	file, err := os.Open(s.path(sum))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Delete removes a blob's file
+func (s *LocalStore) Delete(sum string) error {
+	if !ValidSHA256(sum) {
+		return ErrNotFound
+	}
+	if err := os.Remove(s.path(sum)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the store for blob files, skipping uploads in progress
+func (s *LocalStore) List() ([]Blob, error) {
+	var blobs []Blob
+	err := filepath.WalkDir(s.root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == s.root {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if entry.IsDir() {
+			if entry.Name() == "tmp" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !ValidSHA256(entry.Name()) {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		blobs = append(blobs, Blob{SHA256: entry.Name(), Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return blobs, err
+}
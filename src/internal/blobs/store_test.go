@@ -0,0 +1,155 @@
+package blobs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/objectstore"
+)
+
+func TestLocalStore(t *testing.T) {
+	store := NewLocalStore(filepath.Join(t.TempDir(), "blobs"))
+
+	blobs, err := store.List()
+	require.NoError(t, err)
+	assert.Empty(t, blobs, "a store that was never written to is empty")
+
+	content := "\x89PNG\r\n\x1a\nnot really a png"
+	sum := sha256.Sum256([]byte(content))
+	blob, err := store.Put(strings.NewReader(content), 0)
+	require.NoError(t, err)
+	assert.Equal(t, hex.EncodeToString(sum[:]), blob.SHA256)
+	assert.EqualValues(t, len(content), blob.Size)
+
+	// The same content is stored once
+	again, err := store.Put(strings.NewReader(content), 0)
+	require.NoError(t, err)
+	assert.Equal(t, blob.SHA256, again.SHA256)
+	blobs, err = store.List()
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	assert.Equal(t, blob.SHA256, blobs[0].SHA256)
+
+	file, err := store.Open(blob.SHA256)
+	require.NoError(t, err)
+	read, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, content, string(read))
+
+	_, err = store.Put(strings.NewReader("0123456789"), 9)
+	assert.ErrorIs(t, err, ErrTooLarge)
+	_, err = store.Put(strings.NewReader("0123456789"), 10)
+	assert.NoError(t, err)
+
+	_, err = store.Open("../../etc/passwd")
+	assert.ErrorIs(t, err, ErrNotFound)
+	require.NoError(t, store.Delete(blob.SHA256))
+	require.NoError(t, store.Delete(blob.SHA256), "deleting a missing blob is not an error")
+	_, err = store.Open(blob.SHA256)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestNewStore(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("storage.path", t.TempDir())
+	store, err := NewStore(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "local", store.Name())
+
+	cfg.Set("storage.type", "tape")
+	_, err = NewStore(cfg)
+	assert.ErrorContains(t, err, "available: local, minio, s3")
+
+	// Object storage types keep blobs in their bucket
+	cfg.Set("storage.type", "s3")
+	cfg.Set("storage.s3.bucket", "gists")
+	cfg.Set("storage.s3.access_key_id", "AKIDEXAMPLE")
+	cfg.Set("storage.s3.secret_access_key", "secret")
+	store, err = NewStore(cfg)
+	require.NoError(t, err)
+	assert.IsType(t, &BucketStore{}, store)
+	assert.Equal(t, "s3", store.Name())
+}
+
+func TestBucketStore(t *testing.T) {
+	root := t.TempDir()
+	store := NewBucketStore(objectstore.NewLocalBucket(root))
+
+	content := "\x89PNG\r\n\x1a\nnot really a png"
+	blob, err := store.Put(strings.NewReader(content), 0)
+	require.NoError(t, err)
+	assert.FileExists(t, filepath.Join(root, "blobs", blob.SHA256[:2], blob.SHA256[2:4], blob.SHA256),
+		"blobs are laid out as in the local store")
+	again, err := store.Put(strings.NewReader(content), 0)
+	require.NoError(t, err)
+	assert.Equal(t, blob.SHA256, again.SHA256)
+
+	_, err = store.Put(strings.NewReader("0123456789"), 9)
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	blobs, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, blobs, 1)
+	assert.Equal(t, blob.SHA256, blobs[0].SHA256)
+
+	file, err := store.Open(blob.SHA256)
+	require.NoError(t, err)
+	read, err := io.ReadAll(file)
+	require.NoError(t, err)
+	require.NoError(t, file.Close())
+	assert.Equal(t, content, string(read))
+
+	require.NoError(t, store.Delete(blob.SHA256))
+	_, err = store.Open(blob.SHA256)
+	assert.ErrorIs(t, err, ErrNotFound)
+}
+
+func TestPrune(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.GistAttachment{}))
+
+	root := t.TempDir()
+	store := NewLocalStore(root)
+	kept, err := store.Put(strings.NewReader("kept"), 0)
+	require.NoError(t, err)
+	orphan, err := store.Put(strings.NewReader("orphan"), 0)
+	require.NoError(t, err)
+	recent, err := store.Put(strings.NewReader("recent"), 0)
+	require.NoError(t, err)
+	require.NoError(t, db.Create(&models.GistAttachment{GistID: uuid.New(), Filename: "a.bin", ContentType: "application/octet-stream", SHA256: kept.SHA256}).Error)
+
+	old := time.Now().Add(-2 * pruneGrace)
+	for _, blob := range []Blob{kept, orphan} {
+		require.NoError(t, os.Chtimes(store.path(blob.SHA256), old, old))
+	}
+
+	pruned, err := NewPruner(db, config.New(), store).Prune(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, pruned)
+	_, err = store.Open(orphan.SHA256)
+	assert.ErrorIs(t, err, ErrNotFound)
+	for _, blob := range []Blob{kept, recent} {
+		file, err := store.Open(blob.SHA256)
+		require.NoError(t, err, "referenced and recent blobs are kept")
+		file.Close()
+	}
+}
@@ -1,15 +1,22 @@
+// Package cache keeps computed values in Redis, shared by every instance,
+// or in process memory when Redis isn't enabled. The CacheManager prefixes
+// its keys with <cache.key_prefix>cache:, so flushing it leaves other data
+// kept in Redis, such as rate limit counters, alone.
 package cache
 
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"strings"
+	"log/slog"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-redis/redis/v8"
-	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Cache interface defines caching operations
@@ -34,9 +41,10 @@ type RedisCache struct {
 
 // MemoryCache implements Cache interface using in-memory storage (fallback)
 type MemoryCache struct {
-	data map[string]cacheItem
-	ttls map[string]time.Time
-	mu   sync.RWMutex
+	data       map[string]cacheItem
+	ttls       map[string]time.Time
+	mu         sync.RWMutex
+	maxEntries int // 0 = unbounded
 }
 
 type cacheItem struct {
@@ -50,35 +58,64 @@ type CacheManager struct {
 	fallback  Cache
 	enabled   bool
 	keyPrefix string
+	ttls      map[string]time.Duration
+
+	hits   atomic.Int64
+	misses atomic.Int64
 }
 
-// NewCacheManager creates a new cache manager
-func NewCacheManager(cfg *viper.Viper) *CacheManager {
+// Backends
+const (
+	BackendMemory = "memory"
+	BackendRedis  = "redis"
+)
+
+// Stats describes the cache for the admin API. Hits and misses are counted
+// by this instance since it started; Entries is only known for memory.
+type Stats struct {
+	Enabled bool   `json:"enabled"`
+	Backend string `json:"backend"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	Hits    int64  `json:"hits"`
+	Misses  int64  `json:"misses"`
+	Entries *int   `json:"entries,omitempty"`
+}
+
+// NewCacheManager creates a new cache manager. cache.type picks the
+// backend: redis, memory, or auto for Redis when redis.enabled is set.
+// If Redis can't be reached the cache is kept in memory.
+func NewCacheManager(cfg *config.Config) *CacheManager {
 	manager := &CacheManager{
 		enabled:   cfg.GetBool("cache.enabled"),
 		keyPrefix: cfg.GetString("cache.key_prefix"),
+		ttls:      ttlTiersFromConfig(cfg),
 	}
 
 	if manager.keyPrefix == "" {
 		manager.keyPrefix = "casgists:"
 	}
+	manager.keyPrefix += "cache:"
 
 	// Try to connect to Redis
-	if manager.enabled && cfg.GetBool("redis.enabled") {
+	backend := cfg.GetString("cache.type")
+	if manager.enabled && (backend == BackendRedis || (backend != BackendMemory && cfg.GetBool("redis.enabled"))) {
 		redisCache, err := NewRedisCache(cfg)
 		if err == nil {
 			manager.primary = redisCache
+		} else {
+			slog.Warn("Cache is kept in memory", "error", err)
 		}
 	}
 
 	// Always have memory cache as fallback
-	manager.fallback = NewMemoryCache()
+	manager.fallback = NewMemoryCacheWithLimit(cfg.GetInt("cache.max_entries"))
 
 	return manager
 }
 
 // NewRedisCache creates a new Redis cache instance
-func NewRedisCache(cfg *viper.Viper) (*RedisCache, error) {
+func NewRedisCache(cfg *config.Config) (*RedisCache, error) {
 	addr := cfg.GetString("redis.addr")
 	if addr == "" {
 		addr = "localhost:6379"
@@ -119,14 +156,98 @@ func NewRedisCache(cfg *viper.Viper) (*RedisCache, error) {
 
 // NewMemoryCache creates a new in-memory cache instance
 func NewMemoryCache() *MemoryCache {
+	return NewMemoryCacheWithLimit(0)
+}
+
+// NewMemoryCacheWithLimit creates an in-memory cache that holds at most
+// maxEntries keys, evicting those closest to expiring first. 0 means no
+// limit.
+func NewMemoryCacheWithLimit(maxEntries int) *MemoryCache {
+	if maxEntries < 0 {
+		maxEntries = 0
+	}
 	return &MemoryCache{
-		data: make(map[string]cacheItem),
-		ttls: make(map[string]time.Time),
+		data:       make(map[string]cacheItem),
+		ttls:       make(map[string]time.Time),
+		maxEntries: maxEntries,
 	}
 }
 
 // CacheManager methods
 
+// Enabled reports whether caching is turned on (cache.enabled)
+func (cm *CacheManager) Enabled() bool {
+	return cm.enabled
+}
+
+// Backend returns the backend values are kept in
+func (cm *CacheManager) Backend() string {
+	if cm.primary != nil {
+		return BackendRedis
+	}
+	return BackendMemory
+}
+
+// TTL returns how long values of a TTL tier are kept
+func (cm *CacheManager) TTL(tier string) time.Duration {
+	if ttl, ok := cm.ttls[tier]; ok {
+		return ttl
+	}
+	return defaultTTLs[TierShort]
+}
+
+// Ping checks that the backend answers. Memory always does.
+func (cm *CacheManager) Ping(ctx context.Context) error {
+	if redisCache, ok := cm.primary.(*RedisCache); ok {
+		return redisCache.client.Ping(ctx).Err()
+	}
+	return nil
+}
+
+// Stats describes the cache and checks its backend
+func (cm *CacheManager) Stats(ctx context.Context) Stats {
+	stats := Stats{
+		Enabled: cm.enabled,
+		Backend: cm.Backend(),
+		Healthy: true,
+		Hits:    cm.hits.Load(),
+		Misses:  cm.misses.Load(),
+	}
+	if err := cm.Ping(ctx); err != nil {
+		stats.Healthy = false
+		stats.Error = err.Error()
+	}
+	if memory, ok := cm.fallback.(*MemoryCache); ok && cm.primary == nil {
+		entries := memory.Len()
+		stats.Entries = &entries
+	}
+	return stats
+}
+
+// Counts returns the hits and misses since startup, without the backend
+// check Stats does
+func (cm *CacheManager) Counts() (hits, misses int64) {
+	return cm.hits.Load(), cm.misses.Load()
+}
+
+// Flush removes every cached value, or with a namespace only the values
+// in it. The number of keys removed is only known for memory and Redis
+// removes them in batches, so it is not returned.
+func (cm *CacheManager) Flush(ctx context.Context, namespace string) error {
+	pattern := "*"
+	if namespace != "" {
+		pattern = namespace + ":*"
+	}
+	if err := cm.DeletePattern(ctx, pattern); err != nil {
+		return err
+	}
+	if namespace != "" {
+		// Values directly under the namespace name, such as trending
+		return cm.Delete(ctx, namespace)
+	}
+	return nil
+}
+
 func (cm *CacheManager) key(key string) string {
 	return cm.keyPrefix + key
 }
@@ -142,12 +263,19 @@ func (cm *CacheManager) Get(ctx context.Context, key string) (string, error) {
 	if cm.primary != nil {
 		value, err := cm.primary.Get(ctx, fullKey)
 		if err == nil {
+			cm.hits.Add(1)
 			return value, nil
 		}
 	}
 
 	// Fallback to memory cache
-	return cm.fallback.Get(ctx, fullKey)
+	value, err := cm.fallback.Get(ctx, fullKey)
+	if err != nil {
+		cm.misses.Add(1)
+		return "", err
+	}
+	cm.hits.Add(1)
+	return value, nil
 }
 
 func (cm *CacheManager) Set(ctx context.Context, key string, value interface{}, ttl time.Duration) error {
@@ -184,6 +312,8 @@ func (cm *CacheManager) Delete(ctx context.Context, key string) error {
 	return nil
 }
 
+// DeletePattern removes the keys matching a glob pattern, where * matches
+// any run of characters and ? any one character
 func (cm *CacheManager) DeletePattern(ctx context.Context, pattern string) error {
 	if !cm.enabled {
 		return nil
@@ -192,12 +322,13 @@ func (cm *CacheManager) DeletePattern(ctx context.Context, pattern string) error
 	fullPattern := cm.key(pattern)
 
 	// Delete from both caches
+	var err error
 	if cm.primary != nil {
-		cm.primary.DeletePattern(ctx, fullPattern)
+		err = cm.primary.DeletePattern(ctx, fullPattern)
 	}
 	cm.fallback.DeletePattern(ctx, fullPattern)
 
-	return nil
+	return err
 }
 
 func (cm *CacheManager) GetJSON(ctx context.Context, key string, dest interface{}) error {
@@ -250,17 +381,25 @@ func (rc *RedisCache) Delete(ctx context.Context, key string) error {
 	return rc.client.Del(ctx, key).Err()
 }
 
+// DeletePattern removes matching keys a batch at a time. SCAN doesn't block
+// the server the way KEYS does on a large keyspace.
 func (rc *RedisCache) DeletePattern(ctx context.Context, pattern string) error {
-	keys, err := rc.client.Keys(ctx, pattern).Result()
-	if err != nil {
-		return err
-	}
-
-	if len(keys) > 0 {
-		return rc.client.Del(ctx, keys...).Err()
+	var cursor uint64
+	for {
+		keys, next, err := rc.client.Scan(ctx, cursor, pattern, 500).Result()
+		if err != nil {
+			return fmt.Errorf("failed to scan cache keys: %w", err)
+		}
+		if len(keys) > 0 {
+			if err := rc.client.Del(ctx, keys...).Err(); err != nil {
+				return fmt.Errorf("failed to delete cache keys: %w", err)
+			}
+		}
+		if next == 0 {
+			return nil
+		}
+		cursor = next
 	}
-
-	return nil
 }
 
 func (rc *RedisCache) Exists(ctx context.Context, key string) (bool, error) {
@@ -346,11 +485,18 @@ func (mc *MemoryCache) Set(ctx context.Context, key string, value interface{}, t
 		strValue = string(data)
 	}
 
-	expiresAt := time.Now().Add(ttl)
+	// Like Redis, no TTL means the value is kept until it is deleted
+	expiresAt := neverExpires
+	if ttl > 0 {
+		expiresAt = time.Now().Add(ttl)
+	}
 	
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 	
+	if _, exists := mc.data[key]; !exists && mc.maxEntries > 0 && len(mc.data) >= mc.maxEntries {
+		mc.evict()
+	}
 	mc.data[key] = cacheItem{
 		value:     strValue,
 		expiresAt: expiresAt,
@@ -373,9 +519,7 @@ func (mc *MemoryCache) DeletePattern(ctx context.Context, pattern string) error
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
 	
-	// Simple pattern matching for memory cache
 	for key := range mc.data {
-		// Basic wildcard matching
 		if matchPattern(pattern, key) {
 			delete(mc.data, key)
 			delete(mc.ttls, key)
@@ -394,9 +538,28 @@ func (mc *MemoryCache) Exists(ctx context.Context, key string) (bool, error) {
 	return exists, nil
 }
 
+// Increment adds one to a counter, starting a missing or expired one at 1
+// without an expiry, like Redis INCR
 func (mc *MemoryCache) Increment(ctx context.Context, key string) (int64, error) {
-	// Not implemented for memory cache
-	return 0, fmt.Errorf("increment not supported in memory cache")
+	mc.mu.Lock()
+	defer mc.mu.Unlock()
+
+	item, exists := mc.data[key]
+	if !exists || time.Now().After(item.expiresAt) {
+		if !exists && mc.maxEntries > 0 && len(mc.data) >= mc.maxEntries {
+			mc.evict()
+		}
+		item = cacheItem{value: "0", expiresAt: neverExpires}
+	}
+	var count int64
+	if _, err := fmt.Sscan(item.value, &count); err != nil {
+		return 0, errors.New("value is not an integer")
+	}
+	count++
+	item.value = fmt.Sprint(count)
+	mc.data[key] = item
+	mc.ttls[key] = item.expiresAt
+	return count, nil
 }
 
 func (mc *MemoryCache) Expire(ctx context.Context, key string, ttl time.Duration) error {
@@ -427,6 +590,38 @@ func (mc *MemoryCache) SetJSON(ctx context.Context, key string, value interface{
 	return mc.Set(ctx, key, string(data), ttl)
 }
 
+// Len returns the number of keys held, including expired ones not yet
+// cleared
+func (mc *MemoryCache) Len() int {
+	mc.mu.RLock()
+	defer mc.mu.RUnlock()
+	return len(mc.data)
+}
+
+// evict makes room for a key, dropping expired keys or else the key that
+// expires soonest. The caller holds the lock.
+func (mc *MemoryCache) evict() {
+	now := time.Now()
+	var (
+		soonest   string
+		soonestAt time.Time
+	)
+	for key, item := range mc.data {
+		if now.After(item.expiresAt) {
+			delete(mc.data, key)
+			delete(mc.ttls, key)
+			continue
+		}
+		if soonest == "" || item.expiresAt.Before(soonestAt) {
+			soonest, soonestAt = key, item.expiresAt
+		}
+	}
+	if len(mc.data) >= mc.maxEntries && soonest != "" {
+		delete(mc.data, soonest)
+		delete(mc.ttls, soonest)
+	}
+}
+
 func (mc *MemoryCache) Close() error {
 	mc.mu.Lock()
 	defer mc.mu.Unlock()
@@ -438,23 +633,31 @@ func (mc *MemoryCache) Close() error {
 
 // Helper functions
 
+// matchPattern matches a key against a Redis-style glob: * matches any run
+// of characters, including none, and ? any one character
 func matchPattern(pattern, str string) bool {
-	// Very basic wildcard matching for memory cache
-	if pattern == "*" {
-		return true
-	}
-	
-	if strings.HasSuffix(pattern, "*") {
-		prefix := pattern[:len(pattern)-1]
-		return strings.HasPrefix(str, prefix)
+	p, k := 0, 0
+	star, mark := -1, 0
+	for k < len(str) {
+		switch {
+		case p < len(pattern) && pattern[p] == '*':
+			star, mark = p, k
+			p++
+		case p < len(pattern) && (pattern[p] == '?' || pattern[p] == str[k]):
+			p++
+			k++
+		case star >= 0:
+			// Let the last * take one more character
+			mark++
+			p, k = star+1, mark
+		default:
+			return false
+		}
 	}
-	
-	if strings.HasPrefix(pattern, "*") {
-		suffix := pattern[1:]
-		return strings.HasSuffix(str, suffix)
+	for p < len(pattern) && pattern[p] == '*' {
+		p++
 	}
-	
-	return pattern == str
+	return p == len(pattern)
 }
 
 // Cache keys constants
@@ -468,8 +671,39 @@ const (
 	CacheKeyTrending   = "trending"
 	CacheKeyPopular    = "popular"
 	CacheKeyConfig     = "config"
+	CacheKeyCodeImage  = "codeimage:%s"
+)
+
+// Key namespaces. Every key starts with one, so a namespace can be
+// flushed on its own.
+const (
+	NamespaceUser       = "user"
+	NamespaceGist       = "gist"
+	NamespaceSearch     = "search"
+	NamespacePublicRead = "public_read" // Anonymous API responses
+	NamespaceCodeImage  = "codeimage"
+	NamespaceTrending   = "trending"
+	NamespacePopular    = "popular"
+	NamespaceConfig     = "config"
 )
 
+// Namespaces lists the key namespaces
+func Namespaces() []string {
+	return []string{
+		NamespaceUser, NamespaceGist, NamespaceSearch, NamespacePublicRead,
+		NamespaceCodeImage, NamespaceTrending, NamespacePopular, NamespaceConfig,
+	}
+}
+
+// Key joins a namespace and the parts of a key with colons
+func Key(namespace string, parts ...string) string {
+	key := namespace
+	for _, part := range parts {
+		key += ":" + part
+	}
+	return key
+}
+
 // Cache TTL constants
 const (
 	TTLShort   = 5 * time.Minute
@@ -478,6 +712,36 @@ const (
 	TTLVeryLong = 24 * time.Hour
 )
 
+// TTL tiers, configured under cache.ttl_tiers and defaulting to the TTL
+// constants
+const (
+	TierShort    = "short"     // Search results and other values that go stale quickly
+	TierMedium   = "medium"    // Gists
+	TierLong     = "long"      // Users
+	TierVeryLong = "very_long" // Values derived from content, such as code images
+)
+
+var defaultTTLs = map[string]time.Duration{
+	TierShort:    TTLShort,
+	TierMedium:   TTLMedium,
+	TierLong:     TTLLong,
+	TierVeryLong: TTLVeryLong,
+}
+
+// neverExpires is the expiry of memory values set without a TTL
+var neverExpires = time.Date(9999, 1, 1, 0, 0, 0, 0, time.UTC)
+
+func ttlTiersFromConfig(cfg *config.Config) map[string]time.Duration {
+	ttls := make(map[string]time.Duration, len(defaultTTLs))
+	for tier, ttl := range defaultTTLs {
+		if configured := cfg.GetDuration("cache.ttl_tiers." + tier); configured > 0 {
+			ttl = configured
+		}
+		ttls[tier] = ttl
+	}
+	return ttls
+}
+
 // Helper functions for common cache operations
 
 func UserKey(userID string) string {
@@ -498,4 +762,8 @@ func UserStatsKey(userID string) string {
 
 func SearchKey(query string) string {
 	return fmt.Sprintf(CacheKeySearch, query)
+}
+
+func CodeImageKey(hash string) string {
+	return fmt.Sprintf(CacheKeyCodeImage, hash)
 }
\ No newline at end of file
@@ -0,0 +1,144 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// Invalidator removes cached values when the rows they were built from
+// change. Services call it directly, and Register hooks it into GORM so
+// writes made anywhere else are caught too.
+type Invalidator struct {
+	cache *CacheManager
+}
+
+// NewInvalidator creates an invalidator for a cache manager
+func NewInvalidator(cacheManager *CacheManager) *Invalidator {
+	return &Invalidator{cache: cacheManager}
+}
+
+// Gist removes what was cached about a gist: the gist, its owner's gist
+// lists, gist lists and rankings, search results and anonymous API
+// responses. Without a gist ID every gist is dropped, and without an owner
+// every user's gist lists.
+func (i *Invalidator) Gist(ctx context.Context, gistID, ownerID string) error {
+	patterns := []string{
+		Key(NamespaceGist, "list", "*"),
+		Key(NamespaceSearch, "*"),
+		Key(NamespacePublicRead, "*"),
+	}
+	if gistID != "" {
+		patterns = append(patterns, GistKey(gistID), Key(NamespaceGist, gistID, "*"))
+	} else {
+		patterns = append(patterns, Key(NamespaceGist, "*"))
+	}
+	if ownerID != "" {
+		patterns = append(patterns, UserGistsKey(ownerID)+"*")
+	} else {
+		patterns = append(patterns, Key(NamespaceUser, "*", "gists*"))
+	}
+	return i.delete(ctx, patterns, NamespaceTrending, NamespacePopular)
+}
+
+// User removes what was cached about a user: the user, by ID and by
+// username, their lists and stats, and anonymous API responses, which
+// show user names
+func (i *Invalidator) User(ctx context.Context, userID, username string) error {
+	patterns := []string{Key(NamespacePublicRead, "*")}
+	if userID != "" {
+		patterns = append(patterns, UserKey(userID), Key(NamespaceUser, userID, "*"))
+	}
+	if username != "" {
+		patterns = append(patterns, UserKey(username))
+	}
+	if userID == "" && username == "" {
+		patterns = append(patterns, Key(NamespaceUser, "*"))
+	}
+	return i.delete(ctx, patterns)
+}
+
+func (i *Invalidator) delete(ctx context.Context, patterns []string, keys ...string) error {
+	if i.cache == nil || !i.cache.Enabled() {
+		return nil
+	}
+	for _, pattern := range patterns {
+		if err := i.cache.DeletePattern(ctx, pattern); err != nil {
+			return fmt.Errorf("failed to invalidate %s: %w", pattern, err)
+		}
+	}
+	for _, key := range keys {
+		if err := i.cache.Delete(ctx, key); err != nil {
+			return fmt.Errorf("failed to invalidate %s: %w", key, err)
+		}
+	}
+	return nil
+}
+
+// gistTables are the tables whose rows make up a gist
+var gistTables = map[string]bool{
+	"gists":      true,
+	"gist_files": true,
+	"gist_stars": true,
+	"gist_tags":  true,
+}
+
+// Register invalidates cached values after every create, update and delete
+// GORM runs on gists and users. Raw SQL isn't seen.
+func (i *Invalidator) Register(db *gorm.DB) error {
+	if err := db.Callback().Create().After("gorm:create").Register("casgists:cache_invalidation", i.afterWrite); err != nil {
+		return err
+	}
+	if err := db.Callback().Update().After("gorm:update").Register("casgists:cache_invalidation", i.afterWrite); err != nil {
+		return err
+	}
+	return db.Callback().Delete().After("gorm:delete").Register("casgists:cache_invalidation", i.afterWrite)
+}
+
+func (i *Invalidator) afterWrite(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	table := db.Statement.Schema.Table
+	if !gistTables[table] && table != "users" {
+		return
+	}
+	ctx := db.Statement.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	// Batch writes leave the IDs empty, which widens what is removed
+	row := reflect.Indirect(db.Statement.ReflectValue)
+	var err error
+	switch table {
+	case "gists":
+		err = i.Gist(ctx, field(row, "ID"), field(row, "UserID"))
+	case "users":
+		err = i.User(ctx, field(row, "ID"), field(row, "Username"))
+	default:
+		err = i.Gist(ctx, field(row, "GistID"), "")
+	}
+	if err != nil {
+		slog.Error("Cache invalidation failed", "table", table, "error", err)
+	}
+}
+
+// field returns a struct field as a string, or "" when the row isn't a
+// single struct or the field is unset
+func field(row reflect.Value, name string) string {
+	if row.Kind() != reflect.Struct {
+		return ""
+	}
+	value := row.FieldByName(name)
+	if !value.IsValid() || value.IsZero() {
+		return ""
+	}
+	if value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	return fmt.Sprint(value.Interface())
+}
@@ -54,4 +54,46 @@ func (m *MemoryCacheService) Delete(key string) error {
 func (m *MemoryCacheService) Exists(key string) (bool, error) {
 	ctx := context.Background()
 	return m.MemoryCache.Exists(ctx, key)
-}
\ No newline at end of file
+}
+// managerService implements Service on top of a CacheManager, so health
+// checks exercise the backend the rest of the server uses
+type managerService struct {
+	manager *CacheManager
+}
+
+// NewManagerService creates a Service backed by a cache manager, or by
+// memory when the cache is disabled
+func NewManagerService(manager *CacheManager) Service {
+	if manager == nil || !manager.Enabled() {
+		return NewMemoryCacheService()
+	}
+	return &managerService{manager: manager}
+}
+
+// Backend returns the backend of the cache manager
+func (m *managerService) Backend() string {
+	return m.manager.Backend()
+}
+
+// Get retrieves a value from cache
+func (m *managerService) Get(key string, dest interface{}) error {
+	return m.manager.GetJSON(context.Background(), key, dest)
+}
+
+// Set stores a value in cache
+func (m *managerService) Set(key string, value interface{}, ttl time.Duration) error {
+	return m.manager.SetJSON(context.Background(), key, value, ttl)
+}
+
+// Delete removes a value from cache
+func (m *managerService) Delete(key string) error {
+	return m.manager.Delete(context.Background(), key)
+}
+
+// Exists checks if a key exists
+func (m *managerService) Exists(key string) (bool, error) {
+	if _, err := m.manager.Get(context.Background(), key); err != nil {
+		return false, nil
+	}
+	return true, nil
+}
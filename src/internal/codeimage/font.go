@@ -0,0 +1,61 @@
+package codeimage
+
+//go:generate go run ./internal/fontgen
+
+import (
+	"bytes"
+	_ "embed"
+	"image"
+	"image/png"
+	"sync"
+)
+
+// font.png is DejaVu Sans Mono pre-rasterized by internal/fontgen. DejaVu
+// fonts are derived from Bitstream Vera and are free to redistribute and
+// embed under the Bitstream Vera license.
+//
+//go:embed font.png
+var fontPNG []byte
+
+var (
+	atlasOnce  sync.Once
+	atlas      *image.Alpha
+	glyphIndex map[rune]int
+)
+
+// loadAtlas decodes the embedded atlas into an alpha mask once
+func loadAtlas() {
+	atlasOnce.Do(func() {
+		img, err := png.Decode(bytes.NewReader(fontPNG))
+		if err != nil {
+			panic("codeimage: invalid embedded font atlas: " + err.Error())
+		}
+		gray, ok := img.(*image.Gray)
+		if !ok {
+			panic("codeimage: embedded font atlas is not grayscale")
+		}
+		// A Gray image is fully opaque when used as a mask, so copy its
+		// coverage values into an Alpha image
+		atlas = &image.Alpha{Pix: gray.Pix, Stride: gray.Stride, Rect: gray.Rect}
+
+		glyphIndex = make(map[rune]int)
+		i := 0
+		for _, r := range glyphRunes {
+			glyphIndex[r] = i
+			i++
+		}
+	})
+}
+
+// glyphRect returns the atlas cell for r, falling back to '?' for runes the
+// atlas does not include
+func glyphRect(r rune) image.Rectangle {
+	loadAtlas()
+	i, ok := glyphIndex[r]
+	if !ok {
+		i = glyphIndex['?']
+	}
+	x := (i % glyphsPerRow) * glyphWidth
+	y := (i / glyphsPerRow) * glyphHeight
+	return image.Rect(x, y, x+glyphWidth, y+glyphHeight)
+}
@@ -0,0 +1,23 @@
+package codeimage
+
+import "github.com/casapps/casgists/src/internal/syntax"
+
+// The tokenizer is shared with the markdown renderer, which colors code
+// blocks with it
+type (
+	tokenKind = syntax.TokenKind
+	token     = syntax.Token
+)
+
+const (
+	tokenText    = syntax.TokenText
+	tokenKeyword = syntax.TokenKeyword
+	tokenString  = syntax.TokenString
+	tokenComment = syntax.TokenComment
+	tokenNumber  = syntax.TokenNumber
+)
+
+// highlight splits source lines into colored tokens
+func highlight(lines []string, language string) [][]token {
+	return syntax.Highlight(lines, language)
+}
@@ -0,0 +1,442 @@
+// Command fontgen rasterizes a monospace TrueType font into the glyph atlas
+// embedded by the codeimage package. It only depends on the standard library
+// so the atlas can be regenerated without extra modules:
+//
+//	go generate ./src/internal/codeimage
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"go/format"
+	"image"
+	"image/color"
+	"image/png"
+	"log"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// charset is every rune included in the atlas: printable ASCII, Latin-1 and
+// a few symbols common in code comments
+func charset() []rune {
+	var runes []rune
+	for r := rune(0x20); r <= 0x7e; r++ {
+		runes = append(runes, r)
+	}
+	for r := rune(0xa1); r <= 0xff; r++ {
+		runes = append(runes, r)
+	}
+	return append(runes, '…', '→', '←', '•', '✓', '✗', '─', '│', '└', '├')
+}
+
+func main() {
+	ttfPath := flag.String("ttf", "/usr/share/fonts/truetype/dejavu/DejaVuSansMono.ttf", "monospace TrueType font to rasterize")
+	advance := flag.Int("advance", 14, "glyph advance (cell width) in pixels")
+	out := flag.String("out", ".", "directory to write font.png and font_gen.go to")
+	flag.Parse()
+
+	data, err := os.ReadFile(*ttfPath)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := parse(data)
+	if err != nil {
+		log.Fatalf("parse %s: %v", *ttfPath, err)
+	}
+
+	// Scale so the advance of the (monospace) font is a whole number of pixels
+	scale := float64(*advance) / float64(f.advance(f.glyphIndex('M')))
+	ascent := int(math.Ceil(float64(f.ascender) * scale))
+	descent := int(math.Ceil(float64(-f.descender) * scale))
+	cellW, cellH := *advance, ascent+descent
+
+	runes := charset()
+	const perRow = 32
+	rows := (len(runes) + perRow - 1) / perRow
+	atlas := image.NewGray(image.Rect(0, 0, perRow*cellW, rows*cellH))
+
+	for i, r := range runes {
+		gi := f.glyphIndex(r)
+		if gi == 0 {
+			log.Printf("warning: %q (U+%04X) is not in the font", r, r)
+		}
+		contours, err := f.glyph(gi, 0)
+		if err != nil {
+			log.Fatalf("glyph %q: %v", r, err)
+		}
+		ox, oy := (i%perRow)*cellW, (i/perRow)*cellH
+		rasterize(atlas, image.Rect(ox, oy, ox+cellW, oy+cellH), contours, scale, float64(oy+ascent), float64(ox))
+	}
+
+	pngFile, err := os.Create(filepath.Join(*out, "font.png"))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := png.Encode(pngFile, atlas); err != nil {
+		log.Fatal(err)
+	}
+	pngFile.Close()
+
+	var quoted strings.Builder
+	for _, r := range runes {
+		if r == '"' || r == '\\' {
+			quoted.WriteRune('\\')
+		}
+		quoted.WriteRune(r)
+	}
+	src := fmt.Sprintf(`// Code generated by fontgen from %s; DO NOT EDIT.
+
+package codeimage
+
+const (
+	glyphWidth    = %d
+	glyphHeight   = %d
+	glyphAscent   = %d
+	glyphsPerRow  = %d
+)
+
+// glyphRunes lists the runes in the atlas in order
+const glyphRunes = "%s"
+`, filepath.Base(*ttfPath), cellW, cellH, ascent, perRow, quoted.String())
+	formatted, err := format.Source([]byte(src))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(*out, "font_gen.go"), formatted, 0o644); err != nil {
+		log.Fatal(err)
+	}
+}
+
+// point is a glyph outline point in font units
+type point struct {
+	x, y    float64
+	onCurve bool
+}
+
+// font holds the tables needed to rasterize glyphs
+type font struct {
+	tables     map[string][]byte
+	longLoca   bool
+	numGlyphs  int
+	ascender   int16
+	descender  int16
+	numHMetric int
+}
+
+func parse(data []byte) (*font, error) {
+	if len(data) < 12 {
+		return nil, errors.New("file too short")
+	}
+	f := &font{tables: make(map[string][]byte)}
+	numTables := int(binary.BigEndian.Uint16(data[4:]))
+	for i := 0; i < numTables; i++ {
+		rec := data[12+16*i:]
+		tag := string(rec[:4])
+		off := binary.BigEndian.Uint32(rec[8:])
+		length := binary.BigEndian.Uint32(rec[12:])
+		f.tables[tag] = data[off : off+length]
+	}
+	for _, tag := range []string{"head", "maxp", "hhea", "hmtx", "loca", "glyf", "cmap"} {
+		if f.tables[tag] == nil {
+			return nil, fmt.Errorf("missing %s table", tag)
+		}
+	}
+	f.longLoca = binary.BigEndian.Uint16(f.tables["head"][50:]) == 1
+	f.numGlyphs = int(binary.BigEndian.Uint16(f.tables["maxp"][4:]))
+	hhea := f.tables["hhea"]
+	f.ascender = int16(binary.BigEndian.Uint16(hhea[4:]))
+	f.descender = int16(binary.BigEndian.Uint16(hhea[6:]))
+	f.numHMetric = int(binary.BigEndian.Uint16(hhea[34:]))
+	return f, nil
+}
+
+// glyphIndex maps a rune through the Unicode BMP (format 4) cmap subtable
+func (f *font) glyphIndex(r rune) int {
+	cmap := f.tables["cmap"]
+	n := int(binary.BigEndian.Uint16(cmap[2:]))
+	for i := 0; i < n; i++ {
+		rec := cmap[4+8*i:]
+		platform, encoding := binary.BigEndian.Uint16(rec), binary.BigEndian.Uint16(rec[2:])
+		sub := cmap[binary.BigEndian.Uint32(rec[4:]):]
+		if platform != 3 || encoding != 1 || binary.BigEndian.Uint16(sub) != 4 {
+			continue
+		}
+		segX2 := int(binary.BigEndian.Uint16(sub[6:]))
+		ends, starts := sub[14:], sub[16+segX2:]
+		deltas, rangeOffsets := sub[16+2*segX2:], sub[16+3*segX2:]
+		for s := 0; s < segX2; s += 2 {
+			end, start := rune(binary.BigEndian.Uint16(ends[s:])), rune(binary.BigEndian.Uint16(starts[s:]))
+			if r < start || r > end {
+				continue
+			}
+			delta := int(int16(binary.BigEndian.Uint16(deltas[s:])))
+			ro := int(binary.BigEndian.Uint16(rangeOffsets[s:]))
+			if ro == 0 {
+				return (int(r) + delta) & 0xffff
+			}
+			gi := int(binary.BigEndian.Uint16(rangeOffsets[s+ro+2*int(r-start):]))
+			if gi == 0 {
+				return 0
+			}
+			return (gi + delta) & 0xffff
+		}
+	}
+	return 0
+}
+
+func (f *font) advance(gi int) int {
+	if gi >= f.numHMetric {
+		gi = f.numHMetric - 1
+	}
+	return int(binary.BigEndian.Uint16(f.tables["hmtx"][4*gi:]))
+}
+
+// glyph returns the contours of a glyph, resolving composite glyphs
+func (f *font) glyph(gi, depth int) ([][]point, error) {
+	if depth > 8 {
+		return nil, errors.New("composite glyph nesting too deep")
+	}
+	if gi < 0 || gi >= f.numGlyphs {
+		return nil, fmt.Errorf("glyph %d out of range", gi)
+	}
+	loca := f.tables["loca"]
+	var start, end int
+	if f.longLoca {
+		start, end = int(binary.BigEndian.Uint32(loca[4*gi:])), int(binary.BigEndian.Uint32(loca[4*gi+4:]))
+	} else {
+		start, end = 2*int(binary.BigEndian.Uint16(loca[2*gi:])), 2*int(binary.BigEndian.Uint16(loca[2*gi+2:]))
+	}
+	if start == end {
+		return nil, nil // Empty glyph such as space
+	}
+	g := f.tables["glyf"][start:end]
+	numContours := int(int16(binary.BigEndian.Uint16(g)))
+	if numContours < 0 {
+		return f.composite(g[10:], depth)
+	}
+
+	endPts := make([]int, numContours)
+	for i := range endPts {
+		endPts[i] = int(binary.BigEndian.Uint16(g[10+2*i:]))
+	}
+	numPoints := endPts[numContours-1] + 1
+	p := 10 + 2*numContours
+	p += 2 + int(binary.BigEndian.Uint16(g[p:])) // Skip instructions
+
+	flags := make([]byte, 0, numPoints)
+	for len(flags) < numPoints {
+		flag := g[p]
+		p++
+		flags = append(flags, flag)
+		if flag&0x08 != 0 {
+			for n := g[p]; n > 0; n-- {
+				flags = append(flags, flag)
+			}
+			p++
+		}
+	}
+
+	coords := func(short, same byte) []float64 {
+		values := make([]float64, numPoints)
+		v := 0
+		for i, flag := range flags {
+			switch {
+			case flag&short != 0:
+				d := int(g[p])
+				p++
+				if flag&same == 0 {
+					d = -d
+				}
+				v += d
+			case flag&same == 0:
+				v += int(int16(binary.BigEndian.Uint16(g[p:])))
+				p += 2
+			}
+			values[i] = float64(v)
+		}
+		return values
+	}
+	xs := coords(0x02, 0x10)
+	ys := coords(0x04, 0x20)
+
+	contours := make([][]point, 0, numContours)
+	first := 0
+	for _, last := range endPts {
+		contour := make([]point, 0, last-first+1)
+		for i := first; i <= last; i++ {
+			contour = append(contour, point{xs[i], ys[i], flags[i]&0x01 != 0})
+		}
+		contours = append(contours, contour)
+		first = last + 1
+	}
+	return contours, nil
+}
+
+// composite assembles a composite glyph from its transformed components
+func (f *font) composite(g []byte, depth int) ([][]point, error) {
+	var contours [][]point
+	for p := 0; ; {
+		flags := binary.BigEndian.Uint16(g[p:])
+		gi := int(binary.BigEndian.Uint16(g[p+2:]))
+		p += 4
+
+		var dx, dy float64
+		if flags&0x0001 != 0 { // ARG_1_AND_2_ARE_WORDS
+			dx, dy = float64(int16(binary.BigEndian.Uint16(g[p:]))), float64(int16(binary.BigEndian.Uint16(g[p+2:])))
+			p += 4
+		} else {
+			dx, dy = float64(int8(g[p])), float64(int8(g[p+1]))
+			p += 2
+		}
+		if flags&0x0002 == 0 {
+			return nil, errors.New("point-matched composite glyphs are not supported")
+		}
+
+		a, b, c, d := 1.0, 0.0, 0.0, 1.0
+		f2dot14 := func(o int) float64 { return float64(int16(binary.BigEndian.Uint16(g[o:]))) / 16384 }
+		switch {
+		case flags&0x0008 != 0: // WE_HAVE_A_SCALE
+			a, d = f2dot14(p), f2dot14(p)
+			p += 2
+		case flags&0x0040 != 0: // WE_HAVE_AN_X_AND_Y_SCALE
+			a, d = f2dot14(p), f2dot14(p+2)
+			p += 4
+		case flags&0x0080 != 0: // WE_HAVE_A_TWO_BY_TWO
+			a, b, c, d = f2dot14(p), f2dot14(p+2), f2dot14(p+4), f2dot14(p+6)
+			p += 8
+		}
+
+		component, err := f.glyph(gi, depth+1)
+		if err != nil {
+			return nil, err
+		}
+		for _, contour := range component {
+			transformed := make([]point, len(contour))
+			for i, pt := range contour {
+				transformed[i] = point{a*pt.x + c*pt.y + dx, b*pt.x + d*pt.y + dy, pt.onCurve}
+			}
+			contours = append(contours, transformed)
+		}
+
+		if flags&0x0020 == 0 { // MORE_COMPONENTS
+			return contours, nil
+		}
+	}
+}
+
+// edge is a line segment of a flattened outline in pixel space
+type edge struct {
+	x0, y0, x1, y1 float64
+}
+
+// flatten converts quadratic outlines into pixel-space line segments
+func flatten(contours [][]point, scale, baseline, left float64) []edge {
+	var edges []edge
+	toPixel := func(p point) (float64, float64) { return left + p.x*scale, baseline - p.y*scale }
+
+	for _, contour := range contours {
+		if len(contour) < 2 {
+			continue
+		}
+		// Insert the implied on-curve midpoints between consecutive off-curve points
+		var pts []point
+		for i, p := range contour {
+			prev := contour[(i+len(contour)-1)%len(contour)]
+			if !p.onCurve && !prev.onCurve {
+				pts = append(pts, point{(p.x + prev.x) / 2, (p.y + prev.y) / 2, true})
+			}
+			pts = append(pts, p)
+		}
+		// Start from an on-curve point
+		startIdx := 0
+		for i, p := range pts {
+			if p.onCurve {
+				startIdx = i
+				break
+			}
+		}
+		pts = append(pts[startIdx:], pts[:startIdx]...)
+		pts = append(pts, pts[0])
+
+		cx, cy := toPixel(pts[0])
+		for i := 1; i < len(pts); i++ {
+			if pts[i].onCurve {
+				x, y := toPixel(pts[i])
+				edges = append(edges, edge{cx, cy, x, y})
+				cx, cy = x, y
+				continue
+			}
+			ctrlX, ctrlY := toPixel(pts[i])
+			endX, endY := toPixel(pts[i+1])
+			const steps = 8
+			for s := 1; s <= steps; s++ {
+				t := float64(s) / steps
+				x := (1-t)*(1-t)*cx + 2*(1-t)*t*ctrlX + t*t*endX
+				y := (1-t)*(1-t)*cy + 2*(1-t)*t*ctrlY + t*t*endY
+				edges = append(edges, edge{cx, cy, x, y})
+				cx, cy = x, y
+			}
+			i++
+		}
+	}
+	return edges
+}
+
+// rasterize fills the outline into dst with 8x8 supersampled coverage using
+// the nonzero winding rule, clipped to cell
+func rasterize(dst *image.Gray, cell image.Rectangle, contours [][]point, scale, baseline, left float64) {
+	edges := flatten(contours, scale, baseline, left)
+	if len(edges) == 0 {
+		return
+	}
+
+	const samples = 8
+	type crossing struct {
+		x   float64
+		dir int
+	}
+	for py := cell.Min.Y; py < cell.Max.Y; py++ {
+		coverage := make([]int, cell.Dx())
+		for sy := 0; sy < samples; sy++ {
+			y := float64(py) + (float64(sy)+0.5)/samples
+			var crossings []crossing
+			for _, e := range edges {
+				if (e.y0 <= y) == (e.y1 <= y) {
+					continue
+				}
+				x := e.x0 + (y-e.y0)*(e.x1-e.x0)/(e.y1-e.y0)
+				dir := 1
+				if e.y1 < e.y0 {
+					dir = -1
+				}
+				crossings = append(crossings, crossing{x, dir})
+			}
+			sort.Slice(crossings, func(i, j int) bool { return crossings[i].x < crossings[j].x })
+
+			for px := cell.Min.X; px < cell.Max.X; px++ {
+				for sx := 0; sx < samples; sx++ {
+					x := float64(px) + (float64(sx)+0.5)/samples
+					winding := 0
+					for _, c := range crossings {
+						if c.x > x {
+							break
+						}
+						winding += c.dir
+					}
+					if winding != 0 {
+						coverage[px-cell.Min.X]++
+					}
+				}
+			}
+		}
+		for i, n := range coverage {
+			dst.SetGray(cell.Min.X+i, py, color.Gray{Y: uint8(n * 255 / (samples * samples))})
+		}
+	}
+}
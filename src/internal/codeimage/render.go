@@ -0,0 +1,290 @@
+// Package codeimage renders source code as a syntax-highlighted PNG in the
+// style of a desktop editor window, for sharing snippets on social media and
+// in slides. It only depends on the standard library: glyphs come from an
+// embedded pre-rasterized font atlas.
+package codeimage
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// Layout, in pixels
+const (
+	MinWidth = 400
+
+	backdropPadding = 48
+	windowPadding   = 24
+	titleBarHeight  = 44
+	cornerRadius    = 10
+	lineHeight      = glyphHeight + 4
+	tabWidth        = 4
+	minColumns      = 10
+)
+
+var (
+	// ErrTooLarge is returned when the code exceeds Options.MaxBytes
+	ErrTooLarge = errors.New("code is too large to render")
+	// ErrUnknownTheme is returned for theme names that are not built in
+	ErrUnknownTheme = errors.New("unknown theme")
+	// ErrInvalidWidth is returned for widths outside [MinWidth, Options.MaxWidth]
+	ErrInvalidWidth = errors.New("invalid image width")
+)
+
+// Options controls how code is rendered
+type Options struct {
+	Theme       string // Theme name; DefaultTheme if empty
+	Language    string // Language ID from the syntax package; plain text if unknown
+	Title       string // Shown centered in the title bar, usually the filename
+	Width       int    // Image width; 0 fits the longest line up to MaxWidth
+	LineNumbers bool
+	MaxWidth    int // Upper bound for Width; 0 means unlimited
+	MaxLines    int // Rendered rows beyond this are replaced with a marker; 0 means unlimited
+	MaxBytes    int // Code longer than this is rejected; 0 means unlimited
+}
+
+// row is one rendered line of text. Long source lines wrap onto several rows,
+// and only the first of them carries a line number.
+type row struct {
+	number int
+	tokens []token
+}
+
+// Render writes code to w as a PNG image
+func Render(w io.Writer, code string, opts Options) error {
+	img, err := Draw(code, opts)
+	if err != nil {
+		return err
+	}
+	return png.Encode(w, img)
+}
+
+// Draw renders code into an image
+func Draw(code string, opts Options) (*image.RGBA, error) {
+	if opts.MaxBytes > 0 && len(code) > opts.MaxBytes {
+		return nil, ErrTooLarge
+	}
+	theme, err := LookupTheme(opts.Theme)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Width != 0 && (opts.Width < MinWidth || (opts.MaxWidth > 0 && opts.Width > opts.MaxWidth)) {
+		return nil, ErrInvalidWidth
+	}
+
+	lines := splitLines(code)
+	tokens := highlight(lines, opts.Language)
+
+	gutter := 0
+	if opts.LineNumbers {
+		gutter = len(strconv.Itoa(len(lines))) + 2
+	}
+
+	// Size the window to the longest line unless a width was requested
+	chrome := 2*backdropPadding + 2*windowPadding
+	width := opts.Width
+	if width == 0 {
+		longest := 0
+		for _, line := range lines {
+			if n := utf8.RuneCountInString(line); n > longest {
+				longest = n
+			}
+		}
+		width = chrome + (gutter+longest)*glyphWidth
+		if titleWidth := chrome + (utf8.RuneCountInString(opts.Title)+12)*glyphWidth; titleWidth > width {
+			width = titleWidth
+		}
+		width = max(width, MinWidth)
+		if opts.MaxWidth > 0 {
+			width = min(width, opts.MaxWidth)
+		}
+	}
+	columns := max((width-chrome)/glyphWidth-gutter, minColumns)
+
+	rows, hidden := wrapRows(tokens, columns, opts.MaxLines)
+	height := 2*backdropPadding + titleBarHeight + 2*windowPadding + len(rows)*lineHeight
+	if hidden > 0 {
+		height += lineHeight
+	}
+
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	draw.Draw(img, img.Bounds(), image.NewUniform(theme.Backdrop), image.Point{}, draw.Src)
+
+	window := image.Rect(backdropPadding, backdropPadding, width-backdropPadding, height-backdropPadding)
+	fillRoundedRect(img, window.Add(image.Pt(0, 8)), cornerRadius+4, color.RGBA{A: 0x50})
+	fillRoundedRect(img, window, cornerRadius, theme.Background)
+
+	// Window controls and title
+	dotY := float64(window.Min.Y + titleBarHeight/2)
+	for i, c := range []color.RGBA{rgb(0xff5f56), rgb(0xffbd2e), rgb(0x27c93f)} {
+		fillCircle(img, float64(window.Min.X+windowPadding+6+i*20), dotY, 6, c)
+	}
+	if opts.Title != "" {
+		title := []rune(opts.Title)
+		maxTitle := (window.Dx()-2*windowPadding)/glyphWidth - 8
+		if maxTitle < 1 {
+			title = nil
+		} else if len(title) > maxTitle {
+			title = append(title[:maxTitle-1], '…')
+		}
+		x := window.Min.X + (window.Dx()-len(title)*glyphWidth)/2
+		drawText(img, x, window.Min.Y+(titleBarHeight-glyphHeight)/2, string(title), theme.Comment)
+	}
+
+	// Code
+	x0 := window.Min.X + windowPadding
+	y := window.Min.Y + titleBarHeight + windowPadding
+	for _, r := range rows {
+		x := x0
+		if gutter > 0 {
+			if r.number > 0 {
+				label := strconv.Itoa(r.number)
+				drawText(img, x+(gutter-2-len(label))*glyphWidth, y, label, theme.LineNumber)
+			}
+			x += gutter * glyphWidth
+		}
+		for _, t := range r.tokens {
+			x = drawText(img, x, y, t.Text, theme.color(t.Kind))
+		}
+		y += lineHeight
+	}
+	if hidden > 0 {
+		drawText(img, x0, y, fmt.Sprintf("… %d more lines", hidden), theme.Comment)
+	}
+
+	return img, nil
+}
+
+// splitLines normalizes line endings, expands tabs and drops the trailing
+// newline so it does not render as an empty last line
+func splitLines(code string) []string {
+	code = strings.ReplaceAll(code, "\r\n", "\n")
+	code = strings.TrimSuffix(code, "\n")
+	lines := strings.Split(code, "\n")
+	for i, line := range lines {
+		if !strings.Contains(line, "\t") {
+			continue
+		}
+		var b strings.Builder
+		col := 0
+		for _, r := range line {
+			if r == '\t' {
+				n := tabWidth - col%tabWidth
+				b.WriteString(strings.Repeat(" ", n))
+				col += n
+				continue
+			}
+			b.WriteRune(r)
+			col++
+		}
+		lines[i] = b.String()
+	}
+	return lines
+}
+
+// wrapRows soft-wraps highlighted lines at columns and stops after maxRows,
+// returning how many source lines were left out
+func wrapRows(lines [][]token, columns, maxRows int) ([]row, int) {
+	var rows []row
+	for i, tokens := range lines {
+		current := row{number: i + 1}
+		used := 0
+		for _, t := range tokens {
+			text := t.Text
+			for text != "" {
+				if used == columns {
+					rows = append(rows, current)
+					if maxRows > 0 && len(rows) == maxRows {
+						return rows, len(lines) - i - 1
+					}
+					current, used = row{}, 0
+				}
+				n := 0
+				cut := len(text)
+				for j := range text {
+					if n == columns-used {
+						cut = j
+						break
+					}
+					n++
+				}
+				current.tokens = append(current.tokens, token{Kind: t.Kind, Text: text[:cut]})
+				used += n
+				text = text[cut:]
+			}
+		}
+		rows = append(rows, current)
+		if maxRows > 0 && len(rows) == maxRows && i < len(lines)-1 {
+			return rows, len(lines) - i - 1
+		}
+	}
+	return rows, 0
+}
+
+// drawText draws s starting at (x, y) and returns the x position after it
+func drawText(img *image.RGBA, x, y int, s string, c color.RGBA) int {
+	loadAtlas()
+	src := image.NewUniform(c)
+	for _, r := range s {
+		if r != ' ' {
+			cell := glyphRect(r)
+			dst := image.Rect(x, y, x+glyphWidth, y+glyphHeight)
+			draw.DrawMask(img, dst, src, image.Point{}, atlas, cell.Min, draw.Over)
+		}
+		x += glyphWidth
+	}
+	return x
+}
+
+// fillRoundedRect fills r with rounded, antialiased corners
+func fillRoundedRect(img *image.RGBA, r image.Rectangle, radius int, c color.RGBA) {
+	rad := float64(radius)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			// Distance from the pixel center into the nearest corner circle
+			px, py := float64(x)+0.5, float64(y)+0.5
+			cx := math.Max(float64(r.Min.X)+rad, math.Min(px, float64(r.Max.X)-rad))
+			cy := math.Max(float64(r.Min.Y)+rad, math.Min(py, float64(r.Max.Y)-rad))
+			blend(img, x, y, c, coverage(math.Hypot(px-cx, py-cy), rad))
+		}
+	}
+}
+
+// fillCircle fills an antialiased circle centered on (cx, cy)
+func fillCircle(img *image.RGBA, cx, cy, radius float64, c color.RGBA) {
+	for y := int(cy - radius - 1); y <= int(cy+radius+1); y++ {
+		for x := int(cx - radius - 1); x <= int(cx+radius+1); x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			blend(img, x, y, c, coverage(d, radius))
+		}
+	}
+}
+
+// coverage approximates how much of a pixel at distance d from a circle's
+// center lies inside a circle of the given radius
+func coverage(d, radius float64) float64 {
+	return math.Max(0, math.Min(1, radius-d+0.5))
+}
+
+// blend composites c over the pixel at (x, y) with the given coverage
+func blend(img *image.RGBA, x, y int, c color.RGBA, cov float64) {
+	if cov <= 0 || !(image.Point{x, y}.In(img.Rect)) {
+		return
+	}
+	a := cov * float64(c.A) / 0xff
+	i := img.PixOffset(x, y)
+	pix := img.Pix[i : i+4 : i+4]
+	pix[0] = uint8(float64(pix[0])*(1-a) + float64(c.R)*a + 0.5)
+	pix[1] = uint8(float64(pix[1])*(1-a) + float64(c.G)*a + 0.5)
+	pix[2] = uint8(float64(pix[2])*(1-a) + float64(c.B)*a + 0.5)
+	pix[3] = uint8(float64(pix[3])*(1-a) + 0xff*a + 0.5)
+}
@@ -0,0 +1,75 @@
+package codeimage
+
+import (
+	"bytes"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sample = "package main\n\nimport \"fmt\"\n\n// main says hello\nfunc main() {\n\tfmt.Println(\"hello\", 42)\n}\n"
+
+func TestRenderProducesPNG(t *testing.T) {
+	var buf bytes.Buffer
+	err := Render(&buf, sample, Options{Language: "go", Title: "main.go", LineNumbers: true})
+	require.NoError(t, err)
+
+	img, err := png.Decode(&buf)
+	require.NoError(t, err)
+	bounds := img.Bounds()
+	assert.GreaterOrEqual(t, bounds.Dx(), MinWidth)
+
+	lines := 8
+	assert.Equal(t, 2*backdropPadding+titleBarHeight+2*windowPadding+lines*lineHeight, bounds.Dy())
+
+	// The corner is backdrop, the window interior is the editor background
+	theme, _ := LookupTheme(DefaultTheme)
+	r, g, b, _ := img.At(0, 0).RGBA()
+	assert.Equal(t, uint32(theme.Backdrop.R), r>>8)
+	assert.Equal(t, uint32(theme.Backdrop.G), g>>8)
+	assert.Equal(t, uint32(theme.Backdrop.B), b>>8)
+}
+
+func TestDrawWrapsLongLines(t *testing.T) {
+	code := strings.Repeat("x", 200)
+
+	img, err := Draw(code, Options{Width: 600})
+	require.NoError(t, err)
+	assert.Equal(t, 600, img.Bounds().Dx())
+
+	columns := (600 - 2*backdropPadding - 2*windowPadding) / glyphWidth
+	rows := (200 + columns - 1) / columns
+	assert.Equal(t, 2*backdropPadding+titleBarHeight+2*windowPadding+rows*lineHeight, img.Bounds().Dy())
+}
+
+func TestDrawAutoWidthIsCapped(t *testing.T) {
+	img, err := Draw(strings.Repeat("y", 500), Options{MaxWidth: 1200})
+	require.NoError(t, err)
+	assert.Equal(t, 1200, img.Bounds().Dx())
+}
+
+func TestDrawTruncatesLines(t *testing.T) {
+	code := strings.Repeat("line\n", 50)
+
+	img, err := Draw(code, Options{MaxLines: 10})
+	require.NoError(t, err)
+	// Ten rows plus the "more lines" marker
+	assert.Equal(t, 2*backdropPadding+titleBarHeight+2*windowPadding+11*lineHeight, img.Bounds().Dy())
+}
+
+func TestDrawLimits(t *testing.T) {
+	_, err := Draw(strings.Repeat("z", 100), Options{MaxBytes: 50})
+	assert.ErrorIs(t, err, ErrTooLarge)
+
+	_, err = Draw(sample, Options{Theme: "no-such-theme"})
+	assert.ErrorIs(t, err, ErrUnknownTheme)
+
+	_, err = Draw(sample, Options{Width: 100})
+	assert.ErrorIs(t, err, ErrInvalidWidth)
+
+	_, err = Draw(sample, Options{Width: 5000, MaxWidth: 2400})
+	assert.ErrorIs(t, err, ErrInvalidWidth)
+}
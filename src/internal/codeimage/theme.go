@@ -0,0 +1,86 @@
+package codeimage
+
+import (
+	"image/color"
+	"sort"
+)
+
+// Theme is the color scheme of a rendered code image
+type Theme struct {
+	Name       string
+	Backdrop   color.RGBA // Area around the window
+	Background color.RGBA // Window and editor background
+	Foreground color.RGBA
+	Keyword    color.RGBA
+	String     color.RGBA
+	Comment    color.RGBA
+	Number     color.RGBA
+	LineNumber color.RGBA
+}
+
+func rgb(hex uint32) color.RGBA {
+	return color.RGBA{R: uint8(hex >> 16), G: uint8(hex >> 8), B: uint8(hex), A: 0xff}
+}
+
+// DefaultTheme is used when no theme is requested
+const DefaultTheme = "dracula"
+
+var themes = map[string]Theme{
+	"dracula": {
+		Name: "dracula", Backdrop: rgb(0xbd93f9), Background: rgb(0x282a36), Foreground: rgb(0xf8f8f2),
+		Keyword: rgb(0xff79c6), String: rgb(0xf1fa8c), Comment: rgb(0x6272a4), Number: rgb(0xbd93f9), LineNumber: rgb(0x6272a4),
+	},
+	"monokai": {
+		Name: "monokai", Backdrop: rgb(0xa6e22e), Background: rgb(0x272822), Foreground: rgb(0xf8f8f2),
+		Keyword: rgb(0xf92672), String: rgb(0xe6db74), Comment: rgb(0x75715e), Number: rgb(0xae81ff), LineNumber: rgb(0x75715e),
+	},
+	"one-dark": {
+		Name: "one-dark", Backdrop: rgb(0x61afef), Background: rgb(0x282c34), Foreground: rgb(0xabb2bf),
+		Keyword: rgb(0xc678dd), String: rgb(0x98c379), Comment: rgb(0x5c6370), Number: rgb(0xd19a66), LineNumber: rgb(0x4b5263),
+	},
+	"solarized-dark": {
+		Name: "solarized-dark", Backdrop: rgb(0x2aa198), Background: rgb(0x002b36), Foreground: rgb(0x839496),
+		Keyword: rgb(0x859900), String: rgb(0x2aa198), Comment: rgb(0x586e75), Number: rgb(0xd33682), LineNumber: rgb(0x586e75),
+	},
+	"github-light": {
+		Name: "github-light", Backdrop: rgb(0xd0d7de), Background: rgb(0xffffff), Foreground: rgb(0x24292f),
+		Keyword: rgb(0xcf222e), String: rgb(0x0a3069), Comment: rgb(0x6e7781), Number: rgb(0x0550ae), LineNumber: rgb(0x8c959f),
+	},
+}
+
+// LookupTheme returns the named theme
+func LookupTheme(name string) (Theme, error) {
+	if name == "" {
+		name = DefaultTheme
+	}
+	theme, ok := themes[name]
+	if !ok {
+		return Theme{}, ErrUnknownTheme
+	}
+	return theme, nil
+}
+
+// Themes returns the names of all built-in themes, sorted
+func Themes() []string {
+	names := make([]string, 0, len(themes))
+	for name := range themes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (t Theme) color(kind tokenKind) color.RGBA {
+	switch kind {
+	case tokenKeyword:
+		return t.Keyword
+	case tokenString:
+		return t.String
+	case tokenComment:
+		return t.Comment
+	case tokenNumber:
+		return t.Number
+	default:
+		return t.Foreground
+	}
+}
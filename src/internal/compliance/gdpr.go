@@ -5,29 +5,41 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
 	"github.com/casapps/casgists/src/internal/models"
+	"github.com/casapps/casgists/src/internal/objectstore"
+	"github.com/casapps/casgists/src/internal/services"
 )
 
+// storedExportPrefix marks export paths that are object storage keys
+const storedExportPrefix = "objectstore:"
+
 // GDPRService handles GDPR compliance operations
 type GDPRService struct {
 	db           *gorm.DB
 	exportDir    string
+	store        objectstore.Bucket // nil when exports stay in exportDir
 	auditService *AuditService
+	gists        *services.GistDispositionService
 }
 
-// NewGDPRService creates a new GDPR service
-func NewGDPRService(db *gorm.DB, exportDir string, auditService *AuditService) *GDPRService {
+// NewGDPRService creates a new GDPR service. Exports are built in
+// exportDir and, with a store, moved into object storage once complete.
+func NewGDPRService(db *gorm.DB, exportDir string, store objectstore.Bucket, auditService *AuditService, gists *services.GistDispositionService) *GDPRService {
 	return &GDPRService{
 		db:           db,
 		exportDir:    exportDir,
+		store:        store,
 		auditService: auditService,
+		gists:        gists,
 	}
 }
 
@@ -67,8 +79,9 @@ func (g *GDPRService) RequestDataExport(userID uuid.UUID, requestorIP string) (*
 	return exportRequest, nil
 }
 
-// RequestDataDeletion creates a GDPR data deletion request
-func (g *GDPRService) RequestDataDeletion(userID uuid.UUID, reason, requestorIP string) (*models.GDPRDeletionRequest, error) {
+// RequestDataDeletion creates a GDPR data deletion request. The disposition
+// records what the user chose to happen to their public gists.
+func (g *GDPRService) RequestDataDeletion(userID uuid.UUID, reason, requestorIP string, disposition services.GistDisposition) (*models.GDPRDeletionRequest, error) {
 	// Check if user has a pending or processing deletion request
 	var existingRequest models.GDPRDeletionRequest
 	err := g.db.Where("user_id = ? AND status IN ('pending', 'processing')", userID).First(&existingRequest).Error
@@ -84,6 +97,8 @@ func (g *GDPRService) RequestDataDeletion(userID uuid.UUID, reason, requestorIP
 		UserID:         userID,
 		Status:         "pending",
 		DeletionReason: reason,
+		GistPolicy:     string(disposition.Policy),
+		GistOrgID:      disposition.OrganizationID,
 		CreatedAt:      time.Now(),
 	}
 	
@@ -95,6 +110,7 @@ func (g *GDPRService) RequestDataDeletion(userID uuid.UUID, reason, requestorIP
 	g.auditService.LogCompliance(userID, "GDPR", "data_deletion_requested", "user_data", map[string]interface{}{
 		"deletion_request_id": deletionRequest.ID,
 		"reason":              reason,
+		"gist_policy":         disposition.Policy,
 		"requestor_ip":        requestorIP,
 	}, "user_right_to_erasure", 0)
 	
@@ -126,8 +142,18 @@ func (g *GDPRService) ProcessDataDeletion(requestID uuid.UUID, processorID uuid.
 		"processor_id":        processorID,
 	}, "user_right_to_erasure", 0)
 	
+	// Requests made before gist policies existed get the instance default
+	disposition := services.GistDisposition{
+		Policy:         services.GistPolicy(request.GistPolicy),
+		OrganizationID: request.GistOrgID,
+	}
+	if disposition.Policy == "" {
+		disposition.Policy = g.gists.DefaultPolicy()
+	}
+	
 	// Perform deletion
-	if err := g.deleteUserData(request.UserID, requestID); err != nil {
+	gists, err := g.deleteUserData(request.UserID, requestID, disposition)
+	if err != nil {
 		// Mark as failed
 		request.Status = "failed"
 		g.db.Save(&request)
@@ -146,6 +172,9 @@ func (g *GDPRService) ProcessDataDeletion(requestID uuid.UUID, processorID uuid.
 	g.auditService.LogCompliance(request.UserID, "GDPR", "data_deletion_completed", "user_data", map[string]interface{}{
 		"deletion_request_id": requestID,
 		"processor_id":        processorID,
+		"gist_policy":         gists.Policy,
+		"gists_transferred":   gists.Transferred,
+		"gists_deleted":       gists.Deleted,
 	}, "user_right_to_erasure", 0)
 	
 	return nil
@@ -191,6 +220,9 @@ func (g *GDPRService) processDataExport(request *models.GDPRExportRequest) {
 	
 	// Export user data
 	exportPath, err := g.exportUserData(request.UserID, request.ID)
+	if err == nil && g.store != nil {
+		exportPath, err = g.storeExport(exportPath)
+	}
 	if err != nil {
 		request.Status = "failed"
 		g.db.Save(request)
@@ -355,45 +387,47 @@ func (g *GDPRService) addJSONToZip(zipWriter *zip.Writer, filename string, data
 	return err
 }
 
-// deleteUserData deletes all user data (GDPR right to erasure)
-func (g *GDPRService) deleteUserData(userID uuid.UUID, requestID uuid.UUID) error {
+// deleteUserData deletes all user data (GDPR right to erasure). Public
+// gists are handed over or deleted according to the disposition; everything
+// else the user owns is deleted.
+func (g *GDPRService) deleteUserData(userID uuid.UUID, requestID uuid.UUID, disposition services.GistDisposition) (*services.GistDispositionResult, error) {
 	// Start transaction
 	tx := g.db.Begin()
 	if tx.Error != nil {
-		return tx.Error
+		return nil, tx.Error
 	}
 	defer tx.Rollback()
 	
-	// Delete user gists and related data
-	if err := tx.Where("user_id = ?", userID).Delete(&models.GistFile{}).Error; err != nil {
-		return fmt.Errorf("failed to delete gist files: %w", err)
-	}
-	
-	if err := tx.Where("user_id = ?", userID).Delete(&models.Star{}).Error; err != nil {
-		return fmt.Errorf("failed to delete gist stars: %w", err)
+	// Stars and comments the user left on any gist
+	if err := tx.Table("gist_stars").Where("user_id = ?", userID).Delete(&models.Star{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete gist stars: %w", err)
 	}
 	
-	if err := tx.Where("user_id = ?", userID).Delete(&models.Comment{}).Error; err != nil {
-		return fmt.Errorf("failed to delete gist comments: %w", err)
+	if err := tx.Table("gist_comments").Where("user_id = ?", userID).Delete(&models.Comment{}).Error; err != nil {
+		return nil, fmt.Errorf("failed to delete gist comments: %w", err)
 	}
 	
-	if err := tx.Where("user_id = ?", userID).Delete(&models.Gist{}).Error; err != nil {
-		return fmt.Errorf("failed to delete gists: %w", err)
+	// Transfer or delete the user's gists
+	gists, err := g.gists.Apply(tx, userID, disposition)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Delete user preferences
 	if err := tx.Where("user_id = ?", userID).Delete(&models.UserPreference{}).Error; err != nil {
-		return fmt.Errorf("failed to delete user preferences: %w", err)
+		return nil, fmt.Errorf("failed to delete user preferences: %w", err)
 	}
 	
 	// Delete webhooks
 	if err := tx.Where("user_id = ?", userID).Delete(&models.Webhook{}).Error; err != nil {
-		return fmt.Errorf("failed to delete webhooks: %w", err)
+		return nil, fmt.Errorf("failed to delete webhooks: %w", err)
 	}
 	
 	// Delete custom domains
-	if err := tx.Where("user_id = ?", userID).Delete(&models.CustomDomain{}).Error; err != nil {
-		return fmt.Errorf("failed to delete custom domains: %w", err)
+	if tx.Migrator().HasTable(&models.CustomDomain{}) {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.CustomDomain{}).Error; err != nil {
+			return nil, fmt.Errorf("failed to delete custom domains: %w", err)
+		}
 	}
 	
 	// Anonymize audit logs (keep for legal compliance but remove PII)
@@ -401,16 +435,71 @@ func (g *GDPRService) deleteUserData(userID uuid.UUID, requestID uuid.UUID) erro
 		"user_id": nil,
 		"details": fmt.Sprintf(`{"anonymized": true, "deletion_request_id": "%s"}`, requestID),
 	}).Error; err != nil {
-		return fmt.Errorf("failed to anonymize audit logs: %w", err)
+		return nil, fmt.Errorf("failed to anonymize audit logs: %w", err)
 	}
 	
 	// Finally, delete the user
 	if err := tx.Delete(&models.User{}, userID).Error; err != nil {
-		return fmt.Errorf("failed to delete user: %w", err)
+		return nil, fmt.Errorf("failed to delete user: %w", err)
 	}
 	
 	// Commit transaction
-	return tx.Commit().Error
+	if err := tx.Commit().Error; err != nil {
+		return nil, err
+	}
+	return gists, nil
+}
+
+// storeExport moves a finished export into object storage and returns the
+// path recorded for it
+func (g *GDPRService) storeExport(exportPath string) (string, error) {
+	key := objectstore.PrefixExports + filepath.Base(exportPath)
+	if err := objectstore.PutFile(context.Background(), g.store, key, exportPath); err != nil {
+		os.Remove(exportPath)
+		return "", fmt.Errorf("failed to upload export: %w", err)
+	}
+	os.Remove(exportPath)
+	return storedExportPrefix + key, nil
+}
+
+// OpenExport returns the content of a completed export and when it was
+// written
+func (g *GDPRService) OpenExport(ctx context.Context, request *models.GDPRExportRequest) (io.ReadSeekCloser, time.Time, error) {
+	if key, ok := strings.CutPrefix(request.ExportFilePath, storedExportPrefix); ok {
+		if g.store == nil {
+			return nil, time.Time{}, fmt.Errorf("export %s is in object storage, which isn't configured", key)
+		}
+		object, err := g.store.Stat(ctx, key)
+		if err != nil {
+			return nil, time.Time{}, err
+		}
+		content, err := g.store.Open(ctx, key)
+		return content, object.ModTime, err
+	}
+	file, err := os.Open(request.ExportFilePath)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, time.Time{}, err
+	}
+	return file, info.ModTime(), nil
+}
+
+// removeExport deletes an export's file or object
+func (g *GDPRService) removeExport(exportPath string) error {
+	if key, ok := strings.CutPrefix(exportPath, storedExportPrefix); ok {
+		if g.store == nil {
+			return fmt.Errorf("object storage isn't configured")
+		}
+		return g.store.Delete(context.Background(), key)
+	}
+	if err := os.Remove(exportPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
 // CleanupExpiredExports removes expired export files
@@ -423,7 +512,7 @@ func (g *GDPRService) CleanupExpiredExports() error {
 	for _, request := range expiredRequests {
 		// Delete export file
 		if request.ExportFilePath != "" {
-			if err := os.Remove(request.ExportFilePath); err != nil && !os.IsNotExist(err) {
+			if err := g.removeExport(request.ExportFilePath); err != nil {
 				fmt.Printf("Warning: failed to delete export file %s: %v\n", request.ExportFilePath, err)
 			}
 		}
@@ -0,0 +1,179 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/mail"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Setting categories, in the order the admin settings page shows them
+const (
+	CategoryAuth       = "auth"
+	CategoryEmail      = "email"
+	CategoryFeatures   = "features"
+	CategoryLimits     = "limits"
+	CategoryAppearance = "appearance"
+)
+
+// Categories lists the setting categories in display order
+var Categories = []string{CategoryAuth, CategoryEmail, CategoryFeatures, CategoryLimits, CategoryAppearance}
+
+// Setting types
+const (
+	TypeBool     = "bool"
+	TypeInt      = "int"
+	TypeString   = "string"
+	TypeDuration = "duration"
+	TypeList     = "list"
+)
+
+// Setting describes a setting administrators can change at runtime. The
+// value is saved in the database and overrides the config file.
+type Setting struct {
+	Key         string `json:"key"`
+	Category    string `json:"category"`
+	Type        string `json:"type"`
+	Description string `json:"description"`
+	Secret      bool   `json:"secret,omitempty"`  // never shown once saved
+	Restart     bool   `json:"restart,omitempty"` // takes effect after a restart
+	Min         int    `json:"min,omitempty"`
+	Max         int    `json:"max,omitempty"`
+
+	check func(string) error
+}
+
+// Catalog lists the settings the admin settings API and page manage
+var Catalog = []Setting{
+	{Key: "features.registration", Category: CategoryAuth, Type: TypeBool, Description: "Allow new users to sign up"},
+	{Key: "features.password_reset", Category: CategoryAuth, Type: TypeBool, Description: "Allow users to reset a forgotten password by email"},
+	{Key: "auth.require_email_verification", Category: CategoryAuth, Type: TypeBool, Description: "Refuse password sign in until the email address is verified"},
+	{Key: "auth.require_2fa", Category: CategoryAuth, Type: TypeBool, Description: "Require two-factor authentication for every user"},
+	{Key: "registration.allowed_domains", Category: CategoryAuth, Type: TypeList, Description: "Only these email domains may sign up; empty allows any"},
+	{Key: "registration.denied_domains", Category: CategoryAuth, Type: TypeList, Description: "Email domains that may not sign up"},
+	{Key: "security.password.min_length", Category: CategoryAuth, Type: TypeInt, Min: 8, Max: 128, Description: "Shortest password accepted"},
+	{Key: "security.session.idle_timeout", Category: CategoryAuth, Type: TypeDuration, Description: "Sign out sessions idle for this long"},
+	{Key: "security.session.max_concurrent", Category: CategoryAuth, Type: TypeInt, Min: 1, Max: 100, Description: "Sessions a user may have at once"},
+
+	{Key: "email.enabled", Category: CategoryEmail, Type: TypeBool, Restart: true, Description: "Send email"},
+	{Key: "email.smtp.host", Category: CategoryEmail, Type: TypeString, Description: "SMTP server"},
+	{Key: "email.smtp.port", Category: CategoryEmail, Type: TypeInt, Min: 1, Max: 65535, Description: "SMTP port"},
+	{Key: "email.smtp.username", Category: CategoryEmail, Type: TypeString, Description: "SMTP user name"},
+	{Key: "email.smtp.password", Category: CategoryEmail, Type: TypeString, Secret: true, Description: "SMTP password"},
+	{Key: "email.smtp.tls", Category: CategoryEmail, Type: TypeBool, Description: "Use TLS to reach the SMTP server"},
+	{Key: "email.from.address", Category: CategoryEmail, Type: TypeString, Description: "Address email is sent from", check: checkAddress},
+	{Key: "email.from.name", Category: CategoryEmail, Type: TypeString, Description: "Name email is sent from"},
+
+	{Key: "features.organizations", Category: CategoryFeatures, Type: TypeBool, Description: "Organizations and teams"},
+	{Key: "features.social", Category: CategoryFeatures, Type: TypeBool, Description: "Stars, follows and activity feeds"},
+	{Key: "features.search", Category: CategoryFeatures, Type: TypeBool, Description: "Search"},
+	{Key: "features.webhooks", Category: CategoryFeatures, Type: TypeBool, Description: "Webhooks"},
+	{Key: "features.api", Category: CategoryFeatures, Type: TypeBool, Description: "The REST API"},
+	{Key: "features.custom_domains", Category: CategoryFeatures, Type: TypeBool, Description: "Serve gists on users' own domains"},
+	{Key: "features.math", Category: CategoryFeatures, Type: TypeBool, Description: "KaTeX math in rendered markdown"},
+	{Key: "features.diagrams", Category: CategoryFeatures, Type: TypeBool, Description: "Mermaid diagrams in rendered markdown"},
+
+	{Key: "storage.max_file_size", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "Largest gist file, in bytes"},
+	{Key: "storage.max_files_per_gist", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "Files a gist may hold"},
+	{Key: "attachments.max_size", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "Largest attachment, in bytes"},
+	{Key: "attachments.max_per_gist", Category: CategoryLimits, Type: TypeInt, Min: 0, Description: "Attachments a gist may hold"},
+	{Key: "webhook.max_per_gist", Category: CategoryLimits, Type: TypeInt, Min: 0, Description: "Webhooks a gist may have"},
+	{Key: "ratelimit.api_per_ip", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "API requests per window from one IP"},
+	{Key: "ratelimit.api_per_token", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "API requests per window with one token"},
+	{Key: "ratelimit.api_window", Category: CategoryLimits, Type: TypeDuration, Description: "API rate limit window"},
+	{Key: "ratelimit.login_attempts", Category: CategoryLimits, Type: TypeInt, Min: 1, Description: "Sign in attempts per window from one IP"},
+
+	{Key: "ui.title", Category: CategoryAppearance, Type: TypeString, Description: "Site name"},
+	{Key: "ui.description", Category: CategoryAppearance, Type: TypeString, Description: "Site description"},
+	{Key: "ui.footer", Category: CategoryAppearance, Type: TypeString, Description: "Page footer"},
+	{Key: "ui.theme", Category: CategoryAppearance, Type: TypeString, Description: "Default theme"},
+	{Key: "ui.language", Category: CategoryAppearance, Type: TypeString, Description: "Default language"},
+	{Key: "ui.timezone", Category: CategoryAppearance, Type: TypeString, Description: "Time zone for visitors and users without one", check: checkTimezone},
+}
+
+// LookupSetting returns the catalog entry for key
+func LookupSetting(key string) (Setting, bool) {
+	for _, setting := range Catalog {
+		if setting.Key == key {
+			return setting, true
+		}
+	}
+	return Setting{}, false
+}
+
+// Parse checks a value sent for the setting, as decoded from JSON, and
+// returns it as saved
+func (s Setting) Parse(value interface{}) (string, error) {
+	raw := format(value)
+	switch s.Type {
+	case TypeBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("%s must be true or false", s.Key)
+		}
+		raw = strconv.FormatBool(b)
+	case TypeInt:
+		// JSON numbers arrive as float64
+		if f, ok := value.(float64); ok && f == math.Trunc(f) {
+			raw = strconv.FormatFloat(f, 'f', 0, 64)
+		}
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a whole number", s.Key)
+		}
+		if n < s.Min {
+			return "", fmt.Errorf("%s must be at least %d", s.Key, s.Min)
+		}
+		if s.Max > 0 && n > s.Max {
+			return "", fmt.Errorf("%s must be at most %d", s.Key, s.Max)
+		}
+		raw = strconv.Itoa(n)
+	case TypeDuration:
+		d, err := time.ParseDuration(raw)
+		if err != nil || d <= 0 {
+			return "", fmt.Errorf("%s must be a duration such as 30m or 8h", s.Key)
+		}
+	case TypeList:
+		items := []string{}
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		raw = strings.Join(items, ",")
+	}
+	if s.check != nil {
+		if err := s.check(raw); err != nil {
+			return "", fmt.Errorf("%s: %w", s.Key, err)
+		}
+	}
+	return raw, nil
+}
+
+// SettingsByCategory groups the catalog by category
+func SettingsByCategory() map[string][]Setting {
+	grouped := make(map[string][]Setting, len(Categories))
+	for _, setting := range Catalog {
+		grouped[setting.Category] = append(grouped[setting.Category], setting)
+	}
+	return grouped
+}
+
+func checkAddress(value string) error {
+	if value == "" {
+		return nil
+	}
+	if _, err := mail.ParseAddress(value); err != nil {
+		return fmt.Errorf("invalid email address")
+	}
+	return nil
+}
+
+func checkTimezone(value string) error {
+	if _, err := time.LoadLocation(value); err != nil {
+		return fmt.Errorf("unknown time zone %q", value)
+	}
+	return nil
+}
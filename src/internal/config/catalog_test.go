@@ -0,0 +1,61 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCatalog(t *testing.T) {
+	v := newViper()
+	for _, setting := range Catalog {
+		assert.Contains(t, Categories, setting.Category, setting.Key)
+		assert.NotNil(t, v.Get(setting.Key), "%s has no default", setting.Key)
+		assert.False(t, fileOnly(setting.Key), "%s can't be saved in the database", setting.Key)
+	}
+}
+
+func TestSettingParse(t *testing.T) {
+	lookup := func(key string) Setting {
+		setting, ok := LookupSetting(key)
+		require.True(t, ok, key)
+		return setting
+	}
+
+	tests := []struct {
+		key   string
+		value interface{}
+		want  string
+		err   string
+	}{
+		{key: "features.registration", value: true, want: "true"},
+		{key: "features.registration", value: "FALSE", want: "false"},
+		{key: "features.registration", value: "sometimes", err: "must be true or false"},
+		{key: "security.password.min_length", value: float64(16), want: "16"},
+		{key: "security.password.min_length", value: "4", err: "at least 8"},
+		{key: "security.password.min_length", value: 200.0, err: "at most 128"},
+		{key: "security.password.min_length", value: 12.5, err: "whole number"},
+		{key: "storage.max_file_size", value: float64(10485760), want: "10485760"},
+		{key: "security.session.idle_timeout", value: "30m", want: "30m"},
+		{key: "security.session.idle_timeout", value: "soon", err: "duration"},
+		{key: "registration.allowed_domains", value: " example.com, ,example.org ", want: "example.com,example.org"},
+		{key: "registration.allowed_domains", value: []interface{}{"example.com", "example.org"}, want: "example.com,example.org"},
+		{key: "email.from.address", value: "gists@example.com", want: "gists@example.com"},
+		{key: "email.from.address", value: "not an address", err: "invalid email address"},
+		{key: "ui.timezone", value: "Europe/Paris", want: "Europe/Paris"},
+		{key: "ui.timezone", value: "Mars/Olympus", err: "unknown time zone"},
+	}
+	for _, tt := range tests {
+		got, err := lookup(tt.key).Parse(tt.value)
+		if tt.err != "" {
+			assert.ErrorContains(t, err, tt.err, "%s = %v", tt.key, tt.value)
+			continue
+		}
+		require.NoError(t, err, "%s = %v", tt.key, tt.value)
+		assert.Equal(t, tt.want, got, tt.key)
+	}
+
+	_, ok := LookupSetting("security.secret_key")
+	assert.False(t, ok)
+}
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"bytes"
 	"crypto/rand"
 	"encoding/hex"
 	"fmt"
@@ -14,32 +15,27 @@ import (
 	"github.com/spf13/viper"
 )
 
-// Config holds all configuration values
-type Config struct {
-	v *viper.Viper
-}
-
-// Load loads configuration from environment variables and config files
-func Load() (*viper.Viper, error) {
+// newViper returns a viper with the defaults, reading CASGISTS_*
+// environment variables over them
+func newViper() *viper.Viper {
 	v := viper.New()
-
-	// Set config type
 	v.SetConfigType("yaml")
-
-	// Set environment variable prefix
 	v.SetEnvPrefix("CASGISTS")
 	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
 	v.AutomaticEnv()
-
-	// Set defaults
 	setDefaults(v)
+	return v
+}
 
-	// Resolve paths with variable substitution
-	resolvePaths(v)
+// Load loads configuration from environment variables and config files.
+// Paths are resolved after the config file is read, so templated values
+// in it are resolved too.
+func Load() (*Config, error) {
+	v := newViper()
 
 	// Load config file if exists
 	configPaths := []string{
-		v.GetString("paths.config"),
+		resolveValue(v, v.GetString("paths.config")),
 		".",
 		"/etc/casgists",
 	}
@@ -55,19 +51,40 @@ func Load() (*viper.Viper, error) {
 			return nil, fmt.Errorf("error reading config file: %w", err)
 		}
 	}
+	resolvePaths(v)
 
-	// Generate secret key if not set
-	if v.GetString("security.secret_key") == "" {
-		key, err := generateSecretKey()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate secret key: %w", err)
-		}
-		v.Set("security.secret_key", key)
+	cfg := newConfig(v, loadFile)
+	if err := generateSecretKey(cfg); err != nil {
+		return nil, err
 	}
+	return cfg, nil
+}
 
+// loadFile builds the configuration Load does from the config file's
+// contents
+func loadFile(file []byte) (*viper.Viper, error) {
+	v := newViper()
+	if err := v.ReadConfig(bytes.NewReader(file)); err != nil {
+		return nil, err
+	}
+	resolvePaths(v)
 	return v, nil
 }
 
+// generateSecretKey sets security.secret_key to a random key if it is not
+// set. It is set at runtime, so it outlives reloads.
+func generateSecretKey(cfg *Config) error {
+	if cfg.GetString("security.secret_key") != "" {
+		return nil
+	}
+	key, err := GenerateSecretKey()
+	if err != nil {
+		return fmt.Errorf("failed to generate secret key: %w", err)
+	}
+	cfg.Set("security.secret_key", key)
+	return nil
+}
+
 func setDefaults(v *viper.Viper) {
 	// Path defaults
 	if runtime.GOOS == "windows" {
@@ -95,15 +112,26 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("database.max_open_conns", 25)
 	v.SetDefault("database.max_idle_conns", 5)
 	v.SetDefault("database.conn_max_lifetime", "300s")
+	v.SetDefault("database.conn_max_idle_time", "5m")
+	v.SetDefault("database.replicas", []string{})
+	v.SetDefault("database.slow_query_threshold", "200ms")
 
 	// Server defaults
 	v.SetDefault("server.port", 0) // 0 = random port selection
 	v.SetDefault("server.host", "0.0.0.0")
 	v.SetDefault("server.url", "") // Auto-detect if empty
+	v.SetDefault("server.trusted_proxies", []string{"127.0.0.1", "::1"}) // Reverse proxies whose X-Forwarded-* headers count
+	v.SetDefault("server.shutdown_timeout", "30s") // How long shutdown waits for requests and background jobs
+	v.SetDefault("server.shutdown_delay", "0s")    // How long /readyz fails before the listeners close
 	v.SetDefault("server.tls.enabled", false)
 	v.SetDefault("server.tls.cert_path", "")
 	v.SetDefault("server.tls.key_path", "")
 	v.SetDefault("server.tls.auto_cert", false)
+	v.SetDefault("server.tls.http_port", 80) // 0 = no HTTP to HTTPS redirect
+	v.SetDefault("server.tls.acme.domains", []string{})
+	v.SetDefault("server.tls.acme.email", "")
+	v.SetDefault("server.tls.acme.directory_url", "") // Let's Encrypt if empty
+	v.SetDefault("server.tls.acme.cache_dir", "")     // {data_dir}/ssl/acme if empty
 
 	// Security defaults
 	v.SetDefault("security.secret_key", "")
@@ -116,23 +144,163 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("security.password.require_lowercase", true)
 	v.SetDefault("security.password.require_numbers", true)
 	v.SetDefault("security.password.require_symbols", false)
-
-	// Rate limiting defaults
+	v.SetDefault("security.device_flow.enabled", true)
+	v.SetDefault("security.device_flow.code_ttl", "15m")
+	v.SetDefault("security.device_flow.poll_interval", "5s")
+	v.SetDefault("security.device_flow.session_ttl", "168h")
+	v.SetDefault("security.email_change.confirm_ttl", "24h")
+	v.SetDefault("security.email_change.revert_window", "168h")
+	v.SetDefault("security.password_reset.token_ttl", "1h")
+	v.SetDefault("security.password_reset.max_requests", 3)
+	v.SetDefault("security.email_verification.token_ttl", "24h")
+	v.SetDefault("security.token_lockout.attempts", 10)
+	v.SetDefault("security.token_lockout.duration", "15m")
+	v.SetDefault("security.deactivation.grace_period", "720h")
+	v.SetDefault("security.deletion.gist_policy", "archive")
+	v.SetDefault("security.deletion.allow_user_choice", true)
+	v.SetDefault("security.deletion.archive_username", "archive")
+	v.SetDefault("security.webauthn.enabled", true)
+	v.SetDefault("security.webauthn.rp_id", "")           // Host of server.url if empty
+	v.SetDefault("security.webauthn.rp_name", "CasGists")
+	v.SetDefault("security.webauthn.origins", []string{}) // Origin of server.url if empty
+	v.SetDefault("security.webauthn.require", "off")      // off, admins or all
+
+	// Rate limiting defaults. Counters are kept in Redis when redis.enabled
+	// is set, so every instance shares them, and in memory otherwise.
+	v.SetDefault("ratelimit.enabled", true)
 	v.SetDefault("ratelimit.authenticated_api", 1000)
 	v.SetDefault("ratelimit.anonymous_api", 100)
-	v.SetDefault("ratelimit.login_attempts", 5)
-	v.SetDefault("ratelimit.login_window", 15)
+	v.SetDefault("ratelimit.login_attempts", 5) // Per IP
+	v.SetDefault("ratelimit.login_window", 15)  // Minutes, or a duration such as "15m"
+	v.SetDefault("ratelimit.register_attempts", 5)
+	v.SetDefault("ratelimit.register_window", "1h")
+	v.SetDefault("ratelimit.password_reset_attempts", 5)
+	v.SetDefault("ratelimit.password_reset_window", "1h")
+	// Every API request counts against its IP; one with a token also counts
+	// against the token, so per_ip should leave room for several tokens
+	v.SetDefault("ratelimit.api_per_ip", 1200)
+	v.SetDefault("ratelimit.api_per_token", 300)
+	v.SetDefault("ratelimit.api_window", "1m")
+	v.SetDefault("ratelimit.raw_per_ip", 1200)
+	v.SetDefault("ratelimit.raw_per_token", 600)
+	v.SetDefault("ratelimit.raw_window", "1m")
 	v.SetDefault("ratelimit.gist_creation", 50)
 	v.SetDefault("ratelimit.comment_creation", 100)
 	v.SetDefault("ratelimit.search_requests", 200)
 
+	// Anonymous read tier: public reads without a token, limited to
+	// ratelimit.anonymous_api requests per window per IP
+	v.SetDefault("public_api.enabled", true)
+	v.SetDefault("public_api.window", "1h")
+	v.SetDefault("public_api.cache_ttl", "60s")
+	v.SetDefault("public_api.max_cache_bytes", 1048576)
+
+	// API timestamps are RFC 3339 in UTC. legacy_timestamps brings back
+	// server-local times while clients are updated; it will be removed.
+	v.SetDefault("api.legacy_timestamps", false)
+
+	// Sandbox mode: API requests sent with X-CasGists-Sandbox: true create
+	// ephemeral gists that are purged once they are older than ttl
+	v.SetDefault("api.sandbox.enabled", false)
+	v.SetDefault("api.sandbox.ttl", "1h")
+	v.SetDefault("api.sandbox.purge_interval", "1h")
+
+	// Gists created with an expiry, or burned by their first anonymous
+	// view, are hidden at once and deleted on the next sweep
+	v.SetDefault("gists.expiry.sweep_interval", "1m")
+
+	// Deleted gists stay in their owner's trash for retention_days, then
+	// are purged with their repositories; 0 keeps them until an admin
+	// purges them
+	v.SetDefault("gists.trash.retention_days", 30)
+	v.SetDefault("gists.trash.purge_interval", "1h")
+
+	// Views are counted in memory and written every view_flush_interval, or
+	// sooner once view_flush_size gists have views waiting. Star and fork
+	// counts are recomputed from the stars and forks every
+	// reconcile_interval; 0 turns that off
+	v.SetDefault("gists.counters.view_flush_interval", "10s")
+	v.SetDefault("gists.counters.view_flush_size", 1000)
+	v.SetDefault("gists.counters.reconcile_interval", "24h")
+
+	// What HTML survives in rendered markdown (descriptions, comments, READMEs)
+	v.SetDefault("markup.sanitizer.iframe_hosts", []string{})
+	v.SetDefault("markup.sanitizer.allow_details", true)
+	v.SetDefault("markup.sanitizer.allow_math", false)
+
+	// Sign in with GitHub, GitLab or an OpenID Connect provider. Register
+	// an OAuth application with <server.url>/auth/oauth/<provider>/callback
+	// as its callback URL. allow_signup creates accounts on first sign in
+	// while features.registration is on.
+	v.SetDefault("oauth.allow_signup", true)
+	v.SetDefault("oauth.github.enabled", false)
+	v.SetDefault("oauth.gitlab.enabled", false)
+	v.SetDefault("oauth.gitlab.url", "https://gitlab.com")
+	v.SetDefault("oauth.oidc.enabled", false)
+
 	// Feature flags
 	v.SetDefault("features.registration", true)
+	v.SetDefault("features.password_reset", true)
 	v.SetDefault("features.organizations", true)
+	v.SetDefault("features.custom_domains", false) // Users serve their gists on their own domains
 	v.SetDefault("features.social", true)
 	v.SetDefault("features.search", true)
 	v.SetDefault("features.webhooks", true)
 	v.SetDefault("features.api", true)
+	v.SetDefault("features.math", true)     // KaTeX math in rendered markdown
+	v.SetDefault("features.diagrams", true) // Mermaid diagrams in rendered markdown
+
+	// Who may sign up while features.registration is on. invite_only asks
+	// for an invite code from an admin; the domain lists restrict email
+	// addresses, subdomains included, with denied_domains winning; and
+	// require_approval keeps accounts signed up without an invite inactive
+	// until an admin approves them.
+	v.SetDefault("registration.invite_only", false)
+	v.SetDefault("registration.require_approval", false)
+	v.SetDefault("registration.allowed_domains", []string{})
+	v.SetDefault("registration.denied_domains", []string{})
+
+	// Sign in. require_email_verification refuses password sign in until
+	// the address is verified; require_2fa refuses API requests from users
+	// without an authenticator app or a security key. Admins are held to
+	// require_2fa too.
+	v.SetDefault("auth.require_email_verification", false)
+	v.SetDefault("auth.require_2fa", false)
+
+	// Webhook defaults
+	v.SetDefault("webhook.max_per_gist", 5)
+	v.SetDefault("webhook.digest.max_window", "1h") // Longest digest_window a webhook may ask for
+	v.SetDefault("webhook.digest.max_events", 100)  // A digest is sent early once it holds this many
+
+	// NodeInfo defaults (/.well-known/nodeinfo instance metadata)
+	v.SetDefault("nodeinfo.enabled", true)
+	v.SetDefault("nodeinfo.usage", true)
+
+	// Logging. server.log and access.log go to logging.directory, or the
+	// log directory when it is empty. max_size is in MB; a rotation limit
+	// of 0 turns it off.
+	v.SetDefault("logging.level", "info")     // debug, info, warn or error
+	v.SetDefault("logging.format", "console") // console or json
+	v.SetDefault("logging.directory", "")
+	v.SetDefault("logging.rotation.max_size", 100)
+	v.SetDefault("logging.rotation.interval", "24h")
+	v.SetDefault("logging.rotation.max_backups", 14)
+	v.SetDefault("logging.rotation.max_age", "720h") // 30 days
+
+	// Prometheus metrics on /metrics. With a token, scrapers must send it
+	// as a bearer token.
+	v.SetDefault("metrics.enabled", true)
+	v.SetDefault("metrics.token", "")
+
+	// Reload the config file when it changes, as on SIGHUP
+	v.SetDefault("config.watch", true)
+
+	// Readiness checks on /readyz. Each check gets the timeout; a required
+	// component failing failure_threshold checks in a row fails readiness,
+	// other components only degrade it.
+	v.SetDefault("health.timeout", "2s")
+	v.SetDefault("health.failure_threshold", 1)
+	v.SetDefault("health.required", []string{"database", "storage", "repo_storage"})
 
 	// Email defaults
 	v.SetDefault("email.enabled", false)
@@ -143,25 +311,101 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("email.smtp.tls", true)
 	v.SetDefault("email.from.address", "")
 	v.SetDefault("email.from.name", "CasGists")
+	// Queued email is sent in batches over a small pool of SMTP
+	// connections, closed again after idling
+	v.SetDefault("email.smtp.pool_size", 2)
+	v.SetDefault("email.smtp.idle_timeout", "30s")
+	v.SetDefault("email.process_interval", "30s")
+	v.SetDefault("email.batch_size", 10)
+	// Weekly digest for users who opted in, with hourly, daily, weekly or
+	// monthly at email.digest.time, or a cron expression
+	v.SetDefault("email.digest.enabled", true)
+	v.SetDefault("email.digest.schedule", "weekly")
+	v.SetDefault("email.digest.time", "09:00")
 
 	// Storage defaults
 	v.SetDefault("storage.type", "local")
 	v.SetDefault("storage.path", "{paths.data}/files")
 	v.SetDefault("storage.max_file_size", 5242880) // 5MB
 	v.SetDefault("storage.max_files_per_gist", 100)
+
+	// S3-compatible object storage, used when storage.type is s3 or minio.
+	// Credentials left empty come from the AWS_* environment variables.
+	v.SetDefault("storage.s3.endpoint", "") // Defaults to AWS for the region
+	v.SetDefault("storage.s3.region", "")   // Defaults to AWS_REGION, then us-east-1
+	v.SetDefault("storage.s3.bucket", "")
+	v.SetDefault("storage.s3.prefix", "")
+	v.SetDefault("storage.s3.path_style", false)
+	v.SetDefault("storage.s3.access_key_id", "")
+	v.SetDefault("storage.s3.secret_access_key", "")
+	v.SetDefault("storage.s3.secret_access_key_file", "")
+	v.SetDefault("storage.s3.session_token", "")
+
+	// Attachments: binary and large files kept as blobs under storage.path
+	v.SetDefault("attachments.enabled", true)
+	v.SetDefault("attachments.inline_max_size", 262144) // 256KB; smaller text files must be gist files
+	v.SetDefault("attachments.max_size", 104857600)     // 100MB
+	v.SetDefault("attachments.max_per_gist", 20)
+	v.SetDefault("attachments.prune_interval", "24h")
+
+	// Quota tiers replace the storage.* limits above and
+	// ratelimit.authenticated_api per user. 0 means unlimited.
+	v.SetDefault("quota.enabled", true)
+	v.SetDefault("quota.member_after_days", 7)
+	v.SetDefault("quota.trusted_after_days", 90)
+	v.SetDefault("quota.rate_window", "1h")
+	v.SetDefault("quota.tiers.free.max_gist_size", 10485760) // 10MB
+	v.SetDefault("quota.tiers.free.max_file_size", 1048576)  // 1MB
+	v.SetDefault("quota.tiers.free.max_files", 20)
+	v.SetDefault("quota.tiers.free.rate_limit", 1000)
+	v.SetDefault("quota.tiers.member.max_gist_size", 26214400) // 25MB
+	v.SetDefault("quota.tiers.member.max_file_size", 5242880)  // 5MB
+	v.SetDefault("quota.tiers.member.max_files", 100)
+	v.SetDefault("quota.tiers.member.rate_limit", 5000)
+	v.SetDefault("quota.tiers.trusted.max_gist_size", 104857600) // 100MB
+	v.SetDefault("quota.tiers.trusted.max_file_size", 10485760)  // 10MB
+	v.SetDefault("quota.tiers.trusted.max_files", 300)
+	v.SetDefault("quota.tiers.trusted.rate_limit", 15000)
+	v.SetDefault("quota.tiers.admin.max_gist_size", 0)
+	v.SetDefault("quota.tiers.admin.max_file_size", 0)
+	v.SetDefault("quota.tiers.admin.max_files", 0)
+	v.SetDefault("quota.tiers.admin.rate_limit", 0)
 	v.SetDefault("storage.max_total_size", 26214400) // 25MB
 
 	// Search defaults
-	v.SetDefault("search.backend", "sqlite") // sqlite or redis
+	v.SetDefault("search.backend", "sqlite") // sqlite (the database's full-text index) or bleve
+	v.SetDefault("search.bleve.path", "{paths.data}/search.bleve")
+	v.SetDefault("search.bleve.sync_interval", "1m")
+	v.SetDefault("search.bleve.reindex_interval", "0") // 0 = never
 	v.SetDefault("search.redis.host", "localhost")
 	v.SetDefault("search.redis.port", 6379)
 	v.SetDefault("search.redis.password", "")
 	v.SetDefault("search.redis.db", 0)
+	v.SetDefault("search.guard.max_query_length", 256)
+	v.SetDefault("search.guard.max_terms", 10)
+	v.SetDefault("search.guard.max_wildcards", 4)
+	v.SetDefault("search.guard.min_wildcard_prefix", 2)
+	v.SetDefault("search.guard.max_concurrent_per_user", 2)
+	v.SetDefault("search.guard.timeout", "5s")
+	v.SetDefault("search.guard.cache_ttl", "5m")
+	v.SetDefault("search.guard.popular_threshold", 3)
+	v.SetDefault("search.index.max_lag", "15m")
 
 	// Cache defaults
-	v.SetDefault("cache.type", "memory") // memory or redis
-	v.SetDefault("cache.ttl", "5m")
-	v.SetDefault("cache.max_entries", 1000)
+	v.SetDefault("cache.enabled", true)
+	v.SetDefault("cache.type", "auto") // auto (redis when redis.enabled), memory or redis
+	v.SetDefault("cache.key_prefix", "casgists:")
+	v.SetDefault("cache.max_entries", 1000) // Memory backend only
+	v.SetDefault("cache.ttl_tiers.short", "5m")
+	v.SetDefault("cache.ttl_tiers.medium", "30m")
+	v.SetDefault("cache.ttl_tiers.long", "2h")
+	v.SetDefault("cache.ttl_tiers.very_long", "24h")
+
+	// Redis, shared by the cache and rate limits
+	v.SetDefault("redis.enabled", false)
+	v.SetDefault("redis.addr", "localhost:6379")
+	v.SetDefault("redis.password", "")
+	v.SetDefault("redis.db", 0)
 
 	// UI defaults
 	v.SetDefault("ui.theme", "dracula")
@@ -169,14 +413,101 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("ui.title", "CasGists")
 	v.SetDefault("ui.description", "Self-hosted Git snippet manager")
 	v.SetDefault("ui.footer", "Powered by CasGists")
+	v.SetDefault("ui.timezone", "UTC") // For visitors and users without a time zone preference
 
-	// Backup defaults
+	// Backup defaults. Schedules are hourly, daily, weekly or monthly at
+	// backup.time, or cron expressions.
 	v.SetDefault("backup.enabled", true)
-	v.SetDefault("backup.schedule", "weekly")
+	v.SetDefault("backup.schedule", "weekly")       // Full backups
+	v.SetDefault("backup.incremental_schedule", "") // Incremental backups in between, off when empty
 	v.SetDefault("backup.time", "02:00")
-	v.SetDefault("backup.retention", 4)
+	v.SetDefault("backup.retention", 4) // Full backups kept, with their incrementals; 0 keeps all
 	v.SetDefault("backup.path", "{paths.data}/backups")
-	v.SetDefault("backup.encrypt", true)
+	v.SetDefault("backup.include_git_repos", true)
+	v.SetDefault("backup.include_attachments", true)
+	v.SetDefault("backup.encrypt", true) // Needs a key below; without one backups are not encrypted
+	v.SetDefault("backup.encryption_key", "")
+	v.SetDefault("backup.encryption_key_file", "")
+	v.SetDefault("backup.notify", true) // Email administrators when a backup succeeds
+
+	// SQLite replication defaults (WAL shipping, off by default)
+	v.SetDefault("replication.enabled", false)
+	v.SetDefault("replication.target", "")
+	v.SetDefault("replication.hooks.upload", "")
+	v.SetDefault("replication.hooks.download", "")
+	v.SetDefault("replication.hooks.list", "")
+	v.SetDefault("replication.hooks.delete", "")
+	v.SetDefault("replication.hooks.timeout", "5m")
+	v.SetDefault("replication.sync_interval", "1s")
+	v.SetDefault("replication.checkpoint_size", 4194304)
+	v.SetDefault("replication.snapshot_interval", "24h")
+	v.SetDefault("replication.retention", "72h")
+	v.SetDefault("replication.max_lag", "1m")
+
+	// Tag automation rule defaults
+	v.SetDefault("automation.enabled", true)
+	v.SetDefault("automation.poll_interval", "5s")
+	v.SetDefault("automation.max_attempts", 3)
+	v.SetDefault("automation.retry_delay", "1m")
+	v.SetDefault("automation.max_rules", 50)
+
+	// Gist metadata suggestion defaults (heuristic, http or disabled)
+	v.SetDefault("enrichment.provider", "heuristic")
+	v.SetDefault("enrichment.url", "")
+	v.SetDefault("enrichment.token", "")
+	v.SetDefault("enrichment.timeout", "5s")
+	v.SetDefault("enrichment.max_bytes", 65536)
+
+	// Formatter/linter hooks run on save (format or annotate); tools and
+	// an optional sandbox wrapper command are configured per install
+	v.SetDefault("formatting.enabled", false)
+	v.SetDefault("formatting.mode", "annotate")
+	v.SetDefault("formatting.timeout", "10s")
+	v.SetDefault("formatting.max_bytes", 262144)
+	v.SetDefault("formatting.sandbox", []string{})
+	v.SetDefault("formatting.tools", []interface{}{})
+
+	// Content policy checked before gists are saved; each rule is off,
+	// warn or block, and organizations can only make it stricter
+	v.SetDefault("content_policy.max_file_size", 0)
+	v.SetDefault("content_policy.max_gist_size", 0)
+	v.SetDefault("content_policy.secret_scanning", "warn")
+	v.SetDefault("content_policy.secret_patterns", []interface{}{})
+	v.SetDefault("content_policy.banned_words", []string{})
+	v.SetDefault("content_policy.banned_words_action", "block")
+	v.SetDefault("content_policy.require_license", "off")
+
+	// External images in markdown and avatars are served through a signed
+	// proxy, fetched on first request and cached on disk
+	v.SetDefault("image_proxy.enabled", true)
+	v.SetDefault("image_proxy.key", "") // Defaults to security.secret_key
+	v.SetDefault("image_proxy.timeout", "10s")
+	v.SetDefault("image_proxy.max_bytes", 5242880) // 5MB per image
+	v.SetDefault("image_proxy.cache_dir", "")      // Empty disables the disk cache
+	v.SetDefault("image_proxy.cache_ttl", "24h")
+	v.SetDefault("image_proxy.cache_max_bytes", 524288000) // 500MB
+	v.SetDefault("image_proxy.allow_private", false)
+
+	// Optional malware scanning of uploads and imported archives through
+	// clamd or an external scanning API; flagged files are quarantined
+	v.SetDefault("scanning.enabled", false)
+	v.SetDefault("scanning.provider", "clamd")                          // clamd or http
+	v.SetDefault("scanning.clamd.address", "/var/run/clamav/clamd.ctl") // Socket path or host:port
+	v.SetDefault("scanning.http.url", "")
+	v.SetDefault("scanning.http.token", "")
+	v.SetDefault("scanning.timeout", "30s")
+	v.SetDefault("scanning.max_bytes", 26214400) // 25MB; larger files are skipped
+	v.SetDefault("scanning.fail_closed", false)  // Reject uploads when the scanner is unavailable
+	v.SetDefault("scanning.quarantine_dir", "")  // Empty records flagged files without keeping them
+
+	// Newsletter defaults; batches keep large sends under SMTP rate limits
+	v.SetDefault("newsletter.enabled", true)
+	v.SetDefault("newsletter.batch_size", 50)
+	v.SetDefault("newsletter.batch_interval", "1m")
+	v.SetDefault("newsletter.max_attempts", 3)
+
+	// Git repository storage defaults
+	v.SetDefault("git.storage.driver", "local")
 
 	// Compliance defaults
 	v.SetDefault("compliance.audit_logs", true)
@@ -184,34 +515,84 @@ func setDefaults(v *viper.Viper) {
 	v.SetDefault("compliance.soc2", false)
 	v.SetDefault("compliance.hipaa", false)
 	v.SetDefault("compliance.retention_days", 90)
+
+	// Telemetry defaults (opt-in only)
+	v.SetDefault("telemetry.enabled", false)
+	v.SetDefault("telemetry.endpoint", "")
+	v.SetDefault("telemetry.interval", "24h")
+
+	// CLI installer defaults (prebuilt binaries served by /install.sh)
+	v.SetDefault("cli.release_dir", "{paths.data}/cli")
+	v.SetDefault("cli.mirror_url", "")
+	v.SetDefault("cli.binary_name", "casgists-cli")
+
+	// Self-update defaults ("casgists upgrade" and /api/v1/admin/update).
+	// With a public key (base64 ed25519) releases must carry a signature of
+	// SHA256SUMS.txt by it.
+	v.SetDefault("update.repository", "casapps/casgists")
+	v.SetDefault("update.api_url", "https://api.github.com")
+	v.SetDefault("update.public_key", "")
+	v.SetDefault("update.check_interval", "1h")
+	v.SetDefault("update.backup_before_migrate", true) // Save the database before migrating it on start
+
+	// Alerting defaults (built-in health rules, emailed to administrators)
+	v.SetDefault("alerting.enabled", true)
+	v.SetDefault("alerting.interval", "1m")
+	v.SetDefault("alerting.window", "5m")
+	v.SetDefault("alerting.repeat_interval", "6h")
+	v.SetDefault("alerting.error_rate_min_requests", 50)
+	v.SetDefault("alerting.rules.db_latency.enabled", true)
+	v.SetDefault("alerting.rules.db_latency.threshold", 250)
+	v.SetDefault("alerting.rules.failed_webhooks.enabled", true)
+	v.SetDefault("alerting.rules.failed_webhooks.threshold", 20)
+	v.SetDefault("alerting.rules.disk_usage.enabled", true)
+	v.SetDefault("alerting.rules.disk_usage.threshold", 90)
+	v.SetDefault("alerting.rules.error_rate.enabled", true)
+	v.SetDefault("alerting.rules.error_rate.threshold", 5)
+
+	// Code image defaults (PNG snapshots of gist files)
+	v.SetDefault("codeimage.default_theme", "dracula")
+	v.SetDefault("codeimage.max_width", 2400)
+	v.SetDefault("codeimage.max_lines", 200)
+	v.SetDefault("codeimage.max_bytes", 102400)
+	v.SetDefault("codeimage.cache_ttl", "24h")
+
+	// Response compression and streaming defaults
+	v.SetDefault("performance.compression_enabled", true)
+	v.SetDefault("performance.compression_level", 6)
+	v.SetDefault("performance.compression_min_size", 1024) // Smaller bodies are sent as they are
+	v.SetDefault("performance.compression_types", []string{}) // Empty = text, JSON, JavaScript, XML and SVG
+	v.SetDefault("performance.streaming.chunk_size", 32768)
+	v.SetDefault("performance.streaming.write_timeout", "30s") // Per chunk; slower clients are dropped
+	v.SetDefault("performance.streaming.max_bytes", 0) // 0 = unlimited
+	v.SetDefault("performance.streaming.archive.max_bytes", 104857600) // 100MB
 }
 
+// resolvePaths replaces {key} in every value with the value of key and
+// expands environment variables and ~ in it
 func resolvePaths(v *viper.Viper) {
-	// Get all config keys
 	for _, key := range v.AllKeys() {
 		value := v.GetString(key)
-		
-		// Check if value contains variable substitution
 		if strings.Contains(value, "{") && strings.Contains(value, "}") {
-			resolved := value
-			
-			// Replace all {var} patterns
-			for _, varKey := range v.AllKeys() {
-				varPattern := fmt.Sprintf("{%s}", varKey)
-				if strings.Contains(resolved, varPattern) {
-					varValue := v.GetString(varKey)
-					resolved = strings.ReplaceAll(resolved, varPattern, varValue)
-				}
-			}
-			
-			// Expand environment variables
-			resolved = expandPath(resolved)
-			
-			v.Set(key, resolved)
+			v.Set(key, resolveValue(v, value))
 		}
 	}
 }
 
+// resolveValue resolves the {key} references and paths in value
+func resolveValue(v *viper.Viper, value string) string {
+	// Replace all {var} patterns
+	for _, varKey := range v.AllKeys() {
+		varPattern := fmt.Sprintf("{%s}", varKey)
+		if strings.Contains(value, varPattern) {
+			value = strings.ReplaceAll(value, varPattern, v.GetString(varKey))
+		}
+	}
+
+	// Expand environment variables
+	return expandPath(value)
+}
+
 func expandPath(path string) string {
 	// Expand environment variables
 	path = os.ExpandEnv(path)
@@ -228,7 +609,8 @@ func expandPath(path string) string {
 	return filepath.Clean(path)
 }
 
-func generateSecretKey() (string, error) {
+// GenerateSecretKey returns a random 256-bit key, hex encoded
+func GenerateSecretKey() (string, error) {
 	bytes := make([]byte, 32)
 	if _, err := rand.Read(bytes); err != nil {
 		return "", err
@@ -281,25 +663,17 @@ func ValidateConfig(v *viper.Viper) error {
 }
 
 // LoadWithPaths loads configuration using the provided path configuration
-func LoadWithPaths(pathConfig *PathConfig) (*viper.Viper, error) {
-	v := viper.New()
-
-	// Set config type
-	v.SetConfigType("yaml")
-
-	// Set environment variable prefix
-	v.SetEnvPrefix("CASGISTS")
-	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
-	v.AutomaticEnv()
-
-	// Set path-based defaults
-	setPathDefaults(v, pathConfig)
-
-	// Set other defaults
-	setDefaults(v)
+func LoadWithPaths(pathConfig *PathConfig) (*Config, error) {
+	// Set defaults; the resolved paths replace the generic path defaults
+	base := func() *viper.Viper {
+		v := newViper()
+		setPathDefaults(v, pathConfig)
+		return v
+	}
+	v := base()
 
 	// Try to load config file if it exists, but don't require it
-	configPath := filepath.Join(filepath.Dir(pathConfig.GetDatabasePath()), "config.yaml")
+	configPath := ConfigFilePath(pathConfig)
 	if _, err := os.Stat(configPath); err == nil {
 		// Config file exists, try to read it
 		v.SetConfigFile(configPath)
@@ -309,23 +683,34 @@ func LoadWithPaths(pathConfig *PathConfig) (*viper.Viper, error) {
 	}
 	// If no config file exists, that's fine - use environment variables and defaults
 
-	// Generate secret key if not set
-	if v.GetString("security.secret_key") == "" {
-		key, err := generateSecretKey()
-		if err != nil {
-			return nil, fmt.Errorf("failed to generate secret key: %w", err)
-		}
-		v.Set("security.secret_key", key)
+	cfg := newConfig(v, func(file []byte) (*viper.Viper, error) {
+		v := base()
+		return v, v.ReadConfig(bytes.NewReader(file))
+	})
+	if err := generateSecretKey(cfg); err != nil {
+		return nil, err
 	}
+	return cfg, nil
+}
 
-	return v, nil
+// ConfigFilePath returns the configuration file LoadWithPaths reads, next to
+// the database
+func ConfigFilePath(pathConfig *PathConfig) string {
+	return filepath.Join(filepath.Dir(pathConfig.GetDatabasePath()), "config.yaml")
 }
 
 // setPathDefaults sets path-specific configuration defaults
 func setPathDefaults(v *viper.Viper, pathConfig *PathConfig) {
 	v.SetDefault("database.path", pathConfig.GetDatabasePath())
 	v.SetDefault("storage.path", pathConfig.GetStoragePath())
+	v.SetDefault("git.storage.local.path", pathConfig.GetRepositoryDir())
 	v.SetDefault("backup.path", pathConfig.GetBackupDir())
+	if cacheDir := pathConfig.GetCacheDir(); cacheDir != "" {
+		v.SetDefault("image_proxy.cache_dir", filepath.Join(cacheDir, "images"))
+	}
+	if storagePath := pathConfig.GetStoragePath(); storagePath != "" {
+		v.SetDefault("scanning.quarantine_dir", filepath.Join(filepath.Dir(storagePath), "quarantine"))
+	}
 	v.SetDefault("ssl.cert_path", pathConfig.GetTLSCertPath())
 	v.SetDefault("ssl.key_path", pathConfig.GetTLSKeyPath())
 }
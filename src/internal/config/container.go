@@ -0,0 +1,49 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// EnvContainer turns container mode on or off, instead of detecting it
+const EnvContainer = "CASGISTS_CONTAINER"
+
+// ContainerDataDir is the volume everything is stored on in container mode,
+// unless CASGISTS_DATA_DIR moves it
+const ContainerDataDir = "/data"
+
+// containerMarkers are files container runtimes create in every container:
+// Docker's and Podman's
+var containerMarkers = []string{"/.dockerenv", "/run/.containerenv"}
+
+// InContainer reports whether the process runs in a container. A true or
+// false CASGISTS_CONTAINER decides; otherwise the files Docker and Podman
+// create, or the variables Kubernetes sets, are looked for.
+func InContainer() bool {
+	if forced, err := strconv.ParseBool(os.Getenv(EnvContainer)); err == nil {
+		return forced
+	}
+	if os.Getenv("KUBERNETES_SERVICE_HOST") != "" {
+		return true
+	}
+	for _, marker := range containerMarkers {
+		if _, err := os.Stat(marker); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// NewContainerPathConfig returns the paths of container mode: the database,
+// files, repositories, backups and cache all go on the data volume, and
+// there is no log directory, so logs go to stdout only. The CASGISTS_*_DIR
+// variables still override each of them.
+func NewContainerPathConfig() *PathConfig {
+	dataDir := getEnvOrDefault("CASGISTS_DATA_DIR", ContainerDataDir)
+	return newPathConfig(DirectoryPaths{
+		Data:  dataDir,
+		Cache: filepath.Join(dataDir, "cache"),
+		Temp:  filepath.Join(os.TempDir(), "casgists"),
+	})
+}
@@ -0,0 +1,50 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestInContainer(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), ".dockerenv")
+	original := containerMarkers
+	containerMarkers = []string{marker}
+	t.Cleanup(func() { containerMarkers = original })
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+
+	t.Setenv(EnvContainer, "")
+	assert.False(t, InContainer())
+
+	require.NoError(t, os.WriteFile(marker, nil, 0644))
+	assert.True(t, InContainer())
+
+	// The variable wins over detection, both ways
+	t.Setenv(EnvContainer, "false")
+	assert.False(t, InContainer())
+	require.NoError(t, os.Remove(marker))
+	t.Setenv(EnvContainer, "true")
+	assert.True(t, InContainer())
+
+	t.Setenv(EnvContainer, "")
+	t.Setenv("KUBERNETES_SERVICE_HOST", "10.0.0.1")
+	assert.True(t, InContainer())
+}
+
+func TestNewContainerPathConfig(t *testing.T) {
+	dataDir := t.TempDir()
+	t.Setenv("CASGISTS_DATA_DIR", dataDir)
+	t.Setenv("CASGISTS_LOG_DIR", "")
+	t.Setenv("CASGISTS_CACHE_DIR", "")
+
+	paths := NewContainerPathConfig()
+	require.NoError(t, paths.ResolveAll())
+	assert.Equal(t, filepath.Join(dataDir, "data.db"), paths.GetDatabasePath())
+	assert.Equal(t, filepath.Join(dataDir, "repositories"), paths.GetRepositoryDir())
+	assert.Equal(t, filepath.Join(dataDir, "cache"), paths.GetCacheDir())
+	assert.Empty(t, paths.GetLogDir(), "logs go to stdout only")
+	assert.Equal(t, filepath.Join(dataDir, "config.yaml"), ConfigFilePath(paths))
+}
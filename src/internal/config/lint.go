@@ -0,0 +1,790 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/casapps/casgists/src/internal/cron"
+	"github.com/casapps/casgists/src/internal/logging"
+)
+
+// Severity says how serious a lint diagnostic is
+type Severity string
+
+const (
+	SeverityError   Severity = "error"
+	SeverityWarning Severity = "warning"
+)
+
+// minSecretKeyLength is the shortest secret key not reported as weak
+const minSecretKeyLength = 32
+
+// placeholderSecrets are example secret keys from the documentation and
+// sample configs that must never be used for real
+var placeholderSecrets = []string{
+	"your-super-secret-key-at-least-32-characters-long",
+	"change-me",
+	"changeme",
+	"secret",
+	"your-secret-key",
+}
+
+// Diagnostic is a single finding of the configuration linter
+type Diagnostic struct {
+	Severity Severity `json:"severity"`
+	Code     string   `json:"code"`
+	Key      string   `json:"key,omitempty"`
+	Message  string   `json:"message"`
+	Hint     string   `json:"hint,omitempty"`
+}
+
+// LintReport holds every diagnostic found in a configuration
+type LintReport struct {
+	Valid       bool         `json:"valid"`
+	Errors      int          `json:"errors"`
+	Warnings    int          `json:"warnings"`
+	Diagnostics []Diagnostic `json:"diagnostics"`
+}
+
+// LintOptions controls the checks that touch the outside world
+type LintOptions struct {
+	// CheckSMTP dials the SMTP server when email is enabled
+	CheckSMTP bool
+	// Timeout bounds each network check
+	Timeout time.Duration
+}
+
+// Add records a diagnostic and updates the counts
+func (r *LintReport) Add(d Diagnostic) {
+	r.Diagnostics = append(r.Diagnostics, d)
+	switch d.Severity {
+	case SeverityError:
+		r.Errors++
+	case SeverityWarning:
+		r.Warnings++
+	}
+	r.Valid = r.Errors == 0
+}
+
+// Failed reports whether the configuration should be rejected. In strict
+// mode warnings count as failures too, which is what CI usually wants.
+func (r *LintReport) Failed(strict bool) bool {
+	return r.Errors > 0 || (strict && r.Warnings > 0)
+}
+
+// Lint checks a loaded configuration for mistakes and insecure settings.
+// Every diagnostic says which key to change and how.
+func Lint(cfg *Config, opts LintOptions) *LintReport {
+	var report *LintReport
+	cfg.read(func(v *viper.Viper) { report = lint(v, opts) })
+	return report
+}
+
+func lint(v *viper.Viper, opts LintOptions) *LintReport {
+	if opts.Timeout <= 0 {
+		opts.Timeout = 5 * time.Second
+	}
+
+	report := &LintReport{Valid: true, Diagnostics: []Diagnostic{}}
+	if err := ValidateConfig(v); err != nil {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "invalid_config",
+			Message:  err.Error(),
+		})
+	}
+
+	lintSecurity(v, report)
+	lintServer(v, report)
+	lintEmail(v, report, opts)
+	lintPaths(v, report)
+	lintReplication(v, report)
+	lintEnrichment(v, report)
+	lintFormatting(v, report)
+	lintSearch(v, report)
+	lintOAuth(v, report)
+	lintWebAuthn(v, report)
+	lintRateLimit(v, report)
+	lintCache(v, report)
+	lintStorage(v, report)
+	lintBackup(v, report)
+	lintLogging(v, report)
+	lintHealth(v, report)
+
+	return report
+}
+
+func lintSecurity(v *viper.Viper, report *LintReport) {
+	secret := v.GetString("security.secret_key")
+	for _, placeholder := range placeholderSecrets {
+		if strings.EqualFold(secret, placeholder) {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "secret_key_placeholder",
+				Key:      "security.secret_key",
+				Message:  "the secret key is an example value from the documentation",
+				Hint:     "set security.secret_key to a random value, e.g. the output of `openssl rand -hex 32`",
+			})
+			secret = ""
+			break
+		}
+	}
+	if secret != "" && (len(secret) < minSecretKeyLength || distinctChars(secret) < 8) {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "secret_key_weak",
+			Key:      "security.secret_key",
+			Message:  fmt.Sprintf("the secret key is weak (%d characters)", len(secret)),
+			Hint:     fmt.Sprintf("use at least %d random characters, e.g. the output of `openssl rand -hex 32`", minSecretKeyLength),
+		})
+	}
+
+	if v.GetBool("security.disable_csrf") {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "csrf_disabled",
+			Key:      "security.disable_csrf",
+			Message:  "CSRF protection is disabled, so other sites can submit forms as logged-in users",
+			Hint:     "remove security.disable_csrf or set it to false",
+		})
+	}
+
+	restricted := v.GetBool("registration.invite_only") || v.GetBool("registration.require_approval")
+	if v.GetBool("features.registration") && !restricted && !v.GetBool("auth.require_email_verification") {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "open_registration_unverified",
+			Key:      "auth.require_email_verification",
+			Message:  "registration is open and email addresses are not verified, so anyone can sign up with any address",
+			Hint:     "set auth.require_email_verification to true, or features.registration to false",
+		})
+	}
+	if !v.GetBool("features.registration") && (restricted ||
+		len(v.GetStringSlice("registration.allowed_domains")) > 0 || len(v.GetStringSlice("registration.denied_domains")) > 0) {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "registration_controls_unused",
+			Key:      "registration",
+			Message:  "registration controls are set but features.registration is off, so no one can sign up",
+			Hint:     "set features.registration to true to let people sign up under these rules",
+		})
+	}
+	if v.GetBool("auth.require_email_verification") && !v.GetBool("email.enabled") {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "verification_without_email",
+			Key:      "auth.require_email_verification",
+			Message:  "email verification is required but email is disabled, so new users can never verify",
+			Hint:     "set email.enabled to true and configure email.smtp",
+		})
+	}
+}
+
+func lintServer(v *viper.Viper, report *LintReport) {
+	tlsEnabled := v.GetBool("server.tls.enabled")
+	if tlsEnabled && !v.GetBool("server.tls.auto_cert") {
+		for _, key := range []string{"server.tls.cert_path", "server.tls.key_path"} {
+			path := v.GetString(key)
+			if path == "" {
+				report.Add(Diagnostic{
+					Severity: SeverityError,
+					Code:     "tls_file_missing",
+					Key:      key,
+					Message:  "TLS is enabled but " + key + " is not set",
+					Hint:     "set " + key + ", or enable server.tls.auto_cert",
+				})
+			} else if _, err := os.Stat(path); err != nil {
+				report.Add(Diagnostic{
+					Severity: SeverityError,
+					Code:     "tls_file_missing",
+					Key:      key,
+					Message:  fmt.Sprintf("cannot read %s: %v", path, err),
+					Hint:     "point " + key + " at an existing PEM file",
+				})
+			}
+		}
+	}
+	for _, proxy := range v.GetStringSlice("server.trusted_proxies") {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		if cidrErr != nil && net.ParseIP(proxy) == nil {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "trusted_proxy_invalid",
+				Key:      "server.trusted_proxies",
+				Message:  fmt.Sprintf("%q is not an IP address or CIDR range, so no proxy is trusted", proxy),
+				Hint:     "list the addresses of your reverse proxies, e.g. 127.0.0.1 or 10.0.0.0/8",
+			})
+		}
+	}
+	if tlsEnabled && v.GetBool("server.tls.auto_cert") && len(v.GetStringSlice("server.tls.acme.domains")) == 0 {
+		if u, err := url.Parse(v.GetString("server.url")); err != nil || u.Hostname() == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "acme_domains_missing",
+				Key:      "server.tls.acme.domains",
+				Message:  "automatic certificates are enabled but there is no domain to request them for",
+				Hint:     "set server.tls.acme.domains, or server.url to the public https:// address",
+			})
+		}
+	}
+
+	if raw := v.GetString("server.url"); raw != "" {
+		u, err := url.Parse(raw)
+		switch {
+		case err != nil || u.Scheme == "" || u.Host == "":
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "server_url_invalid",
+				Key:      "server.url",
+				Message:  fmt.Sprintf("%q is not an absolute URL", raw),
+				Hint:     "use the public address including the scheme, e.g. https://gists.example.com",
+			})
+		case u.Scheme == "http" && !isLocalHost(u.Hostname()) && !tlsEnabled:
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "server_url_insecure",
+				Key:      "server.url",
+				Message:  "the public URL uses plain HTTP, so passwords and session cookies are sent unencrypted",
+				Hint:     "serve the instance over HTTPS and use an https:// server.url",
+			})
+		}
+	}
+}
+
+func lintEmail(v *viper.Viper, report *LintReport, opts LintOptions) {
+	if v.GetBool("email.enabled") && v.GetBool("email.digest.enabled") {
+		if _, err := cron.ParseAt(v.GetString("email.digest.schedule"), v.GetString("email.digest.time")); err != nil {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "digest_schedule_invalid",
+				Key:      "email.digest.schedule",
+				Message:  fmt.Sprintf("weekly digests are off: %v", err),
+				Hint:     "use weekly with email.digest.time as HH:MM, or a cron expression such as \"0 9 * * 1\"",
+			})
+		}
+	}
+
+	// A missing host or sender is already reported by ValidateConfig
+	host := v.GetString("email.smtp.host")
+	if !v.GetBool("email.enabled") || host == "" || !opts.CheckSMTP {
+		return
+	}
+
+	address := net.JoinHostPort(host, fmt.Sprintf("%d", v.GetInt("email.smtp.port")))
+	conn, err := net.DialTimeout("tcp", address, opts.Timeout)
+	if err != nil {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "smtp_unreachable",
+			Key:      "email.smtp.host",
+			Message:  fmt.Sprintf("cannot connect to the SMTP server at %s: %v", address, err),
+			Hint:     "check email.smtp.host and email.smtp.port, and that the firewall allows outgoing SMTP",
+		})
+		return
+	}
+	conn.Close()
+}
+
+func lintPaths(v *viper.Viper, report *LintReport) {
+	type lintPath struct {
+		key   string
+		isDir bool
+	}
+	paths := []lintPath{{"storage.path", true}, {"backup.path", true}}
+	if t := v.GetString("database.type"); t == "sqlite" || t == "" {
+		paths = append(paths, lintPath{"database.path", false})
+	}
+	if v.GetString("search.backend") == "bleve" {
+		paths = append(paths, lintPath{"search.bleve.path", false})
+	}
+	// An empty local path falls back to git.repo_path, so only check a set one
+	if d := v.GetString("git.storage.driver"); (d == "local" || d == "") && v.GetString("git.storage.local.path") != "" {
+		paths = append(paths, lintPath{"git.storage.local.path", true})
+	}
+
+	for _, p := range paths {
+		path := v.GetString(p.key)
+		if path == "" || strings.Contains(path, "{") {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "path_unresolved",
+				Key:      p.key,
+				Message:  fmt.Sprintf("%s is not a usable path: %q", p.key, path),
+				Hint:     "set " + p.key + " to an absolute path",
+			})
+			continue
+		}
+
+		dir := path
+		if !p.isDir {
+			dir = filepath.Dir(path)
+		}
+		if err := checkWritable(dir); err != nil {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "path_not_writable",
+				Key:      p.key,
+				Message:  err.Error(),
+				Hint:     "create the directory and make it writable by the user running casgists",
+			})
+		}
+	}
+}
+
+func lintReplication(v *viper.Viper, report *LintReport) {
+	if !v.GetBool("replication.enabled") {
+		return
+	}
+	if t := v.GetString("database.type"); t != "sqlite" && t != "" {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "replication_unsupported",
+			Key:      "replication.enabled",
+			Message:  fmt.Sprintf("WAL shipping only works with SQLite, not %s", t),
+			Hint:     "set replication.enabled to false and use the replication built into " + t,
+		})
+		return
+	}
+	if v.GetString("replication.target") == "" && v.GetString("replication.hooks.upload") == "" {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "replication_target_missing",
+			Key:      "replication.target",
+			Message:  "replication is enabled but there is nowhere to ship the WAL to",
+			Hint:     "set replication.target to a directory, or replication.hooks.upload, download and list to commands",
+		})
+	}
+}
+
+func lintEnrichment(v *viper.Viper, report *LintReport) {
+	switch provider := v.GetString("enrichment.provider"); provider {
+	case "", "heuristic", "disabled":
+	case "http":
+		if v.GetString("enrichment.url") == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "enrichment_url_missing",
+				Key:      "enrichment.url",
+				Message:  "the http metadata suggestion provider has no service URL, so the heuristic is used",
+				Hint:     "set enrichment.url, or enrichment.provider to heuristic",
+			})
+		}
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "enrichment_provider_unknown",
+			Key:      "enrichment.provider",
+			Message:  fmt.Sprintf("unknown metadata suggestion provider %q, so the heuristic is used", provider),
+			Hint:     "use heuristic, http or disabled",
+		})
+	}
+}
+
+func lintSearch(v *viper.Viper, report *LintReport) {
+	switch backend := v.GetString("search.backend"); backend {
+	case "", "sqlite", "bleve":
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "search_backend_unknown",
+			Key:      "search.backend",
+			Message:  fmt.Sprintf("unknown search backend %q, so the database's full-text index is used", backend),
+			Hint:     "use sqlite or bleve",
+		})
+	}
+}
+
+func lintOAuth(v *viper.Viper, report *LintReport) {
+	enabled := false
+	for _, provider := range []string{"github", "gitlab", "oidc"} {
+		prefix := "oauth." + provider + "."
+		if !v.GetBool(prefix + "enabled") {
+			continue
+		}
+		enabled = true
+		if v.GetString(prefix+"client_id") == "" || v.GetString(prefix+"client_secret") == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "oauth_client_missing",
+				Key:      prefix + "client_id",
+				Message:  fmt.Sprintf("the %s sign in provider is enabled without a client ID and secret", provider),
+				Hint:     fmt.Sprintf("set %sclient_id and %sclient_secret from the OAuth application registered with the provider", prefix, prefix),
+			})
+		}
+		if provider == "oidc" && v.GetString(prefix+"url") == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "oauth_issuer_missing",
+				Key:      prefix + "url",
+				Message:  "the OpenID Connect sign in provider is enabled without an issuer URL",
+				Hint:     "set oauth.oidc.url to the issuer, the URL its /.well-known/openid-configuration is under",
+			})
+		}
+	}
+	if enabled && v.GetString("server.url") == "" {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "oauth_callback_relative",
+			Key:      "server.url",
+			Message:  "sign in providers are enabled but there is no public URL to build their callback URL from",
+			Hint:     "set server.url; providers redirect to <server.url>/auth/oauth/<provider>/callback",
+		})
+	}
+}
+
+func lintWebAuthn(v *viper.Viper, report *LintReport) {
+	require := v.GetString("security.webauthn.require")
+	switch require {
+	case "", "off", "admins", "all":
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "webauthn_require_unknown",
+			Key:      "security.webauthn.require",
+			Message:  fmt.Sprintf("unknown security key requirement %q, so no one is required to use one", require),
+			Hint:     "use off, admins or all",
+		})
+	}
+	if !v.GetBool("security.webauthn.enabled") {
+		if require == "admins" || require == "all" {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "webauthn_require_disabled",
+				Key:      "security.webauthn.require",
+				Message:  "security keys are required but disabled, so the requirement has no effect",
+				Hint:     "set security.webauthn.enabled to true or security.webauthn.require to off",
+			})
+		}
+		return
+	}
+	// Keys are bound to the host name they were registered under, so
+	// requiring them while that depends on the request risks lockouts
+	if (require == "admins" || require == "all") && v.GetString("server.url") == "" && v.GetString("security.webauthn.rp_id") == "" {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "webauthn_rp_id_unset",
+			Key:      "server.url",
+			Message:  "security keys are required but are bound to the host name each request came in on",
+			Hint:     "set server.url or security.webauthn.rp_id; keys registered under one host name don't work under another",
+		})
+	}
+}
+
+func lintRateLimit(v *viper.Viper, report *LintReport) {
+	if !v.GetBool("ratelimit.enabled") {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "ratelimit_disabled",
+			Key:      "ratelimit.enabled",
+			Message:  "rate limiting is disabled, so passwords can be guessed as fast as the server answers",
+			Hint:     "set ratelimit.enabled to true and raise the limits that get in the way instead",
+		})
+		return
+	}
+	for _, key := range []string{"ratelimit.register_window", "ratelimit.password_reset_window", "ratelimit.api_window", "ratelimit.raw_window"} {
+		if value := v.GetString(key); value != "" {
+			if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+				report.Add(Diagnostic{
+					Severity: SeverityError,
+					Code:     "ratelimit_window_invalid",
+					Key:      key,
+					Message:  fmt.Sprintf("%q is not a window length, so the limit is not applied", value),
+					Hint:     "use a duration such as 1m or 1h",
+				})
+			}
+		}
+	}
+	// Token requests also count against their IP, so a lower IP limit
+	// caps every token behind one address
+	for _, policy := range []string{"api", "raw"} {
+		perIP, perToken := v.GetInt("ratelimit."+policy+"_per_ip"), v.GetInt("ratelimit."+policy+"_per_token")
+		if perIP > 0 && perToken > perIP {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "ratelimit_ip_below_token",
+				Key:      "ratelimit." + policy + "_per_ip",
+				Message:  fmt.Sprintf("ratelimit.%s_per_ip (%d) is lower than ratelimit.%s_per_token (%d), so a token never reaches its own limit", policy, perIP, policy, perToken),
+				Hint:     fmt.Sprintf("raise ratelimit.%s_per_ip to a multiple of the token limit, enough for the clients sharing an address", policy),
+			})
+		}
+	}
+}
+
+func lintCache(v *viper.Viper, report *LintReport) {
+	switch backend := v.GetString("cache.type"); backend {
+	case "", "auto", "memory":
+	case "redis":
+		if !v.GetBool("redis.enabled") {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "cache_redis_disabled",
+				Key:      "cache.type",
+				Message:  "the cache is set to Redis but redis.enabled is off, so rate limits stay in each instance's memory",
+				Hint:     "set redis.enabled to true, or cache.type to auto",
+			})
+		}
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "cache_type_unknown",
+			Key:      "cache.type",
+			Message:  fmt.Sprintf("unknown cache type %q, so Redis is used only when redis.enabled is set", backend),
+			Hint:     "use auto, memory or redis",
+		})
+	}
+}
+
+func lintStorage(v *viper.Viper, report *LintReport) {
+	switch storageType := v.GetString("storage.type"); storageType {
+	case "", "local":
+	case "s3", "minio":
+		if v.GetString("storage.s3.bucket") == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "storage_bucket_missing",
+				Key:      "storage.s3.bucket",
+				Message:  fmt.Sprintf("storage.type is %s but no bucket is set", storageType),
+				Hint:     "set storage.s3.bucket to an existing bucket",
+			})
+		}
+		if storageType == "minio" && v.GetString("storage.s3.endpoint") == "" {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "storage_endpoint_missing",
+				Key:      "storage.s3.endpoint",
+				Message:  "MinIO storage has no endpoint",
+				Hint:     "set storage.s3.endpoint to the MinIO server's URL",
+			})
+		}
+		hasSecret := v.GetString("storage.s3.secret_access_key") != "" || v.GetString("storage.s3.secret_access_key_file") != ""
+		if (v.GetString("storage.s3.access_key_id") == "" || !hasSecret) &&
+			(os.Getenv("AWS_ACCESS_KEY_ID") == "" || os.Getenv("AWS_SECRET_ACCESS_KEY") == "") {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "storage_credentials_missing",
+				Key:      "storage.s3.access_key_id",
+				Message:  "object storage has no access key ID and secret access key",
+				Hint:     "set storage.s3.access_key_id and storage.s3.secret_access_key (or _file), or AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY",
+			})
+		}
+		if endpoint, err := url.Parse(v.GetString("storage.s3.endpoint")); err == nil && endpoint.Scheme == "http" && !isLocalHost(endpoint.Hostname()) {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "storage_endpoint_insecure",
+				Key:      "storage.s3.endpoint",
+				Message:  "object storage is reached over plain HTTP, so attachments, backups and exports cross the network unencrypted",
+				Hint:     "use an https:// endpoint",
+			})
+		}
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "storage_type_unknown",
+			Key:      "storage.type",
+			Message:  fmt.Sprintf("unknown storage type %q", storageType),
+			Hint:     "use local, s3 or minio",
+		})
+	}
+}
+
+func lintBackup(v *viper.Viper, report *LintReport) {
+	if !v.GetBool("backup.enabled") {
+		return
+	}
+	for _, key := range []string{"backup.schedule", "backup.incremental_schedule"} {
+		spec := v.GetString(key)
+		if spec == "" && key == "backup.incremental_schedule" {
+			continue
+		}
+		if _, err := cron.ParseAt(spec, v.GetString("backup.time")); err != nil {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "backup_schedule_invalid",
+				Key:      key,
+				Message:  fmt.Sprintf("scheduled backups are off: %v", err),
+				Hint:     "use hourly, daily, weekly or monthly with backup.time as HH:MM, or a cron expression such as \"0 2 * * *\"",
+			})
+		}
+	}
+
+	if !v.GetBool("backup.encrypt") {
+		return
+	}
+	if file := v.GetString("backup.encryption_key_file"); file != "" && v.GetString("backup.encryption_key") == "" {
+		if _, err := os.ReadFile(file); err != nil {
+			report.Add(Diagnostic{
+				Severity: SeverityError,
+				Code:     "backup_encryption_key_unreadable",
+				Key:      "backup.encryption_key_file",
+				Message:  fmt.Sprintf("the backup encryption key can't be read, so backups fail: %v", err),
+				Hint:     "point backup.encryption_key_file at a readable file holding the passphrase",
+			})
+		}
+		return
+	}
+	// Unencrypted backups kept on the server are as exposed as the data
+	// itself; uploaded ones are not
+	if storageType := v.GetString("storage.type"); v.GetString("backup.encryption_key") == "" && storageType != "" && storageType != "local" {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "backup_encryption_key_missing",
+			Key:      "backup.encryption_key",
+			Message:  fmt.Sprintf("backup.encrypt is on but no key is set, so backups are uploaded to %s unencrypted", storageType),
+			Hint:     "set backup.encryption_key or backup.encryption_key_file, and keep a copy of the key away from the server",
+		})
+	}
+}
+
+func lintFormatting(v *viper.Viper, report *LintReport) {
+	if !v.GetBool("formatting.enabled") {
+		return
+	}
+	switch mode := v.GetString("formatting.mode"); mode {
+	case "", "format", "annotate":
+	default:
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "formatting_mode_unknown",
+			Key:      "formatting.mode",
+			Message:  fmt.Sprintf("unknown formatting mode %q, so files are saved as written", mode),
+			Hint:     "use format or annotate",
+		})
+	}
+
+	var tools []struct {
+		Name    string   `mapstructure:"name"`
+		Command []string `mapstructure:"command"`
+	}
+	if err := v.UnmarshalKey("formatting.tools", &tools); err != nil || len(tools) == 0 {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "formatting_tools_missing",
+			Key:      "formatting.tools",
+			Message:  "formatting hooks are enabled but no tools are configured",
+			Hint:     "add formatters or linters to formatting.tools, or set formatting.enabled to false",
+		})
+		return
+	}
+
+	// The sandbox wrapper, when set, is what has to be installed
+	sandbox := v.GetStringSlice("formatting.sandbox")
+	for i, tool := range tools {
+		argv := append(append([]string{}, sandbox...), tool.Command...)
+		if len(argv) == 0 {
+			continue
+		}
+		if _, err := exec.LookPath(argv[0]); err != nil {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "formatting_tool_not_found",
+				Key:      fmt.Sprintf("formatting.tools[%d].command", i),
+				Message:  fmt.Sprintf("%s is not installed, so the %s hook is skipped", argv[0], tool.Name),
+				Hint:     "install it or remove the tool",
+			})
+		}
+	}
+}
+
+// checkWritable reports whether dir can be written to, or created if it does
+// not exist yet. Nothing is left behind on disk.
+func checkWritable(dir string) error {
+	existing := dir
+	for {
+		info, err := os.Stat(existing)
+		if err == nil {
+			if !info.IsDir() {
+				return fmt.Errorf("%s is not a directory", existing)
+			}
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			return fmt.Errorf("no existing parent directory for %s", dir)
+		}
+		existing = parent
+	}
+
+	probe, err := os.CreateTemp(existing, ".casgists-lint-*")
+	if err != nil {
+		if existing != dir {
+			return fmt.Errorf("%s does not exist and cannot be created in %s: %v", dir, existing, err)
+		}
+		return fmt.Errorf("%s is not writable: %v", dir, err)
+	}
+	probe.Close()
+	os.Remove(probe.Name())
+	return nil
+}
+
+func distinctChars(s string) int {
+	seen := map[rune]bool{}
+	for _, r := range s {
+		seen[r] = true
+	}
+	return len(seen)
+}
+
+func isLocalHost(host string) bool {
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func lintLogging(v *viper.Viper, report *LintReport) {
+	if _, err := logging.ParseLevel(v.GetString("logging.level")); err != nil {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "log_level_invalid",
+			Key:      "logging.level",
+			Message:  fmt.Sprintf("%s, so the info level is used", err),
+			Hint:     "use debug, info, warn or error",
+		})
+	}
+	if format := strings.ToLower(v.GetString("logging.format")); format != "" && !logging.ValidFormat(format) {
+		report.Add(Diagnostic{
+			Severity: SeverityError,
+			Code:     "log_format_invalid",
+			Key:      "logging.format",
+			Message:  fmt.Sprintf("unknown log format %q, so the console format is used", format),
+			Hint:     "use console for terminals or json for log collectors",
+		})
+	}
+}
+
+// healthComponents are the components /readyz can check
+var healthComponents = map[string]bool{
+	"database": true, "storage": true, "repo_storage": true, "cache": true, "search": true, "smtp": true,
+}
+
+func lintHealth(v *viper.Viper, report *LintReport) {
+	for _, name := range v.GetStringSlice("health.required") {
+		if !healthComponents[name] {
+			report.Add(Diagnostic{
+				Severity: SeverityWarning,
+				Code:     "health_component_unknown",
+				Key:      "health.required",
+				Message:  fmt.Sprintf("unknown readiness component %q is never checked", name),
+				Hint:     "use database, storage, repo_storage, cache, search or smtp",
+			})
+		}
+	}
+	if v.GetInt("health.failure_threshold") < 1 {
+		report.Add(Diagnostic{
+			Severity: SeverityWarning,
+			Code:     "health_threshold_invalid",
+			Key:      "health.failure_threshold",
+			Message:  "the failure threshold is below 1, so a single failed check fails readiness",
+			Hint:     "set the number of failed checks in a row that take the instance out of rotation",
+		})
+	}
+}
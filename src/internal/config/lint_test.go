@@ -0,0 +1,250 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func lintCodes(report *LintReport) []string {
+	codes := []string{}
+	for _, d := range report.Diagnostics {
+		codes = append(codes, d.Code)
+	}
+	return codes
+}
+
+func TestLint(t *testing.T) {
+	newConfig := func(t *testing.T) *Config {
+		dir := t.TempDir()
+		v := testConfig(t, "")
+		v.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e4b6d8f0a1c3e5b7d9f2a4c6e8b0d1f3a")
+		v.Set("features.registration", false)
+		v.Set("database.path", filepath.Join(dir, "data.db"))
+		v.Set("storage.path", filepath.Join(dir, "files"))
+		v.Set("backup.path", filepath.Join(dir, "backups"))
+		return v
+	}
+
+	t.Run("Clean", func(t *testing.T) {
+		report := Lint(newConfig(t), LintOptions{})
+		assert.Empty(t, report.Diagnostics)
+		assert.True(t, report.Valid)
+		assert.False(t, report.Failed(true))
+	})
+
+	t.Run("InsecureSettings", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("security.secret_key", "short")
+		v.Set("security.disable_csrf", true)
+		v.Set("features.registration", true)
+		v.Set("server.url", "http://gists.example.com")
+
+		report := Lint(v, LintOptions{})
+		assert.ElementsMatch(t, []string{
+			"secret_key_weak", "csrf_disabled", "open_registration_unverified", "server_url_insecure",
+		}, lintCodes(report))
+		assert.Equal(t, 4, report.Warnings)
+		assert.True(t, report.Valid)
+		assert.False(t, report.Failed(false))
+		assert.True(t, report.Failed(true))
+	})
+
+	t.Run("Registration", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("registration.invite_only", true)
+		assert.ElementsMatch(t, []string{"registration_controls_unused"}, lintCodes(Lint(v, LintOptions{})))
+
+		// Invite-only registration isn't open to any address
+		v.Set("features.registration", true)
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("registration.invite_only", false)
+		v.Set("registration.allowed_domains", []string{"example.com"})
+		assert.ElementsMatch(t, []string{"open_registration_unverified"}, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("Errors", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("security.secret_key", "your-super-secret-key-at-least-32-characters-long")
+		v.Set("auth.require_email_verification", true)
+		v.Set("server.tls.enabled", true)
+
+		report := Lint(v, LintOptions{})
+		assert.ElementsMatch(t, []string{
+			"secret_key_placeholder", "verification_without_email", "tls_file_missing", "tls_file_missing",
+		}, lintCodes(report))
+		assert.False(t, report.Valid)
+
+		// Automatic certificates don't need files, but a domain
+		v.Set("server.tls.auto_cert", true)
+		assert.Contains(t, lintCodes(Lint(v, LintOptions{})), "acme_domains_missing")
+		v.Set("server.url", "https://gists.example.com")
+		assert.NotContains(t, lintCodes(Lint(v, LintOptions{})), "acme_domains_missing")
+		assert.NotContains(t, lintCodes(Lint(v, LintOptions{})), "tls_file_missing")
+
+		v.Set("server.trusted_proxies", []string{"10.0.0.0/8", "proxy.internal"})
+		assert.Contains(t, lintCodes(Lint(v, LintOptions{})), "trusted_proxy_invalid")
+
+		v.Set("logging.level", "verbose")
+		v.Set("logging.format", "xml")
+		assert.Contains(t, lintCodes(Lint(v, LintOptions{})), "log_level_invalid")
+		assert.Contains(t, lintCodes(Lint(v, LintOptions{})), "log_format_invalid")
+	})
+
+	t.Run("Health", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("health.required", []string{"database", "redis"})
+		v.Set("health.failure_threshold", 0)
+		assert.ElementsMatch(t, []string{"health_component_unknown", "health_threshold_invalid"}, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("UnwritablePath", func(t *testing.T) {
+		v := newConfig(t)
+		file := filepath.Join(t.TempDir(), "not-a-dir")
+		require.NoError(t, os.WriteFile(file, []byte("x"), 0644))
+		v.Set("storage.path", filepath.Join(file, "files"))
+		v.Set("backup.path", "{paths.data}/backups")
+
+		report := Lint(v, LintOptions{})
+		assert.ElementsMatch(t, []string{"path_not_writable", "path_unresolved"}, lintCodes(report))
+	})
+
+	t.Run("SearchBackend", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("search.backend", "solr")
+		assert.ElementsMatch(t, []string{"search_backend_unknown"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("search.backend", "bleve")
+		v.Set("search.bleve.path", "{paths.data}/search.bleve")
+		assert.ElementsMatch(t, []string{"path_unresolved"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("search.bleve.path", filepath.Join(t.TempDir(), "search.bleve"))
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("OAuthProviders", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("oauth.github.enabled", true)
+		v.Set("oauth.oidc.enabled", true)
+		assert.ElementsMatch(t, []string{
+			"oauth_client_missing", "oauth_client_missing", "oauth_issuer_missing", "oauth_callback_relative",
+		}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("server.url", "https://gists.example.com")
+		v.Set("oauth.oidc.enabled", false)
+		v.Set("oauth.github.client_id", "id")
+		v.Set("oauth.github.client_secret", "secret")
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("WebAuthn", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("security.webauthn.require", "everyone")
+		assert.ElementsMatch(t, []string{"webauthn_require_unknown"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("security.webauthn.require", "admins")
+		assert.ElementsMatch(t, []string{"webauthn_rp_id_unset"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("security.webauthn.enabled", false)
+		assert.ElementsMatch(t, []string{"webauthn_require_disabled"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("security.webauthn.enabled", true)
+		v.Set("server.url", "https://gists.example.com")
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("RateLimit", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("ratelimit.api_window", "1 minute")
+		v.Set("ratelimit.raw_per_ip", 100)
+		assert.ElementsMatch(t, []string{"ratelimit_window_invalid", "ratelimit_ip_below_token"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("ratelimit.enabled", false)
+		assert.ElementsMatch(t, []string{"ratelimit_disabled"}, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("Cache", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("cache.type", "memcached")
+		assert.ElementsMatch(t, []string{"cache_type_unknown"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("cache.type", "redis")
+		assert.ElementsMatch(t, []string{"cache_redis_disabled"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("redis.enabled", true)
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("Storage", func(t *testing.T) {
+		t.Setenv("AWS_ACCESS_KEY_ID", "")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+		v := newConfig(t)
+		v.Set("backup.encryption_key", "correct horse battery staple")
+		v.Set("storage.type", "tape")
+		assert.ElementsMatch(t, []string{"storage_type_unknown"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("storage.type", "minio")
+		v.Set("storage.s3.endpoint", "http://minio.internal:9000")
+		assert.ElementsMatch(t, []string{"storage_bucket_missing", "storage_credentials_missing", "storage_endpoint_insecure"},
+			lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("storage.s3.bucket", "gists")
+		v.Set("storage.s3.endpoint", "https://minio.internal:9000")
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("Backup", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("backup.schedule", "0 25 * * *")
+		v.Set("backup.incremental_schedule", "daily")
+		v.Set("backup.time", "2am")
+		assert.ElementsMatch(t, []string{"backup_schedule_invalid", "backup_schedule_invalid"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("backup.schedule", "weekly")
+		v.Set("backup.time", "02:00")
+		v.Set("backup.encryption_key_file", filepath.Join(t.TempDir(), "missing"))
+		assert.ElementsMatch(t, []string{"backup_encryption_key_unreadable"}, lintCodes(Lint(v, LintOptions{})))
+
+		t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+		t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+		v.Set("backup.encryption_key_file", "")
+		v.Set("storage.type", "s3")
+		v.Set("storage.s3.bucket", "gists")
+		assert.ElementsMatch(t, []string{"backup_encryption_key_missing"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("backup.encryption_key", "correct horse battery staple")
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("Digest", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("email.enabled", true)
+		v.Set("email.smtp.host", "smtp.example.com")
+		v.Set("email.from.address", "noreply@example.com")
+		v.Set("email.digest.time", "9am")
+		assert.ElementsMatch(t, []string{"digest_schedule_invalid"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("email.digest.enabled", false)
+		assert.Empty(t, lintCodes(Lint(v, LintOptions{})))
+	})
+
+	t.Run("FormattingHooks", func(t *testing.T) {
+		v := newConfig(t)
+		v.Set("formatting.enabled", true)
+		v.Set("formatting.mode", "prettify")
+		assert.ElementsMatch(t, []string{"formatting_mode_unknown", "formatting_tools_missing"}, lintCodes(Lint(v, LintOptions{})))
+
+		v.Set("formatting.mode", "format")
+		v.Set("formatting.tools", []map[string]interface{}{
+			{"name": "cat", "kind": "formatter", "command": []string{"cat"}},
+			{"name": "nope", "kind": "linter", "command": []string{"casgists-no-such-linter"}},
+		})
+		assert.ElementsMatch(t, []string{"formatting_tool_not_found"}, lintCodes(Lint(v, LintOptions{})))
+	})
+}
@@ -0,0 +1,226 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Config is the running configuration every component reads. It is safe
+// for concurrent use: a reload or a setting saved by an administrator
+// changes it while requests read it. A reload builds a new viper from the
+// config file and swaps it in, so values set at runtime with Set and
+// SetDefault are kept and applied again over it.
+type Config struct {
+	mu sync.RWMutex
+	v  *viper.Viper
+
+	// load builds the configuration from the config file's contents the
+	// way it was built at startup
+	load      func(file []byte) (*viper.Viper, error)
+	defaults  map[string]interface{}
+	overrides map[string]interface{}
+}
+
+// New returns an empty configuration, for tools and tests
+func New() *Config {
+	return newConfig(viper.New(), func(file []byte) (*viper.Viper, error) {
+		v := viper.New()
+		v.SetConfigType("yaml")
+		return v, v.ReadConfig(bytes.NewReader(file))
+	})
+}
+
+func newConfig(v *viper.Viper, load func(file []byte) (*viper.Viper, error)) *Config {
+	return &Config{
+		v:         v,
+		load:      load,
+		defaults:  map[string]interface{}{},
+		overrides: map[string]interface{}{},
+	}
+}
+
+// read calls fn with the viper holding the configuration, which must not
+// be kept past fn
+func (c *Config) read(fn func(v *viper.Viper)) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	fn(c.v)
+}
+
+// rebuild builds the configuration again from file, the config file's
+// contents, with the runtime values applied over it. It is checked with
+// ValidateConfig before it replaces the running one, and the settings
+// before and after are returned.
+func (c *Config) rebuild(file []byte) (before, after map[string]interface{}, err error) {
+	v, err := c.load(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to parse the config file: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range c.defaults {
+		v.SetDefault(key, value)
+	}
+	for key, value := range c.overrides {
+		v.Set(key, value)
+	}
+	v.SetConfigFile(c.v.ConfigFileUsed())
+	if err := ValidateConfig(v); err != nil {
+		return nil, nil, err
+	}
+	before = snapshot(c.v)
+	c.v = v
+	return before, snapshot(v), nil
+}
+
+// Set sets a value over every other source; it is kept across reloads
+func (c *Config) Set(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.overrides[key] = value
+	c.v.Set(key, value)
+}
+
+// setAll sets several values like Set, all at once, so that readers see
+// either none or all of them
+func (c *Config) setAll(values map[string]interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, value := range values {
+		c.overrides[key] = value
+		c.v.Set(key, value)
+	}
+}
+
+// SetDefault sets the value used when no other source sets key; it is
+// kept across reloads
+func (c *Config) SetDefault(key string, value interface{}) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.defaults[key] = value
+	c.v.SetDefault(key, value)
+}
+
+// Get returns the value of key
+func (c *Config) Get(key string) interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.Get(key)
+}
+
+// GetString returns the value of key as a string
+func (c *Config) GetString(key string) string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetString(key)
+}
+
+// GetBool returns the value of key as a bool
+func (c *Config) GetBool(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetBool(key)
+}
+
+// GetInt returns the value of key as an int
+func (c *Config) GetInt(key string) int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetInt(key)
+}
+
+// GetInt64 returns the value of key as an int64
+func (c *Config) GetInt64(key string) int64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetInt64(key)
+}
+
+// GetUint64 returns the value of key as a uint64
+func (c *Config) GetUint64(key string) uint64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetUint64(key)
+}
+
+// GetFloat64 returns the value of key as a float64
+func (c *Config) GetFloat64(key string) float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetFloat64(key)
+}
+
+// GetDuration returns the value of key as a duration
+func (c *Config) GetDuration(key string) time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetDuration(key)
+}
+
+// GetStringSlice returns the value of key as a slice of strings
+func (c *Config) GetStringSlice(key string) []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetStringSlice(key)
+}
+
+// GetStringMap returns the value of key as a map
+func (c *Config) GetStringMap(key string) map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.GetStringMap(key)
+}
+
+// IsSet reports whether any source sets key
+func (c *Config) IsSet(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.IsSet(key)
+}
+
+// InConfig reports whether the config file sets key
+func (c *Config) InConfig(key string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.InConfig(key)
+}
+
+// AllKeys returns every key with a value
+func (c *Config) AllKeys() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.AllKeys()
+}
+
+// AllSettings returns every setting as nested maps
+func (c *Config) AllSettings() map[string]interface{} {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.AllSettings()
+}
+
+// UnmarshalKey decodes the settings under key into rawVal
+func (c *Config) UnmarshalKey(key string, rawVal interface{}, opts ...viper.DecoderConfigOption) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.UnmarshalKey(key, rawVal, opts...)
+}
+
+// Unmarshal decodes every setting into rawVal
+func (c *Config) Unmarshal(rawVal interface{}, opts ...viper.DecoderConfigOption) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.Unmarshal(rawVal, opts...)
+}
+
+// ConfigFileUsed returns the config file read at startup
+func (c *Config) ConfigFileUsed() string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.v.ConfigFileUsed()
+}
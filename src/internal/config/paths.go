@@ -106,10 +106,6 @@ var directoryConfig = map[string]DirectorySet{
 
 // NewPathConfig creates a new path configuration with platform defaults
 func NewPathConfig(privileged bool) *PathConfig {
-	config := &PathConfig{
-		resolved: make(map[string]string),
-	}
-
 	// Get platform-specific defaults
 	platformConfig, exists := directoryConfig[runtime.GOOS]
 	if !exists {
@@ -117,11 +113,17 @@ func NewPathConfig(privileged bool) *PathConfig {
 		platformConfig = directoryConfig["linux"]
 	}
 
-	var paths DirectoryPaths
 	if privileged {
-		paths = platformConfig.Privileged
-	} else {
-		paths = platformConfig.UserMode
+		return newPathConfig(platformConfig.Privileged)
+	}
+	return newPathConfig(platformConfig.UserMode)
+}
+
+// newPathConfig creates a path configuration from the given directories,
+// which the CASGISTS_*_DIR environment variables override
+func newPathConfig(paths DirectoryPaths) *PathConfig {
+	config := &PathConfig{
+		resolved: make(map[string]string),
 	}
 
 	// Set defaults from environment or platform defaults
@@ -197,6 +199,8 @@ func (p *PathConfig) ResolveAll() error {
 		return fmt.Errorf("failed to resolve backup dir: %w", err)
 	}
 
+	p.resolved["CacheDir"] = variables["CASGISTS_CACHE_DIR"]
+
 	p.resolved["SSLDir"], err = p.substituteVariables(p.SSLDir, variables)
 	if err != nil {
 		return fmt.Errorf("failed to resolve ssl dir: %w", err)
@@ -240,6 +244,11 @@ func (p *PathConfig) GetBackupDir() string {
 	return p.resolved["BackupDir"]
 }
 
+// GetCacheDir returns the resolved cache directory
+func (p *PathConfig) GetCacheDir() string {
+	return p.resolved["CacheDir"]
+}
+
 // GetSSLDir returns the resolved SSL directory
 func (p *PathConfig) GetSSLDir() string {
 	return p.resolved["SSLDir"]
@@ -314,8 +323,8 @@ func (p *PathConfig) expandPath(path string) (string, error) {
 		return "", nil
 	}
 
-	// Expand environment variables
-	expanded := os.ExpandEnv(path)
+	// Expand environment variables, in the Windows %VAR% form too
+	expanded := os.ExpandEnv(expandPercentEnv(path))
 
 	// Handle home directory expansion
 	if strings.HasPrefix(expanded, "~/") {
@@ -335,6 +344,36 @@ func (p *PathConfig) expandPath(path string) (string, error) {
 	return absPath, nil
 }
 
+// expandPercentEnv expands %VAR% references the way cmd.exe does, leaving
+// unset variables as they are. The Windows defaults are written this way.
+func expandPercentEnv(path string) string {
+	var b strings.Builder
+	for {
+		start := strings.IndexByte(path, '%')
+		if start < 0 {
+			break
+		}
+		end := strings.IndexByte(path[start+1:], '%')
+		if end < 0 {
+			break
+		}
+		end += start + 1
+
+		name := path[start+1 : end]
+		if value, ok := os.LookupEnv(name); ok && name != "" {
+			b.WriteString(path[:start])
+			b.WriteString(value)
+			path = path[end+1:]
+			continue
+		}
+		// Not a variable; the closing % may open the next one
+		b.WriteString(path[:end])
+		path = path[end:]
+	}
+	b.WriteString(path)
+	return b.String()
+}
+
 // getEnvOrDefault returns environment variable value or default if not set
 func getEnvOrDefault(envVar, defaultValue string) string {
 	if value := os.Getenv(envVar); value != "" {
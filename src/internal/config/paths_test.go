@@ -0,0 +1,33 @@
+package config
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestExpandPercentEnv(t *testing.T) {
+	t.Setenv("PROGRAMDATA", `C:\ProgramData`)
+	t.Setenv("CASGISTS_TEST_EMPTY", "")
+
+	assert.Equal(t, `C:\ProgramData\casgists`, expandPercentEnv(`%PROGRAMDATA%\casgists`))
+	assert.Equal(t, `C:\ProgramData\C:\ProgramData`, expandPercentEnv(`%PROGRAMDATA%\%PROGRAMDATA%`))
+	assert.Equal(t, `\casgists`, expandPercentEnv(`%CASGISTS_TEST_EMPTY%\casgists`))
+	// Unset variables and lone percent signs are left alone
+	assert.Equal(t, `%CASGISTS_TEST_UNSET%\casgists`, expandPercentEnv(`%CASGISTS_TEST_UNSET%\casgists`))
+	assert.Equal(t, `100%C:\ProgramData`, expandPercentEnv(`100%%PROGRAMDATA%`))
+	assert.Equal(t, `50% full`, expandPercentEnv(`50% full`))
+	assert.Equal(t, `%%`, expandPercentEnv(`%%`))
+}
+
+func TestResolveAllExpandsWindowsDefaults(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("PROGRAMDATA", dir)
+	t.Setenv("CASGISTS_DATA_DIR", "%PROGRAMDATA%/casgists")
+
+	paths := NewPathConfig(true)
+	require.NoError(t, paths.ResolveAll())
+	assert.Equal(t, filepath.Join(dir, "casgists", "data.db"), paths.GetDatabasePath())
+}
@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"os"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+// ErrNoConfigFile is returned by Reload when there is no config file to
+// read
+var ErrNoConfigFile = errors.New("no config file to reload")
+
+// restartKeys are the settings only read at startup. A reload applies
+// them, but they take effect after the next restart.
+var restartKeys = []string{
+	"server.host",
+	"server.port",
+	"server.tls.",
+	"database.",
+	"cache.",
+	"search.backend",
+	"storage.",
+	"git.storage.",
+	"replication.",
+	"security.secret_key",
+	"email.enabled",
+	"logging.format",
+	"logging.directory",
+	"logging.rotation.",
+}
+
+// ReloadResult describes a reload
+type ReloadResult struct {
+	File            string    `json:"file"`
+	ReloadedAt      time.Time `json:"reloaded_at"`
+	Changed         []string  `json:"changed"`
+	RestartRequired []string  `json:"restart_required"`
+}
+
+// Reloader re-reads the config file into the running configuration.
+// Settings read on each use, such as feature flags, take effect at once;
+// components that keep settings read at startup, such as the log level,
+// rate limits and the SMTP connection, register an OnReload hook. A file
+// that doesn't parse or validate leaves the running configuration alone.
+type Reloader struct {
+	cfg  *Config
+	path string
+
+	mu    sync.Mutex
+	hooks []func(*Config)
+}
+
+// NewReloader creates a reloader for cfg, read from the config file at
+// path
+func NewReloader(cfg *Config, path string) *Reloader {
+	return &Reloader{cfg: cfg, path: path}
+}
+
+// Path returns the config file the reloader reads
+func (r *Reloader) Path() string {
+	return r.path
+}
+
+// OnReload registers a function called with the configuration after each
+// successful reload
+func (r *Reloader) OnReload(hook func(*Config)) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.hooks = append(r.hooks, hook)
+}
+
+// Reload reads the config file again and applies it. Values set in the
+// environment or at runtime still take precedence over the file, and
+// templated paths in it are resolved as they were at startup.
+func (r *Reloader) Reload() (*ReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.path == "" {
+		return nil, ErrNoConfigFile
+	}
+	data, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoConfigFile
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", r.path, err)
+	}
+
+	// The file is read into a configuration of its own, which replaces
+	// the running one once it validates
+	before, after, err := r.cfg.rebuild(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid configuration, keeping the running one: %w", err)
+	}
+
+	result := &ReloadResult{File: r.path, ReloadedAt: time.Now(), Changed: []string{}, RestartRequired: []string{}}
+	for key := range union(before, after) {
+		if reflect.DeepEqual(before[key], after[key]) {
+			continue
+		}
+		result.Changed = append(result.Changed, key)
+		if needsRestart(key) {
+			result.RestartRequired = append(result.RestartRequired, key)
+		}
+	}
+	sort.Strings(result.Changed)
+	sort.Strings(result.RestartRequired)
+
+	for _, hook := range r.hooks {
+		hook(r.cfg)
+	}
+	return result, nil
+}
+
+// Watch reloads the configuration whenever the config file is written.
+// The file is watched through a viper of its own, since viper reads a
+// changed file into the watching instance before anyone can check it.
+func (r *Reloader) Watch() {
+	if r.path == "" {
+		return
+	}
+	watcher := viper.New()
+	watcher.SetConfigFile(r.path)
+	watcher.OnConfigChange(func(event fsnotify.Event) {
+		result, err := r.Reload()
+		if err != nil {
+			slog.Error("Config file changed but was not reloaded", "error", err)
+			return
+		}
+		LogReload(result)
+	})
+	watcher.WatchConfig()
+}
+
+// LogReload logs what a reload changed
+func LogReload(result *ReloadResult) {
+	if len(result.Changed) == 0 {
+		log.Printf("Reloaded %s, nothing changed", result.File)
+		return
+	}
+	log.Printf("Reloaded %s, changed: %s", result.File, strings.Join(result.Changed, ", "))
+	if len(result.RestartRequired) > 0 {
+		slog.Warn("Restart to apply", "settings", strings.Join(result.RestartRequired, ", "))
+	}
+}
+
+// snapshot returns every setting of v by key
+func snapshot(v *viper.Viper) map[string]interface{} {
+	settings := make(map[string]interface{})
+	for _, key := range v.AllKeys() {
+		settings[key] = v.Get(key)
+	}
+	return settings
+}
+
+func union(a, b map[string]interface{}) map[string]bool {
+	keys := make(map[string]bool, len(a))
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+	return keys
+}
+
+func needsRestart(key string) bool {
+	for _, prefix := range restartKeys {
+		if key == prefix || (strings.HasSuffix(prefix, ".") && strings.HasPrefix(key, prefix)) {
+			return true
+		}
+	}
+	return false
+}
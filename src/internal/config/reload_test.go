@@ -0,0 +1,120 @@
+package config
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// testConfig returns a configuration with the defaults and the config file
+// at path, if any, loaded the way LoadWithPaths does
+func testConfig(t *testing.T, path string) *Config {
+	load := func(file []byte) (*viper.Viper, error) {
+		v := newViper()
+		return v, v.ReadConfig(bytes.NewReader(file))
+	}
+	v := newViper()
+	if path != "" {
+		v.SetConfigFile(path)
+		require.NoError(t, v.ReadInConfig())
+	}
+	return newConfig(v, load)
+}
+
+func TestReloader(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+	write("logging:\n  level: info\nfeatures:\n  registration: false\n")
+
+	v := testConfig(t, path)
+	v.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e")
+	v.Set("database.path", "/var/lib/casgists/data.db")
+
+	reloader := NewReloader(v, path)
+	var hooked string
+	reloader.OnReload(func(v *Config) { hooked = v.GetString("logging.level") })
+
+	write("logging:\n  level: debug\nfeatures:\n  registration: true\nserver:\n  host: 127.0.0.1\n")
+	result, err := reloader.Reload()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"features.registration", "logging.level", "server.host"}, result.Changed)
+	assert.Equal(t, []string{"server.host"}, result.RestartRequired)
+	assert.Equal(t, "debug", hooked)
+	assert.True(t, v.GetBool("features.registration"))
+	assert.Equal(t, "/var/lib/casgists/data.db", v.GetString("database.path"), "runtime values still win")
+
+	// A file that fails validation leaves the running configuration alone
+	write("logging:\n  level: warn\nemail:\n  enabled: true\n")
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "debug", v.GetString("logging.level"))
+	assert.False(t, v.GetBool("email.enabled"))
+
+	// So does one that doesn't parse
+	write("logging: [")
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, "debug", v.GetString("logging.level"))
+	assert.Equal(t, "debug", hooked)
+
+	require.NoError(t, os.Remove(path))
+	_, err = reloader.Reload()
+	assert.ErrorIs(t, err, ErrNoConfigFile)
+}
+
+func TestReloadResolvesPaths(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	write := func(content string) []byte {
+		require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		return []byte(content)
+	}
+	v, err := loadFile(write("paths:\n  data: /srv/one\n"))
+	require.NoError(t, err)
+	cfg := newConfig(v, loadFile)
+	cfg.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e")
+	cfg.SetDefault("markup.theme", "dark")
+	assert.Equal(t, filepath.Clean("/srv/one/backups"), cfg.GetString("backup.path"))
+
+	write("paths:\n  data: /srv/two\n")
+	result, err := NewReloader(cfg, path).Reload()
+	require.NoError(t, err)
+	assert.Contains(t, result.Changed, "backup.path")
+	assert.Equal(t, filepath.Clean("/srv/two/backups"), cfg.GetString("backup.path"), "paths are resolved again")
+	assert.Equal(t, "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e", cfg.GetString("security.secret_key"))
+	assert.Equal(t, "dark", cfg.GetString("markup.theme"), "runtime defaults are kept")
+}
+
+func TestReloadWhileReading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("features:\n  registration: true\n"), 0600))
+	cfg := testConfig(t, path)
+	cfg.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e")
+	reloader := NewReloader(cfg, path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				cfg.GetBool("features.registration")
+				cfg.GetString("logging.level")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		_, err := reloader.Reload()
+		require.NoError(t, err)
+		cfg.Set("logging.level", "debug")
+	}
+	wg.Wait()
+	assert.True(t, cfg.GetBool("features.registration"))
+}
@@ -0,0 +1,330 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// Source is where the value of a setting comes from
+type Source string
+
+// Sources, from lowest to highest precedence
+const (
+	SourceDefault  Source = "default"
+	SourceFile     Source = "file"
+	SourceEnv      Source = "env"
+	SourceDatabase Source = "database"
+)
+
+// fileOnlyKeys are read before the database is open, so only the config
+// file and the environment can set them
+var fileOnlyKeys = []string{"database.", "paths.", "security.secret_key"}
+
+// ErrInvalidSettings is returned by SaveAll when the settings would leave
+// the configuration unusable
+var ErrInvalidSettings = errors.New("invalid settings")
+
+// Store keeps the settings changed at runtime, by the setup wizard or an
+// administrator. The system_configs table is the store.
+type Store interface {
+	Settings() (map[string]string, error)
+	SaveSetting(key, value string) error
+}
+
+// Settings layers the configuration. Each layer overrides the ones before
+// it: the defaults, the config file, CASGISTS_* environment variables and
+// the settings saved in the database. Only keys the configuration knows
+// are taken from the database; the others belong to the components that
+// stored them. Saved settings are set on the configuration as runtime
+// values, so they are kept when the config file is reloaded.
+type Settings struct {
+	cfg   *Config
+	store Store
+	file  string
+
+	saving sync.Mutex // one Load or save at a time
+
+	mu     sync.RWMutex
+	stored map[string]bool // keys set from the database
+	hooks  []func(*Config)
+}
+
+// NewSettings layers the settings in store over cfg. file is the config
+// file Save writes the settings only the file can hold to.
+func NewSettings(cfg *Config, store Store, file string) *Settings {
+	return &Settings{cfg: cfg, store: store, file: file, stored: map[string]bool{}}
+}
+
+// Load applies the settings saved in the database. A value that doesn't
+// fit its setting is logged and skipped.
+func (s *Settings) Load() error {
+	saved, err := s.store.Settings()
+	if err != nil {
+		return fmt.Errorf("failed to load saved settings: %w", err)
+	}
+
+	s.saving.Lock()
+	defer s.saving.Unlock()
+	values := make(map[string]interface{}, len(saved))
+	for key, raw := range saved {
+		if !s.known(key) || fileOnly(key) {
+			continue
+		}
+		value, err := convert(s.cfg.Get(key), raw)
+		if err != nil {
+			slog.Warn("Ignoring saved setting", "key", key, "error", err)
+			continue
+		}
+		values[key] = value
+	}
+	s.apply(values)
+	return nil
+}
+
+// OnSave registers a function called with the configuration after
+// SaveAll changes it, for components that keep settings read at startup
+func (s *Settings) OnSave(hook func(*Config)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hooks = append(s.hooks, hook)
+}
+
+// SaveAll saves several settings at once. The configuration they make is
+// validated first, so a change that leaves it unusable, such as enabling
+// email without an SMTP server, saves nothing.
+func (s *Settings) SaveAll(changes map[string]string) error {
+	if len(changes) == 0 {
+		return nil
+	}
+
+	if err := s.saveAll(changes); err != nil {
+		return err
+	}
+
+	s.mu.RLock()
+	hooks := s.hooks
+	s.mu.RUnlock()
+	for _, hook := range hooks {
+		hook(s.cfg)
+	}
+	return nil
+}
+
+func (s *Settings) saveAll(changes map[string]string) error {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	candidate := viper.New()
+	if err := candidate.MergeConfigMap(s.cfg.AllSettings()); err != nil {
+		return err
+	}
+	values := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		candidate.Set(key, value)
+		values[key] = value
+	}
+	if err := ValidateConfig(candidate); err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidSettings, err)
+	}
+	return s.save(values)
+}
+
+// Save stores a setting so that it survives a restart. Settings only the
+// config file can hold are written to it and take effect after a restart;
+// the rest are saved in the database and take effect at once.
+func (s *Settings) Save(key string, value interface{}) error {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+	return s.save(map[string]interface{}{key: value})
+}
+
+// save stores changes and sets the ones the configuration knows on it all
+// at once, so readers see either none or all of them. Every value is
+// checked before anything is stored.
+func (s *Settings) save(changes map[string]interface{}) error {
+	converted := make(map[string]interface{}, len(changes))
+	for key, value := range changes {
+		if fileOnly(key) || !s.known(key) {
+			continue
+		}
+		value, err := convert(s.cfg.Get(key), format(value))
+		if err != nil {
+			return fmt.Errorf("invalid value for %s: %w", key, err)
+		}
+		converted[key] = value
+	}
+
+	// What was stored before a failure still takes effect
+	saved := make(map[string]interface{}, len(converted))
+	defer func() { s.apply(saved) }()
+	for key, value := range changes {
+		if fileOnly(key) {
+			if err := s.saveToFile(key, value); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := s.store.SaveSetting(key, format(value)); err != nil {
+			return fmt.Errorf("failed to save %s: %w", key, err)
+		}
+		if value, ok := converted[key]; ok {
+			saved[key] = value
+		}
+	}
+	return nil
+}
+
+// apply sets settings saved in the database on the configuration
+func (s *Settings) apply(values map[string]interface{}) {
+	if len(values) == 0 {
+		return
+	}
+	s.cfg.setAll(values)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key := range values {
+		s.stored[key] = true
+	}
+}
+
+// saveToFile sets a setting in the config file, keeping the rest of it
+func (s *Settings) saveToFile(key string, value interface{}) error {
+	if s.file == "" {
+		return fmt.Errorf("%s can only be set in the config file, and there is none", key)
+	}
+	file := viper.New()
+	file.SetConfigType("yaml")
+	file.SetConfigFile(s.file)
+	// The file holds secrets, so a new one is for the server's user only
+	file.SetConfigPermissions(0600)
+	if _, err := os.Stat(s.file); err == nil {
+		if err := file.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", s.file, err)
+		}
+	}
+	file.Set(key, value)
+	if err := file.WriteConfigAs(s.file); err != nil {
+		return fmt.Errorf("failed to write %s: %w", s.file, err)
+	}
+	return nil
+}
+
+// Source reports which layer a setting's value comes from
+func (s *Settings) Source(key string) Source {
+	s.mu.RLock()
+	stored := s.stored[key]
+	s.mu.RUnlock()
+	switch {
+	case stored:
+		return SourceDatabase
+	case envSet(key):
+		return SourceEnv
+	case s.cfg.InConfig(key):
+		return SourceFile
+	default:
+		return SourceDefault
+	}
+}
+
+// Get returns a setting's value
+func (s *Settings) Get(key string) interface{} {
+	return s.cfg.Get(key)
+}
+
+// Raw returns a setting's value as the store keeps it
+func (s *Settings) Raw(key string) string {
+	return format(s.cfg.Get(key))
+}
+
+// String returns a setting as a string
+func (s *Settings) String(key string) string {
+	return s.cfg.GetString(key)
+}
+
+// Bool returns a setting as a bool
+func (s *Settings) Bool(key string) bool {
+	return s.cfg.GetBool(key)
+}
+
+// Int returns a setting as an int
+func (s *Settings) Int(key string) int {
+	return s.cfg.GetInt(key)
+}
+
+// Duration returns a setting as a duration
+func (s *Settings) Duration(key string) time.Duration {
+	return s.cfg.GetDuration(key)
+}
+
+// Strings returns a list setting
+func (s *Settings) Strings(key string) []string {
+	return s.cfg.GetStringSlice(key)
+}
+
+// known reports whether the configuration has a setting by this name
+func (s *Settings) known(key string) bool {
+	return s.cfg.Get(key) != nil
+}
+
+func fileOnly(key string) bool {
+	for _, prefix := range fileOnlyKeys {
+		if strings.HasPrefix(key, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// envSet reports whether CASGISTS_<KEY> is set for a setting
+func envSet(key string) bool {
+	_, ok := os.LookupEnv("CASGISTS_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_")))
+	return ok
+}
+
+// format turns a value into the text the store keeps. Lists are comma
+// separated.
+func format(value interface{}) string {
+	switch v := value.(type) {
+	case []string:
+		return strings.Join(v, ",")
+	case []interface{}:
+		items := make([]string, len(v))
+		for i, item := range v {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	default:
+		return fmt.Sprint(value)
+	}
+}
+
+// convert parses a stored value as the type of the setting's current value
+func convert(current interface{}, raw string) (interface{}, error) {
+	switch current.(type) {
+	case bool:
+		return strconv.ParseBool(raw)
+	case int, int32, int64:
+		return strconv.Atoi(raw)
+	case float32, float64:
+		return strconv.ParseFloat(raw, 64)
+	case []string, []interface{}:
+		if raw == "" {
+			return []string{}, nil
+		}
+		items := strings.Split(raw, ",")
+		for i := range items {
+			items[i] = strings.TrimSpace(items[i])
+		}
+		return items, nil
+	default:
+		return raw, nil
+	}
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type memoryStore map[string]string
+
+func (m memoryStore) Settings() (map[string]string, error) {
+	return m, nil
+}
+
+func (m memoryStore) SaveSetting(key, value string) error {
+	m[key] = value
+	return nil
+}
+
+func TestSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("features:\n  registration: true\n  search: false\nlogging:\n  level: warn\n"), 0600))
+	t.Setenv("CASGISTS_LOGGING_LEVEL", "error")
+
+	v := testConfig(t, path)
+	v.Set("logging.level", os.Getenv("CASGISTS_LOGGING_LEVEL"))
+
+	store := memoryStore{
+		"features.registration":         "false",
+		"security.password.min_length":  "16",
+		"security.session.idle_timeout": "2h",
+		"registration.allowed_domains":  "example.com, example.org",
+		"features.api":                  "maybe",
+		"database.type":                 "postgres",
+		"setup_completed":               "true",
+	}
+	settings := NewSettings(v, store, path)
+	require.NoError(t, settings.Load())
+
+	t.Run("Precedence", func(t *testing.T) {
+		assert.False(t, settings.Bool("features.registration"), "the database wins over the file")
+		assert.Equal(t, SourceDatabase, settings.Source("features.registration"))
+		assert.False(t, settings.Bool("features.search"))
+		assert.Equal(t, SourceFile, settings.Source("features.search"))
+		assert.Equal(t, "error", settings.String("logging.level"))
+		assert.Equal(t, SourceEnv, settings.Source("logging.level"))
+		assert.Equal(t, SourceDefault, settings.Source("features.math"))
+	})
+
+	t.Run("Types", func(t *testing.T) {
+		assert.Equal(t, 16, settings.Int("security.password.min_length"))
+		assert.Equal(t, 2*time.Hour, settings.Duration("security.session.idle_timeout"))
+		assert.Equal(t, []string{"example.com", "example.org"}, settings.Strings("registration.allowed_domains"))
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		assert.True(t, settings.Bool("features.api"), "a value that doesn't fit is skipped")
+		assert.Equal(t, "sqlite", settings.String("database.type"), "only the file sets the database")
+		assert.Equal(t, SourceDefault, settings.Source("setup_completed"))
+	})
+
+	t.Run("Save", func(t *testing.T) {
+		require.NoError(t, settings.Save("features.search", true))
+		assert.True(t, settings.Bool("features.search"))
+		assert.Equal(t, "true", store["features.search"])
+		assert.Equal(t, SourceDatabase, settings.Source("features.search"))
+
+		require.NoError(t, settings.Save("setup.completed", true))
+		assert.Equal(t, "true", store["setup.completed"])
+
+		assert.Error(t, settings.Save("security.password.min_length", "long"))
+		assert.Equal(t, "16", store["security.password.min_length"])
+	})
+
+	t.Run("SaveAll", func(t *testing.T) {
+		v.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e")
+		var hooked int
+		settings.OnSave(func(v *Config) { hooked = v.GetInt("email.smtp.port") })
+
+		// Enabling email without a server leaves the configuration unusable
+		err := settings.SaveAll(map[string]string{"email.enabled": "true", "email.smtp.port": "2525"})
+		assert.ErrorIs(t, err, ErrInvalidSettings)
+		assert.False(t, settings.Bool("email.enabled"))
+		_, stored := store["email.smtp.port"]
+		assert.False(t, stored, "nothing is saved")
+		assert.Zero(t, hooked)
+
+		require.NoError(t, settings.SaveAll(map[string]string{
+			"email.enabled":      "true",
+			"email.smtp.host":    "smtp.example.com",
+			"email.smtp.port":    "2525",
+			"email.from.address": "gists@example.com",
+		}))
+		assert.True(t, settings.Bool("email.enabled"))
+		assert.Equal(t, "2525", store["email.smtp.port"])
+		assert.Equal(t, "2525", settings.Raw("email.smtp.port"))
+		assert.Equal(t, 2525, hooked)
+	})
+
+	t.Run("SaveToFile", func(t *testing.T) {
+		require.NoError(t, settings.Save("paths.temp", "/srv/casgists/tmp"))
+		_, stored := store["paths.temp"]
+		assert.False(t, stored, "file settings stay out of the database")
+		assert.NotEqual(t, "/srv/casgists/tmp", settings.String("paths.temp"), "applied after a restart")
+
+		file := viper.New()
+		file.SetConfigFile(path)
+		require.NoError(t, file.ReadInConfig())
+		assert.Equal(t, "/srv/casgists/tmp", file.GetString("paths.temp"))
+		assert.False(t, file.GetBool("features.search"), "the rest of the file is kept")
+
+		fresh := NewSettings(v, store, filepath.Join(t.TempDir(), "config.yaml"))
+		require.NoError(t, fresh.Save("security.secret_key", "9d2b7f4e1a6c3e8b5d0f2a7c4e9b1d6f"))
+		info, err := os.Stat(fresh.file)
+		require.NoError(t, err)
+		assert.Equal(t, os.FileMode(0600), info.Mode().Perm(), "a new file holds secrets")
+	})
+}
+
+func TestSettingsWhileReading(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("features:\n  search: false\n"), 0600))
+	cfg := testConfig(t, path)
+	cfg.Set("security.secret_key", "3f9c1a7e5b2d8c4f6a0e9b1d3c5f7a2e")
+	settings := NewSettings(cfg, memoryStore{}, path)
+	reloader := NewReloader(cfg, path)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 200; j++ {
+				settings.Bool("features.search")
+				settings.Source("features.search")
+				cfg.GetInt("security.password.min_length")
+			}
+		}()
+	}
+	for i := 0; i < 20; i++ {
+		require.NoError(t, settings.SaveAll(map[string]string{
+			"features.search":              "true",
+			"security.password.min_length": fmt.Sprint(10 + i),
+		}))
+		_, err := reloader.Reload()
+		require.NoError(t, err)
+	}
+	wg.Wait()
+
+	assert.True(t, settings.Bool("features.search"), "saved settings outlive a reload")
+	assert.Equal(t, SourceDatabase, settings.Source("features.search"))
+	assert.Equal(t, 29, cfg.GetInt("security.password.min_length"))
+}
@@ -0,0 +1,362 @@
+// Package configbundle exports an instance's configuration to a portable
+// YAML bundle and imports it into another instance, e.g. to clone staging
+// into production. A bundle holds the SystemConfig rows and the feature
+// flag, email and OAuth settings; secrets in it are redacted, encrypted
+// with a passphrase, or left in plain text.
+package configbundle
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// FormatVersion is the bundle format written by Export
+const FormatVersion = 1
+
+// Secret handling modes
+const (
+	SecretsRedact  = "redact"
+	SecretsEncrypt = "encrypt"
+	SecretsPlain   = "plain"
+)
+
+// Redacted replaces secret values in redacted bundles. Import keeps the
+// target's current value for these.
+const Redacted = "<redacted>"
+
+// Sections are the configuration file sections a bundle carries
+var Sections = []string{"features", "email", "oauth", "markup"}
+
+// instanceKeys are SystemConfig rows that describe one particular instance
+// and are never exported
+var instanceKeys = map[string]bool{
+	"setup_completed":               true,
+	"server_port":                   true,
+	"server_url":                    true,
+	models.ConfigKeyReadOnlyMode:    true,
+	models.ConfigKeyReadOnlyMessage: true,
+	"telemetry_instance_id":         true,
+	"telemetry_last_sent":           true,
+}
+
+// secretMarkers identify secret keys by their last segment
+var secretMarkers = []string{"password", "secret", "token", "api_key", "private_key"}
+
+// ErrPassphraseRequired is returned when encrypting or decrypting secrets
+// without a passphrase
+var ErrPassphraseRequired = errors.New("a passphrase is required for encrypted secrets")
+
+// Bundle is an exported instance configuration
+type Bundle struct {
+	Version    int                    `yaml:"version"`
+	ExportedAt time.Time              `yaml:"exported_at"`
+	Source     string                 `yaml:"source,omitempty"`
+	Secrets    string                 `yaml:"secrets"`
+	Settings   map[string]interface{} `yaml:"settings"`
+	System     []SystemSetting        `yaml:"system_config"`
+}
+
+// SystemSetting is an exported SystemConfig row
+type SystemSetting struct {
+	Key      string `yaml:"key"`
+	Value    string `yaml:"value"`
+	Type     string `yaml:"type,omitempty"`
+	Category string `yaml:"category,omitempty"`
+}
+
+// ExportOptions controls how secrets are written
+type ExportOptions struct {
+	Secrets    string // SecretsRedact (default), SecretsEncrypt or SecretsPlain
+	Passphrase string // Required for SecretsEncrypt
+}
+
+// ImportOptions controls how a bundle is applied
+type ImportOptions struct {
+	Passphrase string // Required when the bundle has encrypted secrets
+	DryRun     bool   // Report the changes without making them
+}
+
+// ImportResult summarizes an import
+type ImportResult struct {
+	// Changed lists the SystemConfig keys and settings that differ from the
+	// target, prefixed with "system." and "settings." respectively
+	Changed []string
+	// Unchanged counts values already equal on the target
+	Unchanged int
+	// Redacted lists keys left alone because the bundle has no value
+	Redacted []string
+	// Settings are the configuration file values to write, see WriteSettings
+	Settings map[string]interface{}
+}
+
+// IsSecret reports whether a configuration key holds a secret
+func IsSecret(key string) bool {
+	last := strings.ToLower(key[strings.LastIndex(key, ".")+1:])
+	for _, marker := range secretMarkers {
+		if strings.Contains(last, marker) {
+			return true
+		}
+	}
+	return false
+}
+
+// Export captures the instance configuration
+func Export(db *gorm.DB, cfg *config.Config, opts ExportOptions) (*Bundle, error) {
+	if opts.Secrets == "" {
+		opts.Secrets = SecretsRedact
+	}
+	protect, err := secretWriter(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	bundle := &Bundle{
+		Version:    FormatVersion,
+		ExportedAt: time.Now().UTC().Truncate(time.Second),
+		Source:     cfg.GetString("server.url"),
+		Secrets:    opts.Secrets,
+		Settings:   map[string]interface{}{},
+	}
+
+	for _, key := range cfg.AllKeys() {
+		if !inSections(key) {
+			continue
+		}
+		value := cfg.Get(key)
+		if IsSecret(key) {
+			if value, err = protect(fmt.Sprint(value)); err != nil {
+				return nil, err
+			}
+		}
+		bundle.Settings[key] = value
+	}
+
+	var rows []models.SystemConfig
+	if err := db.Order("key").Find(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to read system configuration: %w", err)
+	}
+	for _, row := range rows {
+		if instanceKeys[row.Key] {
+			continue
+		}
+		value := row.Value
+		if IsSecret(row.Key) {
+			if value, err = protect(value); err != nil {
+				return nil, err
+			}
+		}
+		bundle.System = append(bundle.System, SystemSetting{
+			Key:      row.Key,
+			Value:    value,
+			Type:     row.Type,
+			Category: row.Category,
+		})
+	}
+
+	return bundle, nil
+}
+
+// secretWriter returns the function that protects secret values for mode
+func secretWriter(opts ExportOptions) (func(string) (string, error), error) {
+	switch opts.Secrets {
+	case SecretsRedact:
+		return func(value string) (string, error) {
+			if value == "" {
+				return "", nil
+			}
+			return Redacted, nil
+		}, nil
+	case SecretsEncrypt:
+		if opts.Passphrase == "" {
+			return nil, ErrPassphraseRequired
+		}
+		return func(value string) (string, error) {
+			if value == "" {
+				return "", nil
+			}
+			return encrypt(value, opts.Passphrase)
+		}, nil
+	case SecretsPlain:
+		return func(value string) (string, error) { return value, nil }, nil
+	default:
+		return nil, fmt.Errorf("unknown secrets mode %q, expected %s, %s or %s", opts.Secrets, SecretsRedact, SecretsEncrypt, SecretsPlain)
+	}
+}
+
+// Import applies a bundle's SystemConfig rows to db and returns the
+// configuration file settings to write. Redacted secrets keep the target's
+// value.
+func Import(db *gorm.DB, cfg *config.Config, bundle *Bundle, opts ImportOptions) (*ImportResult, error) {
+	if bundle.Version != FormatVersion {
+		return nil, fmt.Errorf("unsupported bundle version %d", bundle.Version)
+	}
+	reveal := func(key, value string) (string, bool, error) {
+		if value == Redacted {
+			return "", false, nil
+		}
+		if !isEncrypted(value) {
+			return value, true, nil
+		}
+		if opts.Passphrase == "" {
+			return "", false, ErrPassphraseRequired
+		}
+		plain, err := decrypt(value, opts.Passphrase)
+		if err != nil {
+			return "", false, fmt.Errorf("%s: %w", key, err)
+		}
+		return plain, true, nil
+	}
+
+	result := &ImportResult{Settings: map[string]interface{}{}}
+
+	for _, key := range sortedKeys(bundle.Settings) {
+		if !inSections(key) {
+			return nil, fmt.Errorf("setting %s is outside the %s sections", key, strings.Join(Sections, ", "))
+		}
+		value := bundle.Settings[key]
+		if s, ok := value.(string); ok {
+			revealed, present, err := reveal(key, s)
+			if err != nil {
+				return nil, err
+			}
+			if !present {
+				result.Redacted = append(result.Redacted, "settings."+key)
+				continue
+			}
+			value = revealed
+		}
+		if cfg.IsSet(key) && fmt.Sprint(cfg.Get(key)) == fmt.Sprint(value) {
+			result.Unchanged++
+			continue
+		}
+		result.Settings[key] = value
+		result.Changed = append(result.Changed, "settings."+key)
+	}
+
+	rows := make([]models.SystemConfig, 0, len(bundle.System))
+	for _, setting := range bundle.System {
+		if setting.Key == "" || instanceKeys[setting.Key] {
+			continue
+		}
+		value, present, err := reveal(setting.Key, setting.Value)
+		if err != nil {
+			return nil, err
+		}
+		if !present {
+			result.Redacted = append(result.Redacted, "system."+setting.Key)
+			continue
+		}
+
+		var existing models.SystemConfig
+		err = db.Where("key = ?", setting.Key).First(&existing).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, fmt.Errorf("failed to read %s: %w", setting.Key, err)
+		}
+		if err == nil && existing.Value == value && (setting.Type == "" || existing.Type == setting.Type) && (setting.Category == "" || existing.Category == setting.Category) {
+			result.Unchanged++
+			continue
+		}
+		existing.Key = setting.Key
+		existing.Value = value
+		if setting.Type != "" {
+			existing.Type = setting.Type
+		}
+		if setting.Category != "" {
+			existing.Category = setting.Category
+		}
+		rows = append(rows, existing)
+		result.Changed = append(result.Changed, "system."+setting.Key)
+	}
+
+	if opts.DryRun || len(rows) == 0 {
+		return result, nil
+	}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		for i := range rows {
+			if err := tx.Save(&rows[i]).Error; err != nil {
+				return fmt.Errorf("failed to save %s: %w", rows[i].Key, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+// WriteSettings merges settings into the configuration file at path,
+// creating it when missing. The file keeps its other values; comments are
+// not preserved.
+func WriteSettings(path string, settings map[string]interface{}) error {
+	if len(settings) == 0 {
+		return nil
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	mode := os.FileMode(0600)
+	if info, err := os.Stat(path); err == nil {
+		mode = info.Mode().Perm()
+		v.SetConfigFile(path)
+		if err := v.ReadInConfig(); err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+	}
+	for key, value := range settings {
+		v.Set(key, value)
+	}
+	if err := v.WriteConfigAs(path); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return os.Chmod(path, mode)
+}
+
+// Encode writes a bundle as YAML
+func Encode(w io.Writer, bundle *Bundle) error {
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(bundle); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Decode reads a YAML bundle
+func Decode(r io.Reader) (*Bundle, error) {
+	var bundle Bundle
+	if err := yaml.NewDecoder(r).Decode(&bundle); err != nil {
+		return nil, fmt.Errorf("invalid bundle: %w", err)
+	}
+	return &bundle, nil
+}
+
+func inSections(key string) bool {
+	for _, section := range Sections {
+		if strings.HasPrefix(key, section+".") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
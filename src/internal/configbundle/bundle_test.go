@@ -0,0 +1,127 @@
+package configbundle
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}))
+	return db
+}
+
+func sourceInstance(t *testing.T) (*gorm.DB, *config.Config) {
+	db := setupTestDB(t)
+	require.NoError(t, db.Create(&models.SystemConfig{Key: "max_gist_size", Value: "1024", Type: "int", Category: "limits"}).Error)
+	require.NoError(t, db.Create(&models.SystemConfig{Key: "server_url", Value: "https://staging.example.com"}).Error)
+
+	cfg := config.New()
+	cfg.Set("server.url", "https://staging.example.com")
+	cfg.Set("features.social", false)
+	cfg.Set("email.smtp.host", "smtp.example.com")
+	cfg.Set("email.smtp.password", "hunter2")
+	cfg.Set("database.password", "not exported")
+	return db, cfg
+}
+
+func TestExportRedactsSecrets(t *testing.T) {
+	db, cfg := sourceInstance(t)
+
+	bundle, err := Export(db, cfg, ExportOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, SecretsRedact, bundle.Secrets)
+	assert.Equal(t, Redacted, bundle.Settings["email.smtp.password"])
+	assert.Equal(t, "smtp.example.com", bundle.Settings["email.smtp.host"])
+	assert.NotContains(t, bundle.Settings, "database.password")
+
+	// Instance specific rows stay behind
+	require.Len(t, bundle.System, 1)
+	assert.Equal(t, "max_gist_size", bundle.System[0].Key)
+
+	_, err = Export(db, cfg, ExportOptions{Secrets: SecretsEncrypt})
+	assert.ErrorIs(t, err, ErrPassphraseRequired)
+}
+
+func TestEncryptedRoundTrip(t *testing.T) {
+	db, cfg := sourceInstance(t)
+
+	bundle, err := Export(db, cfg, ExportOptions{Secrets: SecretsEncrypt, Passphrase: "correct horse"})
+	require.NoError(t, err)
+	assert.NotContains(t, bundle.Settings["email.smtp.password"], "hunter2")
+
+	var buf bytes.Buffer
+	require.NoError(t, Encode(&buf, bundle))
+	decoded, err := Decode(&buf)
+	require.NoError(t, err)
+
+	target := setupTestDB(t)
+	require.NoError(t, target.Create(&models.SystemConfig{Key: "max_gist_size", Value: "2048", Type: "int", Category: "limits"}).Error)
+	targetCfg := config.New()
+	targetCfg.Set("email.smtp.host", "smtp.example.com")
+
+	_, err = Import(target, targetCfg, decoded, ImportOptions{})
+	assert.ErrorIs(t, err, ErrPassphraseRequired)
+	_, err = Import(target, targetCfg, decoded, ImportOptions{Passphrase: "wrong"})
+	assert.Error(t, err)
+
+	result, err := Import(target, targetCfg, decoded, ImportOptions{Passphrase: "correct horse"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"settings.email.smtp.password", "settings.features.social", "system.max_gist_size"}, result.Changed)
+	assert.Equal(t, 1, result.Unchanged)
+	assert.Equal(t, "hunter2", result.Settings["email.smtp.password"])
+	assert.Equal(t, false, result.Settings["features.social"])
+
+	value, err := models.GetConfigValue(target, "max_gist_size")
+	require.NoError(t, err)
+	assert.Equal(t, "1024", value)
+}
+
+func TestImportKeepsRedactedSecrets(t *testing.T) {
+	db, cfg := sourceInstance(t)
+	bundle, err := Export(db, cfg, ExportOptions{})
+	require.NoError(t, err)
+
+	target := setupTestDB(t)
+	result, err := Import(target, config.New(), bundle, ImportOptions{DryRun: true})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"settings.email.smtp.password"}, result.Redacted)
+	assert.NotContains(t, result.Settings, "email.smtp.password")
+
+	// A dry run leaves the database alone
+	_, err = models.GetConfigValue(target, "max_gist_size")
+	assert.Error(t, err)
+
+	bundle.Settings["database.path"] = "/tmp/evil.db"
+	_, err = Import(target, config.New(), bundle, ImportOptions{})
+	assert.Error(t, err)
+}
+
+func TestWriteSettings(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte("server:\n  port: 8080\nfeatures:\n  social: true\n"), 0640))
+
+	require.NoError(t, WriteSettings(path, map[string]interface{}{"features.social": false}))
+
+	v := viper.New()
+	v.SetConfigFile(path)
+	require.NoError(t, v.ReadInConfig())
+	assert.Equal(t, 8080, v.GetInt("server.port"))
+	assert.False(t, v.GetBool("features.social"))
+
+	info, err := os.Stat(path)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0640), info.Mode().Perm())
+}
@@ -0,0 +1,201 @@
+package contentpolicy
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Rules reported in violations
+const (
+	RuleMaxFileSize = "max_file_size"
+	RuleMaxGistSize = "max_gist_size"
+	RuleSecret      = "secret"
+	RuleBannedWord  = "banned_word"
+	RuleLicense     = "license"
+)
+
+// maxViolations bounds the violations reported for one gist
+const maxViolations = 100
+
+// File is a gist file as it will be saved
+type File struct {
+	Filename string
+	Content  string
+}
+
+// Gist is what a policy is checked against
+type Gist struct {
+	Visibility models.Visibility
+	Files      []File
+}
+
+// Violation is a rule a gist breaks. Severity error blocks the save,
+// warning doesn't.
+type Violation struct {
+	Rule     string `json:"rule"`
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"` // 1-based, 0 when about the whole file or gist
+	Message  string `json:"message"`
+}
+
+// Violations is the outcome of a check
+type Violations []Violation
+
+// Blocked reports whether any violation blocks the save
+func (v Violations) Blocked() bool {
+	for _, violation := range v {
+		if violation.Severity == models.LintSeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// Warnings returns the violations that don't block the save
+func (v Violations) Warnings() Violations {
+	var warnings Violations
+	for _, violation := range v {
+		if violation.Severity != models.LintSeverityError {
+			warnings = append(warnings, violation)
+		}
+	}
+	return warnings
+}
+
+// SecretPattern recognizes a kind of credential
+type SecretPattern struct {
+	Name   string
+	Regexp *regexp.Regexp
+}
+
+// builtinSecrets are credentials with a recognizable format
+var builtinSecrets = []SecretPattern{
+	{"AWS access key", regexp.MustCompile(`\b(?:AKIA|ASIA)[0-9A-Z]{16}\b`)},
+	{"GitHub token", regexp.MustCompile(`\b(?:gh[pousr]_[A-Za-z0-9]{36,}|github_pat_[A-Za-z0-9_]{22,})\b`)},
+	{"GitLab token", regexp.MustCompile(`\bglpat-[A-Za-z0-9_-]{20,}\b`)},
+	{"Slack token", regexp.MustCompile(`\bxox[abposr]-[A-Za-z0-9-]{10,}\b`)},
+	{"Stripe secret key", regexp.MustCompile(`\b[sr]k_live_[A-Za-z0-9]{24,}\b`)},
+	{"Google API key", regexp.MustCompile(`\bAIza[0-9A-Za-z_-]{35}\b`)},
+	{"private key", regexp.MustCompile(`-----BEGIN (?:RSA |EC |DSA |OPENSSH |PGP |ENCRYPTED )?PRIVATE KEY(?: BLOCK)?-----`)},
+}
+
+// licenseFile matches LICENSE, LICENCE, COPYING and UNLICENSE files with
+// any extension
+var licenseFile = regexp.MustCompile(`(?i)^(?:un)?(?:licen[cs]e|copying)(?:[.-].*)?$`)
+
+// evaluate checks gist against policy
+func evaluate(policy models.ContentPolicy, secrets []SecretPattern, gist Gist) Violations {
+	var violations Violations
+	add := func(action string, violation Violation) bool {
+		if len(violations) >= maxViolations {
+			return false
+		}
+		violation.Severity = models.LintSeverityWarning
+		if action == models.PolicyActionBlock {
+			violation.Severity = models.LintSeverityError
+		}
+		violations = append(violations, violation)
+		return true
+	}
+
+	var total int64
+	for _, file := range gist.Files {
+		size := int64(len(file.Content))
+		total += size
+		if policy.MaxFileSize > 0 && size > policy.MaxFileSize {
+			add(models.PolicyActionBlock, Violation{
+				Rule:    RuleMaxFileSize,
+				File:    file.Filename,
+				Message: fmt.Sprintf("file is %d bytes, the limit is %d", size, policy.MaxFileSize),
+			})
+		}
+	}
+	if policy.MaxGistSize > 0 && total > policy.MaxGistSize {
+		add(models.PolicyActionBlock, Violation{
+			Rule:    RuleMaxGistSize,
+			Message: fmt.Sprintf("gist is %d bytes, the limit is %d", total, policy.MaxGistSize),
+		})
+	}
+
+	scanSecrets := actionRank(policy.SecretScanning) > 0
+	banned := bannedWordsRegexp(policy.BannedWords)
+	if actionRank(policy.BannedWordsAction) == 0 {
+		banned = nil
+	}
+	if scanSecrets || banned != nil {
+	files:
+		for _, file := range gist.Files {
+			for i, line := range strings.Split(file.Content, "\n") {
+				if scanSecrets {
+					for _, secret := range secrets {
+						if secret.Regexp.MatchString(line) && !add(policy.SecretScanning, Violation{
+							Rule:    RuleSecret,
+							File:    file.Filename,
+							Line:    i + 1,
+							Message: "possible " + secret.Name,
+						}) {
+							break files
+						}
+					}
+				}
+				if banned != nil {
+					seen := map[string]bool{}
+					for _, match := range banned.FindAllStringSubmatch(line, -1) {
+						word := match[1]
+						key := strings.ToLower(word)
+						if seen[key] {
+							continue
+						}
+						seen[key] = true
+						if !add(policy.BannedWordsAction, Violation{
+							Rule:    RuleBannedWord,
+							File:    file.Filename,
+							Line:    i + 1,
+							Message: fmt.Sprintf("banned word %q", word),
+						}) {
+							break files
+						}
+					}
+				}
+			}
+		}
+	}
+
+	if gist.Visibility == models.VisibilityPublic && actionRank(policy.RequireLicense) > 0 && !hasLicense(gist.Files) {
+		add(policy.RequireLicense, Violation{
+			Rule:    RuleLicense,
+			Message: "public gists need a LICENSE file or an SPDX-License-Identifier line",
+		})
+	}
+
+	return violations
+}
+
+// bannedWordsRegexp matches any of words as a whole word, ignoring case.
+// The word itself is the first group.
+func bannedWordsRegexp(words []string) *regexp.Regexp {
+	if len(words) == 0 {
+		return nil
+	}
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		quoted[i] = regexp.QuoteMeta(word)
+	}
+	return regexp.MustCompile(`(?i)(?:^|\b|\W)(` + strings.Join(quoted, "|") + `)(?:$|\b|\W)`)
+}
+
+// hasLicense reports whether a gist carries a license, as a license file or
+// an SPDX identifier in any file
+func hasLicense(files []File) bool {
+	for _, file := range files {
+		if licenseFile.MatchString(path.Base(file.Filename)) || strings.Contains(file.Content, "SPDX-License-Identifier:") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,285 @@
+// Package contentpolicy checks gist content against the instance's content
+// policy before it is saved: file and gist size limits, secret scanning,
+// banned words and a license on public gists. Each rule either blocks the
+// save, only warns, or is off. Organizations can make the policy stricter
+// for their own gists.
+//
+// Checks work on filenames and content alone, so the same policy can be
+// applied wherever content arrives, through the API or a git push.
+package contentpolicy
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Content policy settings. Values saved through the admin settings API take
+// precedence over the config file.
+const (
+	ConfigKeyMaxFileSize       = "content_policy.max_file_size"       // Bytes, 0 for no limit
+	ConfigKeyMaxGistSize       = "content_policy.max_gist_size"       // Bytes across all files, 0 for no limit
+	ConfigKeySecretScanning    = "content_policy.secret_scanning"     // off, warn or block
+	ConfigKeyBannedWords       = "content_policy.banned_words"        // Comma separated
+	ConfigKeyBannedWordsAction = "content_policy.banned_words_action" // warn or block
+	ConfigKeyRequireLicense    = "content_policy.require_license"     // off, warn or block
+
+	// Extra secret patterns, config file only
+	ConfigKeySecretPatterns = "content_policy.secret_patterns"
+)
+
+// settingsTTL is how long a checker keeps the instance policy before it
+// picks up changed settings
+const settingsTTL = 30 * time.Second
+
+// ErrInvalidPolicy is returned when a policy setting has an unusable value
+var ErrInvalidPolicy = errors.New("invalid content policy")
+
+// PolicyFromConfig returns the instance policy from the config file and the
+// admin settings in overrides, keyed like the config
+func PolicyFromConfig(config *config.Config, overrides map[string]string) models.ContentPolicy {
+	policy := models.ContentPolicy{
+		MaxFileSize:       config.GetInt64(ConfigKeyMaxFileSize),
+		MaxGistSize:       config.GetInt64(ConfigKeyMaxGistSize),
+		SecretScanning:    config.GetString(ConfigKeySecretScanning),
+		BannedWords:       splitWords(strings.Join(config.GetStringSlice(ConfigKeyBannedWords), ",")),
+		BannedWordsAction: config.GetString(ConfigKeyBannedWordsAction),
+		RequireLicense:    config.GetString(ConfigKeyRequireLicense),
+	}
+	if raw, ok := overrides[ConfigKeyMaxFileSize]; ok {
+		policy.MaxFileSize, _ = parseSize(raw)
+	}
+	if raw, ok := overrides[ConfigKeyMaxGistSize]; ok {
+		policy.MaxGistSize, _ = parseSize(raw)
+	}
+	if raw, ok := overrides[ConfigKeySecretScanning]; ok {
+		policy.SecretScanning = raw
+	}
+	if raw, ok := overrides[ConfigKeyBannedWords]; ok {
+		policy.BannedWords = splitWords(raw)
+	}
+	if raw, ok := overrides[ConfigKeyBannedWordsAction]; ok {
+		policy.BannedWordsAction = raw
+	}
+	if raw, ok := overrides[ConfigKeyRequireLicense]; ok {
+		policy.RequireLicense = raw
+	}
+	if policy.BannedWordsAction == "" {
+		policy.BannedWordsAction = models.PolicyActionBlock
+	}
+	return policy
+}
+
+// SettingsDefaults returns the content policy settings from the config
+// file, for the admin settings API
+func SettingsDefaults(config *config.Config) map[string]interface{} {
+	policy := PolicyFromConfig(config, nil)
+	return map[string]interface{}{
+		ConfigKeyMaxFileSize:       policy.MaxFileSize,
+		ConfigKeyMaxGistSize:       policy.MaxGistSize,
+		ConfigKeySecretScanning:    policy.SecretScanning,
+		ConfigKeyBannedWords:       strings.Join(policy.BannedWords, ","),
+		ConfigKeyBannedWordsAction: policy.BannedWordsAction,
+		ConfigKeyRequireLicense:    policy.RequireLicense,
+	}
+}
+
+// ValidateSetting checks a value saved through the admin settings API.
+// Keys outside the content policy namespace are accepted unchanged.
+func ValidateSetting(key string, value interface{}) error {
+	raw := fmt.Sprintf("%v", value)
+	switch key {
+	case ConfigKeyMaxFileSize, ConfigKeyMaxGistSize:
+		if _, err := parseSize(raw); err != nil {
+			return fmt.Errorf("%w: %s must be a whole number of bytes, 0 for no limit", ErrInvalidPolicy, key)
+		}
+	case ConfigKeySecretScanning, ConfigKeyRequireLicense, ConfigKeyBannedWordsAction:
+		if !validAction(raw) {
+			return fmt.Errorf("%w: %s must be off, warn or block", ErrInvalidPolicy, key)
+		}
+	case ConfigKeySecretPatterns:
+		return fmt.Errorf("%w: %s can only be set in the config file", ErrInvalidPolicy, key)
+	}
+	return nil
+}
+
+// Normalize validates a policy saved by an organization and returns it
+// with banned words trimmed and deduplicated
+func Normalize(policy models.ContentPolicy) (models.ContentPolicy, error) {
+	if policy.MaxFileSize < 0 || policy.MaxGistSize < 0 {
+		return policy, fmt.Errorf("%w: size limits cannot be negative", ErrInvalidPolicy)
+	}
+	for name, action := range map[string]string{
+		"secret_scanning":     policy.SecretScanning,
+		"banned_words_action": policy.BannedWordsAction,
+		"require_license":     policy.RequireLicense,
+	} {
+		if action != "" && !validAction(action) {
+			return policy, fmt.Errorf("%w: %s must be off, warn or block", ErrInvalidPolicy, name)
+		}
+	}
+	policy.BannedWords = splitWords(strings.Join(policy.BannedWords, ","))
+	return policy, nil
+}
+
+// Merge returns the instance policy made stricter by an organization's:
+// the smaller size limit, the stricter action for each rule and the banned
+// words of both. An organization listing banned words without an action
+// blocks them.
+func Merge(instance, org models.ContentPolicy) models.ContentPolicy {
+	merged := instance
+	merged.MaxFileSize = smallerLimit(instance.MaxFileSize, org.MaxFileSize)
+	merged.MaxGistSize = smallerLimit(instance.MaxGistSize, org.MaxGistSize)
+	merged.SecretScanning = stricterAction(instance.SecretScanning, org.SecretScanning)
+	merged.RequireLicense = stricterAction(instance.RequireLicense, org.RequireLicense)
+	if len(org.BannedWords) > 0 {
+		merged.BannedWords = splitWords(strings.Join(append(append([]string{}, instance.BannedWords...), org.BannedWords...), ","))
+		orgAction := org.BannedWordsAction
+		if orgAction == "" {
+			orgAction = models.PolicyActionBlock
+		}
+		merged.BannedWordsAction = stricterAction(instance.BannedWordsAction, orgAction)
+	}
+	return merged
+}
+
+// Checker checks gists against the instance policy, merged with their
+// organization's where they have one
+type Checker struct {
+	db      *gorm.DB
+	config  *config.Config
+	secrets []SecretPattern
+
+	mu       sync.Mutex
+	policy   models.ContentPolicy
+	loadedAt time.Time
+}
+
+// NewChecker creates a checker. db may be nil, in which case only the
+// config file is used. Invalid content_policy.secret_patterns are logged
+// and left out.
+func NewChecker(db *gorm.DB, config *config.Config) *Checker {
+	secrets := append([]SecretPattern{}, builtinSecrets...)
+	var extra []struct {
+		Name    string `mapstructure:"name"`
+		Pattern string `mapstructure:"pattern"`
+	}
+	if err := config.UnmarshalKey(ConfigKeySecretPatterns, &extra); err != nil {
+		slog.Warn("Ignoring invalid secret patterns", "key", ConfigKeySecretPatterns, "error", err)
+	}
+	for i, pattern := range extra {
+		re, err := regexp.Compile(pattern.Pattern)
+		if err != nil || pattern.Name == "" {
+			slog.Warn("Ignoring secret pattern without a name and a valid regular expression", "key", ConfigKeySecretPatterns, "index", i)
+			continue
+		}
+		secrets = append(secrets, SecretPattern{Name: pattern.Name, Regexp: re})
+	}
+	return &Checker{db: db, config: config, secrets: secrets}
+}
+
+// Policy returns the instance policy currently in effect
+func (c *Checker) Policy() models.ContentPolicy {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.loadedAt.IsZero() || time.Since(c.loadedAt) > settingsTTL {
+		c.policy = PolicyFromConfig(c.config, c.overrides())
+		c.loadedAt = time.Now()
+	}
+	return c.policy
+}
+
+// Check returns the violations of the files a gist will have once saved.
+// org is the organization's policy for organization gists, nil otherwise.
+func (c *Checker) Check(gist Gist, org *models.ContentPolicy) Violations {
+	policy := c.Policy()
+	if org != nil {
+		policy = Merge(policy, *org)
+	}
+	return evaluate(policy, c.secrets, gist)
+}
+
+// overrides returns the policy settings saved through the admin settings API
+func (c *Checker) overrides() map[string]string {
+	overrides := map[string]string{}
+	if c.db == nil {
+		return overrides
+	}
+	var configs []models.SystemConfig
+	c.db.Where("key LIKE ?", "content_policy.%").Find(&configs)
+	for _, config := range configs {
+		overrides[config.Key] = config.Value
+	}
+	return overrides
+}
+
+// actionRank orders actions from least to most strict
+func actionRank(action string) int {
+	switch action {
+	case models.PolicyActionWarn:
+		return 1
+	case models.PolicyActionBlock:
+		return 2
+	}
+	return 0
+}
+
+func stricterAction(a, b string) string {
+	if actionRank(b) > actionRank(a) {
+		return b
+	}
+	return a
+}
+
+func validAction(action string) bool {
+	switch action {
+	case models.PolicyActionOff, models.PolicyActionWarn, models.PolicyActionBlock:
+		return true
+	}
+	return false
+}
+
+// smallerLimit returns the smaller of two limits where 0 means no limit
+func smallerLimit(a, b int64) int64 {
+	if a == 0 || (b > 0 && b < a) {
+		return b
+	}
+	return a
+}
+
+// parseSize parses a size saved through the admin settings API, where JSON
+// numbers arrive as floats
+func parseSize(raw string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || f < 0 || f != float64(int64(f)) {
+		return 0, ErrInvalidPolicy
+	}
+	return int64(f), nil
+}
+
+// splitWords parses a comma or newline separated word list, dropping
+// blanks and case-insensitive duplicates
+func splitWords(raw string) []string {
+	var words []string
+	seen := map[string]bool{}
+	for _, word := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == '\n' }) {
+		word = strings.TrimSpace(word)
+		key := strings.ToLower(word)
+		if word == "" || seen[key] {
+			continue
+		}
+		seen[key] = true
+		words = append(words, word)
+	}
+	return words
+}
@@ -0,0 +1,181 @@
+package counters
+
+import (
+	"context"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupDB(t *testing.T) (*gorm.DB, *models.User) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "counters.db")), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	})
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistStar{}))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	return db, user
+}
+
+func newGist(t *testing.T, db *gorm.DB, user *models.User, title string) *models.Gist {
+	gist := &models.Gist{Title: title, UserID: &user.ID, GitRepoPath: title}
+	require.NoError(t, db.Create(gist).Error)
+	return gist
+}
+
+func counts(t *testing.T, db *gorm.DB, gistID uuid.UUID) models.Gist {
+	var gist models.Gist
+	require.NoError(t, db.Unscoped().Select("view_count", "star_count", "fork_count").Take(&gist, "id = ?", gistID).Error)
+	return gist
+}
+
+func TestViews(t *testing.T) {
+	db, user := setupDB(t)
+	popular := newGist(t, db, user, "popular")
+	quiet := newGist(t, db, user, "quiet")
+
+	config := config.New()
+	config.Set("gists.counters.view_flush_interval", "1h")
+	views := NewViews(db, config)
+	require.NoError(t, db.Use(views))
+
+	// Views are buffered until the flush
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			CountView(db, popular.ID)
+		}()
+	}
+	wg.Wait()
+	CountView(db, quiet.ID)
+	assert.Equal(t, int64(51), views.Pending())
+	assert.Zero(t, counts(t, db, popular.ID).ViewCount)
+
+	require.NoError(t, views.Flush(context.Background()))
+	assert.Zero(t, views.Pending())
+	assert.Equal(t, 50, counts(t, db, popular.ID).ViewCount)
+	assert.Equal(t, 1, counts(t, db, quiet.ID).ViewCount)
+
+	// Stopping writes what is left
+	done := make(chan struct{})
+	go func() {
+		views.Start(context.Background())
+		close(done)
+	}()
+	CountView(db, quiet.ID)
+	views.Stop()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("the view counter didn't stop")
+	}
+	assert.Equal(t, 2, counts(t, db, quiet.ID).ViewCount)
+}
+
+func TestViewsFlushWhenFull(t *testing.T) {
+	db, user := setupDB(t)
+	first := newGist(t, db, user, "first")
+	second := newGist(t, db, user, "second")
+
+	config := config.New()
+	config.Set("gists.counters.view_flush_interval", "1h")
+	config.Set("gists.counters.view_flush_size", 2)
+	views := NewViews(db, config)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go views.Start(ctx)
+
+	views.Add(first.ID)
+	views.Add(second.ID)
+	assert.Eventually(t, func() bool {
+		return counts(t, db, second.ID).ViewCount == 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestCountViewWithoutBuffer(t *testing.T) {
+	db, user := setupDB(t)
+	gist := newGist(t, db, user, "gist")
+
+	CountView(db, gist.ID)
+	CountView(db, gist.ID)
+	assert.Equal(t, 2, counts(t, db, gist.ID).ViewCount)
+}
+
+func TestStarHooks(t *testing.T) {
+	db, user := setupDB(t)
+	gist := newGist(t, db, user, "gist")
+
+	star := &models.GistStar{ID: uuid.New(), GistID: gist.ID, UserID: user.ID}
+	require.NoError(t, db.Create(star).Error)
+	assert.Equal(t, 1, counts(t, db, gist.ID).StarCount)
+
+	require.NoError(t, db.Delete(star).Error)
+	assert.Zero(t, counts(t, db, gist.ID).StarCount)
+
+	// Deleting by a condition doesn't know the gist and leaves the count
+	require.NoError(t, db.Create(&models.GistStar{ID: uuid.New(), GistID: gist.ID, UserID: user.ID}).Error)
+	require.NoError(t, db.Where("user_id = ?", user.ID).Delete(&models.GistStar{}).Error)
+	assert.Equal(t, 1, counts(t, db, gist.ID).StarCount)
+}
+
+func TestReconcile(t *testing.T) {
+	db, user := setupDB(t)
+	original := newGist(t, db, user, "original")
+	right := newGist(t, db, user, "right")
+	trashed := newGist(t, db, user, "trashed")
+
+	for i := 0; i < 3; i++ {
+		fork := newGist(t, db, user, "fork"+string(rune('a'+i)))
+		require.NoError(t, db.Model(fork).Update("forked_from_id", original.ID).Error)
+		if i == 2 {
+			require.NoError(t, db.Delete(fork).Error)
+		}
+	}
+	for _, gist := range []*models.Gist{original, right, trashed} {
+		require.NoError(t, db.Create(&models.GistStar{ID: uuid.New(), GistID: gist.ID, UserID: user.ID}).Error)
+	}
+
+	// Counts that drifted, on a trashed gist too; nothing counted the forks
+	require.NoError(t, db.Model(original).UpdateColumns(map[string]interface{}{"star_count": 5, "fork_count": 0, "view_count": 7}).Error)
+	require.NoError(t, db.Model(trashed).UpdateColumn("star_count", 3).Error)
+	require.NoError(t, db.Delete(trashed).Error)
+
+	result, err := Reconcile(context.Background(), db)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Stars)
+	assert.Equal(t, 1, result.Forks)
+	assert.Equal(t, int64(6), result.Checked)
+
+	fixed := counts(t, db, original.ID)
+	assert.Equal(t, 1, fixed.StarCount)
+	assert.Equal(t, 2, fixed.ForkCount, "the deleted fork isn't counted")
+	assert.Equal(t, 7, fixed.ViewCount, "views are left alone")
+	assert.Equal(t, 1, counts(t, db, trashed.ID).StarCount)
+	assert.Equal(t, 1, counts(t, db, right.ID).StarCount)
+
+	// Nothing left to correct
+	result, err = Reconcile(context.Background(), db)
+	require.NoError(t, err)
+	assert.Zero(t, result.Stars)
+	assert.Zero(t, result.Forks)
+}
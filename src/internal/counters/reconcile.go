@@ -0,0 +1,141 @@
+package counters
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// ReconcileResult reports what a reconciliation corrected
+type ReconcileResult struct {
+	Checked int64 `json:"checked"` // Gists whose counts were compared
+	Stars   int   `json:"stars"`   // Gists whose star_count was corrected
+	Forks   int   `json:"forks"`   // Gists whose fork_count was corrected
+}
+
+// countedGist is a gist with its stored counts next to the recomputed ones
+type countedGist struct {
+	ID        uuid.UUID
+	StarCount int
+	ForkCount int
+	Stars     int
+	Forks     int
+}
+
+// Reconcile recomputes star_count from gist_stars and fork_count from the
+// forks not deleted, and corrects the gists where they drifted, for example
+// after stars were removed along with their user. Trashed gists are
+// included, so their counts are right when they are restored. Views have
+// no rows to be recomputed from and are left alone.
+//
+// A correction only applies while the stored count is still the one that
+// was compared, so a star added meanwhile isn't overwritten; the next run
+// picks up what was skipped.
+func Reconcile(ctx context.Context, db *gorm.DB) (*ReconcileResult, error) {
+	db = db.WithContext(ctx)
+	result := &ReconcileResult{}
+	if err := db.Unscoped().Model(&models.Gist{}).Count(&result.Checked).Error; err != nil {
+		return nil, err
+	}
+
+	var drifted []countedGist
+	err := db.Raw(`SELECT id, star_count, fork_count, stars, forks FROM (
+		SELECT gists.id, gists.star_count, gists.fork_count,
+			(SELECT COUNT(*) FROM gist_stars WHERE gist_stars.gist_id = gists.id) AS stars,
+			(SELECT COUNT(*) FROM gists AS forks WHERE forks.forked_from_id = gists.id AND forks.deleted_at IS NULL) AS forks
+		FROM gists
+	) AS counted WHERE star_count <> stars OR fork_count <> forks`).Scan(&drifted).Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, gist := range drifted {
+		if gist.StarCount != gist.Stars {
+			fixed, err := correct(db, gist.ID, "star_count", gist.StarCount, gist.Stars)
+			if err != nil {
+				return nil, err
+			}
+			if fixed {
+				result.Stars++
+			}
+		}
+		if gist.ForkCount != gist.Forks {
+			fixed, err := correct(db, gist.ID, "fork_count", gist.ForkCount, gist.Forks)
+			if err != nil {
+				return nil, err
+			}
+			if fixed {
+				result.Forks++
+			}
+		}
+	}
+	return result, nil
+}
+
+// correct sets the count column of the gist from stored to actual, unless
+// it no longer holds stored
+func correct(db *gorm.DB, gistID uuid.UUID, column string, stored, actual int) (bool, error) {
+	update := db.Table("gists").Where("id = ? AND "+column+" = ?", gistID, stored).
+		UpdateColumn(column, actual)
+	return update.RowsAffected > 0, update.Error
+}
+
+// Reconciler runs Reconcile every gists.counters.reconcile_interval
+type Reconciler struct {
+	db     *gorm.DB
+	config *config.Config
+	stop   chan bool
+}
+
+// NewReconciler creates a new counter reconciler
+func NewReconciler(db *gorm.DB, config *config.Config) *Reconciler {
+	return &Reconciler{
+		db:     db,
+		config: config,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Enabled reports whether counts are reconciled on a schedule; a
+// reconcile_interval of 0 turns it off
+func (r *Reconciler) Enabled() bool {
+	return r.config.GetDuration("gists.counters.reconcile_interval") > 0
+}
+
+// Start reconciles the counts every gists.counters.reconcile_interval until
+// the context is cancelled or Stop is called
+func (r *Reconciler) Start(ctx context.Context) {
+	ticker := time.NewTicker(r.config.GetDuration("gists.counters.reconcile_interval"))
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stop:
+			return
+		case <-ticker.C:
+			result, err := Reconcile(ctx, r.db)
+			if err != nil {
+				slog.Error("Counter reconciliation failed", "error", err)
+			} else if result.Stars > 0 || result.Forks > 0 {
+				log.Printf("Corrected the star count of %d and the fork count of %d gists", result.Stars, result.Forks)
+			}
+		}
+	}
+}
+
+// Stop stops reconciling
+func (r *Reconciler) Stop() {
+	select {
+	case r.stop <- true:
+	default:
+	}
+}
@@ -0,0 +1,188 @@
+// Package counters keeps the view, star and fork counts of gists: views are
+// buffered and written in batches, and a reconciliation job recomputes stars
+// and forks from the rows they count.
+package counters
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+const viewsPluginName = "casgists:views"
+
+// Views buffers gist views in memory and adds them to view_count in one
+// transaction every gists.counters.view_flush_interval, or sooner once
+// gists.counters.view_flush_size gists have views waiting. A popular gist
+// then costs one UPDATE per flush instead of one per request. Views still
+// buffered when the server crashes are lost; Stop writes them out.
+//
+// Registered on a database with db.Use, it is what CountView hands views to.
+type Views struct {
+	db      *gorm.DB
+	config  *config.Config
+	mu      sync.Mutex
+	pending map[uuid.UUID]int64
+	full    chan struct{}
+	stop    chan bool
+}
+
+// NewViews creates a new view counter
+func NewViews(db *gorm.DB, config *config.Config) *Views {
+	return &Views{
+		db:      db,
+		config:  config,
+		pending: make(map[uuid.UUID]int64),
+		full:    make(chan struct{}, 1),
+		stop:    make(chan bool, 1),
+	}
+}
+
+// Name implements gorm.Plugin
+func (v *Views) Name() string { return viewsPluginName }
+
+// Initialize implements gorm.Plugin
+func (v *Views) Initialize(db *gorm.DB) error { return nil }
+
+// CountView counts a view of the gist through the Views registered on db,
+// or straight away with an atomic increment when there are none, as in the
+// CLI and in tests
+func CountView(db *gorm.DB, gistID uuid.UUID) {
+	if views, ok := db.Config.Plugins[viewsPluginName].(*Views); ok {
+		views.Add(gistID)
+		return
+	}
+	if err := addViews(db, map[uuid.UUID]int64{gistID: 1}); err != nil {
+		slog.Error("Failed to count view of gist", "gist", gistID, "error", err)
+	}
+}
+
+// Add counts a view of the gist, written on the next flush
+func (v *Views) Add(gistID uuid.UUID) {
+	size := v.flushSize()
+	v.mu.Lock()
+	v.pending[gistID]++
+	full := len(v.pending) >= size
+	v.mu.Unlock()
+
+	if full {
+		select {
+		case v.full <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Pending returns how many views are waiting to be written
+func (v *Views) Pending() int64 {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	var total int64
+	for _, n := range v.pending {
+		total += n
+	}
+	return total
+}
+
+// Flush writes the buffered views. When the write fails they are put back,
+// to be tried again on the next flush.
+func (v *Views) Flush(ctx context.Context) error {
+	v.mu.Lock()
+	batch := v.pending
+	v.pending = make(map[uuid.UUID]int64, len(batch))
+	v.mu.Unlock()
+
+	if len(batch) == 0 {
+		return nil
+	}
+	err := v.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return addViews(tx, batch)
+	})
+	if err != nil {
+		v.mu.Lock()
+		for gistID, n := range batch {
+			v.pending[gistID] += n
+		}
+		v.mu.Unlock()
+	}
+	return err
+}
+
+// addViews adds the counts to the gists' view_count. The increment happens
+// in the database, so concurrent writers can't lose each other's views.
+func addViews(db *gorm.DB, views map[uuid.UUID]int64) error {
+	for gistID, n := range views {
+		err := db.Model(&models.Gist{}).Where("id = ?", gistID).
+			UpdateColumn("view_count", gorm.Expr("view_count + ?", n)).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Start flushes the views every gists.counters.view_flush_interval until
+// the context is cancelled or Stop is called, then writes what is left
+func (v *Views) Start(ctx context.Context) {
+	ticker := time.NewTicker(v.interval())
+	defer ticker.Stop()
+	defer v.flushRemaining()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-v.stop:
+			return
+		case <-ticker.C:
+		case <-v.full:
+		}
+		if err := v.Flush(ctx); err != nil {
+			slog.Error("Failed to write gist view counts", "error", err)
+		}
+	}
+}
+
+// Stop stops flushing after writing the buffered views
+func (v *Views) Stop() {
+	select {
+	case v.stop <- true:
+	default:
+	}
+}
+
+// flushRemaining writes the views buffered when the counter stops, even
+// though the context it ran with may be cancelled by then
+func (v *Views) flushRemaining() {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	if err := v.Flush(ctx); err != nil {
+		slog.Error("Failed to write buffered gist views", "views", v.Pending(), "error", err)
+	}
+}
+
+// interval returns the pause between flushes (default: 10 seconds)
+func (v *Views) interval() time.Duration {
+	interval := v.config.GetDuration("gists.counters.view_flush_interval")
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	return interval
+}
+
+// flushSize returns how many gists may have views waiting before they are
+// flushed early (default: 1000)
+func (v *Views) flushSize() int {
+	size := v.config.GetInt("gists.counters.view_flush_size")
+	if size <= 0 {
+		size = 1000
+	}
+	return size
+}
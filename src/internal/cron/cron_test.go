@@ -0,0 +1,104 @@
+package cron
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2026, 3, 11, 10, 17, 30, 0, time.UTC)
+
+	tests := []struct {
+		expr string
+		want time.Time
+	}{
+		{"* * * * *", time.Date(2026, 3, 11, 10, 18, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, 3, 11, 10, 30, 0, 0, time.UTC)},
+		{"0 2 * * *", time.Date(2026, 3, 12, 2, 0, 0, 0, time.UTC)},
+		{"30 10-12 * * *", time.Date(2026, 3, 11, 10, 30, 0, 0, time.UTC)},
+		{"0 9 * * mon-fri", time.Date(2026, 3, 12, 9, 0, 0, 0, time.UTC)},
+		{"0 0 * * 0", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 * * 7", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 * *", time.Date(2026, 4, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 1 jan *", time.Date(2027, 1, 1, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// Either day field matches when both are restricted
+		{"0 0 13 * 5", time.Date(2026, 3, 13, 0, 0, 0, 0, time.UTC)},
+		{"0 0 20 * sat", time.Date(2026, 3, 14, 0, 0, 0, 0, time.UTC)},
+		{"5/20 * * * *", time.Date(2026, 3, 11, 10, 25, 0, 0, time.UTC)},
+		{"0,45 10 * * *", time.Date(2026, 3, 11, 10, 45, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2026, 3, 11, 11, 0, 0, 0, time.UTC)},
+		{"@weekly", time.Date(2026, 3, 15, 0, 0, 0, 0, time.UTC)},
+		{"0 0 30 2 *", time.Time{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expr, func(t *testing.T) {
+			schedule, err := Parse(tt.expr)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, schedule.Next(from))
+		})
+	}
+}
+
+func TestNextInLocation(t *testing.T) {
+	loc := time.FixedZone("UTC+2", 2*60*60)
+	schedule, err := Parse("0 2 * * *")
+	require.NoError(t, err)
+	next := schedule.Next(time.Date(2026, 3, 11, 10, 0, 0, 0, loc))
+	assert.Equal(t, time.Date(2026, 3, 12, 2, 0, 0, 0, loc), next)
+	assert.Equal(t, loc, next.Location())
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, expr := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"*/0 * * * *",
+		"5-1 * * * *",
+		"* * * foo *",
+		"@sometimes",
+	} {
+		_, err := Parse(expr)
+		assert.ErrorIs(t, err, ErrInvalidSchedule, expr)
+	}
+}
+
+func TestParseAt(t *testing.T) {
+	from := time.Date(2026, 3, 11, 10, 17, 0, 0, time.UTC)
+
+	schedule, err := ParseAt("weekly", "02:30")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 15, 2, 30, 0, 0, time.UTC), schedule.Next(from))
+	assert.Equal(t, "weekly at 02:30", schedule.String())
+
+	schedule, err = ParseAt("Daily", "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 12, 0, 0, 0, 0, time.UTC), schedule.Next(from))
+
+	schedule, err = ParseAt("hourly", "04:45")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 11, 10, 45, 0, 0, time.UTC), schedule.Next(from))
+
+	schedule, err = ParseAt("monthly", "23:00")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 4, 1, 23, 0, 0, 0, time.UTC), schedule.Next(from))
+
+	// Cron expressions ignore the time of day
+	schedule, err = ParseAt("0 3 * * *", "02:30")
+	require.NoError(t, err)
+	assert.Equal(t, time.Date(2026, 3, 12, 3, 0, 0, 0, time.UTC), schedule.Next(from))
+	assert.Equal(t, "0 3 * * *", schedule.String())
+
+	_, err = ParseAt("daily", "2am")
+	assert.ErrorIs(t, err, ErrInvalidSchedule)
+}
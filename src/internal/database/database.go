@@ -2,10 +2,11 @@ package database
 
 import (
 	"fmt"
+	"strings"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
-	"github.com/spf13/viper"
 	"gorm.io/driver/mysql"
 	"gorm.io/driver/postgres"
 	"github.com/glebarez/sqlite"
@@ -14,7 +15,7 @@ import (
 )
 
 // Initialize initializes the database connection
-func Initialize(cfg *viper.Viper) (*gorm.DB, error) {
+func Initialize(cfg *config.Config) (*gorm.DB, error) {
 	var dialector gorm.Dialector
 	
 	// Configure database based on type
@@ -26,6 +27,15 @@ func Initialize(cfg *viper.Viper) (*gorm.DB, error) {
 	case "mysql":
 		dialector = mysql.Open(dbDSN)
 	case "sqlite", "":
+		// SQLite is usually configured by file path alone
+		if dbDSN == "" {
+			dbDSN = cfg.GetString("database.path")
+		}
+		if cfg.GetBool("replication.enabled") {
+			// WAL shipping needs WAL mode and takes over checkpointing, and
+			// writers wait for the replicator's short write lock
+			dbDSN = withSQLitePragmas(dbDSN, "journal_mode(WAL)", "wal_autocheckpoint(0)", "busy_timeout(5000)")
+		}
 		dialector = sqlite.Open(dbDSN)
 	default:
 		return nil, fmt.Errorf("unsupported database type: %s", dbType)
@@ -39,7 +49,7 @@ func Initialize(cfg *viper.Viper) (*gorm.DB, error) {
 
 	// Open database connection
 	db, err := gorm.Open(dialector, &gorm.Config{
-		Logger: logger.Default.LogMode(logLevel),
+		Logger: newSlowQueryLogger(logger.Default, logLevel, cfg.GetDuration("database.slow_query_threshold")),
 		NowFunc: func() time.Time {
 			return time.Now().UTC()
 		},
@@ -49,28 +59,71 @@ func Initialize(cfg *viper.Viper) (*gorm.DB, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to connect to database: %w", err)
 	}
+	if !cfg.GetBool("api.legacy_timestamps") {
+		if err := RegisterUTCTimestamps(db); err != nil {
+			return nil, fmt.Errorf("failed to register timestamp callback: %w", err)
+		}
+	}
 	
-	// Configure connection pool
+	if err := configurePool(db, cfg); err != nil {
+		return nil, err
+	}
+	if err := useReplicas(db, cfg, dbType); err != nil {
+		return nil, err
+	}
+	
+	return db, nil
+}
+
+// configurePool applies the database.* connection pool settings and checks
+// the connection
+func configurePool(db *gorm.DB, cfg *config.Config) error {
 	sqlDB, err := db.DB()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get database instance: %w", err)
+		return fmt.Errorf("failed to get database instance: %w", err)
 	}
-	
-	// Set connection pool settings
-	maxConns := cfg.GetInt("database.max_connections")
-	if maxConns <= 0 {
-		maxConns = 25 // default
-	}
-	sqlDB.SetMaxOpenConns(maxConns)
-	sqlDB.SetMaxIdleConns(maxConns / 2)
-	sqlDB.SetConnMaxIdleTime(time.Duration(cfg.GetInt("database.max_idle_time")) * time.Second)
-	
-	// Test connection
+
+	maxOpen, maxIdle := poolSize(cfg)
+	sqlDB.SetMaxOpenConns(maxOpen)
+	sqlDB.SetMaxIdleConns(maxIdle)
+	sqlDB.SetConnMaxLifetime(cfg.GetDuration("database.conn_max_lifetime"))
+	sqlDB.SetConnMaxIdleTime(cfg.GetDuration("database.conn_max_idle_time"))
+
 	if err := sqlDB.Ping(); err != nil {
-		return nil, fmt.Errorf("failed to ping database: %w", err)
+		return fmt.Errorf("failed to ping database: %w", err)
 	}
-	
-	return db, nil
+	return nil
+}
+
+// poolSize returns the database.max_open_conns and database.max_idle_conns
+// settings. database.max_connections is the older name of
+// database.max_open_conns.
+func poolSize(cfg *config.Config) (maxOpen, maxIdle int) {
+	maxOpen = cfg.GetInt("database.max_open_conns")
+	if cfg.IsSet("database.max_connections") {
+		maxOpen = cfg.GetInt("database.max_connections")
+	}
+	if maxOpen <= 0 {
+		maxOpen = 25
+	}
+	maxIdle = cfg.GetInt("database.max_idle_conns")
+	if maxIdle <= 0 {
+		maxIdle = maxOpen / 2
+	}
+	return maxOpen, maxIdle
+}
+
+// withSQLitePragmas adds pragmas to a SQLite DSN, which may be a plain path
+// or a file: URI with parameters of its own
+func withSQLitePragmas(dsn string, pragmas ...string) string {
+	for _, pragma := range pragmas {
+		separator := "?"
+		if strings.Contains(dsn, "?") {
+			separator = "&"
+		}
+		dsn += separator + "_pragma=" + pragma
+	}
+	return dsn
 }
 
 // MigrateDB runs all database migrations
@@ -79,6 +132,19 @@ func MigrateDB(db *gorm.DB) error {
 	if err := FastMigrations(db); err != nil {
 		return fmt.Errorf("failed to run migrations: %w", err)
 	}
+
+	// Compute stats of files stored before they were tracked
+	if err := models.BackfillGistStats(db); err != nil {
+		return fmt.Errorf("failed to backfill gist stats: %w", err)
+	}
+	if err := models.BackfillFileSummaries(db); err != nil {
+		return fmt.Errorf("failed to backfill gist file summaries: %w", err)
+	}
+
+	// Let deleted users and organizations give up their names
+	if err := migrateSoftDeleteUniqueIndexes(db); err != nil {
+		return fmt.Errorf("failed to migrate unique indexes: %w", err)
+	}
 	
 	// Initialize default data
 	if err := InitializeDefaultData(db); err != nil {
@@ -94,6 +160,11 @@ func MigrateTestDB(db *gorm.DB) error {
 	if err := FastMigrationsSkipFTS(db); err != nil {
 		return fmt.Errorf("failed to run test migrations: %w", err)
 	}
+
+	// Let deleted users and organizations give up their names
+	if err := migrateSoftDeleteUniqueIndexes(db); err != nil {
+		return fmt.Errorf("failed to migrate unique indexes: %w", err)
+	}
 	
 	// Initialize default data
 	if err := InitializeDefaultData(db); err != nil {
@@ -2,7 +2,6 @@ package database
 
 import (
 	"fmt"
-	"io/fs"
 	"log/slog"
 	"path/filepath"
 	"sort"
@@ -11,72 +10,17 @@ import (
 	"gorm.io/gorm"
 )
 
-// FastMigrations runs migrations without complex parsing
+// FastMigrations applies the pending migrations for the database's dialect
 func FastMigrations(db *gorm.DB) error {
 	logger := slog.Default()
-	dbType := db.Dialector.Name()
-	
-	logger.Info("Running fast migrations", "database", dbType)
-	
-	// Create migrations table
-	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`).Error; err != nil {
-		return fmt.Errorf("failed to create migrations table: %w", err)
-	}
-	
-	// Read migration files
-	entries, err := migrationsFS.ReadDir("migrations")
+	logger.Info("Running fast migrations", "database", db.Dialector.Name())
+
+	applied, err := MigrateUp(db, 0)
 	if err != nil {
-		return fmt.Errorf("failed to read migrations: %w", err)
-	}
-	
-	// Filter and sort .up.sql files
-	var migrations []string
-	for _, entry := range entries {
-		if strings.HasSuffix(entry.Name(), ".up.sql") {
-			migrations = append(migrations, entry.Name())
-		}
+		return err
 	}
-	sort.Strings(migrations)
-	
-	// Run each migration
-	for _, filename := range migrations {
-		// Extract version
-		var version int
-		fmt.Sscanf(filename, "%06d_", &version)
-		
-		// Check if already applied
-		var count int64
-		db.Raw("SELECT COUNT(*) FROM schema_migrations WHERE version = ?", version).Scan(&count)
-		if count > 0 {
-			logger.Info("Migration already applied", "version", version)
-			continue
-		}
-		
-		// Skip SQLite-specific migrations for other databases
-		if strings.Contains(filename, "sqlite") && dbType != "sqlite" {
-			logger.Info("Skipping SQLite-specific migration", "version", version)
-			continue
-		}
-		
-		// Read content
-		content, err := fs.ReadFile(migrationsFS, filepath.Join("migrations", filename))
-		if err != nil {
-			return fmt.Errorf("failed to read %s: %w", filename, err)
-		}
-		
-		logger.Info("Applying migration", "version", version, "filename", filename)
 
-		// Execute statements one by one for all databases to avoid issues with multiple commands
-		if err := executeStatements(db, string(content)); err != nil {
-			return fmt.Errorf("migration %d failed: %w", version, err)
-		}
-		
-		// Record success
-		db.Exec("INSERT INTO schema_migrations (version) VALUES (?)", version)
-		logger.Info("Migration completed", "version", version)
-	}
-	
-	logger.Info("All migrations completed")
+	logger.Info("All migrations completed", "applied", len(applied))
 	return nil
 }
 
@@ -0,0 +1,16 @@
+-- Device authorization grants for CLI login (RFC 8628)
+CREATE TABLE IF NOT EXISTS device_authorizations (
+    id VARCHAR(36) PRIMARY KEY,
+    device_code_hash VARCHAR(64) NOT NULL UNIQUE,
+    user_code VARCHAR(16) NOT NULL UNIQUE,
+    client_name VARCHAR(100),
+    user_id VARCHAR(36),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    ip_address VARCHAR(45),
+    expires_at TIMESTAMP NOT NULL,
+    last_polled_at TIMESTAMP,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_device_authorizations_expires_at ON device_authorizations(expires_at);
@@ -0,0 +1,2 @@
+ALTER TABLE users DROP COLUMN keep_gists_public;
+ALTER TABLE users DROP COLUMN deactivated_at;
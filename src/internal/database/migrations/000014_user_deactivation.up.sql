@@ -0,0 +1,3 @@
+-- Self-service account deactivation
+ALTER TABLE users ADD COLUMN deactivated_at TIMESTAMP;
+ALTER TABLE users ADD COLUMN keep_gists_public BOOLEAN DEFAULT FALSE;
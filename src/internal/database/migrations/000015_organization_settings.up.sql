@@ -0,0 +1,12 @@
+-- Organization defaults and member policies
+CREATE TABLE IF NOT EXISTS organization_settings (
+    organization_id VARCHAR(36) PRIMARY KEY,
+    default_gist_visibility VARCHAR(20) NOT NULL DEFAULT 'private',
+    require_two_factor BOOLEAN DEFAULT FALSE,
+    member_gist_creation VARCHAR(20) NOT NULL DEFAULT 'members',
+    allowed_webhook_domains TEXT,
+    gist_name_pattern VARCHAR(255),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+);
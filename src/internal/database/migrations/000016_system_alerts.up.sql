@@ -0,0 +1,18 @@
+-- Alerts raised by the built-in alerting engine
+CREATE TABLE IF NOT EXISTS system_alerts (
+    id VARCHAR(36) PRIMARY KEY,
+    rule VARCHAR(50) NOT NULL,
+    severity VARCHAR(20) NOT NULL DEFAULT 'warning',
+    status VARCHAR(20) NOT NULL DEFAULT 'firing',
+    message TEXT,
+    value DOUBLE PRECISION NOT NULL DEFAULT 0,
+    threshold DOUBLE PRECISION NOT NULL DEFAULT 0,
+    fired_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    resolved_at TIMESTAMP NULL,
+    notified_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_system_alerts_rule_status ON system_alerts(rule, status);
+CREATE INDEX IF NOT EXISTS idx_system_alerts_fired_at ON system_alerts(fired_at);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_webhooks_gist_id;
+ALTER TABLE webhooks DROP COLUMN gist_id;
@@ -0,0 +1,4 @@
+-- Webhooks attached to a single gist
+ALTER TABLE webhooks ADD COLUMN gist_id VARCHAR(36);
+
+CREATE INDEX IF NOT EXISTS idx_webhooks_gist_id ON webhooks(gist_id);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS gdpr_deletion_requests;
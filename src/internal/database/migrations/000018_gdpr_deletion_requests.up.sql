@@ -0,0 +1,18 @@
+-- GDPR account deletion requests, with the owner's choice of what happens
+-- to their public gists
+CREATE TABLE IF NOT EXISTS gdpr_deletion_requests (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    deletion_reason TEXT,
+    gist_policy VARCHAR(20),
+    gist_organization_id VARCHAR(36),
+    processed_by_id VARCHAR(36),
+    processed_at TIMESTAMP NULL,
+    completed_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_gdpr_deletion_requests_user_id ON gdpr_deletion_requests(user_id);
+CREATE INDEX IF NOT EXISTS idx_gdpr_deletion_requests_status ON gdpr_deletion_requests(status);
@@ -0,0 +1,2 @@
+ALTER TABLE audit_logs DROP COLUMN error_message;
+ALTER TABLE audit_logs DROP COLUMN success;
@@ -0,0 +1,3 @@
+-- Outcome of audited actions, matching the audit log model
+ALTER TABLE audit_logs ADD COLUMN success BOOLEAN DEFAULT TRUE;
+ALTER TABLE audit_logs ADD COLUMN error_message VARCHAR(500);
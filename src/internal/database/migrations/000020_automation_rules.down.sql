@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS automation_runs;
+DROP TABLE IF EXISTS automation_rules;
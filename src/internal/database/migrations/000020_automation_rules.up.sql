@@ -0,0 +1,41 @@
+-- Tag-based automation rules, owned by a user or an organization
+CREATE TABLE IF NOT EXISTS automation_rules (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36),
+    organization_id VARCHAR(36),
+    name VARCHAR(100) NOT NULL,
+    tag VARCHAR(50) NOT NULL,
+    action VARCHAR(50) NOT NULL,
+    params TEXT,
+    enabled BOOLEAN NOT NULL DEFAULT TRUE,
+    run_count INTEGER NOT NULL DEFAULT 0,
+    last_run_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    CHECK ((user_id IS NOT NULL AND organization_id IS NULL) OR (user_id IS NULL AND organization_id IS NOT NULL))
+);
+
+CREATE INDEX IF NOT EXISTS idx_automation_rules_user_tag ON automation_rules(user_id, tag);
+CREATE INDEX IF NOT EXISTS idx_automation_rules_org_tag ON automation_rules(organization_id, tag);
+
+-- One queued or finished execution of a rule against a gist
+CREATE TABLE IF NOT EXISTS automation_runs (
+    id VARCHAR(36) PRIMARY KEY,
+    rule_id VARCHAR(36) NOT NULL,
+    gist_id VARCHAR(36) NOT NULL,
+    actor_id VARCHAR(36),
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    run_after TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    finished_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (rule_id) REFERENCES automation_rules(id) ON DELETE CASCADE,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_automation_runs_status_run_after ON automation_runs(status, run_after);
+CREATE INDEX IF NOT EXISTS idx_automation_runs_rule_id ON automation_runs(rule_id);
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS newsletter_deliveries;
+DROP TABLE IF EXISTS newsletters;
+ALTER TABLE user_preferences DROP COLUMN newsletter;
@@ -0,0 +1,42 @@
+-- Newsletter opt-in; users have to subscribe explicitly
+ALTER TABLE user_preferences ADD COLUMN newsletter BOOLEAN NOT NULL DEFAULT FALSE;
+
+-- Announcements broadcast by administrators to subscribed users
+CREATE TABLE IF NOT EXISTS newsletters (
+    id VARCHAR(36) PRIMARY KEY,
+    subject VARCHAR(200) NOT NULL,
+    body TEXT NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'draft',
+    recurrence VARCHAR(20) NOT NULL DEFAULT '',
+    scheduled_at TIMESTAMP NULL,
+    started_at TIMESTAMP NULL,
+    completed_at TIMESTAMP NULL,
+    recipients INTEGER NOT NULL DEFAULT 0,
+    sent_count INTEGER NOT NULL DEFAULT 0,
+    failed_count INTEGER NOT NULL DEFAULT 0,
+    created_by VARCHAR(36),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_newsletters_status_scheduled_at ON newsletters(status, scheduled_at);
+
+-- One recipient of a newsletter send
+CREATE TABLE IF NOT EXISTS newsletter_deliveries (
+    id VARCHAR(36) PRIMARY KEY,
+    newsletter_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    email VARCHAR(255) NOT NULL,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    error TEXT,
+    sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (newsletter_id) REFERENCES newsletters(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    UNIQUE (newsletter_id, user_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_newsletter_deliveries_status ON newsletter_deliveries(newsletter_id, status);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS gist_redirects;
+DROP INDEX IF EXISTS idx_gists_org_slug;
+ALTER TABLE gists DROP COLUMN slug;
+ALTER TABLE organizations DROP COLUMN is_public;
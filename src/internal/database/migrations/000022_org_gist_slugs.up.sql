@@ -0,0 +1,19 @@
+-- Organization gists are reachable at /o/:org/:slug
+ALTER TABLE gists ADD COLUMN slug VARCHAR(100);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gists_org_slug ON gists(organization_id, slug);
+
+-- Only members can browse the /o/:org pages of private organizations
+ALTER TABLE organizations ADD COLUMN is_public BOOLEAN DEFAULT TRUE;
+
+-- Old /o/:org/:slug addresses of gists that were renamed or moved away
+CREATE TABLE IF NOT EXISTS gist_redirects (
+    id VARCHAR(36) PRIMARY KEY,
+    organization_id VARCHAR(36) NOT NULL,
+    slug VARCHAR(100) NOT NULL,
+    gist_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    UNIQUE(organization_id, slug),
+    FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE
+);
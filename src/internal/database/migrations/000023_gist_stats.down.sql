@@ -0,0 +1,7 @@
+ALTER TABLE gists DROP COLUMN read_time_seconds;
+ALTER TABLE gists DROP COLUMN word_count;
+ALTER TABLE gists DROP COLUMN total_size;
+
+ALTER TABLE gist_files DROP COLUMN complexity;
+ALTER TABLE gist_files DROP COLUMN read_time_seconds;
+ALTER TABLE gist_files DROP COLUMN word_count;
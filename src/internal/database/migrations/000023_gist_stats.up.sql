@@ -0,0 +1,10 @@
+-- File stats computed when a file is saved, and their totals per gist.
+-- read_time_seconds stays NULL for existing files until they are backfilled
+-- at startup.
+ALTER TABLE gist_files ADD COLUMN word_count INTEGER DEFAULT 0;
+ALTER TABLE gist_files ADD COLUMN read_time_seconds INTEGER;
+ALTER TABLE gist_files ADD COLUMN complexity INTEGER DEFAULT 0;
+
+ALTER TABLE gists ADD COLUMN total_size BIGINT DEFAULT 0;
+ALTER TABLE gists ADD COLUMN word_count INTEGER DEFAULT 0;
+ALTER TABLE gists ADD COLUMN read_time_seconds INTEGER DEFAULT 0;
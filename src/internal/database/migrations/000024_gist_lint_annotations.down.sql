@@ -0,0 +1 @@
+ALTER TABLE gist_files DROP COLUMN lint_annotations;
@@ -0,0 +1,3 @@
+-- Lint annotations attached to a file by the configured linters and
+-- formatters when it was last saved, as a JSON array
+ALTER TABLE gist_files ADD COLUMN lint_annotations TEXT;
@@ -0,0 +1,3 @@
+-- Quota tier assigned to a user by an admin. Empty means the tier follows
+-- the user's role and account age.
+ALTER TABLE users ADD COLUMN quota_tier VARCHAR(32) DEFAULT '';
@@ -0,0 +1,2 @@
+-- Organization additions to the instance content policy, stored as JSON
+ALTER TABLE organization_settings ADD COLUMN content_policy TEXT;
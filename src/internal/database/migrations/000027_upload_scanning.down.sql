@@ -0,0 +1,4 @@
+DROP INDEX IF EXISTS idx_quarantined_files_user_id;
+DROP TABLE IF EXISTS quarantined_files;
+ALTER TABLE gist_files DROP COLUMN scanned_at;
+ALTER TABLE gist_files DROP COLUMN scan_status;
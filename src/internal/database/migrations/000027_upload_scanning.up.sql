@@ -0,0 +1,22 @@
+-- Malware scan result of each gist file, set on save when upload scanning
+-- is enabled
+ALTER TABLE gist_files ADD COLUMN scan_status VARCHAR(20);
+ALTER TABLE gist_files ADD COLUMN scanned_at TIMESTAMP;
+
+-- Uploads the scanner flagged, kept out of gists for admins to review
+CREATE TABLE IF NOT EXISTS quarantined_files (
+    id VARCHAR(36) PRIMARY KEY,
+    filename VARCHAR(255) NOT NULL,
+    source VARCHAR(30) NOT NULL,
+    user_id VARCHAR(36),
+    gist_id VARCHAR(36),
+    scanner VARCHAR(20),
+    signature VARCHAR(255),
+    size BIGINT DEFAULT 0,
+    sha256 VARCHAR(64),
+    stored_path VARCHAR(500),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_quarantined_files_user_id ON quarantined_files(user_id);
@@ -0,0 +1,3 @@
+-- Webhooks in digest mode batch events over this many seconds into one
+-- delivery; 0 delivers each event on its own
+ALTER TABLE webhooks ADD COLUMN digest_window INTEGER NOT NULL DEFAULT 0;
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS idx_gists_ephemeral;
+ALTER TABLE gists DROP COLUMN ephemeral;
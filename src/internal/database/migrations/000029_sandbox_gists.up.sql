@@ -0,0 +1,4 @@
+-- Gists created by API sandbox requests, purged after api.sandbox.ttl
+ALTER TABLE gists ADD COLUMN ephemeral BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_gists_ephemeral ON gists(ephemeral);
@@ -0,0 +1,3 @@
+ALTER TABLE gists DROP COLUMN forks_disabled;
+ALTER TABLE gists DROP COLUMN indexing_disabled;
+ALTER TABLE gists DROP COLUMN embed_disabled;
@@ -0,0 +1,4 @@
+-- Per-gist owner permissions; everything stays allowed for existing gists
+ALTER TABLE gists ADD COLUMN embed_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE gists ADD COLUMN indexing_disabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE gists ADD COLUMN forks_disabled BOOLEAN NOT NULL DEFAULT FALSE;
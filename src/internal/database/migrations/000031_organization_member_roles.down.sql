@@ -0,0 +1,2 @@
+ALTER TABLE organization_members DROP COLUMN updated_at;
+ALTER TABLE organization_members DROP COLUMN joined_at;
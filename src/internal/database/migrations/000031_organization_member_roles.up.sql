@@ -0,0 +1,6 @@
+-- Timestamps the membership models write; roles now include viewer, which
+-- fits the existing role column
+ALTER TABLE organization_members ADD COLUMN joined_at TIMESTAMP;
+ALTER TABLE organization_members ADD COLUMN updated_at TIMESTAMP;
+
+UPDATE organization_members SET joined_at = created_at, updated_at = created_at;
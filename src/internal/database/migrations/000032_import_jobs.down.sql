@@ -0,0 +1,16 @@
+DROP TABLE IF EXISTS import_items;
+DROP TABLE IF EXISTS import_jobs;
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    source VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    total_items INTEGER DEFAULT 0,
+    imported_items INTEGER DEFAULT 0,
+    failed_items INTEGER DEFAULT 0,
+    error TEXT,
+    started_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    completed_at TIMESTAMP NULL,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
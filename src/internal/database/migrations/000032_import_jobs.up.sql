@@ -0,0 +1,42 @@
+-- Import jobs record each run of an import from another platform, with a
+-- row in import_items for every gist read from the source. The table from
+-- the initial schema was never written to, so it is replaced.
+DROP TABLE IF EXISTS import_jobs;
+
+CREATE TABLE IF NOT EXISTS import_jobs (
+    id VARCHAR(36) PRIMARY KEY,
+    platform VARCHAR(50) NOT NULL,
+    status VARCHAR(20) NOT NULL,
+    source_url VARCHAR(500),
+    source_username VARCHAR(255),
+    items_total INTEGER DEFAULT 0,
+    items_imported INTEGER DEFAULT 0,
+    error_count INTEGER DEFAULT 0,
+    settings TEXT,
+    result TEXT,
+    started_at TIMESTAMP NOT NULL,
+    completed_at TIMESTAMP NULL,
+    created_by VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_jobs_created_by ON import_jobs(created_by);
+
+CREATE TABLE IF NOT EXISTS import_items (
+    id VARCHAR(36) PRIMARY KEY,
+    import_job_id VARCHAR(36) NOT NULL,
+    source_url VARCHAR(500),
+    source_id VARCHAR(255),
+    gist_id VARCHAR(36),
+    status VARCHAR(20) DEFAULT 'pending',
+    error_message TEXT,
+    source_data TEXT,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    processed_at TIMESTAMP NULL,
+    FOREIGN KEY (import_job_id) REFERENCES import_jobs(id) ON DELETE CASCADE,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_import_items_import_job_id ON import_items(import_job_id);
@@ -0,0 +1,4 @@
+DROP TABLE IF EXISTS gist_comment_reactions;
+DROP INDEX IF EXISTS idx_gist_comments_parent_comment_id;
+ALTER TABLE gist_comments DROP COLUMN edited_at;
+ALTER TABLE gist_comments DROP COLUMN parent_comment_id;
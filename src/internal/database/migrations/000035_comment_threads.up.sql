@@ -0,0 +1,19 @@
+-- Comment replies point at a top-level comment; edited_at is set when the
+-- author changes the content
+ALTER TABLE gist_comments ADD COLUMN parent_comment_id VARCHAR(36) NULL;
+ALTER TABLE gist_comments ADD COLUMN edited_at TIMESTAMP NULL;
+
+CREATE INDEX IF NOT EXISTS idx_gist_comments_parent_comment_id ON gist_comments(parent_comment_id);
+
+-- Emoji reactions to comments, each given once per user and comment
+CREATE TABLE IF NOT EXISTS gist_comment_reactions (
+    id VARCHAR(36) PRIMARY KEY,
+    comment_id VARCHAR(36) NOT NULL,
+    user_id VARCHAR(36) NOT NULL,
+    reaction VARCHAR(20) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (comment_id) REFERENCES gist_comments(id) ON DELETE CASCADE,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_comment_reaction ON gist_comment_reactions(comment_id, user_id, reaction);
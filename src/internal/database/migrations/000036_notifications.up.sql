@@ -0,0 +1,30 @@
+-- In-app notification center entries
+CREATE TABLE IF NOT EXISTS notifications (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    actor_id VARCHAR(36),
+    type VARCHAR(30) NOT NULL,
+    message VARCHAR(500) NOT NULL,
+    url VARCHAR(500),
+    gist_id VARCHAR(36),
+    read_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (actor_id) REFERENCES users(id) ON DELETE SET NULL
+);
+
+CREATE INDEX IF NOT EXISTS idx_notifications_user_read ON notifications(user_id, read_at);
+CREATE INDEX IF NOT EXISTS idx_notifications_gist_id ON notifications(gist_id);
+CREATE INDEX IF NOT EXISTS idx_notifications_created_at ON notifications(created_at);
+
+-- Which notifications a user gets; users without a row get all of them
+CREATE TABLE IF NOT EXISTS notification_preferences (
+    user_id VARCHAR(36) PRIMARY KEY,
+    gist_starred BOOLEAN NOT NULL DEFAULT TRUE,
+    gist_forked BOOLEAN NOT NULL DEFAULT TRUE,
+    gist_commented BOOLEAN NOT NULL DEFAULT TRUE,
+    followed BOOLEAN NOT NULL DEFAULT TRUE,
+    org_invited BOOLEAN NOT NULL DEFAULT TRUE,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
@@ -0,0 +1,20 @@
+-- Secret share links to gists; only a hash of each token is stored
+CREATE TABLE IF NOT EXISTS gist_shares (
+    id VARCHAR(36) PRIMARY KEY,
+    gist_id VARCHAR(36) NOT NULL,
+    created_by_id VARCHAR(36) NOT NULL,
+    label VARCHAR(100),
+    token_hash VARCHAR(64) NOT NULL,
+    token_prefix VARCHAR(8) NOT NULL,
+    expires_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    last_used_at TIMESTAMP NULL,
+    use_count BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE,
+    FOREIGN KEY (created_by_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gist_shares_token_hash ON gist_shares(token_hash);
+CREATE INDEX IF NOT EXISTS idx_gist_shares_gist_id ON gist_shares(gist_id);
+CREATE INDEX IF NOT EXISTS idx_gist_shares_created_by_id ON gist_shares(created_by_id);
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS idx_gists_expires_at;
+ALTER TABLE gists DROP COLUMN burn_after_read;
+ALTER TABLE gists DROP COLUMN expires_at;
@@ -0,0 +1,6 @@
+-- Gists may expire at a set time or on their first anonymous view; the
+-- expiry janitor deletes them once expired
+ALTER TABLE gists ADD COLUMN expires_at TIMESTAMP NULL;
+ALTER TABLE gists ADD COLUMN burn_after_read BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_gists_expires_at ON gists(expires_at);
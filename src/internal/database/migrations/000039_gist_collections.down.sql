@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS pinned_gists;
+DROP TABLE IF EXISTS gist_collection_items;
+DROP TABLE IF EXISTS gist_collections;
@@ -0,0 +1,39 @@
+-- Named, ordered lists of gists users curate on their profiles
+CREATE TABLE IF NOT EXISTS gist_collections (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    description VARCHAR(500),
+    is_public BOOLEAN NOT NULL DEFAULT TRUE,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_user_collection_name ON gist_collections(user_id, name);
+
+CREATE TABLE IF NOT EXISTS gist_collection_items (
+    collection_id VARCHAR(36) NOT NULL,
+    gist_id VARCHAR(36) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (collection_id, gist_id),
+    FOREIGN KEY (collection_id) REFERENCES gist_collections(id) ON DELETE CASCADE,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_gist_collection_items_gist_id ON gist_collection_items(gist_id);
+
+-- Gists pinned to the top of a user's profile
+CREATE TABLE IF NOT EXISTS pinned_gists (
+    user_id VARCHAR(36) NOT NULL,
+    gist_id VARCHAR(36) NOT NULL,
+    position INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    PRIMARY KEY (user_id, gist_id),
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_pinned_gists_gist_id ON pinned_gists(gist_id);
@@ -0,0 +1,15 @@
+-- Binary and large files of gists, stored as content-addressed blobs
+CREATE TABLE IF NOT EXISTS gist_attachments (
+    id VARCHAR(36) PRIMARY KEY,
+    gist_id VARCHAR(36) NOT NULL,
+    filename VARCHAR(255) NOT NULL,
+    content_type VARCHAR(255) NOT NULL,
+    size BIGINT NOT NULL,
+    sha256 VARCHAR(64) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_gist_attachment_name ON gist_attachments(gist_id, filename);
+CREATE INDEX IF NOT EXISTS idx_gist_attachments_sha256 ON gist_attachments(sha256);
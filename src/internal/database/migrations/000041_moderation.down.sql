@@ -0,0 +1,7 @@
+DROP TABLE IF EXISTS moderation_notes;
+DROP TABLE IF EXISTS reports;
+ALTER TABLE users DROP COLUMN suspension_reason;
+ALTER TABLE users DROP COLUMN suspended_at;
+DROP INDEX IF EXISTS idx_gists_hidden_at;
+ALTER TABLE gists DROP COLUMN hidden_reason;
+ALTER TABLE gists DROP COLUMN hidden_at;
@@ -0,0 +1,44 @@
+-- Gists administrators hid, and why
+ALTER TABLE gists ADD COLUMN hidden_at TIMESTAMP NULL;
+ALTER TABLE gists ADD COLUMN hidden_reason VARCHAR(500);
+
+CREATE INDEX IF NOT EXISTS idx_gists_hidden_at ON gists(hidden_at);
+
+-- Why and when an administrator suspended a user
+ALTER TABLE users ADD COLUMN suspended_at TIMESTAMP NULL;
+ALTER TABLE users ADD COLUMN suspension_reason VARCHAR(500);
+
+-- Users' reports of spam and abuse
+CREATE TABLE IF NOT EXISTS reports (
+    id VARCHAR(36) PRIMARY KEY,
+    gist_id VARCHAR(36) NOT NULL,
+    reporter_id VARCHAR(36) NOT NULL,
+    reason VARCHAR(20) NOT NULL,
+    description VARCHAR(1000),
+    status VARCHAR(20) NOT NULL DEFAULT 'open',
+    resolved_by_id VARCHAR(36),
+    resolved_at TIMESTAMP NULL,
+    resolution VARCHAR(1000),
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (gist_id) REFERENCES gists(id) ON DELETE CASCADE,
+    FOREIGN KEY (reporter_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_reports_gist_id ON reports(gist_id);
+CREATE INDEX IF NOT EXISTS idx_reports_reporter_id ON reports(reporter_id);
+CREATE INDEX IF NOT EXISTS idx_reports_status ON reports(status);
+
+-- Administrators' notes on gists and users
+CREATE TABLE IF NOT EXISTS moderation_notes (
+    id VARCHAR(36) PRIMARY KEY,
+    subject_type VARCHAR(20) NOT NULL,
+    subject_id VARCHAR(36) NOT NULL,
+    author_id VARCHAR(36) NOT NULL,
+    action VARCHAR(20),
+    body VARCHAR(2000) NOT NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (author_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_moderation_notes_subject ON moderation_notes(subject_type, subject_id);
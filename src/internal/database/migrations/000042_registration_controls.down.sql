@@ -0,0 +1,5 @@
+DROP INDEX IF EXISTS idx_users_pending_approval;
+ALTER TABLE users DROP COLUMN pending_approval;
+ALTER TABLE users DROP COLUMN invite_code_id;
+
+DROP TABLE IF EXISTS invite_codes;
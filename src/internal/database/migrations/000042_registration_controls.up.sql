@@ -0,0 +1,24 @@
+-- Invite codes admins hand out for signing up
+CREATE TABLE IF NOT EXISTS invite_codes (
+    id VARCHAR(36) PRIMARY KEY,
+    created_by_id VARCHAR(36) NOT NULL,
+    label VARCHAR(100),
+    code_hash VARCHAR(64) NOT NULL,
+    code_prefix VARCHAR(8) NOT NULL,
+    max_uses INTEGER NOT NULL DEFAULT 0,
+    use_count INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NULL,
+    revoked_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (created_by_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_invite_codes_code_hash ON invite_codes(code_hash);
+CREATE INDEX IF NOT EXISTS idx_invite_codes_created_by_id ON invite_codes(created_by_id);
+
+-- Which invite a user signed up with, and whether an admin still has to
+-- approve them
+ALTER TABLE users ADD COLUMN invite_code_id VARCHAR(36) NULL;
+ALTER TABLE users ADD COLUMN pending_approval BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS idx_users_pending_approval ON users(pending_approval);
@@ -0,0 +1,22 @@
+-- Domains users and organizations serve their profile and public gists on
+CREATE TABLE IF NOT EXISTS custom_domains (
+    id VARCHAR(36) PRIMARY KEY,
+    domain VARCHAR(255) NOT NULL,
+    user_id VARCHAR(36) NULL,
+    organization_id VARCHAR(36) NULL,
+    verified BOOLEAN NOT NULL DEFAULT FALSE,
+    verification_token VARCHAR(64) NOT NULL,
+    verified_at TIMESTAMP NULL,
+    ssl_enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    ssl_cert_path VARCHAR(255),
+    ssl_key_path VARCHAR(255),
+    ssl_expires_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE,
+    FOREIGN KEY (organization_id) REFERENCES organizations(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_custom_domains_domain ON custom_domains(domain);
+CREATE INDEX IF NOT EXISTS idx_custom_domains_user_id ON custom_domains(user_id);
+CREATE INDEX IF NOT EXISTS idx_custom_domains_organization_id ON custom_domains(organization_id);
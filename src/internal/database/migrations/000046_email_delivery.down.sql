@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS email_digest_items;
+DROP TABLE IF EXISTS email_preferences;
+DROP TABLE IF EXISTS email_queues;
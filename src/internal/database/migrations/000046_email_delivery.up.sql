@@ -0,0 +1,59 @@
+-- Outgoing emails, sent in the background by the email processor
+CREATE TABLE IF NOT EXISTS email_queues (
+    id VARCHAR(36) PRIMARY KEY,
+    type VARCHAR(50) NOT NULL,
+    to_email VARCHAR(255) NOT NULL,
+    to_name VARCHAR(255),
+    from_email VARCHAR(255) NOT NULL,
+    from_name VARCHAR(255),
+    subject TEXT NOT NULL,
+    body_text TEXT,
+    body_html TEXT,
+    headers TEXT,
+    priority INTEGER NOT NULL DEFAULT 5,
+    status VARCHAR(20) NOT NULL DEFAULT 'pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    max_attempts INTEGER NOT NULL DEFAULT 3,
+    error TEXT,
+    scheduled_at TIMESTAMP NULL,
+    sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_queues_pending ON email_queues(status, scheduled_at, priority);
+CREATE INDEX IF NOT EXISTS idx_email_queues_to_email ON email_queues(to_email);
+
+-- Which notification emails each user gets, and whether activity
+-- notifications wait for the weekly digest
+CREATE TABLE IF NOT EXISTS email_preferences (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    email_verified BOOLEAN NOT NULL DEFAULT FALSE,
+    notify_gist_starred BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_gist_forked BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_gist_commented BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_followed BOOLEAN NOT NULL DEFAULT TRUE,
+    notify_weekly_digest BOOLEAN NOT NULL DEFAULT FALSE,
+    notify_system_alerts BOOLEAN NOT NULL DEFAULT TRUE,
+    digest_notifications BOOLEAN NOT NULL DEFAULT FALSE,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_email_preferences_user_id ON email_preferences(user_id);
+
+-- Activity notifications held back for the next weekly digest
+CREATE TABLE IF NOT EXISTS email_digest_items (
+    id VARCHAR(36) PRIMARY KEY,
+    user_id VARCHAR(36) NOT NULL,
+    type VARCHAR(50) NOT NULL,
+    summary TEXT NOT NULL,
+    url TEXT,
+    sent_at TIMESTAMP NULL,
+    created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE
+);
+
+CREATE INDEX IF NOT EXISTS idx_email_digest_items_user_id ON email_digest_items(user_id, sent_at);
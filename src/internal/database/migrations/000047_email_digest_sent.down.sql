@@ -0,0 +1 @@
+ALTER TABLE email_preferences DROP COLUMN last_digest_at;
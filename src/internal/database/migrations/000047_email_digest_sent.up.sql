@@ -0,0 +1,3 @@
+-- When each user was last sent the weekly digest, so a run repeated by a
+-- restart or a second instance sends nobody a digest twice
+ALTER TABLE email_preferences ADD COLUMN last_digest_at TIMESTAMP NULL;
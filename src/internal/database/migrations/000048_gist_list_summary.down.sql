@@ -0,0 +1,6 @@
+DROP INDEX IF EXISTS idx_gists_user_id_created_at_id;
+DROP INDEX IF EXISTS idx_gists_visibility_created_at_id;
+DROP INDEX IF EXISTS idx_gists_star_count_id;
+DROP INDEX IF EXISTS idx_gists_updated_at_id;
+DROP INDEX IF EXISTS idx_gists_created_at_id;
+ALTER TABLE gists DROP COLUMN file_summary;
@@ -0,0 +1,11 @@
+-- What gist lists show of each gist's files, kept by RefreshGistStats so
+-- lists don't load the files. It stays NULL for existing gists until they
+-- are backfilled at startup.
+ALTER TABLE gists ADD COLUMN file_summary TEXT;
+
+-- Keyset pagination of gist lists walks these in (sort key, id) order
+CREATE INDEX IF NOT EXISTS idx_gists_created_at_id ON gists(created_at, id);
+CREATE INDEX IF NOT EXISTS idx_gists_updated_at_id ON gists(updated_at, id);
+CREATE INDEX IF NOT EXISTS idx_gists_star_count_id ON gists(star_count, id);
+CREATE INDEX IF NOT EXISTS idx_gists_visibility_created_at_id ON gists(visibility, created_at, id);
+CREATE INDEX IF NOT EXISTS idx_gists_user_id_created_at_id ON gists(user_id, created_at, id);
@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// EmailVerificationToken is a link sent to confirm a user owns their email
+// address. It only works once, and only while the address is still the
+// user's. The token is stored as a SHA-256 hash.
+type EmailVerificationToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	Email     string    `gorm:"size:255;not null"` // The address the link was sent to
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null"`
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (t *EmailVerificationToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
+
+// PasswordResetToken is a link sent to let a user who forgot their
+// password set a new one. It only works once. The token is stored as a
+// SHA-256 hash.
+type PasswordResetToken struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	TokenHash string    `gorm:"uniqueIndex;size:64;not null"`
+	RequestIP string    `gorm:"size:45"` // Who asked for the link
+	ExpiresAt time.Time `gorm:"not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
+	if t.ID == uuid.Nil {
+		t.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GistAttachment is a binary or large file of a gist. Its content is kept
+// in blob storage under its SHA-256 rather than in the database, so it is
+// not searched, rendered or part of the gist's git history.
+type GistAttachment struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	GistID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_gist_attachment_name" json:"-"`
+	Filename    string    `gorm:"size:255;not null;uniqueIndex:idx_gist_attachment_name" json:"filename"`
+	ContentType string    `gorm:"size:255;not null" json:"content_type"`
+	Size        int64     `gorm:"not null" json:"size"`
+	SHA256      string    `gorm:"column:sha256;size:64;not null;index" json:"sha256"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	Gist Gist `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// BeforeCreate hook
+func (a *GistAttachment) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,68 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Automation run statuses
+const (
+	AutomationRunPending   = "pending"
+	AutomationRunSucceeded = "succeeded"
+	AutomationRunFailed    = "failed"
+)
+
+// AutomationRule runs an action on every gist created with a tag. A rule
+// belongs to a user, for their own gists, or to an organization, for the
+// gists created in it.
+type AutomationRule struct {
+	ID             uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	UserID         *uuid.UUID `json:"user_id,omitempty" gorm:"type:uuid;index"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty" gorm:"type:uuid;index"`
+	Name           string     `json:"name" gorm:"size:100;not null"`
+	Tag            string     `json:"tag" gorm:"size:50;not null"`
+	Action         string     `json:"action" gorm:"size:50;not null"`
+	Params         string     `json:"-" gorm:"type:text"` // JSON object of action parameters
+	Enabled        bool       `json:"enabled" gorm:"not null;default:true"`
+	RunCount       int64      `json:"run_count" gorm:"not null;default:0"`
+	LastRunAt      *time.Time `json:"last_run_at,omitempty"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate hook
+func (r *AutomationRule) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// AutomationRun is one execution of a rule against a gist. Runs are queued
+// when the gist is created and picked up by the automation engine.
+type AutomationRun struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	RuleID     uuid.UUID  `json:"rule_id" gorm:"type:uuid;not null;index"`
+	GistID     uuid.UUID  `json:"gist_id" gorm:"type:uuid;not null"`
+	ActorID    *uuid.UUID `json:"actor_id,omitempty" gorm:"type:uuid"` // who created the gist
+	Status     string     `json:"status" gorm:"size:20;not null;default:pending"`
+	Attempts   int        `json:"attempts" gorm:"not null;default:0"`
+	Error      string     `json:"error,omitempty" gorm:"type:text"`
+	RunAfter   time.Time  `json:"run_after" gorm:"not null"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate hook
+func (r *AutomationRun) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	if r.RunAfter.IsZero() {
+		r.RunAfter = time.Now()
+	}
+	return nil
+}
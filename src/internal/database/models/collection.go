@@ -0,0 +1,56 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GistCollection is a named list of gists a user curates. A user's
+// collections are shown on their profile in Position order.
+type GistCollection struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	UserID      uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_user_collection_name" json:"user_id"`
+	Name        string    `gorm:"size:100;not null;uniqueIndex:idx_user_collection_name" json:"name"`
+	Description string    `gorm:"size:500" json:"description"`
+	IsPublic    bool      `gorm:"not null" json:"is_public"`
+	Position    int       `gorm:"not null;default:0" json:"position"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+
+	// Relations
+	User  User                 `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Items []GistCollectionItem `gorm:"foreignKey:CollectionID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// GistCollectionItem places a gist in a collection
+type GistCollectionItem struct {
+	CollectionID uuid.UUID `gorm:"type:uuid;primaryKey"`
+	GistID       uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	Position     int       `gorm:"not null;default:0"`
+	CreatedAt    time.Time
+
+	// Relations
+	Gist Gist `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// PinnedGist is a gist a user shows at the top of their profile
+type PinnedGist struct {
+	UserID    uuid.UUID `gorm:"type:uuid;primaryKey"`
+	GistID    uuid.UUID `gorm:"type:uuid;primaryKey;index"`
+	Position  int       `gorm:"not null;default:0"`
+	CreatedAt time.Time
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE"`
+	Gist Gist `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (c *GistCollection) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
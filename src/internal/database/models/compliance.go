@@ -82,6 +82,8 @@ type GDPRDeletionRequest struct {
 	UserID         uuid.UUID  `gorm:"type:uuid;not null"`
 	Status         string     `gorm:"size:20;default:'pending'"`
 	DeletionReason string     `gorm:"size:500"`
+	GistPolicy     string     `gorm:"size:20"`
+	GistOrgID      *uuid.UUID `gorm:"column:gist_organization_id;type:uuid"`
 	ProcessedByID  *uuid.UUID `gorm:"type:uuid"`
 	CreatedAt      time.Time
 	ProcessedAt    *time.Time
@@ -0,0 +1,63 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Content policy actions
+const (
+	PolicyActionOff   = "off"
+	PolicyActionWarn  = "warn"  // Save the gist and report the violation
+	PolicyActionBlock = "block" // Reject the save
+)
+
+// ContentPolicy holds the content rules gists are checked against before
+// they are saved. The instance policy comes from the content_policy.*
+// settings; an organization's policy can only make it stricter.
+type ContentPolicy struct {
+	MaxFileSize       int64    `json:"max_file_size,omitempty"` // Bytes, 0 for no limit
+	MaxGistSize       int64    `json:"max_gist_size,omitempty"` // Bytes across all files, 0 for no limit
+	SecretScanning    string   `json:"secret_scanning,omitempty"`
+	BannedWords       []string `json:"banned_words,omitempty"`
+	BannedWordsAction string   `json:"banned_words_action,omitempty"`
+	RequireLicense    string   `json:"require_license,omitempty"` // Public gists only
+}
+
+// IsZero reports whether the policy sets nothing
+func (p ContentPolicy) IsZero() bool {
+	return p.MaxFileSize == 0 && p.MaxGistSize == 0 && p.SecretScanning == "" &&
+		len(p.BannedWords) == 0 && p.BannedWordsAction == "" && p.RequireLicense == ""
+}
+
+// Value marshals the policy for database storage
+func (p ContentPolicy) Value() (driver.Value, error) {
+	if p.IsZero() {
+		return nil, nil
+	}
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan unmarshals the policy from the database
+func (p *ContentPolicy) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into ContentPolicy", value)
+	}
+	*p = ContentPolicy{}
+	if len(data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(data, p)
+}
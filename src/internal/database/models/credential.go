@@ -0,0 +1,75 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Credential is a WebAuthn public key credential: a security key or a
+// platform passkey registered to a user. The credential ID and public key
+// are stored base64url encoded; Transports is a comma separated list of
+// the ways the browser can reach the authenticator.
+type Credential struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID          uuid.UUID `gorm:"type:uuid;index;not null"`
+	Name            string    `gorm:"size:100;not null"`
+	CredentialID    string    `gorm:"type:text;not null"`
+	PublicKey       string    `gorm:"type:text;not null"`
+	AttestationType string    `gorm:"size:32"`
+	AAGUID          string    `gorm:"column:aaguid;size:36"`
+	Transports      string    `gorm:"size:255"`
+	SignCount       int64     `gorm:"not null;default:0"`
+	BackupEligible  bool      `gorm:"default:false"`
+	BackupState     bool      `gorm:"default:false"`
+	LastUsedAt      *time.Time
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+
+	// Relations
+	User *User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// TableName names the table after the standard rather than the generic
+// model name
+func (Credential) TableName() string {
+	return "webauthn_credentials"
+}
+
+// BeforeCreate hook
+func (c *Credential) BeforeCreate(tx *gorm.DB) error {
+	if c.ID == uuid.Nil {
+		c.ID = uuid.New()
+	}
+	return nil
+}
+
+// WebAuthnSession holds the challenge of a WebAuthn registration or sign in
+// between the request that starts it and the one that finishes it. Each
+// session is used once. UserID is empty for a passkey sign in, where the
+// browser picks the account.
+type WebAuthnSession struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key"`
+	UserID    *uuid.UUID `gorm:"type:uuid;index"`
+	Purpose   string     `gorm:"size:20;not null"`
+	Data      string     `gorm:"type:text;not null"`
+	ExpiresAt time.Time  `gorm:"index;not null"`
+	CreatedAt time.Time
+
+	// Relations
+	User *User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (s *WebAuthnSession) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsExpired reports whether the session can no longer be finished
+func (s *WebAuthnSession) IsExpired() bool {
+	return time.Now().After(s.ExpiresAt)
+}
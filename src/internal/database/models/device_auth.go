@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Device authorization statuses
+const (
+	DeviceAuthPending  = "pending"
+	DeviceAuthApproved = "approved"
+	DeviceAuthDenied   = "denied"
+	DeviceAuthConsumed = "consumed"
+)
+
+// DeviceAuthorization is a pending CLI login started with the device flow.
+// The device code itself is never stored, only its SHA-256 hash.
+type DeviceAuthorization struct {
+	ID             uuid.UUID  `gorm:"type:uuid;primary_key"`
+	DeviceCodeHash string     `gorm:"uniqueIndex;size:64;not null"`
+	UserCode       string     `gorm:"uniqueIndex;size:16;not null"`
+	ClientName     string     `gorm:"size:100"`
+	UserID         *uuid.UUID `gorm:"type:uuid"`
+	Status         string     `gorm:"size:20;not null;default:pending"`
+	IPAddress      string     `gorm:"size:45"`
+	ExpiresAt      time.Time  `gorm:"not null"`
+	LastPolledAt   *time.Time
+	CreatedAt      time.Time
+
+	// Relations
+	User *User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (d *DeviceAuthorization) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsExpired reports whether the authorization can no longer be used
+func (d *DeviceAuthorization) IsExpired() bool {
+	return time.Now().After(d.ExpiresAt)
+}
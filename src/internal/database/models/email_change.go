@@ -0,0 +1,44 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Email change request statuses
+const (
+	EmailChangePending   = "pending"
+	EmailChangeConfirmed = "confirmed"
+	EmailChangeReverted  = "reverted"
+	EmailChangeCancelled = "cancelled"
+)
+
+// EmailChangeRequest tracks an account email change from request through the
+// revert grace period. Tokens are stored as SHA-256 hashes.
+type EmailChangeRequest struct {
+	ID               uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID           uuid.UUID `gorm:"type:uuid;not null;index"`
+	OldEmail         string    `gorm:"size:255;not null"`
+	NewEmail         string    `gorm:"size:255;not null;index"`
+	ConfirmTokenHash string    `gorm:"uniqueIndex;size:64;not null"`
+	RevertTokenHash  string    `gorm:"uniqueIndex;size:64;not null"`
+	Status           string    `gorm:"size:20;not null;default:pending"`
+	ExpiresAt        time.Time `gorm:"not null"`
+	RevertExpiresAt  *time.Time
+	ConfirmedAt      *time.Time
+	RevertedAt       *time.Time
+	CreatedAt        time.Time
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (r *EmailChangeRequest) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
@@ -2,6 +2,7 @@ package models
 
 import (
 	"errors"
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -21,6 +22,7 @@ const (
 type Gist struct {
 	ID             uuid.UUID  `gorm:"type:uuid;primary_key"`
 	Name           string     `gorm:"size:63"`
+	Slug           *string    `gorm:"size:100"` // Address under /o/:org, organization gists only
 	Title          string     `gorm:"size:255;not null"`
 	Description    string     `gorm:"size:1000"`
 	Visibility     Visibility `gorm:"size:20;default:'private'"`
@@ -33,12 +35,29 @@ type Gist struct {
 	ViewCount      int        `gorm:"default:0"`
 	Language       string     `gorm:"size:50"`
 	TagsString     string     `gorm:"size:500" json:"-"`
-	ImportID       string     `gorm:"size:255;index"` // External ID for imported gists
-	ImportURL      string     `gorm:"size:500"`       // Original URL for imported gists
+	ImportID       string     `gorm:"size:255;index"`      // External ID for imported gists
+	ImportURL      string     `gorm:"size:500"`            // Original URL for imported gists
+	Ephemeral      bool       `gorm:"default:false;index"` // Created by an API sandbox request; purged after api.sandbox.ttl
+	ExpiresAt      *time.Time `gorm:"index"`               // Hidden once passed, then deleted by the expiry janitor
+	BurnAfterRead  bool       `gorm:"default:false"`       // Expires on its first anonymous view
+	HiddenAt       *time.Time `gorm:"index"`               // Hidden by an administrator; only the owner and administrators see it
+	HiddenReason   string     `gorm:"size:500"`
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 	DeletedAt      gorm.DeletedAt `gorm:"index"`
 
+	// Owner permissions, stored negated so existing gists keep everything allowed
+	EmbedDisabled    bool `gorm:"default:false"` // Refuse the embed endpoint
+	IndexingDisabled bool `gorm:"default:false"` // Ask search engines not to index the gist
+	ForksDisabled    bool `gorm:"default:false"` // Refuse forks by anyone but the owner
+
+	// Totals of the files' stats and what lists show of the files, kept
+	// by RefreshGistStats
+	TotalSize       int64
+	WordCount       int
+	ReadTimeSeconds int
+	FileSummary     FileSummaries `gorm:"type:text;<-:false"`
+
 	// Relations
 	User         *User         `gorm:"constraint:OnDelete:CASCADE"`
 	Organization *Organization `gorm:"constraint:OnDelete:CASCADE"`
@@ -64,6 +83,18 @@ type GistFile struct {
 	CreatedAt time.Time
 	UpdatedAt time.Time
 
+	// Computed on save by ComputeStats
+	WordCount       int // Prose files only
+	ReadTimeSeconds int
+	Complexity      int // Code files only
+
+	// Set on save when formatting hooks are enabled
+	LintAnnotations LintAnnotations `gorm:"type:text"`
+
+	// Set on save when upload scanning is enabled
+	ScanStatus string `gorm:"size:20"` // clean, skipped or error; empty when not scanned
+	ScannedAt  *time.Time
+
 	// Relations
 	Gist Gist `gorm:"constraint:OnDelete:CASCADE"`
 }
@@ -80,21 +111,68 @@ type GistStar struct {
 	User User `gorm:"constraint:OnDelete:CASCADE"`
 }
 
-// GistComment represents a comment on a gist
+// GistComment represents a comment on a gist. Replies point at a top-level
+// comment, so threads are one level deep.
 type GistComment struct {
-	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
-	GistID    uuid.UUID `gorm:"type:uuid;not null"`
-	UserID    uuid.UUID `gorm:"type:uuid;not null"`
-	Content   string    `gorm:"type:text;not null"`
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	DeletedAt gorm.DeletedAt `gorm:"index"`
+	ID              uuid.UUID  `gorm:"type:uuid;primary_key"`
+	GistID          uuid.UUID  `gorm:"type:uuid;not null"`
+	UserID          uuid.UUID  `gorm:"type:uuid;not null"`
+	ParentCommentID *uuid.UUID `gorm:"type:uuid;index"`
+	Content         string     `gorm:"type:text;not null"`
+	EditedAt        *time.Time // Set when the author changes the content
+	CreatedAt       time.Time
+	UpdatedAt       time.Time
+	DeletedAt       gorm.DeletedAt `gorm:"index"`
+
+	// Filled in for API responses
+	ContentHTML     string           `gorm:"-"` // Content rendered as markdown
+	Reactions       map[string]int64 `gorm:"-"` // Count per reaction
+	ViewerReactions []string         `gorm:"-"` // The requesting user's reactions
+	Replies         []GistComment    `gorm:"-"` // Top-level comments only
 
 	// Relations
 	Gist Gist `gorm:"constraint:OnDelete:CASCADE"`
 	User User `gorm:"constraint:OnDelete:CASCADE"`
 }
 
+// Comment reactions, named as on GitHub
+const (
+	ReactionThumbsUp   = "+1"
+	ReactionThumbsDown = "-1"
+	ReactionLaugh      = "laugh"
+	ReactionHooray     = "hooray"
+	ReactionConfused   = "confused"
+	ReactionHeart      = "heart"
+	ReactionRocket     = "rocket"
+	ReactionEyes       = "eyes"
+)
+
+// CommentReactions maps the reactions a comment can get to their emoji
+var CommentReactions = map[string]string{
+	ReactionThumbsUp:   "👍",
+	ReactionThumbsDown: "👎",
+	ReactionLaugh:      "😄",
+	ReactionHooray:     "🎉",
+	ReactionConfused:   "😕",
+	ReactionHeart:      "❤️",
+	ReactionRocket:     "🚀",
+	ReactionEyes:       "👀",
+}
+
+// GistCommentReaction is a user's emoji reaction to a comment. A user can
+// give each reaction once per comment.
+type GistCommentReaction struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	CommentID uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_comment_reaction"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;uniqueIndex:idx_comment_reaction"`
+	Reaction  string    `gorm:"size:20;not null;uniqueIndex:idx_comment_reaction"`
+	CreatedAt time.Time
+
+	// Relations
+	Comment GistComment `gorm:"foreignKey:CommentID;constraint:OnDelete:CASCADE"`
+	User    User        `gorm:"constraint:OnDelete:CASCADE"`
+}
+
 // GistView represents a view of a gist
 type GistView struct {
 	ID        uuid.UUID  `gorm:"type:uuid;primary_key"`
@@ -132,6 +210,13 @@ func (g *Gist) BeforeCreate(tx *gorm.DB) error {
 		(g.UserID != nil && g.OrganizationID != nil) {
 		return gorm.ErrInvalidData
 	}
+	// Totals of files created along with the gist
+	for i := range g.Files {
+		g.Files[i].ComputeStats()
+		g.TotalSize += g.Files[i].Size
+		g.WordCount += g.Files[i].WordCount
+		g.ReadTimeSeconds += g.Files[i].ReadTimeSeconds
+	}
 	return nil
 }
 
@@ -139,12 +224,23 @@ func (f *GistFile) BeforeCreate(tx *gorm.DB) error {
 	if f.ID == uuid.Nil {
 		f.ID = uuid.New()
 	}
-	// Calculate file size and lines
-	f.Size = int64(len(f.Content))
-	f.Lines = countLines(f.Content)
 	return nil
 }
 
+// BeforeSave keeps the file's stats in step with its content
+func (f *GistFile) BeforeSave(tx *gorm.DB) error {
+	f.ComputeStats()
+	return nil
+}
+
+// AfterSave updates the gist's totals
+func (f *GistFile) AfterSave(tx *gorm.DB) error {
+	if f.GistID == uuid.Nil {
+		return nil
+	}
+	return RefreshGistStats(tx, f.GistID)
+}
+
 func (s *GistStar) BeforeCreate(tx *gorm.DB) error {
 	if s.ID == uuid.Nil {
 		s.ID = uuid.New()
@@ -167,6 +263,13 @@ func (c *GistComment) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
+func (r *GistCommentReaction) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
 func (v *GistView) BeforeCreate(tx *gorm.DB) error {
 	if v.ID == uuid.Nil {
 		v.ID = uuid.New()
@@ -189,17 +292,51 @@ func (w *GistWatch) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// AfterCreate/AfterDelete hooks to update counters
+// AfterCreate/AfterDelete hooks to update counters. They are the only
+// place star_count changes, apart from counters.Reconcile; stars deleted by
+// a condition rather than as a loaded row are left to the reconciliation.
 func (s *GistStar) AfterCreate(tx *gorm.DB) error {
 	return tx.Model(&Gist{}).Where("id = ?", s.GistID).
 		UpdateColumn("star_count", gorm.Expr("star_count + ?", 1)).Error
 }
 
 func (s *GistStar) AfterDelete(tx *gorm.DB) error {
+	if s.GistID == uuid.Nil {
+		return nil
+	}
 	return tx.Model(&Gist{}).Where("id = ?", s.GistID).
 		UpdateColumn("star_count", gorm.Expr("star_count - ?", 1)).Error
 }
 
+// SandboxScope is a query scope that keeps sandbox gists and real gists
+// apart: sandbox requests only see ephemeral gists, other requests never do
+func SandboxScope(sandbox bool) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("gists.ephemeral = ?", sandbox)
+	}
+}
+
+// Expired reports whether the gist is past its expiry
+func (g *Gist) Expired(now time.Time) bool {
+	return g.ExpiresAt != nil && !now.Before(*g.ExpiresAt)
+}
+
+// HideExpired is a query scope that excludes gists past their expiry, which
+// the expiry janitor hasn't deleted yet
+func HideExpired(db *gorm.DB) *gorm.DB {
+	return db.Where("gists.expires_at IS NULL OR gists.expires_at > ?", time.Now())
+}
+
+// BurnGist expires a burn-after-read gist now. It reports whether this call
+// expired it, so of several concurrent readers only one gets the content.
+func BurnGist(db *gorm.DB, gistID uuid.UUID) (bool, error) {
+	now := time.Now()
+	result := db.Model(&Gist{}).
+		Where("id = ? AND burn_after_read = ? AND (expires_at IS NULL OR expires_at > ?)", gistID, true, now).
+		UpdateColumn("expires_at", now)
+	return result.RowsAffected == 1, result.Error
+}
+
 // countLines counts the number of lines in a string
 func countLines(s string) int {
 	if s == "" {
@@ -213,3 +350,31 @@ func countLines(s string) int {
 	}
 	return lines
 }
+
+// NormalizeTags lowercases and trims tags and drops empty and duplicate ones
+func NormalizeTags(tags []string) []string {
+	normalized := make([]string, 0, len(tags))
+	seen := make(map[string]bool, len(tags))
+	for _, tag := range tags {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		normalized = append(normalized, tag)
+	}
+	return normalized
+}
+
+// TagList returns the gist's tags, which are stored comma separated
+func (g *Gist) TagList() []string {
+	if g.TagsString == "" {
+		return []string{}
+	}
+	return NormalizeTags(strings.Split(g.TagsString, ","))
+}
+
+// SetTags replaces the gist's tags
+func (g *Gist) SetTags(tags []string) {
+	g.TagsString = strings.Join(NormalizeTags(tags), ",")
+}
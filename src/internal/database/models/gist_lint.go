@@ -0,0 +1,57 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+)
+
+// Lint annotation severities
+const (
+	LintSeverityError   = "error"
+	LintSeverityWarning = "warning"
+)
+
+// LintAnnotation is a message from a linter or formatter about one line of
+// a gist file
+type LintAnnotation struct {
+	Tool     string `json:"tool"`
+	Line     int    `json:"line"`             // 1-based, 0 when the message is about the whole file
+	Column   int    `json:"column,omitempty"` // 1-based
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// LintAnnotations is stored as a JSON array
+type LintAnnotations []LintAnnotation
+
+// Value marshals the annotations for database storage
+func (a LintAnnotations) Value() (driver.Value, error) {
+	if len(a) == 0 {
+		return nil, nil
+	}
+	data, err := json.Marshal(a)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan unmarshals the annotations from the database
+func (a *LintAnnotations) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into LintAnnotations", value)
+	}
+	if len(data) == 0 {
+		*a = nil
+		return nil
+	}
+	return json.Unmarshal(data, a)
+}
@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// GistShare is a secret link to a gist. Whoever holds its token may read
+// the gist whatever its visibility, until the link expires or is revoked.
+// The token is stored as a SHA-256 hash.
+type GistShare struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	GistID      uuid.UUID  `gorm:"type:uuid;not null;index" json:"gist_id"`
+	CreatedByID uuid.UUID  `gorm:"type:uuid;not null;index" json:"created_by_id"`
+	Label       string     `gorm:"size:100" json:"label,omitempty"`
+	TokenHash   string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	TokenPrefix string     `gorm:"size:8;not null" json:"token_prefix"` // Tells links apart in lists
+	ExpiresAt   *time.Time `json:"expires_at"`                          // Nil for links that don't expire
+	RevokedAt   *time.Time `json:"revoked_at"`
+	LastUsedAt  *time.Time `json:"last_used_at"`
+	UseCount    int64      `gorm:"not null;default:0" json:"use_count"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Relations
+	Gist      Gist `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	CreatedBy User `gorm:"foreignKey:CreatedByID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Active reports whether the link still grants access
+func (s *GistShare) Active(now time.Time) bool {
+	return s.RevokedAt == nil && (s.ExpiresAt == nil || now.Before(*s.ExpiresAt))
+}
+
+// BeforeCreate hook
+func (s *GistShare) BeforeCreate(tx *gorm.DB) error {
+	if s.ID == uuid.Nil {
+		s.ID = uuid.New()
+	}
+	return nil
+}
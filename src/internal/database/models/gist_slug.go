@@ -0,0 +1,197 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// MaxGistSlugLength bounds organization gist slugs
+const MaxGistSlugLength = 100
+
+// GistRedirect keeps an old /o/:org/:slug address working after the gist
+// was renamed or moved to another owner
+type GistRedirect struct {
+	ID             uuid.UUID `json:"id" gorm:"type:uuid;primary_key"`
+	OrganizationID uuid.UUID `json:"organization_id" gorm:"type:uuid;not null;uniqueIndex:idx_gist_redirects_org_slug"`
+	Slug           string    `json:"slug" gorm:"size:100;not null;uniqueIndex:idx_gist_redirects_org_slug"`
+	GistID         uuid.UUID `json:"gist_id" gorm:"type:uuid;not null"`
+	CreatedAt      time.Time `json:"created_at"`
+}
+
+// BeforeCreate hook
+func (r *GistRedirect) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// Slugify turns a gist name or title into a URL slug of lowercase letters,
+// digits and dashes. It returns "" when nothing usable is left.
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		switch {
+		case r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)):
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			dash = false
+		default:
+			dash = true
+		}
+		if b.Len() >= MaxGistSlugLength {
+			break
+		}
+	}
+	return strings.Trim(b.String(), "-")
+}
+
+// ValidGistSlug reports whether slug is already in slug form
+func ValidGistSlug(slug string) bool {
+	return slug != "" && len(slug) <= MaxGistSlugLength && Slugify(slug) == slug
+}
+
+// OrgGistPath returns the web address of an organization gist
+func OrgGistPath(orgName, slug string) string {
+	return "/o/" + url.PathEscape(orgName) + "/" + slug
+}
+
+// SlugValue returns the gist's slug, or "" when it has none
+func (g *Gist) SlugValue() string {
+	if g.Slug == nil {
+		return ""
+	}
+	return *g.Slug
+}
+
+// SetOrgSlug gives the gist a slug that is free in its organization, based
+// on preferred, the gist name or the title, and clears it for gists owned
+// by a user. prevOrgID and prevSlug describe the gist's address before a
+// rename or transfer; it is kept as a redirect. The caller saves the gist.
+func SetOrgSlug(tx *gorm.DB, gist *Gist, prevOrgID *uuid.UUID, prevSlug string, preferred string) error {
+	if prevOrgID != nil && prevSlug != "" {
+		if err := saveGistRedirect(tx, *prevOrgID, prevSlug, gist.ID); err != nil {
+			return err
+		}
+	}
+
+	if gist.OrganizationID == nil {
+		gist.Slug = nil
+		return nil
+	}
+
+	base := Slugify(preferred)
+	if base == "" {
+		base = Slugify(gist.Name)
+	}
+	if base == "" {
+		base = Slugify(gist.Title)
+	}
+	if base == "" {
+		base = "gist"
+	}
+
+	slug, err := freeGistSlug(tx, *gist.OrganizationID, base, gist.ID)
+	if err != nil {
+		return err
+	}
+
+	// A redirect from this address to the gist itself is no longer needed
+	if err := tx.Where("organization_id = ? AND slug = ?", *gist.OrganizationID, slug).Delete(&GistRedirect{}).Error; err != nil {
+		return fmt.Errorf("failed to clear gist redirect: %w", err)
+	}
+	gist.Slug = &slug
+	return nil
+}
+
+// freeGistSlug returns base, or base with a numeric suffix, that no other
+// gist in the organization uses or used
+func freeGistSlug(tx *gorm.DB, orgID uuid.UUID, base string, gistID uuid.UUID) (string, error) {
+	if len(base) > MaxGistSlugLength-4 {
+		base = strings.TrimRight(base[:MaxGistSlugLength-4], "-")
+	}
+	for i := 1; i <= 100; i++ {
+		candidate := base
+		if i > 1 {
+			candidate = fmt.Sprintf("%s-%d", base, i)
+		}
+		taken, err := IsGistSlugTaken(tx, orgID, candidate, gistID)
+		if err != nil {
+			return "", err
+		}
+		if !taken {
+			return candidate, nil
+		}
+	}
+	// Fall back to something unique rather than failing the request
+	return base + "-" + gistID.String()[:8], nil
+}
+
+// IsGistSlugTaken reports whether another gist uses the slug, or an old
+// address of another gist redirects from it. Deleted gists keep their slug
+// so their links never point at something else.
+func IsGistSlugTaken(tx *gorm.DB, orgID uuid.UUID, slug string, gistID uuid.UUID) (bool, error) {
+	var count int64
+	if err := tx.Unscoped().Model(&Gist{}).
+		Where("organization_id = ? AND slug = ? AND id <> ?", orgID, slug, gistID).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check gist slug: %w", err)
+	}
+	if count > 0 {
+		return true, nil
+	}
+	if err := tx.Model(&GistRedirect{}).
+		Where("organization_id = ? AND slug = ? AND gist_id <> ?", orgID, slug, gistID).
+		Count(&count).Error; err != nil {
+		return false, fmt.Errorf("failed to check gist redirects: %w", err)
+	}
+	return count > 0, nil
+}
+
+func saveGistRedirect(tx *gorm.DB, orgID uuid.UUID, slug string, gistID uuid.UUID) error {
+	var redirect GistRedirect
+	err := tx.Where("organization_id = ? AND slug = ?", orgID, slug).First(&redirect).Error
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		redirect = GistRedirect{OrganizationID: orgID, Slug: slug, GistID: gistID}
+		err = tx.Create(&redirect).Error
+	case err == nil:
+		err = tx.Model(&redirect).Update("gist_id", gistID).Error
+	}
+	if err != nil {
+		return fmt.Errorf("failed to save gist redirect: %w", err)
+	}
+	return nil
+}
+
+// FindOrgGist finds the gist at /o/:org/:slug. When the address is an old
+// one, the gist it moved to is returned with redirected set.
+func FindOrgGist(db *gorm.DB, orgID uuid.UUID, slug string) (gist *Gist, redirected bool, err error) {
+	gist = &Gist{}
+	err = db.Where("organization_id = ? AND slug = ?", orgID, slug).First(gist).Error
+	if err == nil {
+		return gist, false, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, false, err
+	}
+
+	var redirect GistRedirect
+	if err := db.Where("organization_id = ? AND slug = ?", orgID, slug).First(&redirect).Error; err != nil {
+		return nil, false, err
+	}
+	if err := db.First(gist, "id = ?", redirect.GistID).Error; err != nil {
+		return nil, false, err
+	}
+	return gist, true, nil
+}
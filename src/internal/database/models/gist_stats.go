@@ -0,0 +1,231 @@
+package models
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Reading speeds used for read time estimates. Code is read about half as
+// fast as prose.
+const (
+	proseWordsPerMinute = 200
+	codeWordsPerMinute  = 100
+)
+
+// branchTokens are the keywords that open another path through code, for
+// the complexity heuristic. They are matched as whole words only.
+var branchTokens = map[string]bool{
+	"if": true, "elif": true, "elsif": true, "for": true, "foreach": true,
+	"while": true, "until": true, "case": true, "when": true, "catch": true,
+	"except": true, "rescue": true, "and": true, "or": true,
+}
+
+// IsProseFile reports whether a file is read as text rather than code
+func IsProseFile(filename, language string) bool {
+	switch strings.ToLower(language) {
+	case "markdown", "md", "text", "plaintext", "restructuredtext", "asciidoc":
+		return true
+	}
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown", ".txt", ".rst", ".adoc", "":
+		return true
+	}
+	return false
+}
+
+// ComputeStats fills in the file's size, line count, word count, estimated
+// read time and, for code, its complexity
+func (f *GistFile) ComputeStats() {
+	f.Size = int64(len(f.Content))
+	f.Lines = countLines(f.Content)
+
+	words := len(strings.Fields(f.Content))
+	wpm := codeWordsPerMinute
+	f.WordCount = 0
+	f.Complexity = 0
+	if IsProseFile(f.Filename, f.Language) {
+		f.WordCount = words
+		wpm = proseWordsPerMinute
+	} else if words > 0 {
+		f.Complexity = codeComplexity(f.Content)
+	}
+	f.ReadTimeSeconds = (words*60 + wpm - 1) / wpm
+}
+
+// codeComplexity is a cyclomatic-style estimate: one plus the number of
+// branching keywords, && and || operators and ternaries. It doesn't parse
+// the language, so keywords in strings and comments count as well.
+func codeComplexity(content string) int {
+	complexity := 1
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 && branchTokens[word.String()] {
+			complexity++
+		}
+		word.Reset()
+	}
+
+	runes := []rune(content)
+	for i := 0; i < len(runes); i++ {
+		r := runes[i]
+		if r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) {
+			word.WriteRune(r)
+			continue
+		}
+		flush()
+		switch {
+		case (r == '&' || r == '|') && i+1 < len(runes) && runes[i+1] == r:
+			complexity++
+			i++
+		case r == '?' && i+1 < len(runes) && runes[i+1] == ' ':
+			// Ternaries, written "a ? b : c"
+			complexity++
+		}
+	}
+	flush()
+	return complexity
+}
+
+// FileSummary is what gist lists show of a file
+type FileSummary struct {
+	ID       uuid.UUID `json:"id"`
+	Filename string    `json:"filename"`
+	Language string    `json:"language"`
+	Size     int64     `json:"size"`
+	Lines    int       `json:"lines"`
+}
+
+// FileSummaries is stored as a JSON array ordered by file name, and is
+// NULL until RefreshGistStats first runs for the gist
+type FileSummaries []FileSummary
+
+// Value marshals the summaries for database storage
+func (s FileSummaries) Value() (driver.Value, error) {
+	if s == nil {
+		return nil, nil
+	}
+	data, err := json.Marshal(s)
+	if err != nil {
+		return nil, err
+	}
+	return string(data), nil
+}
+
+// Scan unmarshals the summaries from the database
+func (s *FileSummaries) Scan(value interface{}) error {
+	var data []byte
+	switch v := value.(type) {
+	case nil:
+	case []byte:
+		data = v
+	case string:
+		data = []byte(v)
+	default:
+		return fmt.Errorf("cannot scan %T into FileSummaries", value)
+	}
+	if len(data) == 0 {
+		*s = nil
+		return nil
+	}
+	return json.Unmarshal(data, s)
+}
+
+// RefreshGistStats stores the gist's totals and file summary computed from
+// its files. It doesn't touch updated_at.
+func RefreshGistStats(tx *gorm.DB, gistID uuid.UUID) error {
+	var files []struct {
+		FileSummary
+		WordCount       int
+		ReadTimeSeconds int
+	}
+	if err := tx.Model(&GistFile{}).
+		Select("id, filename, language, size, lines, COALESCE(word_count, 0) AS word_count, COALESCE(read_time_seconds, 0) AS read_time_seconds").
+		Where("gist_id = ?", gistID).
+		Order("filename").
+		Scan(&files).Error; err != nil {
+		return fmt.Errorf("failed to load gist file stats: %w", err)
+	}
+
+	var totalSize int64
+	var wordCount, readTime int
+	summary := make(FileSummaries, 0, len(files))
+	for _, file := range files {
+		totalSize += file.Size
+		wordCount += file.WordCount
+		readTime += file.ReadTimeSeconds
+		summary = append(summary, file.FileSummary)
+	}
+
+	// file_summary is read-only on the model, so saving a gist loaded
+	// before its files changed can't overwrite it
+	if err := tx.Table("gists").Where("id = ?", gistID).UpdateColumns(map[string]interface{}{
+		"total_size":        totalSize,
+		"word_count":        wordCount,
+		"read_time_seconds": readTime,
+		"file_summary":      summary,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to store gist stats: %w", err)
+	}
+	return nil
+}
+
+// BackfillGistStats computes stats for files stored before they were
+// tracked, and the totals of their gists
+func BackfillGistStats(db *gorm.DB) error {
+	for {
+		var files []GistFile
+		if err := db.Where("read_time_seconds IS NULL").Limit(200).Find(&files).Error; err != nil {
+			return fmt.Errorf("failed to load gist files: %w", err)
+		}
+		if len(files) == 0 {
+			return nil
+		}
+
+		gists := make(map[uuid.UUID]bool)
+		for i := range files {
+			file := &files[i]
+			file.ComputeStats()
+			if err := db.Model(file).UpdateColumns(map[string]interface{}{
+				"size":              file.Size,
+				"lines":             file.Lines,
+				"word_count":        file.WordCount,
+				"read_time_seconds": file.ReadTimeSeconds,
+				"complexity":        file.Complexity,
+			}).Error; err != nil {
+				return fmt.Errorf("failed to store gist file stats: %w", err)
+			}
+			gists[file.GistID] = true
+		}
+		for gistID := range gists {
+			if err := RefreshGistStats(db, gistID); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// BackfillFileSummaries computes the file summary of gists stored before
+// it was kept
+func BackfillFileSummaries(db *gorm.DB) error {
+	for {
+		var ids []uuid.UUID
+		if err := db.Table("gists").Where("file_summary IS NULL").Limit(200).Pluck("id", &ids).Error; err != nil {
+			return fmt.Errorf("failed to load gists: %w", err)
+		}
+		if len(ids) == 0 {
+			return nil
+		}
+		for _, gistID := range ids {
+			if err := RefreshGistStats(db, gistID); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -16,6 +16,7 @@ const (
 	ImportStatusCompleted  ImportStatus = "completed"
 	ImportStatusFailed     ImportStatus = "failed"
 	ImportStatusCancelled  ImportStatus = "cancelled"
+	ImportStatusSkipped    ImportStatus = "skipped" // Already imported by an earlier job
 )
 
 // ImportJob represents a bulk import operation
@@ -50,7 +51,7 @@ type ImportItem struct {
 	GistID      *uuid.UUID   `gorm:"type:uuid"`
 	Status      ImportStatus `gorm:"size:20;default:'pending'"`
 	ErrorMessage string      `gorm:"type:text"`
-	SourceData  string       `gorm:"type:jsonb"`
+	SourceData  string       `gorm:"type:text"`
 	CreatedAt   time.Time
 	ProcessedAt *time.Time
 
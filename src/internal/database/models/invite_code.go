@@ -0,0 +1,42 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// InviteCode lets people sign up while registration is invite only. It
+// works MaxUses times until it expires or is revoked. The code is stored
+// as a SHA-256 hash.
+type InviteCode struct {
+	ID          uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	CreatedByID uuid.UUID  `gorm:"type:uuid;not null;index" json:"created_by_id"`
+	Label       string     `gorm:"size:100" json:"label,omitempty"`
+	CodeHash    string     `gorm:"uniqueIndex;size:64;not null" json:"-"`
+	CodePrefix  string     `gorm:"size:8;not null" json:"code_prefix"` // Tells codes apart in lists
+	MaxUses     int        `gorm:"not null" json:"max_uses"`           // 0 for no limit
+	UseCount    int        `gorm:"not null;default:0" json:"use_count"`
+	ExpiresAt   *time.Time `json:"expires_at"` // Nil for codes that don't expire
+	RevokedAt   *time.Time `json:"revoked_at"`
+	CreatedAt   time.Time  `json:"created_at"`
+
+	// Relations
+	CreatedBy User `gorm:"foreignKey:CreatedByID;constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Active reports whether the code can still be used to sign up
+func (i *InviteCode) Active(now time.Time) bool {
+	return i.RevokedAt == nil &&
+		(i.ExpiresAt == nil || now.Before(*i.ExpiresAt)) &&
+		(i.MaxUses == 0 || i.UseCount < i.MaxUses)
+}
+
+// BeforeCreate hook
+func (i *InviteCode) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
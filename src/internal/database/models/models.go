@@ -8,21 +8,42 @@ func GetAllModels() []interface{} {
 		&UserPreference{},
 		&Session{},
 		&APIToken{},
+		&DeviceAuthorization{},
+		&UserIdentity{},
+		&Credential{},
+		&WebAuthnSession{},
+		&EmailChangeRequest{},
+		&EmailVerificationToken{},
+		&PasswordResetToken{},
+		&RecoveryCode{},
 		&UserFollow{},
 		&UserBlock{},
+		&Notification{},
+		&NotificationPreference{},
 		
 		// Gist models
 		&Gist{},
 		&GistFile{},
 		&GistStar{},
 		&GistComment{},
+		&GistCommentReaction{},
 		&GistView{},
 		&GistWatch{},
+		&GistRedirect{},
+		&GistShare{},
+		&GistCollection{},
+		&GistCollectionItem{},
+		&PinnedGist{},
+		&GistAttachment{},
+		&Report{},
+		&ModerationNote{},
+		&InviteCode{},
 		
 		// Organization models
 		&Organization{},
 		&OrganizationMember{},
 		&OrganizationInvitation{},
+		&OrganizationSettings{},
 		
 		// Transfer models
 		&TransferRequest{},
@@ -51,6 +72,12 @@ func GetAllModels() []interface{} {
 		
 		// System models
 		&SystemConfig{},
+		&SystemAlert{},
+		&QuarantinedFile{},
+		&AutomationRule{},
+		&AutomationRun{},
+		&Newsletter{},
+		&NewsletterDelivery{},
 		
 		// Search models
 		&SavedSearch{},
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Report reasons
+const (
+	ReportReasonSpam      = "spam"
+	ReportReasonAbuse     = "abuse"
+	ReportReasonMalware   = "malware"
+	ReportReasonCopyright = "copyright"
+	ReportReasonOther     = "other"
+)
+
+// ReportReasons lists the reasons a gist can be reported for
+var ReportReasons = []string{ReportReasonSpam, ReportReasonAbuse, ReportReasonMalware, ReportReasonCopyright, ReportReasonOther}
+
+// Report statuses. Reports stay open until an administrator resolves them,
+// acting on the gist, or dismisses them.
+const (
+	ReportStatusOpen      = "open"
+	ReportStatusResolved  = "resolved"
+	ReportStatusDismissed = "dismissed"
+)
+
+// Report is a user's flag on a gist for the administrators to review
+type Report struct {
+	ID           uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	GistID       uuid.UUID  `gorm:"type:uuid;not null;index" json:"gist_id"`
+	ReporterID   uuid.UUID  `gorm:"type:uuid;not null;index" json:"reporter_id"`
+	Reason       string     `gorm:"size:20;not null" json:"reason"`
+	Description  string     `gorm:"size:1000" json:"description"`
+	Status       string     `gorm:"size:20;not null;default:'open';index" json:"status"`
+	ResolvedByID *uuid.UUID `gorm:"type:uuid" json:"resolved_by_id,omitempty"`
+	ResolvedAt   *time.Time `json:"resolved_at,omitempty"`
+	Resolution   string     `gorm:"size:1000" json:"resolution,omitempty"` // The administrator's note on closing it
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+
+	// Relations
+	Gist     Gist `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Reporter User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// Moderation note subjects
+const (
+	ModerationSubjectGist = "gist"
+	ModerationSubjectUser = "user"
+)
+
+// ModerationNote is an administrator's note on a gist or user, kept for
+// other administrators. Hiding, suspending and their reversal add one too.
+type ModerationNote struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key" json:"id"`
+	SubjectType string    `gorm:"size:20;not null;index:idx_moderation_notes_subject" json:"subject_type"`
+	SubjectID   uuid.UUID `gorm:"type:uuid;not null;index:idx_moderation_notes_subject" json:"subject_id"`
+	AuthorID    uuid.UUID `gorm:"type:uuid;not null" json:"author_id"`
+	Action      string    `gorm:"size:20" json:"action,omitempty"` // hide, unhide, suspend or unsuspend; empty for plain notes
+	Body        string    `gorm:"size:2000;not null" json:"body"`
+	CreatedAt   time.Time `json:"created_at"`
+
+	// Relations
+	Author User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// HideModerated is a query scope that excludes gists an administrator hid
+func HideModerated(db *gorm.DB) *gorm.DB {
+	return db.Where("gists.hidden_at IS NULL")
+}
+
+// BeforeCreate hook
+func (r *Report) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
+
+// BeforeCreate hook
+func (n *ModerationNote) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
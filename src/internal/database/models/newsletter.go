@@ -0,0 +1,88 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Newsletter statuses
+const (
+	NewsletterDraft     = "draft"
+	NewsletterScheduled = "scheduled"
+	NewsletterSending   = "sending"
+	NewsletterSent      = "sent"
+	NewsletterCancelled = "cancelled"
+)
+
+// Newsletter recurrences
+const (
+	NewsletterOnce    = ""
+	NewsletterWeekly  = "weekly"
+	NewsletterMonthly = "monthly"
+)
+
+// Newsletter delivery statuses
+const (
+	NewsletterDeliveryPending = "pending"
+	NewsletterDeliverySent    = "sent"
+	NewsletterDeliveryFailed  = "failed"
+	NewsletterDeliverySkipped = "skipped" // unsubscribed, deactivated or cancelled before sending
+)
+
+// Newsletter is an announcement broadcast by an administrator to every
+// active user who subscribed. Subject and body are text templates rendered
+// per recipient.
+type Newsletter struct {
+	ID          uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	Subject     string     `json:"subject" gorm:"size:200;not null"`
+	Body        string     `json:"body" gorm:"type:text;not null"`
+	Status      string     `json:"status" gorm:"size:20;not null;default:draft"`
+	Recurrence  string     `json:"recurrence" gorm:"size:20;not null;default:''"`
+	ScheduledAt *time.Time `json:"scheduled_at,omitempty"`
+	StartedAt   *time.Time `json:"started_at,omitempty"`
+	CompletedAt *time.Time `json:"completed_at,omitempty"`
+	Recipients  int        `json:"recipients" gorm:"not null;default:0"`
+	SentCount   int        `json:"sent_count" gorm:"not null;default:0"`
+	FailedCount int        `json:"failed_count" gorm:"not null;default:0"`
+	CreatedBy   *uuid.UUID `json:"created_by,omitempty" gorm:"type:uuid"`
+	CreatedAt   time.Time  `json:"created_at"`
+	UpdatedAt   time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate hook
+func (n *Newsletter) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
+
+// Editable reports whether the newsletter can still be changed
+func (n *Newsletter) Editable() bool {
+	return n.Status == NewsletterDraft || n.Status == NewsletterScheduled
+}
+
+// NewsletterDelivery is one recipient of a newsletter. Recipients are
+// snapshotted when sending starts and worked through in batches.
+type NewsletterDelivery struct {
+	ID           uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	NewsletterID uuid.UUID  `json:"newsletter_id" gorm:"type:uuid;not null;uniqueIndex:idx_newsletter_delivery_user"`
+	UserID       uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;uniqueIndex:idx_newsletter_delivery_user"`
+	Email        string     `json:"email" gorm:"size:255;not null"`
+	Status       string     `json:"status" gorm:"size:20;not null;default:pending"`
+	Attempts     int        `json:"attempts" gorm:"not null;default:0"`
+	Error        string     `json:"error,omitempty" gorm:"type:text"`
+	SentAt       *time.Time `json:"sent_at,omitempty"`
+	CreatedAt    time.Time  `json:"created_at"`
+	UpdatedAt    time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate hook
+func (d *NewsletterDelivery) BeforeCreate(tx *gorm.DB) error {
+	if d.ID == uuid.Nil {
+		d.ID = uuid.New()
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Notification types
+const (
+	NotificationGistStarred    = "gist_starred"
+	NotificationGistForked     = "gist_forked"
+	NotificationGistCommented  = "gist_commented"
+	NotificationCommentReplied = "comment_replied"
+	NotificationFollowed       = "followed"
+	NotificationOrgInvited     = "org_invited"
+)
+
+// Notification is an entry of a user's in-app notification center
+type Notification struct {
+	ID        uuid.UUID  `gorm:"type:uuid;primary_key" json:"id"`
+	UserID    uuid.UUID  `gorm:"type:uuid;not null;index:idx_notifications_user_read" json:"-"`
+	ActorID   *uuid.UUID `gorm:"type:uuid" json:"actor_id,omitempty"`
+	Type      string     `gorm:"size:30;not null" json:"type"`
+	Message   string     `gorm:"size:500;not null" json:"message"`
+	URL       string     `gorm:"size:500" json:"url,omitempty"`
+	GistID    *uuid.UUID `gorm:"type:uuid;index" json:"gist_id,omitempty"`
+	ReadAt    *time.Time `gorm:"index:idx_notifications_user_read" json:"read_at"`
+	CreatedAt time.Time  `gorm:"index" json:"created_at"`
+
+	// Relations
+	User  User  `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+	Actor *User `gorm:"foreignKey:ActorID;constraint:OnDelete:SET NULL" json:"-"`
+}
+
+// NotificationPreference holds which notifications a user gets in the
+// notification center. Users without a row get all of them.
+type NotificationPreference struct {
+	UserID        uuid.UUID `gorm:"type:uuid;primary_key" json:"-"`
+	GistStarred   bool      `gorm:"not null;default:true" json:"gist_starred"`
+	GistForked    bool      `gorm:"not null;default:true" json:"gist_forked"`
+	GistCommented bool      `gorm:"not null;default:true" json:"gist_commented"` // Comments on the user's gists and replies to their comments
+	Followed      bool      `gorm:"not null;default:true" json:"followed"`
+	OrgInvited    bool      `gorm:"not null;default:true" json:"org_invited"`
+	UpdatedAt     time.Time `json:"updated_at"`
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE" json:"-"`
+}
+
+// DefaultNotificationPreference returns the preferences of a user who
+// never changed them
+func DefaultNotificationPreference(userID uuid.UUID) NotificationPreference {
+	return NotificationPreference{
+		UserID:        userID,
+		GistStarred:   true,
+		GistForked:    true,
+		GistCommented: true,
+		Followed:      true,
+		OrgInvited:    true,
+	}
+}
+
+// Wants reports whether the preferences let a notification type through
+func (p *NotificationPreference) Wants(notificationType string) bool {
+	switch notificationType {
+	case NotificationGistStarred:
+		return p.GistStarred
+	case NotificationGistForked:
+		return p.GistForked
+	case NotificationGistCommented, NotificationCommentReplied:
+		return p.GistCommented
+	case NotificationFollowed:
+		return p.Followed
+	case NotificationOrgInvited:
+		return p.OrgInvited
+	default:
+		return true
+	}
+}
+
+// BeforeCreate hook
+func (n *Notification) BeforeCreate(tx *gorm.DB) error {
+	if n.ID == uuid.Nil {
+		n.ID = uuid.New()
+	}
+	return nil
+}
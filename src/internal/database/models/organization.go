@@ -1,6 +1,7 @@
 package models
 
 import (
+	"strings"
 	"time"
 
 	"github.com/google/uuid"
@@ -10,7 +11,7 @@ import (
 // Organization represents a group of users
 type Organization struct {
 	ID          uuid.UUID      `gorm:"type:uuid;primary_key"`
-	Name        string         `gorm:"uniqueIndex;size:39;not null"`
+	Name        string         `gorm:"uniqueIndex:idx_organizations_name_live,where:deleted_at IS NULL;size:39;not null"`
 	DisplayName string         `gorm:"size:100"`
 	Description string         `gorm:"size:500"`
 	Website     string         `gorm:"size:255"`
@@ -62,6 +63,55 @@ type OrganizationInvitation struct {
 	InvitedBy    User         `gorm:"foreignKey:InvitedByID;constraint:OnDelete:CASCADE"`
 }
 
+// Organization roles
+const (
+	OrgRoleOwner  = "owner"
+	OrgRoleAdmin  = "admin"
+	OrgRoleMember = "member"
+	OrgRoleViewer = "viewer" // Reads the organization's private gists, changes nothing
+)
+
+// Who may create gists owned by an organization
+const (
+	OrgGistCreationMembers = "members" // Any member
+	OrgGistCreationAdmins  = "admins"  // Owners and admins only
+)
+
+// OrganizationSettings holds an organization's defaults and member policies
+type OrganizationSettings struct {
+	OrganizationID        uuid.UUID     `gorm:"type:uuid;primary_key"`
+	DefaultGistVisibility Visibility    `gorm:"size:20;not null;default:'private'"`
+	RequireTwoFactor      bool          `gorm:"default:false"`
+	MemberGistCreation    string        `gorm:"size:20;not null;default:'members'"`
+	AllowedWebhookDomains string        `gorm:"type:text"` // Comma-separated; empty allows any domain
+	GistNamePattern       string        `gorm:"size:255"`  // Regular expression gist names must match
+	ContentPolicy         ContentPolicy `gorm:"type:text"` // Stricter content rules for the organization's gists
+	CreatedAt             time.Time
+	UpdatedAt             time.Time
+}
+
+// WebhookDomains returns the allowed webhook domains as a list
+func (s *OrganizationSettings) WebhookDomains() []string {
+	var domains []string
+	for _, domain := range strings.Split(s.AllowedWebhookDomains, ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			domains = append(domains, domain)
+		}
+	}
+	return domains
+}
+
+// IsOrgAdminRole reports whether the role can manage the organization
+func IsOrgAdminRole(role string) bool {
+	return role == OrgRoleOwner || role == OrgRoleAdmin
+}
+
+// CanEditOrgGists reports whether the role can create and edit the
+// organization's gists
+func CanEditOrgGists(role string) bool {
+	return IsOrgAdminRole(role) || role == OrgRoleMember
+}
+
 // BeforeCreate hooks
 func (o *Organization) BeforeCreate(tx *gorm.DB) error {
 	if o.ID == uuid.Nil {
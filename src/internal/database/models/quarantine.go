@@ -0,0 +1,41 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Where a quarantined file was uploaded
+const (
+	QuarantineSourceGistUpload    = "gist_upload"
+	QuarantineSourceArchiveImport = "archive_import"
+)
+
+// QuarantinedFile is an upload the malware scanner flagged. The file is
+// kept out of gists and storage; its content is moved to the quarantine
+// directory for admins to inspect.
+type QuarantinedFile struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	Filename   string     `json:"filename" gorm:"size:255;not null"`
+	Source     string     `json:"source" gorm:"size:30;not null"`
+	UserID     *uuid.UUID `json:"user_id,omitempty" gorm:"type:uuid;index"`
+	GistID     *uuid.UUID `json:"gist_id,omitempty" gorm:"type:uuid"`
+	Scanner    string     `json:"scanner" gorm:"size:20"`
+	Signature  string     `json:"signature" gorm:"size:255"`
+	Size       int64      `json:"size"`
+	SHA256     string     `json:"sha256" gorm:"column:sha256;size:64"`
+	StoredPath string     `json:"-" gorm:"size:500"` // Relative to scanning.quarantine_dir; empty when not kept
+	CreatedAt  time.Time  `json:"created_at"`
+
+	User *User `json:"user,omitempty" gorm:"constraint:OnDelete:SET NULL"`
+}
+
+// BeforeCreate hook
+func (q *QuarantinedFile) BeforeCreate(tx *gorm.DB) error {
+	if q.ID == uuid.Nil {
+		q.ID = uuid.New()
+	}
+	return nil
+}
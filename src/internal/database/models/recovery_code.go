@@ -0,0 +1,30 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// RecoveryCode signs a user with two-factor authentication in once when
+// they can't give a code from their authenticator app. The code is stored
+// as a SHA-256 hash.
+type RecoveryCode struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID    uuid.UUID `gorm:"type:uuid;not null;index"`
+	CodeHash  string    `gorm:"uniqueIndex;size:64;not null"`
+	UsedAt    *time.Time
+	CreatedAt time.Time
+
+	// Relations
+	User User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (r *RecoveryCode) BeforeCreate(tx *gorm.DB) error {
+	if r.ID == uuid.Nil {
+		r.ID = uuid.New()
+	}
+	return nil
+}
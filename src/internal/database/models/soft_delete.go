@@ -0,0 +1,175 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// NotDeleted is a query scope leaving out soft-deleted rows of a table.
+// GORM only does this by itself for queries on a model, so queries naming
+// tables, through Table or in joins, need it for each soft-deletable table.
+func NotDeleted(table string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(table + ".deleted_at IS NULL")
+	}
+}
+
+// PurgeResult counts what a purge of soft-deleted rows removed for good
+type PurgeResult struct {
+	Gists         int64 `json:"gists"`
+	Users         int64 `json:"users"`
+	Organizations int64 `json:"organizations"`
+
+	// GistIDs are the purged gists, whose repositories can go too
+	GistIDs []uuid.UUID `json:"-"`
+}
+
+// PurgeDeleted permanently deletes the gists, users and organizations that
+// were soft-deleted before cutoff. Gists of purged users and organizations
+// go with them, and so do the rows pointing at anything purged, as foreign
+// keys would cascade where they are enforced.
+func PurgeDeleted(db *gorm.DB, cutoff time.Time) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		users := tx.Unscoped().Model(&User{}).Select("id").Where("deleted_at < ?", cutoff)
+		orgs := tx.Unscoped().Model(&Organization{}).Select("id").Where("deleted_at < ?", cutoff)
+		var gists []uuid.UUID
+		if err := tx.Unscoped().Model(&Gist{}).
+			Where("deleted_at < ? OR user_id IN (?) OR organization_id IN (?)", cutoff, users, orgs).
+			Pluck("id", &gists).Error; err != nil {
+			return err
+		}
+		if err := purgeGists(tx, gists, result); err != nil {
+			return err
+		}
+
+		comments := tx.Unscoped().Model(&GistComment{}).Select("id").Where("user_id IN (?)", users)
+		if err := purgeRows(tx, &GistCommentReaction{}, "user_id IN (?) OR comment_id IN (?)", users, comments); err != nil {
+			return err
+		}
+		for _, model := range []interface{}{
+			&UserPreference{}, &Session{}, &APIToken{}, &DeviceAuthorization{}, &UserIdentity{}, &Credential{}, &WebAuthnSession{}, &EmailChangeRequest{}, &EmailVerificationToken{}, &PasswordResetToken{}, &RecoveryCode{},
+			&OrganizationMember{}, &GistStar{}, &GistComment{}, &GistWatch{}, &Notification{}, &NotificationPreference{},
+		} {
+			if err := purgeRows(tx, model, "user_id IN (?)", users); err != nil {
+				return err
+			}
+		}
+		if err := purgeRows(tx, &UserFollow{}, "follower_id IN (?) OR following_id IN (?)", users, users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &UserBlock{}, "blocker_id IN (?) OR blocked_id IN (?)", users, users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &GistShare{}, "created_by_id IN (?)", users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &Report{}, "reporter_id IN (?)", users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &InviteCode{}, "created_by_id IN (?)", users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &ModerationNote{}, "author_id IN (?) OR (subject_type = ? AND subject_id IN (?))", users, ModerationSubjectUser, users); err != nil {
+			return err
+		}
+		if err := purgeRows(tx, &CustomDomain{}, "user_id IN (?) OR organization_id IN (?)", users, orgs); err != nil {
+			return err
+		}
+		collections := tx.Model(&GistCollection{}).Select("id").Where("user_id IN (?)", users)
+		if err := purgeRows(tx, &GistCollectionItem{}, "collection_id IN (?)", collections); err != nil {
+			return err
+		}
+		// The email package keeps its own models, so these go by table name
+		for _, model := range []interface{}{&GistCollection{}, &PinnedGist{}, "email_preferences", "email_digest_items"} {
+			if err := purgeRows(tx, model, "user_id IN (?)", users); err != nil {
+				return err
+			}
+		}
+
+		for _, model := range []interface{}{
+			&OrganizationMember{}, &OrganizationInvitation{}, &OrganizationSettings{}, &GistRedirect{},
+		} {
+			if err := purgeRows(tx, model, "organization_id IN (?)", orgs); err != nil {
+				return err
+			}
+		}
+
+		// Users and organizations go last, the queries above select by them
+		purged := tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&User{})
+		if purged.Error != nil {
+			return purged.Error
+		}
+		result.Users = purged.RowsAffected
+		purged = tx.Unscoped().Where("deleted_at < ?", cutoff).Delete(&Organization{})
+		if purged.Error != nil {
+			return purged.Error
+		}
+		result.Organizations = purged.RowsAffected
+		return nil
+	})
+	return result, err
+}
+
+// PurgeDeletedGists permanently deletes the gists soft-deleted before
+// cutoff, leaving users and organizations alone
+func PurgeDeletedGists(db *gorm.DB, cutoff time.Time) (*PurgeResult, error) {
+	result := &PurgeResult{}
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var gists []uuid.UUID
+		if err := tx.Unscoped().Model(&Gist{}).Where("deleted_at < ?", cutoff).Pluck("id", &gists).Error; err != nil {
+			return err
+		}
+		return purgeGists(tx, gists, result)
+	})
+	return result, err
+}
+
+// purgeGists permanently deletes gists with the rows pointing at them
+func purgeGists(tx *gorm.DB, gists []uuid.UUID, result *PurgeResult) error {
+	if len(gists) == 0 {
+		return nil
+	}
+	// Forks outlive the gist they were forked from
+	if err := tx.Unscoped().Model(&Gist{}).Where("forked_from_id IN ?", gists).
+		UpdateColumn("forked_from_id", nil).Error; err != nil {
+		return err
+	}
+	comments := tx.Unscoped().Model(&GistComment{}).Select("id").Where("gist_id IN ?", gists)
+	if err := purgeRows(tx, &GistCommentReaction{}, "comment_id IN (?)", comments); err != nil {
+		return err
+	}
+	for _, model := range []interface{}{
+		&GistFile{}, &GistStar{}, &GistComment{}, &GistView{}, &GistWatch{}, &GistRedirect{}, &GistTag{}, &Notification{}, &GistShare{},
+		&GistCollectionItem{}, &PinnedGist{}, &GistAttachment{}, &Report{},
+	} {
+		if err := purgeRows(tx, model, "gist_id IN ?", gists); err != nil {
+			return err
+		}
+	}
+	if err := purgeRows(tx, &ModerationNote{}, "subject_type = ? AND subject_id IN ?", ModerationSubjectGist, gists); err != nil {
+		return err
+	}
+	purged := tx.Unscoped().Where("id IN ?", gists).Delete(&Gist{})
+	if purged.Error != nil {
+		return purged.Error
+	}
+	result.Gists = purged.RowsAffected
+	result.GistIDs = gists
+	return nil
+}
+
+// purgeRows permanently deletes the rows of model, or of the table named
+// by a string, matching the query. Not every model has a table on every
+// instance, and those are skipped.
+func purgeRows(tx *gorm.DB, model interface{}, query string, args ...interface{}) error {
+	if !tx.Migrator().HasTable(model) {
+		return nil
+	}
+	if table, ok := model.(string); ok {
+		return tx.Table(table).Where(query, args...).Delete(map[string]interface{}{}).Error
+	}
+	return tx.Unscoped().Where(query, args...).Delete(model).Error
+}
@@ -0,0 +1,143 @@
+package models
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+func setupSoftDeleteTest(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger:                                   logger.Default.LogMode(logger.Silent),
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&User{}, &Organization{}, &OrganizationMember{}, &Gist{}, &GistFile{}, &GistStar{}, &Session{},
+	))
+	return db
+}
+
+func TestDeletedUserGivesUpNameAndEmail(t *testing.T) {
+	db := setupSoftDeleteTest(t)
+
+	user := &User{Username: "gopher", Email: "gopher@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	assert.Error(t, db.Create(&User{Username: "gopher", Email: "other@example.com"}).Error)
+	assert.Error(t, db.Create(&User{Username: "other", Email: "gopher@example.com"}).Error)
+
+	require.NoError(t, db.Delete(user).Error)
+	assert.NoError(t, db.Create(&User{Username: "gopher", Email: "gopher@example.com"}).Error)
+
+	org := &Organization{Name: "gophers"}
+	require.NoError(t, db.Create(org).Error)
+	require.NoError(t, db.Delete(org).Error)
+	assert.NoError(t, db.Create(&Organization{Name: "gophers"}).Error)
+}
+
+func TestPurgeDeleted(t *testing.T) {
+	db := setupSoftDeleteTest(t)
+	cutoff := time.Now().Add(-30 * 24 * time.Hour)
+	longAgo := cutoff.Add(-time.Hour)
+
+	// A user deleted before the cutoff, with a gist, a star and a session
+	gone := &User{Username: "gone", Email: "gone@example.com"}
+	require.NoError(t, db.Create(gone).Error)
+	goneGist := &Gist{Title: "Gone", UserID: &gone.ID, Files: []GistFile{{Filename: "a.txt", Content: "a"}}}
+	require.NoError(t, db.Create(goneGist).Error)
+	require.NoError(t, db.Create(&Session{UserID: gone.ID, Token: "t", RefreshToken: "r", ExpiresAt: time.Now()}).Error)
+
+	// A user deleted recently, who can still be restored
+	recent := &User{Username: "recent", Email: "recent@example.com"}
+	require.NoError(t, db.Create(recent).Error)
+
+	// A live user whose fork of the purged gist outlives it, and who
+	// starred it
+	live := &User{Username: "live", Email: "live@example.com"}
+	require.NoError(t, db.Create(live).Error)
+	fork := &Gist{Title: "Fork", UserID: &live.ID, ForkedFromID: &goneGist.ID}
+	require.NoError(t, db.Create(fork).Error)
+	require.NoError(t, db.Create(&GistStar{UserID: live.ID, GistID: goneGist.ID}).Error)
+
+	// An organization deleted before the cutoff, with a gist
+	org := &Organization{Name: "gone-org"}
+	require.NoError(t, db.Create(org).Error)
+	orgGist := &Gist{Title: "Org", OrganizationID: &org.ID}
+	require.NoError(t, db.Create(orgGist).Error)
+
+	require.NoError(t, db.Delete(gone).Error)
+	require.NoError(t, db.Delete(recent).Error)
+	require.NoError(t, db.Delete(org).Error)
+	require.NoError(t, db.Unscoped().Model(&User{}).Where("id = ?", gone.ID).Update("deleted_at", longAgo).Error)
+	require.NoError(t, db.Unscoped().Model(&Organization{}).Where("id = ?", org.ID).Update("deleted_at", longAgo).Error)
+
+	result, err := PurgeDeleted(db, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Users)
+	assert.Equal(t, int64(1), result.Organizations)
+	assert.Equal(t, int64(2), result.Gists)
+	assert.ElementsMatch(t, []uuid.UUID{goneGist.ID, orgGist.ID}, result.GistIDs)
+
+	count := func(model interface{}, query string, args ...interface{}) int64 {
+		var n int64
+		require.NoError(t, db.Unscoped().Model(model).Where(query, args...).Count(&n).Error)
+		return n
+	}
+	assert.Zero(t, count(&User{}, "id = ?", gone.ID))
+	assert.Zero(t, count(&Organization{}, "id = ?", org.ID))
+	assert.Zero(t, count(&Gist{}, "id IN ?", result.GistIDs))
+	assert.Zero(t, count(&GistFile{}, "gist_id = ?", goneGist.ID))
+	assert.Zero(t, count(&GistStar{}, "gist_id = ?", goneGist.ID))
+	assert.Zero(t, count(&Session{}, "user_id = ?", gone.ID))
+
+	assert.Equal(t, int64(1), count(&User{}, "id = ?", recent.ID))
+	assert.Equal(t, int64(1), count(&User{}, "id = ?", live.ID))
+	var reloaded Gist
+	require.NoError(t, db.First(&reloaded, "id = ?", fork.ID).Error)
+	assert.Nil(t, reloaded.ForkedFromID)
+
+	// Nothing is left to purge
+	result, err = PurgeDeleted(db, cutoff)
+	require.NoError(t, err)
+	assert.Zero(t, result.Users+result.Organizations+result.Gists)
+}
+
+func TestPurgeDeletedGists(t *testing.T) {
+	db := setupSoftDeleteTest(t)
+	cutoff := time.Now().Add(-time.Hour)
+
+	user := &User{Username: "gopher", Email: "gopher@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	old := &Gist{Title: "Old", UserID: &user.ID, Files: []GistFile{{Filename: "a.txt", Content: "a"}}}
+	require.NoError(t, db.Create(old).Error)
+	kept := &Gist{Title: "Kept", UserID: &user.ID}
+	require.NoError(t, db.Create(kept).Error)
+
+	require.NoError(t, db.Delete(old).Error)
+	require.NoError(t, db.Unscoped().Model(&Gist{}).Where("id = ?", old.ID).Update("deleted_at", cutoff.Add(-time.Minute)).Error)
+	// Deleted after the cutoff, so it can still be restored from the trash
+	recent := &Gist{Title: "Recent", UserID: &user.ID}
+	require.NoError(t, db.Create(recent).Error)
+	require.NoError(t, db.Delete(recent).Error)
+
+	result, err := PurgeDeletedGists(db, cutoff)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Gists)
+	assert.Equal(t, []uuid.UUID{old.ID}, result.GistIDs)
+	assert.Zero(t, result.Users)
+
+	var n int64
+	db.Unscoped().Model(&Gist{}).Where("id IN ?", []interface{}{kept.ID, recent.ID}).Count(&n)
+	assert.Equal(t, int64(2), n)
+	db.Model(&GistFile{}).Where("gist_id = ?", old.ID).Count(&n)
+	assert.Zero(t, n)
+	db.Model(&User{}).Where("id = ?", user.ID).Count(&n)
+	assert.Equal(t, int64(1), n)
+}
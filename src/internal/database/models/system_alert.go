@@ -0,0 +1,50 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// System alert statuses
+const (
+	AlertStatusFiring   = "firing"
+	AlertStatusResolved = "resolved"
+)
+
+// System alert severities
+const (
+	AlertSeverityWarning  = "warning"
+	AlertSeverityCritical = "critical"
+)
+
+// SystemAlert records one firing of a built-in alerting rule, from the
+// evaluation that crossed the threshold until the one that cleared it
+type SystemAlert struct {
+	ID         uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
+	Rule       string     `json:"rule" gorm:"size:50;not null;index"`
+	Severity   string     `json:"severity" gorm:"size:20;not null;default:warning"`
+	Status     string     `json:"status" gorm:"size:20;not null;default:firing"`
+	Message    string     `json:"message" gorm:"type:text"`
+	Value      float64    `json:"value"`
+	Threshold  float64    `json:"threshold"`
+	FiredAt    time.Time  `json:"fired_at" gorm:"not null"`
+	ResolvedAt *time.Time `json:"resolved_at,omitempty"`
+	NotifiedAt *time.Time `json:"notified_at,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UpdatedAt  time.Time  `json:"updated_at"`
+}
+
+// BeforeCreate hook
+func (a *SystemAlert) BeforeCreate(tx *gorm.DB) error {
+	if a.ID == uuid.Nil {
+		a.ID = uuid.New()
+	}
+	return nil
+}
+
+// IsFiring reports whether the alert has not been resolved yet
+func (a *SystemAlert) IsFiring() bool {
+	return a.Status == AlertStatusFiring
+}
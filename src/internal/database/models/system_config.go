@@ -161,6 +161,12 @@ var SystemConfigDefaults = []SystemConfig{
 		Type:     "int",
 		Category: "rate_limit",
 	},
+	{
+		Key:      ConfigKeyReadOnlyMode,
+		Value:    "false",
+		Type:     "bool",
+		Category: "maintenance",
+	},
 	{
 		Key:      "rate_limit_login_attempts",
 		Value:    "5",
@@ -169,6 +175,33 @@ var SystemConfigDefaults = []SystemConfig{
 	},
 }
 
+// Read-only mode keys. While read-only mode is on the web UI and API reject
+// every change, e.g. while repositories move to another storage driver.
+const (
+	ConfigKeyReadOnlyMode    = "read_only_mode"
+	ConfigKeyReadOnlyMessage = "read_only_message"
+)
+
+// GetReadOnlyMode reports whether read-only mode is on, and why
+func GetReadOnlyMode(db *gorm.DB) (bool, string) {
+	enabled, err := GetConfigBool(db, ConfigKeyReadOnlyMode)
+	if err != nil || !enabled {
+		return false, ""
+	}
+	message, _ := GetConfigValue(db, ConfigKeyReadOnlyMessage)
+	return true, message
+}
+
+// SetReadOnlyMode turns read-only mode on or off
+func SetReadOnlyMode(db *gorm.DB, enabled bool, message string) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := SetConfigValue(tx, ConfigKeyReadOnlyMessage, message); err != nil {
+			return err
+		}
+		return SetConfigValue(tx, ConfigKeyReadOnlyMode, fmt.Sprintf("%t", enabled))
+	})
+}
+
 // GetConfigValue gets a configuration value by key
 func GetConfigValue(db *gorm.DB, key string) (string, error) {
 	var config SystemConfig
@@ -226,4 +259,34 @@ func InitializeSystemConfig(db *gorm.DB) error {
 		}
 	}
 	return nil
-}
\ No newline at end of file
+}
+
+// ConfigStore keeps runtime settings in the system_configs table. Rows
+// keyed like configuration settings ("features.registration") override
+// the config file and environment when the server starts.
+type ConfigStore struct {
+	db *gorm.DB
+}
+
+// NewConfigStore creates a settings store backed by db
+func NewConfigStore(db *gorm.DB) *ConfigStore {
+	return &ConfigStore{db: db}
+}
+
+// Settings returns every stored value by key
+func (s *ConfigStore) Settings() (map[string]string, error) {
+	var rows []SystemConfig
+	if err := s.db.Find(&rows).Error; err != nil {
+		return nil, err
+	}
+	settings := make(map[string]string, len(rows))
+	for _, row := range rows {
+		settings[row.Key] = row.Value
+	}
+	return settings, nil
+}
+
+// SaveSetting stores a value
+func (s *ConfigStore) SaveSetting(key, value string) error {
+	return SetConfigValue(s.db, key, value)
+}
@@ -10,8 +10,8 @@ import (
 // User represents a user account
 type User struct {
 	ID               uuid.UUID      `gorm:"type:uuid;primary_key"`
-	Username         string         `gorm:"uniqueIndex;size:39;not null"`
-	Email            string         `gorm:"uniqueIndex;size:255;not null"`
+	Username         string         `gorm:"uniqueIndex:idx_users_username_live,where:deleted_at IS NULL;size:39;not null"`
+	Email            string         `gorm:"uniqueIndex:idx_users_email_live,where:deleted_at IS NULL;size:255;not null"`
 	PasswordHash     string         `gorm:"size:255;not null"`
 	DisplayName      string         `gorm:"size:100"`
 	Bio              string         `gorm:"size:500"`
@@ -20,11 +20,18 @@ type User struct {
 	AvatarURL        string         `gorm:"size:255"`
 	IsAdmin          bool           `gorm:"default:false"`
 	IsActive         bool           `gorm:"default:true"`
+	PendingApproval  bool           `gorm:"default:false;index"` // Signed up while registration needs approval; inactive until approved
+	InviteCodeID     *uuid.UUID     `gorm:"type:uuid"`            // The invite code signed up with
 	EmailVerified    bool           `gorm:"default:false"`
 	TwoFactorEnabled bool           `gorm:"default:false"`
 	TwoFactorSecret  string         `gorm:"size:32"`
 	IsSuspended      bool           `gorm:"default:false"`
+	SuspendedAt      *time.Time
+	SuspensionReason string         `gorm:"size:500"`
 	IsEmailVerified  bool           `gorm:"default:false"`
+	DeactivatedAt    *time.Time
+	KeepGistsPublic  bool           `gorm:"default:false"` // Public gists stay visible while deactivated
+	QuotaTier        string         `gorm:"size:32"`       // Assigned by an admin; empty follows role and account age
 	LastLoginAt      *time.Time
 	CreatedAt        time.Time
 	UpdatedAt        time.Time
@@ -69,6 +76,7 @@ type UserPreference struct {
 	EditorTheme           string    `gorm:"size:20;default:'dracula'"`
 	Timezone              string    `gorm:"size:50;default:'UTC'"`
 	DateFormat            string    `gorm:"size:20;default:'YYYY-MM-DD'"`
+	Newsletter            bool      `gorm:"not null;default:false"`
 	CreatedAt             time.Time
 	UpdatedAt             time.Time
 
@@ -134,6 +142,42 @@ type UserBlock struct {
 	Blocked User `gorm:"foreignKey:BlockedID;constraint:OnDelete:CASCADE"`
 }
 
+// IsDeactivated reports whether the user has deactivated their own account
+func (u *User) IsDeactivated() bool {
+	return u.DeactivatedAt != nil
+}
+
+// HideDeactivatedOwners is a query scope that excludes gists owned by users
+// who deactivated their account without keeping their gists public
+func HideDeactivatedOwners(db *gorm.DB) *gorm.DB {
+	return db.Where("gists.user_id IS NULL OR gists.user_id NOT IN (SELECT id FROM users WHERE deactivated_at IS NOT NULL AND keep_gists_public = ?)", false)
+}
+
+// UserTimezone returns the user's time zone preference, empty when the
+// user has none
+func UserTimezone(db *gorm.DB, userID uuid.UUID) (string, error) {
+	var prefs []UserPreference
+	if err := db.Select("timezone").Where("user_id = ?", userID).Limit(1).Find(&prefs).Error; err != nil {
+		return "", err
+	}
+	if len(prefs) == 0 {
+		return "", nil
+	}
+	return prefs[0].Timezone, nil
+}
+
+// SetUserTimezone saves the user's time zone preference
+func SetUserTimezone(db *gorm.DB, userID uuid.UUID, timezone string) error {
+	var count int64
+	if err := db.Model(&UserPreference{}).Where("user_id = ?", userID).Count(&count).Error; err != nil {
+		return err
+	}
+	if count == 0 {
+		return db.Create(&UserPreference{UserID: userID, Timezone: timezone}).Error
+	}
+	return db.Model(&UserPreference{}).Where("user_id = ?", userID).Update("timezone", timezone).Error
+}
+
 // BeforeCreate hooks for UUID generation
 func (u *User) BeforeCreate(tx *gorm.DB) error {
 	if u.ID == uuid.Nil {
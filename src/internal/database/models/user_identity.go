@@ -0,0 +1,34 @@
+package models
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// UserIdentity links a user to their account at an external identity
+// provider (GitHub, GitLab or an OpenID Connect provider). Subject is the
+// provider's stable ID for the account; Email is the address the provider
+// reported at the last sign in.
+type UserIdentity struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key"`
+	UserID      uuid.UUID `gorm:"type:uuid;index;not null"`
+	Provider    string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;size:50;not null"`
+	Subject     string    `gorm:"uniqueIndex:idx_user_identities_provider_subject;size:255;not null"`
+	Email       string    `gorm:"size:255"`
+	LastLoginAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+
+	// Relations
+	User *User `gorm:"constraint:OnDelete:CASCADE"`
+}
+
+// BeforeCreate hook
+func (i *UserIdentity) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
+	}
+	return nil
+}
@@ -9,16 +9,18 @@ import (
 
 // Webhook represents a webhook configuration
 type Webhook struct {
-	ID          uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
-	UserID      *uuid.UUID   `json:"user_id" gorm:"type:uuid;index"` // nil for system-wide webhooks
-	URL         string       `json:"url" gorm:"type:text;not null"`
-	Secret      string       `json:"-" gorm:"type:text"` // HMAC secret for verification
-	Events      string       `json:"events" gorm:"type:text"` // JSON array of subscribed events
-	IsActive    bool         `json:"is_active" gorm:"default:true"`
-	ContentType string       `json:"content_type" gorm:"default:'application/json'"`
-	CreatedAt   time.Time    `json:"created_at"`
-	UpdatedAt   time.Time    `json:"updated_at"`
-	DeletedAt   *time.Time   `json:"deleted_at,omitempty" gorm:"index"`
+	ID           uuid.UUID    `json:"id" gorm:"type:uuid;primary_key"`
+	UserID       *uuid.UUID   `json:"user_id" gorm:"type:uuid;index"` // nil for system-wide webhooks
+	GistID       *uuid.UUID   `json:"gist_id,omitempty" gorm:"type:uuid;index"` // Only receives events about this gist
+	URL          string       `json:"url" gorm:"type:text;not null"`
+	Secret       string       `json:"-" gorm:"type:text"` // HMAC secret for verification
+	Events       string       `json:"events" gorm:"type:text"` // JSON array of subscribed events
+	IsActive     bool         `json:"is_active" gorm:"default:true"`
+	ContentType  string       `json:"content_type" gorm:"default:'application/json'"`
+	DigestWindow int          `json:"digest_window" gorm:"default:0"` // Seconds to batch events into one delivery; 0 sends each event
+	CreatedAt    time.Time    `json:"created_at"`
+	UpdatedAt    time.Time    `json:"updated_at"`
+	DeletedAt    *time.Time   `json:"deleted_at,omitempty" gorm:"index"`
 
 	// Relations
 	User *User `gorm:"constraint:OnDelete:CASCADE"`
@@ -0,0 +1,23 @@
+package database
+
+import "gorm.io/gorm"
+
+// PendingMigrations returns the versions of the migrations MigrateDB would
+// apply, in order. A database that has never been migrated has nothing worth
+// keeping yet, so none are reported for it.
+func PendingMigrations(db *gorm.DB) ([]int, error) {
+	if !db.Migrator().HasTable("schema_migrations") {
+		return nil, nil
+	}
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+	var pending []int
+	for _, m := range migrations {
+		if !m.Applied {
+			pending = append(pending, m.Version)
+		}
+	}
+	return pending, nil
+}
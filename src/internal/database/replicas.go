@@ -0,0 +1,125 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/driver/postgres"
+	"gorm.io/gorm"
+	"gorm.io/plugin/dbresolver"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// replicaResolver names the resolver holding the read replicas. Only
+// statements that ask for it by name use it; the rest stay on the primary.
+const replicaResolver = "casgists:replicas"
+
+// ReadReplica returns db with its queries sent to a read replica when
+// database.replicas are configured, and to the primary otherwise. Replicas
+// lag behind the primary, so it is for lists and searches, not for reading
+// back what was just written. Statements in a transaction stay on the
+// primary. The returned DB can be shared like the one it was made from.
+func ReadReplica(db *gorm.DB) *gorm.DB {
+	return db.Clauses(dbresolver.Use(replicaResolver), dbresolver.Read).Session(&gorm.Session{})
+}
+
+// Replicas reports how many read replicas db sends queries to
+func Replicas(db *gorm.DB) int {
+	for _, plugin := range db.Config.Plugins {
+		resolver, ok := plugin.(*dbresolver.DBResolver)
+		if !ok {
+			continue
+		}
+		pools := 0
+		resolver.Call(func(gorm.ConnPool) error {
+			pools++
+			return nil
+		})
+		// The primary is the resolver's one source
+		return pools - 1
+	}
+	return 0
+}
+
+// useReplicas opens the database.replicas and registers them with
+// dbresolver for ReadReplica queries, sharing the primary's pool settings.
+// A replica that can't be reached is logged and left out, so the primary
+// alone keeps the server running.
+func useReplicas(db *gorm.DB, cfg *config.Config, dbType string) error {
+	dsns := replicaDSNs(cfg)
+	if len(dsns) == 0 {
+		return nil
+	}
+
+	// Replicas are opened here to check they can be reached, then handed
+	// to dbresolver as dialectors over the opened connections
+	var open func(string) gorm.Dialector
+	var reuse func(*sql.DB) gorm.Dialector
+	switch dbType {
+	case "postgres", "postgresql":
+		open = postgres.Open
+		reuse = func(conn *sql.DB) gorm.Dialector { return postgres.New(postgres.Config{Conn: conn}) }
+	case "mysql":
+		open = mysql.Open
+		reuse = func(conn *sql.DB) gorm.Dialector { return mysql.New(mysql.Config{Conn: conn}) }
+	default:
+		slog.Warn("Read replicas need PostgreSQL or MySQL, database.replicas is ignored", "type", dbType)
+		return nil
+	}
+
+	var replicas []gorm.Dialector
+	for i, dsn := range dsns {
+		replica, err := gorm.Open(open(dsn), &gorm.Config{Logger: db.Logger})
+		if err == nil {
+			var conn *sql.DB
+			if conn, err = replica.DB(); err == nil {
+				replicas = append(replicas, reuse(conn))
+				continue
+			}
+		}
+		slog.Warn("Read replica unavailable, leaving it out", "replica", i+1, "error", err)
+	}
+	if len(replicas) == 0 {
+		return nil
+	}
+
+	maxOpen, maxIdle := poolSize(cfg)
+	resolver := dbresolver.Register(dbresolver.Config{
+		Replicas: replicas,
+		Policy:   dbresolver.StrictRoundRobinPolicy(),
+	}, replicaResolver).
+		SetMaxOpenConns(maxOpen).
+		SetMaxIdleConns(maxIdle).
+		SetConnMaxLifetime(cfg.GetDuration("database.conn_max_lifetime")).
+		SetConnMaxIdleTime(cfg.GetDuration("database.conn_max_idle_time"))
+	if err := db.Use(resolver); err != nil {
+		return fmt.Errorf("failed to register read replicas: %w", err)
+	}
+	slog.Info("Sending list and search queries to read replicas", "replicas", len(replicas))
+	return nil
+}
+
+// replicaDSNs returns database.replicas, given as a list in the config file
+// or separated by commas in CASGISTS_DATABASE_REPLICAS. A DSN in the
+// key=value form has spaces, so a string is only split at commas.
+func replicaDSNs(cfg *config.Config) []string {
+	var values []string
+	switch value := cfg.Get("database.replicas").(type) {
+	case string:
+		values = strings.Split(value, ",")
+	default:
+		values = cfg.GetStringSlice("database.replicas")
+	}
+
+	var dsns []string
+	for _, dsn := range values {
+		if dsn = strings.TrimSpace(dsn); dsn != "" {
+			dsns = append(dsns, dsn)
+		}
+	}
+	return dsns
+}
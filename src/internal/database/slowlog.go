@@ -0,0 +1,96 @@
+package database
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/metrics"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// recentSlowQueries is how many slow statements SlowQueries keeps
+const recentSlowQueries = 20
+
+// SlowQuery is a statement that took longer than
+// database.slow_query_threshold
+type SlowQuery struct {
+	SQL      string    `json:"sql"`
+	Duration string    `json:"duration"`
+	Rows     int64     `json:"rows"`
+	At       time.Time `json:"at"`
+}
+
+// slowQueries keeps the most recent slow statements, newest first
+type slowQueries struct {
+	mu     sync.Mutex
+	recent []SlowQuery
+}
+
+func (s *slowQueries) add(query SlowQuery) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.recent = append([]SlowQuery{query}, s.recent...)
+	if len(s.recent) > recentSlowQueries {
+		s.recent = s.recent[:recentSlowQueries]
+	}
+}
+
+// slowQueryLogger logs statements slower than the threshold and counts them
+// in metrics.DBSlowQueries, on top of what the wrapped logger logs. Below
+// the Info level parameter values are left out of the SQL, so passwords and
+// tokens don't end up in the log; only Raw().Scan() statements, which GORM
+// records without asking the logger, keep them.
+type slowQueryLogger struct {
+	logger.Interface
+	level     logger.LogLevel
+	threshold time.Duration
+	log       *slowQueries
+}
+
+func newSlowQueryLogger(base logger.Interface, level logger.LogLevel, threshold time.Duration) slowQueryLogger {
+	return slowQueryLogger{Interface: base.LogMode(level), level: level, threshold: threshold, log: &slowQueries{}}
+}
+
+// LogMode implements logger.Interface; db.Debug() calls it
+func (l slowQueryLogger) LogMode(level logger.LogLevel) logger.Interface {
+	l.Interface = l.Interface.LogMode(level)
+	l.level = level
+	return l
+}
+
+// Trace implements logger.Interface
+func (l slowQueryLogger) Trace(ctx context.Context, begin time.Time, fc func() (string, int64), err error) {
+	l.Interface.Trace(ctx, begin, fc, err)
+
+	elapsed := time.Since(begin)
+	if l.threshold <= 0 || elapsed < l.threshold {
+		return
+	}
+	sql, rows := fc()
+	metrics.DBSlowQueries.WithLabelValues().Inc()
+	slog.Warn("Slow database query", "duration", elapsed.Round(time.Millisecond), "rows", rows, "sql", sql)
+	l.log.add(SlowQuery{SQL: sql, Duration: elapsed.Round(time.Millisecond).String(), Rows: rows, At: begin.UTC()})
+}
+
+// ParamsFilter implements gorm.ParamsFilter
+func (l slowQueryLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.level >= logger.Info {
+		return sql, params
+	}
+	return sql, nil
+}
+
+// SlowQueries returns the most recent statements slower than
+// database.slow_query_threshold, newest first
+func SlowQueries(db *gorm.DB) []SlowQuery {
+	l, ok := db.Logger.(slowQueryLogger)
+	if !ok {
+		return nil
+	}
+	l.log.mu.Lock()
+	defer l.log.mu.Unlock()
+	return append([]SlowQuery{}, l.log.recent...)
+}
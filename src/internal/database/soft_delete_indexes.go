@@ -0,0 +1,182 @@
+package database
+
+import (
+	"fmt"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+// liveUniqueIndex is a unique column of a soft-deletable table. The initial
+// schema declared these columns UNIQUE, so a deleted account or
+// organization kept its name and address forever and creating a new one
+// with the same value failed deep in the database instead of with a
+// validation error. They are only unique among rows that aren't deleted.
+type liveUniqueIndex struct {
+	table  string
+	column string
+	// constraint is the name Postgres gave the inline UNIQUE constraint
+	constraint string
+}
+
+// Index names match the uniqueIndex tags of the models, which build the same
+// indexes for databases created by AutoMigrate
+var liveUniqueIndexes = []liveUniqueIndex{
+	{table: "users", column: "username", constraint: "users_username_key"},
+	{table: "users", column: "email", constraint: "users_email_key"},
+	{table: "organizations", column: "name", constraint: "organizations_name_key"},
+}
+
+func (i liveUniqueIndex) name() string {
+	return "idx_" + i.table + "_" + i.column + "_live"
+}
+
+// migrateSoftDeleteUniqueIndexes replaces the UNIQUE constraints of the
+// initial schema with unique indexes covering only rows that aren't
+// soft-deleted. It is a no-op once the indexes exist.
+func migrateSoftDeleteUniqueIndexes(db *gorm.DB) error {
+	switch db.Dialector.Name() {
+	case "postgres":
+		return migrateLiveIndexesPostgres(db)
+	case "mysql":
+		return migrateLiveIndexesMySQL(db)
+	case "sqlite":
+		return migrateLiveIndexesSQLite(db)
+	}
+	return nil
+}
+
+func migrateLiveIndexesPostgres(db *gorm.DB) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		for _, index := range liveUniqueIndexes {
+			if err := tx.Exec(fmt.Sprintf("ALTER TABLE %s DROP CONSTRAINT IF EXISTS %s",
+				index.table, index.constraint)).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s(%s) WHERE deleted_at IS NULL",
+				index.name(), index.table, index.column)).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// MySQL has no partial indexes, but NULLs never collide in a unique index,
+// so the index is on an expression that is NULL for deleted rows
+func migrateLiveIndexesMySQL(db *gorm.DB) error {
+	for _, index := range liveUniqueIndexes {
+		var count int64
+		if err := db.Raw(`SELECT COUNT(*) FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`,
+			index.table, index.name()).Scan(&count).Error; err != nil {
+			return err
+		}
+		if count > 0 {
+			continue
+		}
+		// MySQL names an inline UNIQUE constraint after its column
+		var inline int64
+		if err := db.Raw(`SELECT COUNT(*) FROM information_schema.statistics
+			WHERE table_schema = DATABASE() AND table_name = ? AND index_name = ?`,
+			index.table, index.column).Scan(&inline).Error; err != nil {
+			return err
+		}
+		if inline > 0 {
+			if err := db.Exec(fmt.Sprintf("ALTER TABLE %s DROP INDEX %s", index.table, index.column)).Error; err != nil {
+				return err
+			}
+		}
+		if err := db.Exec(fmt.Sprintf("CREATE UNIQUE INDEX %s ON %s ((CASE WHEN deleted_at IS NULL THEN %s END))",
+			index.name(), index.table, index.column)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// SQLite can't drop a constraint, so a table with inline UNIQUE columns is
+// rebuilt without them, keeping its rows, indexes and triggers
+func migrateLiveIndexesSQLite(db *gorm.DB) error {
+	tables := map[string][]liveUniqueIndex{}
+	var order []string
+	for _, index := range liveUniqueIndexes {
+		if tables[index.table] == nil {
+			order = append(order, index.table)
+		}
+		tables[index.table] = append(tables[index.table], index)
+	}
+
+	// Foreign keys must be off while a table is dropped and recreated, and
+	// the pragma has no effect inside a transaction
+	var foreignKeys int
+	db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys)
+	if foreignKeys == 1 {
+		if err := db.Exec("PRAGMA foreign_keys = OFF").Error; err != nil {
+			return err
+		}
+		defer db.Exec("PRAGMA foreign_keys = ON")
+	}
+
+	for _, table := range order {
+		if err := db.Transaction(func(tx *gorm.DB) error {
+			return rebuildSQLiteTable(tx, table, tables[table])
+		}); err != nil {
+			return fmt.Errorf("failed to rebuild %s: %w", table, err)
+		}
+	}
+	return nil
+}
+
+func rebuildSQLiteTable(tx *gorm.DB, table string, indexes []liveUniqueIndex) error {
+	var createSQL string
+	if err := tx.Raw("SELECT sql FROM sqlite_master WHERE type = 'table' AND name = ?", table).
+		Scan(&createSQL).Error; err != nil {
+		return err
+	}
+	if createSQL == "" {
+		return nil
+	}
+
+	rebuilt := createSQL
+	for _, index := range indexes {
+		inline := regexp.MustCompile(`(?i)(\b` + index.column + `\s[^,]*?)\s+UNIQUE\b`)
+		rebuilt = inline.ReplaceAllString(rebuilt, "$1")
+	}
+
+	if rebuilt != createSQL {
+		// Indexes and triggers go with the table and are recreated after
+		var dependents []string
+		if err := tx.Raw("SELECT sql FROM sqlite_master WHERE tbl_name = ? AND type IN ('index', 'trigger') AND sql IS NOT NULL",
+			table).Scan(&dependents).Error; err != nil {
+			return err
+		}
+
+		// Triggers of other tables name the table, and would stop the
+		// rename while it is gone without the legacy behavior
+		temp := table + "_rebuild"
+		name := regexp.MustCompile(`(?i)^CREATE TABLE\s+("?)` + table + `("?)`)
+		statements := []string{
+			name.ReplaceAllString(rebuilt, "CREATE TABLE ${1}"+temp+"${2}"),
+			fmt.Sprintf("INSERT INTO %s SELECT * FROM %s", temp, table),
+			fmt.Sprintf("DROP TABLE %s", table),
+			"PRAGMA legacy_alter_table = ON",
+			fmt.Sprintf("ALTER TABLE %s RENAME TO %s", temp, table),
+			"PRAGMA legacy_alter_table = OFF",
+		}
+		statements = append(statements, dependents...)
+		for _, statement := range statements {
+			if err := tx.Exec(statement).Error; err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, index := range indexes {
+		if err := tx.Exec(fmt.Sprintf("CREATE UNIQUE INDEX IF NOT EXISTS %s ON %s(%s) WHERE deleted_at IS NULL",
+			index.name(), index.table, index.column)).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package database
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// legacySchema is the initial schema of the soft-deletable tables, with
+// inline UNIQUE columns, an index and a trigger
+var legacySchema = []string{
+	`CREATE TABLE users (
+		id TEXT PRIMARY KEY,
+		username VARCHAR(39) NOT NULL UNIQUE,
+		email VARCHAR(255) NOT NULL UNIQUE,
+		updated_at DATETIME,
+		deleted_at DATETIME
+	)`,
+	`CREATE INDEX idx_users_deleted_at ON users(deleted_at)`,
+	`CREATE TRIGGER users_touch AFTER UPDATE OF username ON users
+		BEGIN UPDATE users SET updated_at = CURRENT_TIMESTAMP WHERE id = NEW.id; END`,
+	`CREATE TABLE organizations (
+		id TEXT PRIMARY KEY,
+		name VARCHAR(39) NOT NULL UNIQUE,
+		deleted_at DATETIME
+	)`,
+	`INSERT INTO users (id, username, email, deleted_at) VALUES
+		('1', 'gopher', 'gopher@example.com', CURRENT_TIMESTAMP),
+		('2', 'alice', 'alice@example.com', NULL)`,
+	`INSERT INTO organizations (id, name, deleted_at) VALUES ('1', 'gophers', CURRENT_TIMESTAMP)`,
+}
+
+func setupLegacyDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	for _, statement := range legacySchema {
+		require.NoError(t, db.Exec(statement).Error)
+	}
+	return db
+}
+
+func TestMigrateSoftDeleteUniqueIndexes(t *testing.T) {
+	db := setupLegacyDB(t)
+
+	// The deleted account holds on to its name
+	assert.Error(t, db.Exec(`INSERT INTO users (id, username, email) VALUES ('3', 'gopher', 'new@example.com')`).Error)
+
+	require.NoError(t, migrateSoftDeleteUniqueIndexes(db))
+
+	// Rows, indexes and triggers survive the rebuild
+	var users int64
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM users").Scan(&users).Error)
+	assert.Equal(t, int64(2), users)
+	var names []string
+	require.NoError(t, db.Raw("SELECT name FROM sqlite_master WHERE tbl_name = 'users' AND type IN ('index', 'trigger') AND sql IS NOT NULL ORDER BY name").
+		Scan(&names).Error)
+	assert.Equal(t, []string{"idx_users_deleted_at", "idx_users_email_live", "idx_users_username_live", "users_touch"}, names)
+
+	// A deleted account's name and address can be taken again, a live
+	// one's can't
+	assert.NoError(t, db.Exec(`INSERT INTO users (id, username, email) VALUES ('3', 'gopher', 'gopher@example.com')`).Error)
+	assert.Error(t, db.Exec(`INSERT INTO users (id, username, email) VALUES ('4', 'alice', 'other@example.com')`).Error)
+	assert.Error(t, db.Exec(`INSERT INTO users (id, username, email) VALUES ('4', 'other', 'alice@example.com')`).Error)
+	assert.NoError(t, db.Exec(`INSERT INTO organizations (id, name) VALUES ('2', 'gophers')`).Error)
+	assert.Error(t, db.Exec(`INSERT INTO organizations (id, name) VALUES ('3', 'gophers')`).Error)
+
+	// Running it again changes nothing
+	var before string
+	require.NoError(t, db.Raw("SELECT sql FROM sqlite_master WHERE name = 'users'").Scan(&before).Error)
+	require.NoError(t, migrateSoftDeleteUniqueIndexes(db))
+	var after string
+	require.NoError(t, db.Raw("SELECT sql FROM sqlite_master WHERE name = 'users'").Scan(&after).Error)
+	assert.Equal(t, before, after)
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM users").Scan(&users).Error)
+	assert.Equal(t, int64(3), users)
+}
+
+func TestMigrateSoftDeleteUniqueIndexesKeepsForeignKeys(t *testing.T) {
+	db := setupLegacyDB(t)
+	require.NoError(t, db.Exec("PRAGMA foreign_keys = ON").Error)
+	require.NoError(t, db.Exec(`CREATE TABLE sessions (id TEXT PRIMARY KEY, user_id TEXT REFERENCES users(id))`).Error)
+	require.NoError(t, db.Exec(`INSERT INTO sessions (id, user_id) VALUES ('1', '2')`).Error)
+
+	require.NoError(t, migrateSoftDeleteUniqueIndexes(db))
+
+	var sessions int64
+	require.NoError(t, db.Raw("SELECT COUNT(*) FROM sessions").Scan(&sessions).Error)
+	assert.Equal(t, int64(1), sessions)
+	var foreignKeys int
+	require.NoError(t, db.Raw("PRAGMA foreign_keys").Scan(&foreignKeys).Error)
+	assert.Equal(t, 1, foreignKeys)
+}
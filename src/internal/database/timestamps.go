@@ -0,0 +1,69 @@
+package database
+
+import (
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// RegisterUTCTimestamps converts every time loaded from the database to
+// UTC. Depending on the driver, times come back in the session time zone
+// or with the offset they were written with, which made the same column
+// show different offsets from row to row in API responses.
+func RegisterUTCTimestamps(db *gorm.DB) error {
+	return db.Callback().Query().After("gorm:after_query").Register("casgists:utc_timestamps", utcTimestamps)
+}
+
+func utcTimestamps(db *gorm.DB) {
+	if db.Error != nil || db.Statement.Schema == nil {
+		return
+	}
+	fields := timeFields(db.Statement.Schema)
+	if len(fields) == 0 {
+		return
+	}
+
+	rv := reflect.Indirect(db.Statement.ReflectValue)
+	switch rv.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < rv.Len(); i++ {
+			if elem := reflect.Indirect(rv.Index(i)); elem.Kind() == reflect.Struct {
+				toUTC(db, fields, elem)
+			}
+		}
+	case reflect.Struct:
+		toUTC(db, fields, rv)
+	}
+}
+
+func timeFields(s *schema.Schema) []*schema.Field {
+	var fields []*schema.Field
+	for _, field := range s.Fields {
+		switch field.FieldType {
+		case reflect.TypeOf(time.Time{}), reflect.TypeOf(&time.Time{}):
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func toUTC(db *gorm.DB, fields []*schema.Field, rv reflect.Value) {
+	ctx := db.Statement.Context
+	for _, field := range fields {
+		value, zero := field.ValueOf(ctx, rv)
+		if zero {
+			continue
+		}
+		switch t := value.(type) {
+		case time.Time:
+			field.Set(ctx, rv, t.UTC())
+		case *time.Time:
+			if t != nil {
+				utc := t.UTC()
+				field.Set(ctx, rv, &utc)
+			}
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package database
+
+import (
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Migration files in migrations/ are named <version>_<name>[.<dialect>].up.sql
+// with an optional .down.sql that reverts them. A file for a dialect
+// (sqlite, postgres or mysql) replaces the plain file of the same version
+// on that database and is skipped on the others. Older files carry
+// "sqlite" in their name instead, which also limits them to SQLite.
+var migrationFilename = regexp.MustCompile(`^(\d{6})_(\w+?)(?:\.(sqlite|postgres|mysql))?\.(up|down)\.sql$`)
+
+// Migration is a versioned schema change
+type Migration struct {
+	Version int    `json:"version"`
+	Name    string `json:"name"`
+	Up      string `json:"-"` // "" when only the database knows the version
+	Down    string `json:"-"` // "" when it can't be reverted
+	Applied bool   `json:"applied"`
+}
+
+// Reversible reports whether the migration has a down file
+func (m Migration) Reversible() bool {
+	return m.Down != ""
+}
+
+// Migrations lists the migrations for the database's dialect in version
+// order, marking those schema_migrations records as applied. Versions the
+// database has that no file exists for, left by a newer release, are
+// listed as applied without files.
+func Migrations(db *gorm.DB) ([]Migration, error) {
+	entries, err := migrationsFS.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %w", err)
+	}
+
+	dialect := db.Dialector.Name()
+	byVersion := make(map[int]*Migration)
+	// Whether the files found for a version are specific to the dialect
+	specific := make(map[int]bool)
+	for _, entry := range entries {
+		match := migrationFilename.FindStringSubmatch(entry.Name())
+		if match == nil {
+			continue
+		}
+		var version int
+		fmt.Sscanf(match[1], "%d", &version)
+		name, fileDialect, direction := match[2], match[3], match[4]
+
+		if fileDialect != "" && fileDialect != dialect {
+			continue
+		}
+		if fileDialect == "" && strings.Contains(name, "sqlite") && dialect != "sqlite" {
+			continue
+		}
+		isSpecific := fileDialect != ""
+		if specific[version] && !isSpecific {
+			continue
+		}
+
+		m := byVersion[version]
+		if m == nil || (isSpecific && !specific[version]) {
+			m = &Migration{Version: version, Name: name}
+			byVersion[version] = m
+			specific[version] = isSpecific
+		}
+		if direction == "up" {
+			m.Up = entry.Name()
+		} else {
+			m.Down = entry.Name()
+		}
+	}
+
+	applied, err := appliedVersions(db)
+	if err != nil {
+		return nil, err
+	}
+	for version := range applied {
+		if byVersion[version] == nil {
+			byVersion[version] = &Migration{Version: version}
+		}
+		byVersion[version].Applied = true
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		// A down file without its up file is left over from a rename
+		if m.Up == "" && !m.Applied {
+			continue
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// appliedVersions reads schema_migrations, which is empty before the first
+// migration
+func appliedVersions(db *gorm.DB) (map[int]bool, error) {
+	applied := make(map[int]bool)
+	if !db.Migrator().HasTable("schema_migrations") {
+		return applied, nil
+	}
+	var versions []int
+	if err := db.Raw("SELECT version FROM schema_migrations").Scan(&versions).Error; err != nil {
+		return nil, fmt.Errorf("failed to read applied migrations: %w", err)
+	}
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+// MigrateUp applies up to steps pending migrations in version order, all of
+// them when steps is 0, and returns those applied. Each runs in a
+// transaction with its schema_migrations record, so on SQLite and
+// PostgreSQL a failed migration leaves nothing behind; MySQL commits
+// schema changes as it goes.
+func MigrateUp(db *gorm.DB, steps int) ([]Migration, error) {
+	if err := db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`).Error; err != nil {
+		return nil, fmt.Errorf("failed to create migrations table: %w", err)
+	}
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, m := range migrations {
+		if m.Applied || m.Up == "" {
+			continue
+		}
+		if steps > 0 && len(done) == steps {
+			break
+		}
+		slog.Info("Applying migration", "version", m.Version, "filename", m.Up)
+		if err := runMigration(db, m.Up, func(tx *gorm.DB) error {
+			return tx.Exec("INSERT INTO schema_migrations (version) VALUES (?)", m.Version).Error
+		}); err != nil {
+			return done, fmt.Errorf("migration %d failed: %w", m.Version, err)
+		}
+		m.Applied = true
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// MigrateDown reverts the last steps applied migrations, newest first, and
+// returns those reverted. It stops before a migration that has no down
+// file.
+func MigrateDown(db *gorm.DB, steps int) ([]Migration, error) {
+	if steps <= 0 {
+		return nil, fmt.Errorf("steps must be greater than 0")
+	}
+	migrations, err := Migrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		m := migrations[i]
+		if !m.Applied {
+			continue
+		}
+		if m.Up == "" {
+			return done, fmt.Errorf("migration %d was applied by a newer release, which must revert it", m.Version)
+		}
+		if !m.Reversible() {
+			return done, fmt.Errorf("migration %d (%s) can't be reverted", m.Version, m.Name)
+		}
+		slog.Info("Reverting migration", "version", m.Version, "filename", m.Down)
+		if err := runMigration(db, m.Down, func(tx *gorm.DB) error {
+			return tx.Exec("DELETE FROM schema_migrations WHERE version = ?", m.Version).Error
+		}); err != nil {
+			return done, fmt.Errorf("reverting migration %d failed: %w", m.Version, err)
+		}
+		m.Applied = false
+		done = append(done, m)
+	}
+	return done, nil
+}
+
+// runMigration executes a migration file and records it in one transaction
+func runMigration(db *gorm.DB, filename string, record func(tx *gorm.DB) error) error {
+	content, err := fs.ReadFile(migrationsFS, path.Join("migrations", filename))
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filename, err)
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := executeStatements(tx, string(content)); err != nil {
+			return err
+		}
+		return record(tx)
+	})
+}
+
+// MigrateSchema applies the pending migrations and the index changes
+// MigrateDB makes, without the default data MigrateDB adds, for a database
+// rows are about to be copied into
+func MigrateSchema(db *gorm.DB) error {
+	if _, err := MigrateUp(db, 0); err != nil {
+		return err
+	}
+	if err := migrateSoftDeleteUniqueIndexes(db); err != nil {
+		return fmt.Errorf("failed to migrate unique indexes: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,172 @@
+package dbcopy
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// kind is how values of a target column are converted
+type kind int
+
+const (
+	kindText kind = iota
+	kindBool
+	kindInt
+	kindFloat
+	kindTime
+	kindBinary
+)
+
+// kindOf classifies a column by its database type name
+func kindOf(databaseType string) kind {
+	t := strings.ToLower(databaseType)
+	switch {
+	case strings.Contains(t, "bool"):
+		return kindBool
+	case strings.Contains(t, "timestamp"), strings.Contains(t, "datetime"), t == "date":
+		return kindTime
+	case strings.Contains(t, "bytea"), strings.Contains(t, "blob"), strings.Contains(t, "binary"):
+		return kindBinary
+	case strings.Contains(t, "int"), strings.Contains(t, "serial"):
+		return kindInt
+	case strings.Contains(t, "real"), strings.Contains(t, "float"), strings.Contains(t, "double"),
+		strings.Contains(t, "numeric"), strings.Contains(t, "decimal"):
+		return kindFloat
+	}
+	return kindText
+}
+
+// timeLayouts are the ways SQLite databases written by different drivers
+// hold timestamps as text
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05.999999999-07:00",
+	"2006-01-02 15:04:05.999999999Z07:00",
+	"2006-01-02 15:04:05.999999999",
+	"2006-01-02T15:04:05.999999999",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+// convert turns a value read from either database into what the target
+// column takes. SQLite keeps booleans as integers and timestamps as text;
+// UUIDs are text everywhere but may be read as 16 bytes. Timestamps are
+// kept to the microsecond, which is what PostgreSQL stores.
+func convert(value interface{}, k kind) (interface{}, error) {
+	if value == nil {
+		return nil, nil
+	}
+	if uuid, ok := value.([16]byte); ok {
+		value = formatUUID(uuid)
+	}
+
+	switch k {
+	case kindBool:
+		switch v := value.(type) {
+		case bool:
+			return v, nil
+		case int64:
+			return v != 0, nil
+		case float64:
+			return v != 0, nil
+		case []byte:
+			return strconv.ParseBool(string(v))
+		case string:
+			return strconv.ParseBool(v)
+		}
+	case kindTime:
+		switch v := value.(type) {
+		case time.Time:
+			return v.UTC().Truncate(time.Microsecond), nil
+		case int64:
+			return time.Unix(v, 0).UTC(), nil
+		case []byte:
+			return parseTime(string(v))
+		case string:
+			return parseTime(v)
+		}
+	case kindBinary:
+		switch v := value.(type) {
+		case string:
+			return []byte(v), nil
+		}
+	case kindInt:
+		switch v := value.(type) {
+		case bool:
+			if v {
+				return int64(1), nil
+			}
+			return int64(0), nil
+		case float64:
+			if v == math.Trunc(v) {
+				return int64(v), nil
+			}
+		case []byte:
+			if n, err := strconv.ParseInt(string(v), 10, 64); err == nil {
+				return n, nil
+			}
+		case string:
+			if n, err := strconv.ParseInt(v, 10, 64); err == nil {
+				return n, nil
+			}
+		}
+	case kindFloat:
+		switch v := value.(type) {
+		case int64:
+			return float64(v), nil
+		case []byte:
+			if f, err := strconv.ParseFloat(string(v), 64); err == nil {
+				return f, nil
+			}
+		case string:
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				return f, nil
+			}
+		}
+	case kindText:
+		return normalize(value), nil
+	}
+	return value, nil
+}
+
+func parseTime(value string) (interface{}, error) {
+	value = strings.TrimSpace(value)
+	if value == "" {
+		return nil, nil
+	}
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t.UTC().Truncate(time.Microsecond), nil
+		}
+	}
+	return nil, fmt.Errorf("%q is not a timestamp", value)
+}
+
+// normalize formats a converted value the same way whichever database it
+// came from, for text columns and checksums
+func normalize(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return "\x00"
+	case string:
+		return v
+	case []byte:
+		return string(v)
+	case bool:
+		return strconv.FormatBool(v)
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case time.Time:
+		return v.UTC().Format(time.RFC3339Nano)
+	}
+	return fmt.Sprint(value)
+}
+
+func formatUUID(b [16]byte) string {
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,345 @@
+// Package dbcopy copies a CasGists database to an empty database of another
+// kind, such as from SQLite to PostgreSQL. The target gets its schema from
+// the migrations; rows are copied table by table with their values
+// converted to the target's column types, and every table is verified by
+// row count and checksum before the copy is committed.
+package dbcopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/database"
+	"gorm.io/gorm"
+)
+
+// maxParams stays under the bind parameter limits of SQLite (32766) and
+// PostgreSQL (65535)
+const maxParams = 30000
+
+// ErrTargetNotEmpty is returned when the target database already has rows
+var ErrTargetNotEmpty = errors.New("target database is not empty")
+
+// Options tune a copy
+type Options struct {
+	// DryRun plans the copy and checks both databases without writing
+	DryRun bool
+	// BatchSize is the number of rows inserted per statement
+	BatchSize int
+	// Progress is called after each batch, if set
+	Progress func(table string, copied, total int64)
+}
+
+// Table is the outcome of copying one table
+type Table struct {
+	Name     string `json:"name"`
+	Rows     int64  `json:"rows"`
+	Copied   int64  `json:"copied"`
+	Checksum string `json:"checksum,omitempty"`
+	Verified bool   `json:"verified"`
+}
+
+// Report describes a copy, or the plan of a dry run
+type Report struct {
+	Source  string   `json:"source"`
+	Target  string   `json:"target"`
+	DryRun  bool     `json:"dry_run"`
+	Tables  []Table  `json:"tables"`  // In the order they are copied
+	Skipped []string `json:"skipped"` // Tables that aren't part of the schema, such as search indexes
+	Rows    int64    `json:"rows"`
+}
+
+// Copy copies every table of source to target. The source must have all
+// migrations applied and the target must hold no rows; it is migrated
+// first. The copy runs in one transaction, so a failed or unverified copy
+// leaves the target as it was after migrating. Nothing may write to the
+// source meanwhile.
+func Copy(ctx context.Context, source, target *gorm.DB, opts Options) (*Report, error) {
+	if opts.BatchSize <= 0 {
+		opts.BatchSize = 500
+	}
+	report := &Report{
+		Source:  source.Dialector.Name(),
+		Target:  target.Dialector.Name(),
+		DryRun:  opts.DryRun,
+		Tables:  []Table{},
+		Skipped: []string{},
+	}
+
+	if err := checkMigrated(source); err != nil {
+		return nil, err
+	}
+	tables, err := copyOrder(source)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkEmpty(target, tables); err != nil {
+		return nil, err
+	}
+
+	if opts.DryRun {
+		for _, name := range tables {
+			rows, err := count(source, name)
+			if err != nil {
+				return nil, err
+			}
+			report.Tables = append(report.Tables, Table{Name: name, Rows: rows})
+			report.Rows += rows
+		}
+		return report, nil
+	}
+
+	if err := database.MigrateSchema(target); err != nil {
+		return nil, fmt.Errorf("failed to create the schema in the target database: %w", err)
+	}
+	targetTables, err := target.Migrator().GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list target tables: %w", err)
+	}
+	inTarget := make(map[string]bool, len(targetTables))
+	for _, name := range targetTables {
+		inTarget[name] = true
+	}
+	var copied []string
+	for _, name := range tables {
+		if inTarget[name] {
+			copied = append(copied, name)
+		} else {
+			report.Skipped = append(report.Skipped, name)
+		}
+	}
+
+	err = target.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		// Rows are inserted parent tables first, but a table referring to
+		// itself, or two referring to each other, can't be ordered
+		restore := disableForeignKeys(conn)
+		defer restore()
+
+		return conn.Transaction(func(tx *gorm.DB) error {
+			deferForeignKeys(tx)
+			for _, name := range copied {
+				table, err := copyTable(ctx, source, tx, name, opts)
+				if err != nil {
+					return err
+				}
+				report.Tables = append(report.Tables, *table)
+				report.Rows += table.Copied
+			}
+			return resetSequences(tx, copied)
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// checkMigrated makes sure the source has the schema of this release
+func checkMigrated(db *gorm.DB) error {
+	if !db.Migrator().HasTable("schema_migrations") {
+		return errors.New("the source database has no CasGists schema")
+	}
+	migrations, err := database.Migrations(db)
+	if err != nil {
+		return err
+	}
+	for _, m := range migrations {
+		if !m.Applied {
+			return fmt.Errorf("the source database has pending migrations, starting with %06d_%s; run \"casgists migrate up\" first", m.Version, m.Name)
+		}
+		if m.Up == "" {
+			return fmt.Errorf("the source database was migrated by a newer release (migration %06d); copy it with that release", m.Version)
+		}
+	}
+	return nil
+}
+
+// checkEmpty refuses a target that holds rows in any of the tables
+func checkEmpty(db *gorm.DB, tables []string) error {
+	for _, name := range tables {
+		if !db.Migrator().HasTable(name) {
+			continue
+		}
+		rows, err := count(db, name)
+		if err != nil {
+			return err
+		}
+		if rows > 0 {
+			return fmt.Errorf("%w: %s has %d rows", ErrTargetNotEmpty, name, rows)
+		}
+	}
+	return nil
+}
+
+// copyOrder lists the tables of the source that hold data, parents before
+// the tables that refer to them
+func copyOrder(db *gorm.DB) ([]string, error) {
+	all, err := db.Migrator().GetTables()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list source tables: %w", err)
+	}
+	var tables []string
+	for _, name := range all {
+		if !internalTable(name) {
+			tables = append(tables, name)
+		}
+	}
+	sort.Strings(tables)
+
+	parents := make(map[string][]string, len(tables))
+	for _, name := range tables {
+		refs, err := foreignKeys(db, name)
+		if err != nil {
+			return nil, err
+		}
+		parents[name] = refs
+	}
+	return sortByDependencies(tables, parents), nil
+}
+
+// internalTable reports tables that aren't copied: the migration records,
+// which the target has its own of, and the SQLite full-text index, which
+// triggers fill on the target
+func internalTable(name string) bool {
+	return name == "schema_migrations" || strings.HasPrefix(name, "sqlite_") || strings.Contains(name, "_fts")
+}
+
+// sortByDependencies orders tables so each comes after the tables it refers
+// to. Tables in a cycle keep their alphabetical order at the end.
+func sortByDependencies(tables []string, parents map[string][]string) []string {
+	known := make(map[string]bool, len(tables))
+	for _, name := range tables {
+		known[name] = true
+	}
+	done := make(map[string]bool, len(tables))
+	ordered := make([]string, 0, len(tables))
+	for len(ordered) < len(tables) {
+		progressed := false
+		for _, name := range tables {
+			if done[name] {
+				continue
+			}
+			ready := true
+			for _, parent := range parents[name] {
+				if parent != name && known[parent] && !done[parent] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				done[name] = true
+				ordered = append(ordered, name)
+				progressed = true
+			}
+		}
+		if !progressed {
+			for _, name := range tables {
+				if !done[name] {
+					done[name] = true
+					ordered = append(ordered, name)
+				}
+			}
+		}
+	}
+	return ordered
+}
+
+// foreignKeys returns the tables a table refers to
+func foreignKeys(db *gorm.DB, table string) ([]string, error) {
+	var refs []string
+	var err error
+	switch db.Dialector.Name() {
+	case "sqlite":
+		err = db.Raw("SELECT DISTINCT \"table\" FROM pragma_foreign_key_list(?)", table).Scan(&refs).Error
+	case "postgres":
+		err = db.Raw(`SELECT DISTINCT ccu.table_name
+			FROM information_schema.table_constraints tc
+			JOIN information_schema.constraint_column_usage ccu
+				ON tc.constraint_name = ccu.constraint_name AND tc.table_schema = ccu.table_schema
+			WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = ?`, table).Scan(&refs).Error
+	case "mysql":
+		err = db.Raw(`SELECT DISTINCT referenced_table_name FROM information_schema.key_column_usage
+			WHERE table_schema = DATABASE() AND table_name = ? AND referenced_table_name IS NOT NULL`, table).Scan(&refs).Error
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the foreign keys of %s: %w", table, err)
+	}
+	return refs, nil
+}
+
+// disableForeignKeys turns foreign key checks off for the connection on
+// the databases that allow it outside a transaction, and returns what
+// turns them back on
+func disableForeignKeys(conn *gorm.DB) func() {
+	switch conn.Dialector.Name() {
+	case "sqlite":
+		var enabled int
+		conn.Raw("PRAGMA foreign_keys").Scan(&enabled)
+		conn.Exec("PRAGMA foreign_keys = OFF")
+		return func() {
+			if enabled == 1 {
+				conn.Exec("PRAGMA foreign_keys = ON")
+			}
+		}
+	case "mysql":
+		conn.Exec("SET FOREIGN_KEY_CHECKS = 0")
+		return func() { conn.Exec("SET FOREIGN_KEY_CHECKS = 1") }
+	}
+	return func() {}
+}
+
+// deferForeignKeys turns PostgreSQL's foreign key triggers off for the
+// transaction. Only a superuser may; for others the tables are still copied
+// parents first.
+func deferForeignKeys(tx *gorm.DB) {
+	if tx.Dialector.Name() != "postgres" {
+		return
+	}
+	// A failed statement would abort the transaction
+	tx.SavePoint("foreign_keys")
+	if err := tx.Exec("SET LOCAL session_replication_role = replica").Error; err != nil {
+		tx.RollbackTo("foreign_keys")
+		slog.Warn("Foreign key checks stay on while copying", "error", err)
+	}
+}
+
+// resetSequences moves PostgreSQL sequences past the copied ids
+func resetSequences(tx *gorm.DB, tables []string) error {
+	if tx.Dialector.Name() != "postgres" {
+		return nil
+	}
+	for _, table := range tables {
+		var columns []string
+		if err := tx.Raw(`SELECT column_name FROM information_schema.columns
+			WHERE table_schema = current_schema() AND table_name = ? AND column_default LIKE 'nextval(%'`, table).Scan(&columns).Error; err != nil {
+			return fmt.Errorf("failed to read the sequences of %s: %w", table, err)
+		}
+		for _, column := range columns {
+			sql := fmt.Sprintf("SELECT setval(pg_get_serial_sequence(?, ?), COALESCE(MAX(%s), 1), MAX(%s) IS NOT NULL) FROM %s",
+				quote(tx, column), quote(tx, column), quote(tx, table))
+			if err := tx.Exec(sql, table, column).Error; err != nil {
+				return fmt.Errorf("failed to reset the sequence of %s.%s: %w", table, column, err)
+			}
+		}
+	}
+	return nil
+}
+
+func count(db *gorm.DB, table string) (int64, error) {
+	var rows int64
+	if err := db.Table(table).Count(&rows).Error; err != nil {
+		return 0, fmt.Errorf("failed to count the rows of %s: %w", table, err)
+	}
+	return rows, nil
+}
+
+// quote quotes an identifier the way the database expects
+func quote(db *gorm.DB, name string) string {
+	var b strings.Builder
+	db.Dialector.QuoteTo(&b, name)
+	return b.String()
+}
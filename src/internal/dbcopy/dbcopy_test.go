@@ -0,0 +1,182 @@
+package dbcopy
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func openDB(t *testing.T, name string) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), name)), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	return db
+}
+
+// sourceDB is a migrated database with a user, an admin and a gist forked
+// from another, which refers to its own table
+func sourceDB(t *testing.T) *gorm.DB {
+	db := openDB(t, "source.db")
+	require.NoError(t, database.MigrateDB(db))
+
+	alice := &models.User{ID: uuid.New(), Username: "alice", Email: "alice@example.com", PasswordHash: "x", IsAdmin: true}
+	bob := &models.User{ID: uuid.New(), Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	require.NoError(t, db.Create(alice).Error)
+	require.NoError(t, db.Create(bob).Error)
+
+	original := &models.Gist{Title: "Original", Visibility: models.VisibilityPublic, UserID: &alice.ID, GitRepoPath: "a",
+		Files: []models.GistFile{{Filename: "main.go", Content: "package main\n"}}}
+	require.NoError(t, db.Create(original).Error)
+	fork := &models.Gist{Title: "Fork", Visibility: models.VisibilityPublic, UserID: &bob.ID, ForkedFromID: &original.ID, GitRepoPath: "b"}
+	require.NoError(t, db.Create(fork).Error)
+	return db
+}
+
+func TestCopy(t *testing.T) {
+	source := sourceDB(t)
+	target := openDB(t, "target.db")
+
+	report, err := Copy(context.Background(), source, target, Options{BatchSize: 1})
+	require.NoError(t, err)
+	assert.False(t, report.DryRun)
+	for _, table := range report.Tables {
+		assert.True(t, table.Verified, table.Name)
+		assert.Equal(t, table.Rows, table.Copied, table.Name)
+	}
+
+	var users []models.User
+	require.NoError(t, target.Order("username").Find(&users).Error)
+	require.Len(t, users, 2)
+	assert.True(t, users[0].IsAdmin)
+	assert.False(t, users[1].IsAdmin)
+	assert.WithinDuration(t, time.Now(), users[0].CreatedAt, time.Minute)
+
+	var fork models.Gist
+	require.NoError(t, target.Where("title = ?", "Fork").First(&fork).Error)
+	require.NotNil(t, fork.ForkedFromID)
+	var files int64
+	target.Model(&models.GistFile{}).Count(&files)
+	assert.Equal(t, int64(1), files)
+}
+
+func TestCopyOrdersParentsFirst(t *testing.T) {
+	report, err := Copy(context.Background(), sourceDB(t), openDB(t, "target.db"), Options{DryRun: true})
+	require.NoError(t, err)
+
+	position := map[string]int{}
+	for i, table := range report.Tables {
+		position[table.Name] = i
+	}
+	assert.Less(t, position["users"], position["gists"])
+	assert.Less(t, position["gists"], position["gist_files"])
+	assert.NotContains(t, position, "schema_migrations")
+	assert.NotContains(t, position, "gists_fts")
+}
+
+func TestCopyDryRun(t *testing.T) {
+	target := openDB(t, "target.db")
+
+	report, err := Copy(context.Background(), sourceDB(t), target, Options{DryRun: true})
+	require.NoError(t, err)
+	assert.True(t, report.DryRun)
+	assert.NotZero(t, report.Rows)
+
+	tables, err := target.Migrator().GetTables()
+	require.NoError(t, err)
+	assert.Empty(t, tables)
+}
+
+func TestCopyRefusesTargetWithData(t *testing.T) {
+	target := sourceDB(t)
+
+	_, err := Copy(context.Background(), sourceDB(t), target, Options{})
+	assert.ErrorIs(t, err, ErrTargetNotEmpty)
+}
+
+func TestCopyRefusesPendingMigrations(t *testing.T) {
+	source := sourceDB(t)
+	require.NoError(t, source.Exec("DELETE FROM schema_migrations WHERE version = (SELECT MAX(version) FROM schema_migrations)").Error)
+
+	_, err := Copy(context.Background(), source, openDB(t, "target.db"), Options{})
+	assert.ErrorContains(t, err, "pending migrations")
+}
+
+func TestSortByDependencies(t *testing.T) {
+	ordered := sortByDependencies(
+		[]string{"comments", "gists", "loop_a", "loop_b", "users"},
+		map[string][]string{
+			"comments": {"gists", "users", "comments"},
+			"gists":    {"users", "gists"},
+			"loop_a":   {"loop_b"},
+			"loop_b":   {"loop_a"},
+		},
+	)
+	assert.Equal(t, []string{"users", "gists", "comments", "loop_a", "loop_b"}, ordered)
+}
+
+func TestConvert(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		kind  kind
+		want  interface{}
+	}{
+		{int64(1), kindBool, true},
+		{int64(0), kindBool, false},
+		{"t", kindBool, true},
+		{"2026-10-17 09:30:00.123456789+02:00", kindTime, time.Date(2026, 10, 17, 7, 30, 0, 123456000, time.UTC)},
+		{"2026-10-17 09:30:00", kindTime, time.Date(2026, 10, 17, 9, 30, 0, 0, time.UTC)},
+		{[16]byte{0x3f, 0x0c, 0x6a, 0x2e, 0x8d, 0x1b, 0x4f, 0x5a, 0x9c, 0x7e, 0x2b, 0x4d, 0x6f, 0x8a, 0x0c, 0x1e}, kindText, "3f0c6a2e-8d1b-4f5a-9c7e-2b4d6f8a0c1e"},
+		{[]byte("text"), kindText, "text"},
+		{true, kindInt, int64(1)},
+		{"blob", kindBinary, []byte("blob")},
+		{nil, kindTime, nil},
+	}
+	for _, tt := range tests {
+		got, err := convert(tt.value, tt.kind)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, got, "%v", tt.value)
+	}
+
+	_, err := convert("yesterday", kindTime)
+	assert.Error(t, err)
+}
+
+func TestKindOf(t *testing.T) {
+	assert.Equal(t, kindBool, kindOf("BOOLEAN"))
+	assert.Equal(t, kindBool, kindOf("bool"))
+	assert.Equal(t, kindTime, kindOf("TIMESTAMPTZ"))
+	assert.Equal(t, kindTime, kindOf("datetime"))
+	assert.Equal(t, kindInt, kindOf("INT8"))
+	assert.Equal(t, kindBinary, kindOf("BYTEA"))
+	assert.Equal(t, kindText, kindOf("VARCHAR"))
+	assert.Equal(t, kindText, kindOf("UUID"))
+}
+
+func TestCopyKeepsDeletedAccounts(t *testing.T) {
+	source := sourceDB(t)
+	require.NoError(t, source.Where("username = ?", "bob").Delete(&models.User{}).Error)
+	again := &models.User{ID: uuid.New(), Username: "bob", Email: "bob@example.com", PasswordHash: "x"}
+	require.NoError(t, source.Create(again).Error)
+	target := openDB(t, "target.db")
+
+	_, err := Copy(context.Background(), source, target, Options{})
+	require.NoError(t, err)
+
+	var users int64
+	target.Unscoped().Model(&models.User{}).Where("username = ?", "bob").Count(&users)
+	assert.Equal(t, int64(2), users)
+}
@@ -0,0 +1,180 @@
+package dbcopy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// copyTable copies the rows of one table in batches and verifies them. Only
+// the columns both databases have are copied: columns the target lacks,
+// such as PostgreSQL's search vector, are rebuilt by the server.
+func copyTable(ctx context.Context, source, tx *gorm.DB, name string, opts Options) (*Table, error) {
+	columns, kinds, err := sharedColumns(source, tx, name)
+	if err != nil {
+		return nil, err
+	}
+	table := &Table{Name: name}
+	if table.Rows, err = count(source, name); err != nil {
+		return nil, err
+	}
+	if len(columns) == 0 {
+		table.Verified = table.Rows == 0
+		return table, nil
+	}
+
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = quote(tx, column)
+	}
+	selectSQL := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), quote(source, name))
+	insertSQL := fmt.Sprintf("INSERT INTO %s (%s) VALUES ", quote(tx, name), strings.Join(quoted, ", "))
+	placeholders := "(" + strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ") + ")"
+	batchSize := opts.BatchSize
+	if batchSize*len(columns) > maxParams {
+		batchSize = maxParams / len(columns)
+	}
+
+	var sent checksum
+	batch := make([]interface{}, 0, batchSize*len(columns))
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		rows := len(batch) / len(columns)
+		values := strings.TrimSuffix(strings.Repeat(placeholders+", ", rows), ", ")
+		if err := tx.Exec(insertSQL+values, batch...).Error; err != nil {
+			return fmt.Errorf("failed to copy rows of %s: %w", name, err)
+		}
+		table.Copied += int64(rows)
+		batch = batch[:0]
+		if opts.Progress != nil {
+			opts.Progress(name, table.Copied, table.Rows)
+		}
+		return nil
+	}
+
+	err = scanRows(source.WithContext(ctx), selectSQL, name, columns, kinds, func(row []interface{}) error {
+		sent.add(row)
+		batch = append(batch, row...)
+		if len(batch) >= batchSize*len(columns) {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Read the rows back the way the source's were read
+	var received checksum
+	var found int64
+	targetSQL := fmt.Sprintf("SELECT %s FROM %s", strings.Join(quoted, ", "), quote(tx, name))
+	if err := scanRows(tx, targetSQL, name, columns, kinds, func(row []interface{}) error {
+		received.add(row)
+		found++
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	if found != table.Copied {
+		return nil, fmt.Errorf("%s: copied %d rows, but the target has %d", name, table.Copied, found)
+	}
+	if received != sent {
+		return nil, fmt.Errorf("%s: the copied rows don't match the source's checksum", name)
+	}
+	if table.Copied > 0 {
+		table.Checksum = sent.String()
+	}
+	table.Verified = true
+	return table, nil
+}
+
+// sharedColumns returns the columns of a table in both databases, in the
+// source's order, with how to convert their values for the target
+func sharedColumns(source, target *gorm.DB, table string) ([]string, []kind, error) {
+	targetTypes, err := target.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the columns of %s in the target: %w", table, err)
+	}
+	kinds := make(map[string]kind, len(targetTypes))
+	for _, column := range targetTypes {
+		kinds[column.Name()] = kindOf(column.DatabaseTypeName())
+	}
+
+	sourceTypes, err := source.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read the columns of %s in the source: %w", table, err)
+	}
+	var columns []string
+	var columnKinds []kind
+	for _, column := range sourceTypes {
+		if k, ok := kinds[column.Name()]; ok {
+			columns = append(columns, column.Name())
+			columnKinds = append(columnKinds, k)
+		}
+	}
+	return columns, columnKinds, nil
+}
+
+// scanRows runs a query and passes each row, converted for the target, to fn
+func scanRows(db *gorm.DB, query, table string, columns []string, kinds []kind, fn func([]interface{}) error) error {
+	rows, err := db.Raw(query).Rows()
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	defer rows.Close()
+
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for i := range values {
+		pointers[i] = &values[i]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return fmt.Errorf("failed to read %s: %w", table, err)
+		}
+		row := make([]interface{}, len(columns))
+		for i, value := range values {
+			converted, err := convert(value, kinds[i])
+			if err != nil {
+				return fmt.Errorf("%s.%s: %w", table, columns[i], err)
+			}
+			row[i] = converted
+		}
+		if err := fn(row); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to read %s: %w", table, err)
+	}
+	return nil
+}
+
+// checksum adds up the SHA-256 of every row, so it doesn't depend on the
+// order rows come back in, which differs between databases
+type checksum [4]uint64
+
+func (c *checksum) add(row []interface{}) {
+	h := sha256.New()
+	for _, value := range row {
+		h.Write([]byte(normalize(value)))
+		h.Write([]byte{0x1f})
+	}
+	sum := h.Sum(nil)
+	for i := range c {
+		c[i] += binary.BigEndian.Uint64(sum[i*8:])
+	}
+}
+
+func (c checksum) String() string {
+	return fmt.Sprintf("%016x%016x%016x%016x", c[0], c[1], c[2], c[3])
+}
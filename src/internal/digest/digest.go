@@ -0,0 +1,300 @@
+// Package digest sends the weekly digest email: each opted-in user's
+// stats for the week, their most popular gists and the activity held back
+// for the digest.
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/cron"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+const (
+	// week is the period a digest covers
+	week = 7 * 24 * time.Hour
+	// minGap is how soon after one digest a user can get the next, a
+	// little under a week so a schedule running late still sends
+	minGap = 6 * 24 * time.Hour
+	// popularGists is how many of the user's gists a digest lists
+	popularGists = 5
+)
+
+// Sender queues a digest email; *email.Service implements it
+type Sender interface {
+	SendWeeklyDigest(userID uuid.UUID, toEmail, toName string, data email.EmailData) error
+	PendingDigestItems(userID uuid.UUID) ([]email.EmailDigestItem, error)
+}
+
+// Stats are a user's numbers for a digest, totals and the week's share
+type Stats struct {
+	TotalGists     int64
+	NewGists       int64
+	TotalStars     int64
+	NewStars       int64
+	TotalForks     int64
+	NewForks       int64
+	TotalFollowers int64
+	NewFollowers   int64
+}
+
+// Quiet reports whether nothing happened during the week
+func (s Stats) Quiet() bool {
+	return s.NewGists == 0 && s.NewStars == 0 && s.NewForks == 0 && s.NewFollowers == 0
+}
+
+// PopularGist is a gist listed in the digest
+type PopularGist struct {
+	Title string
+	URL   string
+	Stars int
+	Forks int
+}
+
+// Job sends the weekly digest on email.digest.schedule to every active
+// user who asked for it or has notifications held for it. A user is only
+// sent a digest when something happened, and never twice within six days,
+// however often the job runs.
+type Job struct {
+	db     *gorm.DB
+	config *config.Config
+	sender Sender
+	stop   chan bool
+}
+
+// NewJob creates a weekly digest job
+func NewJob(db *gorm.DB, config *config.Config, sender Sender) *Job {
+	return &Job{
+		db:     db,
+		config: config,
+		sender: sender,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Schedule parses email.digest.schedule, run at email.digest.time
+func Schedule(config *config.Config) (*cron.Schedule, error) {
+	schedule, err := cron.ParseAt(config.GetString("email.digest.schedule"), config.GetString("email.digest.time"))
+	if err != nil {
+		return nil, fmt.Errorf("email.digest.schedule: %w", err)
+	}
+	return schedule, nil
+}
+
+// Start sends digests on schedule until the context is cancelled or Stop
+// is called
+func (j *Job) Start(ctx context.Context) {
+	schedule, err := Schedule(j.config)
+	if err != nil {
+		slog.Warn("Weekly digests are off", "error", err)
+		return
+	}
+
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-j.stop:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+
+		sent, err := j.Run(ctx, time.Now())
+		if err != nil {
+			slog.Error("Weekly digest run failed", "sent", sent, "error", err)
+		} else {
+			log.Printf("Queued %d weekly digests", sent)
+		}
+	}
+}
+
+// Stop stops sending digests
+func (j *Job) Stop() {
+	select {
+	case j.stop <- true:
+	default:
+	}
+}
+
+// recipient is a user who wants the digest
+type recipient struct {
+	ID           uuid.UUID
+	Username     string
+	DisplayName  string
+	Email        string
+	LastDigestAt *time.Time
+}
+
+// Run queues the digest for the week up to now to each user due one and
+// returns how many were queued. A failure for one user is logged and the
+// rest still get theirs.
+func (j *Job) Run(ctx context.Context, now time.Time) (int, error) {
+	var recipients []recipient
+	if err := j.db.WithContext(ctx).Table("email_preferences").
+		Select("users.id, users.username, users.display_name, users.email, email_preferences.last_digest_at").
+		Joins("JOIN users ON users.id = email_preferences.user_id").
+		Where("(email_preferences.notify_weekly_digest = ? OR email_preferences.digest_notifications = ?)", true, true).
+		Where("users.deleted_at IS NULL AND users.is_active = ?", true).
+		Where("email_preferences.last_digest_at IS NULL OR email_preferences.last_digest_at < ?", now.Add(-minGap)).
+		Scan(&recipients).Error; err != nil {
+		return 0, fmt.Errorf("failed to list digest recipients: %w", err)
+	}
+
+	sent := 0
+	for _, r := range recipients {
+		if err := ctx.Err(); err != nil {
+			return sent, err
+		}
+		ok, err := j.send(ctx, r, now)
+		if err != nil {
+			slog.Error("Failed to send weekly digest", "user", r.Username, "error", err)
+			continue
+		}
+		if ok {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// send queues one user's digest, reporting false when the week was quiet
+// or another run got there first
+func (j *Job) send(ctx context.Context, r recipient, now time.Time) (bool, error) {
+	stats, err := j.Stats(ctx, r.ID, now.Add(-week))
+	if err != nil {
+		return false, err
+	}
+	items, err := j.sender.PendingDigestItems(r.ID)
+	if err != nil {
+		return false, err
+	}
+	if stats.Quiet() && len(items) == 0 {
+		return false, nil
+	}
+
+	// Claim the user's digest, so a concurrent run skips them
+	claim := j.db.WithContext(ctx).Table("email_preferences").
+		Where("user_id = ? AND (last_digest_at IS NULL OR last_digest_at < ?)", r.ID, now.Add(-minGap)).
+		Update("last_digest_at", now)
+	if claim.Error != nil || claim.RowsAffected == 0 {
+		return false, claim.Error
+	}
+
+	popular, err := j.popular(ctx, r.ID)
+	if err != nil {
+		j.release(r)
+		return false, err
+	}
+
+	name := r.DisplayName
+	if name == "" {
+		name = r.Username
+	}
+	serverURL := j.config.GetString("server.url")
+	data := email.EmailData{
+		"UserName":       name,
+		"Week":           fmt.Sprintf("%s – %s", now.Add(-week).Format("Jan 2"), now.Format("Jan 2, 2006")),
+		"TotalGists":     stats.TotalGists,
+		"NewGists":       stats.NewGists,
+		"TotalStars":     stats.TotalStars,
+		"NewStars":       stats.NewStars,
+		"TotalForks":     stats.TotalForks,
+		"NewForks":       stats.NewForks,
+		"TotalFollowers": stats.TotalFollowers,
+		"NewFollowers":   stats.NewFollowers,
+		"PopularGists":   popular,
+		"DashboardURL":   serverURL + "/dashboard",
+		"SettingsURL":    serverURL + "/settings/notifications",
+	}
+	if err := j.sender.SendWeeklyDigest(r.ID, r.Email, name, data); err != nil {
+		j.release(r)
+		return false, err
+	}
+	return true, nil
+}
+
+// release puts back the last digest time of a digest that wasn't sent
+func (j *Job) release(r recipient) {
+	if err := j.db.Table("email_preferences").Where("user_id = ?", r.ID).
+		Update("last_digest_at", r.LastDigestAt).Error; err != nil {
+		slog.Error("Failed to reset the last digest time", "user", r.Username, "error", err)
+	}
+}
+
+// Stats counts a user's gists, the stars and forks they received and
+// their followers, in total and since the given time. Deleted gists don't
+// count.
+func (j *Job) Stats(ctx context.Context, userID uuid.UUID, since time.Time) (Stats, error) {
+	var stats Stats
+	db := j.db.WithContext(ctx)
+	owned := db.Model(&models.Gist{}).Select("id").Where("user_id = ?", userID)
+
+	counts := []struct {
+		total, recent *int64
+		query         func() *gorm.DB
+	}{
+		{&stats.TotalGists, &stats.NewGists, func() *gorm.DB {
+			return db.Model(&models.Gist{}).Where("user_id = ?", userID)
+		}},
+		{&stats.TotalStars, &stats.NewStars, func() *gorm.DB {
+			return db.Model(&models.GistStar{}).Where("gist_id IN (?)", owned)
+		}},
+		{&stats.TotalForks, &stats.NewForks, func() *gorm.DB {
+			return db.Model(&models.Gist{}).Where("forked_from_id IN (?)", owned)
+		}},
+		{&stats.TotalFollowers, &stats.NewFollowers, func() *gorm.DB {
+			return db.Model(&models.UserFollow{}).Where("following_id = ?", userID)
+		}},
+	}
+	for _, c := range counts {
+		if err := c.query().Count(c.total).Error; err != nil {
+			return stats, err
+		}
+		if err := c.query().Where("created_at >= ?", since).Count(c.recent).Error; err != nil {
+			return stats, err
+		}
+	}
+	return stats, nil
+}
+
+// popular returns the user's most starred gists
+func (j *Job) popular(ctx context.Context, userID uuid.UUID) ([]PopularGist, error) {
+	var gists []models.Gist
+	if err := j.db.WithContext(ctx).
+		Where("user_id = ? AND star_count > 0", userID).
+		Order("star_count DESC, fork_count DESC").
+		Limit(popularGists).
+		Find(&gists).Error; err != nil {
+		return nil, err
+	}
+
+	builder := urls.NewBuilder(j.config)
+	popular := make([]PopularGist, len(gists))
+	for i, gist := range gists {
+		popular[i] = PopularGist{
+			Title: gist.Title,
+			URL:   builder.Gist(gist.ID),
+			Stars: gist.StarCount,
+			Forks: gist.ForkCount,
+		}
+	}
+	return popular, nil
+}
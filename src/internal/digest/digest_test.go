@@ -0,0 +1,159 @@
+package digest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+)
+
+// fakeSender records the digests it is asked to send
+type fakeSender struct {
+	sent  map[uuid.UUID]email.EmailData
+	items map[uuid.UUID][]email.EmailDigestItem
+	fail  error
+}
+
+func (f *fakeSender) SendWeeklyDigest(userID uuid.UUID, toEmail, toName string, data email.EmailData) error {
+	if f.fail != nil {
+		return f.fail
+	}
+	f.sent[userID] = data
+	return nil
+}
+
+func (f *fakeSender) PendingDigestItems(userID uuid.UUID) ([]email.EmailDigestItem, error) {
+	return f.items[userID], nil
+}
+
+func setupDigestTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistStar{}, &models.UserFollow{}))
+	require.NoError(t, db.Exec(`CREATE TABLE email_preferences (
+		id TEXT PRIMARY KEY,
+		user_id TEXT NOT NULL UNIQUE,
+		notify_weekly_digest BOOLEAN DEFAULT FALSE,
+		digest_notifications BOOLEAN DEFAULT FALSE,
+		last_digest_at DATETIME
+	)`).Error)
+	return db
+}
+
+func TestJobRun(t *testing.T) {
+	db := setupDigestTestDB(t)
+	now := time.Now()
+	longAgo := now.Add(-30 * 24 * time.Hour)
+
+	newUser := func(name string, weekly, held bool) *models.User {
+		user := &models.User{Username: name, Email: name + "@example.com", IsActive: true}
+		require.NoError(t, db.Create(user).Error)
+		require.NoError(t, db.Exec("INSERT INTO email_preferences (id, user_id, notify_weekly_digest, digest_notifications) VALUES (?, ?, ?, ?)",
+			uuid.New(), user.ID, weekly, held).Error)
+		return user
+	}
+	alice := newUser("alice", true, false)
+	bob := newUser("bob", false, true)
+	quiet := newUser("quiet", true, false)
+	newUser("optedout", false, false)
+	fan := &models.User{Username: "fan", Email: "fan@example.com", IsActive: true}
+	require.NoError(t, db.Create(fan).Error)
+
+	// alice: an old gist starred this week and a new one, one new follower
+	old := &models.Gist{Title: "old", UserID: &alice.ID, StarCount: 2, CreatedAt: longAgo}
+	fresh := &models.Gist{Title: "fresh", UserID: &alice.ID}
+	require.NoError(t, db.Create(old).Error)
+	require.NoError(t, db.Create(fresh).Error)
+	require.NoError(t, db.Create(&models.GistStar{ID: uuid.New(), GistID: old.ID, UserID: fan.ID, CreatedAt: longAgo}).Error)
+	require.NoError(t, db.Create(&models.GistStar{ID: uuid.New(), GistID: old.ID, UserID: bob.ID}).Error)
+	require.NoError(t, db.Create(&models.Gist{Title: "fork", UserID: &fan.ID, ForkedFromID: &old.ID}).Error)
+	require.NoError(t, db.Create(&models.UserFollow{ID: uuid.New(), FollowerID: fan.ID, FollowingID: alice.ID}).Error)
+
+	// quiet: a gist from long ago and nothing since
+	require.NoError(t, db.Create(&models.Gist{Title: "ancient", UserID: &quiet.ID, CreatedAt: longAgo}).Error)
+
+	cfg := config.New()
+	cfg.Set("server.url", "https://gists.example.com")
+	sender := &fakeSender{
+		sent: map[uuid.UUID]email.EmailData{},
+		// bob had nothing happen but has held notifications
+		items: map[uuid.UUID][]email.EmailDigestItem{bob.ID: {{Summary: "fan followed you"}}},
+	}
+	job := NewJob(db, cfg, sender)
+
+	sent, err := job.Run(context.Background(), now)
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+	require.Contains(t, sender.sent, alice.ID)
+	require.Contains(t, sender.sent, bob.ID)
+	assert.NotContains(t, sender.sent, quiet.ID, "a quiet week sends nothing")
+
+	data := sender.sent[alice.ID]
+	assert.EqualValues(t, 2, data["TotalGists"])
+	assert.EqualValues(t, 1, data["NewGists"])
+	assert.EqualValues(t, 2, data["TotalStars"])
+	assert.EqualValues(t, 1, data["NewStars"])
+	assert.EqualValues(t, 1, data["NewForks"])
+	assert.EqualValues(t, 1, data["NewFollowers"])
+	popular := data["PopularGists"].([]PopularGist)
+	require.Len(t, popular, 1)
+	assert.Equal(t, "old", popular[0].Title)
+	assert.Equal(t, "https://gists.example.com/dashboard", data["DashboardURL"])
+
+	// Running again, say after a restart, sends nobody a second digest
+	sender.sent = map[uuid.UUID]email.EmailData{}
+	sent, err = job.Run(context.Background(), now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	// The next week they are due again, but only bob has anything to read
+	sent, err = job.Run(context.Background(), now.Add(8*24*time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+	assert.Contains(t, sender.sent, bob.ID)
+}
+
+func TestJobRunSendFailure(t *testing.T) {
+	db := setupDigestTestDB(t)
+	user := &models.User{Username: "alice", Email: "alice@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	require.NoError(t, db.Exec("INSERT INTO email_preferences (id, user_id, notify_weekly_digest) VALUES (?, ?, ?)", uuid.New(), user.ID, true).Error)
+	require.NoError(t, db.Create(&models.Gist{Title: "new", UserID: &user.ID}).Error)
+
+	sender := &fakeSender{sent: map[uuid.UUID]email.EmailData{}, fail: errors.New("render failed")}
+	sent, err := NewJob(db, config.New(), sender).Run(context.Background(), time.Now())
+	require.NoError(t, err)
+	assert.Zero(t, sent)
+
+	// The digest wasn't sent, so the next run tries again
+	var pref struct{ LastDigestAt *time.Time }
+	require.NoError(t, db.Table("email_preferences").Where("user_id = ?", user.ID).Scan(&pref).Error)
+	assert.Nil(t, pref.LastDigestAt)
+}
+
+func TestSchedule(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("email.digest.schedule", "weekly")
+	cfg.Set("email.digest.time", "09:00")
+	schedule, err := Schedule(cfg)
+	require.NoError(t, err)
+
+	next := schedule.Next(time.Date(2026, 10, 14, 12, 0, 0, 0, time.Local))
+	assert.Equal(t, time.Date(2026, 10, 18, 9, 0, 0, 0, time.Local), next)
+
+	cfg.Set("email.digest.time", "9am")
+	_, err = Schedule(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,33 @@
+// Package diskspace reports the size and free space of the file system a
+// path is on, the same way on Unix and Windows.
+package diskspace
+
+// Usage is the space of a file system in bytes
+type Usage struct {
+	// Total is the size of the file system
+	Total uint64
+	// Free is the unused space, including any reserved for the superuser
+	Free uint64
+	// Available is the space an unprivileged process can still use
+	Available uint64
+}
+
+// Used returns the space in use
+func (u Usage) Used() uint64 {
+	return u.Total - u.Free
+}
+
+// UsedPercent returns the share of the space a process can use that is in
+// use, the way df reports it
+func (u Usage) UsedPercent() float64 {
+	used := u.Used()
+	if used+u.Available == 0 {
+		return 0
+	}
+	return float64(used) / float64(used+u.Available) * 100
+}
+
+// Of returns the usage of the file system path is on
+func Of(path string) (Usage, error) {
+	return statFS(path)
+}
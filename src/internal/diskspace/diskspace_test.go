@@ -0,0 +1,29 @@
+package diskspace
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestOf(t *testing.T) {
+	usage, err := Of(t.TempDir())
+	require.NoError(t, err)
+	assert.NotZero(t, usage.Total)
+	assert.LessOrEqual(t, usage.Free, usage.Total)
+	assert.LessOrEqual(t, usage.Available, usage.Free)
+	assert.Equal(t, usage.Total-usage.Free, usage.Used())
+}
+
+func TestOfMissingPath(t *testing.T) {
+	_, err := Of("/does/not/exist/casgists")
+	assert.Error(t, err)
+}
+
+func TestUsedPercent(t *testing.T) {
+	// 100 blocks, 10 reserved for root: 40 used of the 90 a user can fill
+	usage := Usage{Total: 100, Free: 60, Available: 50}
+	assert.InDelta(t, 44.44, usage.UsedPercent(), 0.01)
+	assert.Zero(t, Usage{}.UsedPercent())
+}
@@ -0,0 +1,19 @@
+//go:build !windows
+
+package diskspace
+
+import "syscall"
+
+func statFS(path string) (Usage, error) {
+	var stat syscall.Statfs_t
+	if err := syscall.Statfs(path, &stat); err != nil {
+		return Usage{}, err
+	}
+	// Bsize is signed on some platforms
+	size := uint64(stat.Bsize)
+	return Usage{
+		Total:     uint64(stat.Blocks) * size,
+		Free:      uint64(stat.Bfree) * size,
+		Available: uint64(stat.Bavail) * size,
+	}, nil
+}
@@ -0,0 +1,18 @@
+//go:build windows
+
+package diskspace
+
+import "golang.org/x/sys/windows"
+
+func statFS(path string) (Usage, error) {
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return Usage{}, err
+	}
+	var usage Usage
+	// Available honours the quota of the calling user
+	if err := windows.GetDiskFreeSpaceEx(name, &usage.Available, &usage.Total, &usage.Free); err != nil {
+		return Usage{}, err
+	}
+	return usage, nil
+}
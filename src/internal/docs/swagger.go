@@ -7,6 +7,8 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/httpcache"
 )
 
 //go:embed swagger-ui/*
@@ -17,8 +19,9 @@ var templates embed.FS
 
 // SwaggerService handles API documentation generation and serving
 type SwaggerService struct {
-	spec     *OpenAPISpec
-	template *template.Template
+	spec      *OpenAPISpec
+	template  *template.Template
+	generated time.Time // When the spec was generated, its Last-Modified
 }
 
 // OpenAPISpec represents the OpenAPI 3.0 specification
@@ -153,7 +156,7 @@ type OpenAPITag struct {
 
 // NewSwaggerService creates a new swagger documentation service
 func NewSwaggerService() *SwaggerService {
-	service := &SwaggerService{}
+	service := &SwaggerService{generated: time.Now()}
 	service.generateSpec()
 	service.loadTemplate()
 	return service
@@ -393,12 +396,12 @@ func (s *SwaggerService) generateComponents() OpenAPIComponents {
 			"cookieAuth": {
 				Type:        "apiKey",
 				Description: "Session cookie authentication",
-				Name:        "session",
+				Name:        "access_token",
 				In:          "cookie",
 			},
 			"csrfToken": {
 				Type:        "apiKey",
-				Description: "CSRF protection token",
+				Description: "CSRF token from GET /api/v1/csrf, required with cookieAuth on writes",
 				Name:        "X-CSRF-Token",
 				In:          "header",
 			},
@@ -472,6 +475,40 @@ func (s *SwaggerService) generateAPIPaths() {
 		},
 	}
 
+	s.spec.Paths["/api/v1/csrf"] = OpenAPIPath{
+		Get: &OpenAPIOperation{
+			Tags:        []string{"Authentication"},
+			Summary:     "Get CSRF token",
+			Description: "Return the token browser sessions send in the X-CSRF-Token header with state-changing requests",
+			OperationID: "getCSRFToken",
+			Responses: map[string]OpenAPIResponse{
+				"200": {
+					Description: "CSRF token",
+					Content: map[string]OpenAPIMediaType{
+						"application/json": {
+							Schema: &OpenAPISchema{
+								Type: "object",
+								Properties: map[string]*OpenAPISchema{
+									"enabled": {
+										Type:    "boolean",
+										Example: true,
+									},
+									"csrf_token": {
+										Type: "string",
+									},
+									"header": {
+										Type:    "string",
+										Example: "X-CSRF-Token",
+									},
+								},
+							},
+						},
+					},
+				},
+			},
+		},
+	}
+
 	// Authentication endpoints
 	s.spec.Paths["/api/v1/auth/login"] = OpenAPIPath{
 		Post: &OpenAPIOperation{
@@ -948,19 +985,19 @@ func (s *SwaggerService) ServeSwaggerUI(c echo.Context) error {
 	return s.template.Execute(c.Response().Writer, data)
 }
 
-// ServeOpenAPISpec serves the OpenAPI JSON specification
+// ServeOpenAPISpec serves the OpenAPI JSON specification. It is the same
+// for everyone, so CDNs may keep it, and only changes with an upgrade.
 func (s *SwaggerService) ServeOpenAPISpec(c echo.Context) error {
-	// Update server URL based on request
-	if len(s.spec.Servers) > 0 {
-		scheme := "http"
-		if c.Request().TLS != nil || c.Request().Header.Get("X-Forwarded-Proto") == "https" {
-			scheme = "https"
-		}
-		s.spec.Servers[0].URL = scheme + "://" + c.Request().Host
+	// Update server URL based on request, on a copy shared by no other
+	// request
+	spec := *s.spec
+	if len(spec.Servers) > 0 {
+		spec.Servers = append([]OpenAPIServer{}, spec.Servers...)
+		spec.Servers[0].URL = c.Scheme() + "://" + c.Request().Host
 	}
 
-	c.Response().Header().Set("Content-Type", "application/json")
-	return c.JSON(http.StatusOK, s.spec)
+	httpcache.Revalidate(c, true)
+	return httpcache.JSON(c, &spec, s.generated)
 }
 
 // ServeReDoc serves the ReDoc documentation interface
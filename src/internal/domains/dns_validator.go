@@ -10,12 +10,15 @@ import (
 // DNSValidator handles DNS-based domain verification
 type DNSValidator struct {
 	timeout time.Duration
+	// resolveTXT looks up TXT records, net.LookupTXT outside tests
+	resolveTXT func(name string) ([]string, error)
 }
 
 // NewDNSValidator creates a new DNS validator
 func NewDNSValidator() *DNSValidator {
 	return &DNSValidator{
-		timeout: 10 * time.Second,
+		timeout:    10 * time.Second,
+		resolveTXT: net.LookupTXT,
 	}
 }
 
@@ -29,9 +32,9 @@ func (dv *DNSValidator) VerifyOwnership(domain, token string) error {
 		return fmt.Errorf("failed to lookup TXT records for %s: %w", challengeDomain, err)
 	}
 	
-	// Check if any TXT record contains our token
+	// Check if any TXT record is our token
 	for _, record := range txtRecords {
-		if strings.Contains(record, token) {
+		if strings.TrimSpace(record) == token {
 			return nil // Verification successful
 		}
 	}
@@ -77,7 +80,7 @@ func (dv *DNSValidator) lookupTXT(domain string) ([]string, error) {
 	
 	// Perform lookup in goroutine
 	go func() {
-		records, err := net.LookupTXT(domain)
+		records, err := dv.resolveTXT(domain)
 		if err != nil {
 			errorChan <- err
 			return
@@ -0,0 +1,111 @@
+package domains
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// ContextKey is where the middleware puts the custom domain a request came
+// in on
+const ContextKey = "custom_domain"
+
+// assetPaths are served on custom domains as they are, so the owner's pages
+// render
+var assetPaths = []string{"/static/", "/favicon.ico", "/robots.txt", "/manifest.json", "/service-worker.js"}
+
+// gistPrefixes are the routes that show a single gist, with its ID as the
+// next path segment
+var gistPrefixes = []string{"/gists/", "/g/", "/raw/"}
+
+// Middleware routes requests for verified custom domains. It must run before
+// routing (echo.Pre): the domain's root shows the owner's profile, the
+// owner's public gists are served as usual, and every other page is
+// redirected to the server's own URL.
+func (s *Service) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			domain, err := s.GetDomainByName(req.Host)
+			if err != nil {
+				return next(c)
+			}
+			c.Set(ContextKey, domain)
+
+			profile := ownerPath(domain)
+			path := req.URL.Path
+			switch {
+			case profile == "":
+				return echo.NewHTTPError(http.StatusNotFound)
+			case path == "/" || path == "":
+				req.URL.Path, req.URL.RawPath = profile, ""
+				return next(c)
+			case path == profile || strings.HasPrefix(path, profile+"/"), isAsset(path):
+				return next(c)
+			}
+
+			if id, ok := gistID(path); ok {
+				if !s.servesGist(domain, id) {
+					return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+				}
+				return next(c)
+			}
+
+			base := strings.TrimSuffix(s.config.GetString("server.url"), "/")
+			if base == "" {
+				return echo.NewHTTPError(http.StatusNotFound)
+			}
+			return c.Redirect(http.StatusFound, base+req.URL.RequestURI())
+		}
+	}
+}
+
+// servesGist reports whether a gist is one of the domain owner's public
+// gists
+func (s *Service) servesGist(domain *models.CustomDomain, id uuid.UUID) bool {
+	query := s.db.Model(&models.Gist{}).
+		Where("id = ? AND visibility = ? AND hidden_at IS NULL", id, models.VisibilityPublic)
+	if domain.OrganizationID != nil {
+		query = query.Where("organization_id = ?", *domain.OrganizationID)
+	} else {
+		query = query.Where("user_id = ? AND organization_id IS NULL", *domain.UserID)
+	}
+	var count int64
+	return query.Count(&count).Error == nil && count > 0
+}
+
+// ownerPath returns the profile page of the domain's owner
+func ownerPath(domain *models.CustomDomain) string {
+	switch {
+	case domain.Organization != nil:
+		return "/o/" + domain.Organization.Name
+	case domain.User != nil:
+		return "/u/" + domain.User.Username
+	}
+	return ""
+}
+
+// gistID returns the gist a single gist path is for
+func gistID(path string) (uuid.UUID, bool) {
+	for _, prefix := range gistPrefixes {
+		if rest, ok := strings.CutPrefix(path, prefix); ok {
+			segment, _, _ := strings.Cut(rest, "/")
+			id, err := uuid.Parse(segment)
+			return id, err == nil
+		}
+	}
+	return uuid.Nil, false
+}
+
+func isAsset(path string) bool {
+	for _, asset := range assetPaths {
+		if path == asset || (strings.HasSuffix(asset, "/") && strings.HasPrefix(path, asset)) {
+			return true
+		}
+	}
+	return false
+}
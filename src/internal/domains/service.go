@@ -1,191 +1,175 @@
 package domains
 
 import (
+	"context"
 	"crypto/rand"
-	"crypto/tls"
 	"encoding/hex"
+	"errors"
 	"fmt"
-	"net"
-	"net/http"
+	"net/url"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
-// Service handles custom domain operations
+var (
+	ErrDomainsDisabled    = errors.New("custom domains are disabled")
+	ErrDomainInvalid      = errors.New("invalid domain")
+	ErrDomainTaken        = errors.New("domain is already in use")
+	ErrDomainNotFound     = errors.New("domain not found")
+	ErrDomainNotVerified  = errors.New("domain must be verified first")
+	ErrDomainVerification = errors.New("verification record not found")
+)
+
+// challengePrefix is the label the verification TXT record is published under
+const challengePrefix = "_casgists-challenge."
+
+// lookupTTL is how long the domain a host maps to is remembered
+const lookupTTL = time.Minute
+
+// Service handles custom domain operations. A user or organization adds a
+// domain, proves they control it with a DNS TXT record holding the
+// domain's VerificationToken, and from then on the domain serves their
+// profile and public gists. With SSLEnabled the server gets a certificate
+// for the domain from the ACME CA.
 type Service struct {
-	db              *gorm.DB
-	serverIP        string
-	certManager     *CertificateManager
-	dnsValidator    *DNSValidator
-	domainWhitelist []string // Optional: allowed domains
+	db           *gorm.DB
+	config       *config.Config
+	dnsValidator *DNSValidator
+
+	mu      sync.Mutex
+	lookups map[string]lookup // host -> verified domain, nil for none
+}
+
+type lookup struct {
+	domain  *models.CustomDomain
+	expires time.Time
 }
 
 // NewService creates a new domain service
-func NewService(db *gorm.DB, serverIP string, certManager *CertificateManager) *Service {
+func NewService(db *gorm.DB, config *config.Config) *Service {
 	return &Service{
 		db:           db,
-		serverIP:     serverIP,
-		certManager:  certManager,
+		config:       config,
 		dnsValidator: NewDNSValidator(),
+		lookups:      make(map[string]lookup),
 	}
 }
 
+// Enabled reports whether users may add custom domains
+func (s *Service) Enabled() bool {
+	return s.config.GetBool("features.custom_domains")
+}
+
 // AddCustomDomain adds a custom domain for a user or organization
 func (s *Service) AddCustomDomain(domain string, userID *uuid.UUID, orgID *uuid.UUID) (*models.CustomDomain, error) {
-	// Validate input
+	if !s.Enabled() {
+		return nil, ErrDomainsDisabled
+	}
+	domain = NormalizeDomain(domain)
 	if err := s.validateDomain(domain); err != nil {
-		return nil, fmt.Errorf("invalid domain: %w", err)
+		return nil, err
 	}
-	
+
 	// Check if domain already exists
-	var existing models.CustomDomain
-	err := s.db.Where("domain = ?", domain).First(&existing).Error
-	if err == nil {
-		return nil, fmt.Errorf("domain already exists")
-	} else if err != gorm.ErrRecordNotFound {
+	var existing int64
+	if err := s.db.Model(&models.CustomDomain{}).Where("domain = ?", domain).Count(&existing).Error; err != nil {
 		return nil, fmt.Errorf("failed to check domain existence: %w", err)
 	}
-	
+	if existing > 0 {
+		return nil, ErrDomainTaken
+	}
+
 	// Generate verification token
 	token, err := s.generateVerificationToken()
 	if err != nil {
 		return nil, fmt.Errorf("failed to generate verification token: %w", err)
 	}
-	
-	// Create domain record
+
 	customDomain := &models.CustomDomain{
 		Domain:            domain,
 		UserID:            userID,
 		OrganizationID:    orgID,
 		VerificationToken: token,
-		Verified:          false,
-		SSLEnabled:        false,
 	}
-	
 	if err := s.db.Create(customDomain).Error; err != nil {
 		return nil, fmt.Errorf("failed to create custom domain: %w", err)
 	}
-	
+
 	return customDomain, nil
 }
 
-// VerifyDomain verifies domain ownership through DNS
-func (s *Service) VerifyDomain(domainID uuid.UUID) error {
-	// Get domain
+// GetDomain returns a domain of a user or organization
+func (s *Service) GetDomain(domainID uuid.UUID, userID *uuid.UUID, orgID *uuid.UUID) (*models.CustomDomain, error) {
+	query := s.db.Where("id = ?", domainID)
+	if orgID != nil {
+		query = query.Where("organization_id = ?", *orgID)
+	} else if userID != nil {
+		query = query.Where("user_id = ?", *userID)
+	}
 	var domain models.CustomDomain
-	if err := s.db.First(&domain, "id = ?", domainID).Error; err != nil {
-		return fmt.Errorf("domain not found: %w", err)
+	if err := query.First(&domain).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrDomainNotFound
+		}
+		return nil, err
 	}
-	
+	return &domain, nil
+}
+
+// VerifyDomain verifies domain ownership through the DNS TXT record
+func (s *Service) VerifyDomain(domain *models.CustomDomain) error {
 	if domain.Verified {
-		return fmt.Errorf("domain already verified")
+		return nil
 	}
-	
-	// Check DNS verification
+
 	if err := s.dnsValidator.VerifyOwnership(domain.Domain, domain.VerificationToken); err != nil {
-		return fmt.Errorf("DNS verification failed: %w", err)
+		return fmt.Errorf("%w: %v", ErrDomainVerification, err)
 	}
-	
+
 	// Mark as verified
 	now := time.Now()
 	domain.Verified = true
 	domain.VerifiedAt = &now
-	
-	if err := s.db.Save(&domain).Error; err != nil {
+	if err := s.db.Save(domain).Error; err != nil {
 		return fmt.Errorf("failed to update domain: %w", err)
 	}
-	
-	// Attempt to provision SSL certificate
-	go s.provisionSSLCertificate(&domain)
-	
+	s.forget(domain.Domain)
+
 	return nil
 }
 
-// EnableSSL enables SSL for a verified domain
-func (s *Service) EnableSSL(domainID uuid.UUID) error {
-	// Get domain
-	var domain models.CustomDomain
-	if err := s.db.First(&domain, "id = ?", domainID).Error; err != nil {
-		return fmt.Errorf("domain not found: %w", err)
+// SetSSL turns HTTPS on or off for a domain. Certificates are issued on the
+// first HTTPS request once it is on, and renewed automatically.
+func (s *Service) SetSSL(domain *models.CustomDomain, enabled bool) error {
+	if enabled && !domain.Verified {
+		return ErrDomainNotVerified
 	}
-	
-	if !domain.Verified {
-		return fmt.Errorf("domain must be verified before enabling SSL")
-	}
-	
-	// Provision SSL certificate
-	certPath, keyPath, expiresAt, err := s.certManager.ObtainCertificate(domain.Domain)
-	if err != nil {
-		return fmt.Errorf("failed to obtain SSL certificate: %w", err)
-	}
-	
-	// Update domain record
-	domain.SSLEnabled = true
-	domain.SSLCertPath = certPath
-	domain.SSLKeyPath = keyPath
-	domain.SSLExpiresAt = &expiresAt
-	
-	if err := s.db.Save(&domain).Error; err != nil {
-		return fmt.Errorf("failed to update domain SSL config: %w", err)
-	}
-	
-	return nil
-}
 
-// DisableSSL disables SSL for a domain
-func (s *Service) DisableSSL(domainID uuid.UUID) error {
-	// Get domain
-	var domain models.CustomDomain
-	if err := s.db.First(&domain, "id = ?", domainID).Error; err != nil {
-		return fmt.Errorf("domain not found: %w", err)
-	}
-	
-	// Revoke certificate if it exists
-	if domain.SSLCertPath != "" {
-		if err := s.certManager.RevokeCertificate(domain.SSLCertPath); err != nil {
-			// Log error but don't fail the operation
-			fmt.Printf("Warning: failed to revoke certificate for %s: %v\n", domain.Domain, err)
-		}
+	domain.SSLEnabled = enabled
+	if !enabled {
+		domain.SSLExpiresAt = nil
 	}
-	
-	// Update domain record
-	domain.SSLEnabled = false
-	domain.SSLCertPath = ""
-	domain.SSLKeyPath = ""
-	domain.SSLExpiresAt = nil
-	
-	if err := s.db.Save(&domain).Error; err != nil {
+	if err := s.db.Save(domain).Error; err != nil {
 		return fmt.Errorf("failed to update domain SSL config: %w", err)
 	}
-	
+	s.forget(domain.Domain)
+
 	return nil
 }
 
 // RemoveDomain removes a custom domain
-func (s *Service) RemoveDomain(domainID uuid.UUID) error {
-	// Get domain
-	var domain models.CustomDomain
-	if err := s.db.First(&domain, "id = ?", domainID).Error; err != nil {
-		return fmt.Errorf("domain not found: %w", err)
-	}
-	
-	// Revoke SSL certificate if enabled
-	if domain.SSLEnabled && domain.SSLCertPath != "" {
-		if err := s.certManager.RevokeCertificate(domain.SSLCertPath); err != nil {
-			fmt.Printf("Warning: failed to revoke certificate for %s: %v\n", domain.Domain, err)
-		}
-	}
-	
-	// Delete domain
-	if err := s.db.Delete(&domain).Error; err != nil {
+func (s *Service) RemoveDomain(domain *models.CustomDomain) error {
+	if err := s.db.Delete(domain).Error; err != nil {
 		return fmt.Errorf("failed to delete domain: %w", err)
 	}
-	
+	s.forget(domain.Domain)
 	return nil
 }
 
@@ -209,101 +193,132 @@ func (s *Service) GetOrganizationDomains(orgID uuid.UUID) ([]models.CustomDomain
 	return domains, nil
 }
 
-// GetDomainByName gets a domain by its name
-func (s *Service) GetDomainByName(domain string) (*models.CustomDomain, error) {
-	var customDomain models.CustomDomain
-	err := s.db.Where("domain = ? AND verified = ?", domain, true).
+// GetDomainByName returns the verified domain a host name maps to, with
+// its owner. Answers are remembered for a minute, since every request on
+// the server asks. No host maps to a domain while custom domains are
+// disabled.
+func (s *Service) GetDomainByName(host string) (*models.CustomDomain, error) {
+	if !s.Enabled() {
+		return nil, ErrDomainNotFound
+	}
+	host = NormalizeDomain(host)
+
+	s.mu.Lock()
+	cached, ok := s.lookups[host]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		if cached.domain == nil {
+			return nil, ErrDomainNotFound
+		}
+		return cached.domain, nil
+	}
+
+	// Find rather than First, as most hosts asked about aren't custom
+	// domains and that isn't worth logging
+	var matches []models.CustomDomain
+	err := s.db.Where("domain = ? AND verified = ?", host, true).
 		Preload("User").
 		Preload("Organization").
-		First(&customDomain).Error
+		Limit(1).
+		Find(&matches).Error
 	if err != nil {
-		return nil, fmt.Errorf("domain not found or not verified: %w", err)
+		return nil, err
+	}
+	var found *models.CustomDomain
+	if len(matches) > 0 {
+		found = &matches[0]
 	}
-	return &customDomain, nil
+
+	s.mu.Lock()
+	s.lookups[host] = lookup{domain: found, expires: time.Now().Add(lookupTTL)}
+	s.mu.Unlock()
+
+	if found == nil {
+		return nil, ErrDomainNotFound
+	}
+	return found, nil
 }
 
-// CheckSSLRenewal checks and renews SSL certificates that are expiring soon
-func (s *Service) CheckSSLRenewal() error {
-	// Get domains with SSL enabled that expire within 30 days
-	var domains []models.CustomDomain
-	expireThreshold := time.Now().AddDate(0, 0, 30) // 30 days from now
-	
-	err := s.db.Where("ssl_enabled = ? AND ssl_expires_at < ?", true, expireThreshold).
-		Find(&domains).Error
+// HostPolicy allows certificates for verified domains with SSL enabled. It
+// fits autocert.Manager.HostPolicy.
+func (s *Service) HostPolicy(_ context.Context, host string) error {
+	domain, err := s.GetDomainByName(host)
 	if err != nil {
-		return fmt.Errorf("failed to get domains for renewal: %w", err)
-	}
-	
-	// Renew each domain
-	for _, domain := range domains {
-		if err := s.renewSSLCertificate(&domain); err != nil {
-			fmt.Printf("Failed to renew certificate for %s: %v\n", domain.Domain, err)
-			continue
-		}
+		return err
+	}
+	if !domain.SSLEnabled {
+		return fmt.Errorf("SSL is not enabled for %s", domain.Domain)
 	}
-	
 	return nil
 }
 
+// CertificateIssued records when the certificate served for a domain
+// expires
+func (s *Service) CertificateIssued(host string, expiresAt time.Time) {
+	domain, err := s.GetDomainByName(host)
+	if err != nil || (domain.SSLExpiresAt != nil && domain.SSLExpiresAt.Equal(expiresAt)) {
+		return
+	}
+	if err := s.db.Model(&models.CustomDomain{}).Where("id = ?", domain.ID).Update("ssl_expires_at", expiresAt).Error; err == nil {
+		s.forget(host)
+	}
+}
+
 // GetVerificationInstructions returns instructions for domain verification
-func (s *Service) GetVerificationInstructions(domainID uuid.UUID) (*VerificationInstructions, error) {
-	var domain models.CustomDomain
-	if err := s.db.First(&domain, "id = ?", domainID).Error; err != nil {
-		return nil, fmt.Errorf("domain not found: %w", err)
+func (s *Service) GetVerificationInstructions(domain *models.CustomDomain) *VerificationInstructions {
+	methods := []VerificationMethod{
+		{
+			Type:        "DNS_TXT",
+			Name:        challengePrefix + domain.Domain,
+			Value:       domain.VerificationToken,
+			Description: "Add this TXT record to your DNS configuration",
+		},
+	}
+	if host := s.serverHost(); host != "" {
+		methods = append(methods, VerificationMethod{
+			Type:        "DNS_CNAME",
+			Name:        domain.Domain,
+			Value:       host,
+			Description: "Point your domain at this server",
+		})
+	}
+
+	checkURL := fmt.Sprintf("/api/v1/user/domains/%s/verify", domain.ID)
+	if domain.Organization != nil {
+		checkURL = fmt.Sprintf("/api/v1/orgs/%s/domains/%s/verify", domain.Organization.Name, domain.ID)
 	}
-	
 	return &VerificationInstructions{
-		Domain: domain.Domain,
-		Methods: []VerificationMethod{
-			{
-				Type:        "DNS_TXT",
-				Name:        fmt.Sprintf("_casgists-challenge.%s", domain.Domain),
-				Value:       domain.VerificationToken,
-				Description: "Add this TXT record to your DNS configuration",
-			},
-			{
-				Type:        "DNS_CNAME",
-				Name:        domain.Domain,
-				Value:       s.serverIP,
-				Description: "Point your domain to our server IP address",
-			},
-		},
-		CheckURL: fmt.Sprintf("/api/v1/domains/%s/verify", domainID),
-	}, nil
+		Domain:   domain.Domain,
+		Methods:  methods,
+		CheckURL: checkURL,
+	}
+}
+
+// forget drops what is remembered about a host
+func (s *Service) forget(host string) {
+	s.mu.Lock()
+	delete(s.lookups, NormalizeDomain(host))
+	s.mu.Unlock()
+}
+
+// serverHost returns the host name of server.url
+func (s *Service) serverHost() string {
+	u, err := url.Parse(s.config.GetString("server.url"))
+	if err != nil {
+		return ""
+	}
+	return NormalizeDomain(u.Hostname())
 }
 
-// validateDomain validates domain format and checks against whitelist
+// validateDomain validates domain format. The server's own host name can't
+// be claimed.
 func (s *Service) validateDomain(domain string) error {
-	// Basic format validation
-	if len(domain) == 0 || len(domain) > 253 {
-		return fmt.Errorf("invalid domain length")
-	}
-	
-	// Check for valid characters and structure
-	if !isDomainValid(domain) {
-		return fmt.Errorf("invalid domain format")
-	}
-	
-	// Check against whitelist if configured
-	if len(s.domainWhitelist) > 0 {
-		allowed := false
-		for _, allowedDomain := range s.domainWhitelist {
-			if domain == allowedDomain || strings.HasSuffix("."+domain, "."+allowedDomain) {
-				allowed = true
-				break
-			}
-		}
-		if !allowed {
-			return fmt.Errorf("domain not in whitelist")
-		}
+	if len(domain) == 0 || len(domain) > 253 || !isDomainValid(domain) {
+		return fmt.Errorf("%w: %q", ErrDomainInvalid, domain)
 	}
-	
-	// Check if domain resolves
-	_, err := net.LookupHost(domain)
-	if err != nil {
-		return fmt.Errorf("domain does not resolve: %w", err)
+	if host := s.serverHost(); host != "" && (domain == host || strings.HasSuffix(domain, "."+host)) {
+		return fmt.Errorf("%w: %s belongs to this server", ErrDomainInvalid, domain)
 	}
-	
 	return nil
 }
 
@@ -316,73 +331,36 @@ func (s *Service) generateVerificationToken() (string, error) {
 	return hex.EncodeToString(bytes), nil
 }
 
-// provisionSSLCertificate attempts to provision an SSL certificate
-func (s *Service) provisionSSLCertificate(domain *models.CustomDomain) {
-	certPath, keyPath, expiresAt, err := s.certManager.ObtainCertificate(domain.Domain)
-	if err != nil {
-		fmt.Printf("Failed to provision SSL certificate for %s: %v\n", domain.Domain, err)
-		return
+// NormalizeDomain lowercases a host name and removes the port and the
+// trailing dot
+func NormalizeDomain(host string) string {
+	if i := strings.LastIndex(host, ":"); i != -1 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
 	}
-	
-	// Update domain record
-	domain.SSLEnabled = true
-	domain.SSLCertPath = certPath
-	domain.SSLKeyPath = keyPath
-	domain.SSLExpiresAt = &expiresAt
-	
-	if err := s.db.Save(domain).Error; err != nil {
-		fmt.Printf("Failed to update domain SSL config for %s: %v\n", domain.Domain, err)
-		return
-	}
-	
-	fmt.Printf("SSL certificate provisioned successfully for %s\n", domain.Domain)
-}
-
-// renewSSLCertificate renews an SSL certificate
-func (s *Service) renewSSLCertificate(domain *models.CustomDomain) error {
-	certPath, keyPath, expiresAt, err := s.certManager.RenewCertificate(domain.SSLCertPath)
-	if err != nil {
-		return fmt.Errorf("failed to renew certificate: %w", err)
-	}
-	
-	// Update domain record
-	domain.SSLCertPath = certPath
-	domain.SSLKeyPath = keyPath
-	domain.SSLExpiresAt = &expiresAt
-	
-	if err := s.db.Save(domain).Error; err != nil {
-		return fmt.Errorf("failed to update domain: %w", err)
-	}
-	
-	return nil
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(host)), ".")
 }
 
 // isDomainValid checks if a domain name is valid
 func isDomainValid(domain string) bool {
-	// Basic RFC validation - simplified
-	if strings.Contains(domain, "..") {
-		return false
-	}
-	
-	if strings.HasPrefix(domain, ".") || strings.HasSuffix(domain, ".") {
-		return false
-	}
-	
 	parts := strings.Split(domain, ".")
 	if len(parts) < 2 {
 		return false
 	}
-	
+
 	for _, part := range parts {
 		if len(part) == 0 || len(part) > 63 {
 			return false
 		}
-		
 		if strings.HasPrefix(part, "-") || strings.HasSuffix(part, "-") {
 			return false
 		}
+		for _, r := range part {
+			if (r < 'a' || r > 'z') && (r < '0' || r > '9') && r != '-' {
+				return false
+			}
+		}
 	}
-	
+
 	return true
 }
 
@@ -400,62 +378,3 @@ type VerificationMethod struct {
 	Value       string `json:"value"`
 	Description string `json:"description"`
 }
-
-// DomainMiddleware creates middleware to handle custom domain routing
-func (s *Service) DomainMiddleware() func(http.Handler) http.Handler {
-	return func(next http.Handler) http.Handler {
-		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			host := r.Host
-			
-			// Remove port if present
-			if colonIndex := strings.Index(host, ":"); colonIndex != -1 {
-				host = host[:colonIndex]
-			}
-			
-			// Check if this is a custom domain
-			customDomain, err := s.GetDomainByName(host)
-			if err == nil {
-				// Set custom domain context
-				r.Header.Set("X-Custom-Domain", customDomain.Domain)
-				if customDomain.UserID != nil {
-					r.Header.Set("X-Custom-Domain-User", customDomain.UserID.String())
-				}
-				if customDomain.OrganizationID != nil {
-					r.Header.Set("X-Custom-Domain-Org", customDomain.OrganizationID.String())
-				}
-			}
-			
-			next.ServeHTTP(w, r)
-		})
-	}
-}
-
-// GetTLSConfig returns TLS configuration for custom domains
-func (s *Service) GetTLSConfig() *tls.Config {
-	return &tls.Config{
-		GetCertificate: s.getCertificate,
-	}
-}
-
-// getCertificate retrieves the appropriate certificate for a domain
-func (s *Service) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
-	domain := hello.ServerName
-	
-	// Get custom domain
-	customDomain, err := s.GetDomainByName(domain)
-	if err != nil {
-		return nil, fmt.Errorf("no certificate found for domain: %s", domain)
-	}
-	
-	if !customDomain.SSLEnabled {
-		return nil, fmt.Errorf("SSL not enabled for domain: %s", domain)
-	}
-	
-	// Load certificate
-	cert, err := tls.LoadX509KeyPair(customDomain.SSLCertPath, customDomain.SSLKeyPath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load certificate for %s: %w", domain, err)
-	}
-	
-	return &cert, nil
-}
\ No newline at end of file
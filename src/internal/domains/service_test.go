@@ -0,0 +1,187 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupDomainTest(t *testing.T) (*Service, *models.User) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Organization{}, &models.Gist{}, &models.CustomDomain{}))
+
+	cfg := config.New()
+	cfg.Set("features.custom_domains", true)
+	cfg.Set("server.url", "https://gists.example.com")
+
+	user := &models.User{Username: "gopher", Email: "gopher@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	return NewService(db, cfg), user
+}
+
+// publishTXT answers TXT lookups with records, by name
+func publishTXT(s *Service, records map[string][]string) {
+	s.dnsValidator.resolveTXT = func(name string) ([]string, error) {
+		if found, ok := records[name]; ok {
+			return found, nil
+		}
+		return nil, errors.New("no such host")
+	}
+}
+
+func TestAddCustomDomain(t *testing.T) {
+	s, user := setupDomainTest(t)
+
+	domain, err := s.AddCustomDomain("Gists.Gopher.dev:443", &user.ID, nil)
+	require.NoError(t, err)
+	assert.Equal(t, "gists.gopher.dev", domain.Domain)
+	assert.False(t, domain.Verified)
+	assert.Len(t, domain.VerificationToken, 64)
+
+	_, err = s.AddCustomDomain("gists.gopher.dev", &user.ID, nil)
+	assert.ErrorIs(t, err, ErrDomainTaken)
+	for _, invalid := range []string{"", "localhost", "not a domain", "gists.example.com", "evil.gists.example.com"} {
+		_, err = s.AddCustomDomain(invalid, &user.ID, nil)
+		assert.ErrorIs(t, err, ErrDomainInvalid, invalid)
+	}
+
+	s.config.Set("features.custom_domains", false)
+	_, err = s.AddCustomDomain("other.gopher.dev", &user.ID, nil)
+	assert.ErrorIs(t, err, ErrDomainsDisabled)
+}
+
+func TestVerifyDomain(t *testing.T) {
+	s, user := setupDomainTest(t)
+	domain, err := s.AddCustomDomain("gists.gopher.dev", &user.ID, nil)
+	require.NoError(t, err)
+
+	// No record, or a record with another token
+	publishTXT(s, nil)
+	assert.ErrorIs(t, s.VerifyDomain(domain), ErrDomainVerification)
+	publishTXT(s, map[string][]string{"_casgists-challenge.gists.gopher.dev": {"someone-elses-token"}})
+	assert.ErrorIs(t, s.VerifyDomain(domain), ErrDomainVerification)
+	// The token on the domain itself doesn't count
+	publishTXT(s, map[string][]string{"gists.gopher.dev": {domain.VerificationToken}})
+	assert.ErrorIs(t, s.VerifyDomain(domain), ErrDomainVerification)
+
+	stored, err := s.GetDomain(domain.ID, &user.ID, nil)
+	require.NoError(t, err)
+	assert.False(t, stored.Verified)
+	_, err = s.GetDomainByName("gists.gopher.dev")
+	assert.ErrorIs(t, err, ErrDomainNotFound)
+
+	publishTXT(s, map[string][]string{"_casgists-challenge.gists.gopher.dev": {"v=other", " " + domain.VerificationToken + " "}})
+	require.NoError(t, s.VerifyDomain(domain))
+	stored, err = s.GetDomain(domain.ID, &user.ID, nil)
+	require.NoError(t, err)
+	assert.True(t, stored.Verified)
+	assert.NotNil(t, stored.VerifiedAt)
+
+	// Verifying forgets the earlier answer, so the domain routes at once
+	found, err := s.GetDomainByName("GISTS.gopher.dev:8080")
+	require.NoError(t, err)
+	assert.Equal(t, domain.ID, found.ID)
+	assert.Equal(t, "gopher", found.User.Username)
+}
+
+func TestHostPolicy(t *testing.T) {
+	s, user := setupDomainTest(t)
+	ctx := context.Background()
+	domain, err := s.AddCustomDomain("gists.gopher.dev", &user.ID, nil)
+	require.NoError(t, err)
+
+	// Unverified domains get no certificate, and can't turn SSL on
+	assert.Error(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+	assert.ErrorIs(t, s.SetSSL(domain, true), ErrDomainNotVerified)
+
+	publishTXT(s, map[string][]string{"_casgists-challenge.gists.gopher.dev": {domain.VerificationToken}})
+	require.NoError(t, s.VerifyDomain(domain))
+	// Verified, but SSL is off
+	assert.Error(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+
+	require.NoError(t, s.SetSSL(domain, true))
+	assert.NoError(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+	assert.Error(t, s.HostPolicy(ctx, "other.gopher.dev"))
+	assert.Error(t, s.HostPolicy(ctx, "gists.example.com"))
+
+	// Turning SSL off again stops new certificates
+	require.NoError(t, s.SetSSL(domain, false))
+	assert.Error(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+	require.NoError(t, s.SetSSL(domain, true))
+
+	// As does disabling custom domains
+	s.config.Set("features.custom_domains", false)
+	assert.Error(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+	s.config.Set("features.custom_domains", true)
+	assert.NoError(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+
+	// And removing the domain
+	require.NoError(t, s.RemoveDomain(domain))
+	assert.Error(t, s.HostPolicy(ctx, "gists.gopher.dev"))
+}
+
+func TestMiddlewareRouting(t *testing.T) {
+	s, user := setupDomainTest(t)
+	domain, err := s.AddCustomDomain("gists.gopher.dev", &user.ID, nil)
+	require.NoError(t, err)
+
+	// serve returns the path the request was routed to, and whether it was
+	// routed as a custom domain
+	serve := func(host, path string) (int, string, bool) {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.Host = host
+		rec := httptest.NewRecorder()
+		c := echo.New().NewContext(req, rec)
+		var routed string
+		err := s.Middleware()(func(c echo.Context) error {
+			routed = c.Request().URL.Path
+			return c.NoContent(http.StatusOK)
+		})(c)
+		if he, ok := err.(*echo.HTTPError); ok {
+			return he.Code, routed, c.Get(ContextKey) != nil
+		}
+		require.NoError(t, err)
+		return rec.Code, routed, c.Get(ContextKey) != nil
+	}
+
+	// An unverified domain is served like any unknown host
+	code, routed, custom := serve("gists.gopher.dev", "/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/", routed)
+	assert.False(t, custom)
+
+	publishTXT(s, map[string][]string{"_casgists-challenge.gists.gopher.dev": {domain.VerificationToken}})
+	require.NoError(t, s.VerifyDomain(domain))
+
+	code, routed, custom = serve("gists.gopher.dev", "/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/u/gopher", routed)
+	assert.True(t, custom)
+
+	// Other pages go to the server's own URL
+	code, _, _ = serve("gists.gopher.dev", "/admin")
+	assert.Equal(t, http.StatusFound, code)
+
+	// Disabling custom domains stops routing them
+	s.config.Set("features.custom_domains", false)
+	code, routed, custom = serve("gists.gopher.dev", "/")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, "/", routed)
+	assert.False(t, custom)
+}
@@ -5,46 +5,94 @@ import (
 	"fmt"
 	"net/smtp"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/spf13/viper"
 	"gopkg.in/gomail.v2"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Mailer handles sending emails
 type Mailer struct {
-	cfg    *viper.Viper
+	cfg *config.Config
+
+	mu     sync.RWMutex
 	dialer *gomail.Dialer
+	pool   *smtpPool
 }
 
 // NewMailer creates a new mailer instance
-func NewMailer(cfg *viper.Viper) *Mailer {
-	var dialer *gomail.Dialer
-
-	if cfg.GetBool("email.enabled") {
-		host := cfg.GetString("email.smtp.host")
-		port := cfg.GetInt("email.smtp.port")
-		username := cfg.GetString("email.smtp.username")
-		password := cfg.GetString("email.smtp.password")
-
-		dialer = gomail.NewDialer(host, port, username, password)
-		
-		// Configure TLS
-		if cfg.GetBool("email.smtp.use_tls") {
-			dialer.TLSConfig = &tls.Config{
-				ServerName:         host,
-				InsecureSkipVerify: cfg.GetBool("email.smtp.skip_verify"),
-			}
+func NewMailer(cfg *config.Config) *Mailer {
+	mailer := &Mailer{cfg: cfg}
+	mailer.dialer, mailer.pool = newDialer(cfg)
+	return mailer
+}
+
+// newDialer reads email.smtp.*, returning nils while email is disabled
+func newDialer(cfg *config.Config) (*gomail.Dialer, *smtpPool) {
+	if !cfg.GetBool("email.enabled") {
+		return nil, nil
+	}
+
+	host := cfg.GetString("email.smtp.host")
+	port := cfg.GetInt("email.smtp.port")
+	username := cfg.GetString("email.smtp.username")
+	password := cfg.GetString("email.smtp.password")
+
+	dialer := gomail.NewDialer(host, port, username, password)
+
+	// Configure TLS
+	if cfg.GetBool("email.smtp.tls") || cfg.GetBool("email.smtp.use_tls") {
+		dialer.TLSConfig = &tls.Config{
+			ServerName:         host,
+			InsecureSkipVerify: cfg.GetBool("email.smtp.skip_verify"),
 		}
+	}
+
+	// Note: gomail.v2 doesn't support setting timeout directly
+	// The timeout would need to be handled at a different level
 
-		// Note: gomail.v2 doesn't support setting timeout directly
-		// The timeout would need to be handled at a different level
+	return dialer, newSMTPPool(dialer.Dial, cfg.GetInt("email.smtp.pool_size"), cfg.GetDuration("email.smtp.idle_timeout"))
+}
+
+// Reconfigure reads email.smtp.* again, after a config reload. Sends
+// already under way finish on their connections, which are then closed.
+func (m *Mailer) Reconfigure() {
+	dialer, pool := newDialer(m.cfg)
+	m.mu.Lock()
+	old := m.pool
+	m.dialer, m.pool = dialer, pool
+	m.mu.Unlock()
+
+	if old != nil {
+		old.close()
 	}
+}
 
-	return &Mailer{
-		cfg:    cfg,
-		dialer: dialer,
+// current returns the dialer and pool in use
+func (m *Mailer) current() (*gomail.Dialer, *smtpPool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.dialer, m.pool
+}
+
+// FromAddress is the sender address for outgoing email. email.from_email
+// is the older name of the setting.
+func FromAddress(cfg *config.Config) string {
+	if address := cfg.GetString("email.from.address"); address != "" {
+		return address
+	}
+	return cfg.GetString("email.from_email")
+}
+
+// FromName is the sender name for outgoing email. email.from.name always
+// has a default, so the older email.from_name wins when it is set.
+func FromName(cfg *config.Config) string {
+	if name := cfg.GetString("email.from_name"); name != "" {
+		return name
 	}
+	return cfg.GetString("email.from.name")
 }
 
 // SendEmail sends an email message
@@ -53,7 +101,8 @@ func (m *Mailer) SendEmail(email *EmailQueue) error {
 		return fmt.Errorf("email sending is disabled")
 	}
 
-	if m.dialer == nil {
+	_, pool := m.current()
+	if pool == nil {
 		return fmt.Errorf("email dialer not configured")
 	}
 
@@ -80,27 +129,46 @@ func (m *Mailer) SendEmail(email *EmailQueue) error {
 	message.SetHeader("X-Mailer", "CasGists")
 	message.SetHeader("X-Email-Type", string(email.Type))
 	message.SetHeader("X-Priority", fmt.Sprintf("%d", email.Priority))
+	for name, value := range email.Headers {
+		message.SetHeader(name, value)
+	}
 
-	// Send the email
-	if err := m.dialer.DialAndSend(message); err != nil {
+	// Send the email over a pooled connection
+	if err := pool.send(message); err != nil {
 		return fmt.Errorf("failed to send email: %w", err)
 	}
 
 	return nil
 }
 
+// CloseIdle closes pooled SMTP connections that have been idle past
+// email.smtp.idle_timeout
+func (m *Mailer) CloseIdle() {
+	if _, pool := m.current(); pool != nil {
+		pool.closeIdle()
+	}
+}
+
+// Close closes every pooled SMTP connection
+func (m *Mailer) Close() {
+	if _, pool := m.current(); pool != nil {
+		pool.close()
+	}
+}
+
 // TestConnection tests the SMTP connection
 func (m *Mailer) TestConnection() error {
 	if !m.cfg.GetBool("email.enabled") {
 		return fmt.Errorf("email is disabled")
 	}
 
-	if m.dialer == nil {
+	dialer, _ := m.current()
+	if dialer == nil {
 		return fmt.Errorf("email dialer not configured")
 	}
 
 	// Create a test connection
-	closer, err := m.dialer.Dial()
+	closer, err := dialer.Dial()
 	if err != nil {
 		return fmt.Errorf("failed to connect to SMTP server: %w", err)
 	}
@@ -119,8 +187,8 @@ func (m *Mailer) SendTestEmail(toEmail, toName string) error {
 		Type:      EmailTypeSystemAlert,
 		ToEmail:   toEmail,
 		ToName:    toName,
-		FromEmail: m.cfg.GetString("email.from_email"),
-		FromName:  m.cfg.GetString("email.from_name"),
+		FromEmail: FromAddress(m.cfg),
+		FromName:  FromName(m.cfg),
 		Subject:   "CasGists Email Test",
 		BodyText:  "This is a test email from CasGists. If you received this, email configuration is working correctly.",
 		BodyHTML:  "<p>This is a test email from <strong>CasGists</strong>.</p><p>If you received this, email configuration is working correctly.</p>",
@@ -24,6 +24,9 @@ const (
 	EmailTypeInvitation        EmailType = "invitation"
 	EmailTypeBackupComplete    EmailType = "backup_complete"
 	EmailTypeMigrationComplete EmailType = "migration_complete"
+	EmailTypeEmailChange       EmailType = "email_change"
+	EmailTypeEmailChanged      EmailType = "email_changed"
+	EmailTypeNewsletter        EmailType = "newsletter"
 )
 
 // EmailTemplate represents an email template
@@ -60,6 +63,9 @@ type EmailQueue struct {
 	SentAt      *time.Time  `json:"sent_at,omitempty"`
 	CreatedAt   time.Time   `json:"created_at"`
 	UpdatedAt   time.Time   `json:"updated_at"`
+
+	// Headers are extra message headers, such as List-Unsubscribe
+	Headers map[string]string `json:"-" gorm:"type:text;serializer:json"`
 }
 
 func (q *EmailQueue) BeforeCreate(tx *gorm.DB) error {
@@ -91,8 +97,12 @@ type EmailPreference struct {
 	NotifyFollowed      bool      `json:"notify_followed" gorm:"default:true"`
 	NotifyWeeklyDigest  bool      `json:"notify_weekly_digest" gorm:"default:false"`
 	NotifySystemAlerts  bool      `json:"notify_system_alerts" gorm:"default:true"`
-	CreatedAt           time.Time `json:"created_at"`
-	UpdatedAt           time.Time `json:"updated_at"`
+	// DigestNotifications holds star, fork, comment and follow
+	// notifications for the weekly digest instead of mailing each one
+	DigestNotifications bool       `json:"digest_notifications" gorm:"default:false"`
+	LastDigestAt        *time.Time `json:"last_digest_at,omitempty"`
+	CreatedAt           time.Time  `json:"created_at"`
+	UpdatedAt           time.Time  `json:"updated_at"`
 }
 
 func (p *EmailPreference) BeforeCreate(tx *gorm.DB) error {
@@ -102,40 +112,26 @@ func (p *EmailPreference) BeforeCreate(tx *gorm.DB) error {
 	return nil
 }
 
-// EmailVerificationToken represents an email verification token
-type EmailVerificationToken struct {
-	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
-	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
-	Email     string     `json:"email" gorm:"type:text;not null"`
-	Token     string     `json:"token" gorm:"type:text;not null;unique"`
-	Used      bool       `json:"used" gorm:"default:false"`
-	ExpiresAt time.Time  `json:"expires_at"`
-	UsedAt    *time.Time `json:"used_at,omitempty"`
-	CreatedAt time.Time  `json:"created_at"`
-}
-
-func (t *EmailVerificationToken) BeforeCreate(tx *gorm.DB) error {
-	if t.ID == uuid.Nil {
-		t.ID = uuid.New()
-	}
-	return nil
+// WantsDigest reports whether the user gets the weekly digest, which
+// they do when they asked for it or have notifications held for it
+func (p *EmailPreference) WantsDigest() bool {
+	return p.NotifyWeeklyDigest || p.DigestNotifications
 }
 
-// PasswordResetToken represents a password reset token
-type PasswordResetToken struct {
+// EmailDigestItem is an activity notification held for the weekly digest
+type EmailDigestItem struct {
 	ID        uuid.UUID  `json:"id" gorm:"type:uuid;primary_key"`
-	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null"`
-	Email     string     `json:"email" gorm:"type:text;not null"`
-	Token     string     `json:"token" gorm:"type:text;not null;unique"`
-	Used      bool       `json:"used" gorm:"default:false"`
-	ExpiresAt time.Time  `json:"expires_at"`
-	UsedAt    *time.Time `json:"used_at,omitempty"`
+	UserID    uuid.UUID  `json:"user_id" gorm:"type:uuid;not null;index"`
+	Type      EmailType  `json:"type" gorm:"type:text;not null"`
+	Summary   string     `json:"summary" gorm:"type:text;not null"`
+	URL       string     `json:"url" gorm:"type:text"`
+	SentAt    *time.Time `json:"sent_at,omitempty"`
 	CreatedAt time.Time  `json:"created_at"`
 }
 
-func (t *PasswordResetToken) BeforeCreate(tx *gorm.DB) error {
-	if t.ID == uuid.Nil {
-		t.ID = uuid.New()
+func (i *EmailDigestItem) BeforeCreate(tx *gorm.DB) error {
+	if i.ID == uuid.Nil {
+		i.ID = uuid.New()
 	}
 	return nil
 }
@@ -0,0 +1,129 @@
+package email
+
+import (
+	"sync"
+	"time"
+
+	"gopkg.in/gomail.v2"
+)
+
+// smtpPool keeps SMTP connections open between sends, so a batch of
+// queued emails doesn't dial and authenticate once per message. At most
+// size connections are in use at once; callers beyond that wait.
+type smtpPool struct {
+	dial        func() (gomail.SendCloser, error)
+	idleTimeout time.Duration
+	slots       chan struct{}
+
+	mu     sync.Mutex
+	idle   []pooledConn
+	closed bool // connections coming back are closed instead of kept
+}
+
+type pooledConn struct {
+	conn     gomail.SendCloser
+	lastUsed time.Time
+}
+
+func newSMTPPool(dial func() (gomail.SendCloser, error), size int, idleTimeout time.Duration) *smtpPool {
+	if size < 1 {
+		size = 1
+	}
+	return &smtpPool{
+		dial:        dial,
+		idleTimeout: idleTimeout,
+		slots:       make(chan struct{}, size),
+	}
+}
+
+// send delivers the message over a pooled connection. Servers drop idle
+// connections without telling us, so a send failing on a reused
+// connection is tried once more on a fresh one.
+func (p *smtpPool) send(message *gomail.Message) error {
+	p.slots <- struct{}{}
+	defer func() { <-p.slots }()
+
+	conn := p.get()
+	reused := conn != nil
+	if !reused {
+		var err error
+		if conn, err = p.dial(); err != nil {
+			return err
+		}
+	}
+
+	err := gomail.Send(conn, message)
+	if err != nil && reused {
+		conn.Close()
+		if conn, err = p.dial(); err != nil {
+			return err
+		}
+		err = gomail.Send(conn, message)
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.put(conn)
+	return nil
+}
+
+// get takes the most recently used idle connection, closing any that sat
+// idle too long to still be open on the server
+func (p *smtpPool) get() gomail.SendCloser {
+	p.closeIdle()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.idle) == 0 {
+		return nil
+	}
+	last := p.idle[len(p.idle)-1]
+	p.idle = p.idle[:len(p.idle)-1]
+	return last.conn
+}
+
+func (p *smtpPool) put(conn gomail.SendCloser) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.closed {
+		conn.Close()
+		return
+	}
+	p.idle = append(p.idle, pooledConn{conn: conn, lastUsed: time.Now()})
+}
+
+// closeIdle closes the connections idle for longer than the idle timeout
+func (p *smtpPool) closeIdle() {
+	p.mu.Lock()
+	var stale []gomail.SendCloser
+	kept := p.idle[:0]
+	for _, c := range p.idle {
+		if p.idleTimeout > 0 && time.Since(c.lastUsed) > p.idleTimeout {
+			stale = append(stale, c.conn)
+			continue
+		}
+		kept = append(kept, c)
+	}
+	p.idle = kept
+	p.mu.Unlock()
+
+	for _, conn := range stale {
+		conn.Close()
+	}
+}
+
+// close closes every idle connection, and the ones in use once their
+// sends are done
+func (p *smtpPool) close() {
+	p.mu.Lock()
+	idle := p.idle
+	p.idle = nil
+	p.closed = true
+	p.mu.Unlock()
+
+	for _, c := range idle {
+		c.conn.Close()
+	}
+}
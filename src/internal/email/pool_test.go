@@ -0,0 +1,86 @@
+package email
+
+import (
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gopkg.in/gomail.v2"
+)
+
+// fakeConn is an SMTP connection that records what it sends
+type fakeConn struct {
+	sent   int
+	fail   bool
+	closed bool
+}
+
+func (c *fakeConn) Send(from string, to []string, msg io.WriterTo) error {
+	if c.fail || c.closed {
+		return errors.New("connection reset")
+	}
+	c.sent++
+	return nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func testMessage() *gomail.Message {
+	m := gomail.NewMessage()
+	m.SetHeader("From", "from@example.com")
+	m.SetHeader("To", "to@example.com")
+	m.SetBody("text/plain", "hello")
+	return m
+}
+
+func TestSMTPPool(t *testing.T) {
+	var dialed []*fakeConn
+	dial := func() (gomail.SendCloser, error) {
+		conn := &fakeConn{}
+		dialed = append(dialed, conn)
+		return conn, nil
+	}
+	pool := newSMTPPool(dial, 2, time.Minute)
+
+	require.NoError(t, pool.send(testMessage()))
+	require.NoError(t, pool.send(testMessage()))
+	require.Len(t, dialed, 1, "the connection is reused")
+	assert.Equal(t, 2, dialed[0].sent)
+
+	// A connection the server dropped is replaced
+	dialed[0].fail = true
+	require.NoError(t, pool.send(testMessage()))
+	require.Len(t, dialed, 2)
+	assert.True(t, dialed[0].closed)
+	assert.Equal(t, 1, dialed[1].sent)
+
+	// Connections idle past the timeout are closed
+	pool.idle[0].lastUsed = time.Now().Add(-2 * time.Minute)
+	pool.closeIdle()
+	assert.True(t, dialed[1].closed)
+	assert.Empty(t, pool.idle)
+
+	require.NoError(t, pool.send(testMessage()))
+	pool.close()
+	assert.True(t, dialed[2].closed)
+
+	// Connections in use when the pool closes aren't kept afterwards
+	require.NoError(t, pool.send(testMessage()))
+	assert.True(t, dialed[3].closed)
+	assert.Empty(t, pool.idle)
+}
+
+func TestSMTPPoolDialError(t *testing.T) {
+	pool := newSMTPPool(func() (gomail.SendCloser, error) {
+		return nil, errors.New("connection refused")
+	}, 1, time.Minute)
+
+	assert.EqualError(t, pool.send(testMessage()), "connection refused")
+	assert.Empty(t, pool.idle)
+}
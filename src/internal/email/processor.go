@@ -3,30 +3,30 @@ package email
 import (
 	"context"
 	"log"
+	"log/slog"
 	"time"
 
-	"github.com/spf13/viper"
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Processor handles background email processing
 type Processor struct {
 	service *Service
-	cfg     *viper.Viper
+	cfg     *config.Config
 	stop    chan bool
-	stopped chan bool
 }
 
 // NewProcessor creates a new email processor
-func NewProcessor(service *Service, cfg *viper.Viper) *Processor {
+func NewProcessor(service *Service, cfg *config.Config) *Processor {
 	return &Processor{
 		service: service,
 		cfg:     cfg,
 		stop:    make(chan bool, 1),
-		stopped: make(chan bool, 1),
 	}
 }
 
-// Start begins processing emails in the background
+// Start processes emails until the context is cancelled or Stop is
+// called. A batch being sent when Stop is called is finished first.
 func (p *Processor) Start(ctx context.Context) {
 	if !p.cfg.GetBool("email.enabled") {
 		log.Println("Email processing is disabled")
@@ -35,7 +35,8 @@ func (p *Processor) Start(ctx context.Context) {
 
 	log.Println("Starting email processor...")
 
-	// Process interval (default: 30 seconds)
+	// Process interval (default: 30 seconds); queuing an email also
+	// wakes the processor
 	interval := p.cfg.GetDuration("email.process_interval")
 	if interval == 0 {
 		interval = 30 * time.Second
@@ -48,36 +49,31 @@ func (p *Processor) Start(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			log.Println("Email processor stopping due to context cancellation")
-			p.stopped <- true
 			return
 		case <-p.stop:
 			log.Println("Email processor stopping...")
-			p.stopped <- true
 			return
 		case <-ticker.C:
 			p.processEmails(ctx)
+		case <-p.service.queued:
+			p.processEmails(ctx)
 		}
 	}
 }
 
-// Stop stops the email processor
+// Stop stops the email processor once the batch in progress is sent
 func (p *Processor) Stop() {
 	select {
 	case p.stop <- true:
 	default:
 	}
-	
-	// Wait for processor to stop
-	select {
-	case <-p.stopped:
-	case <-time.After(5 * time.Second):
-		log.Println("Email processor stop timeout")
-	}
 }
 
-// processEmails processes pending emails
+// processEmails processes pending emails, then lets go of the SMTP
+// connections the queue has stopped using
 func (p *Processor) processEmails(ctx context.Context) {
-	if err := p.service.ProcessEmailQueue(ctx); err != nil {
-		log.Printf("Error processing email queue: %v", err)
+	if err := p.service.ProcessEmailQueue(ctx); err != nil && ctx.Err() == nil {
+		slog.Error("Failed to process email queue", "error", err)
 	}
+	p.service.mailer.CloseIdle()
 }
\ No newline at end of file
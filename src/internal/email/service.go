@@ -2,37 +2,51 @@ package email
 
 import (
 	"context"
-	"crypto/rand"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/urls"
 )
 
+// stuckAfter is how long an email may stay in sending before it is
+// assumed its sender died and it is queued again
+const stuckAfter = 10 * time.Minute
+
+// maxDigestActivity caps the activity listed in one weekly digest
+const maxDigestActivity = 50
+
 // Service handles email operations
 type Service struct {
 	db       *gorm.DB
-	cfg      *viper.Viper
+	cfg      *config.Config
 	mailer   *Mailer
 	renderer *TemplateRenderer
+
+	// queued wakes the processor when an email is queued
+	queued chan struct{}
 }
 
 // NewService creates a new email service
-func NewService(db *gorm.DB, cfg *viper.Viper) *Service {
+func NewService(db *gorm.DB, cfg *config.Config) *Service {
 	service := &Service{
 		db:       db,
 		cfg:      cfg,
 		mailer:   NewMailer(cfg),
 		renderer: NewTemplateRenderer(),
+		queued:   make(chan struct{}, 1),
 	}
 
 	// Load default templates
 	if err := service.renderer.LoadDefaultTemplates(); err != nil {
-		log.Printf("Failed to load default email templates: %v", err)
+		slog.Error("Failed to load default email templates", "error", err)
 	}
 
 	return service
@@ -56,67 +70,81 @@ func (s *Service) QueueEmail(email *EmailQueue) error {
 		email.MaxAttempts = 3
 	}
 
-	return s.db.Create(email).Error
-}
-
-// SendVerificationEmail sends email verification
-func (s *Service) SendVerificationEmail(userID uuid.UUID, email, username string) error {
-	// Generate verification token
-	token, err := s.generateToken()
-	if err != nil {
-		return fmt.Errorf("failed to generate token: %w", err)
+	if err := s.db.Create(email).Error; err != nil {
+		return err
 	}
 
-	// Save token to database
-	verificationToken := &EmailVerificationToken{
-		UserID:    userID,
-		Email:     email,
-		Token:     token,
-		ExpiresAt: time.Now().Add(24 * time.Hour),
+	// Send it now rather than on the processor's next tick
+	select {
+	case s.queued <- struct{}{}:
+	default:
 	}
+	return nil
+}
 
-	if err := s.db.Create(verificationToken).Error; err != nil {
-		return fmt.Errorf("failed to save verification token: %w", err)
-	}
+// Close closes the pooled SMTP connections
+func (s *Service) Close() {
+	s.mailer.Close()
+}
+
+// Reconfigure applies changed email.smtp.* settings after a config reload
+func (s *Service) Reconfigure() {
+	s.mailer.Reconfigure()
+}
 
-	// Prepare email data
+// SendVerificationEmail sends the link confirming a user's email address
+func (s *Service) SendVerificationEmail(email, username, verificationURL string, expiresAt time.Time) error {
 	data := EmailData{
 		"UserName":        username,
-		"VerificationURL": fmt.Sprintf("%s/verify-email?token=%s", s.cfg.GetString("server.url"), token),
-		"ExpiresAt":       verificationToken.ExpiresAt.Format("January 2, 2006 at 3:04 PM"),
+		"VerificationURL": verificationURL,
+		"ExpiresAt":       expiresAt.Format("January 2, 2006 at 3:04 PM"),
 	}
 
 	return s.sendTemplatedEmail(EmailTypeVerification, email, username, data)
 }
 
-// SendPasswordResetEmail sends password reset email
-func (s *Service) SendPasswordResetEmail(userID uuid.UUID, email, username string) error {
-	// Generate reset token
-	token, err := s.generateToken()
-	if err != nil {
-		return fmt.Errorf("failed to generate token: %w", err)
+// SendPasswordResetEmail sends the link for setting a new password
+func (s *Service) SendPasswordResetEmail(email, username, resetURL string, expiresAt time.Time) error {
+	data := EmailData{
+		"UserName":  username,
+		"ResetURL":  resetURL,
+		"ExpiresAt": expiresAt.Format("January 2, 2006 at 3:04 PM"),
 	}
 
-	// Save token to database
-	resetToken := &PasswordResetToken{
-		UserID:    userID,
-		Email:     email,
-		Token:     token,
-		ExpiresAt: time.Now().Add(1 * time.Hour),
-	}
+	return s.sendTemplatedEmail(EmailTypePasswordReset, email, username, data)
+}
 
-	if err := s.db.Create(resetToken).Error; err != nil {
-		return fmt.Errorf("failed to save reset token: %w", err)
+// SendEmailChangeConfirmation sends the confirmation link for an email change to the new address
+func (s *Service) SendEmailChangeConfirmation(newEmail, username, confirmURL string, expiresAt time.Time) error {
+	data := EmailData{
+		"UserName":   username,
+		"NewEmail":   newEmail,
+		"ConfirmURL": confirmURL,
+		"ExpiresAt":  expiresAt.Format("January 2, 2006 at 3:04 PM"),
 	}
 
-	// Prepare email data
+	return s.sendTemplatedEmail(EmailTypeEmailChange, newEmail, username, data)
+}
+
+// SendEmailChangedNotice tells the old address about a completed email change and how to revert it
+func (s *Service) SendEmailChangedNotice(oldEmail, newEmail, username, revertURL string, revertExpiresAt time.Time) error {
 	data := EmailData{
-		"UserName":  username,
-		"ResetURL":  fmt.Sprintf("%s/reset-password?token=%s", s.cfg.GetString("server.url"), token),
-		"ExpiresAt": resetToken.ExpiresAt.Format("January 2, 2006 at 3:04 PM"),
+		"UserName":        username,
+		"OldEmail":        oldEmail,
+		"NewEmail":        newEmail,
+		"RevertURL":       revertURL,
+		"RevertExpiresAt": revertExpiresAt.Format("January 2, 2006 at 3:04 PM"),
 	}
 
-	return s.sendTemplatedEmail(EmailTypePasswordReset, email, username, data)
+	return s.sendTemplatedEmail(EmailTypeEmailChanged, oldEmail, username, data)
+}
+
+// RerouteQueuedEmails redirects pending emails from one address to another
+func (s *Service) RerouteQueuedEmails(fromEmail, toEmail string) error {
+	return s.db.Model(&EmailQueue{}).
+		Where("to_email = ? AND status = ? AND type NOT IN ?", fromEmail, EmailStatusPending,
+			[]EmailType{EmailTypeEmailChange, EmailTypeEmailChanged}).
+		Update("to_email", toEmail).Error
 }
 
 // SendWelcomeEmail sends welcome email to new users
@@ -132,16 +160,19 @@ func (s *Service) SendWelcomeEmail(email, username string) error {
 
 // SendGistStarredNotification sends notification when gist is starred
 func (s *Service) SendGistStarredNotification(recipientID uuid.UUID, recipientEmail, recipientName, actorName, gistTitle string, gistID uuid.UUID) error {
-	// Check if user wants this notification
-	if !s.userWantsNotification(recipientID, "notify_gist_starred") {
+	gistURL := urls.NewBuilder(s.cfg).Gist(gistID)
+	switch s.deliveryFor(recipientID, "notify_gist_starred") {
+	case deliverNone:
 		return nil
+	case deliverDigest:
+		return s.holdForDigest(recipientID, EmailTypeGistStarred, fmt.Sprintf("%s starred %s", actorName, gistTitle), gistURL)
 	}
 
 	data := EmailData{
 		"RecipientName": recipientName,
 		"ActorName":     actorName,
 		"GistTitle":     gistTitle,
-		"GistURL":       fmt.Sprintf("%s/gists/%s", s.cfg.GetString("server.url"), gistID.String()),
+		"GistURL":       gistURL,
 	}
 
 	return s.sendTemplatedEmail(EmailTypeGistStarred, recipientEmail, recipientName, data)
@@ -149,56 +180,109 @@ func (s *Service) SendGistStarredNotification(recipientID uuid.UUID, recipientEm
 
 // SendUserFollowedNotification sends notification when user is followed
 func (s *Service) SendUserFollowedNotification(recipientID uuid.UUID, recipientEmail, recipientName, followerName string, followerID uuid.UUID) error {
-	// Check if user wants this notification
-	if !s.userWantsNotification(recipientID, "notify_followed") {
+	followerURL := fmt.Sprintf("%s/users/%s", s.cfg.GetString("server.url"), followerID.String())
+	switch s.deliveryFor(recipientID, "notify_followed") {
+	case deliverNone:
 		return nil
+	case deliverDigest:
+		return s.holdForDigest(recipientID, EmailTypeUserFollowed, fmt.Sprintf("%s followed you", followerName), followerURL)
 	}
 
 	data := EmailData{
 		"RecipientName": recipientName,
 		"FollowerName":  followerName,
-		"FollowerURL":   fmt.Sprintf("%s/users/%s", s.cfg.GetString("server.url"), followerID.String()),
+		"FollowerURL":   followerURL,
 		"ProfileURL":    fmt.Sprintf("%s/users/%s", s.cfg.GetString("server.url"), recipientID.String()),
 	}
 
 	return s.sendTemplatedEmail(EmailTypeUserFollowed, recipientEmail, recipientName, data)
 }
 
-// ProcessEmailQueue processes pending emails in the queue
+// ProcessEmailQueue sends the pending emails that are due, highest
+// priority first, as many at once as the SMTP pool has connections. Each
+// email is claimed before it is sent, so several instances can share the
+// queue without sending anything twice.
 func (s *Service) ProcessEmailQueue(ctx context.Context) error {
+	// Emails left in sending by a process that died go back in the queue
+	if err := s.db.Model(&EmailQueue{}).
+		Where("status = ? AND updated_at < ?", EmailStatusSending, time.Now().Add(-stuckAfter)).
+		Update("status", EmailStatusPending).Error; err != nil {
+		return fmt.Errorf("failed to requeue stuck emails: %w", err)
+	}
+
 	// Get pending emails ordered by priority and creation time
 	var emails []EmailQueue
 	if err := s.db.Where("status = ? AND (scheduled_at IS NULL OR scheduled_at <= ?)",
 		EmailStatusPending, time.Now()).
 		Order("priority ASC, created_at ASC").
-		Limit(10). // Process 10 emails at a time
+		Limit(s.batchSize()).
 		Find(&emails).Error; err != nil {
 		return fmt.Errorf("failed to fetch pending emails: %w", err)
 	}
 
-	for _, email := range emails {
-		select {
-		case <-ctx.Done():
-			return ctx.Err()
-		default:
-			if err := s.processEmail(&email); err != nil {
-				log.Printf("Failed to process email %s: %v", email.ID, err)
-			}
+	workers := s.cfg.GetInt("email.smtp.pool_size")
+	if workers < 1 {
+		workers = 1
+	}
+	sem := make(chan struct{}, workers)
+	var wg sync.WaitGroup
+	for i := range emails {
+		if ctx.Err() != nil {
+			break
+		}
+		sem <- struct{}{}
+		claimed, err := s.claim(&emails[i])
+		if err != nil {
+			slog.Error("Failed to claim email", "email", emails[i].ID, "error", err)
 		}
+		if !claimed {
+			<-sem
+			continue
+		}
+
+		wg.Add(1)
+		go func(email *EmailQueue) {
+			defer func() { <-sem; wg.Done() }()
+			if err := s.processEmail(email); err != nil {
+				slog.Error("Failed to process email", "email", email.ID, "error", err)
+			}
+		}(&emails[i])
 	}
+	wg.Wait()
 
-	return nil
+	return ctx.Err()
 }
 
-// processEmail processes a single email
-func (s *Service) processEmail(email *EmailQueue) error {
-	// Mark as sending
+// batchSize is how many emails one run of the queue picks up
+func (s *Service) batchSize() int {
+	if size := s.cfg.GetInt("email.batch_size"); size > 0 {
+		return size
+	}
+	return 10
+}
+
+// claim marks a pending email as sending, reporting false when another
+// worker got to it first
+func (s *Service) claim(email *EmailQueue) (bool, error) {
+	now := time.Now()
+	result := s.db.Model(&EmailQueue{}).
+		Where("id = ? AND status = ?", email.ID, EmailStatusPending).
+		Updates(map[string]interface{}{
+			"status":     EmailStatusSending,
+			"attempts":   gorm.Expr("attempts + 1"),
+			"updated_at": now,
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
 	email.Status = EmailStatusSending
 	email.Attempts++
-	if err := s.db.Save(email).Error; err != nil {
-		return fmt.Errorf("failed to update email status: %w", err)
-	}
+	email.UpdatedAt = now
+	return true, nil
+}
 
+// processEmail sends a claimed email and records the outcome
+func (s *Service) processEmail(email *EmailQueue) error {
 	// Send the email
 	if err := s.mailer.SendEmail(email); err != nil {
 		// Mark as failed
@@ -208,7 +292,7 @@ func (s *Service) processEmail(email *EmailQueue) error {
 		// Schedule retry if attempts remaining
 		if email.Attempts < email.MaxAttempts {
 			email.Status = EmailStatusPending
-			// Exponential backoff: 5min, 15min, 45min
+			// Exponential backoff: 5min, 20min, 45min
 			retryDelay := time.Duration(5*email.Attempts*email.Attempts) * time.Minute
 			scheduledAt := time.Now().Add(retryDelay)
 			email.ScheduledAt = &scheduledAt
@@ -254,7 +338,7 @@ func (s *Service) sendTemplatedEmail(emailType EmailType, toEmail, toName string
 	// Render subject with data
 	renderedSubject, err := RenderSubject(subject, data)
 	if err != nil {
-		log.Printf("Failed to render subject template, using default: %v", err)
+		slog.Warn("Failed to render subject template, using default", "error", err)
 		renderedSubject = subject
 	}
 
@@ -263,8 +347,8 @@ func (s *Service) sendTemplatedEmail(emailType EmailType, toEmail, toName string
 		Type:      emailType,
 		ToEmail:   toEmail,
 		ToName:    toName,
-		FromEmail: s.cfg.GetString("email.from_email"),
-		FromName:  s.cfg.GetString("email.from_name"),
+		FromEmail: FromAddress(s.cfg),
+		FromName:  FromName(s.cfg),
 		Subject:   renderedSubject,
 		BodyHTML:  htmlBody,
 		BodyText:  textBody,
@@ -277,7 +361,7 @@ func (s *Service) sendTemplatedEmail(emailType EmailType, toEmail, toName string
 // getEmailPriority returns priority for email type
 func (s *Service) getEmailPriority(emailType EmailType) int {
 	switch emailType {
-	case EmailTypeVerification, EmailTypePasswordReset:
+	case EmailTypeVerification, EmailTypePasswordReset, EmailTypeEmailChange, EmailTypeEmailChanged:
 		return 1 // Highest priority
 	case EmailTypeWelcome:
 		return 2
@@ -285,18 +369,40 @@ func (s *Service) getEmailPriority(emailType EmailType) int {
 		return 3
 	case EmailTypeGistStarred, EmailTypeGistForked, EmailTypeUserFollowed:
 		return 5 // Normal priority
-	case EmailTypeWeeklyDigest:
+	case EmailTypeWeeklyDigest, EmailTypeNewsletter:
 		return 8 // Lower priority
 	default:
 		return 5
 	}
 }
 
+// notificationDelivery is how a notification email reaches a user
+type notificationDelivery int
+
+const (
+	deliverNone notificationDelivery = iota
+	deliverNow
+	deliverDigest
+)
+
+// deliveryFor works out whether a notification is mailed now, held for
+// the weekly digest or not sent at all
+func (s *Service) deliveryFor(userID uuid.UUID, notificationType string) notificationDelivery {
+	if !s.userWantsNotification(userID, notificationType) {
+		return deliverNone
+	}
+	preference, err := s.GetEmailPreference(userID)
+	if err != nil || !preference.DigestNotifications {
+		return deliverNow
+	}
+	return deliverDigest
+}
+
 // userWantsNotification checks if user wants specific notification
 func (s *Service) userWantsNotification(userID uuid.UUID, notificationType string) bool {
-	var preference EmailPreference
-	if err := s.db.Where("user_id = ?", userID).First(&preference).Error; err != nil {
-		// If no preference found, assume user wants notifications
+	preference, err := s.GetEmailPreference(userID)
+	if err != nil {
+		// If preferences can't be read, assume user wants notifications
 		return true
 	}
 
@@ -318,9 +424,72 @@ func (s *Service) userWantsNotification(userID uuid.UUID, notificationType strin
 	}
 }
 
-// CreateEmailPreference creates email preferences for a user
-func (s *Service) CreateEmailPreference(userID uuid.UUID) error {
-	preference := &EmailPreference{
+// holdForDigest saves an activity notification for the next weekly digest
+func (s *Service) holdForDigest(userID uuid.UUID, emailType EmailType, summary, url string) error {
+	if !s.cfg.GetBool("email.enabled") {
+		return nil
+	}
+	return s.db.Create(&EmailDigestItem{
+		UserID:  userID,
+		Type:    emailType,
+		Summary: summary,
+		URL:     url,
+	}).Error
+}
+
+// PendingDigestItems returns the activity held for a user's next digest,
+// oldest first
+func (s *Service) PendingDigestItems(userID uuid.UUID) ([]EmailDigestItem, error) {
+	var items []EmailDigestItem
+	err := s.db.Where("user_id = ? AND sent_at IS NULL", userID).
+		Order("created_at ASC").
+		Find(&items).Error
+	return items, err
+}
+
+// SendWeeklyDigest queues the weekly digest for a user who wants it. data
+// carries the week's stats; the activity held back since the last digest
+// is added as Activity, and marked sent once the digest is queued.
+func (s *Service) SendWeeklyDigest(userID uuid.UUID, toEmail, toName string, data EmailData) error {
+	preference, err := s.GetEmailPreference(userID)
+	if err != nil {
+		return err
+	}
+	if !preference.WantsDigest() {
+		return nil
+	}
+
+	items, err := s.PendingDigestItems(userID)
+	if err != nil {
+		return fmt.Errorf("failed to load digest activity: %w", err)
+	}
+	if data == nil {
+		data = EmailData{}
+	}
+	listed := items
+	if len(listed) > maxDigestActivity {
+		listed = listed[:maxDigestActivity]
+		data["ActivityMore"] = len(items) - maxDigestActivity
+	}
+	data["Activity"] = listed
+
+	if err := s.sendTemplatedEmail(EmailTypeWeeklyDigest, toEmail, toName, data); err != nil {
+		return err
+	}
+	if len(items) == 0 {
+		return nil
+	}
+
+	ids := make([]uuid.UUID, len(items))
+	for i, item := range items {
+		ids[i] = item.ID
+	}
+	return s.db.Model(&EmailDigestItem{}).Where("id IN ?", ids).Update("sent_at", time.Now()).Error
+}
+
+// defaultEmailPreference is what a user without saved preferences gets
+func defaultEmailPreference(userID uuid.UUID) *EmailPreference {
+	return &EmailPreference{
 		UserID:              userID,
 		EmailVerified:       false,
 		NotifyGistStarred:   true,
@@ -329,17 +498,29 @@ func (s *Service) CreateEmailPreference(userID uuid.UUID) error {
 		NotifyFollowed:      true,
 		NotifyWeeklyDigest:  false,
 		NotifySystemAlerts:  true,
+		DigestNotifications: false,
 	}
+}
 
-	return s.db.Create(preference).Error
+// CreateEmailPreference creates email preferences for a user
+func (s *Service) CreateEmailPreference(userID uuid.UUID) error {
+	return s.db.Create(defaultEmailPreference(userID)).Error
 }
 
-// UpdateEmailPreference updates user email preferences
+// UpdateEmailPreference updates user email preferences, saving the
+// defaults first for a user who has none yet
 func (s *Service) UpdateEmailPreference(userID uuid.UUID, updates map[string]bool) error {
-	var preference EmailPreference
-	if err := s.db.Where("user_id = ?", userID).First(&preference).Error; err != nil {
+	preference, err := s.GetEmailPreference(userID)
+	if err != nil {
 		return err
 	}
+	// Create skips false fields in favour of the column defaults, so the
+	// row is saved with the defaults before the updates go on top
+	if preference.ID == uuid.Nil {
+		if err := s.db.Create(preference).Error; err != nil {
+			return err
+		}
+	}
 
 	if value, ok := updates["notify_gist_starred"]; ok {
 		preference.NotifyGistStarred = value
@@ -359,76 +540,27 @@ func (s *Service) UpdateEmailPreference(userID uuid.UUID, updates map[string]boo
 	if value, ok := updates["notify_system_alerts"]; ok {
 		preference.NotifySystemAlerts = value
 	}
+	if value, ok := updates["digest_notifications"]; ok {
+		preference.DigestNotifications = value
+	}
 
-	return s.db.Save(&preference).Error
+	return s.db.Save(preference).Error
 }
 
-// GetEmailPreference gets user email preferences
+// GetEmailPreference gets user email preferences. A user who never saved
+// any gets the defaults, with a nil ID.
 func (s *Service) GetEmailPreference(userID uuid.UUID) (*EmailPreference, error) {
 	var preference EmailPreference
-	if err := s.db.Where("user_id = ?", userID).First(&preference).Error; err != nil {
+	err := s.db.Where("user_id = ?", userID).First(&preference).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return defaultEmailPreference(userID), nil
+	}
+	if err != nil {
 		return nil, err
 	}
 	return &preference, nil
 }
 
-// VerifyEmail verifies an email address using token
-func (s *Service) VerifyEmail(token string) error {
-	var verificationToken EmailVerificationToken
-	if err := s.db.Where("token = ? AND used = ? AND expires_at > ?",
-		token, false, time.Now()).First(&verificationToken).Error; err != nil {
-		return fmt.Errorf("invalid or expired verification token")
-	}
-
-	// Mark token as used
-	verificationToken.Used = true
-	usedAt := time.Now()
-	verificationToken.UsedAt = &usedAt
-
-	if err := s.db.Save(&verificationToken).Error; err != nil {
-		return fmt.Errorf("failed to mark token as used: %w", err)
-	}
-
-	// Update email preference to mark email as verified
-	return s.db.Model(&EmailPreference{}).
-		Where("user_id = ?", verificationToken.UserID).
-		Update("email_verified", true).Error
-}
-
-// VerifyPasswordResetToken verifies a password reset token
-func (s *Service) VerifyPasswordResetToken(token string) (*PasswordResetToken, error) {
-	var resetToken PasswordResetToken
-	if err := s.db.Where("token = ? AND used = ? AND expires_at > ?",
-		token, false, time.Now()).First(&resetToken).Error; err != nil {
-		return nil, fmt.Errorf("invalid or expired reset token")
-	}
-
-	return &resetToken, nil
-}
-
-// UsePasswordResetToken marks a password reset token as used
-func (s *Service) UsePasswordResetToken(token string) error {
-	var resetToken PasswordResetToken
-	if err := s.db.Where("token = ?", token).First(&resetToken).Error; err != nil {
-		return fmt.Errorf("token not found")
-	}
-
-	resetToken.Used = true
-	usedAt := time.Now()
-	resetToken.UsedAt = &usedAt
-
-	return s.db.Save(&resetToken).Error
-}
-
-// generateToken generates a secure random token
-func (s *Service) generateToken() (string, error) {
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		return "", err
-	}
-	return hex.EncodeToString(bytes), nil
-}
-
 // TestEmailConfiguration tests email configuration
 func (s *Service) TestEmailConfiguration() error {
 	return s.mailer.TestConnection()
@@ -441,9 +573,12 @@ func (s *Service) SendTestEmail(toEmail, toName string) error {
 
 // SendGistCommentNotification sends notification when someone comments on a gist
 func (s *Service) SendGistCommentNotification(recipientID uuid.UUID, recipientEmail, recipientName, commenterName, gistTitle, commentPreview string, gistID, commentID uuid.UUID) error {
-	// Check if user wants this notification
-	if !s.userWantsNotification(recipientID, "notify_gist_commented") {
+	gistURL := urls.NewBuilder(s.cfg).Gist(gistID)
+	switch s.deliveryFor(recipientID, "notify_gist_commented") {
+	case deliverNone:
 		return nil
+	case deliverDigest:
+		return s.holdForDigest(recipientID, EmailTypeGistCommented, fmt.Sprintf("%s commented on %s", commenterName, gistTitle), gistURL)
 	}
 
 	// Truncate comment preview to 200 characters
@@ -456,7 +591,7 @@ func (s *Service) SendGistCommentNotification(recipientID uuid.UUID, recipientEm
 		"CommenterName":  commenterName,
 		"GistTitle":      gistTitle,
 		"CommentPreview": commentPreview,
-		"GistURL":        fmt.Sprintf("%s/gists/%s", s.cfg.GetString("server.url"), gistID.String()),
+		"GistURL":        gistURL,
 		"CommentID":      commentID.String(),
 		"SettingsURL":    fmt.Sprintf("%s/settings/notifications", s.cfg.GetString("server.url")),
 	}
@@ -479,10 +614,14 @@ func (s *Service) SendBackupCompleteNotification(userID uuid.UUID, email, userna
 		"UserCount":       backupStats.UserCount,
 		"StorageLocation": backupStats.StorageLocation,
 		"BackupType":      backupStats.Type,
-		"NextBackupDate":  backupStats.NextBackupDate.Format("January 2, 2006 at 3:04 PM"),
 		"AdminURL":        fmt.Sprintf("%s/admin/backup", s.cfg.GetString("server.url")),
 	}
 
+	// Without scheduled backups there is no next one to mention
+	if !backupStats.NextBackupDate.IsZero() {
+		data["NextBackupDate"] = backupStats.NextBackupDate.Format("January 2, 2006 at 3:04 PM")
+	}
+
 	// Add download URL if available
 	if backupStats.DownloadURL != "" {
 		data["DownloadURL"] = backupStats.DownloadURL
@@ -491,6 +630,24 @@ func (s *Service) SendBackupCompleteNotification(userID uuid.UUID, email, userna
 	return s.sendTemplatedEmail(EmailTypeBackupComplete, email, username, data)
 }
 
+// SendSystemAlertNotification sends an alerting engine notification to an administrator
+func (s *Service) SendSystemAlertNotification(userID uuid.UUID, email, username, title, message string) error {
+	if !s.userWantsNotification(userID, "notify_system_alerts") {
+		return nil
+	}
+
+	data := EmailData{
+		"UserName":     username,
+		"AlertTitle":   title,
+		"AlertMessage": message,
+		"ActionURL":    fmt.Sprintf("%s/admin/dashboard", s.cfg.GetString("server.url")),
+		"ActionLabel":  "Open admin dashboard",
+		"SupportEmail": FromAddress(s.cfg),
+	}
+
+	return s.sendTemplatedEmail(EmailTypeSystemAlert, email, username, data)
+}
+
 // SendMigrationCompleteNotification sends notification when migration is completed
 func (s *Service) SendMigrationCompleteNotification(userID uuid.UUID, email, username string, migrationStats MigrationStats) error {
 	data := EmailData{
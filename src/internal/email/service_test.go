@@ -6,11 +6,13 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"gopkg.in/gomail.v2"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 func setupEmailTestDB(t *testing.T) *gorm.DB {
@@ -30,6 +32,7 @@ func setupEmailTestDB(t *testing.T) *gorm.DB {
 		subject TEXT NOT NULL,
 		body_text TEXT,
 		body_html TEXT,
+		headers TEXT,
 		priority INTEGER DEFAULT 5,
 		status TEXT DEFAULT 'pending',
 		attempts INTEGER DEFAULT 0,
@@ -51,29 +54,19 @@ func setupEmailTestDB(t *testing.T) *gorm.DB {
 		notify_followed BOOLEAN DEFAULT TRUE,
 		notify_weekly_digest BOOLEAN DEFAULT FALSE,
 		notify_system_alerts BOOLEAN DEFAULT TRUE,
+		digest_notifications BOOLEAN DEFAULT FALSE,
+		last_digest_at DATETIME,
 		created_at DATETIME,
 		updated_at DATETIME
 	)`)
 
-	db.Exec(`CREATE TABLE email_verification_tokens (
-		id TEXT PRIMARY KEY,
-		user_id TEXT NOT NULL,
-		email TEXT NOT NULL,
-		token TEXT NOT NULL UNIQUE,
-		used BOOLEAN DEFAULT FALSE,
-		expires_at DATETIME,
-		used_at DATETIME,
-		created_at DATETIME
-	)`)
-
-	db.Exec(`CREATE TABLE password_reset_tokens (
+	db.Exec(`CREATE TABLE email_digest_items (
 		id TEXT PRIMARY KEY,
 		user_id TEXT NOT NULL,
-		email TEXT NOT NULL,
-		token TEXT NOT NULL UNIQUE,
-		used BOOLEAN DEFAULT FALSE,
-		expires_at DATETIME,
-		used_at DATETIME,
+		type TEXT NOT NULL,
+		summary TEXT NOT NULL,
+		url TEXT,
+		sent_at DATETIME,
 		created_at DATETIME
 	)`)
 
@@ -82,7 +75,7 @@ func setupEmailTestDB(t *testing.T) *gorm.DB {
 
 func TestEmailService(t *testing.T) {
 	db := setupEmailTestDB(t)
-	cfg := viper.New()
+	cfg := config.New()
 	cfg.Set("email.enabled", true)
 	cfg.Set("email.from_email", "test@example.com")
 	cfg.Set("email.from_name", "Test Service")
@@ -144,87 +137,27 @@ func TestEmailService(t *testing.T) {
 	})
 
 	t.Run("SendVerificationEmail", func(t *testing.T) {
-		err := service.SendVerificationEmail(userID, "test@example.com", "testuser")
+		expiresAt := time.Now().Add(24 * time.Hour)
+		err := service.SendVerificationEmail("test@example.com", "testuser", "https://test.example.com/verify/abc123", expiresAt)
 		assert.NoError(t, err)
 
-		// Check that token was created
-		var token EmailVerificationToken
-		err = db.Where("user_id = ?", userID).First(&token).Error
-		assert.NoError(t, err)
-		assert.False(t, token.Used)
-		assert.True(t, token.ExpiresAt.After(time.Now()))
-
-		// Check that email was queued
 		var email EmailQueue
 		err = db.Where("type = ? AND to_email = ?", EmailTypeVerification, "test@example.com").First(&email).Error
 		assert.NoError(t, err)
 		assert.Equal(t, EmailStatusPending, email.Status)
-		assert.Contains(t, email.BodyHTML, token.Token)
-	})
-
-	t.Run("VerifyEmail", func(t *testing.T) {
-		// Create a verification token
-		token := &EmailVerificationToken{
-			UserID:    userID,
-			Email:     "test@example.com",
-			Token:     "test-token-123",
-			ExpiresAt: time.Now().Add(24 * time.Hour),
-		}
-		require.NoError(t, db.Create(token).Error)
-
-		err := service.VerifyEmail("test-token-123")
-		assert.NoError(t, err)
-
-		// Check that token was marked as used
-		var updatedToken EmailVerificationToken
-		err = db.Where("token = ?", "test-token-123").First(&updatedToken).Error
-		assert.NoError(t, err)
-		assert.True(t, updatedToken.Used)
-		assert.NotNil(t, updatedToken.UsedAt)
+		assert.Contains(t, email.BodyHTML, "https://test.example.com/verify/abc123")
 	})
 
 	t.Run("SendPasswordResetEmail", func(t *testing.T) {
-		err := service.SendPasswordResetEmail(userID, "test@example.com", "testuser")
-		assert.NoError(t, err)
-
-		// Check that token was created
-		var token PasswordResetToken
-		err = db.Where("user_id = ?", userID).First(&token).Error
+		expiresAt := time.Now().Add(time.Hour)
+		err := service.SendPasswordResetEmail("test@example.com", "testuser", "https://test.example.com/reset/xyz789", expiresAt)
 		assert.NoError(t, err)
-		assert.False(t, token.Used)
-		assert.True(t, token.ExpiresAt.After(time.Now()))
 
-		// Check that email was queued
 		var email EmailQueue
 		err = db.Where("type = ? AND to_email = ?", EmailTypePasswordReset, "test@example.com").First(&email).Error
 		assert.NoError(t, err)
 		assert.Equal(t, EmailStatusPending, email.Status)
-	})
-
-	t.Run("VerifyPasswordResetToken", func(t *testing.T) {
-		// Create a password reset token
-		token := &PasswordResetToken{
-			UserID:    userID,
-			Email:     "test@example.com",
-			Token:     "reset-token-123",
-			ExpiresAt: time.Now().Add(1 * time.Hour),
-		}
-		require.NoError(t, db.Create(token).Error)
-
-		verifiedToken, err := service.VerifyPasswordResetToken("reset-token-123")
-		assert.NoError(t, err)
-		assert.Equal(t, userID, verifiedToken.UserID)
-
-		// Use the token
-		err = service.UsePasswordResetToken("reset-token-123")
-		assert.NoError(t, err)
-
-		// Check that token was marked as used
-		var updatedToken PasswordResetToken
-		err = db.Where("token = ?", "reset-token-123").First(&updatedToken).Error
-		assert.NoError(t, err)
-		assert.True(t, updatedToken.Used)
-		assert.NotNil(t, updatedToken.UsedAt)
+		assert.Contains(t, email.BodyText, "https://test.example.com/reset/xyz789")
 	})
 
 	t.Run("SendGistStarredNotification", func(t *testing.T) {
@@ -282,6 +215,113 @@ func TestEmailService(t *testing.T) {
 	})
 }
 
+func TestEmailPreferenceDefaults(t *testing.T) {
+	db := setupEmailTestDB(t)
+	service := NewService(db, config.New())
+	userID := uuid.New()
+
+	// Without a saved row the defaults apply
+	pref, err := service.GetEmailPreference(userID)
+	require.NoError(t, err)
+	assert.Equal(t, uuid.Nil, pref.ID)
+	assert.True(t, pref.NotifyGistStarred)
+	assert.False(t, pref.DigestNotifications)
+
+	// Updating saves the row, keeping false values
+	require.NoError(t, service.UpdateEmailPreference(userID, map[string]bool{
+		"notify_gist_starred":  false,
+		"digest_notifications": true,
+	}))
+	pref, err = service.GetEmailPreference(userID)
+	require.NoError(t, err)
+	assert.NotEqual(t, uuid.Nil, pref.ID)
+	assert.False(t, pref.NotifyGistStarred)
+	assert.True(t, pref.NotifyFollowed)
+	assert.True(t, pref.DigestNotifications)
+	assert.True(t, pref.WantsDigest())
+}
+
+func TestDigestNotifications(t *testing.T) {
+	db := setupEmailTestDB(t)
+	cfg := config.New()
+	cfg.Set("email.enabled", true)
+	cfg.Set("server.url", "https://test.example.com")
+	service := NewService(db, cfg)
+
+	userID := uuid.New()
+	require.NoError(t, service.UpdateEmailPreference(userID, map[string]bool{"digest_notifications": true}))
+
+	require.NoError(t, service.SendGistStarredNotification(userID, "owner@example.com", "Owner", "alice", "Deploy script", uuid.New()))
+	require.NoError(t, service.SendUserFollowedNotification(userID, "owner@example.com", "Owner", "bob", uuid.New()))
+
+	var queued int64
+	db.Model(&EmailQueue{}).Count(&queued)
+	assert.Zero(t, queued, "notifications wait for the digest")
+
+	items, err := service.PendingDigestItems(userID)
+	require.NoError(t, err)
+	require.Len(t, items, 2)
+	assert.Equal(t, "alice starred Deploy script", items[0].Summary)
+	assert.Equal(t, EmailTypeUserFollowed, items[1].Type)
+
+	require.NoError(t, service.SendWeeklyDigest(userID, "owner@example.com", "Owner", EmailData{"UserName": "Owner"}))
+
+	var digest EmailQueue
+	require.NoError(t, db.Where("type = ?", EmailTypeWeeklyDigest).First(&digest).Error)
+	assert.Contains(t, digest.BodyHTML, "alice starred Deploy script")
+	assert.Contains(t, digest.BodyText, "bob followed you")
+
+	items, err = service.PendingDigestItems(userID)
+	require.NoError(t, err)
+	assert.Empty(t, items, "digested activity is not sent again")
+
+	// Users who want neither the digest nor held notifications get no digest
+	other := uuid.New()
+	require.NoError(t, service.SendWeeklyDigest(other, "other@example.com", "Other", nil))
+	db.Model(&EmailQueue{}).Where("to_email = ?", "other@example.com").Count(&queued)
+	assert.Zero(t, queued)
+}
+
+func TestProcessEmailQueueSendsOnce(t *testing.T) {
+	db := setupEmailTestDB(t)
+	cfg := config.New()
+	cfg.Set("email.enabled", true)
+	service := NewService(db, cfg)
+
+	conn := &fakeConn{}
+	service.mailer.pool = newSMTPPool(func() (gomail.SendCloser, error) { return conn, nil }, 2, time.Minute)
+
+	email := &EmailQueue{
+		Type:      EmailTypeNewsletter,
+		ToEmail:   "reader@example.com",
+		FromEmail: "news@example.com",
+		Subject:   "News",
+		BodyText:  "Hello",
+		Headers:   map[string]string{"List-Unsubscribe": "<https://example.com/unsubscribe>"},
+	}
+	require.NoError(t, service.QueueEmail(email))
+
+	// Headers survive the round trip through the queue
+	var stored EmailQueue
+	require.NoError(t, db.First(&stored, "id = ?", email.ID).Error)
+	assert.Equal(t, email.Headers, stored.Headers)
+
+	require.NoError(t, service.ProcessEmailQueue(context.Background()))
+	require.NoError(t, service.ProcessEmailQueue(context.Background()))
+	assert.Equal(t, 1, conn.sent)
+
+	require.NoError(t, db.First(&stored, "id = ?", email.ID).Error)
+	assert.Equal(t, EmailStatusSent, stored.Status)
+	assert.Equal(t, 1, stored.Attempts)
+
+	// An email stuck in sending by a dead process is claimed again
+	stuck := time.Now().Add(-time.Hour)
+	require.NoError(t, db.Model(&EmailQueue{}).Where("id = ?", email.ID).
+		Updates(map[string]interface{}{"status": EmailStatusSending, "updated_at": stuck}).Error)
+	require.NoError(t, service.ProcessEmailQueue(context.Background()))
+	assert.Equal(t, 2, conn.sent)
+}
+
 func TestTemplateRenderer(t *testing.T) {
 	renderer := NewTemplateRenderer()
 	require.NotNil(t, renderer)
@@ -325,7 +365,7 @@ func TestTemplateRenderer(t *testing.T) {
 }
 
 func TestEmailPriority(t *testing.T) {
-	cfg := viper.New()
+	cfg := config.New()
 	service := &Service{cfg: cfg}
 
 	tests := []struct {
@@ -325,6 +325,16 @@ View the original: {{.GistURL}}`,
         </ol>
         {{end}}
 
+        {{if .Activity}}
+        <h3 style="color: #2c3e50;">🔔 Activity</h3>
+        <ul>
+            {{range .Activity}}
+            <li>{{if .URL}}<a href="{{.URL}}">{{.Summary}}</a>{{else}}{{.Summary}}{{end}}</li>
+            {{end}}
+        </ul>
+        {{if .ActivityMore}}<p>…and {{.ActivityMore}} more.</p>{{end}}
+        {{end}}
+
         <div style="text-align: center; margin: 30px 0;">
             <a href="{{.DashboardURL}}" style="background-color: #3498db; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">View Dashboard</a>
         </div>
@@ -344,7 +354,11 @@ Here's what happened this week:
 - ⭐ {{.TotalStars}} total stars ({{.NewStars}} new this week)  
 - 🍴 {{.TotalForks}} total forks ({{.NewForks}} new this week)
 - 👥 {{.TotalFollowers}} followers ({{.NewFollowers}} new this week)
-
+{{if .Activity}}
+🔔 Activity
+{{range .Activity}}- {{.Summary}}{{if .URL}} ({{.URL}}){{end}}
+{{end}}{{if .ActivityMore}}…and {{.ActivityMore}} more.
+{{end}}{{end}}
 View your dashboard: {{.DashboardURL}}
 
 You can adjust your email preferences in your account settings: {{.SettingsURL}}`,
@@ -513,7 +527,7 @@ You can adjust your notification settings in your account settings: {{.SettingsU
         </div>
         {{end}}
         
-        <p><strong>Next scheduled backup:</strong> {{.NextBackupDate}}</p>
+        {{if .NextBackupDate}}<p><strong>Next scheduled backup:</strong> {{.NextBackupDate}}</p>{{end}}
         
         <p style="color: #666; font-size: 14px;">Backup settings can be adjusted in your <a href="{{.AdminURL}}">admin panel</a>.</p>
     </div>
@@ -535,7 +549,7 @@ Backup Details:
 
 {{if .DownloadURL}}Download backup: {{.DownloadURL}}{{end}}
 
-Next scheduled backup: {{.NextBackupDate}}
+{{if .NextBackupDate}}Next scheduled backup: {{.NextBackupDate}}{{end}}
 
 Backup settings can be adjusted in your admin panel: {{.AdminURL}}`,
 	},
@@ -615,6 +629,77 @@ What's Next?
 
 If you encounter any issues, please contact support: {{.SupportURL}}`,
 	},
+
+	EmailTypeEmailChange: {
+		HTML: `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Confirm Your New Email - CasGists</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h1 style="color: #2c3e50;">Confirm Your New Email Address</h1>
+        <p>Hello {{.UserName}},</p>
+        <p>You asked to change the email address of your CasGists account to <strong>{{.NewEmail}}</strong>. Click the button below to confirm:</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="{{.ConfirmURL}}" style="background-color: #3498db; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Confirm Email Address</a>
+        </div>
+        <p>If the button doesn't work, you can also copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; background-color: #f8f9fa; padding: 10px; border-radius: 4px;">{{.ConfirmURL}}</p>
+        <p><strong>This link will expire on {{.ExpiresAt}}.</strong></p>
+        <hr style="border: none; border-top: 1px solid #eee; margin: 30px 0;">
+        <p style="color: #666; font-size: 14px;">If you didn't request this change, you can safely ignore this email. Your account email will not change.</p>
+    </div>
+</body>
+</html>`,
+		Text: `Confirm Your New Email Address - CasGists
+
+Hello {{.UserName}},
+
+You asked to change the email address of your CasGists account to {{.NewEmail}}. Confirm the change by visiting this link:
+
+{{.ConfirmURL}}
+
+This link will expire on {{.ExpiresAt}}.
+
+If you didn't request this change, you can safely ignore this email. Your account email will not change.`,
+	},
+
+	EmailTypeEmailChanged: {
+		HTML: `<!DOCTYPE html>
+<html>
+<head>
+    <meta charset="utf-8">
+    <title>Your Email Address Was Changed - CasGists</title>
+</head>
+<body style="font-family: Arial, sans-serif; line-height: 1.6; color: #333;">
+    <div style="max-width: 600px; margin: 0 auto; padding: 20px;">
+        <h1 style="color: #e74c3c;">Your Email Address Was Changed</h1>
+        <p>Hello {{.UserName}},</p>
+        <p>The email address of your CasGists account was changed from <strong>{{.OldEmail}}</strong> to <strong>{{.NewEmail}}</strong>. Notifications will now be sent to the new address.</p>
+        <p>If you didn't make this change, revert it and sign out all sessions by clicking the button below:</p>
+        <div style="text-align: center; margin: 30px 0;">
+            <a href="{{.RevertURL}}" style="background-color: #e74c3c; color: white; padding: 12px 24px; text-decoration: none; border-radius: 4px; display: inline-block;">Revert Email Change</a>
+        </div>
+        <p>If the button doesn't work, you can also copy and paste this link into your browser:</p>
+        <p style="word-break: break-all; background-color: #f8f9fa; padding: 10px; border-radius: 4px;">{{.RevertURL}}</p>
+        <p><strong>This link can be used until {{.RevertExpiresAt}}.</strong> We also recommend changing your password.</p>
+    </div>
+</body>
+</html>`,
+		Text: `Your Email Address Was Changed - CasGists
+
+Hello {{.UserName}},
+
+The email address of your CasGists account was changed from {{.OldEmail}} to {{.NewEmail}}. Notifications will now be sent to the new address.
+
+If you didn't make this change, revert it and sign out all sessions by visiting this link:
+
+{{.RevertURL}}
+
+This link can be used until {{.RevertExpiresAt}}. We also recommend changing your password.`,
+	},
 }
 
 // GetDefaultSubjects returns default email subjects
@@ -632,6 +717,8 @@ func GetDefaultSubjects() map[EmailType]string {
 		EmailTypeGistCommented:    "💬 New comment on your gist",
 		EmailTypeBackupComplete:   "✅ Backup completed successfully",
 		EmailTypeMigrationComplete: "🎉 Migration completed successfully",
+		EmailTypeEmailChange:       "Confirm your new CasGists email address",
+		EmailTypeEmailChanged:      "Your CasGists email address was changed",
 	}
 }
 
@@ -0,0 +1,88 @@
+// Package enrichment suggests gist metadata (title, description and tags)
+// from file content, for users who leave those fields blank.
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Providers selectable with enrichment.provider
+const (
+	ProviderHeuristic = "heuristic"
+	ProviderHTTP      = "http"
+	ProviderDisabled  = "disabled"
+)
+
+// maxTags bounds the number of suggested tags
+const maxTags = 5
+
+// File is a gist file to suggest metadata for
+type File struct {
+	Filename string `json:"filename"`
+	Content  string `json:"content"`
+	Language string `json:"language,omitempty"`
+}
+
+// Suggestion is the metadata suggested for a gist. Empty fields mean there
+// is no suggestion.
+type Suggestion struct {
+	Title       string   `json:"title"`
+	Description string   `json:"description"`
+	Tags        []string `json:"tags"`
+	// Source names the hook that made the suggestion
+	Source string `json:"source"`
+}
+
+// Hook suggests metadata for a set of files
+type Hook interface {
+	Name() string
+	Suggest(ctx context.Context, files []File) (*Suggestion, error)
+}
+
+// NewHook returns the hook selected by enrichment.provider. The local
+// heuristic is the default, and the HTTP hook falls back to it when the
+// external service fails. It returns nil when suggestions are disabled.
+func NewHook(cfg *config.Config) (Hook, error) {
+	switch provider := cfg.GetString("enrichment.provider"); provider {
+	case ProviderHeuristic, "":
+		return NewHeuristicHook(), nil
+	case ProviderHTTP:
+		hook, err := NewHTTPHook(cfg, NewHeuristicHook())
+		if err != nil {
+			return nil, err
+		}
+		return hook, nil
+	case ProviderDisabled:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("unknown enrichment provider %q", provider)
+	}
+}
+
+// clean trims a suggestion to what a gist accepts
+func clean(s *Suggestion) *Suggestion {
+	s.Title = truncate(strings.Join(strings.Fields(s.Title), " "), 255)
+	s.Description = truncate(strings.TrimSpace(s.Description), 1000)
+	tags := models.NormalizeTags(s.Tags)
+	if len(tags) > maxTags {
+		tags = tags[:maxTags]
+	}
+	if tags == nil {
+		tags = []string{}
+	}
+	s.Tags = tags
+	return s
+}
+
+func truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return strings.TrimSpace(string(runes[:max-1])) + "…"
+}
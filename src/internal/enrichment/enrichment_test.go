@@ -0,0 +1,152 @@
+package enrichment
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestHeuristicHook(t *testing.T) {
+	hook := NewHeuristicHook()
+
+	tests := []struct {
+		name        string
+		files       []File
+		title       string
+		description string
+		tags        []string
+	}{
+		{
+			name:        "go doc comment after license",
+			files:       []File{{Filename: "retry.go", Content: "// Copyright 2024 Example\n// SPDX-License-Identifier: MIT\n\n// Package retry retries flaky calls\n// with exponential backoff.\npackage retry\n"}},
+			title:       "retry.go",
+			description: "Package retry retries flaky calls with exponential backoff.",
+			tags:        []string{"go"},
+		},
+		{
+			name:        "python docstring",
+			files:       []File{{Filename: "dedupe.py", Content: "#!/usr/bin/env python3\n\"\"\"\nRemove duplicate lines from a file.\n\nUsage: dedupe.py FILE\n\"\"\"\nimport sys\n"}},
+			title:       "dedupe.py",
+			description: "Remove duplicate lines from a file.",
+			tags:        []string{"python"},
+		},
+		{
+			name: "shell script with several files",
+			files: []File{
+				{Filename: "deploy.sh", Content: "#!/bin/bash\n# Deploy the site to production\nset -e\n"},
+				{Filename: "config.yaml", Content: "a: 1\n"},
+				{Filename: "notes", Content: "todo\n"},
+			},
+			title:       "deploy.sh and 2 more files",
+			description: "Deploy the site to production",
+			tags:        []string{"shell", "yaml"},
+		},
+		{
+			name: "readme first",
+			files: []File{
+				{Filename: "main.c", Content: "#include <stdio.h>\nint main() {}\n"},
+				{Filename: "README.md", Content: "# Tiny HTTP server\n\n![badge](x.svg)\nServes files from the\ncurrent directory.\n\n## Usage\n"},
+			},
+			title:       "Tiny HTTP server",
+			description: "Serves files from the current directory.",
+			tags:        []string{"c", "markdown"},
+		},
+		{
+			name:  "code without comments",
+			files: []File{{Filename: "", Content: "SELECT 1;"}, {Filename: "q.sql", Content: "SELECT 2;"}},
+			tags:  []string{"sql"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			suggestion, err := hook.Suggest(context.Background(), tt.files)
+			require.NoError(t, err)
+			assert.Equal(t, tt.title, suggestion.Title)
+			assert.Equal(t, tt.description, suggestion.Description)
+			assert.Equal(t, tt.tags, suggestion.Tags)
+			assert.Equal(t, ProviderHeuristic, suggestion.Source)
+		})
+	}
+}
+
+func TestHTTPHook(t *testing.T) {
+	var received struct {
+		Files []File `json:"files"`
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "Bearer secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"title":"  Retry   helper ","description":"Retries things","tags":["Go","go","Networking"]}`))
+	}))
+	defer server.Close()
+
+	cfg := config.New()
+	cfg.Set("enrichment.provider", ProviderHTTP)
+	cfg.Set("enrichment.url", server.URL)
+	cfg.Set("enrichment.token", "secret")
+	cfg.Set("enrichment.max_bytes", 10)
+	hook, err := NewHook(cfg)
+	require.NoError(t, err)
+
+	suggestion, err := hook.Suggest(context.Background(), []File{
+		{Filename: "a.go", Content: "0123456789abcdef"},
+		{Filename: "b.go", Content: "more"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "Retry helper", suggestion.Title)
+	assert.Equal(t, "Retries things", suggestion.Description)
+	assert.Equal(t, []string{"go", "networking"}, suggestion.Tags)
+	assert.Equal(t, ProviderHTTP, suggestion.Source)
+
+	// Only max_bytes of content is sent
+	require.Len(t, received.Files, 2)
+	assert.Equal(t, "0123456789", received.Files[0].Content)
+	assert.Equal(t, "", received.Files[1].Content)
+}
+
+func TestHTTPHookFallsBack(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	cfg := config.New()
+	cfg.Set("enrichment.provider", ProviderHTTP)
+	cfg.Set("enrichment.url", server.URL)
+	hook, err := NewHook(cfg)
+	require.NoError(t, err)
+
+	suggestion, err := hook.Suggest(context.Background(), []File{{Filename: "x.py", Content: "# Say hi\nprint('hi')\n"}})
+	require.NoError(t, err)
+	assert.Equal(t, ProviderHeuristic, suggestion.Source)
+	assert.Equal(t, "Say hi", suggestion.Description)
+}
+
+func TestNewHook(t *testing.T) {
+	cfg := config.New()
+	hook, err := NewHook(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, ProviderHeuristic, hook.Name())
+
+	cfg.Set("enrichment.provider", ProviderDisabled)
+	hook, err = NewHook(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, hook)
+
+	cfg.Set("enrichment.provider", ProviderHTTP)
+	_, err = NewHook(cfg)
+	assert.Error(t, err)
+
+	cfg.Set("enrichment.provider", "magic")
+	_, err = NewHook(cfg)
+	assert.Error(t, err)
+}
@@ -0,0 +1,251 @@
+package enrichment
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/casapps/casgists/src/internal/syntax"
+)
+
+// scanLines bounds how far into a file the heuristic looks for a header
+// comment
+const scanLines = 40
+
+// commentPrefixes are line comment markers, longest first
+var commentPrefixes = []string{"///", "//!", "//", "/**", "/*", "*/", "*", "#", "--", ";;", "%", "rem ", "REM "}
+
+// ignoredComments are header comments that don't describe the file
+var ignoredComments = []string{
+	"copyright", "spdx-license", "license", "all rights reserved", "-*-",
+	"eslint", "@ts-", "go:build", "+build", "noqa", "pylint", "prettier-ignore",
+	"vim:", "shellcheck", "nolint", "@flow",
+}
+
+// HeuristicHook suggests metadata locally: the title from a Markdown heading
+// or the file names, the description from the leading comment of the main
+// file, and tags from the detected languages
+type HeuristicHook struct {
+	detector *syntax.LanguageDetector
+}
+
+// NewHeuristicHook creates a new heuristic hook
+func NewHeuristicHook() *HeuristicHook {
+	return &HeuristicHook{detector: syntax.NewLanguageDetector()}
+}
+
+// Name returns the hook name
+func (h *HeuristicHook) Name() string {
+	return ProviderHeuristic
+}
+
+// Suggest suggests metadata for the files
+func (h *HeuristicHook) Suggest(ctx context.Context, files []File) (*Suggestion, error) {
+	suggestion := &Suggestion{Source: h.Name()}
+	main := mainFile(files)
+	if main == nil {
+		return clean(suggestion), nil
+	}
+
+	if isMarkdown(main.Filename) {
+		suggestion.Title, suggestion.Description = markdownSummary(main.Content)
+	} else {
+		suggestion.Description = headerComment(main.Content)
+	}
+	if suggestion.Title == "" && main.Filename != "" {
+		suggestion.Title = main.Filename
+		if others := len(files) - 1; others == 1 {
+			suggestion.Title += " and 1 more file"
+		} else if others > 1 {
+			suggestion.Title += fmt.Sprintf(" and %d more files", others)
+		}
+	}
+
+	for _, file := range files {
+		language := h.detector.GetLanguageByID(strings.ToLower(file.Language))
+		if language == nil {
+			language = h.detector.DetectLanguage(filepath.Base(file.Filename), file.Content)
+		}
+		if language != nil && language.ID != "text" {
+			suggestion.Tags = append(suggestion.Tags, language.ID)
+		}
+	}
+	return clean(suggestion), nil
+}
+
+// mainFile picks the file that describes the gist: a README if there is
+// one, otherwise the first file with content
+func mainFile(files []File) *File {
+	for i := range files {
+		name := strings.ToLower(filepath.Base(files[i].Filename))
+		if strings.HasPrefix(name, "readme") && strings.TrimSpace(files[i].Content) != "" {
+			return &files[i]
+		}
+	}
+	for i := range files {
+		if strings.TrimSpace(files[i].Content) != "" {
+			return &files[i]
+		}
+	}
+	return nil
+}
+
+func isMarkdown(filename string) bool {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".md", ".markdown", ".mdown", ".mkd":
+		return true
+	}
+	return false
+}
+
+// markdownSummary returns the first heading and the first paragraph after it
+func markdownSummary(content string) (title, description string) {
+	var paragraph []string
+	for _, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "#"):
+			if len(paragraph) > 0 {
+				return title, strings.Join(paragraph, " ")
+			}
+			if title == "" {
+				title = strings.TrimSpace(strings.TrimLeft(line, "#"))
+			}
+		case line == "":
+			if len(paragraph) > 0 {
+				return title, strings.Join(paragraph, " ")
+			}
+		case strings.HasPrefix(line, "```"), strings.HasPrefix(line, "!["), strings.HasPrefix(line, "[!["), strings.HasPrefix(line, "<"):
+			// Code, badges and HTML don't make a description
+		default:
+			paragraph = append(paragraph, line)
+		}
+	}
+	return title, strings.Join(paragraph, " ")
+}
+
+// headerComment returns the first paragraph of the comment at the top of a
+// source file, skipping shebangs, package clauses and license boilerplate
+func headerComment(content string) string {
+	lines := strings.Split(content, "\n")
+	if len(lines) > scanLines {
+		lines = lines[:scanLines]
+	}
+
+	var paragraph []string
+	inDocstring := ""
+	for i, raw := range lines {
+		line := strings.TrimSpace(raw)
+
+		if inDocstring != "" {
+			text, closed := strings.CutSuffix(line, inDocstring)
+			if closed {
+				inDocstring = ""
+			}
+			if text = strings.TrimSpace(text); text == "" {
+				if len(paragraph) > 0 || closed {
+					break
+				}
+				continue
+			}
+			paragraph = append(paragraph, text)
+			if closed {
+				break
+			}
+			continue
+		}
+
+		if i == 0 && strings.HasPrefix(line, "#!") {
+			continue
+		}
+		if line == "" || isPreamble(line) {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+
+		if quote := docstringQuote(line); quote != "" && len(paragraph) == 0 {
+			text := strings.TrimSpace(strings.TrimPrefix(line, quote))
+			if body, closed := strings.CutSuffix(text, quote); closed {
+				paragraph = append(paragraph, strings.TrimSpace(body))
+				break
+			}
+			inDocstring = quote
+			if text != "" {
+				paragraph = append(paragraph, text)
+			}
+			continue
+		}
+
+		text, ok := stripComment(line)
+		if !ok {
+			// Code starts; the header comment, if any, is over
+			break
+		}
+		if text == "" {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		if ignoredComment(text) {
+			if len(paragraph) > 0 {
+				break
+			}
+			continue
+		}
+		paragraph = append(paragraph, text)
+	}
+	return strings.Join(paragraph, " ")
+}
+
+// isPreamble reports lines that come before a header comment without
+// ending it
+func isPreamble(line string) bool {
+	return strings.HasPrefix(line, "package ") || line == "<?php" ||
+		line == `"use strict";` || line == `'use strict';` ||
+		strings.HasPrefix(line, "<!DOCTYPE") || strings.HasPrefix(line, "<?xml") ||
+		line == "---" || line == "<!--" || line == "-->"
+}
+
+func docstringQuote(line string) string {
+	for _, quote := range []string{`"""`, `'''`} {
+		if strings.HasPrefix(line, quote) {
+			return quote
+		}
+	}
+	return ""
+}
+
+// stripComment removes the comment markers from a line, reporting whether
+// the line was a comment at all
+func stripComment(line string) (string, bool) {
+	if strings.HasPrefix(line, "<!--") {
+		return strings.TrimSpace(strings.TrimSuffix(strings.TrimPrefix(line, "<!--"), "-->")), true
+	}
+	for _, directive := range []string{"#include", "#define", "#import", "#pragma", "#if", "#region"} {
+		if strings.HasPrefix(line, directive) {
+			return "", false
+		}
+	}
+	for _, prefix := range commentPrefixes {
+		if strings.HasPrefix(line, prefix) {
+			text := strings.TrimPrefix(line, prefix)
+			text = strings.TrimSuffix(strings.TrimSpace(text), "*/")
+			return strings.TrimSpace(strings.Trim(text, "#*/-=")), true
+		}
+	}
+	return "", false
+}
+
+func ignoredComment(text string) bool {
+	lower := strings.ToLower(text)
+	for _, marker := range ignoredComments {
+		if strings.Contains(lower, marker) {
+			return true
+		}
+	}
+	return false
+}
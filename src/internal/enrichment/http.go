@@ -0,0 +1,113 @@
+package enrichment
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// maxResponseBytes bounds the suggestion a service may return
+const maxResponseBytes = 64 * 1024
+
+// HTTPHook asks an external service for suggestions. The service receives
+// {"files": [{"filename", "content", "language"}]} and answers with
+// {"title", "description", "tags"}; any field may be left out. When the
+// service fails the fallback hook is used instead.
+type HTTPHook struct {
+	url      string
+	token    string
+	maxBytes int
+	client   *http.Client
+	fallback Hook
+}
+
+// NewHTTPHook creates a hook for the service at enrichment.url
+func NewHTTPHook(cfg *config.Config, fallback Hook) (*HTTPHook, error) {
+	url := cfg.GetString("enrichment.url")
+	if url == "" {
+		return nil, fmt.Errorf("enrichment.url is required for the http provider")
+	}
+	timeout := cfg.GetDuration("enrichment.timeout")
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	maxBytes := cfg.GetInt("enrichment.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = 64 * 1024
+	}
+	return &HTTPHook{
+		url:      url,
+		token:    cfg.GetString("enrichment.token"),
+		maxBytes: maxBytes,
+		client:   &http.Client{Timeout: timeout},
+		fallback: fallback,
+	}, nil
+}
+
+// Name returns the hook name
+func (h *HTTPHook) Name() string {
+	return ProviderHTTP
+}
+
+// Suggest asks the service, falling back when it can't answer
+func (h *HTTPHook) Suggest(ctx context.Context, files []File) (*Suggestion, error) {
+	suggestion, err := h.request(ctx, files)
+	if err == nil {
+		return suggestion, nil
+	}
+	if h.fallback == nil {
+		return nil, err
+	}
+	slog.Warn("Metadata suggestion service failed", "fallback", h.fallback.Name(), "error", err)
+	return h.fallback.Suggest(ctx, files)
+}
+
+func (h *HTTPHook) request(ctx context.Context, files []File) (*Suggestion, error) {
+	// Only the first max_bytes of content leave the server
+	budget := h.maxBytes
+	sent := make([]File, 0, len(files))
+	for _, file := range files {
+		if len(file.Content) > budget {
+			file.Content = file.Content[:budget]
+		}
+		budget -= len(file.Content)
+		sent = append(sent, file)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{"files": sent})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, h.url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CasGists-Enrichment/1.0")
+	if h.token != "" {
+		req.Header.Set("Authorization", "Bearer "+h.token)
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("service returned HTTP %d", resp.StatusCode)
+	}
+
+	var suggestion Suggestion
+	if err := json.NewDecoder(io.LimitReader(resp.Body, maxResponseBytes)).Decode(&suggestion); err != nil {
+		return nil, fmt.Errorf("invalid response: %w", err)
+	}
+	suggestion.Source = h.Name()
+	return clean(&suggestion), nil
+}
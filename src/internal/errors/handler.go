@@ -10,20 +10,21 @@ import (
 
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // ErrorHandler provides comprehensive error handling for CasGists
 type ErrorHandler struct {
-	config     *viper.Viper
+	config     *config.Config
 	db         *gorm.DB
 	production bool
 	logger     ErrorLogger
 }
 
 // NewErrorHandler creates a new error handler
-func NewErrorHandler(config *viper.Viper, db *gorm.DB) *ErrorHandler {
+func NewErrorHandler(config *config.Config, db *gorm.DB) *ErrorHandler {
 	return &ErrorHandler{
 		config:     config,
 		db:         db,
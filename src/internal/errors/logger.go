@@ -5,6 +5,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -12,13 +13,14 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // ErrorLogger handles comprehensive error logging
 type ErrorLogger struct {
-	config     *viper.Viper
+	config     *config.Config
 	db         *gorm.DB
 	fileLogger *log.Logger
 	logFile    *os.File
@@ -65,7 +67,7 @@ type ErrorLogEntry struct {
 }
 
 // NewErrorLogger creates a new error logger
-func NewErrorLogger(config *viper.Viper, db *gorm.DB) ErrorLogger {
+func NewErrorLogger(config *config.Config, db *gorm.DB) ErrorLogger {
 	logger := ErrorLogger{
 		config: config,
 		db:     db,
@@ -93,7 +95,7 @@ func (l *ErrorLogger) initializeFileLogger() {
 	
 	// Create log directory
 	if err := os.MkdirAll(logDir, 0755); err != nil {
-		log.Printf("Failed to create log directory: %v", err)
+		slog.Error("Failed to create log directory", "error", err)
 		return
 	}
 	
@@ -101,7 +103,7 @@ func (l *ErrorLogger) initializeFileLogger() {
 	logPath := filepath.Join(logDir, "errors.log")
 	file, err := os.OpenFile(logPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
 	if err != nil {
-		log.Printf("Failed to open error log file: %v", err)
+		slog.Error("Failed to open error log file", "error", err)
 		return
 	}
 	
@@ -114,7 +116,7 @@ func (l *ErrorLogger) initializeDatabase() {
 	if l.db != nil {
 		// Auto-migrate error log table
 		if err := l.db.AutoMigrate(&ErrorLogEntry{}); err != nil {
-			log.Printf("Failed to migrate error log table: %v", err)
+			slog.Error("Failed to migrate error log table", "error", err)
 		}
 	}
 }
@@ -316,7 +318,7 @@ func (l *ErrorLogger) logToDatabase(entry ErrorLogEntry) {
 	// Log to database in background to avoid blocking
 	go func() {
 		if err := l.db.Create(&entry).Error; err != nil {
-			log.Printf("Failed to log error to database: %v", err)
+			slog.Error("Failed to log error to database", "error", err)
 		}
 	}()
 }
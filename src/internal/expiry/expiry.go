@@ -0,0 +1,80 @@
+package expiry
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Janitor deletes gists past their expiry, including burn-after-read gists
+// that have been viewed. Expired gists are hidden as soon as they expire;
+// the janitor soft deletes them, leaving the deleted gist retention policy
+// to remove them for good.
+type Janitor struct {
+	db     *gorm.DB
+	config *config.Config
+	stop   chan bool
+}
+
+// NewJanitor creates a new expiry janitor
+func NewJanitor(db *gorm.DB, config *config.Config) *Janitor {
+	return &Janitor{
+		db:     db,
+		config: config,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Sweep deletes the gists that have expired and returns how many went
+func (j *Janitor) Sweep(ctx context.Context) (int64, error) {
+	result := j.db.WithContext(ctx).
+		Where("expires_at IS NOT NULL AND expires_at <= ?", time.Now()).
+		Delete(&models.Gist{})
+	return result.RowsAffected, result.Error
+}
+
+// Start sweeps expired gists every gists.expiry.sweep_interval until the
+// context is cancelled or Stop is called
+func (j *Janitor) Start(ctx context.Context) {
+	ticker := time.NewTicker(j.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-j.stop:
+			return
+		case <-ticker.C:
+			deleted, err := j.Sweep(ctx)
+			if err != nil {
+				slog.Error("Expired gist sweep failed", "error", err)
+			} else if deleted > 0 {
+				log.Printf("Deleted %d expired gists", deleted)
+			}
+		}
+	}
+}
+
+// Stop stops sweeping
+func (j *Janitor) Stop() {
+	select {
+	case j.stop <- true:
+	default:
+	}
+}
+
+// interval returns the pause between sweeps (default: 1 minute)
+func (j *Janitor) interval() time.Duration {
+	interval := j.config.GetDuration("gists.expiry.sweep_interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval
+}
@@ -0,0 +1,66 @@
+package expiry
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestSweep(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistFile{}))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	newGist := func(title string, expiresIn time.Duration, burn bool) *models.Gist {
+		gist := &models.Gist{Title: title, UserID: &user.ID, BurnAfterRead: burn}
+		if expiresIn != 0 {
+			at := time.Now().Add(expiresIn)
+			gist.ExpiresAt = &at
+		}
+		require.NoError(t, db.Create(gist).Error)
+		return gist
+	}
+	expired := newGist("expired", -time.Minute, false)
+	newGist("later", time.Hour, false)
+	newGist("forever", 0, false)
+	burn := newGist("burn", 0, true)
+
+	// Hidden from queries as soon as they expire
+	var visible []string
+	require.NoError(t, db.Model(&models.Gist{}).Scopes(models.HideExpired).Order("title").Pluck("title", &visible).Error)
+	assert.Equal(t, []string{"burn", "forever", "later"}, visible)
+
+	// Only the first read burns the gist
+	burned, err := models.BurnGist(db, burn.ID)
+	require.NoError(t, err)
+	assert.True(t, burned)
+	burned, err = models.BurnGist(db, burn.ID)
+	require.NoError(t, err)
+	assert.False(t, burned)
+	burned, err = models.BurnGist(db, expired.ID)
+	require.NoError(t, err)
+	assert.False(t, burned, "gists that don't burn after reading are left alone")
+
+	deleted, err := NewJanitor(db, config.New()).Sweep(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), deleted)
+
+	var titles []string
+	require.NoError(t, db.Model(&models.Gist{}).Order("title").Pluck("title", &titles).Error)
+	assert.Equal(t, []string{"forever", "later"}, titles)
+	var kept int64
+	db.Unscoped().Model(&models.Gist{}).Where("id = ?", expired.ID).Count(&kept)
+	assert.Equal(t, int64(1), kept, "expired gists are soft deleted")
+}
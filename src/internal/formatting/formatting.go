@@ -0,0 +1,305 @@
+// Package formatting runs external formatters and linters (gofmt, black,
+// prettier and the like) over gist files when they are saved. Formatters
+// either rewrite the content or, in annotate mode, only report that the
+// file isn't formatted; linters attach their messages to the file as
+// annotations shown in the viewer.
+package formatting
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Modes selectable with formatting.mode
+const (
+	ModeFormat   = "format"   // Replace file content with the formatter output
+	ModeAnnotate = "annotate" // Keep content as written and annotate unformatted files
+)
+
+// Tool kinds
+const (
+	KindFormatter = "formatter" // Reads the file on stdin and writes it formatted to stdout
+	KindLinter    = "linter"    // Reads the file on stdin and reports problems as file:line[:col]: message
+)
+
+// maxAnnotations bounds the annotations kept per file
+const maxAnnotations = 100
+
+// Tool is an external formatter or linter
+type Tool struct {
+	Name       string   `mapstructure:"name"`
+	Kind       string   `mapstructure:"kind"`
+	Languages  []string `mapstructure:"languages"`  // Matched against the file's language, case-insensitively
+	Extensions []string `mapstructure:"extensions"` // Matched against the filename, e.g. ".go"
+	Command    []string `mapstructure:"command"`    // {filename} is replaced by the file's base name
+}
+
+// matches reports whether the tool handles the file
+func (t Tool) matches(filename, language string) bool {
+	for _, l := range t.Languages {
+		if language != "" && strings.EqualFold(l, language) {
+			return true
+		}
+	}
+	ext := strings.ToLower(filepath.Ext(filename))
+	for _, e := range t.Extensions {
+		if ext != "" && strings.EqualFold(e, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// Result is what the tools made of a file
+type Result struct {
+	Content     string
+	Formatted   bool // Content was changed by a formatter
+	Annotations models.LintAnnotations
+}
+
+// Runner runs the configured tools in a sandbox: a scratch working
+// directory, a minimal environment, a timeout, capped output and,
+// optionally, a wrapper command such as bwrap or firejail.
+type Runner struct {
+	mode     string
+	tools    []Tool
+	sandbox  []string
+	timeout  time.Duration
+	maxBytes int
+}
+
+// NewRunner creates a runner from the formatting.* settings. It returns
+// nil when formatting hooks are disabled or no tools are configured.
+func NewRunner(cfg *config.Config) (*Runner, error) {
+	if !cfg.GetBool("formatting.enabled") {
+		return nil, nil
+	}
+
+	mode := cfg.GetString("formatting.mode")
+	switch mode {
+	case ModeFormat, ModeAnnotate:
+	case "":
+		mode = ModeAnnotate
+	default:
+		return nil, fmt.Errorf("unknown formatting mode %q", mode)
+	}
+
+	var tools []Tool
+	if err := cfg.UnmarshalKey("formatting.tools", &tools); err != nil {
+		return nil, fmt.Errorf("invalid formatting.tools: %w", err)
+	}
+	for i, tool := range tools {
+		if tool.Name == "" || len(tool.Command) == 0 {
+			return nil, fmt.Errorf("formatting.tools[%d]: name and command are required", i)
+		}
+		if tool.Kind != KindFormatter && tool.Kind != KindLinter {
+			return nil, fmt.Errorf("formatting.tools[%d]: kind must be formatter or linter", i)
+		}
+	}
+	if len(tools) == 0 {
+		return nil, nil
+	}
+
+	timeout := cfg.GetDuration("formatting.timeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxBytes := cfg.GetInt("formatting.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = 256 * 1024
+	}
+	return &Runner{
+		mode:     mode,
+		tools:    tools,
+		sandbox:  cfg.GetStringSlice("formatting.sandbox"),
+		timeout:  timeout,
+		maxBytes: maxBytes,
+	}, nil
+}
+
+// Process runs the tools that handle the file. Formatters run first, in
+// the order configured, and linters see the formatted content. A tool that
+// is missing, times out or crashes is logged and skipped; it never stops
+// the file from being saved.
+func (r *Runner) Process(ctx context.Context, filename, language, content string) Result {
+	result := Result{Content: content}
+	if r == nil || len(content) == 0 || len(content) > r.maxBytes {
+		return result
+	}
+
+	for _, kind := range []string{KindFormatter, KindLinter} {
+		for _, tool := range r.tools {
+			if tool.Kind != kind || !tool.matches(filename, language) {
+				continue
+			}
+			stdout, stderr, exitErr, err := r.run(ctx, tool, filename, result.Content)
+			if err != nil {
+				slog.Warn("Formatter failed", "tool", tool.Name, "file", filename, "error", err)
+				continue
+			}
+			if kind == KindFormatter {
+				r.applyFormatter(&result, tool, stdout, stderr, exitErr)
+			} else {
+				result.Annotations = append(result.Annotations, parseAnnotations(tool.Name, stdout+stderr, exitErr)...)
+			}
+		}
+	}
+
+	if len(result.Annotations) > maxAnnotations {
+		result.Annotations = result.Annotations[:maxAnnotations]
+	}
+	return result
+}
+
+// applyFormatter records a formatter's outcome. A formatter that fails
+// usually found a syntax error, which is reported instead.
+func (r *Runner) applyFormatter(result *Result, tool Tool, stdout, stderr string, exitErr bool) {
+	if exitErr {
+		result.Annotations = append(result.Annotations, parseAnnotations(tool.Name, stderr+stdout, true)...)
+		return
+	}
+	if stdout == "" || stdout == result.Content {
+		return
+	}
+	if r.mode == ModeFormat {
+		result.Content = stdout
+		result.Formatted = true
+		return
+	}
+	result.Annotations = append(result.Annotations, models.LintAnnotation{
+		Tool:     tool.Name,
+		Line:     firstDifference(result.Content, stdout),
+		Severity: models.LintSeverityWarning,
+		Message:  fmt.Sprintf("file is not formatted with %s", tool.Name),
+	})
+}
+
+// run runs one tool with content on stdin. exitErr reports a non-zero
+// exit; err is only set when the tool couldn't run to completion.
+func (r *Runner) run(ctx context.Context, tool Tool, filename, content string) (stdout, stderr string, exitErr bool, err error) {
+	dir, err := os.MkdirTemp("", "casgists-format-")
+	if err != nil {
+		return "", "", false, err
+	}
+	defer os.RemoveAll(dir)
+
+	base := safeFilename(filename)
+	argv := make([]string, 0, len(r.sandbox)+len(tool.Command))
+	argv = append(argv, r.sandbox...)
+	for _, arg := range tool.Command {
+		argv = append(argv, strings.ReplaceAll(arg, "{filename}", base))
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Dir = dir
+	cmd.Env = []string{"PATH=" + os.Getenv("PATH"), "HOME=" + dir, "TMPDIR=" + dir, "LANG=C.UTF-8"}
+	cmd.Stdin = strings.NewReader(content)
+	out := &limitedBuffer{max: r.maxBytes * 2}
+	errOut := &limitedBuffer{max: 64 * 1024}
+	cmd.Stdout = out
+	cmd.Stderr = errOut
+	cmd.WaitDelay = time.Second
+
+	runErr := cmd.Run()
+	if ctx.Err() != nil {
+		return "", "", false, fmt.Errorf("timed out after %s", r.timeout)
+	}
+	if out.truncated {
+		return "", "", false, errors.New("output too large")
+	}
+	var exit *exec.ExitError
+	if runErr != nil && !errors.As(runErr, &exit) {
+		return "", "", false, runErr
+	}
+	return out.String(), errOut.String(), runErr != nil, nil
+}
+
+// safeFilename returns a base name safe to pass as an argument
+func safeFilename(filename string) string {
+	base := filepath.Base(filepath.Clean("/" + filename))
+	if base == "/" || base == "." || strings.HasPrefix(base, "-") {
+		return "file" + filepath.Ext(filename)
+	}
+	return base
+}
+
+// annotationLine matches file:line[:column]: message, the format of gofmt,
+// go vet, flake8, eslint --format unix, shellcheck -f gcc and most others
+var annotationLine = regexp.MustCompile(`^[^:\n]*:(\d+)(?::(\d+))?:\s*(.+)$`)
+
+// parseAnnotations turns tool output into annotations. Output in no known
+// format becomes a single message about the whole file when the tool failed.
+func parseAnnotations(tool, output string, failed bool) models.LintAnnotations {
+	var annotations models.LintAnnotations
+	for _, line := range strings.Split(output, "\n") {
+		match := annotationLine.FindStringSubmatch(strings.TrimSpace(line))
+		if match == nil {
+			continue
+		}
+		annotation := models.LintAnnotation{Tool: tool, Severity: models.LintSeverityWarning, Message: match[3]}
+		annotation.Line, _ = strconv.Atoi(match[1])
+		annotation.Column, _ = strconv.Atoi(match[2])
+		if failed && strings.Contains(strings.ToLower(match[3]), "error") {
+			annotation.Severity = models.LintSeverityError
+		}
+		annotations = append(annotations, annotation)
+	}
+	if len(annotations) == 0 && failed {
+		message := strings.TrimSpace(output)
+		if i := strings.IndexByte(message, '\n'); i >= 0 {
+			message = message[:i]
+		}
+		if message == "" {
+			message = "exited with an error"
+		}
+		annotations = append(annotations, models.LintAnnotation{Tool: tool, Severity: models.LintSeverityError, Message: message})
+	}
+	return annotations
+}
+
+// firstDifference returns the first line, 1-based, where a and b differ
+func firstDifference(a, b string) int {
+	linesA, linesB := strings.Split(a, "\n"), strings.Split(b, "\n")
+	for i := 0; i < len(linesA) && i < len(linesB); i++ {
+		if linesA[i] != linesB[i] {
+			return i + 1
+		}
+	}
+	if len(linesA) < len(linesB) {
+		return len(linesA)
+	}
+	return len(linesB)
+}
+
+// limitedBuffer keeps at most max bytes and notes whether more was written
+type limitedBuffer struct {
+	bytes.Buffer
+	max       int
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := b.max - b.Len(); len(p) > room {
+		b.truncated = true
+		if room > 0 {
+			b.Buffer.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
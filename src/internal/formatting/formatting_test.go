@@ -0,0 +1,108 @@
+package formatting
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func newTestRunner(t *testing.T, mode string, tools ...map[string]interface{}) *Runner {
+	t.Helper()
+	cfg := config.New()
+	cfg.Set("formatting.enabled", true)
+	cfg.Set("formatting.mode", mode)
+	cfg.Set("formatting.timeout", "2s")
+	cfg.Set("formatting.tools", tools)
+	runner, err := NewRunner(cfg)
+	require.NoError(t, err)
+	require.NotNil(t, runner)
+	return runner
+}
+
+// upper is a stand-in formatter that upper-cases its input
+var upper = map[string]interface{}{
+	"name": "upper", "kind": KindFormatter, "extensions": []string{".txt"},
+	"command": []string{"tr", "a-z", "A-Z"},
+}
+
+// vet is a stand-in linter that reports a problem on line 2
+var vet = map[string]interface{}{
+	"name": "vet", "kind": KindLinter, "languages": []string{"text"},
+	"command": []string{"sh", "-c", "cat >/dev/null; echo '{filename}:2:5: unused variable'; exit 1"},
+}
+
+func TestFormatMode(t *testing.T) {
+	runner := newTestRunner(t, ModeFormat, upper, vet)
+
+	result := runner.Process(context.Background(), "notes.txt", "text", "hello\nworld\n")
+	assert.True(t, result.Formatted)
+	assert.Equal(t, "HELLO\nWORLD\n", result.Content)
+	require.Len(t, result.Annotations, 1)
+	assert.Equal(t, models.LintAnnotation{Tool: "vet", Line: 2, Column: 5, Severity: models.LintSeverityWarning, Message: "unused variable"}, result.Annotations[0])
+
+	// Files no tool handles are left alone
+	result = runner.Process(context.Background(), "main.go", "go", "package main\n")
+	assert.False(t, result.Formatted)
+	assert.Equal(t, "package main\n", result.Content)
+	assert.Empty(t, result.Annotations)
+}
+
+func TestAnnotateMode(t *testing.T) {
+	runner := newTestRunner(t, ModeAnnotate, upper)
+
+	result := runner.Process(context.Background(), "notes.txt", "", "HELLO\nworld\n")
+	assert.False(t, result.Formatted)
+	assert.Equal(t, "HELLO\nworld\n", result.Content)
+	require.Len(t, result.Annotations, 1)
+	assert.Equal(t, 2, result.Annotations[0].Line)
+	assert.Contains(t, result.Annotations[0].Message, "not formatted with upper")
+
+	assert.Empty(t, runner.Process(context.Background(), "notes.txt", "", "HELLO\n").Annotations)
+}
+
+func TestFailingTools(t *testing.T) {
+	broken := map[string]interface{}{
+		"name": "broken", "kind": KindFormatter, "extensions": []string{".txt"},
+		"command": []string{"sh", "-c", "echo 'syntax error: unexpected EOF' >&2; exit 2"},
+	}
+	missing := map[string]interface{}{
+		"name": "missing", "kind": KindFormatter, "extensions": []string{".txt"},
+		"command": []string{"casgists-no-such-formatter"},
+	}
+	slow := map[string]interface{}{
+		"name": "slow", "kind": KindLinter, "extensions": []string{".txt"},
+		"command": []string{"sleep", "10"},
+	}
+	runner := newTestRunner(t, ModeFormat, broken, missing, slow)
+	runner.timeout = 200 * time.Millisecond
+
+	start := time.Now()
+	result := runner.Process(context.Background(), "notes.txt", "", "hello\n")
+	assert.Less(t, time.Since(start), 5*time.Second)
+	assert.Equal(t, "hello\n", result.Content)
+	require.Len(t, result.Annotations, 1)
+	assert.Equal(t, "broken", result.Annotations[0].Tool)
+	assert.Equal(t, models.LintSeverityError, result.Annotations[0].Severity)
+	assert.Equal(t, "syntax error: unexpected EOF", result.Annotations[0].Message)
+}
+
+func TestNewRunner(t *testing.T) {
+	runner, err := NewRunner(config.New())
+	assert.NoError(t, err)
+	assert.Nil(t, runner)
+
+	cfg := config.New()
+	cfg.Set("formatting.enabled", true)
+	cfg.Set("formatting.tools", []map[string]interface{}{{"name": "x", "kind": "beautifier", "command": []string{"x"}}})
+	_, err = NewRunner(cfg)
+	assert.Error(t, err)
+
+	assert.Equal(t, "main.go", safeFilename("../../etc/main.go"))
+	assert.Equal(t, "file.go", safeFilename("-rf.go"))
+}
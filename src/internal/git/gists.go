@@ -0,0 +1,288 @@
+package git
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/storage/filesystem"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Errors reading a gist's files at a revision
+var (
+	ErrRevisionNotFound = errors.New("revision not found")
+	ErrFileNotFound     = errors.New("file not found")
+)
+
+// GistRepositories keeps a bare repository for each gist, named after the
+// gist's ID, with a commit for every change to its files. Commits carry the
+// identity of the user who made the change.
+type GistRepositories struct {
+	service *Service
+}
+
+// NewGistRepositories stores gist repositories through a git service
+func NewGistRepositories(service *Service) *GistRepositories {
+	return &GistRepositories{service: service}
+}
+
+// repoPath is the repository ID a gist is stored under, kept in its
+// GitRepoPath
+func repoPath(gist *models.Gist) string {
+	return gist.ID.String()
+}
+
+// InitializeGistRepo creates the repository of a new gist with its files as
+// the first commit
+func (r *GistRepositories) InitializeGistRepo(gist *models.Gist, files []models.GistFile, author *models.User) error {
+	repo, err := r.create(repoPath(gist))
+	if err != nil {
+		return err
+	}
+	_, err = commitFiles(repo, files, author, "Create gist")
+	return err
+}
+
+// UpdateGistFiles commits the gist's current files. Gists stored before
+// they had a repository get one on their first change.
+func (r *GistRepositories) UpdateGistFiles(gist *models.Gist, files []models.GistFile, author *models.User, message string) error {
+	repo, err := r.openOrInit(repoPath(gist))
+	if err != nil {
+		return err
+	}
+	if message == "" {
+		message = "Update gist"
+	}
+	_, err = commitFiles(repo, files, author, message)
+	return err
+}
+
+// ForkGistRepo gives a fork a copy of the source gist's repository, so it
+// keeps the history. A source without a repository gives the fork a new
+// one holding its files.
+func (r *GistRepositories) ForkGistRepo(source, fork *models.Gist, files []models.GistFile, author *models.User) error {
+	exists, err := r.service.storage.Exists(repoPath(source))
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return r.InitializeGistRepo(fork, files, author)
+	}
+
+	from, err := r.service.storage.Filesystem(repoPath(source))
+	if err != nil {
+		return err
+	}
+	if err := r.service.storage.Create(repoPath(fork)); err != nil {
+		return err
+	}
+	to, err := r.service.storage.Filesystem(repoPath(fork))
+	if err != nil {
+		return err
+	}
+	if _, err := copyTree(from, to, "/"); err != nil {
+		return fmt.Errorf("failed to copy repository: %w", err)
+	}
+	return nil
+}
+
+// DeleteGistRepo removes the repository of a gist
+func (r *GistRepositories) DeleteGistRepo(gist *models.Gist) error {
+	return r.service.storage.Delete(repoPath(gist))
+}
+
+// FileAt returns the content of one of a gist's files as of a commit,
+// named by its full hash or an unambiguous prefix of at least four hex
+// digits, along with the commit's full hash
+func (r *GistRepositories) FileAt(gist *models.Gist, revision, filename string) (string, string, error) {
+	if !isRevision(revision) {
+		return "", "", ErrRevisionNotFound
+	}
+	exists, err := r.service.storage.Exists(repoPath(gist))
+	if err != nil {
+		return "", "", err
+	}
+	if !exists {
+		return "", "", ErrRevisionNotFound
+	}
+	repo, _, err := r.service.open(repoPath(gist))
+	if err != nil {
+		return "", "", err
+	}
+
+	hash, err := repo.ResolveRevision(plumbing.Revision(strings.ToLower(revision)))
+	if err != nil {
+		return "", "", ErrRevisionNotFound
+	}
+	commit, err := repo.CommitObject(*hash)
+	if err != nil {
+		return "", "", ErrRevisionNotFound
+	}
+	tree, err := commit.Tree()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get tree: %w", err)
+	}
+	// Gist filenames may hold a slash, which tree.File would take as a
+	// directory, so the entry is looked up by name
+	for _, entry := range tree.Entries {
+		if entry.Name != filename {
+			continue
+		}
+		blob, err := repo.BlobObject(entry.Hash)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to get %s: %w", filename, err)
+		}
+		reader, err := blob.Reader()
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		defer reader.Close()
+		content, err := io.ReadAll(reader)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to read %s: %w", filename, err)
+		}
+		return string(content), commit.Hash.String(), nil
+	}
+	return "", "", ErrFileNotFound
+}
+
+// isRevision reports whether a revision is a commit hash or a prefix of
+// one long enough to name a commit. Branch and tag names are not accepted,
+// so a revision always means the same content.
+func isRevision(revision string) bool {
+	if len(revision) < 4 || len(revision) > 40 {
+		return false
+	}
+	for _, r := range strings.ToLower(revision) {
+		if (r < '0' || r > '9') && (r < 'a' || r > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// create creates an empty bare repository whose HEAD is main
+func (r *GistRepositories) create(repoID string) (*git.Repository, error) {
+	if err := r.service.storage.Create(repoID); err != nil {
+		return nil, err
+	}
+	fs, err := r.service.storage.Filesystem(repoID)
+	if err != nil {
+		return nil, err
+	}
+	repo, err := git.InitWithOptions(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil, git.InitOptions{
+		DefaultBranch: plumbing.Main,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize git repository: %w", err)
+	}
+	return repo, nil
+}
+
+func (r *GistRepositories) openOrInit(repoID string) (*git.Repository, error) {
+	exists, err := r.service.storage.Exists(repoID)
+	if err != nil {
+		return nil, err
+	}
+	if !exists {
+		return r.create(repoID)
+	}
+	repo, _, err := r.service.open(repoID)
+	return repo, err
+}
+
+// commitFiles writes files as the whole tree of a new commit on HEAD's
+// branch. A bare repository has no worktree, so the blobs, tree and commit
+// are stored directly.
+func commitFiles(repo *git.Repository, files []models.GistFile, author *models.User, message string) (plumbing.Hash, error) {
+	tree := &object.Tree{}
+	for _, file := range files {
+		blob := repo.Storer.NewEncodedObject()
+		blob.SetType(plumbing.BlobObject)
+		w, err := blob.Writer()
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		if _, err := w.Write([]byte(file.Content)); err != nil {
+			w.Close()
+			return plumbing.ZeroHash, err
+		}
+		if err := w.Close(); err != nil {
+			return plumbing.ZeroHash, err
+		}
+		hash, err := repo.Storer.SetEncodedObject(blob)
+		if err != nil {
+			return plumbing.ZeroHash, fmt.Errorf("failed to store %s: %w", file.Filename, err)
+		}
+		tree.Entries = append(tree.Entries, object.TreeEntry{Name: file.Filename, Mode: filemode.Regular, Hash: hash})
+	}
+	// Git requires tree entries in name order
+	sort.Slice(tree.Entries, func(i, j int) bool { return tree.Entries[i].Name < tree.Entries[j].Name })
+	treeHash, err := storeObject(repo, tree)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to store tree: %w", err)
+	}
+
+	head, err := repo.Storer.Reference(plumbing.HEAD)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	branch := head.Target()
+	var parents []plumbing.Hash
+	if current, err := repo.Storer.Reference(branch); err == nil {
+		parents = append(parents, current.Hash())
+	} else if !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return plumbing.ZeroHash, fmt.Errorf("failed to read %s: %w", branch, err)
+	}
+
+	signature := authorSignature(author)
+	commitHash, err := storeObject(repo, &object.Commit{
+		Author:       signature,
+		Committer:    signature,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	})
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to create commit: %w", err)
+	}
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(branch, commitHash)); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("failed to update %s: %w", branch, err)
+	}
+	return commitHash, nil
+}
+
+// storeObject encodes a tree or commit into the repository
+func storeObject(repo *git.Repository, obj interface {
+	Encode(plumbing.EncodedObject) error
+}) (plumbing.Hash, error) {
+	encoded := repo.Storer.NewEncodedObject()
+	if err := obj.Encode(encoded); err != nil {
+		return plumbing.ZeroHash, err
+	}
+	return repo.Storer.SetEncodedObject(encoded)
+}
+
+// authorSignature names the user behind a change, or CasGists itself when
+// there is none
+func authorSignature(author *models.User) object.Signature {
+	signature := object.Signature{Name: "CasGists", Email: "noreply@casgists.local", When: time.Now()}
+	if author != nil {
+		signature.Name = author.DisplayName
+		if signature.Name == "" {
+			signature.Name = author.Username
+		}
+		signature.Email = author.Email
+	}
+	return signature
+}
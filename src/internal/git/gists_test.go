@@ -0,0 +1,82 @@
+package git
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistRepositories(t *testing.T) {
+	service := NewServiceWithStorage(config.New(), NewLocalDriver(t.TempDir()))
+	repos := NewGistRepositories(service)
+	alice := &models.User{Username: "alice", DisplayName: "Alice", Email: "alice@example.com"}
+	bob := &models.User{Username: "bob", Email: "bob@example.com"}
+
+	gist := &models.Gist{ID: uuid.New()}
+	require.NoError(t, repos.InitializeGistRepo(gist, []models.GistFile{
+		{Filename: "b.txt", Content: "second"},
+		{Filename: "a.txt", Content: "first"},
+	}, alice))
+	require.NoError(t, repos.UpdateGistFiles(gist, []models.GistFile{
+		{Filename: "a.txt", Content: "changed"},
+	}, bob, "Change a.txt"))
+
+	history, err := service.GetCommitHistory(gist.ID.String(), 0)
+	require.NoError(t, err)
+	require.Len(t, history, 2)
+	assert.Equal(t, "Change a.txt", history[0].Message)
+	assert.Equal(t, "bob", history[0].Author)
+	assert.Equal(t, "Alice", history[1].Author)
+	assert.Equal(t, "alice@example.com", history[1].Email)
+
+	content, err := service.GetFileContent(gist.ID.String(), "a.txt", history[0].Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "changed", content)
+	_, err = service.GetFileContent(gist.ID.String(), "b.txt", history[0].Hash)
+	assert.Error(t, err, "files left out of an update are deleted")
+	content, err = service.GetFileContent(gist.ID.String(), "b.txt", history[1].Hash)
+	require.NoError(t, err)
+	assert.Equal(t, "second", content)
+
+	// Files read at a revision, by full hash or a prefix of one
+	content, commit, err := repos.FileAt(gist, history[1].Hash, "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "first", content)
+	assert.Equal(t, history[1].Hash, commit)
+	content, commit, err = repos.FileAt(gist, strings.ToUpper(history[1].Hash[:8]), "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "second", content)
+	assert.Equal(t, history[1].Hash, commit)
+	_, _, err = repos.FileAt(gist, history[0].Hash, "b.txt")
+	assert.ErrorIs(t, err, ErrFileNotFound)
+	for _, revision := range []string{"main", "HEAD", "abc", strings.Repeat("0", 40)} {
+		_, _, err = repos.FileAt(gist, revision, "a.txt")
+		assert.ErrorIs(t, err, ErrRevisionNotFound, revision)
+	}
+	_, _, err = repos.FileAt(&models.Gist{ID: uuid.New()}, history[0].Hash, "a.txt")
+	assert.ErrorIs(t, err, ErrRevisionNotFound, "gists without a repository have no revisions")
+
+	// A fork keeps the history of the original
+	fork := &models.Gist{ID: uuid.New()}
+	require.NoError(t, repos.ForkGistRepo(gist, fork, nil, bob))
+	forkHistory, err := service.GetCommitHistory(fork.ID.String(), 0)
+	require.NoError(t, err)
+	assert.Equal(t, history, forkHistory)
+
+	// Gists stored before they had a repository get one on their first change
+	legacy := &models.Gist{ID: uuid.New()}
+	require.NoError(t, repos.UpdateGistFiles(legacy, []models.GistFile{{Filename: "x.txt", Content: "x"}}, nil, ""))
+	legacyHistory, err := service.GetCommitHistory(legacy.ID.String(), 0)
+	require.NoError(t, err)
+	require.Len(t, legacyHistory, 1)
+	assert.Equal(t, "CasGists", legacyHistory[0].Author)
+
+	require.NoError(t, repos.DeleteGistRepo(fork))
+	assert.Error(t, service.ValidateGistRepo(fork.ID.String()))
+}
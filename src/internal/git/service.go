@@ -6,35 +6,62 @@ import (
 	"path/filepath"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/models"
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/cache"
 	"github.com/go-git/go-git/v5/storage/filesystem"
+	"github.com/go-git/go-billy/v5"
 	"github.com/go-git/go-billy/v5/osfs"
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 )
 
 // Service handles Git operations for gists
 type Service struct {
-	config     *viper.Viper
-	repoPath   string
+	config  *config.Config
+	storage StorageDriver
 }
 
-// NewService creates a new Git service
-func NewService(cfg *viper.Viper) *Service {
-	repoPath := cfg.GetString("git.repo_path")
-	if repoPath == "" {
-		repoPath = cfg.GetString("data_dir") + "/repositories"
+// NewService creates a new Git service storing repositories on the local
+// filesystem (use NewServiceWithStorage for the configured driver)
+func NewService(cfg *config.Config) *Service {
+	storage, err := newLocalDriverFromConfig(cfg)
+	if err != nil {
+		storage = NewLocalDriver(filepath.Join(cfg.GetString("data_dir"), "repositories"))
 	}
+	return NewServiceWithStorage(cfg, storage)
+}
 
+// NewServiceWithStorage creates a new Git service on a storage driver
+func NewServiceWithStorage(cfg *config.Config, storage StorageDriver) *Service {
 	return &Service{
-		config:   cfg,
-		repoPath: repoPath,
+		config:  cfg,
+		storage: storage,
 	}
 }
 
+// Storage returns the driver repositories are stored with
+func (s *Service) Storage() StorageDriver {
+	return s.storage
+}
+
+// open opens the repository of a gist through the storage driver
+func (s *Service) open(gistID string) (*git.Repository, billy.Filesystem, error) {
+	fs, err := s.storage.Filesystem(gistID)
+	if err != nil {
+		return nil, nil, err
+	}
+	storage := filesystem.NewStorage(fs, cache.NewObjectLRUDefault())
+
+	repo, err := git.Open(storage, fs)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return repo, fs, nil
+}
+
 // CommitInfo represents git commit information (alias for compatibility)
 type CommitInfo = Commit
 
@@ -60,15 +87,17 @@ type RepoStats struct {
 
 // InitRepository initializes a Git repository for a gist
 func (s *Service) InitRepository(gistID uuid.UUID) error {
-	repoDir := filepath.Join(s.repoPath, gistID.String())
-	
-	// Create repository directory
-	if err := os.MkdirAll(repoDir, 0755); err != nil {
-		return fmt.Errorf("failed to create repository directory: %w", err)
+	// Create repository location
+	if err := s.storage.Create(gistID.String()); err != nil {
+		return err
+	}
+	fs, err := s.storage.Filesystem(gistID.String())
+	if err != nil {
+		return err
 	}
 
 	// Initialize bare repository
-	_, err := git.PlainInit(repoDir, true)
+	_, err = git.Init(filesystem.NewStorage(fs, cache.NewObjectLRUDefault()), nil)
 	if err != nil {
 		return fmt.Errorf("failed to initialize git repository: %w", err)
 	}
@@ -83,15 +112,10 @@ func (s *Service) InitializeGistRepo(gist *models.Gist) error {
 
 // CommitChanges commits changes to a gist repository
 func (s *Service) CommitChanges(gistID uuid.UUID, message string) error {
-	repoDir := filepath.Join(s.repoPath, gistID.String())
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID.String())
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return err
 	}
 
 	// Get working tree
@@ -127,15 +151,10 @@ func (s *Service) CommitChanges(gistID uuid.UUID, message string) error {
 
 // CreateCommit creates a commit with the gist files
 func (s *Service) CreateCommit(gist *models.Gist, message string, author *models.User) error {
-	repoDir := filepath.Join(s.repoPath, gist.ID.String())
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, fs, err := s.open(gist.ID.String())
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return err
 	}
 
 	// Get working tree
@@ -220,15 +239,10 @@ func (s *Service) GetGistHistory(gistID uuid.UUID, limit int) ([]CommitInfo, err
 
 // GetCommitHistory returns the commit history for a gist
 func (s *Service) GetCommitHistory(gistID string, limit int) ([]*Commit, error) {
-	repoDir := filepath.Join(s.repoPath, gistID)
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open repository: %w", err)
+		return nil, err
 	}
 
 	// Get commit iterator
@@ -271,41 +285,56 @@ func (s *Service) GetCommitHistory(gistID string, limit int) ([]*Commit, error)
 	return commits, nil
 }
 
-// CloneRepository clones a gist repository
+// CloneRepository clones a gist repository into a local directory
 func (s *Service) CloneRepository(gistID uuid.UUID, destination string) error {
-	sourceDir := filepath.Join(s.repoPath, gistID.String())
-	
 	// Create destination directory
 	if err := os.MkdirAll(destination, 0755); err != nil {
 		return fmt.Errorf("failed to create destination directory: %w", err)
 	}
 
-	// Clone repository
-	_, err := git.PlainClone(destination, false, &git.CloneOptions{
-		URL: sourceDir,
-	})
+	// Clone straight from disk when the driver keeps repositories there
+	if local, ok := s.storage.(*LocalDriver); ok {
+		sourceDir, err := local.RepoPath(gistID.String())
+		if err != nil {
+			return err
+		}
+		_, err = git.PlainClone(destination, false, &git.CloneOptions{
+			URL: sourceDir,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to clone repository: %w", err)
+		}
+		return nil
+	}
+
+	source, err := s.storage.Filesystem(gistID.String())
 	if err != nil {
+		return err
+	}
+	if _, err := copyTree(source, osfs.New(destination), "/"); err != nil {
 		return fmt.Errorf("failed to clone repository: %w", err)
 	}
-
 	return nil
 }
 
 // CloneGist creates a clone/fork of a gist
 func (s *Service) CloneGist(sourceGist, targetGist *models.Gist) error {
-	sourceDir := filepath.Join(s.repoPath, sourceGist.ID.String())
-	targetDir := filepath.Join(s.repoPath, targetGist.ID.String())
-	
-	// Create target directory
-	if err := os.MkdirAll(targetDir, 0755); err != nil {
-		return fmt.Errorf("failed to create target directory: %w", err)
+	source, err := s.storage.Filesystem(sourceGist.ID.String())
+	if err != nil {
+		return err
 	}
 
-	// Clone repository
-	_, err := git.PlainClone(targetDir, false, &git.CloneOptions{
-		URL: sourceDir,
-	})
+	// Create target repository
+	if err := s.storage.Create(targetGist.ID.String()); err != nil {
+		return err
+	}
+	target, err := s.storage.Filesystem(targetGist.ID.String())
 	if err != nil {
+		return err
+	}
+
+	// A fork starts as a copy of the source repository
+	if _, err := copyTree(source, target, "/"); err != nil {
 		return fmt.Errorf("failed to clone repository: %w", err)
 	}
 
@@ -333,15 +362,10 @@ func (s *Service) CreateGistVersion(gistID uuid.UUID, message string) (string, e
 
 // GetGistBranches returns list of branches for a gist
 func (s *Service) GetGistBranches(gistID uuid.UUID) ([]string, error) {
-	repoDir := filepath.Join(s.repoPath, gistID.String())
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID.String())
 	if err != nil {
-		return nil, fmt.Errorf("failed to open repository: %w", err)
+		return nil, err
 	}
 
 	// Get branches
@@ -372,15 +396,10 @@ func (s *Service) GetGistBranches(gistID uuid.UUID) ([]string, error) {
 
 // CreateGistBranch creates a new branch for a gist
 func (s *Service) CreateGistBranch(gistID uuid.UUID, branchName string) error {
-	repoDir := filepath.Join(s.repoPath, gistID.String())
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID.String())
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return err
 	}
 
 	// Get current HEAD
@@ -418,15 +437,10 @@ func (s *Service) GetRepositorySize(gistID uuid.UUID) (int64, error) {
 
 // GetRepoStats returns statistics about the repository
 func (s *Service) GetRepoStats(gistID string) (*RepoStats, error) {
-	repoDir := filepath.Join(s.repoPath, gistID)
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open repository: %w", err)
+		return nil, err
 	}
 
 	// Count commits
@@ -451,18 +465,9 @@ func (s *Service) GetRepoStats(gistID string) (*RepoStats, error) {
 	}
 
 	// Get repository size
-	var totalSize int64
-	err = filepath.Walk(repoDir, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-		if !info.IsDir() {
-			totalSize += info.Size()
-		}
-		return nil
-	})
+	totalSize, err := s.storage.Size(gistID)
 	if err != nil {
-		return nil, fmt.Errorf("failed to calculate repository size: %w", err)
+		return nil, err
 	}
 
 	return &RepoStats{
@@ -473,15 +478,10 @@ func (s *Service) GetRepoStats(gistID string) (*RepoStats, error) {
 
 // GetFileContent returns the content of a file at a specific commit
 func (s *Service) GetFileContent(gistID, filename, commitHash string) (string, error) {
-	repoDir := filepath.Join(s.repoPath, gistID)
-	
 	// Open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	repo, err := git.Open(storage, fs)
+	repo, _, err := s.open(gistID)
 	if err != nil {
-		return "", fmt.Errorf("failed to open repository: %w", err)
+		return "", err
 	}
 
 	// Get commit object
@@ -513,32 +513,24 @@ func (s *Service) GetFileContent(gistID, filename, commitHash string) (string, e
 
 // DeleteGistRepo removes the Git repository for a gist
 func (s *Service) DeleteGistRepo(gistID string) error {
-	repoDir := filepath.Join(s.repoPath, gistID)
-	
-	if err := os.RemoveAll(repoDir); err != nil {
-		return fmt.Errorf("failed to delete repository: %w", err)
-	}
-
-	return nil
+	return s.storage.Delete(gistID)
 }
 
 // ValidateGistRepo checks if a gist repository exists and is valid
 func (s *Service) ValidateGistRepo(gistID string) error {
-	repoDir := filepath.Join(s.repoPath, gistID)
-	
-	// Check if directory exists
-	if _, err := os.Stat(repoDir); os.IsNotExist(err) {
+	// Check if the repository exists
+	exists, err := s.storage.Exists(gistID)
+	if err != nil {
+		return err
+	}
+	if !exists {
 		return fmt.Errorf("repository does not exist")
 	}
 
 	// Try to open repository
-	fs := osfs.New(repoDir)
-	storage := filesystem.NewStorage(fs, nil)
-	
-	_, err := git.Open(storage, fs)
-	if err != nil {
+	if _, _, err := s.open(gistID); err != nil {
 		return fmt.Errorf("invalid git repository: %w", err)
 	}
 
 	return nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,287 @@
+package git
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-billy/v5"
+	"github.com/go-git/go-billy/v5/osfs"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/diskspace"
+)
+
+// StorageDriver stores the git repositories of gists. Repositories are
+// addressed by gist ID and accessed through a billy filesystem, which is
+// all go-git needs, so a driver only has to provide a filesystem per
+// repository plus the bookkeeping around it.
+type StorageDriver interface {
+	// Name returns the driver name used in git.storage.driver
+	Name() string
+	// Describe returns where the driver stores repositories
+	Describe() string
+	// Filesystem returns the filesystem rooted at a repository
+	Filesystem(repoID string) (billy.Filesystem, error)
+	// Exists reports whether a repository exists
+	Exists(repoID string) (bool, error)
+	// Create prepares an empty repository location
+	Create(repoID string) error
+	// Delete removes a repository; deleting a missing one is not an error
+	Delete(repoID string) error
+	// List returns the IDs of all stored repositories
+	List() ([]string, error)
+	// Size returns the bytes used by a repository
+	Size(repoID string) (int64, error)
+	// Health checks that the storage is reachable and writable
+	Health(ctx context.Context) StorageHealth
+}
+
+// StorageHealth is the result of a storage driver health check
+type StorageHealth struct {
+	Driver         string  `json:"driver"`
+	Target         string  `json:"target"`
+	Healthy        bool    `json:"healthy"`
+	Writable       bool    `json:"writable"`
+	LatencyMs      float64 `json:"latency_ms"`
+	AvailableBytes uint64  `json:"available_bytes,omitempty"`
+	Error          string  `json:"error,omitempty"`
+}
+
+// StorageDriverFactory builds a driver from its git.storage.<name>.* settings
+type StorageDriverFactory func(cfg *config.Config) (StorageDriver, error)
+
+var (
+	storageDriversMu sync.RWMutex
+	storageDrivers   = map[string]StorageDriverFactory{
+		"local": newLocalDriverFromConfig,
+	}
+)
+
+// RegisterStorageDriver makes a driver available under name
+func RegisterStorageDriver(name string, factory StorageDriverFactory) {
+	storageDriversMu.Lock()
+	defer storageDriversMu.Unlock()
+	storageDrivers[name] = factory
+}
+
+// StorageDriverNames returns the names of all registered drivers
+func StorageDriverNames() []string {
+	storageDriversMu.RLock()
+	defer storageDriversMu.RUnlock()
+	names := make([]string, 0, len(storageDrivers))
+	for name := range storageDrivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// NewStorageDriver builds the driver selected by git.storage.driver
+func NewStorageDriver(cfg *config.Config) (StorageDriver, error) {
+	name := cfg.GetString("git.storage.driver")
+	if name == "" {
+		name = "local"
+	}
+	return OpenStorageDriver(name, cfg)
+}
+
+// OpenStorageDriver builds the named driver from its configuration
+func OpenStorageDriver(name string, cfg *config.Config) (StorageDriver, error) {
+	storageDriversMu.RLock()
+	factory, ok := storageDrivers[name]
+	storageDriversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown git storage driver %q (available: %s)", name, strings.Join(StorageDriverNames(), ", "))
+	}
+	return factory(cfg)
+}
+
+// validRepoID rejects IDs that could escape the storage root
+func validRepoID(repoID string) error {
+	if repoID == "" || repoID == "." || repoID == ".." || strings.ContainsAny(repoID, `/\`) {
+		return fmt.Errorf("invalid repository id %q", repoID)
+	}
+	return nil
+}
+
+// LocalDriver keeps each repository in a directory under a root path. It
+// also covers NFS and other network filesystems mounted on the host.
+type LocalDriver struct {
+	root string
+}
+
+// NewLocalDriver creates a driver storing repositories under root
+func NewLocalDriver(root string) *LocalDriver {
+	return &LocalDriver{root: root}
+}
+
+func newLocalDriverFromConfig(cfg *config.Config) (StorageDriver, error) {
+	root := cfg.GetString("git.storage.local.path")
+	if root == "" {
+		// Older configurations only set git.repo_path
+		root = cfg.GetString("git.repo_path")
+	}
+	if root == "" {
+		root = filepath.Join(cfg.GetString("data_dir"), "repositories")
+	}
+	if strings.Contains(root, "{") {
+		return nil, fmt.Errorf("git.storage.local.path has an unresolved placeholder: %s", root)
+	}
+	return NewLocalDriver(root), nil
+}
+
+// Name returns "local"
+func (d *LocalDriver) Name() string {
+	return "local"
+}
+
+// Describe returns the root directory
+func (d *LocalDriver) Describe() string {
+	return d.root
+}
+
+// Root returns the directory repositories are stored under
+func (d *LocalDriver) Root() string {
+	return d.root
+}
+
+// RepoPath returns the directory of a repository
+func (d *LocalDriver) RepoPath(repoID string) (string, error) {
+	if err := validRepoID(repoID); err != nil {
+		return "", err
+	}
+	return filepath.Join(d.root, repoID), nil
+}
+
+// Filesystem returns the repository directory as a filesystem
+func (d *LocalDriver) Filesystem(repoID string) (billy.Filesystem, error) {
+	path, err := d.RepoPath(repoID)
+	if err != nil {
+		return nil, err
+	}
+	return osfs.New(path), nil
+}
+
+// Exists reports whether the repository directory exists
+func (d *LocalDriver) Exists(repoID string) (bool, error) {
+	path, err := d.RepoPath(repoID)
+	if err != nil {
+		return false, err
+	}
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+// Create makes the repository directory
+func (d *LocalDriver) Create(repoID string) error {
+	path, err := d.RepoPath(repoID)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("failed to create repository directory: %w", err)
+	}
+	return nil
+}
+
+// Delete removes the repository directory
+func (d *LocalDriver) Delete(repoID string) error {
+	path, err := d.RepoPath(repoID)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(path); err != nil {
+		return fmt.Errorf("failed to delete repository: %w", err)
+	}
+	return nil
+}
+
+// List returns the repository directories under the root
+func (d *LocalDriver) List() ([]string, error) {
+	entries, err := os.ReadDir(d.root)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []string{}, nil
+		}
+		return nil, fmt.Errorf("failed to list repositories: %w", err)
+	}
+	ids := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		if entry.IsDir() && !strings.HasPrefix(entry.Name(), ".") {
+			ids = append(ids, entry.Name())
+		}
+	}
+	return ids, nil
+}
+
+// Size adds up the files in the repository directory
+func (d *LocalDriver) Size(repoID string) (int64, error) {
+	path, err := d.RepoPath(repoID)
+	if err != nil {
+		return 0, err
+	}
+	var size int64
+	err = filepath.Walk(path, func(_ string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			size += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to calculate repository size: %w", err)
+	}
+	return size, nil
+}
+
+// Health writes, reads back and removes a probe file under the root. On
+// network filesystems the latency shows how far away the storage is.
+func (d *LocalDriver) Health(ctx context.Context) StorageHealth {
+	health := StorageHealth{Driver: d.Name(), Target: d.root}
+
+	started := time.Now()
+	err := func() error {
+		if err := os.MkdirAll(d.root, 0755); err != nil {
+			return fmt.Errorf("repository root is not accessible: %w", err)
+		}
+		probe := filepath.Join(d.root, fmt.Sprintf(".health-%d", time.Now().UnixNano()))
+		defer os.Remove(probe)
+		want := []byte("casgists storage health check")
+		if err := os.WriteFile(probe, want, 0600); err != nil {
+			return fmt.Errorf("repository root is not writable: %w", err)
+		}
+		got, err := os.ReadFile(probe)
+		if err != nil {
+			return fmt.Errorf("repository root is not readable: %w", err)
+		}
+		if string(got) != string(want) {
+			return fmt.Errorf("repository root returned different data than was written")
+		}
+		return nil
+	}()
+	health.LatencyMs = float64(time.Since(started).Microseconds()) / 1000
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.Writable = true
+	health.Healthy = true
+
+	if usage, err := diskspace.Of(d.root); err == nil {
+		health.AvailableBytes = usage.Available
+	}
+	return health
+}
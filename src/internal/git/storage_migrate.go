@@ -0,0 +1,243 @@
+package git
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+	"path"
+
+	"github.com/go-git/go-billy/v5"
+)
+
+// MigrateOptions controls a repository migration between drivers
+type MigrateOptions struct {
+	// DeleteSource removes each repository from the source driver once its
+	// copy has been verified
+	DeleteSource bool
+}
+
+// MigrateProgress reports how far a migration got
+type MigrateProgress struct {
+	Total    int    `json:"total"`
+	Done     int    `json:"done"`
+	Copied   int    `json:"copied"`
+	Skipped  int    `json:"skipped"`
+	Failed   int    `json:"failed"`
+	Current  string `json:"current,omitempty"`
+	Bytes    int64  `json:"bytes"`
+	LastFail string `json:"last_failure,omitempty"`
+}
+
+// MigrateRepositories copies every repository from one driver to another
+// and verifies each copy file by file. Repositories already present and
+// identical at the destination are skipped, so an interrupted migration
+// can simply be run again. Writes must be stopped while it runs, which is
+// what read-only mode is for.
+func MigrateRepositories(ctx context.Context, from, to StorageDriver, opts MigrateOptions, progress func(MigrateProgress)) (*MigrateProgress, error) {
+	ids, err := from.List()
+	if err != nil {
+		return nil, err
+	}
+
+	state := &MigrateProgress{Total: len(ids)}
+	report := func() {
+		if progress != nil {
+			progress(*state)
+		}
+	}
+
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return state, err
+		}
+		state.Current = id
+		report()
+
+		copied, bytes, err := migrateRepository(from, to, id)
+		switch {
+		case err != nil:
+			state.Failed++
+			state.LastFail = fmt.Sprintf("%s: %v", id, err)
+		case copied:
+			state.Copied++
+			state.Bytes += bytes
+		default:
+			state.Skipped++
+		}
+		if err == nil && opts.DeleteSource {
+			if err := from.Delete(id); err != nil {
+				state.Failed++
+				state.LastFail = fmt.Sprintf("%s: %v", id, err)
+			}
+		}
+		state.Done++
+	}
+	state.Current = ""
+	report()
+
+	if state.Failed > 0 {
+		return state, fmt.Errorf("%d of %d repositories failed to migrate, last error: %s", state.Failed, state.Total, state.LastFail)
+	}
+	return state, nil
+}
+
+func migrateRepository(from, to StorageDriver, id string) (bool, int64, error) {
+	src, err := from.Filesystem(id)
+	if err != nil {
+		return false, 0, err
+	}
+
+	exists, err := to.Exists(id)
+	if err != nil {
+		return false, 0, err
+	}
+	if exists {
+		dst, err := to.Filesystem(id)
+		if err != nil {
+			return false, 0, err
+		}
+		if compareTrees(src, dst) == nil {
+			return false, 0, nil
+		}
+		// A partial copy from an interrupted run
+		if err := to.Delete(id); err != nil {
+			return false, 0, err
+		}
+	}
+
+	if err := to.Create(id); err != nil {
+		return false, 0, err
+	}
+	dst, err := to.Filesystem(id)
+	if err != nil {
+		return false, 0, err
+	}
+	bytes, err := copyTree(src, dst, "/")
+	if err != nil {
+		return false, 0, fmt.Errorf("copy failed: %w", err)
+	}
+	if err := compareTrees(src, dst); err != nil {
+		return false, 0, fmt.Errorf("verification failed: %w", err)
+	}
+	return true, bytes, nil
+}
+
+// VerifyRepository checks that a repository is identical in both drivers
+func VerifyRepository(from, to StorageDriver, id string) error {
+	src, err := from.Filesystem(id)
+	if err != nil {
+		return err
+	}
+	dst, err := to.Filesystem(id)
+	if err != nil {
+		return err
+	}
+	return compareTrees(src, dst)
+}
+
+func copyTree(src, dst billy.Filesystem, dir string) (int64, error) {
+	entries, err := src.ReadDir(dir)
+	if err != nil {
+		return 0, err
+	}
+
+	var total int64
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if err := dst.MkdirAll(name, 0755); err != nil {
+				return total, err
+			}
+			n, err := copyTree(src, dst, name)
+			total += n
+			if err != nil {
+				return total, err
+			}
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		n, err := copyFile(src, dst, name, entry.Mode().Perm())
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+func copyFile(src, dst billy.Filesystem, name string, perm os.FileMode) (int64, error) {
+	in, err := src.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer in.Close()
+
+	out, err := dst.OpenFile(name, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm)
+	if err != nil {
+		return 0, err
+	}
+	n, err := io.Copy(out, in)
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	return n, err
+}
+
+// compareTrees checks that both filesystems hold the same regular files
+// with the same contents
+func compareTrees(a, b billy.Filesystem) error {
+	left, err := hashTree(a, "/", map[string][32]byte{})
+	if err != nil {
+		return err
+	}
+	right, err := hashTree(b, "/", map[string][32]byte{})
+	if err != nil {
+		return err
+	}
+	if len(left) != len(right) {
+		return fmt.Errorf("file count differs: %d and %d", len(left), len(right))
+	}
+	for name, sum := range left {
+		if other, ok := right[name]; !ok || other != sum {
+			return fmt.Errorf("%s differs", name)
+		}
+	}
+	return nil
+}
+
+func hashTree(fs billy.Filesystem, dir string, sums map[string][32]byte) (map[string][32]byte, error) {
+	entries, err := fs.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := path.Join(dir, entry.Name())
+		if entry.IsDir() {
+			if _, err := hashTree(fs, name, sums); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if !entry.Mode().IsRegular() {
+			continue
+		}
+		f, err := fs.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+		var sum [32]byte
+		copy(sum[:], h.Sum(nil))
+		sums[name] = sum
+	}
+	return sums, nil
+}
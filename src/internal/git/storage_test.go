@@ -0,0 +1,90 @@
+package git
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestLocalDriver(t *testing.T) {
+	driver := NewLocalDriver(t.TempDir())
+
+	health := driver.Health(context.Background())
+	assert.True(t, health.Healthy, health.Error)
+	assert.True(t, health.Writable)
+
+	for _, id := range []string{"", ".", "..", "../etc", `a\b`} {
+		_, err := driver.Filesystem(id)
+		assert.Error(t, err, id)
+	}
+
+	require.NoError(t, driver.Create("abc"))
+	exists, err := driver.Exists("abc")
+	require.NoError(t, err)
+	assert.True(t, exists)
+
+	ids, err := driver.List()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"abc"}, ids)
+
+	require.NoError(t, driver.Delete("abc"))
+	require.NoError(t, driver.Delete("abc"))
+	exists, err = driver.Exists("abc")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
+
+func TestServiceOnStorageDriver(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("git.storage.local.path", t.TempDir())
+	storage, err := NewStorageDriver(cfg)
+	require.NoError(t, err)
+
+	service := NewServiceWithStorage(cfg, storage)
+	id := uuid.New()
+	require.NoError(t, service.InitRepository(id))
+	require.NoError(t, service.ValidateGistRepo(id.String()))
+
+	_, err = OpenStorageDriver("nope", cfg)
+	assert.Error(t, err)
+}
+
+func TestMigrateRepositories(t *testing.T) {
+	from := NewLocalDriver(t.TempDir())
+	to := NewLocalDriver(t.TempDir())
+
+	for _, id := range []string{"one", "two"} {
+		require.NoError(t, from.Create(id))
+		dir, _ := from.RepoPath(id)
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, "objects", "ab"), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, "objects", "ab", "cdef"), []byte(id), 0644))
+	}
+
+	// A partial copy left behind by an interrupted run
+	require.NoError(t, to.Create("two"))
+	partial, _ := to.RepoPath("two")
+	require.NoError(t, os.WriteFile(filepath.Join(partial, "HEAD"), []byte("ref"), 0644))
+
+	result, err := MigrateRepositories(context.Background(), from, to, MigrateOptions{}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Copied)
+	for _, id := range []string{"one", "two"} {
+		assert.NoError(t, VerifyRepository(from, to, id))
+	}
+
+	// Running again skips what is already there, then removes the source
+	result, err = MigrateRepositories(context.Background(), from, to, MigrateOptions{DeleteSource: true}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 2, result.Skipped)
+	ids, err := from.List()
+	require.NoError(t, err)
+	assert.Empty(t, ids)
+}
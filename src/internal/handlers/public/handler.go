@@ -4,18 +4,19 @@ import (
 	"net/http"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Handler handles public web pages
 type Handler struct {
 	db     *gorm.DB
-	config *viper.Viper
+	config *config.Config
 }
 
 // NewHandler creates a new public handler
-func NewHandler(db *gorm.DB, cfg *viper.Viper) *Handler {
+func NewHandler(db *gorm.DB, cfg *config.Config) *Handler {
 	return &Handler{
 		db:     db,
 		config: cfg,
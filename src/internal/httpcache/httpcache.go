@@ -0,0 +1,125 @@
+// Package httpcache answers conditional requests. Responses carry an ETag
+// and Last-Modified; a request whose If-None-Match or If-Modified-Since
+// shows the client, or a CDN in front of the server, already has the
+// current version is answered 304 Not Modified without a body.
+//
+// Responses are cacheable but revalidated on every use, so a gist that
+// was made private or deleted stops being served from caches at once;
+// only public content may be kept by shared caches.
+package httpcache
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// ETag returns the strong entity tag of a response body
+func ETag(body []byte) string {
+	h := NewHasher()
+	h.Write(body)
+	return h.ETag()
+}
+
+// Hasher computes the ETag of a body written to it in pieces, for
+// responses streamed rather than held in memory
+type Hasher struct {
+	hash.Hash
+}
+
+// NewHasher creates a new ETag hasher
+func NewHasher() *Hasher {
+	return &Hasher{Hash: sha256.New()}
+}
+
+// ETag returns the strong entity tag of what was written
+func (h *Hasher) ETag() string {
+	return `"` + hex.EncodeToString(h.Sum(nil)) + `"`
+}
+
+// Revalidate lets caches keep the response as long as they check with the
+// server before each reuse. Shared caches such as CDNs may only keep it
+// when shared is true, for content anyone may read; otherwise only the
+// client's own cache does. Responses already marked no-store, for share
+// links and burn-after-read gists, are left alone.
+func Revalidate(c echo.Context, shared bool) {
+	if NoStore(c) {
+		return
+	}
+	scope := "private"
+	if shared {
+		scope = "public"
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, scope+", no-cache")
+}
+
+// NoStore reports whether the response is marked no-store and must not be
+// kept or revalidated
+func NoStore(c echo.Context) bool {
+	return strings.Contains(c.Response().Header().Get(echo.HeaderCacheControl), "no-store")
+}
+
+// NotModified sets the ETag and Last-Modified of a response and reports
+// whether the request's preconditions show the client has this version, so
+// it is to be answered 304. A zero modified time leaves Last-Modified out.
+// If-None-Match is compared weakly, so ETags a proxy weakened after
+// compressing the body still match, and If-Modified-Since is only
+// consulted without it. Responses marked no-store get neither header.
+func NotModified(c echo.Context, etag string, modified time.Time) bool {
+	if NoStore(c) {
+		return false
+	}
+	header := c.Response().Header()
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	if !modified.IsZero() {
+		header.Set(echo.HeaderLastModified, modified.UTC().Format(http.TimeFormat))
+	}
+
+	req := c.Request()
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return false
+	}
+	if match := req.Header.Get("If-None-Match"); match != "" {
+		return etag != "" && matchesETag(match, etag)
+	}
+	if since := req.Header.Get(echo.HeaderIfModifiedSince); since != "" && !modified.IsZero() {
+		t, err := http.ParseTime(since)
+		// Last-Modified has whole seconds
+		return err == nil && !modified.Truncate(time.Second).After(t)
+	}
+	return false
+}
+
+// JSON responds with value encoded as JSON, tagged with the ETag of the
+// encoding and modified as Last-Modified, or with 304 when the client has
+// that version
+func JSON(c echo.Context, value interface{}, modified time.Time) error {
+	body, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	if NotModified(c, ETag(body), modified) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.JSONBlob(http.StatusOK, body)
+}
+
+// matchesETag reports whether an If-None-Match list names etag
+func matchesETag(list, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
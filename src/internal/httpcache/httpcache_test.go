@@ -0,0 +1,100 @@
+package httpcache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newContext(method string, headers map[string]string) (echo.Context, *httptest.ResponseRecorder) {
+	req := httptest.NewRequest(method, "/gists/abc", nil)
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	rec := httptest.NewRecorder()
+	return echo.New().NewContext(req, rec), rec
+}
+
+func TestNotModified(t *testing.T) {
+	etag := ETag([]byte("hello"))
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 500_000_000, time.UTC)
+
+	tests := []struct {
+		name    string
+		method  string
+		headers map[string]string
+		want    bool
+	}{
+		{"no preconditions", http.MethodGet, nil, false},
+		{"matching ETag", http.MethodGet, map[string]string{"If-None-Match": etag}, true},
+		{"ETag in a list", http.MethodGet, map[string]string{"If-None-Match": `"other", ` + etag}, true},
+		{"weakened ETag", http.MethodGet, map[string]string{"If-None-Match": "W/" + etag}, true},
+		{"any ETag", http.MethodHead, map[string]string{"If-None-Match": "*"}, true},
+		{"stale ETag", http.MethodGet, map[string]string{"If-None-Match": `"other"`}, false},
+		{"not modified since", http.MethodGet, map[string]string{"If-Modified-Since": modified.Format(http.TimeFormat)}, true},
+		{"modified since", http.MethodGet, map[string]string{"If-Modified-Since": modified.Add(-time.Minute).Format(http.TimeFormat)}, false},
+		{"unparsable date", http.MethodGet, map[string]string{"If-Modified-Since": "yesterday"}, false},
+		{
+			"If-None-Match wins over If-Modified-Since", http.MethodGet,
+			map[string]string{"If-None-Match": `"other"`, "If-Modified-Since": modified.Format(http.TimeFormat)}, false,
+		},
+		{"not a read", http.MethodPost, map[string]string{"If-None-Match": etag}, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c, rec := newContext(tt.method, tt.headers)
+			assert.Equal(t, tt.want, NotModified(c, etag, modified))
+			assert.Equal(t, etag, rec.Header().Get("ETag"))
+			assert.Equal(t, "Sun, 01 Mar 2026 12:00:00 GMT", rec.Header().Get("Last-Modified"))
+		})
+	}
+
+	t.Run("no-store responses get no validators", func(t *testing.T) {
+		c, rec := newContext(http.MethodGet, map[string]string{"If-None-Match": etag})
+		c.Response().Header().Set("Cache-Control", "private, no-store")
+		Revalidate(c, true)
+		assert.False(t, NotModified(c, etag, modified))
+		assert.Empty(t, rec.Header().Get("ETag"))
+		assert.Empty(t, rec.Header().Get("Last-Modified"))
+		assert.Equal(t, "private, no-store", rec.Header().Get("Cache-Control"))
+	})
+}
+
+func TestHasher(t *testing.T) {
+	h := NewHasher()
+	h.Write([]byte("hel"))
+	h.Write([]byte("lo"))
+	assert.Equal(t, ETag([]byte("hello")), h.ETag())
+}
+
+func TestJSON(t *testing.T) {
+	value := map[string]string{"title": "notes"}
+	modified := time.Now()
+
+	c, rec := newContext(http.MethodGet, nil)
+	Revalidate(c, true)
+	require.NoError(t, JSON(c, value, modified))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.JSONEq(t, `{"title":"notes"}`, rec.Body.String())
+	assert.Equal(t, "public, no-cache", rec.Header().Get("Cache-Control"))
+	etag := rec.Header().Get("ETag")
+	require.NotEmpty(t, etag)
+
+	c, rec = newContext(http.MethodGet, map[string]string{"If-None-Match": etag})
+	Revalidate(c, false)
+	require.NoError(t, JSON(c, value, modified))
+	assert.Equal(t, http.StatusNotModified, rec.Code)
+	assert.Empty(t, rec.Body.String())
+	assert.Equal(t, "private, no-cache", rec.Header().Get("Cache-Control"))
+
+	// A change to the content changes the ETag
+	c, rec = newContext(http.MethodGet, map[string]string{"If-None-Match": etag})
+	require.NoError(t, JSON(c, map[string]string{"title": "todo"}, modified))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, etag, rec.Header().Get("ETag"))
+}
@@ -0,0 +1,140 @@
+// Package imageproxy serves external images through the server, the way
+// camo does for GitHub. Image URLs in rendered markdown and user avatars
+// are rewritten to signed /camo/<digest>/<hex url> URLs, so readers never
+// contact third-party hosts (which would learn their IP address) and
+// pages served over https never load images over plain http.
+//
+// Images are fetched when first requested, not while the page is
+// rendered, and kept in a size-bounded disk cache.
+package imageproxy
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net/url"
+	"strings"
+
+	"golang.org/x/net/html"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Path is where the proxy is served
+const Path = "/camo/"
+
+var (
+	ErrInvalidSignature = errors.New("invalid image proxy signature")
+	ErrInvalidURL       = errors.New("invalid image URL")
+)
+
+// Signer rewrites external image URLs to signed proxy URLs. A nil or
+// disabled signer leaves URLs as they are.
+type Signer struct {
+	key  []byte
+	base string // Server URL the proxy path is appended to, may be empty
+	host string // Host of the server itself, whose images aren't proxied
+}
+
+// NewSigner creates a signer from the image_proxy.* settings. It returns
+// nil when the proxy is disabled. The key defaults to security.secret_key.
+func NewSigner(config *config.Config) *Signer {
+	if !config.GetBool("image_proxy.enabled") {
+		return nil
+	}
+	key := config.GetString("image_proxy.key")
+	if key == "" {
+		key = config.GetString("security.secret_key")
+	}
+	if key == "" {
+		return nil
+	}
+
+	signer := &Signer{key: []byte(key)}
+	if serverURL, err := url.Parse(config.GetString("server.url")); err == nil && serverURL.Host != "" {
+		signer.base = strings.TrimSuffix(serverURL.String(), "/")
+		signer.host = strings.ToLower(serverURL.Host)
+	}
+	return signer
+}
+
+// URL returns the proxy URL for an image. Relative URLs, data: URLs and
+// images on the server itself are returned unchanged.
+func (s *Signer) URL(raw string) string {
+	if s == nil {
+		return raw
+	}
+	u, err := url.Parse(strings.TrimSpace(raw))
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return raw
+	}
+	if s.host != "" && strings.EqualFold(u.Host, s.host) {
+		return raw
+	}
+	target := u.String()
+	return s.base + Path + s.digest(target) + "/" + hex.EncodeToString([]byte(target))
+}
+
+// Verify checks a digest and hex encoded URL taken from a proxy URL and
+// returns the image URL
+func (s *Signer) Verify(digest, encoded string) (string, error) {
+	decoded, err := hex.DecodeString(encoded)
+	if err != nil {
+		return "", ErrInvalidURL
+	}
+	target := string(decoded)
+	if !hmac.Equal([]byte(digest), []byte(s.digest(target))) {
+		return "", ErrInvalidSignature
+	}
+	u, err := url.Parse(target)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return "", ErrInvalidURL
+	}
+	return target, nil
+}
+
+func (s *Signer) digest(target string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(target))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// RewriteHTML rewrites the src of every img element in sanitized HTML to
+// go through the proxy. Everything else is copied as is.
+func (s *Signer) RewriteHTML(source string) string {
+	if s == nil || !strings.Contains(source, "<img") {
+		return source
+	}
+
+	var out bytes.Buffer
+	z := html.NewTokenizer(strings.NewReader(source))
+	for {
+		tt := z.Next()
+		if tt == html.ErrorToken {
+			if z.Err() != io.EOF {
+				// Never drop content over a tokenizer error
+				return source
+			}
+			return out.String()
+		}
+		if tt != html.StartTagToken && tt != html.SelfClosingTagToken {
+			out.Write(z.Raw())
+			continue
+		}
+		raw := append([]byte(nil), z.Raw()...)
+		token := z.Token()
+		if token.Data != "img" {
+			out.Write(raw)
+			continue
+		}
+		for i, attr := range token.Attr {
+			if attr.Key == "src" {
+				token.Attr[i].Val = s.URL(attr.Val)
+			}
+		}
+		out.WriteString(token.String())
+	}
+}
@@ -0,0 +1,141 @@
+package imageproxy
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+var png = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func testConfig(t *testing.T) *config.Config {
+	config := config.New()
+	config.Set("image_proxy.enabled", true)
+	config.Set("security.secret_key", "test-secret")
+	config.Set("server.url", "https://gists.example.com/")
+	config.Set("image_proxy.cache_dir", t.TempDir())
+	config.Set("image_proxy.max_bytes", 1024)
+	return config
+}
+
+func TestSigner(t *testing.T) {
+	signer := NewSigner(testConfig(t))
+	require.NotNil(t, signer)
+
+	proxied := signer.URL("http://images.example.net/cat.png?size=2")
+	assert.True(t, strings.HasPrefix(proxied, "https://gists.example.com/camo/"), proxied)
+	parts := strings.Split(strings.TrimPrefix(proxied, "https://gists.example.com/camo/"), "/")
+	require.Len(t, parts, 2)
+	target, err := signer.Verify(parts[0], parts[1])
+	require.NoError(t, err)
+	assert.Equal(t, "http://images.example.net/cat.png?size=2", target)
+
+	_, err = signer.Verify(strings.Repeat("0", 64), parts[1])
+	assert.ErrorIs(t, err, ErrInvalidSignature)
+	_, err = signer.Verify(parts[0], "zz")
+	assert.ErrorIs(t, err, ErrInvalidURL)
+
+	for _, raw := range []string{"/static/logo.png", "data:image/png;base64,AAAA", "https://gists.example.com/raw/x.png", "javascript:alert(1)"} {
+		assert.Equal(t, raw, signer.URL(raw))
+	}
+
+	rewritten := signer.RewriteHTML(`<p>Hi <img src="https://a.example/x.png" alt="x &amp; y"> <a href="https://a.example/x.png">link</a></p>`)
+	assert.Contains(t, rewritten, `<img src="https://gists.example.com/camo/`)
+	assert.Contains(t, rewritten, `alt="x &amp; y"`)
+	assert.Contains(t, rewritten, `<a href="https://a.example/x.png">link</a></p>`)
+
+	// Disabled, URLs are left alone
+	var disabled *Signer
+	assert.Equal(t, "https://a.example/x.png", disabled.URL("https://a.example/x.png"))
+	assert.Nil(t, NewSigner(config.New()))
+}
+
+func TestProxy(t *testing.T) {
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		switch r.URL.Path {
+		case "/cat.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(png)
+		case "/unlabeled":
+			w.Write(png)
+		case "/page.html":
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<html></html>"))
+		case "/logo.svg":
+			w.Header().Set("Content-Type", "image/svg+xml")
+			w.Write([]byte("<svg/>"))
+		case "/huge.png":
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(make([]byte, 2048))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer upstream.Close()
+
+	config := testConfig(t)
+	t.Run("PrivateAddress", func(t *testing.T) {
+		_, err := NewProxy(config).Get(context.Background(), upstream.URL+"/cat.png")
+		assert.ErrorIs(t, err, ErrPrivateAddress)
+	})
+
+	config.Set("image_proxy.allow_private", true)
+	proxy := NewProxy(config)
+	e := echo.New()
+	serve := func(target string) *httptest.ResponseRecorder {
+		parts := strings.Split(strings.TrimPrefix(proxy.Signer().URL(target), "https://gists.example.com/camo/"), "/")
+		rec := httptest.NewRecorder()
+		c := e.NewContext(httptest.NewRequest(http.MethodGet, "/", nil), rec)
+		c.SetParamNames("digest", "url")
+		c.SetParamValues(parts[0], parts[1])
+		if err := proxy.Handle(c); err != nil {
+			e.HTTPErrorHandler(err, c)
+		}
+		return rec
+	}
+
+	rec := serve(upstream.URL + "/cat.png")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+	assert.Equal(t, "nosniff", rec.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, png, rec.Body.Bytes())
+
+	// Served from the cache the second time
+	assert.Equal(t, http.StatusOK, serve(upstream.URL+"/cat.png").Code)
+	assert.Equal(t, int32(1), requests.Load())
+
+	rec = serve(upstream.URL + "/unlabeled")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "image/png", rec.Header().Get("Content-Type"))
+
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(upstream.URL+"/page.html").Code)
+	assert.Equal(t, http.StatusUnsupportedMediaType, serve(upstream.URL+"/logo.svg").Code)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, serve(upstream.URL+"/huge.png").Code)
+	assert.Equal(t, http.StatusBadGateway, serve(upstream.URL+"/missing.png").Code)
+
+	t.Run("Trim", func(t *testing.T) {
+		proxy.cacheMaxBytes = int64(len(png))
+		require.NoError(t, proxy.trim())
+		var files int
+		filepath.Walk(proxy.cacheDir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && info.Mode().IsRegular() && !strings.HasSuffix(path, ".type") {
+				files++
+			}
+			return nil
+		})
+		assert.Equal(t, 1, files)
+	})
+}
@@ -0,0 +1,342 @@
+package imageproxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// maxRedirects bounds the redirects followed for one image
+const maxRedirects = 3
+
+var (
+	ErrTooLarge       = errors.New("image is too large")
+	ErrNotImage       = errors.New("not an image")
+	ErrPrivateAddress = errors.New("image host resolves to a private address")
+)
+
+// Image is a fetched image
+type Image struct {
+	ContentType string
+	Body        []byte
+}
+
+// Proxy fetches and caches the images behind signed proxy URLs
+type Proxy struct {
+	signer        *Signer
+	client        *http.Client
+	maxBytes      int64
+	cacheDir      string // Empty disables the disk cache
+	cacheTTL      time.Duration
+	cacheMaxBytes int64
+
+	mu       sync.Mutex
+	inflight map[string]*fetch
+	trimming atomic.Bool
+}
+
+// fetch is a download other requests for the same image wait on
+type fetch struct {
+	done  chan struct{}
+	image *Image
+	err   error
+}
+
+// NewProxy creates a proxy from the image_proxy.* settings. It returns nil
+// when the proxy is disabled.
+func NewProxy(config *config.Config) *Proxy {
+	signer := NewSigner(config)
+	if signer == nil {
+		return nil
+	}
+
+	timeout := config.GetDuration("image_proxy.timeout")
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	maxBytes := config.GetInt64("image_proxy.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = 5 * 1024 * 1024
+	}
+	cacheTTL := config.GetDuration("image_proxy.cache_ttl")
+	if cacheTTL <= 0 {
+		cacheTTL = 24 * time.Hour
+	}
+
+	// Images are fetched on behalf of anyone who can write markdown, so
+	// addresses inside the network are off limits unless allowed
+	dialer := &net.Dialer{Timeout: timeout}
+	if !config.GetBool("image_proxy.allow_private") {
+		dialer.Control = func(network, address string, _ syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !publicIP(ip) {
+				return ErrPrivateAddress
+			}
+			return nil
+		}
+	}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           dialer.DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+			MaxIdleConns:          20,
+			IdleConnTimeout:       90 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("stopped after %d redirects", maxRedirects)
+			}
+			if req.URL.Scheme != "http" && req.URL.Scheme != "https" {
+				return ErrInvalidURL
+			}
+			return nil
+		},
+	}
+
+	return &Proxy{
+		signer:        signer,
+		client:        client,
+		maxBytes:      maxBytes,
+		cacheDir:      config.GetString("image_proxy.cache_dir"),
+		cacheTTL:      cacheTTL,
+		cacheMaxBytes: config.GetInt64("image_proxy.cache_max_bytes"),
+		inflight:      map[string]*fetch{},
+	}
+}
+
+// Signer returns the signer for the proxy's URLs
+func (p *Proxy) Signer() *Signer {
+	return p.signer
+}
+
+// Handle serves GET /camo/:digest/:url
+func (p *Proxy) Handle(c echo.Context) error {
+	target, err := p.signer.Verify(c.Param("digest"), c.Param("url"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "image not found")
+	}
+
+	image, err := p.Get(c.Request().Context(), target)
+	switch {
+	case err == nil:
+	case errors.Is(err, ErrTooLarge):
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, err.Error())
+	case errors.Is(err, ErrNotImage):
+		return echo.NewHTTPError(http.StatusUnsupportedMediaType, err.Error())
+	default:
+		return echo.NewHTTPError(http.StatusBadGateway, "failed to fetch image")
+	}
+
+	header := c.Response().Header()
+	header.Set("Cache-Control", "public, max-age="+strconv.Itoa(int(p.cacheTTL.Seconds())))
+	header.Set("X-Content-Type-Options", "nosniff")
+	header.Set("Content-Security-Policy", "default-src 'none'; img-src data:; style-src 'unsafe-inline'")
+	return c.Blob(http.StatusOK, image.ContentType, image.Body)
+}
+
+// Get returns an image from the cache, fetching it when it isn't cached or
+// has expired. Concurrent requests for the same image share one download.
+func (p *Proxy) Get(ctx context.Context, target string) (*Image, error) {
+	key := cacheKey(target)
+	if image := p.cached(key); image != nil {
+		return image, nil
+	}
+
+	p.mu.Lock()
+	if f, ok := p.inflight[key]; ok {
+		p.mu.Unlock()
+		select {
+		case <-f.done:
+			return f.image, f.err
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	f := &fetch{done: make(chan struct{})}
+	p.inflight[key] = f
+	p.mu.Unlock()
+
+	// The download outlives a reader who gives up, so the next one finds
+	// the image cached
+	f.image, f.err = p.download(context.Background(), target)
+	if f.err == nil {
+		p.store(key, f.image)
+	}
+	close(f.done)
+
+	p.mu.Lock()
+	delete(p.inflight, key)
+	p.mu.Unlock()
+	return f.image, f.err
+}
+
+// download fetches an image, refusing anything that isn't a raster image
+// or is larger than max_bytes
+func (p *Proxy) download(ctx context.Context, target string) (*Image, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+	if err != nil {
+		return nil, ErrInvalidURL
+	}
+	req.Header.Set("User-Agent", "CasGists-ImageProxy")
+	req.Header.Set("Accept", "image/*")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("image host answered %s", resp.Status)
+	}
+	if resp.ContentLength > p.maxBytes {
+		return nil, ErrTooLarge
+	}
+	body, err := io.ReadAll(io.LimitReader(resp.Body, p.maxBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(body)) > p.maxBytes {
+		return nil, ErrTooLarge
+	}
+
+	contentType := strings.ToLower(strings.TrimSpace(strings.Split(resp.Header.Get("Content-Type"), ";")[0]))
+	if contentType == "" || contentType == "application/octet-stream" {
+		contentType = http.DetectContentType(body)
+	}
+	// SVG can carry scripts, so only raster images are passed on
+	if !strings.HasPrefix(contentType, "image/") || strings.Contains(contentType, "svg") {
+		return nil, ErrNotImage
+	}
+	return &Image{ContentType: contentType, Body: body}, nil
+}
+
+// cached returns an image from the disk cache if it is there and fresh
+func (p *Proxy) cached(key string) *Image {
+	if p.cacheDir == "" {
+		return nil
+	}
+	path := p.cachePath(key)
+	info, err := os.Stat(path)
+	if err != nil || time.Since(info.ModTime()) > p.cacheTTL {
+		return nil
+	}
+	contentType, err := os.ReadFile(path + ".type")
+	if err != nil {
+		return nil
+	}
+	body, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	return &Image{ContentType: string(contentType), Body: body}
+}
+
+// store writes an image to the disk cache and trims the cache in the
+// background when it has grown past cache_max_bytes
+func (p *Proxy) store(key string, image *Image) {
+	if p.cacheDir == "" {
+		return
+	}
+	path := p.cachePath(key)
+	if err := os.MkdirAll(filepath.Dir(path), 0750); err != nil {
+		slog.Warn("Failed to cache proxied image", "error", err)
+		return
+	}
+	if err := os.WriteFile(path+".type", []byte(image.ContentType), 0640); err != nil {
+		slog.Warn("Failed to cache proxied image", "error", err)
+		return
+	}
+	if err := os.WriteFile(path, image.Body, 0640); err != nil {
+		slog.Warn("Failed to cache proxied image", "error", err)
+		return
+	}
+
+	if p.cacheMaxBytes > 0 && p.trimming.CompareAndSwap(false, true) {
+		go func() {
+			defer p.trimming.Store(false)
+			if err := p.trim(); err != nil {
+				slog.Error("Failed to trim image proxy cache", "error", err)
+			}
+		}()
+	}
+}
+
+// trim removes the least recently fetched images until the cache fits in
+// cache_max_bytes
+func (p *Proxy) trim() error {
+	type entry struct {
+		path    string
+		size    int64
+		modTime time.Time
+	}
+	var entries []entry
+	var total int64
+	err := filepath.Walk(p.cacheDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.Mode().IsRegular() && !strings.HasSuffix(path, ".type") {
+			entries = append(entries, entry{path: path, size: info.Size(), modTime: info.ModTime()})
+			total += info.Size()
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].modTime.Before(entries[j].modTime) })
+	for _, e := range entries {
+		if total <= p.cacheMaxBytes {
+			break
+		}
+		os.Remove(e.path + ".type")
+		if err := os.Remove(e.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		total -= e.size
+	}
+	return nil
+}
+
+func (p *Proxy) cachePath(key string) string {
+	return filepath.Join(p.cacheDir, key[:2], key)
+}
+
+func cacheKey(target string) string {
+	sum := sha256.Sum256([]byte(target))
+	return hex.EncodeToString(sum[:])
+}
+
+// publicIP reports whether ip is reachable on the internet, not a
+// loopback, private, link-local or otherwise reserved address
+func publicIP(ip net.IP) bool {
+	return !(ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified())
+}
@@ -14,9 +14,12 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
 	"text/template"
 	"time"
+
+	"github.com/casapps/casgists/src/internal/diskspace"
+	"github.com/casapps/casgists/src/internal/logging"
+	"github.com/casapps/casgists/src/internal/privileges"
 )
 
 // Installer handles the installation of CasGists as a system service
@@ -42,40 +45,83 @@ type InstallerConfig struct {
 	NoSystemService bool
 }
 
+// DefaultConfig returns the installation layout of the platform: the FHS
+// directories on Linux and macOS, Program Files and ProgramData on Windows
+func DefaultConfig() InstallerConfig {
+	config := InstallerConfig{
+		ServiceName: "casgists",
+		User:        "casgists",
+		Group:       "casgists",
+		Port:        64080,
+		Description: "CasGists - Self-hosted GitHub Gist Alternative",
+	}
+
+	if runtime.GOOS == "windows" {
+		// The service runs as LocalSystem, which the server gives the
+		// %ProgramData%\casgists layout too
+		dataDir := filepath.Join(windowsDir("ProgramData", `C:\ProgramData`), "casgists")
+		config.InstallPath = filepath.Join(windowsDir("ProgramFiles", `C:\Program Files`), "CasGists")
+		config.DataDir = dataDir
+		config.ConfigPath = filepath.Join(dataDir, "config.yaml")
+		config.WorkingDir = dataDir
+		config.EnvFile = filepath.Join(dataDir, "environment")
+		config.LogFile = filepath.Join(dataDir, "logs", logging.ServerLog)
+		return config
+	}
+
+	config.InstallPath = "/opt/casgists"
+	config.DataDir = "/var/lib/casgists"
+	config.ConfigPath = "/etc/casgists/config.yaml"
+	config.WorkingDir = "/var/lib/casgists"
+	config.EnvFile = "/etc/casgists/environment"
+	config.LogFile = "/var/log/casgists/casgists.log"
+	return config
+}
+
+// windowsDir returns a known folder from the environment, or its usual
+// location when the variable isn't set
+func windowsDir(envVar, fallback string) string {
+	if dir := os.Getenv(envVar); dir != "" {
+		return dir
+	}
+	return fallback
+}
+
 // NewInstaller creates a new installer instance
 func NewInstaller(config InstallerConfig) *Installer {
+	defaults := DefaultConfig()
 	if config.ServiceName == "" {
-		config.ServiceName = "casgists"
+		config.ServiceName = defaults.ServiceName
 	}
 	if config.User == "" {
-		config.User = "casgists"
+		config.User = defaults.User
 	}
 	if config.Group == "" {
-		config.Group = "casgists"
+		config.Group = defaults.Group
 	}
 	if config.Port == 0 {
-		config.Port = 64080
+		config.Port = defaults.Port
 	}
 	if config.InstallPath == "" {
-		config.InstallPath = "/opt/casgists"
+		config.InstallPath = defaults.InstallPath
 	}
 	if config.DataDir == "" {
-		config.DataDir = "/var/lib/casgists"
+		config.DataDir = defaults.DataDir
 	}
 	if config.ConfigPath == "" {
-		config.ConfigPath = "/etc/casgists/config.yaml"
+		config.ConfigPath = defaults.ConfigPath
 	}
 	if config.WorkingDir == "" {
-		config.WorkingDir = "/var/lib/casgists"
+		config.WorkingDir = config.DataDir
 	}
 	if config.EnvFile == "" {
-		config.EnvFile = "/etc/casgists/environment"
+		config.EnvFile = defaults.EnvFile
 	}
 	if config.LogFile == "" {
-		config.LogFile = "/var/log/casgists/casgists.log"
+		config.LogFile = defaults.LogFile
 	}
 	if config.Description == "" {
-		config.Description = "CasGists - Self-hosted GitHub Gist Alternative"
+		config.Description = defaults.Description
 	}
 
 	return &Installer{
@@ -86,9 +132,8 @@ func NewInstaller(config InstallerConfig) *Installer {
 
 // Install performs the system installation
 func (i *Installer) Install(ctx context.Context) error {
-	// Check if running as root
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("installation must be run as root (use sudo)")
+	if err := RequireAdmin("installation"); err != nil {
+		return err
 	}
 
 	// Detect OS and choose installation method
@@ -97,6 +142,8 @@ func (i *Installer) Install(ctx context.Context) error {
 		return i.installLinux(ctx)
 	case "darwin":
 		return i.installDarwin(ctx)
+	case "windows":
+		return i.installWindows(ctx)
 	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
@@ -248,7 +295,7 @@ func (i *Installer) createDirectories() error {
 
 // installBinary copies the CasGists binary to the installation location
 func (i *Installer) installBinary() error {
-	targetPath := filepath.Join(i.Config.InstallPath, "bin", "casgists")
+	targetPath := i.binaryPath()
 
 	// Create bin directory
 	binDir := filepath.Join(i.Config.InstallPath, "bin")
@@ -275,6 +322,12 @@ func (i *Installer) installBinary() error {
 		return fmt.Errorf("failed to copy binary: %w", err)
 	}
 
+	// Windows has no common bin directory; the service runs the binary
+	// from the install path
+	if runtime.GOOS == "windows" {
+		return nil
+	}
+
 	// Create symlink in /usr/local/bin for CLI access
 	symlinkPath := "/usr/local/bin/casgists"
 	os.Remove(symlinkPath) // Remove existing symlink if any
@@ -285,13 +338,22 @@ func (i *Installer) installBinary() error {
 	return nil
 }
 
+// binaryPath returns where the binary is installed
+func (i *Installer) binaryPath() string {
+	name := "casgists"
+	if runtime.GOOS == "windows" {
+		name += ".exe"
+	}
+	return filepath.Join(i.Config.InstallPath, "bin", name)
+}
+
 // setupPortCapability sets CAP_NET_BIND_SERVICE capability for binding to privileged ports
 func (i *Installer) setupPortCapability() error {
 	if runtime.GOOS != "linux" {
 		return nil
 	}
 
-	binaryPath := filepath.Join(i.Config.InstallPath, "bin", "casgists")
+	binaryPath := i.binaryPath()
 
 	// Check if setcap is available
 	if _, err := exec.LookPath("setcap"); err != nil {
@@ -322,7 +384,7 @@ Type=notify
 User={{.User}}
 Group={{.Group}}
 WorkingDirectory={{.WorkingDir}}
-ExecStart={{.InstallPath}}/bin/casgists serve --config {{.ConfigPath}}
+ExecStart={{.InstallPath}}/bin/casgists serve
 Restart=on-failure
 RestartSec=5s
 
@@ -677,8 +739,8 @@ func (i *Installer) setPermissions() error {
 
 // Uninstall removes CasGists from the system
 func (i *Installer) Uninstall(ctx context.Context) error {
-	if os.Geteuid() != 0 {
-		return fmt.Errorf("uninstallation must be run as root (use sudo)")
+	if err := RequireAdmin("uninstallation"); err != nil {
+		return err
 	}
 
 	fmt.Fprintln(i.writer, "Uninstalling CasGists...")
@@ -702,10 +764,16 @@ func (i *Installer) Uninstall(ctx context.Context) error {
 		plistPath := fmt.Sprintf("/Library/LaunchDaemons/com.casapps.%s.plist", i.Config.ServiceName)
 		exec.Command("launchctl", "unload", plistPath).Run()
 		os.Remove(plistPath)
+	case "windows":
+		if err := i.uninstallWindowsService(); err != nil {
+			fmt.Fprintf(i.writer, "Warning: %v\n", err)
+		}
 	}
 
 	// Remove symlink
-	os.Remove("/usr/local/bin/casgists")
+	if runtime.GOOS != "windows" {
+		os.Remove("/usr/local/bin/casgists")
+	}
 
 	// Ask about data removal
 	fmt.Fprintln(i.writer, "\nDo you want to remove all CasGists data? (This cannot be undone)")
@@ -743,7 +811,11 @@ func (i *Installer) printPostInstallInstructions() {
 	fmt.Fprintln(i.writer, strings.Repeat("=", 60))
 	fmt.Fprintln(i.writer, "\nNext steps:")
 	fmt.Fprintln(i.writer, "1. Review and edit the configuration file:")
-	fmt.Fprintf(i.writer, "   sudo nano %s\n", i.Config.ConfigPath)
+	if runtime.GOOS == "windows" {
+		fmt.Fprintf(i.writer, "   notepad \"%s\"\n", i.Config.ConfigPath)
+	} else {
+		fmt.Fprintf(i.writer, "   sudo nano %s\n", i.Config.ConfigPath)
+	}
 	fmt.Fprintln(i.writer, "\n2. Start the CasGists service:")
 
 	switch runtime.GOOS {
@@ -757,13 +829,20 @@ func (i *Installer) printPostInstallInstructions() {
 		}
 	case "darwin":
 		fmt.Fprintf(i.writer, "   sudo launchctl start com.casapps.%s\n", i.Config.ServiceName)
+	case "windows":
+		fmt.Fprintf(i.writer, "   sc.exe start %s\n", i.Config.ServiceName)
+		fmt.Fprintf(i.writer, "   sc.exe query %s\n", i.Config.ServiceName)
 	}
 
 	fmt.Fprintln(i.writer, "\n3. Access CasGists at:")
 	fmt.Fprintf(i.writer, "   http://localhost:%d\n", i.Config.Port)
 	fmt.Fprintln(i.writer, "\n4. Complete the setup wizard on first access")
 	fmt.Fprintln(i.writer, "\nView logs:")
-	fmt.Fprintf(i.writer, "   tail -f %s\n", i.Config.LogFile)
+	if runtime.GOOS == "windows" {
+		fmt.Fprintf(i.writer, "   Get-Content -Wait \"%s\"\n", i.Config.LogFile)
+	} else {
+		fmt.Fprintf(i.writer, "   tail -f %s\n", i.Config.LogFile)
+	}
 
 	if runtime.GOOS == "linux" && detectInitSystem() == "systemd" {
 		fmt.Fprintf(i.writer, "   journalctl -u %s -f\n", i.Config.ServiceName)
@@ -807,10 +886,24 @@ func CheckPort(port int) error {
 	return nil
 }
 
+// RequireAdmin fails unless the process may install system services: root
+// on Linux and macOS, an elevated Administrator on Windows
+func RequireAdmin(action string) error {
+	if privileges.IsElevated() {
+		return nil
+	}
+	if runtime.GOOS == "windows" {
+		return fmt.Errorf("%s must be run as Administrator (from an elevated prompt)", action)
+	}
+	return fmt.Errorf("%s must be run as root (use sudo)", action)
+}
+
 // VerifySystemRequirements checks system requirements
 func VerifySystemRequirements() error {
 	// Check OS
-	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+	switch runtime.GOOS {
+	case "linux", "darwin", "windows":
+	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 
@@ -820,13 +913,21 @@ func VerifySystemRequirements() error {
 	}
 
 	// Check available disk space (require at least 1GB)
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs("/", &stat); err == nil {
-		availableGB := stat.Bavail * uint64(stat.Bsize) / (1024 * 1024 * 1024)
+	if usage, err := diskspace.Of(systemRoot()); err == nil {
+		availableGB := usage.Available / (1024 * 1024 * 1024)
 		if availableGB < 1 {
 			return fmt.Errorf("insufficient disk space: %d GB available (minimum 1 GB required)", availableGB)
 		}
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+
+// systemRoot returns the root of the system drive, where the program and
+// its data go by default
+func systemRoot() string {
+	if runtime.GOOS == "windows" {
+		return windowsDir("SystemDrive", "C:") + `\`
+	}
+	return "/"
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package installer
+
+import (
+	"context"
+	"errors"
+)
+
+var errWindowsOnly = errors.New("Windows services can only be managed on Windows")
+
+func (i *Installer) installWindows(ctx context.Context) error {
+	return errWindowsOnly
+}
+
+func (i *Installer) uninstallWindowsService() error {
+	return errWindowsOnly
+}
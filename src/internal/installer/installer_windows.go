@@ -0,0 +1,149 @@
+//go:build windows
+
+package installer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows"
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+)
+
+// installWindows handles Windows installation: the binary goes to Program
+// Files, the data to ProgramData and the service is registered with the
+// service control manager
+func (i *Installer) installWindows(ctx context.Context) error {
+	fmt.Fprintln(i.writer, "Installing CasGists for Windows...")
+
+	// Create directory structure
+	if err := i.createDirectories(); err != nil {
+		return fmt.Errorf("failed to create directories: %w", err)
+	}
+
+	// Copy binary to install location
+	if err := i.installBinary(); err != nil {
+		return fmt.Errorf("failed to install binary: %w", err)
+	}
+
+	// Create initial configuration
+	if err := i.createInitialConfig(); err != nil {
+		return fmt.Errorf("failed to create initial configuration: %w", err)
+	}
+
+	if i.Config.NoSystemService {
+		fmt.Fprintln(i.writer, "Skipping service registration")
+	} else if err := i.installWindowsService(); err != nil {
+		return fmt.Errorf("failed to install Windows service: %w", err)
+	}
+
+	fmt.Fprintln(i.writer, "\nInstallation completed successfully!")
+	i.printPostInstallInstructions()
+
+	return nil
+}
+
+// installWindowsService registers the service with the service control
+// manager. It starts automatically, after the other automatic services, runs
+// as LocalSystem and is restarted when it fails.
+func (i *Installer) installWindowsService() error {
+	fmt.Fprintln(i.writer, "Registering Windows service...")
+
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	if s, err := m.OpenService(i.Config.ServiceName); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists, uninstall it first", i.Config.ServiceName)
+	}
+
+	s, err := m.CreateService(i.Config.ServiceName, i.binaryPath(), mgr.Config{
+		DisplayName:      "CasGists",
+		Description:      i.Config.Description,
+		StartType:        mgr.StartAutomatic,
+		DelayedAutoStart: true,
+	}, "serve", "--service")
+	if err != nil {
+		return fmt.Errorf("failed to create service: %w", err)
+	}
+	defer s.Close()
+
+	// Restart after 5 seconds, then after a minute; the count resets after
+	// a day without failures
+	recovery := []mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}
+	if err := s.SetRecoveryActions(recovery, uint32((24 * time.Hour).Seconds())); err != nil {
+		fmt.Fprintf(i.writer, "Warning: Failed to set service recovery actions: %v\n", err)
+	}
+
+	if err := i.setServiceEnvironment(); err != nil {
+		s.Delete()
+		return err
+	}
+
+	return nil
+}
+
+// setServiceEnvironment points the service at the installed directories,
+// the way the environment file does for systemd. The service manager
+// passes the Environment value of the service's key to the process.
+func (i *Installer) setServiceEnvironment() error {
+	key, err := registry.OpenKey(registry.LOCAL_MACHINE,
+		`SYSTEM\CurrentControlSet\Services\`+i.Config.ServiceName, registry.SET_VALUE)
+	if err != nil {
+		return fmt.Errorf("failed to open service registry key: %w", err)
+	}
+	defer key.Close()
+
+	environment := []string{
+		"CASGISTS_DATA_DIR=" + i.Config.DataDir,
+		"CASGISTS_LOG_DIR=" + filepath.Dir(i.Config.LogFile),
+	}
+	if err := key.SetStringsValue("Environment", environment); err != nil {
+		return fmt.Errorf("failed to set service environment: %w", err)
+	}
+	return nil
+}
+
+// uninstallWindowsService stops the service, waiting for it to shut down
+// gracefully, and removes it
+func (i *Installer) uninstallWindowsService() error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service manager: %w", err)
+	}
+	defer m.Disconnect()
+
+	s, err := m.OpenService(i.Config.ServiceName)
+	if err != nil {
+		return fmt.Errorf("service %s is not installed", i.Config.ServiceName)
+	}
+	defer s.Close()
+
+	status, err := s.Control(svc.Stop)
+	if err != nil && !errors.Is(err, windows.ERROR_SERVICE_NOT_ACTIVE) {
+		return fmt.Errorf("failed to stop service: %w", err)
+	}
+	// The server waits up to server.shutdown_timeout for requests
+	deadline := time.Now().Add(time.Minute)
+	for err == nil && status.State != svc.Stopped && time.Now().Before(deadline) {
+		time.Sleep(500 * time.Millisecond)
+		status, err = s.Query()
+	}
+
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to delete service: %w", err)
+	}
+	return nil
+}
@@ -9,15 +9,13 @@ import (
 	"strings"
 	"text/template"
 
-	"github.com/spf13/viper"
-
 	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/privilege"
 )
 
 // ServiceInstaller handles system service installation
 type ServiceInstaller struct {
-	cfg              *viper.Viper
+	cfg              *config.Config
 	pathConfig       *config.PathConfig
 	privilegeService *privilege.EscalationService
 }
@@ -51,7 +49,7 @@ type InstallationResult struct {
 }
 
 // NewServiceInstaller creates a new service installer
-func NewServiceInstaller(cfg *viper.Viper, pathConfig *config.PathConfig) *ServiceInstaller {
+func NewServiceInstaller(cfg *config.Config, pathConfig *config.PathConfig) *ServiceInstaller {
 	return &ServiceInstaller{
 		cfg:              cfg,
 		pathConfig:       pathConfig,
@@ -419,7 +417,7 @@ Type=simple
 User={{.User}}
 Group={{.Group}}
 WorkingDirectory={{.WorkingDir}}
-ExecStart={{.BinaryPath}} --config={{.WorkingDir}}/config.toml
+ExecStart={{.BinaryPath}} serve
 Restart=always
 RestartSec=10
 StandardOutput=journal
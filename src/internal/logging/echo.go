@@ -0,0 +1,142 @@
+package logging
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/gommon/log"
+)
+
+// EchoLogger routes Echo's logger, which handlers and the server use as
+// e.Logger, to a slog logger. The level and output belong to the slog
+// logger, so the setters only record what they were given.
+type EchoLogger struct {
+	logger *slog.Logger
+	prefix string
+	level  log.Lvl
+}
+
+var _ echo.Logger = (*EchoLogger)(nil)
+
+// NewEchoLogger wraps logger for Echo
+func NewEchoLogger(logger *slog.Logger) *EchoLogger {
+	return &EchoLogger{logger: logger, level: log.DEBUG}
+}
+
+func (l *EchoLogger) Output() io.Writer     { return os.Stdout }
+func (l *EchoLogger) SetOutput(w io.Writer) {}
+func (l *EchoLogger) Prefix() string        { return l.prefix }
+func (l *EchoLogger) SetPrefix(p string)    { l.prefix = p }
+func (l *EchoLogger) Level() log.Lvl        { return l.level }
+func (l *EchoLogger) SetLevel(v log.Lvl)    { l.level = v }
+func (l *EchoLogger) SetHeader(h string)    {}
+
+func (l *EchoLogger) Print(i ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprint(i...))
+}
+
+func (l *EchoLogger) Printf(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *EchoLogger) Printj(j log.JSON) {
+	l.log(slog.LevelInfo, jsonString(j))
+}
+
+func (l *EchoLogger) Debug(i ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprint(i...))
+}
+
+func (l *EchoLogger) Debugf(format string, args ...interface{}) {
+	l.log(slog.LevelDebug, fmt.Sprintf(format, args...))
+}
+
+func (l *EchoLogger) Debugj(j log.JSON) {
+	l.log(slog.LevelDebug, jsonString(j))
+}
+
+func (l *EchoLogger) Info(i ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprint(i...))
+}
+
+func (l *EchoLogger) Infof(format string, args ...interface{}) {
+	l.log(slog.LevelInfo, fmt.Sprintf(format, args...))
+}
+
+func (l *EchoLogger) Infoj(j log.JSON) {
+	l.log(slog.LevelInfo, jsonString(j))
+}
+
+func (l *EchoLogger) Warn(i ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprint(i...))
+}
+
+func (l *EchoLogger) Warnf(format string, args ...interface{}) {
+	l.log(slog.LevelWarn, fmt.Sprintf(format, args...))
+}
+
+func (l *EchoLogger) Warnj(j log.JSON) {
+	l.log(slog.LevelWarn, jsonString(j))
+}
+
+func (l *EchoLogger) Error(i ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprint(i...))
+}
+
+func (l *EchoLogger) Errorf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *EchoLogger) Errorj(j log.JSON) {
+	l.log(slog.LevelError, jsonString(j))
+}
+
+func (l *EchoLogger) Fatal(i ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprint(i...))
+	os.Exit(1)
+}
+
+func (l *EchoLogger) Fatalf(format string, args ...interface{}) {
+	l.log(slog.LevelError, fmt.Sprintf(format, args...))
+	os.Exit(1)
+}
+
+func (l *EchoLogger) Fatalj(j log.JSON) {
+	l.log(slog.LevelError, jsonString(j))
+	os.Exit(1)
+}
+
+func (l *EchoLogger) Panic(i ...interface{}) {
+	message := fmt.Sprint(i...)
+	l.log(slog.LevelError, message)
+	panic(message)
+}
+
+func (l *EchoLogger) Panicf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	l.log(slog.LevelError, message)
+	panic(message)
+}
+
+func (l *EchoLogger) Panicj(j log.JSON) {
+	message := jsonString(j)
+	l.log(slog.LevelError, message)
+	panic(message)
+}
+
+func (l *EchoLogger) log(level slog.Level, message string) {
+	if l.prefix != "" {
+		message = l.prefix + ": " + message
+	}
+	l.logger.Log(context.Background(), level, message)
+}
+
+func jsonString(j log.JSON) string {
+	b, _ := json.Marshal(j)
+	return string(b)
+}
@@ -0,0 +1,159 @@
+// Package logging sets up the structured logger every package writes to.
+// Packages log with log/slog, or with the standard log package, whose
+// output goes through the same handler at the info level.
+package logging
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// FormatConsole writes key=value lines, easy to read in a terminal
+	FormatConsole = "console"
+	// FormatJSON writes one JSON object per line, for log collectors
+	FormatJSON = "json"
+)
+
+// ServerLog is the name of the server log in the log directory
+const ServerLog = "server.log"
+
+// Config describes where logs go and how they look
+type Config struct {
+	Level    slog.Level
+	Format   string
+	Dir      string // server.log is written here too; empty logs to the console only
+	Rotation RotationConfig
+}
+
+// ParseLevel parses debug, info, warn (or warning) and error
+func ParseLevel(s string) (slog.Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return slog.LevelDebug, nil
+	case "", "info":
+		return slog.LevelInfo, nil
+	case "warn", "warning":
+		return slog.LevelWarn, nil
+	case "error":
+		return slog.LevelError, nil
+	}
+	return slog.LevelInfo, fmt.Errorf("unknown log level %q", s)
+}
+
+// ValidFormat reports whether format is console or json
+func ValidFormat(format string) bool {
+	return format == FormatConsole || format == FormatJSON
+}
+
+// Settings is the configuration ConfigFromViper reads, a viper or the
+// running configuration
+type Settings interface {
+	GetString(key string) string
+	GetInt(key string) int
+	GetInt64(key string) int64
+	GetDuration(key string) time.Duration
+}
+
+// ConfigFromViper reads logging.level, logging.format and
+// logging.rotation.*. Logs go to logging.directory, or to dir when it is
+// not set. Invalid values fall back to the defaults; the config linter
+// reports them.
+func ConfigFromViper(v Settings, dir string) Config {
+	level, _ := ParseLevel(v.GetString("logging.level"))
+	format := strings.ToLower(v.GetString("logging.format"))
+	if !ValidFormat(format) {
+		format = FormatConsole
+	}
+	if configured := v.GetString("logging.directory"); configured != "" {
+		dir = configured
+	}
+	return Config{
+		Level:  level,
+		Format: format,
+		Dir:    dir,
+		Rotation: RotationConfig{
+			MaxSize:    v.GetInt64("logging.rotation.max_size") * 1024 * 1024,
+			Interval:   v.GetDuration("logging.rotation.interval"),
+			MaxBackups: v.GetInt("logging.rotation.max_backups"),
+			MaxAge:     v.GetDuration("logging.rotation.max_age"),
+		},
+	}
+}
+
+// level is the level of the logger Setup installs, changed by SetLevel
+var level = new(slog.LevelVar)
+
+// New creates a logger writing to console and, with a directory, to a
+// rotating server.log. The returned closer closes server.log. When the
+// log file can't be opened the logger still writes to the console and the
+// error says why.
+func New(cfg Config, console io.Writer) (*slog.Logger, io.Closer, error) {
+	return newLogger(cfg, console, cfg.Level)
+}
+
+func newLogger(cfg Config, console io.Writer, leveler slog.Leveler) (*slog.Logger, io.Closer, error) {
+	out := console
+	var closer io.Closer = nopCloser{}
+	var fileErr error
+	if cfg.Dir != "" {
+		file, err := openLogFile(filepath.Join(cfg.Dir, ServerLog), cfg.Rotation)
+		if err != nil {
+			fileErr = err
+		} else {
+			out = io.MultiWriter(console, file)
+			closer = file
+		}
+	}
+
+	opts := &slog.HandlerOptions{Level: leveler}
+	var handler slog.Handler
+	if cfg.Format == FormatJSON {
+		handler = slog.NewJSONHandler(out, opts)
+	} else {
+		handler = slog.NewTextHandler(out, opts)
+	}
+	return slog.New(handler), closer, fileErr
+}
+
+// Setup creates the logger with New and makes it the default for slog and
+// the standard log package
+func Setup(cfg Config) (io.Closer, error) {
+	level.Set(cfg.Level)
+	logger, closer, err := newLogger(cfg, os.Stdout, level)
+	slog.SetDefault(logger)
+	// The standard log package now logs at info, so at warn or error its
+	// messages are dropped; warnings and errors go through slog instead
+	slog.SetLogLoggerLevel(slog.LevelInfo)
+	// slog adds its own timestamp
+	log.SetFlags(0)
+	return closer, err
+}
+
+// SetLevel changes the level of the logger Setup installed, without
+// reopening its files
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+// Level returns the level of the logger Setup installed
+func Level() slog.Level {
+	return level.Level()
+}
+
+type nopCloser struct{}
+
+func (nopCloser) Close() error { return nil }
+
+func openLogFile(path string, rotation RotationConfig) (*RotatingFile, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return OpenRotatingFile(path, rotation)
+}
@@ -0,0 +1,134 @@
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseLevel(t *testing.T) {
+	for input, want := range map[string]slog.Level{
+		"":        slog.LevelInfo,
+		"debug":   slog.LevelDebug,
+		"INFO":    slog.LevelInfo,
+		"warning": slog.LevelWarn,
+		"error":   slog.LevelError,
+	} {
+		level, err := ParseLevel(input)
+		require.NoError(t, err, input)
+		assert.Equal(t, want, level, input)
+	}
+	_, err := ParseLevel("verbose")
+	assert.Error(t, err)
+}
+
+func TestConfigFromViper(t *testing.T) {
+	v := viper.New()
+	v.Set("logging.level", "warn")
+	v.Set("logging.format", "JSON")
+	v.Set("logging.rotation.max_size", 10)
+	v.Set("logging.rotation.interval", "24h")
+	v.Set("logging.rotation.max_backups", 3)
+
+	cfg := ConfigFromViper(v, "/var/log/casgists")
+	assert.Equal(t, slog.LevelWarn, cfg.Level)
+	assert.Equal(t, FormatJSON, cfg.Format)
+	assert.Equal(t, "/var/log/casgists", cfg.Dir)
+	assert.EqualValues(t, 10*1024*1024, cfg.Rotation.MaxSize)
+	assert.Equal(t, 24*time.Hour, cfg.Rotation.Interval)
+	assert.Equal(t, 3, cfg.Rotation.MaxBackups)
+
+	v.Set("logging.format", "xml")
+	v.Set("logging.directory", "/srv/logs")
+	cfg = ConfigFromViper(v, "/var/log/casgists")
+	assert.Equal(t, FormatConsole, cfg.Format)
+	assert.Equal(t, "/srv/logs", cfg.Dir)
+}
+
+func TestNew(t *testing.T) {
+	dir := t.TempDir()
+	var console bytes.Buffer
+	logger, closer, err := New(Config{Level: slog.LevelInfo, Format: FormatJSON, Dir: dir}, &console)
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	logger.Info("started", "port", 8080)
+	require.NoError(t, closer.Close())
+
+	var entry map[string]interface{}
+	require.NoError(t, json.Unmarshal(console.Bytes(), &entry))
+	assert.Equal(t, "started", entry["msg"])
+	assert.EqualValues(t, 8080, entry["port"])
+
+	file, err := os.ReadFile(filepath.Join(dir, ServerLog))
+	require.NoError(t, err)
+	assert.Equal(t, console.String(), string(file))
+}
+
+func TestSetLevel(t *testing.T) {
+	defer SetLevel(Level())
+	SetLevel(slog.LevelInfo)
+
+	var console bytes.Buffer
+	logger, _, err := newLogger(Config{Format: FormatConsole}, &console, level)
+	require.NoError(t, err)
+
+	logger.Debug("hidden")
+	SetLevel(slog.LevelDebug)
+	logger.Debug("shown")
+	assert.NotContains(t, console.String(), "hidden")
+	assert.Contains(t, console.String(), "shown")
+}
+
+func TestRotatingFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "server.log")
+	now := time.Date(2026, 10, 17, 10, 0, 0, 0, time.UTC)
+
+	r, err := OpenRotatingFile(path, RotationConfig{MaxSize: 10, Interval: 24 * time.Hour, MaxBackups: 2})
+	require.NoError(t, err)
+	defer r.Close()
+	r.now = func() time.Time { return now }
+	r.lastWrite = now
+
+	write := func(s string) {
+		_, err := r.Write([]byte(s))
+		require.NoError(t, err)
+	}
+	backups := func() []string { return r.backups() }
+
+	write("12345")
+	write("6789")
+	assert.Empty(t, backups(), "still under the size limit")
+
+	now = now.Add(time.Second)
+	write("abc")
+	require.Len(t, backups(), 1, "rotated before growing past the size limit")
+	current, _ := os.ReadFile(path)
+	assert.Equal(t, "abc", string(current))
+	rotated, _ := os.ReadFile(backups()[0])
+	assert.Equal(t, "123456789", string(rotated))
+
+	// A new day starts a new file
+	now = now.Add(24 * time.Hour)
+	write("d")
+	require.Len(t, backups(), 2)
+
+	// Only MaxBackups rotated files are kept, the oldest go first
+	now = now.Add(time.Second)
+	require.NoError(t, r.Rotate())
+	remaining := backups()
+	require.Len(t, remaining, 2)
+	assert.True(t, strings.HasSuffix(remaining[1], now.Format(backupTimeFormat)+".log"))
+	rotated, _ = os.ReadFile(remaining[0])
+	assert.Equal(t, "abc", string(rotated))
+}
@@ -0,0 +1,153 @@
+package logging
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// backupTimeFormat stamps rotated files; it sorts in time order
+const backupTimeFormat = "20060102-150405.000"
+
+// RotationConfig says when a log file is rotated and how many of the old
+// ones are kept. Zero values turn each limit off.
+type RotationConfig struct {
+	MaxSize    int64         // Bytes; the file is rotated before it grows past this
+	Interval   time.Duration // The file is rotated when a new interval starts, e.g. daily with 24h
+	MaxBackups int           // Rotated files kept
+	MaxAge     time.Duration // Rotated files older than this are removed
+}
+
+// RotatingFile is a log file that is renamed to name-<time>.ext and
+// started afresh when it grows too large or a new interval begins. Old
+// files beyond the configured count or age are removed.
+type RotatingFile struct {
+	mu        sync.Mutex
+	path      string
+	config    RotationConfig
+	file      *os.File
+	size      int64
+	lastWrite time.Time
+	now       func() time.Time
+}
+
+// OpenRotatingFile opens path for appending
+func OpenRotatingFile(path string, config RotationConfig) (*RotatingFile, error) {
+	r := &RotatingFile{path: path, config: config, now: time.Now}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat log file: %w", err)
+	}
+	r.file = file
+	r.size = info.Size()
+	r.lastWrite = info.ModTime()
+	if r.size == 0 {
+		r.lastWrite = r.now()
+	}
+	return nil
+}
+
+// Write appends p, rotating the file first when it is due
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := r.now()
+	if r.due(now, len(p)) {
+		if err := r.rotate(now); err != nil {
+			return 0, err
+		}
+	}
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	r.lastWrite = now
+	return n, err
+}
+
+// Rotate starts a new file now
+func (r *RotatingFile) Rotate() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.rotate(r.now())
+}
+
+// Close closes the file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.file.Close()
+}
+
+// due reports whether writing n more bytes at now needs a new file. An
+// empty file is never rotated.
+func (r *RotatingFile) due(now time.Time, n int) bool {
+	if r.size == 0 {
+		return false
+	}
+	if r.config.MaxSize > 0 && r.size+int64(n) > r.config.MaxSize {
+		return true
+	}
+	return r.config.Interval > 0 && !now.Truncate(r.config.Interval).Equal(r.lastWrite.Truncate(r.config.Interval))
+}
+
+func (r *RotatingFile) rotate(now time.Time) error {
+	if err := r.file.Close(); err != nil {
+		return fmt.Errorf("failed to close log file: %w", err)
+	}
+	if err := os.Rename(r.path, r.backupName(now)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to rotate log file: %w", err)
+	}
+	if err := r.open(); err != nil {
+		return err
+	}
+	r.prune(now)
+	return nil
+}
+
+// backupName is the name the current file is rotated to
+func (r *RotatingFile) backupName(now time.Time) string {
+	ext := filepath.Ext(r.path)
+	return strings.TrimSuffix(r.path, ext) + "-" + now.UTC().Format(backupTimeFormat) + ext
+}
+
+// backups lists the rotated files, oldest first
+func (r *RotatingFile) backups() []string {
+	ext := filepath.Ext(r.path)
+	matches, _ := filepath.Glob(strings.TrimSuffix(r.path, ext) + "-*" + ext)
+	sort.Strings(matches)
+	return matches
+}
+
+// prune removes rotated files beyond MaxBackups or older than MaxAge
+func (r *RotatingFile) prune(now time.Time) {
+	backups := r.backups()
+	if r.config.MaxBackups > 0 && len(backups) > r.config.MaxBackups {
+		for _, name := range backups[:len(backups)-r.config.MaxBackups] {
+			os.Remove(name)
+		}
+		backups = backups[len(backups)-r.config.MaxBackups:]
+	}
+	if r.config.MaxAge > 0 {
+		for _, name := range backups {
+			if info, err := os.Stat(name); err == nil && now.Sub(info.ModTime()) > r.config.MaxAge {
+				os.Remove(name)
+			}
+		}
+	}
+}
@@ -0,0 +1,241 @@
+package markup
+
+import (
+	"bytes"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/parser"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/text"
+	"github.com/yuin/goldmark/util"
+)
+
+// Feature flags for math and diagram markup. When enabled, the renderer
+// emits TeX and Mermaid sources in elements the gist pages hydrate with
+// KaTeX and Mermaid; when disabled they stay plain text and code blocks.
+const (
+	ConfigKeyMath     = "features.math"     // $...$, $$...$$ and ```math blocks
+	ConfigKeyDiagrams = "features.diagrams" // ```mermaid blocks
+)
+
+// KindMath is the node kind of inline and display math
+var KindMath = ast.NewNodeKind("Math")
+
+// Math is TeX written between $ or $$ delimiters, or in a ```math block
+type Math struct {
+	ast.BaseInline
+	Display bool
+	Block   bool
+	Value   []byte
+}
+
+// Kind implements ast.Node
+func (n *Math) Kind() ast.NodeKind {
+	return KindMath
+}
+
+// Dump implements ast.Node
+func (n *Math) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+// KindDiagram is the node kind of a Mermaid diagram
+var KindDiagram = ast.NewNodeKind("Diagram")
+
+// Diagram is the source of a ```mermaid block
+type Diagram struct {
+	ast.BaseBlock
+	Value []byte
+}
+
+// Kind implements ast.Node
+func (n *Diagram) Kind() ast.NodeKind {
+	return KindDiagram
+}
+
+// Dump implements ast.Node
+func (n *Diagram) Dump(source []byte, level int) {
+	ast.DumpHelper(n, source, level, map[string]string{"Value": string(n.Value)}, nil)
+}
+
+// mathParser parses $inline$ and $$display$$ math. A single $ only opens
+// when followed by a non-space and only closes when preceded by one and
+// not followed by a digit, so prices like $5 and $10 stay text.
+type mathParser struct{}
+
+func (p *mathParser) Trigger() []byte {
+	return []byte{'$'}
+}
+
+func (p *mathParser) Parse(parent ast.Node, block text.Reader, pc parser.Context) ast.Node {
+	line, _ := block.PeekLine()
+	opener := 0
+	for opener < len(line) && line[opener] == '$' {
+		opener++
+	}
+	if opener > 2 {
+		return nil
+	}
+	if opener == 1 {
+		return parseInlineMath(block, line)
+	}
+
+	// $$ may span lines within the paragraph
+	l, pos := block.Position()
+	block.Advance(2)
+	var value bytes.Buffer
+	for {
+		line, _ := block.PeekLine()
+		if line == nil {
+			block.SetPosition(l, pos)
+			return nil
+		}
+		if end := closingDelimiter(line, []byte("$$")); end >= 0 {
+			value.Write(line[:end])
+			block.Advance(end + 2)
+			break
+		}
+		value.Write(line)
+		block.AdvanceLine()
+	}
+	tex := bytes.TrimSpace(value.Bytes())
+	if len(tex) == 0 {
+		block.SetPosition(l, pos)
+		return nil
+	}
+	return &Math{Display: true, Value: tex}
+}
+
+// parseInlineMath parses $...$ on the current line
+func parseInlineMath(block text.Reader, line []byte) ast.Node {
+	if len(line) < 3 || util.IsSpace(line[1]) {
+		return nil
+	}
+	for i := 1; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '$':
+			if util.IsSpace(line[i-1]) || i+1 < len(line) && line[i+1] >= '0' && line[i+1] <= '9' {
+				continue
+			}
+			block.Advance(i + 1)
+			return &Math{Value: append([]byte(nil), line[1:i]...)}
+		case '\n':
+			return nil
+		}
+	}
+	return nil
+}
+
+// closingDelimiter returns the index of delim in line, skipping backslash
+// escapes, or -1
+func closingDelimiter(line, delim []byte) int {
+	for i := 0; i < len(line); i++ {
+		if line[i] == '\\' {
+			i++
+			continue
+		}
+		if bytes.HasPrefix(line[i:], delim) {
+			return i
+		}
+	}
+	return -1
+}
+
+// fenceTransformer replaces ```math and ```mermaid code blocks with math
+// and diagram nodes
+type fenceTransformer struct {
+	math     bool
+	diagrams bool
+}
+
+func (t *fenceTransformer) Transform(doc *ast.Document, reader text.Reader, pc parser.Context) {
+	source := reader.Source()
+	var fences []*ast.FencedCodeBlock
+	_ = ast.Walk(doc, func(node ast.Node, entering bool) (ast.WalkStatus, error) {
+		if fence, ok := node.(*ast.FencedCodeBlock); ok && entering {
+			fences = append(fences, fence)
+		}
+		return ast.WalkContinue, nil
+	})
+
+	for _, fence := range fences {
+		var value bytes.Buffer
+		for i := 0; i < fence.Lines().Len(); i++ {
+			segment := fence.Lines().At(i)
+			value.Write(segment.Value(source))
+		}
+
+		var replacement ast.Node
+		switch string(fence.Language(source)) {
+		case "math":
+			if t.math {
+				replacement = &Math{Display: true, Block: true, Value: value.Bytes()}
+			}
+		case "mermaid":
+			if t.diagrams {
+				replacement = &Diagram{Value: value.Bytes()}
+			}
+		}
+		if replacement != nil {
+			fence.Parent().ReplaceChild(fence.Parent(), fence, replacement)
+		}
+	}
+}
+
+// markupRenderer writes math and diagram nodes as the elements the
+// client-side hydration looks for, with their sources escaped as text
+type markupRenderer struct{}
+
+func (r *markupRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(KindMath, r.renderMath)
+	reg.Register(KindDiagram, r.renderDiagram)
+}
+
+func (r *markupRenderer) renderMath(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*Math)
+	switch {
+	case n.Block:
+		_, _ = w.WriteString(`<div class="math display">`)
+		_, _ = w.Write(util.EscapeHTML(n.Value))
+		_, _ = w.WriteString("</div>\n")
+	case n.Display:
+		_, _ = w.WriteString(`<span class="math display">`)
+		_, _ = w.Write(util.EscapeHTML(n.Value))
+		_, _ = w.WriteString("</span>")
+	default:
+		_, _ = w.WriteString(`<span class="math inline">`)
+		_, _ = w.Write(util.EscapeHTML(n.Value))
+		_, _ = w.WriteString("</span>")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+func (r *markupRenderer) renderDiagram(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if entering {
+		_, _ = w.WriteString(`<pre class="mermaid">`)
+		_, _ = w.Write(util.EscapeHTML(node.(*Diagram).Value))
+		_, _ = w.WriteString("</pre>\n")
+	}
+	return ast.WalkSkipChildren, nil
+}
+
+// mathAndDiagrams is the goldmark extension for math and Mermaid markup
+type mathAndDiagrams struct {
+	math     bool
+	diagrams bool
+}
+
+// Extend implements goldmark.Extender
+func (e *mathAndDiagrams) Extend(m goldmark.Markdown) {
+	if e.math {
+		m.Parser().AddOptions(parser.WithInlineParsers(util.Prioritized(&mathParser{}, 150)))
+	}
+	m.Parser().AddOptions(parser.WithASTTransformers(util.Prioritized(&fenceTransformer{math: e.math, diagrams: e.diagrams}, 100)))
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&markupRenderer{}, 500)))
+}
@@ -0,0 +1,103 @@
+package markup
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+
+	"github.com/casapps/casgists/src/internal/syntax"
+)
+
+// tokenClasses are the classes code blocks are colored with, styled by the
+// gist pages' stylesheet
+var tokenClasses = map[syntax.TokenKind]string{
+	syntax.TokenKeyword: "hl-keyword",
+	syntax.TokenString:  "hl-string",
+	syntax.TokenComment: "hl-comment",
+	syntax.TokenNumber:  "hl-number",
+}
+
+// fenceLanguages maps the short names code fences are usually tagged with
+// to the language IDs syntax.Highlight knows
+var fenceLanguages = map[string]string{
+	"js": "javascript", "ts": "typescript", "py": "python", "rb": "ruby",
+	"rs": "rust", "sh": "shell", "bash": "shell", "zsh": "shell",
+	"yml": "yaml", "c++": "cpp", "cs": "csharp", "kt": "kotlin",
+}
+
+// tokenClass matches the classes of tokenClasses for the sanitizer
+var tokenClass = regexp.MustCompile(`^hl-(keyword|string|comment|number)$`)
+
+// codeRenderer writes fenced code blocks with a language as
+// <pre><code class="language-x">, coloring the languages syntax.Highlight
+// knows. Others keep the language class for client-side highlighting.
+type codeRenderer struct{}
+
+func (r *codeRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCode)
+}
+
+func (r *codeRenderer) renderFencedCode(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	n := node.(*ast.FencedCodeBlock)
+
+	var code bytes.Buffer
+	for i := 0; i < n.Lines().Len(); i++ {
+		segment := n.Lines().At(i)
+		code.Write(segment.Value(source))
+	}
+
+	language := strings.ToLower(string(n.Language(source)))
+	_, _ = w.WriteString("<pre><code")
+	if language != "" {
+		_, _ = w.WriteString(` class="language-`)
+		_, _ = w.Write(util.EscapeHTML([]byte(language)))
+		_, _ = w.WriteString(`"`)
+	}
+	_, _ = w.WriteString(">")
+
+	grammar := language
+	if alias, ok := fenceLanguages[language]; ok {
+		grammar = alias
+	}
+	if language == "" || !syntax.HasGrammar(grammar) {
+		_, _ = w.Write(util.EscapeHTML(code.Bytes()))
+	} else {
+		lines := strings.Split(strings.TrimSuffix(code.String(), "\n"), "\n")
+		for i, tokens := range syntax.Highlight(lines, grammar) {
+			if i > 0 {
+				_ = w.WriteByte('\n')
+			}
+			for _, token := range tokens {
+				text := util.EscapeHTML([]byte(token.Text))
+				class, colored := tokenClasses[token.Kind]
+				if !colored {
+					_, _ = w.Write(text)
+					continue
+				}
+				_, _ = w.WriteString(`<span class="` + class + `">`)
+				_, _ = w.Write(text)
+				_, _ = w.WriteString("</span>")
+			}
+		}
+		_ = w.WriteByte('\n')
+	}
+	_, _ = w.WriteString("</code></pre>\n")
+	return ast.WalkSkipChildren, nil
+}
+
+// highlighting is the goldmark extension for colored code blocks. It runs
+// after mathAndDiagrams has taken the math and mermaid fences.
+type highlighting struct{}
+
+// Extend implements goldmark.Extender
+func (e *highlighting) Extend(m goldmark.Markdown) {
+	m.Renderer().AddOptions(renderer.WithNodeRenderers(util.Prioritized(&codeRenderer{}, 500)))
+}
@@ -0,0 +1,252 @@
+package markup
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/html"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// xssCorpus holds payloads that must never survive sanitization, whatever
+// the policy allows
+var xssCorpus = []string{
+	`<script>alert(1)</script>`,
+	`<SCRIPT SRC=//evil.example/xss.js></SCRIPT>`,
+	`<img src=x onerror=alert(1)>`,
+	`<img src="javascript:alert(1)">`,
+	`<IMG SRC=JaVaScRiPt:alert('XSS')>`,
+	`<img src=x oNeRrOr=alert(1)//`,
+	`<a href="javascript:alert(1)">x</a>`,
+	`<a href="jav&#x09;ascript:alert(1)">x</a>`,
+	`<a href="&#106;&#97;&#118;&#97;&#115;&#99;&#114;&#105;&#112;&#116;&#58;alert(1)">x</a>`,
+	`<a href=" javascript:alert(1)">x</a>`,
+	`<a href="vbscript:msgbox(1)">x</a>`,
+	`<a href="data:text/html;base64,PHNjcmlwdD5hbGVydCgxKTwvc2NyaXB0Pg==">x</a>`,
+	`<svg onload=alert(1)>`,
+	`<svg><script>alert(1)</script></svg>`,
+	`<math><mi xlink:href="javascript:alert(1)">x</mi></math>`,
+	`<body onload=alert(1)>`,
+	`<iframe src="javascript:alert(1)"></iframe>`,
+	`<iframe srcdoc="<script>alert(1)</script>"></iframe>`,
+	`<iframe src="https://evil.example/"></iframe>`,
+	`<iframe src="http://www.youtube.com/embed/x"></iframe>`,
+	`<iframe src="https://www.youtube.com.evil.example/embed/x"></iframe>`,
+	`<object data="javascript:alert(1)"></object>`,
+	`<embed src="javascript:alert(1)">`,
+	`<form action="javascript:alert(1)"><input type=submit></form>`,
+	`<div style="background:url(javascript:alert(1))">x</div>`,
+	`<style>body{background:url("javascript:alert(1)")}</style>`,
+	`<link rel=stylesheet href="javascript:alert(1)">`,
+	`<meta http-equiv="refresh" content="0;url=javascript:alert(1)">`,
+	`<base href="javascript:alert(1)//">`,
+	`<details open ontoggle=alert(1)><summary>x</summary></details>`,
+	`<div onmouseover="alert(1)">x</div>`,
+	`<span class="math" onclick="alert(1)">x</span>`,
+	`<code class="language-go" onclick="alert(1)">x</code>`,
+	`<table background="javascript:alert(1)"><tr><td>x</td></tr></table>`,
+	`<video><source onerror="alert(1)"></video>`,
+	`<marquee onstart=alert(1)>x</marquee>`,
+	`"><script>alert(1)</script>`,
+	`<scr<script>ipt>alert(1)</scr</script>ipt>`,
+	`<!--<img src="--><img src=x onerror=alert(1)//">`,
+	`[x](javascript:alert(1))`,
+	`![x](javascript:alert(1))`,
+	`[x](JaVaScRiPt:alert(1))`,
+	`<javascript:alert(1)>`,
+}
+
+// forbidden are elements no sanitized output may contain
+var forbidden = map[string]bool{
+	"script": true, "style": true, "object": true, "embed": true, "form": true, "input": true,
+	"meta": true, "base": true, "link": true, "svg": true, "video": true, "source": true,
+	"marquee": true,
+}
+
+// assertSafe parses sanitized output and fails on forbidden elements,
+// event handlers, inline styles and script URLs
+func assertSafe(t *testing.T, out, context string) {
+	t.Helper()
+	doc, err := html.Parse(strings.NewReader(out))
+	require.NoError(t, err)
+
+	var walk func(n *html.Node)
+	walk = func(n *html.Node) {
+		if n.Type == html.ElementNode {
+			assert.False(t, forbidden[n.Data], "element <%s> in %q (%s)", n.Data, out, context)
+			for _, attr := range n.Attr {
+				key := strings.ToLower(attr.Key)
+				value := strings.ToLower(strings.Join(strings.Fields(attr.Val), ""))
+				assert.False(t, strings.HasPrefix(key, "on") || key == "style" || key == "srcdoc" || key == "xlink:href",
+					"attribute %s in %q (%s)", key, out, context)
+				for _, scheme := range []string{"javascript:", "vbscript:", "data:text"} {
+					assert.False(t, strings.HasPrefix(value, scheme), "%s URL in %q (%s)", scheme, out, context)
+				}
+				if n.Data == "iframe" && key == "src" {
+					assert.True(t, strings.HasPrefix(value, "https://www.youtube.com/"), "iframe %q (%s)", value, context)
+				}
+			}
+		}
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			walk(c)
+		}
+	}
+	walk(doc)
+}
+
+func permissive() Policy {
+	return Policy{IframeHosts: []string{"www.youtube.com"}, AllowDetails: true, AllowMath: true}
+}
+
+func TestXSSCorpus(t *testing.T) {
+	policies := map[string]Policy{"strict": {}, "permissive": permissive()}
+	for name, policy := range policies {
+		sanitizer := policy.Build()
+		md := NewRenderer(nil, config.New()).md
+		for _, payload := range xssCorpus {
+			var rendered strings.Builder
+			require.NoError(t, md.Convert([]byte(payload), &rendered))
+
+			assertSafe(t, sanitizer.Sanitize(payload), name+" policy, HTML "+payload)
+			assertSafe(t, sanitizer.Sanitize(rendered.String()), name+" policy, markdown "+payload)
+		}
+	}
+}
+
+func TestPolicyToggles(t *testing.T) {
+	details := `<details open><summary>More</summary>Body</details>`
+	iframe := `<iframe src="https://www.youtube.com/embed/abc" width="560" height="315" onload="x()"></iframe>`
+	math := `<span class="math inline"><math><mi>x</mi><msup><mi>y</mi><mn>2</mn></msup></math></span>`
+
+	strict := Policy{}.Build()
+	assert.NotContains(t, strict.Sanitize(details), "<details")
+	assert.NotContains(t, strict.Sanitize(iframe), "<iframe")
+	assert.NotContains(t, strict.Sanitize(math), "<math")
+	assert.NotContains(t, strict.Sanitize(math), `class=`)
+
+	allowed := permissive().Build()
+	assert.Contains(t, allowed.Sanitize(details), "<details open")
+	assert.Contains(t, allowed.Sanitize(details), "<summary>More</summary>")
+	out := allowed.Sanitize(iframe)
+	assert.Contains(t, out, `src="https://www.youtube.com/embed/abc"`)
+	assert.Contains(t, out, `sandbox=`)
+	assert.NotContains(t, out, "onload")
+	assert.Contains(t, allowed.Sanitize(math), `<span class="math inline"><math><mi>x</mi>`)
+
+	// Code language hints survive for syntax highlighting
+	assert.Contains(t, strict.Sanitize(`<pre><code class="language-go">x</code></pre>`), `class="language-go"`)
+	assert.Contains(t, strict.Sanitize(`<a href="https://example.com">x</a>`), `rel="nofollow"`)
+}
+
+func TestSanitizerUsesAdminSettings(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}))
+
+	config := config.New()
+	config.Set(ConfigKeyAllowDetails, true)
+	config.Set(ConfigKeyIframeHosts, []string{"player.vimeo.com"})
+
+	require.NoError(t, db.Create(&models.SystemConfig{Key: ConfigKeyAllowDetails, Value: "false"}).Error)
+	require.NoError(t, db.Create(&models.SystemConfig{Key: ConfigKeyIframeHosts, Value: "www.youtube.com, player.vimeo.com"}).Error)
+
+	policy := NewSanitizer(db, config).Policy()
+	assert.False(t, policy.AllowDetails)
+	assert.Equal(t, []string{"www.youtube.com", "player.vimeo.com"}, policy.IframeHosts)
+
+	assert.NoError(t, ValidateSetting(ConfigKeyIframeHosts, "www.youtube.com,player.vimeo.com"))
+	assert.Error(t, ValidateSetting(ConfigKeyIframeHosts, "https://www.youtube.com/"))
+	assert.Error(t, ValidateSetting(ConfigKeyIframeHosts, "*.example.com"))
+	assert.Error(t, ValidateSetting(ConfigKeyAllowMath, "maybe"))
+	assert.NoError(t, ValidateSetting("gist.max_files", "anything"))
+}
+
+func TestRender(t *testing.T) {
+	renderer := NewRenderer(nil, config.New())
+	out := renderer.Render("# Title\n\nSome *text* <b>bold</b> <script>alert(1)</script>\n\n```go\nfmt.Println()\n```\n")
+	assert.Contains(t, out, "<h1>Title</h1>")
+	assert.Contains(t, out, "<em>text</em> <b>bold</b>")
+	assert.Contains(t, out, `<code class="language-go">`)
+	assert.NotContains(t, out, "<script")
+	assert.Empty(t, renderer.Render("  \n"))
+
+	assert.True(t, IsReadme("README.md"))
+	assert.True(t, IsReadme("readme.markdown"))
+	assert.False(t, IsReadme("README.txt"))
+	assert.False(t, IsReadme("notes.md"))
+	assert.True(t, IsMarkdown("notes.MD"))
+	assert.False(t, IsMarkdown("main.go"))
+}
+
+func TestMathAndDiagrams(t *testing.T) {
+	cfg := config.New()
+	cfg.Set(ConfigKeyMath, true)
+	cfg.Set(ConfigKeyDiagrams, true)
+	renderer := NewRenderer(nil, cfg)
+
+	out := renderer.Render("Euler: $e^{i\\pi} + 1 = 0$ costs $5 or $10.\n\n$$\n\\sum_{n=1}^\\infty \\frac{1}{n^2}\n$$\n")
+	assert.Contains(t, out, `<span class="math inline">e^{i\pi} + 1 = 0</span>`)
+	assert.Contains(t, out, "costs $5 or $10.")
+	assert.Contains(t, out, `<span class="math display">\sum_{n=1}^\infty \frac{1}{n^2}</span>`)
+
+	out = renderer.Render("```math\na < b\n```\n\n```mermaid\ngraph TD\n  A-->B\n```\n")
+	assert.Contains(t, out, `<div class="math display">a &lt; b`)
+	assert.Contains(t, out, "<pre class=\"mermaid\">graph TD\n  A--&gt;B\n</pre>")
+
+	// Sources are text, so markup inside them can't escape the element
+	out = renderer.Render("$<img src=x onerror=alert(1)>$\n\n```mermaid\n<script>alert(1)</script>\n```\n")
+	assert.NotContains(t, out, "<img")
+	assert.NotContains(t, out, "<script")
+	assertSafe(t, out, "math and diagrams")
+
+	disabled := NewRenderer(nil, config.New())
+	out = disabled.Render("$x$\n\n```mermaid\ngraph TD\n```\n")
+	assert.Contains(t, out, "<p>$x$</p>")
+	assert.Contains(t, out, `<code class="language-mermaid">`)
+	assert.NotContains(t, disabled.Sanitizer().Sanitize(`<pre class="mermaid">x</pre>`), "class=")
+}
+
+func TestGitHubFlavoredMarkdown(t *testing.T) {
+	renderer := NewRenderer(nil, config.New())
+
+	out := renderer.Render("| Name | Count |\n|:-----|------:|\n| a    | 1     |\n")
+	assert.Contains(t, out, "<table>")
+	assert.Contains(t, out, `<th align="left">Name</th>`)
+	assert.Contains(t, out, `<td align="right">1</td>`)
+
+	out = renderer.Render("- [x] done\n- [ ] todo\n\n~~gone~~ https://example.com\n")
+	assert.Contains(t, out, `<input checked="" disabled="" type="checkbox">`)
+	assert.Contains(t, out, `<input disabled="" type="checkbox">`)
+	assert.Contains(t, out, "<del>gone</del>")
+	assert.Contains(t, out, `<a href="https://example.com" rel="nofollow">https://example.com</a>`)
+
+	// Only task list checkboxes survive
+	assert.NotContains(t, renderer.Render(`<input type="text" value="x">`), "<input")
+}
+
+func TestCodeHighlighting(t *testing.T) {
+	renderer := NewRenderer(nil, config.New())
+
+	out := renderer.Render("```go\n// add\nfunc add() int { return 1 + \"<b>\" }\n```\n")
+	assert.Contains(t, out, `<pre><code class="language-go"><span class="hl-comment">// add</span>`)
+	assert.Contains(t, out, `<span class="hl-keyword">func</span>`)
+	assert.Contains(t, out, `<span class="hl-number">1</span>`)
+	assert.Contains(t, out, `<span class="hl-string">&#34;&lt;b&gt;&#34;</span>`)
+	assertSafe(t, out, "highlighted code")
+
+	out = renderer.Render("```sh\necho hi # greet\n```\n")
+	assert.Contains(t, out, `<code class="language-sh"><span class="hl-keyword">echo</span> hi <span class="hl-comment"># greet</span>`)
+
+	// Languages without a grammar keep their class for the browser
+	out = renderer.Render("```brainfuck\n<+>\n```\n")
+	assert.Contains(t, out, "<pre><code class=\"language-brainfuck\">&lt;+&gt;\n</code></pre>")
+
+	// Token classes are the only classes spans may carry
+	assert.NotContains(t, renderer.Sanitizer().Sanitize(`<span class="hl-evil">x</span>`), "class=")
+}
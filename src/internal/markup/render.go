@@ -0,0 +1,84 @@
+// Package markup renders user supplied markdown to HTML that is safe to
+// embed in pages: gist descriptions, comments and markdown files. It
+// understands GitHub flavored markdown (tables, task lists, strikethrough
+// and autolinks) and colors fenced code blocks.
+package markup
+
+import (
+	"bytes"
+	"html"
+	"path"
+	"strings"
+
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	gmhtml "github.com/yuin/goldmark/renderer/html"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/imageproxy"
+)
+
+// Renderer converts markdown to sanitized HTML
+type Renderer struct {
+	md        goldmark.Markdown
+	sanitizer *Sanitizer
+	images    *imageproxy.Signer // nil when the image proxy is disabled
+}
+
+// NewRenderer creates a renderer using the configured sanitizer policy. db
+// may be nil, in which case only the config file is used.
+func NewRenderer(db *gorm.DB, config *config.Config) *Renderer {
+	return &Renderer{
+		// Raw HTML is passed through and then sanitized, so the policy
+		// alone decides what survives
+		md: goldmark.New(
+			goldmark.WithRendererOptions(gmhtml.WithUnsafe()),
+			goldmark.WithExtensions(
+				extension.NewTable(extension.WithTableCellAlignMethod(extension.TableCellAlignAttribute)),
+				extension.Strikethrough,
+				extension.Linkify,
+				extension.TaskList,
+				&mathAndDiagrams{
+					math:     config.GetBool(ConfigKeyMath),
+					diagrams: config.GetBool(ConfigKeyDiagrams),
+				},
+				&highlighting{},
+			),
+		),
+		sanitizer: NewSanitizer(db, config),
+		images:    imageproxy.NewSigner(config),
+	}
+}
+
+// Render converts markdown to sanitized HTML, with external images served
+// through the image proxy
+func (r *Renderer) Render(source string) string {
+	if strings.TrimSpace(source) == "" {
+		return ""
+	}
+	var buf bytes.Buffer
+	if err := r.md.Convert([]byte(source), &buf); err != nil {
+		// Fall back to the escaped source rather than failing the page
+		return r.sanitizer.Sanitize("<pre>" + html.EscapeString(source) + "</pre>")
+	}
+	return r.images.RewriteHTML(r.sanitizer.Sanitize(buf.String()))
+}
+
+// Sanitizer returns the renderer's sanitizer
+func (r *Renderer) Sanitizer() *Sanitizer {
+	return r.sanitizer
+}
+
+// IsMarkdown reports whether a gist file is markdown, which is rendered
+// alongside its source
+func IsMarkdown(filename string) bool {
+	ext := strings.ToLower(path.Ext(filename))
+	return ext == ".md" || ext == ".markdown"
+}
+
+// IsReadme reports whether a gist file is rendered as the gist's README
+func IsReadme(filename string) bool {
+	base := strings.ToLower(strings.TrimSuffix(filename, path.Ext(filename)))
+	return base == "readme" && IsMarkdown(filename)
+}
@@ -0,0 +1,268 @@
+package markup
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/microcosm-cc/bluemonday"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Sanitizer settings. Values saved through the admin settings API take
+// precedence over the config file.
+const (
+	ConfigKeyIframeHosts  = "markup.sanitizer.iframe_hosts"  // Hosts iframes may embed, comma separated
+	ConfigKeyAllowDetails = "markup.sanitizer.allow_details" // Collapsible <details> sections
+	ConfigKeyAllowMath    = "markup.sanitizer.allow_math"    // MathML and math markup for KaTeX/MathJax
+)
+
+// settingsTTL is how long a sanitizer keeps its policy before it picks up
+// changed settings
+const settingsTTL = 30 * time.Second
+
+// ErrInvalidSetting is returned when a sanitizer setting has an unusable value
+var ErrInvalidSetting = errors.New("invalid sanitizer setting")
+
+// Policy is what user supplied HTML may contain on top of basic formatting,
+// links, images, lists and tables
+type Policy struct {
+	IframeHosts  []string `json:"iframe_hosts"`
+	AllowDetails bool     `json:"allow_details"`
+	AllowMath    bool     `json:"allow_math"`
+
+	// Markup emitted by the renderer for client-side hydration, enabled
+	// by the math and diagrams feature flags
+	Math     bool `json:"math"`
+	Diagrams bool `json:"diagrams"`
+}
+
+// mathElements are the MathML elements KaTeX and MathJax produce
+var mathElements = []string{
+	"math", "semantics", "annotation", "mrow", "mi", "mn", "mo", "ms", "mtext",
+	"mspace", "msup", "msub", "msubsup", "mfrac", "msqrt", "mroot", "mover",
+	"munder", "munderover", "mtable", "mtr", "mtd", "mstyle", "mpadded", "mphantom",
+	"menclose",
+}
+
+var (
+	languageClass = regexp.MustCompile(`^language-[\w+#-]+$`)
+	mathClass     = regexp.MustCompile(`^math( (inline|display))?$`)
+	iframeSize    = regexp.MustCompile(`^[0-9]{1,4}%?$`)
+)
+
+// PolicyFromConfig returns the sanitizer policy from the config file and
+// the admin settings in overrides, keyed like the config
+func PolicyFromConfig(config *config.Config, overrides map[string]string) Policy {
+	policy := Policy{
+		IframeHosts:  config.GetStringSlice(ConfigKeyIframeHosts),
+		AllowDetails: config.GetBool(ConfigKeyAllowDetails),
+		AllowMath:    config.GetBool(ConfigKeyAllowMath),
+		Math:         config.GetBool(ConfigKeyMath),
+		Diagrams:     config.GetBool(ConfigKeyDiagrams),
+	}
+	if raw, ok := overrides[ConfigKeyIframeHosts]; ok {
+		policy.IframeHosts = splitHosts(raw)
+	}
+	if raw, ok := overrides[ConfigKeyAllowDetails]; ok {
+		policy.AllowDetails, _ = strconv.ParseBool(raw)
+	}
+	if raw, ok := overrides[ConfigKeyAllowMath]; ok {
+		policy.AllowMath, _ = strconv.ParseBool(raw)
+	}
+	return policy
+}
+
+// SettingsDefaults returns the sanitizer settings from the config file, for
+// the admin settings API
+func SettingsDefaults(config *config.Config) map[string]interface{} {
+	policy := PolicyFromConfig(config, nil)
+	return map[string]interface{}{
+		ConfigKeyIframeHosts:  strings.Join(policy.IframeHosts, ","),
+		ConfigKeyAllowDetails: policy.AllowDetails,
+		ConfigKeyAllowMath:    policy.AllowMath,
+	}
+}
+
+// ValidateSetting checks a value saved through the admin settings API.
+// Keys outside the sanitizer namespace are accepted unchanged.
+func ValidateSetting(key string, value interface{}) error {
+	raw := fmt.Sprintf("%v", value)
+	switch key {
+	case ConfigKeyAllowDetails, ConfigKeyAllowMath:
+		if _, err := strconv.ParseBool(raw); err != nil {
+			return fmt.Errorf("%w: %s must be true or false", ErrInvalidSetting, key)
+		}
+	case ConfigKeyIframeHosts:
+		for _, host := range splitHosts(raw) {
+			if !validHost(host) {
+				return fmt.Errorf("%w: %q is not a host name", ErrInvalidSetting, host)
+			}
+		}
+	}
+	return nil
+}
+
+// splitHosts parses a comma or whitespace separated host list
+func splitHosts(raw string) []string {
+	var hosts []string
+	for _, host := range strings.FieldsFunc(raw, func(r rune) bool { return r == ',' || r == ' ' || r == '\n' }) {
+		hosts = append(hosts, strings.ToLower(strings.TrimSpace(host)))
+	}
+	return hosts
+}
+
+// validHost reports whether host is a bare host name, without scheme, port
+// or path
+func validHost(host string) bool {
+	if host == "" || strings.ContainsAny(host, "/:@?#*") {
+		return false
+	}
+	if net.ParseIP(host) != nil {
+		return true
+	}
+	for _, label := range strings.Split(host, ".") {
+		if label == "" || len(label) > 63 {
+			return false
+		}
+		for _, r := range label {
+			if !(r == '-' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// Build returns the bluemonday policy for p. Scripts, styles, event
+// handlers, forms and javascript: or data: URLs are never allowed.
+func (p Policy) Build() *bluemonday.Policy {
+	policy := bluemonday.NewPolicy()
+	policy.AllowStandardAttributes()
+	policy.AllowStandardURLs()
+	policy.RequireNoFollowOnLinks(true)
+
+	policy.AllowElements("article", "aside", "figure", "figcaption", "section", "hgroup",
+		"h1", "h2", "h3", "h4", "h5", "h6", "br", "div", "hr", "p", "span", "wbr",
+		"abbr", "acronym", "cite", "dfn", "em", "mark", "s", "samp", "strong",
+		"sub", "sup", "var", "b", "i", "pre", "small", "strike", "tt", "u",
+		"rp", "rt", "ruby", "kbd")
+	policy.AllowAttrs("href").OnElements("a")
+	policy.AllowAttrs("cite").OnElements("blockquote", "q", "del", "ins")
+	policy.AllowAttrs("datetime").Matching(bluemonday.ISO8601).OnElements("time", "del", "ins")
+	policy.AllowAttrs("class").Matching(languageClass).OnElements("code")
+	policy.AllowElements("code")
+	policy.AllowAttrs("class").Matching(tokenClass).OnElements("span")
+
+	// Task list checkboxes; inputs of any other type lose their only
+	// attribute and are dropped
+	policy.AllowAttrs("type").Matching(regexp.MustCompile(`^checkbox$`)).OnElements("input")
+	policy.AllowAttrs("checked", "disabled").Matching(regexp.MustCompile(`(?i)^(|checked|disabled)$`)).OnElements("input")
+	policy.AllowLists()
+	policy.AllowTables()
+	policy.AllowImages()
+
+	if p.AllowDetails {
+		policy.AllowAttrs("open").Matching(regexp.MustCompile(`(?i)^(|open)$`)).OnElements("details")
+		policy.AllowElements("details", "summary")
+	}
+
+	if p.AllowMath || p.Math {
+		policy.AllowAttrs("class").Matching(mathClass).OnElements("span", "div")
+	}
+	if p.AllowMath {
+		policy.AllowNoAttrs().OnElements(mathElements...)
+		policy.AllowAttrs("display").Matching(regexp.MustCompile(`^(block|inline)$`)).OnElements("math")
+		policy.AllowAttrs("encoding").Matching(regexp.MustCompile(`^application/x-tex$`)).OnElements("annotation")
+		policy.AllowAttrs("mathvariant", "stretchy", "fence", "separator", "accent", "lspace", "rspace").
+			Matching(regexp.MustCompile(`^[\w.-]*$`)).OnElements(mathElements...)
+	}
+
+	if p.Diagrams {
+		policy.AllowAttrs("class").Matching(regexp.MustCompile(`^mermaid$`)).OnElements("pre")
+	}
+
+	if hosts := iframeHostPattern(p.IframeHosts); hosts != nil {
+		policy.AllowAttrs("src").Matching(hosts).OnElements("iframe")
+		policy.AllowAttrs("width", "height").Matching(iframeSize).OnElements("iframe")
+		policy.AllowAttrs("title").Matching(bluemonday.Paragraph).OnElements("iframe")
+		policy.AllowAttrs("allowfullscreen").Matching(regexp.MustCompile(`^(|true|allowfullscreen)$`)).OnElements("iframe")
+		policy.RequireSandboxOnIFrame(bluemonday.SandboxAllowScripts, bluemonday.SandboxAllowSameOrigin,
+			bluemonday.SandboxAllowPopups, bluemonday.SandboxAllowPresentation)
+	}
+	return policy
+}
+
+// iframeHostPattern matches https URLs on exactly one of hosts
+func iframeHostPattern(hosts []string) *regexp.Regexp {
+	var quoted []string
+	for _, host := range hosts {
+		if validHost(host) {
+			quoted = append(quoted, regexp.QuoteMeta(host))
+		}
+	}
+	if len(quoted) == 0 {
+		return nil
+	}
+	return regexp.MustCompile(`^https://(` + strings.Join(quoted, "|") + `)/[^\s]*$`)
+}
+
+// Sanitizer cleans user supplied HTML with the configured policy
+type Sanitizer struct {
+	db     *gorm.DB
+	config *config.Config
+
+	mu       sync.Mutex
+	policy   *bluemonday.Policy
+	loadedAt time.Time
+}
+
+// NewSanitizer creates a sanitizer. db may be nil, in which case only the
+// config file is used.
+func NewSanitizer(db *gorm.DB, config *config.Config) *Sanitizer {
+	return &Sanitizer{db: db, config: config}
+}
+
+// Sanitize returns html with everything the policy doesn't allow removed
+func (s *Sanitizer) Sanitize(html string) string {
+	return s.current().Sanitize(html)
+}
+
+// Policy returns the policy currently in effect
+func (s *Sanitizer) Policy() Policy {
+	return PolicyFromConfig(s.config, s.overrides())
+}
+
+// current returns the bluemonday policy, rebuilding it when it is older
+// than settingsTTL
+func (s *Sanitizer) current() *bluemonday.Policy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.policy == nil || time.Since(s.loadedAt) > settingsTTL {
+		s.policy = s.Policy().Build()
+		s.loadedAt = time.Now()
+	}
+	return s.policy
+}
+
+// overrides returns the sanitizer settings saved through the admin settings API
+func (s *Sanitizer) overrides() map[string]string {
+	overrides := map[string]string{}
+	if s.db == nil {
+		return overrides
+	}
+	var configs []models.SystemConfig
+	s.db.Where("key LIKE ?", "markup.sanitizer.%").Find(&configs)
+	for _, config := range configs {
+		overrides[config.Key] = config.Value
+	}
+	return overrides
+}
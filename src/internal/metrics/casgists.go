@@ -0,0 +1,191 @@
+package metrics
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Default is the registry served on /metrics
+var Default = NewRegistry()
+
+var (
+	// HTTP instruments requests served by the API and web UI
+	HTTP = NewHTTPMetrics(Default)
+
+	// DBQueries counts database statements by operation and result
+	DBQueries = Default.NewCounterVec("casgists_db_queries_total",
+		"Database statements run, by operation and result (ok or error).", "operation", "result")
+
+	// DBQueryDuration times database statements by operation
+	DBQueryDuration = Default.NewHistogramVec("casgists_db_query_duration_seconds",
+		"Time spent running database statements.", DefaultBuckets, "operation")
+
+	// DBSlowQueries counts database statements slower than
+	// database.slow_query_threshold
+	DBSlowQueries = Default.NewCounterVec("casgists_db_slow_queries_total",
+		"Database statements slower than database.slow_query_threshold.")
+
+	// WebhookDeliveries counts webhook delivery attempts by outcome:
+	// success, retry (failed, tried again later) or failed (given up)
+	WebhookDeliveries = Default.NewCounterVec("casgists_webhook_deliveries_total",
+		"Webhook delivery attempts, by outcome.", "outcome")
+)
+
+// HTTPMetrics are the request metrics filled in by the metrics middleware,
+// and the open connections counted by ConnState
+type HTTPMetrics struct {
+	Requests    *CounterVec
+	Duration    *HistogramVec
+	InFlight    *Gauge
+	Connections *Gauge
+	Recent      *Window
+}
+
+// NewHTTPMetrics registers the request metrics in r
+func NewHTTPMetrics(r *Registry) *HTTPMetrics {
+	return &HTTPMetrics{
+		Requests: r.NewCounterVec("casgists_http_requests_total",
+			"HTTP requests served, by method, route and status code.", "method", "route", "status"),
+		Duration: r.NewHistogramVec("casgists_http_request_duration_seconds",
+			"Time spent serving HTTP requests, by method and route.", DefaultBuckets, "method", "route"),
+		InFlight: r.NewGauge("casgists_http_requests_in_flight",
+			"HTTP requests being served right now."),
+		Connections: r.NewGauge("casgists_http_open_connections",
+			"Client connections open right now, idle keep-alive ones included."),
+		Recent: NewWindow(time.Minute),
+	}
+}
+
+// ConnState counts open connections; set it as the ConnState hook of
+// every http.Server serving requests
+func (m *HTTPMetrics) ConnState(_ net.Conn, state http.ConnState) {
+	switch state {
+	case http.StateNew:
+		m.Connections.Inc()
+	case http.StateClosed, http.StateHijacked:
+		m.Connections.Dec()
+	}
+}
+
+// windowSlots is how many slots a window is split into
+const windowSlots = 60
+
+// latencyBounds are the upper bounds of the buckets a window sorts
+// durations into for percentiles: from half a millisecond, each 20%
+// above the last, up to about a minute. A percentile is reported as the
+// bound of its bucket, so it is at most 20% high.
+var latencyBounds = func() []time.Duration {
+	var bounds []time.Duration
+	for b := 500 * time.Microsecond; b < time.Minute; b = b * 6 / 5 {
+		bounds = append(bounds, b)
+	}
+	return bounds
+}()
+
+// Window counts requests, their total duration and how the durations are
+// spread over a sliding period, for the request rate, average response
+// time and percentiles reported by /healthz
+type Window struct {
+	mu    sync.Mutex
+	slot  time.Duration
+	slots [windowSlots]windowSlot
+	now   func() time.Time
+}
+
+type windowSlot struct {
+	start   time.Time
+	count   int64
+	total   time.Duration
+	buckets []int64
+}
+
+// WindowSummary describes the requests in a window
+type WindowSummary struct {
+	Count   int64
+	Average time.Duration
+	P50     time.Duration
+	P95     time.Duration
+	P99     time.Duration
+}
+
+// NewWindow creates a window covering period
+func NewWindow(period time.Duration) *Window {
+	return &Window{slot: period / windowSlots, now: time.Now}
+}
+
+// Observe records a request that took d
+func (w *Window) Observe(d time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	start := w.now().Truncate(w.slot)
+	s := &w.slots[int(start.UnixNano()/int64(w.slot))%windowSlots]
+	if !s.start.Equal(start) {
+		buckets := s.buckets
+		if buckets == nil {
+			buckets = make([]int64, len(latencyBounds)+1)
+		} else {
+			clear(buckets)
+		}
+		*s = windowSlot{start: start, buckets: buckets}
+	}
+	s.count++
+	s.total += d
+	s.buckets[sort.Search(len(latencyBounds), func(i int) bool { return latencyBounds[i] >= d })]++
+}
+
+// Stats returns the number of requests in the period and their average
+// duration
+func (w *Window) Stats() (count int64, average time.Duration) {
+	summary := w.Summary()
+	return summary.Count, summary.Average
+}
+
+// Summary returns the number of requests in the period, their average
+// duration and percentiles
+func (w *Window) Summary() WindowSummary {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	oldest := w.now().Truncate(w.slot).Add(-w.slot * (windowSlots - 1))
+	var summary WindowSummary
+	var total time.Duration
+	buckets := make([]int64, len(latencyBounds)+1)
+	for _, s := range w.slots {
+		if s.start.Before(oldest) {
+			continue
+		}
+		summary.Count += s.count
+		total += s.total
+		for i, n := range s.buckets {
+			buckets[i] += n
+		}
+	}
+	if summary.Count == 0 {
+		return summary
+	}
+	summary.Average = total / time.Duration(summary.Count)
+	summary.P50 = percentile(buckets, summary.Count, 0.50)
+	summary.P95 = percentile(buckets, summary.Count, 0.95)
+	summary.P99 = percentile(buckets, summary.Count, 0.99)
+	return summary
+}
+
+// percentile returns the bucket bound below which fraction p of the count
+// durations fall. Durations past the last bound report as that bound.
+func percentile(buckets []int64, count int64, p float64) time.Duration {
+	rank := int64(math.Ceil(p * float64(count)))
+	var seen int64
+	for i, n := range buckets {
+		seen += n
+		if seen >= rank {
+			if i == len(latencyBounds) {
+				i--
+			}
+			return latencyBounds[i]
+		}
+	}
+	return latencyBounds[len(latencyBounds)-1]
+}
@@ -0,0 +1,61 @@
+package metrics
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// startKey is where the statement start time is kept between callbacks
+const startKey = "metrics:start"
+
+// GormPlugin counts and times every statement run through a gorm.DB into
+// DBQueries and DBQueryDuration
+type GormPlugin struct{}
+
+// Name implements gorm.Plugin
+func (GormPlugin) Name() string { return "casgists:metrics" }
+
+// Initialize implements gorm.Plugin
+func (GormPlugin) Initialize(db *gorm.DB) error {
+	cb := db.Callback()
+	for _, err := range []error{
+		instrument(cb.Create().Before("*"), cb.Create().After("*"), "create"),
+		instrument(cb.Query().Before("*"), cb.Query().After("*"), "query"),
+		instrument(cb.Update().Before("*"), cb.Update().After("*"), "update"),
+		instrument(cb.Delete().Before("*"), cb.Delete().After("*"), "delete"),
+		instrument(cb.Row().Before("*"), cb.Row().After("*"), "row"),
+		instrument(cb.Raw().Before("*"), cb.Raw().After("*"), "raw"),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// instrument registers the callbacks timing one kind of statement
+func instrument[C interface {
+	Register(name string, fn func(*gorm.DB)) error
+}](before, after C, operation string) error {
+	if err := before.Register("metrics:before_"+operation, func(tx *gorm.DB) {
+		tx.InstanceSet(startKey, time.Now())
+	}); err != nil {
+		return err
+	}
+	return after.Register("metrics:after_"+operation, func(tx *gorm.DB) {
+		observeQuery(tx, operation)
+	})
+}
+
+func observeQuery(tx *gorm.DB, operation string) {
+	result := "ok"
+	if tx.Error != nil && !errors.Is(tx.Error, gorm.ErrRecordNotFound) {
+		result = "error"
+	}
+	DBQueries.WithLabelValues(operation, result).Inc()
+	if start, ok := tx.InstanceGet(startKey); ok {
+		DBQueryDuration.WithLabelValues(operation).Observe(time.Since(start.(time.Time)).Seconds())
+	}
+}
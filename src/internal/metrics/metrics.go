@@ -1,392 +1,411 @@
 package metrics
 
 import (
-	"context"
-	"encoding/json"
-	"runtime"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
-	"time"
+	"sync/atomic"
+)
 
-	"gorm.io/gorm"
+// DefaultBuckets are the histogram buckets, in seconds, used for request
+// and query durations
+var DefaultBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
 
-	"github.com/casapps/casgists/src/internal/database/models"
-)
+// Registry holds metrics and writes them in the Prometheus text exposition
+// format. Metrics are written in the order they were registered.
+type Registry struct {
+	mu      sync.RWMutex
+	metrics []metric
+	names   map[string]bool
+}
 
-// Metrics represents application metrics
-type Metrics struct {
-	mu         sync.RWMutex
-	db         *gorm.DB
-	startTime  time.Time
-	counters   map[string]int64
-	gauges     map[string]float64
-	histograms map[string]*Histogram
+// metric is anything a registry can write
+type metric interface {
+	name() string
+	write(w io.Writer) error
 }
 
-// Histogram represents a histogram metric
-type Histogram struct {
-	mu     sync.RWMutex
-	values []float64
-	sum    float64
-	count  int64
-}
-
-// MetricsSnapshot represents a point-in-time view of metrics
-type MetricsSnapshot struct {
-	Timestamp      time.Time              `json:"timestamp"`
-	Uptime         string                 `json:"uptime"`
-	Version        string                 `json:"version"`
-	GoVersion      string                 `json:"go_version"`
-	Counters       map[string]int64       `json:"counters"`
-	Gauges         map[string]float64     `json:"gauges"`
-	Histograms     map[string]HistogramStats `json:"histograms"`
-	System         SystemMetrics          `json:"system"`
-	Database       DatabaseMetrics        `json:"database"`
-	Application    ApplicationMetrics     `json:"application"`
-}
-
-// HistogramStats represents histogram statistics
-type HistogramStats struct {
-	Count   int64   `json:"count"`
-	Sum     float64 `json:"sum"`
-	Average float64 `json:"average"`
-	Min     float64 `json:"min"`
-	Max     float64 `json:"max"`
-	P50     float64 `json:"p50"`
-	P95     float64 `json:"p95"`
-	P99     float64 `json:"p99"`
-}
-
-// SystemMetrics represents system-level metrics
-type SystemMetrics struct {
-	GoRoutines   int     `json:"goroutines"`
-	MemoryUsed   uint64  `json:"memory_used"`
-	MemoryTotal  uint64  `json:"memory_total"`
-	CPUCount     int     `json:"cpu_count"`
-	GCPauses     uint64  `json:"gc_pauses"`
-	HeapObjects  uint64  `json:"heap_objects"`
-	StackInUse   uint64  `json:"stack_in_use"`
-}
-
-// DatabaseMetrics represents database-related metrics
-type DatabaseMetrics struct {
-	Users         int64 `json:"users"`
-	Gists         int64 `json:"gists"`
-	Files         int64 `json:"files"`
-	Stars         int64 `json:"stars"`
-	Organizations int64 `json:"organizations"`
-	Tags          int64 `json:"tags"`
-}
-
-// ApplicationMetrics represents application-specific metrics
-type ApplicationMetrics struct {
-	PublicGists  int64 `json:"public_gists"`
-	PrivateGists int64 `json:"private_gists"`
-	ActiveUsers  int64 `json:"active_users"`
-	TotalViews   int64 `json:"total_views"`
-}
-
-// NewMetrics creates a new metrics instance
-func NewMetrics(db *gorm.DB) *Metrics {
-	return &Metrics{
-		db:         db,
-		startTime:  time.Now(),
-		counters:   make(map[string]int64),
-		gauges:     make(map[string]float64),
-		histograms: make(map[string]*Histogram),
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{names: make(map[string]bool)}
+}
+
+// register adds a metric, panicking on a duplicate name like a duplicate
+// route would
+func (r *Registry) register(m metric) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.names[m.name()] {
+		panic(fmt.Sprintf("metrics: %s registered twice", m.name()))
 	}
+	r.names[m.name()] = true
+	r.metrics = append(r.metrics, m)
 }
 
-// IncrementCounter increments a counter metric
-func (m *Metrics) IncrementCounter(name string) {
-	m.IncrementCounterBy(name, 1)
+// WritePrometheus writes every metric in the Prometheus text format
+func (r *Registry) WritePrometheus(w io.Writer) error {
+	r.mu.RLock()
+	metrics := append([]metric(nil), r.metrics...)
+	r.mu.RUnlock()
+
+	for _, m := range metrics {
+		if err := m.write(w); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// desc is the name, help text and label names of a metric
+type desc struct {
+	metricName string
+	help       string
+	labels     []string
 }
 
-// IncrementCounterBy increments a counter metric by a specific value
-func (m *Metrics) IncrementCounterBy(name string, value int64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.counters[name] += value
+func (d desc) name() string { return d.metricName }
+
+func (d desc) header(w io.Writer, kind string) error {
+	_, err := fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", d.metricName, escapeHelp(d.help), d.metricName, kind)
+	return err
 }
 
-// SetGauge sets a gauge metric value
-func (m *Metrics) SetGauge(name string, value float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	m.gauges[name] = value
+// value is a float64 that can be updated atomically
+type value struct {
+	bits atomic.Uint64
 }
 
-// RecordHistogram records a value in a histogram
-func (m *Metrics) RecordHistogram(name string, value float64) {
-	m.mu.Lock()
-	defer m.mu.Unlock()
-	
-	hist, exists := m.histograms[name]
-	if !exists {
-		hist = &Histogram{
-			values: make([]float64, 0, 1000), // Pre-allocate for performance
+func (v *value) Load() float64 { return math.Float64frombits(v.bits.Load()) }
+
+func (v *value) Store(f float64) { v.bits.Store(math.Float64bits(f)) }
+
+func (v *value) Add(delta float64) {
+	for {
+		old := v.bits.Load()
+		if v.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
 		}
-		m.histograms[name] = hist
-	}
-	
-	hist.mu.Lock()
-	defer hist.mu.Unlock()
-	
-	hist.values = append(hist.values, value)
-	hist.sum += value
-	hist.count++
-	
-	// Keep only the last 1000 values to prevent memory growth
-	if len(hist.values) > 1000 {
-		hist.values = hist.values[len(hist.values)-1000:]
 	}
 }
 
-// GetSnapshot returns a snapshot of current metrics
-func (m *Metrics) GetSnapshot(version string) MetricsSnapshot {
-	m.mu.RLock()
-	defer m.mu.RUnlock()
+// Counter only goes up
+type Counter struct {
+	v value
+}
 
-	// Copy counters
-	counters := make(map[string]int64)
-	for k, v := range m.counters {
-		counters[k] = v
-	}
+// Inc adds one
+func (c *Counter) Inc() { c.v.Add(1) }
 
-	// Copy gauges
-	gauges := make(map[string]float64)
-	for k, v := range m.gauges {
-		gauges[k] = v
+// Add adds a non-negative amount
+func (c *Counter) Add(delta float64) {
+	if delta < 0 {
+		return
 	}
+	c.v.Add(delta)
+}
 
-	// Calculate histogram stats
-	histograms := make(map[string]HistogramStats)
-	for name, hist := range m.histograms {
-		histograms[name] = hist.getStats()
-	}
+// Value returns the current count
+func (c *Counter) Value() float64 { return c.v.Load() }
 
-	return MetricsSnapshot{
-		Timestamp:   time.Now(),
-		Uptime:      time.Since(m.startTime).String(),
-		Version:     version,
-		GoVersion:   runtime.Version(),
-		Counters:    counters,
-		Gauges:      gauges,
-		Histograms:  histograms,
-		System:      m.getSystemMetrics(),
-		Database:    m.getDatabaseMetrics(),
-		Application: m.getApplicationMetrics(),
-	}
+// Gauge goes up and down
+type Gauge struct {
+	v value
 }
 
-// getStats calculates histogram statistics
-func (h *Histogram) getStats() HistogramStats {
-	h.mu.RLock()
-	defer h.mu.RUnlock()
+// Set sets the gauge
+func (g *Gauge) Set(f float64) { g.v.Store(f) }
 
-	if h.count == 0 {
-		return HistogramStats{}
-	}
+// Inc adds one
+func (g *Gauge) Inc() { g.v.Add(1) }
 
-	stats := HistogramStats{
-		Count:   h.count,
-		Sum:     h.sum,
-		Average: h.sum / float64(h.count),
-	}
+// Dec subtracts one
+func (g *Gauge) Dec() { g.v.Add(-1) }
 
-	if len(h.values) > 0 {
-		// Sort values for percentile calculation
-		sorted := make([]float64, len(h.values))
-		copy(sorted, h.values)
-		
-		// Simple insertion sort for small arrays
-		for i := 1; i < len(sorted); i++ {
-			key := sorted[i]
-			j := i - 1
-			for j >= 0 && sorted[j] > key {
-				sorted[j+1] = sorted[j]
-				j--
-			}
-			sorted[j+1] = key
-		}
+// Add adds an amount, which may be negative
+func (g *Gauge) Add(delta float64) { g.v.Add(delta) }
+
+// Value returns the current value
+func (g *Gauge) Value() float64 { return g.v.Load() }
 
-		stats.Min = sorted[0]
-		stats.Max = sorted[len(sorted)-1]
-		stats.P50 = percentile(sorted, 0.5)
-		stats.P95 = percentile(sorted, 0.95)
-		stats.P99 = percentile(sorted, 0.99)
+// Histogram counts observations in cumulative buckets
+type Histogram struct {
+	buckets []float64
+	counts  []atomic.Uint64
+	count   atomic.Uint64
+	sum     value
+}
+
+func newHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]atomic.Uint64, len(buckets))}
+}
+
+// Observe records one observation
+func (h *Histogram) Observe(f float64) {
+	if i := sort.SearchFloat64s(h.buckets, f); i < len(h.buckets) {
+		h.counts[i].Add(1)
 	}
+	h.count.Add(1)
+	h.sum.Add(f)
+}
+
+// Count returns the number of observations
+func (h *Histogram) Count() uint64 { return h.count.Load() }
+
+// Sum returns the sum of the observations
+func (h *Histogram) Sum() float64 { return h.sum.Load() }
 
-	return stats
+// vec keeps one series per combination of label values
+type vec[T any] struct {
+	desc
+	create func() *T
+	mu     sync.RWMutex
+	series map[string]*T
+	values map[string][]string
+}
+
+func newVec[T any](d desc, create func() *T) *vec[T] {
+	return &vec[T]{desc: d, create: create, series: make(map[string]*T), values: make(map[string][]string)}
 }
 
-// percentile calculates the percentile of a sorted slice
-func percentile(sorted []float64, p float64) float64 {
-	if len(sorted) == 0 {
-		return 0
+// with returns the series for the label values, creating it on first use
+func (v *vec[T]) with(values ...string) *T {
+	if len(values) != len(v.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", v.metricName, len(v.labels), len(values)))
 	}
-	
-	index := p * float64(len(sorted)-1)
-	lower := int(index)
-	upper := lower + 1
-	
-	if upper >= len(sorted) {
-		return sorted[len(sorted)-1]
+	key := strings.Join(values, "\xff")
+
+	v.mu.RLock()
+	s, ok := v.series[key]
+	v.mu.RUnlock()
+	if ok {
+		return s
 	}
-	
-	weight := index - float64(lower)
-	return sorted[lower]*(1-weight) + sorted[upper]*weight
-}
-
-// getSystemMetrics collects system-level metrics
-func (m *Metrics) getSystemMetrics() SystemMetrics {
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
-
-	return SystemMetrics{
-		GoRoutines:   runtime.NumGoroutine(),
-		MemoryUsed:   mem.Alloc,
-		MemoryTotal:  mem.TotalAlloc,
-		CPUCount:     runtime.NumCPU(),
-		GCPauses:     mem.PauseTotalNs,
-		HeapObjects:  mem.HeapObjects,
-		StackInUse:   mem.StackInuse,
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if s, ok := v.series[key]; ok {
+		return s
 	}
+	s = v.create()
+	v.series[key] = s
+	v.values[key] = append([]string(nil), values...)
+	return s
 }
 
-// getDatabaseMetrics collects database-related metrics
-func (m *Metrics) getDatabaseMetrics() DatabaseMetrics {
-	var metrics DatabaseMetrics
-
-	if m.db != nil {
-		// Count users
-		m.db.Model(&models.User{}).Count(&metrics.Users)
-		
-		// Count gists
-		m.db.Model(&models.Gist{}).Count(&metrics.Gists)
-		
-		// Count files
-		m.db.Model(&models.GistFile{}).Count(&metrics.Files)
-		
-		// Count stars
-		m.db.Model(&models.GistStar{}).Count(&metrics.Stars)
-		
-		// Count organizations
-		m.db.Model(&models.Organization{}).Count(&metrics.Organizations)
-		
-		// Count tags
-		m.db.Model(&models.Tag{}).Count(&metrics.Tags)
+// each calls fn for every series, sorted by label values so the output is
+// stable between scrapes
+func (v *vec[T]) each(fn func(values []string, s *T) error) error {
+	v.mu.RLock()
+	keys := make([]string, 0, len(v.series))
+	for key := range v.series {
+		keys = append(keys, key)
 	}
+	v.mu.RUnlock()
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		v.mu.RLock()
+		s, values := v.series[key], v.values[key]
+		v.mu.RUnlock()
+		if err := fn(values, s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
 
-	return metrics
+// CounterVec is a counter partitioned by labels
+type CounterVec struct {
+	*vec[Counter]
 }
 
-// getApplicationMetrics collects application-specific metrics
-func (m *Metrics) getApplicationMetrics() ApplicationMetrics {
-	var metrics ApplicationMetrics
+// NewCounterVec registers a counter with the given label names
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	c := &CounterVec{newVec(desc{name, help, labels}, func() *Counter { return &Counter{} })}
+	r.register(c)
+	return c
+}
 
-	if m.db != nil {
-		// Count public/private gists
-		m.db.Model(&models.Gist{}).Where("visibility = ?", models.VisibilityPublic).Count(&metrics.PublicGists)
-		m.db.Model(&models.Gist{}).Where("visibility = ?", models.VisibilityPrivate).Count(&metrics.PrivateGists)
-		
-		// Count active users (logged in within last 30 days)
-		thirtyDaysAgo := time.Now().AddDate(0, 0, -30)
-		m.db.Model(&models.User{}).Where("last_login_at > ?", thirtyDaysAgo).Count(&metrics.ActiveUsers)
-		
-		// Count total views
-		m.db.Model(&models.GistView{}).Count(&metrics.TotalViews)
+// WithLabelValues returns the counter for the label values
+func (c *CounterVec) WithLabelValues(values ...string) *Counter { return c.with(values...) }
+
+func (c *CounterVec) write(w io.Writer) error {
+	if err := c.header(w, "counter"); err != nil {
+		return err
 	}
+	return c.each(func(values []string, s *Counter) error {
+		return writeSample(w, c.metricName, c.labels, values, s.Value())
+	})
+}
 
-	return metrics
+// GaugeVec is a gauge partitioned by labels
+type GaugeVec struct {
+	*vec[Gauge]
 }
 
-// ToJSON converts metrics snapshot to JSON
-func (m *MetricsSnapshot) ToJSON() ([]byte, error) {
-	return json.MarshalIndent(m, "", "  ")
+// NewGaugeVec registers a gauge with the given label names. Without label
+// names it has a single series, WithLabelValues().
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	g := &GaugeVec{newVec(desc{name, help, labels}, func() *Gauge { return &Gauge{} })}
+	r.register(g)
+	return g
 }
 
-// MetricsCollector collects metrics periodically
-type MetricsCollector struct {
-	metrics *Metrics
-	ticker  *time.Ticker
-	done    chan bool
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) *Gauge {
+	return r.NewGaugeVec(name, help).WithLabelValues()
 }
 
-// NewMetricsCollector creates a new metrics collector
-func NewMetricsCollector(metrics *Metrics, interval time.Duration) *MetricsCollector {
-	return &MetricsCollector{
-		metrics: metrics,
-		ticker:  time.NewTicker(interval),
-		done:    make(chan bool),
+// WithLabelValues returns the gauge for the label values
+func (g *GaugeVec) WithLabelValues(values ...string) *Gauge { return g.with(values...) }
+
+func (g *GaugeVec) write(w io.Writer) error {
+	if err := g.header(w, "gauge"); err != nil {
+		return err
 	}
+	return g.each(func(values []string, s *Gauge) error {
+		return writeSample(w, g.metricName, g.labels, values, s.Value())
+	})
+}
+
+// HistogramVec is a histogram partitioned by labels
+type HistogramVec struct {
+	*vec[Histogram]
+}
+
+// NewHistogramVec registers a histogram with the given upper bounds, which
+// must be sorted, and label names
+func (r *Registry) NewHistogramVec(name, help string, buckets []float64, labels ...string) *HistogramVec {
+	buckets = append([]float64(nil), buckets...)
+	h := &HistogramVec{newVec(desc{name, help, labels}, func() *Histogram { return newHistogram(buckets) })}
+	r.register(h)
+	return h
 }
 
-// Start begins collecting metrics
-func (mc *MetricsCollector) Start(ctx context.Context) {
-	go func() {
-		for {
-			select {
-			case <-mc.ticker.C:
-				mc.collectSystemMetrics()
-			case <-mc.done:
-				return
-			case <-ctx.Done():
-				return
+// WithLabelValues returns the histogram for the label values
+func (h *HistogramVec) WithLabelValues(values ...string) *Histogram { return h.with(values...) }
+
+func (h *HistogramVec) write(w io.Writer) error {
+	if err := h.header(w, "histogram"); err != nil {
+		return err
+	}
+	bucketLabels := append(append([]string(nil), h.labels...), "le")
+	return h.each(func(values []string, s *Histogram) error {
+		var cumulative uint64
+		for i, bound := range s.buckets {
+			cumulative += s.counts[i].Load()
+			le := append(append([]string(nil), values...), formatFloat(bound))
+			if err := writeSample(w, h.metricName+"_bucket", bucketLabels, le, float64(cumulative)); err != nil {
+				return err
 			}
 		}
-	}()
+		count := s.Count()
+		inf := append(append([]string(nil), values...), "+Inf")
+		if err := writeSample(w, h.metricName+"_bucket", bucketLabels, inf, float64(count)); err != nil {
+			return err
+		}
+		if err := writeSample(w, h.metricName+"_sum", h.labels, values, s.Sum()); err != nil {
+			return err
+		}
+		return writeSample(w, h.metricName+"_count", h.labels, values, float64(count))
+	})
 }
 
-// Stop stops collecting metrics
-func (mc *MetricsCollector) Stop() {
-	mc.ticker.Stop()
-	close(mc.done)
+// funcMetric reads its values when it is written, for numbers that are
+// already kept elsewhere (row counts, cache statistics, runtime stats)
+type funcMetric struct {
+	desc
+	kind string
+	fn   func() map[string]float64
 }
 
-// collectSystemMetrics collects system metrics periodically
-func (mc *MetricsCollector) collectSystemMetrics() {
-	var mem runtime.MemStats
-	runtime.ReadMemStats(&mem)
+// NewGaugeFunc registers a gauge read from fn on every scrape
+func (r *Registry) NewGaugeFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc{name, help, nil}, "gauge", func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
+}
 
-	mc.metrics.SetGauge("system.goroutines", float64(runtime.NumGoroutine()))
-	mc.metrics.SetGauge("system.memory.used", float64(mem.Alloc))
-	mc.metrics.SetGauge("system.memory.heap_objects", float64(mem.HeapObjects))
-	mc.metrics.SetGauge("system.gc.num", float64(mem.NumGC))
+// NewCounterFunc registers a counter read from fn on every scrape
+func (r *Registry) NewCounterFunc(name, help string, fn func() float64) {
+	r.register(&funcMetric{desc{name, help, nil}, "counter", func() map[string]float64 {
+		return map[string]float64{"": fn()}
+	}})
 }
 
-// RequestMetrics tracks HTTP request metrics
-func (m *Metrics) RequestMetrics(method, path string, statusCode int, duration time.Duration) {
-	// Increment request counter
-	m.IncrementCounter("http.requests.total")
-	m.IncrementCounter("http.requests." + method)
-	m.IncrementCounter("http.requests.status." + string(rune(statusCode/100)) + "xx")
+// NewLabeledGaugeFunc registers a gauge with one label whose series are
+// read from fn on every scrape, keyed by the label value
+func (r *Registry) NewLabeledGaugeFunc(name, help, label string, fn func() map[string]float64) {
+	r.register(&funcMetric{desc{name, help, []string{label}}, "gauge", fn})
+}
 
-	// Record response time
-	m.RecordHistogram("http.request.duration", duration.Seconds())
-	m.RecordHistogram("http.request.duration."+method, duration.Seconds())
+func (f *funcMetric) write(w io.Writer) error {
+	values := f.fn()
+	if err := f.header(w, f.kind); err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var labelValues []string
+		if len(f.labels) > 0 {
+			labelValues = []string{key}
+		}
+		if err := writeSample(w, f.metricName, f.labels, labelValues, values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
-// AuthMetrics tracks authentication metrics
-func (m *Metrics) AuthMetrics(event string, success bool) {
-	m.IncrementCounter("auth.events.total")
-	m.IncrementCounter("auth.events." + event)
-	
-	if success {
-		m.IncrementCounter("auth.events." + event + ".success")
-	} else {
-		m.IncrementCounter("auth.events." + event + ".failure")
+// writeSample writes one line: name{label="value",...} value
+func writeSample(w io.Writer, name string, labels, values []string, v float64) error {
+	var b strings.Builder
+	b.WriteString(name)
+	if len(labels) > 0 {
+		b.WriteByte('{')
+		for i, label := range labels {
+			if i > 0 {
+				b.WriteByte(',')
+			}
+			b.WriteString(label)
+			b.WriteString(`="`)
+			b.WriteString(escapeLabel(values[i]))
+			b.WriteByte('"')
+		}
+		b.WriteByte('}')
 	}
+	b.WriteByte(' ')
+	b.WriteString(formatFloat(v))
+	b.WriteByte('\n')
+	_, err := io.WriteString(w, b.String())
+	return err
 }
 
-// GistMetrics tracks gist-related metrics
-func (m *Metrics) GistMetrics(event string) {
-	m.IncrementCounter("gist.events.total")
-	m.IncrementCounter("gist.events." + event)
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+	return strconv.FormatFloat(f, 'g', -1, 64)
 }
 
-// SearchMetrics tracks search metrics
-func (m *Metrics) SearchMetrics(query string, resultCount int, duration time.Duration) {
-	m.IncrementCounter("search.queries.total")
-	m.RecordHistogram("search.duration", duration.Seconds())
-	m.RecordHistogram("search.results", float64(resultCount))
-}
\ No newline at end of file
+var (
+	labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+)
+
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
+
+func escapeHelp(s string) string { return helpEscaper.Replace(s) }
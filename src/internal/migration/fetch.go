@@ -0,0 +1,227 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrRateLimited is returned when a source API asks us to wait longer than
+// the client is willing to
+var ErrRateLimited = errors.New("rate limited by the source API")
+
+const (
+	// maxRateLimitWait is the longest the client waits for a rate limit to
+	// reset before giving up
+	maxRateLimitWait = 5 * time.Minute
+	// maxFetchRetries is how many times a throttled request is retried
+	maxFetchRetries = 3
+	// maxResponseSize caps a single API response or raw file
+	maxResponseSize = 10 << 20
+)
+
+// apiClient fetches JSON and raw files from a source API. It honors the
+// rate limit headers GitLab and Gitea send: when a response says no
+// requests remain, the next request waits for the reset, and throttled
+// responses (429, or 403 with nothing remaining) are retried after
+// Retry-After or the reset time.
+type apiClient struct {
+	client    *http.Client
+	baseURL   string
+	authorize func(req *http.Request)
+	maxWait   time.Duration
+	sleep     func(ctx context.Context, d time.Duration) error
+
+	mu         sync.Mutex
+	pauseUntil time.Time
+}
+
+func newAPIClient(client *http.Client, baseURL string, authorize func(req *http.Request)) *apiClient {
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+	return &apiClient{
+		client:    client,
+		baseURL:   strings.TrimRight(baseURL, "/"),
+		authorize: authorize,
+		maxWait:   maxRateLimitWait,
+		sleep:     sleepContext,
+	}
+}
+
+// getJSON fetches path and decodes the JSON response into v, returning the
+// response headers for pagination
+func (c *apiClient) getJSON(ctx context.Context, path string, v interface{}) (http.Header, error) {
+	body, header, err := c.get(ctx, path, "application/json")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		return nil, fmt.Errorf("failed to decode response from %s: %w", path, err)
+	}
+	return header, nil
+}
+
+// getRaw fetches path and returns the response body
+func (c *apiClient) getRaw(ctx context.Context, path string) ([]byte, error) {
+	body, _, err := c.get(ctx, path, "")
+	return body, err
+}
+
+func (c *apiClient) get(ctx context.Context, path, accept string) ([]byte, http.Header, error) {
+	for attempt := 0; ; attempt++ {
+		if err := c.waitForReset(ctx); err != nil {
+			return nil, nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+path, nil)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to create request: %w", err)
+		}
+		if accept != "" {
+			req.Header.Set("Accept", accept)
+		}
+		if c.authorize != nil {
+			c.authorize(req)
+		}
+
+		resp, err := c.client.Do(req)
+		if err != nil {
+			return nil, nil, fmt.Errorf("request to %s failed: %w", path, err)
+		}
+		body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize+1))
+		resp.Body.Close()
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read response from %s: %w", path, err)
+		}
+
+		now := time.Now()
+		remaining, reset := rateLimitState(resp.Header, now)
+		if remaining == 0 && !reset.IsZero() {
+			c.pause(reset)
+		}
+
+		if throttled(resp.StatusCode, remaining) {
+			wait := retryAfter(resp.Header, now)
+			if wait == 0 && !reset.IsZero() {
+				wait = reset.Sub(now)
+			}
+			if wait <= 0 {
+				wait = time.Second << attempt
+			}
+			if attempt >= maxFetchRetries || wait > c.maxWait {
+				return nil, nil, fmt.Errorf("%w: %s (retry in %s)", ErrRateLimited, path, wait.Round(time.Second))
+			}
+			if err := c.sleep(ctx, wait); err != nil {
+				return nil, nil, err
+			}
+			continue
+		}
+
+		if resp.StatusCode != http.StatusOK {
+			return nil, nil, fmt.Errorf("%s returned %s: %s", path, resp.Status, strings.TrimSpace(string(truncate(body, 200))))
+		}
+		if len(body) > maxResponseSize {
+			return nil, nil, fmt.Errorf("response from %s exceeds %d bytes", path, maxResponseSize)
+		}
+		return body, resp.Header, nil
+	}
+}
+
+// pause holds back requests until the rate limit resets
+func (c *apiClient) pause(until time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if until.After(c.pauseUntil) {
+		c.pauseUntil = until
+	}
+}
+
+func (c *apiClient) waitForReset(ctx context.Context) error {
+	c.mu.Lock()
+	wait := time.Until(c.pauseUntil)
+	c.mu.Unlock()
+	if wait <= 0 {
+		return nil
+	}
+	if wait > c.maxWait {
+		return fmt.Errorf("%w: limit resets in %s", ErrRateLimited, wait.Round(time.Second))
+	}
+	return c.sleep(ctx, wait)
+}
+
+// throttled reports whether a response was rejected by a rate limit.
+// GitHub-style APIs answer 403 rather than 429 once nothing remains.
+func throttled(status, remaining int) bool {
+	return status == http.StatusTooManyRequests || (status == http.StatusForbidden && remaining == 0)
+}
+
+// rateLimitState reads the remaining request count and reset time from
+// RateLimit-* (GitLab) or X-RateLimit-* (Gitea, GitHub) headers. Remaining
+// is -1 when the response has no rate limit headers.
+func rateLimitState(header http.Header, now time.Time) (int, time.Time) {
+	remaining := -1
+	var reset time.Time
+	for _, prefix := range []string{"RateLimit-", "X-RateLimit-"} {
+		if value := header.Get(prefix + "Remaining"); value != "" {
+			if n, err := strconv.Atoi(value); err == nil {
+				remaining = n
+			}
+		}
+		if value := header.Get(prefix + "Reset"); value != "" {
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				// Large values are Unix timestamps, small ones are seconds
+				// from now
+				if n > 1_000_000_000 {
+					reset = time.Unix(n, 0)
+				} else {
+					reset = now.Add(time.Duration(n) * time.Second)
+				}
+			}
+		}
+		if remaining >= 0 {
+			break
+		}
+	}
+	return remaining, reset
+}
+
+// retryAfter parses the Retry-After header, in seconds or as an HTTP date
+func retryAfter(header http.Header, now time.Time) time.Duration {
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil {
+		return at.Sub(now)
+	}
+	return 0
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+func truncate(b []byte, n int) []byte {
+	if len(b) > n {
+		return b[:n]
+	}
+	return b
+}
@@ -0,0 +1,168 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// defaultGiteaTopic marks the repositories imported from Gitea and Forgejo
+const defaultGiteaTopic = "gist"
+
+// giteaPageSize is the page size for repository searches, Gitea's default
+// maximum
+const giteaPageSize = 50
+
+// giteaImporter imports from Gitea and Forgejo. Neither has gists, so
+// snippets live in small repositories; the importer takes the account's
+// own repositories tagged with a topic, "gist" by default, and turns the
+// files at the top of each default branch into a gist.
+type giteaImporter struct {
+	source ImportSource
+	api    *apiClient
+	topic  string
+	userID int64
+}
+
+func newGiteaImporter(source ImportSource, options SourceOptions) *giteaImporter {
+	topic := options.Topic
+	if topic == "" {
+		topic = defaultGiteaTopic
+	}
+	token := options.AccessToken
+	return &giteaImporter{
+		source: source,
+		topic:  topic,
+		api: newAPIClient(options.Client, strings.TrimRight(options.BaseURL, "/")+"/api/v1", func(req *http.Request) {
+			req.Header.Set("Authorization", "token "+token)
+		}),
+	}
+}
+
+type giteaRepository struct {
+	ID            int64     `json:"id"`
+	Name          string    `json:"name"`
+	Description   string    `json:"description"`
+	Private       bool      `json:"private"`
+	Empty         bool      `json:"empty"`
+	DefaultBranch string    `json:"default_branch"`
+	HTMLURL       string    `json:"html_url"`
+	CreatedAt     time.Time `json:"created_at"`
+	Owner         struct {
+		Login string `json:"login"`
+	} `json:"owner"`
+}
+
+type giteaContent struct {
+	Name string `json:"name"`
+	Path string `json:"path"`
+	Type string `json:"type"`
+}
+
+// Source returns SourceGitea or SourceForgejo
+func (g *giteaImporter) Source() ImportSource {
+	return g.source
+}
+
+// Authenticate returns the login the token belongs to
+func (g *giteaImporter) Authenticate(ctx context.Context) (string, error) {
+	var user struct {
+		ID    int64  `json:"id"`
+		Login string `json:"login"`
+	}
+	if _, err := g.api.getJSON(ctx, "/user", &user); err != nil {
+		return "", fmt.Errorf("failed to authenticate with %s: %w", g.source, err)
+	}
+	g.userID = user.ID
+	return user.Login, nil
+}
+
+// List returns the account's repositories with the topic
+func (g *giteaImporter) List(ctx context.Context) ([]SourceItem, error) {
+	if g.userID == 0 {
+		if _, err := g.Authenticate(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	var items []SourceItem
+	for page := 1; ; page++ {
+		query := url.Values{
+			"uid":       {strconv.FormatInt(g.userID, 10)},
+			"exclusive": {"true"},
+			"topic":     {"true"},
+			"q":         {g.topic},
+			"limit":     {strconv.Itoa(giteaPageSize)},
+			"page":      {strconv.Itoa(page)},
+		}
+		var result struct {
+			Data []giteaRepository `json:"data"`
+		}
+		if _, err := g.api.getJSON(ctx, "/repos/search?"+query.Encode(), &result); err != nil {
+			return nil, fmt.Errorf("failed to list %s repositories: %w", g.source, err)
+		}
+		for i := range result.Data {
+			repo := result.Data[i]
+			items = append(items, SourceItem{
+				ID:    strconv.FormatInt(repo.ID, 10),
+				Title: repo.Name,
+				URL:   repo.HTMLURL,
+				ref:   &repo,
+			})
+		}
+		if len(result.Data) < giteaPageSize {
+			return items, nil
+		}
+	}
+}
+
+// Fetch downloads the top-level files of a repository's default branch.
+// Directories and symlinks are left out, as gists are flat.
+func (g *giteaImporter) Fetch(ctx context.Context, item SourceItem) (*SourceGist, error) {
+	repo, ok := item.ref.(*giteaRepository)
+	if !ok {
+		return nil, fmt.Errorf("repository %s was not listed by this importer", item.ID)
+	}
+	if repo.Empty {
+		return nil, fmt.Errorf("repository %s is empty", repo.Name)
+	}
+
+	repoPath := "/repos/" + url.PathEscape(repo.Owner.Login) + "/" + url.PathEscape(repo.Name)
+	ref := url.Values{"ref": {repo.DefaultBranch}}.Encode()
+
+	var contents []giteaContent
+	if _, err := g.api.getJSON(ctx, repoPath+"/contents?"+ref, &contents); err != nil {
+		return nil, err
+	}
+
+	visibility := models.VisibilityPublic
+	if repo.Private {
+		visibility = models.VisibilityPrivate
+	}
+	gist := &SourceGist{
+		SourceItem:  item,
+		Description: repo.Description,
+		Visibility:  visibility,
+		CreatedAt:   repo.CreatedAt,
+	}
+	for _, entry := range contents {
+		if entry.Type != "file" {
+			continue
+		}
+		content, err := g.api.getRaw(ctx, repoPath+"/raw/"+url.PathEscape(entry.Path)+"?"+ref)
+		if err != nil {
+			return nil, err
+		}
+		gist.Files = append(gist.Files, SourceFile{Filename: entry.Name, Content: string(content)})
+	}
+	if len(gist.Files) == 0 {
+		return nil, fmt.Errorf("repository %s has no files at its top level", repo.Name)
+	}
+	return gist, nil
+}
@@ -0,0 +1,146 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// gitLabImporter imports the snippets of a GitLab account
+type gitLabImporter struct {
+	api *apiClient
+}
+
+func newGitLabImporter(options SourceOptions) *gitLabImporter {
+	baseURL := options.BaseURL
+	if baseURL == "" {
+		baseURL = "https://gitlab.com"
+	}
+	token := options.AccessToken
+	return &gitLabImporter{
+		api: newAPIClient(options.Client, strings.TrimRight(baseURL, "/")+"/api/v4", func(req *http.Request) {
+			req.Header.Set("PRIVATE-TOKEN", token)
+		}),
+	}
+}
+
+type gitLabSnippet struct {
+	ID          int       `json:"id"`
+	Title       string    `json:"title"`
+	Description string    `json:"description"`
+	Visibility  string    `json:"visibility"`
+	FileName    string    `json:"file_name"`
+	WebURL      string    `json:"web_url"`
+	CreatedAt   time.Time `json:"created_at"`
+	Files       []struct {
+		Path   string `json:"path"`
+		RawURL string `json:"raw_url"`
+	} `json:"files"`
+}
+
+// Source returns SourceGitLab
+func (g *gitLabImporter) Source() ImportSource {
+	return SourceGitLab
+}
+
+// Authenticate returns the username the token belongs to
+func (g *gitLabImporter) Authenticate(ctx context.Context) (string, error) {
+	var user struct {
+		Username string `json:"username"`
+	}
+	if _, err := g.api.getJSON(ctx, "/user", &user); err != nil {
+		return "", fmt.Errorf("failed to authenticate with GitLab: %w", err)
+	}
+	return user.Username, nil
+}
+
+// List returns the personal snippets of the account, following
+// X-Next-Page until the last page
+func (g *gitLabImporter) List(ctx context.Context) ([]SourceItem, error) {
+	var items []SourceItem
+	for page := "1"; page != ""; {
+		var snippets []gitLabSnippet
+		header, err := g.api.getJSON(ctx, "/snippets?per_page=100&page="+page, &snippets)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list GitLab snippets: %w", err)
+		}
+		for i := range snippets {
+			snippet := snippets[i]
+			items = append(items, SourceItem{
+				ID:    strconv.Itoa(snippet.ID),
+				Title: snippet.Title,
+				URL:   snippet.WebURL,
+				ref:   &snippet,
+			})
+		}
+		page = header.Get("X-Next-Page")
+	}
+	return items, nil
+}
+
+// Fetch downloads the files of a snippet
+func (g *gitLabImporter) Fetch(ctx context.Context, item SourceItem) (*SourceGist, error) {
+	snippet, ok := item.ref.(*gitLabSnippet)
+	if !ok {
+		return nil, fmt.Errorf("snippet %s was not listed by this importer", item.ID)
+	}
+
+	gist := &SourceGist{
+		SourceItem:  item,
+		Description: snippet.Description,
+		Visibility:  gitLabVisibility(snippet.Visibility),
+		CreatedAt:   snippet.CreatedAt,
+	}
+
+	if len(snippet.Files) == 0 {
+		// Instances before multi-file snippets only have the one file
+		content, err := g.api.getRaw(ctx, fmt.Sprintf("/snippets/%d/raw", snippet.ID))
+		if err != nil {
+			return nil, err
+		}
+		gist.Files = append(gist.Files, SourceFile{Filename: snippet.FileName, Content: string(content)})
+		return gist, nil
+	}
+
+	for _, file := range snippet.Files {
+		path := fmt.Sprintf("/snippets/%d/files/%s/%s/raw",
+			snippet.ID, url.PathEscape(gitLabFileRef(file.RawURL, file.Path)), url.PathEscape(file.Path))
+		content, err := g.api.getRaw(ctx, path)
+		if err != nil {
+			return nil, err
+		}
+		gist.Files = append(gist.Files, SourceFile{Filename: file.Path, Content: string(content)})
+	}
+	return gist, nil
+}
+
+// gitLabFileRef takes the branch out of a snippet file's raw URL,
+// .../-/snippets/1/raw/<ref>/<path>, falling back to HEAD
+func gitLabFileRef(rawURL, path string) string {
+	_, rest, found := strings.Cut(rawURL, "/raw/")
+	if !found {
+		return "HEAD"
+	}
+	ref := strings.TrimSuffix(rest, "/"+url.PathEscape(path))
+	ref = strings.TrimSuffix(ref, "/"+path)
+	if ref == "" || ref == rest {
+		return "HEAD"
+	}
+	return ref
+}
+
+// gitLabVisibility maps snippet visibility onto gist visibility. Internal
+// snippets are only visible to signed-in users of the instance, so they
+// stay private rather than becoming reachable by link.
+func gitLabVisibility(visibility string) models.Visibility {
+	if visibility == "public" {
+		return models.VisibilityPublic
+	}
+	return models.VisibilityPrivate
+}
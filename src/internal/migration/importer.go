@@ -20,6 +20,8 @@ type ImportSource string
 const (
 	SourceGitHub   ImportSource = "github"
 	SourceGitLab   ImportSource = "gitlab"
+	SourceGitea    ImportSource = "gitea"
+	SourceForgejo  ImportSource = "forgejo"
 	SourceOpenGist ImportSource = "opengist"
 )
 
@@ -52,16 +54,17 @@ type ImportError struct {
 	Timestamp   time.Time
 }
 
-// Importer handles importing gists from various sources
-type Importer struct {
+// Migrator imports gists from GitHub and OpenGist in a single request.
+// GitLab, Gitea and Forgejo go through an Importer and a Job instead.
+type Migrator struct {
 	db      *gorm.DB
 	client  *http.Client
 	options ImportOptions
 }
 
-// NewImporter creates a new importer instance
-func NewImporter(db *gorm.DB, options ImportOptions) *Importer {
-	return &Importer{
+// NewMigrator creates a new migrator instance
+func NewMigrator(db *gorm.DB, options ImportOptions) *Migrator {
+	return &Migrator{
 		db:      db,
 		client:  &http.Client{Timeout: 30 * time.Second},
 		options: options,
@@ -69,12 +72,10 @@ func NewImporter(db *gorm.DB, options ImportOptions) *Importer {
 }
 
 // Import performs the import operation
-func (i *Importer) Import(ctx context.Context) (*ImportResult, error) {
+func (i *Migrator) Import(ctx context.Context) (*ImportResult, error) {
 	switch i.options.Source {
 	case SourceGitHub:
 		return i.importFromGitHub(ctx)
-	case SourceGitLab:
-		return i.importFromGitLab(ctx)
 	case SourceOpenGist:
 		return i.importFromOpenGist(ctx)
 	default:
@@ -110,7 +111,7 @@ type GitHubUser struct {
 	AvatarURL string `json:"avatar_url"`
 }
 
-func (i *Importer) importFromGitHub(ctx context.Context) (*ImportResult, error) {
+func (i *Migrator) importFromGitHub(ctx context.Context) (*ImportResult, error) {
 	result := &ImportResult{
 		Errors:      []ImportError{},
 		ImportedIDs: []uuid.UUID{},
@@ -216,7 +217,7 @@ func (i *Importer) importFromGitHub(ctx context.Context) (*ImportResult, error)
 	return result, nil
 }
 
-func (i *Importer) fetchGitHubGist(ctx context.Context, gistID string) (*GitHubGist, error) {
+func (i *Migrator) fetchGitHubGist(ctx context.Context, gistID string) (*GitHubGist, error) {
 	// Determine API endpoint
 	apiURL := fmt.Sprintf("https://api.github.com/gists/%s", gistID)
 	if i.options.BaseURL != "" {
@@ -256,7 +257,7 @@ func (i *Importer) fetchGitHubGist(ctx context.Context, gistID string) (*GitHubG
 	return &gist, nil
 }
 
-func (i *Importer) importGitHubGist(ghGist *GitHubGist) (uuid.UUID, error) {
+func (i *Migrator) importGitHubGist(ghGist *GitHubGist) (uuid.UUID, error) {
 	// Create gist model
 	gist := &models.Gist{
 		ID:          uuid.New(),
@@ -319,7 +320,7 @@ func (i *Importer) importGitHubGist(ghGist *GitHubGist) (uuid.UUID, error) {
 	return gist.ID, nil
 }
 
-func (i *Importer) fetchFileContent(url string) (string, error) {
+func (i *Migrator) fetchFileContent(url string) (string, error) {
 	resp, err := i.client.Get(url)
 	if err != nil {
 		return "", err
@@ -334,171 +335,6 @@ func (i *Importer) fetchFileContent(url string) (string, error) {
 	return string(content), nil
 }
 
-// GitLab Import
-
-type GitLabSnippet struct {
-	ID          int                    `json:"id"`
-	Title       string                 `json:"title"`
-	Description string                 `json:"description"`
-	Visibility  string                 `json:"visibility"`
-	Files       map[string]GitLabFile  `json:"files"`
-	CreatedAt   time.Time              `json:"created_at"`
-	UpdatedAt   time.Time              `json:"updated_at"`
-	Author      GitLabUser             `json:"author"`
-	WebURL      string                 `json:"web_url"`
-}
-
-type GitLabFile struct {
-	Path    string `json:"path"`
-	Content string `json:"content"`
-}
-
-type GitLabUser struct {
-	Username  string `json:"username"`
-	Name      string `json:"name"`
-	ID        int    `json:"id"`
-	AvatarURL string `json:"avatar_url"`
-}
-
-func (i *Importer) importFromGitLab(ctx context.Context) (*ImportResult, error) {
-	result := &ImportResult{
-		Errors:      []ImportError{},
-		ImportedIDs: []uuid.UUID{},
-	}
-
-	// Determine API endpoint
-	apiURL := "https://gitlab.com/api/v4/snippets"
-	if i.options.BaseURL != "" {
-		apiURL = strings.TrimRight(i.options.BaseURL, "/") + "/api/v4/snippets"
-	}
-
-	page := 1
-	perPage := 100
-	if i.options.Limit > 0 && i.options.Limit < perPage {
-		perPage = i.options.Limit
-	}
-
-	for {
-		// Build request URL
-		url := fmt.Sprintf("%s?page=%d&per_page=%d", apiURL, page, perPage)
-		
-		// Create request
-		req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
-		if err != nil {
-			return result, fmt.Errorf("failed to create request: %w", err)
-		}
-
-		// Add authentication
-		if i.options.AccessToken != "" {
-			req.Header.Set("PRIVATE-TOKEN", i.options.AccessToken)
-		}
-
-		// Execute request
-		resp, err := i.client.Do(req)
-		if err != nil {
-			return result, fmt.Errorf("failed to fetch snippets: %w", err)
-		}
-		defer resp.Body.Close()
-
-		if resp.StatusCode != http.StatusOK {
-			body, _ := io.ReadAll(resp.Body)
-			return result, fmt.Errorf("GitLab API error: %s - %s", resp.Status, string(body))
-		}
-
-		// Parse response
-		var snippets []GitLabSnippet
-		if err := json.NewDecoder(resp.Body).Decode(&snippets); err != nil {
-			return result, fmt.Errorf("failed to decode response: %w", err)
-		}
-
-		// Import each snippet
-		for _, snippet := range snippets {
-			result.TotalGists++
-
-			if i.options.DryRun {
-				continue
-			}
-
-			// Import the snippet
-			gistID, err := i.importGitLabSnippet(&snippet)
-			if err != nil {
-				result.FailedGists++
-				result.Errors = append(result.Errors, ImportError{
-					GistID:    fmt.Sprintf("%d", snippet.ID),
-					Title:     snippet.Title,
-					Error:     err.Error(),
-					Timestamp: time.Now(),
-				})
-				continue
-			}
-
-			result.ImportedGists++
-			result.ImportedIDs = append(result.ImportedIDs, gistID)
-
-			// Check limit
-			if i.options.Limit > 0 && result.TotalGists >= i.options.Limit {
-				return result, nil
-			}
-		}
-
-		// Check if there are more pages
-		if len(snippets) < perPage {
-			break
-		}
-
-		page++
-	}
-
-	return result, nil
-}
-
-func (i *Importer) importGitLabSnippet(snippet *GitLabSnippet) (uuid.UUID, error) {
-	// Create gist model
-	gist := &models.Gist{
-		ID:          uuid.New(),
-		UserID:      i.options.UserID,
-		Title:       snippet.Title,
-		Description: snippet.Description,
-		IsPublic:    snippet.Visibility == "public",
-		CreatedAt:   snippet.CreatedAt,
-		UpdatedAt:   snippet.UpdatedAt,
-	}
-
-	// Start transaction
-	tx := i.db.Begin()
-	defer tx.Rollback()
-
-	// Create gist
-	if err := tx.Create(gist).Error; err != nil {
-		return uuid.Nil, fmt.Errorf("failed to create gist: %w", err)
-	}
-
-	// Create files
-	for filename, file := range snippet.Files {
-		gistFile := &models.GistFile{
-			ID:       uuid.New(),
-			GistID:   gist.ID,
-			Filename: filename,
-			Content:  file.Content,
-			Size:     int64(len(file.Content)),
-		}
-
-		// Detect language from filename
-		gistFile.Language = detectLanguageFromFilename(filename)
-
-		if err := tx.Create(gistFile).Error; err != nil {
-			return uuid.Nil, fmt.Errorf("failed to create file: %w", err)
-		}
-	}
-
-	// Commit transaction
-	if err := tx.Commit().Error; err != nil {
-		return uuid.Nil, fmt.Errorf("failed to commit transaction: %w", err)
-	}
-
-	return gist.ID, nil
-}
-
 // OpenGist Import
 
 type OpenGistData struct {
@@ -522,7 +358,7 @@ type OpenGistUser struct {
 	Email    string `json:"email"`
 }
 
-func (i *Importer) importFromOpenGist(ctx context.Context) (*ImportResult, error) {
+func (i *Migrator) importFromOpenGist(ctx context.Context) (*ImportResult, error) {
 	result := &ImportResult{
 		Errors:      []ImportError{},
 		ImportedIDs: []uuid.UUID{},
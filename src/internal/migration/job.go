@@ -0,0 +1,340 @@
+package migration
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// RepoInitializer creates the Git repository of an imported gist
+type RepoInitializer interface {
+	InitializeGistRepo(gist *models.Gist, files []models.GistFile, author *models.User) error
+}
+
+// JobOptions configures an import job
+type JobOptions struct {
+	UserID uuid.UUID
+	// SourceURL is the instance imported from, recorded on the job
+	SourceURL string
+	// Limit caps the number of items read from the source; 0 reads all
+	Limit int
+	// Repos creates the gists' repositories; nil skips them
+	Repos RepoInitializer
+	// Quota checks each gist against the user's quota tier; nil skips it
+	Quota *services.QuotaService
+}
+
+// Job imports the gists of one account from an import source. Start
+// checks the access token and records the job; Run reads the items and
+// records the outcome of each one, so a single bad snippet doesn't stop
+// the import.
+type Job struct {
+	db       *gorm.DB
+	importer Importer
+	options  JobOptions
+	user     models.User
+	record   *models.ImportJob
+}
+
+// NewJob creates an import job
+func NewJob(db *gorm.DB, importer Importer, options JobOptions) *Job {
+	return &Job{db: db, importer: importer, options: options}
+}
+
+// ID returns the ID of the job record, set by Start
+func (j *Job) ID() uuid.UUID {
+	if j.record == nil {
+		return uuid.Nil
+	}
+	return j.record.ID
+}
+
+// Start authenticates with the source and records the job as processing
+func (j *Job) Start(ctx context.Context) error {
+	if err := j.db.First(&j.user, "id = ?", j.options.UserID).Error; err != nil {
+		return fmt.Errorf("failed to load user: %w", err)
+	}
+
+	account, err := j.importer.Authenticate(ctx)
+	if err != nil {
+		return err
+	}
+
+	settings, _ := json.Marshal(map[string]interface{}{"limit": j.options.Limit})
+	j.record = &models.ImportJob{
+		Platform:       string(j.importer.Source()),
+		Status:         string(models.ImportStatusProcessing),
+		SourceURL:      j.options.SourceURL,
+		SourceUsername: account,
+		Settings:       string(settings),
+		StartedAt:      time.Now(),
+		CreatedBy:      j.user.ID,
+	}
+	if err := j.db.Create(j.record).Error; err != nil {
+		return fmt.Errorf("failed to create import job: %w", err)
+	}
+	return nil
+}
+
+// Run imports every listed item and completes the job. Errors on single
+// items are recorded on the item; Run only returns an error when the
+// listing fails or the source stops answering.
+func (j *Job) Run(ctx context.Context) error {
+	if j.record == nil {
+		return errors.New("import job was not started")
+	}
+
+	items, err := j.importer.List(ctx)
+	if err != nil {
+		return j.finish(models.ImportStatusFailed, err)
+	}
+	if j.options.Limit > 0 && len(items) > j.options.Limit {
+		items = items[:j.options.Limit]
+	}
+	j.record.ItemsTotal = len(items)
+	j.db.Model(j.record).Update("items_total", j.record.ItemsTotal)
+
+	for _, item := range items {
+		record, err := j.importItem(ctx, item)
+		if err != nil {
+			record.Status = models.ImportStatusFailed
+			record.ErrorMessage = err.Error()
+		}
+		processed := time.Now()
+		record.ProcessedAt = &processed
+		if err := j.db.Create(record).Error; err != nil {
+			slog.Error("Failed to record import item", "item", item.ID, "job", j.record.ID, "error", err)
+		}
+
+		switch record.Status {
+		case models.ImportStatusCompleted:
+			j.record.ItemsImported++
+		case models.ImportStatusFailed:
+			j.record.ErrorCount++
+		}
+		j.db.Model(j.record).Updates(map[string]interface{}{
+			"items_imported": j.record.ItemsImported,
+			"error_count":    j.record.ErrorCount,
+		})
+
+		// Once the source stops answering, the remaining items would only
+		// fail the same way
+		if ctx.Err() != nil {
+			return j.finish(models.ImportStatusCancelled, ctx.Err())
+		}
+		if errors.Is(err, ErrRateLimited) {
+			return j.finish(models.ImportStatusFailed, err)
+		}
+	}
+	return j.finish(models.ImportStatusCompleted, nil)
+}
+
+// importItem imports one item, returning its record for the report
+func (j *Job) importItem(ctx context.Context, item SourceItem) (*models.ImportItem, error) {
+	sourceData, _ := json.Marshal(map[string]string{"title": item.Title})
+	record := &models.ImportItem{
+		ImportJobID: j.record.ID,
+		SourceID:    item.ID,
+		SourceURL:   item.URL,
+		SourceData:  string(sourceData),
+	}
+	importID := fmt.Sprintf("%s:%s", j.importer.Source(), item.ID)
+
+	// Running an import again only picks up what's new
+	var existing models.Gist
+	err := j.db.Where("user_id = ? AND import_id = ? AND import_url = ?", j.user.ID, importID, item.URL).First(&existing).Error
+	if err == nil {
+		record.Status = models.ImportStatusSkipped
+		record.GistID = &existing.ID
+		return record, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return record, fmt.Errorf("failed to check for an earlier import: %w", err)
+	}
+
+	source, err := j.importer.Fetch(ctx, item)
+	if err != nil {
+		return record, err
+	}
+
+	gist := &models.Gist{
+		ID:          uuid.New(),
+		UserID:      &j.user.ID,
+		Title:       source.Title,
+		Description: source.Description,
+		Visibility:  source.Visibility,
+		ImportID:    importID,
+		ImportURL:   item.URL,
+		// UpdatedAt is left to the save, so incremental search indexes
+		// pick the gist up
+		CreatedAt: source.CreatedAt,
+	}
+	gist.GitRepoPath = gist.ID.String()
+
+	quotaFiles := make([]services.QuotaFile, 0, len(source.Files))
+	for i, file := range source.Files {
+		filename := strings.TrimSpace(file.Filename)
+		if filename == "" {
+			filename = fmt.Sprintf("file%d.txt", i+1)
+		}
+		gist.Files = append(gist.Files, models.GistFile{
+			ID:       uuid.New(),
+			Filename: filename,
+			Content:  file.Content,
+			Language: detectLanguageFromFilename(filename),
+			Size:     int64(len(file.Content)),
+			Lines:    countLines(file.Content),
+		})
+		quotaFiles = append(quotaFiles, services.QuotaFile{Filename: filename, Size: int64(len(file.Content))})
+	}
+	if gist.Title == "" && len(gist.Files) > 0 {
+		gist.Title = gist.Files[0].Filename
+	}
+
+	if j.options.Quota != nil {
+		if err := j.options.Quota.CheckUserFiles(j.user.ID, quotaFiles); err != nil {
+			return record, err
+		}
+	}
+
+	if err := j.db.Create(gist).Error; err != nil {
+		return record, fmt.Errorf("failed to save gist: %w", err)
+	}
+	if j.options.Repos != nil {
+		if err := j.options.Repos.InitializeGistRepo(gist, gist.Files, &j.user); err != nil {
+			// The files are saved, as when creating a gist
+			slog.Error("Failed to initialize git repo for imported gist", "gist", gist.ID, "error", err)
+		}
+	}
+
+	record.Status = models.ImportStatusCompleted
+	record.GistID = &gist.ID
+	return record, nil
+}
+
+// finish records the job's final status and the error that ended it
+func (j *Job) finish(status models.ImportStatus, cause error) error {
+	completed := time.Now()
+	j.record.Status = string(status)
+	j.record.CompletedAt = &completed
+	if cause != nil {
+		j.record.Result = cause.Error()
+	}
+	if err := j.db.Model(j.record).Updates(map[string]interface{}{
+		"status":       j.record.Status,
+		"completed_at": completed,
+		"result":       j.record.Result,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to update import job: %w", err)
+	}
+	return cause
+}
+
+// Report is the outcome of an import job with an entry for every item
+type Report struct {
+	ID          uuid.UUID    `json:"id"`
+	Source      string       `json:"source"`
+	SourceURL   string       `json:"source_url,omitempty"`
+	Account     string       `json:"account"`
+	Status      string       `json:"status"`
+	Error       string       `json:"error,omitempty"`
+	Total       int          `json:"total"`
+	Imported    int          `json:"imported"`
+	Skipped     int          `json:"skipped"`
+	Failed      int          `json:"failed"`
+	StartedAt   time.Time    `json:"started_at"`
+	CompletedAt *time.Time   `json:"completed_at,omitempty"`
+	Items       []ItemReport `json:"items"`
+}
+
+// ItemReport is the outcome of importing one item
+type ItemReport struct {
+	SourceID string     `json:"source_id"`
+	Title    string     `json:"title"`
+	URL      string     `json:"url,omitempty"`
+	Status   string     `json:"status"`
+	GistID   *uuid.UUID `json:"gist_id,omitempty"`
+	Error    string     `json:"error,omitempty"`
+}
+
+// LoadReport returns the report of a job started by the user
+func LoadReport(db *gorm.DB, jobID, userID uuid.UUID) (*Report, error) {
+	var job models.ImportJob
+	if err := db.Where("id = ? AND created_by = ?", jobID, userID).First(&job).Error; err != nil {
+		return nil, err
+	}
+	var items []models.ImportItem
+	if err := db.Where("import_job_id = ?", job.ID).Order("processed_at ASC").Find(&items).Error; err != nil {
+		return nil, err
+	}
+	return newReport(&job, items), nil
+}
+
+// ListReports returns the user's import jobs, newest first, without items
+func ListReports(db *gorm.DB, userID uuid.UUID) ([]*Report, error) {
+	var jobs []models.ImportJob
+	if err := db.Where("created_by = ?", userID).Order("started_at DESC").Find(&jobs).Error; err != nil {
+		return nil, err
+	}
+	reports := make([]*Report, len(jobs))
+	for i := range jobs {
+		reports[i] = newReport(&jobs[i], nil)
+	}
+	return reports, nil
+}
+
+func newReport(job *models.ImportJob, items []models.ImportItem) *Report {
+	report := &Report{
+		ID:          job.ID,
+		Source:      job.Platform,
+		SourceURL:   job.SourceURL,
+		Account:     job.SourceUsername,
+		Status:      job.Status,
+		Error:       job.Result,
+		Total:       job.ItemsTotal,
+		Imported:    job.ItemsImported,
+		Failed:      job.ErrorCount,
+		StartedAt:   job.StartedAt.UTC(),
+		CompletedAt: job.CompletedAt,
+		Items:       []ItemReport{},
+	}
+	if report.CompletedAt != nil {
+		completed := report.CompletedAt.UTC()
+		report.CompletedAt = &completed
+	}
+	for _, item := range items {
+		var data struct {
+			Title string `json:"title"`
+		}
+		json.Unmarshal([]byte(item.SourceData), &data)
+		if item.Status == models.ImportStatusSkipped {
+			report.Skipped++
+		}
+		report.Items = append(report.Items, ItemReport{
+			SourceID: item.SourceID,
+			Title:    data.Title,
+			URL:      item.SourceURL,
+			Status:   string(item.Status),
+			GistID:   item.GistID,
+			Error:    item.ErrorMessage,
+		})
+	}
+	return report
+}
+
+func countLines(content string) int {
+	if content == "" {
+		return 0
+	}
+	return strings.Count(content, "\n") + 1
+}
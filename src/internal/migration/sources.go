@@ -0,0 +1,79 @@
+package migration
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Importer is an import source: it lists the gists of an account on
+// another platform and fetches them one at a time, so a failure only
+// affects the item it happened on
+type Importer interface {
+	// Source returns the platform the importer reads from
+	Source() ImportSource
+	// Authenticate checks the access token and returns the account name
+	Authenticate(ctx context.Context) (string, error)
+	// List returns the items the account owns
+	List(ctx context.Context) ([]SourceItem, error)
+	// Fetch returns an item with its files
+	Fetch(ctx context.Context, item SourceItem) (*SourceGist, error)
+}
+
+// SourceItem identifies a gist on the source platform
+type SourceItem struct {
+	ID    string
+	Title string
+	URL   string
+
+	// ref is importer specific data carried from List to Fetch
+	ref interface{}
+}
+
+// SourceGist is a gist fetched from the source platform
+type SourceGist struct {
+	SourceItem
+	Description string
+	Visibility  models.Visibility
+	Files       []SourceFile
+	CreatedAt   time.Time
+}
+
+// SourceFile is a file of a fetched gist
+type SourceFile struct {
+	Filename string
+	Content  string
+}
+
+// SourceOptions configures an import source
+type SourceOptions struct {
+	// BaseURL is the instance URL; GitLab defaults to gitlab.com, Gitea and
+	// Forgejo require one
+	BaseURL     string
+	AccessToken string
+	// Topic selects the Gitea or Forgejo repositories to import
+	Topic string
+	// Client overrides the HTTP client
+	Client *http.Client
+}
+
+// NewSourceImporter returns the importer for a source
+func NewSourceImporter(source ImportSource, options SourceOptions) (Importer, error) {
+	if options.AccessToken == "" {
+		return nil, fmt.Errorf("an access token is required to import from %s", source)
+	}
+	switch source {
+	case SourceGitLab:
+		return newGitLabImporter(options), nil
+	case SourceGitea, SourceForgejo:
+		if options.BaseURL == "" {
+			return nil, fmt.Errorf("a base URL is required to import from %s", source)
+		}
+		return newGiteaImporter(source, options), nil
+	default:
+		return nil, fmt.Errorf("unsupported import source: %s", source)
+	}
+}
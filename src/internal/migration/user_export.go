@@ -0,0 +1,224 @@
+package migration
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// UserArchiveMetadata is the name of the file listing the gists of a user
+// archive
+const UserArchiveMetadata = "metadata.json"
+
+// ArchiveGist describes a gist in metadata.json. The fields follow
+// GitHub's gist API, so tools that read GitHub gist exports can read the
+// archive; what GitHub has no field for is under "casgists".
+type ArchiveGist struct {
+	ID          string                 `json:"id"`
+	HTMLURL     string                 `json:"html_url"`
+	Description string                 `json:"description"`
+	Public      bool                   `json:"public"`
+	Files       map[string]ArchiveFile `json:"files"`
+	Comments    int                    `json:"comments"`
+	Owner       ArchiveOwner           `json:"owner"`
+	Truncated   bool                   `json:"truncated"`
+	CreatedAt   string                 `json:"created_at"`
+	UpdatedAt   string                 `json:"updated_at"`
+	CasGists    ArchiveGistExtras      `json:"casgists"`
+}
+
+// ArchiveFile describes a file of a gist in metadata.json
+type ArchiveFile struct {
+	Filename string `json:"filename"`
+	Type     string `json:"type"`
+	Language string `json:"language"`
+	RawURL   string `json:"raw_url"`
+	Size     int64  `json:"size"`
+	// Path is where the file is stored in the archive
+	Path string `json:"path"`
+}
+
+// ArchiveOwner is the owner of a gist in metadata.json
+type ArchiveOwner struct {
+	Login string `json:"login"`
+}
+
+// ArchiveGistExtras holds the gist fields GitHub's format has no room for
+type ArchiveGistExtras struct {
+	Title      string   `json:"title"`
+	Visibility string   `json:"visibility"`
+	Tags       []string `json:"tags"`
+	Stars      int      `json:"stars"`
+	Forks      int      `json:"forks"`
+	ForkedFrom string   `json:"forked_from,omitempty"`
+}
+
+// UserArchive holds the gists of a user, loaded before the archive is
+// written so a failed query can still be reported
+type UserArchive struct {
+	db    *gorm.DB
+	user  *models.User
+	gists []models.Gist
+	links *urls.Builder
+}
+
+// LoadUserArchive loads every gist the user owns, including private ones,
+// ready to be written. Sandbox gists and deleted gists are left out.
+func LoadUserArchive(db *gorm.DB, userID uuid.UUID, links *urls.Builder) (*UserArchive, error) {
+	var user models.User
+	if err := db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, fmt.Errorf("failed to load user: %w", err)
+	}
+
+	var gists []models.Gist
+	if err := db.Preload("Files").
+		Scopes(models.SandboxScope(false)).
+		Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&gists).Error; err != nil {
+		return nil, fmt.Errorf("failed to load gists: %w", err)
+	}
+	return &UserArchive{db: db, user: &user, gists: gists, links: links}, nil
+}
+
+// Count returns the number of gists in the archive
+func (a *UserArchive) Count() int {
+	return len(a.gists)
+}
+
+// Filename suggests a name for the archive file
+func (a *UserArchive) Filename() string {
+	return fmt.Sprintf("casgists-%s-%s.zip", a.user.Username, time.Now().UTC().Format("20060102"))
+}
+
+// Write writes the gists as a zip with a directory per gist, named by the
+// gist's ID and holding its files, plus metadata.json at the root
+// describing them all
+func (a *UserArchive) Write(w io.Writer) error {
+	comments, err := a.commentCounts()
+	if err != nil {
+		return err
+	}
+
+	zw := zip.NewWriter(w)
+	metadata := make([]ArchiveGist, 0, len(a.gists))
+	for _, gist := range a.gists {
+		entry := ArchiveGist{
+			ID:          gist.ID.String(),
+			HTMLURL:     a.links.Gist(gist.ID),
+			Description: gist.Description,
+			Public:      gist.Visibility == models.VisibilityPublic,
+			Files:       make(map[string]ArchiveFile, len(gist.Files)),
+			Comments:    comments[gist.ID],
+			Owner:       ArchiveOwner{Login: a.user.Username},
+			CreatedAt:   gist.CreatedAt.UTC().Format(time.RFC3339),
+			UpdatedAt:   gist.UpdatedAt.UTC().Format(time.RFC3339),
+			CasGists: ArchiveGistExtras{
+				Title:      gist.Title,
+				Visibility: string(gist.Visibility),
+				Tags:       gist.TagList(),
+				Stars:      gist.StarCount,
+				Forks:      gist.ForkCount,
+			},
+		}
+		if entry.CasGists.Tags == nil {
+			entry.CasGists.Tags = []string{}
+		}
+		if gist.ForkedFromID != nil {
+			entry.CasGists.ForkedFrom = gist.ForkedFromID.String()
+		}
+
+		for _, file := range gist.Files {
+			name := archiveFilename(file.Filename)
+			if name == "" {
+				continue
+			}
+			filePath := gist.ID.String() + "/" + name
+			header := &zip.FileHeader{Name: filePath, Method: zip.Deflate, Modified: file.UpdatedAt}
+			fw, err := zw.CreateHeader(header)
+			if err != nil {
+				return fmt.Errorf("failed to add %s: %w", filePath, err)
+			}
+			if _, err := io.WriteString(fw, file.Content); err != nil {
+				return fmt.Errorf("failed to write %s: %w", filePath, err)
+			}
+			entry.Files[file.Filename] = ArchiveFile{
+				Filename: file.Filename,
+				Type:     archiveFileType(file.Filename),
+				Language: file.Language,
+				RawURL:   a.links.RawFile(gist.ID, file.Filename),
+				Size:     int64(len(file.Content)),
+				Path:     filePath,
+			}
+		}
+		metadata = append(metadata, entry)
+	}
+
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: UserArchiveMetadata, Method: zip.Deflate, Modified: time.Now()})
+	if err != nil {
+		return fmt.Errorf("failed to add %s: %w", UserArchiveMetadata, err)
+	}
+	encoder := json.NewEncoder(fw)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(metadata); err != nil {
+		return fmt.Errorf("failed to write %s: %w", UserArchiveMetadata, err)
+	}
+	return zw.Close()
+}
+
+// commentCounts returns the number of comments on each gist
+func (a *UserArchive) commentCounts() (map[uuid.UUID]int, error) {
+	counts := make(map[uuid.UUID]int)
+	if len(a.gists) == 0 {
+		return counts, nil
+	}
+	ids := make([]uuid.UUID, len(a.gists))
+	for i, gist := range a.gists {
+		ids[i] = gist.ID
+	}
+
+	var rows []struct {
+		GistID uuid.UUID
+		Count  int
+	}
+	if err := a.db.Model(&models.GistComment{}).
+		Select("gist_id, COUNT(*) AS count").
+		Where("gist_id IN ?", ids).
+		Group("gist_id").
+		Scan(&rows).Error; err != nil {
+		return nil, fmt.Errorf("failed to count comments: %w", err)
+	}
+	for _, row := range rows {
+		counts[row.GistID] = row.Count
+	}
+	return counts, nil
+}
+
+// archiveFilename keeps a gist filename from escaping its directory in
+// the archive
+func archiveFilename(name string) string {
+	name = path.Base(strings.ReplaceAll(name, "\\", "/"))
+	if name == "." || name == ".." || name == "/" {
+		return ""
+	}
+	return name
+}
+
+// archiveFileType returns the MIME type GitHub would report for a file
+func archiveFileType(filename string) string {
+	if mimeType := mime.TypeByExtension(path.Ext(filename)); mimeType != "" {
+		return strings.SplitN(mimeType, ";", 2)[0]
+	}
+	return "text/plain"
+}
@@ -28,6 +28,8 @@ type GDPRDeletionRequest struct {
 	UserID          uuid.UUID  `gorm:"type:char(36);not null;index" json:"user_id"`
 	Status          string     `gorm:"type:varchar(20);not null;default:'pending'" json:"status"` // pending, processing, completed, failed
 	DeletionReason  string     `gorm:"type:text" json:"deletion_reason"`
+	GistPolicy      string     `gorm:"type:varchar(20)" json:"gist_policy"`                      // archive, organization, delete
+	GistOrgID       *uuid.UUID `gorm:"column:gist_organization_id;type:char(36)" json:"gist_organization_id,omitempty"`
 	ProcessedByID   *uuid.UUID `gorm:"type:char(36)" json:"processed_by_id,omitempty"`
 	CompletedAt     *time.Time `json:"completed_at,omitempty"`
 	CreatedAt       time.Time  `json:"created_at"`
@@ -10,7 +10,7 @@ import (
 // Organization represents an organization
 type Organization struct {
 	ID            uuid.UUID      `gorm:"type:char(36);primary_key" json:"id"`
-	Name          string         `gorm:"type:varchar(255);uniqueIndex;not null" json:"name"`
+	Name          string         `gorm:"type:varchar(255);uniqueIndex:idx_organizations_name_live,where:deleted_at IS NULL;not null" json:"name"`
 	DisplayName   string         `gorm:"type:varchar(255)" json:"display_name"`
 	Description   string         `gorm:"type:text" json:"description"`
 	Email         string         `gorm:"type:varchar(255)" json:"email"`
@@ -45,7 +45,7 @@ type OrganizationUser struct {
 	ID             uuid.UUID `gorm:"type:char(36);primary_key"`
 	UserID         uuid.UUID `gorm:"type:char(36);not null;index"`
 	OrganizationID uuid.UUID `gorm:"type:char(36);not null;index"`
-	Role           string    `gorm:"type:varchar(50);not null;default:'member'"` // owner, admin, member
+	Role           string    `gorm:"type:varchar(50);not null;default:'member'"` // owner, admin, member, viewer
 	CreatedAt      time.Time
 	UpdatedAt      time.Time
 
@@ -54,6 +54,12 @@ type OrganizationUser struct {
 	Organization Organization `gorm:"foreignKey:OrganizationID"`
 }
 
+// TableName shares the organization_members table with the database
+// package's OrganizationMember, which the organization policies read
+func (OrganizationUser) TableName() string {
+	return "organization_members"
+}
+
 // BeforeCreate hook to set UUID
 func (ou *OrganizationUser) BeforeCreate(tx *gorm.DB) error {
 	if ou.ID == uuid.Nil {
@@ -10,8 +10,8 @@ import (
 // User represents a user in the system
 type User struct {
 	ID                 uuid.UUID  `gorm:"type:char(36);primary_key" json:"id"`
-	Username           string     `gorm:"type:varchar(255);uniqueIndex;not null" json:"username"`
-	Email              string     `gorm:"type:varchar(255);uniqueIndex;not null" json:"email"`
+	Username           string     `gorm:"type:varchar(255);uniqueIndex:idx_users_username_live,where:deleted_at IS NULL;not null" json:"username"`
+	Email              string     `gorm:"type:varchar(255);uniqueIndex:idx_users_email_live,where:deleted_at IS NULL;not null" json:"email"`
 	PasswordHash       string     `gorm:"type:varchar(255);not null" json:"-"`
 	DisplayName        string     `gorm:"type:varchar(255)" json:"display_name"`
 	Bio                string     `gorm:"type:text" json:"bio"`
@@ -12,12 +12,14 @@ type Webhook struct {
 	ID               uuid.UUID      `gorm:"type:char(36);primary_key" json:"id"`
 	UserID           *uuid.UUID     `gorm:"type:char(36);index" json:"user_id,omitempty"`
 	OrganizationID   *uuid.UUID     `gorm:"type:char(36);index" json:"organization_id,omitempty"`
+	GistID           *uuid.UUID     `gorm:"type:char(36);index" json:"gist_id,omitempty"` // Only receives events about this gist
 	URL              string         `gorm:"type:varchar(255);not null" json:"url"`
 	Secret           string         `gorm:"type:varchar(255)" json:"-"`
 	Events           string         `gorm:"type:text" json:"events"` // JSON array of events
 	IsActive         bool           `gorm:"default:true" json:"is_active"`
 	ContentType      string         `gorm:"type:varchar(50);default:'application/json'" json:"content_type"`
 	InsecureSSL      bool           `gorm:"default:false" json:"insecure_ssl"`
+	DigestWindow     int            `gorm:"default:0" json:"digest_window"` // Seconds to batch events into one delivery; 0 sends each event
 	LastDeliveredAt  *time.Time     `json:"last_delivered_at,omitempty"`
 	LastStatus       int            `json:"last_status,omitempty"`
 	LastError        string         `gorm:"type:text" json:"last_error,omitempty"`
@@ -0,0 +1,447 @@
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+)
+
+// ErrInvalidNewsletter is returned when a newsletter can't be saved or sent
+// as given
+var ErrInvalidNewsletter = errors.New("invalid newsletter")
+
+// Sender delivers a rendered email; *email.Mailer implements it
+type Sender interface {
+	SendEmail(message *email.EmailQueue) error
+}
+
+// Dispatcher sends scheduled newsletters. Recipients are snapshotted into
+// newsletter_deliveries when a send starts and mailed batch_size at a time
+// every batch_interval, so large lists stay under SMTP rate limits and a
+// restart resumes where it stopped.
+type Dispatcher struct {
+	db     *gorm.DB
+	config *config.Config
+	sender Sender
+	stop   chan bool
+}
+
+// NewDispatcher creates a new newsletter dispatcher
+func NewDispatcher(db *gorm.DB, config *config.Config) *Dispatcher {
+	return NewDispatcherWithSender(db, config, email.NewMailer(config))
+}
+
+// NewDispatcherWithSender creates a dispatcher that delivers through sender
+func NewDispatcherWithSender(db *gorm.DB, config *config.Config, sender Sender) *Dispatcher {
+	return &Dispatcher{
+		db:     db,
+		config: config,
+		sender: sender,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Validate normalizes a newsletter and checks that its templates render
+func Validate(cfg *config.Config, n *models.Newsletter) error {
+	n.Subject = strings.TrimSpace(n.Subject)
+	if n.Subject == "" || len(n.Subject) > 200 {
+		return fmt.Errorf("%w: subject is required and at most 200 characters", ErrInvalidNewsletter)
+	}
+	if strings.TrimSpace(n.Body) == "" {
+		return fmt.Errorf("%w: body is required", ErrInvalidNewsletter)
+	}
+	switch n.Recurrence {
+	case models.NewsletterOnce, models.NewsletterWeekly, models.NewsletterMonthly:
+	default:
+		return fmt.Errorf("%w: recurrence must be weekly, monthly or empty", ErrInvalidNewsletter)
+	}
+
+	sample := &models.User{ID: uuid.New(), Username: "example", Email: "example@example.com"}
+	_, err := Render(cfg, n, sample)
+	return err
+}
+
+// SendTest renders the newsletter for the given user and mails it right
+// away, without recording a delivery
+func (d *Dispatcher) SendTest(n *models.Newsletter, user *models.User) error {
+	message, err := d.message(n, user)
+	if err != nil {
+		return err
+	}
+	message.Subject = "[Test] " + message.Subject
+	return d.sender.SendEmail(message)
+}
+
+// Schedule queues a draft newsletter to go out at the given time; a zero
+// time sends it on the next tick
+func (d *Dispatcher) Schedule(n *models.Newsletter, at time.Time) error {
+	if !n.Editable() {
+		return fmt.Errorf("%w: a %s newsletter can't be scheduled", ErrInvalidNewsletter, n.Status)
+	}
+	if at.IsZero() {
+		at = time.Now()
+	}
+	n.Status = models.NewsletterScheduled
+	n.ScheduledAt = &at
+	return d.db.Save(n).Error
+}
+
+// Cancel stops a scheduled or in-progress newsletter. Recipients that were
+// not mailed yet are marked as skipped.
+func (d *Dispatcher) Cancel(n *models.Newsletter) error {
+	if n.Status != models.NewsletterScheduled && n.Status != models.NewsletterSending {
+		return fmt.Errorf("%w: only scheduled or sending newsletters can be cancelled", ErrInvalidNewsletter)
+	}
+	return d.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&models.NewsletterDelivery{}).
+			Where("newsletter_id = ? AND status = ?", n.ID, models.NewsletterDeliveryPending).
+			Update("status", models.NewsletterDeliverySkipped).Error; err != nil {
+			return err
+		}
+		now := time.Now()
+		n.Status = models.NewsletterCancelled
+		n.CompletedAt = &now
+		return tx.Save(n).Error
+	})
+}
+
+// Stats counts a newsletter's deliveries by status
+func (d *Dispatcher) Stats(newsletterID uuid.UUID) (map[string]int64, error) {
+	var rows []struct {
+		Status string
+		Count  int64
+	}
+	if err := d.db.Model(&models.NewsletterDelivery{}).
+		Select("status, COUNT(*) AS count").
+		Where("newsletter_id = ?", newsletterID).
+		Group("status").
+		Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	stats := map[string]int64{
+		models.NewsletterDeliveryPending: 0,
+		models.NewsletterDeliverySent:    0,
+		models.NewsletterDeliveryFailed:  0,
+		models.NewsletterDeliverySkipped: 0,
+	}
+	for _, row := range rows {
+		stats[row.Status] = row.Count
+	}
+	return stats, nil
+}
+
+// ProcessDue starts newsletters whose time has come, mails one batch of
+// recipients and completes newsletters with nobody left to mail. It returns
+// how many emails were sent.
+func (d *Dispatcher) ProcessDue(ctx context.Context) (int, error) {
+	var due []models.Newsletter
+	if err := d.db.WithContext(ctx).
+		Where("status = ? AND scheduled_at <= ?", models.NewsletterScheduled, time.Now()).
+		Order("scheduled_at").
+		Find(&due).Error; err != nil {
+		return 0, fmt.Errorf("failed to load scheduled newsletters: %w", err)
+	}
+	for i := range due {
+		if err := d.start(ctx, &due[i]); err != nil {
+			slog.Error("Failed to start newsletter", "newsletter", due[i].ID, "error", err)
+		}
+	}
+
+	sent, err := d.sendBatch(ctx)
+	if err != nil {
+		return sent, err
+	}
+
+	if err := d.db.WithContext(ctx).Model(&models.Newsletter{}).
+		Where("status = ?", models.NewsletterSending).
+		Where("NOT EXISTS (SELECT 1 FROM newsletter_deliveries WHERE newsletter_deliveries.newsletter_id = newsletters.id AND newsletter_deliveries.status = ?)", models.NewsletterDeliveryPending).
+		Updates(map[string]interface{}{
+			"status":       models.NewsletterSent,
+			"completed_at": time.Now(),
+		}).Error; err != nil {
+		return sent, fmt.Errorf("failed to complete newsletters: %w", err)
+	}
+	return sent, nil
+}
+
+// start snapshots the subscribers of a due newsletter and, for a recurring
+// one, schedules the next issue
+func (d *Dispatcher) start(ctx context.Context, n *models.Newsletter) error {
+	return d.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := time.Now()
+		claim := tx.Model(&models.Newsletter{}).
+			Where("id = ? AND status = ?", n.ID, models.NewsletterScheduled).
+			Updates(map[string]interface{}{
+				"status":     models.NewsletterSending,
+				"started_at": now,
+			})
+		if claim.Error != nil {
+			return claim.Error
+		}
+		if claim.RowsAffected == 0 {
+			// Another process started it
+			return nil
+		}
+
+		var subscribers []struct {
+			ID    uuid.UUID
+			Email string
+		}
+		if err := tx.Model(&models.User{}).
+			Select("users.id, users.email").
+			Joins("JOIN user_preferences ON user_preferences.user_id = users.id").
+			Where("user_preferences.newsletter = ? AND users.is_active = ? AND users.is_suspended = ? AND users.email <> ''", true, true, false).
+			Scan(&subscribers).Error; err != nil {
+			return err
+		}
+
+		deliveries := make([]models.NewsletterDelivery, 0, len(subscribers))
+		for _, subscriber := range subscribers {
+			deliveries = append(deliveries, models.NewsletterDelivery{
+				NewsletterID: n.ID,
+				UserID:       subscriber.ID,
+				Email:        subscriber.Email,
+				Status:       models.NewsletterDeliveryPending,
+			})
+		}
+		if len(deliveries) > 0 {
+			if err := tx.CreateInBatches(deliveries, 500).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Model(&models.Newsletter{}).Where("id = ?", n.ID).
+			Update("recipients", len(deliveries)).Error; err != nil {
+			return err
+		}
+
+		if next := nextIssue(n); next != nil {
+			if err := tx.Create(next).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// nextIssue returns the following issue of a recurring newsletter
+func nextIssue(n *models.Newsletter) *models.Newsletter {
+	if n.ScheduledAt == nil {
+		return nil
+	}
+	var at time.Time
+	switch n.Recurrence {
+	case models.NewsletterWeekly:
+		at = n.ScheduledAt.AddDate(0, 0, 7)
+	case models.NewsletterMonthly:
+		at = n.ScheduledAt.AddDate(0, 1, 0)
+	default:
+		return nil
+	}
+	// Don't replay issues missed while the server was down
+	for !at.After(time.Now()) {
+		if n.Recurrence == models.NewsletterWeekly {
+			at = at.AddDate(0, 0, 7)
+		} else {
+			at = at.AddDate(0, 1, 0)
+		}
+	}
+	return &models.Newsletter{
+		Subject:     n.Subject,
+		Body:        n.Body,
+		Status:      models.NewsletterScheduled,
+		Recurrence:  n.Recurrence,
+		ScheduledAt: &at,
+		CreatedBy:   n.CreatedBy,
+	}
+}
+
+// sendBatch mails up to batch_size pending recipients of newsletters that
+// are being sent
+func (d *Dispatcher) sendBatch(ctx context.Context) (int, error) {
+	var deliveries []models.NewsletterDelivery
+	if err := d.db.WithContext(ctx).
+		Joins("JOIN newsletters ON newsletters.id = newsletter_deliveries.newsletter_id").
+		Where("newsletters.status = ? AND newsletter_deliveries.status = ?", models.NewsletterSending, models.NewsletterDeliveryPending).
+		Order("newsletter_deliveries.attempts, newsletter_deliveries.created_at").
+		Limit(d.batchSize()).
+		Find(&deliveries).Error; err != nil {
+		return 0, fmt.Errorf("failed to load newsletter deliveries: %w", err)
+	}
+
+	newsletters := map[uuid.UUID]*models.Newsletter{}
+	sent := 0
+	for i := range deliveries {
+		if ctx.Err() != nil {
+			break
+		}
+		delivery := &deliveries[i]
+
+		// Claim the delivery; another process may have got there first
+		claim := d.db.WithContext(ctx).Model(&models.NewsletterDelivery{}).
+			Where("id = ? AND status = ? AND attempts = ?", delivery.ID, models.NewsletterDeliveryPending, delivery.Attempts).
+			Update("attempts", delivery.Attempts+1)
+		if claim.Error != nil || claim.RowsAffected == 0 {
+			continue
+		}
+		delivery.Attempts++
+
+		n, ok := newsletters[delivery.NewsletterID]
+		if !ok {
+			n = &models.Newsletter{}
+			if err := d.db.WithContext(ctx).First(n, "id = ?", delivery.NewsletterID).Error; err != nil {
+				return sent, fmt.Errorf("failed to load newsletter: %w", err)
+			}
+			newsletters[delivery.NewsletterID] = n
+		}
+
+		if d.deliver(ctx, n, delivery) {
+			sent++
+		}
+	}
+	return sent, nil
+}
+
+// deliver mails one recipient and records the outcome
+func (d *Dispatcher) deliver(ctx context.Context, n *models.Newsletter, delivery *models.NewsletterDelivery) bool {
+	var user models.User
+	if err := d.db.WithContext(ctx).First(&user, "id = ?", delivery.UserID).Error; err != nil {
+		d.record(ctx, delivery, models.NewsletterDeliverySkipped, "user no longer exists", "")
+		return false
+	}
+	if !d.subscribed(ctx, &user) {
+		d.record(ctx, delivery, models.NewsletterDeliverySkipped, "unsubscribed", "")
+		return false
+	}
+
+	message, err := d.message(n, &user)
+	if err == nil {
+		err = d.sender.SendEmail(message)
+	}
+	switch {
+	case err == nil:
+		d.record(ctx, delivery, models.NewsletterDeliverySent, "", "sent_count")
+		return true
+	case errors.Is(err, ErrInvalidNewsletter) || delivery.Attempts >= d.maxAttempts():
+		d.record(ctx, delivery, models.NewsletterDeliveryFailed, err.Error(), "failed_count")
+	default:
+		// Stays pending and is retried in a later batch
+		d.record(ctx, delivery, models.NewsletterDeliveryPending, err.Error(), "")
+	}
+	return false
+}
+
+// subscribed reports whether the user still wants the newsletter; sending
+// can take a while and users may unsubscribe in between
+func (d *Dispatcher) subscribed(ctx context.Context, user *models.User) bool {
+	var count int64
+	d.db.WithContext(ctx).Model(&models.UserPreference{}).
+		Where("user_id = ? AND newsletter = ?", user.ID, true).
+		Count(&count)
+	return count > 0 && user.IsActive && !user.IsSuspended
+}
+
+func (d *Dispatcher) record(ctx context.Context, delivery *models.NewsletterDelivery, status, message, counter string) {
+	updates := map[string]interface{}{"status": status, "error": message}
+	if status == models.NewsletterDeliverySent {
+		updates["sent_at"] = time.Now()
+	}
+	if err := d.db.WithContext(ctx).Model(&models.NewsletterDelivery{}).
+		Where("id = ?", delivery.ID).
+		Updates(updates).Error; err != nil {
+		slog.Error("Failed to record newsletter delivery", "delivery", delivery.ID, "error", err)
+		return
+	}
+	if counter == "" {
+		return
+	}
+	if err := d.db.WithContext(ctx).Model(&models.Newsletter{}).
+		Where("id = ?", delivery.NewsletterID).
+		Update(counter, gorm.Expr(counter+" + ?", 1)).Error; err != nil {
+		slog.Error("Failed to update newsletter stats", "newsletter", delivery.NewsletterID, "error", err)
+	}
+}
+
+// message renders the newsletter into an email for the user
+func (d *Dispatcher) message(n *models.Newsletter, user *models.User) (*email.EmailQueue, error) {
+	rendered, err := Render(d.config, n, user)
+	if err != nil {
+		return nil, err
+	}
+	return &email.EmailQueue{
+		Type:      email.EmailTypeNewsletter,
+		ToEmail:   user.Email,
+		ToName:    user.DisplayName,
+		FromEmail: email.FromAddress(d.config),
+		FromName:  email.FromName(d.config),
+		Subject:   rendered.Subject,
+		BodyText:  rendered.Text,
+		BodyHTML:  rendered.HTML,
+		Priority:  8,
+		Headers: map[string]string{
+			"List-Unsubscribe": "<" + rendered.UnsubscribeURL + ">",
+		},
+	}, nil
+}
+
+// Start sends newsletters until the context is cancelled or Stop is called
+func (d *Dispatcher) Start(ctx context.Context) {
+	ticker := time.NewTicker(d.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-d.stop:
+			return
+		case <-ticker.C:
+			if _, err := d.ProcessDue(ctx); err != nil {
+				slog.Error("Newsletter processing failed", "error", err)
+			}
+		}
+	}
+}
+
+// Stop stops sending newsletters
+func (d *Dispatcher) Stop() {
+	select {
+	case d.stop <- true:
+	default:
+	}
+}
+
+// interval returns the pause between batches (default: 1 minute)
+func (d *Dispatcher) interval() time.Duration {
+	interval := d.config.GetDuration("newsletter.batch_interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval
+}
+
+func (d *Dispatcher) batchSize() int {
+	size := d.config.GetInt("newsletter.batch_size")
+	if size <= 0 {
+		size = 50
+	}
+	return size
+}
+
+func (d *Dispatcher) maxAttempts() int {
+	attempts := d.config.GetInt("newsletter.max_attempts")
+	if attempts <= 0 {
+		attempts = 3
+	}
+	return attempts
+}
@@ -0,0 +1,232 @@
+package newsletter
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+)
+
+type fakeSender struct {
+	sent []*email.EmailQueue
+	err  error
+}
+
+func (f *fakeSender) SendEmail(message *email.EmailQueue) error {
+	if f.err != nil {
+		return f.err
+	}
+	f.sent = append(f.sent, message)
+	return nil
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(
+		&models.User{}, &models.UserPreference{},
+		&models.Newsletter{}, &models.NewsletterDelivery{},
+	))
+	return db
+}
+
+func testConfig() *config.Config {
+	cfg := config.New()
+	cfg.Set("app.name", "CasGists")
+	cfg.Set("server.url", "https://gists.example.com")
+	cfg.Set("security.secret_key", "test-secret")
+	return cfg
+}
+
+func createUser(t *testing.T, db *gorm.DB, username string, subscribed, active bool) *models.User {
+	user := &models.User{Username: username, Email: username + "@example.com", IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	if !active {
+		require.NoError(t, db.Model(user).Update("is_active", false).Error)
+	}
+	require.NoError(t, db.Create(&models.UserPreference{UserID: user.ID, Newsletter: subscribed}).Error)
+	return user
+}
+
+func createNewsletter(t *testing.T, db *gorm.DB, cfg *config.Config, n *models.Newsletter) {
+	require.NoError(t, Validate(cfg, n))
+	require.NoError(t, db.Create(n).Error)
+}
+
+func TestProcessDueSendsInBatches(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testConfig()
+	cfg.Set("newsletter.batch_size", 2)
+	sender := &fakeSender{}
+	dispatcher := NewDispatcherWithSender(db, cfg, sender)
+
+	createUser(t, db, "alice", true, true)
+	createUser(t, db, "bob", true, true)
+	createUser(t, db, "carol", true, true)
+	createUser(t, db, "dave", false, true)
+	createUser(t, db, "erin", true, false)
+
+	n := &models.Newsletter{Subject: "{{.AppName}} news", Body: "Hi {{.Name}},\n\nWe shipped things."}
+	createNewsletter(t, db, cfg, n)
+	require.NoError(t, dispatcher.Schedule(n, time.Time{}))
+
+	sent, err := dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 2, sent)
+
+	var current models.Newsletter
+	require.NoError(t, db.First(&current, "id = ?", n.ID).Error)
+	assert.Equal(t, models.NewsletterSending, current.Status)
+	assert.Equal(t, 3, current.Recipients)
+
+	sent, err = dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 1, sent)
+
+	require.NoError(t, db.First(&current, "id = ?", n.ID).Error)
+	assert.Equal(t, models.NewsletterSent, current.Status)
+	assert.Equal(t, 3, current.SentCount)
+	assert.NotNil(t, current.CompletedAt)
+
+	stats, err := dispatcher.Stats(n.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(3), stats[models.NewsletterDeliverySent])
+	assert.Equal(t, int64(0), stats[models.NewsletterDeliveryPending])
+
+	require.Len(t, sender.sent, 3)
+	message := sender.sent[0]
+	assert.Equal(t, "CasGists news", message.Subject)
+	assert.Contains(t, message.BodyText, "Hi alice,")
+	assert.Contains(t, message.BodyText, "https://gists.example.com/newsletter/unsubscribe?")
+	assert.Contains(t, message.Headers["List-Unsubscribe"], "/newsletter/unsubscribe?")
+	for _, m := range sender.sent {
+		assert.NotEqual(t, "dave@example.com", m.ToEmail)
+		assert.NotEqual(t, "erin@example.com", m.ToEmail)
+	}
+}
+
+func TestProcessDueRetriesThenFails(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testConfig()
+	cfg.Set("newsletter.max_attempts", 2)
+	sender := &fakeSender{err: errors.New("421 too many messages")}
+	dispatcher := NewDispatcherWithSender(db, cfg, sender)
+
+	createUser(t, db, "alice", true, true)
+	n := &models.Newsletter{Subject: "News", Body: "Body"}
+	createNewsletter(t, db, cfg, n)
+	require.NoError(t, dispatcher.Schedule(n, time.Time{}))
+
+	_, err := dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+	var delivery models.NewsletterDelivery
+	require.NoError(t, db.First(&delivery).Error)
+	assert.Equal(t, models.NewsletterDeliveryPending, delivery.Status)
+	assert.Equal(t, 1, delivery.Attempts)
+
+	_, err = dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+	require.NoError(t, db.First(&delivery).Error)
+	assert.Equal(t, models.NewsletterDeliveryFailed, delivery.Status)
+	assert.Contains(t, delivery.Error, "too many messages")
+
+	var current models.Newsletter
+	require.NoError(t, db.First(&current, "id = ?", n.ID).Error)
+	assert.Equal(t, models.NewsletterSent, current.Status)
+	assert.Equal(t, 1, current.FailedCount)
+}
+
+func TestRecurringNewsletterSchedulesNextIssue(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testConfig()
+	dispatcher := NewDispatcherWithSender(db, cfg, &fakeSender{})
+
+	createUser(t, db, "alice", true, true)
+	n := &models.Newsletter{Subject: "Weekly", Body: "Body", Recurrence: models.NewsletterWeekly}
+	createNewsletter(t, db, cfg, n)
+	at := time.Now().Add(-time.Minute)
+	require.NoError(t, dispatcher.Schedule(n, at))
+
+	_, err := dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+
+	var next models.Newsletter
+	require.NoError(t, db.First(&next, "id <> ?", n.ID).Error)
+	assert.Equal(t, models.NewsletterScheduled, next.Status)
+	assert.Equal(t, models.NewsletterWeekly, next.Recurrence)
+	require.NotNil(t, next.ScheduledAt)
+	assert.WithinDuration(t, at.AddDate(0, 0, 7), *next.ScheduledAt, time.Second)
+
+	// Not due yet, so nothing else is started
+	sent, err := dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, 0, sent)
+	require.NoError(t, db.First(&next, "id = ?", next.ID).Error)
+	assert.Equal(t, models.NewsletterScheduled, next.Status)
+}
+
+func TestCancelSkipsPendingRecipients(t *testing.T) {
+	db := setupTestDB(t)
+	cfg := testConfig()
+	cfg.Set("newsletter.batch_size", 1)
+	dispatcher := NewDispatcherWithSender(db, cfg, &fakeSender{})
+
+	createUser(t, db, "alice", true, true)
+	createUser(t, db, "bob", true, true)
+	n := &models.Newsletter{Subject: "News", Body: "Body"}
+	createNewsletter(t, db, cfg, n)
+	require.NoError(t, dispatcher.Schedule(n, time.Time{}))
+	_, err := dispatcher.ProcessDue(context.Background())
+	require.NoError(t, err)
+
+	require.NoError(t, db.First(n, "id = ?", n.ID).Error)
+	require.NoError(t, dispatcher.Cancel(n))
+
+	stats, err := dispatcher.Stats(n.ID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), stats[models.NewsletterDeliverySent])
+	assert.Equal(t, int64(1), stats[models.NewsletterDeliverySkipped])
+	assert.Equal(t, models.NewsletterCancelled, n.Status)
+	assert.ErrorIs(t, dispatcher.Schedule(n, time.Time{}), ErrInvalidNewsletter)
+}
+
+func TestValidateAndRender(t *testing.T) {
+	cfg := testConfig()
+
+	invalid := []models.Newsletter{
+		{Subject: " ", Body: "Body"},
+		{Subject: "News", Body: ""},
+		{Subject: "News", Body: "Body", Recurrence: "daily"},
+		{Subject: "{{.Nope}}", Body: "Body"},
+		{Subject: "News", Body: "{{if}}"},
+	}
+	for _, n := range invalid {
+		assert.ErrorIs(t, Validate(cfg, &n), ErrInvalidNewsletter, "%q %q", n.Subject, n.Body)
+	}
+
+	user := &models.User{ID: uuid.New(), Username: "alice", DisplayName: "Alice <3", Email: "alice@example.com"}
+	message, err := Render(cfg, &models.Newsletter{Subject: "Hello\n{{.Username}}", Body: "Hi {{.Name}}\n\nLine one\nline two"}, user)
+	require.NoError(t, err)
+	assert.Equal(t, "Hello alice", message.Subject)
+	assert.Contains(t, message.HTML, "<p>Hi Alice &lt;3</p>")
+	assert.Contains(t, message.HTML, "<p>Line one<br>\nline two</p>")
+	assert.True(t, strings.HasPrefix(message.Text, "Hi Alice <3\n\nLine one"))
+
+	token := UnsubscribeToken(cfg, user.ID)
+	assert.True(t, VerifyUnsubscribeToken(cfg, user.ID, token))
+	assert.False(t, VerifyUnsubscribeToken(cfg, uuid.New(), token))
+	assert.Contains(t, message.UnsubscribeURL, "token="+token)
+}
@@ -0,0 +1,132 @@
+package newsletter
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"html"
+	"net/url"
+	"strings"
+	textTemplate "text/template"
+
+	"github.com/google/uuid"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// TemplateData is available to newsletter subjects and bodies, e.g.
+// "Hi {{.Name}}" or "{{.AppName}} changelog"
+type TemplateData struct {
+	Name           string
+	Username       string
+	Email          string
+	AppName        string
+	ServerURL      string
+	UnsubscribeURL string
+}
+
+// Message is a newsletter rendered for one recipient
+type Message struct {
+	Subject        string
+	Text           string
+	HTML           string
+	UnsubscribeURL string
+}
+
+// templateData builds the template variables for a recipient
+func templateData(cfg *config.Config, user *models.User) TemplateData {
+	name := user.DisplayName
+	if name == "" {
+		name = user.Username
+	}
+	appName := cfg.GetString("app.name")
+	if appName == "" {
+		appName = "CasGists"
+	}
+	return TemplateData{
+		Name:           name,
+		Username:       user.Username,
+		Email:          user.Email,
+		AppName:        appName,
+		ServerURL:      strings.TrimRight(cfg.GetString("server.url"), "/"),
+		UnsubscribeURL: UnsubscribeURL(cfg, user.ID),
+	}
+}
+
+// Render renders a newsletter for a recipient. The text body gets an
+// unsubscribe footer, and the HTML body is the escaped text split into
+// paragraphs on blank lines.
+func Render(cfg *config.Config, n *models.Newsletter, user *models.User) (*Message, error) {
+	data := templateData(cfg, user)
+
+	subject, err := execute("subject", n.Subject, data)
+	if err != nil {
+		return nil, err
+	}
+	body, err := execute("body", n.Body, data)
+	if err != nil {
+		return nil, err
+	}
+	subject = strings.Join(strings.Fields(subject), " ")
+	body = strings.TrimSpace(strings.ReplaceAll(body, "\r\n", "\n"))
+
+	footer := fmt.Sprintf("You are receiving this because you subscribed to %s announcements.\nUnsubscribe: %s", data.AppName, data.UnsubscribeURL)
+
+	var htmlBody strings.Builder
+	htmlBody.WriteString("<!DOCTYPE html>\n<html>\n<body>\n")
+	for _, paragraph := range strings.Split(body, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+		htmlBody.WriteString("<p>")
+		htmlBody.WriteString(strings.ReplaceAll(html.EscapeString(paragraph), "\n", "<br>\n"))
+		htmlBody.WriteString("</p>\n")
+	}
+	fmt.Fprintf(&htmlBody, "<hr>\n<p style=\"font-size:12px;color:#666\">You are receiving this because you subscribed to %s announcements. <a href=\"%s\">Unsubscribe</a></p>\n",
+		html.EscapeString(data.AppName), html.EscapeString(data.UnsubscribeURL))
+	htmlBody.WriteString("</body>\n</html>\n")
+
+	return &Message{
+		Subject:        subject,
+		Text:           body + "\n\n--\n" + footer + "\n",
+		HTML:           htmlBody.String(),
+		UnsubscribeURL: data.UnsubscribeURL,
+	}, nil
+}
+
+func execute(name, source string, data TemplateData) (string, error) {
+	tmpl, err := textTemplate.New(name).Option("missingkey=error").Parse(source)
+	if err != nil {
+		return "", fmt.Errorf("%w: %s template: %v", ErrInvalidNewsletter, name, err)
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", fmt.Errorf("%w: %s template: %v", ErrInvalidNewsletter, name, err)
+	}
+	return buf.String(), nil
+}
+
+// UnsubscribeToken signs a user ID so the unsubscribe link in a newsletter
+// works without logging in
+func UnsubscribeToken(cfg *config.Config, userID uuid.UUID) string {
+	mac := hmac.New(sha256.New, []byte(cfg.GetString("security.secret_key")))
+	mac.Write([]byte("newsletter-unsubscribe:" + userID.String()))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// VerifyUnsubscribeToken checks a token made by UnsubscribeToken
+func VerifyUnsubscribeToken(cfg *config.Config, userID uuid.UUID, token string) bool {
+	return hmac.Equal([]byte(UnsubscribeToken(cfg, userID)), []byte(token))
+}
+
+// UnsubscribeURL returns the one-click unsubscribe link for a user
+func UnsubscribeURL(cfg *config.Config, userID uuid.UUID) string {
+	query := url.Values{}
+	query.Set("user", userID.String())
+	query.Set("token", UnsubscribeToken(cfg, userID))
+	return strings.TrimRight(cfg.GetString("server.url"), "/") + "/newsletter/unsubscribe?" + query.Encode()
+}
@@ -1,25 +1,266 @@
+// Package notifications keeps each user's in-app notification center. It
+// is fed by the events that also send emails: stars, forks, comments,
+// follows and organization invitations.
 package notifications
 
 import (
+	"errors"
+	"fmt"
+	"time"
+
 	"github.com/google/uuid"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// Page size limits for List
+const (
+	DefaultPerPage = 20
+	MaxPerPage     = 100
 )
 
-// Service handles notifications
+// ErrNotFound is returned for notifications that don't exist or belong to
+// someone else
+var ErrNotFound = errors.New("notification not found")
+
+// Service records and reads notifications and pushes them to open streams
 type Service struct {
-	db *gorm.DB
+	db  *gorm.DB
+	hub *Hub
 }
 
-// NewService creates a new notifications service
+// NewService creates a new notification service
 func NewService(db *gorm.DB) *Service {
-	return &Service{
-		db: db,
-	}
+	return &Service{db: db, hub: defaultHub}
+}
+
+// Streams returns the hub of the open notification streams
+func (s *Service) Streams() *Hub {
+	return s.hub
 }
 
-// SendNotification sends a notification (placeholder implementation)
-func (s *Service) SendNotification(userID uuid.UUID, title, message string) error {
-	// This would implement actual notification delivery
-	// For now, it's a placeholder
+// PublishComment pushes a change to a gist's comments to the streams
+// watching the gist
+func (s *Service) PublishComment(gistID uuid.UUID, eventType string, data interface{}) {
+	s.hub.Publish(gistTopic(gistID), Event{Type: eventType, Data: data})
+}
+
+// Notify records a notification, and pushes it to the recipient's open
+// streams, unless they acted themselves or turned the type off
+func (s *Service) Notify(notification *models.Notification) error {
+	if notification.ActorID != nil && *notification.ActorID == notification.UserID {
+		return nil
+	}
+	preference, err := s.Preferences(notification.UserID)
+	if err != nil {
+		return err
+	}
+	if !preference.Wants(notification.Type) {
+		return nil
+	}
+	if err := s.db.Create(notification).Error; err != nil {
+		return err
+	}
+	s.hub.Publish(userTopic(notification.UserID), Event{Type: EventNotification, Data: notification})
 	return nil
-}
\ No newline at end of file
+}
+
+// GistStarred notifies a gist's owner that the actor starred it
+func (s *Service) GistStarred(gist *models.Gist, actor *models.User) error {
+	if gist.UserID == nil {
+		return nil
+	}
+	return s.Notify(&models.Notification{
+		UserID:  *gist.UserID,
+		ActorID: &actor.ID,
+		Type:    models.NotificationGistStarred,
+		Message: fmt.Sprintf("%s starred your gist %s", actor.Username, gistName(gist)),
+		URL:     urls.Path(urls.GistPage, gist.ID.String()),
+		GistID:  &gist.ID,
+	})
+}
+
+// GistForked notifies a gist's owner that the actor forked it
+func (s *Service) GistForked(gist, fork *models.Gist, actor *models.User) error {
+	if gist.UserID == nil {
+		return nil
+	}
+	return s.Notify(&models.Notification{
+		UserID:  *gist.UserID,
+		ActorID: &actor.ID,
+		Type:    models.NotificationGistForked,
+		Message: fmt.Sprintf("%s forked your gist %s", actor.Username, gistName(gist)),
+		URL:     urls.Path(urls.GistPage, fork.ID.String()),
+		GistID:  &gist.ID,
+	})
+}
+
+// GistCommented notifies the recipient of a comment by the actor: the
+// gist's owner, or with reply set the author of the comment answered
+func (s *Service) GistCommented(recipientID uuid.UUID, gist *models.Gist, comment *models.GistComment, actor *models.User, reply bool) error {
+	notificationType := models.NotificationGistCommented
+	message := fmt.Sprintf("%s commented on your gist %s", actor.Username, gistName(gist))
+	if reply {
+		notificationType = models.NotificationCommentReplied
+		message = fmt.Sprintf("%s replied to your comment on %s", actor.Username, gistName(gist))
+	}
+	return s.Notify(&models.Notification{
+		UserID:  recipientID,
+		ActorID: &actor.ID,
+		Type:    notificationType,
+		Message: message,
+		URL:     urls.Path(urls.GistPage, gist.ID.String()) + "#comment-" + comment.ID.String(),
+		GistID:  &gist.ID,
+	})
+}
+
+// Followed notifies a user that the actor followed them
+func (s *Service) Followed(userID uuid.UUID, actor *models.User) error {
+	return s.Notify(&models.Notification{
+		UserID:  userID,
+		ActorID: &actor.ID,
+		Type:    models.NotificationFollowed,
+		Message: fmt.Sprintf("%s started following you", actor.Username),
+		URL:     urls.Path("/users/:username", actor.Username),
+	})
+}
+
+// AddedToOrganization notifies a user that the actor made them a member of
+// an organization
+func (s *Service) AddedToOrganization(userID uuid.UUID, orgName, role string, actorID uuid.UUID, actorName string) error {
+	return s.Notify(&models.Notification{
+		UserID:  userID,
+		ActorID: &actorID,
+		Type:    models.NotificationOrgInvited,
+		Message: fmt.Sprintf("%s added you to the %s organization as %s", actorName, orgName, role),
+		URL:     urls.Path("/o/:org", orgName),
+	})
+}
+
+// List returns a page of the user's notifications, newest first, and how
+// many there are in all
+func (s *Service) List(userID uuid.UUID, unreadOnly bool, page, perPage int) ([]models.Notification, int64, error) {
+	if page < 1 {
+		page = 1
+	}
+	if perPage < 1 {
+		perPage = DefaultPerPage
+	}
+	if perPage > MaxPerPage {
+		perPage = MaxPerPage
+	}
+
+	query := s.db.Model(&models.Notification{}).Where("user_id = ?", userID)
+	if unreadOnly {
+		query = query.Where("read_at IS NULL")
+	}
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	notifications := []models.Notification{}
+	if err := query.Order("created_at DESC").Offset((page - 1) * perPage).Limit(perPage).
+		Find(&notifications).Error; err != nil {
+		return nil, 0, err
+	}
+	return notifications, total, nil
+}
+
+// UnreadCount returns how many of the user's notifications are unread
+func (s *Service) UnreadCount(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// MarkRead marks one of the user's notifications as read
+func (s *Service) MarkRead(userID, notificationID uuid.UUID) (*models.Notification, error) {
+	var notification models.Notification
+	if err := s.db.First(&notification, "id = ? AND user_id = ?", notificationID, userID).Error; err != nil {
+		return nil, ErrNotFound
+	}
+	if notification.ReadAt == nil {
+		now := time.Now()
+		if err := s.db.Model(&notification).Update("read_at", now).Error; err != nil {
+			return nil, err
+		}
+		notification.ReadAt = &now
+	}
+	return &notification, nil
+}
+
+// MarkAllRead marks every unread notification of the user as read and
+// returns how many there were
+func (s *Service) MarkAllRead(userID uuid.UUID) (int64, error) {
+	result := s.db.Model(&models.Notification{}).
+		Where("user_id = ? AND read_at IS NULL", userID).
+		Update("read_at", time.Now())
+	return result.RowsAffected, result.Error
+}
+
+// Preferences returns the user's notification preferences
+func (s *Service) Preferences(userID uuid.UUID) (*models.NotificationPreference, error) {
+	// Users without a row keep the defaults
+	preference := models.DefaultNotificationPreference(userID)
+	if err := s.db.Where("user_id = ?", userID).Limit(1).Find(&preference).Error; err != nil {
+		return nil, err
+	}
+	return &preference, nil
+}
+
+// PreferenceUpdate holds the preferences to change; nil fields are left as
+// they are
+type PreferenceUpdate struct {
+	GistStarred   *bool `json:"gist_starred"`
+	GistForked    *bool `json:"gist_forked"`
+	GistCommented *bool `json:"gist_commented"`
+	Followed      *bool `json:"followed"`
+	OrgInvited    *bool `json:"org_invited"`
+}
+
+// UpdatePreferences changes the user's notification preferences
+func (s *Service) UpdatePreferences(userID uuid.UUID, update PreferenceUpdate) (*models.NotificationPreference, error) {
+	preference, err := s.Preferences(userID)
+	if err != nil {
+		return nil, err
+	}
+	if update.GistStarred != nil {
+		preference.GistStarred = *update.GistStarred
+	}
+	if update.GistForked != nil {
+		preference.GistForked = *update.GistForked
+	}
+	if update.GistCommented != nil {
+		preference.GistCommented = *update.GistCommented
+	}
+	if update.Followed != nil {
+		preference.Followed = *update.Followed
+	}
+	if update.OrgInvited != nil {
+		preference.OrgInvited = *update.OrgInvited
+	}
+	// Inserting writes the columns' defaults over false values, so the row
+	// is created with the defaults and the preferences are then updated
+	defaults := models.DefaultNotificationPreference(userID)
+	if err := s.db.FirstOrCreate(&defaults, "user_id = ?", userID).Error; err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(preference).
+		Select("gist_starred", "gist_forked", "gist_commented", "followed", "org_invited").
+		Updates(preference).Error; err != nil {
+		return nil, err
+	}
+	return preference, nil
+}
+
+// gistName is how notifications refer to a gist
+func gistName(gist *models.Gist) string {
+	if gist.Title != "" {
+		return gist.Title
+	}
+	return gist.ID.String()
+}
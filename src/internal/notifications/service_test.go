@@ -0,0 +1,105 @@
+package notifications
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistComment{},
+		&models.Notification{}, &models.NotificationPreference{}))
+	return db
+}
+
+func TestNotifications(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	gist := &models.Gist{UserID: &owner.ID, Title: "Notes", Visibility: models.VisibilityPublic, GitRepoPath: "notes"}
+	require.NoError(t, db.Create(gist).Error)
+
+	require.NoError(t, service.GistStarred(gist, alice))
+	require.NoError(t, service.GistForked(gist, &models.Gist{ID: gist.ID}, alice))
+	require.NoError(t, service.Followed(owner.ID, alice))
+	require.NoError(t, service.AddedToOrganization(owner.ID, "acme", "member", alice.ID, alice.Username))
+	// Acting on your own things notifies nobody
+	require.NoError(t, service.GistStarred(gist, owner))
+
+	items, total, err := service.List(owner.ID, false, 1, 2)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, total)
+	require.Len(t, items, 2)
+	assert.Equal(t, models.NotificationOrgInvited, items[0].Type)
+	assert.Equal(t, "alice added you to the acme organization as member", items[0].Message)
+	assert.Equal(t, "/o/acme", items[0].URL)
+
+	unread, err := service.UnreadCount(owner.ID)
+	require.NoError(t, err)
+	assert.EqualValues(t, 4, unread)
+
+	t.Run("MarkRead", func(t *testing.T) {
+		read, err := service.MarkRead(owner.ID, items[1].ID)
+		require.NoError(t, err)
+		assert.NotNil(t, read.ReadAt)
+
+		_, err = service.MarkRead(alice.ID, items[0].ID)
+		assert.ErrorIs(t, err, ErrNotFound)
+
+		unreadItems, unreadTotal, err := service.List(owner.ID, true, 1, 20)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, unreadTotal)
+		for _, item := range unreadItems {
+			assert.Nil(t, item.ReadAt)
+		}
+
+		marked, err := service.MarkAllRead(owner.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, marked)
+		unread, err := service.UnreadCount(owner.ID)
+		require.NoError(t, err)
+		assert.Zero(t, unread)
+	})
+
+	t.Run("Preferences", func(t *testing.T) {
+		preference, err := service.Preferences(alice.ID)
+		require.NoError(t, err)
+		assert.True(t, preference.GistStarred)
+
+		off := false
+		preference, err = service.UpdatePreferences(alice.ID, PreferenceUpdate{GistCommented: &off})
+		require.NoError(t, err)
+		assert.False(t, preference.GistCommented)
+		assert.True(t, preference.Followed)
+
+		// Saved, not just returned
+		preference, err = service.Preferences(alice.ID)
+		require.NoError(t, err)
+		assert.False(t, preference.GistCommented)
+
+		// Replies count as comments
+		comment := &models.GistComment{GistID: gist.ID, UserID: alice.ID, Content: "Hi"}
+		require.NoError(t, db.Create(comment).Error)
+		require.NoError(t, service.GistCommented(alice.ID, gist, comment, owner, true))
+		require.NoError(t, service.Followed(alice.ID, owner))
+
+		items, total, err := service.List(alice.ID, false, 1, 20)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, total)
+		require.Len(t, items, 1)
+		assert.Equal(t, models.NotificationFollowed, items[0].Type)
+	})
+}
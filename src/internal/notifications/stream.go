@@ -0,0 +1,211 @@
+package notifications
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Stream limits
+const (
+	// StreamBuffer is how many events a connection may fall behind by
+	// before it is dropped
+	StreamBuffer = 64
+	// MaxStreamsPerUser caps the open streams of one user, such as one per
+	// browser tab
+	MaxStreamsPerUser = 5
+	// TicketTTL is how long a stream ticket can be redeemed
+	TicketTTL = 30 * time.Second
+)
+
+// Stream event types
+const (
+	EventNotification     = "notification"
+	EventCommentCreated   = "comment.created"
+	EventCommentUpdated   = "comment.updated"
+	EventCommentDeleted   = "comment.deleted"
+	EventCommentReactions = "comment.reactions"
+)
+
+// Stream errors
+var (
+	ErrTooManyStreams = errors.New("too many open notification streams")
+	ErrTicketInvalid  = errors.New("stream ticket is invalid or expired")
+)
+
+// Event is pushed to the streams subscribed to its topic
+type Event struct {
+	Type string
+	Data interface{}
+}
+
+// Subscription is one open stream. Its events stop and Dropped is closed
+// when it falls more than StreamBuffer events behind.
+type Subscription struct {
+	userID  uuid.UUID
+	topics  []string
+	events  chan Event
+	dropped chan struct{}
+	closed  bool // Guarded by the hub's lock
+}
+
+// Events returns the subscription's events
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Dropped is closed when the subscription couldn't keep up. The client
+// should fetch what it missed and reconnect.
+func (s *Subscription) Dropped() <-chan struct{} {
+	return s.dropped
+}
+
+type ticket struct {
+	userID  uuid.UUID
+	expires time.Time
+}
+
+// Hub passes events from where they happen to the open streams of this
+// process
+type Hub struct {
+	mu            sync.Mutex
+	subscriptions map[string]map[*Subscription]struct{} // By topic
+	streams       map[uuid.UUID]int                     // Open streams by user
+	tickets       map[string]ticket
+}
+
+// NewHub creates an empty hub
+func NewHub() *Hub {
+	return &Hub{
+		subscriptions: make(map[string]map[*Subscription]struct{}),
+		streams:       make(map[uuid.UUID]int),
+		tickets:       make(map[string]ticket),
+	}
+}
+
+// defaultHub is shared by every Service, so events published by one reach
+// streams opened through another
+var defaultHub = NewHub()
+
+func userTopic(userID uuid.UUID) string {
+	return "user:" + userID.String()
+}
+
+func gistTopic(gistID uuid.UUID) string {
+	return "gist:" + gistID.String()
+}
+
+// Subscribe opens a stream of the user's notifications and of the comment
+// events of the given gists. Callers check the user may read the gists.
+func (h *Hub) Subscribe(userID uuid.UUID, gistIDs ...uuid.UUID) (*Subscription, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.streams[userID] >= MaxStreamsPerUser {
+		return nil, ErrTooManyStreams
+	}
+	sub := &Subscription{
+		userID:  userID,
+		topics:  []string{userTopic(userID)},
+		events:  make(chan Event, StreamBuffer),
+		dropped: make(chan struct{}),
+	}
+	for _, gistID := range gistIDs {
+		sub.topics = append(sub.topics, gistTopic(gistID))
+	}
+	for _, topic := range sub.topics {
+		if h.subscriptions[topic] == nil {
+			h.subscriptions[topic] = make(map[*Subscription]struct{})
+		}
+		h.subscriptions[topic][sub] = struct{}{}
+	}
+	h.streams[userID]++
+	return sub, nil
+}
+
+// Unsubscribe closes a stream. Closing it twice is harmless.
+func (h *Hub) Unsubscribe(sub *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.remove(sub)
+}
+
+// remove takes a subscription off its topics; the caller holds the lock
+func (h *Hub) remove(sub *Subscription) bool {
+	if sub.closed {
+		return false
+	}
+	sub.closed = true
+	for _, topic := range sub.topics {
+		delete(h.subscriptions[topic], sub)
+		if len(h.subscriptions[topic]) == 0 {
+			delete(h.subscriptions, topic)
+		}
+	}
+	if h.streams[sub.userID]--; h.streams[sub.userID] <= 0 {
+		delete(h.streams, sub.userID)
+	}
+	return true
+}
+
+// Publish sends an event to the subscriptions of a topic without waiting
+// on any of them. Subscriptions whose buffer is full are dropped rather
+// than holding up the others.
+func (h *Hub) Publish(topic string, event Event) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for sub := range h.subscriptions[topic] {
+		select {
+		case sub.events <- event:
+		default:
+			if h.remove(sub) {
+				close(sub.dropped)
+			}
+		}
+	}
+}
+
+// IssueTicket returns a single-use ticket that opens a stream for the user
+// within TicketTTL. Clients that can't send an Authorization header, like
+// EventSource, authenticate with it instead of putting their token in the
+// URL.
+func (h *Hub) IssueTicket(userID uuid.UUID) (string, time.Time, error) {
+	raw := make([]byte, 24)
+	if _, err := rand.Read(raw); err != nil {
+		return "", time.Time{}, err
+	}
+	value := hex.EncodeToString(raw)
+	expires := time.Now().Add(TicketTTL)
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := time.Now()
+	for key, t := range h.tickets {
+		if now.After(t.expires) {
+			delete(h.tickets, key)
+		}
+	}
+	h.tickets[value] = ticket{userID: userID, expires: expires}
+	return value, expires, nil
+}
+
+// RedeemTicket returns the user a ticket was issued to and invalidates it
+func (h *Hub) RedeemTicket(value string) (uuid.UUID, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	t, ok := h.tickets[value]
+	if !ok {
+		return uuid.Nil, ErrTicketInvalid
+	}
+	delete(h.tickets, value)
+	if time.Now().After(t.expires) {
+		return uuid.Nil, ErrTicketInvalid
+	}
+	return t.userID, nil
+}
@@ -0,0 +1,135 @@
+package notifications
+
+import (
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestHub(t *testing.T) {
+	t.Run("Topics", func(t *testing.T) {
+		hub := NewHub()
+		userID, otherID, gistID := uuid.New(), uuid.New(), uuid.New()
+
+		sub, err := hub.Subscribe(userID, gistID)
+		require.NoError(t, err)
+		other, err := hub.Subscribe(otherID)
+		require.NoError(t, err)
+
+		hub.Publish(userTopic(userID), Event{Type: EventNotification, Data: "starred"})
+		hub.Publish(gistTopic(gistID), Event{Type: EventCommentCreated, Data: "comment"})
+		hub.Publish(gistTopic(uuid.New()), Event{Type: EventCommentCreated, Data: "elsewhere"})
+
+		require.Len(t, sub.Events(), 2)
+		assert.Equal(t, EventNotification, (<-sub.Events()).Type)
+		assert.Equal(t, "comment", (<-sub.Events()).Data)
+		assert.Empty(t, other.Events())
+
+		// Closed streams get nothing more, and closing again is harmless
+		hub.Unsubscribe(sub)
+		hub.Unsubscribe(sub)
+		hub.Publish(userTopic(userID), Event{Type: EventNotification})
+		assert.Empty(t, sub.Events())
+		assert.Empty(t, hub.streams[userID])
+	})
+
+	t.Run("SlowSubscriberDropped", func(t *testing.T) {
+		hub := NewHub()
+		slowID, fastID, gistID := uuid.New(), uuid.New(), uuid.New()
+		slow, err := hub.Subscribe(slowID, gistID)
+		require.NoError(t, err)
+		fast, err := hub.Subscribe(fastID, gistID)
+		require.NoError(t, err)
+
+		for i := 0; i <= StreamBuffer; i++ {
+			hub.Publish(gistTopic(gistID), Event{Type: EventCommentUpdated, Data: i})
+			<-fast.Events()
+		}
+
+		select {
+		case <-slow.Dropped():
+		default:
+			t.Fatal("a subscriber that fell behind should be dropped")
+		}
+		// The others keep receiving
+		hub.Publish(gistTopic(gistID), Event{Type: EventCommentDeleted})
+		assert.Len(t, fast.Events(), 1)
+		assert.Len(t, slow.Events(), StreamBuffer)
+	})
+
+	t.Run("StreamLimit", func(t *testing.T) {
+		hub := NewHub()
+		userID := uuid.New()
+		var subs []*Subscription
+		for i := 0; i < MaxStreamsPerUser; i++ {
+			sub, err := hub.Subscribe(userID)
+			require.NoError(t, err)
+			subs = append(subs, sub)
+		}
+		_, err := hub.Subscribe(userID)
+		assert.ErrorIs(t, err, ErrTooManyStreams)
+
+		hub.Unsubscribe(subs[0])
+		_, err = hub.Subscribe(userID)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Tickets", func(t *testing.T) {
+		hub := NewHub()
+		userID := uuid.New()
+
+		value, expires, err := hub.IssueTicket(userID)
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now().Add(TicketTTL), expires, time.Second)
+
+		redeemed, err := hub.RedeemTicket(value)
+		require.NoError(t, err)
+		assert.Equal(t, userID, redeemed)
+
+		// Single use
+		_, err = hub.RedeemTicket(value)
+		assert.ErrorIs(t, err, ErrTicketInvalid)
+		_, err = hub.RedeemTicket("")
+		assert.ErrorIs(t, err, ErrTicketInvalid)
+
+		expired, _, err := hub.IssueTicket(userID)
+		require.NoError(t, err)
+		hub.tickets[expired] = ticket{userID: userID, expires: time.Now().Add(-time.Second)}
+		_, err = hub.RedeemTicket(expired)
+		assert.ErrorIs(t, err, ErrTicketInvalid)
+	})
+}
+
+func TestNotifyPublishes(t *testing.T) {
+	db := setupTestDB(t)
+	service := NewService(db)
+	service.hub = NewHub()
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+
+	sub, err := service.Streams().Subscribe(owner.ID)
+	require.NoError(t, err)
+	require.NoError(t, service.Followed(owner.ID, alice))
+
+	require.Len(t, sub.Events(), 1)
+	event := <-sub.Events()
+	assert.Equal(t, EventNotification, event.Type)
+	notification, ok := event.Data.(*models.Notification)
+	require.True(t, ok)
+	assert.Equal(t, "alice started following you", notification.Message)
+
+	// Turned off types are neither stored nor pushed
+	off := false
+	_, err = service.UpdatePreferences(owner.ID, PreferenceUpdate{Followed: &off})
+	require.NoError(t, err)
+	require.NoError(t, service.Followed(owner.ID, alice))
+	assert.Empty(t, sub.Events())
+}
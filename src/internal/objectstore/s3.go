@@ -0,0 +1,508 @@
+package objectstore
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+const (
+	// emptyPayloadHash is the SHA-256 of an empty body
+	emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+	// unsignedPayload lets uploads stream without hashing them first;
+	// the transport still protects the content
+	unsignedPayload = "UNSIGNED-PAYLOAD"
+)
+
+// S3Credentials sign requests to S3
+type S3Credentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// S3Options configures an S3 bucket
+type S3Options struct {
+	// Endpoint is the service URL (default: https://s3.<region>.amazonaws.com)
+	Endpoint string
+	Region   string
+	Bucket   string
+	// Prefix is prepended to every key, so one bucket can hold several
+	// instances
+	Prefix string
+	// PathStyle addresses the bucket in the path rather than the host
+	// name, as MinIO and most other S3-compatible services need
+	PathStyle   bool
+	Credentials S3Credentials
+	// Client sends the requests (default: http.DefaultClient)
+	Client *http.Client
+}
+
+// S3Bucket keeps objects in an S3-compatible bucket. Requests are signed
+// with AWS Signature Version 4.
+type S3Bucket struct {
+	name     string
+	endpoint *url.URL
+	opts     S3Options
+	client   *http.Client
+	now      func() time.Time
+}
+
+// NewS3Bucket creates a bucket from its options
+func NewS3Bucket(opts S3Options) (*S3Bucket, error) {
+	if opts.Bucket == "" {
+		return nil, errors.New("storage.s3.bucket is required")
+	}
+	if opts.Region == "" {
+		opts.Region = "us-east-1"
+	}
+	if opts.Endpoint == "" {
+		opts.Endpoint = "https://s3." + opts.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(opts.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("storage.s3.endpoint must be an http or https URL: %q", opts.Endpoint)
+	}
+	if opts.Credentials.AccessKeyID == "" || opts.Credentials.SecretAccessKey == "" {
+		return nil, errors.New("S3 storage needs an access key ID and secret access key")
+	}
+	opts.Prefix = strings.Trim(opts.Prefix, "/")
+	if opts.Prefix != "" {
+		if err := validKey(opts.Prefix); err != nil {
+			return nil, fmt.Errorf("storage.s3.prefix: %w", err)
+		}
+		opts.Prefix += "/"
+	}
+	client := opts.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	return &S3Bucket{name: "s3", endpoint: endpoint, opts: opts, client: client, now: time.Now}, nil
+}
+
+func newS3BucketFromConfig(cfg *config.Config) (Bucket, error) {
+	opts, err := s3OptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewS3Bucket(opts)
+}
+
+// newMinIOBucketFromConfig is the S3 driver with path-style addressing,
+// which MinIO needs
+func newMinIOBucketFromConfig(cfg *config.Config) (Bucket, error) {
+	opts, err := s3OptionsFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Endpoint == "" {
+		return nil, errors.New("storage.s3.endpoint is required for MinIO")
+	}
+	opts.PathStyle = true
+	bucket, err := NewS3Bucket(opts)
+	if err != nil {
+		return nil, err
+	}
+	bucket.name = "minio"
+	return bucket, nil
+}
+
+// s3OptionsFromConfig reads storage.s3.*. Credentials left out of the
+// configuration come from a file named by secret_access_key_file, then
+// from the AWS_* environment variables.
+func s3OptionsFromConfig(cfg *config.Config) (S3Options, error) {
+	opts := S3Options{
+		Endpoint:  cfg.GetString("storage.s3.endpoint"),
+		Region:    cfg.GetString("storage.s3.region"),
+		Bucket:    cfg.GetString("storage.s3.bucket"),
+		Prefix:    cfg.GetString("storage.s3.prefix"),
+		PathStyle: cfg.GetBool("storage.s3.path_style"),
+		Credentials: S3Credentials{
+			AccessKeyID:     cfg.GetString("storage.s3.access_key_id"),
+			SecretAccessKey: cfg.GetString("storage.s3.secret_access_key"),
+			SessionToken:    cfg.GetString("storage.s3.session_token"),
+		},
+	}
+	if opts.Credentials.SecretAccessKey == "" {
+		if file := cfg.GetString("storage.s3.secret_access_key_file"); file != "" {
+			secret, err := os.ReadFile(file)
+			if err != nil {
+				return opts, fmt.Errorf("storage.s3.secret_access_key_file: %w", err)
+			}
+			opts.Credentials.SecretAccessKey = strings.TrimSpace(string(secret))
+		}
+	}
+	if opts.Credentials.AccessKeyID == "" && opts.Credentials.SecretAccessKey == "" {
+		opts.Credentials = S3Credentials{
+			AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		}
+	}
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+	return opts, nil
+}
+
+// Name returns "s3", or "minio" for the MinIO driver
+func (b *S3Bucket) Name() string {
+	return b.name
+}
+
+// Describe returns the endpoint, bucket and prefix
+func (b *S3Bucket) Describe() string {
+	return b.endpoint.Scheme + "://" + b.endpoint.Host + "/" + b.opts.Bucket + "/" + b.opts.Prefix
+}
+
+// objectURL returns the URL of a key, or of the bucket for an empty key
+func (b *S3Bucket) objectURL(key string, query url.Values) *url.URL {
+	u := *b.endpoint
+	basePath := strings.TrimSuffix(u.Path, "/")
+	if b.opts.PathStyle {
+		u.Path = basePath + "/" + b.opts.Bucket + "/"
+	} else {
+		u.Host = b.opts.Bucket + "." + u.Host
+		u.Path = basePath + "/"
+	}
+	if key != "" {
+		u.Path += b.opts.Prefix + key
+	}
+	// Sent exactly as signed
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(query)
+	return &u
+}
+
+// do signs and sends a request, turning S3 error responses into errors
+func (b *S3Bucket) do(ctx context.Context, method, key string, query url.Values, body io.Reader, size int64, header http.Header) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, b.objectURL(key, query).String(), body)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	payloadHash := emptyPayloadHash
+	if body != nil {
+		payloadHash = unsignedPayload
+		req.ContentLength = size
+		if size == 0 {
+			req.Body = http.NoBody
+		}
+	}
+	b.sign(req, payloadHash, b.now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 300 {
+		return resp, nil
+	}
+	defer resp.Body.Close()
+	return nil, s3Error(resp)
+}
+
+// s3ErrorResponse is the XML body S3 returns with a failed request
+type s3ErrorResponse struct {
+	Code    string `xml:"Code"`
+	Message string `xml:"Message"`
+}
+
+func s3Error(resp *http.Response) error {
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	var body s3ErrorResponse
+	if err := xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&body); err == nil && body.Code != "" {
+		return fmt.Errorf("s3: %s: %s (HTTP %d)", body.Code, body.Message, resp.StatusCode)
+	}
+	return fmt.Errorf("s3: HTTP %d", resp.StatusCode)
+}
+
+// Put uploads the content in one request. Content of unknown size is
+// spooled to a temporary file first, as S3 needs the length up front.
+func (b *S3Bucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	if size < 0 {
+		spool, err := os.CreateTemp("", "casgists-upload-*")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(spool.Name())
+		defer spool.Close()
+		if size, err = io.Copy(spool, r); err != nil {
+			return err
+		}
+		if _, err := spool.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		r = spool
+	}
+	header := http.Header{"Content-Type": {"application/octet-stream"}}
+	resp, err := b.do(ctx, http.MethodPut, key, nil, io.NopCloser(r), size, header)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// Open looks the object up and returns a reader that fetches it with
+// ranged GETs, so seeking skips content rather than downloading it
+func (b *S3Bucket) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	object, err := b.Stat(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &s3Reader{ctx: ctx, bucket: b, key: key, size: object.Size}, nil
+}
+
+// Stat sends a HEAD request for the object
+func (b *S3Bucket) Stat(ctx context.Context, key string) (Object, error) {
+	if err := validKey(key); err != nil {
+		return Object{}, err
+	}
+	resp, err := b.do(ctx, http.MethodHead, key, nil, nil, 0, nil)
+	if err != nil {
+		return Object{}, err
+	}
+	resp.Body.Close()
+	modTime, _ := http.ParseTime(resp.Header.Get("Last-Modified"))
+	return Object{Key: key, Size: resp.ContentLength, ModTime: modTime}, nil
+}
+
+// Delete removes the object
+func (b *S3Bucket) Delete(ctx context.Context, key string) error {
+	if err := validKey(key); err != nil {
+		return err
+	}
+	resp, err := b.do(ctx, http.MethodDelete, key, nil, nil, 0, nil)
+	if errors.Is(err, ErrNotFound) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	return nil
+}
+
+// listResult is the part of a ListObjectsV2 response we use
+type listResult struct {
+	Contents []struct {
+		Key          string    `xml:"Key"`
+		Size         int64     `xml:"Size"`
+		LastModified time.Time `xml:"LastModified"`
+	} `xml:"Contents"`
+	IsTruncated           bool   `xml:"IsTruncated"`
+	NextContinuationToken string `xml:"NextContinuationToken"`
+}
+
+// List pages through ListObjectsV2
+func (b *S3Bucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {b.opts.Prefix + prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		resp, err := b.do(ctx, http.MethodGet, "", query, nil, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		var page listResult
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("s3: reading object list: %w", err)
+		}
+		for _, content := range page.Contents {
+			key := strings.TrimPrefix(content.Key, b.opts.Prefix)
+			if key == healthKey {
+				continue
+			}
+			objects = append(objects, Object{Key: key, Size: content.Size, ModTime: content.LastModified})
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Health probes the bucket with a write and read back
+func (b *S3Bucket) Health(ctx context.Context) Health {
+	return probe(ctx, b)
+}
+
+// s3Reader reads an object from the offset it was sought to
+type s3Reader struct {
+	ctx    context.Context
+	bucket *S3Bucket
+	key    string
+	size   int64
+	offset int64
+	body   io.ReadCloser
+}
+
+func (r *s3Reader) Read(p []byte) (int, error) {
+	if r.offset >= r.size {
+		return 0, io.EOF
+	}
+	if r.body == nil {
+		header := http.Header{"Range": {"bytes=" + strconv.FormatInt(r.offset, 10) + "-"}}
+		resp, err := r.bucket.do(r.ctx, http.MethodGet, r.key, nil, nil, 0, header)
+		if err != nil {
+			return 0, err
+		}
+		r.body = resp.Body
+	}
+	n, err := r.body.Read(p)
+	r.offset += int64(n)
+	if err == io.EOF && r.offset < r.size {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (r *s3Reader) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += r.offset
+	case io.SeekEnd:
+		offset += r.size
+	}
+	if offset < 0 {
+		return 0, errors.New("s3: negative position")
+	}
+	if offset != r.offset && r.body != nil {
+		r.body.Close()
+		r.body = nil
+	}
+	r.offset = offset
+	return offset, nil
+}
+
+func (r *s3Reader) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	err := r.body.Close()
+	r.body = nil
+	return err
+}
+
+// sign adds the AWS Signature Version 4 headers to a request
+func (b *S3Bucket) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format("20060102T150405Z")
+	scope := amzDate[:8] + "/" + b.opts.Region + "/s3/aws4_request"
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if b.opts.Credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", b.opts.Credentials.SessionToken)
+	}
+
+	// Host, Range, Content-Type and the x-amz-* headers are signed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "x-amz-") || lower == "range" || lower == "content-type" {
+			headers[lower] = strings.TrimSpace(strings.Join(values, ","))
+		}
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		uriEncode(req.URL.Path, false),
+		canonicalQuery(req.URL.Query()),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(requestHash[:])
+
+	key := hmacSHA256([]byte("AWS4"+b.opts.Credentials.SecretAccessKey), amzDate[:8])
+	key = hmacSHA256(key, b.opts.Region)
+	key = hmacSHA256(key, "s3")
+	key = hmacSHA256(key, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+b.opts.Credentials.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// canonicalQuery encodes a query sorted by name, as signing needs
+func canonicalQuery(query url.Values) string {
+	if len(query) == 0 {
+		return ""
+	}
+	names := make([]string, 0, len(query))
+	for name := range query {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var pairs []string
+	for _, name := range names {
+		values := append([]string(nil), query[name]...)
+		sort.Strings(values)
+		for _, value := range values {
+			pairs = append(pairs, uriEncode(name, true)+"="+uriEncode(value, true))
+		}
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uriEncode percent-encodes everything but unreserved characters and,
+// unless encodeSlash is set, slashes
+func uriEncode(s string, encodeSlash bool) string {
+	var encoded strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9',
+			c == '-', c == '_', c == '.', c == '~':
+			encoded.WriteByte(c)
+		case c == '/' && !encodeSlash:
+			encoded.WriteByte(c)
+		default:
+			fmt.Fprintf(&encoded, "%%%02X", c)
+		}
+	}
+	return encoded.String()
+}
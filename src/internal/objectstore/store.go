@@ -0,0 +1,372 @@
+// Package objectstore keeps files that outgrow the database: attachment
+// blobs, backups, GDPR exports and instance archives. A bucket is a flat
+// namespace of slash-separated keys, stored on the local filesystem or in
+// S3-compatible object storage such as AWS S3 or MinIO.
+package objectstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Key prefixes of the files kept in a bucket
+const (
+	PrefixBlobs    = "blobs/"
+	PrefixBackups  = "backups/"
+	PrefixExports  = "exports/"
+	PrefixArchives = "archives/"
+)
+
+var (
+	ErrNotFound   = errors.New("object not found")
+	ErrInvalidKey = errors.New("invalid object key")
+)
+
+// Object describes a stored object
+type Object struct {
+	Key     string    `json:"key"`
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mod_time"`
+}
+
+// Bucket stores objects under keys
+type Bucket interface {
+	// Name returns the driver name used in storage.type
+	Name() string
+	// Describe returns where the bucket stores objects, without
+	// credentials
+	Describe() string
+	// Put stores the content read from r under key, replacing any object
+	// there. size is the content's length, or -1 if it isn't known.
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	// Open returns an object's content
+	Open(ctx context.Context, key string) (io.ReadSeekCloser, error)
+	// Stat returns an object's size and modification time
+	Stat(ctx context.Context, key string) (Object, error)
+	// Delete removes an object; deleting a missing one is not an error
+	Delete(ctx context.Context, key string) error
+	// List returns the objects whose keys start with prefix
+	List(ctx context.Context, prefix string) ([]Object, error)
+	// Health checks that the bucket is reachable and writable
+	Health(ctx context.Context) Health
+}
+
+// Health is the result of a bucket health check
+type Health struct {
+	Driver    string  `json:"driver"`
+	Target    string  `json:"target"`
+	Healthy   bool    `json:"healthy"`
+	Writable  bool    `json:"writable"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// DriverFactory builds a bucket from the storage.* settings
+type DriverFactory func(cfg *config.Config) (Bucket, error)
+
+var (
+	driversMu sync.RWMutex
+	drivers   = map[string]DriverFactory{
+		"local": newLocalBucketFromConfig,
+		"s3":    newS3BucketFromConfig,
+		"minio": newMinIOBucketFromConfig,
+	}
+)
+
+// RegisterDriver makes a driver available under name
+func RegisterDriver(name string, factory DriverFactory) {
+	driversMu.Lock()
+	defer driversMu.Unlock()
+	drivers[name] = factory
+}
+
+// DriverNames returns the names of all registered drivers
+func DriverNames() []string {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	names := make([]string, 0, len(drivers))
+	for name := range drivers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HasDriver reports whether a driver is registered under name
+func HasDriver(name string) bool {
+	driversMu.RLock()
+	defer driversMu.RUnlock()
+	_, ok := drivers[name]
+	return ok
+}
+
+// New builds the bucket selected by storage.type (default: local)
+func New(cfg *config.Config) (Bucket, error) {
+	name := cfg.GetString("storage.type")
+	if name == "" {
+		name = "local"
+	}
+	return Open(name, cfg)
+}
+
+// Open builds the named driver from its configuration
+func Open(name string, cfg *config.Config) (Bucket, error) {
+	driversMu.RLock()
+	factory, ok := drivers[name]
+	driversMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown storage type %q (available: %s)", name, strings.Join(DriverNames(), ", "))
+	}
+	return factory(cfg)
+}
+
+// NewRemote builds the bucket selected by storage.type, or returns nil when
+// it is local. Backups and exports stay in their own directories on local
+// storage and only move into the bucket when it is remote.
+func NewRemote(cfg *config.Config) (Bucket, error) {
+	if name := cfg.GetString("storage.type"); name == "" || name == "local" {
+		return nil, nil
+	}
+	return New(cfg)
+}
+
+// PutFile uploads a local file under key
+func PutFile(ctx context.Context, bucket Bucket, key, filename string) error {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	return bucket.Put(ctx, key, file, info.Size())
+}
+
+// validKey rejects keys that are empty, absolute or could escape a
+// directory once mapped onto a filesystem
+func validKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.ContainsAny(key, "\\\x00") {
+		return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+	}
+	for _, segment := range strings.Split(key, "/") {
+		if segment == "" || segment == "." || segment == ".." {
+			return fmt.Errorf("%w: %q", ErrInvalidKey, key)
+		}
+	}
+	return nil
+}
+
+// healthKey is the object written and removed by health checks
+const healthKey = ".healthcheck"
+
+// probe writes, reads back and deletes a small object, the health check
+// shared by the drivers
+func probe(ctx context.Context, bucket Bucket) (health Health) {
+	health = Health{Driver: bucket.Name(), Target: bucket.Describe()}
+	start := time.Now()
+	defer func() {
+		health.LatencyMs = float64(time.Since(start).Microseconds()) / 1000
+	}()
+
+	content := fmt.Sprintf("casgists %d", start.UnixNano())
+	if err := bucket.Put(ctx, healthKey, strings.NewReader(content), int64(len(content))); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.Writable = true
+	reader, err := bucket.Open(ctx, healthKey)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	read, err := io.ReadAll(reader)
+	reader.Close()
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	if string(read) != content {
+		health.Error = "read back different content than was written"
+		return health
+	}
+	if err := bucket.Delete(ctx, healthKey); err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	health.Healthy = true
+	return health
+}
+
+// LocalBucket keeps objects as files under a root directory, each key a
+// path below it
+type LocalBucket struct {
+	root string
+}
+
+// NewLocalBucket creates a bucket rooted at a directory
+func NewLocalBucket(root string) *LocalBucket {
+	return &LocalBucket{root: root}
+}
+
+func newLocalBucketFromConfig(cfg *config.Config) (Bucket, error) {
+	root := cfg.GetString("storage.path")
+	if root == "" {
+		root = filepath.Join(cfg.GetString("data_dir"), "files")
+	}
+	if strings.Contains(root, "{") {
+		return nil, fmt.Errorf("storage.path has an unresolved placeholder: %s", root)
+	}
+	return NewLocalBucket(root), nil
+}
+
+// Name returns "local"
+func (b *LocalBucket) Name() string {
+	return "local"
+}
+
+// Describe returns the root directory
+func (b *LocalBucket) Describe() string {
+	return b.root
+}
+
+func (b *LocalBucket) path(key string) (string, error) {
+	if err := validKey(key); err != nil {
+		return "", err
+	}
+	return filepath.Join(b.root, filepath.FromSlash(key)), nil
+}
+
+// Put writes to a temporary file next to the target and renames it into
+// place, so readers never see a partial object
+func (b *LocalBucket) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(target), 0750); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(target), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	written, err := io.Copy(tmp, r)
+	if err == nil && size >= 0 && written != size {
+		err = fmt.Errorf("wrote %d bytes of %d", written, size)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), target)
+}
+
+// Open opens an object's file
+func (b *LocalBucket) Open(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	target, err := b.path(key)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(target)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return file, err
+}
+
+// Stat returns an object's file size and modification time
+func (b *LocalBucket) Stat(ctx context.Context, key string) (Object, error) {
+	target, err := b.path(key)
+	if err != nil {
+		return Object{}, err
+	}
+	info, err := os.Stat(target)
+	if errors.Is(err, fs.ErrNotExist) || (err == nil && info.IsDir()) {
+		return Object{}, ErrNotFound
+	}
+	if err != nil {
+		return Object{}, err
+	}
+	return Object{Key: key, Size: info.Size(), ModTime: info.ModTime()}, nil
+}
+
+// Delete removes an object's file
+func (b *LocalBucket) Delete(ctx context.Context, key string) error {
+	target, err := b.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(target); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// List walks the directories under prefix, skipping uploads in progress
+func (b *LocalBucket) List(ctx context.Context, prefix string) ([]Object, error) {
+	// Only the directory holding the prefix needs walking
+	start := b.root
+	if dir := path.Dir(prefix); prefix != "" && dir != "." {
+		if err := validKey(dir); err != nil {
+			return nil, err
+		}
+		start = filepath.Join(b.root, filepath.FromSlash(dir))
+	}
+
+	var objects []Object
+	err := filepath.WalkDir(start, func(name string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && name == start {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".upload-") {
+			return nil
+		}
+		rel, err := filepath.Rel(b.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if !strings.HasPrefix(key, prefix) || key == healthKey {
+			return nil
+		}
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		objects = append(objects, Object{Key: key, Size: info.Size(), ModTime: info.ModTime()})
+		return nil
+	})
+	return objects, err
+}
+
+// Health probes the root directory with a write and read back
+func (b *LocalBucket) Health(ctx context.Context) Health {
+	return probe(ctx, b)
+}
@@ -0,0 +1,122 @@
+package objectstore
+
+import (
+	"context"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// testBucket runs the behavior every driver shares
+func testBucket(t *testing.T, bucket Bucket) {
+	ctx := context.Background()
+
+	objects, err := bucket.List(ctx, PrefixBackups)
+	require.NoError(t, err)
+	assert.Empty(t, objects, "a bucket that was never written to is empty")
+
+	content := "casgists-backup contents"
+	require.NoError(t, bucket.Put(ctx, "backups/one.tar.gz", strings.NewReader(content), int64(len(content))))
+	require.NoError(t, bucket.Put(ctx, "backups/two.tar.gz", strings.NewReader("second"), -1))
+	require.NoError(t, bucket.Put(ctx, "exports/user.zip", strings.NewReader("export"), -1))
+
+	object, err := bucket.Stat(ctx, "backups/one.tar.gz")
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), object.Size)
+	assert.False(t, object.ModTime.IsZero())
+
+	objects, err = bucket.List(ctx, PrefixBackups)
+	require.NoError(t, err)
+	keys := []string{}
+	for _, object := range objects {
+		keys = append(keys, object.Key)
+	}
+	assert.ElementsMatch(t, []string{"backups/one.tar.gz", "backups/two.tar.gz"}, keys)
+
+	reader, err := bucket.Open(ctx, "backups/one.tar.gz")
+	require.NoError(t, err)
+	_, err = reader.Seek(9, io.SeekStart)
+	require.NoError(t, err)
+	read, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	assert.Equal(t, content[9:], string(read))
+	end, err := reader.Seek(0, io.SeekEnd)
+	require.NoError(t, err)
+	assert.EqualValues(t, len(content), end)
+	require.NoError(t, reader.Close())
+
+	// Replacing an object
+	require.NoError(t, bucket.Put(ctx, "exports/user.zip", strings.NewReader("newer export"), -1))
+	reader, err = bucket.Open(ctx, "exports/user.zip")
+	require.NoError(t, err)
+	read, err = io.ReadAll(reader)
+	require.NoError(t, err)
+	reader.Close()
+	assert.Equal(t, "newer export", string(read))
+
+	for _, key := range []string{"", "/etc/passwd", "backups/../../etc/passwd", "backups//x"} {
+		assert.ErrorIs(t, bucket.Put(ctx, key, strings.NewReader("x"), 1), ErrInvalidKey, key)
+	}
+
+	require.NoError(t, bucket.Delete(ctx, "backups/one.tar.gz"))
+	require.NoError(t, bucket.Delete(ctx, "backups/one.tar.gz"), "deleting a missing object is not an error")
+	_, err = bucket.Open(ctx, "backups/one.tar.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+	_, err = bucket.Stat(ctx, "backups/one.tar.gz")
+	assert.ErrorIs(t, err, ErrNotFound)
+
+	health := bucket.Health(ctx)
+	assert.True(t, health.Healthy, health.Error)
+	assert.True(t, health.Writable)
+	assert.Equal(t, bucket.Name(), health.Driver)
+	objects, err = bucket.List(ctx, "")
+	require.NoError(t, err)
+	assert.Len(t, objects, 2, "the health check leaves nothing behind")
+}
+
+func TestLocalBucket(t *testing.T) {
+	testBucket(t, NewLocalBucket(t.TempDir()))
+}
+
+func TestNew(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("storage.path", t.TempDir())
+	bucket, err := New(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "local", bucket.Name())
+	remote, err := NewRemote(cfg)
+	require.NoError(t, err)
+	assert.Nil(t, remote, "local storage isn't remote")
+
+	cfg.Set("storage.type", "tape")
+	_, err = New(cfg)
+	assert.ErrorContains(t, err, "available: local, minio, s3")
+
+	cfg.Set("storage.type", "s3")
+	cfg.Set("storage.s3.bucket", "gists")
+	t.Setenv("AWS_ACCESS_KEY_ID", "")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "")
+	_, err = New(cfg)
+	assert.ErrorContains(t, err, "access key")
+
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKIDEXAMPLE")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	bucket, err = NewRemote(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "https://s3.us-east-1.amazonaws.com/gists/", bucket.Describe())
+
+	cfg.Set("storage.type", "minio")
+	_, err = New(cfg)
+	assert.ErrorContains(t, err, "endpoint is required")
+	cfg.Set("storage.s3.endpoint", "http://minio:9000")
+	cfg.Set("storage.s3.prefix", "/casgists/")
+	bucket, err = New(cfg)
+	require.NoError(t, err)
+	assert.Equal(t, "minio", bucket.Name())
+	assert.Equal(t, "http://minio:9000/gists/casgists/", bucket.Describe())
+}
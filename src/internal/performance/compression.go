@@ -0,0 +1,335 @@
+package performance
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// defaultCompressionTypes are the content types compressed unless
+// performance.compression_types says otherwise. A * matches any run of
+// characters.
+var defaultCompressionTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/x-ndjson",
+	"application/javascript",
+	"application/xml",
+	"application/*+xml",
+	"image/svg+xml",
+}
+
+// compressor is what brotli.Writer, gzip.Writer and flate.Writer have in
+// common
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// encoding is a content encoding with a pool of its compressors
+type encoding struct {
+	name string
+	pool *sync.Pool
+}
+
+// CompressionMiddleware compresses responses with Brotli, gzip or deflate,
+// as the client's Accept-Encoding prefers. Only bodies whose content type matches
+// performance.compression_types are compressed, and only once they reach
+// performance.compression_min_size bytes; smaller ones are sent as they
+// are. Responses are compressed as they are written, so streamed bodies
+// are never held in memory whole.
+func CompressionMiddleware(cfg *config.Config) echo.MiddlewareFunc {
+	if cfg.IsSet("performance.compression_enabled") && !cfg.GetBool("performance.compression_enabled") {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+
+	level := cfg.GetInt("performance.compression_level")
+	if level == 0 || level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		level = gzip.DefaultCompression
+	}
+	// Brotli's levels run from 0 to 11 and take compression_level as it is
+	brotliLevel := brotli.DefaultCompression
+	if level > 0 {
+		brotliLevel = level
+	}
+	minSize := cfg.GetInt("performance.compression_min_size")
+	if minSize <= 0 {
+		minSize = 1024 // 1KB
+	}
+	types := cfg.GetStringSlice("performance.compression_types")
+	if len(types) == 0 {
+		types = defaultCompressionTypes
+	}
+
+	// In order of preference when the client accepts several equally
+	encodings := []encoding{
+		{name: "br", pool: &sync.Pool{New: func() interface{} {
+			return brotli.NewWriterLevel(io.Discard, brotliLevel)
+		}}},
+		{name: "gzip", pool: &sync.Pool{New: func() interface{} {
+			w, _ := gzip.NewWriterLevel(io.Discard, level)
+			return w
+		}}},
+		{name: "deflate", pool: &sync.Pool{New: func() interface{} {
+			w, _ := flate.NewWriter(io.Discard, level)
+			return w
+		}}},
+	}
+
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			req := c.Request()
+			// Event streams are flushed event by event
+			if req.Method == http.MethodHead || IsEventStream(req) {
+				return next(c)
+			}
+
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			enc := negotiateEncoding(req.Header.Get(echo.HeaderAcceptEncoding), encodings)
+			if enc == nil {
+				return next(c)
+			}
+
+			w := &compressWriter{
+				ResponseWriter: res.Writer,
+				encoding:       enc,
+				minSize:        minSize,
+				types:          types,
+			}
+			res.Writer = w
+			err := next(c)
+			if closeErr := w.Close(); err == nil {
+				err = closeErr
+			}
+			// An error is written by the error handler after this returns
+			res.Writer = w.ResponseWriter
+			return err
+		}
+	}
+}
+
+// negotiateEncoding returns the encoding with the highest q-value in an
+// Accept-Encoding header, or nil when the client accepts none of them
+func negotiateEncoding(header string, encodings []encoding) *encoding {
+	if header == "" {
+		return nil
+	}
+	accepted := make(map[string]float64)
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "x-gzip" {
+			name = "gzip"
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		accepted[name] = q
+	}
+
+	var best *encoding
+	bestQ := 0.0
+	for i := range encodings {
+		q, ok := accepted[encodings[i].name]
+		if !ok {
+			q = accepted["*"]
+		}
+		if q > bestQ {
+			best, bestQ = &encodings[i], q
+		}
+	}
+	return best
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// to compress it: at once when the content type or Content-Length rule it
+// out, otherwise once minSize bytes were written, or when the response
+// ends smaller than that
+type compressWriter struct {
+	http.ResponseWriter
+	encoding *encoding
+	minSize  int
+	types    []string
+	status   int
+	buf      []byte
+	started  bool
+	enc      compressor // Nil when the body is sent as it is
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	// Interim responses pass straight through
+	if w.started || code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+
+	header := w.Header()
+	switch {
+	case code == http.StatusNoContent || code == http.StatusNotModified || code == http.StatusPartialContent:
+		w.start(false)
+	case header.Get(echo.HeaderContentType) != "" && !w.compressible():
+		w.start(false)
+	default:
+		if length, err := strconv.Atoi(header.Get(echo.HeaderContentLength)); err == nil {
+			w.start(length >= w.minSize && w.compressible())
+		}
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.started {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) >= w.minSize {
+			if err := w.start(w.compressible()); err != nil {
+				return 0, err
+			}
+		}
+		return len(p), nil
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// compressible reports whether the response may be compressed. A response
+// without a content type gets the one net/http would sniff from it.
+func (w *compressWriter) compressible() bool {
+	header := w.Header()
+	if header.Get(echo.HeaderContentEncoding) != "" || header.Get("Content-Range") != "" {
+		return false
+	}
+	contentType := header.Get(echo.HeaderContentType)
+	if contentType == "" {
+		if len(w.buf) == 0 {
+			return false
+		}
+		contentType = http.DetectContentType(w.buf)
+		header.Set(echo.HeaderContentType, contentType)
+	}
+	mediaType, _, _ := strings.Cut(contentType, ";")
+	mediaType = strings.ToLower(strings.TrimSpace(mediaType))
+	if mediaType == "text/event-stream" {
+		return false
+	}
+	for _, pattern := range w.types {
+		if matchContentType(pattern, mediaType) {
+			return true
+		}
+	}
+	return false
+}
+
+// start sends the status and what was held back, compressed or not
+func (w *compressWriter) start(compress bool) error {
+	w.started = true
+	header := w.Header()
+	if compress {
+		header.Del(echo.HeaderContentLength)
+		header.Set(echo.HeaderContentEncoding, w.encoding.name)
+		// The compressed body is another representation of the same
+		// content, which a strong ETag would deny
+		if etag := header.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			header.Set("ETag", "W/"+etag)
+		}
+		w.enc = w.encoding.pool.Get().(compressor)
+		w.enc.Reset(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.enc != nil {
+		_, err = w.enc.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Close ends the response: sends it as it is if it stayed smaller than
+// minSize, or finishes the compressed stream
+func (w *compressWriter) Close() error {
+	if !w.started {
+		// Nothing was written; the error handler may yet respond
+		if w.status == 0 && len(w.buf) == 0 {
+			return nil
+		}
+		return w.start(false)
+	}
+	if w.enc == nil {
+		return nil
+	}
+	err := w.enc.Close()
+	w.enc.Reset(io.Discard)
+	w.encoding.pool.Put(w.enc)
+	w.enc = nil
+	return err
+}
+
+// Flush sends what was written so far; a response flushed before it
+// reached minSize is streaming and is compressed if its type allows
+func (w *compressWriter) Flush() {
+	if !w.started {
+		if w.status == 0 {
+			w.status = http.StatusOK
+		}
+		w.start(w.compressible())
+	}
+	if w.enc != nil {
+		w.enc.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack lets handlers take over the connection
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap lets http.ResponseController reach the connection, to set write
+// deadlines
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// matchContentType reports whether a media type matches a pattern, in
+// which a * matches any run of characters
+func matchContentType(pattern, mediaType string) bool {
+	prefix, suffix, wildcard := strings.Cut(strings.ToLower(pattern), "*")
+	if !wildcard {
+		return prefix == mediaType
+	}
+	return len(mediaType) >= len(prefix)+len(suffix) &&
+		strings.HasPrefix(mediaType, prefix) && strings.HasSuffix(mediaType, suffix)
+}
@@ -0,0 +1,63 @@
+package performance
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	encodings := []encoding{{name: "br"}, {name: "gzip"}, {name: "deflate"}}
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"br, gzip", "br"},
+		{"gzip, br", "br"},
+		{"gzip, deflate", "gzip"},
+		{"br;q=0.5, gzip", "gzip"},
+		{"br;q=0, gzip;q=0.1", "gzip"},
+		{"x-gzip", "gzip"},
+		{"*", "br"},
+		{"*;q=0.5, deflate", "deflate"},
+		{"identity", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if enc := negotiateEncoding(tt.header, encodings); enc != nil {
+			got = enc.name
+		}
+		assert.Equal(t, tt.want, got, "Accept-Encoding: %s", tt.header)
+	}
+}
+
+func TestCompressionMiddlewareBrotli(t *testing.T) {
+	body := `{"files":"` + strings.Repeat("hello, world ", 200) + `"}`
+	e := echo.New()
+	e.Use(CompressionMiddleware(config.New()))
+	e.GET("/gist", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, []byte(body))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/gist", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "br, gzip")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+
+	require.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "br", rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Less(t, rec.Body.Len(), len(body))
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	require.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+}
@@ -5,16 +5,16 @@ import (
 	"sync"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 )
 
 // GistCache provides high-performance caching for gists
 type GistCache struct {
 	db            *gorm.DB
-	cfg           *viper.Viper
+	cfg           *config.Config
 	memoryCache   *MemoryCache
 	queryCache    *QueryResultCache
 	popularCache  *PopularGistsCache
@@ -22,7 +22,7 @@ type GistCache struct {
 }
 
 // NewGistCache creates a new gist cache
-func NewGistCache(db *gorm.DB, cfg *viper.Viper) *GistCache {
+func NewGistCache(db *gorm.DB, cfg *config.Config) *GistCache {
 	return &GistCache{
 		db:           db,
 		cfg:          cfg,
@@ -165,7 +165,7 @@ type MemoryCache struct {
 }
 
 // NewMemoryCache creates a new memory cache
-func NewMemoryCache(cfg *viper.Viper) *MemoryCache {
+func NewMemoryCache(cfg *config.Config) *MemoryCache {
 	mc := &MemoryCache{
 		ttl: cfg.GetDuration("cache.memory_ttl"),
 	}
@@ -237,7 +237,7 @@ type QueryResultCache struct {
 }
 
 // NewQueryResultCache creates a new query result cache
-func NewQueryResultCache(cfg *viper.Viper) *QueryResultCache {
+func NewQueryResultCache(cfg *config.Config) *QueryResultCache {
 	return &QueryResultCache{
 		ttl: cfg.GetDuration("cache.query_ttl"),
 	}
@@ -311,7 +311,7 @@ type queryCacheEntry struct {
 // PopularGistsCache tracks and caches popular gists
 type PopularGistsCache struct {
 	db          *gorm.DB
-	cfg         *viper.Viper
+	cfg         *config.Config
 	accessCount sync.Map
 	topGists    []models.Gist
 	mu          sync.RWMutex
@@ -319,7 +319,7 @@ type PopularGistsCache struct {
 }
 
 // NewPopularGistsCache creates a new popular gists cache
-func NewPopularGistsCache(db *gorm.DB, cfg *viper.Viper) *PopularGistsCache {
+func NewPopularGistsCache(db *gorm.DB, cfg *config.Config) *PopularGistsCache {
 	pc := &PopularGistsCache{
 		db:       db,
 		cfg:      cfg,
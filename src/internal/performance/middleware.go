@@ -2,42 +2,18 @@ package performance
 
 import (
 	"bytes"
-	"compress/gzip"
 	"fmt"
 	"net/http"
 	"strings"
 	"sync"
 
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
-	"github.com/spf13/viper"
 )
 
-// CompressionMiddleware returns gzip compression middleware
-func CompressionMiddleware(cfg *viper.Viper) echo.MiddlewareFunc {
-	level := cfg.GetInt("performance.compression_level")
-	if level == 0 {
-		level = gzip.DefaultCompression
-	}
-
-	minSize := cfg.GetInt("performance.compression_min_size")
-	if minSize == 0 {
-		minSize = 1024 // 1KB
-	}
-
-	return middleware.GzipWithConfig(middleware.GzipConfig{
-		Level: level,
-		MinLength: minSize,
-		Skipper: func(c echo.Context) bool {
-			// Skip compression for already compressed content
-			contentType := c.Response().Header().Get("Content-Type")
-			return strings.Contains(contentType, "image/") ||
-				strings.Contains(contentType, "video/") ||
-				strings.Contains(contentType, "audio/") ||
-				strings.HasSuffix(c.Request().URL.Path, ".woff") ||
-				strings.HasSuffix(c.Request().URL.Path, ".woff2")
-		},
-	})
+// IsEventStream reports whether a request asks for server-sent events,
+// which must be neither compressed nor buffered
+func IsEventStream(r *http.Request) bool {
+	return strings.Contains(r.Header.Get("Accept"), "text/event-stream")
 }
 
 // CacheControlMiddleware adds cache headers for static resources
@@ -51,10 +27,16 @@ func CacheControlMiddleware() echo.MiddlewareFunc {
 				// Static assets - cache for 1 year
 				c.Response().Header().Set("Cache-Control", "public, max-age=31536000, immutable")
 			} else if strings.HasPrefix(path, "/api/") {
-				// API responses - no cache
-				c.Response().Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
-				c.Response().Header().Set("Pragma", "no-cache")
-				c.Response().Header().Set("Expires", "0")
+				// API responses - no cache, unless the handler chose a
+				// policy, such as revalidating a gist with its ETag
+				res := c.Response()
+				res.Before(func() {
+					if res.Header().Get("Cache-Control") == "" {
+						res.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+						res.Header().Set("Pragma", "no-cache")
+						res.Header().Set("Expires", "0")
+					}
+				})
 			} else if strings.HasSuffix(path, ".html") || path == "/" {
 				// HTML pages - cache for 5 minutes
 				c.Response().Header().Set("Cache-Control", "public, max-age=300")
@@ -217,6 +199,11 @@ func ResponseBufferingMiddleware(maxSize int) echo.MiddlewareFunc {
 
 	return func(next echo.HandlerFunc) echo.HandlerFunc {
 		return func(c echo.Context) error {
+			// Event streams must reach the client as they are written
+			if IsEventStream(c.Request()) {
+				return next(c)
+			}
+
 			// Create buffered response writer
 			w := &bufferedResponseWriter{
 				ResponseWriter: c.Response().Writer,
@@ -227,6 +214,11 @@ func ResponseBufferingMiddleware(maxSize int) echo.MiddlewareFunc {
 
 			// Process request
 			if err := next(c); err != nil {
+				// The error handler writes its response once this returns,
+				// straight to the client rather than into a buffer nothing
+				// flushes
+				w.Flush()
+				c.Response().Writer = w.ResponseWriter
 				return err
 			}
 
@@ -236,12 +228,31 @@ func ResponseBufferingMiddleware(maxSize int) echo.MiddlewareFunc {
 	}
 }
 
-// bufferedResponseWriter buffers response up to maxSize
+// bufferedResponseWriter buffers response up to maxSize. The status is
+// held back with the body, so that Content-Length is set before writers
+// further down, such as the gzip middleware's, see the headers.
 type bufferedResponseWriter struct {
 	http.ResponseWriter
 	buffer     *bytes.Buffer
 	maxSize    int
 	unbuffered bool
+	status     int
+}
+
+func (w *bufferedResponseWriter) WriteHeader(code int) {
+	if w.unbuffered {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+// writeHeader passes on a held back status
+func (w *bufferedResponseWriter) writeHeader() {
+	if w.status != 0 {
+		w.ResponseWriter.WriteHeader(w.status)
+		w.status = 0
+	}
 }
 
 func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
@@ -253,6 +264,7 @@ func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
 	if w.buffer.Len()+len(p) > w.maxSize {
 		// Switch to unbuffered mode
 		w.unbuffered = true
+		w.writeHeader()
 		
 		// Write buffered content
 		if w.buffer.Len() > 0 {
@@ -269,14 +281,23 @@ func (w *bufferedResponseWriter) Write(p []byte) (int, error) {
 	return w.buffer.Write(p)
 }
 
+// Unwrap lets http.ResponseController reach the connection, to set write
+// deadlines
+func (w *bufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (w *bufferedResponseWriter) Flush() error {
 	if !w.unbuffered && w.buffer.Len() > 0 {
-		// Set Content-Length header
+		// Set Content-Length header; a compressing writer below drops it
+		// when it changes the length
 		w.Header().Set("Content-Length", fmt.Sprintf("%d", w.buffer.Len()))
+		w.writeHeader()
 		
 		// Write buffered content
 		_, err := w.ResponseWriter.Write(w.buffer.Bytes())
 		return err
 	}
+	w.writeHeader()
 	return nil
 }
\ No newline at end of file
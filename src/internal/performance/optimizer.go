@@ -7,14 +7,15 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // Optimizer manages performance optimizations
 type Optimizer struct {
 	db              *gorm.DB
-	cfg             *viper.Viper
+	cfg             *config.Config
 	queryCache      *QueryCache
 	connectionPool  *ConnectionPool
 	resourceLimiter *ResourceLimiter
@@ -22,7 +23,7 @@ type Optimizer struct {
 }
 
 // NewOptimizer creates a new performance optimizer
-func NewOptimizer(db *gorm.DB, cfg *viper.Viper) *Optimizer {
+func NewOptimizer(db *gorm.DB, cfg *config.Config) *Optimizer {
 	return &Optimizer{
 		db:              db,
 		cfg:             cfg,
@@ -34,36 +35,8 @@ func NewOptimizer(db *gorm.DB, cfg *viper.Viper) *Optimizer {
 
 // OptimizeDatabase applies database optimizations
 func (o *Optimizer) OptimizeDatabase() error {
-	// Set connection pool settings
-	sqlDB, err := o.db.DB()
-	if err != nil {
-		return err
-	}
-
-	// Configure connection pool
-	maxOpenConns := o.cfg.GetInt("database.max_open_connections")
-	if maxOpenConns == 0 {
-		maxOpenConns = runtime.NumCPU() * 2
-	}
-	sqlDB.SetMaxOpenConns(maxOpenConns)
-
-	maxIdleConns := o.cfg.GetInt("database.max_idle_connections")
-	if maxIdleConns == 0 {
-		maxIdleConns = runtime.NumCPU()
-	}
-	sqlDB.SetMaxIdleConns(maxIdleConns)
-
-	connMaxLifetime := o.cfg.GetDuration("database.connection_max_lifetime")
-	if connMaxLifetime == 0 {
-		connMaxLifetime = time.Hour
-	}
-	sqlDB.SetConnMaxLifetime(connMaxLifetime)
-
-	connMaxIdleTime := o.cfg.GetDuration("database.connection_max_idle_time")
-	if connMaxIdleTime == 0 {
-		connMaxIdleTime = 10 * time.Minute
-	}
-	sqlDB.SetConnMaxIdleTime(connMaxIdleTime)
+	// The connection pool is configured by database.Initialize from the
+	// database.* settings
 
 	// Enable prepared statements globally
 	o.db = o.db.Session(&gorm.Session{
@@ -117,7 +90,7 @@ type QueryCache struct {
 }
 
 // NewQueryCache creates a new query cache
-func NewQueryCache(cfg *viper.Viper) *QueryCache {
+func NewQueryCache(cfg *config.Config) *QueryCache {
 	ttl := cfg.GetDuration("performance.query_cache_ttl")
 	if ttl == 0 {
 		ttl = 5 * time.Minute
@@ -185,7 +158,7 @@ type ConnectionPool struct {
 }
 
 // NewConnectionPool creates a new connection pool
-func NewConnectionPool(cfg *viper.Viper) *ConnectionPool {
+func NewConnectionPool(cfg *config.Config) *ConnectionPool {
 	return &ConnectionPool{
 		maxSize:     cfg.GetInt("database.pool_size_max"),
 		minSize:     cfg.GetInt("database.pool_size_min"),
@@ -210,7 +183,7 @@ type ResourceLimiter struct {
 }
 
 // NewResourceLimiter creates a new resource limiter
-func NewResourceLimiter(cfg *viper.Viper) *ResourceLimiter {
+func NewResourceLimiter(cfg *config.Config) *ResourceLimiter {
 	return &ResourceLimiter{
 		maxCPU:      cfg.GetFloat64("performance.max_cpu_percent"),
 		maxMemory:   cfg.GetUint64("performance.max_memory_mb") * 1024 * 1024,
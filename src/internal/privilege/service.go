@@ -7,12 +7,12 @@ import (
 	"runtime"
 	"strings"
 
-	"github.com/spf13/viper"
+	"github.com/casapps/casgists/src/internal/config"
 )
 
 // EscalationService handles privilege escalation operations
 type EscalationService struct {
-	cfg *viper.Viper
+	cfg *config.Config
 }
 
 // EscalationMethod represents different ways to escalate privileges
@@ -59,7 +59,7 @@ type PrivilegeResult struct {
 }
 
 // NewEscalationService creates a new privilege escalation service
-func NewEscalationService(cfg *viper.Viper) *EscalationService {
+func NewEscalationService(cfg *config.Config) *EscalationService {
 	return &EscalationService{
 		cfg: cfg,
 	}
@@ -7,7 +7,6 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
-	"syscall"
 )
 
 // EscalationResult represents the result of privilege escalation attempt
@@ -187,22 +186,6 @@ func reexecuteWithUAC() (bool, error) {
 	return false, fmt.Errorf("UAC elevation failed: %w", err)
 }
 
-// isWindowsAdmin checks if running as administrator on Windows
-func isWindowsAdmin() bool {
-	// This is a simplified implementation
-	// In a full implementation, you'd use Windows APIs to check token privileges
-	
-	// Try to create a file in a restricted location as a test
-	testPath := `C:\Windows\Temp\casgists-admin-test`
-	err := os.WriteFile(testPath, []byte("test"), 0644)
-	if err == nil {
-		os.Remove(testPath)
-		return true
-	}
-	
-	return false
-}
-
 // CreateSystemUser creates a system user for CasGists (Unix only)
 func CreateSystemUser() error {
 	if runtime.GOOS == "windows" {
@@ -436,13 +419,5 @@ func DropPrivileges(username string) error {
 	}
 
 	// Drop privileges
-	if err := syscall.Setgid(gid); err != nil {
-		return fmt.Errorf("failed to set GID: %w", err)
-	}
-
-	if err := syscall.Setuid(uid); err != nil {
-		return fmt.Errorf("failed to set UID: %w", err)
-	}
-
-	return nil
+	return setIDs(uid, gid)
 }
\ No newline at end of file
@@ -0,0 +1,27 @@
+//go:build !windows
+
+package privileges
+
+import (
+	"fmt"
+	"syscall"
+)
+
+// setIDs switches the process to another user and group, the group first
+// while it is still allowed
+func setIDs(uid, gid int) error {
+	if err := syscall.Setgid(gid); err != nil {
+		return fmt.Errorf("failed to set GID: %w", err)
+	}
+
+	if err := syscall.Setuid(uid); err != nil {
+		return fmt.Errorf("failed to set UID: %w", err)
+	}
+
+	return nil
+}
+
+// isWindowsAdmin is never true outside Windows
+func isWindowsAdmin() bool {
+	return false
+}
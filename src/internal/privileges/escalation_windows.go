@@ -0,0 +1,22 @@
+//go:build windows
+
+package privileges
+
+import (
+	"errors"
+
+	"golang.org/x/sys/windows"
+)
+
+// setIDs isn't used on Windows, where DropPrivileges returns early; a
+// service runs as the account the service manager starts it as
+func setIDs(uid, gid int) error {
+	return errors.New("switching users is not supported on Windows")
+}
+
+// isWindowsAdmin reports whether the process token is elevated, which is
+// the case under UAC "Run as administrator" and for services running as
+// LocalSystem
+func isWindowsAdmin() bool {
+	return windows.GetCurrentProcessToken().IsElevated()
+}
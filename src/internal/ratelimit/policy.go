@@ -0,0 +1,96 @@
+package ratelimit
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Policy names
+const (
+	PolicyLogin         = "login"
+	PolicyRegister      = "register"
+	PolicyPasswordReset = "password_reset"
+	PolicyAPI           = "api"
+	PolicyRaw           = "raw"
+)
+
+// Policy limits the requests to a group of routes. Every request counts
+// against its client IP's bucket; a request carrying a token also counts
+// against the token's bucket. Either limit can be 0 to leave that bucket
+// out.
+type Policy struct {
+	Name     string
+	PerIP    int
+	PerToken int
+	Window   time.Duration
+}
+
+// Active reports whether the policy limits anything
+func (p Policy) Active() bool {
+	return p.Window > 0 && (p.PerIP > 0 || p.PerToken > 0)
+}
+
+// PoliciesFromConfig reads the policies from ratelimit.*. Sign in,
+// registration and password reset are limited per IP only, since their
+// callers have no token yet.
+func PoliciesFromConfig(cfg *config.Config) map[string]Policy {
+	return map[string]Policy{
+		PolicyLogin: {
+			Name:   PolicyLogin,
+			PerIP:  cfg.GetInt("ratelimit.login_attempts"),
+			Window: minutes(cfg.GetString("ratelimit.login_window")),
+		},
+		PolicyRegister: {
+			Name:   PolicyRegister,
+			PerIP:  cfg.GetInt("ratelimit.register_attempts"),
+			Window: cfg.GetDuration("ratelimit.register_window"),
+		},
+		PolicyPasswordReset: {
+			Name:   PolicyPasswordReset,
+			PerIP:  cfg.GetInt("ratelimit.password_reset_attempts"),
+			Window: cfg.GetDuration("ratelimit.password_reset_window"),
+		},
+		PolicyAPI: {
+			Name:     PolicyAPI,
+			PerIP:    cfg.GetInt("ratelimit.api_per_ip"),
+			PerToken: cfg.GetInt("ratelimit.api_per_token"),
+			Window:   cfg.GetDuration("ratelimit.api_window"),
+		},
+		PolicyRaw: {
+			Name:     PolicyRaw,
+			PerIP:    cfg.GetInt("ratelimit.raw_per_ip"),
+			PerToken: cfg.GetInt("ratelimit.raw_per_token"),
+			Window:   cfg.GetDuration("ratelimit.raw_window"),
+		},
+	}
+}
+
+// minutes reads ratelimit.login_window, which predates duration strings
+// and is a number of minutes when it has no unit
+func minutes(value string) time.Duration {
+	value = strings.TrimSpace(value)
+	if n, err := strconv.Atoi(value); err == nil {
+		return time.Duration(n) * time.Minute
+	}
+	d, _ := time.ParseDuration(value)
+	return d
+}
+
+// SetHeaders describes a bucket in the RateLimit-* headers of the IETF
+// rate limit headers draft: the limit, the requests remaining, the seconds
+// until the window resets, and the policy as "limit;w=seconds".
+func SetHeaders(header http.Header, result Result, window time.Duration, now time.Time) {
+	reset := int(result.Reset.Sub(now).Round(time.Second).Seconds())
+	if reset < 0 {
+		reset = 0
+	}
+	header.Set("RateLimit-Limit", strconv.Itoa(result.Limit))
+	header.Set("RateLimit-Remaining", strconv.Itoa(result.Remaining))
+	header.Set("RateLimit-Reset", strconv.Itoa(reset))
+	header.Set("RateLimit-Policy", fmt.Sprintf("%d;w=%d", result.Limit, int(window.Seconds())))
+}
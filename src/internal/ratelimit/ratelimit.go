@@ -0,0 +1,100 @@
+// Package ratelimit counts requests in fixed windows. Counters live in
+// process memory, or in Redis when redis.enabled is set so every instance
+// behind a load balancer shares them.
+package ratelimit
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Result is a bucket's state after a request was counted against it
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	Reset     time.Time // When the window ends and the bucket is full again
+}
+
+// RetryAfter is how long a refused client should wait, rounded up to whole
+// seconds
+func (r Result) RetryAfter(now time.Time) time.Duration {
+	wait := r.Reset.Sub(now)
+	if wait < 0 {
+		return 0
+	}
+	return wait.Truncate(time.Second) + time.Second
+}
+
+// Store counts requests per key in fixed windows
+type Store interface {
+	// Take counts a request against key, whose window is window long and
+	// allows limit requests
+	Take(ctx context.Context, key string, limit int, window time.Duration) (Result, error)
+}
+
+// NewStore returns a Redis store when redis.enabled is set and Redis can be
+// reached, and a memory store otherwise
+func NewStore(cfg *config.Config) Store {
+	if cfg.GetBool("redis.enabled") {
+		store, err := NewRedisStore(cfg)
+		if err == nil {
+			return store
+		}
+		slog.Warn("Rate limits are kept in memory", "error", err)
+	}
+	return NewMemoryStore()
+}
+
+// MemoryStore keeps counters in process memory. Each instance counts on
+// its own.
+type MemoryStore struct {
+	now func() time.Time
+
+	mu        sync.Mutex
+	windows   map[string]*window
+	lastSweep time.Time
+}
+
+type window struct {
+	count int
+	reset time.Time
+}
+
+// NewMemoryStore creates an empty memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{now: time.Now, windows: make(map[string]*window)}
+}
+
+// Take counts a request against key. Refused requests aren't counted.
+func (s *MemoryStore) Take(_ context.Context, key string, limit int, length time.Duration) (Result, error) {
+	now := s.now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Drop expired windows now and then so idle clients don't pile up
+	if now.Sub(s.lastSweep) >= time.Minute {
+		for k, w := range s.windows {
+			if !now.Before(w.reset) {
+				delete(s.windows, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	w, ok := s.windows[key]
+	if !ok || !now.Before(w.reset) {
+		w = &window{reset: now.Add(length)}
+		s.windows[key] = w
+	}
+	if w.count >= limit {
+		return Result{Limit: limit, Reset: w.reset}, nil
+	}
+	w.count++
+	return Result{Allowed: true, Limit: limit, Remaining: limit - w.count, Reset: w.reset}, nil
+}
@@ -0,0 +1,73 @@
+package ratelimit
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestMemoryStore(t *testing.T) {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	store := NewMemoryStore()
+	store.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	for want := 1; want >= 0; want-- {
+		result, err := store.Take(ctx, "a", 2, time.Minute)
+		require.NoError(t, err)
+		assert.True(t, result.Allowed)
+		assert.Equal(t, want, result.Remaining)
+		assert.Equal(t, now.Add(time.Minute), result.Reset)
+	}
+
+	result, err := store.Take(ctx, "a", 2, time.Minute)
+	require.NoError(t, err)
+	assert.False(t, result.Allowed)
+	assert.Equal(t, 0, result.Remaining)
+	assert.Equal(t, 31*time.Second, result.RetryAfter(now.Add(29*time.Second+500*time.Millisecond)))
+
+	// Other keys have their own bucket
+	result, _ = store.Take(ctx, "b", 2, time.Minute)
+	assert.True(t, result.Allowed)
+
+	// The bucket is full again once the window is over
+	now = now.Add(time.Minute)
+	result, _ = store.Take(ctx, "a", 2, time.Minute)
+	assert.True(t, result.Allowed)
+	assert.Equal(t, 1, result.Remaining)
+}
+
+func TestPoliciesFromConfig(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("ratelimit.login_attempts", 5)
+	cfg.Set("ratelimit.login_window", 15)
+	cfg.Set("ratelimit.api_per_ip", 100)
+	cfg.Set("ratelimit.api_per_token", 50)
+	cfg.Set("ratelimit.api_window", "1m")
+
+	policies := PoliciesFromConfig(cfg)
+	assert.Equal(t, Policy{Name: PolicyLogin, PerIP: 5, Window: 15 * time.Minute}, policies[PolicyLogin])
+	assert.Equal(t, Policy{Name: PolicyAPI, PerIP: 100, PerToken: 50, Window: time.Minute}, policies[PolicyAPI])
+	assert.True(t, policies[PolicyAPI].Active())
+	assert.False(t, policies[PolicyRaw].Active())
+
+	cfg.Set("ratelimit.login_window", "1h")
+	assert.Equal(t, time.Hour, PoliciesFromConfig(cfg)[PolicyLogin].Window)
+}
+
+func TestSetHeaders(t *testing.T) {
+	now := time.Now()
+	header := http.Header{}
+	SetHeaders(header, Result{Allowed: true, Limit: 100, Remaining: 42, Reset: now.Add(30 * time.Second)}, time.Minute, now)
+
+	assert.Equal(t, "100", header.Get("RateLimit-Limit"))
+	assert.Equal(t, "42", header.Get("RateLimit-Remaining"))
+	assert.Equal(t, "30", header.Get("RateLimit-Reset"))
+	assert.Equal(t, "100;w=60", header.Get("RateLimit-Policy"))
+}
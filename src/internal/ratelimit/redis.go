@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// takeScript increments a counter and starts its window on the first
+// request, in one round trip. It returns the count and the milliseconds
+// left in the window.
+var takeScript = redis.NewScript(`
+local count = redis.call("INCR", KEYS[1])
+local ttl = redis.call("PTTL", KEYS[1])
+if ttl < 0 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+	ttl = tonumber(ARGV[1])
+end
+return {count, ttl}
+`)
+
+// RedisStore keeps counters in Redis, shared by every instance
+type RedisStore struct {
+	client *redis.Client
+	prefix string
+}
+
+// NewRedisStore connects to the Redis server of redis.addr
+func NewRedisStore(cfg *config.Config) (*RedisStore, error) {
+	addr := cfg.GetString("redis.addr")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+	prefix := cfg.GetString("cache.key_prefix")
+	if prefix == "" {
+		prefix = "casgists:"
+	}
+
+	client := redis.NewClient(&redis.Options{
+		Addr:         addr,
+		Password:     cfg.GetString("redis.password"),
+		DB:           cfg.GetInt("redis.db"),
+		DialTimeout:  time.Second * 5,
+		ReadTimeout:  time.Second,
+		WriteTimeout: time.Second,
+	})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	if err := client.Ping(ctx).Err(); err != nil {
+		client.Close()
+		return nil, fmt.Errorf("failed to connect to Redis: %w", err)
+	}
+	return &RedisStore{client: client, prefix: prefix + "ratelimit:"}, nil
+}
+
+// Take counts a request against key. Refused requests are counted too,
+// which doesn't change the outcome and saves a round trip.
+func (s *RedisStore) Take(ctx context.Context, key string, limit int, length time.Duration) (Result, error) {
+	values, err := takeScript.Run(ctx, s.client, []string{s.prefix + key}, length.Milliseconds()).Int64Slice()
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to count request: %w", err)
+	}
+	if len(values) != 2 {
+		return Result{}, fmt.Errorf("failed to count request: unexpected reply %v", values)
+	}
+
+	count, ttl := int(values[0]), time.Duration(values[1])*time.Millisecond
+	result := Result{Limit: limit, Reset: time.Now().Add(ttl)}
+	if count <= limit {
+		result.Allowed = true
+		result.Remaining = limit - count
+	}
+	return result, nil
+}
+
+// Close closes the Redis connection
+func (s *RedisStore) Close() error {
+	return s.client.Close()
+}
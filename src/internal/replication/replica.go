@@ -0,0 +1,264 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Replica is where snapshots and WAL segments are shipped to. Objects are
+// addressed by slash separated names such as
+// "generations/<generation>/wal/<segment>.wal.gz" and are transferred as
+// local files, so large snapshots never have to fit in memory.
+type Replica interface {
+	// Describe returns a human readable description of the target
+	Describe() string
+	// Put uploads the local file to name
+	Put(ctx context.Context, name, localPath string) error
+	// Get downloads name to the local file
+	Get(ctx context.Context, name, localPath string) error
+	// List returns the names of all objects starting with prefix
+	List(ctx context.Context, prefix string) ([]string, error)
+	// Delete removes name; deleting a missing object is not an error
+	Delete(ctx context.Context, name string) error
+}
+
+// NewReplicaFromConfig builds the replica configured under replication.*.
+// Upload hooks take precedence over a target directory.
+func NewReplicaFromConfig(cfg *config.Config) (Replica, error) {
+	hooks := HookReplica{
+		Upload:    cfg.GetString("replication.hooks.upload"),
+		Download:  cfg.GetString("replication.hooks.download"),
+		ListCmd:   cfg.GetString("replication.hooks.list"),
+		DeleteCmd: cfg.GetString("replication.hooks.delete"),
+		Timeout:   cfg.GetDuration("replication.hooks.timeout"),
+	}
+	if hooks.Upload != "" {
+		if hooks.Download == "" || hooks.ListCmd == "" {
+			return nil, fmt.Errorf("replication.hooks.download and replication.hooks.list are required with replication.hooks.upload")
+		}
+		return &hooks, nil
+	}
+
+	if target := cfg.GetString("replication.target"); target != "" {
+		return &FileReplica{Root: target}, nil
+	}
+
+	return nil, fmt.Errorf("replication is enabled but neither replication.target nor replication.hooks.upload is set")
+}
+
+// FileReplica stores objects in a directory, typically a mount of another
+// host (NFS, SSHFS) or a disk that is synced elsewhere
+type FileReplica struct {
+	Root string
+}
+
+// Describe returns the target directory
+func (r *FileReplica) Describe() string {
+	return "file://" + r.Root
+}
+
+// Put copies the file into the replica directory. The copy is written under
+// a temporary name and renamed, so readers never see a partial object.
+func (r *FileReplica) Put(ctx context.Context, name, localPath string) error {
+	dest, err := r.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(dest), 0750); err != nil {
+		return fmt.Errorf("failed to create replica directory: %w", err)
+	}
+	tmp := dest + ".tmp"
+	if err := copyFile(localPath, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, dest); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to store %s: %w", name, err)
+	}
+	return nil
+}
+
+// Get copies an object out of the replica directory
+func (r *FileReplica) Get(ctx context.Context, name, localPath string) error {
+	src, err := r.path(name)
+	if err != nil {
+		return err
+	}
+	return copyFile(src, localPath)
+}
+
+// List walks the replica directory for objects under prefix
+func (r *FileReplica) List(ctx context.Context, prefix string) ([]string, error) {
+	var names []string
+	err := filepath.Walk(r.Root, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(p, ".tmp") {
+			return nil
+		}
+		rel, err := filepath.Rel(r.Root, p)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list replica: %w", err)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete removes an object and any directories left empty
+func (r *FileReplica) Delete(ctx context.Context, name string) error {
+	p, err := r.path(name)
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	for dir := filepath.Dir(p); dir != filepath.Clean(r.Root); dir = filepath.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+func (r *FileReplica) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" {
+		return "", fmt.Errorf("invalid object name %q", name)
+	}
+	return filepath.Join(r.Root, filepath.FromSlash(clean)), nil
+}
+
+// HookReplica ships objects with external commands, which is how S3, GCS,
+// SFTP or anything rclone supports is reached without building the SDKs in.
+// Each command runs through sh -c with {file}, {name} and {prefix} replaced
+// by shell quoted values, for example
+//
+//	aws s3 cp {file} s3://my-bucket/casgists/{name}
+//
+// The list command must print one object name per line, relative to the
+// same root the upload command writes to.
+type HookReplica struct {
+	Upload    string
+	Download  string
+	ListCmd   string
+	DeleteCmd string
+	Timeout   time.Duration
+}
+
+// Describe returns the upload command, which identifies the target
+func (r *HookReplica) Describe() string {
+	return "hook: " + r.Upload
+}
+
+// Put runs the upload command
+func (r *HookReplica) Put(ctx context.Context, name, localPath string) error {
+	_, err := r.run(ctx, r.Upload, map[string]string{"file": localPath, "name": name})
+	return err
+}
+
+// Get runs the download command
+func (r *HookReplica) Get(ctx context.Context, name, localPath string) error {
+	_, err := r.run(ctx, r.Download, map[string]string{"file": localPath, "name": name})
+	return err
+}
+
+// List runs the list command and keeps the names under prefix
+func (r *HookReplica) List(ctx context.Context, prefix string) ([]string, error) {
+	out, err := r.run(ctx, r.ListCmd, map[string]string{"prefix": prefix})
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, line := range strings.Split(string(out), "\n") {
+		name := strings.TrimSpace(line)
+		if name != "" && strings.HasPrefix(name, prefix) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Delete runs the delete command. Without one, retention is left to the
+// storage provider's lifecycle rules.
+func (r *HookReplica) Delete(ctx context.Context, name string) error {
+	if r.DeleteCmd == "" {
+		return nil
+	}
+	_, err := r.run(ctx, r.DeleteCmd, map[string]string{"name": name})
+	return err
+}
+
+func (r *HookReplica) run(ctx context.Context, command string, vars map[string]string) ([]byte, error) {
+	timeout := r.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Minute
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	for key, value := range vars {
+		command = strings.ReplaceAll(command, "{"+key+"}", shellQuote(value))
+	}
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("replication hook failed: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func copyFile(src, dest string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", src, err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", dest, err)
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to copy %s: %w", src, err)
+	}
+	if err := out.Sync(); err != nil {
+		out.Close()
+		return fmt.Errorf("failed to sync %s: %w", dest, err)
+	}
+	return out.Close()
+}
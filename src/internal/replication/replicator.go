@@ -0,0 +1,571 @@
+// Package replication continuously ships the SQLite write-ahead log to a
+// replica, so small installs get point-in-time recovery without moving to
+// Postgres.
+//
+// The replicator disables SQLite's automatic checkpoints and owns them
+// instead. Under the database write lock it copies committed WAL frames
+// into compressed segments, and once enough frames are shipped it
+// checkpoints them into the database file. Each run of the WAL between two
+// restarts is one index; a snapshot of the database file plus the segments
+// of every index that follows it make up a generation. A new generation
+// starts on every server start, every snapshot interval, and whenever a gap
+// in the WAL stream is detected.
+package replication
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// errWALReset means the WAL was restarted or truncated before all of its
+// frames were shipped, so the stream has a gap
+var errWALReset = errors.New("WAL was reset outside of the replicator")
+
+// Status describes the state of replication, as shown in /healthz
+type Status struct {
+	Enabled         bool       `json:"enabled"`
+	Healthy         bool       `json:"healthy"`
+	Target          string     `json:"target"`
+	Generation      string     `json:"generation,omitempty"`
+	WALIndex        uint32     `json:"wal_index"`
+	WALOffset       int64      `json:"wal_offset"`
+	PendingSegments int        `json:"pending_segments"`
+	LagSeconds      float64    `json:"lag_seconds"`
+	LastSyncAt      *time.Time `json:"last_sync_at,omitempty"`
+	LastSnapshotAt  *time.Time `json:"last_snapshot_at,omitempty"`
+	LastError       string     `json:"last_error,omitempty"`
+	LastErrorAt     *time.Time `json:"last_error_at,omitempty"`
+}
+
+// Replicator ships the WAL of a SQLite database to a replica
+type Replicator struct {
+	db      *gorm.DB
+	config  *config.Config
+	replica Replica
+
+	mu        sync.RWMutex
+	status    Status
+	startedAt time.Time
+
+	// The fields below are only touched by the sync loop
+	conn       *sql.Conn
+	ckptConn   *sql.Conn
+	dbPath     string
+	tmpDir     string
+	generation string
+	snapshotAt time.Time
+	index      uint32
+	offset     int64
+	header     walHeader
+	// checkpointed is set once every frame of the current WAL is both
+	// shipped and checkpointed, so a WAL restart starts the next index
+	// instead of breaking the stream
+	checkpointed bool
+	pending      []segment
+
+	stop chan bool
+	done chan struct{}
+}
+
+// NewReplicator creates a replicator for the database using the replica
+// configured under replication.*
+func NewReplicator(db *gorm.DB, cfg *config.Config) (*Replicator, error) {
+	if t := cfg.GetString("database.type"); t != "sqlite" && t != "" {
+		return nil, fmt.Errorf("replication only supports SQLite, not %s", t)
+	}
+	replica, err := NewReplicaFromConfig(cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewReplicatorWithReplica(db, cfg, replica), nil
+}
+
+// NewReplicatorWithReplica creates a replicator shipping to the given replica
+func NewReplicatorWithReplica(db *gorm.DB, cfg *config.Config, replica Replica) *Replicator {
+	return &Replicator{
+		db:      db,
+		config:  cfg,
+		replica: replica,
+		status: Status{
+			Enabled: true,
+			Target:  replica.Describe(),
+		},
+		stop: make(chan bool, 1),
+		done: make(chan struct{}),
+	}
+}
+
+// Start runs the sync loop until the context is cancelled or Stop is
+// called. The first sync takes a snapshot and starts a new generation.
+func (r *Replicator) Start(ctx context.Context) {
+	defer close(r.done)
+	defer r.close()
+
+	r.mu.Lock()
+	r.startedAt = time.Now()
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(r.syncInterval())
+	defer ticker.Stop()
+
+	r.tick(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.stop:
+			// Ship whatever was committed since the last tick
+			r.tick(context.Background())
+			return
+		case <-ticker.C:
+			r.tick(ctx)
+		}
+	}
+}
+
+// Stop stops the sync loop after a final sync. It waits a few seconds for
+// the final sync so that shutdown doesn't lose the last writes.
+func (r *Replicator) Stop() {
+	select {
+	case r.stop <- true:
+	default:
+	}
+
+	r.mu.RLock()
+	started := !r.startedAt.IsZero()
+	r.mu.RUnlock()
+	if !started {
+		return
+	}
+	select {
+	case <-r.done:
+	case <-time.After(10 * time.Second):
+	}
+}
+
+// Status returns the current replication status
+func (r *Replicator) Status() Status {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	status := r.status
+	since := r.startedAt
+	if status.LastSyncAt != nil {
+		since = *status.LastSyncAt
+	}
+	if !since.IsZero() {
+		status.LagSeconds = time.Since(since).Seconds()
+	}
+	status.Healthy = status.LastSyncAt != nil && status.LagSeconds <= r.maxLag().Seconds()
+	return status
+}
+
+// Sync ships everything committed so far. It is what the loop runs on
+// every tick, and is exported for tests and one-off syncs.
+func (r *Replicator) Sync(ctx context.Context) error {
+	if err := r.open(ctx); err != nil {
+		return err
+	}
+
+	if r.generation == "" || time.Since(r.snapshotAt) >= r.snapshotInterval() {
+		return r.snapshot(ctx)
+	}
+
+	if err := r.capture(ctx); err != nil {
+		if !errors.Is(err, errWALReset) {
+			return err
+		}
+		slog.Warn("Replication starting a new generation", "error", err)
+		return r.snapshot(ctx)
+	}
+
+	return r.flush(ctx)
+}
+
+func (r *Replicator) tick(ctx context.Context) {
+	err := r.Sync(ctx)
+	now := time.Now().UTC()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status.Generation = r.generation
+	r.status.WALIndex = r.index
+	r.status.WALOffset = r.offset
+	r.status.PendingSegments = len(r.pending)
+	if !r.snapshotAt.IsZero() {
+		snapshotAt := r.snapshotAt
+		r.status.LastSnapshotAt = &snapshotAt
+	}
+	if err != nil {
+		if r.status.LastError != err.Error() {
+			slog.Error("Replication sync failed", "error", err)
+		}
+		r.status.LastError = err.Error()
+		r.status.LastErrorAt = &now
+		return
+	}
+	r.status.LastError = ""
+	r.status.LastSyncAt = &now
+}
+
+// open grabs the two connections the replicator needs: one to hold the
+// write lock while the WAL is read, and one to checkpoint while it is held
+func (r *Replicator) open(ctx context.Context) error {
+	if r.conn != nil {
+		return nil
+	}
+
+	sqlDB, err := r.db.DB()
+	if err != nil {
+		return fmt.Errorf("failed to get database instance: %w", err)
+	}
+	conn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to open replication connection: %w", err)
+	}
+	ckptConn, err := sqlDB.Conn(ctx)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to open replication connection: %w", err)
+	}
+
+	var mode string
+	if err := conn.QueryRowContext(ctx, "PRAGMA journal_mode=WAL").Scan(&mode); err != nil || mode != "wal" {
+		conn.Close()
+		ckptConn.Close()
+		return fmt.Errorf("replication requires WAL journal mode (got %q): %v", mode, err)
+	}
+
+	var seq int
+	var name, file string
+	if err := conn.QueryRowContext(ctx, "PRAGMA database_list").Scan(&seq, &name, &file); err != nil || file == "" {
+		conn.Close()
+		ckptConn.Close()
+		return fmt.Errorf("replication requires a database file: %v", err)
+	}
+
+	r.conn = conn
+	r.ckptConn = ckptConn
+	r.dbPath = file
+	r.tmpDir = filepath.Join(filepath.Dir(file), ".replication")
+	// Anything left over from a previous run belongs to an old generation
+	os.RemoveAll(r.tmpDir)
+	if err := os.MkdirAll(r.tmpDir, 0750); err != nil {
+		return fmt.Errorf("failed to create replication directory: %w", err)
+	}
+	return nil
+}
+
+func (r *Replicator) close() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.ckptConn.Close()
+		r.conn = nil
+		r.ckptConn = nil
+	}
+}
+
+// lock takes the database write lock, so no transaction can commit while
+// the WAL is read. The returned function releases it.
+func (r *Replicator) lock(ctx context.Context) (func(), error) {
+	if _, err := r.conn.ExecContext(ctx, "BEGIN IMMEDIATE"); err != nil {
+		return nil, fmt.Errorf("failed to lock database: %w", err)
+	}
+	return func() {
+		r.conn.ExecContext(context.Background(), "ROLLBACK")
+	}, nil
+}
+
+// checkpoint copies WAL frames into the database file without waiting for
+// readers, and reports whether every frame made it
+func (r *Replicator) checkpoint(ctx context.Context) (bool, int64, error) {
+	var busy, frames, checkpointed int64
+	if err := r.ckptConn.QueryRowContext(ctx, "PRAGMA wal_checkpoint(PASSIVE)").Scan(&busy, &frames, &checkpointed); err != nil {
+		return false, 0, fmt.Errorf("failed to checkpoint: %w", err)
+	}
+	return frames >= 0 && frames == checkpointed, frames, nil
+}
+
+// readWAL returns the WAL header and the WAL contents from offset on. A
+// missing or empty WAL returns a nil header.
+func (r *Replicator) readWAL(offset int64) (*walHeader, []byte, error) {
+	f, err := os.Open(r.dbPath + "-wal")
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open WAL: %w", err)
+	}
+	defer f.Close()
+
+	buf := make([]byte, walHeaderSize)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		return nil, nil, nil
+	}
+	h, err := parseWALHeader(buf)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if _, err := f.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	data, err := io.ReadAll(f)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read WAL: %w", err)
+	}
+	return &h, data, nil
+}
+
+// capture queues the frames committed since the last sync, and checkpoints
+// them once the WAL grows past the checkpoint size
+func (r *Replicator) capture(ctx context.Context) error {
+	unlock, err := r.lock(ctx)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	h, data, err := r.readWAL(r.offset)
+	if err != nil {
+		return err
+	}
+	if h == nil {
+		// SQLite deletes the WAL when the last connection of another
+		// process closes, after checkpointing frames we never saw
+		if r.offset > 0 {
+			return errWALReset
+		}
+		return nil
+	}
+
+	if h.salt != r.header.salt {
+		// Only a single restart after our own checkpoint keeps the stream
+		// intact; anything else means frames were checkpointed unseen
+		if r.offset > 0 && (!r.checkpointed || !h.follows(r.header)) {
+			return errWALReset
+		}
+		// SQLite restarted the WAL after our checkpoint; the next index begins
+		if r.offset > 0 {
+			r.index++
+		}
+		r.header = *h
+		r.offset = 0
+		if _, data, err = r.readWAL(0); err != nil {
+			return err
+		}
+	}
+
+	end := committedEnd(data, r.offset, r.header)
+	if end > r.offset {
+		seg := segment{
+			generation: r.generation,
+			index:      r.index,
+			offset:     r.offset,
+			at:         time.Now().UTC(),
+		}
+		if err := r.queue(seg, data[:end-r.offset]); err != nil {
+			return err
+		}
+		r.offset = end
+		r.checkpointed = false
+	}
+
+	if r.offset >= r.checkpointSize() && !r.checkpointed {
+		complete, frames, err := r.checkpoint(ctx)
+		if err != nil {
+			return err
+		}
+		shipped := (r.offset - walHeaderSize) / r.header.frameSize()
+		r.checkpointed = complete && frames == shipped
+	}
+	return nil
+}
+
+// queue writes a compressed segment to the local spool until it is shipped
+func (r *Replicator) queue(seg segment, data []byte) error {
+	seg.path = filepath.Join(r.tmpDir, filepath.Base(seg.name()))
+	if err := writeGzip(seg.path, bytes.NewReader(data)); err != nil {
+		os.Remove(seg.path)
+		return fmt.Errorf("failed to spool WAL segment: %w", err)
+	}
+	r.pending = append(r.pending, seg)
+	return nil
+}
+
+// flush ships spooled segments in order, stopping at the first failure so
+// that the replica never has gaps
+func (r *Replicator) flush(ctx context.Context) error {
+	for len(r.pending) > 0 {
+		seg := r.pending[0]
+		if err := r.replica.Put(ctx, seg.name(), seg.path); err != nil {
+			return fmt.Errorf("failed to ship WAL segment: %w", err)
+		}
+		os.Remove(seg.path)
+		r.pending = r.pending[1:]
+	}
+	return nil
+}
+
+// snapshot starts a new generation with a copy of the database file. The
+// WAL is checkpointed first, so the copy holds every committed transaction,
+// and the WAL itself is shipped as the first segment because frames
+// appended later are only valid following it.
+func (r *Replicator) snapshot(ctx context.Context) error {
+	// Segments of the old generation are still useful for restores
+	if err := r.flush(ctx); err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	generation := newGeneration(now)
+	snapshotPath := filepath.Join(r.tmpDir, "snapshot.db.gz")
+	defer os.Remove(snapshotPath)
+
+	var h *walHeader
+	var walData []byte
+	err := func() error {
+		unlock, err := r.lock(ctx)
+		if err != nil {
+			return err
+		}
+		defer unlock()
+
+		complete, _, err := r.checkpoint(ctx)
+		if err != nil {
+			return err
+		}
+		if !complete {
+			return fmt.Errorf("snapshot postponed: long running reads are holding back the checkpoint")
+		}
+
+		db, err := os.Open(r.dbPath)
+		if err != nil {
+			return fmt.Errorf("failed to open database file: %w", err)
+		}
+		defer db.Close()
+		if err := writeGzip(snapshotPath, db); err != nil {
+			return fmt.Errorf("failed to copy database file: %w", err)
+		}
+
+		h, walData, err = r.readWAL(0)
+		return err
+	}()
+	if err != nil {
+		return err
+	}
+
+	if err := r.replica.Put(ctx, snapshotName(generation), snapshotPath); err != nil {
+		return fmt.Errorf("failed to ship snapshot: %w", err)
+	}
+
+	r.generation = generation
+	r.snapshotAt = now
+	r.index = 0
+	r.offset = 0
+	r.header = walHeader{}
+	r.checkpointed = true
+	r.pending = nil
+	if h != nil {
+		r.header = *h
+		if end := committedEnd(walData, 0, *h); end > 0 {
+			if err := r.queue(segment{generation: generation, at: now}, walData[:end]); err != nil {
+				return err
+			}
+			r.offset = end
+		}
+	}
+
+	if err := r.flush(ctx); err != nil {
+		return err
+	}
+	if err := r.enforceRetention(ctx); err != nil {
+		slog.Error("Replication retention failed", "error", err)
+	}
+	return nil
+}
+
+// enforceRetention deletes generations that are no longer needed to
+// restore to any point within the retention window. A generation is needed
+// until the one after it is older than the window.
+func (r *Replicator) enforceRetention(ctx context.Context) error {
+	retention := r.config.GetDuration("replication.retention")
+	if retention <= 0 {
+		return nil
+	}
+
+	names, err := r.replica.List(ctx, generationsPrefix)
+	if err != nil {
+		return err
+	}
+	objects := map[string][]string{}
+	for _, name := range names {
+		if generation, _, ok := parseObjectName(name); ok {
+			objects[generation] = append(objects[generation], name)
+		}
+	}
+	generations := make([]string, 0, len(objects))
+	for generation := range objects {
+		generations = append(generations, generation)
+	}
+	sort.Strings(generations)
+
+	cutoff := time.Now().Add(-retention)
+	for i := 0; i+1 < len(generations); i++ {
+		if generations[i] == r.generation {
+			continue
+		}
+		next, err := generationTime(generations[i+1])
+		if err != nil || next.After(cutoff) {
+			continue
+		}
+		for _, name := range objects[generations[i]] {
+			if err := r.replica.Delete(ctx, name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (r *Replicator) syncInterval() time.Duration {
+	if d := r.config.GetDuration("replication.sync_interval"); d > 0 {
+		return d
+	}
+	return time.Second
+}
+
+func (r *Replicator) snapshotInterval() time.Duration {
+	if d := r.config.GetDuration("replication.snapshot_interval"); d > 0 {
+		return d
+	}
+	return 24 * time.Hour
+}
+
+func (r *Replicator) checkpointSize() int64 {
+	if n := r.config.GetInt64("replication.checkpoint_size"); n > 0 {
+		return n
+	}
+	return 4 << 20
+}
+
+func (r *Replicator) maxLag() time.Duration {
+	if d := r.config.GetDuration("replication.max_lag"); d > 0 {
+		return d
+	}
+	return time.Minute
+}
@@ -0,0 +1,136 @@
+package replication
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+)
+
+func countRows(t *testing.T, path string) int64 {
+	var count int64
+	require.NoError(t, withDatabase(path, func(db *gorm.DB) error {
+		return db.Raw("SELECT COUNT(*) FROM notes").Row().Scan(&count)
+	}))
+	return count
+}
+
+func TestReplicateAndRestore(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.New()
+	cfg.Set("database.type", "sqlite")
+	cfg.Set("database.path", filepath.Join(dir, "data", "data.db"))
+	cfg.Set("replication.enabled", true)
+	cfg.Set("replication.target", filepath.Join(dir, "replica"))
+	// Checkpoint after every sync, so the stream spans several WAL indexes
+	cfg.Set("replication.checkpoint_size", 1)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "data"), 0750))
+	db, err := database.Initialize(cfg)
+	require.NoError(t, err)
+	sqlDB, _ := db.DB()
+	defer sqlDB.Close()
+
+	replicator, err := NewReplicator(db, cfg)
+	require.NoError(t, err)
+	ctx := context.Background()
+
+	insert := func(n int) {
+		for i := 0; i < n; i++ {
+			require.NoError(t, db.Exec("INSERT INTO notes (body) VALUES (?)", "note").Error)
+		}
+	}
+
+	require.NoError(t, db.Exec("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT)").Error)
+	insert(5)
+	require.NoError(t, replicator.Sync(ctx))
+	assert.NotEmpty(t, replicator.generation)
+
+	for round := 0; round < 3; round++ {
+		insert(10)
+		require.NoError(t, replicator.Sync(ctx))
+	}
+	assert.Greater(t, replicator.index, uint32(0), "the WAL should have been restarted")
+	middle := time.Now().UTC()
+	time.Sleep(10 * time.Millisecond)
+
+	insert(7)
+	require.NoError(t, replicator.Sync(ctx))
+	replicator.close()
+
+	status := replicator.Status()
+	assert.Empty(t, status.LastError)
+
+	replica := &FileReplica{Root: filepath.Join(dir, "replica")}
+	generations, err := ListGenerations(ctx, replica)
+	require.NoError(t, err)
+	require.Len(t, generations, 1)
+	assert.True(t, generations[0].Snapshot)
+
+	t.Run("Latest", func(t *testing.T) {
+		out := filepath.Join(dir, "latest.db")
+		result, err := Restore(ctx, replica, RestoreOptions{OutputPath: out})
+		require.NoError(t, err)
+		assert.Equal(t, generations[0].Name, result.Generation)
+		assert.Equal(t, int64(42), countRows(t, out))
+
+		_, err = Restore(ctx, replica, RestoreOptions{OutputPath: out})
+		assert.Error(t, err, "restore must not overwrite an existing database")
+	})
+
+	t.Run("PointInTime", func(t *testing.T) {
+		out := filepath.Join(dir, "pitr.db")
+		_, err := Restore(ctx, replica, RestoreOptions{OutputPath: out, Timestamp: middle})
+		require.NoError(t, err)
+		assert.Equal(t, int64(35), countRows(t, out))
+	})
+
+	t.Run("NewGeneration", func(t *testing.T) {
+		// A WAL checkpointed behind the replicator's back breaks the stream
+		cfg.Set("replication.checkpoint_size", 1<<30)
+		insert(3)
+		require.NoError(t, replicator.Sync(ctx))
+		insert(3)
+		require.NoError(t, db.Exec("PRAGMA wal_checkpoint(TRUNCATE)").Error)
+		insert(1)
+		require.NoError(t, replicator.Sync(ctx))
+		replicator.close()
+
+		generations, err := ListGenerations(ctx, replica)
+		require.NoError(t, err)
+		assert.Len(t, generations, 2)
+
+		out := filepath.Join(dir, "new.db")
+		_, err = Restore(ctx, replica, RestoreOptions{OutputPath: out})
+		require.NoError(t, err)
+		assert.Equal(t, int64(49), countRows(t, out))
+	})
+}
+
+func TestParseObjectName(t *testing.T) {
+	at := time.Unix(1700000000, 123).UTC()
+	seg := segment{generation: newGeneration(at), index: 3, offset: 4152, at: at}
+
+	generation, parsed, ok := parseObjectName(seg.name())
+	require.True(t, ok)
+	assert.Equal(t, seg.generation, generation)
+	assert.Equal(t, seg.index, parsed.index)
+	assert.Equal(t, seg.offset, parsed.offset)
+	assert.True(t, at.Equal(parsed.at))
+
+	generation, parsed, ok = parseObjectName(snapshotName(seg.generation))
+	require.True(t, ok)
+	assert.Equal(t, seg.generation, generation)
+	assert.Nil(t, parsed)
+
+	_, _, ok = parseObjectName("generations/nope/snapshot.db.gz")
+	assert.False(t, ok)
+}
@@ -0,0 +1,283 @@
+package replication
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// Generation summarizes one generation stored in a replica
+type Generation struct {
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	Snapshot  bool      `json:"snapshot"`
+	Segments  int       `json:"segments"`
+	// RestorableTo is the latest point in time this generation can restore
+	RestorableTo time.Time `json:"restorable_to"`
+
+	segments []segment
+}
+
+// RestoreOptions controls what is restored and where to
+type RestoreOptions struct {
+	// OutputPath is the database file to create; it must not exist
+	OutputPath string
+	// Generation restores a specific generation instead of the latest one
+	Generation string
+	// Timestamp restores the database as it was at this time; zero
+	// restores everything that was shipped
+	Timestamp time.Time
+}
+
+// RestoreResult describes a finished restore
+type RestoreResult struct {
+	Generation string    `json:"generation"`
+	OutputPath string    `json:"output_path"`
+	SnapshotAt time.Time `json:"snapshot_at"`
+	Segments   int       `json:"segments"`
+	RestoredTo time.Time `json:"restored_to"`
+}
+
+// ListGenerations returns the generations in the replica, oldest first
+func ListGenerations(ctx context.Context, replica Replica) ([]Generation, error) {
+	names, err := replica.List(ctx, generationsPrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	byName := map[string]*Generation{}
+	for _, name := range names {
+		generation, seg, ok := parseObjectName(name)
+		if !ok {
+			continue
+		}
+		g := byName[generation]
+		if g == nil {
+			createdAt, _ := generationTime(generation)
+			g = &Generation{Name: generation, CreatedAt: createdAt, RestorableTo: createdAt}
+			byName[generation] = g
+		}
+		if seg == nil {
+			g.Snapshot = true
+			continue
+		}
+		g.segments = append(g.segments, *seg)
+		g.Segments++
+		if seg.at.After(g.RestorableTo) {
+			g.RestorableTo = seg.at
+		}
+	}
+
+	generations := make([]Generation, 0, len(byName))
+	for _, g := range byName {
+		sort.Slice(g.segments, func(i, j int) bool {
+			if g.segments[i].index != g.segments[j].index {
+				return g.segments[i].index < g.segments[j].index
+			}
+			return g.segments[i].offset < g.segments[j].offset
+		})
+		generations = append(generations, *g)
+	}
+	sort.Slice(generations, func(i, j int) bool {
+		return generations[i].Name < generations[j].Name
+	})
+	return generations, nil
+}
+
+// Restore rebuilds a database from a replica: it downloads the snapshot of
+// a generation and replays its WAL segments, one WAL index at a time, up to
+// the requested point in time. Replay stops early at the first gap in the
+// stream, so the result is always a consistent database.
+func Restore(ctx context.Context, replica Replica, opts RestoreOptions) (*RestoreResult, error) {
+	if opts.OutputPath == "" {
+		return nil, fmt.Errorf("an output path is required")
+	}
+	if _, err := os.Stat(opts.OutputPath); err == nil {
+		return nil, fmt.Errorf("%s already exists", opts.OutputPath)
+	}
+
+	generations, err := ListGenerations(ctx, replica)
+	if err != nil {
+		return nil, err
+	}
+	generation, err := pickGeneration(generations, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	tmpDir, err := os.MkdirTemp(filepath.Dir(opts.OutputPath), ".restore-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create restore directory: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// Build the database under a temporary name so a failed restore never
+	// leaves a half written database behind
+	dbPath := filepath.Join(tmpDir, "restore.db")
+	if err := download(ctx, replica, snapshotName(generation.Name), filepath.Join(tmpDir, "snapshot.gz"), dbPath); err != nil {
+		return nil, fmt.Errorf("failed to restore snapshot: %w", err)
+	}
+
+	result := &RestoreResult{
+		Generation: generation.Name,
+		OutputPath: opts.OutputPath,
+		SnapshotAt: generation.CreatedAt,
+		RestoredTo: generation.CreatedAt,
+	}
+
+	var wal bytes.Buffer
+	var walIndex uint32
+	var applied []segment
+	flushWAL := func() error {
+		if wal.Len() == 0 {
+			return nil
+		}
+		if err := applyWAL(dbPath, wal.Bytes()); err != nil {
+			return fmt.Errorf("failed to replay WAL index %d: %w", walIndex, err)
+		}
+		for _, seg := range applied {
+			result.Segments++
+			if seg.at.After(result.RestoredTo) {
+				result.RestoredTo = seg.at
+			}
+		}
+		wal.Reset()
+		applied = nil
+		return nil
+	}
+
+	for _, seg := range generation.segments {
+		if !opts.Timestamp.IsZero() && seg.at.After(opts.Timestamp) {
+			break
+		}
+		if seg.index != walIndex {
+			if err := flushWAL(); err != nil {
+				return nil, err
+			}
+			if seg.index != walIndex+1 {
+				// A missing WAL index; everything after it is unusable
+				break
+			}
+			walIndex = seg.index
+		}
+		if seg.offset != int64(wal.Len()) {
+			// A missing segment; everything after it is unusable
+			break
+		}
+		data, err := func() ([]byte, error) {
+			local := filepath.Join(tmpDir, "segment.gz")
+			defer os.Remove(local)
+			if err := replica.Get(ctx, seg.name(), local); err != nil {
+				return nil, err
+			}
+			return readGzip(local)
+		}()
+		if err != nil {
+			return nil, fmt.Errorf("failed to download WAL segment: %w", err)
+		}
+		wal.Write(data)
+		applied = append(applied, seg)
+	}
+	if err := flushWAL(); err != nil {
+		return nil, err
+	}
+
+	if err := checkIntegrity(dbPath); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(dbPath, opts.OutputPath); err != nil {
+		return nil, fmt.Errorf("failed to move restored database into place: %w", err)
+	}
+	return result, nil
+}
+
+func pickGeneration(generations []Generation, opts RestoreOptions) (*Generation, error) {
+	for i := len(generations) - 1; i >= 0; i-- {
+		g := generations[i]
+		if !g.Snapshot {
+			continue
+		}
+		if opts.Generation != "" {
+			if g.Name == opts.Generation {
+				return &g, nil
+			}
+			continue
+		}
+		if opts.Timestamp.IsZero() || !g.CreatedAt.After(opts.Timestamp) {
+			return &g, nil
+		}
+	}
+
+	if opts.Generation != "" {
+		return nil, fmt.Errorf("generation %s not found in the replica", opts.Generation)
+	}
+	if !opts.Timestamp.IsZero() {
+		return nil, fmt.Errorf("no snapshot in the replica is older than %s", opts.Timestamp.Format(time.RFC3339))
+	}
+	return nil, fmt.Errorf("the replica holds no snapshots")
+}
+
+func download(ctx context.Context, replica Replica, name, local, dest string) error {
+	defer os.Remove(local)
+	if err := replica.Get(ctx, name, local); err != nil {
+		return err
+	}
+	data, err := readGzip(local)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dest, data, 0640)
+}
+
+// applyWAL places a WAL next to the database and has SQLite checkpoint it
+// into the database file, which is exactly what crash recovery does
+func applyWAL(dbPath string, wal []byte) error {
+	os.Remove(dbPath + "-shm")
+	if err := os.WriteFile(dbPath+"-wal", wal, 0640); err != nil {
+		return err
+	}
+	return withDatabase(dbPath, func(db *gorm.DB) error {
+		var busy, frames, checkpointed int64
+		if err := db.Raw("PRAGMA wal_checkpoint(TRUNCATE)").Row().Scan(&busy, &frames, &checkpointed); err != nil {
+			return err
+		}
+		if busy != 0 {
+			return fmt.Errorf("checkpoint did not complete")
+		}
+		return nil
+	})
+}
+
+func checkIntegrity(dbPath string) error {
+	return withDatabase(dbPath, func(db *gorm.DB) error {
+		var result string
+		if err := db.Raw("PRAGMA integrity_check").Row().Scan(&result); err != nil {
+			return fmt.Errorf("failed to check restored database: %w", err)
+		}
+		if result != "ok" {
+			return fmt.Errorf("restored database failed the integrity check: %s", result)
+		}
+		return nil
+	})
+}
+
+func withDatabase(dbPath string, fn func(db *gorm.DB) error) error {
+	db, err := gorm.Open(sqlite.Open(dbPath), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	if err != nil {
+		return err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+	return fn(db)
+}
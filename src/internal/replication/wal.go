@@ -0,0 +1,185 @@
+package replication
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SQLite WAL layout, see https://www.sqlite.org/fileformat.html#the_write_ahead_log
+const (
+	walHeaderSize      = 32
+	walFrameHeaderSize = 24
+)
+
+// walHeader is the part of the WAL file header the replicator cares about.
+// The salt changes every time SQLite restarts the WAL from the beginning.
+type walHeader struct {
+	pageSize uint32
+	salt     [8]byte
+}
+
+func parseWALHeader(b []byte) (walHeader, error) {
+	if len(b) < walHeaderSize {
+		return walHeader{}, fmt.Errorf("short WAL header")
+	}
+	magic := binary.BigEndian.Uint32(b[0:4])
+	if magic != 0x377f0682 && magic != 0x377f0683 {
+		return walHeader{}, fmt.Errorf("invalid WAL header magic %#x", magic)
+	}
+	h := walHeader{pageSize: binary.BigEndian.Uint32(b[8:12])}
+	if h.pageSize == 1 {
+		h.pageSize = 65536
+	}
+	copy(h.salt[:], b[16:24])
+	return h, nil
+}
+
+// follows reports whether h is the header of the WAL restarted right after
+// prev. The first salt is a counter that goes up by one on every restart.
+func (h walHeader) follows(prev walHeader) bool {
+	return binary.BigEndian.Uint32(h.salt[0:4]) == binary.BigEndian.Uint32(prev.salt[0:4])+1
+}
+
+func (h walHeader) frameSize() int64 {
+	return walFrameHeaderSize + int64(h.pageSize)
+}
+
+// committedEnd returns the WAL offset just past the last commit frame in buf,
+// which holds the WAL file from offset start. Frames of a rolled back
+// transaction, or stale frames left over from before the WAL was restarted,
+// are not part of the database and are never shipped.
+func committedEnd(buf []byte, start int64, h walHeader) int64 {
+	end := start
+	pos := int64(0)
+	if start == 0 {
+		pos = walHeaderSize
+	}
+	frameSize := h.frameSize()
+	for pos+frameSize <= int64(len(buf)) {
+		frame := buf[pos : pos+walFrameHeaderSize]
+		if !bytes.Equal(frame[8:16], h.salt[:]) {
+			break
+		}
+		pos += frameSize
+		if binary.BigEndian.Uint32(frame[4:8]) != 0 {
+			end = start + pos
+		}
+	}
+	return end
+}
+
+// Object names in the replica. Generations and timestamps are fixed width
+// hex so that names sort in time order.
+const generationsPrefix = "generations/"
+
+func newGeneration(t time.Time) string {
+	return fmt.Sprintf("%016x", t.UnixNano())
+}
+
+func generationTime(generation string) (time.Time, error) {
+	nanos, err := strconv.ParseInt(generation, 16, 64)
+	if err != nil || len(generation) != 16 {
+		return time.Time{}, fmt.Errorf("invalid generation %q", generation)
+	}
+	return time.Unix(0, nanos).UTC(), nil
+}
+
+func snapshotName(generation string) string {
+	return generationsPrefix + generation + "/snapshot.db.gz"
+}
+
+// segment is a contiguous range of a WAL file, ending on a commit frame
+type segment struct {
+	generation string
+	index      uint32
+	offset     int64
+	at         time.Time
+	// path is the local compressed copy of a segment waiting to be shipped
+	path string
+}
+
+func (s segment) name() string {
+	return fmt.Sprintf("%s%s/wal/%08x-%016x-%016x.wal.gz", generationsPrefix, s.generation, s.index, s.offset, s.at.UnixNano())
+}
+
+// parseObjectName splits a replica object name into its generation and,
+// for WAL segments, the segment it holds
+func parseObjectName(name string) (generation string, seg *segment, ok bool) {
+	rest, found := strings.CutPrefix(name, generationsPrefix)
+	if !found {
+		return "", nil, false
+	}
+	generation, rest, found = strings.Cut(rest, "/")
+	if !found {
+		return "", nil, false
+	}
+	if _, err := generationTime(generation); err != nil {
+		return "", nil, false
+	}
+	if rest == "snapshot.db.gz" {
+		return generation, nil, true
+	}
+
+	base, found := strings.CutPrefix(rest, "wal/")
+	if !found {
+		return "", nil, false
+	}
+	base, found = strings.CutSuffix(path.Base(base), ".wal.gz")
+	parts := strings.Split(base, "-")
+	if !found || len(parts) != 3 {
+		return "", nil, false
+	}
+	index, err1 := strconv.ParseUint(parts[0], 16, 32)
+	offset, err2 := strconv.ParseInt(parts[1], 16, 64)
+	nanos, err3 := strconv.ParseInt(parts[2], 16, 64)
+	if err1 != nil || err2 != nil || err3 != nil {
+		return "", nil, false
+	}
+	return generation, &segment{
+		generation: generation,
+		index:      uint32(index),
+		offset:     offset,
+		at:         time.Unix(0, nanos).UTC(),
+	}, true
+}
+
+// writeGzip compresses r into a new file at dest
+func writeGzip(dest string, r io.Reader) error {
+	f, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0640)
+	if err != nil {
+		return err
+	}
+	zw := gzip.NewWriter(f)
+	if _, err := io.Copy(zw, r); err != nil {
+		f.Close()
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// readGzip decompresses the file at src
+func readGzip(src string) ([]byte, error) {
+	f, err := os.Open(src)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	zr, err := gzip.NewReader(f)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+	return io.ReadAll(zr)
+}
@@ -0,0 +1,106 @@
+package sandbox
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Purger deletes the ephemeral gists created by API sandbox requests once
+// they are older than api.sandbox.ttl
+type Purger struct {
+	db     *gorm.DB
+	config *config.Config
+	stop   chan bool
+}
+
+// NewPurger creates a new sandbox purger
+func NewPurger(db *gorm.DB, config *config.Config) *Purger {
+	return &Purger{
+		db:     db,
+		config: config,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Purge permanently deletes expired sandbox gists with their files, stars,
+// comments, views, watches and tags, and returns how many gists went
+func (p *Purger) Purge(ctx context.Context) (int64, error) {
+	cutoff := time.Now().Add(-p.ttl())
+	expired := p.db.Unscoped().Model(&models.Gist{}).Select("id").
+		Where("ephemeral = ? AND created_at < ?", true, cutoff)
+
+	var purged int64
+	err := p.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, model := range []interface{}{
+			&models.GistFile{}, &models.GistStar{}, &models.GistComment{},
+			&models.GistView{}, &models.GistWatch{},
+		} {
+			if err := tx.Unscoped().Where("gist_id IN (?)", expired).Delete(model).Error; err != nil {
+				return err
+			}
+		}
+		if err := tx.Exec("DELETE FROM gist_tags WHERE gist_id IN (?)", expired).Error; err != nil {
+			return err
+		}
+		result := tx.Unscoped().Where("ephemeral = ? AND created_at < ?", true, cutoff).Delete(&models.Gist{})
+		purged = result.RowsAffected
+		return result.Error
+	})
+	return purged, err
+}
+
+// Start purges expired sandbox gists every api.sandbox.purge_interval until
+// the context is cancelled or Stop is called
+func (p *Purger) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			purged, err := p.Purge(ctx)
+			if err != nil {
+				slog.Error("Sandbox purge failed", "error", err)
+			} else if purged > 0 {
+				log.Printf("Purged %d sandbox gists", purged)
+			}
+		}
+	}
+}
+
+// Stop stops purging
+func (p *Purger) Stop() {
+	select {
+	case p.stop <- true:
+	default:
+	}
+}
+
+// ttl returns how long sandbox gists live (default: 1 hour)
+func (p *Purger) ttl() time.Duration {
+	ttl := p.config.GetDuration("api.sandbox.ttl")
+	if ttl <= 0 {
+		ttl = time.Hour
+	}
+	return ttl
+}
+
+// interval returns the pause between purges (default: 1 hour)
+func (p *Purger) interval() time.Duration {
+	interval := p.config.GetDuration("api.sandbox.purge_interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return interval
+}
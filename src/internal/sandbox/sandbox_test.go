@@ -0,0 +1,57 @@
+package sandbox
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestPurge(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistFile{}, &models.GistStar{},
+		&models.GistComment{}, &models.GistView{}, &models.GistWatch{}, &models.Tag{}))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	newGist := func(title string, ephemeral bool, age time.Duration) *models.Gist {
+		gist := &models.Gist{
+			Title:     title,
+			UserID:    &user.ID,
+			Ephemeral: ephemeral,
+			CreatedAt: time.Now().Add(-age),
+			Files:     []models.GistFile{{Filename: title + ".txt", Content: title}},
+		}
+		require.NoError(t, db.Create(gist).Error)
+		return gist
+	}
+	expired := newGist("expired", true, 2*time.Hour)
+	fresh := newGist("fresh", true, time.Minute)
+	kept := newGist("real", false, 2*time.Hour)
+	require.NoError(t, db.Create(&models.GistComment{GistID: expired.ID, UserID: user.ID, Content: "hi"}).Error)
+
+	config := config.New()
+	config.Set("api.sandbox.ttl", "1h")
+	purged, err := NewPurger(db, config).Purge(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), purged)
+
+	var titles []string
+	require.NoError(t, db.Unscoped().Model(&models.Gist{}).Order("title").Pluck("title", &titles).Error)
+	assert.Equal(t, []string{fresh.Title, kept.Title}, titles)
+	var files, comments int64
+	db.Model(&models.GistFile{}).Where("gist_id = ?", expired.ID).Count(&files)
+	db.Unscoped().Model(&models.GistComment{}).Where("gist_id = ?", expired.ID).Count(&comments)
+	assert.Zero(t, files)
+	assert.Zero(t, comments)
+}
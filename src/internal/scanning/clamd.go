@@ -0,0 +1,114 @@
+package scanning
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+)
+
+// clamdChunkSize is the size of the chunks streamed to clamd
+const clamdChunkSize = 64 * 1024
+
+// ClamdScanner streams files to a ClamAV daemon with the INSTREAM command
+type ClamdScanner struct {
+	network string
+	address string
+	timeout time.Duration
+}
+
+// NewClamdScanner creates a scanner for the clamd listening at address,
+// either a unix socket path or host:port
+func NewClamdScanner(address string, timeout time.Duration) (*ClamdScanner, error) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, fmt.Errorf("scanning.clamd.address is required for the clamd provider")
+	}
+	scanner := &ClamdScanner{network: "tcp", address: address, timeout: timeout}
+	switch {
+	case strings.HasPrefix(address, "unix://"):
+		scanner.network, scanner.address = "unix", strings.TrimPrefix(address, "unix://")
+	case strings.HasPrefix(address, "tcp://"):
+		scanner.address = strings.TrimPrefix(address, "tcp://")
+	case strings.HasPrefix(address, "/"):
+		scanner.network = "unix"
+	}
+	return scanner, nil
+}
+
+// Name returns the scanner name
+func (s *ClamdScanner) Name() string {
+	return ProviderClamd
+}
+
+// Scan streams r to clamd and parses its verdict: "stream: OK",
+// "stream: <signature> FOUND" or "<reason> ERROR"
+func (s *ClamdScanner) Scan(ctx context.Context, filename string, r io.Reader) (*Result, error) {
+	dialer := net.Dialer{Timeout: s.timeout}
+	conn, err := dialer.DialContext(ctx, s.network, s.address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to clamd: %w", err)
+	}
+	defer conn.Close()
+	deadline := time.Now().Add(s.timeout)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	conn.SetDeadline(deadline)
+
+	if _, err := conn.Write([]byte("zINSTREAM\x00")); err != nil {
+		return nil, fmt.Errorf("failed to send to clamd: %w", err)
+	}
+	buf := make([]byte, clamdChunkSize)
+	size := make([]byte, 4)
+	for {
+		n, err := r.Read(buf)
+		if n > 0 {
+			binary.BigEndian.PutUint32(size, uint32(n))
+			if _, werr := conn.Write(size); werr != nil {
+				return nil, fmt.Errorf("failed to send to clamd: %w", werr)
+			}
+			if _, werr := conn.Write(buf[:n]); werr != nil {
+				return nil, fmt.Errorf("failed to send to clamd: %w", werr)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, err := conn.Write([]byte{0, 0, 0, 0}); err != nil {
+		return nil, fmt.Errorf("failed to send to clamd: %w", err)
+	}
+
+	reply, err := bufio.NewReader(conn).ReadString(0)
+	if err != nil && reply == "" {
+		return nil, fmt.Errorf("failed to read clamd reply: %w", err)
+	}
+	return parseClamdReply(strings.TrimRight(reply, "\x00\n"))
+}
+
+func parseClamdReply(reply string) (*Result, error) {
+	verdict := strings.TrimSpace(reply)
+	if i := strings.Index(verdict, ": "); i >= 0 {
+		verdict = verdict[i+2:]
+	}
+	switch {
+	case verdict == "OK":
+		return &Result{Status: StatusClean, Scanner: ProviderClamd}, nil
+	case strings.HasSuffix(verdict, " FOUND"):
+		return &Result{
+			Status:    StatusInfected,
+			Signature: strings.TrimSuffix(verdict, " FOUND"),
+			Scanner:   ProviderClamd,
+		}, nil
+	default:
+		return nil, fmt.Errorf("clamd: %s", reply)
+	}
+}
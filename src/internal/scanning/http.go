@@ -0,0 +1,66 @@
+package scanning
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPScanner sends files to an external scanning API. The service
+// receives the file as the request body, with its name in X-Filename, and
+// answers with {"infected": bool, "signature": "..."}.
+type HTTPScanner struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewHTTPScanner creates a scanner for the service at url
+func NewHTTPScanner(url, token string, timeout time.Duration) (*HTTPScanner, error) {
+	if url == "" {
+		return nil, fmt.Errorf("scanning.http.url is required for the http provider")
+	}
+	return &HTTPScanner{url: url, token: token, client: &http.Client{Timeout: timeout}}, nil
+}
+
+// Name returns the scanner name
+func (s *HTTPScanner) Name() string {
+	return ProviderHTTP
+}
+
+// Scan sends r to the service
+func (s *HTTPScanner) Scan(ctx context.Context, filename string, r io.Reader) (*Result, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, r)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+	req.Header.Set("X-Filename", filename)
+	if s.token != "" {
+		req.Header.Set("Authorization", "Bearer "+s.token)
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("scanning service unavailable: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("scanning service returned %s", resp.Status)
+	}
+
+	var verdict struct {
+		Infected  bool   `json:"infected"`
+		Signature string `json:"signature"`
+	}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 64*1024)).Decode(&verdict); err != nil {
+		return nil, fmt.Errorf("invalid scanning service response: %w", err)
+	}
+	if verdict.Infected {
+		return &Result{Status: StatusInfected, Signature: verdict.Signature, Scanner: ProviderHTTP}, nil
+	}
+	return &Result{Status: StatusClean, Scanner: ProviderHTTP}, nil
+}
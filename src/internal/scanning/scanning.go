@@ -0,0 +1,72 @@
+// Package scanning checks uploaded files for viruses and malware with
+// ClamAV (through the clamd socket) or an external scanning API. Flagged
+// files are quarantined instead of saved, and administrators are emailed
+// about them.
+package scanning
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Providers selectable with scanning.provider
+const (
+	ProviderClamd = "clamd"
+	ProviderHTTP  = "http"
+)
+
+// Scan statuses stored on gist files
+const (
+	StatusClean    = "clean"
+	StatusInfected = "infected" // Never stored on a file; the file is quarantined
+	StatusSkipped  = "skipped"  // Larger than scanning.max_bytes
+	StatusError    = "error"    // The scanner failed and scanning.fail_closed is off
+)
+
+var (
+	ErrInfected   = errors.New("file is infected")
+	ErrScanFailed = errors.New("file could not be scanned")
+)
+
+// Result is the verdict on one file
+type Result struct {
+	Status    string `json:"status"`
+	Signature string `json:"signature,omitempty"` // Name of the malware found
+	Scanner   string `json:"scanner"`
+}
+
+// Infected reports whether the scanner flagged the file
+func (r *Result) Infected() bool {
+	return r != nil && r.Status == StatusInfected
+}
+
+// Scanner checks a file's content
+type Scanner interface {
+	Name() string
+	Scan(ctx context.Context, filename string, r io.Reader) (*Result, error)
+}
+
+// NewScanner returns the scanner selected by scanning.provider. It returns
+// nil when scanning is disabled.
+func NewScanner(cfg *config.Config) (Scanner, error) {
+	if !cfg.GetBool("scanning.enabled") {
+		return nil, nil
+	}
+	timeout := cfg.GetDuration("scanning.timeout")
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	switch provider := cfg.GetString("scanning.provider"); provider {
+	case ProviderClamd, "":
+		return NewClamdScanner(cfg.GetString("scanning.clamd.address"), timeout)
+	case ProviderHTTP:
+		return NewHTTPScanner(cfg.GetString("scanning.http.url"), cfg.GetString("scanning.http.token"), timeout)
+	default:
+		return nil, fmt.Errorf("unknown scanning provider %q", provider)
+	}
+}
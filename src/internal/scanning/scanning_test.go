@@ -0,0 +1,241 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// eicar is the standard antivirus test file
+const eicar = `X5O!P%@AP[4\PZX54(P^)7CC)7}$EICAR-STANDARD-ANTIVIRUS-TEST-FILE!$H+H*`
+
+type fakeNotifier struct {
+	titles []string
+}
+
+func (n *fakeNotifier) SendSystemAlertNotification(userID uuid.UUID, email, username, title, message string) error {
+	n.titles = append(n.titles, username+": "+title)
+	return nil
+}
+
+func setupTestDB(t *testing.T) *gorm.DB {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.QuarantinedFile{}))
+	return db
+}
+
+// fakeClamd answers INSTREAM requests like clamd, flagging the EICAR
+// string, and returns its address
+func fakeClamd(t *testing.T) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				command := make([]byte, len("zINSTREAM\x00"))
+				if _, err := io.ReadFull(conn, command); err != nil || string(command) != "zINSTREAM\x00" {
+					conn.Write([]byte("UNKNOWN COMMAND\x00"))
+					return
+				}
+				var stream bytes.Buffer
+				size := make([]byte, 4)
+				for {
+					if _, err := io.ReadFull(conn, size); err != nil {
+						return
+					}
+					n := binary.BigEndian.Uint32(size)
+					if n == 0 {
+						break
+					}
+					if _, err := io.CopyN(&stream, conn, int64(n)); err != nil {
+						return
+					}
+				}
+				if bytes.Contains(stream.Bytes(), []byte(eicar)) {
+					conn.Write([]byte("stream: Eicar-Test-Signature FOUND\x00"))
+				} else {
+					conn.Write([]byte("stream: OK\x00"))
+				}
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+func testConfig(t *testing.T) *config.Config {
+	config := config.New()
+	config.Set("scanning.enabled", true)
+	config.Set("scanning.provider", ProviderClamd)
+	config.Set("scanning.clamd.address", fakeClamd(t))
+	config.Set("scanning.timeout", "5s")
+	config.Set("scanning.quarantine_dir", t.TempDir())
+	return config
+}
+
+func TestClamdScanner(t *testing.T) {
+	scanner, err := NewClamdScanner(fakeClamd(t), 5*time.Second)
+	require.NoError(t, err)
+
+	result, err := scanner.Scan(context.Background(), "hello.txt", bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, result.Status)
+
+	// Larger than one chunk, with the signature straddling two
+	infected := append(bytes.Repeat([]byte("a"), clamdChunkSize-10), []byte(eicar)...)
+	result, err = scanner.Scan(context.Background(), "eicar.com", bytes.NewReader(infected))
+	require.NoError(t, err)
+	assert.True(t, result.Infected())
+	assert.Equal(t, "Eicar-Test-Signature", result.Signature)
+
+	_, err = parseClamdReply("INSTREAM size limit exceeded. ERROR")
+	assert.Error(t, err)
+
+	unix, err := NewClamdScanner("/var/run/clamav/clamd.ctl", time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "unix", unix.network)
+}
+
+func TestHTTPScanner(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"infected":  bytes.Contains(body, []byte(eicar)),
+			"signature": "EICAR",
+		})
+	}))
+	defer server.Close()
+
+	scanner, err := NewHTTPScanner(server.URL, "secret", 5*time.Second)
+	require.NoError(t, err)
+	result, err := scanner.Scan(context.Background(), "eicar.com", bytes.NewReader([]byte(eicar)))
+	require.NoError(t, err)
+	assert.True(t, result.Infected())
+	assert.Equal(t, "EICAR", result.Signature)
+
+	result, err = scanner.Scan(context.Background(), "hello.txt", bytes.NewReader([]byte("hello")))
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, result.Status)
+
+	scanner, err = NewHTTPScanner(server.URL, "wrong", 5*time.Second)
+	require.NoError(t, err)
+	_, err = scanner.Scan(context.Background(), "hello.txt", bytes.NewReader([]byte("hello")))
+	assert.Error(t, err)
+}
+
+func TestService(t *testing.T) {
+	db := setupTestDB(t)
+	admin := &models.User{Username: "root", Email: "root@example.com", IsAdmin: true}
+	require.NoError(t, db.Create(admin).Error)
+	uploader := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(uploader).Error)
+
+	cfg := testConfig(t)
+	notifier := &fakeNotifier{}
+	service, err := NewService(db, cfg, notifier)
+	require.NoError(t, err)
+	require.NotNil(t, service)
+	ctx := context.Background()
+	upload := Upload{Filename: "eicar.com", Source: models.QuarantineSourceGistUpload, UserID: &uploader.ID}
+
+	result, err := service.Scan(ctx, upload, []byte("hello"))
+	require.NoError(t, err)
+	assert.Equal(t, StatusClean, result.Status)
+
+	result, err = service.Scan(ctx, upload, []byte(eicar))
+	require.NoError(t, err)
+	assert.True(t, result.Infected())
+
+	var quarantined models.QuarantinedFile
+	require.NoError(t, db.First(&quarantined).Error)
+	assert.Equal(t, "eicar.com", quarantined.Filename)
+	assert.Equal(t, "Eicar-Test-Signature", quarantined.Signature)
+	assert.Equal(t, uploader.ID, *quarantined.UserID)
+	assert.Equal(t, int64(len(eicar)), quarantined.Size)
+	kept, err := os.ReadFile(filepath.Join(cfg.GetString("scanning.quarantine_dir"), quarantined.StoredPath))
+	require.NoError(t, err)
+	assert.Equal(t, eicar, string(kept))
+	assert.Equal(t, []string{"root: Malware quarantined: eicar.com"}, notifier.titles)
+
+	require.NoError(t, RemoveQuarantined(db, cfg.GetString("scanning.quarantine_dir"), &quarantined))
+	assert.NoFileExists(t, filepath.Join(cfg.GetString("scanning.quarantine_dir"), quarantined.StoredPath))
+
+	t.Run("ScanFile", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "upload.bin")
+		require.NoError(t, os.WriteFile(path, []byte(eicar), 0644))
+		result, err := service.ScanFile(ctx, Upload{Filename: "uploads/upload.bin", Source: models.QuarantineSourceArchiveImport}, path)
+		require.NoError(t, err)
+		assert.True(t, result.Infected())
+		assert.NoFileExists(t, path)
+
+		var quarantined models.QuarantinedFile
+		require.NoError(t, db.Where("source = ?", models.QuarantineSourceArchiveImport).First(&quarantined).Error)
+		assert.FileExists(t, filepath.Join(cfg.GetString("scanning.quarantine_dir"), quarantined.StoredPath))
+	})
+
+	t.Run("Skipped", func(t *testing.T) {
+		cfg := testConfig(t)
+		cfg.Set("scanning.max_bytes", 4)
+		service, err := NewService(db, cfg, nil)
+		require.NoError(t, err)
+		result, err := service.Scan(ctx, upload, []byte(eicar))
+		require.NoError(t, err)
+		assert.Equal(t, StatusSkipped, result.Status)
+	})
+
+	t.Run("Unavailable", func(t *testing.T) {
+		cfg := testConfig(t)
+		cfg.Set("scanning.clamd.address", "127.0.0.1:1")
+		service, err := NewService(db, cfg, nil)
+		require.NoError(t, err)
+		result, err := service.Scan(ctx, upload, []byte("hello"))
+		require.NoError(t, err)
+		assert.Equal(t, StatusError, result.Status)
+
+		cfg.Set("scanning.fail_closed", true)
+		service, err = NewService(db, cfg, nil)
+		require.NoError(t, err)
+		_, err = service.Scan(ctx, upload, []byte("hello"))
+		assert.ErrorIs(t, err, ErrScanFailed)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		service, err := NewService(db, config.New(), nil)
+		require.NoError(t, err)
+		assert.Nil(t, service)
+		result, err := service.Scan(ctx, upload, []byte(eicar))
+		assert.NoError(t, err)
+		assert.Nil(t, result)
+	})
+}
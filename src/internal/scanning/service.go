@@ -0,0 +1,235 @@
+package scanning
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Notifier delivers quarantine emails to an administrator
+type Notifier interface {
+	SendSystemAlertNotification(userID uuid.UUID, email, username, title, message string) error
+}
+
+// Upload describes where a scanned file came from
+type Upload struct {
+	Filename string
+	Source   string // models.QuarantineSource*
+	UserID   *uuid.UUID
+	GistID   *uuid.UUID
+}
+
+// Service scans uploads and quarantines the ones the scanner flags
+type Service struct {
+	db            *gorm.DB
+	scanner       Scanner
+	notifier      Notifier
+	maxBytes      int64
+	failClosed    bool
+	quarantineDir string // Empty records flagged files without keeping them
+}
+
+// NewService creates a scanning service from the scanning.* settings. It
+// returns nil when scanning is disabled. The notifier may be nil, in which
+// case quarantined files are only recorded.
+func NewService(db *gorm.DB, config *config.Config, notifier Notifier) (*Service, error) {
+	scanner, err := NewScanner(config)
+	if err != nil || scanner == nil {
+		return nil, err
+	}
+	return NewServiceWithScanner(db, config, scanner, notifier), nil
+}
+
+// NewServiceWithScanner creates a scanning service around scanner
+func NewServiceWithScanner(db *gorm.DB, config *config.Config, scanner Scanner, notifier Notifier) *Service {
+	maxBytes := config.GetInt64("scanning.max_bytes")
+	if maxBytes <= 0 {
+		maxBytes = 25 * 1024 * 1024
+	}
+	return &Service{
+		db:            db,
+		scanner:       scanner,
+		notifier:      notifier,
+		maxBytes:      maxBytes,
+		failClosed:    config.GetBool("scanning.fail_closed"),
+		quarantineDir: config.GetString("scanning.quarantine_dir"),
+	}
+}
+
+// Scan checks an upload held in memory. A nil service scans nothing and
+// returns a nil result. Infected uploads are quarantined before Scan
+// returns; the caller must not save them. With scanning.fail_closed set, a
+// scanner failure returns ErrScanFailed instead of an error result.
+func (s *Service) Scan(ctx context.Context, upload Upload, content []byte) (*Result, error) {
+	if s == nil {
+		return nil, nil
+	}
+	if int64(len(content)) > s.maxBytes {
+		return &Result{Status: StatusSkipped, Scanner: s.scanner.Name()}, nil
+	}
+
+	result, err := s.scan(ctx, upload, bytes.NewReader(content))
+	if err != nil || !result.Infected() {
+		return result, err
+	}
+	s.quarantine(upload, result, int64(len(content)), sha256.Sum256(content), func(dest string) error {
+		return os.WriteFile(dest, content, 0600)
+	})
+	return result, nil
+}
+
+// ScanFile checks an upload already on disk. An infected file is moved
+// into the quarantine directory, or removed when there is none.
+func (s *Service) ScanFile(ctx context.Context, upload Upload, path string) (*Result, error) {
+	if s == nil {
+		return nil, nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > s.maxBytes {
+		return &Result{Status: StatusSkipped, Scanner: s.scanner.Name()}, nil
+	}
+
+	hash := sha256.New()
+	result, err := s.scan(ctx, upload, io.TeeReader(f, hash))
+	if err != nil || !result.Infected() {
+		return result, err
+	}
+	// The scanner may stop reading early once it has found something
+	io.Copy(hash, f)
+	f.Close()
+
+	var sum [sha256.Size]byte
+	copy(sum[:], hash.Sum(nil))
+	s.quarantine(upload, result, info.Size(), sum, func(dest string) error {
+		return moveFile(path, dest)
+	})
+	if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return result, fmt.Errorf("failed to remove infected file: %w", err)
+	}
+	return result, nil
+}
+
+func (s *Service) scan(ctx context.Context, upload Upload, r io.Reader) (*Result, error) {
+	result, err := s.scanner.Scan(ctx, upload.Filename, r)
+	if err == nil {
+		return result, nil
+	}
+	slog.Error("Failed to scan upload", "file", upload.Filename, "error", err)
+	if s.failClosed {
+		return nil, fmt.Errorf("%w: %v", ErrScanFailed, err)
+	}
+	return &Result{Status: StatusError, Scanner: s.scanner.Name()}, nil
+}
+
+// quarantine keeps a flagged file out of reach, records it and tells the
+// administrators. Failures are logged: the upload is rejected either way.
+func (s *Service) quarantine(upload Upload, result *Result, size int64, sum [sha256.Size]byte, store func(dest string) error) {
+	record := &models.QuarantinedFile{
+		ID:        uuid.New(),
+		Filename:  upload.Filename,
+		Source:    upload.Source,
+		UserID:    upload.UserID,
+		GistID:    upload.GistID,
+		Scanner:   result.Scanner,
+		Signature: result.Signature,
+		Size:      size,
+		SHA256:    hex.EncodeToString(sum[:]),
+	}
+
+	if s.quarantineDir != "" {
+		if err := os.MkdirAll(s.quarantineDir, 0700); err != nil {
+			slog.Error("Failed to create quarantine directory", "error", err)
+		} else if err := store(filepath.Join(s.quarantineDir, record.ID.String())); err != nil {
+			slog.Error("Failed to quarantine upload", "file", upload.Filename, "error", err)
+		} else {
+			record.StoredPath = record.ID.String()
+		}
+	}
+
+	if err := s.db.Create(record).Error; err != nil {
+		slog.Error("Failed to record quarantined file", "file", upload.Filename, "error", err)
+	}
+	s.notify(record)
+}
+
+func (s *Service) notify(record *models.QuarantinedFile) {
+	if s.notifier == nil {
+		return
+	}
+
+	uploader := "unknown user"
+	if record.UserID != nil {
+		var user models.User
+		if err := s.db.Select("username").First(&user, "id = ?", *record.UserID).Error; err == nil {
+			uploader = user.Username
+		}
+	}
+	title := fmt.Sprintf("Malware quarantined: %s", record.Filename)
+	message := fmt.Sprintf("%s flagged %s (%s) uploaded by %s via %s. The file was quarantined at %s; SHA-256 %s.",
+		record.Scanner, record.Filename, record.Signature, uploader, record.Source,
+		time.Now().UTC().Format(time.RFC3339), record.SHA256)
+
+	var admins []models.User
+	if err := s.db.Where("is_admin = ? AND deactivated_at IS NULL", true).Find(&admins).Error; err != nil {
+		slog.Error("Failed to load administrators for quarantine notice", "error", err)
+		return
+	}
+	for _, admin := range admins {
+		if err := s.notifier.SendSystemAlertNotification(admin.ID, admin.Email, admin.Username, title, message); err != nil {
+			slog.Error("Failed to send quarantine notice", "user", admin.Username, "error", err)
+		}
+	}
+}
+
+// RemoveQuarantined deletes a quarantined file and its record
+func RemoveQuarantined(db *gorm.DB, quarantineDir string, file *models.QuarantinedFile) error {
+	if file.StoredPath != "" && quarantineDir != "" {
+		path := filepath.Join(quarantineDir, filepath.Base(file.StoredPath))
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove quarantined file: %w", err)
+		}
+	}
+	return db.Delete(file).Error
+}
+
+// moveFile renames src to dest, copying when they are on different devices
+func moveFile(src, dest string) error {
+	if err := os.Rename(src, dest); err == nil {
+		return os.Chmod(dest, 0600)
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(dest, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
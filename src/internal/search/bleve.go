@@ -0,0 +1,401 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/blevesearch/bleve/v2"
+	"github.com/blevesearch/bleve/v2/analysis/analyzer/keyword"
+	"github.com/blevesearch/bleve/v2/analysis/lang/en"
+	"github.com/blevesearch/bleve/v2/mapping"
+	"github.com/blevesearch/bleve/v2/search/query"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// BackendBleve is the on-disk Bleve index
+const BackendBleve = "bleve"
+
+// bleveLastSync is the internal index key holding the time of the last sync
+var bleveLastSync = []byte("casgists:last_sync")
+
+// bleveSyncOverlap is how far back a sync looks before the last one, so
+// changes committed while it ran are picked up by the next
+const bleveSyncOverlap = time.Minute
+
+// bleveFieldBoosts weighs matches by field, titles first and file content
+// last
+var bleveFieldBoosts = map[string]float64{
+	"title":       5,
+	"filenames":   3,
+	"tags":        3,
+	"description": 2,
+	"username":    1,
+	"content":     1,
+}
+
+// BleveProvider searches an on-disk Bleve index. Unlike the database
+// index it isn't updated by triggers: Sync indexes the gists changed since
+// the previous sync, and a Syncer runs it in the background.
+type BleveProvider struct {
+	db   *gorm.DB
+	path string
+
+	mu    sync.RWMutex
+	index bleve.Index
+}
+
+// NewBleveProvider opens the index at path, creating it if needed. A new
+// index is filled by the first Sync.
+func NewBleveProvider(db *gorm.DB, path string) (SearchProvider, error) {
+	if path == "" {
+		return nil, errors.New("search.bleve.path is not set")
+	}
+	index, err := openBleveIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	return &BleveProvider{db: db, path: path, index: index}, nil
+}
+
+func openBleveIndex(path string) (bleve.Index, error) {
+	// Fail instead of waiting when another process, such as a running
+	// server, holds the index
+	index, err := bleve.OpenUsing(path, map[string]interface{}{"bolt_timeout": "5s"})
+	if errors.Is(err, bleve.ErrorIndexPathDoesNotExist) {
+		index, err = bleve.New(path, newBleveMapping())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open search index %s: %w", path, err)
+	}
+	return index, nil
+}
+
+// newBleveMapping analyzes text fields in English and keeps the fields
+// results are filtered on as exact keywords
+func newBleveMapping() mapping.IndexMapping {
+	text := bleve.NewTextFieldMapping()
+	text.Analyzer = en.AnalyzerName
+	text.Store = false
+	exact := bleve.NewTextFieldMapping()
+	exact.Analyzer = keyword.Name
+	exact.Store = false
+	numeric := bleve.NewNumericFieldMapping()
+	numeric.Store = false
+	date := bleve.NewDateTimeFieldMapping()
+	date.Store = false
+
+	gist := bleve.NewDocumentStaticMapping()
+	for field := range bleveFieldBoosts {
+		gist.AddFieldMappingsAt(field, text)
+	}
+	for _, field := range []string{"language", "visibility", "user_id", "organization_id"} {
+		gist.AddFieldMappingsAt(field, exact)
+	}
+	gist.AddFieldMappingsAt("star_count", numeric)
+	gist.AddFieldMappingsAt("created_at", date)
+	gist.AddFieldMappingsAt("updated_at", date)
+
+	indexMapping := bleve.NewIndexMapping()
+	indexMapping.DefaultMapping = gist
+	indexMapping.DefaultAnalyzer = en.AnalyzerName
+	return indexMapping
+}
+
+// bleveDocument is the indexed form of a gist with its owner and files
+func bleveDocument(gist *models.Gist) map[string]interface{} {
+	var filenames, content []string
+	for _, file := range gist.Files {
+		// The name as a whole and its parts, main.go as main and go
+		filenames = append(filenames, file.Filename, strings.Join(parseWords(file.Filename), " "))
+		content = append(content, file.Content)
+	}
+	doc := map[string]interface{}{
+		"title":       gist.Title,
+		"description": gist.Description,
+		"tags":        gist.TagsString,
+		"filenames":   strings.Join(filenames, " "),
+		"content":     strings.Join(content, "\n"),
+		"language":    gist.Language,
+		"visibility":  string(gist.Visibility),
+		"star_count":  gist.StarCount,
+		"created_at":  gist.CreatedAt,
+		"updated_at":  gist.UpdatedAt,
+	}
+	if gist.User != nil {
+		doc["username"] = gist.User.Username
+	}
+	if gist.UserID != nil {
+		doc["user_id"] = gist.UserID.String()
+	}
+	if gist.OrganizationID != nil {
+		doc["organization_id"] = gist.OrganizationID.String()
+	}
+	return doc
+}
+
+// Index adds or replaces a gist. Its owner and files must be loaded.
+func (b *BleveProvider) Index(ctx context.Context, gist *models.Gist) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.index.Index(gist.ID.String(), bleveDocument(gist))
+}
+
+// Delete removes a gist from the index
+func (b *BleveProvider) Delete(ctx context.Context, gistID string) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.index.Delete(gistID)
+}
+
+// UpdateIndex indexes the gists changed since the last sync
+func (b *BleveProvider) UpdateIndex(ctx context.Context) error {
+	_, err := b.Sync(ctx)
+	return err
+}
+
+// Sync indexes the gists created or updated since the previous sync and
+// removes the ones deleted since, returning how many gists it processed.
+// The first sync of a new index indexes every gist.
+func (b *BleveProvider) Sync(ctx context.Context) (int, error) {
+	started := time.Now()
+	since, err := b.lastSync()
+	if err != nil {
+		return 0, err
+	}
+
+	changed := b.db.WithContext(ctx).Unscoped().Preload("User").Preload("Files").Order("id").Limit(reindexBatchSize)
+	if !since.IsZero() {
+		since = since.Add(-bleveSyncOverlap)
+		changed = changed.Where("updated_at > ? OR deleted_at > ?", since, since)
+	}
+	changed = changed.Session(&gorm.Session{})
+
+	processed := 0
+	var lastID string
+	for {
+		if err := ctx.Err(); err != nil {
+			return processed, err
+		}
+
+		var gists []models.Gist
+		batchQuery := changed
+		if lastID != "" {
+			batchQuery = batchQuery.Where("id > ?", lastID)
+		}
+		if err := batchQuery.Find(&gists).Error; err != nil {
+			return processed, fmt.Errorf("failed to load changed gists: %w", err)
+		}
+		if len(gists) == 0 {
+			break
+		}
+
+		b.mu.RLock()
+		batch := b.index.NewBatch()
+		for i := range gists {
+			if gists[i].DeletedAt.Valid {
+				batch.Delete(gists[i].ID.String())
+				continue
+			}
+			if err := batch.Index(gists[i].ID.String(), bleveDocument(&gists[i])); err != nil {
+				b.mu.RUnlock()
+				return processed, err
+			}
+		}
+		err := b.index.Batch(batch)
+		b.mu.RUnlock()
+		if err != nil {
+			return processed, fmt.Errorf("failed to index gists: %w", err)
+		}
+
+		processed += len(gists)
+		lastID = gists[len(gists)-1].ID.String()
+	}
+
+	return processed, b.setLastSync(started)
+}
+
+// Reset replaces the index with an empty one before a full reindex. The
+// reindex covers every change up to now, so the next sync starts here.
+func (b *BleveProvider) Reset(ctx context.Context) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err := b.index.Close(); err != nil {
+		return err
+	}
+	if err := os.RemoveAll(b.path); err != nil {
+		return err
+	}
+	index, err := bleve.New(b.path, newBleveMapping())
+	if err != nil {
+		return fmt.Errorf("failed to create search index %s: %w", b.path, err)
+	}
+	b.index = index
+	return b.setLastSyncLocked(time.Now())
+}
+
+// CountDocuments returns the number of gists in the index
+func (b *BleveProvider) CountDocuments(ctx context.Context) (int64, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	count, err := b.index.DocCount()
+	return int64(count), err
+}
+
+// Backend reports the Bleve backend
+func (b *BleveProvider) Backend() string {
+	return BackendBleve
+}
+
+// Close closes the index
+func (b *BleveProvider) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.index.Close()
+}
+
+// Search matches the query against the indexed fields and loads the
+// matching gists from the database, so deleted gists and gists of
+// deactivated owners that the index still holds are left out
+func (b *BleveProvider) Search(ctx context.Context, searchQuery string, filters SearchFilters) (*SearchResult, error) {
+	startTime := time.Now()
+	limit, offset := pageBounds(filters)
+
+	terms := parseTerms(searchQuery)
+	if len(terms) == 0 && strings.TrimSpace(searchQuery) != "" {
+		// Nothing searchable, only separators
+		return newSearchResult(nil, 0, searchQuery, filters, time.Since(startTime)), nil
+	}
+
+	request := bleve.NewSearchRequestOptions(bleveQuery(terms, filters), limit, offset, false)
+	switch filters.Sort {
+	case "created":
+		request.SortBy([]string{"-created_at"})
+	case "updated":
+		request.SortBy([]string{"-updated_at"})
+	case "stars":
+		request.SortBy([]string{"-star_count", "-updated_at"})
+	default:
+		if len(terms) == 0 {
+			request.SortBy([]string{"-updated_at"})
+		} else {
+			request.SortBy([]string{"-_score", "-updated_at"})
+		}
+	}
+
+	b.mu.RLock()
+	result, err := b.index.SearchInContext(ctx, request)
+	b.mu.RUnlock()
+	if err != nil {
+		return nil, fmt.Errorf("search query failed: %w", err)
+	}
+
+	ids := make([]string, len(result.Hits))
+	for i, hit := range result.Hits {
+		ids[i] = hit.ID
+	}
+	var found []models.Gist
+	if len(ids) > 0 {
+		if err := filterGists(b.db.WithContext(ctx), filters).Where("gists.id IN ?", ids).Preload("User").Find(&found).Error; err != nil {
+			return nil, fmt.Errorf("search query failed: %w", err)
+		}
+	}
+
+	// Keep the index's ranking
+	byID := make(map[string]models.Gist, len(found))
+	for _, gist := range found {
+		byID[gist.ID.String()] = gist
+	}
+	gists := make([]models.Gist, 0, len(found))
+	for _, id := range ids {
+		if gist, ok := byID[id]; ok {
+			gists = append(gists, gist)
+		}
+	}
+
+	return newSearchResult(gists, int64(result.Total), searchQuery, filters, time.Since(startTime)), nil
+}
+
+// bleveQuery matches every term in any text field and applies the filters
+func bleveQuery(terms []searchTerm, filters SearchFilters) query.Query {
+	var must []query.Query
+	for _, term := range terms {
+		var fields []query.Query
+		for field, boost := range bleveFieldBoosts {
+			var q query.Query
+			if term.prefix {
+				prefix := bleve.NewPrefixQuery(term.word)
+				prefix.SetField(field)
+				prefix.SetBoost(boost)
+				q = prefix
+			} else {
+				match := bleve.NewMatchQuery(term.word)
+				match.SetField(field)
+				match.SetBoost(boost)
+				q = match
+			}
+			fields = append(fields, q)
+		}
+		must = append(must, bleve.NewDisjunctionQuery(fields...))
+	}
+
+	if filters.Visibility != "" {
+		must = append(must, bleveTerm("visibility", filters.Visibility))
+	} else {
+		must = append(must, bleve.NewDisjunctionQuery(
+			bleveTerm("visibility", string(models.VisibilityPublic)),
+			bleveTerm("visibility", string(models.VisibilityUnlisted)),
+		))
+	}
+	if filters.UserID != "" {
+		must = append(must, bleveTerm("user_id", filters.UserID))
+	}
+	if filters.OrganizationID != "" {
+		must = append(must, bleveTerm("organization_id", filters.OrganizationID))
+	}
+	if filters.Language != "" {
+		must = append(must, bleveTerm("language", filters.Language))
+	}
+	return bleve.NewConjunctionQuery(must...)
+}
+
+func bleveTerm(field, value string) query.Query {
+	term := bleve.NewTermQuery(value)
+	term.SetField(field)
+	return term
+}
+
+func (b *BleveProvider) lastSync() (time.Time, error) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	value, err := b.index.GetInternal(bleveLastSync)
+	if err != nil || len(value) == 0 {
+		return time.Time{}, err
+	}
+	var at time.Time
+	if err := at.UnmarshalText(value); err != nil {
+		return time.Time{}, nil
+	}
+	return at, nil
+}
+
+func (b *BleveProvider) setLastSync(at time.Time) error {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.setLastSyncLocked(at)
+}
+
+func (b *BleveProvider) setLastSyncLocked(at time.Time) error {
+	value, err := at.UTC().MarshalText()
+	if err != nil {
+		return err
+	}
+	return b.index.SetInternal(bleveLastSync, value)
+}
@@ -0,0 +1,106 @@
+package search
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestBleveProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("SyncsAndRanks", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		path := filepath.Join(t.TempDir(), "search.bleve")
+		provider, err := NewBleveProvider(db, path)
+		require.NoError(t, err)
+		bleveProvider := provider.(*BleveProvider)
+
+		createSearchGist(t, db, user, "Shell helpers", "", map[string]string{"deploy.sh": "echo kubernetes rollout"})
+		createSearchGist(t, db, user, "Kubernetes cheatsheet", "", map[string]string{"notes.md": "kubectl get pods"})
+		createSearchGist(t, db, user, "Manifests", "Kubernetes deployment examples", map[string]string{"app.yaml": "kind: Deployment"})
+		private := createSearchGist(t, db, user, "Private kubernetes notes", "", nil)
+		require.NoError(t, db.Model(private).Update("visibility", models.VisibilityPrivate).Error)
+
+		// Nothing is indexed until the first sync
+		assert.Empty(t, searchTitles(t, provider, "kubernetes", SearchFilters{}))
+		processed, err := bleveProvider.Sync(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, 4, processed)
+
+		assert.Equal(t, []string{"Kubernetes cheatsheet", "Manifests", "Shell helpers"}, searchTitles(t, provider, "kubernetes", SearchFilters{}))
+		assert.Equal(t, []string{"Shell helpers"}, searchTitles(t, provider, "deploy.sh", SearchFilters{}))
+		assert.Equal(t, []string{"Manifests", "Kubernetes cheatsheet", "Shell helpers"}, searchTitles(t, provider, "kube*", SearchFilters{Sort: "created"}))
+		assert.Equal(t, []string{"Private kubernetes notes"}, searchTitles(t, provider, "notes", SearchFilters{Visibility: "private"}))
+		assert.Empty(t, searchTitles(t, provider, `" OR (`, SearchFilters{}))
+
+		// Changed gists are synced again
+		require.NoError(t, db.Model(&models.GistFile{}).Where("filename = ?", "notes.md").Update("content", "helm install").Error)
+		require.NoError(t, db.Model(&models.Gist{}).Where("title = ?", "Kubernetes cheatsheet").Update("description", "and helm").Error)
+		require.NoError(t, db.Where("title = ?", "Manifests").Delete(&models.Gist{}).Error)
+		_, err = bleveProvider.Sync(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"Kubernetes cheatsheet"}, searchTitles(t, provider, "helm install", SearchFilters{}))
+		assert.Empty(t, searchTitles(t, provider, "deployment", SearchFilters{}))
+
+		// The index and its sync position survive a restart
+		require.NoError(t, bleveProvider.Close())
+		provider, err = NewBleveProvider(db, path)
+		require.NoError(t, err)
+		defer provider.(*BleveProvider).Close()
+		count, err := provider.(*BleveProvider).CountDocuments(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), count)
+		last, err := provider.(*BleveProvider).lastSync()
+		require.NoError(t, err)
+		assert.False(t, last.IsZero())
+	})
+
+	t.Run("ManagerReindex", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		createSearchGist(t, db, user, "One", "", map[string]string{"one.txt": "first"})
+		createSearchGist(t, db, user, "Two", "", map[string]string{"two.txt": "second"})
+
+		manager, err := NewManager(db, "bleve", map[string]interface{}{"path": filepath.Join(t.TempDir(), "search.bleve")})
+		require.NoError(t, err)
+		defer manager.Close()
+		assert.Equal(t, BackendBleve, manager.Backend())
+		assert.True(t, manager.Incremental())
+
+		health, err := manager.Health(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), health.Missing)
+
+		require.NoError(t, manager.Reindex(ctx, nil))
+		health, err = manager.Health(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, IndexHealthy, health.Status)
+		assert.Equal(t, BackendBleve, health.Provider)
+		assert.Equal(t, int64(2), health.IndexedDocuments)
+
+		result, err := manager.Search(ctx, "second", SearchFilters{})
+		require.NoError(t, err)
+		require.Len(t, result.Gists, 1)
+		assert.Equal(t, "Two", result.Gists[0].Title)
+		assert.NotNil(t, result.Gists[0].User)
+		assert.Empty(t, result.Gists[0].User.PasswordHash)
+
+		// The reindex covered everything up to now, so a sync only looks
+		// back as far as the overlap
+		last, err := manager.provider.(*BleveProvider).lastSync()
+		require.NoError(t, err)
+		assert.WithinDuration(t, time.Now(), last, time.Minute)
+	})
+
+	t.Run("RequiresPath", func(t *testing.T) {
+		db, _ := setupFullTextDB(t)
+		_, err := NewManager(db, "bleve", map[string]interface{}{})
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,173 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// FullTextProvider searches the database's full-text index and ranks
+// results by relevance
+type FullTextProvider struct {
+	db      *gorm.DB
+	indexer *Indexer
+}
+
+// NewFullTextProvider creates a full-text search provider. It returns
+// ErrFullTextUnavailable when the database has no full-text support.
+func NewFullTextProvider(db *gorm.DB) (SearchProvider, error) {
+	indexer, err := NewIndexer(context.Background(), db)
+	if err != nil {
+		return nil, err
+	}
+	return &FullTextProvider{db: db, indexer: indexer}, nil
+}
+
+// Index refreshes the index document of a gist
+func (p *FullTextProvider) Index(ctx context.Context, gist *models.Gist) error {
+	return p.indexer.Refresh(ctx, gist.ID.String())
+}
+
+// Delete removes a gist from the index
+func (p *FullTextProvider) Delete(ctx context.Context, gistID string) error {
+	return p.indexer.Remove(ctx, gistID)
+}
+
+// UpdateIndex rebuilds the whole index
+func (p *FullTextProvider) UpdateIndex(ctx context.Context) error {
+	return p.indexer.Rebuild(ctx)
+}
+
+// Reset empties the index before a reindex
+func (p *FullTextProvider) Reset(ctx context.Context) error {
+	return p.indexer.Clear(ctx)
+}
+
+// CountDocuments returns the number of gists in the index
+func (p *FullTextProvider) CountDocuments(ctx context.Context) (int64, error) {
+	return p.indexer.CountDocuments(ctx)
+}
+
+// Live reports that triggers update the index with every change, so it
+// never lags behind the database
+func (p *FullTextProvider) Live() bool {
+	return true
+}
+
+// Backend returns the kind of index in use
+func (p *FullTextProvider) Backend() string {
+	return p.indexer.Backend()
+}
+
+// Search matches the query against gist titles, descriptions, tags,
+// filenames and file content. Results are ranked by relevance unless
+// another sort is requested.
+func (p *FullTextProvider) Search(ctx context.Context, query string, filters SearchFilters) (*SearchResult, error) {
+	startTime := time.Now()
+
+	dbQuery := filterGists(p.db.WithContext(ctx), filters)
+	terms := parseTerms(query)
+	if len(terms) == 0 && strings.TrimSpace(query) != "" {
+		// Nothing searchable, only separators
+		return newSearchResult(nil, 0, query, filters, time.Since(startTime)), nil
+	}
+	var rank *clause.Expr
+	if len(terms) > 0 {
+		if p.indexer.backend == BackendTSVector {
+			tsquery := tsqueryExpression(terms)
+			dbQuery = dbQuery.Where("gists.search_vector @@ to_tsquery('english', ?)", tsquery)
+			rank = &clause.Expr{SQL: "ts_rank_cd(gists.search_vector, to_tsquery('english', ?)) DESC", Vars: []interface{}{tsquery}}
+		} else {
+			dbQuery = dbQuery.Joins("JOIN gists_fts ON gists_fts.gist_id = gists.id").Where("gists_fts MATCH ?", ftsExpression(terms))
+			rank = &clause.Expr{SQL: ftsRank}
+		}
+	}
+
+	var total int64
+	if err := dbQuery.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("search count failed: %w", err)
+	}
+
+	if rank != nil && (filters.Sort == "" || filters.Sort == "relevance") {
+		rank.SQL += ", gists.updated_at DESC"
+		dbQuery = dbQuery.Clauses(clause.OrderBy{Expression: *rank})
+	} else {
+		dbQuery = sortGists(dbQuery, filters.Sort)
+	}
+
+	var gists []models.Gist
+	limit, offset := pageBounds(filters)
+	if err := dbQuery.Limit(limit).Offset(offset).Preload("User").Find(&gists).Error; err != nil {
+		return nil, fmt.Errorf("search query failed: %w", err)
+	}
+
+	return newSearchResult(gists, total, query, filters, time.Since(startTime)), nil
+}
+
+// ftsRank orders FTS5 matches best first. The weights follow the column
+// order of gists_fts: gist_id, title, description, content, username,
+// filename, language, tags.
+const ftsRank = "bm25(gists_fts, 0.0, 10.0, 4.0, 1.0, 2.0, 5.0, 2.0, 4.0)"
+
+// searchTerm is a word of a search query
+type searchTerm struct {
+	word   string
+	prefix bool
+}
+
+// parseTerms splits a query into the words the indexes store. Words are
+// runs of letters, digits and underscores; a trailing * makes the last
+// word of a run a prefix. Everything else, including FTS5 and tsquery
+// operators, only separates words, so user input can't form a malformed
+// or expensive match expression.
+func parseTerms(query string) []searchTerm {
+	var terms []searchTerm
+	for _, field := range strings.Fields(query) {
+		words := parseWords(field)
+		for i, word := range words {
+			terms = append(terms, searchTerm{
+				word:   strings.ToLower(word),
+				prefix: i == len(words)-1 && strings.HasSuffix(field, "*"),
+			})
+		}
+	}
+	return terms
+}
+
+// parseWords splits text into runs of letters, digits and underscores
+func parseWords(text string) []string {
+	return strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_'
+	})
+}
+
+// ftsExpression builds an FTS5 query matching all terms
+func ftsExpression(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = `"` + term.word + `"`
+		if term.prefix {
+			parts[i] += "*"
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// tsqueryExpression builds a tsquery matching all terms
+func tsqueryExpression(terms []searchTerm) string {
+	parts := make([]string, len(terms))
+	for i, term := range terms {
+		parts[i] = term.word
+		if term.prefix {
+			parts[i] += ":*"
+		}
+	}
+	return strings.Join(parts, " & ")
+}
@@ -0,0 +1,153 @@
+package search
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/glebarez/sqlite"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// setupFullTextDB migrates a SQLite database with the driver the server
+// uses, which has FTS5
+func setupFullTextDB(t *testing.T) (*gorm.DB, *models.User) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "search.db")), &gorm.Config{Logger: logger.Default.LogMode(logger.Silent)})
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	})
+	require.NoError(t, database.MigrateDB(db))
+
+	user := &models.User{ID: uuid.New(), Username: "octocat", Email: "octocat@example.com", PasswordHash: "x"}
+	require.NoError(t, db.Create(user).Error)
+	return db, user
+}
+
+func createSearchGist(t *testing.T, db *gorm.DB, user *models.User, title, description string, files map[string]string) *models.Gist {
+	gist := &models.Gist{Title: title, Description: description, Visibility: models.VisibilityPublic, UserID: &user.ID, GitRepoPath: "repo"}
+	for filename, content := range files {
+		gist.Files = append(gist.Files, models.GistFile{Filename: filename, Content: content})
+	}
+	require.NoError(t, db.Create(gist).Error)
+	return gist
+}
+
+func searchTitles(t *testing.T, provider SearchProvider, query string, filters SearchFilters) []string {
+	result, err := provider.Search(context.Background(), query, filters)
+	require.NoError(t, err)
+	titles := make([]string, len(result.Gists))
+	for i, gist := range result.Gists {
+		titles[i] = gist.Title
+	}
+	assert.Equal(t, int64(len(titles)), result.Total)
+	return titles
+}
+
+func TestFullTextProvider(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("IndexesExistingGists", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		createSearchGist(t, db, user, "Before the index", "", map[string]string{"main.go": "package main"})
+
+		provider, err := NewFullTextProvider(db)
+		require.NoError(t, err)
+		assert.Equal(t, BackendFTS5, provider.(*FullTextProvider).Backend())
+		assert.Equal(t, []string{"Before the index"}, searchTitles(t, provider, "package", SearchFilters{}))
+
+		// Creating the provider again keeps the index
+		provider, err = NewFullTextProvider(db)
+		require.NoError(t, err)
+		count, err := provider.(*FullTextProvider).CountDocuments(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("MatchesAllFieldsAndRanks", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		provider, err := NewFullTextProvider(db)
+		require.NoError(t, err)
+
+		createSearchGist(t, db, user, "Shell helpers", "", map[string]string{"deploy.sh": "echo kubernetes rollout"})
+		createSearchGist(t, db, user, "Kubernetes cheatsheet", "", map[string]string{"notes.md": "kubectl get pods"})
+		createSearchGist(t, db, user, "Manifests", "Kubernetes deployment examples", map[string]string{"app.yaml": "kind: Deployment"})
+		createSearchGist(t, db, user, "Unrelated", "", map[string]string{"hello.py": "print('hello')"})
+
+		// Title beats description beats content
+		assert.Equal(t, []string{"Kubernetes cheatsheet", "Manifests", "Shell helpers"}, searchTitles(t, provider, "kubernetes", SearchFilters{}))
+		assert.Equal(t, []string{"Shell helpers"}, searchTitles(t, provider, "rollout", SearchFilters{}))
+		assert.Equal(t, []string{"Unrelated"}, searchTitles(t, provider, "hello.py", SearchFilters{}))
+		assert.Equal(t, []string{"Manifests", "Kubernetes cheatsheet", "Shell helpers"}, searchTitles(t, provider, "kube*", SearchFilters{Sort: "created"}))
+		assert.Equal(t, []string{"Manifests"}, searchTitles(t, provider, "kubernetes deployment", SearchFilters{}))
+		assert.Empty(t, searchTitles(t, provider, `" OR NEAR(`, SearchFilters{}))
+		assert.Len(t, searchTitles(t, provider, "", SearchFilters{}), 4)
+	})
+
+	t.Run("TriggersFollowChanges", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		provider, err := NewFullTextProvider(db)
+		require.NoError(t, err)
+
+		gist := createSearchGist(t, db, user, "Draft", "", map[string]string{"a.txt": "alpha"})
+		other := createSearchGist(t, db, user, "Other", "", map[string]string{"b.txt": "beta"})
+
+		require.NoError(t, db.Model(&models.GistFile{}).Where("gist_id = ?", gist.ID).Update("content", "gamma").Error)
+		assert.Empty(t, searchTitles(t, provider, "alpha", SearchFilters{}))
+		assert.Equal(t, []string{"Draft"}, searchTitles(t, provider, "gamma", SearchFilters{}))
+
+		require.NoError(t, db.Model(gist).Update("title", "Final").Error)
+		assert.Equal(t, []string{"Final"}, searchTitles(t, provider, "final", SearchFilters{}))
+
+		require.NoError(t, db.Model(user).Update("username", "hubot").Error)
+		assert.Len(t, searchTitles(t, provider, "hubot", SearchFilters{}), 2)
+
+		require.NoError(t, db.Delete(gist).Error)
+		assert.Empty(t, searchTitles(t, provider, "gamma", SearchFilters{}))
+
+		require.NoError(t, db.Where("gist_id = ?", other.ID).Delete(&models.GistFile{}).Error)
+		assert.Empty(t, searchTitles(t, provider, "beta", SearchFilters{}))
+
+		count, err := provider.(*FullTextProvider).CountDocuments(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), count)
+	})
+
+	t.Run("ReindexAndHealth", func(t *testing.T) {
+		db, user := setupFullTextDB(t)
+		createSearchGist(t, db, user, "One", "", nil)
+		createSearchGist(t, db, user, "Two", "", nil)
+
+		manager, err := NewManager(db, "sqlite_fts", nil)
+		require.NoError(t, err)
+		assert.Equal(t, BackendFTS5, manager.Backend())
+
+		require.NoError(t, db.Exec(`DELETE FROM gists_fts`).Error)
+		health, err := manager.Health(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, IndexDegraded, health.Status)
+		assert.Equal(t, int64(2), health.Missing)
+
+		require.NoError(t, manager.Reindex(ctx, nil))
+		health, err = manager.Health(ctx, 0)
+		require.NoError(t, err)
+		assert.Equal(t, IndexHealthy, health.Status)
+		assert.Equal(t, BackendFTS5, health.Provider)
+		assert.Equal(t, int64(2), health.IndexedDocuments)
+	})
+}
+
+func TestParseTerms(t *testing.T) {
+	terms := parseTerms(`Hello, wörld! foo-bar* "x" NEAR(`)
+	assert.Equal(t, `"hello" "wörld" "foo" "bar"* "x" "near"`, ftsExpression(terms))
+	assert.Equal(t, `hello & wörld & foo & bar:* & x & near`, tsqueryExpression(terms))
+	assert.Empty(t, parseTerms(`* ( ) "`))
+}
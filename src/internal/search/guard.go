@@ -0,0 +1,233 @@
+package search
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+var (
+	// ErrQueryTooComplex is returned when a query exceeds the configured cost limits
+	ErrQueryTooComplex = errors.New("search query is too complex")
+	// ErrTooManySearches is returned when a caller already has the maximum
+	// number of searches running
+	ErrTooManySearches = errors.New("too many concurrent searches")
+)
+
+// GuardConfig limits how expensive a single search can be
+type GuardConfig struct {
+	MaxQueryLength    int           // Maximum query length in characters
+	MaxTerms          int           // Maximum number of whitespace-separated terms
+	MaxWildcards      int           // Maximum number of * and ? wildcards
+	MinWildcardPrefix int           // Literal characters required before the first wildcard of a term
+	MaxConcurrent     int           // Concurrent searches per user (or IP when anonymous)
+	Timeout           time.Duration // Queries running longer than this are cancelled
+	CacheTTL          time.Duration // How long popular query results are cached
+	PopularThreshold  int           // Searches within CacheTTL before a query's results are cached
+}
+
+// GuardConfigFromViper reads the guard configuration from search.guard.*
+func GuardConfigFromViper(cfg *config.Config) GuardConfig {
+	return GuardConfig{
+		MaxQueryLength:    cfg.GetInt("search.guard.max_query_length"),
+		MaxTerms:          cfg.GetInt("search.guard.max_terms"),
+		MaxWildcards:      cfg.GetInt("search.guard.max_wildcards"),
+		MinWildcardPrefix: cfg.GetInt("search.guard.min_wildcard_prefix"),
+		MaxConcurrent:     cfg.GetInt("search.guard.max_concurrent_per_user"),
+		Timeout:           cfg.GetDuration("search.guard.timeout"),
+		CacheTTL:          cfg.GetDuration("search.guard.cache_ttl"),
+		PopularThreshold:  cfg.GetInt("search.guard.popular_threshold"),
+	}
+}
+
+// QueryCost describes the parts of a query that make it expensive
+type QueryCost struct {
+	Length    int `json:"length"`
+	Terms     int `json:"terms"`
+	Wildcards int `json:"wildcards"`
+}
+
+// resultStore is the subset of the cache API the guard needs
+type resultStore interface {
+	GetJSON(ctx context.Context, key string, dest interface{}) error
+	SetJSON(ctx context.Context, key string, value interface{}, ttl time.Duration) error
+}
+
+// QueryGuard rejects pathological queries, caps concurrent searches per
+// caller and caches the results of popular queries
+type QueryGuard struct {
+	cfg   GuardConfig
+	cache resultStore
+
+	mu     sync.Mutex
+	active map[string]int
+	hits   map[string]*queryHits
+}
+
+type queryHits struct {
+	count int
+	since time.Time
+}
+
+// NewQueryGuard creates a new query guard. Popular results are stored in the
+// shared cache when it is enabled, and in process memory otherwise.
+func NewQueryGuard(cfg GuardConfig, cacheManager *cache.CacheManager) *QueryGuard {
+	var store resultStore = cache.NewMemoryCache()
+	if cacheManager != nil && cacheManager.Enabled() {
+		store = cacheManager
+	}
+
+	return &QueryGuard{
+		cfg:    cfg,
+		cache:  store,
+		active: make(map[string]int),
+		hits:   make(map[string]*queryHits),
+	}
+}
+
+// Analyze returns the cost of a query
+func Analyze(query string) QueryCost {
+	return QueryCost{
+		Length:    len([]rune(query)),
+		Terms:     len(strings.Fields(query)),
+		Wildcards: strings.Count(query, "*") + strings.Count(query, "?"),
+	}
+}
+
+// Check returns an error wrapping ErrQueryTooComplex if the query exceeds
+// any of the configured limits
+func (g *QueryGuard) Check(query string) error {
+	cost := Analyze(query)
+
+	if g.cfg.MaxQueryLength > 0 && cost.Length > g.cfg.MaxQueryLength {
+		return fmt.Errorf("%w: longer than %d characters", ErrQueryTooComplex, g.cfg.MaxQueryLength)
+	}
+	if g.cfg.MaxTerms > 0 && cost.Terms > g.cfg.MaxTerms {
+		return fmt.Errorf("%w: more than %d terms", ErrQueryTooComplex, g.cfg.MaxTerms)
+	}
+	if g.cfg.MaxWildcards > 0 && cost.Wildcards > g.cfg.MaxWildcards {
+		return fmt.Errorf("%w: more than %d wildcards", ErrQueryTooComplex, g.cfg.MaxWildcards)
+	}
+
+	// A term that is mostly wildcards matches nearly every row
+	if g.cfg.MinWildcardPrefix > 0 {
+		for _, term := range strings.Fields(query) {
+			if i := strings.IndexAny(term, "*?"); i >= 0 && i < g.cfg.MinWildcardPrefix {
+				return fmt.Errorf("%w: wildcards need at least %d leading characters", ErrQueryTooComplex, g.cfg.MinWildcardPrefix)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Acquire reserves a search slot for the caller. The returned function must
+// be called when the search finishes.
+func (g *QueryGuard) Acquire(caller string) (func(), error) {
+	if g.cfg.MaxConcurrent <= 0 {
+		return func() {}, nil
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.active[caller] >= g.cfg.MaxConcurrent {
+		return nil, ErrTooManySearches
+	}
+	g.active[caller]++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			g.mu.Lock()
+			defer g.mu.Unlock()
+			if g.active[caller]--; g.active[caller] <= 0 {
+				delete(g.active, caller)
+			}
+		})
+	}, nil
+}
+
+// WithTimeout bounds the context by the configured query timeout
+func (g *QueryGuard) WithTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if g.cfg.Timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, g.cfg.Timeout)
+}
+
+// Cached returns cached results for the query, if any
+func (g *QueryGuard) Cached(ctx context.Context, query string, filters SearchFilters) (*SearchResult, bool) {
+	if g.cfg.CacheTTL <= 0 {
+		return nil, false
+	}
+
+	var result SearchResult
+	if err := g.cache.GetJSON(ctx, g.cacheKey(query, filters), &result); err != nil {
+		return nil, false
+	}
+	return &result, true
+}
+
+// Record counts a search and caches its results once the query has been
+// seen PopularThreshold times within CacheTTL
+func (g *QueryGuard) Record(ctx context.Context, query string, filters SearchFilters, result *SearchResult) {
+	if g.cfg.CacheTTL <= 0 {
+		return
+	}
+
+	key := g.cacheKey(query, filters)
+	now := time.Now()
+
+	g.mu.Lock()
+	hits, ok := g.hits[key]
+	if !ok || now.Sub(hits.since) > g.cfg.CacheTTL {
+		hits = &queryHits{since: now}
+		g.hits[key] = hits
+	}
+	hits.count++
+	popular := hits.count >= g.cfg.PopularThreshold
+	if popular {
+		delete(g.hits, key)
+	}
+	g.pruneHits(now)
+	g.mu.Unlock()
+
+	if popular {
+		g.cache.SetJSON(ctx, key, result, g.cfg.CacheTTL)
+	}
+}
+
+// pruneHits drops counters that have outlived the cache window. Callers
+// must hold g.mu.
+func (g *QueryGuard) pruneHits(now time.Time) {
+	const maxTracked = 10000
+	if len(g.hits) < maxTracked {
+		return
+	}
+	for key, hits := range g.hits {
+		if now.Sub(hits.since) > g.cfg.CacheTTL {
+			delete(g.hits, key)
+		}
+	}
+}
+
+// cacheKey identifies a normalized query and its filters
+func (g *QueryGuard) cacheKey(query string, filters SearchFilters) string {
+	normalized := strings.Join(strings.Fields(strings.ToLower(query)), " ")
+	data, _ := json.Marshal(struct {
+		Query   string
+		Filters SearchFilters
+	}{normalized, filters})
+	sum := sha256.Sum256(data)
+	return cache.SearchKey(hex.EncodeToString(sum[:16]))
+}
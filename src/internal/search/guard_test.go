@@ -0,0 +1,75 @@
+package search
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueryGuard(t *testing.T) {
+	guard := NewQueryGuard(GuardConfig{
+		MaxQueryLength:    40,
+		MaxTerms:          3,
+		MaxWildcards:      2,
+		MinWildcardPrefix: 2,
+		MaxConcurrent:     1,
+		CacheTTL:          time.Minute,
+		PopularThreshold:  2,
+	}, nil)
+
+	t.Run("Check", func(t *testing.T) {
+		assert.NoError(t, guard.Check("flask app*"))
+		assert.ErrorIs(t, guard.Check("one two three four"), ErrQueryTooComplex)
+		assert.ErrorIs(t, guard.Check("ab* cd* ef*"), ErrQueryTooComplex)
+		assert.ErrorIs(t, guard.Check("*py"), ErrQueryTooComplex)
+		assert.ErrorIs(t, guard.Check(strings.Repeat("a", 41)), ErrQueryTooComplex)
+	})
+
+	t.Run("Acquire", func(t *testing.T) {
+		release, err := guard.Acquire("user:1")
+		require.NoError(t, err)
+
+		_, err = guard.Acquire("user:1")
+		assert.ErrorIs(t, err, ErrTooManySearches)
+
+		// Other callers are unaffected
+		other, err := guard.Acquire("user:2")
+		require.NoError(t, err)
+		other()
+
+		release()
+		release() // Releasing twice must not free an extra slot
+		again, err := guard.Acquire("user:1")
+		require.NoError(t, err)
+		_, err = guard.Acquire("user:1")
+		assert.ErrorIs(t, err, ErrTooManySearches)
+		again()
+	})
+
+	t.Run("PopularQueriesAreCached", func(t *testing.T) {
+		ctx := context.Background()
+		filters := SearchFilters{Limit: 20}
+		result := &SearchResult{Total: 7, Query: "flask"}
+
+		guard.Record(ctx, "flask", filters, result)
+		_, ok := guard.Cached(ctx, "flask", filters)
+		assert.False(t, ok, "cached before reaching the popularity threshold")
+
+		guard.Record(ctx, "Flask ", filters, result)
+		cached, ok := guard.Cached(ctx, "  FLASK", filters)
+		require.True(t, ok)
+		assert.Equal(t, int64(7), cached.Total)
+
+		_, ok = guard.Cached(ctx, "flask", SearchFilters{Limit: 20, Offset: 20})
+		assert.False(t, ok, "different filters share a cache entry")
+	})
+}
+
+func TestTextSearchArgs(t *testing.T) {
+	args := textSearchArgs("Read*Me_1?%")
+	assert.Equal(t, "%read%me!_1_!%%", args[0])
+}
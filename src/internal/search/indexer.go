@@ -0,0 +1,265 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Full-text backends, as reported in the health check
+const (
+	BackendFTS5     = "sqlite_fts5"
+	BackendTSVector = "postgres_tsvector"
+	BackendLike     = "like"
+)
+
+// ErrFullTextUnavailable is returned when the database has no full-text
+// index support: MySQL, or SQLite built without FTS5
+var ErrFullTextUnavailable = errors.New("full-text search is not available on this database")
+
+// Indexer maintains the full-text index of gist titles, descriptions, tags,
+// filenames and file content. The index lives in the database and is kept
+// current by triggers, so writes made outside the services are indexed too:
+// an FTS5 table on SQLite and a weighted tsvector column on PostgreSQL.
+type Indexer struct {
+	db      *gorm.DB
+	backend string
+}
+
+// NewIndexer creates the index schema if needed, filling the index when it
+// is new
+func NewIndexer(ctx context.Context, db *gorm.DB) (*Indexer, error) {
+	indexer := &Indexer{db: db}
+	switch db.Dialector.Name() {
+	case "sqlite":
+		indexer.backend = BackendFTS5
+	case "postgres":
+		indexer.backend = BackendTSVector
+	default:
+		return nil, ErrFullTextUnavailable
+	}
+
+	if err := indexer.ensure(ctx); err != nil {
+		return nil, err
+	}
+	return indexer, nil
+}
+
+// Backend returns the kind of index in use
+func (x *Indexer) Backend() string {
+	return x.backend
+}
+
+// Refresh rebuilds the index document of a gist
+func (x *Indexer) Refresh(ctx context.Context, gistID string) error {
+	db := x.db.WithContext(ctx)
+	if x.backend == BackendTSVector {
+		return db.Exec(`UPDATE gists SET search_vector = `+tsvectorDocument+` WHERE id = ?`, gistID).Error
+	}
+	return db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Exec(`DELETE FROM gists_fts WHERE gist_id = ?`, gistID).Error; err != nil {
+			return err
+		}
+		return tx.Exec(ftsDocument+` AND g.id = ?`, gistID).Error
+	})
+}
+
+// Remove drops a gist from the index
+func (x *Indexer) Remove(ctx context.Context, gistID string) error {
+	if x.backend == BackendTSVector {
+		return x.db.WithContext(ctx).Exec(`UPDATE gists SET search_vector = NULL WHERE id = ?`, gistID).Error
+	}
+	return x.db.WithContext(ctx).Exec(`DELETE FROM gists_fts WHERE gist_id = ?`, gistID).Error
+}
+
+// Clear empties the index
+func (x *Indexer) Clear(ctx context.Context) error {
+	if x.backend == BackendTSVector {
+		return x.db.WithContext(ctx).Exec(`UPDATE gists SET search_vector = NULL WHERE search_vector IS NOT NULL`).Error
+	}
+	return x.db.WithContext(ctx).Exec(`DELETE FROM gists_fts`).Error
+}
+
+// Rebuild reindexes every gist in one statement
+func (x *Indexer) Rebuild(ctx context.Context) error {
+	return x.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		return x.rebuild(tx)
+	})
+}
+
+// CountDocuments returns the number of gists in the index
+func (x *Indexer) CountDocuments(ctx context.Context) (int64, error) {
+	var count int64
+	query := `SELECT COUNT(*) FROM gists_fts`
+	if x.backend == BackendTSVector {
+		query = `SELECT COUNT(*) FROM gists WHERE search_vector IS NOT NULL AND deleted_at IS NULL`
+	}
+	err := x.db.WithContext(ctx).Raw(query).Scan(&count).Error
+	return count, err
+}
+
+func (x *Indexer) rebuild(tx *gorm.DB) error {
+	if x.backend == BackendTSVector {
+		return tx.Exec(`UPDATE gists SET search_vector = ` + tsvectorDocument).Error
+	}
+	if err := tx.Exec(`DELETE FROM gists_fts`).Error; err != nil {
+		return err
+	}
+	return tx.Exec(ftsDocument).Error
+}
+
+// ensure creates the index and (re)creates its triggers, so trigger changes
+// reach existing databases on the next start
+func (x *Indexer) ensure(ctx context.Context) error {
+	return x.db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var statements []string
+		var existing int64
+		if x.backend == BackendTSVector {
+			if err := tx.Raw(`SELECT COUNT(*) FROM information_schema.columns WHERE table_name = 'gists' AND column_name = 'search_vector'`).Scan(&existing).Error; err != nil {
+				return err
+			}
+			statements = tsvectorSchema
+		} else {
+			if err := tx.Raw(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name = 'gist_files_search_insert'`).Scan(&existing).Error; err != nil {
+				return err
+			}
+			statements = ftsSchema
+		}
+
+		for _, stmt := range statements {
+			if err := tx.Exec(stmt).Error; err != nil {
+				if strings.Contains(err.Error(), "no such module: fts5") {
+					return ErrFullTextUnavailable
+				}
+				return fmt.Errorf("failed to create search index: %w", err)
+			}
+		}
+
+		// A new index starts empty
+		if existing == 0 {
+			if err := x.rebuild(tx); err != nil {
+				return fmt.Errorf("failed to fill search index: %w", err)
+			}
+		}
+		return nil
+	})
+}
+
+// ftsDocument inserts the index rows of the gists it selects. Filenames and
+// contents of all files are concatenated into one column each.
+const ftsDocument = `INSERT INTO gists_fts (gist_id, title, description, content, username, filename, language, tags)
+SELECT g.id, COALESCE(g.title, ''), COALESCE(g.description, ''),
+	COALESCE((SELECT group_concat(f.content, char(10)) FROM gist_files f WHERE f.gist_id = g.id), ''),
+	COALESCE((SELECT u.username FROM users u WHERE u.id = g.user_id), ''),
+	COALESCE((SELECT group_concat(f.filename, ' ') FROM gist_files f WHERE f.gist_id = g.id), ''),
+	COALESCE(g.language, ''), COALESCE(g.tags_string, '')
+FROM gists g WHERE g.deleted_at IS NULL`
+
+// ftsRefresh replaces the index row of one gist inside a trigger
+func ftsRefresh(gistID string) string {
+	return fmt.Sprintf("DELETE FROM gists_fts WHERE gist_id = %s;\n%s AND g.id = %s;", gistID, ftsDocument, gistID)
+}
+
+// ftsSchema is the SQLite index. The table is the one created by the
+// 000002 migration; its triggers only indexed titles and descriptions and
+// are replaced.
+var ftsSchema = []string{
+	`CREATE VIRTUAL TABLE IF NOT EXISTS gists_fts USING fts5(
+		gist_id UNINDEXED, title, description, content, username, filename, language, tags,
+		tokenize='porter unicode61'
+	)`,
+	`DROP TRIGGER IF EXISTS gists_fts_insert`,
+	`DROP TRIGGER IF EXISTS gists_fts_update`,
+	`DROP TRIGGER IF EXISTS gists_fts_delete`,
+	`DROP TRIGGER IF EXISTS gists_fts_soft_delete`,
+	`DROP TRIGGER IF EXISTS gists_search_insert`,
+	`CREATE TRIGGER gists_search_insert AFTER INSERT ON gists BEGIN
+		` + ftsRefresh("NEW.id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS gists_search_update`,
+	`CREATE TRIGGER gists_search_update AFTER UPDATE OF title, description, language, tags_string, user_id, deleted_at ON gists BEGIN
+		` + ftsRefresh("NEW.id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS gists_search_delete`,
+	`CREATE TRIGGER gists_search_delete AFTER DELETE ON gists BEGIN
+		DELETE FROM gists_fts WHERE gist_id = OLD.id;
+	END`,
+	`DROP TRIGGER IF EXISTS gist_files_search_insert`,
+	`CREATE TRIGGER gist_files_search_insert AFTER INSERT ON gist_files BEGIN
+		` + ftsRefresh("NEW.gist_id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS gist_files_search_update`,
+	`CREATE TRIGGER gist_files_search_update AFTER UPDATE OF gist_id, filename, content ON gist_files BEGIN
+		` + ftsRefresh("OLD.gist_id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS gist_files_search_move`,
+	`CREATE TRIGGER gist_files_search_move AFTER UPDATE OF gist_id ON gist_files WHEN OLD.gist_id <> NEW.gist_id BEGIN
+		` + ftsRefresh("NEW.gist_id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS gist_files_search_delete`,
+	`CREATE TRIGGER gist_files_search_delete AFTER DELETE ON gist_files BEGIN
+		` + ftsRefresh("OLD.gist_id") + `
+	END`,
+	`DROP TRIGGER IF EXISTS users_search_rename`,
+	`CREATE TRIGGER users_search_rename AFTER UPDATE OF username ON users BEGIN
+		UPDATE gists_fts SET username = NEW.username WHERE gist_id IN (SELECT id FROM gists WHERE user_id = NEW.id);
+	END`,
+}
+
+// tsvectorDocument computes the search vector of the gists row being
+// updated. Titles weigh most, then descriptions, tags and filenames, then
+// the language and owner, then file content.
+const tsvectorDocument = `gist_search_document(id, title, description, language, tags_string, user_id)`
+
+// tsvectorSchema is the PostgreSQL index
+var tsvectorSchema = []string{
+	`ALTER TABLE gists ADD COLUMN IF NOT EXISTS search_vector tsvector`,
+	`CREATE INDEX IF NOT EXISTS idx_gists_search_vector ON gists USING GIN (search_vector)`,
+	`CREATE OR REPLACE FUNCTION gist_search_document(p_id VARCHAR, p_title TEXT, p_description TEXT, p_language TEXT, p_tags TEXT, p_user_id VARCHAR)
+	RETURNS tsvector AS $$
+		SELECT setweight(to_tsvector('english', COALESCE(p_title, '')), 'A')
+			|| setweight(to_tsvector('english', COALESCE(p_description, '') || ' ' || COALESCE(p_tags, '')), 'B')
+			|| setweight(to_tsvector('english', COALESCE((SELECT string_agg(filename || ' ' || regexp_replace(filename, '\W+', ' ', 'g'), ' ') FROM gist_files WHERE gist_id = p_id), '')), 'B')
+			|| setweight(to_tsvector('english', COALESCE(p_language, '') || ' ' || COALESCE((SELECT username FROM users WHERE id = p_user_id), '')), 'C')
+			|| setweight(to_tsvector('english', COALESCE((SELECT string_agg(content, E'\n') FROM gist_files WHERE gist_id = p_id), '')), 'D')
+	$$ LANGUAGE sql STABLE`,
+	`CREATE OR REPLACE FUNCTION gists_search_vector_refresh() RETURNS trigger AS $$
+	BEGIN
+		NEW.search_vector := gist_search_document(NEW.id, NEW.title, NEW.description, NEW.language, NEW.tags_string, NEW.user_id);
+		RETURN NEW;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION gist_files_search_vector_refresh() RETURNS trigger AS $$
+	BEGIN
+		IF TG_OP <> 'DELETE' THEN
+			UPDATE gists SET search_vector = ` + tsvectorDocument + ` WHERE id = NEW.gist_id;
+		END IF;
+		IF TG_OP = 'DELETE' THEN
+			UPDATE gists SET search_vector = ` + tsvectorDocument + ` WHERE id = OLD.gist_id;
+		ELSIF TG_OP = 'UPDATE' THEN
+			IF OLD.gist_id <> NEW.gist_id THEN
+				UPDATE gists SET search_vector = ` + tsvectorDocument + ` WHERE id = OLD.gist_id;
+			END IF;
+		END IF;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`CREATE OR REPLACE FUNCTION users_search_vector_refresh() RETURNS trigger AS $$
+	BEGIN
+		UPDATE gists SET search_vector = ` + tsvectorDocument + ` WHERE user_id = NEW.id;
+		RETURN NULL;
+	END
+	$$ LANGUAGE plpgsql`,
+	`DROP TRIGGER IF EXISTS gists_search_vector ON gists`,
+	`CREATE TRIGGER gists_search_vector BEFORE INSERT OR UPDATE OF title, description, language, tags_string, user_id ON gists
+	FOR EACH ROW EXECUTE PROCEDURE gists_search_vector_refresh()`,
+	`DROP TRIGGER IF EXISTS gist_files_search_vector ON gist_files`,
+	`CREATE TRIGGER gist_files_search_vector AFTER INSERT OR UPDATE OF gist_id, filename, content OR DELETE ON gist_files
+	FOR EACH ROW EXECUTE PROCEDURE gist_files_search_vector_refresh()`,
+	`DROP TRIGGER IF EXISTS users_search_vector ON users`,
+	`CREATE TRIGGER users_search_vector AFTER UPDATE OF username ON users
+	FOR EACH ROW WHEN (OLD.username IS DISTINCT FROM NEW.username) EXECUTE PROCEDURE users_search_vector_refresh()`,
+}
@@ -2,17 +2,28 @@ package search
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"log/slog"
+	"sync"
+	"time"
 
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
 // Manager handles search operations across different search providers
 type Manager struct {
-	db       *gorm.DB
-	provider SearchProvider
+	db           *gorm.DB
+	provider     SearchProvider
+	providerType string
+	guard        *QueryGuard
+
+	mu            sync.Mutex
+	reindex       ReindexStatus
+	lastIndexedAt time.Time
 }
 
 // SearchProvider interface for different search implementations
@@ -55,13 +66,22 @@ func NewManager(db *gorm.DB, providerType string, config map[string]interface{})
 
 	switch providerType {
 	case "sqlite_fts":
-		provider, err = NewSQLiteProvider(db)
+		// PostgreSQL and SQLite have a full-text index, other databases
+		// fall back to LIKE queries
+		provider, err = NewFullTextProvider(db)
+		if errors.Is(err, ErrFullTextUnavailable) {
+			slog.Warn("Full-text search unavailable, falling back to LIKE search", "database", db.Dialector.Name())
+			provider, err = NewSQLiteProvider(db)
+		}
 	case "redis":
 		if url, ok := config["url"].(string); ok {
 			provider, err = NewRedisProvider(db, url)
 		} else {
 			err = fmt.Errorf("Redis URL required in config")
 		}
+	case "bleve":
+		path, _ := config["path"].(string)
+		provider, err = NewBleveProvider(db, path)
 	case "elasticsearch":
 		provider, err = NewElasticsearchProvider(config)
 	default:
@@ -73,14 +93,59 @@ func NewManager(db *gorm.DB, providerType string, config map[string]interface{})
 	}
 
 	return &Manager{
-		db:       db,
-		provider: provider,
+		db:           db,
+		provider:     provider,
+		providerType: providerType,
 	}, nil
 }
 
+// Backend returns the search backend in use, such as sqlite_fts5,
+// postgres_tsvector or like
+func (m *Manager) Backend() string {
+	if named, ok := m.provider.(interface{ Backend() string }); ok {
+		return named.Backend()
+	}
+	return m.providerType
+}
+
+// BackendFromViper returns the provider type and provider config for
+// NewManager: the database's full-text index unless search.backend is bleve
+func BackendFromViper(cfg *config.Config) (string, map[string]interface{}) {
+	if cfg.GetString("search.backend") == "bleve" {
+		return "bleve", map[string]interface{}{"path": cfg.GetString("search.bleve.path")}
+	}
+	return "sqlite_fts", nil
+}
+
 // IndexGist adds or updates a gist in the search index
 func (m *Manager) IndexGist(ctx context.Context, gist *models.Gist) error {
-	return m.provider.Index(ctx, gist)
+	if err := m.provider.Index(ctx, gist); err != nil {
+		return err
+	}
+	m.markIndexed(time.Now())
+	return nil
+}
+
+// SetGuard enables query cost limits and popular query caching
+func (m *Manager) SetGuard(guard *QueryGuard) {
+	m.guard = guard
+}
+
+// Acquire reserves one of the caller's concurrent search slots. The
+// returned function releases it.
+func (m *Manager) Acquire(caller string) (func(), error) {
+	if m.guard == nil {
+		return func() {}, nil
+	}
+	return m.guard.Acquire(caller)
+}
+
+// Check returns an error if the query exceeds the configured cost limits
+func (m *Manager) Check(query string) error {
+	if m.guard == nil {
+		return nil
+	}
+	return m.guard.Check(query)
 }
 
 // Search performs a search query
@@ -92,7 +157,46 @@ func (m *Manager) Search(ctx context.Context, query string, filters SearchFilter
 		filters.Limit = 100
 	}
 
-	return m.provider.Search(ctx, query, filters)
+	if m.guard == nil {
+		result, err := m.provider.Search(ctx, query, filters)
+		if err == nil {
+			redactUsers(result)
+		}
+		return result, err
+	}
+
+	if err := m.guard.Check(query); err != nil {
+		return nil, err
+	}
+	if result, ok := m.guard.Cached(ctx, query, filters); ok {
+		return result, nil
+	}
+
+	searchCtx, cancel := m.guard.WithTimeout(ctx)
+	defer cancel()
+
+	result, err := m.provider.Search(searchCtx, query, filters)
+	if err != nil {
+		return nil, err
+	}
+	redactUsers(result)
+
+	m.guard.Record(ctx, query, filters, result)
+	return result, nil
+}
+
+// redactUsers clears credentials from the gist owners loaded with results
+// before they are returned or cached
+func redactUsers(result *SearchResult) {
+	if result == nil {
+		return
+	}
+	for _, gist := range result.Gists {
+		if gist != nil && gist.User != nil {
+			gist.User.PasswordHash = ""
+			gist.User.TwoFactorSecret = ""
+		}
+	}
 }
 
 // DeleteGist removes a gist from the search index
@@ -105,6 +209,33 @@ func (m *Manager) UpdateIndex(ctx context.Context) error {
 	return m.provider.UpdateIndex(ctx)
 }
 
+// incrementalIndex is implemented by providers the database doesn't keep
+// current, which catch up on changes when synced
+type incrementalIndex interface {
+	Sync(ctx context.Context) (int, error)
+}
+
+// Incremental reports whether the index has to be synced to follow changes
+func (m *Manager) Incremental() bool {
+	_, ok := m.provider.(incrementalIndex)
+	return ok
+}
+
+// Sync indexes the gists changed since the last sync, returning how many
+// it processed. It does nothing for indexes the database keeps current.
+func (m *Manager) Sync(ctx context.Context) (int, error) {
+	syncer, ok := m.provider.(incrementalIndex)
+	if !ok {
+		return 0, nil
+	}
+	started := time.Now()
+	processed, err := syncer.Sync(ctx)
+	if err == nil {
+		m.markIndexed(started)
+	}
+	return processed, err
+}
+
 // SearchGists is a convenience method for searching gists with pagination
 func (m *Manager) SearchGists(ctx context.Context, query string, userID string, page, limit int) (*SearchResult, error) {
 	if page < 1 {
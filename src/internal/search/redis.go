@@ -400,17 +400,10 @@ func (r *RedisProvider) Delete(ctx context.Context, gistID string) error {
 // UpdateIndex rebuilds the entire search index
 func (r *RedisProvider) UpdateIndex(ctx context.Context) error {
 	// Clear existing index
-	keys, err := r.client.Keys(ctx, r.prefix+"*").Result()
-	if err != nil {
+	if err := r.Reset(ctx); err != nil {
 		return err
 	}
 
-	if len(keys) > 0 {
-		if err := r.client.Del(ctx, keys...).Err(); err != nil {
-			return err
-		}
-	}
-
 	// Reindex all gists
 	var gists []models.Gist
 	if err := r.db.Preload("User").Preload("Files").Where("deleted_at IS NULL").Find(&gists).Error; err != nil {
@@ -429,6 +422,28 @@ func (r *RedisProvider) UpdateIndex(ctx context.Context) error {
 	return nil
 }
 
+// Reset removes every document and index key
+func (r *RedisProvider) Reset(ctx context.Context) error {
+	keys, err := r.client.Keys(ctx, r.prefix+"*").Result()
+	if err != nil {
+		return err
+	}
+
+	if len(keys) > 0 {
+		return r.client.Del(ctx, keys...).Err()
+	}
+	return nil
+}
+
+// CountDocuments returns the number of gists in the index
+func (r *RedisProvider) CountDocuments(ctx context.Context) (int64, error) {
+	keys, err := r.client.Keys(ctx, r.prefix+"gist:*").Result()
+	if err != nil {
+		return 0, err
+	}
+	return int64(len(keys)), nil
+}
+
 // GetSearchStats returns search statistics
 func (r *RedisProvider) GetSearchStats(ctx context.Context) (map[string]interface{}, error) {
 	stats := make(map[string]interface{})
@@ -0,0 +1,282 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// ErrReindexInProgress is returned when a reindex is requested while another
+// one is still running
+var ErrReindexInProgress = errors.New("a search reindex is already running")
+
+// reindexBatchSize is the number of gists loaded per batch during a reindex
+const reindexBatchSize = 200
+
+// Index health states
+const (
+	IndexHealthy  = "healthy"
+	IndexDegraded = "degraded"
+)
+
+// ReindexStatus reports the progress of the running or most recent reindex
+type ReindexStatus struct {
+	Running    bool       `json:"running"`
+	Total      int64      `json:"total"`
+	Indexed    int64      `json:"indexed"`
+	Failed     int64      `json:"failed"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+	Error      string     `json:"error,omitempty"`
+}
+
+// Percent returns how much of the reindex has been processed
+func (s ReindexStatus) Percent() float64 {
+	if s.Total == 0 {
+		if s.FinishedAt != nil {
+			return 100
+		}
+		return 0
+	}
+	return float64(s.Indexed+s.Failed) * 100 / float64(s.Total)
+}
+
+// IndexHealth compares the search index with the gists in the database
+type IndexHealth struct {
+	Status           string        `json:"status"`
+	Provider         string        `json:"provider"`
+	IndexedDocuments int64         `json:"indexed_documents"`
+	DatabaseGists    int64         `json:"database_gists"`
+	Missing          int64         `json:"missing"`
+	LastIndexedAt    *time.Time    `json:"last_indexed_at,omitempty"`
+	LagSeconds       int64         `json:"lag_seconds"`
+	Problems         []string      `json:"problems,omitempty"`
+	Reindex          ReindexStatus `json:"reindex"`
+}
+
+// documentCounter is implemented by providers that can report how many
+// documents their index holds
+type documentCounter interface {
+	CountDocuments(ctx context.Context) (int64, error)
+}
+
+// indexResetter is implemented by providers whose index must be cleared
+// before a full rebuild
+type indexResetter interface {
+	Reset(ctx context.Context) error
+}
+
+// liveIndex is implemented by providers that query the database directly
+// or whose index the database updates itself, so it can never fall behind
+type liveIndex interface {
+	Live() bool
+}
+
+// Reindex rebuilds the search index from the database, calling progress
+// after every batch. Gists that fail to index are counted and skipped.
+func (m *Manager) Reindex(ctx context.Context, progress func(ReindexStatus)) error {
+	if !m.beginReindex() {
+		return ErrReindexInProgress
+	}
+	err := m.rebuild(ctx, progress)
+	m.finishReindex(err)
+	return err
+}
+
+// StartReindex runs Reindex in the background. Use ReindexStatus to follow
+// its progress.
+func (m *Manager) StartReindex() error {
+	if !m.beginReindex() {
+		return ErrReindexInProgress
+	}
+	go func() {
+		m.finishReindex(m.rebuild(context.Background(), nil))
+	}()
+	return nil
+}
+
+// ReindexStatus returns the progress of the running or most recent reindex
+func (m *Manager) ReindexStatus() ReindexStatus {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.reindex
+}
+
+// Health reports how far the search index has drifted from the database.
+// The index is degraded when gists are missing from it, when it lags
+// behind the newest change by more than maxLag, or when the last reindex
+// failed.
+func (m *Manager) Health(ctx context.Context, maxLag time.Duration) (*IndexHealth, error) {
+	health := &IndexHealth{
+		Status:   IndexHealthy,
+		Provider: m.Backend(),
+		Reindex:  m.ReindexStatus(),
+	}
+
+	if err := m.db.WithContext(ctx).Model(&models.Gist{}).Count(&health.DatabaseGists).Error; err != nil {
+		return nil, fmt.Errorf("failed to count gists: %w", err)
+	}
+
+	// The newest change is what the index has to have caught up with
+	var newestChange *time.Time
+	var newest models.Gist
+	err := m.db.WithContext(ctx).Select("updated_at").Order("updated_at DESC").Take(&newest).Error
+	switch {
+	case err == nil:
+		newestChange = &newest.UpdatedAt
+	case !errors.Is(err, gorm.ErrRecordNotFound):
+		return nil, fmt.Errorf("failed to read latest gist change: %w", err)
+	}
+
+	live := false
+	if provider, ok := m.provider.(liveIndex); ok {
+		live = provider.Live()
+	}
+
+	if counter, ok := m.provider.(documentCounter); ok {
+		count, err := counter.CountDocuments(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to count indexed documents: %w", err)
+		}
+		health.IndexedDocuments = count
+	} else if live {
+		health.IndexedDocuments = health.DatabaseGists
+	}
+
+	if live {
+		health.LastIndexedAt = newestChange
+	} else {
+		m.mu.Lock()
+		lastIndexed := m.lastIndexedAt
+		m.mu.Unlock()
+		if !lastIndexed.IsZero() {
+			health.LastIndexedAt = &lastIndexed
+			if newestChange != nil && newestChange.After(lastIndexed) {
+				health.LagSeconds = int64(newestChange.Sub(lastIndexed).Seconds())
+			}
+		}
+	}
+
+	if missing := health.DatabaseGists - health.IndexedDocuments; missing > 0 {
+		health.Missing = missing
+		health.Problems = append(health.Problems, fmt.Sprintf("%d gists are missing from the search index", missing))
+	}
+	if maxLag > 0 && time.Duration(health.LagSeconds)*time.Second > maxLag {
+		health.Problems = append(health.Problems,
+			fmt.Sprintf("search index is %s behind the latest change", time.Duration(health.LagSeconds)*time.Second))
+	}
+	if health.Reindex.Error != "" && !health.Reindex.Running {
+		health.Problems = append(health.Problems, "last reindex failed: "+health.Reindex.Error)
+	}
+	if len(health.Problems) > 0 {
+		health.Status = IndexDegraded
+	}
+
+	return health, nil
+}
+
+// rebuild clears the index if the provider needs it and indexes every gist
+// in batches
+func (m *Manager) rebuild(ctx context.Context, progress func(ReindexStatus)) error {
+	var total int64
+	if err := m.db.WithContext(ctx).Model(&models.Gist{}).Count(&total).Error; err != nil {
+		return fmt.Errorf("failed to count gists: %w", err)
+	}
+	m.updateReindex(func(s *ReindexStatus) { s.Total = total })
+	m.report(progress)
+
+	if resetter, ok := m.provider.(indexResetter); ok {
+		if err := resetter.Reset(ctx); err != nil {
+			return fmt.Errorf("failed to clear search index: %w", err)
+		}
+	}
+
+	var lastID string
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		var gists []models.Gist
+		query := m.db.WithContext(ctx).Preload("User").Preload("Files").Order("id").Limit(reindexBatchSize)
+		if lastID != "" {
+			query = query.Where("id > ?", lastID)
+		}
+		if err := query.Find(&gists).Error; err != nil {
+			return fmt.Errorf("failed to load gists: %w", err)
+		}
+		if len(gists) == 0 {
+			break
+		}
+
+		var indexed, failed int64
+		for i := range gists {
+			if err := m.provider.Index(ctx, &gists[i]); err != nil {
+				failed++
+				continue
+			}
+			indexed++
+		}
+		lastID = gists[len(gists)-1].ID.String()
+
+		m.updateReindex(func(s *ReindexStatus) {
+			s.Indexed += indexed
+			s.Failed += failed
+		})
+		m.report(progress)
+	}
+
+	m.markIndexed(time.Now())
+
+	if status := m.ReindexStatus(); status.Failed > 0 {
+		return fmt.Errorf("%d of %d gists failed to index", status.Failed, status.Total)
+	}
+	return nil
+}
+
+// beginReindex marks a reindex as running unless one already is
+func (m *Manager) beginReindex() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.reindex.Running {
+		return false
+	}
+	now := time.Now()
+	m.reindex = ReindexStatus{Running: true, StartedAt: &now}
+	return true
+}
+
+// finishReindex records the outcome of the running reindex
+func (m *Manager) finishReindex(err error) {
+	m.updateReindex(func(s *ReindexStatus) {
+		now := time.Now()
+		s.Running = false
+		s.FinishedAt = &now
+		if err != nil {
+			s.Error = err.Error()
+		}
+	})
+}
+
+func (m *Manager) updateReindex(update func(*ReindexStatus)) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	update(&m.reindex)
+}
+
+func (m *Manager) report(progress func(ReindexStatus)) {
+	if progress != nil {
+		progress(m.ReindexStatus())
+	}
+}
+
+func (m *Manager) markIndexed(at time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.lastIndexedAt = at
+}
@@ -0,0 +1,158 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// memoryProvider is an in-memory index used to exercise the manager
+type memoryProvider struct {
+	mu      sync.Mutex
+	docs    map[uuid.UUID]bool
+	resets  int
+	failFor uuid.UUID
+}
+
+func (p *memoryProvider) Index(ctx context.Context, gist *models.Gist) error {
+	if gist.ID == p.failFor {
+		return errors.New("index failed")
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs[gist.ID] = true
+	return nil
+}
+
+func (p *memoryProvider) Search(ctx context.Context, query string, filters SearchFilters) (*SearchResult, error) {
+	return &SearchResult{}, nil
+}
+
+func (p *memoryProvider) Delete(ctx context.Context, gistID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.docs, uuid.MustParse(gistID))
+	return nil
+}
+
+func (p *memoryProvider) UpdateIndex(ctx context.Context) error { return nil }
+
+func (p *memoryProvider) Reset(ctx context.Context) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.docs = map[uuid.UUID]bool{}
+	p.resets++
+	return nil
+}
+
+func (p *memoryProvider) CountDocuments(ctx context.Context) (int64, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return int64(len(p.docs)), nil
+}
+
+func setupReindexDB(t *testing.T, gists int) (*gorm.DB, []uuid.UUID) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{})
+	require.NoError(t, err)
+
+	for _, stmt := range []string{
+		`CREATE TABLE users (id TEXT PRIMARY KEY, username TEXT, email TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE gists (id TEXT PRIMARY KEY, title TEXT, visibility TEXT, user_id TEXT, git_repo_path TEXT, created_at DATETIME, updated_at DATETIME, deleted_at DATETIME)`,
+		`CREATE TABLE gist_files (id TEXT PRIMARY KEY, gist_id TEXT, filename TEXT, content TEXT, created_at DATETIME, updated_at DATETIME)`,
+	} {
+		require.NoError(t, db.Exec(stmt).Error)
+	}
+
+	ids := make([]uuid.UUID, gists)
+	for i := range ids {
+		ids[i] = uuid.New()
+		require.NoError(t, db.Exec(`INSERT INTO gists (id, title, visibility, git_repo_path, created_at, updated_at) VALUES (?, 'gist', 'public', 'repo', ?, ?)`,
+			ids[i].String(), time.Now(), time.Now()).Error)
+	}
+	return db, ids
+}
+
+func TestManagerReindex(t *testing.T) {
+	ctx := context.Background()
+
+	t.Run("RebuildsWithProgress", func(t *testing.T) {
+		db, ids := setupReindexDB(t, reindexBatchSize+5)
+		provider := &memoryProvider{docs: map[uuid.UUID]bool{uuid.New(): true}}
+		manager := &Manager{db: db, provider: provider, providerType: "memory"}
+
+		var updates []ReindexStatus
+		require.NoError(t, manager.Reindex(ctx, func(s ReindexStatus) { updates = append(updates, s) }))
+
+		assert.Equal(t, 1, provider.resets)
+		assert.Len(t, provider.docs, len(ids))
+		require.Len(t, updates, 3) // Count, then one update per batch
+		assert.Equal(t, int64(reindexBatchSize), updates[1].Indexed)
+		assert.InDelta(t, 100, updates[2].Percent(), 0.01)
+
+		status := manager.ReindexStatus()
+		assert.False(t, status.Running)
+		assert.NotNil(t, status.FinishedAt)
+		assert.Empty(t, status.Error)
+
+		health, err := manager.Health(ctx, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, IndexHealthy, health.Status)
+		assert.Equal(t, int64(len(ids)), health.IndexedDocuments)
+		assert.Zero(t, health.Missing)
+	})
+
+	t.Run("ReportsFailuresAndDrift", func(t *testing.T) {
+		db, ids := setupReindexDB(t, 3)
+		provider := &memoryProvider{docs: map[uuid.UUID]bool{}, failFor: ids[1]}
+		manager := &Manager{db: db, provider: provider, providerType: "memory"}
+
+		err := manager.Reindex(ctx, nil)
+		require.Error(t, err)
+		assert.Equal(t, int64(2), manager.ReindexStatus().Indexed)
+		assert.Equal(t, int64(1), manager.ReindexStatus().Failed)
+
+		// A change made after the last index shows up as lag
+		require.NoError(t, db.Exec(`UPDATE gists SET updated_at = ? WHERE id = ?`, time.Now().Add(time.Hour), ids[0].String()).Error)
+
+		health, err := manager.Health(ctx, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, IndexDegraded, health.Status)
+		assert.Equal(t, int64(1), health.Missing)
+		assert.GreaterOrEqual(t, health.LagSeconds, int64(3500))
+		assert.Len(t, health.Problems, 3)
+	})
+
+	t.Run("RejectsConcurrentReindex", func(t *testing.T) {
+		db, _ := setupReindexDB(t, 1)
+		manager := &Manager{db: db, provider: &memoryProvider{docs: map[uuid.UUID]bool{}}}
+
+		require.True(t, manager.beginReindex())
+		assert.ErrorIs(t, manager.StartReindex(), ErrReindexInProgress)
+		assert.ErrorIs(t, manager.Reindex(ctx, nil), ErrReindexInProgress)
+		manager.finishReindex(nil)
+
+		require.NoError(t, manager.StartReindex())
+		assert.Eventually(t, func() bool { return !manager.ReindexStatus().Running }, time.Second, 10*time.Millisecond)
+	})
+
+	t.Run("LiveProviderNeverLags", func(t *testing.T) {
+		db, ids := setupReindexDB(t, 2)
+		manager := &Manager{db: db, provider: &SQLiteProvider{db: db}, providerType: "sqlite_fts"}
+
+		health, err := manager.Health(ctx, time.Minute)
+		require.NoError(t, err)
+		assert.Equal(t, IndexHealthy, health.Status)
+		assert.Equal(t, int64(len(ids)), health.IndexedDocuments)
+		assert.Zero(t, health.LagSeconds)
+	})
+}
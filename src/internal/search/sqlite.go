@@ -3,28 +3,24 @@ package search
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
-// SQLiteProvider implements search using SQLite FTS
+// SQLiteProvider implements search with LIKE queries on the gists table.
+// It is the fallback on databases without a full-text index.
 type SQLiteProvider struct {
 	db *gorm.DB
 }
 
-// NewSQLiteProvider creates a new SQLite search provider
+// NewSQLiteProvider creates a new LIKE search provider
 func NewSQLiteProvider(db *gorm.DB) (SearchProvider, error) {
-	provider := &SQLiteProvider{db: db}
-	
-	// Initialize FTS index if it doesn't exist
-	if err := provider.initializeFTSIndex(); err != nil {
-		return nil, fmt.Errorf("failed to initialize FTS index: %w", err)
-	}
-	
-	return provider, nil
+	return &SQLiteProvider{db: db}, nil
 }
 
 
@@ -33,64 +29,84 @@ func NewElasticsearchProvider(config map[string]interface{}) (SearchProvider, er
 	return nil, fmt.Errorf("Elasticsearch search provider not implemented")
 }
 
-// Index adds or updates a gist in the search index
+// Index is a no-op, searches read the gists table
 func (s *SQLiteProvider) Index(ctx context.Context, gist *models.Gist) error {
-	// For now, just return nil - FTS indexing can be implemented later
 	return nil
 }
 
-// Search performs a search query using SQLite FTS
+// Search performs a search query, matching the query against gist titles,
+// descriptions and tags with LIKE
 func (s *SQLiteProvider) Search(ctx context.Context, query string, filters SearchFilters) (*SearchResult, error) {
 	startTime := time.Now()
-	
-	// Build search query
+
+	dbQuery := filterGists(s.db.WithContext(ctx), filters)
+	if query != "" {
+		dbQuery = dbQuery.Where(textSearchClause, textSearchArgs(query)...)
+	}
+
+	// Get total count for pagination
+	var total int64
+	if err := dbQuery.Session(&gorm.Session{}).Count(&total).Error; err != nil {
+		return nil, fmt.Errorf("search count failed: %w", err)
+	}
+
+	// Apply sorting
+	dbQuery = sortGists(dbQuery, filters.Sort)
+
+	// Execute query with user information
 	var gists []models.Gist
-	dbQuery := s.db.Model(&models.Gist{}).Where("deleted_at IS NULL")
-	
+	limit, offset := pageBounds(filters)
+	if err := dbQuery.Limit(limit).Offset(offset).Preload("User").Find(&gists).Error; err != nil {
+		return nil, fmt.Errorf("search query failed: %w", err)
+	}
+
+	return newSearchResult(gists, total, query, filters, time.Since(startTime)), nil
+}
+
+// filterGists selects the visible gists matching the filters, on a read
+// replica when there is one
+func filterGists(db *gorm.DB, filters SearchFilters) *gorm.DB {
+	dbQuery := database.ReadReplica(db).Model(&models.Gist{}).Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated).Where("gists.deleted_at IS NULL")
+
 	// Apply visibility filter
 	if filters.Visibility != "" {
-		dbQuery = dbQuery.Where("visibility = ?", filters.Visibility)
+		dbQuery = dbQuery.Where("gists.visibility = ?", filters.Visibility)
 	} else {
-		dbQuery = dbQuery.Where("visibility IN ?", []string{"public", "unlisted"})
+		dbQuery = dbQuery.Where("gists.visibility IN ?", []string{"public", "unlisted"})
 	}
-	
+
 	// Apply user filter
 	if filters.UserID != "" {
-		dbQuery = dbQuery.Where("user_id = ?", filters.UserID)
+		dbQuery = dbQuery.Where("gists.user_id = ?", filters.UserID)
 	}
-	
+
 	// Apply organization filter
 	if filters.OrganizationID != "" {
-		dbQuery = dbQuery.Where("organization_id = ?", filters.OrganizationID)
+		dbQuery = dbQuery.Where("gists.organization_id = ?", filters.OrganizationID)
 	}
-	
+
 	// Apply language filter
 	if filters.Language != "" {
-		dbQuery = dbQuery.Where("language = ?", filters.Language)
-	}
-	
-	// Apply text search (basic LIKE search for now)
-	if query != "" {
-		searchTerm := "%" + query + "%"
-		dbQuery = dbQuery.Where("title ILIKE ? OR description ILIKE ? OR tags ILIKE ?", 
-			searchTerm, searchTerm, searchTerm)
+		dbQuery = dbQuery.Where("gists.language = ?", filters.Language)
 	}
-	
-	// Apply sorting
-	switch filters.Sort {
+	return dbQuery
+}
+
+// sortGists orders results by an explicit sort, most recently updated first
+// otherwise
+func sortGists(dbQuery *gorm.DB, sort string) *gorm.DB {
+	switch sort {
 	case "created":
-		dbQuery = dbQuery.Order("created_at DESC")
-	case "updated":
-		dbQuery = dbQuery.Order("updated_at DESC") 
+		return dbQuery.Order("gists.created_at DESC")
 	case "stars":
-		dbQuery = dbQuery.Order("star_count DESC")
-	case "relevance":
-		dbQuery = dbQuery.Order("updated_at DESC") // Fallback
+		return dbQuery.Order("gists.star_count DESC")
 	default:
-		dbQuery = dbQuery.Order("updated_at DESC")
+		return dbQuery.Order("gists.updated_at DESC")
 	}
-	
-	// Apply pagination
+}
+
+// pageBounds returns the limit and offset of the requested page
+func pageBounds(filters SearchFilters) (int, int) {
 	limit := filters.Limit
 	if limit <= 0 || limit > 100 {
 		limit = 20
@@ -99,46 +115,18 @@ func (s *SQLiteProvider) Search(ctx context.Context, query string, filters Searc
 	if offset < 0 {
 		offset = 0
 	}
-	
-	dbQuery = dbQuery.Limit(limit).Offset(offset)
-	
-	// Execute query with user information
-	if err := dbQuery.Preload("User").Find(&gists).Error; err != nil {
-		return nil, fmt.Errorf("search query failed: %w", err)
-	}
-	
-	// Get total count for pagination
-	var total int64
-	countQuery := s.db.Model(&models.Gist{}).Where("deleted_at IS NULL")
-	if filters.Visibility != "" {
-		countQuery = countQuery.Where("visibility = ?", filters.Visibility)
-	} else {
-		countQuery = countQuery.Where("visibility IN ?", []string{"public", "unlisted"})
-	}
-	if filters.UserID != "" {
-		countQuery = countQuery.Where("user_id = ?", filters.UserID)
-	}
-	if filters.OrganizationID != "" {
-		countQuery = countQuery.Where("organization_id = ?", filters.OrganizationID)
-	}
-	if filters.Language != "" {
-		countQuery = countQuery.Where("language = ?", filters.Language)
-	}
-	if query != "" {
-		searchTerm := "%" + query + "%"
-		countQuery = countQuery.Where("title ILIKE ? OR description ILIKE ? OR tags ILIKE ?", 
-			searchTerm, searchTerm, searchTerm)
-	}
-	countQuery.Count(&total)
-	
-	duration := time.Since(startTime)
-	
+	return limit, offset
+}
+
+func newSearchResult(gists []models.Gist, total int64, query string, filters SearchFilters, took time.Duration) *SearchResult {
+	limit, offset := pageBounds(filters)
+
 	// Convert to pointer slice as expected by SearchResult
 	gistPtrs := make([]*models.Gist, len(gists))
 	for i := range gists {
 		gistPtrs[i] = &gists[i]
 	}
-	
+
 	return &SearchResult{
 		Gists:     gistPtrs,
 		Total:     total,
@@ -146,24 +134,57 @@ func (s *SQLiteProvider) Search(ctx context.Context, query string, filters Searc
 		Limit:     limit,
 		Query:     query,
 		Filters:   filters,
-		TimeTaken: duration.Milliseconds(),
-	}, nil
+		TimeTaken: took.Milliseconds(),
+	}
+}
+
+// textSearchClause matches the search pattern case-insensitively against the
+// gist's text fields on every supported database. '!' is used as the escape
+// character because a backslash needs escaping itself on MySQL.
+const textSearchClause = `LOWER(title) LIKE ? ESCAPE '!' OR LOWER(description) LIKE ? ESCAPE '!' OR LOWER(tags_string) LIKE ? ESCAPE '!'`
+
+// textSearchArgs builds the LIKE pattern for a query. The user-facing
+// wildcards * and ? are translated; literal % and _ are escaped so they
+// can't be used to build unbounded patterns.
+func textSearchArgs(query string) []interface{} {
+	var pattern strings.Builder
+	pattern.WriteString("%")
+	for _, r := range strings.ToLower(query) {
+		switch r {
+		case '!', '%', '_':
+			pattern.WriteRune('!')
+			pattern.WriteRune(r)
+		case '*':
+			pattern.WriteRune('%')
+		case '?':
+			pattern.WriteRune('_')
+		default:
+			pattern.WriteRune(r)
+		}
+	}
+	pattern.WriteString("%")
+
+	term := pattern.String()
+	return []interface{}{term, term, term}
 }
 
-// Delete removes a gist from the search index
+// Delete is a no-op, searches read the gists table
 func (s *SQLiteProvider) Delete(ctx context.Context, gistID string) error {
-	// Nothing to do for now
 	return nil
 }
 
-// UpdateIndex rebuilds the entire search index
+// UpdateIndex is a no-op, searches read the gists table
 func (s *SQLiteProvider) UpdateIndex(ctx context.Context) error {
-	// Nothing to do for now
 	return nil
 }
 
-// initializeFTSIndex creates the FTS table if it doesn't exist
-func (s *SQLiteProvider) initializeFTSIndex() error {
-	// Skip FTS initialization for now
-	return nil
-}
\ No newline at end of file
+// Live reports that searches read the gists table directly, so the index
+// can never fall behind the database
+func (s *SQLiteProvider) Live() bool {
+	return true
+}
+
+// Backend reports that no full-text index is used
+func (s *SQLiteProvider) Backend() string {
+	return BackendLike
+}
@@ -0,0 +1,88 @@
+package search
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Syncer keeps an incremental index such as Bleve current in the
+// background. It syncs changed gists every search.bleve.sync_interval and,
+// when search.bleve.reindex_interval is set, rebuilds the whole index on
+// that schedule to drop gists purged from the database.
+type Syncer struct {
+	manager *Manager
+	config  *config.Config
+	stop    chan bool
+}
+
+// NewSyncer creates a new index syncer
+func NewSyncer(manager *Manager, config *config.Config) *Syncer {
+	return &Syncer{
+		manager: manager,
+		config:  config,
+		stop:    make(chan bool, 1),
+	}
+}
+
+// Start syncs the index right away and then on schedule until the context
+// is cancelled or Stop is called
+func (s *Syncer) Start(ctx context.Context) {
+	s.sync(ctx)
+
+	ticker := time.NewTicker(s.syncInterval())
+	defer ticker.Stop()
+
+	// A nil channel never fires, so no reindex without an interval
+	var reindex <-chan time.Time
+	if interval := s.config.GetDuration("search.bleve.reindex_interval"); interval > 0 {
+		reindexTicker := time.NewTicker(interval)
+		defer reindexTicker.Stop()
+		reindex = reindexTicker.C
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			s.sync(ctx)
+		case <-reindex:
+			if err := s.manager.Reindex(ctx, nil); err != nil && !errors.Is(err, ErrReindexInProgress) {
+				slog.Error("Scheduled search reindex failed", "error", err)
+			}
+		}
+	}
+}
+
+// Stop stops syncing
+func (s *Syncer) Stop() {
+	select {
+	case s.stop <- true:
+	default:
+	}
+}
+
+func (s *Syncer) sync(ctx context.Context) {
+	// A running reindex already covers the changes
+	if s.manager.ReindexStatus().Running {
+		return
+	}
+	if _, err := s.manager.Sync(ctx); err != nil {
+		slog.Error("Search index sync failed", "error", err)
+	}
+}
+
+// syncInterval returns the pause between syncs (default: 1 minute)
+func (s *Syncer) syncInterval() time.Duration {
+	interval := s.config.GetDuration("search.bleve.sync_interval")
+	if interval <= 0 {
+		interval = time.Minute
+	}
+	return interval
+}
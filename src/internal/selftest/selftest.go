@@ -0,0 +1,250 @@
+// Package selftest checks an installation end to end, for verify-install
+// after an install or upgrade. Besides checking the configuration,
+// database and storage the server would use, it boots a throwaway instance
+// from the same configuration, with its own temporary database and
+// repositories, and runs an API smoke test against it. Email and webhook
+// connectivity is checked without sending anything.
+package selftest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+// Status is the outcome of a check
+type Status string
+
+const (
+	StatusPass Status = "PASS"
+	StatusFail Status = "FAIL"
+	StatusSkip Status = "SKIP"
+)
+
+// Result is the outcome of one check
+type Result struct {
+	Name       string  `json:"name"`
+	Status     Status  `json:"status"`
+	Detail     string  `json:"detail,omitempty"`
+	DurationMs float64 `json:"duration_ms"`
+}
+
+// Report collects the results of a self-test
+type Report struct {
+	Version string   `json:"version"`
+	Results []Result `json:"results"`
+	Passed  int      `json:"passed"`
+	Failed  int      `json:"failed"`
+	Skipped int      `json:"skipped"`
+}
+
+// Add records the result of a check
+func (r *Report) Add(result Result) {
+	r.Results = append(r.Results, result)
+	switch result.Status {
+	case StatusPass:
+		r.Passed++
+	case StatusFail:
+		r.Failed++
+	default:
+		r.Skipped++
+	}
+}
+
+// OK reports whether no check failed
+func (r *Report) OK() bool {
+	return r.Failed == 0
+}
+
+// Options tunes a self-test
+type Options struct {
+	// Version is the version of the binary under test
+	Version string
+	// Offline skips the checks that connect to SMTP and webhook servers
+	Offline bool
+	// Timeout bounds each network connection and API request
+	Timeout time.Duration
+}
+
+// Run checks the installation configured by cfg. The instance's own data
+// is only read; the smoke test writes to the throwaway instance alone.
+func Run(ctx context.Context, cfg *config.Config, opts Options) *Report {
+	if opts.Timeout == 0 {
+		opts.Timeout = 10 * time.Second
+	}
+	report := &Report{Version: opts.Version, Results: []Result{}}
+
+	report.Add(timed("config", func() (Status, string) { return checkConfig(cfg) }))
+
+	var db *gorm.DB
+	report.Add(timed("database", func() (Status, string) {
+		var status Status
+		var detail string
+		db, status, detail = checkDatabase(cfg)
+		return status, detail
+	}))
+	if db != nil {
+		defer func() {
+			if sqlDB, err := db.DB(); err == nil {
+				sqlDB.Close()
+			}
+		}()
+	}
+
+	report.Add(timed("storage", func() (Status, string) { return checkStorage(ctx, cfg) }))
+
+	for _, result := range runSmokeTest(ctx, cfg, opts.Timeout) {
+		report.Add(result)
+	}
+
+	report.Add(timed("email", func() (Status, string) { return checkEmail(cfg, opts.Offline) }))
+	report.Add(timed("webhooks", func() (Status, string) { return checkWebhooks(db, opts) }))
+	return report
+}
+
+// timed runs a check and records how long it took
+func timed(name string, check func() (Status, string)) Result {
+	start := time.Now()
+	status, detail := check()
+	return Result{
+		Name:       name,
+		Status:     status,
+		Detail:     detail,
+		DurationMs: float64(time.Since(start).Microseconds()) / 1000,
+	}
+}
+
+func checkConfig(cfg *config.Config) (Status, string) {
+	// SMTP is checked below, without the lint's plain TCP dial
+	lint := config.Lint(cfg, config.LintOptions{})
+	for _, d := range lint.Diagnostics {
+		if d.Severity == config.SeverityError {
+			return StatusFail, fmt.Sprintf("%d error(s), first: %s", lint.Errors, d.Message)
+		}
+	}
+	if lint.Warnings > 0 {
+		return StatusPass, fmt.Sprintf("%d warning(s), see casgists --config-check", lint.Warnings)
+	}
+	return StatusPass, ""
+}
+
+// checkDatabase connects to the instance's database. The connection is
+// returned for the checks that read from it.
+func checkDatabase(cfg *config.Config) (*gorm.DB, Status, string) {
+	db, err := database.Initialize(cfg)
+	if err != nil {
+		return nil, StatusFail, err.Error()
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, StatusFail, err.Error()
+	}
+	if err := sqlDB.Ping(); err != nil {
+		sqlDB.Close()
+		return nil, StatusFail, err.Error()
+	}
+
+	dbType := cfg.GetString("database.type")
+	if dbType == "" {
+		dbType = "sqlite"
+	}
+	var users int64
+	if err := db.Model(&models.User{}).Count(&users).Error; err != nil {
+		// Not migrated yet, the server migrates on start
+		return db, StatusPass, fmt.Sprintf("%s, schema not created yet", dbType)
+	}
+	return db, StatusPass, fmt.Sprintf("%s, %d user(s)", dbType, users)
+}
+
+func checkStorage(ctx context.Context, cfg *config.Config) (Status, string) {
+	storage, err := git.NewStorageDriver(cfg)
+	if err != nil {
+		return StatusFail, err.Error()
+	}
+	health := storage.Health(ctx)
+	if !health.Healthy {
+		return StatusFail, fmt.Sprintf("%s (%s): %s", health.Driver, health.Target, health.Error)
+	}
+	return StatusPass, fmt.Sprintf("%s (%s)", health.Driver, health.Target)
+}
+
+// checkEmail connects and authenticates to the SMTP server without sending
+func checkEmail(cfg *config.Config, offline bool) (Status, string) {
+	switch {
+	case !cfg.GetBool("email.enabled"):
+		return StatusSkip, "email is disabled"
+	case offline:
+		return StatusSkip, "offline"
+	}
+	if err := email.NewMailer(cfg).TestConnection(); err != nil {
+		return StatusFail, err.Error()
+	}
+	return StatusPass, fmt.Sprintf("connected to %s:%d", cfg.GetString("email.smtp.host"), cfg.GetInt("email.smtp.port"))
+}
+
+// checkWebhooks connects to the host of every active webhook without
+// delivering anything
+func checkWebhooks(db *gorm.DB, opts Options) (Status, string) {
+	switch {
+	case opts.Offline:
+		return StatusSkip, "offline"
+	case db == nil || !db.Migrator().HasTable("webhooks"):
+		return StatusSkip, "no webhooks"
+	}
+
+	var targets []string
+	if err := db.Table("webhooks").Where("is_active = ?", true).Distinct().Pluck("url", &targets).Error; err != nil {
+		return StatusFail, err.Error()
+	}
+
+	hosts := map[string]bool{}
+	for _, target := range targets {
+		if address := webhookAddress(target); address != "" {
+			hosts[address] = true
+		}
+	}
+	if len(hosts) == 0 {
+		return StatusSkip, "no webhooks"
+	}
+
+	var unreachable []string
+	for address := range hosts {
+		conn, err := net.DialTimeout("tcp", address, opts.Timeout)
+		if err != nil {
+			unreachable = append(unreachable, address)
+			continue
+		}
+		conn.Close()
+	}
+	if len(unreachable) > 0 {
+		return StatusFail, fmt.Sprintf("%d of %d host(s) unreachable: %s", len(unreachable), len(hosts), strings.Join(unreachable, ", "))
+	}
+	return StatusPass, fmt.Sprintf("%d host(s) reachable", len(hosts))
+}
+
+// webhookAddress is the host:port a webhook URL is delivered to
+func webhookAddress(target string) string {
+	u, err := url.Parse(target)
+	if err != nil || u.Hostname() == "" {
+		return ""
+	}
+	port := u.Port()
+	if port == "" {
+		port = "80"
+		if u.Scheme == "https" {
+			port = "443"
+		}
+	}
+	return net.JoinHostPort(u.Hostname(), port)
+}
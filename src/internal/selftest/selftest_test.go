@@ -0,0 +1,94 @@
+package selftest
+
+import (
+	"context"
+	"net"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	cfg := config.New()
+	cfg.Set("database.type", "sqlite")
+	cfg.Set("database.path", filepath.Join(dir, "casgists.db"))
+	cfg.Set("git.storage.local.path", filepath.Join(dir, "repos"))
+	cfg.Set("storage.path", filepath.Join(dir, "files"))
+	cfg.Set("backup.path", filepath.Join(dir, "backups"))
+	cfg.Set("security.secret_key", "verify-install-test-secret-key-0123456789")
+
+	// An instance with a webhook whose host listens
+	db, err := database.Initialize(cfg)
+	require.NoError(t, err)
+	require.NoError(t, database.MigrateDB(db))
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer listener.Close()
+	require.NoError(t, db.Exec("INSERT INTO webhooks (id, user_id, name, url, is_active) VALUES (?, ?, ?, ?, ?)",
+		uuid.NewString(), uuid.NewString(), "test", "http://"+listener.Addr().String()+"/hook", true).Error)
+	var users int64
+	require.NoError(t, db.Model(&models.User{}).Count(&users).Error)
+	sqlDB, _ := db.DB()
+	sqlDB.Close()
+
+	report := Run(context.Background(), cfg, Options{Version: "test"})
+	for _, result := range report.Results {
+		t.Logf("%s %s %s", result.Status, result.Name, result.Detail)
+	}
+	assert.True(t, report.OK())
+
+	statuses := map[string]Status{}
+	for _, result := range report.Results {
+		statuses[result.Name] = result.Status
+	}
+	assert.Equal(t, map[string]Status{
+		"config":           StatusPass,
+		"database":         StatusPass,
+		"storage":          StatusPass,
+		"instance":         StatusPass,
+		"api: register":    StatusPass,
+		"api: create gist": StatusPass,
+		"api: fetch raw":   StatusPass,
+		"api: delete gist": StatusPass,
+		"email":            StatusSkip,
+		"webhooks":         StatusPass,
+	}, statuses)
+
+	// The smoke test ran on its own database
+	db, err = database.Initialize(cfg)
+	require.NoError(t, err)
+	defer func() {
+		sqlDB, _ := db.DB()
+		sqlDB.Close()
+	}()
+	var after int64
+	require.NoError(t, db.Model(&models.User{}).Count(&after).Error)
+	assert.Equal(t, users, after)
+}
+
+func TestRunReportsFailures(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("database.type", "oracle")
+	cfg.Set("git.storage.local.path", filepath.Join(t.TempDir(), "repos"))
+
+	report := Run(context.Background(), cfg, Options{Offline: true})
+	assert.False(t, report.OK())
+	assert.Equal(t, StatusFail, report.Results[1].Status)
+	assert.Equal(t, "database", report.Results[1].Name)
+	assert.Equal(t, len(report.Results), report.Passed+report.Failed+report.Skipped)
+}
+
+func TestWebhookAddress(t *testing.T) {
+	assert.Equal(t, "hooks.example.com:443", webhookAddress("https://hooks.example.com/casgists"))
+	assert.Equal(t, "hooks.example.com:80", webhookAddress("http://hooks.example.com"))
+	assert.Equal(t, "10.0.0.5:8080", webhookAddress("http://10.0.0.5:8080/x"))
+	assert.Equal(t, "", webhookAddress("not a url"))
+}
@@ -0,0 +1,320 @@
+package selftest
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/cookiejar"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/server"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// smokeFile is the file the smoke test stores and reads back
+const (
+	smokeFilename = "verify-install.txt"
+	smokeContent  = "CasGists verify-install smoke test\n"
+)
+
+// runSmokeTest boots a throwaway instance and walks a new user through
+// registering, creating a gist, reading it raw and deleting it. Each step
+// is a result; steps after a failure are skipped.
+func runSmokeTest(ctx context.Context, cfg *config.Config, timeout time.Duration) []Result {
+	steps := []string{"instance", "api: register", "api: create gist", "api: fetch raw", "api: delete gist"}
+	var results []Result
+	skipRest := func(reason string) []Result {
+		for _, name := range steps[len(results):] {
+			results = append(results, Result{Name: name, Status: StatusSkip, Detail: reason})
+		}
+		return results
+	}
+
+	var instance *ephemeralInstance
+	results = append(results, timed("instance", func() (Status, string) {
+		var err error
+		if instance, err = startInstance(cfg); err != nil {
+			return StatusFail, err.Error()
+		}
+		return StatusPass, "booted on " + instance.baseURL
+	}))
+	if instance == nil {
+		return skipRest("instance did not start")
+	}
+	defer instance.Close()
+
+	client, err := newSmokeClient(instance.baseURL, timeout)
+	if err != nil {
+		return skipRest(err.Error())
+	}
+
+	for _, step := range []func() (Status, string){
+		func() (Status, string) { return client.register(ctx) },
+		func() (Status, string) { return client.createGist(ctx) },
+		func() (Status, string) { return client.fetchRaw(ctx) },
+		func() (Status, string) { return client.deleteGist(ctx) },
+	} {
+		result := timed(steps[len(results)], step)
+		results = append(results, result)
+		if result.Status == StatusFail {
+			return skipRest("previous step failed")
+		}
+	}
+	return results
+}
+
+// ephemeralInstance is a server on the instance's configuration with a
+// temporary database and repository storage, listening on a free loopback
+// port. Background services are not started, so nothing is mailed or
+// delivered.
+type ephemeralInstance struct {
+	baseURL string
+	dir     string
+	http    *http.Server
+	closeDB func()
+}
+
+func startInstance(cfg *config.Config) (*ephemeralInstance, error) {
+	dir, err := os.MkdirTemp("", "casgists-verify-")
+	if err != nil {
+		return nil, err
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+	baseURL := "http://" + listener.Addr().String()
+
+	ephemeral := config.New()
+	for _, key := range cfg.AllKeys() {
+		ephemeral.Set(key, cfg.Get(key))
+	}
+	for key, value := range map[string]interface{}{
+		"database.type":          "sqlite",
+		"database.dsn":           "",
+		"database.path":          filepath.Join(dir, "casgists.db"),
+		"storage.path":           filepath.Join(dir, "files"),
+		"git.storage.driver":     "local",
+		"git.storage.local.path": filepath.Join(dir, "repos"),
+		"search.bleve.path":      filepath.Join(dir, "search.bleve"),
+		"replication.enabled":    false,
+		"features.registration":  true,
+		"server.url":             baseURL,
+	} {
+		ephemeral.Set(key, value)
+	}
+
+	db, err := database.Initialize(ephemeral)
+	if err != nil {
+		listener.Close()
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to initialize database: %w", err)
+	}
+	closeDB := func() {
+		if sqlDB, err := db.DB(); err == nil {
+			sqlDB.Close()
+		}
+	}
+	if err := database.MigrateDB(db); err != nil {
+		closeDB()
+		listener.Close()
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to run migrations: %w", err)
+	}
+
+	e := echo.New()
+	e.HideBanner = true
+	e.HidePort = true
+	server.New(e, ephemeral, db)
+
+	instance := &ephemeralInstance{
+		baseURL: baseURL,
+		dir:     dir,
+		http:    &http.Server{Handler: e},
+		closeDB: closeDB,
+	}
+	go instance.http.Serve(listener)
+	return instance, nil
+}
+
+// Close stops the instance and removes its data
+func (i *ephemeralInstance) Close() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	i.http.Shutdown(ctx)
+	i.closeDB()
+	os.RemoveAll(i.dir)
+}
+
+// smokeClient calls the API the way a browser session does, keeping the
+// CSRF cookie and sending it back with writes
+type smokeClient struct {
+	baseURL string
+	http    *http.Client
+	token   string
+	gistID  string
+}
+
+func newSmokeClient(baseURL string, timeout time.Duration) (*smokeClient, error) {
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		return nil, err
+	}
+	return &smokeClient{
+		baseURL: baseURL,
+		http: &http.Client{
+			Jar:     jar,
+			Timeout: timeout,
+			// Redirects would hide a non-canonical URL
+			CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+		},
+	}, nil
+}
+
+func (s *smokeClient) register(ctx context.Context) (Status, string) {
+	// A read sets the CSRF cookie the writes below send back
+	if status, _, err := s.do(ctx, http.MethodGet, "/healthz", nil); err != nil {
+		return StatusFail, err.Error()
+	} else if status != http.StatusOK {
+		return StatusFail, fmt.Sprintf("GET /healthz returned %d", status)
+	}
+
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return StatusFail, err.Error()
+	}
+	username := "verify-" + hex.EncodeToString(suffix)
+	password := make([]byte, 16)
+	if _, err := rand.Read(password); err != nil {
+		return StatusFail, err.Error()
+	}
+
+	var response struct {
+		AccessToken string `json:"access_token"`
+	}
+	if err := s.expectJSON(ctx, http.MethodPost, "/api/v1/auth/register", map[string]string{
+		"username": username,
+		"email":    username + "@example.com",
+		"password": hex.EncodeToString(password) + "Aa1!",
+	}, http.StatusCreated, &response); err != nil {
+		return StatusFail, err.Error()
+	}
+	if response.AccessToken == "" {
+		return StatusFail, "no access token returned"
+	}
+	s.token = response.AccessToken
+	return StatusPass, "registered " + username
+}
+
+func (s *smokeClient) createGist(ctx context.Context) (Status, string) {
+	var response struct {
+		ID string `json:"id"`
+	}
+	if err := s.expectJSON(ctx, http.MethodPost, "/api/v1/gists", map[string]interface{}{
+		"title":      "verify-install",
+		"visibility": "public",
+		"files":      []map[string]string{{"filename": smokeFilename, "content": smokeContent}},
+	}, http.StatusCreated, &response); err != nil {
+		return StatusFail, err.Error()
+	}
+	if response.ID == "" {
+		return StatusFail, "no gist ID returned"
+	}
+	s.gistID = response.ID
+	return StatusPass, "created " + s.gistID
+}
+
+func (s *smokeClient) fetchRaw(ctx context.Context) (Status, string) {
+	path := urls.Path(urls.RawFile, s.gistID, smokeFilename)
+	status, body, err := s.do(ctx, http.MethodGet, path, nil)
+	switch {
+	case err != nil:
+		return StatusFail, err.Error()
+	case status != http.StatusOK:
+		return StatusFail, fmt.Sprintf("GET %s returned %d: %s", path, status, bytes.TrimSpace(body))
+	case string(body) != smokeContent:
+		return StatusFail, fmt.Sprintf("GET %s returned different content", path)
+	}
+	return StatusPass, path
+}
+
+func (s *smokeClient) deleteGist(ctx context.Context) (Status, string) {
+	path := urls.Path(urls.APIGist, s.gistID)
+	if err := s.expectJSON(ctx, http.MethodDelete, path, nil, http.StatusNoContent, nil); err != nil {
+		return StatusFail, err.Error()
+	}
+	status, _, err := s.do(ctx, http.MethodGet, path, nil)
+	switch {
+	case err != nil:
+		return StatusFail, err.Error()
+	case status != http.StatusNotFound:
+		return StatusFail, fmt.Sprintf("GET %s returned %d after deleting", path, status)
+	}
+	return StatusPass, ""
+}
+
+// expectJSON sends body as JSON and decodes the response into out, failing
+// unless it has the wanted status
+func (s *smokeClient) expectJSON(ctx context.Context, method, path string, body interface{}, want int, out interface{}) error {
+	status, response, err := s.do(ctx, method, path, body)
+	if err != nil {
+		return err
+	}
+	if status != want {
+		return fmt.Errorf("%s %s returned %d: %s", method, path, status, bytes.TrimSpace(response))
+	}
+	if out == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response, out); err != nil {
+		return fmt.Errorf("%s %s returned invalid JSON: %w", method, path, err)
+	}
+	return nil
+}
+
+func (s *smokeClient) do(ctx context.Context, method, path string, body interface{}) (int, []byte, error) {
+	var reader io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return 0, nil, err
+		}
+		reader = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, s.baseURL+path, reader)
+	if err != nil {
+		return 0, nil, err
+	}
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if s.token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+s.token)
+	}
+	for _, cookie := range s.http.Jar.Cookies(req.URL) {
+		if cookie.Name == "csrf_token" {
+			req.Header.Set("X-CSRF-Token", cookie.Value)
+		}
+	}
+
+	resp, err := s.http.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	response, err := io.ReadAll(resp.Body)
+	return resp.StatusCode, response, err
+}
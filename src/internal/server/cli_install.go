@@ -0,0 +1,401 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// cliScriptAsset is the checksum name of the shell CLI served at /cli, which
+// the installer falls back to when no binary exists for the platform
+const cliScriptAsset = "casgists-cli.sh"
+
+// cliPlatforms lists the OS/architecture pairs prebuilt CLI binaries may be
+// published for (the same matrix the release build produces)
+var cliPlatforms = []string{
+	"linux/amd64", "linux/arm64",
+	"darwin/amd64", "darwin/arm64",
+	"windows/amd64", "windows/arm64",
+	"freebsd/amd64", "freebsd/arm64",
+	"openbsd/amd64", "openbsd/arm64",
+	"netbsd/amd64", "netbsd/arm64",
+}
+
+// cliAsset is a prebuilt CLI binary offered by the installer
+type cliAsset struct {
+	Name   string `json:"name"`
+	OS     string `json:"os"`
+	Arch   string `json:"arch"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+	URL    string `json:"url"`
+
+	path string
+}
+
+// cliChecksum caches a file's SHA-256 until it changes on disk
+type cliChecksum struct {
+	size    int64
+	modTime time.Time
+	sum     string
+}
+
+// handleInstallScript serves a POSIX installer that detects the OS and
+// architecture, downloads the matching CLI binary and verifies its checksum
+func (s *Server) handleInstallScript(c echo.Context) error {
+	serverURL := strings.TrimRight(s.requestServerURL(c), "/")
+
+	script := fmt.Sprintf(installScript,
+		s.config.GetString("version"),
+		serverURL,
+		s.cliDownloadBase(serverURL),
+		s.cliBinaryName(),
+	)
+
+	c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Response().Header().Set("Content-Disposition", "inline; filename=\"install.sh\"")
+
+	return c.String(http.StatusOK, script)
+}
+
+// handleCLIReleases lists the CLI binaries available for download
+func (s *Server) handleCLIReleases(c echo.Context) error {
+	serverURL := strings.TrimRight(s.requestServerURL(c), "/")
+
+	assets, err := s.cliAssets(serverURL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read CLI release assets")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"version":       s.config.GetString("version"),
+		"download_base": s.cliDownloadBase(serverURL),
+		"installer":     serverURL + "/install.sh",
+		"assets":        assets,
+	})
+}
+
+// handleCLIChecksums serves the SHA-256 checksums of the local CLI binaries
+// and the shell CLI in sha256sum format
+func (s *Server) handleCLIChecksums(c echo.Context) error {
+	serverURL := strings.TrimRight(s.requestServerURL(c), "/")
+
+	assets, err := s.cliAssets(serverURL)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read CLI release assets")
+	}
+
+	var b strings.Builder
+	for _, asset := range assets {
+		fmt.Fprintf(&b, "%s  %s\n", asset.SHA256, asset.Name)
+	}
+	// The shell CLI is generated per server URL, so hash exactly what /cli serves
+	scriptSum := sha256.Sum256([]byte(s.cliScript(s.requestServerURL(c))))
+	fmt.Fprintf(&b, "%s  %s\n", hex.EncodeToString(scriptSum[:]), cliScriptAsset)
+
+	return c.String(http.StatusOK, b.String())
+}
+
+// handleCLIDownload serves a CLI binary from the release directory
+func (s *Server) handleCLIDownload(c echo.Context) error {
+	assets, err := s.cliAssets("")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read CLI release assets")
+	}
+
+	// Only names from the platform matrix are served, never arbitrary paths
+	name := c.Param("name")
+	for _, asset := range assets {
+		if asset.Name == name {
+			return c.Attachment(asset.path, asset.Name)
+		}
+	}
+
+	if mirror := s.cliMirrorURL(); mirror != "" {
+		return c.Redirect(http.StatusFound, mirror+"/"+name)
+	}
+	return echo.NewHTTPError(http.StatusNotFound, "CLI binary not found")
+}
+
+// cliAssets returns the CLI binaries present in the release directory
+func (s *Server) cliAssets(serverURL string) ([]cliAsset, error) {
+	dir := s.config.GetString("cli.release_dir")
+	assets := []cliAsset{}
+	if dir == "" {
+		return assets, nil
+	}
+
+	for _, platform := range cliPlatforms {
+		goos, goarch, _ := strings.Cut(platform, "/")
+		name := cliAssetName(s.cliBinaryName(), goos, goarch)
+		path := filepath.Join(dir, name)
+
+		info, err := os.Stat(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !info.Mode().IsRegular() {
+			continue
+		}
+
+		sum, err := s.cliFileChecksum(path, info)
+		if err != nil {
+			return nil, err
+		}
+
+		assets = append(assets, cliAsset{
+			Name:   name,
+			OS:     goos,
+			Arch:   goarch,
+			Size:   info.Size(),
+			SHA256: sum,
+			URL:    serverURL + "/cli/releases/" + name,
+			path:   path,
+		})
+	}
+
+	sort.Slice(assets, func(i, j int) bool { return assets[i].Name < assets[j].Name })
+	return assets, nil
+}
+
+// cliFileChecksum hashes a release file, reusing the cached sum while the
+// file's size and modification time are unchanged
+func (s *Server) cliFileChecksum(path string, info os.FileInfo) (string, error) {
+	if cached, ok := s.cliChecksums.Load(path); ok {
+		entry := cached.(cliChecksum)
+		if entry.size == info.Size() && entry.modTime.Equal(info.ModTime()) {
+			return entry.sum, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	sum := hex.EncodeToString(h.Sum(nil))
+
+	s.cliChecksums.Store(path, cliChecksum{size: info.Size(), modTime: info.ModTime(), sum: sum})
+	return sum, nil
+}
+
+// cliDownloadBase is where the installer downloads binaries and checksums
+// from: the configured mirror, or this server
+func (s *Server) cliDownloadBase(serverURL string) string {
+	if mirror := s.cliMirrorURL(); mirror != "" {
+		return mirror
+	}
+	return serverURL + "/cli/releases"
+}
+
+// cliMirrorURL returns the configured mirror with {version} substituted
+func (s *Server) cliMirrorURL() string {
+	mirror := strings.TrimRight(s.config.GetString("cli.mirror_url"), "/")
+	return strings.ReplaceAll(mirror, "{version}", s.config.GetString("version"))
+}
+
+// cliBinaryName returns the base name of the CLI binaries
+func (s *Server) cliBinaryName() string {
+	if name := s.config.GetString("cli.binary_name"); name != "" {
+		return name
+	}
+	return "casgists-cli"
+}
+
+// cliAssetName returns the release file name for a platform, matching the
+// <name>-<os>-<arch>[.exe] convention of the release build
+func cliAssetName(binary, goos, goarch string) string {
+	name := binary + "-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// installScript is the POSIX installer served at /install.sh. Arguments:
+// version, server URL, download base URL, binary name.
+const installScript = `#!/bin/sh
+# CasGists CLI installer - POSIX-compliant shell script
+# Generated dynamically by CasGists server
+# Version: %s
+#
+# Usage: curl -fsSL <server>/install.sh | sh
+#
+# Environment:
+#   CASGISTS_INSTALL_DIR    Install directory (default: /usr/local/bin if
+#                           writable, otherwise ~/.local/bin)
+#   CASGISTS_DOWNLOAD_BASE  Override where binaries and checksums.txt are
+#                           downloaded from
+
+set -eu
+
+CASGISTS_URL="${CASGISTS_URL:-%s}"
+DOWNLOAD_BASE="${CASGISTS_DOWNLOAD_BASE:-%s}"
+BINARY_NAME="%s"
+INSTALL_DIR="${CASGISTS_INSTALL_DIR:-}"
+
+# Colors for output (POSIX-compliant)
+if [ -t 1 ]; then
+    RED='\033[0;31m'
+    GREEN='\033[0;32m'
+    BLUE='\033[0;34m'
+    NC='\033[0m' # No Color
+else
+    RED=''
+    GREEN=''
+    BLUE=''
+    NC=''
+fi
+
+# Print error message and exit
+error() {
+    printf "${RED}Error: %%s${NC}\n" "$1" >&2
+    exit 1
+}
+
+# Print success message
+success() {
+    printf "${GREEN}✓ %%s${NC}\n" "$1"
+}
+
+# Print info message
+info() {
+    printf "${BLUE}→ %%s${NC}\n" "$1"
+}
+
+# Download a URL to a file with curl or wget
+fetch() {
+    if command -v curl >/dev/null 2>&1; then
+        curl -fsSL "$1" -o "$2"
+    elif command -v wget >/dev/null 2>&1; then
+        wget -q "$1" -O "$2"
+    else
+        error "curl or wget is required but neither is installed"
+    fi
+}
+
+# Print the SHA-256 of a file with whichever tool the system provides
+sha256() {
+    if command -v sha256sum >/dev/null 2>&1; then
+        sha256sum "$1" | cut -d' ' -f1
+    elif command -v shasum >/dev/null 2>&1; then
+        shasum -a 256 "$1" | cut -d' ' -f1
+    elif command -v sha256 >/dev/null 2>&1; then
+        sha256 -q "$1"
+    elif command -v openssl >/dev/null 2>&1; then
+        openssl dgst -sha256 "$1" | awk '{print $NF}'
+    else
+        error "no SHA-256 tool found (sha256sum, shasum, sha256 or openssl)"
+    fi
+}
+
+# Map uname -s to a Go OS name
+detect_os() {
+    case "$(uname -s)" in
+        Linux) echo linux ;;
+        Darwin) echo darwin ;;
+        FreeBSD) echo freebsd ;;
+        OpenBSD) echo openbsd ;;
+        NetBSD) echo netbsd ;;
+        MINGW*|MSYS*|CYGWIN*) echo windows ;;
+        *) echo unknown ;;
+    esac
+}
+
+# Map uname -m to a Go architecture name
+detect_arch() {
+    case "$(uname -m)" in
+        x86_64|amd64) echo amd64 ;;
+        aarch64|arm64) echo arm64 ;;
+        *) echo unknown ;;
+    esac
+}
+
+# Look up the expected checksum of a file in a sha256sum-style list
+expected_sum() {
+    awk -v name="$2" '$2 == name || $2 == "*" name { print $1; exit }' "$1"
+}
+
+# Verify a downloaded file against a checksum list
+verify() {
+    want=$(expected_sum "$3" "$2")
+    [ -n "$want" ] || error "no checksum published for $2"
+    got=$(sha256 "$1")
+    [ "$got" = "$want" ] || error "checksum mismatch for $2 (expected $want, got $got)"
+    success "Checksum verified for $2"
+}
+
+# Pick where to install when CASGISTS_INSTALL_DIR is not set
+install_dir() {
+    if [ -n "$INSTALL_DIR" ]; then
+        echo "$INSTALL_DIR"
+    elif [ -d /usr/local/bin ] && [ -w /usr/local/bin ]; then
+        echo /usr/local/bin
+    else
+        echo "$HOME/.local/bin"
+    fi
+}
+
+main() {
+    os=$(detect_os)
+    arch=$(detect_arch)
+    info "Detected platform: $os/$arch"
+
+    tmp=$(mktemp -d 2>/dev/null || mktemp -d -t casgists)
+    trap 'rm -rf "$tmp"' EXIT INT TERM
+
+    asset="$BINARY_NAME-$os-$arch"
+    target="$BINARY_NAME"
+    if [ "$os" = "windows" ]; then
+        asset="$asset.exe"
+        target="$target.exe"
+    fi
+
+    if fetch "$DOWNLOAD_BASE/checksums.txt" "$tmp/checksums.txt" 2>/dev/null &&
+        [ -n "$(expected_sum "$tmp/checksums.txt" "$asset")" ]; then
+        info "Downloading $asset"
+        fetch "$DOWNLOAD_BASE/$asset" "$tmp/$asset" || error "failed to download $asset"
+        verify "$tmp/$asset" "$asset" "$tmp/checksums.txt"
+        src="$tmp/$asset"
+    else
+        info "No prebuilt binary for $os/$arch, installing the shell CLI instead"
+        fetch "$CASGISTS_URL/cli/releases/checksums.txt" "$tmp/script-checksums.txt" ||
+            error "failed to download checksums from $CASGISTS_URL"
+        fetch "$CASGISTS_URL/cli" "$tmp/casgists-cli.sh" || error "failed to download the shell CLI"
+        verify "$tmp/casgists-cli.sh" "casgists-cli.sh" "$tmp/script-checksums.txt"
+        src="$tmp/casgists-cli.sh"
+        target="$BINARY_NAME"
+    fi
+
+    dir=$(install_dir)
+    mkdir -p "$dir" || error "cannot create $dir"
+    chmod 755 "$src"
+    mv "$src" "$dir/$target" 2>/dev/null ||
+        error "cannot write to $dir (set CASGISTS_INSTALL_DIR or re-run with sudo)"
+    success "Installed $dir/$target"
+
+    case ":$PATH:" in
+        *":$dir:"*) ;;
+        *) info "Add $dir to your PATH to run $target" ;;
+    esac
+}
+
+main "$@"
+`
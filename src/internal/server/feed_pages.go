@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// feedPageSize is how many feed items the home page shows at a time
+const feedPageSize = 30
+
+// handleFeedPage shows a signed in user the activity of the people they
+// follow on the home page. ?before= pages back in time.
+func (s *Server) handleFeedPage(c echo.Context, viewer *models.User) error {
+	var before time.Time
+	if raw := c.QueryParam("before"); raw != "" {
+		if parsed, err := time.Parse(time.RFC3339Nano, raw); err == nil {
+			before = parsed
+		}
+	}
+
+	items, err := services.NewFeedService(s.db).Feed(viewer, before, feedPageSize)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch feed")
+	}
+	data := map[string]interface{}{
+		"Title": "Home",
+		"Feed":  items,
+	}
+	if len(items) == feedPageSize {
+		data["Before"] = items[len(items)-1].CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return c.Render(http.StatusOK, "home_feed", data)
+}
@@ -0,0 +1,63 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/api/handlers"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+// embedCSP is the content security policy of embed pages. Unlike every other
+// page they may be framed by any site, and they load nothing.
+const embedCSP = "default-src 'none'; style-src 'unsafe-inline'; frame-ancestors *"
+
+// handleGistEmbed renders a public or unlisted gist, or a private one opened
+// with a share link, as a standalone page for third-party sites to put in
+// an iframe, unless its owner turned embedding off or it burns after reading
+func (s *Server) handleGistEmbed(c echo.Context) error {
+	var gist models.Gist
+	err := s.db.Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated).Preload("Files").
+		First(&gist, "id = ?", c.Param("id")).Error
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+	}
+	if gist.Visibility == models.VisibilityPrivate &&
+		!handlers.SharedRead(c, services.NewGistShareService(s.db), &gist) {
+		return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+	}
+	if gist.EmbedDisabled {
+		return echo.NewHTTPError(http.StatusForbidden, "embedding is disabled for this gist")
+	}
+	// Every embed view is anonymous, and link previews would burn the gist
+	// before anyone saw it
+	if gist.BurnAfterRead {
+		return echo.NewHTTPError(http.StatusForbidden, "burn-after-read gists can't be embedded")
+	}
+
+	header := c.Response().Header()
+	header.Del("X-Frame-Options")
+	header.Set("Content-Security-Policy", embedCSP)
+	noIndex(c, &gist)
+
+	// Rendered first, so the page is tagged with the ETag of what it shows
+	var page bytes.Buffer
+	err = c.Echo().Renderer.Render(&page, "gist_embed", map[string]interface{}{
+		"Gist":    &gist,
+		"GistURL": urls.NewBuilder(s.config).Gist(gist.ID),
+		"NoIndex": gist.IndexingDisabled,
+	}, c)
+	if err != nil {
+		return err
+	}
+	httpcache.Revalidate(c, gist.Visibility != models.VisibilityPrivate)
+	if httpcache.NotModified(c, httpcache.ETag(page.Bytes()), gist.UpdatedAt) {
+		return c.NoContent(http.StatusNotModified)
+	}
+	return c.HTMLBlob(http.StatusOK, page.Bytes())
+}
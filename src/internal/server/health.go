@@ -0,0 +1,213 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// Readiness components, as named in /readyz and health.required
+const (
+	componentDatabase    = "database"
+	componentCache       = "cache"
+	componentStorage     = "storage"
+	componentRepoStorage = "repo_storage"
+	componentSearch      = "search"
+	componentSMTP        = "smtp"
+)
+
+// ComponentCheck is one component's result in a /readyz response
+type ComponentCheck struct {
+	Status              string  `json:"status"` // up or down
+	LatencyMs           float64 `json:"latency_ms"`
+	Error               string  `json:"error,omitempty"`
+	Required            bool    `json:"required"`
+	ConsecutiveFailures int     `json:"consecutive_failures"`
+}
+
+// readiness counts each component's consecutive failed checks, so one
+// slow probe doesn't take the instance out of the load balancer
+type readiness struct {
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func newReadiness() *readiness {
+	return &readiness{failures: map[string]int{}}
+}
+
+// record notes a check's outcome and returns the component's run of
+// consecutive failures, zero after a success
+func (r *readiness) record(component string, failed bool) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !failed {
+		delete(r.failures, component)
+		return 0
+	}
+	r.failures[component]++
+	return r.failures[component]
+}
+
+// handleLivez reports that the process is up and serving requests. It
+// checks nothing else, so a restart is only triggered by a wedged process
+// and not by a database outage a restart wouldn't fix.
+func (s *Server) handleLivez(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"status":         "alive",
+		"uptime_seconds": int64(time.Since(s.startTime).Seconds()),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// handleReadyz checks every dependency at once, each within
+// health.timeout, and reports whether the instance should get traffic.
+// It answers 503 once a component in health.required has failed
+// health.failure_threshold checks in a row; other failing components only
+// mark the instance degraded. While shutting down it answers 503 without
+// checking anything.
+func (s *Server) handleReadyz(c echo.Context) error {
+	if s.draining.Load() {
+		return c.JSON(http.StatusServiceUnavailable, map[string]interface{}{
+			"status":    "shutting_down",
+			"timestamp": time.Now().UTC().Format(time.RFC3339),
+		})
+	}
+
+	checks := s.readinessChecks()
+	timeout := s.config.GetDuration("health.timeout")
+	if timeout <= 0 {
+		timeout = 2 * time.Second
+	}
+	threshold := s.config.GetInt("health.failure_threshold")
+	if threshold < 1 {
+		threshold = 1
+	}
+	required := map[string]bool{}
+	for _, name := range s.config.GetStringSlice("health.required") {
+		required[name] = true
+	}
+
+	results := make(map[string]*ComponentCheck, len(checks))
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range checks {
+		wg.Add(1)
+		go func(name string, check func(context.Context) error) {
+			defer wg.Done()
+			ctx, cancel := context.WithTimeout(c.Request().Context(), timeout)
+			defer cancel()
+
+			started := time.Now()
+			err := runCheck(ctx, check)
+			result := &ComponentCheck{
+				Status:    "up",
+				LatencyMs: float64(time.Since(started).Microseconds()) / 1000,
+				Required:  required[name],
+			}
+			if err != nil {
+				result.Status = "down"
+				result.Error = err.Error()
+			}
+			result.ConsecutiveFailures = s.readiness.record(name, err != nil)
+
+			mu.Lock()
+			results[name] = result
+			mu.Unlock()
+		}(name, check)
+	}
+	wg.Wait()
+
+	status, code := "ready", http.StatusOK
+	for _, result := range results {
+		if result.Status == "up" {
+			continue
+		}
+		if result.Required && result.ConsecutiveFailures >= threshold {
+			status, code = "not_ready", http.StatusServiceUnavailable
+			break
+		}
+		status = "degraded"
+	}
+
+	return c.JSON(code, map[string]interface{}{
+		"status":            status,
+		"failure_threshold": threshold,
+		"timeout_ms":        timeout.Milliseconds(),
+		"components":        results,
+		"timestamp":         time.Now().UTC().Format(time.RFC3339),
+	})
+}
+
+// runCheck runs a check, giving up when its context ends even if the
+// check itself ignores the context
+func runCheck(ctx context.Context, check func(context.Context) error) error {
+	done := make(chan error, 1)
+	go func() { done <- check(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("timed out: %w", ctx.Err())
+	}
+}
+
+// readinessChecks returns the checks for the components this instance
+// uses; disabled ones, such as the cache or email, aren't checked
+func (s *Server) readinessChecks() map[string]func(context.Context) error {
+	checks := map[string]func(context.Context) error{
+		componentDatabase: func(ctx context.Context) error {
+			return s.db.WithContext(ctx).Exec("SELECT 1").Error
+		},
+		componentStorage: func(ctx context.Context) error {
+			if s.objectStore == nil {
+				return fmt.Errorf("storage.type %q could not be opened", s.config.GetString("storage.type"))
+			}
+			if health := s.objectStore.Health(ctx); !health.Healthy {
+				return fmt.Errorf("%s", health.Error)
+			}
+			return nil
+		},
+	}
+
+	if s.repoStorage != nil {
+		checks[componentRepoStorage] = func(ctx context.Context) error {
+			if health := s.repoStorage.Health(ctx); !health.Healthy {
+				return fmt.Errorf("%s", health.Error)
+			}
+			return nil
+		}
+	}
+
+	if s.cache != nil && s.cache.Enabled() {
+		checks[componentCache] = s.cache.Ping
+	}
+
+	if s.searchManager != nil {
+		checks[componentSearch] = func(ctx context.Context) error {
+			// A lagging index still answers searches, so only errors count
+			_, err := s.searchManager.Health(ctx, s.config.GetDuration("search.index.max_lag"))
+			return err
+		}
+	}
+
+	if s.config.GetBool("email.enabled") {
+		checks[componentSMTP] = func(ctx context.Context) error {
+			address := net.JoinHostPort(s.config.GetString("email.smtp.host"), strconv.Itoa(s.config.GetInt("email.smtp.port")))
+			var dialer net.Dialer
+			conn, err := dialer.DialContext(ctx, "tcp", address)
+			if err != nil {
+				return err
+			}
+			return conn.Close()
+		}
+	}
+
+	return checks
+}
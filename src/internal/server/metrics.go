@@ -0,0 +1,92 @@
+package server
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"runtime"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	echoMiddleware "github.com/casapps/casgists/src/internal/api/middleware"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/metrics"
+)
+
+// newMetricsRegistry registers the instance gauges, which are read when
+// /metrics is scraped. Request, query and webhook metrics live in
+// metrics.Default and are filled in as they happen.
+func (s *Server) newMetricsRegistry() *metrics.Registry {
+	r := metrics.NewRegistry()
+
+	r.NewGaugeFunc("casgists_uptime_seconds", "Seconds since the server started.", func() float64 {
+		return time.Since(s.startTime).Seconds()
+	})
+	r.NewGaugeFunc("casgists_users", "User accounts, not counting deleted ones.", func() float64 {
+		var count int64
+		s.db.Table("users").Scopes(models.NotDeleted("users")).Count(&count)
+		return float64(count)
+	})
+	r.NewLabeledGaugeFunc("casgists_gists", "Gists by visibility, not counting deleted ones.", "visibility", func() map[string]float64 {
+		var rows []struct {
+			Visibility string
+			Count      int64
+		}
+		s.db.Table("gists").Scopes(models.NotDeleted("gists")).
+			Select("visibility, COUNT(*) AS count").Group("visibility").Scan(&rows)
+		counts := make(map[string]float64, len(rows))
+		for _, row := range rows {
+			counts[row.Visibility] = float64(row.Count)
+		}
+		return counts
+	})
+	r.NewGaugeFunc("casgists_organizations", "Organizations, not counting deleted ones.", func() float64 {
+		var count int64
+		s.db.Table("organizations").Scopes(models.NotDeleted("organizations")).Count(&count)
+		return float64(count)
+	})
+
+	r.NewCounterFunc("casgists_cache_hits_total", "Cache lookups that found a value.", func() float64 {
+		hits, _ := s.cache.Counts()
+		return float64(hits)
+	})
+	r.NewCounterFunc("casgists_cache_misses_total", "Cache lookups that found nothing.", func() float64 {
+		_, misses := s.cache.Counts()
+		return float64(misses)
+	})
+
+	r.NewLabeledGaugeFunc("casgists_db_connections", "Open database connections to the primary, by state.", "state", func() map[string]float64 {
+		sqlDB, err := s.db.DB()
+		if err != nil {
+			return nil
+		}
+		stats := sqlDB.Stats()
+		return map[string]float64{"in_use": float64(stats.InUse), "idle": float64(stats.Idle)}
+	})
+
+	r.NewGaugeFunc("casgists_goroutines", "Goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+	r.NewGaugeFunc("casgists_memory_alloc_bytes", "Bytes of allocated heap objects.", func() float64 {
+		var mem runtime.MemStats
+		runtime.ReadMemStats(&mem)
+		return float64(mem.Alloc)
+	})
+	return r
+}
+
+// handleMetrics serves the Prometheus metrics. metrics.token, when set,
+// must be sent as a bearer token.
+func (s *Server) handleMetrics(c echo.Context) error {
+	if !s.config.GetBool("metrics.enabled") {
+		return echo.ErrNotFound
+	}
+	if token := s.config.GetString("metrics.token"); token != "" {
+		given := c.Request().Header.Get(echo.HeaderAuthorization)
+		if subtle.ConstantTimeCompare([]byte(given), []byte("Bearer "+token)) != 1 {
+			c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Bearer realm="metrics"`)
+			return echo.NewHTTPError(http.StatusUnauthorized, "a metrics token is required")
+		}
+	}
+	return echoMiddleware.MetricsHandler(metrics.Default, s.metrics)(c)
+}
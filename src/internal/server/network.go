@@ -84,11 +84,7 @@ func (nd *NetworkDetector) DetectReverseProxy(c echo.Context) string {
 func (nd *NetworkDetector) GetBestURL(c echo.Context, port int) string {
 	// Check for reverse proxy first
 	if proxy := nd.DetectReverseProxy(c); proxy != "" {
-		scheme := "http"
-		if c.Request().TLS != nil || c.Request().Header.Get("X-Forwarded-Proto") == "https" {
-			scheme = "https"
-		}
-		return fmt.Sprintf("%s://%s", scheme, proxy)
+		return fmt.Sprintf("%s://%s", c.Scheme(), proxy)
 	}
 	
 	// Use FQDN if available
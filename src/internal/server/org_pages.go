@@ -0,0 +1,80 @@
+package server
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// pageViewer returns the signed in user of a page request, or nil
+func (s *Server) pageViewer(c echo.Context) *models.User {
+	userID, ok := c.Get("user_id").(uuid.UUID)
+	if !ok {
+		return nil
+	}
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil
+	}
+	return &user
+}
+
+// handleOrgGistsPage lists an organization's gists at /o/:org
+func (s *Server) handleOrgGistsPage(c echo.Context) error {
+	view, err := services.NewOrgGistService(s.db).View(c.Param("org"), s.pageViewer(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "organization not found")
+	}
+	return c.Render(http.StatusOK, "org_gists", map[string]interface{}{
+		"Title":   view.Organization.Name,
+		"OrgName": view.Organization.Name,
+	})
+}
+
+// handleOrgGistPage shows the gist at /o/:org/:slug and sends old addresses
+// of renamed or moved gists to the current one
+func (s *Server) handleOrgGistPage(c echo.Context) error {
+	service := services.NewOrgGistService(s.db)
+	view, err := service.View(c.Param("org"), s.pageViewer(c))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "organization not found")
+	}
+	gist, moved, err := service.Resolve(view, c.Param("slug"))
+	if err != nil {
+		if errors.Is(err, services.ErrOrgGistNotFound) {
+			return echo.NewHTTPError(http.StatusNotFound, "gist not found")
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, "failed to fetch gist")
+	}
+	if moved != "" {
+		return c.Redirect(http.StatusMovedPermanently, moved)
+	}
+	return c.Render(http.StatusOK, "org_gist", map[string]interface{}{
+		"Title":   gist.Title,
+		"OrgName": view.Organization.Name,
+		"Slug":    gist.SlugValue(),
+		"NoIndex": noIndex(c, gist),
+	})
+}
+
+// orgGistRedirect returns the /o/:org/:slug address of a non-private
+// organization gist, so /gists/:id links land on its canonical page
+func (s *Server) orgGistRedirect(id string) string {
+	gistID, err := uuid.Parse(id)
+	if err != nil {
+		return ""
+	}
+	var gist models.Gist
+	if err := s.db.Preload("Organization").First(&gist, "id = ?", gistID).Error; err != nil {
+		return ""
+	}
+	if gist.Organization == nil || gist.Slug == nil || gist.Visibility == models.VisibilityPrivate {
+		return ""
+	}
+	return models.OrgGistPath(gist.Organization.Name, *gist.Slug)
+}
@@ -0,0 +1,98 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/httpcache"
+)
+
+// rawImmutableAge is how long caches keep a file read at a full commit
+// hash, which never changes
+const rawImmutableAge = 365 * 24 * time.Hour
+
+// Errors parsing a ?lines= range
+var (
+	errLinesInvalid    = errors.New("lines must be a line number or a range such as 10-40")
+	errLinesOutOfRange = errors.New("lines start past the end of the file")
+)
+
+// rawContentTypes maps file extensions to the types raw files are served
+// as; everything else is plain text
+var rawContentTypes = map[string]string{
+	".json": "application/json; charset=utf-8",
+	".xml":  "application/xml; charset=utf-8",
+	".html": "text/html; charset=utf-8",
+	".css":  "text/css; charset=utf-8",
+	".js":   "application/javascript; charset=utf-8",
+}
+
+// rawContentType returns the Content-Type of a raw file
+func rawContentType(filename string) string {
+	if contentType, ok := rawContentTypes[strings.ToLower(path.Ext(filename))]; ok {
+		return contentType
+	}
+	return "text/plain; charset=utf-8"
+}
+
+// sliceLines returns the lines of content a ?lines= value names: one line
+// number or an inclusive range, counted from 1. A range running past the
+// end of the file stops there.
+func sliceLines(content, spec string) (string, error) {
+	first, last, isRange := strings.Cut(spec, "-")
+	start, err := strconv.Atoi(first)
+	if err != nil || start < 1 {
+		return "", errLinesInvalid
+	}
+	end := start
+	if isRange {
+		end, err = strconv.Atoi(last)
+		if err != nil || end < start {
+			return "", errLinesInvalid
+		}
+	}
+
+	lines := strings.SplitAfter(content, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if start > len(lines) {
+		return "", errLinesOutOfRange
+	}
+	if end > len(lines) {
+		end = len(lines)
+	}
+	return strings.Join(lines[start-1:end], ""), nil
+}
+
+// setRawCaching sets the Cache-Control of a raw file response. Responses
+// already marked no-store, for share links and burn-after-read gists, are
+// left alone. A file read at a full commit hash is immutable, up to the
+// gist's expiry; the current version is revalidated on every use.
+func setRawCaching(c echo.Context, gist *models.Gist, immutable bool) {
+	if httpcache.NoStore(c) {
+		return
+	}
+	shared := gist.Visibility != models.VisibilityPrivate
+	if !immutable {
+		httpcache.Revalidate(c, shared)
+		return
+	}
+
+	scope := "public"
+	if !shared {
+		scope = "private"
+	}
+	policy := fmt.Sprintf("max-age=%d, immutable", int(rawImmutableAge.Seconds()))
+	if gist.ExpiresAt != nil {
+		policy = fmt.Sprintf("max-age=%d", max(int(time.Until(*gist.ExpiresAt).Seconds()), 0))
+	}
+	c.Response().Header().Set(echo.HeaderCacheControl, scope+", "+policy)
+}
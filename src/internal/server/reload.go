@@ -0,0 +1,23 @@
+package server
+
+import (
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/logging"
+)
+
+// ReloadConfig reads the config file again and applies it, for SIGHUP
+func (s *Server) ReloadConfig() (*config.ReloadResult, error) {
+	return s.reloader.Reload()
+}
+
+// applyConfig hands the settings that components only read at startup to
+// them after a reload or a change from the admin settings API. The rest
+// read the configuration on each use.
+func (s *Server) applyConfig(v *config.Config) {
+	level, _ := logging.ParseLevel(v.GetString("logging.level"))
+	logging.SetLevel(level)
+	s.rateLimit.Reload(v)
+	if s.emailService != nil {
+		s.emailService.Reconfigure()
+	}
+}
@@ -1,16 +1,31 @@
 package server
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"strings"
-	"syscall"
 	"time"
 
 	"github.com/casapps/casgists/src/internal/api/handlers"
+	echoMiddleware "github.com/casapps/casgists/src/internal/api/middleware"
 	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/oauth"
+	"github.com/casapps/casgists/src/internal/auth/passkey"
+	"github.com/casapps/casgists/src/internal/counters"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/diskspace"
+	"github.com/casapps/casgists/src/internal/git"
+	"github.com/casapps/casgists/src/internal/httpcache"
+	"github.com/casapps/casgists/src/internal/imageproxy"
+	"github.com/casapps/casgists/src/internal/ratelimit"
+	"github.com/casapps/casgists/src/internal/search"
+	"github.com/casapps/casgists/src/internal/services"
+	"github.com/casapps/casgists/src/internal/streaming"
+	"github.com/casapps/casgists/src/internal/update"
+	"github.com/casapps/casgists/src/internal/urls"
 	"github.com/labstack/echo/v4"
 )
 
@@ -22,44 +37,87 @@ func (s *Server) setupRoutes() {
 	// Health check
 	s.echo.GET("/health", s.handleHealth)
 	s.echo.GET("/healthz", s.handleHealthz)
+	s.echo.GET("/livez", s.handleLivez)
+	s.echo.GET("/readyz", s.handleReadyz)
+	s.echo.GET("/metrics", s.handleMetrics)
 
 	// CLI script generation endpoint
 	s.echo.GET("/cli", s.handleCLIScript)
 	s.echo.GET("/cli.sh", s.handleCLIScript)
 
+	// CLI installer: detects OS/arch and installs a checksum-verified binary
+	s.echo.GET("/install.sh", s.handleInstallScript)
+	s.echo.GET("/cli/releases", s.handleCLIReleases)
+	s.echo.GET("/cli/releases/checksums.txt", s.handleCLIChecksums)
+	s.echo.GET("/cli/releases/:name", s.handleCLIDownload)
+
 	// Static files (now handled in setupStaticRoutes)
 	s.setupStaticRoutes()
 
 	// Public routes
-	s.echo.GET("/", s.handleHome)
+	s.echo.GET("/", s.handleHome, authMiddleware.OptionalAuth())
 	s.echo.GET("/login", s.handleLoginPage)
 	s.echo.GET("/register", s.handleRegisterPage)
+	s.echo.GET("/device", s.handleDevicePage)
+	s.echo.GET("/orgs/:name/settings", s.handleOrgSettingsPage)
+	s.echo.GET("/admin/settings", s.handleAdminSettingsPage)
 
 	// Web gist routes (with auth)
 	s.echo.GET("/gists", s.handleGistListPage, authMiddleware.Auth())
 	s.echo.GET("/gists/new", s.handleGistNewPage, authMiddleware.Auth())
-	s.echo.GET("/gists/:id", s.handleGistViewPage)
+	s.echo.GET(urls.GistPage, s.handleGistViewPage)
+	s.echo.GET(urls.GistEmbed, s.handleGistEmbed)
+
+	// Organization gist namespace
+	s.echo.GET("/o/:org", s.handleOrgGistsPage, authMiddleware.OptionalAuth())
+	s.echo.GET("/o/:org/:slug", s.handleOrgGistPage, authMiddleware.OptionalAuth())
+
+	// User profiles
+	s.echo.GET("/u/:username", s.handleUserProfilePage)
+
+	// Tag browsing
+	s.echo.GET("/tags", s.handleTagsPage)
+	s.echo.GET("/tags/:name", s.handleTagPage)
 
 	// Authentication routes
 	authGroup := s.echo.Group("/auth")
-	authGroup.POST("/login", s.handleLogin)
-	authGroup.POST("/register", s.handleRegister)
+	authGroup.POST("/login", s.handleLogin, s.rateLimit.Middleware(ratelimit.PolicyLogin))
+	authGroup.POST("/register", s.handleRegister, s.rateLimit.Middleware(ratelimit.PolicyRegister))
 	authGroup.POST("/logout", s.handleLogout, authMiddleware.Auth())
 	authGroup.POST("/refresh", s.handleRefreshToken)
 	authGroup.GET("/2fa/setup", s.handle2FASetup, authMiddleware.Auth())
 	authGroup.POST("/2fa/verify", s.handle2FAVerify, authMiddleware.Auth())
 	authGroup.POST("/2fa/disable", s.handle2FADisable, authMiddleware.Auth())
+	authGroup.POST("/2fa/recover", s.handle2FARecover, s.rateLimit.Middleware(ratelimit.PolicyLogin))
+	authGroup.GET("/2fa/recovery-codes", s.handle2FARecoveryCodes, authMiddleware.Auth())
+	authGroup.POST("/2fa/recovery-codes", s.handle2FARegenerateRecoveryCodes, authMiddleware.Auth())
+
+	// Security keys and passkeys
+	webAuthnHandler := handlers.NewWebAuthnHandler(s.db, s.auth, s.config)
+	webAuthnHandler.RegisterRoutes(authGroup, authMiddleware.Auth(), authMiddleware.OptionalAuth())
+
+	// Sign in with GitHub, GitLab or an OpenID Connect provider
+	oauthHandler := handlers.NewOAuthHandler(s.db, s.auth, s.config, s.oauthProviders)
+	s.echo.GET(oauth.LoginRoute, oauthHandler.Start)
+	s.echo.GET(oauth.CallbackRoute, oauthHandler.Callback)
 
-	// API v1 routes
-	apiV1 := s.echo.Group("/api/v1")
+	// API v1 routes, rate limited per client IP and per token
+	apiV1 := s.echo.Group("/api/v1", s.rateLimit.Middleware(ratelimit.PolicyAPI))
 	s.setupAPIv1Routes(apiV1)
 
 	// Search routes
 	searchHandler := handlers.NewSearchHandler(s.searchManager, s.config, s.db)
-	searchHandler.RegisterRoutes(apiV1)
+	searchHandler.RegisterRoutes(apiV1, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+
+	// API documentation and the OpenAPI spec
+	s.registerDocumentationRoutes(s.echo.Group("/api", s.rateLimit.Middleware(ratelimit.PolicyAPI)))
+
+	// API v2 preview routes
+	apiV2 := s.echo.Group("/api/v2", s.rateLimit.Middleware(ratelimit.PolicyAPI))
+	s.setupAPIv2Routes(apiV2)
 
 	// Gist API routes
-	gistGroup := s.echo.Group("/api/gists", authMiddleware.Auth())
+	gistGroup := s.echo.Group("/api/gists", s.rateLimit.Middleware(ratelimit.PolicyAPI), authMiddleware.Auth())
 	gistGroup.GET("", s.handleGetGists)
 	gistGroup.POST("", s.handleCreateGist)
 	gistGroup.GET("/:id", s.handleGetGist)
@@ -70,8 +128,16 @@ func (s *Server) setupRoutes() {
 	gistGroup.GET("/:id/stars", s.handleGetStars)
 	gistGroup.POST("/:id/fork", s.handleForkGist)
 	gistGroup.GET("/:id/forks", s.handleGetForks)
-	gistGroup.GET("/:id/comments", s.handleGetComments)
-	gistGroup.POST("/:id/comments", s.handleCreateComment)
+
+	// Comments, one-level reply threads and reactions
+	commentHandler := handlers.NewCommentHandler(s.db, s.config, s.emailService)
+	commentGroup := gistGroup.Group("/:id/comments")
+	commentGroup.GET("", commentHandler.List)
+	commentGroup.POST("", commentHandler.Create)
+	commentGroup.PATCH("/:commentID", commentHandler.Update)
+	commentGroup.DELETE("/:commentID", commentHandler.Delete)
+	commentGroup.POST("/:commentID/reactions", commentHandler.AddReaction)
+	commentGroup.DELETE("/:commentID/reactions/:reaction", commentHandler.RemoveReaction)
 
 	// User routes
 	userGroup := s.echo.Group("/users", authMiddleware.Auth())
@@ -81,16 +147,7 @@ func (s *Server) setupRoutes() {
 	userGroup.POST("/:username/follow", s.handleFollowUser)
 	userGroup.DELETE("/:username/follow", s.handleUnfollowUser)
 
-	// Organization routes
-	// orgGroup := s.echo.Group("/orgs", authMiddleware.Auth())
-	// orgGroup.GET("", s.handleGetOrganizations)
-	// orgGroup.POST("", s.handleCreateOrganization)
-	// orgGroup.GET("/:org", s.handleGetOrganization)
-	// orgGroup.PUT("/:org", s.handleUpdateOrganization)
-	// orgGroup.DELETE("/:org", s.handleDeleteOrganization)
-	// orgGroup.GET("/:org/members", s.handleGetOrgMembers)
-	// orgGroup.POST("/:org/members/:username", s.handleAddOrgMember)
-	// orgGroup.DELETE("/:org/members/:username", s.handleRemoveOrgMember)
+	// Organization routes are served under /api/v1/orgs by OrganizationHandler
 
 	// Admin routes
 	// adminGroup := s.echo.Group("/admin", authMiddleware.Auth(), authMiddleware.RequireAdmin())
@@ -116,38 +173,86 @@ func (s *Server) setupRoutes() {
 	// webhookGroup.POST("/:id/test", s.handleTestWebhook)
 
 	// Public gist viewing (short URLs)
-	s.echo.GET("/g/:id", s.handlePublicGist, authMiddleware.OptionalAuth())
-	s.echo.GET("/raw/:id/:file", s.handleRawFile, authMiddleware.OptionalAuth())
+	s.echo.GET(urls.GistJSON, s.handlePublicGist, authMiddleware.OptionalAuth())
+	s.echo.GET(urls.RawFile, s.handleRawFile, s.rateLimit.Middleware(ratelimit.PolicyRaw), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	s.echo.GET(urls.RawRevision, s.handleRawFile, s.rateLimit.Middleware(ratelimit.PolicyRaw), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+
+	// Paths older pages and clients link to
+	for legacy, canonical := range urls.LegacyRoutes {
+		s.echo.GET(legacy, echoMiddleware.RedirectTo(canonical))
+	}
+
+	// External images in markdown and avatars, see imageproxy.Signer
+	if s.imageProxy != nil {
+		s.echo.GET(imageproxy.Path+":digest/:url", s.imageProxy.Handle)
+	}
+
+	// Mark legacy routes that have /api/v1 replacements as deprecated
+	s.registerDeprecations()
 
 	// Catch-all for 404
 	s.echo.RouteNotFound("/*", s.handle404)
 }
 
+// registerDeprecations registers the legacy routes superseded by /api/v1
+func (s *Server) registerDeprecations() {
+	since := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+	sunset := time.Date(2027, time.April, 1, 0, 0, 0, 0, time.UTC)
+
+	legacy := []struct {
+		method      string
+		path        string
+		replacement string
+	}{
+		{http.MethodGet, "/api/gists", "/api/v1/gists"},
+		{http.MethodPost, "/api/gists", "/api/v1/gists"},
+		{http.MethodGet, "/api/gists/:id", "/api/v1/gists/:id"},
+		{http.MethodPut, "/api/gists/:id", "/api/v1/gists/:id"},
+		{http.MethodDelete, "/api/gists/:id", "/api/v1/gists/:id"},
+		{http.MethodGet, "/users/me", "/api/v1/user"},
+		{http.MethodPut, "/users/me", "/api/v1/user"},
+		{http.MethodGet, "/users/:username", "/api/v1/users/:username"},
+	}
+
+	for _, route := range legacy {
+		s.deprecations.Register(echoMiddleware.Deprecation{
+			Method:      route.method,
+			Path:        route.path,
+			Since:       since,
+			Sunset:      sunset,
+			Replacement: route.replacement,
+			Message:     "Use the versioned /api/v1 endpoint instead",
+		})
+	}
+}
+
 // Placeholder handlers - these would be implemented properly
 func (s *Server) handleHome(c echo.Context) error {
+	if viewer := s.pageViewer(c); viewer != nil {
+		return s.handleFeedPage(c, viewer)
+	}
 	return c.Render(http.StatusOK, "home", map[string]interface{}{
 		"Title": "Welcome",
-		"Gists": []interface{}{}, // TODO: Get recent public gists
 	})
 }
 
 func (s *Server) handleLogin(c echo.Context) error {
-	handler := handlers.NewAuthHandler(s.db, s.auth, s.config)
+	handler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
 	return handler.Login(c)
 }
 
 func (s *Server) handleRegister(c echo.Context) error {
-	handler := handlers.NewAuthHandler(s.db, s.auth, s.config)
+	handler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
 	return handler.Register(c)
 }
 
 func (s *Server) handleLogout(c echo.Context) error {
-	handler := handlers.NewAuthHandler(s.db, s.auth, s.config)
+	handler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
 	return handler.Logout(c)
 }
 
 func (s *Server) handleRefreshToken(c echo.Context) error {
-	handler := handlers.NewAuthHandler(s.db, s.auth, s.config)
+	handler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
 	return handler.RefreshToken(c)
 }
 
@@ -181,8 +286,8 @@ func (s *Server) handle2FASetup(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to save 2FA secret")
 	}
 
-	// Generate recovery codes
-	recoveryCodes, err := auth.GenerateRecoveryCodes(8)
+	// Generate recovery codes; only their hashes are kept
+	recoveryCodes, err := services.NewTwoFactorService(s.db, s.config).NewRecoveryCodes(user.ID)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate recovery codes")
 	}
@@ -285,6 +390,9 @@ func (s *Server) handle2FADisable(c echo.Context) error {
 	if err := s.db.Model(&user).Updates(updates).Error; err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to disable 2FA")
 	}
+	if err := services.NewTwoFactorService(s.db, s.config).DeleteRecoveryCodes(user.ID); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to delete recovery codes")
+	}
 
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"enabled": false,
@@ -292,143 +400,122 @@ func (s *Server) handle2FADisable(c echo.Context) error {
 	})
 }
 
+func (s *Server) handle2FARecover(c echo.Context) error {
+	handler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
+	return handler.Recover(c)
+}
+
+func (s *Server) handle2FARecoveryCodes(c echo.Context) error {
+	// Get user from JWT token
+	userID, err := s.auth.GetUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	remaining, err := services.NewTwoFactorService(s.db, s.config).RemainingRecoveryCodes(userID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to count recovery codes")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"remaining": remaining,
+	})
+}
+
+func (s *Server) handle2FARegenerateRecoveryCodes(c echo.Context) error {
+	// Get user from JWT token
+	userID, err := s.auth.GetUserIDFromToken(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
+	}
+
+	// Parse request body
+	var req struct {
+		Code string `json:"code" validate:"required"`
+	}
+	if err := c.Bind(&req); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
+	}
+
+	// Get user from database
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "User not found")
+	}
+
+	// Check if 2FA is enabled
+	if !user.TwoFactorEnabled {
+		return echo.NewHTTPError(http.StatusBadRequest, "2FA is not enabled")
+	}
+
+	// New codes need a code from the authenticator app, so a stolen
+	// session can't replace them
+	totpService := auth.NewTOTPService("CasGists")
+	if !totpService.ValidateTOTP(user.TwoFactorSecret, req.Code) {
+		return echo.NewHTTPError(http.StatusBadRequest, "Invalid 2FA code")
+	}
+
+	recoveryCodes, err := services.NewTwoFactorService(s.db, s.config).NewRecoveryCodes(user.ID)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to generate recovery codes")
+	}
+
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"recovery_codes": recoveryCodes,
+	})
+}
+
 func (s *Server) handleGetGists(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.List(c)
 }
 
 func (s *Server) handleCreateGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Create(c)
 }
 
 func (s *Server) handleGetGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Get(c)
 }
 
 func (s *Server) handleUpdateGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Update(c)
 }
 
 func (s *Server) handleDeleteGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Delete(c)
 }
 
 // Additional handlers
 func (s *Server) handleStarGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil)
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Star(c)
 }
 
 func (s *Server) handleUnstarGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil)
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Unstar(c)
 }
 
 func (s *Server) handleForkGist(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil)
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.Fork(c)
 }
 
 func (s *Server) handleGetStars(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil)
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.GetStars(c)
 }
 
 func (s *Server) handleGetForks(c echo.Context) error {
-	handler := handlers.NewGistHandler(s.db, s.config, nil)
+	handler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
 	return handler.GetForks(c)
 }
 
-func (s *Server) handleGetComments(c echo.Context) error {
-	gistID := c.Param("gistId")
-	if gistID == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Gist ID required")
-	}
-
-	// Verify gist exists and user has access
-	var gist models.Gist
-	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Gist not found")
-	}
-
-	// Check visibility permissions
-	userID, _ := s.auth.GetUserIDFromToken(c)
-	if gist.Visibility == "private" && gist.UserID != nil && *gist.UserID != userID {
-		return echo.NewHTTPError(http.StatusForbidden, "Access denied")
-	}
-
-	// Get comments with user information
-	var comments []models.GistComment
-	if err := s.db.Preload("User").Where("gist_id = ? AND deleted_at IS NULL", gistID).
-		Order("created_at ASC").Find(&comments).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get comments")
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"comments": comments,
-		"total":    len(comments),
-	})
-}
-
-func (s *Server) handleCreateComment(c echo.Context) error {
-	gistID := c.Param("gistId")
-	if gistID == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Gist ID required")
-	}
-
-	// Get user from token
-	userID, err := s.auth.GetUserIDFromToken(c)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
-	}
-
-	// Parse request body
-	var req struct {
-		Content string `json:"content" validate:"required"`
-	}
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
-	}
-
-	// Validate content length
-	if len(req.Content) == 0 || len(req.Content) > 1000 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Comment content must be 1-1000 characters")
-	}
-
-	// Verify gist exists and user has access
-	var gist models.Gist
-	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Gist not found")
-	}
-
-	// Check visibility permissions
-	if gist.Visibility == "private" && gist.UserID != nil && *gist.UserID != userID {
-		return echo.NewHTTPError(http.StatusForbidden, "Access denied")
-	}
-
-	// Create comment
-	comment := models.GistComment{
-		GistID:  gist.ID,
-		UserID:  userID,
-		Content: req.Content,
-	}
-
-	if err := s.db.Create(&comment).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create comment")
-	}
-
-	// Load user info for response
-	if err := s.db.Preload("User").First(&comment, comment.ID).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to load comment")
-	}
-
-	return c.JSON(http.StatusCreated, comment)
-}
-
 func (s *Server) handleGetCurrentUser(c echo.Context) error {
 	handler := handlers.NewUserHandler(s.db, s.config)
 	return handler.GetCurrent(c)
@@ -484,6 +571,14 @@ func (s *Server) handleFollowUser(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to follow user")
 	}
 
+	var follower models.User
+	if err := s.db.First(&follower, "id = ?", followerID).Error; err == nil {
+		go s.webhookService.TriggerUserFollowed(context.Background(), &follower, &targetUser)
+		if err := s.notifier.Followed(targetUser.ID, &follower); err != nil {
+			c.Logger().Errorf("Failed to notify %s of new follower: %v", targetUser.Username, err)
+		}
+	}
+
 	return c.JSON(http.StatusOK, map[string]interface{}{
 		"following": true,
 		"message":   fmt.Sprintf("Now following %s", targetUser.Username),
@@ -524,216 +619,6 @@ func (s *Server) handleUnfollowUser(c echo.Context) error {
 	})
 }
 
-func (s *Server) handleGetOrganizations(c echo.Context) error {
-	// Get user from token (if authenticated)
-	userID, err := s.auth.GetUserIDFromToken(c)
-	var organizations []models.Organization
-
-	if err != nil {
-		// Public view - only show public organizations
-		if err := s.db.Where("is_public = ?", true).Find(&organizations).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get organizations")
-		}
-	} else {
-		// Authenticated user - show public orgs + user's private orgs
-		query := `
-			SELECT DISTINCT o.* FROM organizations o
-			LEFT JOIN organization_members om ON o.id = om.organization_id
-			WHERE o.is_public = true 
-			   OR (om.user_id = ? AND o.deleted_at IS NULL)
-			ORDER BY o.name
-		`
-		if err := s.db.Raw(query, userID).Scan(&organizations).Error; err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to get organizations")
-		}
-	}
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"organizations": organizations,
-		"total":         len(organizations),
-	})
-}
-
-func (s *Server) handleCreateOrganization(c echo.Context) error {
-	// Get user from token
-	userID, err := s.auth.GetUserIDFromToken(c)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
-	}
-
-	// Parse request body
-	var req struct {
-		Name        string `json:"name" validate:"required"`
-		DisplayName string `json:"display_name"`
-		Description string `json:"description"`
-		Website     string `json:"website"`
-		Location    string `json:"location"`
-		IsPublic    bool   `json:"is_public"`
-	}
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
-	}
-
-	// Validate name
-	if len(req.Name) < 3 || len(req.Name) > 39 {
-		return echo.NewHTTPError(http.StatusBadRequest, "Organization name must be 3-39 characters")
-	}
-
-	// Check if organization name exists
-	var existing models.Organization
-	if err := s.db.Where("name = ?", req.Name).First(&existing).Error; err == nil {
-		return echo.NewHTTPError(http.StatusConflict, "Organization name already exists")
-	}
-
-	// Create organization
-	org := models.Organization{
-		Name:        req.Name,
-		DisplayName: req.DisplayName,
-		Description: req.Description,
-		Website:     req.Website,
-		Location:    req.Location,
-		IsPublic:    req.IsPublic,
-	}
-
-	if err := s.db.Create(&org).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create organization")
-	}
-
-	// Add creator as owner
-	member := models.OrganizationMember{
-		OrganizationID: org.ID,
-		UserID:         userID,
-		Role:           "owner",
-	}
-
-	if err := s.db.Create(&member).Error; err != nil {
-		// If member creation fails, delete the organization
-		s.db.Delete(&org)
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to create organization membership")
-	}
-
-	return c.JSON(http.StatusCreated, org)
-}
-
-func (s *Server) handleGetOrganization(c echo.Context) error {
-	orgName := c.Param("name")
-	if orgName == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Organization name required")
-	}
-
-	// Find organization
-	var org models.Organization
-	if err := s.db.Where("name = ?", orgName).First(&org).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Organization not found")
-	}
-
-	// Check visibility
-	if !org.IsPublic {
-		// Check if user is a member
-		userID, err := s.auth.GetUserIDFromToken(c)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusForbidden, "Access denied")
-		}
-
-		var member models.OrganizationMember
-		if err := s.db.Where("organization_id = ? AND user_id = ?", org.ID, userID).First(&member).Error; err != nil {
-			return echo.NewHTTPError(http.StatusForbidden, "Access denied")
-		}
-	}
-
-	// Get member count
-	var memberCount int64
-	s.db.Model(&models.OrganizationMember{}).Where("organization_id = ?", org.ID).Count(&memberCount)
-
-	// Get gist count
-	var gistCount int64
-	s.db.Model(&models.Gist{}).Where("organization_id = ? AND deleted_at IS NULL", org.ID).Count(&gistCount)
-
-	return c.JSON(http.StatusOK, map[string]interface{}{
-		"organization": org,
-		"member_count": memberCount,
-		"gist_count":   gistCount,
-	})
-}
-
-func (s *Server) handleUpdateOrganization(c echo.Context) error {
-	orgName := c.Param("name")
-	if orgName == "" {
-		return echo.NewHTTPError(http.StatusBadRequest, "Organization name required")
-	}
-
-	// Get user from token
-	userID, err := s.auth.GetUserIDFromToken(c)
-	if err != nil {
-		return echo.NewHTTPError(http.StatusUnauthorized, "Authentication required")
-	}
-
-	// Find organization and check permissions
-	var org models.Organization
-	if err := s.db.Where("name = ?", orgName).First(&org).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "Organization not found")
-	}
-
-	// Check if user is admin/owner
-	var member models.OrganizationMember
-	if err := s.db.Where("organization_id = ? AND user_id = ? AND role IN (?, ?)",
-		org.ID, userID, "admin", "owner").First(&member).Error; err != nil {
-		return echo.NewHTTPError(http.StatusForbidden, "Admin access required")
-	}
-
-	// Parse request body
-	var req struct {
-		DisplayName string `json:"display_name"`
-		Description string `json:"description"`
-		Website     string `json:"website"`
-		Location    string `json:"location"`
-		IsPublic    *bool  `json:"is_public"`
-	}
-	if err := c.Bind(&req); err != nil {
-		return echo.NewHTTPError(http.StatusBadRequest, "Invalid request body")
-	}
-
-	// Update fields
-	updates := map[string]interface{}{}
-	if req.DisplayName != "" {
-		updates["display_name"] = req.DisplayName
-	}
-	if req.Description != "" {
-		updates["description"] = req.Description
-	}
-	if req.Website != "" {
-		updates["website"] = req.Website
-	}
-	if req.Location != "" {
-		updates["location"] = req.Location
-	}
-	if req.IsPublic != nil {
-		updates["is_public"] = *req.IsPublic
-	}
-
-	if err := s.db.Model(&org).Updates(updates).Error; err != nil {
-		return echo.NewHTTPError(http.StatusInternalServerError, "Failed to update organization")
-	}
-
-	return c.JSON(http.StatusOK, org)
-}
-
-func (s *Server) handleDeleteOrganization(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"message": "Delete organization not implemented"})
-}
-
-func (s *Server) handleGetOrgMembers(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"message": "Get org members not implemented"})
-}
-
-func (s *Server) handleAddOrgMember(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"message": "Add org member not implemented"})
-}
-
-func (s *Server) handleRemoveOrgMember(c echo.Context) error {
-	return c.JSON(http.StatusNotImplemented, map[string]string{"message": "Remove org member not implemented"})
-}
-
 func (s *Server) handleAdminDashboard(c echo.Context) error {
 	return c.JSON(http.StatusNotImplemented, map[string]string{"message": "Admin dashboard not implemented"})
 }
@@ -751,7 +636,7 @@ func (s *Server) handleAdminGetAuditLogs(c echo.Context) error {
 }
 
 func (s *Server) handleSetupWizard(c echo.Context) error {
-	handler := handlers.NewSetupHandler(s.db, s.config, s.auth)
+	handler := handlers.NewSetupHandler(s.db, s.config, s.auth, s.settings)
 
 	// Check if setup is already completed - GetStatus handles the response directly
 	// So we don't need to check status here, just continue to render template
@@ -831,12 +716,12 @@ func (s *Server) handleSetupWizard(c echo.Context) error {
 }
 
 func (s *Server) handleSetupStep(c echo.Context) error {
-	handler := handlers.NewSetupHandler(s.db, s.config, s.auth)
+	handler := handlers.NewSetupHandler(s.db, s.config, s.auth, s.settings)
 	return handler.ProcessStep(c)
 }
 
 func (s *Server) handleSetupStatus(c echo.Context) error {
-	handler := handlers.NewSetupHandler(s.db, s.config, s.auth)
+	handler := handlers.NewSetupHandler(s.db, s.config, s.auth, s.settings)
 	return handler.GetStatus(c)
 }
 
@@ -955,117 +840,257 @@ func (s *Server) handleTestWebhook(c echo.Context) error {
 }
 
 func (s *Server) handlePublicGist(c echo.Context) error {
-	gistID := c.Param("gistId")
+	gistID := c.Param(urls.ParamGist)
 	if gistID == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "Gist ID required")
 	}
 
 	// Find gist with files and user info
 	var gist models.Gist
-	if err := s.db.Preload("Files").Preload("User").Where("id = ? AND visibility = ? AND deleted_at IS NULL", gistID, "public").First(&gist).Error; err != nil {
+	if err := s.db.Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated).Preload("Files").Preload("User").Where("id = ? AND visibility = ? AND deleted_at IS NULL", gistID, "public").First(&gist).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Public gist not found")
 	}
+	if err := handlers.BurnRead(c, s.db, &gist); err != nil {
+		return err
+	}
+
+	// Don't expose the profile of a deactivated owner
+	if gist.User != nil && gist.User.IsDeactivated() {
+		gist.User = nil
+	}
 
-	// Increment view count
-	go func() {
-		s.db.Model(&gist).UpdateColumn("view_count", gist.ViewCount+1)
-	}()
+	// Count the view; it is written with the next batch
+	counters.CountView(s.db, gist.ID)
 
-	return c.JSON(http.StatusOK, gist)
+	// Only public gists are served here, so CDNs may keep them
+	httpcache.Revalidate(c, true)
+	return httpcache.JSON(c, gist, gist.UpdatedAt)
 }
 
 func (s *Server) handleRawFile(c echo.Context) error {
-	gistID := c.Param("gistId")
-	filename := c.Param("filename")
+	gistID := c.Param(urls.ParamGist)
+	filename := c.Param(urls.ParamFile)
+	revision := c.Param(urls.ParamRevision)
 	if gistID == "" || filename == "" {
 		return echo.NewHTTPError(http.StatusBadRequest, "Gist ID and filename required")
 	}
 
 	// Find gist and check visibility
 	var gist models.Gist
-	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+	if err := s.db.Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated).First(&gist, "id = ?", gistID).Error; err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Gist not found")
 	}
 
-	// Check access permissions
-	if gist.Visibility == "private" {
+	// Check access permissions; share links open private gists too, and
+	// are all that anonymous requests may use with public reads off
+	shares := services.NewGistShareService(s.db)
+	shared := false
+	if echoMiddleware.IsShareOnly(c) {
+		if !handlers.SharedRead(c, shares, &gist) {
+			return echo.NewHTTPError(http.StatusUnauthorized, "authentication required")
+		}
+		shared = true
+	}
+	if gist.Visibility == "private" && !shared {
 		userID, err := s.auth.GetUserIDFromToken(c)
-		if err != nil || gist.UserID == nil || *gist.UserID != userID {
+		if (err != nil || gist.UserID == nil || *gist.UserID != userID) &&
+			!handlers.SharedRead(c, shares, &gist) {
 			return echo.NewHTTPError(http.StatusForbidden, "Access denied")
 		}
 	}
 
-	// Find the specific file
-	var file models.GistFile
-	if err := s.db.Where("gist_id = ? AND filename = ?", gistID, filename).First(&file).Error; err != nil {
-		return echo.NewHTTPError(http.StatusNotFound, "File not found")
+	// Find the specific file, as it is or as of a commit
+	var content string
+	var modified time.Time // Unknown for a revision
+	immutable := false
+	if revision == "" {
+		var file models.GistFile
+		if err := s.db.Where("gist_id = ? AND filename = ?", gistID, filename).First(&file).Error; err != nil {
+			return echo.NewHTTPError(http.StatusNotFound, "File not found")
+		}
+		content = file.Content
+		modified = file.UpdatedAt
+	} else {
+		var commit string
+		var err error
+		content, commit, err = s.gistRepos.FileAt(&gist, revision, filename)
+		switch {
+		case errors.Is(err, git.ErrRevisionNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "Revision not found")
+		case errors.Is(err, git.ErrFileNotFound):
+			return echo.NewHTTPError(http.StatusNotFound, "File not found")
+		case err != nil:
+			return echo.NewHTTPError(http.StatusInternalServerError, "Failed to read revision")
+		}
+		// Only a full hash is sure to name the same commit forever; a
+		// prefix may become ambiguous as the gist changes
+		immutable = strings.EqualFold(revision, commit)
+	}
+	if lines := c.QueryParam("lines"); lines != "" {
+		sliced, err := sliceLines(content, lines)
+		switch {
+		case errors.Is(err, errLinesOutOfRange):
+			return echo.NewHTTPError(http.StatusRequestedRangeNotSatisfiable, err.Error())
+		case err != nil:
+			return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+		}
+		content = sliced
 	}
-
-	// Set content type based on file extension
-	contentType := "text/plain; charset=utf-8"
-	switch {
-	case strings.HasSuffix(filename, ".json"):
-		contentType = "application/json; charset=utf-8"
-	case strings.HasSuffix(filename, ".xml"):
-		contentType = "application/xml; charset=utf-8"
-	case strings.HasSuffix(filename, ".html"):
-		contentType = "text/html; charset=utf-8"
-	case strings.HasSuffix(filename, ".css"):
-		contentType = "text/css; charset=utf-8"
-	case strings.HasSuffix(filename, ".js"):
-		contentType = "application/javascript; charset=utf-8"
+	if err := handlers.BurnRead(c, s.db, &gist); err != nil {
+		return err
 	}
 
-	c.Response().Header().Set("Content-Type", contentType)
-	c.Response().Header().Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	header := c.Response().Header()
+	header.Set("Content-Disposition", fmt.Sprintf(`inline; filename="%s"`, filename))
+	header.Set("X-Content-Type-Options", "nosniff")
+	noIndex(c, &gist)
+	setRawCaching(c, &gist, immutable)
+	if httpcache.NotModified(c, httpcache.ETag([]byte(content)), modified) {
+		return c.NoContent(http.StatusNotModified)
+	}
 
-	return c.String(http.StatusOK, file.Content)
+	limits := streaming.RouteLimits(s.config, streaming.RouteRaw)
+	return streaming.Stream(c, limits, rawContentType(filename), int64(len(content)), strings.NewReader(content))
 }
 
 // setupAPIv1Routes configures API v1 routes
 func (s *Server) setupAPIv1Routes(g *echo.Group) {
 	// Create handlers
-	authHandler := handlers.NewAuthHandler(s.db, s.auth, s.config)
-	gistHandler := handlers.NewGistHandler(s.db, s.config, nil) // Git operations optional
+	authHandler := handlers.NewAuthHandler(s.db, s.auth, s.config, s.emailService)
+	gistHandler := handlers.NewGistHandler(s.db, s.config, s.gistRepos)
+	suggestionHandler := handlers.NewMetadataSuggestionHandler(s.config)
+	markdownHandler := handlers.NewMarkdownHandler(s.db, s.config)
 	userHandler := handlers.NewUserHandler(s.db, s.config)
 	orgHandler := handlers.NewOrganizationHandler(s.db, s.config)
 	teamHandler := handlers.NewTeamHandler(s.db, s.config)
-	adminHandler := handlers.NewAdminHandler(s.db, s.config)
-	setupHandler := handlers.NewSetupHandler(s.db, s.config, s.auth)
-	migrationHandler := handlers.NewMigrationHandler(s.db, s.config)
+	adminHandler := handlers.NewAdminHandler(s.db, s.config, s.searchManager)
+	setupHandler := handlers.NewSetupHandler(s.db, s.config, s.auth, s.settings)
+	migrationHandler := handlers.NewMigrationHandler(s.db, s.config, s.gistRepos)
 	webhookHandler := handlers.NewWebhookHandler(s.db, s.config, s.webhookManager)
-	backupHandler := handlers.NewBackupHandler(s.db, s.config)
+	backupHandler := handlers.NewBackupHandler(s.db, s.config, s.backups)
 	complianceHandler := handlers.NewComplianceHandler(s.db, s.config)
 	offlineHandler := handlers.NewOfflineHandler(s.db)
-
-	// Create middleware
-	authMiddleware := auth.NewMiddleware(s.auth)
+	telemetryHandler := handlers.NewTelemetryHandler(s.db, s.config, s.telemetry)
+	alertingHandler := handlers.NewAlertingHandler(s.db, s.config, s.alerting)
+	metaHandler := handlers.NewMetaHandler(s.db, s.config, s.deprecations)
+	deviceHandler := handlers.NewDeviceAuthHandler(s.db, s.auth, s.config)
+	oauthHandler := handlers.NewOAuthHandler(s.db, s.auth, s.config, s.oauthProviders)
+	webAuthnHandler := handlers.NewWebAuthnHandler(s.db, s.auth, s.config)
+	emailChangeHandler := handlers.NewEmailChangeHandler(s.db, s.config, s.emailService)
+	orgSettingsHandler := handlers.NewOrgSettingsHandler(s.db, s.config)
+	orgGistHandler := handlers.NewOrgGistHandler(s.db, s.config, s.gistRepos)
+	codeImageHandler := handlers.NewCodeImageHandler(s.db, s.config, s.cache)
+	searchHandler := handlers.NewSearchHandler(s.searchManager, s.config, s.db)
+	automationHandler := handlers.NewAutomationHandler(s.db, s.config)
+	newsletterHandler := handlers.NewNewsletterHandler(s.db, s.config, s.newsletters)
+	emailHandler := handlers.NewEmailHandler(s.db, s.config, s.emailService)
+	commentHandler := handlers.NewCommentHandler(s.db, s.config, s.emailService)
+	notificationHandler := handlers.NewNotificationHandler(s.db)
+	gistShareHandler := handlers.NewGistShareHandler(s.db, s.config)
+	attachmentHandler := handlers.NewAttachmentHandler(s.db, s.config, s.gistRepos, s.blobStore)
+	tagHandler := handlers.NewTagHandler(s.db, s.config, s.gistRepos)
+	collectionHandler := handlers.NewCollectionHandler(s.db, s.config, s.gistRepos)
+	feedHandler := handlers.NewFeedHandler(s.db, s.config, s.gistRepos)
+	moderationHandler := handlers.NewModerationHandler(s.db, s.config, s.gistRepos)
+	registrationHandler := handlers.NewRegistrationHandler(s.db, s.config)
+	accountRecoveryHandler := handlers.NewAccountRecoveryHandler(s.db, s.config, s.emailService)
+
+	// Create middleware; authenticated API requests are rate limited by the
+	// user's quota tier, and refused without a second factor while
+	// auth.require_2fa is on
+	authMiddleware := auth.NewMiddleware(s.auth).WithAuthenticated(s.tierRateLimit.Middleware(),
+		echoMiddleware.RequireTwoFactor(services.NewTwoFactorService(s.db, s.config)))
 
 	// Health endpoints
 	g.GET("/health", s.handleHealth)
 	g.GET("/healthz", s.handleHealthz)
+	g.GET("/livez", s.handleLivez)
+	g.GET("/readyz", s.handleReadyz)
+
+	// CSRF token for single page apps using the session cookie
+	g.GET("/csrf", s.handleCSRFToken)
 
 	// Auth endpoints
-	g.POST("/auth/login", authHandler.Login)
-	g.POST("/auth/register", authHandler.Register)
+	g.POST("/auth/login", authHandler.Login, s.rateLimit.Middleware(ratelimit.PolicyLogin))
+	g.POST("/auth/register", authHandler.Register, s.rateLimit.Middleware(ratelimit.PolicyRegister))
+	registrationHandler.RegisterRoutes(g)
+	accountRecoveryHandler.RegisterRoutes(g, s.rateLimit.Middleware(ratelimit.PolicyPasswordReset))
 	g.POST("/auth/refresh", authHandler.RefreshToken)
-	g.POST("/auth/logout", authHandler.Logout, authMiddleware.Auth())
+	g.POST("/auth/logout", authHandler.Logout, authMiddleware.OptionalAuth())
+	g.GET("/auth/introspect", authHandler.Introspect, authMiddleware.Auth())
+	g.GET("/auth/oauth/providers", oauthHandler.ListProviders)
+	webAuthnHandler.RegisterRoutes(g.Group("/auth"), authMiddleware.Auth(), authMiddleware.OptionalAuth())
+
+	// Device flow login for the CLI
+	g.POST("/auth/device/code", deviceHandler.RequestCode)
+	g.POST("/auth/device/token", deviceHandler.PollToken)
+	g.GET("/auth/device", deviceHandler.Lookup, authMiddleware.Auth())
+	g.POST("/auth/device/approve", deviceHandler.Approve, authMiddleware.Auth())
 
 	// Gist endpoints
-	g.GET("/gists", gistHandler.List, authMiddleware.OptionalAuth())
+	g.GET("/gists", gistHandler.List, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
 	g.POST("/gists", gistHandler.Create, authMiddleware.Auth())
-	g.GET("/gists/:id", gistHandler.Get, authMiddleware.OptionalAuth())
+	g.POST("/gists/suggest-metadata", suggestionHandler.Suggest, authMiddleware.Auth())
+	g.POST("/markdown", markdownHandler.Render, authMiddleware.OptionalAuth())
+	g.GET("/gists/:id", gistHandler.Get, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
 	g.PUT("/gists/:id", gistHandler.Update, authMiddleware.Auth())
+	g.PATCH("/gists/:id", gistHandler.Patch, authMiddleware.Auth())
 	g.DELETE("/gists/:id", gistHandler.Delete, authMiddleware.Auth())
+	g.POST("/gists/:id/restore", gistHandler.Restore, authMiddleware.Auth())
+	g.GET("/gists/:id/files/:file/image.png", codeImageHandler.Render, authMiddleware.OptionalAuth())
+	g.GET("/gists/:id/raw", gistHandler.Raw, s.rateLimit.Middleware(ratelimit.PolicyRaw), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	g.GET("/gists/:id/download", gistHandler.Download, s.rateLimit.Middleware(ratelimit.PolicyRaw), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	commentHandler.RegisterRoutes(g, authMiddleware.Auth(), authMiddleware.OptionalAuth())
+	gistShareHandler.RegisterRoutes(g, authMiddleware.Auth())
+	attachmentHandler.RegisterRoutes(g, authMiddleware.Auth(), s.rateLimit.Middleware(ratelimit.PolicyRaw), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+
+	// Tags and browsing gists by tag
+	tagHandler.RegisterRoutes(g, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	tagHandler.RegisterAdminRoutes(g, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Reports on gists and moderation
+	moderationHandler.RegisterRoutes(g, authMiddleware.Auth())
+	moderationHandler.RegisterAdminRoutes(g, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Invite codes and accounts awaiting approval
+	registrationHandler.RegisterAdminRoutes(g, authMiddleware.Auth(), authMiddleware.RequireAdmin())
 
 	// User endpoints
-	g.GET("/users/:username", userHandler.Get, authMiddleware.OptionalAuth())
-	g.GET("/users/:username/gists", userHandler.GetGists, authMiddleware.OptionalAuth())
+	g.GET("/users/:username", userHandler.Get, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	g.GET("/users/:username/gists", userHandler.GetGists, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
 	g.GET("/user", userHandler.GetCurrent, authMiddleware.Auth())
 	g.PUT("/user", userHandler.Update, authMiddleware.Auth())
+	g.POST("/user/deactivate", userHandler.Deactivate, authMiddleware.Auth())
+	g.GET("/user/trash", gistHandler.Trash, authMiddleware.Auth())
+
+	// Activity of followed users
+	g.GET("/feed", feedHandler.Feed, authMiddleware.Auth())
+
+	// Gist collections and pinned gists
+	collectionHandler.RegisterRoutes(g, authMiddleware.Auth(), authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+
+	// Notification center
+	notificationHandler.RegisterRoutes(g, authMiddleware.Auth(), authMiddleware.OptionalAuth())
+
+	// Email change endpoints (confirm/revert are reached from emailed links,
+	// which only show a page; the change is made by the POST it sends)
+	g.POST("/user/email", emailChangeHandler.Request, authMiddleware.Auth())
+	g.GET("/user/email", emailChangeHandler.GetPending, authMiddleware.Auth())
+	g.DELETE("/user/email", emailChangeHandler.Cancel, authMiddleware.Auth())
+	g.GET("/user/email/confirm", emailChangeHandler.ConfirmPage)
+	g.POST("/user/email/confirm", emailChangeHandler.Confirm)
+	g.GET("/user/email/revert", emailChangeHandler.RevertPage)
+	g.POST("/user/email/revert", emailChangeHandler.Revert)
 
 	// Organization endpoints
-	orgHandler.RegisterRoutes(g)
+	orgHandler.RegisterRoutes(g, authMiddleware.Auth(), authMiddleware.OptionalAuth())
+	g.GET("/orgs/:name/settings", orgSettingsHandler.GetSettings, authMiddleware.Auth())
+	g.PUT("/orgs/:name/settings", orgSettingsHandler.UpdateSettings, authMiddleware.Auth())
+	handlers.NewCustomDomainHandler(s.db, s.customDomains).RegisterRoutes(g, authMiddleware.Auth())
+	g.GET("/orgs/:name/gists", orgGistHandler.List, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	g.GET("/orgs/:name/gists/:slug", orgGistHandler.Get, authMiddleware.OptionalAuth(), s.publicRead.Middleware())
+	g.PATCH("/orgs/:name/gists/:slug", orgGistHandler.Rename, authMiddleware.Auth())
+	g.POST("/gists/:id/transfer", orgGistHandler.Transfer, authMiddleware.Auth())
 
 	// Team endpoints
 	teamHandler.RegisterRoutes(g)
@@ -1077,19 +1102,95 @@ func (s *Server) setupAPIv1Routes(g *echo.Group) {
 	setupHandler.RegisterRoutes(g)
 
 	// Migration endpoints (protected by auth)
-	migrationHandler.RegisterRoutes(g)
+	migrationHandler.RegisterRoutes(g, authMiddleware.Auth())
 
 	// Webhook endpoints (protected by auth)
-	webhookHandler.RegisterRoutes(g)
+	webhookHandler.RegisterRoutes(g, authMiddleware.Auth())
+
+	// Tag automation rules for the user or, with ?organization=, an organization
+	g.GET("/automation/actions", automationHandler.ListActions, authMiddleware.Auth())
+	g.GET("/automation/rules", automationHandler.List, authMiddleware.Auth())
+	g.POST("/automation/rules", automationHandler.Create, authMiddleware.Auth())
+	g.GET("/automation/rules/:id", automationHandler.Get, authMiddleware.Auth())
+	g.PUT("/automation/rules/:id", automationHandler.Update, authMiddleware.Auth())
+	g.DELETE("/automation/rules/:id", automationHandler.Delete, authMiddleware.Auth())
+	g.GET("/automation/rules/:id/runs", automationHandler.ListRuns, authMiddleware.Auth())
+
+	// Newsletter subscription
+	g.GET("/user/newsletter", newsletterHandler.GetSubscription, authMiddleware.Auth())
+	g.PUT("/user/newsletter", newsletterHandler.UpdateSubscription, authMiddleware.Auth())
+
+	// Email notification preferences
+	g.GET("/user/preferences", emailHandler.GetPreferences, authMiddleware.Auth())
+	g.PUT("/user/preferences", emailHandler.UpdatePreferences, authMiddleware.Auth())
 
 	// Backup endpoints (protected by admin middleware)
-	backupHandler.RegisterRoutes(g)
+	backupHandler.RegisterRoutes(g, authMiddleware.Auth(), authMiddleware.RequireAdmin())
 
 	// Compliance endpoints
 	complianceHandler.RegisterRoutes(g)
 
+	// API metadata endpoints
+	g.GET("/meta/versions", metaHandler.Versions)
+	g.GET("/meta/deprecations", metaHandler.ListDeprecations)
+	g.GET("/admin/deprecations/usage", metaHandler.DeprecationUsage, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Telemetry endpoints (admin only)
+	g.GET("/admin/telemetry", telemetryHandler.GetStatus, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.PUT("/admin/telemetry", telemetryHandler.UpdateStatus, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/telemetry/preview", telemetryHandler.Preview, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Alerting endpoints (admin only); thresholds are edited through the admin settings API
+	g.GET("/admin/alerts", alertingHandler.GetAlerts, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/alerts/evaluate", alertingHandler.Evaluate, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/alerts/metrics", alertingHandler.PrometheusMetrics, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/alerts/rules", alertingHandler.PrometheusRules, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Search index maintenance (admin only)
+	g.POST("/admin/search/reindex", searchHandler.Reindex, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/search/index", searchHandler.IndexHealth, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Cache status and flushing (admin only)
+	cacheHandler := handlers.NewCacheHandler(s.cache)
+	g.GET("/admin/cache", cacheHandler.Status, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/cache/flush", cacheHandler.Flush, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Email delivery check (admin only)
+	g.POST("/admin/email/test", emailHandler.SendTest, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Config file reload, as on SIGHUP (admin only)
+	configHandler := handlers.NewConfigHandler(s.reloader)
+	g.POST("/admin/config/reload", configHandler.Reload, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Runtime settings, saved in the database over the config file
+	adminSettingsHandler := handlers.NewAdminSettingsHandler(s.db, s.config, s.settings)
+	g.GET("/admin/settings", adminSettingsHandler.List, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.PUT("/admin/settings", adminSettingsHandler.Update, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Update check against the latest release (admin only)
+	updateHandler := handlers.NewUpdateHandler(update.NewClient(s.config), s.config.GetString("version"))
+	g.GET("/admin/update", updateHandler.Check, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
+	// Newsletters (admin only)
+	g.GET("/admin/newsletters", newsletterHandler.List, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/newsletters", newsletterHandler.Create, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/newsletters/:id", newsletterHandler.Get, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.PUT("/admin/newsletters/:id", newsletterHandler.Update, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.DELETE("/admin/newsletters/:id", newsletterHandler.Delete, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/newsletters/:id/test", newsletterHandler.SendTest, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/newsletters/:id/schedule", newsletterHandler.Schedule, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.POST("/admin/newsletters/:id/cancel", newsletterHandler.Cancel, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+	g.GET("/admin/newsletters/:id/deliveries", newsletterHandler.ListDeliveries, authMiddleware.Auth(), authMiddleware.RequireAdmin())
+
 	// Offline/PWA endpoints
 	offlineHandler.RegisterRoutes(s.echo.Group(""))
+
+	// NodeInfo instance metadata for directories and monitoring tools
+	s.echo.GET("/.well-known/nodeinfo", metaHandler.NodeInfoDiscovery)
+	s.echo.GET("/nodeinfo/:version", metaHandler.NodeInfo)
+
+	// Signed unsubscribe link from newsletter emails
+	s.echo.GET("/newsletter/unsubscribe", newsletterHandler.Unsubscribe)
 }
 
 // Health check handler
@@ -1116,35 +1217,39 @@ func (s *Server) handleHealth(c echo.Context) error {
 	return c.JSON(status, health)
 }
 
-// Enhanced health check handler
-func (s *Server) handleHealthz(c echo.Context) error {
-	// Calculate uptime
-	uptime := time.Since(s.startTime)
-	days := int(uptime.Hours() / 24)
-	hours := int(uptime.Hours()) % 24
-	minutes := int(uptime.Minutes()) % 60
-
-	uptimeStr := ""
-	if days > 0 {
-		uptimeStr = fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
-	} else if hours > 0 {
-		uptimeStr = fmt.Sprintf("%dh %dm", hours, minutes)
-	} else {
-		uptimeStr = fmt.Sprintf("%dm", minutes)
+// handleCSRFToken returns the token browser clients send back in the
+// X-CSRF-Token header; the CSRF middleware has already set its cookie
+func (s *Server) handleCSRFToken(c echo.Context) error {
+	c.Response().Header().Set("Cache-Control", "no-store")
+	if s.config.GetBool("security.disable_csrf") {
+		return c.JSON(http.StatusOK, map[string]interface{}{"enabled": false})
 	}
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"enabled":    true,
+		"csrf_token": echoMiddleware.GetCSRFToken(c),
+		"header":     echoMiddleware.CSRFHeaderName,
+	})
+}
 
+// Enhanced health check handler
+func (s *Server) handleHealthz(c echo.Context) error {
 	// Get version from config or default
 	version := s.config.GetString("version")
 	if version == "" {
 		version = "1.0.0"
 	}
 
+	// Requests served over the last minute
+	recent := s.traffic.Recent.Summary()
+
 	// Initialize health response
 	healthz := map[string]interface{}{
-		"status":    "healthy",
-		"version":   version,
-		"uptime":    uptimeStr,
-		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"status":         "healthy",
+		"version":        version,
+		"uptime":         s.getUptime(),
+		"uptime_seconds": int64(time.Since(s.startTime).Seconds()),
+		"started_at":     s.startTime.UTC().Format(time.RFC3339),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
 		"components": map[string]interface{}{
 			"database": "healthy",
 			"storage":  "healthy",
@@ -1156,11 +1261,14 @@ func (s *Server) handleHealthz(c echo.Context) error {
 			"total_users":           0,
 			"total_gists":           0,
 			"public_gists":          0,
-			"requests_per_minute":   0,
-			"average_response_time": "0ms",
+			"requests_per_minute":   recent.Count,
+			"average_response_time": formatLatency(recent.Average),
+			"p50_response_time":     formatLatency(recent.P50),
+			"p95_response_time":     formatLatency(recent.P95),
+			"requests_in_flight":    int64(s.traffic.InFlight.Value()),
+			"active_connections":    int64(s.traffic.Connections.Value()),
 			"storage_used":          "0B",
 			"storage_available":     "0B",
-			"active_connections":    0,
 		},
 		"features": map[string]interface{}{
 			"registration":    "enabled",
@@ -1180,9 +1288,9 @@ func (s *Server) handleHealthz(c echo.Context) error {
 		var gistCount int64
 		var publicGistCount int64
 
-		s.db.Table("users").Count(&userCount)
-		s.db.Table("gists").Count(&gistCount)
-		s.db.Table("gists").Where("visibility = ?", "public").Count(&publicGistCount)
+		s.db.Table("users").Scopes(models.NotDeleted("users")).Count(&userCount)
+		s.db.Table("gists").Scopes(models.NotDeleted("gists")).Count(&gistCount)
+		s.db.Table("gists").Scopes(models.NotDeleted("gists")).Where("visibility = ?", "public").Count(&publicGistCount)
 
 		healthz["metrics"].(map[string]interface{})["total_users"] = userCount
 		healthz["metrics"].(map[string]interface{})["total_gists"] = gistCount
@@ -1204,15 +1312,94 @@ func (s *Server) handleHealthz(c echo.Context) error {
 		}
 	}
 
+	// Check object storage with a write and read back; attachments,
+	// backups and exports fail without it
+	if s.objectStore == nil {
+		healthz["components"].(map[string]interface{})["storage"] = "unhealthy"
+		if healthz["status"] == "healthy" {
+			healthz["status"] = "degraded"
+		}
+	} else {
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 5*time.Second)
+		storageHealth := s.objectStore.Health(ctx)
+		cancel()
+		if !storageHealth.Healthy {
+			healthz["components"].(map[string]interface{})["storage"] = "unhealthy"
+			if healthz["status"] == "healthy" {
+				healthz["status"] = "degraded"
+			}
+		}
+		healthz["features"].(map[string]interface{})["storage"] = storageHealth.Driver
+		healthz["metrics"].(map[string]interface{})["storage_latency_ms"] = storageHealth.LatencyMs
+		healthz["storage"] = storageHealth
+	}
+
 	// Check search backend
 	if s.searchManager != nil {
-		searchBackend := "sqlite"
-		if s.config.GetBool("redis.enabled") {
-			searchBackend = "redis"
-			// For now, assume Redis is healthy if enabled
-			// TODO: Add proper Redis health check through cache manager
+		healthz["features"].(map[string]interface{})["search"] = s.searchManager.Backend()
+
+		// Compare the search index with the database
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+		index, err := s.searchManager.Health(ctx, s.config.GetDuration("search.index.max_lag"))
+		cancel()
+		if err != nil {
+			healthz["components"].(map[string]interface{})["search"] = "unhealthy"
+			if healthz["status"] == "healthy" {
+				healthz["status"] = "degraded"
+			}
+		} else {
+			healthz["components"].(map[string]interface{})["search"] = index.Status
+			healthz["metrics"].(map[string]interface{})["search_documents_indexed"] = index.IndexedDocuments
+			healthz["metrics"].(map[string]interface{})["search_index_lag_seconds"] = index.LagSeconds
+			healthz["search_index"] = index
+			if index.Status != search.IndexHealthy && healthz["status"] == "healthy" {
+				healthz["status"] = "degraded"
+			}
 		}
-		healthz["features"].(map[string]interface{})["search"] = searchBackend
+	}
+
+	// Check the cache backend; without a cache requests are only slower
+	if s.cache != nil && s.cache.Enabled() {
+		healthz["features"].(map[string]interface{})["cache"] = s.cache.Backend()
+		ctx, cancel := context.WithTimeout(c.Request().Context(), 2*time.Second)
+		err := s.cache.Ping(ctx)
+		cancel()
+		if err != nil {
+			healthz["components"].(map[string]interface{})["cache"] = "unhealthy"
+			if healthz["status"] == "healthy" {
+				healthz["status"] = "degraded"
+			}
+		} else {
+			healthz["components"].(map[string]interface{})["cache"] = "healthy"
+		}
+	}
+
+	// Check SQLite replication
+	if s.replicator != nil {
+		replication := s.replicator.Status()
+		if replication.Healthy {
+			healthz["components"].(map[string]interface{})["replication"] = "healthy"
+		} else {
+			healthz["components"].(map[string]interface{})["replication"] = "lagging"
+			if healthz["status"] == "healthy" {
+				healthz["status"] = "degraded"
+			}
+		}
+		healthz["metrics"].(map[string]interface{})["replication_lag_seconds"] = replication.LagSeconds
+		healthz["replication"] = replication
+	}
+
+	// Check git repository storage
+	if s.repoStorage != nil {
+		repoHealth := s.repoStorage.Health(c.Request().Context())
+		if repoHealth.Healthy {
+			healthz["components"].(map[string]interface{})["repo_storage"] = "healthy"
+		} else {
+			healthz["components"].(map[string]interface{})["repo_storage"] = "unhealthy"
+			healthz["status"] = "degraded"
+		}
+		healthz["metrics"].(map[string]interface{})["repo_storage_latency_ms"] = repoHealth.LatencyMs
+		healthz["repo_storage"] = repoHealth
 	}
 
 	// Check email service
@@ -1224,6 +1411,8 @@ func (s *Server) handleHealthz(c echo.Context) error {
 	healthz["features"].(map[string]interface{})["registration"] = s.boolToEnabled(s.config.GetBool("features.registration"))
 	healthz["features"].(map[string]interface{})["organizations"] = s.boolToEnabled(s.config.GetBool("features.organizations"))
 	healthz["features"].(map[string]interface{})["social_features"] = s.boolToEnabled(s.config.GetBool("features.social_features"))
+	readOnly, _ := models.GetReadOnlyMode(s.db)
+	healthz["features"].(map[string]interface{})["read_only"] = s.boolToEnabled(readOnly)
 
 	// Determine HTTP status
 	status := http.StatusOK
@@ -1238,25 +1427,37 @@ func (s *Server) handleHealthz(c echo.Context) error {
 
 // handleCLIScript generates a dynamic POSIX-compliant shell script for CLI operations
 func (s *Server) handleCLIScript(c echo.Context) error {
-	// Get server URL from request or config
+	script := s.cliScript(s.requestServerURL(c))
+
+	// Set appropriate headers for shell script
+	c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
+	c.Response().Header().Set("Content-Disposition", "inline; filename=\"casgists-cli.sh\"")
+
+	return c.String(http.StatusOK, script)
+}
+
+// requestServerURL returns the configured server URL, or one built from the
+// request when none is configured
+func (s *Server) requestServerURL(c echo.Context) string {
 	serverURL := s.config.GetString("server.url")
 	if serverURL == "" {
-		// Construct URL from request
-		scheme := "http"
-		if c.Request().TLS != nil {
-			scheme = "https"
-		}
-		serverURL = fmt.Sprintf("%s://%s", scheme, c.Request().Host)
+		// Construct URL from request; the scheme and host are those a
+		// trusted reverse proxy reports
+		serverURL = fmt.Sprintf("%s://%s", c.Scheme(), c.Request().Host)
 	}
+	return serverURL
+}
 
-	// Generate the POSIX-compliant shell script
-	script := fmt.Sprintf(`#!/bin/sh
+// cliScript generates the POSIX-compliant shell CLI for a server URL
+func (s *Server) cliScript(serverURL string) string {
+	return fmt.Sprintf(`#!/bin/sh
 # CasGists CLI - POSIX-compliant shell script
 # Generated dynamically by CasGists server
 # Version: %s
 
 CASGISTS_URL="${CASGISTS_URL:-%s}"
 CASGISTS_TOKEN="${CASGISTS_TOKEN:-}"
+CASGISTS_TOKEN_FILE="${CASGISTS_TOKEN_FILE:-${XDG_CONFIG_HOME:-$HOME/.config}/casgists/credentials}"
 
 # Colors for output (POSIX-compliant)
 if [ -t 1 ]; then
@@ -1296,56 +1497,162 @@ check_requirements() {
     fi
 }
 
-# Make API request
-api_request() {
-    method="$1"
-    endpoint="$2"
-    data="$3"
+# Extract a string field from a flat JSON response
+json_field() {
+    printf '%%s' "$1" | grep -o "\"$2\":\"[^\"]*" | head -n 1 | cut -d'"' -f4
+}
 
-    if [ -z "$CASGISTS_TOKEN" ]; then
-        error "CASGISTS_TOKEN environment variable not set"
-        printf "Please set your API token: export CASGISTS_TOKEN=your-token\n"
-        exit 1
-    fi
+# Host part of CASGISTS_URL, used for ~/.netrc lookups
+server_host() {
+    printf '%%s' "$CASGISTS_URL" | sed -e 's|^[a-z]*://||' -e 's|[:/].*$||'
+}
+
+# Read a token from ~/.netrc ("machine <host> login <user> password <token>")
+netrc_token() {
+    netrc="${NETRC:-$HOME/.netrc}"
+    [ -r "$netrc" ] || return 0
+    awk -v host="$(server_host)" '
+        { for (i = 1; i <= NF; i++) {
+            if ($i == "machine") { m = ($(i+1) == host) }
+            if (m && $i == "password") { print $(i+1); exit }
+        } }' "$netrc"
+}
+
+# Read a value from the credentials file
+credential() {
+    [ -r "$CASGISTS_TOKEN_FILE" ] || return 0
+    sed -n "s/^$1=//p" "$CASGISTS_TOKEN_FILE" | head -n 1
+}
 
-    if [ -n "$data" ]; then
-        curl -s -X "$method" \
+# Save credentials readable only by the current user
+save_credentials() {
+    mkdir -p "$(dirname "$CASGISTS_TOKEN_FILE")"
+    (umask 077 && printf 'access_token=%%s\nrefresh_token=%%s\n' "$1" "$2" > "$CASGISTS_TOKEN_FILE")
+}
+
+# Resolve the token: environment, then credentials file, then ~/.netrc
+load_token() {
+    [ -n "$CASGISTS_TOKEN" ] && return 0
+    CASGISTS_TOKEN=$(credential access_token)
+    [ -n "$CASGISTS_TOKEN" ] && return 0
+    CASGISTS_TOKEN=$(netrc_token)
+}
+
+# Exchange the stored refresh token for a new access token
+refresh_token() {
+    refresh=$(credential refresh_token)
+    [ -n "$refresh" ] || return 1
+    response=$(curl -s -X POST \
+        -H "Content-Type: application/json" \
+        -d "{\"refresh_token\":\"$refresh\"}" \
+        "$CASGISTS_URL/api/v1/auth/refresh")
+    token=$(json_field "$response" access_token)
+    [ -n "$token" ] || return 1
+    save_credentials "$token" "$(json_field "$response" refresh_token)"
+    CASGISTS_TOKEN="$token"
+}
+
+# Perform one API call, appending the HTTP status on the last line
+api_call() {
+    if [ -n "$3" ]; then
+        curl -s -w '\n%%{http_code}' -X "$1" \
             -H "Authorization: Bearer $CASGISTS_TOKEN" \
             -H "Content-Type: application/json" \
-            -d "$data" \
-            "$CASGISTS_URL/api/v1$endpoint"
+            -d "$3" \
+            "$CASGISTS_URL/api/v1$2"
     else
-        curl -s -X "$method" \
+        curl -s -w '\n%%{http_code}' -X "$1" \
             -H "Authorization: Bearer $CASGISTS_TOKEN" \
-            "$CASGISTS_URL/api/v1$endpoint"
+            "$CASGISTS_URL/api/v1$2"
     fi
 }
 
-# Login command
-cmd_login() {
-    printf "Username: "
-    read -r username
-    printf "Password: "
-    stty -echo
-    read -r password
-    stty echo
-    printf "\n"
+# Make API request, refreshing an expired access token once
+api_request() {
+    load_token
+    if [ -z "$CASGISTS_TOKEN" ]; then
+        error "Not logged in"
+        printf "Run '$0 login', set CASGISTS_TOKEN, or add a ~/.netrc entry for $(server_host)\n"
+        exit 1
+    fi
 
+    response=$(api_call "$@")
+    if [ "$(printf '%%s' "$response" | tail -n 1)" = "401" ] && refresh_token; then
+        response=$(api_call "$@")
+    fi
+    printf '%%s\n' "$response" | sed '$d'
+}
+
+# Login command (device flow: approve the login in the browser)
+cmd_login() {
+    client="casgists-cli@$(hostname 2>/dev/null || echo unknown)"
     response=$(curl -s -X POST \
         -H "Content-Type: application/json" \
-        -d "{\"username\":\"$username\",\"password\":\"$password\"}" \
-        "$CASGISTS_URL/api/v1/auth/login")
+        -d "{\"client_name\":\"$client\"}" \
+        "$CASGISTS_URL/api/v1/auth/device/code")
 
-    token=$(echo "$response" | grep -o '"token":"[^"]*' | cut -d'"' -f4)
+    device_code=$(json_field "$response" device_code)
+    user_code=$(json_field "$response" user_code)
+    verification_uri=$(json_field "$response" verification_uri)
+    interval=$(printf '%%s' "$response" | grep -o '"interval":[0-9]*' | cut -d: -f2)
+    interval="${interval:-5}"
 
-    if [ -n "$token" ]; then
-        success "Login successful!"
-        info "Add this to your shell configuration:"
-        printf "export CASGISTS_TOKEN=$token\n"
-    else
-        error "Login failed"
+    if [ -z "$device_code" ]; then
+        error "Failed to start login"
         exit 1
     fi
+
+    info "Open $verification_uri and enter the code: $user_code"
+    info "Waiting for approval..."
+
+    while :; do
+        sleep "$interval"
+        response=$(curl -s -X POST \
+            -H "Content-Type: application/json" \
+            -d "{\"device_code\":\"$device_code\"}" \
+            "$CASGISTS_URL/api/v1/auth/device/token")
+
+        token=$(json_field "$response" access_token)
+        if [ -n "$token" ]; then
+            save_credentials "$token" "$(json_field "$response" refresh_token)"
+            success "Logged in as $(json_field "$response" username)"
+            info "Credentials saved to $CASGISTS_TOKEN_FILE"
+            return 0
+        fi
+
+        case "$(json_field "$response" error)" in
+            authorization_pending)
+                ;;
+            slow_down)
+                interval=$((interval + 5))
+                ;;
+            access_denied)
+                error "Login was denied"
+                exit 1
+                ;;
+            expired_token)
+                error "Login code expired, run '$0 login' again"
+                exit 1
+                ;;
+            *)
+                error "Login failed"
+                exit 1
+                ;;
+        esac
+    done
+}
+
+# Logout command (revokes the session and removes stored credentials)
+cmd_logout() {
+    refresh=$(credential refresh_token)
+    if [ -n "$refresh" ]; then
+        curl -s -o /dev/null -X POST \
+            -H "Content-Type: application/json" \
+            -d "{\"refresh_token\":\"$refresh\"}" \
+            "$CASGISTS_URL/api/v1/auth/logout"
+    fi
+    rm -f "$CASGISTS_TOKEN_FILE"
+    success "Logged out"
 }
 
 # List gists
@@ -1398,7 +1705,7 @@ cmd_create() {
     if echo "$response" | grep -q '"id"'; then
         success "Gist created successfully!"
         id=$(echo "$response" | grep -o '"id":"[^"]*' | cut -d'"' -f4)
-        printf "View at: $CASGISTS_URL/gist/$id\n"
+        printf "View at: $CASGISTS_URL/gists/$id\n"
     else
         error "Failed to create gist"
         exit 1
@@ -1436,7 +1743,8 @@ CasGists CLI - Command-line interface for CasGists
 Usage: $0 <command> [arguments]
 
 Commands:
-    login              Login to CasGists
+    login              Login to CasGists (approve in your browser)
+    logout             Logout and remove stored credentials
     list               List your gists
     create <file>      Create a new gist from file
     search <query>     Search public gists
@@ -1444,7 +1752,10 @@ Commands:
 
 Environment Variables:
     CASGISTS_URL       Server URL (default: $CASGISTS_URL)
-    CASGISTS_TOKEN     API authentication token
+    CASGISTS_TOKEN     API authentication token (overrides stored credentials)
+    CASGISTS_TOKEN_FILE
+                       Credentials file (default: $CASGISTS_TOKEN_FILE)
+    NETRC              netrc file to read a token from (default: ~/.netrc)
 
 Examples:
     $0 login
@@ -1464,6 +1775,9 @@ main() {
         login)
             cmd_login
             ;;
+        logout)
+            cmd_logout
+            ;;
         list)
             cmd_list
             ;;
@@ -1489,12 +1803,6 @@ main() {
 # Run main function
 main "$@"
 `, s.config.GetString("version"), serverURL)
-
-	// Set appropriate headers for shell script
-	c.Response().Header().Set("Content-Type", "text/plain; charset=utf-8")
-	c.Response().Header().Set("Content-Disposition", "inline; filename=\"casgists-cli.sh\"")
-
-	return c.String(http.StatusOK, script)
 }
 
 // Handle 404 errors
@@ -1518,16 +1826,60 @@ func isAPIRequest(c echo.Context) bool {
 		strings.HasPrefix(path, "/api")
 }
 
-// getUptime returns the server uptime
+// getUptime returns how long the server has been running, as "3d 4h 5m"
 func (s *Server) getUptime() string {
-	// This would be implemented to track actual uptime
-	return "0h 0m"
+	uptime := time.Since(s.startTime)
+	days := int(uptime.Hours() / 24)
+	hours := int(uptime.Hours()) % 24
+	minutes := int(uptime.Minutes()) % 60
+
+	if days > 0 {
+		return fmt.Sprintf("%dd %dh %dm", days, hours, minutes)
+	}
+	if hours > 0 {
+		return fmt.Sprintf("%dh %dm", hours, minutes)
+	}
+	return fmt.Sprintf("%dm", minutes)
+}
+
+// formatLatency formats a response time in milliseconds, keeping a
+// decimal below 10ms where whole milliseconds say little
+func formatLatency(d time.Duration) string {
+	ms := float64(d) / float64(time.Millisecond)
+	if ms < 10 {
+		return fmt.Sprintf("%.1fms", ms)
+	}
+	return fmt.Sprintf("%.0fms", ms)
 }
 
 // Web page handlers
 func (s *Server) handleLoginPage(c echo.Context) error {
 	return c.Render(http.StatusOK, "login", map[string]interface{}{
-		"Title": "Login",
+		"Title":           "Login",
+		"Error":           oauth.ErrorMessage(c.QueryParam("oauth_error")),
+		"OAuthProviders":  s.oauthProviders.Enabled(),
+		"Redirect":        oauth.SafeRedirect(c.QueryParam("redirect")),
+		"WebAuthnEnabled": s.config.GetBool(passkey.ConfigKeyEnabled),
+	})
+}
+
+func (s *Server) handleDevicePage(c echo.Context) error {
+	return c.Render(http.StatusOK, "device", map[string]interface{}{
+		"Title":    "Authorize Device",
+		"UserCode": c.QueryParam("user_code"),
+	})
+}
+
+func (s *Server) handleOrgSettingsPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "org_settings", map[string]interface{}{
+		"Title":   "Organization Settings",
+		"OrgName": c.Param("name"),
+	})
+}
+
+func (s *Server) handleAdminSettingsPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "admin_runtime_settings", map[string]interface{}{
+		"Title": "Settings",
 	})
 }
 
@@ -1562,6 +1914,12 @@ func (s *Server) handleGistViewPage(c echo.Context) error {
 	// TODO: Get comments
 
 	gistID := c.Param("id")
+	if path := s.orgGistRedirect(gistID); path != "" {
+		return c.Redirect(http.StatusMovedPermanently, path)
+	}
+
+	var stored models.Gist
+	s.db.Select("indexing_disabled").First(&stored, "id = ?", gistID)
 
 	// Placeholder data
 	gist := map[string]interface{}{
@@ -1592,9 +1950,21 @@ func (s *Server) handleGistViewPage(c echo.Context) error {
 		"Comments":  []interface{}{},
 		"IsOwner":   false,
 		"IsStarred": false,
+		"NoIndex":   noIndex(c, &stored),
 	})
 }
 
+// noIndex asks search engines to skip a gist's pages and raw files when its
+// owner turned indexing off, and reports whether it did so templates can
+// add the matching robots meta tag
+func noIndex(c echo.Context, gist *models.Gist) bool {
+	if !gist.IndexingDisabled {
+		return false
+	}
+	c.Response().Header().Set("X-Robots-Tag", "noindex, nofollow")
+	return true
+}
+
 // DiskUsage represents disk usage statistics
 type DiskUsage struct {
 	Total     uint64
@@ -1604,15 +1974,15 @@ type DiskUsage struct {
 
 // getDiskUsage gets disk usage statistics for a path
 func (s *Server) getDiskUsage(path string) (*DiskUsage, error) {
-	var stat syscall.Statfs_t
-	if err := syscall.Statfs(path, &stat); err != nil {
+	stat, err := diskspace.Of(path)
+	if err != nil {
 		return nil, err
 	}
 
 	usage := &DiskUsage{
-		Total:     stat.Blocks * uint64(stat.Bsize),
-		Available: stat.Bavail * uint64(stat.Bsize),
-		Used:      (stat.Blocks - stat.Bfree) * uint64(stat.Bsize),
+		Total:     stat.Total,
+		Available: stat.Available,
+		Used:      stat.Used(),
 	}
 
 	return usage, nil
@@ -0,0 +1,38 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/casapps/casgists/src/internal/api/handlers"
+	"github.com/labstack/echo/v4"
+)
+
+// setupAPIv2Routes configures the API v2 preview routes.
+// v2 is a scaffold: endpoints are added here as they are redesigned, and
+// anything not yet ported returns 404 pointing callers back to v1.
+func (s *Server) setupAPIv2Routes(g *echo.Group) {
+	metaHandler := handlers.NewMetaHandler(s.db, s.config, s.deprecations)
+
+	g.GET("", s.handleAPIv2Index)
+	g.GET("/health", s.handleHealth)
+	g.GET("/meta/versions", metaHandler.Versions)
+	g.GET("/meta/deprecations", metaHandler.ListDeprecations)
+	g.RouteNotFound("/*", s.handleAPIv2NotFound)
+}
+
+// handleAPIv2Index describes the v2 preview
+func (s *Server) handleAPIv2Index(c echo.Context) error {
+	return c.JSON(http.StatusOK, map[string]interface{}{
+		"version": "v2",
+		"status":  "preview",
+		"message": "API v2 is in preview; use /api/v1 for endpoints not listed here",
+	})
+}
+
+// handleAPIv2NotFound points callers to v1 for endpoints not yet ported
+func (s *Server) handleAPIv2NotFound(c echo.Context) error {
+	return c.JSON(http.StatusNotFound, map[string]interface{}{
+		"error":   "endpoint not available in API v2 yet",
+		"v1_path": "/api/v1" + c.Request().URL.Path[len("/api/v2"):],
+	})
+}
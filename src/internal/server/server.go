@@ -5,38 +5,64 @@ import (
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
 	"net/http"
 	"os"
 	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/alerting"
 	"github.com/casapps/casgists/src/internal/api/v1"
 	echoMiddleware "github.com/casapps/casgists/src/internal/api/middleware"
 	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/auth/oauth"
+	"github.com/casapps/casgists/src/internal/automation"
+	"github.com/casapps/casgists/src/internal/backup"
+	"github.com/casapps/casgists/src/internal/blobs"
 	"github.com/casapps/casgists/src/internal/cache"
 	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/counters"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/digest"
+	"github.com/casapps/casgists/src/internal/domains"
 	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/expiry"
 	"github.com/casapps/casgists/src/internal/git"
+	"github.com/casapps/casgists/src/internal/imageproxy"
+	"github.com/casapps/casgists/src/internal/logging"
+	"github.com/casapps/casgists/src/internal/markup"
+	"github.com/casapps/casgists/src/internal/metrics"
+	"github.com/casapps/casgists/src/internal/newsletter"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/objectstore"
 	// "github.com/casapps/casgists/src/internal/handlers/public" // Temporarily disabled
 	// "github.com/casapps/casgists/src/internal/handlers/setup" // Temporarily disabled
 	"github.com/casapps/casgists/src/internal/performance"
+	"github.com/casapps/casgists/src/internal/ratelimit"
+	"github.com/casapps/casgists/src/internal/replication"
+	"github.com/casapps/casgists/src/internal/sandbox"
 	// "github.com/casapps/casgists/src/internal/repositories" // Temporarily disabled
 	"github.com/casapps/casgists/src/internal/search"
+	"github.com/casapps/casgists/src/internal/telemetry"
+	"github.com/casapps/casgists/src/internal/trash"
 	"github.com/casapps/casgists/src/internal/webhook"
-	// "github.com/casapps/casgists/src/internal/services" // Temporarily disabled
+	"github.com/casapps/casgists/src/internal/webhooks"
+	"github.com/casapps/casgists/src/internal/utils"
+	"github.com/casapps/casgists/src/internal/services"
 	// setupPkg "github.com/casapps/casgists/src/internal/setup" // Temporarily disabled
 )
 
 // Server represents the main application server
 type Server struct {
 	echo            *echo.Echo
-	config          *viper.Viper
+	config          *config.Config
 	db              *gorm.DB
 	cache           *cache.CacheManager
 	emailService    *email.Service
@@ -46,44 +72,127 @@ type Server struct {
 	healthService   *v1.HealthService
 	networkDetector *NetworkDetector
 	auth            *auth.AuthService
+	oauthProviders  *oauth.Manager
 	searchManager   *search.Manager
+	searchSyncer    *search.Syncer
 	webhookManager  *webhook.Manager
+	webhookService  *webhooks.Service
+	notifier        *notifications.Service
+	telemetry       *telemetry.Service
+	alerting        *alerting.Engine
+	automation      *automation.Engine
+	newsletters     *newsletter.Dispatcher
+	digests         *digest.Job
+	replicator      *replication.Replicator
+	sandboxPurger   *sandbox.Purger
+	expiryJanitor   *expiry.Janitor
+	trashPurger     *trash.Purger
+	views           *counters.Views
+	reconciler      *counters.Reconciler
+	customDomains   *domains.Service
+	backups         *backup.Scheduler
+	repoStorage     git.StorageDriver
+	gistRepos       *git.GistRepositories
+	blobStore       blobs.Store        // nil when attachment storage could not be opened
+	blobPruner      *blobs.Pruner      // nil without a blob store
+	objectStore     objectstore.Bucket // nil when storage.type could not be opened
+	deprecations    *echoMiddleware.DeprecationRegistry
+	publicRead      *echoMiddleware.PublicReadTier
+	tierRateLimit   *echoMiddleware.TierRateLimiter
+	rateLimit       *echoMiddleware.RateLimiter
+	markup          *markup.Renderer
+	imageProxy      *imageproxy.Proxy // nil when the image proxy is disabled
+	cliChecksums    sync.Map     // release file path -> cliChecksum
+	httpRedirect    *http.Server // plain HTTP listener next to HTTPS, nil without TLS
+	metrics         *metrics.Registry // instance gauges served next to metrics.Default
+	accessLog       *logging.RotatingFile // nil until access.log is opened
+	traffic         *metrics.HTTPMetrics  // requests and connections, counted by middleware and ConnState
+	readiness       *readiness            // consecutive failed checks behind /readyz
+	reloader        *config.Reloader      // re-reads the config file on SIGHUP or request
+	settings        *config.Settings      // database settings layered over the file and env
+	draining        atomic.Bool           // set by Shutdown, fails /readyz
+	workers         sync.WaitGroup        // background jobs started by Start
+	abort           context.Context       // done once shutdown runs past its deadline
+	abortWorkers    context.CancelFunc    // cancels the jobs still running at the deadline
 	startTime       time.Time
 }
 
 // New creates a new server instance (legacy - use NewWithPaths)
-func New(e *echo.Echo, cfg *viper.Viper, db *gorm.DB) *Server {
+func New(e *echo.Echo, cfg *config.Config, db *gorm.DB) *Server {
 	return NewWithPaths(e, cfg, db, nil)
 }
 
 // NewWithPaths creates a new server instance with path configuration
-func NewWithPaths(e *echo.Echo, cfg *viper.Viper, db *gorm.DB, pathConfig *config.PathConfig) *Server {
+func NewWithPaths(e *echo.Echo, cfg *config.Config, db *gorm.DB, pathConfig *config.PathConfig) *Server {
 	// Initialize system config
 	if err := models.InitializeSystemConfig(db); err != nil {
 		e.Logger.Warnf("Failed to initialize system config: %v", err)
 	}
 
+	// Settings saved in the database, by the setup wizard or an admin,
+	// override the config file and environment; load them before anything
+	// reads the configuration
+	configFile := cfg.ConfigFileUsed()
+	if configFile == "" && pathConfig != nil {
+		configFile = config.ConfigFilePath(pathConfig)
+	}
+	settings := config.NewSettings(cfg, models.NewConfigStore(db), configFile)
+	if err := settings.Load(); err != nil {
+		e.Logger.Warnf("Failed to load saved settings: %v", err)
+	}
+
 	// Initialize port manager
 	portManager := NewPortManager(db)
 
-	// Initialize cache manager
+	// Initialize cache manager; cached rows are invalidated when written
 	cacheManager := cache.NewCacheManager(cfg)
+	if cacheManager.Enabled() {
+		if err := cache.NewInvalidator(cacheManager).Register(db); err != nil {
+			e.Logger.Warnf("Failed to register cache invalidation: %v", err)
+		}
+	}
+
+	// Count and time database statements for /metrics
+	if err := db.Use(metrics.GormPlugin{}); err != nil {
+		e.Logger.Warnf("Failed to register database metrics: %v", err)
+	}
+
+	// Rate limit counters, shared through Redis when it is enabled
+	rateLimits := ratelimit.NewStore(cfg)
 	
 	// Initialize email service
 	emailService := email.NewService(db, cfg)
 	emailProcessor := email.NewProcessor(emailService, cfg)
 	
 	// Initialize search manager
-	searchManager, err := search.NewManager(db, "sqlite_fts", nil)
+	searchBackend, searchConfig := search.BackendFromViper(cfg)
+	searchManager, err := search.NewManager(db, searchBackend, searchConfig)
 	if err != nil {
-		log.Fatalf("Failed to initialize search manager: %v", err)
+		slog.Error("Failed to initialize search manager", "error", err)
+		os.Exit(1)
 	}
+	searchManager.SetGuard(search.NewQueryGuard(search.GuardConfigFromViper(cfg), cacheManager))
 	
-	// Initialize git service
-	gitService := git.NewService(cfg)
+	// Initialize git service on the configured storage driver
+	repoStorage, err := git.NewStorageDriver(cfg)
+	if err != nil {
+		slog.Error("Failed to initialize git storage", "error", err)
+		os.Exit(1)
+	}
+	gitService := git.NewServiceWithStorage(cfg, repoStorage)
+	
+	// Attachments are stored as blobs; without a store they are disabled
+	blobStore, err := blobs.NewStore(cfg)
+	if err != nil {
+		slog.Warn("Failed to initialize attachment storage, attachments are disabled", "error", err)
+	}
+	objectStore, err := objectstore.New(cfg)
+	if err != nil {
+		slog.Warn("Failed to initialize object storage", "error", err)
+	}
 	
 	// Initialize cache service
-	cacheService := cache.NewMemoryCacheService()
+	cacheService := cache.NewManagerService(cacheManager)
 	
 	// Initialize health service
 	healthService := v1.NewHealthService(db, cacheService, nil, gitService)
@@ -104,6 +213,36 @@ func NewWithPaths(e *echo.Echo, cfg *viper.Viper, db *gorm.DB, pathConfig *confi
 	}
 	webhookManager := webhook.NewManager(db, webhookWorkers)
 	
+	// Initialize telemetry service (opt-in, disabled by default)
+	telemetryService := telemetry.NewService(db, cfg)
+	
+	// Initialize alerting engine (emails administrators when health rules fire)
+	alertingEngine := alerting.NewEngine(db, cfg, emailService)
+	
+	// Initialize tag automation engine (runs rules queued on gist creation)
+	automationEngine := automation.NewEngine(db, cfg)
+	
+	// Initialize newsletter dispatcher (mails subscribers in batches)
+	newsletterDispatcher := newsletter.NewDispatcher(db, cfg)
+	
+	// Initialize SQLite WAL shipping (off unless replication is enabled)
+	var replicator *replication.Replicator
+	if cfg.GetBool("replication.enabled") {
+		replicator, err = replication.NewReplicator(db, cfg)
+		if err != nil {
+			slog.Error("Failed to initialize replication", "error", err)
+			os.Exit(1)
+		}
+	}
+	
+	// Initialize the view counter; registered on the database, it takes the
+	// views counters.CountView is handed and writes them in batches
+	views := counters.NewViews(db, cfg)
+	if err := db.Use(views); err != nil {
+		slog.Error("Failed to initialize view counting", "error", err)
+		os.Exit(1)
+	}
+	
 	// Initialize performance optimizer
 	optimizer := performance.NewOptimizer(db, cfg)
 	if err := optimizer.OptimizeDatabase(); err != nil {
@@ -123,14 +262,54 @@ func NewWithPaths(e *echo.Echo, cfg *viper.Viper, db *gorm.DB, pathConfig *confi
 		healthService:   healthService,
 		networkDetector: networkDetector,
 		auth:            authService,
+		oauthProviders:  oauth.NewManager(db, cfg),
 		searchManager:   searchManager,
+		searchSyncer:    search.NewSyncer(searchManager, cfg),
 		webhookManager:  webhookManager,
+		webhookService:  webhooks.NewService(db, cfg),
+		notifier:        notifications.NewService(db),
+		telemetry:       telemetryService,
+		alerting:        alertingEngine,
+		automation:      automationEngine,
+		newsletters:     newsletterDispatcher,
+		digests:         digest.NewJob(db, cfg, emailService),
+		replicator:      replicator,
+		sandboxPurger:   sandbox.NewPurger(db, cfg),
+		expiryJanitor:   expiry.NewJanitor(db, cfg),
+		trashPurger:     trash.NewPurger(db, cfg),
+		views:           views,
+		reconciler:      counters.NewReconciler(db, cfg),
+		customDomains:   domains.NewService(db, cfg),
+		repoStorage:     repoStorage,
+		gistRepos:       git.NewGistRepositories(gitService),
+		blobStore:       blobStore,
+		objectStore:     objectStore,
+		deprecations:    echoMiddleware.NewDeprecationRegistry(),
+		publicRead:      echoMiddleware.NewPublicReadTier(echoMiddleware.PublicReadConfigFromViper(cfg), cacheManager, rateLimits),
+		tierRateLimit:   echoMiddleware.NewTierRateLimiter(services.NewQuotaService(db, cfg), rateLimits),
+		rateLimit:       echoMiddleware.NewRateLimiter(cfg, rateLimits),
+		markup:          markup.NewRenderer(db, cfg),
+		imageProxy:      imageproxy.NewProxy(cfg),
+		traffic:         metrics.HTTP,
+		readiness:       newReadiness(),
 		startTime:       time.Now(),
+		settings:        settings,
+	}
+	s.abort, s.abortWorkers = context.WithCancel(context.Background())
+
+	// Reload the config file it was loaded from, or the one it would be
+	s.reloader = config.NewReloader(cfg, configFile)
+	s.reloader.OnReload(s.applyConfig)
+	s.settings.OnSave(s.applyConfig)
+	if blobStore != nil {
+		s.blobPruner = blobs.NewPruner(db, cfg, blobStore)
 	}
+	s.backups = backup.NewScheduler(db, cfg, backup.NewManager(db, cfg), s.webhookService, emailService)
 
 	// Setup validator
 	e.Validator = NewEchoValidator()
 	
+	s.metrics = s.newMetricsRegistry()
 	s.setupMiddleware()
 	s.setupRoutes()
 	
@@ -147,36 +326,235 @@ func NewWithPaths(e *echo.Echo, cfg *viper.Viper, db *gorm.DB, pathConfig *confi
 
 // Start starts the server and background services
 func (s *Server) Start(ctx context.Context, address string) error {
+	// Jobs get the caller's context, also cancelled when shutdown gives up
+	// waiting for them
+	ctx, cancel := context.WithCancel(ctx)
+	context.AfterFunc(s.abort, cancel)
+
 	// Start email processor in background
 	if s.config.GetBool("email.enabled") {
-		go s.emailProcessor.Start(ctx)
+		s.background(ctx, s.emailProcessor.Start)
 	}
 	
 	// Start webhook workers
 	if s.config.GetBool("webhook.enabled") {
-		go s.webhookManager.Start(ctx)
+		s.background(ctx, s.webhookManager.Start)
+	}
+	
+	// Start telemetry reporter (only sends while opted in)
+	s.background(ctx, s.telemetry.Start)
+	
+	// Start alert rule evaluation (skipped while alerting is disabled)
+	s.background(ctx, s.alerting.Start)
+	
+	// Start running queued tag automation rules
+	if s.config.GetBool("automation.enabled") {
+		s.background(ctx, s.automation.Start)
 	}
 	
-	return s.echo.Start(address)
+	// Start sending scheduled newsletters
+	if s.config.GetBool("email.enabled") && s.config.GetBool("newsletter.enabled") {
+		s.background(ctx, s.newsletters.Start)
+	}
+	
+	// Start sending weekly digests
+	if s.config.GetBool("email.enabled") && s.config.GetBool("email.digest.enabled") {
+		s.background(ctx, s.digests.Start)
+	}
+	
+	// Start keeping an index the database doesn't update in sync
+	if s.searchManager.Incremental() {
+		s.background(ctx, s.searchSyncer.Start)
+	}
+	
+	// Start purging expired API sandbox gists
+	if s.config.GetBool("api.sandbox.enabled") {
+		s.background(ctx, s.sandboxPurger.Start)
+	}
+	
+	// Start deleting expired and burned gists
+	s.background(ctx, s.expiryJanitor.Start)
+	
+	// Start purging gists that have been in the trash past their retention
+	s.background(ctx, s.trashPurger.Start)
+	
+	// Start writing gist views in batches
+	s.background(ctx, s.views.Start)
+	
+	// Start recomputing star and fork counts that drifted
+	if s.reconciler.Enabled() {
+		s.background(ctx, s.reconciler.Start)
+	}
+	
+	// Start deleting attachment blobs nothing refers to
+	if s.blobPruner != nil {
+		s.background(ctx, s.blobPruner.Start)
+	}
+	
+	// Start taking scheduled backups
+	if s.config.GetBool("backup.enabled") {
+		s.background(ctx, s.backups.Start)
+	}
+	
+	// Start shipping the SQLite WAL to the replica
+	if s.replicator != nil {
+		s.background(ctx, s.replicator.Start)
+	}
+	
+	// Reload the configuration when its file changes
+	if s.config.GetBool("config.watch") {
+		s.reloader.Watch()
+	}
+	
+	return s.listen(address)
+}
+
+// background runs a job's Start in a goroutine Shutdown waits for
+func (s *Server) background(ctx context.Context, start func(context.Context)) {
+	s.workers.Add(1)
+	go func() {
+		defer s.workers.Done()
+		start(ctx)
+	}()
 }
 
-// Shutdown gracefully shuts down the server
+// Shutdown stops the server in order. /readyz fails first, for
+// server.shutdown_delay, so load balancers stop sending requests. Then the
+// listeners close and requests in flight finish, the background jobs stop
+// taking work and finish what they started, and last the search index,
+// caches and access log are closed. Jobs still running when ctx ends are
+// cancelled.
 func (s *Server) Shutdown(ctx context.Context) error {
-	// Stop email processor
+	s.draining.Store(true)
+	if delay := s.config.GetDuration("server.shutdown_delay"); delay > 0 {
+		log.Printf("Failing readiness for %s before closing the listeners", delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	// Stop redirecting plain HTTP
+	if s.httpRedirect != nil {
+		s.httpRedirect.Shutdown(ctx)
+	}
+	
+	err := s.echo.Shutdown(ctx)
+
+	// Stop the background jobs from taking new work
 	if s.emailProcessor != nil {
 		s.emailProcessor.Stop()
 	}
-	
-	return s.echo.Shutdown(ctx)
+	if s.webhookManager != nil {
+		s.webhookManager.Stop()
+	}
+	if s.telemetry != nil {
+		s.telemetry.Stop()
+	}
+	if s.alerting != nil {
+		s.alerting.Stop()
+	}
+	if s.automation != nil {
+		s.automation.Stop()
+	}
+	if s.newsletters != nil {
+		s.newsletters.Stop()
+	}
+	if s.digests != nil {
+		s.digests.Stop()
+	}
+	if s.searchSyncer != nil {
+		s.searchSyncer.Stop()
+	}
+	if s.sandboxPurger != nil {
+		s.sandboxPurger.Stop()
+	}
+	if s.expiryJanitor != nil {
+		s.expiryJanitor.Stop()
+	}
+	if s.trashPurger != nil {
+		s.trashPurger.Stop()
+	}
+	if s.views != nil {
+		s.views.Stop()
+	}
+	if s.reconciler != nil {
+		s.reconciler.Stop()
+	}
+	if s.blobPruner != nil {
+		s.blobPruner.Stop()
+	}
+	if s.backups != nil {
+		s.backups.Stop()
+	}
+
+	// Send the events held for webhooks in digest mode rather than drop them
+	if s.webhookService != nil {
+		s.webhookService.FlushDigests(ctx)
+	}
+
+	// Wait for running jobs, such as a backup or a batch of emails
+	if drainErr := s.drainWorkers(ctx); drainErr != nil && err == nil {
+		err = drainErr
+	}
+
+	// Ship the last writes before the database goes away
+	if s.replicator != nil {
+		s.replicator.Stop()
+	}
+
+	if s.emailService != nil {
+		s.emailService.Close()
+	}
+	if s.searchManager != nil {
+		s.searchManager.Close()
+	}
+	if s.cache != nil {
+		s.cache.Close()
+	}
+
+	// Close access.log once the last requests are logged
+	if s.accessLog != nil {
+		s.accessLog.Close()
+	}
+	return err
+}
+
+// drainWorkers waits for the background jobs to return. At the deadline
+// it cancels the ones left and gives them a moment to notice.
+func (s *Server) drainWorkers(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		s.workers.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+	}
+
+	slog.Warn("Background jobs still running at the shutdown deadline, cancelling them")
+	s.abortWorkers()
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+	}
+	return fmt.Errorf("background jobs did not finish before the shutdown deadline: %w", ctx.Err())
 }
 
 func (s *Server) setupMiddleware() {
-	// Pretty console logging + Apache format file logging
-	s.echo.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
-		// Pretty console format
-		Format: "  ${time_rfc3339} | ${status} | ${latency_human} | ${method} ${uri}\n",
-		Output: s.getConsoleWriter(),
-	}))
+	// Believe X-Forwarded-* headers from trusted reverse proxies only
+	proxies := echoMiddleware.TrustedProxiesFromViper(s.config)
+	s.echo.IPExtractor = proxies.IPExtractor()
+	s.echo.Pre(proxies.Middleware())
+
+	// Route requests for users' and organizations' custom domains
+	s.echo.Pre(s.customDomains.Middleware())
+
+	// Structured request log, at the configured level and format
+	s.echo.Use(echoMiddleware.RequestLogger(slog.Default()))
 
 	// Apache format to access.log file only
 	s.echo.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
@@ -188,9 +566,13 @@ func (s *Server) setupMiddleware() {
 			return s.pathConfig == nil
 		},
 	}))
+	s.echo.Use(echoMiddleware.AlertingMiddleware(s.alerting))
 	s.echo.Use(middleware.Recover())
 	s.echo.Use(middleware.RequestID())
 
+	// Request counts and durations for /metrics and /healthz
+	s.echo.Use(echoMiddleware.Metrics(s.traffic))
+
 	// Performance middleware
 	s.echo.Use(performance.CompressionMiddleware(s.config))
 	s.echo.Use(performance.CacheControlMiddleware())
@@ -202,11 +584,20 @@ func (s *Server) setupMiddleware() {
 	// Security middleware
 	s.echo.Use(echoMiddleware.Security(s.config))
 	
+	// Canonical route parameters and gist IDs
+	s.echo.Use(echoMiddleware.NormalizeParams())
+
 	// CSRF middleware
 	s.echo.Use(echoMiddleware.CSRF(s.config))
 
-	// Rate limiting middleware
-	s.echo.Use(echoMiddleware.RateLimit(s.config))
+	// Deprecation headers and usage tracking for old API routes
+	s.echo.Use(s.deprecations.Middleware())
+
+	// Reject writes while the instance is read-only (e.g. storage migration)
+	s.echo.Use(echoMiddleware.ReadOnly(s.db))
+
+	// Keep API sandbox requests away from real data
+	s.echo.Use(echoMiddleware.Sandbox(s.db, s.config))
 
 	// Custom middleware
 	s.echo.Use(echoMiddleware.DatabaseInjector(s.db))
@@ -281,17 +672,32 @@ func (s *Server) setupTemplates() error {
 		return fmt.Errorf("failed to create template renderer: %w", err)
 	}
 	
+	renderer.SetLocationFunc(s.userLocation)
 	s.echo.Renderer = renderer
 	return nil
 }
 
-// registerDocumentationRoutes registers API documentation routes
+// userLocation returns the time zone pages are shown in: the signed in
+// user's preference, or ui.timezone
+func (s *Server) userLocation(c echo.Context) *time.Location {
+	timezone := s.config.GetString("ui.timezone")
+	if userID, ok := c.Get("user_id").(uuid.UUID); ok {
+		if preferred, err := models.UserTimezone(s.db, userID); err == nil && preferred != "" {
+			timezone = preferred
+		}
+	}
+	return utils.Timezone(timezone)
+}
+
+// registerDocumentationRoutes registers API documentation routes under
+// /api/docs, and the OpenAPI spec at /api/v1/openapi.json as well
 func (s *Server) registerDocumentationRoutes(api *echo.Group) {
 	// Create documentation handler
 	docsHandler := v1.NewDocsHandler()
 	
 	// Register documentation routes
 	docsHandler.RegisterRoutes(api)
+	api.GET("/v1/openapi.json", docsHandler.ServeOpenAPISpec)
 }
 
 // registerSetupAPIRoutes registers setup-related API routes
@@ -445,60 +851,38 @@ type HealthStatus struct {
 	Features   map[string]interface{} `json:"features,omitempty"`
 }
 
-// getConsoleWriter returns stdout for pretty console logging
-func (s *Server) getConsoleWriter() io.Writer {
-	return os.Stdout
-}
-
-// getAccessLogWriter returns file writer for Apache format access logs
+// getAccessLogWriter returns the rotating access.log for Apache format
+// access logs. Without a log directory, as in container mode, requests are
+// only in the structured log on stdout.
 func (s *Server) getAccessLogWriter() io.Writer {
-	logDir := s.getLogDir()
-	accessLogPath := filepath.Join(logDir, "access.log")
-
-	// Create log directory if it doesn't exist
-	if err := os.MkdirAll(logDir, 0755); err != nil {
-		log.Printf("Warning: Failed to create log directory %s: %v", logDir, err)
-		return io.Discard
-	}
-
-	// Open log file in append mode
-	logFile, err := os.OpenFile(accessLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		log.Printf("Warning: Failed to open access log %s: %v", accessLogPath, err)
+	cfg := logging.ConfigFromViper(s.config, s.getLogDir())
+	if cfg.Dir == "" {
 		return io.Discard
 	}
+	accessLogPath := filepath.Join(cfg.Dir, "access.log")
 
-	log.Printf("✓ Access logging: %s", accessLogPath)
-	return logFile
-}
-
-// getServerLogWriter returns file writer for server event logs
-func (s *Server) getServerLogWriter() io.Writer {
-	logDir := s.getLogDir()
-	serverLogPath := filepath.Join(logDir, "server.log")
-
-	// Create log directory if needed
-	if err := os.MkdirAll(logDir, 0755); err != nil {
+	// Create log directory if it doesn't exist
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		slog.Warn("Failed to create log directory, access logging is off", "dir", cfg.Dir, "error", err)
 		return io.Discard
 	}
 
-	// Open server log file
-	logFile, err := os.OpenFile(serverLogPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	accessLog, err := logging.OpenRotatingFile(accessLogPath, cfg.Rotation)
 	if err != nil {
+		slog.Warn("Failed to open access log, access logging is off", "path", accessLogPath, "error", err)
 		return io.Discard
 	}
 
-	log.Printf("✓ Server logging: %s", serverLogPath)
-	return logFile
+	log.Printf("✓ Access logging: %s", accessLogPath)
+	s.accessLog = accessLog
+	return accessLog
 }
 
-// getLogDir returns the log directory path
+// getLogDir returns the log directory path, empty when logs go to stdout
+// only
 func (s *Server) getLogDir() string {
 	if s.pathConfig != nil {
-		logDir := s.pathConfig.GetLogDir()
-		if logDir != "" {
-			return logDir
-		}
+		return s.pathConfig.GetLogDir()
 	}
 	// Default fallback
 	return "/var/log/casgists"
@@ -0,0 +1,28 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/services"
+)
+
+// handleTagsPage lists the popular tags at /tags
+func (s *Server) handleTagsPage(c echo.Context) error {
+	return c.Render(http.StatusOK, "tags", map[string]interface{}{
+		"Title": "Tags",
+	})
+}
+
+// handleTagPage lists the gists carrying a tag at /tags/:name
+func (s *Server) handleTagPage(c echo.Context) error {
+	tag, err := services.NormalizeTag(c.Param("name"))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "tag not found")
+	}
+	return c.Render(http.StatusOK, "tag_gists", map[string]interface{}{
+		"Title": "#" + tag,
+		"Tag":   tag,
+	})
+}
@@ -9,6 +9,7 @@ import (
 	"strings"
 	"time"
 
+	"github.com/casapps/casgists/src/internal/utils"
 	"github.com/labstack/echo/v4"
 )
 
@@ -16,6 +17,7 @@ import (
 type TemplateRenderer struct {
 	templates *template.Template
 	debug     bool
+	location  func(c echo.Context) *time.Location // Time zone pages are shown in; UTC when nil
 }
 
 // NewTemplateRenderer creates a new template renderer
@@ -25,6 +27,8 @@ func NewTemplateRenderer(templatesPath string, debug bool) (*TemplateRenderer, e
 		"timeago": timeAgo,
 		"timeAgo": timeAgo,  // Add camelCase version
 		"formatDate": formatDate,
+		"timestamp": timestamp,
+		"localtime": localtime,
 		"filesize": formatFileSize,
 		"default": defaultValue,
 		"truncate": truncate,
@@ -71,6 +75,12 @@ func NewTemplateRenderer(templatesPath string, debug bool) (*TemplateRenderer, e
 	}, nil
 }
 
+// SetLocationFunc sets how the time zone a page's times are shown in is
+// chosen for a request
+func (t *TemplateRenderer) SetLocationFunc(fn func(c echo.Context) *time.Location) {
+	t.location = fn
+}
+
 // Render renders a template with the provided data
 func (t *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c echo.Context) error {
 	// In debug mode, reload templates on each request
@@ -100,6 +110,14 @@ func (t *TemplateRenderer) Render(w io.Writer, name string, data interface{}, c
 		
 		// Add CSRF token
 		viewData["CSRFToken"] = c.Get("csrf_token")
+
+		// Times are shown in the user's time zone
+		location := time.UTC
+		if t.location != nil {
+			location = t.location(c)
+		}
+		viewData["Location"] = location
+		viewData["TimeZone"] = location.String()
 		
 		// Add flash messages
 		if flash := c.Get("flash"); flash != nil {
@@ -199,6 +217,8 @@ func getFuncMap() template.FuncMap {
 		"timeago": timeAgo,
 		"timeAgo": timeAgo,  // Add camelCase version
 		"formatDate": formatDate,
+		"timestamp": timestamp,
+		"localtime": localtime,
 		"filesize": formatFileSize,
 		"default": defaultValue,
 		"truncate": truncate,
@@ -273,6 +293,21 @@ func formatDate(t time.Time, format string) string {
 	return t.Format(format)
 }
 
+// timestamp renders t relative to now, with the full date in loc as the
+// tooltip and the UTC time in the datetime attribute for scripts
+func timestamp(t time.Time, loc *time.Location) template.HTML {
+	return template.HTML(fmt.Sprintf(`<time datetime="%s" title="%s">%s</time>`,
+		utils.FormatTimestamp(t), template.HTMLEscapeString(localtime(t, loc)), timeAgo(t)))
+}
+
+// localtime formats t in loc
+func localtime(t time.Time, loc *time.Location) string {
+	if loc == nil {
+		loc = time.UTC
+	}
+	return t.In(loc).Format("Jan 2, 2006 15:04 MST")
+}
+
 func formatFileSize(size int64) string {
 	const unit = 1024
 	if size < unit {
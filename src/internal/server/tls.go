@@ -0,0 +1,255 @@
+package server
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// tlsSettings are the HTTPS settings the server starts with
+type tlsSettings struct {
+	enabled      bool
+	certPath     string
+	keyPath      string
+	autoCert     bool
+	domains      []string
+	email        string
+	directoryURL string
+	cacheDir     string
+	httpPort     int // plain HTTP port for redirects and HTTP-01, 0 for none
+}
+
+// tlsSettings reads server.tls from the configuration. When the config file
+// doesn't enable TLS, the choices made in the setup wizard are used.
+func (s *Server) tlsSettings() tlsSettings {
+	settings := tlsSettings{
+		enabled:      s.config.GetBool("server.tls.enabled"),
+		certPath:     s.config.GetString("server.tls.cert_path"),
+		keyPath:      s.config.GetString("server.tls.key_path"),
+		autoCert:     s.config.GetBool("server.tls.auto_cert"),
+		domains:      s.config.GetStringSlice("server.tls.acme.domains"),
+		email:        s.config.GetString("server.tls.acme.email"),
+		directoryURL: s.config.GetString("server.tls.acme.directory_url"),
+		cacheDir:     s.config.GetString("server.tls.acme.cache_dir"),
+		httpPort:     s.config.GetInt("server.tls.http_port"),
+	}
+
+	if !settings.enabled {
+		wizard := s.systemConfig("server.tls.enabled", "server.tls.cert_path", "server.tls.key_path",
+			"server.tls.auto_cert", "server.tls.acme.email")
+		if wizard["server.tls.enabled"] == "true" {
+			settings.enabled = true
+			settings.certPath = wizard["server.tls.cert_path"]
+			settings.keyPath = wizard["server.tls.key_path"]
+			settings.autoCert = wizard["server.tls.auto_cert"] == "true"
+			if settings.email == "" {
+				settings.email = wizard["server.tls.acme.email"]
+			}
+		}
+	}
+
+	// Certificates are issued for the host of the public URL unless told
+	// otherwise
+	if len(settings.domains) == 0 {
+		if u, err := url.Parse(s.config.GetString("server.url")); err == nil && u.Hostname() != "" {
+			settings.domains = []string{u.Hostname()}
+		}
+	}
+	if settings.cacheDir == "" {
+		settings.cacheDir = filepath.Join(s.sslDir(), "acme")
+	}
+	return settings
+}
+
+// systemConfig returns the values stored in the system_configs table for keys
+func (s *Server) systemConfig(keys ...string) map[string]string {
+	values := make(map[string]string, len(keys))
+	var rows []models.SystemConfig
+	if err := s.db.Where("key IN ?", keys).Find(&rows).Error; err != nil {
+		return values
+	}
+	for _, row := range rows {
+		values[row.Key] = row.Value
+	}
+	return values
+}
+
+// sslDir returns the directory certificates are kept in
+func (s *Server) sslDir() string {
+	if s.pathConfig != nil {
+		if dir := s.pathConfig.GetSSLDir(); dir != "" {
+			return dir
+		}
+	}
+	return filepath.Join(s.config.GetString("paths.data"), "ssl")
+}
+
+// tlsConfig builds the TLS configuration for settings. With auto_cert the
+// certificates come from an ACME CA such as Let's Encrypt, answering
+// TLS-ALPN-01 challenges on the HTTPS port; the returned handler answers
+// HTTP-01 challenges on the plain HTTP port and redirects everything else.
+// Verified custom domains with SSL enabled get their certificates from the
+// CA either way.
+func (s *Server) tlsConfig(settings tlsSettings, httpsPort int) (*tls.Config, http.Handler, error) {
+	if settings.autoCert && len(settings.domains) == 0 {
+		return nil, nil, errors.New("server.tls.auto_cert needs server.tls.acme.domains or an https server.url")
+	}
+	if err := os.MkdirAll(settings.cacheDir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("failed to create certificate cache: %w", err)
+	}
+
+	manager := &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		Cache:      autocert.DirCache(settings.cacheDir),
+		HostPolicy: s.hostPolicy(settings),
+		Email:      settings.email,
+	}
+	if settings.directoryURL != "" {
+		manager.Client = &acme.Client{DirectoryURL: settings.directoryURL}
+	}
+	getCertificate := func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		cert, err := manager.GetCertificate(hello)
+		if err == nil && cert.Leaf != nil && !slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			s.customDomains.CertificateIssued(hello.ServerName, cert.Leaf.NotAfter)
+		}
+		return cert, err
+	}
+	httpHandler := manager.HTTPHandler(s.plainHTTP(httpsPort))
+
+	if settings.autoCert {
+		config := manager.TLSConfig()
+		config.GetCertificate = getCertificate
+		config.MinVersion = tls.VersionTLS12
+		return config, httpHandler, nil
+	}
+
+	if settings.certPath == "" || settings.keyPath == "" {
+		return nil, nil, errors.New("server.tls.cert_path and server.tls.key_path are required unless server.tls.auto_cert is on")
+	}
+	cert, err := tls.LoadX509KeyPair(settings.certPath, settings.keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		// Custom domains get ACME certificates; returning none serves the
+		// configured one
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			if s.customDomains.HostPolicy(hello.Context(), hello.ServerName) != nil {
+				return nil, nil
+			}
+			return getCertificate(hello)
+		},
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1", acme.ALPNProto},
+	}, httpHandler, nil
+}
+
+// hostPolicy allows ACME certificates for the configured domains when
+// auto_cert is on, and for custom domains with SSL enabled
+func (s *Server) hostPolicy(settings tlsSettings) autocert.HostPolicy {
+	own := autocert.HostWhitelist(settings.domains...)
+	return func(ctx context.Context, host string) error {
+		if settings.autoCert && own(ctx, host) == nil {
+			return nil
+		}
+		return s.customDomains.HostPolicy(ctx, host)
+	}
+}
+
+// plainHTTP handles requests on the plain HTTP port: custom domains without
+// SSL are served as they are, everything else is redirected to HTTPS
+func (s *Server) plainHTTP(httpsPort int) http.Handler {
+	redirect := redirectToHTTPS(httpsPort)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if domain, err := s.customDomains.GetDomainByName(r.Host); err == nil && !domain.SSLEnabled {
+			s.echo.ServeHTTP(w, r)
+			return
+		}
+		redirect.ServeHTTP(w, r)
+	})
+}
+
+// listen serves the API on address, over HTTPS when server.tls is enabled
+// with a plain HTTP listener next to it that redirects to HTTPS
+func (s *Server) listen(address string) error {
+	// Count open connections for /metrics and /healthz
+	s.echo.Server.ConnState = s.traffic.ConnState
+	s.echo.TLSServer.ConnState = s.traffic.ConnState
+
+	settings := s.tlsSettings()
+	if !settings.enabled {
+		return s.echo.Start(address)
+	}
+
+	httpsPort := 443
+	if _, port, err := net.SplitHostPort(address); err == nil {
+		if n, err := strconv.Atoi(port); err == nil && n > 0 {
+			httpsPort = n
+		}
+	}
+	tlsConfig, httpHandler, err := s.tlsConfig(settings, httpsPort)
+	if err != nil {
+		return err
+	}
+
+	if settings.httpPort > 0 && settings.httpPort != httpsPort {
+		s.httpRedirect = &http.Server{
+			Addr:              fmt.Sprintf(":%d", settings.httpPort),
+			Handler:           httpHandler,
+			ReadHeaderTimeout: 10 * time.Second,
+		}
+		go func(server *http.Server) {
+			if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				slog.Warn("HTTP to HTTPS redirect stopped", "addr", server.Addr, "error", err)
+			}
+		}(s.httpRedirect)
+	}
+
+	if settings.autoCert {
+		log.Printf("✓ HTTPS with ACME certificates for %s", strings.Join(settings.domains, ", "))
+	} else {
+		log.Printf("✓ HTTPS with certificate %s", settings.certPath)
+	}
+	s.echo.TLSServer.Addr = address
+	s.echo.TLSServer.TLSConfig = tlsConfig
+	return s.echo.StartServer(s.echo.TLSServer)
+}
+
+// redirectToHTTPS sends plain HTTP requests to the same URL over HTTPS.
+// Other methods than GET and HEAD keep their method and body.
+func redirectToHTTPS(httpsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if h, _, err := net.SplitHostPort(host); err == nil {
+			host = h
+		} else {
+			host = strings.Trim(host, "[]")
+		}
+		host = strings.TrimSuffix(net.JoinHostPort(host, strconv.Itoa(httpsPort)), ":443")
+		target := "https://" + host + r.URL.RequestURI()
+
+		status := http.StatusPermanentRedirect
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			status = http.StatusMovedPermanently
+		}
+		http.Redirect(w, r, target, status)
+	})
+}
@@ -0,0 +1,27 @@
+package server
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// handleUserProfilePage shows a user's profile at /u/:username with their
+// pinned gists and public collections
+func (s *Server) handleUserProfilePage(c echo.Context) error {
+	var user models.User
+	if err := s.db.Where("username = ? AND deactivated_at IS NULL", c.Param("username")).First(&user).Error; err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "user not found")
+	}
+	title := user.DisplayName
+	if title == "" {
+		title = user.Username
+	}
+	return c.Render(http.StatusOK, "user_profile", map[string]interface{}{
+		"Title":   title,
+		"Profile": user.Username,
+		"Bio":     user.Bio,
+	})
+}
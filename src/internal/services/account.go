@@ -0,0 +1,120 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+var (
+	ErrAccountInvalidPassword    = errors.New("invalid password")
+	ErrAccountAlreadyDeactivated = errors.New("account is already deactivated")
+	ErrAccountLastAdmin          = errors.New("the last active administrator cannot deactivate their account")
+	ErrAccountGracePeriodExpired = errors.New("account is deactivated")
+)
+
+// AccountService handles self-service account lifecycle operations.
+//
+// Deactivation is reversible: the account is hidden and signed out, and the
+// owner can reactivate it by logging in within the grace period. This is
+// separate from GDPR deletion, which removes the account permanently.
+type AccountService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewAccountService creates a new account service
+func NewAccountService(db *gorm.DB, cfg *config.Config) *AccountService {
+	return &AccountService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// Deactivate deactivates the user's account after re-checking their password.
+// It returns the time until which the account can be reactivated by logging in.
+func (s *AccountService) Deactivate(userID uuid.UUID, password string, keepGistsPublic bool) (time.Time, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return time.Time{}, errors.New("user not found")
+	}
+
+	if !auth.CheckPasswordHash(password, user.PasswordHash) {
+		return time.Time{}, ErrAccountInvalidPassword
+	}
+	if user.IsDeactivated() {
+		return time.Time{}, ErrAccountAlreadyDeactivated
+	}
+
+	if user.IsAdmin {
+		var admins int64
+		s.db.Model(&models.User{}).Where("is_admin = ? AND is_active = ? AND id != ?", true, true, userID).Count(&admins)
+		if admins == 0 {
+			return time.Time{}, ErrAccountLastAdmin
+		}
+	}
+
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"is_active":         false,
+			"deactivated_at":    now,
+			"keep_gists_public": keepGistsPublic,
+		}).Error; err != nil {
+			return err
+		}
+
+		// Sign out everywhere
+		return tx.Where("user_id = ?", userID).Delete(&models.Session{}).Error
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to deactivate account: %w", err)
+	}
+
+	return now.Add(s.GracePeriod()), nil
+}
+
+// CanReactivate reports whether a deactivated account is still within the
+// grace period in which logging in reactivates it
+func (s *AccountService) CanReactivate(user *models.User) bool {
+	if !user.IsDeactivated() {
+		return false
+	}
+	return time.Since(*user.DeactivatedAt) <= s.GracePeriod()
+}
+
+// Reactivate restores a deactivated account
+func (s *AccountService) Reactivate(user *models.User) error {
+	if !s.CanReactivate(user) {
+		return ErrAccountGracePeriodExpired
+	}
+
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"is_active":         true,
+		"deactivated_at":    nil,
+		"keep_gists_public": false,
+	}).Error; err != nil {
+		return fmt.Errorf("failed to reactivate account: %w", err)
+	}
+
+	user.IsActive = true
+	user.DeactivatedAt = nil
+	user.KeepGistsPublic = false
+	return nil
+}
+
+// GracePeriod returns how long a deactivated account can be reactivated by
+// logging in (default: 30 days)
+func (s *AccountService) GracePeriod() time.Duration {
+	if period := s.cfg.GetDuration("security.deactivation.grace_period"); period > 0 {
+		return period
+	}
+	return 30 * 24 * time.Hour
+}
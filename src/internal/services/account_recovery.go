@@ -0,0 +1,408 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+)
+
+var (
+	ErrRecoveryDisabled     = errors.New("password reset is disabled")
+	ErrRecoveryInvalidToken = errors.New("invalid or expired link")
+	ErrRecoveryLocked       = errors.New("too many invalid links, try again later")
+	ErrRecoveryPassword     = errors.New("password is too short")
+	ErrRecoveryUnavailable  = errors.New("account can't be recovered")
+	ErrEmailAlreadyVerified = errors.New("email address is already verified")
+)
+
+// AccountRecoveryService issues and redeems the links emailed to verify an
+// address and to reset a forgotten password.
+//
+// Links are single use and short lived, and only the newest one of each
+// kind works. Each account gets at most a few links per link lifetime, so
+// the forms can't be used to flood an inbox. Clients that present too many
+// invalid links are locked out for a while, which stops token guessing
+// even across many reset forms.
+type AccountRecoveryService struct {
+	db           *gorm.DB
+	config       *config.Config
+	emailService *email.Service
+	lockout      *tokenLockout
+}
+
+// NewAccountRecoveryService creates a new account recovery service.
+// emailService may be nil, in which case links are issued but not sent.
+func NewAccountRecoveryService(db *gorm.DB, config *config.Config, emailService *email.Service) *AccountRecoveryService {
+	return &AccountRecoveryService{
+		db:           db,
+		config:       config,
+		emailService: emailService,
+		lockout:      &tokenLockout{failures: make(map[string]*lockoutWindow)},
+	}
+}
+
+// RequestPasswordReset emails a reset link to the account with the address.
+// It returns nil whether or not there is such an account, so the form can't
+// be used to find out who has one. The token is returned, empty when no
+// link was sent, but is only for the owner of the address to see.
+func (s *AccountRecoveryService) RequestPasswordReset(address, ip string) (string, error) {
+	if !s.config.GetBool("features.password_reset") {
+		return "", ErrRecoveryDisabled
+	}
+	user, ok := s.recoverableUser(address)
+	if !ok || s.tooManyLinks(&models.PasswordResetToken{}, user.ID, s.resetTTL()) {
+		return "", nil
+	}
+
+	token, err := newRecoveryToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	reset := &models.PasswordResetToken{
+		UserID:    user.ID,
+		TokenHash: auth.HashToken(token),
+		RequestIP: ip,
+		ExpiresAt: now.Add(s.resetTTL()),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only the newest link works
+		if err := expireTokens(tx, &models.PasswordResetToken{}, user.ID, now); err != nil {
+			return err
+		}
+		return tx.Create(reset).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save reset link: %w", err)
+	}
+
+	if s.emailService != nil {
+		resetURL := fmt.Sprintf("%s/api/v1/auth/reset/%s", s.baseURL(), token)
+		if err := s.emailService.SendPasswordResetEmail(user.Email, user.Username, resetURL, reset.ExpiresAt); err != nil {
+			slog.Error("Failed to send password reset email", "user", user.ID, "error", err)
+		}
+	}
+	return token, nil
+}
+
+// CheckResetToken reports whether a reset link still works, so a form can
+// be shown before the new password is asked for
+func (s *AccountRecoveryService) CheckResetToken(token, ip string) (*models.PasswordResetToken, error) {
+	if s.lockout.locked(ip, time.Now(), s.lockoutAttempts()) {
+		return nil, ErrRecoveryLocked
+	}
+	reset, err := s.findResetToken(s.db, token)
+	if err != nil {
+		s.fail(ip)
+		return nil, err
+	}
+	return reset, nil
+}
+
+// ResetPassword sets a new password from a reset link and uses the link
+// up. Every session is signed out, and the address is marked verified
+// since the link was read from it.
+func (s *AccountRecoveryService) ResetPassword(token, password, ip string) (*models.User, error) {
+	if s.lockout.locked(ip, time.Now(), s.lockoutAttempts()) {
+		return nil, ErrRecoveryLocked
+	}
+	if length := s.passwordMinLength(); len(password) < length {
+		return nil, fmt.Errorf("%w: use at least %d characters", ErrRecoveryPassword, length)
+	}
+	hash, err := auth.HashPassword(password)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash password: %w", err)
+	}
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		reset, err := s.findResetToken(tx, token)
+		if err != nil {
+			return err
+		}
+		if err := useToken(tx, reset, reset.ID); err != nil {
+			return err
+		}
+		if err := tx.First(&user, "id = ?", reset.UserID).Error; err != nil {
+			return ErrRecoveryInvalidToken
+		}
+		if user.IsSuspended || user.PendingApproval {
+			return ErrRecoveryUnavailable
+		}
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"password_hash":     hash,
+			"email_verified":    true,
+			"is_email_verified": true,
+		}).Error; err != nil {
+			return err
+		}
+		return tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrRecoveryInvalidToken) {
+			s.fail(ip)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// SendVerification emails the user a link confirming their address
+func (s *AccountRecoveryService) SendVerification(user *models.User) (string, error) {
+	if user.EmailVerified || user.IsEmailVerified {
+		return "", ErrEmailAlreadyVerified
+	}
+	if s.tooManyLinks(&models.EmailVerificationToken{}, user.ID, s.verificationTTL()) {
+		return "", nil
+	}
+
+	token, err := newRecoveryToken()
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	verification := &models.EmailVerificationToken{
+		UserID:    user.ID,
+		Email:     user.Email,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: now.Add(s.verificationTTL()),
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := expireTokens(tx, &models.EmailVerificationToken{}, user.ID, now); err != nil {
+			return err
+		}
+		return tx.Create(verification).Error
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to save verification link: %w", err)
+	}
+
+	if s.emailService != nil {
+		verifyURL := fmt.Sprintf("%s/api/v1/auth/verify/%s", s.baseURL(), token)
+		if err := s.emailService.SendVerificationEmail(user.Email, user.Username, verifyURL, verification.ExpiresAt); err != nil {
+			slog.Error("Failed to send verification email", "user", user.ID, "error", err)
+		}
+	}
+	return token, nil
+}
+
+// ResendVerification sends a new verification link to the account with the
+// address. Like RequestPasswordReset it doesn't tell whether there is one.
+func (s *AccountRecoveryService) ResendVerification(address string) error {
+	user, ok := s.recoverableUser(address)
+	if !ok {
+		return nil
+	}
+	if _, err := s.SendVerification(user); err != nil && !errors.Is(err, ErrEmailAlreadyVerified) {
+		return err
+	}
+	return nil
+}
+
+// VerifyEmail marks the address a verification link was sent to as
+// verified. The link stops working if the user has changed address since.
+func (s *AccountRecoveryService) VerifyEmail(token, ip string) (*models.User, error) {
+	if s.lockout.locked(ip, time.Now(), s.lockoutAttempts()) {
+		return nil, ErrRecoveryLocked
+	}
+
+	var user models.User
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		var verification models.EmailVerificationToken
+		if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?",
+			auth.HashToken(token), time.Now()).First(&verification).Error; err != nil {
+			return ErrRecoveryInvalidToken
+		}
+		if err := tx.First(&user, "id = ?", verification.UserID).Error; err != nil ||
+			!strings.EqualFold(user.Email, verification.Email) {
+			return ErrRecoveryInvalidToken
+		}
+		if err := useToken(tx, &verification, verification.ID); err != nil {
+			return err
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email_verified":    true,
+			"is_email_verified": true,
+		}).Error
+	})
+	if err != nil {
+		if errors.Is(err, ErrRecoveryInvalidToken) {
+			s.fail(ip)
+		}
+		return nil, err
+	}
+	return &user, nil
+}
+
+// RequireVerification reports whether users must verify their address
+// before they can sign in
+func (s *AccountRecoveryService) RequireVerification() bool {
+	return s.config.GetBool("auth.require_email_verification")
+}
+
+func (s *AccountRecoveryService) findResetToken(tx *gorm.DB, token string) (*models.PasswordResetToken, error) {
+	var reset models.PasswordResetToken
+	if err := tx.Where("token_hash = ? AND used_at IS NULL AND expires_at > ?",
+		auth.HashToken(token), time.Now()).First(&reset).Error; err != nil {
+		return nil, ErrRecoveryInvalidToken
+	}
+	return &reset, nil
+}
+
+// recoverableUser finds the account links may be sent for. Accounts that
+// are suspended, disabled or awaiting approval get none.
+func (s *AccountRecoveryService) recoverableUser(address string) (*models.User, bool) {
+	address = strings.TrimSpace(address)
+	if address == "" {
+		return nil, false
+	}
+	var user models.User
+	if err := s.db.Where("LOWER(email) = LOWER(?)", address).First(&user).Error; err != nil {
+		return nil, false
+	}
+	if user.IsSuspended || user.PendingApproval || (!user.IsActive && !user.IsDeactivated()) {
+		return nil, false
+	}
+	return &user, true
+}
+
+// tooManyLinks reports whether the user has had as many links of a kind as
+// they may within its lifetime
+func (s *AccountRecoveryService) tooManyLinks(model interface{}, userID uuid.UUID, ttl time.Duration) bool {
+	var count int64
+	s.db.Model(model).Where("user_id = ? AND created_at > ?", userID, time.Now().Add(-ttl)).Count(&count)
+	return count >= int64(s.maxRequests())
+}
+
+// fail counts an invalid link against the client
+func (s *AccountRecoveryService) fail(ip string) {
+	s.lockout.fail(ip, time.Now(), s.lockoutDuration())
+}
+
+// expireTokens stops the user's unused links of a kind from working
+func expireTokens(tx *gorm.DB, model interface{}, userID uuid.UUID, now time.Time) error {
+	return tx.Model(model).
+		Where("user_id = ? AND used_at IS NULL AND expires_at > ?", userID, now).
+		Update("expires_at", now).Error
+}
+
+// useToken marks a link used. It is checked in the same statement, so a
+// link raced by two requests only works for one.
+func useToken(tx *gorm.DB, model interface{}, id uuid.UUID) error {
+	result := tx.Model(model).Where("id = ? AND used_at IS NULL", id).Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecoveryInvalidToken
+	}
+	return nil
+}
+
+func newRecoveryToken() (string, error) {
+	token, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	// Tokens go in URL paths
+	return strings.TrimRight(token, "="), nil
+}
+
+func (s *AccountRecoveryService) baseURL() string {
+	return strings.TrimSuffix(s.config.GetString("server.url"), "/")
+}
+
+func (s *AccountRecoveryService) resetTTL() time.Duration {
+	if ttl := s.config.GetDuration("security.password_reset.token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return time.Hour
+}
+
+func (s *AccountRecoveryService) verificationTTL() time.Duration {
+	if ttl := s.config.GetDuration("security.email_verification.token_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
+
+func (s *AccountRecoveryService) maxRequests() int {
+	if limit := s.config.GetInt("security.password_reset.max_requests"); limit > 0 {
+		return limit
+	}
+	return 3
+}
+
+func (s *AccountRecoveryService) passwordMinLength() int {
+	if length := s.config.GetInt("security.password.min_length"); length > 0 {
+		return length
+	}
+	return 8
+}
+
+func (s *AccountRecoveryService) lockoutAttempts() int {
+	if attempts := s.config.GetInt("security.token_lockout.attempts"); attempts > 0 {
+		return attempts
+	}
+	return 10
+}
+
+func (s *AccountRecoveryService) lockoutDuration() time.Duration {
+	if duration := s.config.GetDuration("security.token_lockout.duration"); duration > 0 {
+		return duration
+	}
+	return 15 * time.Minute
+}
+
+// tokenLockout counts invalid links per client IP. A client that reaches
+// the limit is refused until its window ends; every further failure
+// before that starts the window again.
+type tokenLockout struct {
+	mu       sync.Mutex
+	failures map[string]*lockoutWindow
+}
+
+type lockoutWindow struct {
+	count int
+	reset time.Time
+}
+
+func (l *tokenLockout) locked(ip string, now time.Time, attempts int) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	w, ok := l.failures[ip]
+	return ok && now.Before(w.reset) && w.count >= attempts
+}
+
+func (l *tokenLockout) fail(ip string, now time.Time, duration time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// Drop ended windows now and then so one-off clients don't pile up
+	if len(l.failures) > 1000 {
+		for key, w := range l.failures {
+			if !now.Before(w.reset) {
+				delete(l.failures, key)
+			}
+		}
+	}
+
+	w, ok := l.failures[ip]
+	if !ok || !now.Before(w.reset) {
+		w = &lockoutWindow{}
+		l.failures[ip] = w
+	}
+	w.count++
+	w.reset = now.Add(duration)
+}
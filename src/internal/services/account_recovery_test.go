@@ -0,0 +1,159 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestAccountRecoveryService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Session{}, &models.PasswordResetToken{}, &models.EmailVerificationToken{}))
+
+	config := config.New()
+	config.Set("features.password_reset", true)
+	config.Set("security.password.min_length", 10)
+	config.Set("security.token_lockout.attempts", 3)
+	service := NewAccountRecoveryService(db, config, nil)
+
+	hash, err := auth.HashPassword("old-password")
+	require.NoError(t, err)
+	alice := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hash, IsActive: true}
+	require.NoError(t, db.Create(alice).Error)
+
+	t.Run("RequestPasswordReset", func(t *testing.T) {
+		token, err := service.RequestPasswordReset("nobody@example.com", "192.0.2.1")
+		require.NoError(t, err)
+		assert.Empty(t, token)
+
+		config.Set("features.password_reset", false)
+		_, err = service.RequestPasswordReset("alice@example.com", "192.0.2.1")
+		assert.ErrorIs(t, err, ErrRecoveryDisabled)
+		config.Set("features.password_reset", true)
+
+		first, err := service.RequestPasswordReset(" Alice@Example.com ", "192.0.2.1")
+		require.NoError(t, err)
+		require.NotEmpty(t, first)
+		second, err := service.RequestPasswordReset("alice@example.com", "192.0.2.1")
+		require.NoError(t, err)
+
+		// Only the newest link works
+		_, err = service.CheckResetToken(first, "192.0.2.1")
+		assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+		reset, err := service.CheckResetToken(second, "192.0.2.1")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, reset.UserID)
+		assert.Equal(t, "192.0.2.1", reset.RequestIP)
+
+		// Three links per lifetime
+		_, err = service.RequestPasswordReset("alice@example.com", "192.0.2.1")
+		require.NoError(t, err)
+		token, err = service.RequestPasswordReset("alice@example.com", "192.0.2.1")
+		require.NoError(t, err)
+		assert.Empty(t, token)
+	})
+
+	t.Run("ResetPassword", func(t *testing.T) {
+		db.Where("user_id = ?", alice.ID).Delete(&models.PasswordResetToken{})
+		token, err := service.RequestPasswordReset("alice@example.com", "192.0.2.2")
+		require.NoError(t, err)
+		require.NoError(t, db.Create(&models.Session{UserID: alice.ID, Token: "access", RefreshToken: "refresh"}).Error)
+
+		_, err = service.ResetPassword(token, "short", "192.0.2.2")
+		assert.ErrorIs(t, err, ErrRecoveryPassword)
+
+		user, err := service.ResetPassword(token, "new-password", "192.0.2.2")
+		require.NoError(t, err)
+		assert.Equal(t, alice.ID, user.ID)
+
+		var stored models.User
+		require.NoError(t, db.First(&stored, "id = ?", alice.ID).Error)
+		assert.True(t, auth.CheckPasswordHash("new-password", stored.PasswordHash))
+		assert.True(t, stored.EmailVerified)
+		var sessions int64
+		db.Model(&models.Session{}).Where("user_id = ?", alice.ID).Count(&sessions)
+		assert.Zero(t, sessions)
+
+		// Single use
+		_, err = service.ResetPassword(token, "another-password", "192.0.2.2")
+		assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+	})
+
+	t.Run("Expired", func(t *testing.T) {
+		db.Where("user_id = ?", alice.ID).Delete(&models.PasswordResetToken{})
+		token, err := service.RequestPasswordReset("alice@example.com", "192.0.2.3")
+		require.NoError(t, err)
+		db.Model(&models.PasswordResetToken{}).Where("user_id = ?", alice.ID).Update("expires_at", time.Now().Add(-time.Minute))
+
+		_, err = service.ResetPassword(token, "new-password", "192.0.2.3")
+		assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+	})
+
+	t.Run("Lockout", func(t *testing.T) {
+		db.Where("user_id = ?", alice.ID).Delete(&models.PasswordResetToken{})
+		token, err := service.RequestPasswordReset("alice@example.com", "192.0.2.4")
+		require.NoError(t, err)
+
+		for _, guess := range []string{"guess1", "guess2", "guess3"} {
+			_, err := service.CheckResetToken(guess, "198.51.100.7")
+			assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+		}
+		// Even the right link is refused once locked out
+		_, err = service.ResetPassword(token, "new-password", "198.51.100.7")
+		assert.ErrorIs(t, err, ErrRecoveryLocked)
+		_, err = service.VerifyEmail("guess4", "198.51.100.7")
+		assert.ErrorIs(t, err, ErrRecoveryLocked)
+
+		// Other clients aren't
+		_, err = service.CheckResetToken(token, "192.0.2.4")
+		assert.NoError(t, err)
+	})
+
+	t.Run("VerifyEmail", func(t *testing.T) {
+		bob := &models.User{Username: "bob", Email: "bob@example.com", IsActive: true}
+		require.NoError(t, db.Create(bob).Error)
+
+		token, err := service.SendVerification(bob)
+		require.NoError(t, err)
+		user, err := service.VerifyEmail(token, "192.0.2.5")
+		require.NoError(t, err)
+		assert.Equal(t, bob.ID, user.ID)
+
+		var stored models.User
+		require.NoError(t, db.First(&stored, "id = ?", bob.ID).Error)
+		assert.True(t, stored.EmailVerified)
+		assert.True(t, stored.IsEmailVerified)
+		_, err = service.SendVerification(&stored)
+		assert.ErrorIs(t, err, ErrEmailAlreadyVerified)
+
+		_, err = service.VerifyEmail(token, "192.0.2.5")
+		assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+	})
+
+	t.Run("VerifyChangedEmail", func(t *testing.T) {
+		carol := &models.User{Username: "carol", Email: "carol@example.com", IsActive: true}
+		require.NoError(t, db.Create(carol).Error)
+
+		token, err := service.SendVerification(carol)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(carol).Update("email", "carol@other.example.com").Error)
+
+		_, err = service.VerifyEmail(token, "192.0.2.6")
+		assert.ErrorIs(t, err, ErrRecoveryInvalidToken)
+	})
+
+	t.Run("SuspendedAccount", func(t *testing.T) {
+		dave := &models.User{Username: "dave", Email: "dave@example.com", IsActive: true, IsSuspended: true}
+		require.NoError(t, db.Create(dave).Error)
+
+		token, err := service.RequestPasswordReset("dave@example.com", "192.0.2.7")
+		require.NoError(t, err)
+		assert.Empty(t, token)
+	})
+}
@@ -0,0 +1,92 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestAccountService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Session{}))
+
+	service := NewAccountService(db, config.New())
+
+	hash, err := auth.HashPassword("correct-password")
+	require.NoError(t, err)
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hash, IsActive: true}
+	require.NoError(t, db.Create(user).Error)
+	admin := &models.User{Username: "root", Email: "root@example.com", PasswordHash: hash, IsActive: true, IsAdmin: true}
+	require.NoError(t, db.Create(admin).Error)
+
+	gist := &models.Gist{Title: "hello", UserID: &user.ID, Visibility: models.VisibilityPublic}
+	require.NoError(t, db.Create(gist).Error)
+	require.NoError(t, db.Create(&models.Session{UserID: user.ID, Token: "t", RefreshToken: "r", ExpiresAt: time.Now().Add(time.Hour)}).Error)
+
+	visibleGists := func() int64 {
+		var count int64
+		db.Model(&models.Gist{}).Scopes(models.HideDeactivatedOwners).Count(&count)
+		return count
+	}
+
+	t.Run("Validation", func(t *testing.T) {
+		_, err := service.Deactivate(user.ID, "wrong-password", false)
+		assert.ErrorIs(t, err, ErrAccountInvalidPassword)
+
+		_, err = service.Deactivate(admin.ID, "correct-password", false)
+		assert.ErrorIs(t, err, ErrAccountLastAdmin)
+	})
+
+	t.Run("DeactivateAndReactivate", func(t *testing.T) {
+		_, err := service.Deactivate(user.ID, "correct-password", false)
+		require.NoError(t, err)
+
+		var reloaded models.User
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.True(t, reloaded.IsDeactivated())
+		assert.False(t, reloaded.IsActive)
+		assert.Equal(t, int64(0), visibleGists())
+
+		var sessions int64
+		db.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&sessions)
+		assert.Equal(t, int64(0), sessions)
+
+		_, err = service.Deactivate(user.ID, "correct-password", false)
+		assert.ErrorIs(t, err, ErrAccountAlreadyDeactivated)
+
+		require.NoError(t, service.Reactivate(&reloaded))
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.False(t, reloaded.IsDeactivated())
+		assert.True(t, reloaded.IsActive)
+		assert.Equal(t, int64(1), visibleGists())
+	})
+
+	t.Run("KeepGistsPublic", func(t *testing.T) {
+		_, err := service.Deactivate(user.ID, "correct-password", true)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), visibleGists())
+
+		var reloaded models.User
+		db.First(&reloaded, "id = ?", user.ID)
+		require.NoError(t, service.Reactivate(&reloaded))
+	})
+
+	t.Run("GracePeriodExpired", func(t *testing.T) {
+		_, err := service.Deactivate(user.ID, "correct-password", false)
+		require.NoError(t, err)
+
+		expired := time.Now().Add(-service.GracePeriod() - time.Hour)
+		db.Model(&models.User{}).Where("id = ?", user.ID).Update("deactivated_at", expired)
+
+		var reloaded models.User
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.False(t, service.CanReactivate(&reloaded))
+		assert.ErrorIs(t, service.Reactivate(&reloaded), ErrAccountGracePeriodExpired)
+	})
+}
@@ -0,0 +1,263 @@
+package services
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/blobs"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+var (
+	ErrAttachmentsDisabled    = errors.New("attachments are disabled")
+	ErrAttachmentGistNotFound = errors.New("gist not found")
+	ErrAttachmentNotFound     = errors.New("attachment not found")
+	ErrAttachmentFilename     = errors.New("filename must be 1 to 255 characters without slashes or NUL")
+	ErrAttachmentNameTaken    = errors.New("the gist already has a file with that name")
+	ErrAttachmentInline       = errors.New("small text files are stored as gist files, add it to the gist's files instead")
+	ErrAttachmentTooLarge     = errors.New("attachment exceeds the maximum size")
+	ErrAttachmentLimit        = errors.New("the gist has the maximum number of attachments")
+	ErrAttachmentStorage      = errors.New("attachment storage failed")
+)
+
+// AttachmentService keeps binary and large files of gists in blob
+// storage. Text files up to attachments.inline_max_size belong in the
+// database as gist files, where they are searched and rendered, so they
+// are refused as attachments.
+type AttachmentService struct {
+	db     *gorm.DB
+	config *config.Config
+	store  blobs.Store
+}
+
+// NewAttachmentService creates a new attachment service
+func NewAttachmentService(db *gorm.DB, config *config.Config, store blobs.Store) *AttachmentService {
+	return &AttachmentService{db: db, config: config, store: store}
+}
+
+// Enabled reports whether attachments can be uploaded and downloaded
+func (s *AttachmentService) Enabled() bool {
+	return s.store != nil && s.config.GetBool("attachments.enabled")
+}
+
+// InlineMaxSize is the size up to which text files are gist files (bytes,
+// 0 to store every upload as an attachment)
+func (s *AttachmentService) InlineMaxSize() int64 {
+	return s.config.GetInt64("attachments.inline_max_size")
+}
+
+// MaxSize is the largest attachment accepted (bytes, 0 for no limit)
+func (s *AttachmentService) MaxSize() int64 {
+	return s.config.GetInt64("attachments.max_size")
+}
+
+// MaxPerGist is how many attachments a gist can have (0 for no limit)
+func (s *AttachmentService) MaxPerGist() int {
+	return s.config.GetInt("attachments.max_per_gist")
+}
+
+// managedGist returns the gist if the user may change its files
+func (s *AttachmentService) managedGist(gistID uuid.UUID, user *models.User) (*models.Gist, error) {
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, ErrAttachmentGistNotFound
+	}
+	policy := NewOrgPolicyService(s.db)
+	if err := policy.AuthorizeGist(&gist, user, GistWrite); err != nil {
+		// Gists the user can't see are reported as not found
+		if policy.AuthorizeGist(&gist, user, GistRead) != nil {
+			return nil, ErrAttachmentGistNotFound
+		}
+		return nil, err
+	}
+	return &gist, nil
+}
+
+// List returns a gist's attachments by filename. Callers check that the
+// gist may be read.
+func (s *AttachmentService) List(gistID uuid.UUID) ([]models.GistAttachment, error) {
+	attachments := []models.GistAttachment{}
+	err := s.db.Where("gist_id = ?", gistID).Order("filename").Find(&attachments).Error
+	return attachments, err
+}
+
+// Get returns one of a gist's attachments. Callers check that the gist may
+// be read.
+func (s *AttachmentService) Get(gistID uuid.UUID, filename string) (*models.GistAttachment, error) {
+	var attachment models.GistAttachment
+	if err := s.db.First(&attachment, "gist_id = ? AND filename = ?", gistID, filename).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrAttachmentNotFound
+		}
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+// Open returns an attachment's content
+func (s *AttachmentService) Open(attachment *models.GistAttachment) (io.ReadSeekCloser, error) {
+	if !s.Enabled() {
+		return nil, ErrAttachmentsDisabled
+	}
+	content, err := s.store.Open(attachment.SHA256)
+	if errors.Is(err, blobs.ErrNotFound) {
+		return nil, ErrAttachmentNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAttachmentStorage, err)
+	}
+	return content, nil
+}
+
+// Upload streams content into blob storage and attaches it to the gist
+// under filename, replacing an attachment of that name. It reports whether
+// the attachment is new.
+func (s *AttachmentService) Upload(gistID uuid.UUID, user *models.User, filename string, content io.Reader) (*models.GistAttachment, bool, error) {
+	if !s.Enabled() {
+		return nil, false, ErrAttachmentsDisabled
+	}
+	gist, err := s.managedGist(gistID, user)
+	if err != nil {
+		return nil, false, err
+	}
+	filename = strings.TrimSpace(filename)
+	if !validAttachmentName(filename) {
+		return nil, false, ErrAttachmentFilename
+	}
+	var files int64
+	if err := s.db.Model(&models.GistFile{}).Where("gist_id = ? AND filename = ?", gist.ID, filename).Count(&files).Error; err != nil {
+		return nil, false, err
+	}
+	if files > 0 {
+		return nil, false, ErrAttachmentNameTaken
+	}
+	existing, err := s.Get(gist.ID, filename)
+	if err != nil && !errors.Is(err, ErrAttachmentNotFound) {
+		return nil, false, err
+	}
+	if existing == nil && s.MaxPerGist() > 0 {
+		var count int64
+		if err := s.db.Model(&models.GistAttachment{}).Where("gist_id = ?", gist.ID).Count(&count).Error; err != nil {
+			return nil, false, err
+		}
+		if count >= int64(s.MaxPerGist()) {
+			return nil, false, ErrAttachmentLimit
+		}
+	}
+
+	// The start of the upload decides whether it is a small text file and
+	// what type it is, then is stored ahead of the rest
+	head := make([]byte, max(s.InlineMaxSize()+1, 512))
+	n, err := io.ReadFull(content, head)
+	if err != nil && !errors.Is(err, io.ErrUnexpectedEOF) && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	head = head[:n]
+	if int64(n) <= s.InlineMaxSize() && isText(head) {
+		return nil, false, ErrAttachmentInline
+	}
+	blob, err := s.store.Put(io.MultiReader(bytes.NewReader(head), content), s.MaxSize())
+	if errors.Is(err, blobs.ErrTooLarge) {
+		return nil, false, fmt.Errorf("%w of %d bytes", ErrAttachmentTooLarge, s.MaxSize())
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("%w: %v", ErrAttachmentStorage, err)
+	}
+
+	attachment := existing
+	if attachment == nil {
+		attachment = &models.GistAttachment{GistID: gist.ID, Filename: filename}
+	}
+	previous := attachment.SHA256
+	attachment.ContentType = attachmentContentType(filename, head)
+	attachment.Size = blob.Size
+	attachment.SHA256 = blob.SHA256
+	if err := s.db.Save(attachment).Error; err != nil {
+		return nil, false, err
+	}
+	if previous != "" && previous != blob.SHA256 {
+		s.release(previous)
+	}
+	return attachment, existing == nil, nil
+}
+
+// Delete removes one of a gist's attachments
+func (s *AttachmentService) Delete(gistID uuid.UUID, user *models.User, filename string) error {
+	gist, err := s.managedGist(gistID, user)
+	if err != nil {
+		return err
+	}
+	attachment, err := s.Get(gist.ID, filename)
+	if err != nil {
+		return err
+	}
+	if err := s.db.Delete(attachment).Error; err != nil {
+		return err
+	}
+	s.release(attachment.SHA256)
+	return nil
+}
+
+// release deletes a blob once no attachment refers to it. Blobs left
+// behind by a failure here are removed by the blob pruner.
+func (s *AttachmentService) release(sum string) {
+	if s.store == nil {
+		return
+	}
+	var refs int64
+	if err := s.db.Model(&models.GistAttachment{}).Where("sha256 = ?", sum).Count(&refs).Error; err != nil || refs > 0 {
+		return
+	}
+	if err := s.store.Delete(sum); err != nil {
+		slog.Error("Failed to delete attachment blob", "blob", sum, "error", err)
+	}
+}
+
+// CopyAttachments gives a fork the attachments of the gist it was forked
+// from. Blobs are shared by content, so only the rows are copied.
+func CopyAttachments(tx *gorm.DB, fromID, toID uuid.UUID) error {
+	var attachments []models.GistAttachment
+	if err := tx.Where("gist_id = ?", fromID).Find(&attachments).Error; err != nil {
+		return err
+	}
+	for _, attachment := range attachments {
+		attachment.ID = uuid.Nil
+		attachment.GistID = toID
+		if err := tx.Create(&attachment).Error; err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// validAttachmentName accepts the filenames gist files may have
+func validAttachmentName(filename string) bool {
+	return filename != "" && len(filename) <= 255 && filename != "." && filename != ".." &&
+		!strings.ContainsAny(filename, "/\\\x00")
+}
+
+// isText reports whether content reads as UTF-8 text
+func isText(content []byte) bool {
+	return utf8.Valid(content) && bytes.IndexByte(content, 0) < 0
+}
+
+// attachmentContentType returns the type of an attachment from its
+// extension, or failing that from its first bytes
+func attachmentContentType(filename string, head []byte) string {
+	if contentType := mime.TypeByExtension(strings.ToLower(filepath.Ext(filename))); contentType != "" {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
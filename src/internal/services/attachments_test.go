@@ -0,0 +1,129 @@
+package services
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/blobs"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestAttachmentService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{},
+		&models.GistFile{}, &models.GistAttachment{}))
+
+	cfg := config.New()
+	cfg.Set("attachments.enabled", true)
+	cfg.Set("attachments.inline_max_size", 16)
+	cfg.Set("attachments.max_size", 1024)
+	cfg.Set("attachments.max_per_gist", 2)
+	store := blobs.NewLocalStore(t.TempDir())
+	service := NewAttachmentService(db, cfg, store)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	gist := &models.Gist{UserID: &owner.ID, Title: "Assets", Visibility: models.VisibilityPublic, GitRepoPath: "assets",
+		Files: []models.GistFile{{Filename: "README.md", Content: "# Assets"}}}
+	require.NoError(t, db.Create(gist).Error)
+	private := &models.Gist{UserID: &owner.ID, Title: "Secret", Visibility: models.VisibilityPrivate, GitRepoPath: "secret"}
+	require.NoError(t, db.Create(private).Error)
+
+	png := "\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"
+	logo, created, err := service.Upload(gist.ID, owner, " logo.png ", strings.NewReader(png))
+	require.NoError(t, err)
+	assert.True(t, created)
+	assert.Equal(t, "logo.png", logo.Filename)
+	assert.Equal(t, "image/png", logo.ContentType)
+	assert.EqualValues(t, len(png), logo.Size)
+
+	content, err := service.Open(logo)
+	require.NoError(t, err)
+	read, err := io.ReadAll(content)
+	require.NoError(t, err)
+	content.Close()
+	assert.Equal(t, png, string(read))
+
+	t.Run("Threshold", func(t *testing.T) {
+		// Small text belongs in the gist's files; large text is attached
+		_, _, err := service.Upload(gist.ID, owner, "notes.txt", strings.NewReader("short note"))
+		assert.ErrorIs(t, err, ErrAttachmentInline)
+		_, _, err = service.Upload(gist.ID, owner, "huge.bin", bytes.NewReader(make([]byte, 1025)))
+		assert.ErrorIs(t, err, ErrAttachmentTooLarge)
+
+		log, _, err := service.Upload(gist.ID, owner, "BUILDLOG", strings.NewReader(strings.Repeat("line\n", 10)))
+		require.NoError(t, err)
+		assert.Equal(t, "text/plain; charset=utf-8", log.ContentType, "files without an extension are sniffed")
+		require.NoError(t, service.Delete(gist.ID, owner, "BUILDLOG"))
+	})
+
+	t.Run("Validation", func(t *testing.T) {
+		for _, name := range []string{"", "..", "a/b.png", "a\\b.png"} {
+			_, _, err := service.Upload(gist.ID, owner, name, strings.NewReader(png))
+			assert.ErrorIs(t, err, ErrAttachmentFilename, name)
+		}
+		_, _, err := service.Upload(gist.ID, owner, "README.md", strings.NewReader(png))
+		assert.ErrorIs(t, err, ErrAttachmentNameTaken)
+
+		_, _, err = service.Upload(gist.ID, alice, "alice.png", strings.NewReader(png))
+		assert.ErrorIs(t, err, ErrGistAccessDenied)
+		_, _, err = service.Upload(private.ID, alice, "alice.png", strings.NewReader(png))
+		assert.ErrorIs(t, err, ErrAttachmentGistNotFound)
+	})
+
+	t.Run("ReplaceAndLimit", func(t *testing.T) {
+		_, _, err := service.Upload(gist.ID, owner, "icon.png", strings.NewReader(png+"v1"))
+		require.NoError(t, err)
+		_, _, err = service.Upload(gist.ID, owner, "third.png", strings.NewReader(png))
+		assert.ErrorIs(t, err, ErrAttachmentLimit)
+
+		// Replacing keeps the count and frees the old content
+		replaced, created, err := service.Upload(gist.ID, owner, "icon.png", strings.NewReader(png+"v2"))
+		require.NoError(t, err)
+		assert.False(t, created)
+		stored, err := store.List()
+		require.NoError(t, err)
+		assert.Len(t, stored, 2)
+
+		attachments, err := service.List(gist.ID)
+		require.NoError(t, err)
+		require.Len(t, attachments, 2)
+		assert.Equal(t, "icon.png", attachments[0].Filename)
+		assert.Equal(t, replaced.SHA256, attachments[0].SHA256)
+	})
+
+	t.Run("ForksShareBlobs", func(t *testing.T) {
+		fork := &models.Gist{UserID: &alice.ID, Title: "Assets", GitRepoPath: "fork", ForkedFromID: &gist.ID}
+		require.NoError(t, db.Create(fork).Error)
+		require.NoError(t, CopyAttachments(db, gist.ID, fork.ID))
+		copied, err := service.Get(fork.ID, "logo.png")
+		require.NoError(t, err)
+		assert.Equal(t, logo.SHA256, copied.SHA256)
+
+		// The original letting go of the content leaves it for the fork
+		require.NoError(t, service.Delete(gist.ID, owner, "logo.png"))
+		content, err := service.Open(copied)
+		require.NoError(t, err)
+		content.Close()
+		require.NoError(t, service.Delete(fork.ID, alice, "logo.png"))
+		_, err = service.Open(copied)
+		assert.ErrorIs(t, err, ErrAttachmentNotFound)
+		_, err = service.Get(fork.ID, "logo.png")
+		assert.ErrorIs(t, err, ErrAttachmentNotFound)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		cfg.Set("attachments.enabled", false)
+		defer cfg.Set("attachments.enabled", true)
+		_, _, err := service.Upload(gist.ID, owner, "logo.png", strings.NewReader(png))
+		assert.ErrorIs(t, err, ErrAttachmentsDisabled)
+	})
+}
@@ -0,0 +1,436 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Collection and pin limits
+const (
+	MaxCollectionsPerUser   = 50
+	MaxGistsPerCollection   = 200
+	MaxPinnedGists          = 6
+	MaxCollectionNameLength = 100
+	MaxCollectionDescLength = 500
+)
+
+var (
+	ErrCollectionNotFound    = errors.New("collection not found")
+	ErrCollectionName        = fmt.Errorf("collection name must be 1 to %d characters", MaxCollectionNameLength)
+	ErrCollectionDescription = fmt.Errorf("collection description must be at most %d characters", MaxCollectionDescLength)
+	ErrCollectionNameTaken   = errors.New("you already have a collection with this name")
+	ErrCollectionLimit       = fmt.Errorf("you can have at most %d collections", MaxCollectionsPerUser)
+	ErrCollectionFull        = fmt.Errorf("a collection can hold at most %d gists", MaxGistsPerCollection)
+	ErrCollectionOrder       = errors.New("the order must list each of your collections once")
+	ErrPinLimit              = fmt.Errorf("you can pin at most %d gists", MaxPinnedGists)
+	ErrCollectionGist        = errors.New("gist not found")
+)
+
+// CollectionInput holds the fields of a collection to set; nil fields are
+// left as they are
+type CollectionInput struct {
+	Name        *string
+	Description *string
+	IsPublic    *bool
+}
+
+// CollectionView is a collection with the gists its viewer may see, in
+// order
+type CollectionView struct {
+	models.GistCollection
+	Gists []models.Gist
+}
+
+// GistCollectionService manages users' gist collections and the gists
+// pinned to their profiles. Owners see every gist they can still read;
+// everybody else sees public collections and the public gists in them.
+type GistCollectionService struct {
+	db     *gorm.DB
+	policy *OrgPolicyService
+}
+
+// NewGistCollectionService creates a new collection service
+func NewGistCollectionService(db *gorm.DB) *GistCollectionService {
+	return &GistCollectionService{db: db, policy: NewOrgPolicyService(db)}
+}
+
+// List returns the owner's collections in order, with their gists. Viewers
+// other than the owner only get public collections.
+func (s *GistCollectionService) List(owner, viewer *models.User) ([]CollectionView, error) {
+	query := s.db.Where("user_id = ?", owner.ID)
+	if !isOwner(owner, viewer) {
+		query = query.Where("is_public = ?", true)
+	}
+	var collections []models.GistCollection
+	if err := query.Order("position, created_at").Find(&collections).Error; err != nil {
+		return nil, err
+	}
+
+	views := make([]CollectionView, 0, len(collections))
+	for _, collection := range collections {
+		gists, err := s.collectionGists(collection.ID, owner, viewer)
+		if err != nil {
+			return nil, err
+		}
+		views = append(views, CollectionView{GistCollection: collection, Gists: gists})
+	}
+	return views, nil
+}
+
+// Get returns one of the owner's collections with its gists
+func (s *GistCollectionService) Get(collectionID uuid.UUID, owner, viewer *models.User) (*CollectionView, error) {
+	var collection models.GistCollection
+	if err := s.db.First(&collection, "id = ? AND user_id = ?", collectionID, owner.ID).Error; err != nil {
+		return nil, ErrCollectionNotFound
+	}
+	if !collection.IsPublic && !isOwner(owner, viewer) {
+		return nil, ErrCollectionNotFound
+	}
+	gists, err := s.collectionGists(collection.ID, owner, viewer)
+	if err != nil {
+		return nil, err
+	}
+	return &CollectionView{GistCollection: collection, Gists: gists}, nil
+}
+
+// Create adds a collection after the user's others
+func (s *GistCollectionService) Create(user *models.User, input CollectionInput) (*models.GistCollection, error) {
+	if input.Name == nil {
+		return nil, ErrCollectionName
+	}
+	collection := &models.GistCollection{UserID: user.ID, IsPublic: true}
+	if err := applyCollectionInput(collection, input); err != nil {
+		return nil, err
+	}
+
+	var count int64
+	if err := s.db.Model(&models.GistCollection{}).Where("user_id = ?", user.ID).Count(&count).Error; err != nil {
+		return nil, err
+	}
+	if count >= MaxCollectionsPerUser {
+		return nil, ErrCollectionLimit
+	}
+	if err := s.checkNameFree(user.ID, collection.Name, uuid.Nil); err != nil {
+		return nil, err
+	}
+
+	var last struct{ Position int }
+	s.db.Model(&models.GistCollection{}).Select("COALESCE(MAX(position), -1) AS position").
+		Where("user_id = ?", user.ID).Scan(&last)
+	collection.Position = last.Position + 1
+	if err := s.db.Create(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// Update changes a collection's name, description or visibility
+func (s *GistCollectionService) Update(collectionID uuid.UUID, user *models.User, input CollectionInput) (*models.GistCollection, error) {
+	collection, err := s.owned(collectionID, user)
+	if err != nil {
+		return nil, err
+	}
+	if err := applyCollectionInput(collection, input); err != nil {
+		return nil, err
+	}
+	if input.Name != nil {
+		if err := s.checkNameFree(user.ID, collection.Name, collection.ID); err != nil {
+			return nil, err
+		}
+	}
+	if err := s.db.Model(collection).Select("name", "description", "is_public").Updates(collection).Error; err != nil {
+		return nil, err
+	}
+	return collection, nil
+}
+
+// Delete removes a collection; its gists stay as they are
+func (s *GistCollectionService) Delete(collectionID uuid.UUID, user *models.User) error {
+	collection, err := s.owned(collectionID, user)
+	if err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.GistCollectionItem{}).Error; err != nil {
+			return err
+		}
+		return tx.Delete(collection).Error
+	})
+}
+
+// Reorder puts the user's collections in the given order, which must list
+// each of them once
+func (s *GistCollectionService) Reorder(user *models.User, collectionIDs []uuid.UUID) error {
+	var existing []uuid.UUID
+	if err := s.db.Model(&models.GistCollection{}).Where("user_id = ?", user.ID).Pluck("id", &existing).Error; err != nil {
+		return err
+	}
+	if !sameIDs(existing, collectionIDs) {
+		return ErrCollectionOrder
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for position, id := range collectionIDs {
+			if err := tx.Model(&models.GistCollection{}).Where("id = ? AND user_id = ?", id, user.ID).
+				UpdateColumn("position", position).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// SetGists replaces the gists of a collection with the given ones, in
+// order. The user must be able to read each of them.
+func (s *GistCollectionService) SetGists(collectionID uuid.UUID, user *models.User, gistIDs []uuid.UUID) error {
+	collection, err := s.owned(collectionID, user)
+	if err != nil {
+		return err
+	}
+	gistIDs = uniqueIDs(gistIDs)
+	if len(gistIDs) > MaxGistsPerCollection {
+		return ErrCollectionFull
+	}
+	if err := s.checkReadable(gistIDs, user); err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("collection_id = ?", collection.ID).Delete(&models.GistCollectionItem{}).Error; err != nil {
+			return err
+		}
+		for position, gistID := range gistIDs {
+			item := &models.GistCollectionItem{CollectionID: collection.ID, GistID: gistID, Position: position}
+			if err := tx.Create(item).Error; err != nil {
+				return err
+			}
+		}
+		return tx.Model(collection).Update("updated_at", time.Now()).Error
+	})
+}
+
+// AddGist adds a gist to the end of a collection. Adding a gist that is
+// already in it changes nothing.
+func (s *GistCollectionService) AddGist(collectionID uuid.UUID, user *models.User, gistID uuid.UUID) error {
+	collection, err := s.owned(collectionID, user)
+	if err != nil {
+		return err
+	}
+	if err := s.checkReadable([]uuid.UUID{gistID}, user); err != nil {
+		return err
+	}
+
+	var items []models.GistCollectionItem
+	if err := s.db.Where("collection_id = ?", collection.ID).Order("position").Find(&items).Error; err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.GistID == gistID {
+			return nil
+		}
+	}
+	if len(items) >= MaxGistsPerCollection {
+		return ErrCollectionFull
+	}
+	position := 0
+	if len(items) > 0 {
+		position = items[len(items)-1].Position + 1
+	}
+	return s.db.Create(&models.GistCollectionItem{CollectionID: collection.ID, GistID: gistID, Position: position}).Error
+}
+
+// RemoveGist takes a gist out of a collection
+func (s *GistCollectionService) RemoveGist(collectionID uuid.UUID, user *models.User, gistID uuid.UUID) error {
+	collection, err := s.owned(collectionID, user)
+	if err != nil {
+		return err
+	}
+	result := s.db.Where("collection_id = ? AND gist_id = ?", collection.ID, gistID).Delete(&models.GistCollectionItem{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrCollectionGist
+	}
+	return nil
+}
+
+// Pins returns the gists pinned to the owner's profile that the viewer may
+// see, in order
+func (s *GistCollectionService) Pins(owner, viewer *models.User) ([]models.Gist, error) {
+	var gistIDs []uuid.UUID
+	if err := s.db.Model(&models.PinnedGist{}).Where("user_id = ?", owner.ID).
+		Order("position").Pluck("gist_id", &gistIDs).Error; err != nil {
+		return nil, err
+	}
+	return s.visibleGists(gistIDs, owner, viewer)
+}
+
+// SetPins replaces the gists pinned to the user's profile, in order
+func (s *GistCollectionService) SetPins(user *models.User, gistIDs []uuid.UUID) error {
+	gistIDs = uniqueIDs(gistIDs)
+	if len(gistIDs) > MaxPinnedGists {
+		return ErrPinLimit
+	}
+	if err := s.checkReadable(gistIDs, user); err != nil {
+		return err
+	}
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.PinnedGist{}).Error; err != nil {
+			return err
+		}
+		for position, gistID := range gistIDs {
+			if err := tx.Create(&models.PinnedGist{UserID: user.ID, GistID: gistID, Position: position}).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// owned returns one of the user's collections
+func (s *GistCollectionService) owned(collectionID uuid.UUID, user *models.User) (*models.GistCollection, error) {
+	var collection models.GistCollection
+	if err := s.db.First(&collection, "id = ? AND user_id = ?", collectionID, user.ID).Error; err != nil {
+		return nil, ErrCollectionNotFound
+	}
+	return &collection, nil
+}
+
+// checkNameFree checks that the user has no other collection of that name
+func (s *GistCollectionService) checkNameFree(userID uuid.UUID, name string, except uuid.UUID) error {
+	var count int64
+	if err := s.db.Model(&models.GistCollection{}).
+		Where("user_id = ? AND name = ? AND id <> ?", userID, name, except).
+		Count(&count).Error; err != nil {
+		return err
+	}
+	if count > 0 {
+		return ErrCollectionNameTaken
+	}
+	return nil
+}
+
+// checkReadable checks that the gists exist and the user may read them
+func (s *GistCollectionService) checkReadable(gistIDs []uuid.UUID, user *models.User) error {
+	if len(gistIDs) == 0 {
+		return nil
+	}
+	gists, err := s.visibleGists(gistIDs, user, user)
+	if err != nil {
+		return err
+	}
+	if len(gists) != len(gistIDs) {
+		return ErrCollectionGist
+	}
+	return nil
+}
+
+// collectionGists returns the gists of a collection the viewer may see
+func (s *GistCollectionService) collectionGists(collectionID uuid.UUID, owner, viewer *models.User) ([]models.Gist, error) {
+	var gistIDs []uuid.UUID
+	if err := s.db.Model(&models.GistCollectionItem{}).Where("collection_id = ?", collectionID).
+		Order("position").Pluck("gist_id", &gistIDs).Error; err != nil {
+		return nil, err
+	}
+	return s.visibleGists(gistIDs, owner, viewer)
+}
+
+// visibleGists loads the gists, in the order given, leaving out those that
+// are gone or the viewer may not see. Only owners see the unlisted and
+// private gists they may read; their visitors see public gists.
+func (s *GistCollectionService) visibleGists(gistIDs []uuid.UUID, owner, viewer *models.User) ([]models.Gist, error) {
+	gists := []models.Gist{}
+	if len(gistIDs) == 0 {
+		return gists, nil
+	}
+	var found []models.Gist
+	if err := s.db.Preload("User", ListedUser).
+		Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated, models.SandboxScope(false)).
+		Where("gists.id IN ?", gistIDs).Find(&found).Error; err != nil {
+		return nil, err
+	}
+
+	byID := make(map[uuid.UUID]models.Gist, len(found))
+	for _, gist := range found {
+		byID[gist.ID] = gist
+	}
+	ownerView := isOwner(owner, viewer)
+	for _, id := range gistIDs {
+		gist, ok := byID[id]
+		if !ok {
+			continue
+		}
+		if !ownerView && gist.Visibility != models.VisibilityPublic {
+			continue
+		}
+		if s.policy.AuthorizeGist(&gist, viewer, GistRead) != nil {
+			continue
+		}
+		gists = append(gists, gist)
+	}
+	return gists, nil
+}
+
+// applyCollectionInput validates the input and copies it to the collection
+func applyCollectionInput(collection *models.GistCollection, input CollectionInput) error {
+	if input.Name != nil {
+		name := strings.TrimSpace(*input.Name)
+		if name == "" || len(name) > MaxCollectionNameLength {
+			return ErrCollectionName
+		}
+		collection.Name = name
+	}
+	if input.Description != nil {
+		description := strings.TrimSpace(*input.Description)
+		if len(description) > MaxCollectionDescLength {
+			return ErrCollectionDescription
+		}
+		collection.Description = description
+	}
+	if input.IsPublic != nil {
+		collection.IsPublic = *input.IsPublic
+	}
+	return nil
+}
+
+// isOwner reports whether the viewer is the owner
+func isOwner(owner, viewer *models.User) bool {
+	return viewer != nil && viewer.ID == owner.ID
+}
+
+// uniqueIDs drops repeated IDs, keeping the first of each
+func uniqueIDs(ids []uuid.UUID) []uuid.UUID {
+	seen := make(map[uuid.UUID]bool, len(ids))
+	unique := make([]uuid.UUID, 0, len(ids))
+	for _, id := range ids {
+		if !seen[id] {
+			seen[id] = true
+			unique = append(unique, id)
+		}
+	}
+	return unique
+}
+
+// sameIDs reports whether ordered lists every ID of existing exactly once
+func sameIDs(existing, ordered []uuid.UUID) bool {
+	if len(existing) != len(ordered) {
+		return false
+	}
+	want := make(map[uuid.UUID]bool, len(existing))
+	for _, id := range existing {
+		want[id] = true
+	}
+	for _, id := range ordered {
+		if !want[id] {
+			return false
+		}
+		delete(want, id)
+	}
+	return true
+}
@@ -0,0 +1,127 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistCollectionService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.GistFile{}, &models.Organization{}, &models.OrganizationMember{},
+		&models.OrganizationSettings{}, &models.GistCollection{}, &models.GistCollectionItem{}, &models.PinnedGist{}))
+
+	service := NewGistCollectionService(db)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+
+	gist := func(user *models.User, title string, visibility models.Visibility) *models.Gist {
+		g := &models.Gist{UserID: &user.ID, Title: title, Visibility: visibility, GitRepoPath: title}
+		require.NoError(t, db.Create(g).Error)
+		return g
+	}
+	public := gist(owner, "public", models.VisibilityPublic)
+	private := gist(owner, "private", models.VisibilityPrivate)
+	unlisted := gist(owner, "unlisted", models.VisibilityUnlisted)
+	alicePrivate := gist(alice, "alice-private", models.VisibilityPrivate)
+
+	name := func(s string) *string { return &s }
+	no := false
+
+	t.Run("Collections", func(t *testing.T) {
+		tools, err := service.Create(owner, CollectionInput{Name: name(" Tools ")})
+		require.NoError(t, err)
+		assert.Equal(t, "Tools", tools.Name)
+		assert.True(t, tools.IsPublic)
+
+		drafts, err := service.Create(owner, CollectionInput{Name: name("Drafts"), IsPublic: &no})
+		require.NoError(t, err)
+		assert.False(t, drafts.IsPublic)
+		assert.Equal(t, tools.Position+1, drafts.Position)
+
+		_, err = service.Create(owner, CollectionInput{Name: name("Tools")})
+		assert.ErrorIs(t, err, ErrCollectionNameTaken)
+		_, err = service.Create(owner, CollectionInput{Name: name("  ")})
+		assert.ErrorIs(t, err, ErrCollectionName)
+
+		// Gists the owner can't read can't be collected
+		err = service.SetGists(tools.ID, owner, []uuid.UUID{alicePrivate.ID})
+		assert.ErrorIs(t, err, ErrCollectionGist)
+
+		require.NoError(t, service.SetGists(tools.ID, owner, []uuid.UUID{private.ID, public.ID, unlisted.ID, public.ID}))
+		mine, err := service.Get(tools.ID, owner, owner)
+		require.NoError(t, err)
+		require.Len(t, mine.Gists, 3)
+		assert.Equal(t, private.ID, mine.Gists[0].ID)
+		assert.Equal(t, public.ID, mine.Gists[1].ID)
+
+		// Visitors see public collections and the public gists in them
+		theirs, err := service.List(owner, alice)
+		require.NoError(t, err)
+		require.Len(t, theirs, 1)
+		require.Len(t, theirs[0].Gists, 1)
+		assert.Equal(t, public.ID, theirs[0].Gists[0].ID)
+		_, err = service.Get(drafts.ID, owner, nil)
+		assert.ErrorIs(t, err, ErrCollectionNotFound)
+
+		require.NoError(t, service.AddGist(drafts.ID, owner, public.ID))
+		require.NoError(t, service.AddGist(drafts.ID, owner, public.ID))
+		require.NoError(t, service.RemoveGist(tools.ID, owner, private.ID))
+		assert.ErrorIs(t, service.RemoveGist(tools.ID, owner, private.ID), ErrCollectionGist)
+
+		assert.ErrorIs(t, service.Reorder(owner, []uuid.UUID{drafts.ID}), ErrCollectionOrder)
+		require.NoError(t, service.Reorder(owner, []uuid.UUID{drafts.ID, tools.ID}))
+		all, err := service.List(owner, owner)
+		require.NoError(t, err)
+		require.Len(t, all, 2)
+		assert.Equal(t, "Drafts", all[0].Name)
+		assert.Len(t, all[0].Gists, 1)
+
+		// Only owners change their collections
+		_, err = service.Update(tools.ID, alice, CollectionInput{Name: name("Mine")})
+		assert.ErrorIs(t, err, ErrCollectionNotFound)
+		updated, err := service.Update(tools.ID, owner, CollectionInput{IsPublic: &no})
+		require.NoError(t, err)
+		assert.Equal(t, "Tools", updated.Name)
+		assert.False(t, updated.IsPublic)
+
+		require.NoError(t, service.Delete(drafts.ID, owner))
+		var items int64
+		db.Model(&models.GistCollectionItem{}).Where("collection_id = ?", drafts.ID).Count(&items)
+		assert.Zero(t, items)
+	})
+
+	t.Run("Pins", func(t *testing.T) {
+		require.NoError(t, service.SetPins(owner, []uuid.UUID{unlisted.ID, public.ID}))
+
+		pins, err := service.Pins(owner, owner)
+		require.NoError(t, err)
+		require.Len(t, pins, 2)
+		assert.Equal(t, unlisted.ID, pins[0].ID)
+
+		pins, err = service.Pins(owner, nil)
+		require.NoError(t, err)
+		require.Len(t, pins, 1)
+		assert.Equal(t, public.ID, pins[0].ID)
+
+		many := make([]uuid.UUID, 0, MaxPinnedGists+1)
+		for i := 0; i <= MaxPinnedGists; i++ {
+			many = append(many, uuid.New())
+		}
+		assert.ErrorIs(t, service.SetPins(owner, many), ErrPinLimit)
+		assert.ErrorIs(t, service.SetPins(alice, []uuid.UUID{private.ID}), ErrCollectionGist)
+
+		// Deleted gists drop off the profile
+		require.NoError(t, db.Delete(public).Error)
+		pins, err = service.Pins(owner, owner)
+		require.NoError(t, err)
+		assert.Len(t, pins, 1)
+	})
+}
@@ -0,0 +1,376 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/webhooks"
+)
+
+// MaxCommentLength is the longest comment, in bytes
+const MaxCommentLength = 1000
+
+var (
+	ErrCommentGistNotFound  = errors.New("gist not found")
+	ErrCommentNotFound      = errors.New("comment not found")
+	ErrCommentInvalid       = errors.New("comment content must be 1-1000 characters")
+	ErrCommentParentInvalid = errors.New("replies must answer a top-level comment on the same gist")
+	ErrCommentNotAuthor     = errors.New("only the author can edit a comment")
+	ErrCommentDeleteDenied  = errors.New("only the author, the gist owner or a moderator can delete a comment")
+	ErrReactionInvalid      = errors.New("unknown reaction")
+)
+
+// CommentService handles gist comments, their replies and reactions
+type CommentService struct {
+	db             *gorm.DB
+	cfg            *config.Config
+	webhookService *webhooks.Service
+	emailService   *email.Service
+	notifier       *notifications.Service
+}
+
+// NewCommentService creates a new comment service. emailService may be nil,
+// in which case no notification emails are sent.
+func NewCommentService(db *gorm.DB, cfg *config.Config, emailService *email.Service) *CommentService {
+	return &CommentService{
+		db:             db,
+		cfg:            cfg,
+		webhookService: webhooks.NewService(db, cfg),
+		emailService:   emailService,
+		notifier:       notifications.NewService(db),
+	}
+}
+
+// CreateCommentInput represents input for creating a comment
+type CreateCommentInput struct {
+	Content         string
+	ParentCommentID *uuid.UUID // Set for replies
+}
+
+// loadUser returns the user, or nil for anonymous requests
+func (s *CommentService) loadUser(userID *uuid.UUID) *models.User {
+	if userID == nil {
+		return nil
+	}
+	var user models.User
+	if err := s.db.First(&user, "id = ?", *userID).Error; err != nil {
+		return nil
+	}
+	return &user
+}
+
+// readableGist returns the gist if the user may read it. Gists the user
+// can't see are reported as not found.
+func (s *CommentService) readableGist(gistID uuid.UUID, user *models.User) (*models.Gist, error) {
+	var gist models.Gist
+	if err := s.db.Preload("User").First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, ErrCommentGistNotFound
+	}
+	if err := NewOrgPolicyService(s.db).AuthorizeGist(&gist, user, GistRead); err != nil {
+		return nil, ErrCommentGistNotFound
+	}
+	return &gist, nil
+}
+
+// comment returns a comment of the gist
+func (s *CommentService) comment(gistID, commentID uuid.UUID) (*models.GistComment, error) {
+	var comment models.GistComment
+	if err := s.db.Preload("User").First(&comment, "id = ? AND gist_id = ?", commentID, gistID).Error; err != nil {
+		return nil, ErrCommentNotFound
+	}
+	return &comment, nil
+}
+
+func validateCommentContent(content string) error {
+	if strings.TrimSpace(content) == "" || len(content) > MaxCommentLength {
+		return ErrCommentInvalid
+	}
+	return nil
+}
+
+// ListComments returns the gist's top-level comments, oldest first, each
+// with its replies and reaction counts
+func (s *CommentService) ListComments(gistID uuid.UUID, userID *uuid.UUID) ([]models.GistComment, int, error) {
+	if _, err := s.readableGist(gistID, s.loadUser(userID)); err != nil {
+		return nil, 0, err
+	}
+
+	var comments []models.GistComment
+	if err := s.db.Preload("User").Where("gist_id = ?", gistID).
+		Order("created_at ASC").Find(&comments).Error; err != nil {
+		return nil, 0, err
+	}
+	if err := s.fillReactions(comments, userID); err != nil {
+		return nil, 0, err
+	}
+
+	// Replies whose parent is gone are shown at the top level rather than
+	// lost
+	topLevel := make(map[uuid.UUID]bool)
+	for _, comment := range comments {
+		if comment.ParentCommentID == nil {
+			topLevel[comment.ID] = true
+		}
+	}
+	replies := make(map[uuid.UUID][]models.GistComment)
+	var threads []models.GistComment
+	for _, comment := range comments {
+		if comment.ParentCommentID != nil && topLevel[*comment.ParentCommentID] {
+			replies[*comment.ParentCommentID] = append(replies[*comment.ParentCommentID], comment)
+			continue
+		}
+		threads = append(threads, comment)
+	}
+	for i := range threads {
+		threads[i].Replies = replies[threads[i].ID]
+	}
+	return threads, len(comments), nil
+}
+
+// fillReactions sets the reaction counts of the comments, and which
+// reactions the user gave
+func (s *CommentService) fillReactions(comments []models.GistComment, userID *uuid.UUID) error {
+	if len(comments) == 0 {
+		return nil
+	}
+	ids := make([]uuid.UUID, len(comments))
+	for i := range comments {
+		ids[i] = comments[i].ID
+	}
+
+	var reactions []models.GistCommentReaction
+	if err := s.db.Where("comment_id IN ?", ids).Find(&reactions).Error; err != nil {
+		return err
+	}
+	counts := make(map[uuid.UUID]map[string]int64)
+	mine := make(map[uuid.UUID][]string)
+	for _, reaction := range reactions {
+		if counts[reaction.CommentID] == nil {
+			counts[reaction.CommentID] = make(map[string]int64)
+		}
+		counts[reaction.CommentID][reaction.Reaction]++
+		if userID != nil && reaction.UserID == *userID {
+			mine[reaction.CommentID] = append(mine[reaction.CommentID], reaction.Reaction)
+		}
+	}
+	for i := range comments {
+		comments[i].Reactions = counts[comments[i].ID]
+		if comments[i].Reactions == nil {
+			comments[i].Reactions = map[string]int64{}
+		}
+		comments[i].ViewerReactions = mine[comments[i].ID]
+		sort.Strings(comments[i].ViewerReactions)
+	}
+	return nil
+}
+
+// CreateComment adds a comment or reply to a gist, then notifies the gist
+// owner and, for replies, the author of the comment replied to
+func (s *CommentService) CreateComment(gistID, userID uuid.UUID, input CreateCommentInput) (*models.GistComment, error) {
+	if err := validateCommentContent(input.Content); err != nil {
+		return nil, err
+	}
+	author := s.loadUser(&userID)
+	if author == nil {
+		return nil, errors.New("user not found")
+	}
+	gist, err := s.readableGist(gistID, author)
+	if err != nil {
+		return nil, err
+	}
+
+	var parent *models.GistComment
+	if input.ParentCommentID != nil {
+		parent, err = s.comment(gistID, *input.ParentCommentID)
+		if err != nil || parent.ParentCommentID != nil {
+			return nil, ErrCommentParentInvalid
+		}
+	}
+
+	comment := models.GistComment{
+		GistID:          gist.ID,
+		UserID:          userID,
+		ParentCommentID: input.ParentCommentID,
+		Content:         input.Content,
+	}
+	if err := s.db.Create(&comment).Error; err != nil {
+		return nil, err
+	}
+	comment.User = *author
+	comment.Reactions = map[string]int64{}
+
+	if s.webhookService != nil {
+		go s.webhookService.TriggerCommentCreated(context.Background(), gist, &comment, author)
+	}
+	s.notify(gist, &comment, author, parent)
+
+	return &comment, nil
+}
+
+// notify tells the gist owner and the author of the parent comment, by
+// email and in the app, once each and never the commenter themselves
+func (s *CommentService) notify(gist *models.Gist, comment *models.GistComment, author *models.User, parent *models.GistComment) {
+	notified := map[uuid.UUID]bool{author.ID: true}
+	type recipient struct {
+		user  models.User
+		reply bool
+	}
+	var recipients []recipient
+	if gist.User != nil && !notified[gist.User.ID] {
+		notified[gist.User.ID] = true
+		recipients = append(recipients, recipient{user: *gist.User})
+	}
+	if parent != nil && !notified[parent.UserID] {
+		notified[parent.UserID] = true
+		recipients = append(recipients, recipient{user: parent.User, reply: true})
+	}
+
+	commenterName := author.DisplayName
+	if commenterName == "" {
+		commenterName = author.Username
+	}
+	for _, r := range recipients {
+		if err := s.notifier.GistCommented(r.user.ID, gist, comment, author, r.reply); err != nil {
+			slog.Error("Failed to notify user of comment", "user", r.user.ID, "comment", comment.ID, "error", err)
+		}
+		if s.emailService == nil {
+			continue
+		}
+		go s.emailService.SendGistCommentNotification(
+			r.user.ID,
+			r.user.Email,
+			r.user.DisplayName,
+			commenterName,
+			gist.Title,
+			comment.Content,
+			gist.ID,
+			comment.ID,
+		)
+	}
+}
+
+// UpdateComment changes a comment's content. Only its author may.
+func (s *CommentService) UpdateComment(gistID, commentID, userID uuid.UUID, content string) (*models.GistComment, error) {
+	if err := validateCommentContent(content); err != nil {
+		return nil, err
+	}
+	if _, err := s.readableGist(gistID, s.loadUser(&userID)); err != nil {
+		return nil, err
+	}
+	comment, err := s.comment(gistID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if comment.UserID != userID {
+		return nil, ErrCommentNotAuthor
+	}
+
+	now := time.Now()
+	if err := s.db.Model(comment).Updates(map[string]interface{}{
+		"content":   content,
+		"edited_at": now,
+	}).Error; err != nil {
+		return nil, err
+	}
+	comment.Content = content
+	comment.EditedAt = &now
+
+	comments := []models.GistComment{*comment}
+	if err := s.fillReactions(comments, &userID); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
+
+// DeleteComment soft deletes a comment and its replies. The author, the
+// gist's owner, the admins of the organization owning it and site admins
+// may delete any comment.
+func (s *CommentService) DeleteComment(gistID, commentID, userID uuid.UUID) error {
+	user := s.loadUser(&userID)
+	gist, err := s.readableGist(gistID, user)
+	if err != nil {
+		return err
+	}
+	comment, err := s.comment(gistID, commentID)
+	if err != nil {
+		return err
+	}
+	if comment.UserID != userID && !s.canModerate(gist, user) {
+		return ErrCommentDeleteDenied
+	}
+
+	return s.db.Where("id = ? OR parent_comment_id = ?", comment.ID, comment.ID).
+		Delete(&models.GistComment{}).Error
+}
+
+// canModerate reports whether the user moderates the gist's comments
+func (s *CommentService) canModerate(gist *models.Gist, user *models.User) bool {
+	if user == nil {
+		return false
+	}
+	if user.IsAdmin {
+		return true
+	}
+	if gist.OrganizationID != nil {
+		role, err := NewOrgPolicyService(s.db).MemberRole(*gist.OrganizationID, user.ID)
+		return err == nil && models.IsOrgAdminRole(role)
+	}
+	return gist.UserID != nil && *gist.UserID == user.ID
+}
+
+// AddReaction gives a comment a reaction from the user. Giving it again
+// changes nothing.
+func (s *CommentService) AddReaction(gistID, commentID, userID uuid.UUID, reaction string) (*models.GistComment, error) {
+	return s.react(gistID, commentID, userID, reaction, func(tx *gorm.DB) error {
+		var count int64
+		if err := tx.Model(&models.GistCommentReaction{}).
+			Where("comment_id = ? AND user_id = ? AND reaction = ?", commentID, userID, reaction).
+			Count(&count).Error; err != nil || count > 0 {
+			return err
+		}
+		return tx.Create(&models.GistCommentReaction{CommentID: commentID, UserID: userID, Reaction: reaction}).Error
+	})
+}
+
+// RemoveReaction takes back the user's reaction to a comment
+func (s *CommentService) RemoveReaction(gistID, commentID, userID uuid.UUID, reaction string) (*models.GistComment, error) {
+	return s.react(gistID, commentID, userID, reaction, func(tx *gorm.DB) error {
+		return tx.Where("comment_id = ? AND user_id = ? AND reaction = ?", commentID, userID, reaction).
+			Delete(&models.GistCommentReaction{}).Error
+	})
+}
+
+// react checks the reaction and access to the comment, applies change and
+// returns the comment with its new counts
+func (s *CommentService) react(gistID, commentID, userID uuid.UUID, reaction string, change func(tx *gorm.DB) error) (*models.GistComment, error) {
+	if _, ok := models.CommentReactions[reaction]; !ok {
+		return nil, ErrReactionInvalid
+	}
+	if _, err := s.readableGist(gistID, s.loadUser(&userID)); err != nil {
+		return nil, err
+	}
+	comment, err := s.comment(gistID, commentID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Transaction(change); err != nil {
+		return nil, err
+	}
+
+	comments := []models.GistComment{*comment}
+	if err := s.fillReactions(comments, &userID); err != nil {
+		return nil, err
+	}
+	return &comments[0], nil
+}
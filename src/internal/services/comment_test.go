@@ -0,0 +1,127 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestCommentService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{},
+		&models.GistComment{}, &models.GistCommentReaction{}))
+
+	service := NewCommentService(db, config.New(), nil)
+
+	newUser := func(name string) *models.User {
+		user := &models.User{Username: name, Email: name + "@example.com"}
+		require.NoError(t, db.Create(user).Error)
+		return user
+	}
+	owner := newUser("owner")
+	alice := newUser("alice")
+	bob := newUser("bob")
+	admin := newUser("admin")
+	require.NoError(t, db.Model(admin).Update("is_admin", true).Error)
+
+	gist := &models.Gist{UserID: &owner.ID, Title: "Notes", Visibility: models.VisibilityPublic, GitRepoPath: "notes"}
+	require.NoError(t, db.Create(gist).Error)
+	private := &models.Gist{UserID: &owner.ID, Title: "Secret", Visibility: models.VisibilityPrivate, GitRepoPath: "secret"}
+	require.NoError(t, db.Create(private).Error)
+
+	t.Run("ThreadsAreOneLevelDeep", func(t *testing.T) {
+		top, err := service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "First"})
+		require.NoError(t, err)
+		reply, err := service.CreateComment(gist.ID, bob.ID, CreateCommentInput{Content: "Reply", ParentCommentID: &top.ID})
+		require.NoError(t, err)
+
+		_, err = service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "Nested", ParentCommentID: &reply.ID})
+		assert.ErrorIs(t, err, ErrCommentParentInvalid)
+		_, err = service.CreateComment(private.ID, owner.ID, CreateCommentInput{Content: "Elsewhere", ParentCommentID: &top.ID})
+		assert.ErrorIs(t, err, ErrCommentParentInvalid)
+		_, err = service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "   "})
+		assert.ErrorIs(t, err, ErrCommentInvalid)
+
+		threads, total, err := service.ListComments(gist.ID, nil)
+		require.NoError(t, err)
+		assert.Equal(t, 2, total)
+		require.Len(t, threads, 1)
+		assert.Equal(t, "First", threads[0].Content)
+		require.Len(t, threads[0].Replies, 1)
+		assert.Equal(t, reply.ID, threads[0].Replies[0].ID)
+	})
+
+	t.Run("PrivateGistsHideTheirComments", func(t *testing.T) {
+		_, err := service.CreateComment(private.ID, alice.ID, CreateCommentInput{Content: "Hi"})
+		assert.ErrorIs(t, err, ErrCommentGistNotFound)
+		_, _, err = service.ListComments(private.ID, &alice.ID)
+		assert.ErrorIs(t, err, ErrCommentGistNotFound)
+
+		_, err = service.CreateComment(private.ID, owner.ID, CreateCommentInput{Content: "Note to self"})
+		assert.NoError(t, err)
+	})
+
+	t.Run("OnlyTheAuthorEdits", func(t *testing.T) {
+		comment, err := service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "Typo"})
+		require.NoError(t, err)
+
+		_, err = service.UpdateComment(gist.ID, comment.ID, owner.ID, "Vandalized")
+		assert.ErrorIs(t, err, ErrCommentNotAuthor)
+
+		updated, err := service.UpdateComment(gist.ID, comment.ID, alice.ID, "Fixed")
+		require.NoError(t, err)
+		assert.Equal(t, "Fixed", updated.Content)
+		assert.NotNil(t, updated.EditedAt)
+	})
+
+	t.Run("AuthorsOwnersAndAdminsDelete", func(t *testing.T) {
+		byAlice := func() *models.GistComment {
+			comment, err := service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "Delete me"})
+			require.NoError(t, err)
+			return comment
+		}
+
+		comment := byAlice()
+		assert.ErrorIs(t, service.DeleteComment(gist.ID, comment.ID, bob.ID), ErrCommentDeleteDenied)
+		assert.NoError(t, service.DeleteComment(gist.ID, comment.ID, alice.ID))
+		assert.ErrorIs(t, service.DeleteComment(gist.ID, comment.ID, alice.ID), ErrCommentNotFound)
+		assert.NoError(t, service.DeleteComment(gist.ID, byAlice().ID, owner.ID))
+		assert.NoError(t, service.DeleteComment(gist.ID, byAlice().ID, admin.ID))
+
+		// Replies go with the comment they answer
+		comment = byAlice()
+		reply, err := service.CreateComment(gist.ID, bob.ID, CreateCommentInput{Content: "Reply", ParentCommentID: &comment.ID})
+		require.NoError(t, err)
+		require.NoError(t, service.DeleteComment(gist.ID, comment.ID, alice.ID))
+		var count int64
+		db.Model(&models.GistComment{}).Where("id = ?", reply.ID).Count(&count)
+		assert.Zero(t, count)
+	})
+
+	t.Run("Reactions", func(t *testing.T) {
+		comment, err := service.CreateComment(gist.ID, alice.ID, CreateCommentInput{Content: "Nice"})
+		require.NoError(t, err)
+
+		_, err = service.AddReaction(gist.ID, comment.ID, bob.ID, models.ReactionHeart)
+		require.NoError(t, err)
+		// Reacting twice counts once
+		_, err = service.AddReaction(gist.ID, comment.ID, bob.ID, models.ReactionHeart)
+		require.NoError(t, err)
+		reacted, err := service.AddReaction(gist.ID, comment.ID, owner.ID, models.ReactionHeart)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{models.ReactionHeart: 2}, reacted.Reactions)
+		assert.Equal(t, []string{models.ReactionHeart}, reacted.ViewerReactions)
+
+		_, err = service.AddReaction(gist.ID, comment.ID, bob.ID, "party-parrot")
+		assert.ErrorIs(t, err, ErrReactionInvalid)
+
+		reacted, err = service.RemoveReaction(gist.ID, comment.ID, bob.ID, models.ReactionHeart)
+		require.NoError(t, err)
+		assert.Equal(t, map[string]int64{models.ReactionHeart: 1}, reacted.Reactions)
+		assert.Empty(t, reacted.ViewerReactions)
+	})
+}
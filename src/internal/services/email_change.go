@@ -0,0 +1,298 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/mail"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/email"
+)
+
+var (
+	ErrEmailChangeInvalidPassword = errors.New("invalid password")
+	ErrEmailChangeInvalidEmail    = errors.New("invalid email address")
+	ErrEmailChangeSameEmail       = errors.New("new email matches the current email")
+	ErrEmailChangeEmailTaken      = errors.New("email already taken")
+	ErrEmailChangeInvalidToken    = errors.New("invalid or expired link")
+	ErrEmailChangeNotFound        = errors.New("no pending email change")
+)
+
+// EmailChangeService handles verified account email changes.
+//
+// A change is only applied once the new address is confirmed. The old
+// address is then notified and can revert the change during a grace period.
+type EmailChangeService struct {
+	db           *gorm.DB
+	cfg          *config.Config
+	emailService *email.Service
+}
+
+// NewEmailChangeService creates a new email change service
+func NewEmailChangeService(db *gorm.DB, cfg *config.Config, emailService *email.Service) *EmailChangeService {
+	return &EmailChangeService{
+		db:           db,
+		cfg:          cfg,
+		emailService: emailService,
+	}
+}
+
+// RequestChange starts an email change after re-checking the user's password
+// and sends a confirmation link to the new address
+func (s *EmailChangeService) RequestChange(userID uuid.UUID, newEmail, password string) (*models.EmailChangeRequest, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, errors.New("user not found")
+	}
+
+	if !auth.CheckPasswordHash(password, user.PasswordHash) {
+		return nil, ErrEmailChangeInvalidPassword
+	}
+
+	newEmail = strings.TrimSpace(newEmail)
+	addr, err := mail.ParseAddress(newEmail)
+	if err != nil || addr.Address != newEmail {
+		return nil, ErrEmailChangeInvalidEmail
+	}
+	if strings.EqualFold(newEmail, user.Email) {
+		return nil, ErrEmailChangeSameEmail
+	}
+	if s.emailTaken(newEmail, userID) {
+		return nil, ErrEmailChangeEmailTaken
+	}
+
+	confirmToken, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+	// The revert token is only sent once the change is confirmed; until then
+	// the column holds an unguessable placeholder
+	revertToken, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	request := &models.EmailChangeRequest{
+		UserID:           userID,
+		OldEmail:         user.Email,
+		NewEmail:         newEmail,
+		ConfirmTokenHash: auth.HashToken(confirmToken),
+		RevertTokenHash:  auth.HashToken(revertToken),
+		Status:           models.EmailChangePending,
+		ExpiresAt:        time.Now().Add(s.confirmTTL()),
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one pending change per user
+		if err := tx.Model(&models.EmailChangeRequest{}).
+			Where("user_id = ? AND status = ?", userID, models.EmailChangePending).
+			Update("status", models.EmailChangeCancelled).Error; err != nil {
+			return err
+		}
+		return tx.Create(request).Error
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save email change request: %w", err)
+	}
+
+	if s.emailService != nil {
+		confirmURL := fmt.Sprintf("%s/api/v1/user/email/confirm?token=%s", s.baseURL(), confirmToken)
+		if err := s.emailService.SendEmailChangeConfirmation(newEmail, user.Username, confirmURL, request.ExpiresAt); err != nil {
+			slog.Error("Failed to send email change confirmation", "user", userID, "error", err)
+		}
+	}
+
+	return request, nil
+}
+
+// ConfirmChange applies a pending change once the new address is verified,
+// re-routes queued notifications and notifies the old address
+func (s *EmailChangeService) ConfirmChange(token string) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+	if err := s.db.Where("confirm_token_hash = ? AND status = ? AND expires_at > ?",
+		auth.HashToken(token), models.EmailChangePending, time.Now()).First(&request).Error; err != nil {
+		return nil, ErrEmailChangeInvalidToken
+	}
+
+	if s.emailTaken(request.NewEmail, request.UserID) {
+		return nil, ErrEmailChangeEmailTaken
+	}
+
+	revertToken, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token: %w", err)
+	}
+
+	now := time.Now()
+	revertExpiresAt := now.Add(s.revertWindow())
+
+	var user models.User
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		// Only one of two requests using the same link gets past this
+		result := tx.Model(&models.EmailChangeRequest{}).
+			Where("id = ? AND status = ?", request.ID, models.EmailChangePending).
+			Updates(map[string]interface{}{
+				"status":            models.EmailChangeConfirmed,
+				"confirmed_at":      now,
+				"revert_token_hash": auth.HashToken(revertToken),
+				"revert_expires_at": revertExpiresAt,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrEmailChangeInvalidToken
+		}
+
+		if err := tx.First(&user, "id = ?", request.UserID).Error; err != nil {
+			return err
+		}
+		return tx.Model(&user).Updates(map[string]interface{}{
+			"email":             request.NewEmail,
+			"email_verified":    true,
+			"is_email_verified": true,
+		}).Error
+	})
+	if errors.Is(err, ErrEmailChangeInvalidToken) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to apply email change: %w", err)
+	}
+
+	// Notifications are addressed from user.Email at send time; anything
+	// already queued for the old address follows the user to the new one
+	if s.emailService != nil {
+		if err := s.emailService.RerouteQueuedEmails(request.OldEmail, request.NewEmail); err != nil {
+			slog.Error("Failed to re-route queued emails", "user", request.UserID, "error", err)
+		}
+
+		revertURL := fmt.Sprintf("%s/api/v1/user/email/revert?token=%s", s.baseURL(), revertToken)
+		if err := s.emailService.SendEmailChangedNotice(request.OldEmail, request.NewEmail, user.Username, revertURL, revertExpiresAt); err != nil {
+			slog.Error("Failed to send email change notice", "user", request.UserID, "error", err)
+		}
+	}
+
+	request.Status = models.EmailChangeConfirmed
+	request.ConfirmedAt = &now
+	request.RevertExpiresAt = &revertExpiresAt
+	return &request, nil
+}
+
+// RevertChange restores the old address from the link sent to it, and signs
+// out every session since the change may not have been made by the owner
+func (s *EmailChangeService) RevertChange(token string) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+	if err := s.db.Where("revert_token_hash = ? AND status = ? AND revert_expires_at > ?",
+		auth.HashToken(token), models.EmailChangeConfirmed, time.Now()).First(&request).Error; err != nil {
+		return nil, ErrEmailChangeInvalidToken
+	}
+
+	if s.emailTaken(request.OldEmail, request.UserID) {
+		return nil, ErrEmailChangeEmailTaken
+	}
+
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&models.EmailChangeRequest{}).
+			Where("id = ? AND status = ?", request.ID, models.EmailChangeConfirmed).
+			Updates(map[string]interface{}{
+				"status":      models.EmailChangeReverted,
+				"reverted_at": now,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrEmailChangeInvalidToken
+		}
+
+		if err := tx.Model(&models.User{}).Where("id = ?", request.UserID).Updates(map[string]interface{}{
+			"email":             request.OldEmail,
+			"email_verified":    true,
+			"is_email_verified": true,
+		}).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("user_id = ?", request.UserID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+
+		// Cancel any follow-up change started from the (possibly compromised) session
+		return tx.Model(&models.EmailChangeRequest{}).
+			Where("user_id = ? AND status = ?", request.UserID, models.EmailChangePending).
+			Update("status", models.EmailChangeCancelled).Error
+	})
+	if errors.Is(err, ErrEmailChangeInvalidToken) {
+		return nil, err
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to revert email change: %w", err)
+	}
+
+	if s.emailService != nil {
+		if err := s.emailService.RerouteQueuedEmails(request.NewEmail, request.OldEmail); err != nil {
+			slog.Error("Failed to re-route queued emails", "user", request.UserID, "error", err)
+		}
+	}
+
+	request.Status = models.EmailChangeReverted
+	request.RevertedAt = &now
+	return &request, nil
+}
+
+// GetPending returns the user's pending email change, if any
+func (s *EmailChangeService) GetPending(userID uuid.UUID) (*models.EmailChangeRequest, error) {
+	var request models.EmailChangeRequest
+	if err := s.db.Where("user_id = ? AND status = ? AND expires_at > ?",
+		userID, models.EmailChangePending, time.Now()).First(&request).Error; err != nil {
+		return nil, ErrEmailChangeNotFound
+	}
+	return &request, nil
+}
+
+// CancelPending cancels the user's pending email change
+func (s *EmailChangeService) CancelPending(userID uuid.UUID) error {
+	result := s.db.Model(&models.EmailChangeRequest{}).
+		Where("user_id = ? AND status = ?", userID, models.EmailChangePending).
+		Update("status", models.EmailChangeCancelled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrEmailChangeNotFound
+	}
+	return nil
+}
+
+// emailTaken reports whether another user already uses the address
+func (s *EmailChangeService) emailTaken(address string, userID uuid.UUID) bool {
+	var count int64
+	s.db.Model(&models.User{}).Where("LOWER(email) = LOWER(?) AND id != ?", address, userID).Count(&count)
+	return count > 0
+}
+
+func (s *EmailChangeService) baseURL() string {
+	return strings.TrimSuffix(s.cfg.GetString("server.url"), "/")
+}
+
+func (s *EmailChangeService) confirmTTL() time.Duration {
+	if ttl := s.cfg.GetDuration("security.email_change.confirm_ttl"); ttl > 0 {
+		return ttl
+	}
+	return 24 * time.Hour
+}
+
+func (s *EmailChangeService) revertWindow() time.Duration {
+	if window := s.cfg.GetDuration("security.email_change.revert_window"); window > 0 {
+		return window
+	}
+	return 7 * 24 * time.Hour
+}
@@ -0,0 +1,113 @@
+package services
+
+import (
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestEmailChangeService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Session{}, &models.EmailChangeRequest{}))
+
+	service := NewEmailChangeService(db, config.New(), nil)
+
+	hash, err := auth.HashPassword("correct-password")
+	require.NoError(t, err)
+	user := &models.User{Username: "alice", Email: "alice@example.com", PasswordHash: hash}
+	require.NoError(t, db.Create(user).Error)
+	other := &models.User{Username: "bob", Email: "bob@example.com", PasswordHash: hash}
+	require.NoError(t, db.Create(other).Error)
+
+	t.Run("RequestValidation", func(t *testing.T) {
+		_, err := service.RequestChange(user.ID, "new@example.com", "wrong-password")
+		assert.ErrorIs(t, err, ErrEmailChangeInvalidPassword)
+
+		_, err = service.RequestChange(user.ID, "not-an-email", "correct-password")
+		assert.ErrorIs(t, err, ErrEmailChangeInvalidEmail)
+
+		_, err = service.RequestChange(user.ID, "BOB@example.com", "correct-password")
+		assert.ErrorIs(t, err, ErrEmailChangeEmailTaken)
+	})
+
+	t.Run("ConfirmAndRevert", func(t *testing.T) {
+		request, err := service.RequestChange(user.ID, "new@example.com", "correct-password")
+		require.NoError(t, err)
+		assert.Equal(t, models.EmailChangePending, request.Status)
+
+		// Email is unchanged until confirmed
+		var reloaded models.User
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.Equal(t, "alice@example.com", reloaded.Email)
+
+		// Tokens are only delivered by email, so substitute known ones
+		db.Model(request).Update("confirm_token_hash", auth.HashToken("confirm-token"))
+
+		_, err = service.ConfirmChange("wrong-token")
+		assert.ErrorIs(t, err, ErrEmailChangeInvalidToken)
+
+		confirmed, err := service.ConfirmChange("confirm-token")
+		require.NoError(t, err)
+		assert.Equal(t, models.EmailChangeConfirmed, confirmed.Status)
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.Equal(t, "new@example.com", reloaded.Email)
+
+		// Confirm links are single use
+		_, err = service.ConfirmChange("confirm-token")
+		assert.ErrorIs(t, err, ErrEmailChangeInvalidToken)
+
+		db.Create(&models.Session{UserID: user.ID, Token: "t", RefreshToken: "r", ExpiresAt: reloaded.CreatedAt})
+		db.Model(request).Update("revert_token_hash", auth.HashToken("revert-token"))
+
+		reverted, err := service.RevertChange("revert-token")
+		require.NoError(t, err)
+		assert.Equal(t, models.EmailChangeReverted, reverted.Status)
+		db.First(&reloaded, "id = ?", user.ID)
+		assert.Equal(t, "alice@example.com", reloaded.Email)
+
+		var sessions int64
+		db.Model(&models.Session{}).Where("user_id = ?", user.ID).Count(&sessions)
+		assert.Zero(t, sessions)
+	})
+
+	t.Run("ConfirmRace", func(t *testing.T) {
+		request, err := service.RequestChange(user.ID, "third@example.com", "correct-password")
+		require.NoError(t, err)
+		db.Model(request).Update("confirm_token_hash", auth.HashToken("race-token"))
+
+		// A mail scanner following the link confirms the change between
+		// this request looking it up and applying it
+		var once sync.Once
+		require.NoError(t, db.Callback().Query().After("gorm:query").Register("test:confirm_race", func(tx *gorm.DB) {
+			if tx.Statement.Table != "email_change_requests" {
+				return
+			}
+			once.Do(func() {
+				db.Session(&gorm.Session{NewDB: true}).Model(&models.EmailChangeRequest{}).
+					Where("id = ?", request.ID).Update("status", models.EmailChangeConfirmed)
+			})
+		}))
+		defer db.Callback().Query().Remove("test:confirm_race")
+
+		_, err = service.ConfirmChange("race-token")
+		assert.ErrorIs(t, err, ErrEmailChangeInvalidToken)
+	})
+
+	t.Run("Cancel", func(t *testing.T) {
+		_, err := service.RequestChange(user.ID, "other@example.com", "correct-password")
+		require.NoError(t, err)
+
+		_, err = service.GetPending(user.ID)
+		assert.NoError(t, err)
+
+		assert.NoError(t, service.CancelPending(user.ID))
+		assert.ErrorIs(t, service.CancelPending(user.ID), ErrEmailChangeNotFound)
+	})
+}
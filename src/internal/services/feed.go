@@ -0,0 +1,155 @@
+package services
+
+import (
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Feed item types
+const (
+	FeedGistCreated   = "gist_created"
+	FeedGistForked    = "gist_forked"
+	FeedGistStarred   = "gist_starred"
+	FeedGistCommented = "gist_commented"
+)
+
+// MaxFeedItems is the most items one page of the feed holds
+const MaxFeedItems = 100
+
+// FeedItem is something a followed user did with a public gist
+type FeedItem struct {
+	Type      string
+	Actor     models.User
+	Gist      models.Gist
+	CommentID *uuid.UUID // Set for comments
+	CreatedAt time.Time
+}
+
+// FeedService builds a user's home timeline from the people they follow:
+// the public gists they create or fork and the public gists they star or
+// comment on
+type FeedService struct {
+	db *gorm.DB
+}
+
+// NewFeedService creates a new feed service
+func NewFeedService(db *gorm.DB) *FeedService {
+	return &FeedService{db: db}
+}
+
+// Feed returns up to limit of the newest items from before, newest first.
+// Pass the CreatedAt of the last item as before to get the next page.
+func (s *FeedService) Feed(user *models.User, before time.Time, limit int) ([]FeedItem, error) {
+	if limit <= 0 || limit > MaxFeedItems {
+		limit = MaxFeedItems
+	}
+	if before.IsZero() {
+		before = time.Now()
+	}
+
+	followed := s.db.Model(&models.UserFollow{}).Select("following_id").
+		Where("follower_id = ? AND following_id IN (SELECT id FROM users WHERE deactivated_at IS NULL)", user.ID)
+
+	var gists []models.Gist
+	if err := s.publicGists().Select("gists.id", "gists.user_id", "gists.forked_from_id", "gists.created_at").
+		Where("gists.user_id IN (?) AND gists.created_at < ?", followed, before).
+		Order("gists.created_at DESC").Limit(limit).Find(&gists).Error; err != nil {
+		return nil, err
+	}
+
+	var stars []models.GistStar
+	if err := s.db.Where("user_id IN (?) AND gist_id IN (?) AND created_at < ?", followed, s.publicGists().Select("gists.id"), before).
+		Order("created_at DESC").Limit(limit).Find(&stars).Error; err != nil {
+		return nil, err
+	}
+
+	var comments []models.GistComment
+	if err := s.db.Select("id", "gist_id", "user_id", "created_at").
+		Where("user_id IN (?) AND gist_id IN (?) AND created_at < ?", followed, s.publicGists().Select("gists.id"), before).
+		Order("created_at DESC").Limit(limit).Find(&comments).Error; err != nil {
+		return nil, err
+	}
+
+	type event struct {
+		kind      string
+		actorID   uuid.UUID
+		gistID    uuid.UUID
+		commentID *uuid.UUID
+		at        time.Time
+	}
+	events := make([]event, 0, len(gists)+len(stars)+len(comments))
+	for _, gist := range gists {
+		kind := FeedGistCreated
+		if gist.ForkedFromID != nil {
+			kind = FeedGistForked
+		}
+		events = append(events, event{kind, *gist.UserID, gist.ID, nil, gist.CreatedAt})
+	}
+	for _, star := range stars {
+		events = append(events, event{FeedGistStarred, star.UserID, star.GistID, nil, star.CreatedAt})
+	}
+	for _, comment := range comments {
+		id := comment.ID
+		events = append(events, event{FeedGistCommented, comment.UserID, comment.GistID, &id, comment.CreatedAt})
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].at.After(events[j].at)
+	})
+	if len(events) > limit {
+		events = events[:limit]
+	}
+	if len(events) == 0 {
+		return []FeedItem{}, nil
+	}
+
+	gistIDs := make([]uuid.UUID, 0, len(events))
+	actorIDs := make([]uuid.UUID, 0, len(events))
+	for _, e := range events {
+		gistIDs = append(gistIDs, e.gistID)
+		actorIDs = append(actorIDs, e.actorID)
+	}
+	var loaded []models.Gist
+	if err := s.db.Preload("User").Preload("Files").Where("id IN ?", gistIDs).Find(&loaded).Error; err != nil {
+		return nil, err
+	}
+	gistsByID := make(map[uuid.UUID]models.Gist, len(loaded))
+	for _, gist := range loaded {
+		gistsByID[gist.ID] = gist
+	}
+	var actors []models.User
+	if err := s.db.Where("id IN ?", actorIDs).Find(&actors).Error; err != nil {
+		return nil, err
+	}
+	actorsByID := make(map[uuid.UUID]models.User, len(actors))
+	for _, actor := range actors {
+		actorsByID[actor.ID] = actor
+	}
+
+	items := make([]FeedItem, 0, len(events))
+	for _, e := range events {
+		gist, ok := gistsByID[e.gistID]
+		if !ok {
+			continue
+		}
+		items = append(items, FeedItem{
+			Type:      e.kind,
+			Actor:     actorsByID[e.actorID],
+			Gist:      gist,
+			CommentID: e.commentID,
+			CreatedAt: e.at,
+		})
+	}
+	return items, nil
+}
+
+// publicGists selects the gists anyone may see in a feed
+func (s *FeedService) publicGists() *gorm.DB {
+	return s.db.Model(&models.Gist{}).
+		Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated, models.SandboxScope(false)).
+		Where("gists.visibility = ?", models.VisibilityPublic)
+}
@@ -0,0 +1,72 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestFeedService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.GistFile{}, &models.GistStar{}, &models.GistComment{}))
+
+	service := NewFeedService(db)
+
+	reader := &models.User{Username: "reader", Email: "reader@example.com"}
+	require.NoError(t, db.Create(reader).Error)
+	author := &models.User{Username: "author", Email: "author@example.com"}
+	require.NoError(t, db.Create(author).Error)
+	stranger := &models.User{Username: "stranger", Email: "stranger@example.com"}
+	require.NoError(t, db.Create(stranger).Error)
+	require.NoError(t, db.Create(&models.UserFollow{FollowerID: reader.ID, FollowingID: author.ID}).Error)
+
+	start := time.Now().Add(-time.Hour)
+	gist := func(user *models.User, title string, visibility models.Visibility, minutes int) *models.Gist {
+		g := &models.Gist{UserID: &user.ID, Title: title, Visibility: visibility, GitRepoPath: title,
+			CreatedAt: start.Add(time.Duration(minutes) * time.Minute)}
+		require.NoError(t, db.Create(g).Error)
+		return g
+	}
+	created := gist(author, "created", models.VisibilityPublic, 1)
+	gist(author, "private", models.VisibilityPrivate, 2)
+	strangers := gist(stranger, "strangers", models.VisibilityPublic, 3)
+	fork := gist(author, "fork", models.VisibilityPublic, 4)
+	require.NoError(t, db.Model(fork).Update("forked_from_id", strangers.ID).Error)
+	secret := gist(stranger, "secret", models.VisibilityPrivate, 5)
+
+	require.NoError(t, db.Create(&models.GistStar{GistID: strangers.ID, UserID: author.ID, CreatedAt: start.Add(6 * time.Minute)}).Error)
+	require.NoError(t, db.Create(&models.GistStar{GistID: secret.ID, UserID: author.ID, CreatedAt: start.Add(7 * time.Minute)}).Error)
+	require.NoError(t, db.Create(&models.GistStar{GistID: created.ID, UserID: stranger.ID, CreatedAt: start.Add(8 * time.Minute)}).Error)
+	comment := &models.GistComment{GistID: strangers.ID, UserID: author.ID, Content: "Nice", CreatedAt: start.Add(9 * time.Minute)}
+	require.NoError(t, db.Create(comment).Error)
+
+	items, err := service.Feed(reader, time.Time{}, 10)
+	require.NoError(t, err)
+	require.Len(t, items, 4)
+	assert.Equal(t, FeedGistCommented, items[0].Type)
+	assert.Equal(t, comment.ID, *items[0].CommentID)
+	assert.Equal(t, FeedGistStarred, items[1].Type)
+	assert.Equal(t, strangers.ID, items[1].Gist.ID)
+	assert.Equal(t, FeedGistForked, items[2].Type)
+	assert.Equal(t, FeedGistCreated, items[3].Type)
+	assert.Equal(t, "author", items[3].Actor.Username)
+	assert.Equal(t, "author", items[3].Gist.User.Username)
+
+	// Pages continue before the last item
+	page, err := service.Feed(reader, time.Time{}, 2)
+	require.NoError(t, err)
+	require.Len(t, page, 2)
+	next, err := service.Feed(reader, page[1].CreatedAt, 2)
+	require.NoError(t, err)
+	require.Len(t, next, 2)
+	assert.Equal(t, FeedGistForked, next[0].Type)
+
+	// Nobody followed, nothing to show
+	items, err = service.Feed(stranger, time.Time{}, 10)
+	require.NoError(t, err)
+	assert.Empty(t, items)
+}
@@ -4,41 +4,48 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"path/filepath"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
 	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/counters"
+	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/notifications"
 	"github.com/casapps/casgists/src/internal/webhooks"
 )
 
 // GistService handles gist business logic
 type GistService struct {
 	db             *gorm.DB
-	cfg            *viper.Viper
+	cfg            *config.Config
 	cache          *cache.CacheManager
 	webhookService *webhooks.Service
 	emailService   *email.Service
+	notifier       *notifications.Service
 }
 
 // NewGistService creates a new gist service
-func NewGistService(db *gorm.DB, cfg *viper.Viper, cacheManager *cache.CacheManager, emailService *email.Service) *GistService {
+func NewGistService(db *gorm.DB, cfg *config.Config, cacheManager *cache.CacheManager, emailService *email.Service) *GistService {
 	return &GistService{
 		db:             db,
 		cfg:            cfg,
 		cache:          cacheManager,
 		webhookService: webhooks.NewService(db, cfg),
 		emailService:   emailService,
+		notifier:       notifications.NewService(db),
 	}
 }
 
 // CreateGistInput represents input for creating a gist
 type CreateGistInput struct {
+	Name        string
 	Title       string
 	Description string
 	Visibility  models.Visibility
@@ -76,12 +83,34 @@ func (s *GistService) CreateGist(userID uuid.UUID, input CreateGistInput) (*mode
 	// Create gist
 	gist := &models.Gist{
 		UserID:         &userID,
+		Name:           input.Name,
 		Title:          input.Title,
 		Description:    input.Description,
 		Visibility:     input.Visibility,
 		OrganizationID: input.OrgID,
 	}
 
+	// Organization gists need a role that may create them, and are owned by
+	// the organization rather than the user
+	if input.OrgID != nil {
+		var user models.User
+		if err := tx.First(&user, "id = ?", userID).Error; err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		visibility, err := NewOrgPolicyService(tx).AuthorizeGistCreate(*input.OrgID, &user, input.Name, string(input.Visibility))
+		if err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+		gist.UserID = nil
+		gist.Visibility = visibility
+		if err := models.SetOrgSlug(tx, gist, nil, "", ""); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	if err := tx.Create(gist).Error; err != nil {
 		tx.Rollback()
 		return nil, fmt.Errorf("failed to create gist: %w", err)
@@ -181,12 +210,15 @@ type UpdateFileInput struct {
 func (s *GistService) UpdateGist(gistID uuid.UUID, userID uuid.UUID, input UpdateGistInput) (*models.Gist, error) {
 	// Load gist
 	var gist models.Gist
-	if err := s.db.First(&gist, "id = ? AND user_id = ?", gistID, userID).Error; err != nil {
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("gist not found")
 		}
 		return nil, err
 	}
+	if err := s.authorizeGist(&gist, &userID, GistWrite); err != nil {
+		return nil, err
+	}
 
 	// Start transaction
 	tx := s.db.Begin()
@@ -203,6 +235,7 @@ func (s *GistService) UpdateGist(gistID uuid.UUID, userID uuid.UUID, input Updat
 	if input.Description != nil {
 		gist.Description = *input.Description
 	}
+	previousVisibility := gist.Visibility
 	if input.Visibility != nil {
 		gist.Visibility = *input.Visibility
 	}
@@ -270,6 +303,10 @@ func (s *GistService) UpdateGist(gistID uuid.UUID, userID uuid.UUID, input Updat
 				}
 			}
 		}
+		if err := models.RefreshGistStats(tx, gistID); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
 	// Update tags if provided
@@ -314,20 +351,17 @@ func (s *GistService) UpdateGist(gistID uuid.UUID, userID uuid.UUID, input Updat
 		return nil, err
 	}
 
-	// Invalidate cache after update
+	// Invalidate the gist, its owner's lists and search results
 	if s.cache != nil {
-		ctx := context.Background()
-		cacheKey := cache.GistKey(gistID.String())
-		s.cache.Delete(ctx, cacheKey)
-
-		// Also invalidate user gists list cache
-		userCacheKey := cache.UserGistsKey(userID.String())
-		s.cache.Delete(ctx, userCacheKey)
+		cache.NewInvalidator(s.cache).Gist(context.Background(), gistID.String(), userID.String())
 	}
 
 	// Trigger webhook for gist update
 	if s.webhookService != nil {
 		go s.webhookService.TriggerGistUpdated(context.Background(), &gist, userID)
+		if gist.Visibility != previousVisibility {
+			go s.webhookService.TriggerGistVisibilityChanged(context.Background(), &gist, previousVisibility, userID)
+		}
 	}
 
 	return &gist, nil
@@ -343,56 +377,66 @@ func (s *GistService) GetGist(gistID uuid.UUID, userID *uuid.UUID) (*models.Gist
 		var cachedGist models.Gist
 		if err := s.cache.GetJSON(ctx, cacheKey, &cachedGist); err == nil {
 			// Validate visibility for cached gist
-			if userID == nil && cachedGist.Visibility != models.VisibilityPublic {
-				return nil, errors.New("gist not found")
-			}
-			if userID != nil && cachedGist.Visibility != models.VisibilityPublic && cachedGist.UserID != userID {
+			if err := s.authorizeGist(&cachedGist, userID, GistRead); err != nil {
 				return nil, errors.New("gist not found")
 			}
-			// Increment view count asynchronously
-			go s.incrementViewCount(gistID)
+			counters.CountView(s.db, gistID)
 			return &cachedGist, nil
 		}
 	}
 
 	var gist models.Gist
-	query := s.db.Preload("Files").Preload("Tags").Preload("User")
-
-	// Check visibility
-	if userID == nil {
-		// Anonymous user can only see public gists
-		query = query.Where("id = ? AND visibility = ?", gistID, models.VisibilityPublic)
-	} else {
-		// Authenticated user can see public and their own gists
-		query = query.Where("id = ? AND (visibility = ? OR user_id = ?)", gistID, models.VisibilityPublic, *userID)
-	}
-
-	if err := query.First(&gist).Error; err != nil {
+	if err := s.db.Preload("Files").Preload("Tags").Preload("User").First(&gist, "id = ?", gistID).Error; err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("gist not found")
 		}
 		return nil, err
 	}
 
+	// Check visibility: private gists are hidden from everyone who may not read them
+	if err := s.authorizeGist(&gist, userID, GistRead); err != nil {
+		return nil, errors.New("gist not found")
+	}
+
 	// Cache the gist for future requests (only cache public gists or if specifically requested by owner)
 	if s.cache != nil && (gist.Visibility == models.VisibilityPublic || (userID != nil && gist.UserID == userID)) {
-		s.cache.SetJSON(ctx, cacheKey, &gist, cache.TTLMedium)
+		s.cache.SetJSON(ctx, cacheKey, &gist, s.cache.TTL(cache.TierMedium))
 	}
 
-	// Increment view count
-	s.incrementViewCount(gistID)
+	counters.CountView(s.db, gistID)
 
 	return &gist, nil
 }
 
-// incrementViewCount increments the view count for a gist
-func (s *GistService) incrementViewCount(gistID uuid.UUID) {
-	s.db.Model(&models.Gist{}).Where("id = ?", gistID).UpdateColumn("view_count", gorm.Expr("view_count + ?", 1))
+// authorizeGist checks that the user, nil for anonymous requests, may act on
+// the gist. Another user's gist is reported as not found rather than
+// forbidden, so its existence isn't revealed.
+func (s *GistService) authorizeGist(gist *models.Gist, userID *uuid.UUID, action GistAction) error {
+	var user *models.User
+	if userID != nil {
+		var found models.User
+		if err := s.db.First(&found, "id = ?", *userID).Error; err == nil {
+			user = &found
+		}
+	}
+	err := NewOrgPolicyService(s.db).AuthorizeGist(gist, user, action)
+	if errors.Is(err, ErrGistAccessDenied) || errors.Is(err, ErrGistHidden) {
+		return errors.New("gist not found")
+	}
+	return err
 }
 
 // DeleteGist soft deletes a gist
 func (s *GistService) DeleteGist(gistID uuid.UUID, userID uuid.UUID) error {
-	result := s.db.Delete(&models.Gist{}, "id = ? AND user_id = ?", gistID, userID)
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return errors.New("gist not found")
+	}
+	if err := s.authorizeGist(&gist, &userID, GistDelete); err != nil {
+		return err
+	}
+
+	result := s.db.Delete(&gist)
 	if result.RowsAffected == 0 {
 		return errors.New("gist not found")
 	}
@@ -402,15 +446,9 @@ func (s *GistService) DeleteGist(gistID uuid.UUID, userID uuid.UUID) error {
 		go s.webhookService.TriggerGistDeleted(context.Background(), gistID, userID)
 	}
 
-	// Invalidate cache after deletion
+	// Invalidate the gist, its owner's lists and search results
 	if s.cache != nil {
-		ctx := context.Background()
-		cacheKey := cache.GistKey(gistID.String())
-		s.cache.Delete(ctx, cacheKey)
-
-		// Also invalidate user gists list cache
-		userCacheKey := cache.UserGistsKey(userID.String())
-		s.cache.Delete(ctx, userCacheKey)
+		cache.NewInvalidator(s.cache).Gist(context.Background(), gistID.String(), userID.String())
 	}
 
 	return result.Error
@@ -431,7 +469,7 @@ type ListGistsOptions struct {
 
 // ListGists returns a paginated list of gists
 func (s *GistService) ListGists(opts ListGistsOptions, viewerID *uuid.UUID) ([]models.Gist, int64, error) {
-	query := s.db.Model(&models.Gist{})
+	query := database.ReadReplica(s.db).Model(&models.Gist{})
 
 	// Visibility filter
 	if viewerID == nil {
@@ -534,21 +572,19 @@ func (s *GistService) StarGist(gistID uuid.UUID, userID uuid.UUID, star bool) er
 			GistID: gistID,
 			UserID: userID,
 		}
+		// Creating the star increments star_count
 		if err := s.db.Create(star).Error; err != nil {
 			return err
 		}
-
-		// Update star count
-		s.db.Model(&gist).UpdateColumn("star_count", gorm.Expr("star_count + ?", 1))
 	} else {
-		if !s.IsStarred(gistID, userID) {
+		// Remove star; deleting the loaded star decrements star_count
+		var existing models.GistStar
+		if err := s.db.Where("gist_id = ? AND user_id = ?", gistID, userID).First(&existing).Error; err != nil {
 			return errors.New("not starred")
 		}
-		// Remove star
-		s.db.Delete(&models.GistStar{}, "gist_id = ? AND user_id = ?", gistID, userID)
-
-		// Update star count
-		s.db.Model(&gist).UpdateColumn("star_count", gorm.Expr("star_count - ?", 1))
+		if err := s.db.Delete(&existing).Error; err != nil {
+			return err
+		}
 	}
 
 	// Load full gist and user for webhook and email notifications
@@ -561,7 +597,12 @@ func (s *GistService) StarGist(gistID uuid.UUID, userID uuid.UUID, star bool) er
 				go s.webhookService.TriggerGistStarred(context.Background(), &gist, &user, star)
 			}
 
-			// Send email notification for starring (not unstarring)
+			// Notify the owner of stars (not unstarring)
+			if star {
+				if err := s.notifier.GistStarred(&gist, &user); err != nil {
+					slog.Error("Failed to notify about star of gist", "gist", gist.ID, "error", err)
+				}
+			}
 			if s.emailService != nil && star && gist.User != nil && gist.UserID != nil && *gist.UserID != userID {
 				go s.emailService.SendGistStarredNotification(
 					*gist.UserID,
@@ -593,6 +634,11 @@ func (s *GistService) ForkGist(gistID uuid.UUID, userID uuid.UUID) (*models.Gist
 		return nil, errors.New("gist not found")
 	}
 
+	// The owner may have turned forking off
+	if original.ForksDisabled && (original.UserID == nil || *original.UserID != userID) {
+		return nil, errors.New("forking is disabled for this gist")
+	}
+
 	// Check if already forked
 	var count int64
 	s.db.Model(&models.Gist{}).Where("user_id = ? AND forked_from_id = ?", userID, gistID).Count(&count)
@@ -637,6 +683,10 @@ func (s *GistService) ForkGist(gistID uuid.UUID, userID uuid.UUID) (*models.Gist
 			return nil, fmt.Errorf("failed to copy file: %w", err)
 		}
 	}
+	if err := CopyAttachments(tx, original.ID, fork.ID); err != nil {
+		tx.Rollback()
+		return nil, fmt.Errorf("failed to copy attachments: %w", err)
+	}
 
 	// Copy tags
 	for _, tag := range original.Tags {
@@ -663,6 +713,16 @@ func (s *GistService) ForkGist(gistID uuid.UUID, userID uuid.UUID) (*models.Gist
 		return nil, err
 	}
 
+	// Trigger webhook for the fork and notify the original's owner
+	if s.webhookService != nil && fork.User != nil {
+		go s.webhookService.TriggerGistForked(context.Background(), &original, fork, fork.User)
+	}
+	if fork.User != nil {
+		if err := s.notifier.GistForked(&original, fork, fork.User); err != nil {
+			slog.Error("Failed to notify about fork of gist", "gist", original.ID, "error", err)
+		}
+	}
+
 	return fork, nil
 }
 
@@ -0,0 +1,303 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// GistPolicy decides what happens to a user's public gists when their
+// account is deleted
+type GistPolicy string
+
+const (
+	// GistPolicyArchive hands public gists to the archive system user
+	GistPolicyArchive GistPolicy = "archive"
+	// GistPolicyOrganization hands public gists to one of the user's organizations
+	GistPolicyOrganization GistPolicy = "organization"
+	// GistPolicyDelete deletes public gists along with the account
+	GistPolicyDelete GistPolicy = "delete"
+)
+
+var (
+	ErrInvalidGistPolicy        = errors.New("invalid gist policy")
+	ErrGistPolicyNotAllowed     = errors.New("gist policy is not allowed on this instance")
+	ErrGistPolicyOrgRequired    = errors.New("an organization is required for the organization gist policy")
+	ErrGistPolicyNotOrgMember   = errors.New("user is not a member of the organization")
+	ErrGistPolicyArchiveAccount = errors.New("the archive account cannot be deleted")
+)
+
+// GistDisposition is the resolved choice of what to do with a deleted
+// user's public gists
+type GistDisposition struct {
+	Policy         GistPolicy `json:"policy"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+}
+
+// GistDispositionResult counts what happened to a deleted user's gists
+type GistDispositionResult struct {
+	Policy         GistPolicy `json:"policy"`
+	Transferred    int64      `json:"transferred"`
+	Deleted        int64      `json:"deleted"`
+	ArchiveUserID  *uuid.UUID `json:"archive_user_id,omitempty"`
+	OrganizationID *uuid.UUID `json:"organization_id,omitempty"`
+}
+
+// GistDispositionOptions describes the choices offered to a user before
+// they request deletion of their account
+type GistDispositionOptions struct {
+	DefaultPolicy GistPolicy            `json:"default_policy"`
+	Policies      []GistPolicy          `json:"policies"`
+	Organizations []models.Organization `json:"organizations"`
+	PublicGists   int64                 `json:"public_gists"`
+	OtherGists    int64                 `json:"other_gists"`
+}
+
+// GistDispositionService applies the instance's gist policy when an account
+// is deleted, so public gists other people link to don't silently vanish.
+//
+// Only public gists are kept. Private and unlisted gists are always deleted
+// with the account, and gists owned by an organization are not touched.
+type GistDispositionService struct {
+	db  *gorm.DB
+	cfg *config.Config
+}
+
+// NewGistDispositionService creates a new gist disposition service
+func NewGistDispositionService(db *gorm.DB, cfg *config.Config) *GistDispositionService {
+	return &GistDispositionService{
+		db:  db,
+		cfg: cfg,
+	}
+}
+
+// DefaultPolicy returns the instance's configured gist policy
+func (s *GistDispositionService) DefaultPolicy() GistPolicy {
+	policy, err := ParseGistPolicy(s.cfg.GetString("security.deletion.gist_policy"))
+	if err != nil {
+		return GistPolicyArchive
+	}
+	return policy
+}
+
+// AllowedPolicies returns the policies a user may pick from. When user
+// choice is disabled only the instance default is allowed.
+func (s *GistDispositionService) AllowedPolicies() []GistPolicy {
+	if !s.cfg.GetBool("security.deletion.allow_user_choice") {
+		return []GistPolicy{s.DefaultPolicy()}
+	}
+	return []GistPolicy{GistPolicyArchive, GistPolicyOrganization, GistPolicyDelete}
+}
+
+// ArchiveUsername returns the username of the archive system user
+func (s *GistDispositionService) ArchiveUsername() string {
+	if username := s.cfg.GetString("security.deletion.archive_username"); username != "" {
+		return username
+	}
+	return "archive"
+}
+
+// ParseGistPolicy parses a policy name; an empty name is returned as is so
+// callers can fall back to the default
+func ParseGistPolicy(value string) (GistPolicy, error) {
+	switch policy := GistPolicy(strings.ToLower(strings.TrimSpace(value))); policy {
+	case "", GistPolicyArchive, GistPolicyOrganization, GistPolicyDelete:
+		return policy, nil
+	default:
+		return "", fmt.Errorf("%w: %q", ErrInvalidGistPolicy, value)
+	}
+}
+
+// Options returns the choices to show the user before they request deletion
+func (s *GistDispositionService) Options(userID uuid.UUID) (*GistDispositionOptions, error) {
+	options := &GistDispositionOptions{
+		DefaultPolicy: s.DefaultPolicy(),
+		Policies:      s.AllowedPolicies(),
+		Organizations: []models.Organization{},
+	}
+
+	if err := s.db.Model(&models.Organization{}).
+		Joins("JOIN organization_members ON organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userID).
+		Order("organizations.name").
+		Find(&options.Organizations).Error; err != nil {
+		return nil, fmt.Errorf("failed to list organizations: %w", err)
+	}
+
+	s.db.Model(&models.Gist{}).Where("user_id = ? AND visibility = ?", userID, models.VisibilityPublic).Count(&options.PublicGists)
+	s.db.Model(&models.Gist{}).Where("user_id = ? AND visibility != ?", userID, models.VisibilityPublic).Count(&options.OtherGists)
+
+	return options, nil
+}
+
+// Resolve checks a user's choice against the instance configuration. An
+// empty policy falls back to the instance default.
+func (s *GistDispositionService) Resolve(userID uuid.UUID, policyName string, organizationID *uuid.UUID) (GistDisposition, error) {
+	return s.resolve(userID, policyName, organizationID, true)
+}
+
+// ResolveForAdmin is like Resolve, but lets an administrator deleting an
+// account pick any policy even when user choice is disabled
+func (s *GistDispositionService) ResolveForAdmin(userID uuid.UUID, policyName string, organizationID *uuid.UUID) (GistDisposition, error) {
+	return s.resolve(userID, policyName, organizationID, false)
+}
+
+func (s *GistDispositionService) resolve(userID uuid.UUID, policyName string, organizationID *uuid.UUID, restricted bool) (GistDisposition, error) {
+	policy, err := ParseGistPolicy(policyName)
+	if err != nil {
+		return GistDisposition{}, err
+	}
+	if policy == "" {
+		policy = s.DefaultPolicy()
+	}
+
+	if restricted {
+		allowed := false
+		for _, p := range s.AllowedPolicies() {
+			allowed = allowed || p == policy
+		}
+		if !allowed {
+			return GistDisposition{}, ErrGistPolicyNotAllowed
+		}
+	}
+
+	disposition := GistDisposition{Policy: policy}
+	if policy != GistPolicyOrganization {
+		return disposition, nil
+	}
+
+	if organizationID == nil {
+		return GistDisposition{}, ErrGistPolicyOrgRequired
+	}
+	var members int64
+	s.db.Model(&models.OrganizationMember{}).
+		Where("organization_id = ? AND user_id = ?", *organizationID, userID).
+		Count(&members)
+	if members == 0 {
+		return GistDisposition{}, ErrGistPolicyNotOrgMember
+	}
+	disposition.OrganizationID = organizationID
+
+	return disposition, nil
+}
+
+// Apply moves or deletes the user's gists according to the disposition. It
+// runs on the given transaction so it commits or rolls back together with
+// the rest of the account deletion.
+func (s *GistDispositionService) Apply(tx *gorm.DB, userID uuid.UUID, disposition GistDisposition) (*GistDispositionResult, error) {
+	result := &GistDispositionResult{Policy: disposition.Policy}
+
+	public := func() *gorm.DB {
+		return tx.Model(&models.Gist{}).Where("user_id = ? AND visibility = ?", userID, models.VisibilityPublic)
+	}
+
+	var transfer *gorm.DB
+	switch disposition.Policy {
+	case GistPolicyArchive:
+		archive, err := s.archiveUser(tx)
+		if err != nil {
+			return nil, err
+		}
+		if archive.ID == userID {
+			return nil, ErrGistPolicyArchiveAccount
+		}
+		result.ArchiveUserID = &archive.ID
+		transfer = public().Update("user_id", archive.ID)
+	case GistPolicyOrganization:
+		if disposition.OrganizationID == nil {
+			return nil, ErrGistPolicyOrgRequired
+		}
+		result.OrganizationID = disposition.OrganizationID
+		var moved []models.Gist
+		if err := public().Find(&moved).Error; err != nil {
+			return nil, fmt.Errorf("failed to transfer gists: %w", err)
+		}
+		// Gists are owned by a user or an organization, never both
+		transfer = public().Updates(map[string]interface{}{
+			"user_id":         nil,
+			"organization_id": *disposition.OrganizationID,
+		})
+		if transfer.Error == nil {
+			if err := assignOrgSlugs(tx, moved, *disposition.OrganizationID); err != nil {
+				return nil, err
+			}
+		}
+	case GistPolicyDelete:
+	default:
+		return nil, fmt.Errorf("%w: %q", ErrInvalidGistPolicy, disposition.Policy)
+	}
+
+	if transfer != nil {
+		if transfer.Error != nil {
+			return nil, fmt.Errorf("failed to transfer gists: %w", transfer.Error)
+		}
+		result.Transferred = transfer.RowsAffected
+	}
+
+	// Whatever the user still owns now is private, unlisted, or covered by
+	// the delete policy
+	deleted := tx.Where("user_id = ?", userID).Delete(&models.Gist{})
+	if deleted.Error != nil {
+		return nil, fmt.Errorf("failed to delete gists: %w", deleted.Error)
+	}
+	result.Deleted = deleted.RowsAffected
+
+	return result, nil
+}
+
+// archiveUser finds or creates the system user that keeps public gists of
+// deleted accounts. It cannot log in: it is inactive and has no usable
+// password.
+func (s *GistDispositionService) archiveUser(tx *gorm.DB) (*models.User, error) {
+	username := s.ArchiveUsername()
+
+	var user models.User
+	err := tx.Where("username = ?", username).First(&user).Error
+	if err == nil {
+		return &user, nil
+	}
+	if !errors.Is(err, gorm.ErrRecordNotFound) {
+		return nil, fmt.Errorf("failed to look up archive user: %w", err)
+	}
+
+	user = models.User{
+		Username:     username,
+		Email:        username + "@archive.invalid",
+		PasswordHash: "!",
+		DisplayName:  "Archived gists",
+		Bio:          "Public gists kept from deleted accounts",
+	}
+	if err := tx.Create(&user).Error; err != nil {
+		return nil, fmt.Errorf("failed to create archive user: %w", err)
+	}
+	// is_active defaults to true, so it has to be cleared after the insert
+	if err := tx.Model(&user).Update("is_active", false).Error; err != nil {
+		return nil, fmt.Errorf("failed to create archive user: %w", err)
+	}
+	user.IsActive = false
+
+	return &user, nil
+}
+
+// assignOrgSlugs gives gists moved into an organization their /o/:org/:slug
+// addresses
+func assignOrgSlugs(tx *gorm.DB, gists []models.Gist, orgID uuid.UUID) error {
+	for i := range gists {
+		gist := &gists[i]
+		gist.UserID = nil
+		gist.OrganizationID = &orgID
+		if err := models.SetOrgSlug(tx, gist, nil, "", ""); err != nil {
+			return err
+		}
+		if err := tx.Model(gist).Update("slug", gist.Slug).Error; err != nil {
+			return fmt.Errorf("failed to assign gist slug: %w", err)
+		}
+	}
+	return nil
+}
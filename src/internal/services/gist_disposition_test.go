@@ -0,0 +1,133 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistDispositionService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.GistRedirect{}))
+
+	cfg := config.New()
+	cfg.Set("security.deletion.gist_policy", "archive")
+	cfg.Set("security.deletion.allow_user_choice", true)
+	service := NewGistDispositionService(db, cfg)
+
+	org := &models.Organization{Name: "acme"}
+	require.NoError(t, db.Create(org).Error)
+	other := &models.Organization{Name: "other"}
+	require.NoError(t, db.Create(other).Error)
+
+	newUser := func(name string) *models.User {
+		user := &models.User{Username: name, Email: name + "@example.com", PasswordHash: "x", IsActive: true}
+		require.NoError(t, db.Create(user).Error)
+		for _, visibility := range []models.Visibility{models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityUnlisted} {
+			require.NoError(t, db.Create(&models.Gist{Title: string(visibility), UserID: &user.ID, Visibility: visibility}).Error)
+		}
+		return user
+	}
+
+	apply := func(user *models.User, disposition GistDisposition) *GistDispositionResult {
+		var result *GistDispositionResult
+		require.NoError(t, db.Transaction(func(tx *gorm.DB) error {
+			var err error
+			result, err = service.Apply(tx, user.ID, disposition)
+			return err
+		}))
+		return result
+	}
+
+	t.Run("Resolve", func(t *testing.T) {
+		user := newUser("resolver")
+		require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: user.ID}).Error)
+
+		disposition, err := service.Resolve(user.ID, "", nil)
+		require.NoError(t, err)
+		assert.Equal(t, GistPolicyArchive, disposition.Policy)
+
+		_, err = service.Resolve(user.ID, "bogus", nil)
+		assert.ErrorIs(t, err, ErrInvalidGistPolicy)
+
+		_, err = service.Resolve(user.ID, "organization", nil)
+		assert.ErrorIs(t, err, ErrGistPolicyOrgRequired)
+
+		_, err = service.Resolve(user.ID, "organization", &other.ID)
+		assert.ErrorIs(t, err, ErrGistPolicyNotOrgMember)
+
+		disposition, err = service.Resolve(user.ID, "organization", &org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, org.ID, *disposition.OrganizationID)
+
+		options, err := service.Options(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), options.PublicGists)
+		assert.Equal(t, int64(2), options.OtherGists)
+		require.Len(t, options.Organizations, 1)
+		assert.Equal(t, "acme", options.Organizations[0].Name)
+
+		cfg.Set("security.deletion.allow_user_choice", false)
+		defer cfg.Set("security.deletion.allow_user_choice", true)
+		_, err = service.Resolve(user.ID, "delete", nil)
+		assert.ErrorIs(t, err, ErrGistPolicyNotAllowed)
+		_, err = service.ResolveForAdmin(user.ID, "delete", nil)
+		assert.NoError(t, err)
+	})
+
+	t.Run("Archive", func(t *testing.T) {
+		first := newUser("first")
+		result := apply(first, GistDisposition{Policy: GistPolicyArchive})
+		assert.Equal(t, int64(1), result.Transferred)
+		assert.Equal(t, int64(2), result.Deleted)
+		require.NotNil(t, result.ArchiveUserID)
+
+		var archive models.User
+		require.NoError(t, db.First(&archive, "id = ?", *result.ArchiveUserID).Error)
+		assert.Equal(t, "archive", archive.Username)
+		assert.False(t, archive.IsActive)
+
+		var kept models.Gist
+		require.NoError(t, db.First(&kept, "user_id = ?", archive.ID).Error)
+		assert.Equal(t, models.VisibilityPublic, kept.Visibility)
+
+		// The archive user is reused for later deletions
+		second := newUser("second")
+		result = apply(second, GistDisposition{Policy: GistPolicyArchive})
+		assert.Equal(t, archive.ID, *result.ArchiveUserID)
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			_, err := service.Apply(tx, archive.ID, GistDisposition{Policy: GistPolicyArchive})
+			return err
+		})
+		assert.ErrorIs(t, err, ErrGistPolicyArchiveAccount)
+	})
+
+	t.Run("Organization", func(t *testing.T) {
+		user := newUser("member")
+		result := apply(user, GistDisposition{Policy: GistPolicyOrganization, OrganizationID: &org.ID})
+		assert.Equal(t, int64(1), result.Transferred)
+		assert.Equal(t, int64(2), result.Deleted)
+
+		var gist models.Gist
+		require.NoError(t, db.First(&gist, "organization_id = ?", org.ID).Error)
+		assert.Nil(t, gist.UserID)
+		assert.NotEmpty(t, gist.SlugValue())
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		user := newUser("leaver")
+		result := apply(user, GistDisposition{Policy: GistPolicyDelete})
+		assert.Equal(t, int64(0), result.Transferred)
+		assert.Equal(t, int64(3), result.Deleted)
+
+		var remaining int64
+		db.Model(&models.Gist{}).Where("user_id = ?", user.ID).Count(&remaining)
+		assert.Equal(t, int64(0), remaining)
+	})
+}
@@ -0,0 +1,134 @@
+package services
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// ErrInvalidCursor is returned for a cursor that doesn't come from the same
+// list and sort
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// gistSortColumns are the orders gist lists can be sorted in, newest or
+// most starred first. created is the default.
+var gistSortColumns = map[string]string{
+	"created": "created_at",
+	"updated": "updated_at",
+	"stars":   "star_count",
+}
+
+// GistSort returns the sort a list asked for, or created for an unknown one
+func GistSort(sort string) string {
+	if _, ok := gistSortColumns[sort]; ok {
+		return sort
+	}
+	return "created"
+}
+
+// GistCursor is the last gist of a page; the next page starts after it
+type GistCursor struct {
+	Sort string    `json:"s"`
+	Key  string    `json:"k"` // The sort column of the gist, a timestamp or a star count
+	ID   uuid.UUID `json:"i"`
+}
+
+// String encodes the cursor for the cursor query parameter
+func (c GistCursor) String() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// ParseGistCursor decodes a cursor made by GistCursor.String for sort
+func ParseGistCursor(s, sort string) (*GistCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	var cursor GistCursor
+	if err := json.Unmarshal(data, &cursor); err != nil || cursor.Sort != sort || cursor.ID == uuid.Nil {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := cursor.key(); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return &cursor, nil
+}
+
+// key returns the sort column value the cursor was made at
+func (c GistCursor) key() (interface{}, error) {
+	if c.Sort == "stars" {
+		return strconv.Atoi(c.Key)
+	}
+	return time.Parse(time.RFC3339Nano, c.Key)
+}
+
+// gistCursor returns the cursor pointing after gist
+func gistCursor(gist *models.Gist, sort string) GistCursor {
+	cursor := GistCursor{Sort: sort, ID: gist.ID}
+	switch sort {
+	case "stars":
+		cursor.Key = strconv.Itoa(gist.StarCount)
+	case "updated":
+		cursor.Key = gist.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		cursor.Key = gist.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+	return cursor
+}
+
+// GistPage is one page of a gist list
+type GistPage struct {
+	Gists      []models.Gist
+	NextCursor string // Empty on the last page
+}
+
+// PageGists returns the page of up to limit gists of query that follows
+// cursor, nil for the first page. Pages are walked by the sort column and
+// the gist ID rather than skipped over with OFFSET, so every page is as
+// quick as the first and gists added meanwhile don't shift them.
+func PageGists(query *gorm.DB, sort string, cursor *GistCursor, limit int) (*GistPage, error) {
+	sort = GistSort(sort)
+	column := "gists." + gistSortColumns[sort]
+
+	if cursor != nil {
+		key, err := cursor.key()
+		if err != nil || cursor.Sort != sort {
+			return nil, ErrInvalidCursor
+		}
+		query = query.Where("("+column+" < ? OR ("+column+" = ? AND gists.id < ?))", key, key, cursor.ID)
+	}
+
+	// One more than the page tells whether there is a next page
+	var gists []models.Gist
+	if err := OrderGists(query, sort).Limit(limit + 1).Find(&gists).Error; err != nil {
+		return nil, err
+	}
+
+	page := &GistPage{Gists: gists}
+	if len(gists) > limit {
+		page.Gists = gists[:limit]
+		page.NextCursor = gistCursor(&page.Gists[limit-1], sort).String()
+	}
+	return page, nil
+}
+
+// OrderGists orders query by sort the way PageGists does, for lists still
+// paged by number
+func OrderGists(query *gorm.DB, sort string) *gorm.DB {
+	column := "gists." + gistSortColumns[GistSort(sort)]
+	return query.Order(column + " DESC").Order("gists.id DESC")
+}
+
+// ListedUser is a Preload condition loading only the columns gist lists
+// show of the owner
+func ListedUser(db *gorm.DB) *gorm.DB {
+	return db.Select("id", "username", "email", "display_name", "avatar_url", "is_admin", "deactivated_at")
+}
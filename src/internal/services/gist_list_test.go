@@ -0,0 +1,93 @@
+package services
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestPageGists(t *testing.T) {
+	db := setupTestDB(t)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+
+	// Pairs of gists share a creation time, so pages have to break ties by ID
+	base := time.Date(2026, 1, 1, 12, 0, 0, 123456000, time.UTC)
+	for i := 0; i < 7; i++ {
+		gist := &models.Gist{
+			UserID:      &owner.ID,
+			Title:       fmt.Sprintf("gist %d", i),
+			GitRepoPath: fmt.Sprintf("gist-%d", i),
+			Visibility:  models.VisibilityPublic,
+			StarCount:   i % 3,
+			CreatedAt:   base.Add(time.Duration(i/2) * time.Minute),
+		}
+		require.NoError(t, db.Create(gist).Error)
+	}
+
+	walk := func(sort string) []models.Gist {
+		var all []models.Gist
+		var cursor *GistCursor
+		for pages := 0; pages < 10; pages++ {
+			page, err := PageGists(db.Model(&models.Gist{}), sort, cursor, 3)
+			require.NoError(t, err)
+			all = append(all, page.Gists...)
+			if page.NextCursor == "" {
+				return all
+			}
+			cursor, err = ParseGistCursor(page.NextCursor, GistSort(sort))
+			require.NoError(t, err)
+		}
+		t.Fatal("pages never ended")
+		return nil
+	}
+
+	t.Run("newest first", func(t *testing.T) {
+		var ordered []models.Gist
+		require.NoError(t, OrderGists(db, "created").Find(&ordered).Error)
+
+		all := walk("")
+		require.Len(t, all, 7)
+		seen := map[uuid.UUID]bool{}
+		for i, gist := range all {
+			assert.False(t, seen[gist.ID], "gist %s listed twice", gist.Title)
+			seen[gist.ID] = true
+			assert.Equal(t, ordered[i].ID, gist.ID)
+		}
+		assert.Equal(t, "gist 6", all[0].Title)
+	})
+
+	t.Run("most starred first", func(t *testing.T) {
+		all := walk("stars")
+		require.Len(t, all, 7)
+		for i := 1; i < len(all); i++ {
+			assert.GreaterOrEqual(t, all[i-1].StarCount, all[i].StarCount)
+		}
+	})
+
+	t.Run("the last page has no cursor", func(t *testing.T) {
+		page, err := PageGists(db.Model(&models.Gist{}), "created", nil, 7)
+		require.NoError(t, err)
+		assert.Len(t, page.Gists, 7)
+		assert.Empty(t, page.NextCursor)
+	})
+
+	t.Run("cursors are checked", func(t *testing.T) {
+		page, err := PageGists(db.Model(&models.Gist{}), "created", nil, 3)
+		require.NoError(t, err)
+
+		_, err = ParseGistCursor(page.NextCursor, "stars")
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, err = ParseGistCursor("not a cursor", "created")
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+		_, err = ParseGistCursor(GistCursor{Sort: "created", Key: "yesterday", ID: uuid.New()}.String(), "created")
+		assert.ErrorIs(t, err, ErrInvalidCursor)
+	})
+}
@@ -0,0 +1,163 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Share link limits
+const (
+	MaxActiveSharesPerGist = 50
+	MaxShareLabelLength    = 100
+	shareTokenPrefix       = "gs_"
+)
+
+var (
+	ErrShareGistNotFound = errors.New("gist not found")
+	ErrShareNotFound     = errors.New("share link not found")
+	ErrShareInvalid      = errors.New("share link is invalid, expired or revoked")
+	ErrShareExpiry       = errors.New("expiry must be in the future")
+	ErrShareLabel        = fmt.Errorf("label must be at most %d characters", MaxShareLabelLength)
+	ErrShareLimit        = fmt.Errorf("a gist can have at most %d active share links", MaxActiveSharesPerGist)
+)
+
+// GistShareService manages share links, which let anyone holding their
+// token read a gist without it being made public or unlisted
+type GistShareService struct {
+	db *gorm.DB
+}
+
+// NewGistShareService creates a new share link service
+func NewGistShareService(db *gorm.DB) *GistShareService {
+	return &GistShareService{db: db}
+}
+
+// CreateShareInput describes a new share link
+type CreateShareInput struct {
+	Label     string
+	ExpiresAt *time.Time // Nil for a link that doesn't expire
+}
+
+// managedGist returns the gist if the user manages its share links: its
+// owner, or the owners and admins of the organization owning it
+func (s *GistShareService) managedGist(gistID uuid.UUID, user *models.User) (*models.Gist, error) {
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, ErrShareGistNotFound
+	}
+	policy := NewOrgPolicyService(s.db)
+	if err := policy.AuthorizeGist(&gist, user, GistDelete); err != nil {
+		// Gists the user can't see are reported as not found
+		if policy.AuthorizeGist(&gist, user, GistRead) != nil {
+			return nil, ErrShareGistNotFound
+		}
+		return nil, err
+	}
+	return &gist, nil
+}
+
+// CreateShare creates a share link and returns it with its token, which is
+// only available now
+func (s *GistShareService) CreateShare(gistID uuid.UUID, user *models.User, input CreateShareInput) (*models.GistShare, string, error) {
+	gist, err := s.managedGist(gistID, user)
+	if err != nil {
+		return nil, "", err
+	}
+	input.Label = strings.TrimSpace(input.Label)
+	if len(input.Label) > MaxShareLabelLength {
+		return nil, "", ErrShareLabel
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrShareExpiry
+	}
+
+	var active int64
+	if err := s.db.Model(&models.GistShare{}).
+		Where("gist_id = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", gist.ID, time.Now()).
+		Count(&active).Error; err != nil {
+		return nil, "", err
+	}
+	if active >= MaxActiveSharesPerGist {
+		return nil, "", ErrShareLimit
+	}
+
+	secret, err := auth.GenerateSecureToken(32)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate token: %w", err)
+	}
+	token := shareTokenPrefix + strings.TrimRight(secret, "=")
+	share := &models.GistShare{
+		GistID:      gist.ID,
+		CreatedByID: user.ID,
+		Label:       input.Label,
+		TokenHash:   auth.HashToken(token),
+		TokenPrefix: token[:8],
+		ExpiresAt:   input.ExpiresAt,
+	}
+	if err := s.db.Create(share).Error; err != nil {
+		return nil, "", err
+	}
+	return share, token, nil
+}
+
+// ListShares returns a gist's share links, newest first, including expired
+// and revoked ones
+func (s *GistShareService) ListShares(gistID uuid.UUID, user *models.User) ([]models.GistShare, error) {
+	gist, err := s.managedGist(gistID, user)
+	if err != nil {
+		return nil, err
+	}
+	shares := []models.GistShare{}
+	err = s.db.Where("gist_id = ?", gist.ID).Order("created_at DESC").Find(&shares).Error
+	return shares, err
+}
+
+// RevokeShare stops a share link from granting access. Revoking it again
+// changes nothing.
+func (s *GistShareService) RevokeShare(gistID, shareID uuid.UUID, user *models.User) (*models.GistShare, error) {
+	gist, err := s.managedGist(gistID, user)
+	if err != nil {
+		return nil, err
+	}
+	var share models.GistShare
+	if err := s.db.First(&share, "id = ? AND gist_id = ?", shareID, gist.ID).Error; err != nil {
+		return nil, ErrShareNotFound
+	}
+	if share.RevokedAt == nil {
+		now := time.Now()
+		if err := s.db.Model(&share).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+		share.RevokedAt = &now
+	}
+	return &share, nil
+}
+
+// Redeem checks a token against the gist's active share links and records
+// the use
+func (s *GistShareService) Redeem(gistID uuid.UUID, token string) (*models.GistShare, error) {
+	if !strings.HasPrefix(token, shareTokenPrefix) {
+		return nil, ErrShareInvalid
+	}
+	var share models.GistShare
+	if err := s.db.First(&share, "token_hash = ? AND gist_id = ?", auth.HashToken(token), gistID).Error; err != nil {
+		return nil, ErrShareInvalid
+	}
+	now := time.Now()
+	if !share.Active(now) {
+		return nil, ErrShareInvalid
+	}
+	s.db.Model(&share).UpdateColumns(map[string]interface{}{
+		"last_used_at": now,
+		"use_count":    gorm.Expr("use_count + 1"),
+	})
+	return &share, nil
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistShareService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{},
+		&models.GistShare{}))
+
+	service := NewGistShareService(db)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	private := &models.Gist{UserID: &owner.ID, Title: "Secret", Visibility: models.VisibilityPrivate, GitRepoPath: "secret"}
+	require.NoError(t, db.Create(private).Error)
+	public := &models.Gist{UserID: &owner.ID, Title: "Notes", Visibility: models.VisibilityPublic, GitRepoPath: "notes"}
+	require.NoError(t, db.Create(public).Error)
+
+	t.Run("Redeem", func(t *testing.T) {
+		share, token, err := service.CreateShare(private.ID, owner, CreateShareInput{Label: " Reviewer "})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(token, "gs_"))
+		assert.Equal(t, token[:8], share.TokenPrefix)
+		assert.Equal(t, "Reviewer", share.Label)
+		assert.NotEqual(t, token, share.TokenHash)
+
+		redeemed, err := service.Redeem(private.ID, token)
+		require.NoError(t, err)
+		assert.Equal(t, share.ID, redeemed.ID)
+
+		// Tokens open only their own gist
+		_, err = service.Redeem(public.ID, token)
+		assert.ErrorIs(t, err, ErrShareInvalid)
+		_, err = service.Redeem(private.ID, token+"x")
+		assert.ErrorIs(t, err, ErrShareInvalid)
+
+		var stored models.GistShare
+		require.NoError(t, db.First(&stored, "id = ?", share.ID).Error)
+		assert.EqualValues(t, 1, stored.UseCount)
+		assert.NotNil(t, stored.LastUsedAt)
+	})
+
+	t.Run("ExpiryAndRevocation", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		_, _, err := service.CreateShare(private.ID, owner, CreateShareInput{ExpiresAt: &past})
+		assert.ErrorIs(t, err, ErrShareExpiry)
+
+		soon := time.Now().Add(time.Hour)
+		expiring, token, err := service.CreateShare(private.ID, owner, CreateShareInput{ExpiresAt: &soon})
+		require.NoError(t, err)
+		_, err = service.Redeem(private.ID, token)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(expiring).Update("expires_at", past).Error)
+		_, err = service.Redeem(private.ID, token)
+		assert.ErrorIs(t, err, ErrShareInvalid)
+
+		revoked, token, err := service.CreateShare(private.ID, owner, CreateShareInput{})
+		require.NoError(t, err)
+		share, err := service.RevokeShare(private.ID, revoked.ID, owner)
+		require.NoError(t, err)
+		assert.NotNil(t, share.RevokedAt)
+		_, err = service.Redeem(private.ID, token)
+		assert.ErrorIs(t, err, ErrShareInvalid)
+
+		shares, err := service.ListShares(private.ID, owner)
+		require.NoError(t, err)
+		assert.Len(t, shares, 3)
+	})
+
+	t.Run("OnlyManagersShare", func(t *testing.T) {
+		// Private gists of others don't exist as far as the user knows
+		_, _, err := service.CreateShare(private.ID, alice, CreateShareInput{})
+		assert.ErrorIs(t, err, ErrShareGistNotFound)
+		_, err = service.ListShares(private.ID, nil)
+		assert.ErrorIs(t, err, ErrShareGistNotFound)
+
+		_, _, err = service.CreateShare(public.ID, alice, CreateShareInput{})
+		assert.ErrorIs(t, err, ErrGistAccessDenied)
+	})
+
+	t.Run("Limit", func(t *testing.T) {
+		gist := &models.Gist{UserID: &owner.ID, Title: "Popular", Visibility: models.VisibilityPrivate, GitRepoPath: "popular"}
+		require.NoError(t, db.Create(gist).Error)
+		for i := 0; i < MaxActiveSharesPerGist; i++ {
+			_, _, err := service.CreateShare(gist.ID, owner, CreateShareInput{})
+			require.NoError(t, err)
+		}
+		_, _, err := service.CreateShare(gist.ID, owner, CreateShareInput{})
+		assert.ErrorIs(t, err, ErrShareLimit)
+	})
+}
@@ -0,0 +1,90 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistFileStats(t *testing.T) {
+	prose := &models.GistFile{Filename: "README.md", Content: strings.Repeat("word ", 400)}
+	prose.ComputeStats()
+	assert.Equal(t, 400, prose.WordCount)
+	assert.Equal(t, 120, prose.ReadTimeSeconds)
+	assert.Zero(t, prose.Complexity)
+
+	code := &models.GistFile{Filename: "main.go", Language: "go", Content: "if a && b {\n\tfor x := range y {}\n} // iffy\nz := c ? 1 : 2"}
+	code.ComputeStats()
+	assert.Zero(t, code.WordCount)
+	assert.Equal(t, 5, code.Complexity)
+	assert.Equal(t, 4, code.Lines)
+	assert.Positive(t, code.ReadTimeSeconds)
+}
+
+func TestGistStatsStored(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.GistFile{}))
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+
+	gist := &models.Gist{UserID: &owner.ID, Title: "Notes", GitRepoPath: "notes", Files: []models.GistFile{
+		{Filename: "notes.md", Content: "one two three"},
+		{Filename: "run.sh", Content: "if true; then echo; fi"},
+	}}
+	require.NoError(t, db.Create(gist).Error)
+	assert.Equal(t, int64(35), gist.TotalSize)
+	assert.Equal(t, 3, gist.WordCount)
+
+	reload := func() models.Gist {
+		var stored models.Gist
+		require.NoError(t, db.First(&stored, "id = ?", gist.ID).Error)
+		return stored
+	}
+	assert.Equal(t, int64(35), reload().TotalSize)
+
+	// Lists show the file summary, ordered by file name
+	summary := reload().FileSummary
+	require.Len(t, summary, 2)
+	assert.Equal(t, "notes.md", summary[0].Filename)
+	assert.Equal(t, gist.Files[0].ID, summary[0].ID)
+	assert.Equal(t, int64(22), summary[1].Size)
+
+	// Saving a gist loaded before its files changed keeps the summary
+	stale := reload()
+	stale.FileSummary = nil
+	stale.Title = "Renamed"
+	require.NoError(t, db.Save(&stale).Error)
+	assert.Len(t, reload().FileSummary, 2)
+
+	// Partial updates of a file keep the stored stats in step
+	file := gist.Files[0]
+	file.Content = "one two three four five"
+	require.NoError(t, db.Model(&file).Select("content", "size", "lines", "word_count", "read_time_seconds", "complexity").Updates(&file).Error)
+	assert.Equal(t, 5, reload().WordCount)
+	assert.Equal(t, int64(45), reload().TotalSize)
+
+	require.NoError(t, db.Where("gist_id = ?", gist.ID).Delete(&models.GistFile{}).Error)
+	require.NoError(t, models.RefreshGistStats(db, gist.ID))
+	assert.Zero(t, reload().TotalSize)
+	assert.NotNil(t, reload().FileSummary)
+	assert.Empty(t, reload().FileSummary)
+
+	// Files stored before stats were tracked are backfilled
+	legacy := models.GistFile{GistID: gist.ID, Filename: "old.txt", Content: "a b c d"}
+	require.NoError(t, db.Create(&legacy).Error)
+	require.NoError(t, db.Exec("UPDATE gist_files SET read_time_seconds = NULL, word_count = 0 WHERE id = ?", legacy.ID).Error)
+	require.NoError(t, models.BackfillGistStats(db))
+	assert.Equal(t, 4, reload().WordCount)
+
+	// So are the file summaries of gists stored before they were kept
+	require.NoError(t, db.Exec("UPDATE gists SET file_summary = NULL").Error)
+	require.NoError(t, models.BackfillFileSummaries(db))
+	summary = reload().FileSummary
+	require.Len(t, summary, 1)
+	assert.Equal(t, "old.txt", summary[0].Filename)
+}
@@ -5,12 +5,12 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
@@ -28,6 +28,7 @@ func setupGistTestDB(t *testing.T) *gorm.DB {
 		&models.GistStar{},
 		&models.Tag{},
 		&models.GistTag{},
+		&models.GistAttachment{},
 	)
 	require.NoError(t, err)
 
@@ -36,7 +37,7 @@ func setupGistTestDB(t *testing.T) *gorm.DB {
 
 func TestGistService(t *testing.T) {
 	db := setupGistTestDB(t)
-	cfg := viper.New()
+	cfg := config.New()
 	
 	gistService := NewGistService(db, cfg, nil, nil) // nil cache and email service for testing
 	require.NotNil(t, gistService)
@@ -233,6 +234,70 @@ func TestGistService(t *testing.T) {
 		assert.Contains(t, err.Error(), "already forked")
 	})
 
+	t.Run("ForkGistDisabled", func(t *testing.T) {
+		input := CreateGistInput{
+			Title:      "No Forks Gist",
+			Visibility: models.VisibilityPublic,
+			Files: []CreateFileInput{
+				{Filename: "keep.txt", Content: "Mine"},
+			},
+		}
+		gist, err := gistService.CreateGist(user.ID, input)
+		require.NoError(t, err)
+		require.NoError(t, db.Model(gist).Update("forks_disabled", true).Error)
+
+		user2 := &models.User{Username: "blocked-forker", Email: "blocked-forker@example.com"}
+		require.NoError(t, db.Create(user2).Error)
+
+		_, err = gistService.ForkGist(gist.ID, user2.ID)
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "forking is disabled")
+	})
+
+	t.Run("OrganizationRoles", func(t *testing.T) {
+		require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{},
+			&models.OrganizationSettings{}, &models.GistRedirect{}))
+
+		org := &models.Organization{Name: "gist-org"}
+		require.NoError(t, db.Create(org).Error)
+		roles := map[string]*models.User{}
+		for _, role := range []string{models.OrgRoleOwner, models.OrgRoleMember, models.OrgRoleViewer} {
+			member := &models.User{Username: "org-" + role, Email: role + "@org.example.com"}
+			require.NoError(t, db.Create(member).Error)
+			require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: member.ID, Role: role}).Error)
+			roles[role] = member
+		}
+
+		input := CreateGistInput{
+			Title:      "Runbook",
+			Visibility: models.VisibilityPrivate,
+			Files:      []CreateFileInput{{Filename: "runbook.md", Content: "# Runbook"}},
+			OrgID:      &org.ID,
+		}
+		_, err := gistService.CreateGist(roles[models.OrgRoleViewer].ID, input)
+		assert.ErrorIs(t, err, ErrOrgViewerReadOnly)
+
+		gist, err := gistService.CreateGist(roles[models.OrgRoleMember].ID, input)
+		require.NoError(t, err)
+		assert.Nil(t, gist.UserID)
+		assert.Equal(t, "runbook", gist.SlugValue())
+
+		_, err = gistService.GetGist(gist.ID, &roles[models.OrgRoleViewer].ID)
+		assert.NoError(t, err)
+		_, err = gistService.GetGist(gist.ID, &user.ID)
+		assert.Error(t, err)
+
+		title := "Incident runbook"
+		_, err = gistService.UpdateGist(gist.ID, roles[models.OrgRoleViewer].ID, UpdateGistInput{Title: &title})
+		assert.ErrorIs(t, err, ErrOrgViewerReadOnly)
+		updated, err := gistService.UpdateGist(gist.ID, roles[models.OrgRoleMember].ID, UpdateGistInput{Title: &title})
+		require.NoError(t, err)
+		assert.Equal(t, title, updated.Title)
+
+		assert.ErrorIs(t, gistService.DeleteGist(gist.ID, roles[models.OrgRoleMember].ID), ErrOrgAdminRequired)
+		assert.NoError(t, gistService.DeleteGist(gist.ID, roles[models.OrgRoleOwner].ID))
+	})
+
 	t.Run("UpdateGist", func(t *testing.T) {
 		// Create a gist
 		input := CreateGistInput{
@@ -0,0 +1,85 @@
+package services
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/trash"
+)
+
+var ErrTrashGistNotFound = errors.New("gist not found in the trash")
+
+// TrashedGist is a deleted gist that can still be restored
+type TrashedGist struct {
+	Gist    models.Gist
+	PurgeAt *time.Time // When the gist goes for good, nil while retention is off
+}
+
+// GistTrashService lists and restores soft-deleted gists. Users see their
+// own deleted gists and those of organizations they administer; expired
+// and sandbox gists are never restorable.
+type GistTrashService struct {
+	db     *gorm.DB
+	cfg    *config.Config
+	policy *OrgPolicyService
+}
+
+// NewGistTrashService creates a new trash service
+func NewGistTrashService(db *gorm.DB, cfg *config.Config) *GistTrashService {
+	return &GistTrashService{db: db, cfg: cfg, policy: NewOrgPolicyService(db)}
+}
+
+// trashed selects the deleted gists that can be restored
+func (s *GistTrashService) trashed() *gorm.DB {
+	return s.db.Unscoped().Model(&models.Gist{}).
+		Where("gists.deleted_at IS NOT NULL AND gists.ephemeral = ?", false).
+		Where("gists.expires_at IS NULL OR gists.expires_at > ?", time.Now())
+}
+
+// List returns the user's deleted gists, most recently deleted first
+func (s *GistTrashService) List(user *models.User) ([]TrashedGist, error) {
+	adminOrgs := s.db.Model(&models.OrganizationMember{}).Select("organization_id").
+		Where("user_id = ? AND role IN ?", user.ID, []string{models.OrgRoleOwner, models.OrgRoleAdmin})
+
+	var gists []models.Gist
+	if err := s.trashed().Preload("Files").
+		Where("gists.user_id = ? OR gists.organization_id IN (?)", user.ID, adminOrgs).
+		Order("gists.deleted_at DESC").Find(&gists).Error; err != nil {
+		return nil, err
+	}
+
+	retention := trash.Retention(s.cfg)
+	trashed := make([]TrashedGist, 0, len(gists))
+	for _, gist := range gists {
+		item := TrashedGist{Gist: gist}
+		if retention > 0 {
+			purgeAt := gist.DeletedAt.Time.Add(retention)
+			item.PurgeAt = &purgeAt
+		}
+		trashed = append(trashed, item)
+	}
+	return trashed, nil
+}
+
+// Restore takes a gist out of the trash. Only those who may delete the gist
+// may restore it; to anyone else it isn't in the trash.
+func (s *GistTrashService) Restore(gistID uuid.UUID, user *models.User) (*models.Gist, error) {
+	var gist models.Gist
+	if err := s.trashed().First(&gist, "gists.id = ?", gistID).Error; err != nil {
+		return nil, ErrTrashGistNotFound
+	}
+	if err := s.policy.AuthorizeGist(&gist, user, GistDelete); err != nil {
+		return nil, ErrTrashGistNotFound
+	}
+
+	if err := s.db.Unscoped().Model(&gist).Update("deleted_at", nil).Error; err != nil {
+		return nil, err
+	}
+	gist.DeletedAt = gorm.DeletedAt{}
+	return &gist, nil
+}
@@ -0,0 +1,89 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestGistTrashService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{}, &models.GistFile{}))
+
+	cfg := config.New()
+	cfg.Set("gists.trash.retention_days", 30)
+	service := NewGistTrashService(db, cfg)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	acme := &models.Organization{Name: "acme", IsPublic: true}
+	require.NoError(t, db.Create(acme).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: acme.ID, UserID: owner.ID, Role: models.OrgRoleAdmin}).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: acme.ID, UserID: alice.ID, Role: models.OrgRoleMember}).Error)
+
+	deleted := func(gist *models.Gist) *models.Gist {
+		gist.GitRepoPath = gist.Title
+		require.NoError(t, db.Create(gist).Error)
+		require.NoError(t, db.Delete(gist).Error)
+		return gist
+	}
+	mine := deleted(&models.Gist{UserID: &owner.ID, Title: "mine"})
+	orgGist := deleted(&models.Gist{OrganizationID: &acme.ID, Title: "org"})
+	past := time.Now().Add(-time.Minute)
+	deleted(&models.Gist{UserID: &owner.ID, Title: "expired", ExpiresAt: &past})
+	deleted(&models.Gist{UserID: &owner.ID, Title: "sandbox", Ephemeral: true})
+	theirs := deleted(&models.Gist{UserID: &alice.ID, Title: "theirs"})
+	live := &models.Gist{UserID: &owner.ID, Title: "live", GitRepoPath: "live"}
+	require.NoError(t, db.Create(live).Error)
+
+	t.Run("List", func(t *testing.T) {
+		trashed, err := service.List(owner)
+		require.NoError(t, err)
+		var titles []string
+		for _, item := range trashed {
+			titles = append(titles, item.Gist.Title)
+			require.NotNil(t, item.PurgeAt)
+			assert.WithinDuration(t, item.Gist.DeletedAt.Time.Add(30*24*time.Hour), *item.PurgeAt, time.Second)
+		}
+		assert.ElementsMatch(t, []string{"mine", "org"}, titles)
+
+		// Organization gists only show to the organization's admins
+		trashed, err = service.List(alice)
+		require.NoError(t, err)
+		require.Len(t, trashed, 1)
+		assert.Equal(t, theirs.ID, trashed[0].Gist.ID)
+
+		cfg.Set("gists.trash.retention_days", 0)
+		defer cfg.Set("gists.trash.retention_days", 30)
+		trashed, err = service.List(alice)
+		require.NoError(t, err)
+		assert.Nil(t, trashed[0].PurgeAt)
+	})
+
+	t.Run("Restore", func(t *testing.T) {
+		_, err := service.Restore(theirs.ID, owner)
+		assert.ErrorIs(t, err, ErrTrashGistNotFound)
+		_, err = service.Restore(orgGist.ID, alice)
+		assert.ErrorIs(t, err, ErrTrashGistNotFound)
+		_, err = service.Restore(live.ID, owner)
+		assert.ErrorIs(t, err, ErrTrashGistNotFound)
+
+		restored, err := service.Restore(mine.ID, owner)
+		require.NoError(t, err)
+		assert.False(t, restored.DeletedAt.Valid)
+		require.NoError(t, db.First(&models.Gist{}, "id = ?", mine.ID).Error)
+
+		_, err = service.Restore(orgGist.ID, owner)
+		require.NoError(t, err)
+		trashed, err := service.List(owner)
+		require.NoError(t, err)
+		assert.Empty(t, trashed)
+	})
+}
@@ -0,0 +1,360 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Moderation limits
+const (
+	MaxReportDescriptionLength = 1000
+	MaxModerationReasonLength  = 500
+	MaxModerationNoteLength    = 2000
+)
+
+// Actions recorded on moderation notes
+const (
+	ModerationActionHide      = "hide"
+	ModerationActionUnhide    = "unhide"
+	ModerationActionSuspend   = "suspend"
+	ModerationActionUnsuspend = "unsuspend"
+)
+
+var (
+	ErrReportReason      = fmt.Errorf("reason must be one of %s", strings.Join(models.ReportReasons, ", "))
+	ErrReportDescription = fmt.Errorf("description must be at most %d characters", MaxReportDescriptionLength)
+	ErrReportOwnGist     = errors.New("you can't report your own gist")
+	ErrReportDuplicate   = errors.New("you already reported this gist")
+	ErrReportNotFound    = errors.New("report not found")
+	ErrReportClosed      = errors.New("report is already closed")
+	ErrReportStatus      = errors.New("status must be resolved or dismissed")
+	ErrModerationGist    = errors.New("gist not found")
+	ErrModerationUser    = errors.New("user not found")
+	ErrModerationReason  = fmt.Errorf("reason must be at most %d characters", MaxModerationReasonLength)
+	ErrModerationNote    = fmt.Errorf("note must be 1 to %d characters", MaxModerationNoteLength)
+	ErrModerationSubject = errors.New("notes can be left on gists and users")
+	ErrSuspendAdmin      = errors.New("administrators can't be suspended")
+)
+
+// ReportFilter narrows the reports listed
+type ReportFilter struct {
+	Status string     // Empty for every status
+	GistID *uuid.UUID // Reports on one gist
+	Page   int
+	Limit  int
+}
+
+// ModerationService takes users' reports on gists and carries out what
+// administrators decide: hiding gists, suspending users and noting why.
+// A hidden gist is seen only by its owner and administrators; a suspended
+// user is signed out and can't sign in.
+type ModerationService struct {
+	db *gorm.DB
+}
+
+// NewModerationService creates a new moderation service
+func NewModerationService(db *gorm.DB) *ModerationService {
+	return &ModerationService{db: db}
+}
+
+// Report files the reporter's report on a gist they can read. A reporter
+// has one open report per gist.
+func (s *ModerationService) Report(gist *models.Gist, reporter *models.User, reason, description string) (*models.Report, error) {
+	if !slices.Contains(models.ReportReasons, reason) {
+		return nil, ErrReportReason
+	}
+	description = strings.TrimSpace(description)
+	if len([]rune(description)) > MaxReportDescriptionLength {
+		return nil, ErrReportDescription
+	}
+	if gist.UserID != nil && *gist.UserID == reporter.ID {
+		return nil, ErrReportOwnGist
+	}
+
+	var open int64
+	if err := s.db.Model(&models.Report{}).
+		Where("gist_id = ? AND reporter_id = ? AND status = ?", gist.ID, reporter.ID, models.ReportStatusOpen).
+		Count(&open).Error; err != nil {
+		return nil, err
+	}
+	if open > 0 {
+		return nil, ErrReportDuplicate
+	}
+
+	report := &models.Report{
+		GistID:      gist.ID,
+		ReporterID:  reporter.ID,
+		Reason:      reason,
+		Description: description,
+		Status:      models.ReportStatusOpen,
+	}
+	if err := s.db.Create(report).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// Reports lists reports, newest first, with their gists and reporters
+func (s *ModerationService) Reports(filter ReportFilter) ([]models.Report, int64, error) {
+	query := s.db.Model(&models.Report{})
+	if filter.Status != "" {
+		query = query.Where("status = ?", filter.Status)
+	}
+	if filter.GistID != nil {
+		query = query.Where("gist_id = ?", *filter.GistID)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	if filter.Limit <= 0 || filter.Limit > 100 {
+		filter.Limit = 20
+	}
+	if filter.Page <= 0 {
+		filter.Page = 1
+	}
+	var reports []models.Report
+	err := query.Preload("Gist").Preload("Reporter").
+		Order("created_at DESC").
+		Limit(filter.Limit).Offset((filter.Page - 1) * filter.Limit).
+		Find(&reports).Error
+	return reports, total, err
+}
+
+// GetReport returns a report with its gist and reporter
+func (s *ModerationService) GetReport(reportID uuid.UUID) (*models.Report, error) {
+	var report models.Report
+	if err := s.db.Preload("Gist").Preload("Reporter").First(&report, "id = ?", reportID).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrReportNotFound
+		}
+		return nil, err
+	}
+	return &report, nil
+}
+
+// CloseReport resolves or dismisses an open report, with the moderator's
+// note on why
+func (s *ModerationService) CloseReport(reportID uuid.UUID, moderator *models.User, status, resolution string) (*models.Report, error) {
+	if status != models.ReportStatusResolved && status != models.ReportStatusDismissed {
+		return nil, ErrReportStatus
+	}
+	resolution = strings.TrimSpace(resolution)
+	if len([]rune(resolution)) > MaxReportDescriptionLength {
+		return nil, ErrReportDescription
+	}
+	report, err := s.GetReport(reportID)
+	if err != nil {
+		return nil, err
+	}
+	if report.Status != models.ReportStatusOpen {
+		return nil, ErrReportClosed
+	}
+
+	now := time.Now()
+	if err := s.db.Model(report).Updates(map[string]interface{}{
+		"status":         status,
+		"resolved_by_id": moderator.ID,
+		"resolved_at":    now,
+		"resolution":     resolution,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+// HideGist hides a gist from everyone but its owner and administrators,
+// and resolves the open reports on it
+func (s *ModerationService) HideGist(gistID uuid.UUID, moderator *models.User, reason string) (*models.Gist, error) {
+	reason = strings.TrimSpace(reason)
+	if len([]rune(reason)) > MaxModerationReasonLength {
+		return nil, ErrModerationReason
+	}
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, ErrModerationGist
+	}
+
+	now := time.Now()
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&gist).Updates(map[string]interface{}{
+			"hidden_at":     now,
+			"hidden_reason": reason,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Model(&models.Report{}).
+			Where("gist_id = ? AND status = ?", gist.ID, models.ReportStatusOpen).
+			Updates(map[string]interface{}{
+				"status":         models.ReportStatusResolved,
+				"resolved_by_id": moderator.ID,
+				"resolved_at":    now,
+				"resolution":     "Gist hidden",
+			}).Error; err != nil {
+			return err
+		}
+		return createNote(tx, models.ModerationSubjectGist, gist.ID, moderator, ModerationActionHide, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gist, nil
+}
+
+// UnhideGist shows a hidden gist again
+func (s *ModerationService) UnhideGist(gistID uuid.UUID, moderator *models.User, note string) (*models.Gist, error) {
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, ErrModerationGist
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&gist).Updates(map[string]interface{}{
+			"hidden_at":     nil,
+			"hidden_reason": "",
+		}).Error; err != nil {
+			return err
+		}
+		return createNote(tx, models.ModerationSubjectGist, gist.ID, moderator, ModerationActionUnhide, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &gist, nil
+}
+
+// SuspendUser suspends a user and signs them out everywhere. Their gists
+// stay as they are; hide them one by one if they must go too.
+func (s *ModerationService) SuspendUser(userID uuid.UUID, moderator *models.User, reason string) (*models.User, error) {
+	reason = strings.TrimSpace(reason)
+	if len([]rune(reason)) > MaxModerationReasonLength {
+		return nil, ErrModerationReason
+	}
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrModerationUser
+	}
+	if user.IsAdmin {
+		return nil, ErrSuspendAdmin
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"is_suspended":      true,
+			"suspended_at":      time.Now(),
+			"suspension_reason": reason,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.Where("user_id = ?", user.ID).Delete(&models.Session{}).Error; err != nil {
+			return err
+		}
+		return createNote(tx, models.ModerationSubjectUser, user.ID, moderator, ModerationActionSuspend, reason)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// UnsuspendUser lifts a user's suspension
+func (s *ModerationService) UnsuspendUser(userID uuid.UUID, moderator *models.User, note string) (*models.User, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrModerationUser
+	}
+
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&user).Updates(map[string]interface{}{
+			"is_suspended":      false,
+			"suspended_at":      nil,
+			"suspension_reason": "",
+		}).Error; err != nil {
+			return err
+		}
+		return createNote(tx, models.ModerationSubjectUser, user.ID, moderator, ModerationActionUnsuspend, note)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &user, nil
+}
+
+// AddNote leaves a note on a gist or user for other administrators
+func (s *ModerationService) AddNote(subjectType string, subjectID uuid.UUID, author *models.User, body string) (*models.ModerationNote, error) {
+	body = strings.TrimSpace(body)
+	if body == "" || len([]rune(body)) > MaxModerationNoteLength {
+		return nil, ErrModerationNote
+	}
+	if err := s.subjectExists(subjectType, subjectID); err != nil {
+		return nil, err
+	}
+	note := &models.ModerationNote{
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		AuthorID:    author.ID,
+		Body:        body,
+	}
+	if err := s.db.Create(note).Error; err != nil {
+		return nil, err
+	}
+	return note, nil
+}
+
+// Notes lists the notes on a gist or user, oldest first, with their
+// authors
+func (s *ModerationService) Notes(subjectType string, subjectID uuid.UUID) ([]models.ModerationNote, error) {
+	if err := s.subjectExists(subjectType, subjectID); err != nil {
+		return nil, err
+	}
+	var notes []models.ModerationNote
+	err := s.db.Preload("Author").
+		Where("subject_type = ? AND subject_id = ?", subjectType, subjectID).
+		Order("created_at").Find(&notes).Error
+	return notes, err
+}
+
+func (s *ModerationService) subjectExists(subjectType string, subjectID uuid.UUID) error {
+	var count int64
+	switch subjectType {
+	case models.ModerationSubjectGist:
+		s.db.Model(&models.Gist{}).Where("id = ?", subjectID).Count(&count)
+		if count == 0 {
+			return ErrModerationGist
+		}
+	case models.ModerationSubjectUser:
+		s.db.Model(&models.User{}).Where("id = ?", subjectID).Count(&count)
+		if count == 0 {
+			return ErrModerationUser
+		}
+	default:
+		return ErrModerationSubject
+	}
+	return nil
+}
+
+// createNote records a moderation action. The body may be empty, as the
+// action speaks for itself.
+func createNote(tx *gorm.DB, subjectType string, subjectID uuid.UUID, author *models.User, action, body string) error {
+	body = strings.TrimSpace(body)
+	if len([]rune(body)) > MaxModerationNoteLength {
+		return ErrModerationNote
+	}
+	return tx.Create(&models.ModerationNote{
+		SubjectType: subjectType,
+		SubjectID:   subjectID,
+		AuthorID:    author.ID,
+		Action:      action,
+		Body:        body,
+	}).Error
+}
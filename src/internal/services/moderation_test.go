@@ -0,0 +1,146 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestModerationService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{},
+		&models.Session{}, &models.Report{}, &models.ModerationNote{}))
+
+	service := NewModerationService(db)
+	policy := NewOrgPolicyService(db)
+
+	admin := &models.User{Username: "admin", Email: "admin@example.com", IsAdmin: true}
+	require.NoError(t, db.Create(admin).Error)
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	bob := &models.User{Username: "bob", Email: "bob@example.com"}
+	require.NoError(t, db.Create(bob).Error)
+	gist := &models.Gist{UserID: &owner.ID, Title: "Free money", Visibility: models.VisibilityPublic, GitRepoPath: "spam"}
+	require.NoError(t, db.Create(gist).Error)
+
+	t.Run("Report", func(t *testing.T) {
+		_, err := service.Report(gist, alice, "boring", "")
+		assert.ErrorIs(t, err, ErrReportReason)
+		_, err = service.Report(gist, owner, models.ReportReasonSpam, "")
+		assert.ErrorIs(t, err, ErrReportOwnGist)
+
+		report, err := service.Report(gist, alice, models.ReportReasonSpam, " Links to a scam ")
+		require.NoError(t, err)
+		assert.Equal(t, models.ReportStatusOpen, report.Status)
+		assert.Equal(t, "Links to a scam", report.Description)
+
+		_, err = service.Report(gist, alice, models.ReportReasonAbuse, "")
+		assert.ErrorIs(t, err, ErrReportDuplicate)
+		_, err = service.Report(gist, bob, models.ReportReasonMalware, "")
+		require.NoError(t, err)
+
+		reports, total, err := service.Reports(ReportFilter{Status: models.ReportStatusOpen, GistID: &gist.ID})
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		require.Len(t, reports, 2)
+		assert.Equal(t, gist.Title, reports[0].Gist.Title)
+		assert.NotEmpty(t, reports[0].Reporter.Username)
+	})
+
+	t.Run("CloseReport", func(t *testing.T) {
+		other := &models.Gist{UserID: &owner.ID, Title: "Fine", Visibility: models.VisibilityPublic, GitRepoPath: "fine"}
+		require.NoError(t, db.Create(other).Error)
+		report, err := service.Report(other, bob, models.ReportReasonOther, "")
+		require.NoError(t, err)
+
+		_, err = service.CloseReport(report.ID, admin, models.ReportStatusOpen, "")
+		assert.ErrorIs(t, err, ErrReportStatus)
+		closed, err := service.CloseReport(report.ID, admin, models.ReportStatusDismissed, "Nothing wrong with it")
+		require.NoError(t, err)
+		assert.Equal(t, models.ReportStatusDismissed, closed.Status)
+		assert.Equal(t, admin.ID, *closed.ResolvedByID)
+		_, err = service.CloseReport(report.ID, admin, models.ReportStatusResolved, "")
+		assert.ErrorIs(t, err, ErrReportClosed)
+
+		// A closed report doesn't stop reporting the gist again
+		_, err = service.Report(other, bob, models.ReportReasonSpam, "")
+		assert.NoError(t, err)
+	})
+
+	t.Run("HideGist", func(t *testing.T) {
+		hidden, err := service.HideGist(gist.ID, admin, "Spam")
+		require.NoError(t, err)
+		require.NotNil(t, hidden.HiddenAt)
+
+		var open int64
+		db.Model(&models.Report{}).Where("gist_id = ? AND status = ?", gist.ID, models.ReportStatusOpen).Count(&open)
+		assert.Zero(t, open)
+
+		// Only its owner and admins see it
+		assert.ErrorIs(t, policy.AuthorizeGist(hidden, nil, GistRead), ErrGistHidden)
+		assert.ErrorIs(t, policy.AuthorizeGist(hidden, alice, GistRead), ErrGistHidden)
+		assert.NoError(t, policy.AuthorizeGist(hidden, owner, GistRead))
+		assert.NoError(t, policy.AuthorizeGist(hidden, admin, GistRead))
+
+		var visible int64
+		db.Model(&models.Gist{}).Scopes(models.HideModerated).Where("id = ?", gist.ID).Count(&visible)
+		assert.Zero(t, visible)
+
+		shown, err := service.UnhideGist(gist.ID, admin, "Cleaned up")
+		require.NoError(t, err)
+		assert.Nil(t, shown.HiddenAt)
+		assert.NoError(t, policy.AuthorizeGist(shown, nil, GistRead))
+
+		notes, err := service.Notes(models.ModerationSubjectGist, gist.ID)
+		require.NoError(t, err)
+		require.Len(t, notes, 2)
+		assert.Equal(t, ModerationActionHide, notes[0].Action)
+		assert.Equal(t, "Spam", notes[0].Body)
+		assert.Equal(t, ModerationActionUnhide, notes[1].Action)
+		assert.Equal(t, "admin", notes[1].Author.Username)
+	})
+
+	t.Run("SuspendUser", func(t *testing.T) {
+		require.NoError(t, db.Create(&models.Session{UserID: bob.ID, Token: "access", RefreshToken: "refresh"}).Error)
+
+		_, err := service.SuspendUser(admin.ID, admin, "")
+		assert.ErrorIs(t, err, ErrSuspendAdmin)
+
+		suspended, err := service.SuspendUser(bob.ID, admin, "Spamming reports")
+		require.NoError(t, err)
+		assert.True(t, suspended.IsSuspended)
+		assert.NotNil(t, suspended.SuspendedAt)
+		var sessions int64
+		db.Model(&models.Session{}).Where("user_id = ?", bob.ID).Count(&sessions)
+		assert.Zero(t, sessions)
+
+		restored, err := service.UnsuspendUser(bob.ID, admin, "")
+		require.NoError(t, err)
+		assert.False(t, restored.IsSuspended)
+		assert.Nil(t, restored.SuspendedAt)
+		assert.Empty(t, restored.SuspensionReason)
+	})
+
+	t.Run("Notes", func(t *testing.T) {
+		_, err := service.AddNote(models.ModerationSubjectUser, bob.ID, admin, "  ")
+		assert.ErrorIs(t, err, ErrModerationNote)
+		_, err = service.AddNote("comment", bob.ID, admin, "Hmm")
+		assert.ErrorIs(t, err, ErrModerationSubject)
+		_, err = service.AddNote(models.ModerationSubjectGist, bob.ID, admin, "Hmm")
+		assert.ErrorIs(t, err, ErrModerationGist)
+
+		note, err := service.AddNote(models.ModerationSubjectUser, bob.ID, admin, "Warned by email")
+		require.NoError(t, err)
+		assert.Empty(t, note.Action)
+
+		notes, err := service.Notes(models.ModerationSubjectUser, bob.ID)
+		require.NoError(t, err)
+		require.Len(t, notes, 3)
+		assert.Equal(t, "Warned by email", notes[2].Body)
+	})
+}
@@ -0,0 +1,220 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/urls"
+)
+
+var (
+	ErrOrgGistNotFound = errors.New("gist not found")
+	ErrGistSlugInvalid = errors.New("slug may only contain lowercase letters, digits and single dashes")
+	ErrGistSlugTaken   = errors.New("slug is already used in this organization")
+	ErrGistNotOwner    = errors.New("only the gist owner can transfer it")
+)
+
+// OrgGistService serves organization gists under their /o/:org/:slug
+// addresses and moves gists into organizations
+type OrgGistService struct {
+	db     *gorm.DB
+	policy *OrgPolicyService
+}
+
+// NewOrgGistService creates a new organization gist service
+func NewOrgGistService(db *gorm.DB) *OrgGistService {
+	return &OrgGistService{db: db, policy: NewOrgPolicyService(db)}
+}
+
+// OrgGistView is what a viewer may see of an organization
+type OrgGistView struct {
+	Organization *models.Organization
+	Role         string // The viewer's role, "" for non-members
+}
+
+// IsMember reports whether the viewer is an authorized member
+func (v *OrgGistView) IsMember() bool {
+	return v.Role != ""
+}
+
+// View looks up an organization for a viewer, who may be nil. Private
+// organizations are only visible to their members.
+func (s *OrgGistService) View(orgName string, viewer *models.User) (*OrgGistView, error) {
+	org, err := s.policy.FindOrganization(orgName)
+	if err != nil {
+		return nil, err
+	}
+	view := &OrgGistView{Organization: org}
+	if viewer != nil {
+		if role, err := s.policy.AuthorizeMember(org.ID, viewer); err == nil {
+			view.Role = role
+		}
+	}
+	if !org.IsPublic && !view.IsMember() {
+		return nil, ErrOrgNotFound
+	}
+	return view, nil
+}
+
+// ListGists returns a page of the organization's gists, newest first.
+// Members see every gist, everybody else only public ones.
+func (s *OrgGistService) ListGists(view *OrgGistView, page, limit int) ([]models.Gist, int64, error) {
+	query := database.ReadReplica(s.db).Model(&models.Gist{}).Where("organization_id = ?", view.Organization.ID)
+	if !view.IsMember() {
+		query = query.Where("visibility = ?", models.VisibilityPublic)
+	}
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var gists []models.Gist
+	if err := query.Order("updated_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&gists).Error; err != nil {
+		return nil, 0, err
+	}
+	return gists, total, nil
+}
+
+// Resolve finds the gist at /o/:org/:slug. When the address is an old one
+// the gist's current path is returned as well, for a redirect. Private
+// gists are only visible to members.
+func (s *OrgGistService) Resolve(view *OrgGistView, slug string) (*models.Gist, string, error) {
+	gist, redirected, err := models.FindOrgGist(s.db, view.Organization.ID, slug)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, "", ErrOrgGistNotFound
+		}
+		return nil, "", err
+	}
+
+	if redirected {
+		path, err := s.GistPath(gist)
+		if err != nil {
+			return nil, "", err
+		}
+		return gist, path, nil
+	}
+
+	if gist.Visibility == models.VisibilityPrivate && !view.IsMember() {
+		return nil, "", ErrOrgGistNotFound
+	}
+	if err := s.db.Model(gist).Association("Files").Find(&gist.Files); err != nil {
+		return nil, "", err
+	}
+	return gist, "", nil
+}
+
+// GistPath returns the gist's web address: /o/:org/:slug for organization
+// gists and /gists/:id otherwise
+func (s *OrgGistService) GistPath(gist *models.Gist) (string, error) {
+	if gist.OrganizationID == nil || gist.Slug == nil {
+		return urls.Path(urls.GistPage, gist.ID.String()), nil
+	}
+	var org models.Organization
+	if err := s.db.Select("name").First(&org, "id = ?", *gist.OrganizationID).Error; err != nil {
+		return "", err
+	}
+	return models.OrgGistPath(org.Name, *gist.Slug), nil
+}
+
+// Rename changes an organization gist's slug. The old address redirects to
+// the new one. Only organization admins may rename.
+func (s *OrgGistService) Rename(view *OrgGistView, slug, newSlug string) (*models.Gist, error) {
+	if !models.IsOrgAdminRole(view.Role) {
+		return nil, ErrOrgAdminRequired
+	}
+	if !models.ValidGistSlug(newSlug) {
+		return nil, ErrGistSlugInvalid
+	}
+
+	gist, redirected, err := models.FindOrgGist(s.db, view.Organization.ID, slug)
+	if err != nil || redirected {
+		return nil, ErrOrgGistNotFound
+	}
+	if gist.SlugValue() == newSlug {
+		return gist, nil
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		taken, err := models.IsGistSlugTaken(tx, view.Organization.ID, newSlug, gist.ID)
+		if err != nil {
+			return err
+		}
+		if taken {
+			return ErrGistSlugTaken
+		}
+		if err := models.SetOrgSlug(tx, gist, gist.OrganizationID, slug, newSlug); err != nil {
+			return err
+		}
+		return tx.Model(gist).Update("slug", gist.Slug).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return gist, nil
+}
+
+// TransferToOrganization moves a gist the user owns into an organization
+// they may create gists in. Its /gists/:id address keeps working and its
+// new one is /o/:org/:slug, with slug derived from the gist when empty.
+func (s *OrgGistService) TransferToOrganization(gistID uuid.UUID, user *models.User, orgName, slug string) (*models.Gist, *models.Organization, error) {
+	if slug != "" && !models.ValidGistSlug(slug) {
+		return nil, nil, ErrGistSlugInvalid
+	}
+
+	var gist models.Gist
+	if err := s.db.First(&gist, "id = ?", gistID).Error; err != nil {
+		return nil, nil, ErrOrgGistNotFound
+	}
+	if gist.UserID == nil || *gist.UserID != user.ID {
+		return nil, nil, ErrGistNotOwner
+	}
+
+	org, err := s.policy.FindOrganization(orgName)
+	if err != nil {
+		return nil, nil, err
+	}
+	if _, err := s.policy.AuthorizeGistCreate(org.ID, user, gist.Name, string(gist.Visibility)); err != nil {
+		return nil, nil, err
+	}
+
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if slug != "" {
+			taken, err := models.IsGistSlugTaken(tx, org.ID, slug, gist.ID)
+			if err != nil {
+				return err
+			}
+			if taken {
+				return ErrGistSlugTaken
+			}
+		}
+
+		gist.UserID = nil
+		gist.OrganizationID = &org.ID
+		if err := models.SetOrgSlug(tx, &gist, nil, "", slug); err != nil {
+			return err
+		}
+		// Gists are owned by a user or an organization, never both
+		if err := tx.Model(&gist).Updates(map[string]interface{}{
+			"user_id":         nil,
+			"organization_id": org.ID,
+			"slug":            gist.Slug,
+		}).Error; err != nil {
+			return fmt.Errorf("failed to transfer gist: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return &gist, org, nil
+}
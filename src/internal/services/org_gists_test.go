@@ -0,0 +1,108 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestOrgGistService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{}, &models.GistFile{}, &models.GistRedirect{}))
+
+	service := NewOrgGistService(db)
+
+	acme := &models.Organization{Name: "acme", IsPublic: true}
+	require.NoError(t, db.Create(acme).Error)
+	other := &models.Organization{Name: "other", IsPublic: true}
+	require.NoError(t, db.Create(other).Error)
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: acme.ID, UserID: owner.ID, Role: models.OrgRoleOwner}).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: other.ID, UserID: owner.ID, Role: models.OrgRoleOwner}).Error)
+
+	newGist := func(title string, visibility models.Visibility) *models.Gist {
+		gist := &models.Gist{UserID: &owner.ID, Title: title, Visibility: visibility, GitRepoPath: title}
+		require.NoError(t, db.Create(gist).Error)
+		return gist
+	}
+
+	t.Run("Slugify", func(t *testing.T) {
+		assert.Equal(t, "deploy-script-v2", models.Slugify("  Deploy script (v2)!"))
+		assert.Equal(t, "", models.Slugify("日本語"))
+		assert.True(t, models.ValidGistSlug("deploy-script"))
+		assert.False(t, models.ValidGistSlug("Deploy--script"))
+	})
+
+	t.Run("TransferAssignsFreeSlugs", func(t *testing.T) {
+		first, org, err := service.TransferToOrganization(newGist("Backup Script", models.VisibilityPublic).ID, owner, "acme", "")
+		require.NoError(t, err)
+		assert.Equal(t, acme.ID, org.ID)
+		assert.Equal(t, "backup-script", first.SlugValue())
+		assert.Nil(t, first.UserID)
+
+		second, _, err := service.TransferToOrganization(newGist("Backup script", models.VisibilityPrivate).ID, owner, "acme", "")
+		require.NoError(t, err)
+		assert.Equal(t, "backup-script-2", second.SlugValue())
+
+		_, _, err = service.TransferToOrganization(newGist("x", models.VisibilityPublic).ID, owner, "acme", "backup-script")
+		assert.ErrorIs(t, err, ErrGistSlugTaken)
+
+		_, _, err = service.TransferToOrganization(first.ID, owner, "other", "")
+		assert.ErrorIs(t, err, ErrGistNotOwner)
+	})
+
+	t.Run("VisibilityAndListing", func(t *testing.T) {
+		public, err := service.View("acme", nil)
+		require.NoError(t, err)
+		gists, total, err := service.ListGists(public, 1, 30)
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), total)
+		assert.Len(t, gists, 1)
+
+		_, _, err = service.Resolve(public, "backup-script-2")
+		assert.ErrorIs(t, err, ErrOrgGistNotFound)
+
+		member, err := service.View("acme", owner)
+		require.NoError(t, err)
+		_, total, err = service.ListGists(member, 1, 30)
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), total)
+
+		gist, redirect, err := service.Resolve(member, "backup-script-2")
+		require.NoError(t, err)
+		assert.Empty(t, redirect)
+		assert.Equal(t, "Backup script", gist.Title)
+	})
+
+	t.Run("RenameKeepsOldAddress", func(t *testing.T) {
+		view, err := service.View("acme", owner)
+		require.NoError(t, err)
+
+		_, err = service.Rename(view, "backup-script", "Not A Slug")
+		assert.ErrorIs(t, err, ErrGistSlugInvalid)
+		_, err = service.Rename(view, "backup-script", "backup-script-2")
+		assert.ErrorIs(t, err, ErrGistSlugTaken)
+
+		gist, err := service.Rename(view, "backup-script", "nightly-backup")
+		require.NoError(t, err)
+		assert.Equal(t, "nightly-backup", gist.SlugValue())
+
+		resolved, redirect, err := service.Resolve(view, "backup-script")
+		require.NoError(t, err)
+		assert.Equal(t, gist.ID, resolved.ID)
+		assert.Equal(t, "/o/acme/nightly-backup", redirect)
+
+		// The old address stays reserved for the gist
+		_, _, err = service.TransferToOrganization(newGist("y", models.VisibilityPublic).ID, owner, "acme", "backup-script")
+		assert.ErrorIs(t, err, ErrGistSlugTaken)
+
+		visitor, err := service.View("acme", nil)
+		require.NoError(t, err)
+		_, err = service.Rename(visitor, "nightly-backup", "mine")
+		assert.ErrorIs(t, err, ErrOrgAdminRequired)
+	})
+}
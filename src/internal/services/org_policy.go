@@ -0,0 +1,326 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/contentpolicy"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+var (
+	ErrOrgNotFound                = errors.New("organization not found")
+	ErrOrgNotMember               = errors.New("not a member of this organization")
+	ErrOrgAdminRequired           = errors.New("organization admin access required")
+	ErrOrgTwoFactorRequired       = errors.New("this organization requires two-factor authentication")
+	ErrOrgGistCreationDenied      = errors.New("only organization admins can create gists in this organization")
+	ErrOrgViewerReadOnly          = errors.New("organization viewers cannot change gists")
+	ErrGistAccessDenied           = errors.New("access denied")
+	ErrGistHidden                 = errors.New("gist has been hidden by an administrator")
+	ErrOrgGistNameInvalid         = errors.New("gist name does not match the organization's naming convention")
+	ErrOrgWebhookDomainNotAllowed = errors.New("webhook domain is not allowed by this organization")
+	ErrOrgInvalidSettings         = errors.New("invalid organization settings")
+)
+
+// OrgPolicyService manages organization settings and enforces them wherever
+// members act on behalf of the organization (gists, webhooks, membership)
+type OrgPolicyService struct {
+	db *gorm.DB
+}
+
+// NewOrgPolicyService creates a new organization policy service
+func NewOrgPolicyService(db *gorm.DB) *OrgPolicyService {
+	return &OrgPolicyService{db: db}
+}
+
+// OrgSettingsUpdate holds the settings to change; nil fields are left as is
+type OrgSettingsUpdate struct {
+	DefaultGistVisibility *string
+	RequireTwoFactor      *bool
+	MemberGistCreation    *string
+	AllowedWebhookDomains []string
+	GistNamePattern       *string
+	ContentPolicy         *models.ContentPolicy // Replaces the organization's content policy
+}
+
+// FindOrganization looks up an organization by name
+func (s *OrgPolicyService) FindOrganization(name string) (*models.Organization, error) {
+	var org models.Organization
+	if err := s.db.Where("name = ?", name).First(&org).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, ErrOrgNotFound
+		}
+		return nil, err
+	}
+	return &org, nil
+}
+
+// GetSettings returns the organization's settings, or the defaults if none
+// have been saved yet
+func (s *OrgPolicyService) GetSettings(orgID uuid.UUID) (*models.OrganizationSettings, error) {
+	settings := models.OrganizationSettings{
+		OrganizationID:        orgID,
+		DefaultGistVisibility: models.VisibilityPrivate,
+		MemberGistCreation:    models.OrgGistCreationMembers,
+	}
+	if err := s.db.Where("organization_id = ?", orgID).Limit(1).Find(&settings).Error; err != nil {
+		return nil, err
+	}
+	return &settings, nil
+}
+
+// UpdateSettings validates and saves changes to the organization's settings
+func (s *OrgPolicyService) UpdateSettings(orgID uuid.UUID, update OrgSettingsUpdate) (*models.OrganizationSettings, error) {
+	settings, err := s.GetSettings(orgID)
+	if err != nil {
+		return nil, err
+	}
+
+	if update.DefaultGistVisibility != nil {
+		visibility := models.Visibility(*update.DefaultGistVisibility)
+		switch visibility {
+		case models.VisibilityPublic, models.VisibilityPrivate, models.VisibilityUnlisted:
+			settings.DefaultGistVisibility = visibility
+		default:
+			return nil, fmt.Errorf("%w: unknown visibility %q", ErrOrgInvalidSettings, visibility)
+		}
+	}
+	if update.RequireTwoFactor != nil {
+		settings.RequireTwoFactor = *update.RequireTwoFactor
+	}
+	if update.MemberGistCreation != nil {
+		switch *update.MemberGistCreation {
+		case models.OrgGistCreationMembers, models.OrgGistCreationAdmins:
+			settings.MemberGistCreation = *update.MemberGistCreation
+		default:
+			return nil, fmt.Errorf("%w: member_gist_creation must be %q or %q",
+				ErrOrgInvalidSettings, models.OrgGistCreationMembers, models.OrgGistCreationAdmins)
+		}
+	}
+	if update.AllowedWebhookDomains != nil {
+		domains := make([]string, 0, len(update.AllowedWebhookDomains))
+		for _, domain := range update.AllowedWebhookDomains {
+			domain = strings.ToLower(strings.TrimSpace(domain))
+			if domain == "" {
+				continue
+			}
+			if strings.ContainsAny(domain, "/:, ") {
+				return nil, fmt.Errorf("%w: %q is not a domain name", ErrOrgInvalidSettings, domain)
+			}
+			domains = append(domains, domain)
+		}
+		settings.AllowedWebhookDomains = strings.Join(domains, ",")
+	}
+	if update.GistNamePattern != nil {
+		pattern := strings.TrimSpace(*update.GistNamePattern)
+		if pattern != "" {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return nil, fmt.Errorf("%w: gist_name_pattern: %v", ErrOrgInvalidSettings, err)
+			}
+		}
+		settings.GistNamePattern = pattern
+	}
+	if update.ContentPolicy != nil {
+		policy, err := contentpolicy.Normalize(*update.ContentPolicy)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrOrgInvalidSettings, err)
+		}
+		settings.ContentPolicy = policy
+	}
+
+	if err := s.db.Save(settings).Error; err != nil {
+		return nil, fmt.Errorf("failed to save organization settings: %w", err)
+	}
+	return settings, nil
+}
+
+// MemberRole returns the user's role in the organization
+func (s *OrgPolicyService) MemberRole(orgID, userID uuid.UUID) (string, error) {
+	var member models.OrganizationMember
+	if err := s.db.Where("organization_id = ? AND user_id = ?", orgID, userID).First(&member).Error; err != nil {
+		return "", ErrOrgNotMember
+	}
+	return member.Role, nil
+}
+
+// AuthorizeMember checks that the user is a member and satisfies the
+// organization's two-factor requirement, returning their role
+func (s *OrgPolicyService) AuthorizeMember(orgID uuid.UUID, user *models.User) (string, error) {
+	role, err := s.MemberRole(orgID, user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	settings, err := s.GetSettings(orgID)
+	if err != nil {
+		return "", err
+	}
+	if settings.RequireTwoFactor && !user.TwoFactorEnabled {
+		return "", ErrOrgTwoFactorRequired
+	}
+
+	return role, nil
+}
+
+// AuthorizeAdmin checks that the user may manage the organization
+func (s *OrgPolicyService) AuthorizeAdmin(orgID uuid.UUID, user *models.User) error {
+	role, err := s.AuthorizeMember(orgID, user)
+	if err != nil {
+		return err
+	}
+	if !models.IsOrgAdminRole(role) {
+		return ErrOrgAdminRequired
+	}
+	return nil
+}
+
+// AuthorizeGistCreate checks that the user may create a gist in the
+// organization and returns the visibility to use, applying the
+// organization's default when none was requested
+func (s *OrgPolicyService) AuthorizeGistCreate(orgID uuid.UUID, user *models.User, name, visibility string) (models.Visibility, error) {
+	role, err := s.AuthorizeMember(orgID, user)
+	if err != nil {
+		return "", err
+	}
+	if !models.CanEditOrgGists(role) {
+		return "", ErrOrgViewerReadOnly
+	}
+
+	settings, err := s.GetSettings(orgID)
+	if err != nil {
+		return "", err
+	}
+
+	if settings.MemberGistCreation == models.OrgGistCreationAdmins && !models.IsOrgAdminRole(role) {
+		return "", ErrOrgGistCreationDenied
+	}
+
+	if settings.GistNamePattern != "" {
+		// Anchor the pattern so it describes the whole name
+		re, err := regexp.Compile("^(?:" + settings.GistNamePattern + ")$")
+		if err != nil || !re.MatchString(name) {
+			return "", fmt.Errorf("%w (%s)", ErrOrgGistNameInvalid, settings.GistNamePattern)
+		}
+	}
+
+	if visibility == "" {
+		return settings.DefaultGistVisibility, nil
+	}
+	return models.Visibility(visibility), nil
+}
+
+// GistAction is something done to a gist, checked by AuthorizeGist
+type GistAction int
+
+const (
+	GistRead  GistAction = iota
+	GistWrite            // Change the gist's content or settings
+	GistDelete
+)
+
+// AuthorizeGist checks that the user, nil for anonymous requests, may act
+// on the gist. A user's own gists are theirs alone. An organization's gists
+// follow member roles: any member may read private ones, members and up may
+// change them and only owners and admins may delete them. Gists an
+// administrator hid can only be read by those who may change them and by
+// administrators.
+func (s *OrgPolicyService) AuthorizeGist(gist *models.Gist, user *models.User, action GistAction) error {
+	if action == GistRead && gist.HiddenAt != nil {
+		if user != nil && user.IsAdmin {
+			return nil
+		}
+		if s.AuthorizeGist(gist, user, GistWrite) != nil {
+			return ErrGistHidden
+		}
+		return nil
+	}
+	if action == GistRead && gist.Visibility != models.VisibilityPrivate {
+		return nil
+	}
+
+	if gist.OrganizationID == nil {
+		if user == nil || gist.UserID == nil || *gist.UserID != user.ID {
+			return ErrGistAccessDenied
+		}
+		return nil
+	}
+
+	if user == nil {
+		return ErrOrgNotMember
+	}
+	role, err := s.AuthorizeMember(*gist.OrganizationID, user)
+	if err != nil {
+		return err
+	}
+	switch action {
+	case GistWrite:
+		if !models.CanEditOrgGists(role) {
+			return ErrOrgViewerReadOnly
+		}
+	case GistDelete:
+		if !models.IsOrgAdminRole(role) {
+			return ErrOrgAdminRequired
+		}
+	}
+	return nil
+}
+
+// CheckWebhookURL checks the URL's host against the organization's allowed
+// webhook domains. Subdomains of an allowed domain are accepted.
+func (s *OrgPolicyService) CheckWebhookURL(orgID uuid.UUID, rawURL string) error {
+	settings, err := s.GetSettings(orgID)
+	if err != nil {
+		return err
+	}
+
+	domains := settings.WebhookDomains()
+	if len(domains) == 0 {
+		return nil
+	}
+
+	parsed, err := url.Parse(rawURL)
+	if err != nil || parsed.Hostname() == "" {
+		return ErrOrgWebhookDomainNotAllowed
+	}
+	host := strings.ToLower(parsed.Hostname())
+	for _, domain := range domains {
+		if host == domain || strings.HasSuffix(host, "."+domain) {
+			return nil
+		}
+	}
+	return ErrOrgWebhookDomainNotAllowed
+}
+
+// MembersWithoutTwoFactor lists the usernames of members who have not
+// enabled two-factor authentication
+func (s *OrgPolicyService) MembersWithoutTwoFactor(orgID uuid.UUID) ([]string, error) {
+	var usernames []string
+	err := s.db.Model(&models.User{}).
+		Joins("JOIN organization_members ON organization_members.user_id = users.id").
+		Where("organization_members.organization_id = ? AND users.two_factor_enabled = ?", orgID, false).
+		Order("users.username").
+		Pluck("users.username", &usernames).Error
+	return usernames, err
+}
+
+// AuthorizeWebhook checks that the user may manage the organization's
+// webhooks and, when a URL is given, that its domain is allowed
+func (s *OrgPolicyService) AuthorizeWebhook(orgID, userID uuid.UUID, rawURL string) error {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return ErrOrgNotMember
+	}
+	if err := s.AuthorizeAdmin(orgID, &user); err != nil {
+		return err
+	}
+	if rawURL == "" {
+		return nil
+	}
+	return s.CheckWebhookURL(orgID, rawURL)
+}
@@ -0,0 +1,130 @@
+package services
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestOrgPolicyService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.Organization{}, &models.OrganizationMember{}, &models.OrganizationSettings{}))
+
+	policy := NewOrgPolicyService(db)
+
+	org := &models.Organization{Name: "acme"}
+	require.NoError(t, db.Create(org).Error)
+	owner := &models.User{Username: "owner", Email: "owner@example.com", TwoFactorEnabled: true}
+	require.NoError(t, db.Create(owner).Error)
+	member := &models.User{Username: "member", Email: "member@example.com"}
+	require.NoError(t, db.Create(member).Error)
+	outsider := &models.User{Username: "outsider", Email: "outsider@example.com"}
+	require.NoError(t, db.Create(outsider).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: owner.ID, Role: models.OrgRoleOwner}).Error)
+	require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: member.ID, Role: models.OrgRoleMember}).Error)
+
+	t.Run("Defaults", func(t *testing.T) {
+		visibility, err := policy.AuthorizeGistCreate(org.ID, member, "anything", "")
+		require.NoError(t, err)
+		assert.Equal(t, models.VisibilityPrivate, visibility)
+
+		_, err = policy.AuthorizeGistCreate(org.ID, outsider, "anything", "")
+		assert.ErrorIs(t, err, ErrOrgNotMember)
+
+		assert.NoError(t, policy.CheckWebhookURL(org.ID, "https://anywhere.example.net/hook"))
+	})
+
+	t.Run("InvalidSettings", func(t *testing.T) {
+		bad := "everyone"
+		_, err := policy.UpdateSettings(org.ID, OrgSettingsUpdate{MemberGistCreation: &bad})
+		assert.ErrorIs(t, err, ErrOrgInvalidSettings)
+
+		pattern := "[unclosed"
+		_, err = policy.UpdateSettings(org.ID, OrgSettingsUpdate{GistNamePattern: &pattern})
+		assert.ErrorIs(t, err, ErrOrgInvalidSettings)
+
+		_, err = policy.UpdateSettings(org.ID, OrgSettingsUpdate{ContentPolicy: &models.ContentPolicy{SecretScanning: "sometimes"}})
+		assert.ErrorIs(t, err, ErrOrgInvalidSettings)
+	})
+
+	t.Run("ContentPolicy", func(t *testing.T) {
+		settings, err := policy.UpdateSettings(org.ID, OrgSettingsUpdate{ContentPolicy: &models.ContentPolicy{
+			SecretScanning: "block",
+			BannedWords:    []string{" internal ", "Internal", "codename"},
+		}})
+		require.NoError(t, err)
+		assert.Equal(t, []string{"internal", "codename"}, settings.ContentPolicy.BannedWords)
+
+		saved, err := policy.GetSettings(org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, settings.ContentPolicy, saved.ContentPolicy)
+
+		settings, err = policy.UpdateSettings(org.ID, OrgSettingsUpdate{ContentPolicy: &models.ContentPolicy{}})
+		require.NoError(t, err)
+		assert.True(t, settings.ContentPolicy.IsZero())
+	})
+
+	t.Run("GistRoles", func(t *testing.T) {
+		viewer := &models.User{Username: "viewer", Email: "viewer@example.com", TwoFactorEnabled: true}
+		require.NoError(t, db.Create(viewer).Error)
+		require.NoError(t, db.Create(&models.OrganizationMember{OrganizationID: org.ID, UserID: viewer.ID, Role: models.OrgRoleViewer}).Error)
+
+		gist := &models.Gist{OrganizationID: &org.ID, Visibility: models.VisibilityPrivate}
+		assert.ErrorIs(t, policy.AuthorizeGist(gist, nil, GistRead), ErrOrgNotMember)
+		assert.ErrorIs(t, policy.AuthorizeGist(gist, outsider, GistRead), ErrOrgNotMember)
+		assert.NoError(t, policy.AuthorizeGist(gist, viewer, GistRead))
+		assert.ErrorIs(t, policy.AuthorizeGist(gist, viewer, GistWrite), ErrOrgViewerReadOnly)
+		assert.NoError(t, policy.AuthorizeGist(gist, member, GistWrite))
+		assert.ErrorIs(t, policy.AuthorizeGist(gist, member, GistDelete), ErrOrgAdminRequired)
+		assert.NoError(t, policy.AuthorizeGist(gist, owner, GistDelete))
+
+		_, err := policy.AuthorizeGistCreate(org.ID, viewer, "anything", "")
+		assert.ErrorIs(t, err, ErrOrgViewerReadOnly)
+
+		// Users' own gists are theirs alone, whatever their roles elsewhere
+		own := &models.Gist{UserID: &member.ID, Visibility: models.VisibilityPublic}
+		assert.NoError(t, policy.AuthorizeGist(own, nil, GistRead))
+		assert.NoError(t, policy.AuthorizeGist(own, member, GistDelete))
+		assert.ErrorIs(t, policy.AuthorizeGist(own, owner, GistWrite), ErrGistAccessDenied)
+	})
+
+	t.Run("Enforcement", func(t *testing.T) {
+		visibility := "unlisted"
+		admins := models.OrgGistCreationAdmins
+		pattern := "[a-z]+(-[a-z]+)*"
+		requireTwoFactor := true
+		_, err := policy.UpdateSettings(org.ID, OrgSettingsUpdate{
+			DefaultGistVisibility: &visibility,
+			MemberGistCreation:    &admins,
+			GistNamePattern:       &pattern,
+			AllowedWebhookDomains: []string{" Hooks.Example.com ", ""},
+			RequireTwoFactor:      &requireTwoFactor,
+		})
+		require.NoError(t, err)
+
+		_, err = policy.AuthorizeGistCreate(org.ID, member, "deploy-notes", "")
+		assert.ErrorIs(t, err, ErrOrgTwoFactorRequired)
+
+		member.TwoFactorEnabled = true
+		_, err = policy.AuthorizeGistCreate(org.ID, member, "deploy-notes", "")
+		assert.ErrorIs(t, err, ErrOrgGistCreationDenied)
+
+		_, err = policy.AuthorizeGistCreate(org.ID, owner, "Deploy Notes", "")
+		assert.ErrorIs(t, err, ErrOrgGistNameInvalid)
+
+		got, err := policy.AuthorizeGistCreate(org.ID, owner, "deploy-notes", "")
+		require.NoError(t, err)
+		assert.Equal(t, models.VisibilityUnlisted, got)
+
+		assert.NoError(t, policy.CheckWebhookURL(org.ID, "https://hooks.example.com/x"))
+		assert.NoError(t, policy.CheckWebhookURL(org.ID, "https://ci.hooks.example.com/x"))
+		assert.ErrorIs(t, policy.CheckWebhookURL(org.ID, "https://evilhooks.example.com/x"), ErrOrgWebhookDomainNotAllowed)
+
+		missing, err := policy.MembersWithoutTwoFactor(org.ID)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"member"}, missing)
+	})
+}
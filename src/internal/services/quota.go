@@ -0,0 +1,397 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Built-in quota tiers. Admins can define more under quota.tiers.
+const (
+	QuotaTierFree    = "free"
+	QuotaTierMember  = "member"
+	QuotaTierTrusted = "trusted"
+	QuotaTierAdmin   = "admin"
+)
+
+// Where a user's tier comes from
+const (
+	QuotaSourceAssigned   = "assigned"    // Set by an admin
+	QuotaSourceAdmin      = "admin"       // Instance admins get the admin tier
+	QuotaSourceAccountAge = "account_age" // Promoted automatically as the account ages
+	QuotaSourceDefault    = "default"     // Tiers are disabled; the storage.* limits apply
+)
+
+// quotaSettingsTTL is how long tiers and user assignments are cached
+const quotaSettingsTTL = 30 * time.Second
+
+var (
+	ErrQuotaExceeded    = errors.New("quota exceeded")
+	ErrQuotaUnknownTier = errors.New("unknown quota tier")
+	ErrQuotaSetting     = errors.New("invalid quota setting")
+	ErrQuotaUserMissing = errors.New("user not found")
+)
+
+// QuotaTier is a set of limits. Zero means unlimited.
+type QuotaTier struct {
+	Name        string `json:"name"`
+	MaxGistSize int64  `json:"max_gist_size"` // Bytes across all files of a gist
+	MaxFileSize int64  `json:"max_file_size"` // Bytes per file
+	MaxFiles    int    `json:"max_files"`     // Files per gist
+	RateLimit   int    `json:"rate_limit"`    // Authenticated API requests per rate window
+
+	RateWindowSeconds int    `json:"rate_window_seconds"`
+	Source            string `json:"source,omitempty"` // Set on a user's tier only
+}
+
+// RateWindow returns the tier's rate limit window
+func (t QuotaTier) RateWindow() time.Duration {
+	return time.Duration(t.RateWindowSeconds) * time.Second
+}
+
+// QuotaFile is a file checked against a tier
+type QuotaFile struct {
+	Filename string
+	Size     int64
+}
+
+// QuotaError says which limit a gist exceeds
+type QuotaError struct {
+	Tier    string
+	Limit   string // max_gist_size, max_file_size or max_files
+	Message string
+}
+
+func (e *QuotaError) Error() string {
+	return fmt.Sprintf("%s (%s tier)", e.Message, e.Tier)
+}
+
+// Unwrap lets errors.Is match ErrQuotaExceeded
+func (e *QuotaError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// QuotaService resolves users' quota tiers and enforces their limits.
+// Tier limits come from quota.tiers in the config file, overridden by
+// values saved through the admin settings API.
+type QuotaService struct {
+	db     *gorm.DB
+	config *config.Config
+
+	mu       sync.Mutex
+	tiers    map[string]QuotaTier
+	loadedAt time.Time
+	users    map[uuid.UUID]cachedQuotaTier
+}
+
+type cachedQuotaTier struct {
+	tier    QuotaTier
+	expires time.Time
+}
+
+// NewQuotaService creates a new quota service
+func NewQuotaService(db *gorm.DB, config *config.Config) *QuotaService {
+	return &QuotaService{db: db, config: config, users: make(map[uuid.UUID]cachedQuotaTier)}
+}
+
+// Enabled reports whether quota tiers are in use
+func (s *QuotaService) Enabled() bool {
+	return s.config.GetBool("quota.enabled")
+}
+
+// Tiers returns the defined tiers: the built-in ones first, then any others
+// by name
+func (s *QuotaService) Tiers() []QuotaTier {
+	tiers := s.loadTiers()
+	names := make([]string, 0, len(tiers))
+	for name := range tiers {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		ri, rj := builtinTierRank(names[i]), builtinTierRank(names[j])
+		if ri != rj {
+			return ri < rj
+		}
+		return names[i] < names[j]
+	})
+
+	list := make([]QuotaTier, 0, len(names))
+	for _, name := range names {
+		list = append(list, tiers[name])
+	}
+	return list
+}
+
+// Tier returns the named tier
+func (s *QuotaService) Tier(name string) (QuotaTier, bool) {
+	tier, ok := s.loadTiers()[name]
+	return tier, ok
+}
+
+// TierFor returns the user's tier: the one an admin assigned, the admin
+// tier for instance admins, or otherwise one based on the account's age
+func (s *QuotaService) TierFor(user *models.User) QuotaTier {
+	if !s.Enabled() {
+		return s.defaultTier()
+	}
+	tiers := s.loadTiers()
+	if tier, ok := tiers[user.QuotaTier]; ok && user.QuotaTier != "" {
+		tier.Source = QuotaSourceAssigned
+		return tier
+	}
+	if tier, ok := tiers[QuotaTierAdmin]; ok && user.IsAdmin {
+		tier.Source = QuotaSourceAdmin
+		return tier
+	}
+
+	name := QuotaTierFree
+	age := time.Since(user.CreatedAt)
+	if days := s.config.GetInt("quota.trusted_after_days"); days > 0 && age >= time.Duration(days)*24*time.Hour {
+		name = QuotaTierTrusted
+	} else if days := s.config.GetInt("quota.member_after_days"); days > 0 && age >= time.Duration(days)*24*time.Hour {
+		name = QuotaTierMember
+	}
+	tier, ok := tiers[name]
+	if !ok {
+		tier = s.defaultTier()
+		tier.Name = name
+	}
+	tier.Source = QuotaSourceAccountAge
+	return tier
+}
+
+// TierForUserID returns the tier of the user with the given ID. Results are
+// cached briefly, as this runs on every authenticated API request.
+func (s *QuotaService) TierForUserID(userID uuid.UUID) (QuotaTier, error) {
+	s.mu.Lock()
+	cached, ok := s.users[userID]
+	s.mu.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.tier, nil
+	}
+
+	var user models.User
+	if err := s.db.Select("id", "is_admin", "quota_tier", "created_at").First(&user, "id = ?", userID).Error; err != nil {
+		return QuotaTier{}, err
+	}
+	tier := s.TierFor(&user)
+
+	s.mu.Lock()
+	if len(s.users) > 10000 {
+		s.users = make(map[uuid.UUID]cachedQuotaTier)
+	}
+	s.users[userID] = cachedQuotaTier{tier: tier, expires: time.Now().Add(quotaSettingsTTL)}
+	s.mu.Unlock()
+	return tier, nil
+}
+
+// Assign sets the user's tier. An empty name returns the user to the tier
+// their account age and role give them.
+func (s *QuotaService) Assign(userID uuid.UUID, name string) error {
+	if name != "" {
+		if _, ok := s.Tier(name); !ok {
+			return fmt.Errorf("%w: %q", ErrQuotaUnknownTier, name)
+		}
+	}
+	result := s.db.Model(&models.User{}).Where("id = ?", userID).Update("quota_tier", name)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrQuotaUserMissing
+	}
+
+	s.mu.Lock()
+	delete(s.users, userID)
+	s.mu.Unlock()
+	return nil
+}
+
+// CheckFiles returns a *QuotaError when a gist with these files would
+// exceed the tier's limits
+func (s *QuotaService) CheckFiles(tier QuotaTier, files []QuotaFile) error {
+	if tier.MaxFiles > 0 && len(files) > tier.MaxFiles {
+		return &QuotaError{Tier: tier.Name, Limit: "max_files", Message: fmt.Sprintf("a gist can have at most %d files", tier.MaxFiles)}
+	}
+	var total int64
+	for _, file := range files {
+		if tier.MaxFileSize > 0 && file.Size > tier.MaxFileSize {
+			return &QuotaError{Tier: tier.Name, Limit: "max_file_size", Message: fmt.Sprintf("file %s exceeds maximum size of %d bytes", file.Filename, tier.MaxFileSize)}
+		}
+		total += file.Size
+	}
+	if tier.MaxGistSize > 0 && total > tier.MaxGistSize {
+		return &QuotaError{Tier: tier.Name, Limit: "max_gist_size", Message: fmt.Sprintf("gist exceeds maximum size of %d bytes", tier.MaxGistSize)}
+	}
+	return nil
+}
+
+// CheckUserFiles checks files against the tier of the user with the given ID
+func (s *QuotaService) CheckUserFiles(userID uuid.UUID, files []QuotaFile) error {
+	tier, err := s.TierForUserID(userID)
+	if err != nil {
+		return err
+	}
+	return s.CheckFiles(tier, files)
+}
+
+// defaultTier is what applies while tiers are disabled: the instance-wide
+// storage limits and the authenticated API rate limit
+func (s *QuotaService) defaultTier() QuotaTier {
+	return QuotaTier{
+		Name:              QuotaSourceDefault,
+		MaxFileSize:       s.config.GetInt64("storage.max_file_size"),
+		MaxFiles:          s.config.GetInt("storage.max_files_per_gist"),
+		RateLimit:         s.config.GetInt("ratelimit.authenticated_api"),
+		RateWindowSeconds: s.rateWindowSeconds(),
+		Source:            QuotaSourceDefault,
+	}
+}
+
+func (s *QuotaService) rateWindowSeconds() int {
+	window := s.config.GetDuration("quota.rate_window")
+	if window <= 0 {
+		window = time.Hour
+	}
+	return int(window.Seconds())
+}
+
+// loadTiers returns the tiers, rereading them when the cache is stale
+func (s *QuotaService) loadTiers() map[string]QuotaTier {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tiers != nil && time.Since(s.loadedAt) < quotaSettingsTTL {
+		return s.tiers
+	}
+
+	overrides := map[string]string{}
+	if s.db != nil {
+		var configs []models.SystemConfig
+		s.db.Where("key LIKE ?", "quota.tiers.%").Find(&configs)
+		for _, config := range configs {
+			overrides[config.Key] = config.Value
+		}
+	}
+
+	tiers := make(map[string]QuotaTier)
+	for _, name := range quotaTierNames(s.config, overrides) {
+		tier := QuotaTier{Name: name, RateWindowSeconds: s.rateWindowSeconds()}
+		get := func(field string) int64 {
+			key := "quota.tiers." + name + "." + field
+			if raw, ok := overrides[key]; ok {
+				value, _ := parseQuotaLimit(raw)
+				return value
+			}
+			return s.config.GetInt64(key)
+		}
+		tier.MaxGistSize = get("max_gist_size")
+		tier.MaxFileSize = get("max_file_size")
+		tier.MaxFiles = int(get("max_files"))
+		tier.RateLimit = int(get("rate_limit"))
+		tiers[name] = tier
+	}
+	s.tiers = tiers
+	s.loadedAt = time.Now()
+	return tiers
+}
+
+// quotaTierNames lists the tiers in the config file and the admin settings
+func quotaTierNames(config *config.Config, overrides map[string]string) []string {
+	seen := map[string]bool{}
+	var names []string
+	add := func(name string) {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for name := range config.GetStringMap("quota.tiers") {
+		add(name)
+	}
+	for key := range overrides {
+		if parts := strings.Split(key, "."); len(parts) == 4 {
+			add(parts[2])
+		}
+	}
+	return names
+}
+
+func builtinTierRank(name string) int {
+	for i, builtin := range []string{QuotaTierFree, QuotaTierMember, QuotaTierTrusted, QuotaTierAdmin} {
+		if name == builtin {
+			return i
+		}
+	}
+	return 4
+}
+
+// quotaTierFields are the settable limits of a tier
+var quotaTierFields = []string{"max_gist_size", "max_file_size", "max_files", "rate_limit"}
+
+// QuotaSettingsDefaults returns the tier limits from the config file, for
+// the admin settings API
+func QuotaSettingsDefaults(config *config.Config) map[string]interface{} {
+	defaults := map[string]interface{}{}
+	for _, name := range quotaTierNames(config, nil) {
+		for _, field := range quotaTierFields {
+			key := "quota.tiers." + name + "." + field
+			defaults[key] = config.GetInt64(key)
+		}
+	}
+	return defaults
+}
+
+// ValidateQuotaSetting checks a value saved through the admin settings API.
+// Keys outside quota.tiers are accepted unchanged.
+func ValidateQuotaSetting(key string, value interface{}) error {
+	if !strings.HasPrefix(key, "quota.tiers.") {
+		return nil
+	}
+	parts := strings.Split(key, ".")
+	if len(parts) != 4 || !validTierName(parts[2]) {
+		return fmt.Errorf("%w: %s is not quota.tiers.<name>.<limit>", ErrQuotaSetting, key)
+	}
+	known := false
+	for _, field := range quotaTierFields {
+		known = known || parts[3] == field
+	}
+	if !known {
+		return fmt.Errorf("%w: unknown limit %s, use one of %s", ErrQuotaSetting, parts[3], strings.Join(quotaTierFields, ", "))
+	}
+	if _, err := parseQuotaLimit(fmt.Sprintf("%v", value)); err != nil {
+		return fmt.Errorf("%w: %s must be a whole number, 0 for unlimited", ErrQuotaSetting, key)
+	}
+	return nil
+}
+
+// parseQuotaLimit parses a limit saved through the admin settings API,
+// where JSON numbers arrive as floats and may be stored as 1.048576e+06
+func parseQuotaLimit(raw string) (int64, error) {
+	f, err := strconv.ParseFloat(strings.TrimSpace(raw), 64)
+	if err != nil || f < 0 || f != float64(int64(f)) {
+		return 0, ErrQuotaSetting
+	}
+	return int64(f), nil
+}
+
+func validTierName(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !(r == '_' || r == '-' || r >= 'a' && r <= 'z' || r >= '0' && r <= '9') {
+			return false
+		}
+	}
+	return true
+}
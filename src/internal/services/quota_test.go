@@ -0,0 +1,130 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestQuotaService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}))
+
+	cfg := config.New()
+	cfg.Set("quota.enabled", true)
+	cfg.Set("quota.member_after_days", 7)
+	cfg.Set("quota.trusted_after_days", 90)
+	cfg.Set("quota.rate_window", "1h")
+	cfg.Set("quota.tiers.free.max_gist_size", 100)
+	cfg.Set("quota.tiers.free.max_file_size", 60)
+	cfg.Set("quota.tiers.free.max_files", 2)
+	cfg.Set("quota.tiers.free.rate_limit", 10)
+	cfg.Set("quota.tiers.member.max_files", 5)
+	cfg.Set("quota.tiers.trusted.max_files", 10)
+	cfg.Set("quota.tiers.admin.max_files", 0)
+	quota := NewQuotaService(db, cfg)
+
+	newUser := func(name string, age time.Duration, isAdmin bool) *models.User {
+		user := &models.User{Username: name, Email: name + "@example.com", IsAdmin: isAdmin, CreatedAt: time.Now().Add(-age)}
+		require.NoError(t, db.Create(user).Error)
+		return user
+	}
+	day := 24 * time.Hour
+
+	t.Run("AccountAge", func(t *testing.T) {
+		assert.Equal(t, QuotaTierFree, quota.TierFor(newUser("fresh", time.Hour, false)).Name)
+		assert.Equal(t, QuotaTierMember, quota.TierFor(newUser("settled", 10*day, false)).Name)
+		tier := quota.TierFor(newUser("veteran", 100*day, false))
+		assert.Equal(t, QuotaTierTrusted, tier.Name)
+		assert.Equal(t, QuotaSourceAccountAge, tier.Source)
+
+		tier = quota.TierFor(newUser("root", time.Hour, true))
+		assert.Equal(t, QuotaTierAdmin, tier.Name)
+		assert.Equal(t, QuotaSourceAdmin, tier.Source)
+	})
+
+	t.Run("Limits", func(t *testing.T) {
+		tier, ok := quota.Tier(QuotaTierFree)
+		require.True(t, ok)
+		assert.Equal(t, 3600, tier.RateWindowSeconds)
+
+		assert.NoError(t, quota.CheckFiles(tier, []QuotaFile{{Filename: "a", Size: 50}, {Filename: "b", Size: 50}}))
+
+		var quotaErr *QuotaError
+		err := quota.CheckFiles(tier, []QuotaFile{{Filename: "a"}, {Filename: "b"}, {Filename: "c"}})
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, "max_files", quotaErr.Limit)
+		assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+		err = quota.CheckFiles(tier, []QuotaFile{{Filename: "a", Size: 61}})
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, "max_file_size", quotaErr.Limit)
+
+		err = quota.CheckFiles(tier, []QuotaFile{{Filename: "a", Size: 60}, {Filename: "b", Size: 60}})
+		require.ErrorAs(t, err, &quotaErr)
+		assert.Equal(t, "max_gist_size", quotaErr.Limit)
+
+		admin, _ := quota.Tier(QuotaTierAdmin)
+		assert.NoError(t, quota.CheckFiles(admin, make([]QuotaFile, 1000)))
+	})
+
+	t.Run("Assign", func(t *testing.T) {
+		user := newUser("promoted", time.Hour, false)
+		tier, err := quota.TierForUserID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, QuotaTierFree, tier.Name)
+
+		require.NoError(t, quota.Assign(user.ID, QuotaTierTrusted))
+		tier, err = quota.TierForUserID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, QuotaTierTrusted, tier.Name)
+		assert.Equal(t, QuotaSourceAssigned, tier.Source)
+
+		assert.ErrorIs(t, quota.Assign(user.ID, "platinum"), ErrQuotaUnknownTier)
+
+		require.NoError(t, quota.Assign(user.ID, ""))
+		tier, err = quota.TierForUserID(user.ID)
+		require.NoError(t, err)
+		assert.Equal(t, QuotaTierFree, tier.Name)
+	})
+
+	t.Run("AdminSettings", func(t *testing.T) {
+		assert.NoError(t, ValidateQuotaSetting("quota.tiers.partner.max_files", 500.0))
+		assert.NoError(t, ValidateQuotaSetting("search.enabled", "anything"))
+		assert.ErrorIs(t, ValidateQuotaSetting("quota.tiers.partner.max_stars", 1), ErrQuotaSetting)
+		assert.ErrorIs(t, ValidateQuotaSetting("quota.tiers.Partner!.max_files", 1), ErrQuotaSetting)
+		assert.ErrorIs(t, ValidateQuotaSetting("quota.tiers.partner.max_files", -1), ErrQuotaSetting)
+		assert.ErrorIs(t, ValidateQuotaSetting("quota.tiers.partner.max_files", 1.5), ErrQuotaSetting)
+
+		// Saved settings add tiers and override the config file
+		require.NoError(t, db.Create(&models.SystemConfig{Key: "quota.tiers.partner.max_files", Value: "500"}).Error)
+		require.NoError(t, db.Create(&models.SystemConfig{Key: "quota.tiers.free.max_files", Value: "1.048576e+06"}).Error)
+		fresh := NewQuotaService(db, cfg)
+
+		partner, ok := fresh.Tier("partner")
+		require.True(t, ok)
+		assert.Equal(t, 500, partner.MaxFiles)
+		free, _ := fresh.Tier(QuotaTierFree)
+		assert.Equal(t, 1048576, free.MaxFiles)
+
+		var names []string
+		for _, tier := range fresh.Tiers() {
+			names = append(names, tier.Name)
+		}
+		assert.Equal(t, []string{"free", "member", "trusted", "admin", "partner"}, names)
+	})
+
+	t.Run("Disabled", func(t *testing.T) {
+		disabled := config.New()
+		disabled.Set("quota.enabled", false)
+		disabled.Set("storage.max_files_per_gist", 3)
+		tier := NewQuotaService(db, disabled).TierFor(newUser("legacy", 100*day, false))
+		assert.Equal(t, QuotaSourceDefault, tier.Source)
+		assert.Equal(t, 3, tier.MaxFiles)
+	})
+}
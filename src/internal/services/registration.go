@@ -0,0 +1,300 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// Invite code limits
+const (
+	MaxInviteLabelLength = 100
+	MaxInviteUses        = 10000
+	inviteCodePrefix     = "inv_"
+)
+
+var (
+	ErrRegistrationClosed = errors.New("registration is closed")
+	ErrRegistrationDomain = errors.New("sign up with this email domain isn't allowed")
+	ErrInviteRequired     = errors.New("an invite code is required to sign up")
+	ErrInviteInvalid      = errors.New("invite code is invalid, expired or used up")
+	ErrInviteNotFound     = errors.New("invite code not found")
+	ErrInviteExpiry       = errors.New("expiry must be in the future")
+	ErrInviteLabel        = fmt.Errorf("label must be at most %d characters", MaxInviteLabelLength)
+	ErrInviteUses         = fmt.Errorf("max_uses must be 0 to %d", MaxInviteUses)
+	ErrNotPendingApproval = errors.New("user isn't awaiting approval")
+	ErrSignupUserNotFound = errors.New("user not found")
+)
+
+// RegistrationService decides who may sign up. features.registration
+// opens or closes sign up; registration.invite_only asks for an invite
+// code; registration.allowed_domains and registration.denied_domains
+// restrict email addresses; and registration.require_approval keeps new
+// accounts inactive until an admin approves them. Accounts signed up with
+// an invite code need no approval.
+type RegistrationService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+// NewRegistrationService creates a new registration service
+func NewRegistrationService(db *gorm.DB, config *config.Config) *RegistrationService {
+	return &RegistrationService{db: db, config: config}
+}
+
+// SignupInput is a new account asked for through registration
+type SignupInput struct {
+	Username     string
+	Email        string
+	PasswordHash string
+	InviteCode   string
+}
+
+// CreateInviteInput describes a new invite code
+type CreateInviteInput struct {
+	Label     string
+	MaxUses   int        // 0 for no limit
+	ExpiresAt *time.Time // Nil for a code that doesn't expire
+}
+
+// Open reports whether anyone may sign up
+func (s *RegistrationService) Open() bool {
+	return s.config.GetBool("features.registration")
+}
+
+// InviteOnly reports whether signing up needs an invite code
+func (s *RegistrationService) InviteOnly() bool {
+	return s.config.GetBool("registration.invite_only")
+}
+
+// RequireApproval reports whether new accounts wait for an admin
+func (s *RegistrationService) RequireApproval() bool {
+	return s.config.GetBool("registration.require_approval")
+}
+
+// CheckEmail checks the email address against the domain lists. A domain
+// listed covers its subdomains too; the deny list wins.
+func (s *RegistrationService) CheckEmail(email string) error {
+	_, domain, found := strings.Cut(strings.ToLower(strings.TrimSpace(email)), "@")
+	if !found || domain == "" {
+		return ErrRegistrationDomain
+	}
+	if matchDomain(domain, s.config.GetStringSlice("registration.denied_domains")) {
+		return ErrRegistrationDomain
+	}
+	allowed := s.config.GetStringSlice("registration.allowed_domains")
+	if len(allowed) > 0 && !matchDomain(domain, allowed) {
+		return ErrRegistrationDomain
+	}
+	return nil
+}
+
+// ProviderSignup reports whether signing in with an OAuth provider may
+// create accounts. Provider sign in can't carry an invite code or wait for
+// approval, so it only creates accounts when neither is needed; the email
+// address still has to pass CheckEmail.
+func (s *RegistrationService) ProviderSignup() bool {
+	return s.Open() && !s.InviteOnly() && !s.RequireApproval()
+}
+
+// Signup creates the account of someone signing up. It returns the
+// account inactive and pending approval when an admin has to approve it.
+// Checking the username and email address are free is left to the caller.
+func (s *RegistrationService) Signup(input SignupInput) (*models.User, error) {
+	if !s.Open() {
+		return nil, ErrRegistrationClosed
+	}
+	if err := s.CheckEmail(input.Email); err != nil {
+		return nil, err
+	}
+	code := strings.TrimSpace(input.InviteCode)
+	if code == "" && s.InviteOnly() {
+		return nil, ErrInviteRequired
+	}
+
+	user := &models.User{
+		Username:     input.Username,
+		Email:        input.Email,
+		PasswordHash: input.PasswordHash,
+		IsActive:     true,
+		DisplayName:  input.Username,
+	}
+	err := s.db.Transaction(func(tx *gorm.DB) error {
+		if code != "" {
+			invite, err := redeemInvite(tx, code)
+			if err != nil {
+				return err
+			}
+			user.InviteCodeID = &invite.ID
+		} else if s.RequireApproval() {
+			user.PendingApproval = true
+		}
+		if err := tx.Create(user).Error; err != nil {
+			return err
+		}
+		// is_active defaults to true, so a false one isn't written on create
+		if user.PendingApproval {
+			return tx.Model(user).Update("is_active", false).Error
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// redeemInvite uses up one use of an active invite code. The use count is
+// raised in the same statement that checks it, so concurrent sign ups
+// can't go past max_uses.
+func redeemInvite(tx *gorm.DB, code string) (*models.InviteCode, error) {
+	var invite models.InviteCode
+	if err := tx.First(&invite, "code_hash = ?", auth.HashToken(code)).Error; err != nil {
+		return nil, ErrInviteInvalid
+	}
+	now := time.Now()
+	if !invite.Active(now) {
+		return nil, ErrInviteInvalid
+	}
+	result := tx.Model(&models.InviteCode{}).
+		Where("id = ? AND (max_uses = 0 OR use_count < max_uses)", invite.ID).
+		UpdateColumn("use_count", gorm.Expr("use_count + 1"))
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil, ErrInviteInvalid
+	}
+	invite.UseCount++
+	return &invite, nil
+}
+
+// CreateInvite creates an invite code and returns it with the code, which
+// is only available now
+func (s *RegistrationService) CreateInvite(admin *models.User, input CreateInviteInput) (*models.InviteCode, string, error) {
+	input.Label = strings.TrimSpace(input.Label)
+	if len(input.Label) > MaxInviteLabelLength {
+		return nil, "", ErrInviteLabel
+	}
+	if input.MaxUses < 0 || input.MaxUses > MaxInviteUses {
+		return nil, "", ErrInviteUses
+	}
+	if input.ExpiresAt != nil && !input.ExpiresAt.After(time.Now()) {
+		return nil, "", ErrInviteExpiry
+	}
+
+	secret, err := auth.GenerateSecureToken(18)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to generate code: %w", err)
+	}
+	code := inviteCodePrefix + strings.TrimRight(secret, "=")
+	invite := &models.InviteCode{
+		CreatedByID: admin.ID,
+		Label:       input.Label,
+		CodeHash:    auth.HashToken(code),
+		CodePrefix:  code[:8],
+		MaxUses:     input.MaxUses,
+		ExpiresAt:   input.ExpiresAt,
+	}
+	if err := s.db.Create(invite).Error; err != nil {
+		return nil, "", err
+	}
+	return invite, code, nil
+}
+
+// Invites returns every invite code, newest first, including used up,
+// expired and revoked ones
+func (s *RegistrationService) Invites() ([]models.InviteCode, error) {
+	invites := []models.InviteCode{}
+	err := s.db.Order("created_at DESC").Find(&invites).Error
+	return invites, err
+}
+
+// RevokeInvite stops an invite code from working. Accounts already signed
+// up with it stay. Revoking it again changes nothing.
+func (s *RegistrationService) RevokeInvite(inviteID uuid.UUID) (*models.InviteCode, error) {
+	var invite models.InviteCode
+	if err := s.db.First(&invite, "id = ?", inviteID).Error; err != nil {
+		return nil, ErrInviteNotFound
+	}
+	if invite.RevokedAt == nil {
+		now := time.Now()
+		if err := s.db.Model(&invite).Update("revoked_at", now).Error; err != nil {
+			return nil, err
+		}
+		invite.RevokedAt = &now
+	}
+	return &invite, nil
+}
+
+// Pending returns a page of the accounts awaiting approval, oldest first
+func (s *RegistrationService) Pending(page, limit int) ([]models.User, int64, error) {
+	query := s.db.Model(&models.User{}).Where("pending_approval = ?", true)
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+	users := []models.User{}
+	err := query.Order("created_at").Limit(limit).Offset((page - 1) * limit).Find(&users).Error
+	return users, total, err
+}
+
+// Approve activates an account awaiting approval
+func (s *RegistrationService) Approve(userID uuid.UUID) (*models.User, error) {
+	user, err := s.pendingUser(userID)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.db.Model(user).Updates(map[string]interface{}{
+		"pending_approval": false,
+		"is_active":        true,
+	}).Error; err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// Reject deletes an account awaiting approval. It never signed in, so
+// nothing else refers to it and it is removed for good, freeing its
+// username and email address.
+func (s *RegistrationService) Reject(userID uuid.UUID) error {
+	user, err := s.pendingUser(userID)
+	if err != nil {
+		return err
+	}
+	return s.db.Unscoped().Delete(user).Error
+}
+
+func (s *RegistrationService) pendingUser(userID uuid.UUID) (*models.User, error) {
+	var user models.User
+	if err := s.db.First(&user, "id = ?", userID).Error; err != nil {
+		return nil, ErrSignupUserNotFound
+	}
+	if !user.PendingApproval {
+		return nil, ErrNotPendingApproval
+	}
+	return &user, nil
+}
+
+// matchDomain reports whether domain is one of the listed domains or a
+// subdomain of one
+func matchDomain(domain string, domains []string) bool {
+	for _, listed := range domains {
+		listed = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(listed)), "@")
+		if listed == "" {
+			continue
+		}
+		if domain == listed || strings.HasSuffix(domain, "."+listed) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,152 @@
+package services
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestRegistrationService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.InviteCode{}))
+
+	config := config.New()
+	config.Set("features.registration", true)
+	service := NewRegistrationService(db, config)
+
+	admin := &models.User{Username: "admin", Email: "admin@example.com", IsAdmin: true}
+	require.NoError(t, db.Create(admin).Error)
+
+	signup := func(name, invite string) (*models.User, error) {
+		return service.Signup(SignupInput{Username: name, Email: name + "@example.com", PasswordHash: "x", InviteCode: invite})
+	}
+
+	t.Run("Closed", func(t *testing.T) {
+		config.Set("features.registration", false)
+		defer config.Set("features.registration", true)
+		_, err := signup("closed", "")
+		assert.ErrorIs(t, err, ErrRegistrationClosed)
+		assert.False(t, service.ProviderSignup())
+	})
+
+	t.Run("Domains", func(t *testing.T) {
+		config.Set("registration.allowed_domains", []string{"example.com", "@corp.test"})
+		config.Set("registration.denied_domains", []string{"spam.example.com"})
+		defer config.Set("registration.allowed_domains", []string{})
+		defer config.Set("registration.denied_domains", []string{})
+
+		assert.NoError(t, service.CheckEmail("alice@example.com"))
+		assert.NoError(t, service.CheckEmail("Bob@Dev.Example.COM"))
+		assert.NoError(t, service.CheckEmail("carol@corp.test"))
+		assert.ErrorIs(t, service.CheckEmail("eve@notexample.com"), ErrRegistrationDomain)
+		assert.ErrorIs(t, service.CheckEmail("eve@spam.example.com"), ErrRegistrationDomain)
+		assert.ErrorIs(t, service.CheckEmail("eve@a.spam.example.com"), ErrRegistrationDomain)
+		assert.ErrorIs(t, service.CheckEmail("no-domain"), ErrRegistrationDomain)
+	})
+
+	t.Run("InviteOnly", func(t *testing.T) {
+		config.Set("registration.invite_only", true)
+		defer config.Set("registration.invite_only", false)
+		assert.False(t, service.ProviderSignup())
+
+		_, err := signup("uninvited", "")
+		assert.ErrorIs(t, err, ErrInviteRequired)
+		_, err = signup("guesser", "inv_guess")
+		assert.ErrorIs(t, err, ErrInviteInvalid)
+
+		invite, code, err := service.CreateInvite(admin, CreateInviteInput{Label: " Team ", MaxUses: 2})
+		require.NoError(t, err)
+		assert.True(t, strings.HasPrefix(code, inviteCodePrefix))
+		assert.Equal(t, code[:8], invite.CodePrefix)
+		assert.Equal(t, "Team", invite.Label)
+
+		first, err := signup("first", code)
+		require.NoError(t, err)
+		assert.True(t, first.IsActive)
+		assert.Equal(t, invite.ID, *first.InviteCodeID)
+		_, err = signup("second", code)
+		require.NoError(t, err)
+		_, err = signup("third", code)
+		assert.ErrorIs(t, err, ErrInviteInvalid)
+
+		var stored models.InviteCode
+		require.NoError(t, db.First(&stored, "id = ?", invite.ID).Error)
+		assert.Equal(t, 2, stored.UseCount)
+		assert.False(t, stored.Active(time.Now()))
+	})
+
+	t.Run("InviteLimits", func(t *testing.T) {
+		past := time.Now().Add(-time.Minute)
+		_, _, err := service.CreateInvite(admin, CreateInviteInput{ExpiresAt: &past})
+		assert.ErrorIs(t, err, ErrInviteExpiry)
+		_, _, err = service.CreateInvite(admin, CreateInviteInput{MaxUses: -1})
+		assert.ErrorIs(t, err, ErrInviteUses)
+
+		// 0 uses means no limit
+		unlimited, code, err := service.CreateInvite(admin, CreateInviteInput{})
+		require.NoError(t, err)
+		var stored models.InviteCode
+		require.NoError(t, db.First(&stored, "id = ?", unlimited.ID).Error)
+		assert.Zero(t, stored.MaxUses)
+		for _, name := range []string{"many1", "many2", "many3"} {
+			_, err := signup(name, code)
+			require.NoError(t, err)
+		}
+
+		revoked, err := service.RevokeInvite(unlimited.ID)
+		require.NoError(t, err)
+		assert.NotNil(t, revoked.RevokedAt)
+		_, err = signup("late", code)
+		assert.ErrorIs(t, err, ErrInviteInvalid)
+	})
+
+	t.Run("Approval", func(t *testing.T) {
+		config.Set("registration.require_approval", true)
+		defer config.Set("registration.require_approval", false)
+		assert.False(t, service.ProviderSignup())
+
+		pending, err := signup("pending", "")
+		require.NoError(t, err)
+		var stored models.User
+		require.NoError(t, db.First(&stored, "id = ?", pending.ID).Error)
+		assert.False(t, stored.IsActive)
+		assert.True(t, stored.PendingApproval)
+
+		// An invite code skips the queue
+		_, code, err := service.CreateInvite(admin, CreateInviteInput{MaxUses: 1})
+		require.NoError(t, err)
+		invited, err := signup("invited", code)
+		require.NoError(t, err)
+		assert.True(t, invited.IsActive)
+		assert.False(t, invited.PendingApproval)
+
+		rejected, err := signup("rejected", "")
+		require.NoError(t, err)
+
+		users, total, err := service.Pending(1, 20)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		require.Len(t, users, 2)
+		assert.Equal(t, "pending", users[0].Username)
+
+		approved, err := service.Approve(pending.ID)
+		require.NoError(t, err)
+		require.NoError(t, db.First(&stored, "id = ?", approved.ID).Error)
+		assert.True(t, stored.IsActive)
+		assert.False(t, stored.PendingApproval)
+		_, err = service.Approve(pending.ID)
+		assert.ErrorIs(t, err, ErrNotPendingApproval)
+
+		require.NoError(t, service.Reject(rejected.ID))
+		var count int64
+		db.Unscoped().Model(&models.User{}).Where("id = ?", rejected.ID).Count(&count)
+		assert.Zero(t, count)
+		assert.ErrorIs(t, service.Reject(invited.ID), ErrNotPendingApproval)
+	})
+}
@@ -8,10 +8,10 @@ import (
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
 	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
@@ -56,13 +56,13 @@ type SearchOptions struct {
 // SearchService handles search operations
 type SearchService struct {
 	db      *gorm.DB
-	cfg     *viper.Viper
+	cfg     *config.Config
 	backend SearchBackend
 	cache   *cache.CacheManager
 }
 
 // NewSearchService creates a new search service
-func NewSearchService(db *gorm.DB, cfg *viper.Viper, cacheManager *cache.CacheManager) *SearchService {
+func NewSearchService(db *gorm.DB, cfg *config.Config, cacheManager *cache.CacheManager) *SearchService {
 	backend := SearchBackendSQLite
 	if cfg.GetBool("redis.enabled") {
 		backend = SearchBackendRedis
@@ -133,7 +133,7 @@ func (s *SearchService) Search(ctx context.Context, opts SearchOptions, viewerID
 			Results: results,
 			Total:   total,
 		}
-		s.cache.SetJSON(ctx, cacheKey, cachedResult, cache.TTLShort)
+		s.cache.SetJSON(ctx, cacheKey, cachedResult, s.cache.TTL(cache.TierShort))
 	}
 
 	return results, total, nil
@@ -201,7 +201,7 @@ func (s *SearchService) searchSQLite(ctx context.Context, opts SearchOptions, vi
 
 // searchGists searches for gists
 func (s *SearchService) searchGists(db *gorm.DB, pattern string, opts SearchOptions, viewerID *uuid.UUID) ([]SearchResult, int64, error) {
-	query := db.Model(&models.Gist{})
+	query := db.Model(&models.Gist{}).Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated)
 
 	// Apply visibility filter
 	if viewerID == nil {
@@ -298,7 +298,7 @@ func (s *SearchService) searchUsers(db *gorm.DB, pattern string, opts SearchOpti
 	}
 
 	// Only search active users
-	query = query.Where("is_active = ?", true)
+	query = query.Where("is_active = ? AND deactivated_at IS NULL", true)
 
 	// Count total
 	var total int64
@@ -348,7 +348,8 @@ func (s *SearchService) searchUsers(db *gorm.DB, pattern string, opts SearchOpti
 func (s *SearchService) searchFiles(db *gorm.DB, pattern string, opts SearchOptions, viewerID *uuid.UUID) ([]SearchResult, int64, error) {
 	query := db.Model(&models.GistFile{}).
 		Joins("JOIN gists ON gist_files.gist_id = gists.id").
-		Joins("JOIN users ON gists.user_id = users.id")
+		Joins("JOIN users ON gists.user_id = users.id").
+		Scopes(models.NotDeleted("gists"), models.NotDeleted("users"))
 
 	// Apply visibility filter
 	if viewerID == nil {
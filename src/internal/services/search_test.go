@@ -0,0 +1,30 @@
+package services
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestSearchUsersHidesDeactivated(t *testing.T) {
+	db := setupTestDB(t)
+	search := NewSearchService(db, config.New(), nil)
+
+	active := &models.User{Username: "gopher-active", Email: "active@example.com", IsActive: true}
+	deactivated := &models.User{Username: "gopher-gone", Email: "gone@example.com", IsActive: true}
+	require.NoError(t, db.Create(active).Error)
+	require.NoError(t, db.Create(deactivated).Error)
+	require.NoError(t, db.Model(deactivated).Update("deactivated_at", time.Now()).Error)
+
+	results, total, err := search.Search(context.Background(), SearchOptions{Query: "gopher", Type: "user"}, nil)
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), total)
+	require.Len(t, results, 1)
+	assert.Equal(t, active.ID, results[0].ID)
+}
@@ -0,0 +1,274 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// MaxTagLength is the longest tag name, as stored in the tags table
+const MaxTagLength = 50
+
+var (
+	ErrTagInvalid   = fmt.Errorf("tag names must be 1 to %d characters and can't contain commas", MaxTagLength)
+	ErrTagNotFound  = errors.New("tag not found")
+	ErrTagMergeSelf = errors.New("a tag can't be merged into itself")
+)
+
+// TagCount is a tag and how many gists carry it
+type TagCount struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// TagScope limits tag queries to what a viewer may browse. Anonymous
+// viewers see public gists; signed in viewers also see their own.
+type TagScope struct {
+	Viewer  *models.User
+	Sandbox bool
+}
+
+// TagService lists and browses the tags of gists and lets admins rename
+// and merge them. Tags are read from the gists' tags_string, which every
+// way of creating and editing gists keeps up to date.
+type TagService struct {
+	db *gorm.DB
+}
+
+// NewTagService creates a new tag service
+func NewTagService(db *gorm.DB) *TagService {
+	return &TagService{db: db}
+}
+
+// NormalizeTag returns the stored form of a tag name
+func NormalizeTag(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if name == "" || len(name) > MaxTagLength || strings.Contains(name, ",") {
+		return "", ErrTagInvalid
+	}
+	return name, nil
+}
+
+// Tagged is a query scope that selects gists carrying the tag, which must
+// be normalized
+func Tagged(tag string) func(*gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where(taggedClause, taggedArgs(tag)...)
+	}
+}
+
+// taggedClause matches a tag anywhere in the comma separated tags_string
+const taggedClause = `gists.tags_string = ? OR gists.tags_string LIKE ? ESCAPE '!' OR gists.tags_string LIKE ? ESCAPE '!' OR gists.tags_string LIKE ? ESCAPE '!'`
+
+// taggedArgs returns the arguments of taggedClause for a tag
+func taggedArgs(tag string) []interface{} {
+	escaped := escapeTagPattern(tag)
+	return []interface{}{tag, escaped + ",%", "%," + escaped, "%," + escaped + ",%"}
+}
+
+// escapeTagPattern escapes the LIKE wildcards in a tag. '!' is the escape
+// character, as in search.
+func escapeTagPattern(tag string) string {
+	return strings.NewReplacer("!", "!!", "%", "!%", "_", "!_").Replace(tag)
+}
+
+// visible selects the gists the scope may browse
+func (s *TagService) visible(scope TagScope) *gorm.DB {
+	query := s.db.Model(&models.Gist{}).
+		Scopes(models.HideDeactivatedOwners, models.HideExpired, models.HideModerated, models.SandboxScope(scope.Sandbox))
+	if scope.Viewer == nil {
+		return query.Where("gists.visibility = ?", models.VisibilityPublic)
+	}
+	return query.Where("gists.visibility = ? OR gists.user_id = ?", models.VisibilityPublic, scope.Viewer.ID)
+}
+
+// Popular returns the most used tags of public gists, most used first.
+// Prefix, when set, keeps only tags starting with it; limit <= 0 returns
+// every tag.
+func (s *TagService) Popular(sandbox bool, prefix string, limit int) ([]TagCount, error) {
+	var tagStrings []string
+	if err := s.visible(TagScope{Sandbox: sandbox}).
+		Where("gists.tags_string <> ''").
+		Pluck("gists.tags_string", &tagStrings).Error; err != nil {
+		return nil, err
+	}
+
+	prefix = strings.ToLower(strings.TrimSpace(prefix))
+	counts := make(map[string]int)
+	for _, tagString := range tagStrings {
+		for _, tag := range models.NormalizeTags(strings.Split(tagString, ",")) {
+			if strings.HasPrefix(tag, prefix) {
+				counts[tag]++
+			}
+		}
+	}
+
+	tags := make([]TagCount, 0, len(counts))
+	for name, count := range counts {
+		tags = append(tags, TagCount{Name: name, Count: count})
+	}
+	sort.Slice(tags, func(i, j int) bool {
+		if tags[i].Count != tags[j].Count {
+			return tags[i].Count > tags[j].Count
+		}
+		return tags[i].Name < tags[j].Name
+	})
+	if limit > 0 && len(tags) > limit {
+		tags = tags[:limit]
+	}
+	return tags, nil
+}
+
+// Gists returns a page of the gists carrying the tag, newest first
+func (s *TagService) Gists(tag string, scope TagScope, page, limit int) ([]models.Gist, int64, error) {
+	tag, err := NormalizeTag(tag)
+	if err != nil {
+		return nil, 0, err
+	}
+	query := s.visible(scope).Scopes(Tagged(tag))
+
+	var total int64
+	if err := query.Count(&total).Error; err != nil {
+		return nil, 0, err
+	}
+
+	var gists []models.Gist
+	if err := query.Preload("User", ListedUser).
+		Order("gists.created_at DESC").
+		Offset((page - 1) * limit).
+		Limit(limit).
+		Find(&gists).Error; err != nil {
+		return nil, 0, err
+	}
+	return gists, total, nil
+}
+
+// Rename renames a tag on every gist carrying it. Renaming to a tag that is
+// already in use merges the two.
+func (s *TagService) Rename(from, to string) (int64, error) {
+	return s.Merge([]string{from}, to)
+}
+
+// Merge replaces the source tags with the target on every gist, deleted
+// ones included so restoring them doesn't bring the old tags back. It
+// returns how many gists changed.
+func (s *TagService) Merge(sources []string, target string) (int64, error) {
+	target, err := NormalizeTag(target)
+	if err != nil {
+		return 0, err
+	}
+	replaced := make(map[string]bool, len(sources))
+	for _, source := range sources {
+		source, err := NormalizeTag(source)
+		if err != nil {
+			return 0, err
+		}
+		if source == target {
+			return 0, ErrTagMergeSelf
+		}
+		replaced[source] = true
+	}
+	if len(replaced) == 0 {
+		return 0, ErrTagInvalid
+	}
+
+	var changed int64
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		clauses := make([]string, 0, len(replaced))
+		args := make([]interface{}, 0, 4*len(replaced))
+		for source := range replaced {
+			clauses = append(clauses, taggedClause)
+			args = append(args, taggedArgs(source)...)
+		}
+
+		var gists []models.Gist
+		if err := tx.Unscoped().Model(&models.Gist{}).Select("id", "tags_string").
+			Where(strings.Join(clauses, " OR "), args...).Find(&gists).Error; err != nil {
+			return err
+		}
+		for _, gist := range gists {
+			tags := gist.TagList()
+			for i, tag := range tags {
+				if replaced[tag] {
+					tags[i] = target
+				}
+			}
+			gist.SetTags(tags)
+			// Not an edit of the gist, so updated_at stays
+			if err := tx.Unscoped().Model(&models.Gist{}).Where("id = ?", gist.ID).
+				UpdateColumn("tags_string", gist.TagsString).Error; err != nil {
+				return err
+			}
+		}
+		changed = int64(len(gists))
+
+		found, err := s.mergeTagRows(tx, replaced, target)
+		if err != nil {
+			return err
+		}
+		if changed == 0 && !found {
+			return ErrTagNotFound
+		}
+		return nil
+	})
+	return changed, err
+}
+
+// mergeTagRows applies a merge to the tags and gist_tags tables and reports
+// whether any source tag had a row
+func (s *TagService) mergeTagRows(tx *gorm.DB, replaced map[string]bool, target string) (bool, error) {
+	names := make([]string, 0, len(replaced))
+	for name := range replaced {
+		names = append(names, name)
+	}
+	var sourceTags []models.Tag
+	if err := tx.Where("name IN ?", names).Find(&sourceTags).Error; err != nil {
+		return false, err
+	}
+	if len(sourceTags) == 0 {
+		return false, nil
+	}
+
+	var targetTag models.Tag
+	if err := tx.FirstOrCreate(&targetTag, models.Tag{Name: target}).Error; err != nil {
+		return false, err
+	}
+	sourceIDs := make([]uuid.UUID, 0, len(sourceTags))
+	for _, tag := range sourceTags {
+		sourceIDs = append(sourceIDs, tag.ID)
+	}
+
+	// Gists already carrying the target keep their one link
+	alreadyTagged := tx.Model(&models.GistTag{}).Select("gist_id").Where("tag_id = ?", targetTag.ID)
+	if err := tx.Where("tag_id IN ? AND gist_id IN (?)", sourceIDs, alreadyTagged).
+		Delete(&models.GistTag{}).Error; err != nil {
+		return false, err
+	}
+	var links []models.GistTag
+	if err := tx.Where("tag_id IN ?", sourceIDs).Find(&links).Error; err != nil {
+		return false, err
+	}
+	seen := make(map[uuid.UUID]bool, len(links))
+	for _, link := range links {
+		if seen[link.GistID] {
+			continue
+		}
+		seen[link.GistID] = true
+		if err := tx.Create(&models.GistTag{GistID: link.GistID, TagID: targetTag.ID}).Error; err != nil {
+			return false, err
+		}
+	}
+	if err := tx.Where("tag_id IN ?", sourceIDs).Delete(&models.GistTag{}).Error; err != nil {
+		return false, err
+	}
+	if err := tx.Where("id IN ?", sourceIDs).Delete(&models.Tag{}).Error; err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,112 @@
+package services
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestTagService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.GistFile{}, &models.Tag{}, &models.GistTag{}))
+
+	service := NewTagService(db)
+
+	owner := &models.User{Username: "owner", Email: "owner@example.com"}
+	require.NoError(t, db.Create(owner).Error)
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+
+	gist := func(title string, visibility models.Visibility, tags string) *models.Gist {
+		g := &models.Gist{UserID: &owner.ID, Title: title, Visibility: visibility, GitRepoPath: title, TagsString: tags}
+		require.NoError(t, db.Create(g).Error)
+		return g
+	}
+	gist("one", models.VisibilityPublic, "go,cli")
+	gist("two", models.VisibilityPublic, "go")
+	gist("three", models.VisibilityPublic, "golang,web")
+	gist("four", models.VisibilityPrivate, "go,secret")
+	gist("five", models.VisibilityPublic, "100%_done")
+	expired := gist("six", models.VisibilityPublic, "go")
+	past := time.Now().Add(-time.Minute)
+	require.NoError(t, db.Model(expired).Update("expires_at", past).Error)
+
+	t.Run("Popular", func(t *testing.T) {
+		tags, err := service.Popular(false, "", 0)
+		require.NoError(t, err)
+		assert.Equal(t, []TagCount{
+			{Name: "go", Count: 2},
+			{Name: "100%_done", Count: 1},
+			{Name: "cli", Count: 1},
+			{Name: "golang", Count: 1},
+			{Name: "web", Count: 1},
+		}, tags)
+
+		tags, err = service.Popular(false, "GO", 1)
+		require.NoError(t, err)
+		assert.Equal(t, []TagCount{{Name: "go", Count: 2}}, tags)
+	})
+
+	t.Run("Gists", func(t *testing.T) {
+		gists, total, err := service.Gists("Go", TagScope{}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+		assert.Len(t, gists, 2)
+
+		// Owners also see their private gists; others don't
+		_, total, err = service.Gists("go", TagScope{Viewer: owner}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 3, total)
+		_, total, err = service.Gists("go", TagScope{Viewer: alice}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, total)
+
+		// Wildcards in tags match literally
+		_, total, err = service.Gists("100%_done", TagScope{}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 1, total)
+		_, total, err = service.Gists("100%", TagScope{}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 0, total)
+
+		_, _, err = service.Gists("a,b", TagScope{}, 1, 10)
+		assert.ErrorIs(t, err, ErrTagInvalid)
+	})
+
+	t.Run("Merge", func(t *testing.T) {
+		tag := models.Tag{Name: "golang"}
+		require.NoError(t, db.Create(&tag).Error)
+
+		changed, err := service.Merge([]string{"golang", "cli"}, "go")
+		require.NoError(t, err)
+		assert.EqualValues(t, 2, changed)
+
+		var stored []models.Gist
+		require.NoError(t, db.Order("title").Where("title IN ?", []string{"one", "three"}).Find(&stored).Error)
+		assert.Equal(t, "go", stored[0].TagsString)
+		assert.Equal(t, "go,web", stored[1].TagsString)
+
+		var count int64
+		db.Model(&models.Tag{}).Where("name = ?", "golang").Count(&count)
+		assert.Zero(t, count)
+
+		_, err = service.Rename("go", "go")
+		assert.ErrorIs(t, err, ErrTagMergeSelf)
+		_, err = service.Rename("missing", "go")
+		assert.ErrorIs(t, err, ErrTagNotFound)
+	})
+
+	t.Run("Rename", func(t *testing.T) {
+		changed, err := service.Rename("go", "Golang")
+		require.NoError(t, err)
+		assert.EqualValues(t, 5, changed)
+
+		_, total, err := service.Gists("golang", TagScope{Viewer: owner}, 1, 10)
+		require.NoError(t, err)
+		assert.EqualValues(t, 4, total)
+	})
+}
@@ -0,0 +1,114 @@
+package services
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// RecoveryCodeCount is how many recovery codes a user gets at a time
+const RecoveryCodeCount = 10
+
+var (
+	ErrRecoveryCodeInvalid = errors.New("invalid recovery code")
+	ErrTwoFactorNotEnabled = errors.New("2FA is not enabled")
+)
+
+// TwoFactorService keeps the recovery codes of users with two-factor
+// authentication and decides whether users have to enroll a second factor.
+// auth.require_2fa makes every user enroll an authenticator app or a
+// security key before they can use the API.
+type TwoFactorService struct {
+	db     *gorm.DB
+	config *config.Config
+}
+
+// NewTwoFactorService creates a new two-factor service
+func NewTwoFactorService(db *gorm.DB, config *config.Config) *TwoFactorService {
+	return &TwoFactorService{db: db, config: config}
+}
+
+// Required reports whether every user must enroll a second factor
+func (s *TwoFactorService) Required() bool {
+	return s.config.GetBool("auth.require_2fa")
+}
+
+// Enrolled reports whether the user has an authenticator app or a security
+// key
+func (s *TwoFactorService) Enrolled(userID uuid.UUID) bool {
+	var user models.User
+	if err := s.db.Select("two_factor_enabled").First(&user, "id = ?", userID).Error; err != nil {
+		return false
+	}
+	if user.TwoFactorEnabled {
+		return true
+	}
+	var keys int64
+	s.db.Model(&models.Credential{}).Where("user_id = ?", userID).Count(&keys)
+	return keys > 0
+}
+
+// NewRecoveryCodes replaces the user's recovery codes and returns the new
+// ones, which are only available now
+func (s *TwoFactorService) NewRecoveryCodes(userID uuid.UUID) ([]string, error) {
+	codes, err := auth.GenerateRecoveryCodes(RecoveryCodeCount)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate recovery codes: %w", err)
+	}
+	err = s.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		for _, code := range codes {
+			row := &models.RecoveryCode{UserID: userID, CodeHash: auth.HashToken(auth.NormalizeRecoveryCode(code))}
+			if err := tx.Create(row).Error; err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save recovery codes: %w", err)
+	}
+	return codes, nil
+}
+
+// UseRecoveryCode uses up one of the user's recovery codes. The code is
+// checked in the same statement that marks it used, so it only works once
+// even when two sign ins race.
+func (s *TwoFactorService) UseRecoveryCode(userID uuid.UUID, code string) error {
+	code = auth.NormalizeRecoveryCode(code)
+	if code == "" {
+		return ErrRecoveryCodeInvalid
+	}
+	result := s.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, auth.HashToken(code)).
+		Update("used_at", time.Now())
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRecoveryCodeInvalid
+	}
+	return nil
+}
+
+// RemainingRecoveryCodes counts the user's unused recovery codes
+func (s *TwoFactorService) RemainingRecoveryCodes(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := s.db.Model(&models.RecoveryCode{}).Where("user_id = ? AND used_at IS NULL", userID).Count(&count).Error
+	return count, err
+}
+
+// DeleteRecoveryCodes removes the user's recovery codes, when they turn
+// two-factor authentication off
+func (s *TwoFactorService) DeleteRecoveryCodes(userID uuid.UUID) error {
+	return s.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
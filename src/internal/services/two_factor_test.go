@@ -0,0 +1,69 @@
+package services
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func TestTwoFactorService(t *testing.T) {
+	db := setupTestDB(t)
+	require.NoError(t, db.AutoMigrate(&models.RecoveryCode{}, &models.Credential{}))
+
+	config := config.New()
+	service := NewTwoFactorService(db, config)
+
+	alice := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(alice).Error)
+	bob := &models.User{Username: "bob", Email: "bob@example.com"}
+	require.NoError(t, db.Create(bob).Error)
+
+	t.Run("RecoveryCodes", func(t *testing.T) {
+		codes, err := service.NewRecoveryCodes(alice.ID)
+		require.NoError(t, err)
+		require.Len(t, codes, RecoveryCodeCount)
+		assert.Regexp(t, `^[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}-[A-Z2-7]{4}$`, codes[0])
+
+		// Typed in lower case and without dashes
+		typed := strings.ToLower(strings.ReplaceAll(codes[0], "-", " "))
+		require.NoError(t, service.UseRecoveryCode(alice.ID, typed))
+		assert.ErrorIs(t, service.UseRecoveryCode(alice.ID, codes[0]), ErrRecoveryCodeInvalid)
+		assert.ErrorIs(t, service.UseRecoveryCode(bob.ID, codes[1]), ErrRecoveryCodeInvalid)
+		assert.ErrorIs(t, service.UseRecoveryCode(alice.ID, ""), ErrRecoveryCodeInvalid)
+
+		remaining, err := service.RemainingRecoveryCodes(alice.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, RecoveryCodeCount-1, remaining)
+
+		// New codes replace the old ones
+		fresh, err := service.NewRecoveryCodes(alice.ID)
+		require.NoError(t, err)
+		assert.ErrorIs(t, service.UseRecoveryCode(alice.ID, codes[1]), ErrRecoveryCodeInvalid)
+		remaining, err = service.RemainingRecoveryCodes(alice.ID)
+		require.NoError(t, err)
+		assert.EqualValues(t, RecoveryCodeCount, remaining)
+
+		require.NoError(t, service.DeleteRecoveryCodes(alice.ID))
+		assert.ErrorIs(t, service.UseRecoveryCode(alice.ID, fresh[0]), ErrRecoveryCodeInvalid)
+	})
+
+	t.Run("Enrolled", func(t *testing.T) {
+		assert.False(t, service.Required())
+		config.Set("auth.require_2fa", true)
+		assert.True(t, service.Required())
+
+		assert.False(t, service.Enrolled(alice.ID))
+		require.NoError(t, db.Model(alice).Update("two_factor_enabled", true).Error)
+		assert.True(t, service.Enrolled(alice.ID))
+
+		// A security key counts too
+		assert.False(t, service.Enrolled(bob.ID))
+		require.NoError(t, db.Create(&models.Credential{UserID: bob.ID, Name: "key", CredentialID: "key", PublicKey: "pub"}).Error)
+		assert.True(t, service.Enrolled(bob.ID))
+	})
+}
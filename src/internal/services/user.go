@@ -4,32 +4,39 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
 	"strings"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
 	"github.com/casapps/casgists/src/internal/cache"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/casapps/casgists/src/internal/email"
+	"github.com/casapps/casgists/src/internal/notifications"
+	"github.com/casapps/casgists/src/internal/webhooks"
 )
 
 // UserService handles user business logic
 type UserService struct {
-	db           *gorm.DB
-	cfg          *viper.Viper
-	cache        *cache.CacheManager
-	emailService *email.Service
+	db             *gorm.DB
+	cfg            *config.Config
+	cache          *cache.CacheManager
+	emailService   *email.Service
+	webhookService *webhooks.Service
+	notifier       *notifications.Service
 }
 
 // NewUserService creates a new user service
-func NewUserService(db *gorm.DB, cfg *viper.Viper, cacheManager *cache.CacheManager, emailService *email.Service) *UserService {
+func NewUserService(db *gorm.DB, cfg *config.Config, cacheManager *cache.CacheManager, emailService *email.Service) *UserService {
 	return &UserService{
-		db:           db,
-		cfg:          cfg,
-		cache:        cacheManager,
-		emailService: emailService,
+		db:             db,
+		cfg:            cfg,
+		cache:          cacheManager,
+		emailService:   emailService,
+		webhookService: webhooks.NewService(db, cfg),
+		notifier:       notifications.NewService(db),
 	}
 }
 
@@ -117,7 +124,7 @@ func (s *UserService) GetUserByUsername(username string) (*models.User, error) {
 
 	// Cache the user for future requests
 	if s.cache != nil {
-		s.cache.SetJSON(ctx, cacheKey, &user, cache.TTLLong)
+		s.cache.SetJSON(ctx, cacheKey, &user, s.cache.TTL(cache.TierLong))
 	}
 
 	return &user, nil
@@ -176,18 +183,14 @@ func (s *UserService) UpdateUser(user *models.User) error {
 		return err
 	}
 
-	// Invalidate cache after update
+	// Invalidate the user under both the old and new username
 	if s.cache != nil {
 		ctx := context.Background()
-		// Invalidate both old and new username caches
-		oldUserCacheKey := cache.UserKey(existing.Username)
-		newUserCacheKey := cache.UserKey(user.Username)
-		s.cache.Delete(ctx, oldUserCacheKey)
-		s.cache.Delete(ctx, newUserCacheKey)
-
-		// Also invalidate user stats cache
-		userStatsKey := cache.UserStatsKey(user.ID.String())
-		s.cache.Delete(ctx, userStatsKey)
+		invalidator := cache.NewInvalidator(s.cache)
+		invalidator.User(ctx, user.ID.String(), user.Username)
+		if existing.Username != user.Username {
+			invalidator.User(ctx, user.ID.String(), existing.Username)
+		}
 	}
 
 	return nil
@@ -210,7 +213,7 @@ func (s *UserService) ValidateUsername(username string) error {
 	}
 
 	// Check blacklist
-	blacklist := []string{"admin", "administrator", "root", "system", "api", "www", "mail", "ftp"}
+	blacklist := []string{"admin", "administrator", "root", "system", "archive", "api", "www", "mail", "ftp"}
 	lowerUsername := strings.ToLower(username)
 	for _, blocked := range blacklist {
 		if lowerUsername == blocked {
@@ -269,6 +272,7 @@ func (s *UserService) GetUserStats(userID uuid.UUID) (map[string]interface{}, er
 	var starCount int64
 	s.db.Table("gist_stars").
 		Joins("JOIN gists ON gist_stars.gist_id = gists.id").
+		Scopes(models.NotDeleted("gists")).
 		Where("gists.user_id = ?", userID).
 		Count(&starCount)
 	stats["stars_received"] = starCount
@@ -302,18 +306,27 @@ func (s *UserService) FollowUser(followerID, followingID uuid.UUID) error {
 		return err
 	}
 
-	// Send email notification to the user being followed
-	if s.emailService != nil {
+	// Notify the user being followed, by email and in the app, and trigger
+	// webhooks
+	if s.emailService != nil || s.webhookService != nil {
 		var followerUser, followingUser models.User
 		if s.db.First(&followerUser, "id = ?", followerID).Error == nil &&
 			s.db.First(&followingUser, "id = ?", followingID).Error == nil {
-			go s.emailService.SendUserFollowedNotification(
-				followingID,
-				followingUser.Email,
-				followingUser.DisplayName,
-				followerUser.DisplayName,
-				followerID,
-			)
+			if err := s.notifier.Followed(followingID, &followerUser); err != nil {
+				slog.Error("Failed to notify user of new follower", "user", followingID, "error", err)
+			}
+			if s.emailService != nil {
+				go s.emailService.SendUserFollowedNotification(
+					followingID,
+					followingUser.Email,
+					followingUser.DisplayName,
+					followerUser.DisplayName,
+					followerID,
+				)
+			}
+			if s.webhookService != nil {
+				go s.webhookService.TriggerUserFollowed(context.Background(), &followerUser, &followingUser)
+			}
 		}
 	}
 
@@ -4,12 +4,12 @@ import (
 	"testing"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
@@ -34,7 +34,7 @@ func setupTestDB(t *testing.T) *gorm.DB {
 
 func TestUserService(t *testing.T) {
 	db := setupTestDB(t)
-	cfg := viper.New()
+	cfg := config.New()
 	
 	userService := NewUserService(db, cfg, nil, nil) // nil cache and email service for testing
 	require.NotNil(t, userService)
@@ -0,0 +1,173 @@
+// Package streaming writes large responses, gist archives and raw files,
+// to the client as they are produced instead of building them in memory
+// first. Each route has its own limits under performance.streaming: the
+// largest body it serves, how much is written at a time, and how long a
+// client may take to read each piece before it is dropped.
+package streaming
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Routes with their own limits, configured under performance.streaming
+const (
+	RouteArchive = "archive" // GET /api/v1/gists/:id/download
+	RouteRaw     = "raw"     // Raw files and raw bundles
+)
+
+const (
+	defaultChunkSize    = 32 * 1024
+	defaultWriteTimeout = 30 * time.Second
+)
+
+// Limits bound what a route streams
+type Limits struct {
+	MaxBytes     int64         // Largest body served; 0 = unlimited
+	ChunkSize    int           // Bytes written at a time
+	WriteTimeout time.Duration // How long a client may take to read a chunk
+}
+
+// RouteLimits returns the limits of a route from
+// performance.streaming.<route>, falling back to those set for all routes
+// under performance.streaming
+func RouteLimits(cfg *config.Config, route string) Limits {
+	get := func(key string) string {
+		if routeKey := "performance.streaming." + route + "." + key; cfg.IsSet(routeKey) {
+			return routeKey
+		}
+		return "performance.streaming." + key
+	}
+
+	limits := Limits{
+		MaxBytes:     cfg.GetInt64(get("max_bytes")),
+		ChunkSize:    cfg.GetInt(get("chunk_size")),
+		WriteTimeout: cfg.GetDuration(get("write_timeout")),
+	}
+	if limits.ChunkSize <= 0 {
+		limits.ChunkSize = defaultChunkSize
+	}
+	if limits.WriteTimeout <= 0 {
+		limits.WriteTimeout = defaultWriteTimeout
+	}
+	return limits
+}
+
+// Allows reports whether a body of size bytes is within MaxBytes
+func (l Limits) Allows(size int64) bool {
+	return l.MaxBytes <= 0 || size <= l.MaxBytes
+}
+
+// Writer writes to a response in chunks of at most ChunkSize bytes, each
+// with WriteTimeout to reach the client, so a client that stops reading
+// fails the write instead of holding the handler
+type Writer struct {
+	w          io.Writer
+	controller *http.ResponseController
+	limits     Limits
+	written    int64
+}
+
+// NewWriter creates a writer for the response of c. Close it to clear the
+// write deadline.
+func NewWriter(c echo.Context, limits Limits) *Writer {
+	return &Writer{
+		w:          c.Response(),
+		controller: http.NewResponseController(c.Response()),
+		limits:     limits,
+	}
+}
+
+// Write implements io.Writer
+func (w *Writer) Write(p []byte) (int, error) {
+	total := 0
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > w.limits.ChunkSize {
+			chunk = chunk[:w.limits.ChunkSize]
+		}
+		// Not every writer in the chain can set deadlines; writes then
+		// run without one
+		_ = w.controller.SetWriteDeadline(time.Now().Add(w.limits.WriteTimeout))
+		n, err := w.w.Write(chunk)
+		total += n
+		w.written += int64(n)
+		if err != nil {
+			return total, err
+		}
+		p = p[n:]
+	}
+	return total, nil
+}
+
+// WriteString implements io.StringWriter, so copying a string converts
+// one chunk at a time rather than the whole string at once
+func (w *Writer) WriteString(s string) (int, error) {
+	total := 0
+	for len(s) > 0 {
+		chunk := s
+		if len(chunk) > w.limits.ChunkSize {
+			chunk = chunk[:w.limits.ChunkSize]
+		}
+		n, err := w.Write([]byte(chunk))
+		total += n
+		if err != nil {
+			return total, err
+		}
+		s = s[n:]
+	}
+	return total, nil
+}
+
+// Written returns how many bytes were written
+func (w *Writer) Written() int64 {
+	return w.written
+}
+
+// Close clears the write deadline
+func (w *Writer) Close() error {
+	err := w.controller.SetWriteDeadline(time.Time{})
+	if errors.Is(err, http.ErrNotSupported) {
+		return nil
+	}
+	return err
+}
+
+// Stream responds 200 with contentType and the body read from r, written
+// in chunks. A body larger than MaxBytes is refused with 413 when its size
+// is known up front, which it is unless size is negative.
+func Stream(c echo.Context, limits Limits, contentType string, size int64, r io.Reader) error {
+	return StreamFunc(c, limits, contentType, size, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	})
+}
+
+// StreamFunc is Stream for a body that write produces as it goes, such as
+// an archive
+func StreamFunc(c echo.Context, limits Limits, contentType string, size int64, write func(w io.Writer) error) error {
+	if size >= 0 && !limits.Allows(size) {
+		return echo.NewHTTPError(http.StatusRequestEntityTooLarge, "response too large; clone the gist instead")
+	}
+
+	res := c.Response()
+	res.Header().Set(echo.HeaderContentType, contentType)
+	if size >= 0 {
+		res.Header().Set(echo.HeaderContentLength, strconv.FormatInt(size, 10))
+	}
+	res.WriteHeader(http.StatusOK)
+	if c.Request().Method == http.MethodHead {
+		return nil
+	}
+
+	w := NewWriter(c, limits)
+	defer w.Close()
+	return write(w)
+}
@@ -0,0 +1,131 @@
+package streaming
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestRouteLimits(t *testing.T) {
+	cfg := config.New()
+	limits := RouteLimits(cfg, RouteRaw)
+	assert.Equal(t, Limits{ChunkSize: defaultChunkSize, WriteTimeout: defaultWriteTimeout}, limits)
+	assert.True(t, limits.Allows(1<<40), "no max_bytes is unlimited")
+
+	cfg.Set("performance.streaming.max_bytes", 1000)
+	cfg.Set("performance.streaming.write_timeout", "5s")
+	cfg.Set("performance.streaming.archive.max_bytes", 5000)
+	cfg.Set("performance.streaming.archive.chunk_size", 512)
+
+	raw := RouteLimits(cfg, RouteRaw)
+	assert.Equal(t, int64(1000), raw.MaxBytes)
+	assert.Equal(t, defaultChunkSize, raw.ChunkSize)
+	assert.Equal(t, 5*time.Second, raw.WriteTimeout)
+
+	archive := RouteLimits(cfg, RouteArchive)
+	assert.Equal(t, int64(5000), archive.MaxBytes)
+	assert.Equal(t, 512, archive.ChunkSize)
+	assert.Equal(t, 5*time.Second, archive.WriteTimeout, "unset route limits fall back to all routes'")
+	assert.True(t, archive.Allows(5000))
+	assert.False(t, archive.Allows(5001))
+}
+
+// countingRecorder counts the writes that reach the response
+type countingRecorder struct {
+	*httptest.ResponseRecorder
+	writes int
+}
+
+func (r *countingRecorder) Write(p []byte) (int, error) {
+	r.writes++
+	return r.ResponseRecorder.Write(p)
+}
+
+func TestStream(t *testing.T) {
+	body := strings.Repeat("0123456789", 100)
+	limits := Limits{MaxBytes: 2000, ChunkSize: 300, WriteTimeout: time.Second}
+
+	rec := &countingRecorder{ResponseRecorder: httptest.NewRecorder()}
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/raw", nil), rec)
+	require.NoError(t, Stream(c, limits, echo.MIMETextPlainCharsetUTF8, int64(len(body)), strings.NewReader(body)))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, body, rec.Body.String())
+	assert.Equal(t, "1000", rec.Header().Get(echo.HeaderContentLength))
+	assert.Equal(t, 4, rec.writes, "written in chunks of ChunkSize")
+
+	// Too large for the route
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/raw", nil), httptest.NewRecorder())
+	err := Stream(c, Limits{MaxBytes: 999, ChunkSize: 300}, echo.MIMETextPlainCharsetUTF8, int64(len(body)), strings.NewReader(body))
+	var httpErr *echo.HTTPError
+	require.ErrorAs(t, err, &httpErr)
+	assert.Equal(t, http.StatusRequestEntityTooLarge, httpErr.Code)
+}
+
+func TestStreamFunc(t *testing.T) {
+	rec := httptest.NewRecorder()
+	c := echo.New().NewContext(httptest.NewRequest(http.MethodGet, "/download", nil), rec)
+	err := StreamFunc(c, Limits{ChunkSize: 4}, "application/x-tar", -1, func(w io.Writer) error {
+		_, err := io.WriteString(w, "hello, world")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "hello, world", rec.Body.String())
+	assert.Equal(t, "application/x-tar", rec.Header().Get(echo.HeaderContentType))
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentLength), "the size isn't known up front")
+
+	// HEAD gets the headers only
+	rec = httptest.NewRecorder()
+	c = echo.New().NewContext(httptest.NewRequest(http.MethodHead, "/download", nil), rec)
+	called := false
+	require.NoError(t, StreamFunc(c, Limits{ChunkSize: 4}, "application/zip", -1, func(w io.Writer) error {
+		called = true
+		return nil
+	}))
+	assert.False(t, called)
+	assert.Equal(t, http.StatusOK, rec.Code)
+}
+
+func TestWriterDropsSlowClients(t *testing.T) {
+	release := make(chan struct{})
+	result := make(chan error, 1)
+	e := echo.New()
+	e.GET("/download", func(c echo.Context) error {
+		err := StreamFunc(c, Limits{ChunkSize: 64 * 1024, WriteTimeout: 100 * time.Millisecond}, "application/zip", -1,
+			func(w io.Writer) error {
+				chunk := make([]byte, 64*1024)
+				for i := 0; i < 1024; i++ {
+					if _, err := w.Write(chunk); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+		result <- err
+		<-release
+		return nil
+	})
+	server := httptest.NewServer(e)
+	defer server.Close()
+	defer close(release)
+
+	// The client reads the headers, then stops reading
+	res, err := http.Get(server.URL + "/download")
+	require.NoError(t, err)
+	defer res.Body.Close()
+
+	select {
+	case err := <-result:
+		assert.Error(t, err, "the write times out")
+	case <-time.After(10 * time.Second):
+		t.Fatal("the stream wasn't dropped")
+	}
+}
@@ -0,0 +1,262 @@
+package syntax
+
+import (
+	"strings"
+	"unicode"
+)
+
+// TokenKind classifies a run of source text for coloring
+type TokenKind int
+
+const (
+	TokenText TokenKind = iota
+	TokenKeyword
+	TokenString
+	TokenComment
+	TokenNumber
+)
+
+// Token is a run of text on a single line
+type Token struct {
+	Kind TokenKind
+	Text string
+}
+
+// grammar describes just enough of a language to color it: comments,
+// strings, numbers and keywords
+type grammar struct {
+	lineComments  []string
+	blockComments [][2]string
+	quotes        string
+	keywords      map[string]bool
+}
+
+func words(s string) map[string]bool {
+	set := make(map[string]bool)
+	for _, w := range strings.Fields(s) {
+		set[w] = true
+	}
+	return set
+}
+
+var (
+	cKeywords = "auto break case char const continue default do double else enum extern float for goto if inline int long register return short signed sizeof static struct switch typedef union unsigned void volatile while NULL true false"
+
+	grammars = map[string]grammar{
+		"go": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'`",
+			words("break case chan const continue default defer else fallthrough for func go goto if import interface map package range return select struct switch type var nil true false iota string int int64 int32 uint uint64 byte rune bool error float64 float32 any make new len cap append")},
+		"python": {[]string{"#"}, nil, "\"'",
+			words("and as assert async await break class continue def del elif else except finally for from global if import in is lambda nonlocal not or pass raise return try while with yield None True False self")},
+		"javascript": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'`",
+			words("async await break case catch class const continue debugger default delete do else export extends finally for from function if import in instanceof let new of return static super switch this throw try typeof var void while yield null undefined true false")},
+		"typescript": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'`",
+			words("abstract any as async await boolean break case catch class const constructor continue declare default do else enum export extends false finally for from function if implements import in instanceof interface keyof let namespace never new null number of private protected public readonly return static string super switch this throw true try type typeof undefined unknown var void while")},
+		"java": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'",
+			words("abstract boolean break byte case catch char class const continue default do double else enum extends final finally float for if implements import instanceof int interface long new null package private protected public return short static super switch synchronized this throw throws try void volatile while true false var record")},
+		"csharp": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'",
+			words("abstract as async await base bool break byte case catch char class const continue decimal default delegate do double else enum event false finally float for foreach if in int interface internal is long namespace new null object out override private protected public readonly return sealed static string struct switch this throw true try typeof using var virtual void while")},
+		"c":   {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'", words(cKeywords + " #include #define #ifdef #ifndef #endif")},
+		"cpp": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'", words(cKeywords + " bool catch class constexpr delete explicit friend namespace new nullptr operator private protected public template this throw try typename using virtual #include #define")},
+		"rust": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"",
+			words("as async await break const continue crate dyn else enum extern false fn for if impl in let loop match mod move mut pub ref return self Self static struct super trait true type unsafe use where while Some None Ok Err")},
+		"php": {[]string{"//", "#"}, [][2]string{{"/*", "*/"}}, "\"'",
+			words("abstract and array as break case catch class const continue default do echo else elseif extends false final finally fn for foreach function global if implements include interface namespace new null or private protected public require return static switch this throw true try use var while")},
+		"ruby": {[]string{"#"}, [][2]string{{"=begin", "=end"}}, "\"'",
+			words("alias and begin break case class def defined? do else elsif end ensure false for if in module next nil not or redo rescue retry return self super then true undef unless until when while yield require attr_accessor")},
+		"swift": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"",
+			words("as break case catch class continue default defer do else enum extension false for func guard if import in init let nil protocol return self struct switch throw throws true try var where while")},
+		"kotlin": {[]string{"//"}, [][2]string{{"/*", "*/"}}, "\"'",
+			words("as break class continue do else false for fun if import in interface is null object package return super this throw true try typealias val var when while data sealed override private public")},
+		"shell": {[]string{"#"}, nil, "\"'",
+			words("if then else elif fi for while until do done case esac function in return local export set unset echo exit source readonly shift")},
+		"sql": {[]string{"--"}, [][2]string{{"/*", "*/"}}, "'",
+			words("select from where and or not insert into values update set delete create table drop alter index join left right inner outer on group by order having limit offset as distinct null is in like between primary key foreign references union all case when then else end SELECT FROM WHERE AND OR NOT INSERT INTO VALUES UPDATE SET DELETE CREATE TABLE DROP ALTER INDEX JOIN LEFT RIGHT INNER OUTER ON GROUP BY ORDER HAVING LIMIT OFFSET AS DISTINCT NULL IS IN LIKE BETWEEN PRIMARY KEY FOREIGN REFERENCES UNION ALL CASE WHEN THEN ELSE END")},
+		"lua": {[]string{"--"}, nil, "\"'",
+			words("and break do else elseif end false for function goto if in local nil not or repeat return then true until while")},
+		"yaml":       {[]string{"#"}, nil, "\"'", words("true false null yes no")},
+		"toml":       {[]string{"#"}, nil, "\"'", words("true false")},
+		"ini":        {[]string{";", "#"}, nil, "\"", nil},
+		"json":       {nil, nil, "\"", words("true false null")},
+		"css":        {nil, [][2]string{{"/*", "*/"}}, "\"'", words("!important @media @import @keyframes")},
+		"html":       {nil, [][2]string{{"<!--", "-->"}}, "\"'", nil},
+		"xml":        {nil, [][2]string{{"<!--", "-->"}}, "\"'", nil},
+		"dockerfile": {[]string{"#"}, nil, "\"'", words("FROM RUN CMD LABEL EXPOSE ENV ADD COPY ENTRYPOINT VOLUME USER WORKDIR ARG ONBUILD STOPSIGNAL HEALTHCHECK SHELL AS")},
+		"makefile":   {[]string{"#"}, nil, "\"'", words("ifeq ifneq ifdef ifndef else endif include define endef export")},
+	}
+
+	// aliases maps language IDs that share a grammar
+	aliases = map[string]string{
+		"scss": "css", "objectivec": "c", "scala": "kotlin", "dart": "java",
+		"powershell": "shell", "perl": "shell", "r": "python", "julia": "python",
+		"elixir": "ruby", "nginx": "shell",
+	}
+)
+
+// grammarFor returns the grammar for a language ID, if any
+func grammarFor(language string) (grammar, bool) {
+	language = strings.ToLower(language)
+	if alias, ok := aliases[language]; ok {
+		language = alias
+	}
+	g, ok := grammars[language]
+	return g, ok
+}
+
+// HasGrammar reports whether Highlight knows how to color a language
+func HasGrammar(language string) bool {
+	_, ok := grammarFor(language)
+	return ok
+}
+
+// Highlight splits source lines into colored tokens. Block comments and
+// strings may span lines, so state carries over between lines. Languages
+// without a grammar come back as plain text.
+func Highlight(lines []string, language string) [][]Token {
+	g, ok := grammarFor(language)
+	result := make([][]Token, len(lines))
+	if !ok {
+		for i, line := range lines {
+			result[i] = []Token{{TokenText, line}}
+		}
+		return result
+	}
+
+	var blockEnd string // Non-empty while inside a block comment
+	var openQuote rune  // Non-zero while inside a multi-line string (backticks)
+	for i, line := range lines {
+		var tokens []Token
+		emit := func(kind TokenKind, text string) {
+			if text == "" {
+				return
+			}
+			if n := len(tokens); n > 0 && tokens[n-1].Kind == kind {
+				tokens[n-1].Text += text
+				return
+			}
+			tokens = append(tokens, Token{kind, text})
+		}
+
+		rest := line
+		for rest != "" {
+			switch {
+			case blockEnd != "":
+				end := strings.Index(rest, blockEnd)
+				if end < 0 {
+					emit(TokenComment, rest)
+					rest = ""
+					continue
+				}
+				emit(TokenComment, rest[:end+len(blockEnd)])
+				rest = rest[end+len(blockEnd):]
+				blockEnd = ""
+				continue
+
+			case openQuote != 0:
+				end := closingQuote(rest, openQuote)
+				if end < 0 {
+					emit(TokenString, rest)
+					rest = ""
+					continue
+				}
+				emit(TokenString, rest[:end+1])
+				rest = rest[end+1:]
+				openQuote = 0
+				continue
+			}
+
+			if hasAnyPrefix(rest, g.lineComments) {
+				emit(TokenComment, rest)
+				break
+			}
+
+			matchedBlock := false
+			for _, block := range g.blockComments {
+				if strings.HasPrefix(rest, block[0]) {
+					emit(TokenComment, block[0])
+					rest = rest[len(block[0]):]
+					blockEnd = block[1]
+					matchedBlock = true
+					break
+				}
+			}
+			if matchedBlock {
+				continue
+			}
+
+			r := []rune(rest)[0]
+			switch {
+			case strings.ContainsRune(g.quotes, r):
+				end := closingQuote(rest[1:], r)
+				if end < 0 {
+					emit(TokenString, rest)
+					rest = ""
+					if r == '`' {
+						openQuote = r
+					}
+					continue
+				}
+				emit(TokenString, rest[:end+2])
+				rest = rest[end+2:]
+
+			case unicode.IsDigit(r):
+				n := strings.IndexFunc(rest, func(c rune) bool {
+					return !(unicode.IsDigit(c) || unicode.IsLetter(c) || c == '.' || c == '_')
+				})
+				if n < 0 {
+					n = len(rest)
+				}
+				emit(TokenNumber, rest[:n])
+				rest = rest[n:]
+
+			case isWordRune(r) || r == '#' || r == '@' || r == '!':
+				n := 1 + strings.IndexFunc(rest[1:], func(c rune) bool { return !isWordRune(c) && c != '?' })
+				if n == 0 {
+					n = len(rest)
+				}
+				word := rest[:n]
+				if g.keywords[word] {
+					emit(TokenKeyword, word)
+				} else {
+					emit(TokenText, word)
+				}
+				rest = rest[n:]
+
+			default:
+				size := len(string(r))
+				emit(TokenText, rest[:size])
+				rest = rest[size:]
+			}
+		}
+		result[i] = tokens
+	}
+	return result
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r)
+}
+
+// closingQuote returns the index of the unescaped closing quote in s, or -1
+func closingQuote(s string, quote rune) int {
+	escaped := false
+	for i, r := range s {
+		switch {
+		case escaped:
+			escaped = false
+		case r == '\\' && quote != '`':
+			escaped = true
+		case r == quote:
+			return i
+		}
+	}
+	return -1
+}
+
+func hasAnyPrefix(s string, prefixes []string) bool {
+	for _, prefix := range prefixes {
+		if strings.HasPrefix(s, prefix) {
+			return true
+		}
+	}
+	return false
+}
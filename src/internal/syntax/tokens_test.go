@@ -0,0 +1,29 @@
+package syntax
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHighlight(t *testing.T) {
+	tokens := Highlight([]string{`x := "a" // note`, "/* start", "end */ return 1"}, "go")
+
+	assert.Equal(t, []Token{
+		{TokenText, "x := "},
+		{TokenString, `"a"`},
+		{TokenText, " "},
+		{TokenComment, "// note"},
+	}, tokens[0])
+	assert.Equal(t, []Token{{TokenComment, "/* start"}}, tokens[1])
+	assert.Equal(t, []Token{
+		{TokenComment, "end */"},
+		{TokenText, " "},
+		{TokenKeyword, "return"},
+		{TokenText, " "},
+		{TokenNumber, "1"},
+	}, tokens[2])
+
+	plain := Highlight([]string{"return 1"}, "unknown")
+	assert.Equal(t, []Token{{TokenText, "return 1"}}, plain[0])
+}
@@ -0,0 +1,277 @@
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+const (
+	// ConfigKeyEnabled is the SystemConfig key holding the admin opt-in toggle
+	ConfigKeyEnabled = "telemetry_enabled"
+	// ConfigKeyInstanceID is the SystemConfig key holding the anonymous instance ID
+	ConfigKeyInstanceID = "telemetry_instance_id"
+	// ConfigKeyLastSent is the SystemConfig key holding the last successful report time
+	ConfigKeyLastSent = "telemetry_last_sent"
+)
+
+// Payload is the anonymous report sent to the telemetry endpoint.
+// It must never contain user-identifying data such as names, emails or URLs.
+type Payload struct {
+	InstanceID    string          `json:"instance_id"`
+	Version       string          `json:"version"`
+	DatabaseType  string          `json:"database_type"`
+	OS            string          `json:"os"`
+	Arch          string          `json:"arch"`
+	Users         string          `json:"users"`
+	Gists         string          `json:"gists"`
+	Organizations string          `json:"organizations"`
+	Features      map[string]bool `json:"features"`
+	GeneratedAt   time.Time       `json:"generated_at"`
+}
+
+// Status describes the current telemetry state for the admin UI
+type Status struct {
+	Enabled   bool       `json:"enabled"`
+	Endpoint  string     `json:"endpoint"`
+	Interval  string     `json:"interval"`
+	LastSent  *time.Time `json:"last_sent,omitempty"`
+	LastError string     `json:"last_error,omitempty"`
+}
+
+// Service periodically reports anonymous usage statistics when opted in
+type Service struct {
+	db     *gorm.DB
+	config *config.Config
+	client *http.Client
+	stop   chan bool
+
+	// mu guards lastError, written by the reporting loop and read by the
+	// admin UI
+	mu        sync.Mutex
+	lastError string
+}
+
+// NewService creates a new telemetry service
+func NewService(db *gorm.DB, config *config.Config) *Service {
+	return &Service{
+		db:     db,
+		config: config,
+		client: &http.Client{Timeout: 15 * time.Second},
+		stop:   make(chan bool, 1),
+	}
+}
+
+// IsEnabled reports whether the admin has opted in to telemetry.
+// The SystemConfig toggle takes precedence over the config file default.
+func (s *Service) IsEnabled() bool {
+	if value, err := models.GetConfigValue(s.db, ConfigKeyEnabled); err == nil {
+		return value == "true"
+	}
+	return s.config.GetBool("telemetry.enabled")
+}
+
+// SetEnabled stores the admin opt-in toggle
+func (s *Service) SetEnabled(enabled bool) error {
+	return models.SetConfigValue(s.db, ConfigKeyEnabled, fmt.Sprintf("%t", enabled))
+}
+
+// GetStatus returns the current telemetry status
+func (s *Service) GetStatus() *Status {
+	status := &Status{
+		Enabled:   s.IsEnabled(),
+		Endpoint:  s.config.GetString("telemetry.endpoint"),
+		Interval:  s.interval().String(),
+		LastError: s.getLastError(),
+	}
+
+	if value, err := models.GetConfigValue(s.db, ConfigKeyLastSent); err == nil {
+		if t, err := time.Parse(time.RFC3339, value); err == nil {
+			status.LastSent = &t
+		}
+	}
+
+	return status
+}
+
+// BuildPayload collects the anonymous aggregates that would be reported
+func (s *Service) BuildPayload() (*Payload, error) {
+	instanceID, err := s.instanceID()
+	if err != nil {
+		return nil, err
+	}
+
+	var userCount, gistCount, orgCount int64
+	s.db.Model(&models.User{}).Count(&userCount)
+	s.db.Model(&models.Gist{}).Count(&gistCount)
+	s.db.Model(&models.Organization{}).Count(&orgCount)
+
+	version := s.config.GetString("version")
+	if version == "" {
+		version = "dev"
+	}
+
+	return &Payload{
+		InstanceID:    instanceID,
+		Version:       version,
+		DatabaseType:  s.db.Dialector.Name(),
+		OS:            runtime.GOOS,
+		Arch:          runtime.GOARCH,
+		Users:         Bucket(userCount),
+		Gists:         Bucket(gistCount),
+		Organizations: Bucket(orgCount),
+		Features: map[string]bool{
+			"registration":  s.config.GetBool("features.registration"),
+			"organizations": s.config.GetBool("features.organizations"),
+			"social":        s.config.GetBool("features.social"),
+			"search":        s.config.GetBool("features.search"),
+			"webhooks":      s.config.GetBool("features.webhooks"),
+			"email":         s.config.GetBool("email.enabled"),
+			"redis":         s.config.GetBool("redis.enabled"),
+			"backup":        s.config.GetBool("backup.enabled"),
+		},
+		GeneratedAt: time.Now().UTC(),
+	}, nil
+}
+
+// Send builds and posts a single report to the configured endpoint
+func (s *Service) Send(ctx context.Context) error {
+	endpoint := s.config.GetString("telemetry.endpoint")
+	if endpoint == "" {
+		return fmt.Errorf("telemetry endpoint not configured")
+	}
+
+	payload, err := s.BuildPayload()
+	if err != nil {
+		return fmt.Errorf("failed to build telemetry payload: %w", err)
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode telemetry payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create telemetry request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "CasGists-Telemetry/"+payload.Version)
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send telemetry: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("telemetry endpoint returned status %d", resp.StatusCode)
+	}
+
+	return models.SetConfigValue(s.db, ConfigKeyLastSent, time.Now().UTC().Format(time.RFC3339))
+}
+
+// Start runs the reporting loop until the context is cancelled or Stop is called.
+// Reports are only sent while telemetry is enabled, so toggling it in the
+// admin UI takes effect without a restart.
+func (s *Service) Start(ctx context.Context) {
+	ticker := time.NewTicker(s.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-s.stop:
+			return
+		case <-ticker.C:
+			if !s.IsEnabled() {
+				continue
+			}
+			s.report(ctx)
+		}
+	}
+}
+
+// report sends a report and records its error for GetStatus
+func (s *Service) report(ctx context.Context) {
+	err := s.Send(ctx)
+	if err != nil {
+		slog.Warn("Telemetry report failed", "error", err)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if err != nil {
+		s.lastError = err.Error()
+	} else {
+		s.lastError = ""
+	}
+}
+
+// getLastError returns the error of the last report, if it failed
+func (s *Service) getLastError() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastError
+}
+
+// Stop stops the reporting loop
+func (s *Service) Stop() {
+	select {
+	case s.stop <- true:
+	default:
+	}
+}
+
+// interval returns the reporting interval (default: 24 hours)
+func (s *Service) interval() time.Duration {
+	interval := s.config.GetDuration("telemetry.interval")
+	if interval < time.Hour {
+		interval = 24 * time.Hour
+	}
+	return interval
+}
+
+// instanceID returns the random anonymous instance ID, creating it on first use
+func (s *Service) instanceID() (string, error) {
+	if value, err := models.GetConfigValue(s.db, ConfigKeyInstanceID); err == nil && value != "" {
+		return value, nil
+	}
+
+	id := uuid.New().String()
+	if err := models.SetConfigValue(s.db, ConfigKeyInstanceID, id); err != nil {
+		return "", fmt.Errorf("failed to store telemetry instance ID: %w", err)
+	}
+	return id, nil
+}
+
+// Bucket converts an exact count into a coarse range so reports cannot be
+// used to fingerprint an instance
+func Bucket(count int64) string {
+	switch {
+	case count <= 0:
+		return "0"
+	case count < 10:
+		return "1-9"
+	case count < 100:
+		return "10-99"
+	case count < 1000:
+		return "100-999"
+	case count < 10000:
+		return "1000-9999"
+	default:
+		return "10000+"
+	}
+}
@@ -0,0 +1,123 @@
+package telemetry
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+func newTestService(t *testing.T) (*Service, *gorm.DB, *config.Config) {
+	db, err := gorm.Open(sqlite.Open(filepath.Join(t.TempDir(), "test.db")), &gorm.Config{
+		Logger: logger.Default.LogMode(logger.Silent),
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.SystemConfig{}, &models.User{}, &models.Gist{}, &models.Organization{}))
+
+	cfg := config.New()
+	return NewService(db, cfg), db, cfg
+}
+
+func TestBucket(t *testing.T) {
+	for count, want := range map[int64]string{
+		-1:     "0",
+		0:      "0",
+		1:      "1-9",
+		9:      "1-9",
+		10:     "10-99",
+		99:     "10-99",
+		100:    "100-999",
+		999:    "100-999",
+		1000:   "1000-9999",
+		9999:   "1000-9999",
+		10000:  "10000+",
+		250000: "10000+",
+	} {
+		assert.Equal(t, want, Bucket(count), "count %d", count)
+	}
+}
+
+func TestBuildPayload(t *testing.T) {
+	service, db, cfg := newTestService(t)
+	cfg.Set("version", "1.2.3")
+	cfg.Set("features.registration", true)
+
+	for _, name := range []string{"alice", "bob", "carol"} {
+		require.NoError(t, db.Create(&models.User{Username: name, Email: name + "@example.com"}).Error)
+	}
+
+	payload, err := service.BuildPayload()
+	require.NoError(t, err)
+	assert.NotEmpty(t, payload.InstanceID)
+	assert.Equal(t, "1.2.3", payload.Version)
+	assert.Equal(t, "sqlite", payload.DatabaseType)
+	assert.Equal(t, runtime.GOOS, payload.OS)
+	assert.Equal(t, "1-9", payload.Users)
+	assert.Equal(t, "0", payload.Gists)
+	assert.Equal(t, "0", payload.Organizations)
+	assert.True(t, payload.Features["registration"])
+	assert.False(t, payload.Features["webhooks"])
+
+	// The instance ID is kept, so reports from one instance can be told apart
+	// without identifying it
+	again, err := service.BuildPayload()
+	require.NoError(t, err)
+	assert.Equal(t, payload.InstanceID, again.InstanceID)
+
+	// Nothing identifying is reported
+	body, err := json.Marshal(payload)
+	require.NoError(t, err)
+	assert.NotContains(t, string(body), "alice")
+	assert.NotContains(t, string(body), "example.com")
+}
+
+func TestReportRecordsLastError(t *testing.T) {
+	service, _, cfg := newTestService(t)
+
+	status := http.StatusInternalServerError
+	var mu sync.Mutex
+	endpoint := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	defer endpoint.Close()
+	cfg.Set("telemetry.endpoint", endpoint.URL)
+
+	// The admin UI reads the status while the loop reports
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			service.report(context.Background())
+		}()
+		go func() {
+			defer wg.Done()
+			service.GetStatus()
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, "telemetry endpoint returned status 500", service.GetStatus().LastError)
+
+	mu.Lock()
+	status = http.StatusNoContent
+	mu.Unlock()
+	service.report(context.Background())
+	current := service.GetStatus()
+	assert.Empty(t, current.LastError)
+	assert.NotNil(t, current.LastSent)
+}
@@ -16,7 +16,6 @@ import (
 	"time"
 
 	"github.com/labstack/echo/v4"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
@@ -25,6 +24,7 @@ import (
 	"gorm.io/gorm/logger"
 
 	"github.com/casapps/casgists/src/internal/auth"
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database"
 	"github.com/casapps/casgists/src/internal/database/models"
 	"github.com/casapps/casgists/src/internal/server"
@@ -36,7 +36,7 @@ type TestSuite struct {
 	
 	// Core components
 	DB           *gorm.DB
-	Config       *viper.Viper
+	Config       *config.Config
 	Server       *server.Server
 	Echo         *echo.Echo
 	TestServer   *httptest.Server
@@ -153,7 +153,7 @@ func (s *TestSuite) AddCleanup(fn func()) {
 
 // setupConfig initializes test configuration
 func (s *TestSuite) setupConfig() {
-	config := viper.New()
+	config := config.New()
 	config.SetDefault("environment", "test")
 	config.SetDefault("database.driver", "sqlite")
 	config.SetDefault("database.dsn", ":memory:")
@@ -168,7 +168,6 @@ func (s *TestSuite) setupConfig() {
 	config.SetDefault("auth.require_email_verification", false)
 	config.SetDefault("features.registration", true)
 	config.SetDefault("features.public_gists", true)
-	config.SetDefault("features.search", false) // Disable search for testing to avoid FTS5 issues
 	config.SetDefault("logging.level", "error")
 	config.SetDefault("logging.directory", filepath.Join(s.TempDir, "logs"))
 	config.SetDefault("storage.path", filepath.Join(s.TempDir, "storage"))
@@ -0,0 +1,116 @@
+package trash
+
+import (
+	"context"
+	"log"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+// Retention returns how long deleted gists stay in the trash before they
+// are purged, from gists.trash.retention_days. Zero keeps them until an
+// admin purges them.
+func Retention(config *config.Config) time.Duration {
+	days := config.GetInt("gists.trash.retention_days")
+	if days <= 0 {
+		return 0
+	}
+	return time.Duration(days) * 24 * time.Hour
+}
+
+// RemoveRepositories deletes the git repositories of purged gists. Failures
+// are logged; the rows are gone either way.
+func RemoveRepositories(config *config.Config, gistIDs []uuid.UUID) {
+	if len(gistIDs) == 0 {
+		return
+	}
+	storage, err := git.NewStorageDriver(config)
+	if err != nil {
+		slog.Error("Failed to open git storage to remove purged gist repositories", "error", err)
+		return
+	}
+	for _, id := range gistIDs {
+		if err := storage.Delete(id.String()); err != nil {
+			slog.Error("Failed to remove repository of purged gist", "gist", id, "error", err)
+		}
+	}
+}
+
+// Purger permanently deletes gists, and their repositories, once they have
+// been in the trash for longer than the retention period
+type Purger struct {
+	db     *gorm.DB
+	config *config.Config
+	stop   chan bool
+}
+
+// NewPurger creates a new trash purger
+func NewPurger(db *gorm.DB, config *config.Config) *Purger {
+	return &Purger{
+		db:     db,
+		config: config,
+		stop:   make(chan bool, 1),
+	}
+}
+
+// Purge permanently deletes the gists past the retention period and returns
+// how many went. Nothing is purged while retention is off.
+func (p *Purger) Purge(ctx context.Context) (*models.PurgeResult, error) {
+	retention := Retention(p.config)
+	if retention <= 0 {
+		return &models.PurgeResult{}, nil
+	}
+	result, err := models.PurgeDeletedGists(p.db.WithContext(ctx), time.Now().Add(-retention))
+	if err != nil {
+		return nil, err
+	}
+	RemoveRepositories(p.config, result.GistIDs)
+	return result, nil
+}
+
+// Start purges the trash every gists.trash.purge_interval until the context
+// is cancelled or Stop is called
+func (p *Purger) Start(ctx context.Context) {
+	ticker := time.NewTicker(p.interval())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			result, err := p.Purge(ctx)
+			if err != nil {
+				slog.Error("Trash purge failed", "error", err)
+			} else if result.Gists > 0 {
+				log.Printf("Purged %d gists from the trash", result.Gists)
+			}
+		}
+	}
+}
+
+// Stop stops purging
+func (p *Purger) Stop() {
+	select {
+	case p.stop <- true:
+	default:
+	}
+}
+
+// interval returns the pause between purges (default: 1 hour)
+func (p *Purger) interval() time.Duration {
+	interval := p.config.GetDuration("gists.trash.purge_interval")
+	if interval <= 0 {
+		interval = time.Hour
+	}
+	return interval
+}
@@ -0,0 +1,70 @@
+package trash
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/git"
+)
+
+func TestPurge(t *testing.T) {
+	db, err := gorm.Open(sqlite.Open(":memory:"), &gorm.Config{
+		DisableForeignKeyConstraintWhenMigrating: true,
+	})
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&models.User{}, &models.Gist{}, &models.GistFile{}, &models.GistStar{},
+		&models.GistComment{}, &models.GistView{}, &models.GistWatch{}, &models.Tag{}))
+
+	user := &models.User{Username: "alice", Email: "alice@example.com"}
+	require.NoError(t, db.Create(user).Error)
+	newGist := func(title string, deletedAgo time.Duration) *models.Gist {
+		gist := &models.Gist{
+			Title:  title,
+			UserID: &user.ID,
+			Files:  []models.GistFile{{Filename: title + ".txt", Content: title}},
+		}
+		require.NoError(t, db.Create(gist).Error)
+		if deletedAgo > 0 {
+			require.NoError(t, db.Model(gist).Update("deleted_at", time.Now().Add(-deletedAgo)).Error)
+		}
+		return gist
+	}
+	old := newGist("old", 40*24*time.Hour)
+	newGist("recent", time.Hour)
+	newGist("live", 0)
+
+	root := t.TempDir()
+	config := config.New()
+	config.Set("git.storage.local.path", root)
+	storage := git.NewLocalDriver(root)
+	require.NoError(t, storage.Create(old.ID.String()))
+	purger := NewPurger(db, config)
+
+	// Deleted gists are kept while retention is off
+	result, err := purger.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Zero(t, result.Gists)
+
+	config.Set("gists.trash.retention_days", 30)
+	result, err = purger.Purge(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), result.Gists)
+
+	var titles []string
+	require.NoError(t, db.Unscoped().Model(&models.Gist{}).Order("title").Pluck("title", &titles).Error)
+	assert.Equal(t, []string{"live", "recent"}, titles)
+	var files int64
+	db.Model(&models.GistFile{}).Where("gist_id = ?", old.ID).Count(&files)
+	assert.Zero(t, files)
+	exists, err := storage.Exists(old.ID.String())
+	require.NoError(t, err)
+	assert.False(t, exists, "the repositories of purged gists are removed")
+}
@@ -0,0 +1,59 @@
+package update
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/backup"
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// BackupBeforeMigrations saves the database before pending migrations are
+// applied and returns where. A SQLite database is copied as it is with
+// VACUUM INTO, so the copy can be put back in place of the database file;
+// others get a database-only backup that "casgists backup restore" reads.
+// Neither is removed by the backup retention policy.
+func BackupBeforeMigrations(ctx context.Context, db *gorm.DB, cfg *config.Config) (path string, err error) {
+	manager := backup.NewManager(db, cfg)
+	if err = os.MkdirAll(manager.Dir(), 0700); err != nil {
+		return "", fmt.Errorf("failed to create backup directory: %w", err)
+	}
+	// Migrating down and up again can take two backups within a second
+	name := "casgists-pre-migration-" + time.Now().Format("20060102-150405") + "-" + uuid.New().String()[:8]
+
+	if db.Dialector.Name() == "sqlite" {
+		path = filepath.Join(manager.Dir(), name+".db")
+		// VACUUM INTO takes a string literal, not a bound parameter
+		quoted := "'" + strings.ReplaceAll(path, "'", "''") + "'"
+		if err = db.WithContext(ctx).Exec("VACUUM INTO " + quoted).Error; err != nil {
+			return "", fmt.Errorf("failed to copy the database: %w", err)
+		}
+		return path, os.Chmod(path, 0600)
+	}
+
+	var key string
+	if cfg.GetBool("backup.encrypt") {
+		if key, err = manager.EncryptionKey(); err != nil {
+			return "", fmt.Errorf("failed to read encryption key: %w", err)
+		}
+	}
+	result, err := manager.CreateBackup(ctx, backup.BackupOptions{
+		Type:          backup.TypeSnapshot,
+		OutputPath:    filepath.Join(manager.Dir(), name+".tar.gz"),
+		EncryptionKey: key,
+	})
+	if err != nil {
+		return "", err
+	}
+	if !result.Success {
+		return "", errors.New(strings.Join(result.Errors, "; "))
+	}
+	return result.OutputPath, nil
+}
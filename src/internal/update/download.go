@@ -0,0 +1,205 @@
+package update
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+)
+
+// maxBinarySize bounds what is read from a release archive
+const maxBinarySize = 512 << 20
+
+// BinaryName is the name of the binary in the release archive of a platform
+func BinaryName(goos, goarch string) string {
+	name := "casgists-" + goos + "-" + goarch
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name
+}
+
+// AssetName is the name of the release archive of a platform
+func AssetName(goos, goarch string) string {
+	if goos == "windows" {
+		return "casgists-" + goos + "-" + goarch + ".zip"
+	}
+	return "casgists-" + goos + "-" + goarch + ".tar.gz"
+}
+
+// Asset returns the release download with the given name
+func (r *Release) Asset(name string) (Asset, bool) {
+	for _, asset := range r.Assets {
+		if asset.Name == name {
+			return asset, true
+		}
+	}
+	return Asset{}, false
+}
+
+// Download fetches the binary of a release for the running platform. The
+// archive must match its line in SHA256SUMS.txt, and when update.public_key
+// is set SHA256SUMS.txt must carry a valid ed25519 signature by that key.
+func (c *Client) Download(ctx context.Context, release *Release) ([]byte, error) {
+	archiveName := AssetName(runtime.GOOS, runtime.GOARCH)
+	archive, ok := release.Asset(archiveName)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no build for %s/%s", release.Version, runtime.GOOS, runtime.GOARCH)
+	}
+	checksums, ok := release.Asset(ChecksumsAsset)
+	if !ok {
+		return nil, fmt.Errorf("release %s has no %s to verify the download with", release.Version, ChecksumsAsset)
+	}
+
+	sums, err := c.fetch(ctx, checksums, 1<<20)
+	if err != nil {
+		return nil, err
+	}
+	if c.publicKey != "" {
+		signature, ok := release.Asset(SignatureAsset)
+		if !ok {
+			return nil, fmt.Errorf("release %s is not signed, but update.public_key is set", release.Version)
+		}
+		sig, err := c.fetch(ctx, signature, 1<<10)
+		if err != nil {
+			return nil, err
+		}
+		if err := VerifySignature(c.publicKey, sums, sig); err != nil {
+			return nil, err
+		}
+	}
+	want, err := Checksum(sums, archiveName)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.fetch(ctx, archive, maxBinarySize)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	if hex.EncodeToString(sum[:]) != want {
+		return nil, fmt.Errorf("checksum mismatch for %s", archiveName)
+	}
+	return Extract(data, archiveName, BinaryName(runtime.GOOS, runtime.GOARCH))
+}
+
+// fetch downloads an asset, failing when it is larger than limit
+func (c *Client) fetch(ctx context.Context, asset Asset, limit int64) ([]byte, error) {
+	resp, err := c.get(ctx, asset.URL, "application/octet-stream")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, limit+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", asset.Name, err)
+	}
+	if int64(len(data)) > limit {
+		return nil, fmt.Errorf("%s is larger than %d bytes", asset.Name, limit)
+	}
+	return data, nil
+}
+
+// Checksum returns the hex SHA-256 of a file in sha256sum output
+func Checksum(sums []byte, name string) (string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(sums))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		// "<sum>  <name>", or "<sum> *<name>" for binary mode
+		if len(fields) == 2 && strings.TrimPrefix(fields[1], "*") == name {
+			return strings.ToLower(fields[0]), nil
+		}
+	}
+	return "", fmt.Errorf("%s has no checksum for %s", ChecksumsAsset, name)
+}
+
+// VerifySignature checks an ed25519 signature, raw or base64, of message
+// by a base64 public key
+func VerifySignature(publicKey string, message, signature []byte) error {
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(publicKey))
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return errors.New("update.public_key is not a base64 ed25519 public key")
+	}
+	if len(signature) != ed25519.SignatureSize {
+		decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(signature)))
+		if err != nil {
+			return fmt.Errorf("%s is not an ed25519 signature", SignatureAsset)
+		}
+		signature = decoded
+	}
+	if !ed25519.Verify(ed25519.PublicKey(key), message, signature) {
+		return fmt.Errorf("the signature of %s does not match update.public_key", ChecksumsAsset)
+	}
+	return nil
+}
+
+// Extract reads the binary out of a .tar.gz or .zip release archive
+func Extract(archive []byte, archiveName, binary string) ([]byte, error) {
+	if strings.HasSuffix(archiveName, ".zip") {
+		reader, err := zip.NewReader(bytes.NewReader(archive), int64(len(archive)))
+		if err != nil {
+			return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+		}
+		for _, file := range reader.File {
+			if baseName(file.Name) != binary {
+				continue
+			}
+			rc, err := file.Open()
+			if err != nil {
+				return nil, err
+			}
+			defer rc.Close()
+			return readLimited(rc)
+		}
+		return nil, fmt.Errorf("%s has no %s", archiveName, binary)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s: %w", archiveName, err)
+	}
+	defer gz.Close()
+	reader := tar.NewReader(gz)
+	for {
+		header, err := reader.Next()
+		if err == io.EOF {
+			return nil, fmt.Errorf("%s has no %s", archiveName, binary)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", archiveName, err)
+		}
+		if header.Typeflag == tar.TypeReg && baseName(header.Name) == binary {
+			return readLimited(reader)
+		}
+	}
+}
+
+func readLimited(r io.Reader) ([]byte, error) {
+	data, err := io.ReadAll(io.LimitReader(r, maxBinarySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) > maxBinarySize {
+		return nil, errors.New("binary in the release archive is too large")
+	}
+	return data, nil
+}
+
+// baseName strips the directories of an archive entry, which always uses
+// forward slashes
+func baseName(name string) string {
+	return name[strings.LastIndex(name, "/")+1:]
+}
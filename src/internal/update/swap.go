@@ -0,0 +1,75 @@
+package update
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// Stage writes a downloaded binary next to target as target.new, with the
+// mode of target, so it ends up on the same file system for the swap
+func Stage(target string, binary []byte) (string, error) {
+	mode := os.FileMode(0755)
+	if info, err := os.Stat(target); err == nil {
+		mode = info.Mode().Perm()
+	}
+
+	staged := target + ".new"
+	file, err := os.OpenFile(staged, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, mode)
+	if err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", staged, err)
+	}
+	if _, err := file.Write(binary); err != nil {
+		file.Close()
+		os.Remove(staged)
+		return "", fmt.Errorf("failed to write %s: %w", staged, err)
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(staged)
+		return "", err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	// OpenFile's mode is reduced by the umask
+	if err := os.Chmod(staged, mode); err != nil {
+		os.Remove(staged)
+		return "", err
+	}
+	return staged, nil
+}
+
+// Swap replaces target with the staged binary and keeps the old one as
+// target.old, which is returned. On Unix the replacement is a single
+// rename, so target is never missing; Windows can't replace a running
+// executable, but can rename it out of the way first.
+func Swap(target, staged string) (string, error) {
+	old := target + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to remove the previous %s: %w", filepath.Base(old), err)
+	}
+
+	if runtime.GOOS == "windows" {
+		if err := os.Rename(target, old); err != nil {
+			return "", fmt.Errorf("failed to move %s aside: %w", target, err)
+		}
+		if err := os.Rename(staged, target); err != nil {
+			// Put the running binary back
+			os.Rename(old, target)
+			return "", fmt.Errorf("failed to replace %s: %w", target, err)
+		}
+		return old, nil
+	}
+
+	if err := os.Link(target, old); err != nil {
+		return "", fmt.Errorf("failed to keep the current binary as %s: %w", old, err)
+	}
+	if err := os.Rename(staged, target); err != nil {
+		os.Remove(old)
+		return "", fmt.Errorf("failed to replace %s: %w", target, err)
+	}
+	return old, nil
+}
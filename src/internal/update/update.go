@@ -0,0 +1,217 @@
+// Package update checks GitHub releases for a newer CasGists, downloads and
+// verifies its binary and swaps it in for the running one. The database is
+// migrated when the new binary starts, after BackupBeforeMigrations has
+// saved a copy of it.
+package update
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Release archives are named after the platform, the way the Makefile
+// packages them, next to a checksum file and optionally its signature
+const (
+	ChecksumsAsset = "SHA256SUMS.txt"
+	SignatureAsset = "SHA256SUMS.txt.sig"
+)
+
+// ErrNoRelease is returned when the repository has no published release,
+// or none with the requested tag
+var ErrNoRelease = errors.New("release not found")
+
+// Release is a published release and its downloads
+type Release struct {
+	Version     string    `json:"version"`
+	Name        string    `json:"name"`
+	URL         string    `json:"url"`
+	Notes       string    `json:"notes"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []Asset   `json:"assets"`
+}
+
+// Asset is a file attached to a release
+type Asset struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	Size int64  `json:"size"`
+}
+
+// Status compares the running version with the latest release
+type Status struct {
+	Current   string    `json:"current"`
+	Latest    string    `json:"latest"`
+	Available bool      `json:"update_available"`
+	Release   *Release  `json:"release,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+// Client talks to the GitHub releases API of update.repository
+type Client struct {
+	http       *http.Client
+	apiURL     string
+	repository string
+	publicKey  string
+	interval   time.Duration
+
+	mu      sync.Mutex
+	checked *Status
+}
+
+// NewClient creates a client from the update.* settings
+func NewClient(cfg *config.Config) *Client {
+	return &Client{
+		http:       &http.Client{Timeout: 5 * time.Minute},
+		apiURL:     strings.TrimRight(cfg.GetString("update.api_url"), "/"),
+		repository: cfg.GetString("update.repository"),
+		publicKey:  cfg.GetString("update.public_key"),
+		interval:   cfg.GetDuration("update.check_interval"),
+	}
+}
+
+// Check compares current with the latest release. The result is reused for
+// update.check_interval, as unauthenticated API requests are limited to 60
+// an hour; refresh asks again.
+func (c *Client) Check(ctx context.Context, current string, refresh bool) (*Status, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if !refresh && c.checked != nil && c.checked.Current == current && time.Since(c.checked.CheckedAt) < c.interval {
+		return c.checked, nil
+	}
+
+	release, err := c.Latest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.checked = &Status{
+		Current:   current,
+		Latest:    release.Version,
+		Available: Newer(release.Version, current),
+		Release:   release,
+		CheckedAt: time.Now(),
+	}
+	return c.checked, nil
+}
+
+// Latest returns the latest published release, leaving out drafts and
+// pre-releases
+func (c *Client) Latest(ctx context.Context) (*Release, error) {
+	return c.release(ctx, "/repos/"+c.repository+"/releases/latest")
+}
+
+// Tagged returns the release of a version, such as 1.2.0 or v1.2.0
+func (c *Client) Tagged(ctx context.Context, version string) (*Release, error) {
+	return c.release(ctx, "/repos/"+c.repository+"/releases/tags/v"+strings.TrimPrefix(version, "v"))
+}
+
+// githubRelease is the part of the API's release object that is used
+type githubRelease struct {
+	TagName     string    `json:"tag_name"`
+	Name        string    `json:"name"`
+	HTMLURL     string    `json:"html_url"`
+	Body        string    `json:"body"`
+	PublishedAt time.Time `json:"published_at"`
+	Assets      []struct {
+		Name               string `json:"name"`
+		BrowserDownloadURL string `json:"browser_download_url"`
+		Size               int64  `json:"size"`
+	} `json:"assets"`
+}
+
+func (c *Client) release(ctx context.Context, path string) (*Release, error) {
+	resp, err := c.get(ctx, c.apiURL+path, "application/vnd.github+json")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var found githubRelease
+	if err := json.NewDecoder(resp.Body).Decode(&found); err != nil {
+		return nil, fmt.Errorf("unexpected release response: %w", err)
+	}
+	release := &Release{
+		Version:     strings.TrimPrefix(found.TagName, "v"),
+		Name:        found.Name,
+		URL:         found.HTMLURL,
+		Notes:       found.Body,
+		PublishedAt: found.PublishedAt,
+	}
+	for _, asset := range found.Assets {
+		release.Assets = append(release.Assets, Asset{Name: asset.Name, URL: asset.BrowserDownloadURL, Size: asset.Size})
+	}
+	return release, nil
+}
+
+// get sends a GET request and fails on anything but 200
+func (c *Client) get(ctx context.Context, url, accept string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", accept)
+	req.Header.Set("User-Agent", "CasGists-Updater")
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, ErrNoRelease
+		}
+		return nil, fmt.Errorf("GET %s: %s %s", url, resp.Status, strings.TrimSpace(string(body)))
+	}
+	return resp, nil
+}
+
+// Newer reports whether version is a later release than current. Versions
+// are compared as major.minor.patch; a pre-release such as 1.2.0-rc1 comes
+// before 1.2.0. A current version that isn't a release, such as "dev", is
+// never older.
+func Newer(version, current string) bool {
+	v, vPre, ok := parseVersion(version)
+	if !ok {
+		return false
+	}
+	c, cPre, ok := parseVersion(current)
+	if !ok {
+		return false
+	}
+	for i := range v {
+		if v[i] != c[i] {
+			return v[i] > c[i]
+		}
+	}
+	// The same release: only a final release is newer than its pre-release
+	return vPre == "" && cPre != ""
+}
+
+func parseVersion(version string) ([3]int, string, bool) {
+	var parts [3]int
+	version = strings.TrimPrefix(strings.TrimSpace(version), "v")
+	version, pre, _ := strings.Cut(version, "-")
+	fields := strings.Split(version, ".")
+	if len(fields) == 0 || len(fields) > 3 {
+		return parts, "", false
+	}
+	for i, field := range fields {
+		n, err := strconv.Atoi(field)
+		if err != nil || n < 0 {
+			return parts, "", false
+		}
+		parts[i] = n
+	}
+	return parts, pre, true
+}
@@ -0,0 +1,251 @@
+package update
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/glebarez/sqlite"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestNewer(t *testing.T) {
+	tests := []struct {
+		version, current string
+		newer            bool
+	}{
+		{"1.2.0", "1.1.9", true},
+		{"v1.10.0", "1.9.0", true},
+		{"1.2.0", "1.2.0", false},
+		{"1.1.0", "1.2.0", false},
+		{"2", "1.9.9", true},
+		{"1.2.0", "1.2.0-rc1", true},
+		{"1.2.0-rc1", "1.2.0", false},
+		{"1.3.0", "dev", false},
+		{"latest", "1.0.0", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.newer, Newer(tt.version, tt.current), "%s over %s", tt.version, tt.current)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	sums := []byte("abc123  casgists-linux-amd64.tar.gz\nDEF456 *casgists-windows-amd64.zip\n")
+
+	sum, err := Checksum(sums, "casgists-linux-amd64.tar.gz")
+	require.NoError(t, err)
+	assert.Equal(t, "abc123", sum)
+
+	sum, err = Checksum(sums, "casgists-windows-amd64.zip")
+	require.NoError(t, err)
+	assert.Equal(t, "def456", sum)
+
+	_, err = Checksum(sums, "casgists-darwin-arm64.tar.gz")
+	assert.Error(t, err)
+}
+
+func TestVerifySignature(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key := base64.StdEncoding.EncodeToString(public)
+	message := []byte("sums")
+	signature := ed25519.Sign(private, message)
+
+	assert.NoError(t, VerifySignature(key, message, signature))
+	assert.NoError(t, VerifySignature(key, message, []byte(base64.StdEncoding.EncodeToString(signature)+"\n")))
+	assert.Error(t, VerifySignature(key, []byte("other"), signature))
+	assert.Error(t, VerifySignature("not a key", message, signature))
+}
+
+// release serves a fake GitHub release of version with a build for the
+// running platform containing binary
+type release struct {
+	version string
+	binary  []byte
+	sign    ed25519.PrivateKey
+	corrupt bool
+}
+
+func (r release) serve(t *testing.T) *httptest.Server {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake release is a .tar.gz")
+	}
+	archiveName := AssetName(runtime.GOOS, runtime.GOARCH)
+	archive := tarGz(t, "casgists-"+r.version+"/"+BinaryName(runtime.GOOS, runtime.GOARCH), r.binary)
+	sum := sha256.Sum256(archive)
+	sums := []byte(fmt.Sprintf("%s  %s\n", hex.EncodeToString(sum[:]), archiveName))
+	if r.corrupt {
+		archive = append(archive, 0)
+	}
+
+	var server *httptest.Server
+	mux := http.NewServeMux()
+	mux.HandleFunc("/repos/casapps/casgists/releases/latest", func(w http.ResponseWriter, req *http.Request) {
+		assets := fmt.Sprintf(`{"name":%q,"browser_download_url":"%s/download/archive"},{"name":"SHA256SUMS.txt","browser_download_url":"%s/download/sums"}`,
+			archiveName, server.URL, server.URL)
+		if r.sign != nil {
+			assets += fmt.Sprintf(`,{"name":"SHA256SUMS.txt.sig","browser_download_url":"%s/download/sig"}`, server.URL)
+		}
+		fmt.Fprintf(w, `{"tag_name":"v%s","name":"CasGists v%s","html_url":"https://example.com","assets":[%s]}`, r.version, r.version, assets)
+	})
+	mux.HandleFunc("/download/archive", func(w http.ResponseWriter, req *http.Request) { w.Write(archive) })
+	mux.HandleFunc("/download/sums", func(w http.ResponseWriter, req *http.Request) { w.Write(sums) })
+	mux.HandleFunc("/download/sig", func(w http.ResponseWriter, req *http.Request) { w.Write(ed25519.Sign(r.sign, sums)) })
+	server = httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+func tarGz(t *testing.T, name string, content []byte) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0755, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+	_, err := tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	require.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func newTestClient(url, publicKey string) *Client {
+	cfg := config.New()
+	cfg.Set("update.api_url", url)
+	cfg.Set("update.repository", "casapps/casgists")
+	cfg.Set("update.public_key", publicKey)
+	cfg.Set("update.check_interval", time.Hour)
+	return NewClient(cfg)
+}
+
+func TestCheck(t *testing.T) {
+	server := release{version: "1.4.0", binary: []byte("new")}.serve(t)
+	client := newTestClient(server.URL, "")
+
+	status, err := client.Check(context.Background(), "1.3.2", false)
+	require.NoError(t, err)
+	assert.True(t, status.Available)
+	assert.Equal(t, "1.4.0", status.Latest)
+	assert.Equal(t, "CasGists v1.4.0", status.Release.Name)
+
+	cached, err := client.Check(context.Background(), "1.3.2", false)
+	require.NoError(t, err)
+	assert.Same(t, status, cached)
+
+	status, err = client.Check(context.Background(), "1.4.0", false)
+	require.NoError(t, err)
+	assert.False(t, status.Available)
+}
+
+func TestDownload(t *testing.T) {
+	server := release{version: "1.4.0", binary: []byte("new binary")}.serve(t)
+	client := newTestClient(server.URL, "")
+
+	found, err := client.Latest(context.Background())
+	require.NoError(t, err)
+	binary, err := client.Download(context.Background(), found)
+	require.NoError(t, err)
+	assert.Equal(t, []byte("new binary"), binary)
+}
+
+func TestDownloadChecksumMismatch(t *testing.T) {
+	server := release{version: "1.4.0", binary: []byte("new"), corrupt: true}.serve(t)
+	client := newTestClient(server.URL, "")
+
+	found, err := client.Latest(context.Background())
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), found)
+	assert.ErrorContains(t, err, "checksum mismatch")
+}
+
+func TestDownloadSigned(t *testing.T) {
+	public, private, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	key := base64.StdEncoding.EncodeToString(public)
+
+	signed := release{version: "1.4.0", binary: []byte("new"), sign: private}.serve(t)
+	client := newTestClient(signed.URL, key)
+	found, err := client.Latest(context.Background())
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), found)
+	assert.NoError(t, err)
+
+	unsigned := release{version: "1.4.0", binary: []byte("new")}.serve(t)
+	client = newTestClient(unsigned.URL, key)
+	found, err = client.Latest(context.Background())
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), found)
+	assert.ErrorContains(t, err, "not signed")
+
+	_, other, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	forged := release{version: "1.4.0", binary: []byte("new"), sign: other}.serve(t)
+	client = newTestClient(forged.URL, key)
+	found, err = client.Latest(context.Background())
+	require.NoError(t, err)
+	_, err = client.Download(context.Background(), found)
+	assert.ErrorContains(t, err, "does not match")
+}
+
+func TestLatestNoRelease(t *testing.T) {
+	server := httptest.NewServer(http.NotFoundHandler())
+	defer server.Close()
+
+	_, err := newTestClient(server.URL, "").Latest(context.Background())
+	assert.ErrorIs(t, err, ErrNoRelease)
+}
+
+func TestStageAndSwap(t *testing.T) {
+	target := filepath.Join(t.TempDir(), "casgists")
+	require.NoError(t, os.WriteFile(target, []byte("old"), 0750))
+
+	staged, err := Stage(target, []byte("new"))
+	require.NoError(t, err)
+	info, err := os.Stat(staged)
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0750), info.Mode().Perm())
+
+	old, err := Swap(target, staged)
+	require.NoError(t, err)
+	content, _ := os.ReadFile(target)
+	assert.Equal(t, "new", string(content))
+	content, _ = os.ReadFile(old)
+	assert.Equal(t, "old", string(content))
+	assert.NoFileExists(t, staged)
+}
+
+func TestBackupBeforeMigrationsSQLite(t *testing.T) {
+	dir := t.TempDir()
+	db, err := gorm.Open(sqlite.Open(filepath.Join(dir, "data.db")), &gorm.Config{})
+	require.NoError(t, err)
+	require.NoError(t, db.Exec("CREATE TABLE notes (body TEXT)").Error)
+	require.NoError(t, db.Exec("INSERT INTO notes VALUES ('kept')").Error)
+
+	cfg := config.New()
+	cfg.Set("backup.path", filepath.Join(dir, "backups"))
+	path, err := BackupBeforeMigrations(context.Background(), db, cfg)
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(dir, "backups"), filepath.Dir(path))
+
+	copied, err := gorm.Open(sqlite.Open(path), &gorm.Config{})
+	require.NoError(t, err)
+	var body string
+	require.NoError(t, copied.Raw("SELECT body FROM notes").Scan(&body).Error)
+	assert.Equal(t, "kept", body)
+}
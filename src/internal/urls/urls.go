@@ -0,0 +1,139 @@
+// Package urls holds the canonical routes of gists and builds their
+// addresses. Routes name their parameters ParamGist and ParamFile, so every
+// handler reads a gist ID or filename under the same name, and links in
+// pages, emails and webhook payloads are built from the same patterns the
+// server routes.
+package urls
+
+import (
+	"net/url"
+	"strings"
+
+	"github.com/google/uuid"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+// Route parameter names
+const (
+	ParamGist     = "id"
+	ParamFile     = "file"
+	ParamRevision = "sha"
+)
+
+// QueryShare carries a share link's token on gist routes that honor it
+const QueryShare = "share"
+
+// Canonical gist routes
+const (
+	GistPage    = "/gists/:" + ParamGist
+	GistEmbed   = "/gists/:" + ParamGist + "/embed"
+	GistJSON    = "/g/:" + ParamGist
+	RawFile     = "/raw/:" + ParamGist + "/:" + ParamFile
+	RawRevision = "/raw/:" + ParamGist + "/:" + ParamRevision + "/:" + ParamFile
+	APIGist     = "/api/v1/gists/:" + ParamGist
+	APIRaw      = "/api/v1/gists/:" + ParamGist + "/raw"
+	APIDownload = "/api/v1/gists/:" + ParamGist + "/download"
+
+	APIAttachment = "/api/v1/gists/:" + ParamGist + "/attachments/:" + ParamFile
+)
+
+// LegacyRoutes maps paths that older pages and clients link to onto the
+// canonical routes they are redirected to
+var LegacyRoutes = map[string]string{
+	"/gist/:" + ParamGist:                         GistPage,
+	"/gists/:" + ParamGist + "/raw":               APIRaw,
+	"/gists/:" + ParamGist + "/raw/:" + ParamFile: RawFile,
+}
+
+// ParamAliases maps parameter names some routes and clients used before
+// the names were settled to the canonical ones
+var ParamAliases = map[string]string{
+	"gistId":   ParamGist,
+	"gist_id":  ParamGist,
+	"gistID":   ParamGist,
+	"filename": ParamFile,
+}
+
+// Path fills the parameters of a route pattern in order, escaping each
+// value as a path segment. Missing values leave the rest of the pattern
+// as it is.
+func Path(pattern string, values ...string) string {
+	segments := strings.Split(pattern, "/")
+	for i, segment := range segments {
+		if !strings.HasPrefix(segment, ":") || len(values) == 0 {
+			continue
+		}
+		segments[i] = url.PathEscape(values[0])
+		values = values[1:]
+	}
+	return strings.Join(segments, "/")
+}
+
+// Params returns the parameter names of a route pattern in order
+func Params(pattern string) []string {
+	var names []string
+	for _, segment := range strings.Split(pattern, "/") {
+		if strings.HasPrefix(segment, ":") {
+			names = append(names, segment[1:])
+		}
+	}
+	return names
+}
+
+// IsGistRoute reports whether the ParamGist parameter of a route, as
+// registered with the router, holds a gist ID. Other routes use the same
+// name for their own IDs.
+func IsGistRoute(route string) bool {
+	return strings.Contains(route, "/gists/:"+ParamGist) ||
+		strings.HasPrefix(route, "/g/:"+ParamGist) ||
+		strings.HasPrefix(route, "/gist/:"+ParamGist) ||
+		strings.HasPrefix(route, "/raw/:"+ParamGist)
+}
+
+// CanonicalID returns the canonical form of a gist ID: the lowercase,
+// hyphenated UUID. It accepts the forms uuid.Parse does, including the
+// 32 hex digits without hyphens that some clients shorten IDs to.
+func CanonicalID(raw string) (string, bool) {
+	id, err := uuid.Parse(raw)
+	if err != nil {
+		return "", false
+	}
+	return id.String(), true
+}
+
+// Builder builds absolute URLs on the configured server.url
+type Builder struct {
+	base string
+}
+
+// NewBuilder creates a builder for the server.url setting. Without one,
+// URLs are relative to the server root.
+func NewBuilder(config *config.Config) *Builder {
+	return &Builder{base: strings.TrimSuffix(config.GetString("server.url"), "/")}
+}
+
+// URL returns the absolute URL of a route pattern filled with values
+func (b *Builder) URL(pattern string, values ...string) string {
+	return b.base + Path(pattern, values...)
+}
+
+// Gist returns the URL of a gist's page
+func (b *Builder) Gist(id uuid.UUID) string {
+	return b.URL(GistPage, id.String())
+}
+
+// RawFile returns the URL serving one file of a gist as it is
+func (b *Builder) RawFile(id uuid.UUID, filename string) string {
+	return b.URL(RawFile, id.String(), filename)
+}
+
+// RawRevision returns the URL serving one file of a gist as of a commit
+func (b *Builder) RawRevision(id uuid.UUID, revision, filename string) string {
+	return b.URL(RawRevision, id.String(), revision, filename)
+}
+
+// Shared adds a share link's token to a URL
+func Shared(address, token string) string {
+	return address + "?" + QueryShare + "=" + url.QueryEscape(token)
+}
@@ -0,0 +1,68 @@
+package urls
+
+import (
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/casapps/casgists/src/internal/config"
+)
+
+func TestPath(t *testing.T) {
+	assert.Equal(t, "/gists/abc", Path(GistPage, "abc"))
+	assert.Equal(t, "/gists/abc/embed", Path(GistEmbed, "abc"))
+	assert.Equal(t, "/raw/abc/my%20notes.md", Path(RawFile, "abc", "my notes.md"))
+	assert.Equal(t, "/raw/abc/a%2Fb", Path(RawFile, "abc", "a/b"))
+	assert.Equal(t, "/raw/abc/:file", Path(RawFile, "abc"))
+	assert.Equal(t, "/raw/abc/0f1e2d3/a%2Fb", Path(RawRevision, "abc", "0f1e2d3", "a/b"))
+	assert.Equal(t, "/api/v1/gists/abc/raw", Path(APIRaw, "abc"))
+}
+
+func TestCanonicalID(t *testing.T) {
+	id := uuid.MustParse("0b8e1c9a-5f5e-4f3c-9a53-7d2d6c1e4f10")
+	for _, raw := range []string{
+		"0b8e1c9a-5f5e-4f3c-9a53-7d2d6c1e4f10",
+		"0B8E1C9A-5F5E-4F3C-9A53-7D2D6C1E4F10",
+		"0b8e1c9a5f5e4f3c9a537d2d6c1e4f10",
+		"{0b8e1c9a-5f5e-4f3c-9a53-7d2d6c1e4f10}",
+	} {
+		canonical, ok := CanonicalID(raw)
+		assert.True(t, ok, raw)
+		assert.Equal(t, id.String(), canonical, raw)
+	}
+	_, ok := CanonicalID("not-a-gist")
+	assert.False(t, ok)
+}
+
+func TestBuilder(t *testing.T) {
+	cfg := config.New()
+	cfg.Set("server.url", "https://gists.example.com/")
+	b := NewBuilder(cfg)
+	id := uuid.MustParse("0b8e1c9a-5f5e-4f3c-9a53-7d2d6c1e4f10")
+
+	assert.Equal(t, "https://gists.example.com/gists/"+id.String(), b.Gist(id))
+	assert.Equal(t, "https://gists.example.com/raw/"+id.String()+"/hello%20world.go", b.RawFile(id, "hello world.go"))
+	assert.Equal(t, "https://gists.example.com/raw/"+id.String()+"/0f1e2d3/main.go", b.RawRevision(id, "0f1e2d3", "main.go"))
+	assert.Equal(t, "/gists/"+id.String(), NewBuilder(config.New()).Gist(id))
+	assert.Equal(t, "/api/v1/gists/abc/raw?share=a-b%2Bc", Shared(Path(APIRaw, "abc"), "a-b+c"))
+}
+
+func TestRoutes(t *testing.T) {
+	assert.Equal(t, []string{ParamGist, ParamFile}, Params(RawFile))
+	assert.Empty(t, Params("/gists/new"))
+
+	for _, route := range []string{
+		GistPage, GistEmbed, GistJSON, RawFile, APIGist, APIRaw,
+		"/api/gists/:id/comments", "/api/v1/gists/:id/files/:file/image.png",
+	} {
+		assert.True(t, IsGistRoute(route), route)
+	}
+	for legacy, canonical := range LegacyRoutes {
+		assert.True(t, IsGistRoute(legacy), legacy)
+		assert.Equal(t, Params(canonical), Params(legacy), legacy)
+	}
+	for _, route := range []string{"/api/v1/automation/rules/:id", "/admin/newsletters/:id", "/o/:org/:slug"} {
+		assert.False(t, IsGistRoute(route), route)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	_ "time/tzdata" // Time zone preferences work on hosts without zoneinfo
+)
+
+// LegacyTimestampLayout is how gist timestamps were formatted before the
+// API standardized on RFC 3339 in UTC. It labelled server-local times as
+// UTC; api.legacy_timestamps brings it back for clients not yet updated.
+const LegacyTimestampLayout = "2006-01-02T15:04:05Z"
+
+// FormatTimestamp formats t for API responses: RFC 3339 in UTC
+func FormatTimestamp(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// LoadTimezone resolves an IANA time zone name such as "Europe/Berlin".
+// An empty name is UTC.
+func LoadTimezone(name string) (*time.Location, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || strings.EqualFold(name, "UTC") {
+		return time.UTC, nil
+	}
+	loc, err := time.LoadLocation(name)
+	if err != nil || strings.EqualFold(name, "Local") {
+		return nil, fmt.Errorf("unknown time zone %q", name)
+	}
+	return loc, nil
+}
+
+// Timezone is LoadTimezone falling back to UTC for unknown names, for
+// preferences saved before they were validated
+func Timezone(name string) *time.Location {
+	loc, err := LoadTimezone(name)
+	if err != nil {
+		return time.UTC
+	}
+	return loc
+}
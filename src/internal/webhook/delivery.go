@@ -203,7 +203,7 @@ func (d *DeliveryService) RetryFailedDeliveries(ctx context.Context) error {
 func (d *DeliveryService) PublishEvent(ctx context.Context, eventType string, data map[string]interface{}) error {
 	// Get active webhooks that subscribe to this event
 	var webhooks []models.Webhook
-	if err := d.db.Where("is_active = ? AND events LIKE ?", true, "%"+eventType+"%").Find(&webhooks).Error; err != nil {
+	if err := scopeToGist(d.db.Where("is_active = ? AND events LIKE ?", true, "%"+eventType+"%"), data).Find(&webhooks).Error; err != nil {
 		return fmt.Errorf("failed to get webhooks: %w", err)
 	}
 
@@ -9,6 +9,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/casapps/casgists/src/internal/models"
@@ -78,6 +79,8 @@ type Manager struct {
 	httpClient *http.Client
 	queue      chan *Event
 	workers    int
+	stop       chan struct{}
+	stopOnce   sync.Once
 }
 
 // NewManager creates a new webhook manager
@@ -93,14 +96,28 @@ func NewManager(db *gorm.DB, workers int) *Manager {
 		},
 		queue:   make(chan *Event, 1000),
 		workers: workers,
+		stop:    make(chan struct{}),
 	}
 }
 
-// Start starts the webhook processing workers
+// Start runs the webhook processing workers until the context is
+// cancelled or Stop is called, and returns once they have all finished
 func (m *Manager) Start(ctx context.Context) {
+	var wg sync.WaitGroup
 	for i := 0; i < m.workers; i++ {
-		go m.worker(ctx)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.worker(ctx)
+		}()
 	}
+	wg.Wait()
+}
+
+// Stop stops taking events. The workers deliver the events already queued
+// before Start returns.
+func (m *Manager) Stop() {
+	m.stopOnce.Do(func() { close(m.stop) })
 }
 
 // TriggerEvent triggers a webhook event
@@ -125,6 +142,12 @@ func (m *Manager) TriggerEvent(eventType EventType, actor *models.User, data map
 		}
 	}
 
+	select {
+	case <-m.stop:
+		return fmt.Errorf("webhook manager is shutting down")
+	default:
+	}
+
 	select {
 	case m.queue <- event:
 		return nil
@@ -140,18 +163,35 @@ func (m *Manager) worker(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-m.stop:
+			m.drain(ctx)
+			return
+		case event := <-m.queue:
+			m.processEvent(event)
+		}
+	}
+}
+
+// drain processes the events left in the queue, giving up on the rest
+// when the context is cancelled
+func (m *Manager) drain(ctx context.Context) {
+	for ctx.Err() == nil {
+		select {
 		case event := <-m.queue:
 			m.processEvent(event)
+		default:
+			return
 		}
 	}
 }
 
 // processEvent processes a single webhook event
 func (m *Manager) processEvent(event *Event) {
-	// Find all active subscriptions for this event type
+	// Find all active subscriptions for this event type. Subscriptions
+	// attached to a gist only receive events about that gist.
 	var subscriptions []models.Webhook
-	if err := m.db.Where("is_active = ? AND (events LIKE ? OR events = ?)",
-		true, "%"+string(event.Type)+"%", "*").
+	if err := scopeToGist(m.db.Where("is_active = ? AND (events LIKE ? OR events = ?)",
+		true, "%"+string(event.Type)+"%", "*"), event.Data).
 		Find(&subscriptions).Error; err != nil {
 		// Log error
 		return
@@ -293,6 +333,15 @@ func (m *Manager) scheduleRetry(subscription *models.Webhook, event *Event) {
 
 // Helper functions
 
+// scopeToGist limits a subscription query to global subscriptions plus those
+// attached to the gist named by the event's gist_id, if any
+func scopeToGist(query *gorm.DB, data map[string]interface{}) *gorm.DB {
+	if gistID, ok := data["gist_id"].(string); ok && gistID != "" {
+		return query.Where("gist_id IS NULL OR gist_id = ?", gistID)
+	}
+	return query.Where("gist_id IS NULL")
+}
+
 func mustMarshal(v interface{}) []byte {
 	b, _ := json.Marshal(v)
 	return b
@@ -325,6 +374,47 @@ func (m *Manager) CreateSubscription(userID uuid.UUID, url string, eventTypes []
 	return subscription, nil
 }
 
+// CreateOrganizationSubscription creates a webhook subscription owned by an organization
+func (m *Manager) CreateOrganizationSubscription(orgID uuid.UUID, url string, eventTypes []string, secret string) (*models.Webhook, error) {
+	subscription := &models.Webhook{
+		ID:             uuid.New(),
+		OrganizationID: &orgID,
+		URL:            url,
+		ContentType:    "application/json",
+		Events:         joinEventTypes(eventTypes),
+		Secret:         secret,
+		IsActive:       true,
+	}
+
+	if err := m.db.Create(subscription).Error; err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
+// CreateGistSubscription creates a webhook subscription that only receives
+// events about one gist. Events are stored as a JSON array, which both
+// delivery paths match.
+func (m *Manager) CreateGistSubscription(userID, gistID uuid.UUID, url string, eventTypes []string, secret string) (*models.Webhook, error) {
+	subscription := &models.Webhook{
+		ID:          uuid.New(),
+		UserID:      &userID,
+		GistID:      &gistID,
+		URL:         url,
+		ContentType: "application/json",
+		Events:      string(mustMarshal(eventTypes)),
+		Secret:      secret,
+		IsActive:    true,
+	}
+
+	if err := m.db.Create(subscription).Error; err != nil {
+		return nil, err
+	}
+
+	return subscription, nil
+}
+
 // UpdateSubscription updates a webhook subscription
 func (m *Manager) UpdateSubscription(id uuid.UUID, updates map[string]interface{}) error {
 	return m.db.Model(&models.Webhook{}).Where("id = ?", id).Updates(updates).Error
@@ -0,0 +1,124 @@
+package webhooks
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/casapps/casgists/src/internal/config"
+	"github.com/casapps/casgists/src/internal/database/models"
+)
+
+// EventDigest is the event of a delivery batching several events for a
+// webhook in digest mode
+const EventDigest WebhookEvent = "digest"
+
+// DigestEventData is the data of a digest delivery: every event from one
+// window, oldest first
+type DigestEventData struct {
+	WindowStart time.Time        `json:"window_start"`
+	WindowEnd   time.Time        `json:"window_end"`
+	Count       int              `json:"count"`
+	Events      []WebhookPayload `json:"events"`
+}
+
+// ValidateDigestWindow checks a webhook's digest window, in seconds, against
+// webhook.digest.max_window. 0 turns digest mode off.
+func ValidateDigestWindow(cfg *config.Config, seconds int) error {
+	if seconds < 0 {
+		return fmt.Errorf("digest window cannot be negative")
+	}
+	maxWindow := cfg.GetDuration("webhook.digest.max_window")
+	if maxWindow <= 0 {
+		maxWindow = time.Hour
+	}
+	if time.Duration(seconds)*time.Second > maxWindow {
+		return fmt.Errorf("digest window cannot be longer than %s", maxWindow)
+	}
+	return nil
+}
+
+// digest collects the events for one webhook until its window closes
+type digest struct {
+	start  time.Time
+	events []WebhookPayload
+	timer  *time.Timer
+}
+
+// queueDigest adds an event to the webhook's open digest, opening one if
+// needed. A digest that reaches webhook.digest.max_events is sent at once
+// rather than waiting for its window to close.
+func (m *Manager) queueDigest(ctx context.Context, webhook models.Webhook, payload WebhookPayload) {
+	maxEvents := m.cfg.GetInt("webhook.digest.max_events")
+	if maxEvents <= 0 {
+		maxEvents = 100
+	}
+
+	m.digestMu.Lock()
+	d := m.digests[webhook.ID]
+	if d == nil {
+		d = &digest{start: time.Now()}
+		window := time.Duration(webhook.DigestWindow) * time.Second
+		d.timer = time.AfterFunc(window, func() {
+			m.flushDigest(context.Background(), webhook.ID, d)
+		})
+		m.digests[webhook.ID] = d
+	}
+	d.events = append(d.events, payload)
+	full := len(d.events) >= maxEvents
+	m.digestMu.Unlock()
+
+	if full {
+		d.timer.Stop()
+		m.flushDigest(ctx, webhook.ID, d)
+	}
+}
+
+// flushDigest sends d if it is still the webhook's open digest. The webhook
+// is reloaded so a digest for a webhook deleted or deactivated in the
+// meantime is dropped, and URL or secret changes are honored.
+func (m *Manager) flushDigest(ctx context.Context, webhookID uuid.UUID, d *digest) {
+	m.digestMu.Lock()
+	if m.digests[webhookID] != d {
+		m.digestMu.Unlock()
+		return
+	}
+	delete(m.digests, webhookID)
+	m.digestMu.Unlock()
+
+	var webhook models.Webhook
+	if err := m.db.Where("id = ? AND is_active = ?", webhookID, true).First(&webhook).Error; err != nil {
+		slog.Error("Dropping webhook digest", "events", len(d.events), "webhook", webhookID, "error", err)
+		return
+	}
+
+	m.sendWebhook(ctx, webhook, WebhookPayload{
+		Event:     EventDigest,
+		Timestamp: time.Now(),
+		Data: DigestEventData{
+			WindowStart: d.start,
+			WindowEnd:   time.Now(),
+			Count:       len(d.events),
+			Events:      d.events,
+		},
+	})
+}
+
+// FlushDigests sends every open digest without waiting for its window to
+// close, e.g. before shutting down
+func (m *Manager) FlushDigests(ctx context.Context) {
+	m.digestMu.Lock()
+	pending := make(map[uuid.UUID]*digest, len(m.digests))
+	for id, d := range m.digests {
+		pending[id] = d
+	}
+	m.digestMu.Unlock()
+
+	for id, d := range pending {
+		d.timer.Stop()
+		m.flushDigest(ctx, id, d)
+	}
+}
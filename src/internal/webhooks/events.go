@@ -0,0 +1,183 @@
+package webhooks
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// EventScope says what an event is about, which decides who receives it:
+// gist webhooks only get gist-scoped events, and system events only go to
+// system-wide webhooks
+type EventScope string
+
+const (
+	ScopeGist   EventScope = "gist"
+	ScopeUser   EventScope = "user"
+	ScopeOrg    EventScope = "org"
+	ScopeSystem EventScope = "system"
+)
+
+// EventInfo describes an event of the catalogue
+type EventInfo struct {
+	Event       WebhookEvent `json:"type"`
+	Scope       EventScope   `json:"scope"`
+	Description string       `json:"description"`
+	// Payload names the type of the payload's data field
+	Payload string `json:"payload"`
+}
+
+// catalogue lists every event a webhook can subscribe to
+var catalogue = []EventInfo{
+	{EventGistCreated, ScopeUser, "A gist was created", "GistEventData"},
+	{EventGistUpdated, ScopeGist, "A gist's files or details were changed", "GistEventData"},
+	{EventGistDeleted, ScopeGist, "A gist was deleted", "{id}"},
+	{EventGistStarred, ScopeGist, "A gist was starred or unstarred", "{action, data: StarEventData}"},
+	{EventGistForked, ScopeGist, "A gist was forked", "ForkEventData"},
+	{EventGistVisibilityChanged, ScopeGist, "A gist was made public, unlisted or private", "VisibilityEventData"},
+	{EventCommentCreated, ScopeGist, "A comment was added to a gist", "CommentEventData"},
+	{EventUserCreated, ScopeUser, "A user signed up", "UserEventData"},
+	{EventUserUpdated, ScopeUser, "A user changed their profile", "UserEventData"},
+	{EventUserFollowed, ScopeUser, "A user followed another user", "FollowEventData"},
+	{EventOrgCreated, ScopeOrg, "An organization was created", "OrgEventData"},
+	{EventOrgMemberAdded, ScopeOrg, "A user joined an organization", "OrgMemberEventData"},
+	{EventOrgMemberRemoved, ScopeOrg, "A user left or was removed from an organization", "OrgMemberEventData"},
+	{EventOrgMemberRoleChanged, ScopeOrg, "An organization member's role changed", "OrgMemberEventData"},
+	{EventTeamCreated, ScopeOrg, "A team was created in an organization", "TeamEventData"},
+	{EventBackupCompleted, ScopeSystem, "A backup finished, successfully or not", "BackupEventData"},
+}
+
+// legacyEvents maps names older webhooks may have subscribed with to the
+// events that replaced them
+var legacyEvents = map[string]WebhookEvent{
+	"comment.added": EventCommentCreated,
+}
+
+// Catalogue returns every event a webhook can subscribe to
+func Catalogue() []EventInfo {
+	return append([]EventInfo(nil), catalogue...)
+}
+
+// GistEvents returns the events a gist webhook can subscribe to
+func GistEvents() []WebhookEvent {
+	var events []WebhookEvent
+	for _, info := range catalogue {
+		if info.Scope == ScopeGist {
+			events = append(events, info.Event)
+		}
+	}
+	return events
+}
+
+// EventSet is a webhook's parsed subscription. Each entry is an event name,
+// a namespace wildcard such as "gist.*", or "*" for every event.
+type EventSet []string
+
+// ParseEvents reads a webhook's Events column. Webhooks created through the
+// API keep a JSON array and older ones a comma separated list; both are
+// accepted, and legacy event names are translated.
+func ParseEvents(raw string) EventSet {
+	var entries []string
+	raw = strings.TrimSpace(raw)
+	if strings.HasPrefix(raw, "[") {
+		if err := json.Unmarshal([]byte(raw), &entries); err != nil {
+			entries = nil
+			raw = strings.Trim(raw, "[]")
+		}
+	}
+	if entries == nil {
+		entries = strings.FieldsFunc(raw, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t' || r == '\n'
+		})
+	}
+
+	set := make(EventSet, 0, len(entries))
+	for _, entry := range entries {
+		entry = strings.ToLower(strings.Trim(strings.TrimSpace(entry), `"'`))
+		if entry == "" {
+			continue
+		}
+		if event, ok := legacyEvents[entry]; ok {
+			entry = string(event)
+		}
+		set = append(set, entry)
+	}
+	return set
+}
+
+// Matches reports whether the set subscribes to an event
+func (s EventSet) Matches(event WebhookEvent) bool {
+	name := string(event)
+	for _, entry := range s {
+		switch {
+		case entry == "*", entry == name:
+			return true
+		case strings.HasSuffix(entry, ".*") && strings.HasPrefix(name, strings.TrimSuffix(entry, "*")):
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateEvents checks that every entry names an event of the catalogue, a
+// namespace wildcard that covers at least one, or "*"
+func ValidateEvents(events []string) error {
+	for _, entry := range events {
+		if !knownEntry(entry) {
+			return fmt.Errorf("invalid event: %s", entry)
+		}
+	}
+	return nil
+}
+
+// ValidateGistEvents is ValidateEvents for gist webhooks, which only
+// receive gist-scoped events
+func ValidateGistEvents(events []string) error {
+	if err := ValidateEvents(events); err != nil {
+		return err
+	}
+	gistEvents := GistEvents()
+	for _, entry := range events {
+		set := ParseEvents(entry)
+		matched := false
+		for _, event := range gistEvents {
+			if set.Matches(event) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("invalid event for a gist webhook: %s", entry)
+		}
+	}
+	return nil
+}
+
+func knownEntry(entry string) bool {
+	set := ParseEvents(entry)
+	if len(set) != 1 {
+		return false
+	}
+	if set[0] == "*" {
+		return true
+	}
+	if strings.HasSuffix(set[0], ".*") {
+		for _, info := range catalogue {
+			if set.Matches(info.Event) {
+				return true
+			}
+		}
+		return false
+	}
+	_, ok := lookupEvent(set[0])
+	return ok
+}
+
+func lookupEvent(name string) (EventInfo, bool) {
+	for _, info := range catalogue {
+		if string(info.Event) == name {
+			return info, true
+		}
+	}
+	return EventInfo{}, false
+}
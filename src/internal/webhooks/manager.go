@@ -9,31 +9,35 @@ import (
 	"encoding/json"
 	"fmt"
 	"net/http"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
 // Manager handles webhook operations
 type Manager struct {
 	db     *gorm.DB
-	cfg    *viper.Viper
+	cfg    *config.Config
 	client *http.Client
+
+	digestMu sync.Mutex
+	digests  map[uuid.UUID]*digest // Open digests by webhook
 }
 
 // NewManager creates a new webhook manager
-func NewManager(db *gorm.DB, cfg *viper.Viper) *Manager {
+func NewManager(db *gorm.DB, cfg *config.Config) *Manager {
 	return &Manager{
 		db:  db,
 		cfg: cfg,
 		client: &http.Client{
 			Timeout: time.Duration(cfg.GetInt("webhooks.timeout_seconds")) * time.Second,
 		},
+		digests: make(map[uuid.UUID]*digest),
 	}
 }
 
@@ -126,7 +130,7 @@ func (m *Manager) DeleteWebhook(id uuid.UUID, userID *uuid.UUID) error {
 // TriggerEvent triggers webhooks for a specific event
 func (m *Manager) TriggerEvent(ctx context.Context, event WebhookEvent, data interface{}, senderID *uuid.UUID) error {
 	// Get all active webhooks that subscribe to this event
-	webhooks, err := m.getWebhooksForEvent(event)
+	webhooks, err := m.getWebhooksForEvent(event, eventGistID(data))
 	if err != nil {
 		return fmt.Errorf("failed to get webhooks: %w", err)
 	}
@@ -149,8 +153,13 @@ func (m *Manager) TriggerEvent(ctx context.Context, event WebhookEvent, data int
 		Sender:    sender,
 	}
 
-	// Dispatch webhooks synchronously to ensure delivery records exist before returning.
+	// Dispatch webhooks synchronously to ensure delivery records exist before
+	// returning. Webhooks in digest mode get the event in their next digest.
 	for _, webhook := range webhooks {
+		if webhook.DigestWindow > 0 {
+			m.queueDigest(ctx, webhook, payload)
+			continue
+		}
 		m.sendWebhook(ctx, webhook, payload)
 	}
 
@@ -241,18 +250,31 @@ func (m *Manager) generateSignature(payload []byte, secret string) string {
 	return "sha256=" + signature
 }
 
-// getWebhooksForEvent retrieves all webhooks that subscribe to a specific event
-func (m *Manager) getWebhooksForEvent(event WebhookEvent) ([]models.Webhook, error) {
+// getWebhooksForEvent retrieves all webhooks that subscribe to a specific
+// event. Webhooks attached to a gist only match events about that gist.
+func (m *Manager) getWebhooksForEvent(event WebhookEvent, gistID *uuid.UUID) ([]models.Webhook, error) {
 	var webhooks []models.Webhook
-	eventStr := string(event)
 
-	// Query webhooks that have this event in their events JSON array
-	err := m.db.Where("is_active = ? AND (events LIKE ? OR events LIKE ? OR events LIKE ?)",
-		true,
-		fmt.Sprintf("%%\"%s\"%%", eventStr),
-		fmt.Sprintf("%%'%s'%%", eventStr),
-		"%*%", // Wildcard for all events
-	).Find(&webhooks).Error
+	// Narrow down to webhooks whose events mention this event, a legacy name
+	// for it or a wildcard; webhookSubscribesToEvent then parses them
+	clause := "events LIKE ? OR events LIKE ?"
+	args := []interface{}{"%" + string(event) + "%", "%*%"}
+	for legacy, current := range legacyEvents {
+		if current == event {
+			clause += " OR events LIKE ?"
+			args = append(args, "%"+legacy+"%")
+		}
+	}
+	query := m.db.Where("is_active = ?", true).Where(clause, args...)
+	if info, _ := lookupEvent(string(event)); info.Scope == ScopeSystem {
+		query = query.Where("user_id IS NULL")
+	}
+	if gistID != nil {
+		query = query.Where("gist_id IS NULL OR gist_id = ?", *gistID)
+	} else {
+		query = query.Where("gist_id IS NULL")
+	}
+	err := query.Find(&webhooks).Error
 
 	if err != nil {
 		return nil, err
@@ -269,32 +291,37 @@ func (m *Manager) getWebhooksForEvent(event WebhookEvent) ([]models.Webhook, err
 	return filteredWebhooks, nil
 }
 
-// webhookSubscribesToEvent checks if a webhook subscribes to a specific event
-func (m *Manager) webhookSubscribesToEvent(webhook models.Webhook, event WebhookEvent) bool {
-	if webhook.Events == "" {
-		return false
-	}
-
-	// Check for wildcard subscription
-	if strings.Contains(webhook.Events, "*") {
-		return true
-	}
-
-	// Parse events JSON array
-	var events []string
-	if err := json.Unmarshal([]byte(webhook.Events), &events); err != nil {
-		// Fallback to simple string search
-		return strings.Contains(webhook.Events, string(event))
-	}
-
-	// Check if event is in the list
-	for _, e := range events {
-		if e == string(event) || e == "*" {
-			return true
+// eventGistID returns the gist an event is about, if any. For forks that is
+// the original gist.
+func eventGistID(data interface{}) *uuid.UUID {
+	var id uuid.UUID
+	switch d := data.(type) {
+	case GistEventData:
+		id = d.ID
+	case *GistEventData:
+		id = d.ID
+	case ForkEventData:
+		id = d.OriginalGist.ID
+	case VisibilityEventData:
+		id = d.Gist.ID
+	case CommentEventData:
+		id = d.Gist.ID
+	case map[string]interface{}:
+		if star, ok := d["data"].(StarEventData); ok {
+			id = star.Gist.ID
+		} else if gistID, ok := d["id"].(uuid.UUID); ok {
+			id = gistID
 		}
 	}
+	if id == uuid.Nil {
+		return nil
+	}
+	return &id
+}
 
-	return false
+// webhookSubscribesToEvent checks if a webhook subscribes to a specific event
+func (m *Manager) webhookSubscribesToEvent(webhook models.Webhook, event WebhookEvent) bool {
+	return ParseEvents(webhook.Events).Matches(event)
 }
 
 // getSenderInfo retrieves sender information for webhook payload
@@ -307,6 +334,7 @@ func (m *Manager) getSenderInfo(userID uuid.UUID) (*SenderInfo, error) {
 
 	err := m.db.Table("users").
 		Select("id, username, email").
+		Scopes(models.NotDeleted("users")).
 		Where("id = ?", userID).
 		First(&sender).Error
 
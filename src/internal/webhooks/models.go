@@ -10,14 +10,22 @@ import (
 type WebhookEvent string
 
 const (
-	EventGistCreated  WebhookEvent = "gist.created"
-	EventGistUpdated  WebhookEvent = "gist.updated"
-	EventGistDeleted  WebhookEvent = "gist.deleted"
-	EventGistStarred  WebhookEvent = "gist.starred"
-	EventGistForked   WebhookEvent = "gist.forked"
-	EventUserCreated  WebhookEvent = "user.created"
-	EventUserUpdated  WebhookEvent = "user.updated"
-	EventUserFollowed WebhookEvent = "user.followed"
+	EventGistCreated           WebhookEvent = "gist.created"
+	EventGistUpdated           WebhookEvent = "gist.updated"
+	EventGistDeleted           WebhookEvent = "gist.deleted"
+	EventGistStarred           WebhookEvent = "gist.starred"
+	EventGistForked            WebhookEvent = "gist.forked"
+	EventGistVisibilityChanged WebhookEvent = "gist.visibility_changed"
+	EventCommentCreated        WebhookEvent = "comment.created"
+	EventUserCreated           WebhookEvent = "user.created"
+	EventUserUpdated           WebhookEvent = "user.updated"
+	EventUserFollowed          WebhookEvent = "user.followed"
+	EventOrgCreated            WebhookEvent = "org.created"
+	EventOrgMemberAdded        WebhookEvent = "org.member_added"
+	EventOrgMemberRemoved      WebhookEvent = "org.member_removed"
+	EventOrgMemberRoleChanged  WebhookEvent = "org.member_role_changed"
+	EventTeamCreated           WebhookEvent = "team.created"
+	EventBackupCompleted       WebhookEvent = "backup.completed"
 )
 
 // Event represents a webhook event payload
@@ -143,3 +151,59 @@ type ForkEventData struct {
 	ForkedGist   GistEventData `json:"forked_gist"`
 	Forker       UserEventData `json:"forker"`
 }
+
+// VisibilityEventData represents a change of a gist's visibility. The gist
+// carries the new visibility.
+type VisibilityEventData struct {
+	Gist               GistEventData `json:"gist"`
+	PreviousVisibility string        `json:"previous_visibility"`
+}
+
+// CommentEventData represents comment-related event data
+type CommentEventData struct {
+	ID        uuid.UUID     `json:"id"`
+	Body      string        `json:"body"`
+	Gist      GistEventData `json:"gist"`
+	Author    UserEventData `json:"author"`
+	CreatedAt time.Time     `json:"created_at"`
+}
+
+// OrgEventData represents organization-related event data
+type OrgEventData struct {
+	ID          uuid.UUID `json:"id"`
+	Name        string    `json:"name"`
+	DisplayName string    `json:"display_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// OrgMemberEventData represents a change of an organization's membership.
+// PreviousRole is set when a member's role changes.
+type OrgMemberEventData struct {
+	Organization OrgEventData  `json:"organization"`
+	Member       UserEventData `json:"member"`
+	Role         string        `json:"role"`
+	PreviousRole string        `json:"previous_role,omitempty"`
+}
+
+// TeamEventData represents team-related event data
+type TeamEventData struct {
+	ID           uuid.UUID    `json:"id"`
+	Name         string       `json:"name"`
+	Description  string       `json:"description,omitempty"`
+	Organization OrgEventData `json:"organization"`
+	CreatedAt    time.Time    `json:"created_at"`
+}
+
+// BackupEventData represents a finished backup
+type BackupEventData struct {
+	ID                  string    `json:"id"`
+	Filename            string    `json:"filename"`
+	Size                int64     `json:"size"`
+	Success             bool      `json:"success"`
+	DatabaseExported    bool      `json:"database_exported"`
+	GitReposExported    bool      `json:"git_repos_exported"`
+	AttachmentsExported bool      `json:"attachments_exported"`
+	Errors              []string  `json:"errors,omitempty"`
+	StartedAt           time.Time `json:"started_at"`
+	CompletedAt         time.Time `json:"completed_at"`
+}
@@ -16,6 +16,7 @@ import (
 	"gorm.io/gorm"
 
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/metrics"
 )
 
 // RetryConfig defines retry behavior for webhook deliveries
@@ -198,6 +199,7 @@ func (s *RetryableWebhookService) DeliverWithRetry(ctx context.Context, webhook
 		delivery.NextRetry = &nextRetry
 	}
 
+	recordOutcome(delivery)
 	if err := s.db.Create(delivery).Error; err != nil {
 		return fmt.Errorf("failed to save delivery record: %w", err)
 	}
@@ -290,9 +292,22 @@ func (s *RetryableWebhookService) processRetry(ctx context.Context, task *RetryT
 		delivery.NextRetry = nil
 	}
 
+	recordOutcome(&delivery)
 	s.db.Save(&delivery)
 }
 
+// recordOutcome counts a delivery attempt in the webhook metrics
+func recordOutcome(delivery *models.WebhookDelivery) {
+	outcome := "failed"
+	switch {
+	case delivery.Success:
+		outcome = "success"
+	case delivery.NextRetry != nil:
+		outcome = "retry"
+	}
+	metrics.WebhookDeliveries.WithLabelValues(outcome).Inc()
+}
+
 // attemptDelivery attempts to deliver a webhook event
 func (s *RetryableWebhookService) attemptDelivery(
 	ctx context.Context,
@@ -6,20 +6,21 @@ import (
 	"fmt"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
+	"github.com/casapps/casgists/src/internal/urls"
 )
 
 // Service provides webhook functionality
 type Service struct {
 	manager *Manager
-	cfg     *viper.Viper
+	cfg     *config.Config
 }
 
 // NewService creates a new webhook service
-func NewService(db *gorm.DB, cfg *viper.Viper) *Service {
+func NewService(db *gorm.DB, cfg *config.Config) *Service {
 	return &Service{
 		manager: NewManager(db, cfg),
 		cfg:     cfg,
@@ -32,6 +33,8 @@ type CreateWebhookInput struct {
 	Events      []string `json:"events" validate:"required,min=1"`
 	Secret      string   `json:"secret,omitempty"`
 	ContentType string   `json:"content_type,omitempty"`
+	// DigestWindow batches events over this many seconds into one delivery
+	DigestWindow int `json:"digest_window,omitempty"`
 }
 
 // UpdateWebhookInput represents input for updating a webhook
@@ -41,6 +44,8 @@ type UpdateWebhookInput struct {
 	Secret      *string  `json:"secret,omitempty"`
 	ContentType *string  `json:"content_type,omitempty"`
 	IsActive    *bool    `json:"is_active,omitempty"`
+	// DigestWindow set to 0 turns digest mode off
+	DigestWindow *int `json:"digest_window,omitempty"`
 }
 
 // CreateWebhook creates a new webhook for a user
@@ -49,6 +54,9 @@ func (s *Service) CreateWebhook(userID uuid.UUID, input CreateWebhookInput) (*mo
 	if err := s.validateEvents(input.Events); err != nil {
 		return nil, fmt.Errorf("invalid events: %w", err)
 	}
+	if err := ValidateDigestWindow(s.cfg, input.DigestWindow); err != nil {
+		return nil, err
+	}
 
 	// Convert events to JSON
 	eventsJSON, err := json.Marshal(input.Events)
@@ -62,12 +70,13 @@ func (s *Service) CreateWebhook(userID uuid.UUID, input CreateWebhookInput) (*mo
 	}
 
 	webhook := &models.Webhook{
-		UserID:      &userID,
-		URL:         input.URL,
-		Secret:      input.Secret,
-		Events:      string(eventsJSON),
-		IsActive:    true,
-		ContentType: contentType,
+		UserID:       &userID,
+		URL:          input.URL,
+		Secret:       input.Secret,
+		Events:       string(eventsJSON),
+		IsActive:     true,
+		ContentType:  contentType,
+		DigestWindow: input.DigestWindow,
 	}
 
 	if err := s.manager.CreateWebhook(webhook); err != nil {
@@ -125,6 +134,13 @@ func (s *Service) UpdateWebhook(id uuid.UUID, userID *uuid.UUID, input UpdateWeb
 		updates["is_active"] = *input.IsActive
 	}
 
+	if input.DigestWindow != nil {
+		if err := ValidateDigestWindow(s.cfg, *input.DigestWindow); err != nil {
+			return nil, err
+		}
+		updates["digest_window"] = *input.DigestWindow
+	}
+
 	if err := s.manager.UpdateWebhook(id, userID, updates); err != nil {
 		return nil, fmt.Errorf("failed to update webhook: %w", err)
 	}
@@ -163,6 +179,12 @@ func (s *Service) PingWebhook(ctx context.Context, webhookID uuid.UUID, userID *
 	return s.manager.PingWebhook(ctx, webhookID, userID)
 }
 
+// FlushDigests sends the events held for webhooks in digest mode without
+// waiting for their windows to close
+func (s *Service) FlushDigests(ctx context.Context) {
+	s.manager.FlushDigests(ctx)
+}
+
 // Event trigger methods for integration with other services
 
 // TriggerGistCreated triggers webhooks when a gist is created
@@ -270,29 +292,119 @@ func (s *Service) TriggerUserFollowed(ctx context.Context, follower, following *
 	return s.manager.TriggerEvent(ctx, EventUserFollowed, data, &follower.ID)
 }
 
+// TriggerGistVisibilityChanged triggers webhooks when a gist's visibility
+// changes
+func (s *Service) TriggerGistVisibilityChanged(ctx context.Context, gist *models.Gist, previous models.Visibility, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	data := VisibilityEventData{
+		Gist:               s.gistToEventData(gist),
+		PreviousVisibility: string(previous),
+	}
+
+	return s.manager.TriggerEvent(ctx, EventGistVisibilityChanged, data, &senderID)
+}
+
+// TriggerCommentCreated triggers webhooks when a comment is added to a gist
+func (s *Service) TriggerCommentCreated(ctx context.Context, gist *models.Gist, comment *models.GistComment, author *models.User) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	data := CommentEventData{
+		ID:        comment.ID,
+		Body:      comment.Content,
+		Gist:      s.gistToEventData(gist),
+		Author:    s.userToEventData(author),
+		CreatedAt: comment.CreatedAt,
+	}
+
+	return s.manager.TriggerEvent(ctx, EventCommentCreated, data, &author.ID)
+}
+
+// TriggerOrgCreated triggers webhooks when an organization is created
+func (s *Service) TriggerOrgCreated(ctx context.Context, org OrgEventData, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	return s.manager.TriggerEvent(ctx, EventOrgCreated, org, &senderID)
+}
+
+// TriggerOrgMemberAdded triggers webhooks when a user joins an organization
+func (s *Service) TriggerOrgMemberAdded(ctx context.Context, org OrgEventData, member UserEventData, role string, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	data := OrgMemberEventData{
+		Organization: org,
+		Member:       member,
+		Role:         role,
+	}
+
+	return s.manager.TriggerEvent(ctx, EventOrgMemberAdded, data, &senderID)
+}
+
+// TriggerOrgMemberRemoved triggers webhooks when a user leaves or is removed
+// from an organization. role is the one they had.
+func (s *Service) TriggerOrgMemberRemoved(ctx context.Context, org OrgEventData, member UserEventData, role string, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	data := OrgMemberEventData{
+		Organization: org,
+		Member:       member,
+		Role:         role,
+	}
+
+	return s.manager.TriggerEvent(ctx, EventOrgMemberRemoved, data, &senderID)
+}
+
+// TriggerOrgMemberRoleChanged triggers webhooks when an organization member's
+// role changes
+func (s *Service) TriggerOrgMemberRoleChanged(ctx context.Context, org OrgEventData, member UserEventData, role, previousRole string, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	data := OrgMemberEventData{
+		Organization: org,
+		Member:       member,
+		Role:         role,
+		PreviousRole: previousRole,
+	}
+
+	return s.manager.TriggerEvent(ctx, EventOrgMemberRoleChanged, data, &senderID)
+}
+
+// TriggerTeamCreated triggers webhooks when a team is created
+func (s *Service) TriggerTeamCreated(ctx context.Context, team TeamEventData, senderID uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	return s.manager.TriggerEvent(ctx, EventTeamCreated, team, &senderID)
+}
+
+// TriggerBackupCompleted triggers webhooks when a backup finishes. Like
+// every system event it only goes to system-wide webhooks.
+func (s *Service) TriggerBackupCompleted(ctx context.Context, backup BackupEventData, senderID *uuid.UUID) error {
+	if !s.isWebhooksEnabled() {
+		return nil
+	}
+
+	return s.manager.TriggerEvent(ctx, EventBackupCompleted, backup, senderID)
+}
+
 // Helper methods
 
 // validateEvents validates the list of webhook events
 func (s *Service) validateEvents(events []string) error {
-	validEvents := map[string]bool{
-		string(EventGistCreated):  true,
-		string(EventGistUpdated):  true,
-		string(EventGistDeleted):  true,
-		string(EventGistStarred):  true,
-		string(EventGistForked):   true,
-		string(EventUserCreated):  true,
-		string(EventUserUpdated):  true,
-		string(EventUserFollowed): true,
-		"*": true, // Wildcard for all events
-	}
-
-	for _, event := range events {
-		if !validEvents[event] {
-			return fmt.Errorf("invalid event: %s", event)
-		}
-	}
-
-	return nil
+	return ValidateEvents(events)
 }
 
 // isWebhooksEnabled checks if webhooks are enabled in configuration
@@ -312,7 +424,7 @@ func (s *Service) gistToEventData(gist *models.Gist) GistEventData {
 		Title:       gist.Title,
 		Description: gist.Description,
 		Visibility:  string(gist.Visibility),
-		URL:         fmt.Sprintf("%s/gists/%s", s.cfg.GetString("server.url"), gist.ID),
+		URL:         urls.NewBuilder(s.cfg).Gist(gist.ID),
 		FilesCount:  len(gist.Files),
 		Tags:        tags,
 		CreatedAt:   gist.CreatedAt,
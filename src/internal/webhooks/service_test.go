@@ -3,16 +3,20 @@ package webhooks
 import (
 	"context"
 	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
 	"github.com/google/uuid"
-	"github.com/spf13/viper"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 
+	"github.com/casapps/casgists/src/internal/config"
 	"github.com/casapps/casgists/src/internal/database/models"
 )
 
@@ -38,7 +42,7 @@ func setupWebhookTestDB(t *testing.T) *gorm.DB {
 
 func TestWebhookService(t *testing.T) {
 	db := setupWebhookTestDB(t)
-	cfg := viper.New()
+	cfg := config.New()
 	cfg.Set("webhooks.enabled", true)
 	cfg.Set("webhooks.timeout_seconds", 5)
 	cfg.Set("webhooks.max_retries", 2)
@@ -156,6 +160,15 @@ func TestWebhookService(t *testing.T) {
 			"user.created",
 			"user.updated",
 			"user.followed",
+			"gist.visibility_changed",
+			"comment.created",
+			"comment.added",
+			"org.member_added",
+			"org.member_removed",
+			"org.member_role_changed",
+			"backup.completed",
+			"gist.*",
+			"org.*",
 			"*",
 		}
 
@@ -167,12 +180,19 @@ func TestWebhookService(t *testing.T) {
 		// Test invalid event
 		err := service.validateEvents([]string{"invalid.event"})
 		assert.Error(t, err)
+		assert.Error(t, service.validateEvents([]string{"invalid.*"}))
+		assert.Error(t, service.validateEvents([]string{"gist.created,gist.updated"}))
+
+		// Gist webhooks only take events about an existing gist
+		assert.NoError(t, ValidateGistEvents([]string{"comment.created", "gist.*", "*"}))
+		assert.Error(t, ValidateGistEvents([]string{"gist.created"}))
+		assert.Error(t, ValidateGistEvents([]string{"org.*"}))
 	})
 }
 
 func TestWebhookManager(t *testing.T) {
 	db := setupWebhookTestDB(t)
-	cfg := viper.New()
+	cfg := config.New()
 	cfg.Set("webhooks.enabled", true)
 	cfg.Set("webhooks.timeout_seconds", 5)
 	cfg.Set("webhooks.max_retries", 1)
@@ -252,10 +272,168 @@ func TestWebhookManager(t *testing.T) {
 		assert.True(t, total > 0)
 		assert.True(t, len(deliveries) > 0)
 	})
+
+	t.Run("GistScopedWebhooks", func(t *testing.T) {
+		watched, other := uuid.New(), uuid.New()
+		global := &models.Webhook{
+			UserID:   &user.ID,
+			URL:      "https://example.com/global",
+			Events:   `["gist.updated"]`,
+			IsActive: true,
+		}
+		scoped := &models.Webhook{
+			UserID:   &user.ID,
+			GistID:   &watched,
+			URL:      "https://example.com/scoped",
+			Events:   `["gist.updated"]`,
+			IsActive: true,
+		}
+		require.NoError(t, manager.CreateWebhook(global))
+		require.NoError(t, manager.CreateWebhook(scoped))
+
+		urls := func(data interface{}) []string {
+			hooks, err := manager.getWebhooksForEvent(EventGistUpdated, eventGistID(data))
+			require.NoError(t, err)
+			var result []string
+			for _, hook := range hooks {
+				result = append(result, hook.URL)
+			}
+			return result
+		}
+
+		assert.ElementsMatch(t, []string{global.URL, scoped.URL}, urls(GistEventData{ID: watched}))
+		assert.ElementsMatch(t, []string{global.URL}, urls(GistEventData{ID: other}))
+		assert.ElementsMatch(t, []string{global.URL}, urls(map[string]string{"test": "data"}))
+		assert.ElementsMatch(t, []string{global.URL, scoped.URL}, urls(map[string]interface{}{"id": watched}))
+		assert.ElementsMatch(t, []string{global.URL, scoped.URL},
+			urls(ForkEventData{OriginalGist: GistEventData{ID: watched}, ForkedGist: GistEventData{ID: other}}))
+		assert.ElementsMatch(t, []string{global.URL, scoped.URL}, urls(CommentEventData{Gist: GistEventData{ID: watched}}))
+		assert.ElementsMatch(t, []string{global.URL, scoped.URL}, urls(VisibilityEventData{Gist: GistEventData{ID: watched}}))
+	})
+
+	t.Run("EventsColumnFormats", func(t *testing.T) {
+		create := func(url, events string, owner *uuid.UUID) {
+			require.NoError(t, manager.CreateWebhook(&models.Webhook{
+				UserID:   owner,
+				URL:      "https://example.com/formats/" + url,
+				Events:   events,
+				IsActive: true,
+			}))
+		}
+		create("json", `["comment.created"]`, &user.ID)
+		create("csv", "gist.updated, comment.created", &user.ID)
+		create("legacy", "comment.added", &user.ID)
+		create("namespace", `["org.*"]`, &user.ID)
+		create("all", "*", &user.ID)
+		create("system", "backup.completed,org.member_added", nil)
+
+		urls := func(event WebhookEvent) []string {
+			hooks, err := manager.getWebhooksForEvent(event, nil)
+			require.NoError(t, err)
+			var result []string
+			for _, hook := range hooks {
+				if strings.HasPrefix(hook.URL, "https://example.com/formats/") {
+					result = append(result, strings.TrimPrefix(hook.URL, "https://example.com/formats/"))
+				}
+			}
+			return result
+		}
+
+		assert.ElementsMatch(t, []string{"json", "csv", "legacy", "all"}, urls(EventCommentCreated))
+		assert.ElementsMatch(t, []string{"csv", "all"}, urls(EventGistUpdated))
+		assert.ElementsMatch(t, []string{"namespace", "all", "system"}, urls(EventOrgMemberAdded))
+		assert.ElementsMatch(t, []string{"namespace", "all"}, urls(EventOrgMemberRemoved))
+
+		// System events only go to system-wide webhooks
+		assert.ElementsMatch(t, []string{"system"}, urls(EventBackupCompleted))
+	})
+
+	t.Run("ParseEvents", func(t *testing.T) {
+		assert.Equal(t, EventSet{"gist.created", "gist.updated"}, ParseEvents(`["gist.created","gist.updated"]`))
+		assert.Equal(t, EventSet{"gist.created", "gist.updated"}, ParseEvents(" gist.created ,gist.updated,"))
+		assert.Equal(t, EventSet{"comment.created"}, ParseEvents("COMMENT.ADDED"))
+		assert.Empty(t, ParseEvents(""))
+
+		set := ParseEvents("gist.*")
+		assert.True(t, set.Matches(EventGistVisibilityChanged))
+		assert.False(t, set.Matches(EventCommentCreated))
+		assert.False(t, ParseEvents("gist.created").Matches(EventGistCreated+"x"))
+	})
+
+	t.Run("DigestMode", func(t *testing.T) {
+		// Timers flush digests from their own goroutine; keep it on the
+		// in-memory database
+		sqlDB, err := db.DB()
+		require.NoError(t, err)
+		sqlDB.SetMaxOpenConns(1)
+
+		var mu sync.Mutex
+		var received []WebhookPayload
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			var payload WebhookPayload
+			require.NoError(t, json.NewDecoder(r.Body).Decode(&payload))
+			mu.Lock()
+			received = append(received, payload)
+			mu.Unlock()
+		}))
+		defer server.Close()
+		count := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(received)
+		}
+
+		cfg.Set("webhook.digest.max_events", 3)
+		webhook := &models.Webhook{
+			UserID:       &user.ID,
+			URL:          server.URL,
+			Events:       `["gist.deleted"]`,
+			IsActive:     true,
+			ContentType:  "application/json",
+			DigestWindow: 1,
+		}
+		require.NoError(t, manager.CreateWebhook(webhook))
+		ctx := context.Background()
+
+		// Events wait for the window to close
+		require.NoError(t, manager.TriggerEvent(ctx, EventGistDeleted, map[string]string{"n": "1"}, &user.ID))
+		require.NoError(t, manager.TriggerEvent(ctx, EventGistDeleted, map[string]string{"n": "2"}, &user.ID))
+		assert.Equal(t, 0, count())
+		assert.Eventually(t, func() bool { return count() == 1 }, 5*time.Second, 50*time.Millisecond)
+
+		mu.Lock()
+		digest := received[0]
+		mu.Unlock()
+		assert.Equal(t, EventDigest, digest.Event)
+		data := digest.Data.(map[string]interface{})
+		assert.EqualValues(t, 2, data["count"])
+		events := data["events"].([]interface{})
+		require.Len(t, events, 2)
+		assert.Equal(t, "1", events[0].(map[string]interface{})["data"].(map[string]interface{})["n"])
+
+		// A full digest is sent without waiting
+		for i := 0; i < 3; i++ {
+			require.NoError(t, manager.TriggerEvent(ctx, EventGistDeleted, map[string]string{"n": "x"}, &user.ID))
+		}
+		assert.Equal(t, 2, count())
+
+		// Open digests go out on flush; deactivated webhooks drop theirs
+		require.NoError(t, manager.TriggerEvent(ctx, EventGistDeleted, map[string]string{"n": "y"}, &user.ID))
+		manager.FlushDigests(ctx)
+		assert.Equal(t, 3, count())
+		require.NoError(t, manager.TriggerEvent(ctx, EventGistDeleted, map[string]string{"n": "z"}, &user.ID))
+		require.NoError(t, db.Model(webhook).Update("is_active", false).Error)
+		manager.FlushDigests(ctx)
+		assert.Equal(t, 3, count())
+
+		assert.NoError(t, ValidateDigestWindow(cfg, 300))
+		assert.Error(t, ValidateDigestWindow(cfg, -1))
+		assert.Error(t, ValidateDigestWindow(cfg, 7200))
+	})
 }
 
 func TestWebhookEventData(t *testing.T) {
-	cfg := viper.New()
+	cfg := config.New()
 	cfg.Set("server.url", "https://example.com")
 
 	service := &Service{cfg: cfg}
@@ -26,6 +26,9 @@ document.addEventListener('DOMContentLoaded', function() {
     
     // Initialize PWA
     initPWA();
+
+    // Live notifications and comments
+    initLiveStream();
 });
 
 // Initialize tooltips
@@ -83,6 +86,60 @@ function showNotification(message, type = 'info') {
     }, 5000);
 }
 
+// Live notifications and comment updates over server-sent events. The
+// stream is opened for signed in users, who have the unread badge.
+function initLiveStream() {
+    const badge = document.getElementById('notification-count');
+    if (!badge || !window.EventSource) {
+        return;
+    }
+
+    let url = '/api/v1/notifications/stream';
+    const comments = document.querySelector('[data-live-comments]');
+    if (comments) {
+        url += '?gist=' + encodeURIComponent(comments.dataset.liveComments);
+    }
+    const source = new EventSource(url);
+
+    let unread = 0;
+    const setUnread = count => {
+        unread = count;
+        badge.textContent = count > 99 ? '99+' : String(count);
+        badge.classList.toggle('hidden', count === 0);
+    };
+
+    source.addEventListener('ready', event => {
+        setUnread(JSON.parse(event.data).unread_count);
+    });
+    source.addEventListener('notification', event => {
+        const notification = JSON.parse(event.data);
+        setUnread(unread + 1);
+        // Messages quote gist titles and usernames, so they go in as text
+        const message = document.createElement('span');
+        message.textContent = notification.message;
+        showNotification(message.innerHTML, 'info');
+    });
+    source.addEventListener('comment.created', event => {
+        const comment = JSON.parse(event.data);
+        if (!document.getElementById('comment-' + comment.ID)) {
+            showNotification('New comment on this gist; reload to see it', 'info');
+        }
+    });
+    source.addEventListener('comment.updated', event => {
+        const comment = JSON.parse(event.data);
+        const content = document.querySelector('#comment-' + comment.ID + ' [data-comment-content]');
+        if (content) {
+            content.textContent = comment.Content;
+        }
+    });
+    source.addEventListener('comment.deleted', event => {
+        const removed = document.getElementById('comment-' + JSON.parse(event.data).id);
+        if (removed) {
+            removed.remove();
+        }
+    });
+}
+
 // Copy to clipboard
 function copyToClipboard(text) {
     if (navigator.clipboard && navigator.clipboard.writeText) {
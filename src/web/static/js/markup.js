@@ -0,0 +1,86 @@
+// CasGists rendered markdown hydration
+//
+// The server renders math as <span class="math inline|display"> and
+// <div class="math display">, and Mermaid diagrams as <pre class="mermaid">,
+// with their sources escaped as text. This script typesets them in the
+// browser. KaTeX runs with trust disabled and Mermaid with its strict
+// security level, so neither can inject links, HTML or scripts into the
+// page. The libraries are only fetched when a page contains such markup.
+(function() {
+    const KATEX = 'https://cdnjs.cloudflare.com/ajax/libs/KaTeX/0.16.9/';
+    const MERMAID = 'https://cdnjs.cloudflare.com/ajax/libs/mermaid/10.9.0/mermaid.min.js';
+
+    const loaded = {};
+
+    function loadScript(src) {
+        if (!loaded[src]) {
+            loaded[src] = new Promise((resolve, reject) => {
+                const script = document.createElement('script');
+                script.src = src;
+                script.onload = resolve;
+                script.onerror = reject;
+                document.head.appendChild(script);
+            });
+        }
+        return loaded[src];
+    }
+
+    function loadStyle(href) {
+        if (!loaded[href]) {
+            const link = document.createElement('link');
+            link.rel = 'stylesheet';
+            link.href = href;
+            document.head.appendChild(link);
+            loaded[href] = Promise.resolve();
+        }
+        return loaded[href];
+    }
+
+    async function hydrateMath(root) {
+        const nodes = root.querySelectorAll('.math:not([data-hydrated])');
+        if (nodes.length === 0) {
+            return;
+        }
+        loadStyle(KATEX + 'katex.min.css');
+        await loadScript(KATEX + 'katex.min.js');
+        nodes.forEach((node) => {
+            katex.render(node.textContent, node, {
+                displayMode: node.classList.contains('display'),
+                throwOnError: false,
+                trust: false
+            });
+            node.dataset.hydrated = 'true';
+        });
+    }
+
+    async function hydrateDiagrams(root) {
+        const nodes = root.querySelectorAll('pre.mermaid:not([data-processed])');
+        if (nodes.length === 0) {
+            return;
+        }
+        await loadScript(MERMAID);
+        const dark = document.documentElement.getAttribute('data-theme') === 'dark' ||
+            document.body.classList.contains('bg-gray-900');
+        mermaid.initialize({
+            startOnLoad: false,
+            securityLevel: 'strict',
+            theme: dark ? 'dark' : 'default'
+        });
+        await mermaid.run({ nodes: nodes, suppressErrors: true });
+    }
+
+    // hydrate typesets math and diagrams below root. Pages call it again
+    // after inserting rendered markdown fetched from the API.
+    async function hydrate(root) {
+        root = root || document;
+        try {
+            await Promise.all([hydrateMath(root), hydrateDiagrams(root)]);
+        } catch (error) {
+            // The sources stay readable as text when a library fails to load
+            console.warn('Failed to render math or diagrams:', error);
+        }
+    }
+
+    window.CasGistsMarkup = { hydrate: hydrate };
+    document.addEventListener('DOMContentLoaded', () => hydrate(document));
+})();
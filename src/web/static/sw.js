@@ -510,7 +510,7 @@ self.addEventListener('notificationclick', event => {
   if (data && data.url) {
     url = data.url;
   } else if (action === 'view' && data && data.gistId) {
-    url = `/gist/${data.gistId}`;
+    url = `/gists/${data.gistId}`;
   }
   
   event.waitUntil(
@@ -6,7 +6,7 @@ sleep 2
 
 # Start server
 echo "Starting server..."
-./build/casgists --config=configs/development.yaml > server-test.log 2>&1 &
+./build/casgists serve > server-test.log 2>&1 &
 SERVER_PID=$!
 
 # Wait for server to start
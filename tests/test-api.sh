@@ -6,7 +6,7 @@ sleep 2
 
 # Start server
 echo "Starting server..."
-./build/casgists --config=configs/development.yaml > test-server.log 2>&1 &
+./build/casgists serve > test-server.log 2>&1 &
 SERVER_PID=$!
 
 # Wait for server to start